@@ -2,7 +2,17 @@ package api
 
 import (
 	"context"
+	"io"
 
+	"github.com/filecoin-project/boost/lib/dtcontrol"
+	"github.com/filecoin-project/boost/lib/faults"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/lib/fundsmigration"
+	"github.com/filecoin-project/boost/lib/paychmanager"
+	"github.com/filecoin-project/boost/lib/piecegc"
+	"github.com/filecoin-project/boost/lib/repobackup"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	"github.com/filecoin-project/boost/lib/supportbundle"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
@@ -36,6 +46,10 @@ type Boost interface {
 	// MethodGroup: Boost
 	BoostIndexerAnnounceAllDeals(ctx context.Context) error                                                                        //perm:admin
 	BoostOfflineDealWithData(ctx context.Context, dealUuid uuid.UUID, filePath string) (*ProviderDealRejectionInfo, error)         //perm:admin
+	BoostOfflineDealUpload(ctx context.Context, dealUuid uuid.UUID) (*smtypes.OfflineDealUpload, error)                            //perm:admin
+	BoostOfflineDealUploadChunk(ctx context.Context, dealUuid uuid.UUID, offset uint64, r io.Reader) (uint64, error)               //perm:admin
+	BoostOfflineDealUploadFinish(ctx context.Context, dealUuid uuid.UUID) (*ProviderDealRejectionInfo, error)                      //perm:admin
+	BoostOfflineDealUploadCancel(ctx context.Context, dealUuid uuid.UUID) error                                                    //perm:admin
 	BoostDeal(ctx context.Context, dealUuid uuid.UUID) (*smtypes.ProviderDealState, error)                                         //perm:admin
 	BoostDealBySignedProposalCid(ctx context.Context, proposalCid cid.Cid) (*smtypes.ProviderDealState, error)                     //perm:admin
 	BoostDummyDeal(context.Context, smtypes.DealParams) (*ProviderDealRejectionInfo, error)                                        //perm:admin
@@ -47,6 +61,38 @@ type Boost interface {
 	BoostDagstoreGC(ctx context.Context) ([]DagstoreShardResult, error)                                                            //perm:admin
 	BoostDagstorePiecesContainingMultihash(ctx context.Context, mh multihash.Multihash) ([]cid.Cid, error)                         //perm:read
 	BoostDagstoreListShards(ctx context.Context) ([]DagstoreShardInfo, error)                                                      //perm:read
+	BoostListImports(ctx context.Context, filter smtypes.ImportsFilter) (<-chan smtypes.ImportInfo, error)                         //perm:read
+	BoostFaultsGet(ctx context.Context) (faults.Faults, error)                                                                     //perm:admin
+	BoostFaultsSet(ctx context.Context, f faults.Faults) error                                                                     //perm:admin
+	BoostFeatures(ctx context.Context) ([]features.Status, error)                                                                  //perm:read
+	BoostFeatureSet(ctx context.Context, name string, enabled bool) error                                                          //perm:admin
+	BoostConfigReload(ctx context.Context) error                                                                                   //perm:admin
+	BoostCapacityReservations(ctx context.Context) ([]smtypes.CapacityReservationStatus, error)                                    //perm:read
+	BoostClientFundsMigrationStatus(ctx context.Context) (*fundsmigration.Status, error)                                           //perm:read
+	BoostClientFundsMigrate(ctx context.Context, wallet address.Address, dryRun bool) (*fundsmigration.Status, error)              //perm:admin
+	BoostPaychInventory(ctx context.Context) ([]paychmanager.Channel, error)                                                       //perm:read
+	BoostPaychSettle(ctx context.Context, ch address.Address) error                                                                //perm:admin
+	BoostPieceGC(ctx context.Context, dryRun bool) (*piecegc.Report, error)                                                        //perm:admin
+	BoostDealQueue(ctx context.Context) ([]smtypes.QueuedDeal, error)                                                              //perm:read
+	BoostDealQueueSetWeight(ctx context.Context, dealUuid uuid.UUID, weight int64) error                                           //perm:admin
+	BoostDealRateLimits(ctx context.Context) (*smtypes.DealRateLimitStatus, error)                                                 //perm:read
+	BoostDealRateLimitsSet(ctx context.Context, limits smtypes.DealRateLimits) error                                               //perm:admin
+	BoostDealAnnotate(ctx context.Context, dealUuid uuid.UUID, key string, value string) error                                     //perm:admin
+	BoostDealAnnotationRemove(ctx context.Context, dealUuid uuid.UUID, key string) error                                           //perm:admin
+	BoostDealAnnotations(ctx context.Context, dealUuid uuid.UUID) ([]smtypes.DealAnnotation, error)                                //perm:read
+	BoostDealAnnotationsList(ctx context.Context, key string, value string) ([]smtypes.DealAnnotation, error)                      //perm:read
+	BoostBackup(ctx context.Context, fpath string) (*repobackup.Manifest, error)                                                   //perm:admin
+	BoostSupportSnapshot(ctx context.Context, params supportbundle.SnapshotParams) (*supportbundle.Snapshot, error)                //perm:admin
+	BoostRetrievalACL(ctx context.Context) (*retrievalacl.Config, error)                                                           //perm:read
+	BoostRetrievalStatsRecord(ctx context.Context, pieceCid cid.Cid, bytesServed uint64) error                                     //perm:write
+	BoostRetrievalStatsRecordBlocks(ctx context.Context, blocks []smtypes.BlockServed) error                                       //perm:write
+	BoostDataTransferPause(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error     //perm:admin
+	BoostDataTransferResume(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error    //perm:admin
+	BoostDataTransferCancel(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error    //perm:admin
+	BoostDataTransferPausePeer(ctx context.Context, otherPeer peer.ID) ([]dtcontrol.Result, error)                                 //perm:admin
+	BoostDataTransferResumePeer(ctx context.Context, otherPeer peer.ID) ([]dtcontrol.Result, error)                                //perm:admin
+	BoostDataTransferCancelPeer(ctx context.Context, otherPeer peer.ID) ([]dtcontrol.Result, error)                                //perm:admin
+	BoostDataTransferIntents(ctx context.Context) ([]dtcontrol.Intent, error)                                                      //perm:read
 
 	// MethodGroup: Blockstore
 	BlockstoreGet(ctx context.Context, c cid.Cid) ([]byte, error)  //perm:read
@@ -77,6 +123,7 @@ type Boost interface {
 	PiecesGetPieceInfo(ctx context.Context, pieceCid cid.Cid) (*piecestore.PieceInfo, error) //perm:read
 	PiecesGetCIDInfo(ctx context.Context, payloadCid cid.Cid) (*piecestore.CIDInfo, error)   //perm:read
 	PiecesGetMaxOffset(ctx context.Context, pieceCid cid.Cid) (uint64, error)                //perm:read
+	PiecesGetIndex(ctx context.Context, pieceCid cid.Cid) ([]byte, error)                    //perm:read
 
 	// MethodGroup: Actor
 	ActorSectorSize(context.Context, address.Address) (abi.SectorSize, error) //perm:read
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/filecoin-project/go-jsonrpc/auth"
+	apitypes "github.com/filecoin-project/lotus/api/types"
 )
 
 //                       MODIFYING THE API INTERFACE
@@ -33,13 +34,13 @@ type Common interface {
 	//// node
 	//LogAlerts(ctx context.Context) ([]alerting.Alert, error) //perm:admin
 
-	//// MethodGroup: Common
+	// MethodGroup: Common
 
 	//// Version provides information about API provider
 	//Version(context.Context) (APIVersion, error) //perm:read
 
-	//// Discover returns an OpenRPC document describing an RPC API.
-	//Discover(ctx context.Context) (apitypes.OpenRPCDocument, error) //perm:read
+	// Discover returns an OpenRPC document describing an RPC API.
+	Discover(ctx context.Context) (apitypes.OpenRPCDocument, error) //perm:read
 
 	//// trigger graceful shutdown
 	//Shutdown(context.Context) error //perm:admin
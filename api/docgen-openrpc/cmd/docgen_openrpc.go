@@ -10,6 +10,7 @@ import (
 	"github.com/filecoin-project/boost/api/docgen"
 
 	docgen_openrpc "github.com/filecoin-project/boost/api/docgen-openrpc"
+	meta_schema "github.com/open-rpc/meta-schema"
 )
 
 /*
@@ -42,6 +43,11 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// The deal states are integers in the API types: describe their values
+	// so that typed clients can be generated from the document
+	schemas := docgen_openrpc.DealStateSchemas()
+	out.Components = &meta_schema.Components{Schemas: &schemas}
+
 	var jsonOut []byte
 	var writer io.WriteCloser
 
@@ -5,11 +5,15 @@ import (
 	"go/ast"
 	"net"
 	"reflect"
+	"sort"
 
 	"github.com/alecthomas/jsonschema"
 	go_openrpc_reflect "github.com/etclabscore/go-openrpc-reflect"
 	"github.com/filecoin-project/boost/api/docgen"
 	"github.com/filecoin-project/boost/build"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/ipfs/go-cid"
 	meta_schema "github.com/open-rpc/meta-schema"
 )
@@ -159,3 +163,47 @@ func NewLotusOpenRPCDocument(Comments, GroupDocs map[string]string) *go_openrpc_
 	d.WithReflector(appReflector)
 	return d
 }
+
+// DealStateSchemas returns the schemas of the deal state enums, which are
+// marshalled as integers. The name of each value is listed in
+// x-enum-varnames, so that clients generated from the document have a named
+// constant for each state.
+func DealStateSchemas() meta_schema.SchemaComponents {
+	checkpoints := make(map[uint64]string)
+	for cp := dealcheckpoints.Accepted; cp <= dealcheckpoints.Complete; cp++ {
+		checkpoints[uint64(cp)] = cp.String()
+	}
+	storageStates := make(map[uint64]string, len(storagemarket.DealStates))
+	for st, name := range storagemarket.DealStates {
+		storageStates[uint64(st)] = name
+	}
+	retrievalStates := make(map[uint64]string, len(retrievalmarket.DealStatuses))
+	for st, name := range retrievalmarket.DealStatuses {
+		retrievalStates[uint64(st)] = name
+	}
+
+	return meta_schema.SchemaComponents{
+		"DealCheckpoint":      enumSchema("The checkpoint reached by a boost storage deal", checkpoints),
+		"StorageDealStatus":   enumSchema("The state of a legacy (go-fil-markets) storage deal", storageStates),
+		"RetrievalDealStatus": enumSchema("The state of a retrieval deal", retrievalStates),
+	}
+}
+
+func enumSchema(description string, names map[uint64]string) map[string]interface{} {
+	values := make([]uint64, 0, len(names))
+	for v := range names {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	varnames := make([]string, 0, len(values))
+	for _, v := range values {
+		varnames = append(varnames, names[v])
+	}
+	return map[string]interface{}{
+		"type":            "integer",
+		"description":     description,
+		"enum":            values,
+		"x-enum-varnames": varnames,
+	}
+}
@@ -5,8 +5,18 @@ package api
 import (
 	"context"
 	"errors"
+	"io"
 	"time"
 
+	"github.com/filecoin-project/boost/lib/dtcontrol"
+	"github.com/filecoin-project/boost/lib/faults"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/lib/fundsmigration"
+	"github.com/filecoin-project/boost/lib/paychmanager"
+	"github.com/filecoin-project/boost/lib/piecegc"
+	"github.com/filecoin-project/boost/lib/repobackup"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	"github.com/filecoin-project/boost/lib/supportbundle"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
@@ -18,6 +28,7 @@ import (
 	"github.com/filecoin-project/go-state-types/crypto"
 	lapi "github.com/filecoin-project/lotus/api"
 	lotus_api "github.com/filecoin-project/lotus/api"
+	apitypes "github.com/filecoin-project/lotus/api/types"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
@@ -44,6 +55,16 @@ type BoostStruct struct {
 
 		BlockstoreHas func(p0 context.Context, p1 cid.Cid) (bool, error) `perm:"read"`
 
+		BoostBackup func(p0 context.Context, p1 string) (*repobackup.Manifest, error) `perm:"admin"`
+
+		BoostCapacityReservations func(p0 context.Context) ([]smtypes.CapacityReservationStatus, error) `perm:"read"`
+
+		BoostClientFundsMigrate func(p0 context.Context, p1 address.Address, p2 bool) (*fundsmigration.Status, error) `perm:"admin"`
+
+		BoostClientFundsMigrationStatus func(p0 context.Context) (*fundsmigration.Status, error) `perm:"read"`
+
+		BoostConfigReload func(p0 context.Context) error `perm:"admin"`
+
 		BoostDagstoreDestroyShard func(p0 context.Context, p1 string) error `perm:"admin"`
 
 		BoostDagstoreGC func(p0 context.Context) ([]DagstoreShardResult, error) `perm:"admin"`
@@ -60,16 +81,78 @@ type BoostStruct struct {
 
 		BoostDagstoreRegisterShard func(p0 context.Context, p1 string) error `perm:"admin"`
 
+		BoostDataTransferCancel func(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error `perm:"admin"`
+
+		BoostDataTransferCancelPeer func(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) `perm:"admin"`
+
+		BoostDataTransferIntents func(p0 context.Context) ([]dtcontrol.Intent, error) `perm:"read"`
+
+		BoostDataTransferPause func(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error `perm:"admin"`
+
+		BoostDataTransferPausePeer func(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) `perm:"admin"`
+
+		BoostDataTransferResume func(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error `perm:"admin"`
+
+		BoostDataTransferResumePeer func(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) `perm:"admin"`
+
 		BoostDeal func(p0 context.Context, p1 uuid.UUID) (*smtypes.ProviderDealState, error) `perm:"admin"`
 
+		BoostDealAnnotate func(p0 context.Context, p1 uuid.UUID, p2 string, p3 string) error `perm:"admin"`
+
+		BoostDealAnnotationRemove func(p0 context.Context, p1 uuid.UUID, p2 string) error `perm:"admin"`
+
+		BoostDealAnnotations func(p0 context.Context, p1 uuid.UUID) ([]smtypes.DealAnnotation, error) `perm:"read"`
+
+		BoostDealAnnotationsList func(p0 context.Context, p1 string, p2 string) ([]smtypes.DealAnnotation, error) `perm:"read"`
+
 		BoostDealBySignedProposalCid func(p0 context.Context, p1 cid.Cid) (*smtypes.ProviderDealState, error) `perm:"admin"`
 
+		BoostDealQueue func(p0 context.Context) ([]smtypes.QueuedDeal, error) `perm:"read"`
+
+		BoostDealQueueSetWeight func(p0 context.Context, p1 uuid.UUID, p2 int64) error `perm:"admin"`
+
+		BoostDealRateLimits func(p0 context.Context) (*smtypes.DealRateLimitStatus, error) `perm:"read"`
+
+		BoostDealRateLimitsSet func(p0 context.Context, p1 smtypes.DealRateLimits) error `perm:"admin"`
+
 		BoostDummyDeal func(p0 context.Context, p1 smtypes.DealParams) (*ProviderDealRejectionInfo, error) `perm:"admin"`
 
+		BoostFaultsGet func(p0 context.Context) (faults.Faults, error) `perm:"admin"`
+
+		BoostFaultsSet func(p0 context.Context, p1 faults.Faults) error `perm:"admin"`
+
+		BoostFeatureSet func(p0 context.Context, p1 string, p2 bool) error `perm:"admin"`
+
+		BoostFeatures func(p0 context.Context) ([]features.Status, error) `perm:"read"`
+
 		BoostIndexerAnnounceAllDeals func(p0 context.Context) error `perm:"admin"`
 
+		BoostListImports func(p0 context.Context, p1 smtypes.ImportsFilter) (<-chan smtypes.ImportInfo, error) `perm:"read"`
+
+		BoostOfflineDealUpload func(p0 context.Context, p1 uuid.UUID) (*smtypes.OfflineDealUpload, error) `perm:"admin"`
+
+		BoostOfflineDealUploadCancel func(p0 context.Context, p1 uuid.UUID) error `perm:"admin"`
+
+		BoostOfflineDealUploadChunk func(p0 context.Context, p1 uuid.UUID, p2 uint64, p3 io.Reader) (uint64, error) `perm:"admin"`
+
+		BoostOfflineDealUploadFinish func(p0 context.Context, p1 uuid.UUID) (*ProviderDealRejectionInfo, error) `perm:"admin"`
+
 		BoostOfflineDealWithData func(p0 context.Context, p1 uuid.UUID, p2 string) (*ProviderDealRejectionInfo, error) `perm:"admin"`
 
+		BoostPaychInventory func(p0 context.Context) ([]paychmanager.Channel, error) `perm:"read"`
+
+		BoostPaychSettle func(p0 context.Context, p1 address.Address) error `perm:"admin"`
+
+		BoostPieceGC func(p0 context.Context, p1 bool) (*piecegc.Report, error) `perm:"admin"`
+
+		BoostRetrievalACL func(p0 context.Context) (*retrievalacl.Config, error) `perm:"read"`
+
+		BoostRetrievalStatsRecord func(p0 context.Context, p1 cid.Cid, p2 uint64) error `perm:"write"`
+
+		BoostRetrievalStatsRecordBlocks func(p0 context.Context, p1 []smtypes.BlockServed) error `perm:"write"`
+
+		BoostSupportSnapshot func(p0 context.Context, p1 supportbundle.SnapshotParams) (*supportbundle.Snapshot, error) `perm:"admin"`
+
 		DealsConsiderOfflineRetrievalDeals func(p0 context.Context) (bool, error) `perm:"admin"`
 
 		DealsConsiderOfflineStorageDeals func(p0 context.Context) (bool, error) `perm:"admin"`
@@ -124,6 +207,8 @@ type BoostStruct struct {
 
 		PiecesGetCIDInfo func(p0 context.Context, p1 cid.Cid) (*piecestore.CIDInfo, error) `perm:"read"`
 
+		PiecesGetIndex func(p0 context.Context, p1 cid.Cid) ([]byte, error) `perm:"read"`
+
 		PiecesGetMaxOffset func(p0 context.Context, p1 cid.Cid) (uint64, error) `perm:"read"`
 
 		PiecesGetPieceInfo func(p0 context.Context, p1 cid.Cid) (*piecestore.PieceInfo, error) `perm:"read"`
@@ -161,6 +246,8 @@ type CommonStruct struct {
 
 		AuthVerify func(p0 context.Context, p1 string) ([]auth.Permission, error) `perm:"read"`
 
+		Discover func(p0 context.Context) (apitypes.OpenRPCDocument, error) `perm:"read"`
+
 		LogList func(p0 context.Context) ([]string, error) `perm:"write"`
 
 		LogSetLevel func(p0 context.Context, p1 string, p2 string) error `perm:"write"`
@@ -305,6 +392,61 @@ func (s *BoostStub) BlockstoreHas(p0 context.Context, p1 cid.Cid) (bool, error)
 	return false, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostBackup(p0 context.Context, p1 string) (*repobackup.Manifest, error) {
+	if s.Internal.BoostBackup == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostBackup(p0, p1)
+}
+
+func (s *BoostStub) BoostBackup(p0 context.Context, p1 string) (*repobackup.Manifest, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostCapacityReservations(p0 context.Context) ([]smtypes.CapacityReservationStatus, error) {
+	if s.Internal.BoostCapacityReservations == nil {
+		return *new([]smtypes.CapacityReservationStatus), ErrNotSupported
+	}
+	return s.Internal.BoostCapacityReservations(p0)
+}
+
+func (s *BoostStub) BoostCapacityReservations(p0 context.Context) ([]smtypes.CapacityReservationStatus, error) {
+	return *new([]smtypes.CapacityReservationStatus), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostClientFundsMigrate(p0 context.Context, p1 address.Address, p2 bool) (*fundsmigration.Status, error) {
+	if s.Internal.BoostClientFundsMigrate == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostClientFundsMigrate(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostClientFundsMigrate(p0 context.Context, p1 address.Address, p2 bool) (*fundsmigration.Status, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostClientFundsMigrationStatus(p0 context.Context) (*fundsmigration.Status, error) {
+	if s.Internal.BoostClientFundsMigrationStatus == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostClientFundsMigrationStatus(p0)
+}
+
+func (s *BoostStub) BoostClientFundsMigrationStatus(p0 context.Context) (*fundsmigration.Status, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostConfigReload(p0 context.Context) error {
+	if s.Internal.BoostConfigReload == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostConfigReload(p0)
+}
+
+func (s *BoostStub) BoostConfigReload(p0 context.Context) error {
+	return ErrNotSupported
+}
+
 func (s *BoostStruct) BoostDagstoreDestroyShard(p0 context.Context, p1 string) error {
 	if s.Internal.BoostDagstoreDestroyShard == nil {
 		return ErrNotSupported
@@ -393,6 +535,83 @@ func (s *BoostStub) BoostDagstoreRegisterShard(p0 context.Context, p1 string) er
 	return ErrNotSupported
 }
 
+func (s *BoostStruct) BoostDataTransferCancel(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error {
+	if s.Internal.BoostDataTransferCancel == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferCancel(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) BoostDataTransferCancel(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDataTransferCancelPeer(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) {
+	if s.Internal.BoostDataTransferCancelPeer == nil {
+		return *new([]dtcontrol.Result), ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferCancelPeer(p0, p1)
+}
+
+func (s *BoostStub) BoostDataTransferCancelPeer(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) {
+	return *new([]dtcontrol.Result), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDataTransferIntents(p0 context.Context) ([]dtcontrol.Intent, error) {
+	if s.Internal.BoostDataTransferIntents == nil {
+		return *new([]dtcontrol.Intent), ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferIntents(p0)
+}
+
+func (s *BoostStub) BoostDataTransferIntents(p0 context.Context) ([]dtcontrol.Intent, error) {
+	return *new([]dtcontrol.Intent), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDataTransferPause(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error {
+	if s.Internal.BoostDataTransferPause == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferPause(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) BoostDataTransferPause(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDataTransferPausePeer(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) {
+	if s.Internal.BoostDataTransferPausePeer == nil {
+		return *new([]dtcontrol.Result), ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferPausePeer(p0, p1)
+}
+
+func (s *BoostStub) BoostDataTransferPausePeer(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) {
+	return *new([]dtcontrol.Result), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDataTransferResume(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error {
+	if s.Internal.BoostDataTransferResume == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferResume(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) BoostDataTransferResume(p0 context.Context, p1 datatransfer.TransferID, p2 peer.ID, p3 bool) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDataTransferResumePeer(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) {
+	if s.Internal.BoostDataTransferResumePeer == nil {
+		return *new([]dtcontrol.Result), ErrNotSupported
+	}
+	return s.Internal.BoostDataTransferResumePeer(p0, p1)
+}
+
+func (s *BoostStub) BoostDataTransferResumePeer(p0 context.Context, p1 peer.ID) ([]dtcontrol.Result, error) {
+	return *new([]dtcontrol.Result), ErrNotSupported
+}
+
 func (s *BoostStruct) BoostDeal(p0 context.Context, p1 uuid.UUID) (*smtypes.ProviderDealState, error) {
 	if s.Internal.BoostDeal == nil {
 		return nil, ErrNotSupported
@@ -404,6 +623,50 @@ func (s *BoostStub) BoostDeal(p0 context.Context, p1 uuid.UUID) (*smtypes.Provid
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostDealAnnotate(p0 context.Context, p1 uuid.UUID, p2 string, p3 string) error {
+	if s.Internal.BoostDealAnnotate == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDealAnnotate(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) BoostDealAnnotate(p0 context.Context, p1 uuid.UUID, p2 string, p3 string) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealAnnotationRemove(p0 context.Context, p1 uuid.UUID, p2 string) error {
+	if s.Internal.BoostDealAnnotationRemove == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDealAnnotationRemove(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostDealAnnotationRemove(p0 context.Context, p1 uuid.UUID, p2 string) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealAnnotations(p0 context.Context, p1 uuid.UUID) ([]smtypes.DealAnnotation, error) {
+	if s.Internal.BoostDealAnnotations == nil {
+		return *new([]smtypes.DealAnnotation), ErrNotSupported
+	}
+	return s.Internal.BoostDealAnnotations(p0, p1)
+}
+
+func (s *BoostStub) BoostDealAnnotations(p0 context.Context, p1 uuid.UUID) ([]smtypes.DealAnnotation, error) {
+	return *new([]smtypes.DealAnnotation), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealAnnotationsList(p0 context.Context, p1 string, p2 string) ([]smtypes.DealAnnotation, error) {
+	if s.Internal.BoostDealAnnotationsList == nil {
+		return *new([]smtypes.DealAnnotation), ErrNotSupported
+	}
+	return s.Internal.BoostDealAnnotationsList(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostDealAnnotationsList(p0 context.Context, p1 string, p2 string) ([]smtypes.DealAnnotation, error) {
+	return *new([]smtypes.DealAnnotation), ErrNotSupported
+}
+
 func (s *BoostStruct) BoostDealBySignedProposalCid(p0 context.Context, p1 cid.Cid) (*smtypes.ProviderDealState, error) {
 	if s.Internal.BoostDealBySignedProposalCid == nil {
 		return nil, ErrNotSupported
@@ -415,6 +678,50 @@ func (s *BoostStub) BoostDealBySignedProposalCid(p0 context.Context, p1 cid.Cid)
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostDealQueue(p0 context.Context) ([]smtypes.QueuedDeal, error) {
+	if s.Internal.BoostDealQueue == nil {
+		return *new([]smtypes.QueuedDeal), ErrNotSupported
+	}
+	return s.Internal.BoostDealQueue(p0)
+}
+
+func (s *BoostStub) BoostDealQueue(p0 context.Context) ([]smtypes.QueuedDeal, error) {
+	return *new([]smtypes.QueuedDeal), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealQueueSetWeight(p0 context.Context, p1 uuid.UUID, p2 int64) error {
+	if s.Internal.BoostDealQueueSetWeight == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDealQueueSetWeight(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostDealQueueSetWeight(p0 context.Context, p1 uuid.UUID, p2 int64) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealRateLimits(p0 context.Context) (*smtypes.DealRateLimitStatus, error) {
+	if s.Internal.BoostDealRateLimits == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostDealRateLimits(p0)
+}
+
+func (s *BoostStub) BoostDealRateLimits(p0 context.Context) (*smtypes.DealRateLimitStatus, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostDealRateLimitsSet(p0 context.Context, p1 smtypes.DealRateLimits) error {
+	if s.Internal.BoostDealRateLimitsSet == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostDealRateLimitsSet(p0, p1)
+}
+
+func (s *BoostStub) BoostDealRateLimitsSet(p0 context.Context, p1 smtypes.DealRateLimits) error {
+	return ErrNotSupported
+}
+
 func (s *BoostStruct) BoostDummyDeal(p0 context.Context, p1 smtypes.DealParams) (*ProviderDealRejectionInfo, error) {
 	if s.Internal.BoostDummyDeal == nil {
 		return nil, ErrNotSupported
@@ -426,6 +733,50 @@ func (s *BoostStub) BoostDummyDeal(p0 context.Context, p1 smtypes.DealParams) (*
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostFaultsGet(p0 context.Context) (faults.Faults, error) {
+	if s.Internal.BoostFaultsGet == nil {
+		return *new(faults.Faults), ErrNotSupported
+	}
+	return s.Internal.BoostFaultsGet(p0)
+}
+
+func (s *BoostStub) BoostFaultsGet(p0 context.Context) (faults.Faults, error) {
+	return *new(faults.Faults), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostFaultsSet(p0 context.Context, p1 faults.Faults) error {
+	if s.Internal.BoostFaultsSet == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostFaultsSet(p0, p1)
+}
+
+func (s *BoostStub) BoostFaultsSet(p0 context.Context, p1 faults.Faults) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostFeatureSet(p0 context.Context, p1 string, p2 bool) error {
+	if s.Internal.BoostFeatureSet == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostFeatureSet(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostFeatureSet(p0 context.Context, p1 string, p2 bool) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostFeatures(p0 context.Context) ([]features.Status, error) {
+	if s.Internal.BoostFeatures == nil {
+		return *new([]features.Status), ErrNotSupported
+	}
+	return s.Internal.BoostFeatures(p0)
+}
+
+func (s *BoostStub) BoostFeatures(p0 context.Context) ([]features.Status, error) {
+	return *new([]features.Status), ErrNotSupported
+}
+
 func (s *BoostStruct) BoostIndexerAnnounceAllDeals(p0 context.Context) error {
 	if s.Internal.BoostIndexerAnnounceAllDeals == nil {
 		return ErrNotSupported
@@ -437,6 +788,61 @@ func (s *BoostStub) BoostIndexerAnnounceAllDeals(p0 context.Context) error {
 	return ErrNotSupported
 }
 
+func (s *BoostStruct) BoostListImports(p0 context.Context, p1 smtypes.ImportsFilter) (<-chan smtypes.ImportInfo, error) {
+	if s.Internal.BoostListImports == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostListImports(p0, p1)
+}
+
+func (s *BoostStub) BoostListImports(p0 context.Context, p1 smtypes.ImportsFilter) (<-chan smtypes.ImportInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostOfflineDealUpload(p0 context.Context, p1 uuid.UUID) (*smtypes.OfflineDealUpload, error) {
+	if s.Internal.BoostOfflineDealUpload == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostOfflineDealUpload(p0, p1)
+}
+
+func (s *BoostStub) BoostOfflineDealUpload(p0 context.Context, p1 uuid.UUID) (*smtypes.OfflineDealUpload, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostOfflineDealUploadCancel(p0 context.Context, p1 uuid.UUID) error {
+	if s.Internal.BoostOfflineDealUploadCancel == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostOfflineDealUploadCancel(p0, p1)
+}
+
+func (s *BoostStub) BoostOfflineDealUploadCancel(p0 context.Context, p1 uuid.UUID) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostOfflineDealUploadChunk(p0 context.Context, p1 uuid.UUID, p2 uint64, p3 io.Reader) (uint64, error) {
+	if s.Internal.BoostOfflineDealUploadChunk == nil {
+		return 0, ErrNotSupported
+	}
+	return s.Internal.BoostOfflineDealUploadChunk(p0, p1, p2, p3)
+}
+
+func (s *BoostStub) BoostOfflineDealUploadChunk(p0 context.Context, p1 uuid.UUID, p2 uint64, p3 io.Reader) (uint64, error) {
+	return 0, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostOfflineDealUploadFinish(p0 context.Context, p1 uuid.UUID) (*ProviderDealRejectionInfo, error) {
+	if s.Internal.BoostOfflineDealUploadFinish == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostOfflineDealUploadFinish(p0, p1)
+}
+
+func (s *BoostStub) BoostOfflineDealUploadFinish(p0 context.Context, p1 uuid.UUID) (*ProviderDealRejectionInfo, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *BoostStruct) BoostOfflineDealWithData(p0 context.Context, p1 uuid.UUID, p2 string) (*ProviderDealRejectionInfo, error) {
 	if s.Internal.BoostOfflineDealWithData == nil {
 		return nil, ErrNotSupported
@@ -448,6 +854,83 @@ func (s *BoostStub) BoostOfflineDealWithData(p0 context.Context, p1 uuid.UUID, p
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) BoostPaychInventory(p0 context.Context) ([]paychmanager.Channel, error) {
+	if s.Internal.BoostPaychInventory == nil {
+		return *new([]paychmanager.Channel), ErrNotSupported
+	}
+	return s.Internal.BoostPaychInventory(p0)
+}
+
+func (s *BoostStub) BoostPaychInventory(p0 context.Context) ([]paychmanager.Channel, error) {
+	return *new([]paychmanager.Channel), ErrNotSupported
+}
+
+func (s *BoostStruct) BoostPaychSettle(p0 context.Context, p1 address.Address) error {
+	if s.Internal.BoostPaychSettle == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostPaychSettle(p0, p1)
+}
+
+func (s *BoostStub) BoostPaychSettle(p0 context.Context, p1 address.Address) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostPieceGC(p0 context.Context, p1 bool) (*piecegc.Report, error) {
+	if s.Internal.BoostPieceGC == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostPieceGC(p0, p1)
+}
+
+func (s *BoostStub) BoostPieceGC(p0 context.Context, p1 bool) (*piecegc.Report, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostRetrievalACL(p0 context.Context) (*retrievalacl.Config, error) {
+	if s.Internal.BoostRetrievalACL == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostRetrievalACL(p0)
+}
+
+func (s *BoostStub) BoostRetrievalACL(p0 context.Context) (*retrievalacl.Config, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *BoostStruct) BoostRetrievalStatsRecord(p0 context.Context, p1 cid.Cid, p2 uint64) error {
+	if s.Internal.BoostRetrievalStatsRecord == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostRetrievalStatsRecord(p0, p1, p2)
+}
+
+func (s *BoostStub) BoostRetrievalStatsRecord(p0 context.Context, p1 cid.Cid, p2 uint64) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostRetrievalStatsRecordBlocks(p0 context.Context, p1 []smtypes.BlockServed) error {
+	if s.Internal.BoostRetrievalStatsRecordBlocks == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.BoostRetrievalStatsRecordBlocks(p0, p1)
+}
+
+func (s *BoostStub) BoostRetrievalStatsRecordBlocks(p0 context.Context, p1 []smtypes.BlockServed) error {
+	return ErrNotSupported
+}
+
+func (s *BoostStruct) BoostSupportSnapshot(p0 context.Context, p1 supportbundle.SnapshotParams) (*supportbundle.Snapshot, error) {
+	if s.Internal.BoostSupportSnapshot == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.BoostSupportSnapshot(p0, p1)
+}
+
+func (s *BoostStub) BoostSupportSnapshot(p0 context.Context, p1 supportbundle.SnapshotParams) (*supportbundle.Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *BoostStruct) DealsConsiderOfflineRetrievalDeals(p0 context.Context) (bool, error) {
 	if s.Internal.DealsConsiderOfflineRetrievalDeals == nil {
 		return false, ErrNotSupported
@@ -745,6 +1228,17 @@ func (s *BoostStub) PiecesGetCIDInfo(p0 context.Context, p1 cid.Cid) (*piecestor
 	return nil, ErrNotSupported
 }
 
+func (s *BoostStruct) PiecesGetIndex(p0 context.Context, p1 cid.Cid) ([]byte, error) {
+	if s.Internal.PiecesGetIndex == nil {
+		return *new([]byte), ErrNotSupported
+	}
+	return s.Internal.PiecesGetIndex(p0, p1)
+}
+
+func (s *BoostStub) PiecesGetIndex(p0 context.Context, p1 cid.Cid) ([]byte, error) {
+	return *new([]byte), ErrNotSupported
+}
+
 func (s *BoostStruct) PiecesGetMaxOffset(p0 context.Context, p1 cid.Cid) (uint64, error) {
 	if s.Internal.PiecesGetMaxOffset == nil {
 		return 0, ErrNotSupported
@@ -855,6 +1349,17 @@ func (s *CommonStub) AuthVerify(p0 context.Context, p1 string) ([]auth.Permissio
 	return *new([]auth.Permission), ErrNotSupported
 }
 
+func (s *CommonStruct) Discover(p0 context.Context) (apitypes.OpenRPCDocument, error) {
+	if s.Internal.Discover == nil {
+		return *new(apitypes.OpenRPCDocument), ErrNotSupported
+	}
+	return s.Internal.Discover(p0)
+}
+
+func (s *CommonStub) Discover(p0 context.Context) (apitypes.OpenRPCDocument, error) {
+	return *new(apitypes.OpenRPCDocument), ErrNotSupported
+}
+
 func (s *CommonStruct) LogList(p0 context.Context) ([]string, error) {
 	if s.Internal.LogList == nil {
 		return *new([]string), ErrNotSupported
@@ -24,16 +24,35 @@ type CarOffsetWriter struct {
 	nodeGetter format.NodeGetter
 	blockInfos *BlockInfoCache
 	header     car.CarHeader
+	traversal  *TraversalOptions
+	stats      *TraversalStats
 }
 
-func NewCarOffsetWriter(payloadCid cid.Cid, bstore blockstore.Blockstore, blockInfos *BlockInfoCache) *CarOffsetWriter {
+// CarOffsetWriterOption is an option for configuring the CarOffsetWriter
+type CarOffsetWriterOption func(*CarOffsetWriter)
+
+// WithTraversalOptions bounds the memory used by the DAG traversal, and
+// records traversal stats to the given stats (or to GlobalTraversalStats
+// if stats is nil)
+func WithTraversalOptions(opts TraversalOptions, stats *TraversalStats) CarOffsetWriterOption {
+	return func(s *CarOffsetWriter) {
+		s.traversal = &opts
+		s.stats = stats
+	}
+}
+
+func NewCarOffsetWriter(payloadCid cid.Cid, bstore blockstore.Blockstore, blockInfos *BlockInfoCache, opts ...CarOffsetWriterOption) *CarOffsetWriter {
 	ng := merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore)))
-	return &CarOffsetWriter{
+	s := &CarOffsetWriter{
 		payloadCid: payloadCid,
 		nodeGetter: ng,
 		blockInfos: blockInfos,
 		header:     carHeader(payloadCid),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func carHeader(payloadCid cid.Cid) car.CarHeader {
@@ -147,6 +166,9 @@ func (s *CarOffsetWriter) writeBlocks(ctx context.Context, w io.Writer, headerSi
 	}
 
 	seen := cid.NewSet()
+	if s.traversal != nil {
+		return Walk(ctx, nextCid, s.payloadCid, seen.Visit, *s.traversal, s.stats)
+	}
 	return merkledag.Walk(ctx, nextCid, s.payloadCid, seen.Visit)
 }
 
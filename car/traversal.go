@@ -0,0 +1,283 @@
+package car
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+)
+
+// TraversalOptions bounds the amount of memory used by a DAG traversal.
+type TraversalOptions struct {
+	// The maximum number of bytes of pending links to keep in memory.
+	// When the limit is exceeded, the oldest pending links are spilled to a
+	// temporary file on disk. Zero means no limit.
+	MaxMemoryBytes uint64
+	// The directory in which to create spill files.
+	// If empty, the default directory for temporary files is used.
+	SpillDir string
+}
+
+// TraversalStats keeps track of memory usage across DAG traversals
+type TraversalStats struct {
+	activeWalks     int64
+	blocksVisited   uint64
+	memoryBytes     int64
+	peakMemoryBytes int64
+	spilledLinks    uint64
+	spilledBytes    uint64
+}
+
+// TraversalStatsSnapshot is a point-in-time copy of TraversalStats
+type TraversalStatsSnapshot struct {
+	// The number of traversals currently in progress
+	ActiveWalks int64
+	// The total number of blocks visited
+	BlocksVisited uint64
+	// The number of bytes of pending links currently held in memory
+	MemoryBytes int64
+	// The highest number of bytes of pending links held in memory at once
+	PeakMemoryBytes int64
+	// The total number of links that were spilled to disk
+	SpilledLinks uint64
+	// The total number of bytes that were spilled to disk
+	SpilledBytes uint64
+}
+
+func (s *TraversalStats) Snapshot() TraversalStatsSnapshot {
+	return TraversalStatsSnapshot{
+		ActiveWalks:     atomic.LoadInt64(&s.activeWalks),
+		BlocksVisited:   atomic.LoadUint64(&s.blocksVisited),
+		MemoryBytes:     atomic.LoadInt64(&s.memoryBytes),
+		PeakMemoryBytes: atomic.LoadInt64(&s.peakMemoryBytes),
+		SpilledLinks:    atomic.LoadUint64(&s.spilledLinks),
+		SpilledBytes:    atomic.LoadUint64(&s.spilledBytes),
+	}
+}
+
+func (s *TraversalStats) addMemory(delta int64) {
+	mem := atomic.AddInt64(&s.memoryBytes, delta)
+	for {
+		peak := atomic.LoadInt64(&s.peakMemoryBytes)
+		if mem <= peak || atomic.CompareAndSwapInt64(&s.peakMemoryBytes, peak, mem) {
+			return
+		}
+	}
+}
+
+// GlobalTraversalStats aggregates the stats of all traversals in the process
+var GlobalTraversalStats = &TraversalStats{}
+
+// Walk does a depth-first traversal of the DAG starting at root, in the same
+// order as merkledag.Walk. Pending links are kept on a stack that spills to
+// disk once it exceeds opts.MaxMemoryBytes, so that very wide DAGs can be
+// traversed with bounded memory.
+func Walk(ctx context.Context, getLinks merkledag.GetLinks, root cid.Cid, visit func(cid.Cid) bool, opts TraversalOptions, stats *TraversalStats) error {
+	if stats == nil {
+		stats = GlobalTraversalStats
+	}
+	atomic.AddInt64(&stats.activeWalks, 1)
+	defer atomic.AddInt64(&stats.activeWalks, -1)
+
+	stack := newLinkStack(opts, stats)
+	defer stack.close()
+
+	if err := stack.push([]cid.Cid{root}); err != nil {
+		return err
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		c, ok, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		if !visit(c) {
+			continue
+		}
+		atomic.AddUint64(&stats.blocksVisited, 1)
+
+		links, err := getLinks(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		// Push the links in reverse order so that the first link is popped
+		// first
+		cids := make([]cid.Cid, 0, len(links))
+		for i := len(links) - 1; i >= 0; i-- {
+			cids = append(cids, links[i].Cid)
+		}
+		if err := stack.push(cids); err != nil {
+			return err
+		}
+	}
+}
+
+// linkStack is a stack of CIDs. The top of the stack is kept in memory, and
+// when the stack exceeds the memory limit, the bottom half of the in-memory
+// stack is written to a spill file as a new segment.
+type linkStack struct {
+	opts  TraversalOptions
+	stats *TraversalStats
+
+	mem      []cid.Cid
+	memBytes int64
+
+	spill    *os.File
+	segments []int64
+	spillEnd int64
+}
+
+func newLinkStack(opts TraversalOptions, stats *TraversalStats) *linkStack {
+	return &linkStack{opts: opts, stats: stats}
+}
+
+func (s *linkStack) push(cids []cid.Cid) error {
+	for _, c := range cids {
+		s.mem = append(s.mem, c)
+		sz := int64(c.ByteLen())
+		s.memBytes += sz
+		s.stats.addMemory(sz)
+
+		if s.opts.MaxMemoryBytes == 0 || uint64(s.memBytes) <= s.opts.MaxMemoryBytes || len(s.mem) < 2 {
+			continue
+		}
+		if err := s.spillBottom(len(s.mem) / 2); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *linkStack) pop() (cid.Cid, bool, error) {
+	if len(s.mem) == 0 {
+		if err := s.unspill(); err != nil {
+			return cid.Undef, false, err
+		}
+		if len(s.mem) == 0 {
+			return cid.Undef, false, nil
+		}
+	}
+
+	c := s.mem[len(s.mem)-1]
+	s.mem = s.mem[:len(s.mem)-1]
+	sz := int64(c.ByteLen())
+	s.memBytes -= sz
+	s.stats.addMemory(-sz)
+	return c, true, nil
+}
+
+// spillBottom writes the bottom count CIDs of the in-memory stack to the
+// spill file as a new segment
+func (s *linkStack) spillBottom(count int) error {
+	if s.spill == nil {
+		f, err := os.CreateTemp(s.opts.SpillDir, "boost-traversal-*.spill")
+		if err != nil {
+			return fmt.Errorf("creating traversal spill file: %w", err)
+		}
+		s.spill = f
+	}
+
+	if _, err := s.spill.Seek(s.spillEnd, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking traversal spill file: %w", err)
+	}
+
+	w := bufio.NewWriter(s.spill)
+	var written int64
+	var spilledBytes int64
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, c := range s.mem[:count] {
+		bz := c.Bytes()
+		n := binary.PutUvarint(lenBuf, uint64(len(bz)))
+		if _, err := w.Write(lenBuf[:n]); err != nil {
+			return fmt.Errorf("writing traversal spill file: %w", err)
+		}
+		if _, err := w.Write(bz); err != nil {
+			return fmt.Errorf("writing traversal spill file: %w", err)
+		}
+		written += int64(n + len(bz))
+		spilledBytes += int64(c.ByteLen())
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing traversal spill file: %w", err)
+	}
+
+	s.segments = append(s.segments, s.spillEnd)
+	s.spillEnd += written
+
+	remaining := make([]cid.Cid, len(s.mem)-count)
+	copy(remaining, s.mem[count:])
+	s.mem = remaining
+	s.memBytes -= spilledBytes
+	s.stats.addMemory(-spilledBytes)
+	atomic.AddUint64(&s.stats.spilledLinks, uint64(count))
+	atomic.AddUint64(&s.stats.spilledBytes, uint64(written))
+
+	return nil
+}
+
+// unspill reads the most recently spilled segment back into memory
+func (s *linkStack) unspill() error {
+	if len(s.segments) == 0 {
+		return nil
+	}
+
+	start := s.segments[len(s.segments)-1]
+	if _, err := s.spill.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking traversal spill file: %w", err)
+	}
+
+	r := bufio.NewReader(io.LimitReader(s.spill, s.spillEnd-start))
+	for {
+		l, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading traversal spill file: %w", err)
+		}
+		bz := make([]byte, l)
+		if _, err := io.ReadFull(r, bz); err != nil {
+			return fmt.Errorf("reading traversal spill file: %w", err)
+		}
+		_, c, err := cid.CidFromBytes(bz)
+		if err != nil {
+			return fmt.Errorf("parsing cid from traversal spill file: %w", err)
+		}
+		s.mem = append(s.mem, c)
+		sz := int64(c.ByteLen())
+		s.memBytes += sz
+		s.stats.addMemory(sz)
+	}
+
+	s.segments = s.segments[:len(s.segments)-1]
+	s.spillEnd = start
+	return nil
+}
+
+func (s *linkStack) close() {
+	s.stats.addMemory(-s.memBytes)
+	s.memBytes = 0
+	s.mem = nil
+
+	if s.spill != nil {
+		_ = s.spill.Close()
+		_ = os.Remove(s.spill.Name())
+		s.spill = nil
+	}
+}
@@ -0,0 +1,60 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-merkledag"
+	merkledagpb "github.com/ipfs/go-merkledag/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarOffsetWriterBoundedTraversal(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := bstore.NewBlockstore(ds)
+	bserv := blockservice.New(bs, nil)
+
+	// Make a wide DAG: a root block with many leaf blocks
+	pbn := &merkledagpb.PBNode{}
+	for i := 0; i < 500; i++ {
+		name := fmt.Sprintf("leaf-%d", i)
+		leaf := merkledag.NewRawNode([]byte(name))
+		require.NoError(t, bserv.AddBlock(ctx, leaf))
+		pbn.Links = append(pbn.Links, &merkledagpb.PBLink{Hash: leaf.Cid().Bytes(), Name: &name})
+	}
+	rootByts, err := pbn.Marshal()
+	require.NoError(t, err)
+	rootBlk := blocks.NewBlock(rootByts)
+	require.NoError(t, bserv.AddBlock(ctx, rootBlk))
+
+	// Write the CAR without a memory limit
+	var expected bytes.Buffer
+	cow := NewCarOffsetWriter(rootBlk.Cid(), bs, NewBlockInfoCache())
+	require.NoError(t, cow.Write(ctx, &expected, 0))
+
+	// Write the CAR with a small memory limit so that links are spilled to disk
+	stats := &TraversalStats{}
+	opts := TraversalOptions{MaxMemoryBytes: 1024, SpillDir: t.TempDir()}
+	var actual bytes.Buffer
+	cow = NewCarOffsetWriter(rootBlk.Cid(), bs, NewBlockInfoCache(), WithTraversalOptions(opts, stats))
+	require.NoError(t, cow.Write(ctx, &actual, 0))
+
+	// The output should be identical
+	require.Equal(t, expected.Bytes(), actual.Bytes())
+
+	snap := stats.Snapshot()
+	require.EqualValues(t, 501, snap.BlocksVisited)
+	require.Greater(t, snap.SpilledLinks, uint64(0))
+	require.Greater(t, snap.SpilledBytes, uint64(0))
+	require.EqualValues(t, 0, snap.MemoryBytes)
+	require.EqualValues(t, 0, snap.ActiveWalks)
+	require.Less(t, snap.PeakMemoryBytes, int64(2*1024))
+}
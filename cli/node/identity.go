@@ -0,0 +1,211 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	crand "crypto/rand"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Identity is a libp2p identity that the client has used to make deals
+type Identity struct {
+	PeerID    peer.ID    `json:"peerId"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"`
+	// The identity that replaced this one when it was rotated
+	ReplacedBy peer.ID `json:"replacedBy,omitempty"`
+	// Ephemeral identities are generated for a single batch of deals, and
+	// their keys are not kept
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// The deals proposed with this identity
+	Deals []uuid.UUID `json:"deals,omitempty"`
+}
+
+// Identities keeps track of the client's current and past libp2p
+// identities, and maps retired identities to the identity that replaced
+// them so that deals proposed with an old identity can be matched to the
+// client's current identity
+type Identities struct {
+	path string
+
+	lk         sync.Mutex
+	Identities []*Identity `json:"identities"`
+}
+
+func identitiesPath(baseDir string) string {
+	return filepath.Join(baseDir, "identities.json")
+}
+
+func retiredKeysPath(baseDir string) string {
+	return filepath.Join(baseDir, "libp2p-keys")
+}
+
+// LoadIdentities reads the identity records from the client repo
+func LoadIdentities(cfgdir string) (*Identities, error) {
+	ids := &Identities{path: identitiesPath(cfgdir)}
+	b, err := os.ReadFile(ids.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ids, nil
+		}
+		return nil, fmt.Errorf("reading identities: %w", err)
+	}
+	if err := json.Unmarshal(b, ids); err != nil {
+		return nil, fmt.Errorf("parsing identities %s: %w", ids.path, err)
+	}
+	return ids, nil
+}
+
+// Get returns the record for the peer ID, or nil if there is none
+func (ids *Identities) Get(p peer.ID) *Identity {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+	return ids.get(p)
+}
+
+func (ids *Identities) get(p peer.ID) *Identity {
+	for _, id := range ids.Identities {
+		if id.PeerID == p {
+			return id
+		}
+	}
+	return nil
+}
+
+// Current follows the chain of rotations from the peer ID, and returns the
+// identity that has replaced it (or p itself if it hasn't been rotated)
+func (ids *Identities) Current(p peer.ID) peer.ID {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	seen := make(map[peer.ID]struct{})
+	for {
+		id := ids.get(p)
+		if id == nil || id.ReplacedBy == "" {
+			return p
+		}
+		if _, ok := seen[p]; ok {
+			return p
+		}
+		seen[p] = struct{}{}
+		p = id.ReplacedBy
+	}
+}
+
+// RecordDeal records that a deal was proposed with the identity
+func (ids *Identities) RecordDeal(p peer.ID, ephemeral bool, dealUuid uuid.UUID) error {
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+
+	id := ids.ensure(p, ephemeral)
+	id.Deals = append(id.Deals, dealUuid)
+	return ids.save()
+}
+
+func (ids *Identities) ensure(p peer.ID, ephemeral bool) *Identity {
+	id := ids.get(p)
+	if id == nil {
+		id = &Identity{PeerID: p, CreatedAt: time.Now(), Ephemeral: ephemeral}
+		ids.Identities = append(ids.Identities, id)
+	}
+	return id
+}
+
+func (ids *Identities) save() error {
+	b, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing identities: %w", err)
+	}
+	if err := os.WriteFile(ids.path, b, 0600); err != nil {
+		return fmt.Errorf("writing identities: %w", err)
+	}
+	return nil
+}
+
+// Rotation is the result of rotating the client's libp2p identity
+type Rotation struct {
+	Old peer.ID `json:"old"`
+	New peer.ID `json:"new"`
+	// The path that the old key was moved to
+	RetiredKeyPath string `json:"retiredKeyPath"`
+}
+
+// RotateIdentity replaces the client's libp2p key with a new key. The old
+// key is kept in the repo, and the old peer ID is mapped to the new one.
+func RotateIdentity(cfgdir string) (*Rotation, error) {
+	ids, err := LoadIdentities(cfgdir)
+	if err != nil {
+		return nil, err
+	}
+
+	oldKey, err := loadOrInitPeerKey(keyPath(cfgdir))
+	if err != nil {
+		return nil, err
+	}
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	newKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the old key, so that the old identity can still be proven
+	if err := os.MkdirAll(retiredKeysPath(cfgdir), 0700); err != nil {
+		return nil, fmt.Errorf("creating retired keys dir: %w", err)
+	}
+	retiredPath := filepath.Join(retiredKeysPath(cfgdir), oldID.String()+".key")
+	if err := os.Rename(keyPath(cfgdir), retiredPath); err != nil {
+		return nil, fmt.Errorf("retiring libp2p key: %w", err)
+	}
+	if err := os.WriteFile(keyPath(cfgdir), data, 0600); err != nil {
+		return nil, fmt.Errorf("writing new libp2p key: %w", err)
+	}
+
+	ids.lk.Lock()
+	defer ids.lk.Unlock()
+	now := time.Now()
+	old := ids.ensure(oldID, false)
+	old.RetiredAt = &now
+	old.ReplacedBy = newID
+	ids.ensure(newID, false)
+	if err := ids.save(); err != nil {
+		return nil, err
+	}
+
+	return &Rotation{Old: oldID, New: newID, RetiredKeyPath: retiredPath}, nil
+}
+
+// generateEphemeralKey generates a key for an ephemeral identity
+func generateEphemeralKey() (crypto.PrivKey, error) {
+	k, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	return k, err
+}
+
+// PeerID returns the peer ID of the client's persistent libp2p identity
+func PeerID(cfgdir string) (peer.ID, error) {
+	k, err := loadOrInitPeerKey(keyPath(cfgdir))
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPrivateKey(k)
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateIdentity(t *testing.T) {
+	cfgdir := t.TempDir()
+
+	first, err := PeerID(cfgdir)
+	require.NoError(t, err)
+
+	// Deals proposed with the first identity are recorded against it
+	dealUuid := uuid.New()
+	ids, err := LoadIdentities(cfgdir)
+	require.NoError(t, err)
+	require.NoError(t, ids.RecordDeal(first, false, dealUuid))
+
+	rot, err := RotateIdentity(cfgdir)
+	require.NoError(t, err)
+	require.Equal(t, first, rot.Old)
+	require.NotEqual(t, first, rot.New)
+
+	// The repo now has the new key
+	current, err := PeerID(cfgdir)
+	require.NoError(t, err)
+	require.Equal(t, rot.New, current)
+
+	// The old key is kept
+	data, err := os.ReadFile(rot.RetiredKeyPath)
+	require.NoError(t, err)
+	oldKey, err := crypto.UnmarshalPrivateKey(data)
+	require.NoError(t, err)
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	require.NoError(t, err)
+	require.Equal(t, first, oldID)
+	require.Equal(t, filepath.Join(cfgdir, "libp2p-keys", first.String()+".key"), rot.RetiredKeyPath)
+
+	// The old identity is retired and mapped to the new one
+	ids, err = LoadIdentities(cfgdir)
+	require.NoError(t, err)
+	old := ids.Get(first)
+	require.NotNil(t, old)
+	require.NotNil(t, old.RetiredAt)
+	require.Equal(t, rot.New, old.ReplacedBy)
+	require.Equal(t, []uuid.UUID{dealUuid}, old.Deals)
+	require.NotNil(t, ids.Get(rot.New))
+	require.Nil(t, ids.Get(rot.New).RetiredAt)
+
+	// Rotating again maps each retired identity to the latest identity
+	rot2, err := RotateIdentity(cfgdir)
+	require.NoError(t, err)
+	require.Equal(t, rot.New, rot2.Old)
+	ids, err = LoadIdentities(cfgdir)
+	require.NoError(t, err)
+	require.Equal(t, rot2.New, ids.Current(first))
+	require.Equal(t, rot2.New, ids.Current(rot.New))
+	require.Equal(t, rot2.New, ids.Current(rot2.New))
+}
+
+func TestIdentities(t *testing.T) {
+	cfgdir := t.TempDir()
+
+	// There are no identities until a deal is recorded
+	ids, err := LoadIdentities(cfgdir)
+	require.NoError(t, err)
+	require.Empty(t, ids.Identities)
+
+	p1, p2 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	require.Nil(t, ids.Get(p1))
+	require.Equal(t, p1, ids.Current(p1))
+
+	d1, d2, d3 := uuid.New(), uuid.New(), uuid.New()
+	require.NoError(t, ids.RecordDeal(p1, false, d1))
+	require.NoError(t, ids.RecordDeal(p1, false, d2))
+	require.NoError(t, ids.RecordDeal(p2, true, d3))
+
+	// The records are persisted to the repo
+	ids, err = LoadIdentities(cfgdir)
+	require.NoError(t, err)
+	require.Len(t, ids.Identities, 2)
+	require.Equal(t, []uuid.UUID{d1, d2}, ids.Get(p1).Deals)
+	require.False(t, ids.Get(p1).Ephemeral)
+	require.Equal(t, []uuid.UUID{d3}, ids.Get(p2).Deals)
+	require.True(t, ids.Get(p2).Ephemeral)
+
+	// A cycle of replacements doesn't loop forever
+	ids.Get(p1).ReplacedBy = p2
+	ids.Get(p2).ReplacedBy = p1
+	require.Contains(t, []peer.ID{p1, p2}, ids.Current(p1))
+
+	// A corrupt identities file is an error
+	require.NoError(t, os.WriteFile(identitiesPath(cfgdir), []byte("{not json"), 0600))
+	_, err = LoadIdentities(cfgdir)
+	require.ErrorContains(t, err, "parsing identities")
+}
+
+func TestEphemeralKey(t *testing.T) {
+	cfgdir := t.TempDir()
+
+	persistent, err := PeerID(cfgdir)
+	require.NoError(t, err)
+
+	// An ephemeral identity is not linked to the persistent identity, and
+	// doesn't replace it
+	k, err := generateEphemeralKey()
+	require.NoError(t, err)
+	ephemeral, err := peer.IDFromPrivateKey(k)
+	require.NoError(t, err)
+	require.NotEqual(t, persistent, ephemeral)
+
+	current, err := PeerID(cfgdir)
+	require.NoError(t, err)
+	require.Equal(t, persistent, current)
+}
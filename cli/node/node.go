@@ -24,9 +24,32 @@ import (
 type Node struct {
 	Host   host.Host
 	Wallet *wallet.LocalWallet
+	// Whether the host has an ephemeral identity rather than the client's
+	// persistent identity
+	Ephemeral bool
 }
 
-func Setup(cfgdir string) (*Node, error) {
+type setupOpts struct {
+	ephemeral bool
+}
+
+type SetupOption func(*setupOpts)
+
+// EphemeralIdentity gives the host a newly generated libp2p identity that
+// isn't linked to the client's persistent identity (eg for a batch of
+// deals). The wallet is still the client's wallet.
+func EphemeralIdentity(ephemeral bool) SetupOption {
+	return func(o *setupOpts) {
+		o.ephemeral = ephemeral
+	}
+}
+
+func Setup(cfgdir string, opts ...SetupOption) (*Node, error) {
+	var o setupOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	cfgdir, err := homedir.Expand(cfgdir)
 	if err != nil {
 		return nil, fmt.Errorf("getting homedir: %w", err)
@@ -37,7 +60,12 @@ func Setup(cfgdir string) (*Node, error) {
 		return nil, errors.New("repo dir doesn't exist. run `boost init` first.")
 	}
 
-	peerkey, err := loadOrInitPeerKey(keyPath(cfgdir))
+	var peerkey crypto.PrivKey
+	if o.ephemeral {
+		peerkey, err = generateEphemeralKey()
+	} else {
+		peerkey, err = loadOrInitPeerKey(keyPath(cfgdir))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -56,8 +84,9 @@ func Setup(cfgdir string) (*Node, error) {
 	}
 
 	return &Node{
-		Host:   h,
-		Wallet: wallet,
+		Host:      h,
+		Wallet:    wallet,
+		Ephemeral: o.ephemeral,
 	}, nil
 }
 
@@ -88,12 +117,7 @@ func loadOrInitPeerKey(kf string) (crypto.PrivKey, error) {
 }
 
 func setupWallet(dir string) (*wallet.LocalWallet, error) {
-	kstore, err := keystore.OpenOrInitKeystore(dir)
-	if err != nil {
-		return nil, err
-	}
-
-	wallet, err := wallet.NewWallet(kstore)
+	wallet, err := OpenWallet(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +137,16 @@ func setupWallet(dir string) (*wallet.LocalWallet, error) {
 	return wallet, nil
 }
 
+// OpenWallet opens the wallet with the keystore in dir, without setting up
+// the rest of the node (eg for signing on a machine with no network access)
+func OpenWallet(dir string) (*wallet.LocalWallet, error) {
+	kstore, err := keystore.OpenOrInitKeystore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.NewWallet(kstore)
+}
+
 func keyPath(baseDir string) string {
 	return filepath.Join(baseDir, "libp2p.key")
 }
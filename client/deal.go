@@ -2,14 +2,21 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/filecoin-project/boost/api"
+	"github.com/filecoin-project/boost/lib/carserver"
+	"github.com/filecoin-project/boost/lib/dealbatch"
 	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/build"
 	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -19,8 +26,9 @@ import (
 
 // StorageClient starts storage deals with Boost over libp2p
 type StorageClient struct {
-	PeerStore  peerstore.Peerstore
-	dealClient *lp2pimpl.DealClient
+	PeerStore   peerstore.Peerstore
+	dealClient  *lp2pimpl.DealClient
+	fullNodeApi v1api.FullNode
 }
 
 func NewStorageClient(addr address.Address, fullNodeApi v1api.FullNode) (*StorageClient, error) {
@@ -42,14 +50,21 @@ func NewStorageClient(addr address.Address, fullNodeApi v1api.FullNode) (*Storag
 
 	retryOpts := lp2pimpl.RetryParameters(time.Millisecond, time.Millisecond, 1, 1)
 	return &StorageClient{
-		dealClient: lp2pimpl.NewDealClient(h, addr, fullNodeApi, retryOpts),
-		PeerStore:  pstore,
+		dealClient:  lp2pimpl.NewDealClient(h, addr, fullNodeApi, retryOpts),
+		PeerStore:   pstore,
+		fullNodeApi: fullNodeApi,
 	}, nil
 }
 
+// StorageDeal proposes the deal to the provider. If the response to the
+// proposal is lost, the provider is asked whether it has the deal before it
+// is proposed again. If that can't be found out, the error wraps a
+// types.ProposalOutcomeUnknownError: the provider may have the deal, so the
+// caller must check the deal's status with DealStatus before proposing it
+// again.
 func (c *StorageClient) StorageDeal(ctx context.Context, params types.DealParams, providerID peer.ID) (*api.ProviderDealRejectionInfo, error) {
 	// Send the deal proposal to the provider
-	resp, err := c.dealClient.SendDealProposal(ctx, providerID, params)
+	resp, err := dealbatch.ProposeChecked(ctx, dealProposer{c.dealClient}, providerID, params, 2)
 	if err != nil {
 		return nil, fmt.Errorf("sending deal proposal: %w", err)
 	}
@@ -60,7 +75,89 @@ func (c *StorageClient) StorageDeal(ctx context.Context, params types.DealParams
 	}, nil
 }
 
+// StorageDealServeCar serves the CAR file at carPath with the CAR server, so
+// that the provider downloads the deal's data directly from the client, and
+// sends the deal proposal. The caller can wait on the server for the
+// download to complete.
+func (c *StorageClient) StorageDealServeCar(ctx context.Context, params types.DealParams, providerID peer.ID, srv *carserver.Server, carPath string) (*api.ProviderDealRejectionInfo, error) {
+	id := params.DealUUID.String()
+	transferParams, err := srv.Add(id, carPath)
+	if err != nil {
+		return nil, err
+	}
+	paramsBytes, err := json.Marshal(transferParams)
+	if err != nil {
+		srv.Remove(id)
+		return nil, fmt.Errorf("marshalling request parameters: %w", err)
+	}
+	params.Transfer.Type = "http"
+	params.Transfer.Params = paramsBytes
+
+	res, err := c.StorageDeal(ctx, params, providerID)
+	// If the outcome is unknown the provider may have the deal, so keep
+	// serving the CAR file
+	var uerr *types.ProposalOutcomeUnknownError
+	if (err != nil && !errors.As(err, &uerr)) || (err == nil && !res.Accepted) {
+		srv.Remove(id)
+	}
+	return res, err
+}
+
 func (c *StorageClient) DealStatus(ctx context.Context, providerID peer.ID, dealUUid uuid.UUID) (*types.DealStatusResponse, error) {
 	// Send the deal proposal to the provider
 	return c.dealClient.SendDealStatusRequest(ctx, providerID, dealUUid)
 }
+
+// StorageDealBatch proposes a batch of deals to their providers as a unit:
+// the funds for all the deals are reserved with the full node's market fund
+// manager before any deal is proposed, proposals are rate limited per
+// provider and retried if they fail to send. If cfg.Funds is nil the full
+// node's market fund manager is used. See dealbatch.Run.
+func (c *StorageClient) StorageDealBatch(ctx context.Context, deals []dealbatch.Deal, cfg dealbatch.Config) ([]dealbatch.Result, error) {
+	if cfg.Funds == nil {
+		cfg.Funds = &marketFunds{api: c.fullNodeApi}
+	}
+	return dealbatch.Run(ctx, dealProposer{c.dealClient}, deals, cfg)
+}
+
+type dealProposer struct {
+	dealClient *lp2pimpl.DealClient
+}
+
+func (p dealProposer) Propose(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
+	return p.dealClient.SendDealProposal(ctx, id, params)
+}
+
+func (p dealProposer) DealStatus(ctx context.Context, id peer.ID, dealUUID uuid.UUID) (*types.DealStatusResponse, error) {
+	return p.dealClient.SendDealStatusRequest(ctx, id, dealUUID)
+}
+
+// marketFunds reserves funds for a batch of deals with the full node's
+// market fund manager
+type marketFunds struct {
+	api v1api.FullNode
+}
+
+func (f *marketFunds) Reserve(ctx context.Context, client address.Address, amt abi.TokenAmount) error {
+	msgCid, err := f.api.MarketReserveFunds(ctx, client, client, amt)
+	if err != nil {
+		return err
+	}
+	if !msgCid.Defined() {
+		// There were already enough funds in escrow
+		return nil
+	}
+
+	lookup, err := f.api.StateWaitMsg(ctx, msgCid, build.MessageConfidence, lapi.LookbackNoLimit, true)
+	if err != nil {
+		return fmt.Errorf("waiting for market escrow top up %s: %w", msgCid, err)
+	}
+	if !lookup.Receipt.ExitCode.IsSuccess() {
+		return fmt.Errorf("market escrow top up %s failed with exit code %d", msgCid, lookup.Receipt.ExitCode)
+	}
+	return nil
+}
+
+func (f *marketFunds) Release(ctx context.Context, client address.Address, amt abi.TokenAmount) error {
+	return f.api.MarketReleaseFunds(ctx, client, amt)
+}
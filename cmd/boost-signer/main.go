@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/boost/build"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/lib/offlinesign"
+	types2 "github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// boost-signer signs deal proposals exported by the boost client with
+// --export-unsigned. It never opens a network connection, so that it can be
+// run on an air-gapped machine that holds the client's wallet keys.
+func main() {
+	app := &cli.App{
+		Name:    "boost-signer",
+		Usage:   "Sign Boost deal proposals on an air-gapped machine",
+		Version: build.UserVersion(),
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "wallet-dir",
+				Usage:   "the directory of the wallet keystore",
+				Value:   "~/.boost-signer/wallet",
+				EnvVars: []string{"BOOST_SIGNER_WALLET"},
+			},
+		},
+		Commands: []*cli.Command{
+			signCmd,
+			walletNewCmd,
+			walletListCmd,
+		},
+	}
+	app.Setup()
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func openWallet(cctx *cli.Context) (*wallet.LocalWallet, error) {
+	dir, err := homedir.Expand(cctx.String("wallet-dir"))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating wallet dir %s: %w", dir, err)
+	}
+	return clinode.OpenWallet(dir)
+}
+
+var signCmd = &cli.Command{
+	Name:      "sign",
+	Usage:     "Sign the proposal of an unsigned deal",
+	ArgsUsage: "<unsigned deal file>",
+	Description: "Shows the deal and, once confirmed, signs its proposal with the key for the proposal's " +
+		"client address. The signature is written to a separate file, to be carried back to the networked " +
+		"host and sent with 'boost send-signed-deal'.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "the file to write the signature to (defaults to the deal file with a .sig.json extension)",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "sign without asking for confirmation",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: sign <unsigned deal file>")
+		}
+		path := cctx.Args().First()
+
+		b, err := offlinesign.ReadBundle(path)
+		if err != nil {
+			return err
+		}
+		proposal, err := b.Proposal()
+		if err != nil {
+			return err
+		}
+
+		w, err := openWallet(cctx)
+		if err != nil {
+			return err
+		}
+		has, err := w.WalletHas(ctx, proposal.Client)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("the wallet does not have the key for the deal client %s", proposal.Client)
+		}
+
+		duration := proposal.EndEpoch - proposal.StartEpoch
+		fmt.Printf("deal %s\n", b.DealParams.DealUUID)
+		fmt.Printf("  client: %s\n", proposal.Client)
+		fmt.Printf("  storage provider: %s\n", proposal.Provider)
+		fmt.Printf("  payload cid: %s\n", b.DealParams.DealDataRoot)
+		fmt.Printf("  commp: %s\n", proposal.PieceCID)
+		fmt.Printf("  piece size: %d\n", proposal.PieceSize)
+		fmt.Printf("  verified: %t\n", proposal.VerifiedDeal)
+		fmt.Printf("  start epoch: %d\n", proposal.StartEpoch)
+		fmt.Printf("  end epoch: %d (%d epochs)\n", proposal.EndEpoch, duration)
+		fmt.Printf("  storage price per epoch: %s\n", types.FIL(proposal.StoragePricePerEpoch).Short())
+		fmt.Printf("  total storage price: %s\n", types.FIL(big.Mul(proposal.StoragePricePerEpoch, big.NewInt(int64(duration)))).Short())
+		fmt.Printf("  provider collateral: %s\n", types.FIL(proposal.ProviderCollateral).Short())
+		if b.DealParams.IsOffline {
+			fmt.Printf("  offline deal\n")
+		} else {
+			var transferParams types2.HttpRequest
+			if err := json.Unmarshal(b.DealParams.Transfer.Params, &transferParams); err == nil {
+				fmt.Printf("  url: %s\n", transferParams.URL)
+			}
+		}
+
+		if !cctx.Bool("yes") {
+			ok, err := confirm()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("not signed")
+			}
+		}
+
+		sign := func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+			return w.WalletSign(ctx, addr, msg, api.MsgMeta{Type: api.MTDealProposal})
+		}
+		sig, err := offlinesign.Sign(ctx, sign, b)
+		if err != nil {
+			return err
+		}
+
+		out := cctx.String("output")
+		if out == "" {
+			out = strings.TrimSuffix(path, ".json") + ".sig.json"
+		}
+		if err := offlinesign.WriteSignature(out, sig); err != nil {
+			return err
+		}
+		fmt.Printf("wrote signature to %s\n", out)
+		return nil
+	},
+}
+
+func confirm() (bool, error) {
+	rd := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Sign? Yes [y] / No [n]:\n")
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return false, fmt.Errorf("reading input: %w", err)
+		}
+		switch strings.TrimSpace(line) {
+		case "y", "Y", "yes":
+			return true, nil
+		case "n", "N", "no":
+			return false, nil
+		}
+	}
+}
+
+var walletNewCmd = &cli.Command{
+	Name:      "wallet-new",
+	Usage:     "Generate a new key of the given type",
+	ArgsUsage: "[bls|secp256k1 (default secp256k1)]",
+	Action: func(cctx *cli.Context) error {
+		w, err := openWallet(cctx)
+		if err != nil {
+			return err
+		}
+
+		t := cctx.Args().First()
+		if t == "" {
+			t = "secp256k1"
+		}
+		addr, err := w.WalletNew(cctx.Context, types.KeyType(t))
+		if err != nil {
+			return err
+		}
+		fmt.Println(addr)
+		return nil
+	},
+}
+
+var walletListCmd = &cli.Command{
+	Name:  "wallet-list",
+	Usage: "List the addresses of the keys in the wallet",
+	Action: func(cctx *cli.Context) error {
+		w, err := openWallet(cctx)
+		if err != nil {
+			return err
+		}
+		addrs, err := w.WalletList(cctx.Context)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			fmt.Println(addr)
+		}
+		return nil
+	},
+}
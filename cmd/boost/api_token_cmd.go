@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// apiTokensFile is the name of the API tokens file in the client repo
+const apiTokensFile = "api-tokens.json"
+
+func init() {
+	cmd.RegisterJsonOutput("api-token create", apiquota.CreateTokenResponse{})
+	cmd.RegisterJsonOutput("api-token list", []apiquota.Token{})
+}
+
+var quotaFlags = []cli.Flag{
+	&cli.Int64Flag{
+		Name:  "deals-per-day",
+		Usage: "the number of deals the token may propose per day (0 for no limit)",
+	},
+	&cli.StringFlag{
+		Name:  "bytes-per-day",
+		Usage: "the number of bytes the token may propose in deals or retrieve per day, eg 100GiB (empty for no limit)",
+	},
+	&cli.StringFlag{
+		Name:  "spend-per-day",
+		Usage: "the FIL the token may spend on deals per day, eg '0.5 FIL' (empty for no limit)",
+	},
+}
+
+var apiTokenCmd = &cli.Command{
+	Name:  "api-token",
+	Usage: "Manage the API tokens accepted by prep-api and serve-retrievals, and their daily quotas",
+	Description: "Callers present an API token in an 'Authorization: Bearer <token>' header. The deals proposed " +
+		"for jobs created with a token, and the bytes retrieved with it, are charged to the token's quota. " +
+		"Quotas reset each day (UTC). Jobs created with a token can only be seen by callers with that token. " +
+		"The admin token (the --token flag of prep-api) has no quota, and prep-api also serves these " +
+		"commands to callers with the admin token:\n\n" +
+		"   GET    /api-tokens               list tokens with their quotas and usage today\n" +
+		"   POST   /api-tokens               create a token: {\"name\": ..., \"quota\": {...}, \"approver\": false}\n" +
+		"   PUT    /api-tokens/{name}/quota  replace a token's quota\n" +
+		"   DELETE /api-tokens/{name}        remove a token",
+	Before: before,
+	Subcommands: []*cli.Command{
+		apiTokenCreateCmd,
+		apiTokenListCmd,
+		apiTokenSetQuotaCmd,
+		apiTokenRemoveCmd,
+	},
+}
+
+var apiTokenCreateCmd = &cli.Command{
+	Name:      "create",
+	Usage:     "Create an API token",
+	ArgsUsage: "<name>",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "approver",
+			Usage: "allow the token to approve or reject deal proposals held for approval by prep-api",
+		},
+	}, quotaFlags...),
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: api-token create <name>")
+		}
+		store, err := openAPITokenStore(cctx)
+		if err != nil {
+			return err
+		}
+		name := cctx.Args().First()
+		secret, err := store.Create(name, quotaFromFlags(cctx), cctx.Bool("approver"))
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(apiquota.CreateTokenResponse{Name: name, Secret: secret})
+		}
+		fmt.Printf("Created API token %s. The token is shown only once:\n%s\n", name, secret)
+		return nil
+	},
+}
+
+var apiTokenListCmd = &cli.Command{
+	Name:   "list",
+	Usage:  "List API tokens with their quotas and usage today",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		store, err := openAPITokenStore(cctx)
+		if err != nil {
+			return err
+		}
+		tokens, err := store.List()
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			if tokens == nil {
+				tokens = []apiquota.Token{}
+			}
+			return cmd.PrintJson(tokens)
+		}
+		if len(tokens) == 0 {
+			fmt.Println("no api tokens")
+			return nil
+		}
+
+		limit := func(used string, quota string) string {
+			if quota == "" || quota == "0" {
+				return used
+			}
+			return used + " / " + quota
+		}
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "NAME\tDEALS\tBYTES\tSPEND\tAPPROVER\n")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", t.Name,
+				limit(fmt.Sprint(t.Usage.Deals), fmt.Sprint(t.Quota.DealsPerDay)),
+				limit(humanize.IBytes(t.Usage.Bytes), t.Quota.BytesPerDay),
+				limit(types.FIL(t.Usage.Spend).Short(), t.Quota.SpendPerDay),
+				t.Approver)
+		}
+		return w.Flush()
+	},
+}
+
+var apiTokenSetQuotaCmd = &cli.Command{
+	Name:      "set-quota",
+	Usage:     "Replace an API token's quota",
+	ArgsUsage: "<name>",
+	Flags:     quotaFlags,
+	Before:    before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: api-token set-quota <name>")
+		}
+		store, err := openAPITokenStore(cctx)
+		if err != nil {
+			return err
+		}
+		return store.SetQuota(cctx.Args().First(), quotaFromFlags(cctx))
+	},
+}
+
+var apiTokenRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove an API token, so that it is no longer accepted",
+	ArgsUsage: "<name>",
+	Before:    before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: api-token remove <name>")
+		}
+		store, err := openAPITokenStore(cctx)
+		if err != nil {
+			return err
+		}
+		return store.Remove(cctx.Args().First())
+	},
+}
+
+func quotaFromFlags(cctx *cli.Context) apiquota.Quota {
+	return apiquota.Quota{
+		DealsPerDay: cctx.Int64("deals-per-day"),
+		BytesPerDay: cctx.String("bytes-per-day"),
+		SpendPerDay: cctx.String("spend-per-day"),
+	}
+}
+
+// openAPITokenStore opens the API tokens in the client repo
+func openAPITokenStore(cctx *cli.Context) (*apiquota.Store, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return apiquota.NewStore(filepath.Join(sdir, apiTokensFile)), nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/archival"
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/transferoverrides"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	lapi "github.com/filecoin-project/lotus/api"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("archive list", []archival.Batch{})
+	cmd.RegisterJsonOutput("archive tombstones", []archival.Tombstone{})
+}
+
+var archiveCmd = &cli.Command{
+	Name:  "archive",
+	Usage: "Delete the local data of deal batches once their deals are active and verified",
+	Description: "Batches are recorded by deal-batch --archive. The run command checks the deals for each piece " +
+		"in a batch. Once they are all active on chain, it retrieves randomly chosen byte ranges of the piece from " +
+		"a randomly chosen provider and compares them with the local CAR file. If they match, the CAR file is " +
+		"deleted and a tombstone records the deals that store the piece. If a deal fails, or the retrieved data " +
+		"doesn't match, the local data is kept.",
+	Before: before,
+	Subcommands: []*cli.Command{
+		archiveListCmd,
+		archiveRunCmd,
+		archiveTombstonesCmd,
+	},
+}
+
+var archiveListCmd = &cli.Command{
+	Name:   "list",
+	Usage:  "List the batches recorded for archival, and the state of each piece",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		store, err := openArchivalStore(cctx)
+		if err != nil {
+			return err
+		}
+		batches, err := store.Batches()
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(batches)
+		}
+		if len(batches) == 0 {
+			fmt.Println("No batches recorded for archival")
+			return nil
+		}
+		for _, b := range batches {
+			fmt.Printf("Batch %s created %s (%d probe ranges)\n", b.ID, b.CreatedAt.Format(time.RFC3339), b.Policy.ProbeRanges)
+			w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "  PIECE CID\tCAR FILE\tACTIVE DEALS\tSTATE")
+			for _, p := range b.Pieces {
+				var active int
+				for _, d := range p.Deals {
+					if d.Active {
+						active++
+					}
+				}
+				state := p.State
+				if p.Error != "" {
+					state += ": " + p.Error
+				}
+				fmt.Fprintf(w, "  %s\t%s\t%d/%d\t%s\n", p.PieceCid, p.CarPath, active, len(p.Deals), state)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var archiveTombstonesCmd = &cli.Command{
+	Name:   "tombstones",
+	Usage:  "List the pieces whose local data was deleted, and the deals that store them",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		store, err := openArchivalStore(cctx)
+		if err != nil {
+			return err
+		}
+		tombstones, err := store.Tombstones()
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(tombstones)
+		}
+		if len(tombstones) == 0 {
+			fmt.Println("No local data has been deleted")
+			return nil
+		}
+		for _, t := range tombstones {
+			fmt.Printf("%s  %s  %s  deleted %s\n", t.PieceCid, t.CarPath, humanize.IBytes(uint64(t.CarSize)), t.DeletedAt.Format(time.RFC3339))
+			for _, r := range t.Replicas {
+				fmt.Printf("  %s  deal %d (%s)\n", r.Provider, r.DealID, r.DealUUID)
+			}
+		}
+		return nil
+	},
+}
+
+var archiveRunCmd = &cli.Command{
+	Name:  "run",
+	Usage: "Check the batches recorded for archival, and delete the local data that has been verified",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to check the batches",
+			Value: time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "once",
+			Usage: "check the batches once and exit",
+		},
+	},
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		store, err := openArchivalStore(cctx)
+		if err != nil {
+			return err
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		overrides, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		checker := &archivalChecker{n: n, api: api, overrides: overrides}
+		archiver := archival.NewArchiver(store, checker, func(ctx context.Context, p archival.Piece, policy archival.Policy) error {
+			return removeArchivedData(ctx, cctx, p, policy)
+		})
+
+		if cctx.Bool("once") {
+			archived, err := archiver.RunOnce(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Deleted the local data of %d pieces\n", archived)
+			return nil
+		}
+
+		fmt.Println("Checking batches for archival")
+		return archiver.Run(ctx, cctx.Duration("interval"))
+	},
+}
+
+// recordArchivalBatch records the accepted deals of a deal batch, so that
+// the local CAR files are deleted once the deals are active and verified
+func recordArchivalBatch(cctx *cli.Context, wallet address.Address, manifest []batchManifestEntry, items []dealBatchItem) (uuid.UUID, error) {
+	store, err := openArchivalStore(cctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	b := &archival.Batch{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		Wallet:    wallet,
+		Policy: archival.Policy{
+			ProbeRanges:  cctx.Int("archive-probe-ranges"),
+			RemoveImport: cctx.Bool("archive-remove-import"),
+		},
+	}
+	for _, e := range manifest {
+		payloadCid, err := cid.Parse(e.PayloadCid)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		pieceCid, err := cid.Parse(e.CommP)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		carPath, err := filepath.Abs(e.CarPath)
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		p := archival.Piece{PayloadCid: payloadCid, PieceCid: pieceCid, CarPath: carPath}
+		for _, item := range items {
+			if !item.Accepted || item.CommP != e.CommP || item.PayloadCid != e.PayloadCid {
+				continue
+			}
+			dealUuid, err := uuid.Parse(item.DealUUID)
+			if err != nil {
+				return uuid.Nil, err
+			}
+			maddr, err := address.NewFromString(item.Provider)
+			if err != nil {
+				return uuid.Nil, err
+			}
+			p.Deals = append(p.Deals, archival.Deal{DealUUID: dealUuid, Provider: maddr})
+		}
+		b.Pieces = append(b.Pieces, p)
+	}
+
+	if err := store.AddBatch(b); err != nil {
+		return uuid.Nil, err
+	}
+	return b.ID, nil
+}
+
+// openArchivalStore opens the store in the client repo that records the
+// batches recorded for archival and the tombstones of deleted data
+func openArchivalStore(cctx *cli.Context) (*archival.Store, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return archival.NewStore(filepath.Join(sdir, "archival"))
+}
+
+// removeArchivedData deletes the local CAR file of a verified piece and,
+// if the policy says so, the imports that it was written from
+func removeArchivedData(ctx context.Context, cctx *cli.Context, p archival.Piece, policy archival.Policy) error {
+	if policy.RemoveImport {
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		imps, err := s.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, imp := range imps {
+			for _, car := range imp.Cars {
+				if car.Path != p.CarPath && car.PieceCid != p.PieceCid {
+					continue
+				}
+				if err := s.Remove(ctx, imp.ID); err != nil {
+					return fmt.Errorf("removing import %d: %w", imp.ID, err)
+				}
+				log.Infow("removed import of archived piece", "import", imp.ID, "piece", p.PieceCid)
+				break
+			}
+		}
+	}
+
+	if err := os.Remove(p.CarPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// archivalChecker gets the state of deals from the providers and the
+// chain, and retrieves byte ranges of pieces over http
+type archivalChecker struct {
+	n         *clinode.Node
+	api       lapi.Gateway
+	overrides *transferoverrides.Store
+}
+
+func (c *archivalChecker) DealState(ctx context.Context, wallet address.Address, pieceCid cid.Cid, d archival.Deal) (archival.DealState, error) {
+	dealID := d.DealID
+	if dealID == 0 {
+		// The deal id is only known once the provider has published the deal.
+		// It is reported by the provider, so it is checked against the chain
+		// below before the deal is trusted.
+		o, err := providerTransferOverride(c.overrides, d.Provider)
+		if err != nil {
+			return archival.DealState{}, err
+		}
+		id, _, err := connectDealProvider(ctx, c.api, c.n, d.Provider, o)
+		if err != nil {
+			return archival.DealState{}, err
+		}
+		dc := lp2pimpl.NewDealClient(c.n.Host, wallet, clinode.DealProposalSigner{LocalWallet: c.n.Wallet})
+		resp, err := dc.SendDealStatusRequest(ctx, id, d.DealUUID)
+		if err != nil {
+			return archival.DealState{}, fmt.Errorf("send deal status request failed: %w", err)
+		}
+		if resp.Error != "" {
+			return archival.DealState{}, errors.New(resp.Error)
+		}
+		if resp.DealStatus == nil {
+			return archival.DealState{}, nil
+		}
+		if resp.DealStatus.Error != "" {
+			return archival.DealState{Failed: resp.DealStatus.Error}, nil
+		}
+		dealID = resp.DealStatus.ChainDealID
+		if dealID == 0 {
+			return archival.DealState{}, nil
+		}
+	}
+
+	md, err := c.api.StateMarketStorageDeal(ctx, dealID, chain_types.EmptyTSK)
+	if err != nil {
+		return archival.DealState{DealID: dealID}, fmt.Errorf("getting deal %d from chain: %w", dealID, err)
+	}
+	if mismatch, err := c.checkProposal(ctx, md.Proposal, wallet, pieceCid, d.Provider); err != nil {
+		return archival.DealState{DealID: dealID}, err
+	} else if mismatch != "" {
+		return archival.DealState{DealID: dealID, Failed: fmt.Sprintf("on-chain deal %d %s", dealID, mismatch)}, nil
+	}
+	if md.State.SlashEpoch >= 0 {
+		return archival.DealState{DealID: dealID, Failed: fmt.Sprintf("slashed at epoch %d", md.State.SlashEpoch)}, nil
+	}
+	return archival.DealState{DealID: dealID, Active: md.State.SectorStartEpoch > 0}, nil
+}
+
+// checkProposal checks that the on-chain deal proposal is for the piece,
+// and is between the wallet and the provider. It returns a description of
+// the mismatch if it is not.
+func (c *archivalChecker) checkProposal(ctx context.Context, prop market.DealProposal, wallet address.Address, pieceCid cid.Cid, provider address.Address) (string, error) {
+	if !prop.PieceCID.Equals(pieceCid) {
+		return fmt.Sprintf("is for piece %s, not %s", prop.PieceCID, pieceCid), nil
+	}
+
+	// The market actor stores the client and provider as id addresses
+	clientID, err := c.api.StateLookupID(ctx, wallet, chain_types.EmptyTSK)
+	if err != nil {
+		return "", fmt.Errorf("looking up id of wallet %s: %w", wallet, err)
+	}
+	if prop.Client != clientID {
+		return fmt.Sprintf("has client %s, not %s (%s)", prop.Client, wallet, clientID), nil
+	}
+	providerID, err := c.api.StateLookupID(ctx, provider, chain_types.EmptyTSK)
+	if err != nil {
+		return "", fmt.Errorf("looking up id of provider %s: %w", provider, err)
+	}
+	if prop.Provider != providerID {
+		return fmt.Sprintf("has provider %s, not %s", prop.Provider, provider), nil
+	}
+	return "", nil
+}
+
+func (c *archivalChecker) ReadRange(ctx context.Context, provider address.Address, pieceCid cid.Cid, offset int64, length int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	src := retrievalSources(c.n, c.api, []address.Address{provider})[0]
+	return carfetch.ReadRange(ctx, src, url.Values{"pieceCid": {pieceCid.String()}}, offset, length)
+}
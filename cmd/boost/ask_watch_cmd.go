@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/askwatch"
+	"github.com/filecoin-project/go-address"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+)
+
+// askChange is the json output of the watch-asks command
+type askChange struct {
+	Provider           string    `json:"provider"`
+	At                 time.Time `json:"at"`
+	OldPrice           string    `json:"oldPrice"`
+	NewPrice           string    `json:"newPrice"`
+	OldVerifiedPrice   string    `json:"oldVerifiedPrice"`
+	NewVerifiedPrice   string    `json:"newVerifiedPrice"`
+	OldMinPieceSize    uint64    `json:"oldMinPieceSize"`
+	NewMinPieceSize    uint64    `json:"newMinPieceSize"`
+	OldMaxPieceSize    uint64    `json:"oldMaxPieceSize"`
+	NewMaxPieceSize    uint64    `json:"newMaxPieceSize"`
+	PriceChanged       bool      `json:"priceChanged"`
+	PieceBoundsChanged bool      `json:"pieceBoundsChanged"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("provider watch-asks", askChange{})
+}
+
+var watchAsksCmd = &cli.Command{
+	Name:      "watch-asks",
+	Usage:     "Watch the storage asks of a list of providers, and print a line each time a provider changes its price or piece size bounds",
+	ArgsUsage: "<provider> [provider...]",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to query each provider's ask",
+			Value: askwatch.DefaultInterval,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() == 0 {
+			return fmt.Errorf("usage: watch-asks <provider> [provider...]")
+		}
+		var providers []address.Address
+		for _, a := range cctx.Args().Slice() {
+			maddr, err := address.NewFromString(a)
+			if err != nil {
+				return fmt.Errorf("parsing provider address %s: %w", a, err)
+			}
+			providers = append(providers, maddr)
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		w := askwatch.NewWatcher(&askFetcher{node: n, api: api}, askwatch.StaticWatchlist(providers...), cctx.Duration("interval"))
+		changes, unsub := w.Subscribe()
+		defer unsub()
+		go w.Run(ctx)
+
+		afmt := NewAppFmt(cctx.App)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case c := <-changes:
+				if cctx.Bool("json") {
+					if err := cmd.PrintJson(toAskChange(c)); err != nil {
+						return err
+					}
+					continue
+				}
+				afmt.Printf("%s %s: price %s -> %s, verified price %s -> %s, piece size %s-%s -> %s-%s\n",
+					c.At.Format(time.RFC3339), c.Provider,
+					types.FIL(c.Old.Price), types.FIL(c.New.Price),
+					types.FIL(c.Old.VerifiedPrice), types.FIL(c.New.VerifiedPrice),
+					types.SizeStr(types.NewInt(uint64(c.Old.MinPieceSize))), types.SizeStr(types.NewInt(uint64(c.Old.MaxPieceSize))),
+					types.SizeStr(types.NewInt(uint64(c.New.MinPieceSize))), types.SizeStr(types.NewInt(uint64(c.New.MaxPieceSize))))
+			}
+		}
+	},
+}
+
+func toAskChange(c askwatch.Change) askChange {
+	return askChange{
+		Provider:           c.Provider.String(),
+		At:                 c.At,
+		OldPrice:           c.Old.Price.String(),
+		NewPrice:           c.New.Price.String(),
+		OldVerifiedPrice:   c.Old.VerifiedPrice.String(),
+		NewVerifiedPrice:   c.New.VerifiedPrice.String(),
+		OldMinPieceSize:    uint64(c.Old.MinPieceSize),
+		NewMinPieceSize:    uint64(c.New.MinPieceSize),
+		OldMaxPieceSize:    uint64(c.Old.MaxPieceSize),
+		NewMaxPieceSize:    uint64(c.New.MaxPieceSize),
+		PriceChanged:       c.PriceChanged(),
+		PieceBoundsChanged: c.SizeChanged(),
+	}
+}
+
+// askFetcher queries storage asks over libp2p
+type askFetcher struct {
+	node *clinode.Node
+	api  lapi.Gateway
+}
+
+func (f *askFetcher) StorageAsk(ctx context.Context, provider address.Address) (*askwatch.Ask, error) {
+	ask, err := queryStorageAsk(ctx, f.node, f.api, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &askwatch.Ask{
+		Price:         ask.Price,
+		VerifiedPrice: ask.VerifiedPrice,
+		MinPieceSize:  ask.MinPieceSize,
+		MaxPieceSize:  ask.MaxPieceSize,
+	}, nil
+}
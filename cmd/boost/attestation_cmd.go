@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/attestation"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	lapi "github.com/filecoin-project/lotus/api"
+	car "github.com/ipld/go-car"
+	"github.com/urfave/cli/v2"
+)
+
+// verifyAttestationOutput is the output of the verify-attestation command
+// in json mode
+type verifyAttestationOutput struct {
+	Path        string                  `json:"path"`
+	Attestation attestation.Attestation `json:"attestation"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("verify-attestation", verifyAttestationOutput{})
+}
+
+var verifyAttestationCmd = &cli.Command{
+	Name:      "verify-attestation",
+	Usage:     "Verify the signed attestation of a retrieval against the retrieved CAR file",
+	ArgsUsage: "<car path>",
+	Description: "Checks that the attestation written alongside a CAR file by the retrieve command " +
+		"was signed by the client wallet in the attestation, and that the CAR file matches the attested root cid and size " +
+		"and contains the complete, unmodified DAG under the root cid.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "attestation",
+			Usage: "path of the attestation (defaults to <car path>" + attestation.FileExt + ")",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: verify-attestation <car path>")
+		}
+		carPath := cctx.Args().First()
+		path := cctx.String("attestation")
+		if path == "" {
+			path = attestation.Path(carPath)
+		}
+
+		signed, err := attestation.Read(path)
+		if err != nil {
+			return err
+		}
+		if err := signed.Verify(); err != nil {
+			return err
+		}
+		if err := checkAttestedCar(carPath, &signed.Attestation); err != nil {
+			return err
+		}
+		if err := attestation.VerifyCar(cctx.Context, carPath, signed.Attestation.RootCid); err != nil {
+			return err
+		}
+
+		att := signed.Attestation
+		if cctx.Bool("json") {
+			return cmd.PrintJson(verifyAttestationOutput{Path: carPath, Attestation: att})
+		}
+		fmt.Printf("Attestation for %s is valid\n", carPath)
+		fmt.Printf("  signed by: %s\n", att.Client)
+		fmt.Printf("  retrieved from: %s (deal %d)\n", att.Provider, att.DealID)
+		fmt.Printf("  root cid: %s\n", att.RootCid)
+		fmt.Printf("  size: %d bytes\n", att.Size)
+		fmt.Printf("  at: %s\n", att.Timestamp)
+		return nil
+	},
+}
+
+// writeRetrievalAttestation verifies the retrieved CAR file against the root
+// cid, then signs an attestation of the completed retrieval with the client
+// wallet, and writes it alongside the CAR file
+func writeRetrievalAttestation(ctx context.Context, n *clinode.Node, wallet string, carPath string, att attestation.Attestation) (string, error) {
+	if err := attestation.VerifyCar(ctx, carPath, att.RootCid); err != nil {
+		return "", fmt.Errorf("not signing retrieval attestation: %w", err)
+	}
+
+	client, err := n.GetProvidedOrDefaultWallet(ctx, wallet)
+	if err != nil {
+		return "", fmt.Errorf("getting wallet to sign retrieval attestation: %w", err)
+	}
+	sign := func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+		return n.Wallet.WalletSign(ctx, addr, msg, lapi.MsgMeta{Type: lapi.MTUnknown})
+	}
+	signed, err := attestation.Sign(ctx, sign, client, att)
+	if err != nil {
+		return "", err
+	}
+	path := attestation.Path(carPath)
+	if err := attestation.Write(path, signed); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// checkAttestedCar checks that the CAR file has the size and root cid in
+// the attestation
+func checkAttestedCar(carPath string, att *attestation.Attestation) error {
+	f, err := os.Open(carPath)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", carPath, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("getting size of %s: %w", carPath, err)
+	}
+	if st.Size() != att.Size {
+		return fmt.Errorf("size of %s is %d bytes but the attestation is for %d bytes", carPath, st.Size(), att.Size)
+	}
+
+	hdr, err := car.ReadHeader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("reading CAR header of %s: %w", carPath, err)
+	}
+	for _, r := range hdr.Roots {
+		if r.Equals(att.RootCid) {
+			return nil
+		}
+	}
+	return fmt.Errorf("attested root cid %s is not a root of %s (roots: %s)", att.RootCid, carPath, hdr.Roots)
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/archival"
+	"github.com/filecoin-project/boost/lib/dealbatch"
+	"github.com/filecoin-project/boost/lib/providerattrs"
+	"github.com/filecoin-project/boost/lib/providerimpl"
+	"github.com/filecoin-project/boost/lib/transferoverrides"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	types2 "github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/urfave/cli/v2"
+)
+
+// batchManifestEntry is a CAR file in a deal-batch manifest
+type batchManifestEntry struct {
+	PayloadCid string `json:"payloadCid"`
+	CommP      string `json:"commp"`
+	PieceSize  uint64 `json:"pieceSize"`
+	CarSize    uint64 `json:"carSize"`
+	// The url that providers download the CAR file from (online deals only)
+	HttpUrl     string            `json:"httpUrl,omitempty"`
+	HttpHeaders map[string]string `json:"httpHeaders,omitempty"`
+	// The path of the local CAR file, which is deleted once the deals are
+	// active and verified (with --archive only)
+	CarPath string `json:"carPath,omitempty"`
+}
+
+// dealBatchOutput is the output of the deal-batch command in json mode
+type dealBatchOutput struct {
+	Deals []dealBatchItem `json:"deals"`
+	// The providers that were not used because they don't satisfy the
+	// attribute constraint, or because max-providers was reached
+	Excluded []dealBatchExclusion `json:"excluded,omitempty"`
+	// The id of the batch recorded for archival (with --archive only)
+	ArchiveBatch string `json:"archiveBatch,omitempty"`
+}
+
+type dealBatchExclusion struct {
+	Provider   string   `json:"provider"`
+	Attributes []string `json:"attributes,omitempty"`
+	Reason     string   `json:"reason"`
+}
+
+type dealBatchItem struct {
+	DealUUID   string `json:"dealUuid"`
+	Provider   string `json:"provider"`
+	PayloadCid string `json:"payloadCid"`
+	CommP      string `json:"commp"`
+	Accepted   bool   `json:"accepted"`
+	// The provider's reason for rejecting the deal, or the error sending
+	// the proposal
+	Reason   string `json:"reason,omitempty"`
+	Attempts int    `json:"attempts"`
+	// The provider's attested attributes (eg renewable-energy)
+	Attributes []string `json:"attributes,omitempty"`
+	// The preferred attributes that the provider doesn't have
+	MissingPreferred []string `json:"missingPreferred,omitempty"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("deal-batch", dealBatchOutput{})
+}
+
+// dealBatchFlags are the deal flags that apply to every deal in a batch
+// (the per CAR file flags are in the manifest instead)
+func dealBatchFlags() []cli.Flag {
+	perDeal := map[string]struct{}{
+		"provider": {}, "commp": {}, "piece-size": {}, "car-size": {}, "payload-cid": {}, "export-unsigned": {},
+	}
+	var flags []cli.Flag
+	for _, f := range dealFlags {
+		if _, ok := perDeal[f.Names()[0]]; !ok {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+var dealBatchCmd = &cli.Command{
+	Name:  "deal-batch",
+	Usage: "Propose deals for many CAR files to a set of storage providers in one batch",
+	Description: "The manifest is a json array of CAR files, each with a payloadCid, commp, pieceSize, carSize and " +
+		"(for online deals) an httpUrl and optional httpHeaders. A deal for each CAR file is proposed to each provider.\n" +
+		"The market escrow for all the deals is checked (and with --add-funds, topped up in a single message) before " +
+		"any deal is proposed. Proposals to each provider are spaced out by --provider-interval, and proposals that " +
+		"fail to send are retried.\n" +
+		"Providers' attested attributes (eg renewable-energy) are taken from provider-attribute and each " +
+		"attestation-feed. Providers without all of the --require-attribute attributes are not used, and " +
+		"providers with more of the --prefer-attribute attributes are used first (with --max-providers, only " +
+		"the most preferred providers are used). The attributes of each deal's provider are included in the report.\n" +
+		"With --archive, each manifest entry must have a carPath. The batch is recorded, and the archive run " +
+		"command deletes each local CAR file once all of its deals are active and randomly chosen byte ranges " +
+		"retrieved from a provider match the local data, leaving a tombstone that records where the data is stored.",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "manifest",
+			Usage:    "path to the json manifest of CAR files",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:     "provider",
+			Usage:    "storage provider on-chain address (may be repeated)",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "make offline deals",
+		},
+		&cli.DurationFlag{
+			Name:  "provider-interval",
+			Usage: "the minimum time between proposals to the same storage provider",
+			Value: time.Second,
+		},
+		&cli.IntFlag{
+			Name:        "max-attempts",
+			Usage:       "the maximum number of times to try to send a proposal",
+			DefaultText: "3, or 5 for Curio providers",
+		},
+		&cli.DurationFlag{
+			Name:        "retry-interval",
+			Usage:       "the time to wait before retrying a proposal, multiplied by the number of attempts so far",
+			DefaultText: "10s, or 30s for Curio providers",
+		},
+		&cli.StringSliceFlag{
+			Name:  "require-attribute",
+			Usage: "only use providers with this attested attribute, eg renewable-energy (may be repeated)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "prefer-attribute",
+			Usage: "use providers with this attested attribute first (may be repeated)",
+		},
+		&cli.IntFlag{
+			Name:  "max-providers",
+			Usage: "the maximum number of providers to use, choosing those with the most preferred attributes (0 means no maximum)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "provider-attribute",
+			Usage: "the attributes of a provider, in the format <provider>=<attribute>[,<attribute>] eg f01000=renewable-energy (may be repeated)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "attestation-feed",
+			Usage: "the url of a feed of attested provider attributes: a JSON object of provider address to an array of attributes (may be repeated)",
+		},
+		&cli.DurationFlag{
+			Name:  "attestation-feed-ttl",
+			Usage: "how long to cache attestation feeds for",
+			Value: time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "archive",
+			Usage: "delete the local CAR files once their deals are active and verified (see archive run)",
+		},
+		&cli.IntFlag{
+			Name:  "archive-probe-ranges",
+			Usage: "the number of random byte ranges of each piece to retrieve and compare with the local CAR file",
+			Value: archival.DefaultProbeRanges,
+		},
+		&cli.BoolFlag{
+			Name:  "archive-remove-import",
+			Usage: "also remove the import that each CAR file was written from",
+		},
+	}, dealBatchFlags()...),
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		var manifest []batchManifestEntry
+		b, err := os.ReadFile(cctx.String("manifest"))
+		if err != nil {
+			return fmt.Errorf("reading manifest: %w", err)
+		}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return fmt.Errorf("parsing manifest: %w", err)
+		}
+		if len(manifest) == 0 {
+			return fmt.Errorf("manifest %s is empty", cctx.String("manifest"))
+		}
+		isOnline := !cctx.Bool("offline")
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name), clinode.EphemeralIdentity(cctx.Bool("ephemeral-identity")))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		var candidates []address.Address
+		for _, p := range cctx.StringSlice("provider") {
+			maddr, err := address.NewFromString(p)
+			if err != nil {
+				return err
+			}
+			candidates = append(candidates, maddr)
+		}
+		providerAddrs, attrs, excluded, err := selectBatchProviders(cctx, candidates)
+		if err != nil {
+			return err
+		}
+
+		// Connect to all the providers before proposing any deal, and adapt
+		// the proposals to each provider's implementation and transfer
+		// overrides
+		store, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		providers := make(map[address.Address]peer.ID)
+		heuristics := make(map[address.Address]providerimpl.Heuristics)
+		overrides := make(map[address.Address]*transferoverrides.Override)
+		for _, maddr := range providerAddrs {
+			overrides[maddr], err = providerTransferOverride(store, maddr)
+			if err != nil {
+				return err
+			}
+			id, software, err := connectDealProvider(ctx, api, n, maddr, overrides[maddr])
+			if err != nil {
+				return err
+			}
+			providers[maddr] = id
+			heuristics[maddr] = providerimpl.HeuristicsFor(software)
+			log.Debugw("storage provider software", "provider", maddr, "software", software.Software, "version", software.Version)
+		}
+
+		startEpochs := make(map[address.Address]abi.ChainEpoch)
+		if cctx.IsSet("start-epoch") {
+			for _, maddr := range providerAddrs {
+				startEpochs[maddr] = abi.ChainEpoch(cctx.Int("start-epoch"))
+			}
+		} else {
+			tipset, err := api.ChainHead(ctx)
+			if err != nil {
+				return fmt.Errorf("getting chain head: %w", err)
+			}
+			for _, maddr := range providerAddrs {
+				startEpochs[maddr] = heuristics[maddr].StartEpoch(tipset.Height())
+			}
+		}
+
+		collateral := make(map[abi.PaddedPieceSize]abi.TokenAmount)
+		var deals []dealbatch.Deal
+		var items []dealBatchItem
+		for i, e := range manifest {
+			pieceCid, err := cid.Parse(e.CommP)
+			if err != nil {
+				return fmt.Errorf("manifest entry %d: parsing commp '%s': %w", i, e.CommP, err)
+			}
+			rootCid, err := cid.Parse(e.PayloadCid)
+			if err != nil {
+				return fmt.Errorf("manifest entry %d: parsing payload cid %s: %w", i, e.PayloadCid, err)
+			}
+			if e.PieceSize == 0 || e.CarSize == 0 {
+				return fmt.Errorf("manifest entry %d: pieceSize and carSize must be set", i)
+			}
+			if cctx.Bool("archive") && e.CarPath == "" {
+				return fmt.Errorf("manifest entry %d: carPath must be set with --archive", i)
+			}
+			pieceSize := abi.PaddedPieceSize(e.PieceSize)
+
+			transfer := types.Transfer{Size: e.CarSize}
+			if isOnline {
+				if e.HttpUrl == "" {
+					return fmt.Errorf("manifest entry %d: httpUrl must be set for online deals", i)
+				}
+				paramsBytes, err := json.Marshal(&types2.HttpRequest{URL: e.HttpUrl, Headers: e.HttpHeaders})
+				if err != nil {
+					return fmt.Errorf("marshalling request parameters: %w", err)
+				}
+				transfer.Type = "http"
+				transfer.Params = paramsBytes
+			}
+
+			providerCollateral, ok := collateral[pieceSize]
+			if !ok {
+				if cctx.IsSet("provider-collateral") {
+					providerCollateral = abi.NewTokenAmount(cctx.Int64("provider-collateral"))
+				} else {
+					bounds, err := api.StateDealProviderCollateralBounds(ctx, pieceSize, cctx.Bool("verified"), chain_types.EmptyTSK)
+					if err != nil {
+						return fmt.Errorf("node error getting collateral bounds: %w", err)
+					}
+					providerCollateral = big.Div(big.Mul(bounds.Min, big.NewInt(6)), big.NewInt(5)) // add 20%
+				}
+				collateral[pieceSize] = providerCollateral
+			}
+
+			label, err := dealLabel(cctx.String("label-mode"), rootCid, cctx.String("label-salt"))
+			if err != nil {
+				return fmt.Errorf("creating deal label: %w", err)
+			}
+
+			for _, maddr := range providerAddrs {
+				dealProposal, err := dealProposal(ctx, n, walletAddr, rootCid, pieceSize, pieceCid, maddr, startEpochs[maddr], cctx.Int("duration"),
+					cctx.Bool("verified"), providerCollateral, abi.NewTokenAmount(cctx.Int64("storage-price")), label.Label)
+				if err != nil {
+					return fmt.Errorf("failed to create a deal proposal: %w", err)
+				}
+				dealUuid := uuid.New()
+				transferStartTimeout, transferTimeout := overriddenTransferTimeouts(cctx, overrides[maddr])
+				deals = append(deals, dealbatch.Deal{
+					Peer: providers[maddr],
+					Params: types.DealParams{
+						DealUUID:             dealUuid,
+						ClientDealProposal:   *dealProposal,
+						DealDataRoot:         rootCid,
+						IsOffline:            !isOnline,
+						Transfer:             transfer,
+						TransferStartTimeout: transferStartTimeout,
+						TransferTimeout:      transferTimeout,
+					},
+				})
+				items = append(items, dealBatchItem{
+					DealUUID:         dealUuid.String(),
+					Provider:         maddr.String(),
+					PayloadCid:       rootCid.String(),
+					CommP:            pieceCid.String(),
+					Attributes:       attrs[maddr],
+					MissingPreferred: providerattrs.Missing(cctx.StringSlice("prefer-attribute"), attrs[maddr]),
+				})
+			}
+		}
+
+		// The retries and timeout that the user hasn't set depend on each
+		// provider's implementation
+		proposer := &streamProposer{
+			n:        n,
+			timeouts: make(map[peer.ID]time.Duration),
+			status:   lp2pimpl.NewDealClient(n.Host, walletAddr, clinode.DealProposalSigner{LocalWallet: n.Wallet}),
+		}
+		providerRetry := make(map[address.Address]dealbatch.Retry)
+		providerIntervals := make(map[address.Address]time.Duration)
+		for maddr, h := range heuristics {
+			if o := overrides[maddr]; o.ProposalInterval > 0 && !cctx.IsSet("provider-interval") {
+				providerIntervals[maddr] = time.Duration(o.ProposalInterval)
+			}
+			proposer.timeouts[providers[maddr]] = h.ProposalTimeout
+			if cctx.IsSet("negotiation-timeout") {
+				proposer.timeouts[providers[maddr]] = cctx.Duration("negotiation-timeout")
+			}
+			retry := dealbatch.Retry{MaxAttempts: h.ProposalAttempts, RetryInterval: h.ProposalRetryInterval}
+			if cctx.IsSet("max-attempts") {
+				retry.MaxAttempts = cctx.Int("max-attempts")
+			}
+			if cctx.IsSet("retry-interval") {
+				retry.RetryInterval = cctx.Duration("retry-interval")
+			}
+			providerRetry[maddr] = retry
+		}
+		results, err := dealbatch.Run(ctx, proposer, deals, dealbatch.Config{
+			ProviderInterval:  cctx.Duration("provider-interval"),
+			ProviderIntervals: providerIntervals,
+			ProviderRetry:     providerRetry,
+			Funds:             &escrowFunds{api: api, n: n, addFunds: cctx.Bool("add-funds")},
+		})
+		if err != nil {
+			return err
+		}
+
+		var accepted int
+		for i, res := range results {
+			items[i].Accepted = res.Accepted
+			items[i].Attempts = res.Attempts
+			items[i].Reason = res.Message
+			if res.Err != nil {
+				items[i].Reason = res.Err.Error()
+			}
+			if res.OutcomeUnknown {
+				items[i].Reason = "outcome unknown, check with deal-status before proposing again: " + items[i].Reason
+			}
+			if res.Accepted {
+				accepted++
+				recordDealIdentity(cctx, n, res.DealUUID)
+			}
+		}
+
+		var archiveID string
+		if cctx.Bool("archive") {
+			batchID, err := recordArchivalBatch(cctx, walletAddr, manifest, items)
+			if err != nil {
+				return fmt.Errorf("recording batch for archival: %w", err)
+			}
+			archiveID = batchID.String()
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(dealBatchOutput{Deals: items, Excluded: excluded, ArchiveBatch: archiveID})
+		}
+
+		for _, e := range excluded {
+			fmt.Printf("provider %s not used: %s\n", e.Provider, e.Reason)
+		}
+		for _, item := range items {
+			status := "accepted"
+			if !item.Accepted {
+				status = "rejected: " + item.Reason
+			}
+			attributes := "-"
+			if len(item.Attributes) > 0 {
+				attributes = strings.Join(item.Attributes, ",")
+			}
+			fmt.Printf("%s  %s  %s  %s  %s\n", item.DealUUID, item.Provider, item.PayloadCid, attributes, status)
+		}
+		fmt.Printf("%d of %d deal proposals accepted\n", accepted, len(items))
+		if archiveID != "" {
+			fmt.Printf("recorded batch %s for archival\n", archiveID)
+		}
+		return nil
+	},
+}
+
+// selectBatchProviders looks up the attested attributes of the candidate
+// providers, and returns the providers that satisfy the attribute
+// constraint, most preferred first, along with the providers that are not
+// used and why
+func selectBatchProviders(cctx *cli.Context, candidates []address.Address) ([]address.Address, map[address.Address][]string, []dealBatchExclusion, error) {
+	static, err := providerattrs.ParseStatic(cctx.StringSlice("provider-attribute"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sources := []providerattrs.Source{static}
+	for _, url := range cctx.StringSlice("attestation-feed") {
+		sources = append(sources, providerattrs.NewFeed(url, cctx.Duration("attestation-feed-ttl")))
+	}
+	attrs, err := providerattrs.Merge(sources...).Attributes(cctx.Context, candidates)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("looking up provider attributes: %w", err)
+	}
+
+	constraint := providerattrs.Constraint{
+		Require: cctx.StringSlice("require-attribute"),
+		Prefer:  cctx.StringSlice("prefer-attribute"),
+	}
+	selected, reasons := constraint.Select(candidates, attrs)
+	var excluded []dealBatchExclusion
+	for _, maddr := range candidates {
+		if reason, ok := reasons[maddr]; ok {
+			excluded = append(excluded, dealBatchExclusion{Provider: maddr.String(), Attributes: attrs[maddr], Reason: reason})
+		}
+	}
+	if maxProviders := cctx.Int("max-providers"); maxProviders > 0 && len(selected) > maxProviders {
+		for _, maddr := range selected[maxProviders:] {
+			excluded = append(excluded, dealBatchExclusion{
+				Provider:   maddr.String(),
+				Attributes: attrs[maddr],
+				Reason:     fmt.Sprintf("max providers (%d) reached with providers that are preferred", maxProviders),
+			})
+		}
+		selected = selected[:maxProviders]
+	}
+	if len(selected) == 0 {
+		return nil, nil, nil, fmt.Errorf("none of the %d providers have all of the required attributes %s",
+			len(candidates), strings.Join(constraint.Require, ", "))
+	}
+	return selected, attrs, excluded, nil
+}
+
+// connectDealProvider connects to the storage provider, at the multiaddrs
+// in its transfer override if it has any, checks that it supports the deal
+// protocol and identifies the software it runs
+func connectDealProvider(ctx context.Context, api lapi.Gateway, n *clinode.Node, maddr address.Address, o *transferoverrides.Override) (peer.ID, providerimpl.Info, error) {
+	addrInfo, err := overriddenAddrInfo(ctx, api, maddr, o)
+	if err != nil {
+		return "", providerimpl.Info{}, err
+	}
+	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+		return "", providerimpl.Info{}, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+	software := providerSoftware(n, addrInfo.ID)
+	if _, err := supportedDealProtocol(n, addrInfo.ID, []protocol.ID{DealProtocolv120}); err != nil {
+		return "", software, dealProviderError(maddr, software, err)
+	}
+	return addrInfo.ID, software, nil
+}
+
+// streamProposer sends deal proposals over a new libp2p stream per proposal
+type streamProposer struct {
+	n *clinode.Node
+	// The time to wait for each provider to reply to a proposal
+	timeouts map[peer.ID]time.Duration
+	status   *lp2pimpl.DealClient
+}
+
+func (p *streamProposer) Propose(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
+	if timeout := p.timeouts[id]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	s, err := p.n.Host.NewStream(ctx, id, DealProtocolv120)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream to peer %s: %w", id, err)
+	}
+	defer s.Close()
+
+	// Once the stream is open the proposal may reach the provider, even if
+	// sending it fails
+	var resp types.DealResponse
+	if err := doRpc(ctx, s, &params, &resp); err != nil {
+		return nil, &types.ProposalOutcomeUnknownError{DealUUID: params.DealUUID, Err: fmt.Errorf("send proposal rpc: %w", err)}
+	}
+	return &resp, nil
+}
+
+func (p *streamProposer) DealStatus(ctx context.Context, id peer.ID, dealUUID uuid.UUID) (*types.DealStatusResponse, error) {
+	return p.status.SendDealStatusRequest(ctx, id, dealUUID)
+}
+
+// dealClientProposer sends deal proposals with the deal client
+type dealClientProposer struct {
+	dc *lp2pimpl.DealClient
+}
+
+func (p *dealClientProposer) Propose(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
+	return p.dc.SendDealProposal(ctx, id, params)
+}
+
+func (p *dealClientProposer) DealStatus(ctx context.Context, id peer.ID, dealUUID uuid.UUID) (*types.DealStatusResponse, error) {
+	return p.dc.SendDealStatusRequest(ctx, id, dealUUID)
+}
+
+// escrowFunds checks that the client's available market escrow covers the
+// batch, topping it up in one message if add-funds is set.
+// The client doesn't hold a reservation, so there is nothing to release.
+type escrowFunds struct {
+	api      lapi.Gateway
+	n        *clinode.Node
+	addFunds bool
+}
+
+func (f *escrowFunds) Reserve(ctx context.Context, client address.Address, amt abi.TokenAmount) error {
+	available, err := availableEscrow(ctx, f.api, client)
+	if err != nil {
+		return err
+	}
+	if available.GreaterThanEqual(amt) {
+		return nil
+	}
+
+	shortfall := big.Sub(amt, available)
+	if !f.addFunds {
+		return fmt.Errorf("available market escrow %s doesn't cover the batch (short by %s): set --add-funds to top it up",
+			chain_types.FIL(available).Short(), chain_types.FIL(shortfall).Short())
+	}
+
+	msgCid, err := addEscrow(ctx, f.api, f.n, client, shortfall)
+	if err != nil {
+		return fmt.Errorf("adding %s to market escrow: %w", chain_types.FIL(shortfall).Short(), err)
+	}
+	log.Infow("waiting for market escrow top up to land on chain", "cid", msgCid, "amount", chain_types.FIL(shortfall).Short())
+	lookup, err := f.api.StateWaitMsg(ctx, msgCid, build.MessageConfidence, lapi.LookbackNoLimit, true)
+	if err != nil {
+		return fmt.Errorf("waiting for market escrow top up %s: %w", msgCid, err)
+	}
+	if !lookup.Receipt.ExitCode.IsSuccess() {
+		return fmt.Errorf("market escrow top up %s failed with exit code %d", msgCid, lookup.Receipt.ExitCode)
+	}
+	return nil
+}
+
+func (f *escrowFunds) Release(context.Context, address.Address, abi.TokenAmount) error {
+	return nil
+}
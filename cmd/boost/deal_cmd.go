@@ -4,12 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"strings"
 
 	bcli "github.com/filecoin-project/boost/cli"
 	clinode "github.com/filecoin-project/boost/cli/node"
 	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/lib/providerimpl"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
 	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/transport/s3transport"
+	"github.com/filecoin-project/boost/transport/tcptransport"
 	types2 "github.com/filecoin-project/boost/transport/types"
 	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
@@ -22,6 +28,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	inet "github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/urfave/cli/v2"
 )
 
@@ -56,7 +64,7 @@ var dealFlags = []cli.Flag{
 	&cli.IntFlag{
 		Name:  "start-epoch",
 		Usage: "start epoch by when the deal should be proved by provider on-chain",
-		DefaultText: "current chain head + 2 days",
+		DefaultText: "current chain head + 2 days, or + 3 days for Curio providers",
 	},
 	&cli.IntFlag{
 		Name:  "duration",
@@ -81,6 +89,78 @@ var dealFlags = []cli.Flag{
 		Name:  "wallet",
 		Usage: "wallet address to be used to initiate the deal",
 	},
+	&cli.BoolFlag{
+		Name: "skip-rule-check",
+		Usage: "send the deal proposal without first checking it against the filter rules that the provider publishes " +
+			"(providers that don't publish rules are always sent the proposal)",
+	},
+	&cli.DurationFlag{
+		Name:        "negotiation-timeout",
+		Usage:       "how long to wait for the storage provider to respond to the deal proposal",
+		DefaultText: "1m, or 3m for Curio providers",
+	},
+	&cli.DurationFlag{
+		Name:        "transfer-start-timeout",
+		Usage:       "the maximum time the storage provider may wait before starting the data transfer",
+		DefaultText: "storage provider default",
+	},
+	&cli.DurationFlag{
+		Name:        "transfer-timeout",
+		Usage:       "the maximum time the storage provider may take to complete the data transfer once it has started; may be up to the storage provider's maximum transfer timeout",
+		DefaultText: "storage provider default",
+	},
+	&cli.DurationFlag{
+		Name:        "completion-timeout",
+		Usage:       "the maximum time from when the storage provider accepts the deal until it publishes the deal",
+		DefaultText: "no limit",
+	},
+	&cli.StringFlag{
+		Name:  "label-mode",
+		Usage: fmt.Sprintf("what to put in the on-chain deal label: %s", strings.Join(labelModes, ", ")),
+		Value: LabelModePayloadCid,
+	},
+	&cli.StringFlag{
+		Name:  "label-salt",
+		Usage: "hex-encoded salt for the salted-hash label mode; if empty a random salt is generated",
+	},
+	&cli.BoolFlag{
+		Name: "add-funds",
+		Usage: "if the wallet's available market escrow doesn't cover the deal, add the shortfall and wait for it to land " +
+			"before proposing the deal (small deals with providers listed in " + trustedProvidersFile + " in the repo " +
+			"always top up escrow in batches, without waiting)",
+	},
+	&cli.BoolFlag{
+		Name:  "ephemeral-identity",
+		Usage: "propose the deal with a newly generated libp2p identity, so that it can't be linked to the client's other deals",
+	},
+	&cli.StringFlag{
+		Name: "export-unsigned",
+		Usage: "write the deal with an unsigned proposal to this file instead of sending it, so that the proposal " +
+			"can be signed on an air-gapped machine with boost-signer and sent with send-signed-deal " +
+			"(the --wallet key does not need to be in the client repo)",
+	},
+}
+
+// dealOutput is the output of the deal and offline-deal commands in json mode
+type dealOutput struct {
+	DealUUID           string `json:"dealUuid"`
+	Provider           string `json:"provider"`
+	ClientWallet       string `json:"clientWallet"`
+	PayloadCid         string `json:"payloadCid"`
+	CommP              string `json:"commp"`
+	StartEpoch         string `json:"startEpoch"`
+	EndEpoch           string `json:"endEpoch"`
+	ProviderCollateral string `json:"providerCollateral"`
+	// The software the provider runs, eg "curio 1.23.0"
+	ProviderSoftware string `json:"providerSoftware"`
+	// Only set for online deals
+	URL       string `json:"url,omitempty"`
+	LabelSalt string `json:"labelSalt,omitempty"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("deal", dealOutput{})
+	cmd.RegisterJsonOutput("offline-deal", dealOutput{})
 }
 
 var dealCmd = &cli.Command{
@@ -88,15 +168,51 @@ var dealCmd = &cli.Command{
 	Usage: "Make an online deal with Boost",
 	Flags: append([]cli.Flag{
 		&cli.StringFlag{
-			Name:     "http-url",
-			Usage:    "http url to CAR file",
-			Required: true,
+			Name:  "http-url",
+			Usage: "http url to CAR file (required unless the CAR file is served with --serve-car)",
 		},
 		&cli.StringSliceFlag{
 			Name:  "http-headers",
 			Usage: "http headers to be passed with the request (e.g key=value)",
 		},
-	}, dealFlags...),
+		&cli.StringSliceFlag{
+			Name: "http-mirror",
+			Usage: "http url of a mirror of the CAR file (eg in another region), optionally prefixed with the " +
+				"mirror's region (e.g. us-west-2=https://...). The provider pulls from the closest healthy origin; " +
+				"when origins are equally close, the http-url is preferred, then mirrors in the order given.",
+		},
+		&cli.StringFlag{
+			Name: "s3-url",
+			Usage: "s3 url of the CAR file (e.g. s3://bucket/path/to/file.car), that the provider pulls from an " +
+				"S3-compatible store with its own credentials (requires a provider that has s3 transfers enabled for the bucket)",
+		},
+		&cli.StringFlag{
+			Name:  "s3-sha256",
+			Usage: "the hex-encoded sha256 hash of the CAR file at the s3-url, that the provider checks the downloaded data against",
+		},
+		&cli.StringSliceFlag{
+			Name: "alternate-url",
+			Usage: "http url of another copy of the CAR file, that the provider transfers from if the transfer from the " +
+				"http-url fails (can be repeated; the urls are tried in the order given). Requires a provider that " +
+				"supports deal protocol v1.3.",
+		},
+		&cli.Uint64Flag{
+			Name:        "transfer-retry-attempts",
+			Usage:       "the maximum number of attempts the provider makes to transfer the data (requires deal protocol v1.3)",
+			DefaultText: "storage provider default",
+		},
+		&cli.DurationFlag{
+			Name: "transfer-retry-min-backoff",
+			Usage: "how long the provider waits before retrying a failed transfer; the wait grows with each retry up to " +
+				"the max backoff (requires deal protocol v1.3)",
+			DefaultText: "storage provider default",
+		},
+		&cli.DurationFlag{
+			Name:        "transfer-retry-max-backoff",
+			Usage:       "the longest the provider waits between retries of a failed transfer (requires deal protocol v1.3)",
+			DefaultText: "storage provider default",
+		},
+	}, append(serveCarFlags, dealFlags...)...),
 	Before: before,
 	Action: func(cctx *cli.Context) error {
 		return dealCmdAction(cctx, true)
@@ -116,7 +232,12 @@ var offlineDealCmd = &cli.Command{
 func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	ctx := bcli.ReqContext(cctx)
 
-	n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+	// Every log line for the deal includes the deal uuid
+	dealUuid := uuid.New()
+	ctx = logctx.WithDeal(ctx, dealUuid)
+	dlog := logctx.Logger(ctx, log)
+
+	n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name), clinode.EphemeralIdentity(cctx.Bool("ephemeral-identity")))
 	if err != nil {
 		return err
 	}
@@ -127,39 +248,81 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	}
 	defer closer()
 
-	walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+	exportPath := cctx.String("export-unsigned")
+	var walletAddr address.Address
+	if exportPath != "" {
+		// The proposal is signed elsewhere, so the key isn't in the local wallet
+		if !cctx.IsSet("wallet") {
+			return fmt.Errorf("the --wallet address of the signing key must be set with --export-unsigned")
+		}
+		if cctx.Bool("add-funds") {
+			return fmt.Errorf("--add-funds can't be used with --export-unsigned: funds must be added with the signing key")
+		}
+		walletAddr, err = address.NewFromString(cctx.String("wallet"))
+	} else {
+		walletAddr, err = n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+	}
 	if err != nil {
 		return err
 	}
 
-	log.Debugw("selected wallet", "wallet", walletAddr)
+	dlog.Debugw("selected wallet", "wallet", walletAddr)
 
 	maddr, err := address.NewFromString(cctx.String("provider"))
 	if err != nil {
 		return err
 	}
 
-	addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+	overrides, err := openTransferOverrides(cctx)
+	if err != nil {
+		return err
+	}
+	override, err := providerTransferOverride(overrides, maddr)
+	if err != nil {
+		return err
+	}
+
+	addrInfo, err := overriddenAddrInfo(ctx, api, maddr, override)
 	if err != nil {
 		return err
 	}
 
-	log.Debugw("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
+	dlog.Debugw("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
 
 	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
 		return fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
 	}
 
-	x, err := n.Host.Peerstore().FirstSupportedProtocol(addrInfo.ID, DealProtocolv120)
-	if err != nil {
-		return fmt.Errorf("getting protocols for peer %s: %w", addrInfo.ID, err)
-	}
+	// Adapt the proposal to the provider's implementation
+	software := providerSoftware(n, addrInfo.ID)
+	heuristics := providerimpl.HeuristicsFor(software)
+	dlog.Debugw("storage provider software", "software", software.Software, "version", software.Version)
 
-	if len(x) == 0 {
-		return fmt.Errorf("boost client cannot make a deal with storage provider %s because it does not support protocol version 1.2.0", maddr)
+	// A CAR file served by the client is served over the provider's
+	// preferred transport, unless the transport is set on the command line
+	serveTransport := cctx.String("serve-car-transport")
+	if !cctx.IsSet("serve-car-transport") && cctx.IsSet("serve-car") && override.Transport != "" {
+		serveTransport = override.Transport
 	}
 
-	dealUuid := uuid.New()
+	// Transfer options require a provider that supports deal protocol v1.3
+	var transferOpts types.DealParams
+	if isOnline {
+		transferOpts.TransferRetry, transferOpts.AlternateURLs = dealTransferOptions(cctx)
+		// The provider's retry policy override is left out if the provider
+		// doesn't support it
+		if transferOpts.TransferRetry == nil && serveTransport == "http" && !cctx.IsSet("s3-url") && software.SupportsTransferOptions() {
+			transferOpts.TransferRetry = override.TransferRetry()
+		}
+	}
+	if transferOpts.HasTransferOptions() && !software.SupportsTransferOptions() {
+		return fmt.Errorf("storage provider %s runs %s, which doesn't support transfer options "+
+			"(--alternate-url and --transfer-retry-*)", maddr, software)
+	}
+	dealProto, err := supportedDealProtocol(n, addrInfo.ID, lp2pimpl.DealProtocols(transferOpts))
+	if err != nil {
+		return dealProviderError(maddr, software, err)
+	}
 
 	commp := cctx.String("commp")
 	pieceCid, err := cid.Parse(commp)
@@ -186,13 +349,98 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	transfer := types.Transfer{
 		Size: carFileSize,
 	}
-	if isOnline {
+	if isOnline && serveTransport != "http" && serveTransport != "tcp" && serveTransport != "libp2p" {
+		return fmt.Errorf("unrecognized --serve-car-transport '%s': must be 'http', 'tcp' or 'libp2p'", serveTransport)
+	}
+	var carServer carFileServer
+	var transferURL string
+	if isOnline && cctx.IsSet("s3-url") {
+		if cctx.IsSet("http-url") || cctx.IsSet("serve-car") || cctx.IsSet("serve-import") {
+			return fmt.Errorf("only one of --s3-url, --http-url, --serve-car and --serve-import can be set")
+		}
+		transferParams := &types2.S3Request{URL: cctx.String("s3-url"), SHA256: cctx.String("s3-sha256")}
+		if _, _, err := s3transport.ParseURL(transferParams.URL); err != nil {
+			return err
+		}
+		transferURL = transferParams.URL
+
+		paramsBytes, err := json.Marshal(transferParams)
+		if err != nil {
+			return fmt.Errorf("marshalling request parameters: %w", err)
+		}
+		transfer.Type = "s3"
+		transfer.Params = paramsBytes
+	} else if isOnline && serveTransport == "tcp" {
+		if cctx.IsSet("http-url") {
+			return fmt.Errorf("only one of --http-url and --serve-car can be set")
+		}
+		tcpServer, stop, err := startTcpCarServer(cctx)
+		if err != nil {
+			return err
+		}
+		defer stop()
+
+		transferURL, err = serveCarOverTcp(cctx, tcpServer, dealUuid, &transfer, tcptransport.FrameSize(override.TcpFrameSize))
+		if err != nil {
+			return err
+		}
+		carServer = tcpServer
+	} else if isOnline && serveTransport == "libp2p" {
+		if cctx.IsSet("http-url") {
+			return fmt.Errorf("only one of --http-url and --serve-car can be set")
+		}
+		lp2pServer, stop, err := startLibp2pCarServer(cctx, n.Host, rootCid)
+		if err != nil {
+			return err
+		}
+		defer stop()
+
+		transferParams, err := lp2pServer.Add(ctx, dealUuid.String(), rootCid, carFileSize)
+		if err != nil {
+			return err
+		}
+		transferURL = transferParams.URL
+		paramsBytes, err := json.Marshal(transferParams)
+		if err != nil {
+			return fmt.Errorf("marshalling request parameters: %w", err)
+		}
+		transfer.Type = "libp2p"
+		transfer.Params = paramsBytes
+		carServer = lp2pServer
+	} else if isOnline {
 		// Store the path to the CAR file as a transfer parameter
 		transferParams := &types2.HttpRequest{URL: cctx.String("http-url")}
+		if cctx.IsSet("serve-car") || cctx.IsSet("serve-import") {
+			if cctx.IsSet("http-url") {
+				return fmt.Errorf("only one of --http-url, --serve-car and --serve-import can be set")
+			}
+			if cctx.IsSet("serve-car") && cctx.IsSet("serve-import") {
+				return fmt.Errorf("only one of --serve-car and --serve-import can be set")
+			}
+			httpServer, stop, err := startCarServer(cctx)
+			if err != nil {
+				return err
+			}
+			defer stop()
 
-		if cctx.IsSet("http-headers") {
-			transferParams.Headers = make(map[string]string)
+			if cctx.IsSet("serve-import") {
+				transferParams, err = serveMountedImport(cctx, httpServer, dealUuid)
+			} else {
+				transferParams, err = httpServer.Add(dealUuid.String(), cctx.String("serve-car"))
+			}
+			if err != nil {
+				return err
+			}
+			carServer = httpServer
+		} else if transferParams.URL == "" {
+			return fmt.Errorf("one of --http-url, --s3-url, --serve-car or --serve-import must be set")
+		}
+		transferURL = transferParams.URL
 
+		if cctx.IsSet("http-headers") {
+			if transferParams.Headers == nil {
+				transferParams.Headers = make(map[string]string)
+			}
 			for _, header := range cctx.StringSlice("http-headers") {
 				sp := strings.Split(header, "=")
 				if len(sp) != 2 {
@@ -203,6 +451,15 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 			}
 		}
 
+		for i, m := range cctx.StringSlice("http-mirror") {
+			mirror, err := parseHttpMirror(m)
+			if err != nil {
+				return err
+			}
+			mirror.Priority = i + 1
+			transferParams.Mirrors = append(transferParams.Mirrors, *mirror)
+		}
+
 		paramsBytes, err := json.Marshal(transferParams)
 		if err != nil {
 			return fmt.Errorf("marshalling request parameters: %w", err)
@@ -211,6 +468,17 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 		transfer.Params = paramsBytes
 	}
 
+	if carServer != nil {
+		// The client serves the deal's data: if it is served from an import,
+		// record the deal as pending on the import until it has been
+		// downloaded
+		untrack, err := trackImportDeal(cctx, dealUuid, maddr, pieceCid)
+		if err != nil {
+			return err
+		}
+		defer untrack()
+	}
+
 	var providerCollateral abi.TokenAmount
 	if cctx.IsSet("provider-collateral") {
 		providerCollateral = abi.NewTokenAmount(cctx.Int64("provider-collateral"))
@@ -234,35 +502,93 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 
 		head := tipset.Height()
 
-		log.Debugw("current block height", "number", head)
+		dlog.Debugw("current block height", "number", head)
 
-		startEpoch = head + abi.ChainEpoch(5760) // head + 2 days
+		startEpoch = heuristics.StartEpoch(head)
 	}
 
 	// Create a deal proposal to storage provider using deal protocol v1.2.0 format
-	dealProposal, err := dealProposal(ctx, n, walletAddr, rootCid, abi.PaddedPieceSize(pieceSize), pieceCid, maddr, startEpoch, cctx.Int("duration"), cctx.Bool("verified"), providerCollateral, abi.NewTokenAmount(cctx.Int64("storage-price")))
+	label, err := dealLabel(cctx.String("label-mode"), rootCid, cctx.String("label-salt"))
 	if err != nil {
-		return fmt.Errorf("failed to create a deal proposal: %w", err)
+		return fmt.Errorf("creating deal label: %w", err)
+	}
+	proposal := newDealProposal(walletAddr, abi.PaddedPieceSize(pieceSize), pieceCid, maddr, startEpoch, cctx.Int("duration"), cctx.Bool("verified"), providerCollateral, abi.NewTokenAmount(cctx.Int64("storage-price")), label.Label)
+
+	// Check the deal against the provider's published filter rules before
+	// signing it and topping up escrow for it
+	if !cctx.Bool("skip-rule-check") {
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return fmt.Errorf("getting chain head: %w", err)
+		}
+		dc := lp2pimpl.NewDealClient(n.Host, walletAddr, clinode.DealProposalSigner{LocalWallet: n.Wallet})
+		params := types.DealParams{
+			ClientDealProposal: market.ClientDealProposal{Proposal: proposal},
+			IsOffline:          !isOnline,
+			Transfer:           transfer,
+		}
+		if rule := newProviderRules(dc).check(ctx, addrInfo.ID, params, head.Height()); rule != "" {
+			return fmt.Errorf("deal would be rejected by the provider's published filter rule %s: "+
+				"proposal not sent (use --skip-rule-check to send it anyway)", rule)
+		}
 	}
+	dealProposal := &market.ClientDealProposal{Proposal: proposal}
+	if exportPath == "" {
+		dealProposal, err = signDealProposal(ctx, n, proposal)
+		if err != nil {
+			return fmt.Errorf("failed to create a deal proposal: %w", err)
+		}
 
+		if err := ensureEscrow(ctx, cctx, api, n, &dealProposal.Proposal); err != nil {
+			return err
+		}
+	}
+
+	transferStartTimeout, transferTimeout := overriddenTransferTimeouts(cctx, override)
 	dealParams := types.DealParams{
 		DealUUID:           dealUuid,
 		ClientDealProposal: *dealProposal,
 		DealDataRoot:       rootCid,
 		IsOffline:          !isOnline,
 		Transfer:           transfer,
+
+		TransferStartTimeout: transferStartTimeout,
+		TransferTimeout:      transferTimeout,
+		CompletionTimeout:    cctx.Duration("completion-timeout"),
+
+		TransferRetry: transferOpts.TransferRetry,
+		AlternateURLs: transferOpts.AlternateURLs,
 	}
+	// Check the transfer options as the provider will
+	if _, err := dealParams.TransferWithOptions(); err != nil {
+		return err
+	}
+
+	if exportPath != "" {
+		return exportUnsignedDeal(cctx, exportPath, dealParams)
+	}
+
+	dlog.Debugw("about to submit deal proposal")
 
-	log.Debugw("about to submit deal proposal", "uuid", dealUuid.String())
+	negCtx := ctx
+	timeout := heuristics.ProposalTimeout
+	if cctx.IsSet("negotiation-timeout") {
+		timeout = cctx.Duration("negotiation-timeout")
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		negCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	s, err := n.Host.NewStream(ctx, addrInfo.ID, DealProtocolv120)
+	s, err := n.Host.NewStream(negCtx, addrInfo.ID, dealProto)
 	if err != nil {
 		return fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
 	}
 	defer s.Close()
 
 	var resp types.DealResponse
-	if err := doRpc(ctx, s, &dealParams, &resp); err != nil {
+	if err := doRpc(negCtx, s, &dealParams, &resp); err != nil {
 		return fmt.Errorf("send proposal rpc: %w", err)
 	}
 
@@ -270,21 +596,28 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 		return fmt.Errorf("deal proposal rejected: %s", resp.Message)
 	}
 
+	recordDealIdentity(cctx, n, dealUuid)
+
 	if cctx.Bool("json") {
-		out := map[string]interface{}{
-			"dealUuid":           dealUuid.String(),
-			"provider":           maddr.String(),
-			"clientWallet":       walletAddr.String(),
-			"payloadCid":         rootCid.String(),
-			"commp":              dealProposal.Proposal.PieceCID.String(),
-			"startEpoch":         dealProposal.Proposal.StartEpoch.String(),
-			"endEpoch":           dealProposal.Proposal.EndEpoch.String(),
-			"providerCollateral": dealProposal.Proposal.ProviderCollateral.String(),
+		out := dealOutput{
+			DealUUID:           dealUuid.String(),
+			Provider:           maddr.String(),
+			ClientWallet:       walletAddr.String(),
+			PayloadCid:         rootCid.String(),
+			CommP:              dealProposal.Proposal.PieceCID.String(),
+			StartEpoch:         dealProposal.Proposal.StartEpoch.String(),
+			EndEpoch:           dealProposal.Proposal.EndEpoch.String(),
+			ProviderCollateral: dealProposal.Proposal.ProviderCollateral.String(),
+			ProviderSoftware:   software.String(),
+			LabelSalt:          label.Salt,
 		}
 		if isOnline {
-			out["url"] = cctx.String("http-url")
+			out.URL = transferURL
 		}
-		return cmd.PrintJson(out)
+		if err := cmd.PrintJson(out); err != nil {
+			return err
+		}
+		return waitForCarDownload(ctx, carServer, dealUuid)
 	}
 
 	msg := "sent deal proposal"
@@ -294,48 +627,101 @@ func dealCmdAction(cctx *cli.Context, isOnline bool) error {
 	msg += "\n"
 	msg += fmt.Sprintf("  deal uuid: %s\n", dealUuid)
 	msg += fmt.Sprintf("  storage provider: %s\n", maddr)
+	msg += fmt.Sprintf("  provider software: %s\n", software)
 	msg += fmt.Sprintf("  client wallet: %s\n", walletAddr)
 	msg += fmt.Sprintf("  payload cid: %s\n", rootCid)
 	if isOnline {
-		msg += fmt.Sprintf("  url: %s\n", cctx.String("http-url"))
+		msg += fmt.Sprintf("  url: %s\n", transferURL)
 	}
 	msg += fmt.Sprintf("  commp: %s\n", dealProposal.Proposal.PieceCID)
 	msg += fmt.Sprintf("  start epoch: %d\n", dealProposal.Proposal.StartEpoch)
 	msg += fmt.Sprintf("  end epoch: %d\n", dealProposal.Proposal.EndEpoch)
 	msg += fmt.Sprintf("  provider collateral: %s\n", chain_types.FIL(dealProposal.Proposal.ProviderCollateral).Short())
+	if label.Salt != "" {
+		msg += fmt.Sprintf("  label salt: %s\n", label.Salt)
+	}
 	fmt.Println(msg)
 
-	return nil
+	return waitForCarDownload(ctx, carServer, dealUuid)
 }
 
-func dealProposal(ctx context.Context, n *clinode.Node, clientAddr address.Address, rootCid cid.Cid, pieceSize abi.PaddedPieceSize, pieceCid cid.Cid, minerAddr address.Address, startEpoch abi.ChainEpoch, duration int, verified bool, providerCollateral abi.TokenAmount, storagePrice abi.TokenAmount) (*market.ClientDealProposal, error) {
+// dealTransferOptions returns the retry policy and alternate urls for the
+// data transfer that are set by flags
+func dealTransferOptions(cctx *cli.Context) (*types.TransferRetryPolicy, []types.AlternateURL) {
+	var retry *types.TransferRetryPolicy
+	if cctx.IsSet("transfer-retry-attempts") || cctx.IsSet("transfer-retry-min-backoff") || cctx.IsSet("transfer-retry-max-backoff") {
+		retry = &types.TransferRetryPolicy{
+			MaxAttempts: cctx.Uint64("transfer-retry-attempts"),
+			MinBackoff:  cctx.Duration("transfer-retry-min-backoff"),
+			MaxBackoff:  cctx.Duration("transfer-retry-max-backoff"),
+		}
+	}
+	var alternates []types.AlternateURL
+	for _, u := range cctx.StringSlice("alternate-url") {
+		alternates = append(alternates, types.AlternateURL{URL: u})
+	}
+	return retry, alternates
+}
+
+// dealProviderError explains why the client can't make a deal with the
+// provider, given the error finding a deal protocol that the provider
+// supports
+func dealProviderError(maddr address.Address, software providerimpl.Info, err error) error {
+	if software.Software == providerimpl.LotusMarkets {
+		return fmt.Errorf("boost client cannot make a deal with storage provider %s: it runs the legacy "+
+			"lotus-miner markets (%s), which only supports the go-fil-markets deal protocols: %w", maddr, software, err)
+	}
+	return fmt.Errorf("boost client cannot make a deal with storage provider %s: %w", maddr, err)
+}
+
+// supportedDealProtocol returns the first of the deal protocols that the
+// provider supports
+func supportedDealProtocol(n *clinode.Node, id peer.ID, protos []protocol.ID) (protocol.ID, error) {
+	strs := make([]string, 0, len(protos))
+	for _, p := range protos {
+		strs = append(strs, string(p))
+	}
+	x, err := n.Host.Peerstore().FirstSupportedProtocol(id, strs...)
+	if err != nil {
+		return "", fmt.Errorf("getting protocols for peer %s: %w", id, err)
+	}
+	if len(x) == 0 {
+		return "", lp2pimpl.NewProtocolNotSupportedError(n.Host, id, protos)
+	}
+	return protocol.ID(x), nil
+}
+
+func dealProposal(ctx context.Context, n *clinode.Node, clientAddr address.Address, rootCid cid.Cid, pieceSize abi.PaddedPieceSize, pieceCid cid.Cid, minerAddr address.Address, startEpoch abi.ChainEpoch, duration int, verified bool, providerCollateral abi.TokenAmount, storagePrice abi.TokenAmount, label market.DealLabel) (*market.ClientDealProposal, error) {
+	proposal := newDealProposal(clientAddr, pieceSize, pieceCid, minerAddr, startEpoch, duration, verified, providerCollateral, storagePrice, label)
+	return signDealProposal(ctx, n, proposal)
+}
+
+func newDealProposal(clientAddr address.Address, pieceSize abi.PaddedPieceSize, pieceCid cid.Cid, minerAddr address.Address, startEpoch abi.ChainEpoch, duration int, verified bool, providerCollateral abi.TokenAmount, storagePrice abi.TokenAmount, label market.DealLabel) market.DealProposal {
 	endEpoch := startEpoch + abi.ChainEpoch(duration)
 	// deal proposal expects total storage price for deal per epoch, therefore we
 	// multiply pieceSize * storagePrice (which is set per epoch per GiB) and divide by 2^30
 	storagePricePerEpochForDeal := big.Div(big.Mul(big.NewInt(int64(pieceSize)), storagePrice), big.NewInt(int64(1<<30)))
-	l, err := market.NewLabelFromString(rootCid.String())
-	if err != nil {
-		return nil, err
-	}
-	proposal := market.DealProposal{
+	return market.DealProposal{
 		PieceCID:             pieceCid,
 		PieceSize:            pieceSize,
 		VerifiedDeal:         verified,
 		Client:               clientAddr,
 		Provider:             minerAddr,
-		Label:                l,
+		Label:                label,
 		StartEpoch:           startEpoch,
 		EndEpoch:             endEpoch,
 		StoragePricePerEpoch: storagePricePerEpochForDeal,
 		ProviderCollateral:   providerCollateral,
 	}
+}
 
+func signDealProposal(ctx context.Context, n *clinode.Node, proposal market.DealProposal) (*market.ClientDealProposal, error) {
 	buf, err := cborutil.Dump(&proposal)
 	if err != nil {
 		return nil, err
 	}
 
-	sig, err := n.Wallet.WalletSign(ctx, clientAddr, buf, api.MsgMeta{Type: api.MTDealProposal})
+	sig, err := n.Wallet.WalletSign(ctx, proposal.Client, buf, api.MsgMeta{Type: api.MTDealProposal})
 	if err != nil {
 		return nil, fmt.Errorf("wallet sign failed: %w", err)
 	}
@@ -369,3 +755,22 @@ func doRpc(ctx context.Context, s inet.Stream, req interface{}, resp interface{}
 		return ctx.Err()
 	}
 }
+
+// parseHttpMirror parses a mirror url with an optional region prefix,
+// eg us-west-2=https://bucket.s3.us-west-2.amazonaws.com/data.car
+func parseHttpMirror(s string) (*types2.HttpMirror, error) {
+	var mirror types2.HttpMirror
+	mirror.URL = s
+	if i := strings.Index(s, "="); i > 0 && !strings.Contains(s[:i], "://") {
+		mirror.Region = s[:i]
+		mirror.URL = s[i+1:]
+	}
+	u, err := url.Parse(mirror.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing http mirror url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("http mirror must be an http or https url: %s", s)
+	}
+	return &mirror, nil
+}
@@ -12,11 +12,34 @@ import (
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
 )
 
+// dealStatusOutput is the output of the deal-status command in json mode
+type dealStatusOutput struct {
+	// If the provider returned an error, none of the other fields are set
+	Error         string     `json:"error,omitempty"`
+	DealUUID      string     `json:"dealUuid,omitempty"`
+	Provider      string     `json:"provider,omitempty"`
+	ClientWallet  string     `json:"clientWallet,omitempty"`
+	Label         string     `json:"label,omitempty"`
+	ChainDealID   abi.DealID `json:"chainDealId,omitempty"`
+	Status        string     `json:"status,omitempty"`
+	SealingStatus string     `json:"sealingStatus,omitempty"`
+	StatusMessage string     `json:"statusMessage,omitempty"`
+	PublishCid    *string    `json:"publishCid"`
+	// The origin that the provider transferred the deal data from, eg one
+	// of the deal's alternate urls
+	TransferOrigin string `json:"transferOrigin,omitempty"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("deal-status", dealStatusOutput{})
+}
+
 var dealStatusCmd = &cli.Command{
 	Name:  "deal-status",
 	Usage: "",
@@ -35,6 +58,11 @@ var dealStatusCmd = &cli.Command{
 			Name:  "wallet",
 			Usage: "the wallet address that was used to sign the deal proposal",
 		},
+		&cli.BoolFlag{
+			Name: "wait-active",
+			Usage: "after showing the status of a published deal, wait for the deal to be activated on chain " +
+				"(detected from chain notifications, falling back to polling if the notify stream drops)",
+		},
 	},
 	Before: before,
 	Action: func(cctx *cli.Context) error {
@@ -104,30 +132,35 @@ var dealStatusCmd = &cli.Command{
 		}
 
 		if cctx.Bool("json") {
-			out := map[string]interface{}{}
+			var out dealStatusOutput
 			if resp.Error != "" {
-				out["error"] = resp.Error
+				out.Error = resp.Error
 			} else {
-				out = map[string]interface{}{
-					"dealUuid":     resp.DealUUID.String(),
-					"provider":     maddr.String(),
-					"clientWallet": walletAddr.String(),
-				}
+				out.DealUUID = resp.DealUUID.String()
+				out.Provider = maddr.String()
+				out.ClientWallet = walletAddr.String()
 				// resp.DealStatus should always be present if there's no error,
 				// but check just in case
 				if resp.DealStatus != nil {
-					out["label"] = lstr
-					out["chainDealId"] = resp.DealStatus.ChainDealID
-					out["status"] = resp.DealStatus.Status
-					out["sealingStatus"] = resp.DealStatus.SealingStatus
-					out["statusMessage"] = statusMessage(resp)
-					out["publishCid"] = nil
+					out.Label = lstr
+					out.ChainDealID = resp.DealStatus.ChainDealID
+					out.Status = resp.DealStatus.Status
+					out.SealingStatus = resp.DealStatus.SealingStatus
+					out.StatusMessage = statusMessage(resp)
+					out.TransferOrigin = resp.TransferOrigin
 					if resp.DealStatus.PublishCid != nil {
-						out["publishCid"] = resp.DealStatus.PublishCid.String()
+						publishCid := resp.DealStatus.PublishCid.String()
+						out.PublishCid = &publishCid
 					}
 				}
 			}
-			return cmd.PrintJson(out)
+			if err := cmd.PrintJson(out); err != nil {
+				return err
+			}
+			if cctx.Bool("wait-active") && resp.Error == "" && resp.DealStatus != nil {
+				return waitDealActive(ctx, cctx, api, dc, addrInfo.ID, maddr, dealUUID, resp.DealStatus)
+			}
+			return nil
 		}
 
 		msg := "got deal status response"
@@ -143,10 +176,16 @@ var dealStatusCmd = &cli.Command{
 		msg += fmt.Sprintf("  deal uuid: %s\n", resp.DealUUID)
 		msg += fmt.Sprintf("  deal status: %s\n", statusMessage(resp))
 		msg += fmt.Sprintf("  deal label: %s\n", lstr)
+		if resp.TransferOrigin != "" {
+			msg += fmt.Sprintf("  transfer origin: %s\n", resp.TransferOrigin)
+		}
 		msg += fmt.Sprintf("  publish cid: %s\n", resp.DealStatus.PublishCid)
 		msg += fmt.Sprintf("  chain deal id: %d\n", resp.DealStatus.ChainDealID)
 		fmt.Println(msg)
 
+		if cctx.Bool("wait-active") {
+			return waitDealActive(ctx, cctx, api, dc, addrInfo.ID, maddr, dealUUID, resp.DealStatus)
+		}
 		return nil
 	},
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/dealwatch"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+// dealActiveOutput is output by deal-status --wait-active in json mode, once
+// the deal has been activated or slashed
+type dealActiveOutput struct {
+	DealUUID    string         `json:"dealUuid"`
+	ChainDealID abi.DealID     `json:"chainDealId"`
+	State       string         `json:"state"`
+	Epoch       abi.ChainEpoch `json:"epoch"`
+}
+
+// waitDealActive waits for the deal to be published (if it hasn't been
+// already) and then activated, using a chain watcher rather than polling
+// the deal's state
+func waitDealActive(ctx context.Context, cctx *cli.Context, api lapi.Gateway, dc *lp2pimpl.DealClient, id peer.ID, maddr address.Address, dealUUID uuid.UUID, status *types.DealStatus) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if status.ChainDealID == 0 && status.PublishCid == nil {
+		return fmt.Errorf("deal %s has not been published yet", dealUUID)
+	}
+
+	w := dealwatch.NewWatcher(api, dealwatch.DefaultPollInterval)
+	evts, unsub := w.Subscribe()
+	defer unsub()
+
+	if status.ChainDealID == 0 {
+		w.WatchPublish(*status.PublishCid)
+	} else {
+		w.WatchDeal(status.ChainDealID, maddr)
+	}
+	go w.Run(ctx)
+
+	if !cctx.Bool("json") {
+		fmt.Println("waiting for deal to be activated on chain")
+	}
+	for {
+		var evt dealwatch.Event
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case evt = <-evts:
+		}
+
+		switch evt.Type {
+		case dealwatch.EventPublished:
+			if evt.ExitCode != exitcode.Ok {
+				return fmt.Errorf("publish message %s failed with exit code %s", evt.PublishCid, evt.ExitCode)
+			}
+			if !cctx.Bool("json") {
+				if evt.ReplacedBy.Defined() {
+					fmt.Printf("  publish message was replaced by %s\n", evt.ReplacedBy)
+				}
+				fmt.Printf("  publish message landed at height %d\n", evt.Height)
+			}
+			// The provider assigns the chain deal id once it has seen the
+			// publish message land
+			dealID, err := waitChainDealID(ctx, dc, id, dealUUID)
+			if err != nil {
+				return err
+			}
+			if !cctx.Bool("json") {
+				fmt.Printf("  chain deal id: %d\n", dealID)
+			}
+			w.WatchDeal(dealID, maddr)
+		case dealwatch.EventActivated, dealwatch.EventSlashed:
+			if cctx.Bool("json") {
+				return cmd.PrintJson(dealActiveOutput{
+					DealUUID:    dealUUID.String(),
+					ChainDealID: evt.DealID,
+					State:       string(evt.Type),
+					Epoch:       evt.Epoch,
+				})
+			}
+			if evt.Type == dealwatch.EventSlashed {
+				return fmt.Errorf("deal %d was slashed at epoch %d", evt.DealID, evt.Epoch)
+			}
+			fmt.Printf("  deal %d activated at epoch %d\n", evt.DealID, evt.Epoch)
+			return nil
+		}
+	}
+}
+
+// waitChainDealID queries the provider for the deal's chain deal id until
+// it has one
+func waitChainDealID(ctx context.Context, dc *lp2pimpl.DealClient, id peer.ID, dealUUID uuid.UUID) (abi.DealID, error) {
+	for {
+		resp, err := dc.SendDealStatusRequest(ctx, id, dealUUID)
+		if err != nil {
+			return 0, fmt.Errorf("send deal status request failed: %w", err)
+		}
+		if resp.Error != "" {
+			return 0, fmt.Errorf("deal status error: %s", resp.Error)
+		}
+		if resp.DealStatus != nil && resp.DealStatus.ChainDealID != 0 {
+			return resp.DealStatus.ChainDealID, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(dealwatch.DefaultPollInterval):
+		}
+	}
+}
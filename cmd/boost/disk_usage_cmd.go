@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/diskusage"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// diskQuotasFile is the name of the disk quotas file in the client repo
+	diskQuotasFile = "disk-quotas.json"
+	// diskUsageHistoryFile is the name of the disk usage history file in the
+	// client repo
+	diskUsageHistoryFile = "disk-usage-history.json"
+	// diskUsageTrendWindow is the period over which usage trends are shown
+	diskUsageTrendWindow = 7 * 24 * time.Hour
+)
+
+// diskUsageOutput is the output of the disk-usage command in json mode
+type diskUsageOutput struct {
+	Usage  diskusage.Usage  `json:"usage"`
+	Total  uint64           `json:"total"`
+	Trend  *diskusage.Trend `json:"trend,omitempty"`
+	Quotas diskusage.Quotas `json:"quotas"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("disk-usage", diskUsageOutput{})
+}
+
+var diskUsageCmd = &cli.Command{
+	Name:  "disk-usage",
+	Usage: "Show the disk space used by imports, retrievals and the datastore in the client repo",
+	Description: "Before an import or retrieval starts, its projected disk usage is checked against the free " +
+		"space on the disk and against the quotas in " + diskQuotasFile + " in the client repo, eg\n\n" +
+		"   {\"imports\": \"500GiB\", \"retrievals\": \"1TiB\", \"minFree\": \"20GiB\"}\n\n" +
+		"   Each check records a usage sample, which is used to show how usage is trending.",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		u, quotas, history, err := measureDiskUsage(cctx)
+		if err != nil {
+			return err
+		}
+		trend := history.Trend(diskUsageTrendWindow)
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(diskUsageOutput{Usage: *u, Total: u.Total(), Trend: trend, Quotas: *quotas})
+		}
+
+		withQuota := func(size uint64, quota string) string {
+			if quota == "" {
+				return humanize.IBytes(size)
+			}
+			return humanize.IBytes(size) + " (quota " + quota + ")"
+		}
+		fmt.Printf("Imports:    %s\n", withQuota(u.Imports, quotas.Imports))
+		fmt.Printf("Retrievals: %s\n", withQuota(u.Retrievals, quotas.Retrievals))
+		fmt.Printf("Datastore:  %s\n", humanize.IBytes(u.Datastore))
+		fmt.Printf("Total:      %s\n", humanize.IBytes(u.Total()))
+		fmt.Printf("Free:       %s\n", withQuota(u.Free, quotas.MinFree))
+		if trend != nil {
+			fmt.Printf("\nChange per day since %s:\n", trend.Since.Format(time.RFC3339))
+			fmt.Printf("Imports:    %s\n", signedBytes(trend.Imports))
+			fmt.Printf("Retrievals: %s\n", signedBytes(trend.Retrievals))
+			fmt.Printf("Datastore:  %s\n", signedBytes(trend.Datastore))
+			fmt.Printf("Total:      %s\n", signedBytes(trend.Total))
+		}
+		return nil
+	},
+}
+
+func signedBytes(n int64) string {
+	if n < 0 {
+		return "-" + humanize.IBytes(uint64(-n))
+	}
+	return "+" + humanize.IBytes(uint64(n))
+}
+
+// measureDiskUsage measures the disk usage of the client repo, and records
+// it in the usage history
+func measureDiskUsage(cctx *cli.Context) (*diskusage.Usage, *diskusage.Quotas, *diskusage.History, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Count the retrieved CAR files that are still on disk
+	store, err := openRetrievalStore(cctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	records, err := store.List()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var retrieved uint64
+	for _, r := range records {
+		if r.Path == "" {
+			continue
+		}
+		if st, err := os.Stat(r.Path); err == nil {
+			retrieved += uint64(st.Size())
+		}
+	}
+
+	u, err := diskusage.Measure(sdir, filepath.Join(sdir, "dedupstore"), filepath.Join(sdir, "retrievals"), retrieved)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("measuring disk usage: %w", err)
+	}
+	quotas, err := diskusage.LoadQuotas(filepath.Join(sdir, diskQuotasFile))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	history, err := diskusage.LoadHistory(filepath.Join(sdir, diskUsageHistoryFile))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := history.Record(*u); err != nil {
+		return nil, nil, nil, err
+	}
+	return u, quotas, history, nil
+}
+
+// checkDiskSpace fails with a *diskusage.InsufficientSpaceError if writing
+// required bytes of the given kind to path would exceed the free disk space
+// or the client repo's disk quotas
+func checkDiskSpace(cctx *cli.Context, kind diskusage.Kind, path string, required uint64) error {
+	u, quotas, _, err := measureDiskUsage(cctx)
+	if err != nil {
+		return err
+	}
+	if err := diskusage.Check(u, quotas, kind, path, required); err != nil {
+		return err
+	}
+	log.Debugw("disk space check passed", "kind", kind, "path", path, "required", required, "free", u.Free)
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/erasure"
+	"github.com/filecoin-project/boost/lib/prepjobs"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+)
+
+// erasureRebuildOutput is the output of the erasure rebuild command in json
+// mode
+type erasureRebuildOutput struct {
+	Path   string                `json:"path"`
+	Size   int64                 `json:"size"`
+	Shards []erasure.ShardResult `json:"shards"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("erasure encode", erasure.Manifest{})
+	cmd.RegisterJsonOutput("erasure deal", erasure.Manifest{})
+	cmd.RegisterJsonOutput("erasure rebuild", erasureRebuildOutput{})
+}
+
+var erasureCmd = &cli.Command{
+	Name:  "erasure",
+	Usage: "Store a dataset as erasure coded shards with different storage providers",
+	Description: "The dataset is split into k data shards and m parity shards, each stored with a " +
+		"different storage provider. The dataset can be rebuilt from any k shards, so it survives " +
+		"the loss of up to m providers, at a storage cost of (k+m)/k instead of full replication.",
+	Subcommands: []*cli.Command{
+		erasureEncodeCmd,
+		erasureDealCmd,
+		erasureRebuildCmd,
+	},
+}
+
+var erasureEncodeCmd = &cli.Command{
+	Name:      "encode",
+	Usage:     "Erasure code a file into shard CAR files and write a manifest",
+	ArgsUsage: "<input file> <output dir>",
+	Before:    before,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "data-shards",
+			Usage: "the number of data shards (k)",
+			Value: 4,
+		},
+		&cli.IntFlag{
+			Name:  "parity-shards",
+			Usage: "the number of parity shards (m)",
+			Value: 2,
+		},
+		&cli.IntFlag{
+			Name:  "block-size",
+			Usage: "the number of bytes of each shard in each stripe",
+			Value: erasure.DefaultBlockSize,
+		},
+		&cli.StringSliceFlag{
+			Name:  "provider",
+			Usage: "the storage providers to store the shards with, one per shard (may be repeated, or set later in the manifest)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 2 {
+			return fmt.Errorf("usage: erasure encode <input file> <output dir>")
+		}
+		inPath := cctx.Args().Get(0)
+		outDir := cctx.Args().Get(1)
+
+		k, m := cctx.Int("data-shards"), cctx.Int("parity-shards")
+		providers := cctx.StringSlice("provider")
+		if len(providers) > 0 && len(providers) != k+m {
+			return fmt.Errorf("%d providers given for %d shards: each shard must be stored with a different provider", len(providers), k+m)
+		}
+		seen := make(map[address.Address]struct{})
+		for _, p := range providers {
+			maddr, err := address.NewFromString(p)
+			if err != nil {
+				return fmt.Errorf("parsing provider address %s: %w", p, err)
+			}
+			if _, ok := seen[maddr]; ok {
+				return fmt.Errorf("provider %s is given more than once: each shard must be stored with a different provider", maddr)
+			}
+			seen[maddr] = struct{}{}
+		}
+
+		codec, err := erasure.New(k, m, cctx.Int("block-size"))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return fmt.Errorf("creating output directory %s: %w", outDir, err)
+		}
+		outDir, err = filepath.Abs(outDir)
+		if err != nil {
+			return err
+		}
+
+		manifest, err := erasure.EncodeFile(ctx, codec, inPath, outDir)
+		if err != nil {
+			return err
+		}
+		for i, p := range providers {
+			manifest.Shards[i].Provider = p
+		}
+		manifestPath := filepath.Join(outDir, "manifest.json")
+		if err := manifest.Save(manifestPath); err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(manifest)
+		}
+		fmt.Printf("Encoded %s (%d bytes) into %d data + %d parity shards\n", inPath, manifest.DataSize, k, m)
+		for _, s := range manifest.Shards {
+			kind := "data"
+			if manifest.IsParity(s) {
+				kind = "parity"
+			}
+			fmt.Printf("  shard %d (%s): %s piece %s (%d)\n", s.Index, kind, filepath.Base(s.Path), s.PieceCid, s.PieceSize)
+		}
+		fmt.Printf("Wrote manifest to %s\n", manifestPath)
+		return nil
+	},
+}
+
+var erasureDealCmd = &cli.Command{
+	Name:      "deal",
+	Usage:     "Make an online deal for each shard in a manifest with the shard's storage provider",
+	ArgsUsage: "<manifest>",
+	Before:    before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "url-prefix",
+			Usage:    "the url that the shard CAR files are served from; each shard's url is the prefix followed by the CAR file name",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "duration",
+			Usage: "duration of the deals in epochs",
+			Value: 518400, // default is 2880 * 180 == 180 days
+		},
+		&cli.Int64Flag{
+			Name:  "storage-price",
+			Usage: "storage price in attoFIL per epoch per GiB",
+			Value: 1,
+		},
+		&cli.BoolFlag{
+			Name:  "verified",
+			Usage: "whether the deal funds should come from verified client data-cap",
+			Value: true,
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "wallet address to be used to make the deals",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "make deals for all shards, including shards that already have an accepted deal",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: erasure deal <manifest>")
+		}
+		manifestPath := cctx.Args().First()
+		manifest, err := erasure.LoadManifest(manifestPath)
+		if err != nil {
+			return err
+		}
+		for _, s := range manifest.Shards {
+			if s.Provider == "" {
+				return fmt.Errorf("shard %d has no storage provider: set each shard's provider in the manifest", s.Index)
+			}
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		policy := prepjobs.Policy{
+			Replicas:         1,
+			Duration:         abi.ChainEpoch(cctx.Int("duration")),
+			StartEpochOffset: prepjobs.DefaultStartEpochOffset,
+			Verified:         cctx.Bool("verified"),
+			StoragePrice:     big.NewInt(cctx.Int64("storage-price")),
+		}
+		overrides, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		dm := &clientDealMaker{node: n, api: api, wallet: walletAddr, overrides: overrides}
+		urlPrefix := strings.TrimSuffix(cctx.String("url-prefix"), "/") + "/"
+
+		var failed int
+		for i := range manifest.Shards {
+			s := &manifest.Shards[i]
+			// Skip shards that already have an accepted deal
+			if !cctx.Bool("all") && s.DealUUID != nil && s.DealError == "" {
+				continue
+			}
+
+			maddr, err := address.NewFromString(s.Provider)
+			if err != nil {
+				return fmt.Errorf("parsing provider address %s for shard %d: %w", s.Provider, s.Index, err)
+			}
+			piece := prepjobs.Piece{
+				PieceCid:   s.PieceCid,
+				PieceSize:  s.PieceSize,
+				PayloadCid: s.PayloadCid,
+				CarSize:    s.CarSize,
+				URL:        urlPrefix + url.PathEscape(filepath.Base(s.Path)),
+			}
+			deal, err := dm.MakeDeal(ctx, policy, maddr, piece)
+			s.DealError = ""
+			switch {
+			case err != nil:
+				s.DealError = err.Error()
+			case !deal.Accepted:
+				s.DealUUID = &deal.DealUUID
+				s.DealError = "deal rejected: " + deal.Message
+			default:
+				s.DealUUID = &deal.DealUUID
+			}
+			if s.DealError != "" {
+				failed++
+				log.Warnw("shard deal failed", "shard", s.Index, "provider", s.Provider, "err", s.DealError)
+			}
+			if !cctx.Bool("json") {
+				status := "accepted"
+				if s.DealError != "" {
+					status = s.DealError
+				}
+				fmt.Printf("shard %d with %s: %s\n", s.Index, s.Provider, status)
+			}
+
+			// Save after each deal so that progress isn't lost
+			if err := manifest.Save(manifestPath); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("json") {
+			if err := cmd.PrintJson(manifest); err != nil {
+				return err
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("deals for %d of %d shards failed", failed, len(manifest.Shards))
+		}
+		return nil
+	},
+}
+
+var erasureRebuildCmd = &cli.Command{
+	Name:      "rebuild",
+	Usage:     "Retrieve any k shards of an erasure coded dataset and rebuild the original file",
+	ArgsUsage: "<manifest> <output file>",
+	Description: "Shards are retrieved from their storage providers, data shards first. A shard that " +
+		"can't be retrieved, or whose contents don't match the manifest, is skipped.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "shard-dir",
+			Usage:       "the directory to write retrieved shard CAR files to",
+			DefaultText: "a temporary directory",
+		},
+		&cli.BoolFlag{
+			Name:  "local",
+			Usage: "use the shard CAR files at the paths in the manifest when they exist, instead of retrieving them",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 2 {
+			return fmt.Errorf("usage: erasure rebuild <manifest> <output file>")
+		}
+		manifest, err := erasure.LoadManifest(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		outPath := cctx.Args().Get(1)
+
+		shardDir := cctx.String("shard-dir")
+		if shardDir == "" {
+			shardDir, err = os.MkdirTemp("", "erasure-shards")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(shardDir) //nolint:errcheck
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		local := cctx.Bool("local")
+		fetch := func(ctx context.Context, s erasure.Shard) (string, error) {
+			if local {
+				if _, err := os.Stat(s.Path); err == nil {
+					return s.Path, nil
+				}
+			}
+			if s.Provider == "" {
+				return "", fmt.Errorf("shard has no storage provider")
+			}
+			maddr, err := address.NewFromString(s.Provider)
+			if err != nil {
+				return "", fmt.Errorf("parsing provider address %s: %w", s.Provider, err)
+			}
+			endpoint, err := httpRetrievalEndpoint(ctx, n, api, maddr)
+			if err != nil {
+				return "", err
+			}
+			carPath := filepath.Join(shardDir, fmt.Sprintf("shard-%03d.car", s.Index))
+			query := url.Values{"payloadCid": {s.PayloadCid.String()}}
+			if _, _, err := retrieveCar(ctx, endpoint, query, carPath); err != nil {
+				return "", err
+			}
+			return carPath, nil
+		}
+
+		out, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", outPath, err)
+		}
+		defer out.Close() //nolint:errcheck
+
+		results, err := erasure.Rebuild(ctx, manifest, fetch, out)
+		if !cctx.Bool("json") {
+			for _, res := range results {
+				status := "ok"
+				if res.Error != "" {
+					status = res.Error
+				}
+				fmt.Printf("shard %d from %s: %s\n", res.Index, res.Provider, status)
+			}
+		}
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(outPath)
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(erasureRebuildOutput{Path: outPath, Size: manifest.DataSize, Shards: results})
+		}
+		fmt.Printf("Rebuilt %s (%d bytes) to %s\n", manifest.Name, manifest.DataSize, outPath)
+		return nil
+	},
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/escrow"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors"
+	marketactor "github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/modules"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// trustedProvidersFile is the name of the provider trust list in the client
+// repo
+const trustedProvidersFile = "trusted-providers.json"
+
+// ensureEscrow makes sure that the client's available market escrow covers
+// the deal before it's proposed.
+// Small deals with providers on the client's trust list take the fast path:
+// escrow is topped up in batches, without waiting for the top up to land.
+// Other deals are only topped up if the add-funds flag is set, and wait for
+// the top up to land before the deal is proposed.
+func ensureEscrow(ctx context.Context, cctx *cli.Context, api lapi.Gateway, n *clinode.Node, proposal *market.DealProposal) error {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return err
+	}
+	trustList, err := escrow.LoadTrustList(filepath.Join(sdir, trustedProvidersFile))
+	if err != nil {
+		return err
+	}
+
+	dlog := logctx.Logger(ctx, log)
+	fast := trustList.FastPath(proposal.Provider, proposal.PieceSize)
+	if !fast && !cctx.Bool("add-funds") {
+		// Leave it to the provider to check the client's escrow
+		return nil
+	}
+
+	available, err := availableEscrow(ctx, api, proposal.Client)
+	if err != nil {
+		return err
+	}
+
+	pending, err := escrow.LoadPendingTopUps(filepath.Join(sdir, "escrow-topups-"+proposal.Client.String()+".json"))
+	if err != nil {
+		return err
+	}
+	if fast {
+		err = pending.Prune(time.Now(), func(c cid.Cid) (bool, error) {
+			lookup, err := api.StateSearchMsg(ctx, chain_types.EmptyTSK, c, lapi.LookbackNoLimit, true)
+			return lookup != nil, err
+		})
+		if err != nil {
+			return err
+		}
+		if err := pending.Save(); err != nil {
+			return err
+		}
+	}
+
+	required := proposal.ClientBalanceRequirement()
+	plan := trustList.PlanTopUp(fast, available, pending.Total(), required)
+	dlog.Debugw("escrow preflight", "fast-path", fast, "available", available, "pending", pending.Total(),
+		"required", required, "top-up", plan.TopUp)
+	if plan.TopUp.IsZero() {
+		return nil
+	}
+
+	msgCid, err := addEscrow(ctx, api, n, proposal.Client, plan.TopUp)
+	if err != nil {
+		return fmt.Errorf("adding %s to market escrow: %w", chain_types.FIL(plan.TopUp).Short(), err)
+	}
+	dlog.Infow("sent market escrow top up", "wallet", proposal.Client, "amount", chain_types.FIL(plan.TopUp).Short(),
+		"cid", msgCid, "fast-path", fast)
+
+	if !plan.Wait {
+		return pending.Add(escrow.TopUp{Cid: msgCid, Amount: plan.TopUp, SentAt: time.Now()})
+	}
+
+	dlog.Infow("waiting for market escrow top up to land on chain", "cid", msgCid)
+	lookup, err := api.StateWaitMsg(ctx, msgCid, build.MessageConfidence, lapi.LookbackNoLimit, true)
+	if err != nil {
+		return fmt.Errorf("waiting for market escrow top up %s: %w", msgCid, err)
+	}
+	if !lookup.Receipt.ExitCode.IsSuccess() {
+		return fmt.Errorf("market escrow top up %s failed with exit code %d", msgCid, lookup.Receipt.ExitCode)
+	}
+	return nil
+}
+
+// availableEscrow returns the client's market escrow balance that isn't
+// locked in deals
+func availableEscrow(ctx context.Context, api lapi.Gateway, client address.Address) (abi.TokenAmount, error) {
+	bal, err := api.StateMarketBalance(ctx, client, chain_types.EmptyTSK)
+	if err != nil {
+		if strings.Contains(err.Error(), "actor not found") {
+			// Funds have never been added to escrow for the client
+			return big.Zero(), nil
+		}
+		return abi.TokenAmount{}, fmt.Errorf("getting market balance for %s: %w", client, err)
+	}
+	return big.Sub(bal.Escrow, bal.Locked), nil
+}
+
+// addEscrow sends a message that adds amount to the client's market escrow
+func addEscrow(ctx context.Context, api lapi.Gateway, n *clinode.Node, client address.Address, amount abi.TokenAmount) (cid.Cid, error) {
+	params, aerr := actors.SerializeParams(&client)
+	if aerr != nil {
+		return cid.Undef, aerr
+	}
+	msg := &chain_types.Message{
+		To:     marketactor.Address,
+		From:   client,
+		Value:  amount,
+		Method: marketactor.Methods.AddBalance,
+		Params: params,
+	}
+
+	msg, err := api.GasEstimateMessageGas(ctx, msg, &lapi.MessageSendSpec{}, chain_types.EmptyTSK)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("estimating gas: %w", err)
+	}
+
+	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	signer := messagesigner.NewMessageSigner(n.Wallet, &modules.MpoolNonceAPI{ChainModule: api, StateModule: api}, ds)
+	smsg, err := signer.SignMessage(ctx, msg, func(*chain_types.SignedMessage) error { return nil })
+	if err != nil {
+		return cid.Undef, fmt.Errorf("signing message: %w", err)
+	}
+
+	msgCid, err := api.MpoolPush(ctx, smsg)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("mpool push: %w", err)
+	}
+	return msgCid, nil
+}
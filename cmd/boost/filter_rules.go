@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/dealfilter"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// How long the filter rules that a provider publishes are cached for
+const providerRulesTTL = time.Hour
+
+// providerRules checks deals against the filter rules that providers
+// publish before they are proposed, so that proposals that the provider
+// would reject aren't sent. Providers that don't publish rules (or whose
+// rules can't be fetched or evaluated) are sent every proposal.
+type providerRules struct {
+	dc *lp2pimpl.DealClient
+
+	lk    sync.Mutex
+	cache map[peer.ID]cachedProviderRules
+}
+
+type cachedProviderRules struct {
+	rules     *dealfilter.Rules
+	fetchedAt time.Time
+}
+
+func newProviderRules(dc *lp2pimpl.DealClient) *providerRules {
+	return &providerRules{dc: dc, cache: make(map[peer.ID]cachedProviderRules)}
+}
+
+// check returns the name of the provider's published rule that the deal
+// doesn't satisfy, or "" if the deal satisfies all the rules (or the
+// provider doesn't publish any)
+func (r *providerRules) check(ctx context.Context, id peer.ID, params types.DealParams, head abi.ChainEpoch) string {
+	rules := r.get(ctx, id)
+	if rules == nil {
+		return ""
+	}
+	ok, rule, err := rules.Eval(dealfilter.StorageDealVars(types.DealFilterParams{DealParams: &params, ChainHead: head}))
+	if err != nil {
+		log.Debugw("evaluating provider's published filter rules", "provider-peer", id, "err", err)
+		return ""
+	}
+	if !ok {
+		return rule
+	}
+	return ""
+}
+
+func (r *providerRules) get(ctx context.Context, id peer.ID) *dealfilter.Rules {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if c, ok := r.cache[id]; ok && time.Since(c.fetchedAt) < providerRulesTTL {
+		return c.rules
+	}
+
+	published, err := r.dc.SendProviderFilterRulesRequest(ctx, id)
+	if err != nil {
+		var protoErr *lp2pimpl.ProtocolNotSupportedError
+		if !errors.As(err, &protoErr) {
+			// Try again next time
+			log.Debugw("getting provider's published filter rules", "provider-peer", id, "err", err)
+			return nil
+		}
+		// The provider runs a version that doesn't publish rules
+		published = nil
+	}
+
+	var rules *dealfilter.Rules
+	if len(published) > 0 {
+		var skipped []string
+		rules, skipped = dealfilter.CompilePublishedRules(published)
+		if len(skipped) > 0 {
+			log.Infow("skipping provider's published filter rules that can't be evaluated",
+				"provider-peer", id, "rules", strings.Join(skipped, ", "))
+		}
+	}
+	r.cache[id] = cachedProviderRules{rules: rules, fetchedAt: time.Now()}
+	return rules
+}
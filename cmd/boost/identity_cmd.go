@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/google/uuid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// identityShowOutput is the output of the identity show command in json mode
+type identityShowOutput struct {
+	PeerID string `json:"peerId"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("identity show", identityShowOutput{})
+	cmd.RegisterJsonOutput("identity rotate", clinode.Rotation{})
+	cmd.RegisterJsonOutput("identity list", []clinode.Identity{})
+}
+
+var identityCmd = &cli.Command{
+	Name:  "identity",
+	Usage: "Manage the client's libp2p identity",
+	Description: "The libp2p identity (peer ID) is used to connect to storage providers. Rotating the identity " +
+		"replaces the key in the repo, and maps the old peer ID to the new one so that deals proposed with " +
+		"the old identity can still be matched to the client. Deals can also be made with an ephemeral " +
+		"identity (see the --ephemeral-identity flag of the deal commands) so that they can't be linked.",
+	Before: before,
+	Subcommands: []*cli.Command{
+		identityShowCmd,
+		identityRotateCmd,
+		identityListCmd,
+	},
+}
+
+var identityShowCmd = &cli.Command{
+	Name:   "show",
+	Usage:  "Show the client's current peer ID",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+		p, err := clinode.PeerID(sdir)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(identityShowOutput{PeerID: p.String()})
+		}
+		fmt.Println(p)
+		return nil
+	},
+}
+
+var identityRotateCmd = &cli.Command{
+	Name:   "rotate",
+	Usage:  "Replace the client's libp2p identity with a new one",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+		r, err := clinode.RotateIdentity(sdir)
+		if err != nil {
+			return fmt.Errorf("rotating identity: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(r)
+		}
+		fmt.Printf("rotated identity %s -> %s\n", r.Old, r.New)
+		fmt.Printf("the old key was moved to %s\n", r.RetiredKeyPath)
+		return nil
+	},
+}
+
+var identityListCmd = &cli.Command{
+	Name:   "list",
+	Usage:  "List the identities the client has used, and the identities that replaced them",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+		ids, err := clinode.LoadIdentities(sdir)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(ids.Identities)
+		}
+		if len(ids.Identities) == 0 {
+			fmt.Println("no identities have been rotated or used for deals")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "PEER ID\tCREATED\tRETIRED\tCURRENT\tEPHEMERAL\tDEALS\n")
+		for _, id := range ids.Identities {
+			retired := ""
+			if id.RetiredAt != nil {
+				retired = id.RetiredAt.Format(time.RFC3339)
+			}
+			current := ""
+			if id.ReplacedBy != "" {
+				current = ids.Current(id.PeerID).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%d\n", id.PeerID, id.CreatedAt.Format(time.RFC3339),
+				retired, current, id.Ephemeral, len(id.Deals))
+		}
+		return w.Flush()
+	},
+}
+
+// recordDealIdentity records which libp2p identity a deal was proposed with,
+// so that deals proposed with a rotated or ephemeral identity can be matched
+// to the client
+func recordDealIdentity(cctx *cli.Context, n *clinode.Node, dealUuid uuid.UUID) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		log.Warnw("recording deal identity", "uuid", dealUuid, "err", err)
+		return
+	}
+	ids, err := clinode.LoadIdentities(sdir)
+	if err == nil {
+		err = ids.RecordDeal(n.Host.ID(), n.Ephemeral, dealUuid)
+	}
+	if err != nil {
+		log.Warnw("recording deal identity", "uuid", dealUuid, "err", err)
+	}
+}
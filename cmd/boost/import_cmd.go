@@ -0,0 +1,612 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/boost/lib/dedupstore"
+	"github.com/filecoin-project/boost/lib/diskusage"
+	"github.com/filecoin-project/go-state-types/abi"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+var importCmd = &cli.Command{
+	Name:  "import",
+	Usage: "Manage data imported into the shared, deduplicated client blockstore",
+	Description: "Blocks that are shared by multiple imports (eg overlapping versions of a dataset) are only " +
+		"stored once. The CAR file for an import is written out on demand when making a deal. " +
+		"CAR files on read-only mounts (eg NFS or object storage FUSE mounts) can be imported without copying " +
+		"their blocks: see the mount command. " +
+		"The pack and unpack commands convert between files, CAR files and piece files. " +
+		"The plan command suggests how to split and merge imports into pieces with the least padding.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name: "check-interval",
+			Usage: "check that the blocks of each import are present, and that the CAR files written for imports " +
+				"are intact, if the last check was longer ago than this interval (0 to disable)",
+			Value: 24 * time.Hour,
+		},
+	},
+	Subcommands: []*cli.Command{
+		importAddCmd,
+		importListCmd,
+		importRemoveCmd,
+		importCarCmd,
+		importCheckCmd,
+		importDealsCmd,
+		importMountCmd,
+		importPackCmd,
+		importUnpackCmd,
+		importPlanCmd,
+	},
+}
+
+// importAddOutput is the output of the import add command in json mode
+type importAddOutput struct {
+	ID     uint64    `json:"id"`
+	Roots  []cid.Cid `json:"roots"`
+	Blocks int       `json:"blocks"`
+	Size   uint64    `json:"size"`
+}
+
+// importListOutput is the output of the import list command in json mode
+type importListOutput struct {
+	Imports []importListItem `json:"imports"`
+	Usage   dedupstore.Usage `json:"usage"`
+}
+
+type importListItem struct {
+	ID         uint64
+	Source     string
+	Roots      []string
+	Blocks     int
+	Size       uint64
+	Mount      string                 `json:",omitempty"`
+	Quarantine *dedupstore.Quarantine `json:",omitempty"`
+	Incomplete bool                   `json:",omitempty"`
+}
+
+// importCarOutput is the output of the import car command in json mode
+type importCarOutput struct {
+	Path       string              `json:"path,omitempty"`
+	PayloadCid string              `json:"payloadCid"`
+	CommP      string              `json:"commp"`
+	PieceSize  abi.PaddedPieceSize `json:"pieceSize"`
+	CarSize    int64               `json:"carSize"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("import add", importAddOutput{})
+	cmd.RegisterJsonOutput("import list", importListOutput{})
+	cmd.RegisterJsonOutput("import car", importCarOutput{})
+	cmd.RegisterJsonOutput("import check", dedupstore.CheckReport{})
+	cmd.RegisterJsonOutput("import deals", []dedupstore.ImportDeal{})
+	cmd.RegisterJsonOutput("import mount list", []dedupstore.Mount{})
+}
+
+var importAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "Import the blocks in a CAR file",
+	ArgsUsage: "<car path>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "mount",
+			Usage: "import the CAR file from the named read-only mount without copying its blocks " +
+				"(the car path is relative to the mount)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: import add <car path>")
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		var imp *dedupstore.Import
+		if cctx.IsSet("mount") {
+			imp, err = s.AddFromMount(ctx, cctx.String("mount"), cctx.Args().First())
+			if err != nil {
+				return err
+			}
+		} else {
+			carPath, err := filepath.Abs(cctx.Args().First())
+			if err != nil {
+				return err
+			}
+
+			// Deduplication may mean that fewer bytes are stored, but check
+			// that there's space for the whole CAR file
+			st, err := os.Stat(carPath)
+			if err != nil {
+				return err
+			}
+			sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+			if err != nil {
+				return err
+			}
+			if err := checkDiskSpace(cctx, diskusage.KindImport, filepath.Join(sdir, "dedupstore"), uint64(st.Size())); err != nil {
+				return err
+			}
+
+			imp, err = s.Add(ctx, carPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(importAddOutput{
+				ID:     imp.ID,
+				Roots:  imp.Roots,
+				Blocks: imp.Blocks,
+				Size:   imp.Size,
+			})
+		}
+		fmt.Printf("Import %d: %d blocks (%s)\n", imp.ID, imp.Blocks, humanize.IBytes(imp.Size))
+		return nil
+	},
+}
+
+var importListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List imports",
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		imps, err := s.List(ctx)
+		if err != nil {
+			return err
+		}
+		usage, err := s.Usage(ctx)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			out := make([]importListItem, 0, len(imps))
+			for _, imp := range imps {
+				roots := make([]string, 0, len(imp.Roots))
+				for _, r := range imp.Roots {
+					roots = append(roots, r.String())
+				}
+				item := importListItem{
+					ID:         imp.ID,
+					Source:     imp.Source,
+					Roots:      roots,
+					Blocks:     imp.Blocks,
+					Size:       imp.Size,
+					Quarantine: imp.Quarantine,
+					Incomplete: imp.Incomplete,
+				}
+				if imp.Mounted != nil {
+					item.Mount = imp.Mounted.Mount
+				}
+				out = append(out, item)
+			}
+			return cmd.PrintJson(importListOutput{
+				Imports: out,
+				Usage:   *usage,
+			})
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "ID\tRoots\tBlocks\tSize\tSource\tStatus\n")
+		for _, imp := range imps {
+			roots := make([]string, 0, len(imp.Roots))
+			for _, r := range imp.Roots {
+				roots = append(roots, r.String())
+			}
+			status := "ok"
+			if imp.Mounted != nil {
+				status = "ok (mount " + imp.Mounted.Mount + ")"
+			}
+			if imp.Quarantine != nil {
+				status = "quarantined: " + imp.Quarantine.Reason
+			}
+			if imp.Incomplete {
+				status = "incomplete (remove it and import it again)"
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\n",
+				imp.ID, strings.Join(roots, ","), imp.Blocks, humanize.IBytes(imp.Size), imp.Source, status)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("\nStored %d unique blocks (%s) for imports totalling %s\n",
+			usage.Blocks, humanize.IBytes(usage.Size), humanize.IBytes(usage.ImportsSize))
+		return nil
+	},
+}
+
+var importRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove an import, deleting any blocks that are not used by other imports",
+	ArgsUsage: "<import id>",
+	Action: func(cctx *cli.Context) error {
+		id, err := importIDArg(cctx)
+		if err != nil {
+			return err
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return s.Remove(ctx, id)
+	},
+}
+
+var importCarCmd = &cli.Command{
+	Name:      "car",
+	Usage:     "Write the CAR file for an import, and output the parameters needed to make a deal with it",
+	ArgsUsage: "<import id> [output car path]",
+	Description: "The output path may be left out for an import from a mount: the deal parameters are " +
+		"calculated by reading the CAR file directly from the mount, and the deal's data can be served " +
+		"from the mount with deal --serve-import.",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 && cctx.Args().Len() != 2 {
+			return fmt.Errorf("usage: import car <import id> [output car path]")
+		}
+		id, err := importIDArg(cctx)
+		if err != nil {
+			return err
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		imp, err := s.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if len(imp.Roots) == 0 {
+			return fmt.Errorf("import %d has no root cid", id)
+		}
+
+		outPath := cctx.Args().Get(1)
+		if outPath == "" && imp.Mounted == nil {
+			return fmt.Errorf("an output car path must be set for import %d, which is not from a mount", id)
+		}
+		out := io.Discard
+		if outPath != "" {
+			f, err := os.Create(outPath)
+			if err != nil {
+				return fmt.Errorf("creating %s: %w", outPath, err)
+			}
+			defer f.Close() //nolint:errcheck
+			out = f
+		}
+
+		// Calculate commp while writing out the CAR file
+		pr, pw := io.Pipe()
+		defer pr.Close() //nolint:errcheck
+		cw := &countWriter{}
+		go func() {
+			_ = pw.CloseWithError(s.WriteCar(ctx, id, io.MultiWriter(out, pw, cw)))
+		}()
+		pi, err := commp.Default().Sum(ctx, pr)
+		if err != nil {
+			return fmt.Errorf("writing CAR file for import %d: %w", id, err)
+		}
+
+		// Record the CAR file so that checks verify that it is intact until
+		// the deal's data has been transferred
+		if outPath != "" {
+			carFile := dedupstore.CarFile{Path: outPath, Size: cw.n, PieceCid: pi.PieceCID, WrittenAt: time.Now()}
+			if abs, err := filepath.Abs(outPath); err == nil {
+				carFile.Path = abs
+			}
+			if err := s.RecordCar(ctx, id, carFile); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(importCarOutput{
+				Path:       outPath,
+				PayloadCid: imp.Roots[0].String(),
+				CommP:      pi.PieceCID.String(),
+				PieceSize:  pi.Size,
+				CarSize:    cw.n,
+			})
+		}
+		if outPath != "" {
+			fmt.Printf("Wrote %s\n", outPath)
+		} else {
+			fmt.Printf("Import %d is served from mount %s\n", id, imp.Mounted.Mount)
+		}
+		fmt.Printf("  payload cid: %s\n", imp.Roots[0])
+		fmt.Printf("  commp: %s\n", pi.PieceCID)
+		fmt.Printf("  piece size: %d\n", pi.Size)
+		fmt.Printf("  car size: %d\n", cw.n)
+		return nil
+	},
+}
+
+var importCheckCmd = &cli.Command{
+	Name:  "check",
+	Usage: "Check the integrity of imports, and of the CAR files written for them",
+	Description: "Imports with missing or corrupt blocks are quarantined: their CAR file cannot be written " +
+		"until a later check finds that they are intact. CAR files that are missing, truncated or corrupt " +
+		"can be written again with the import car command. Pending deals (see import deals) whose data is " +
+		"damaged are quarantined too, and are released once a later check finds that their data is intact.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "full",
+			Usage: "rehash every block and recalculate the commp of every CAR file",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		report, err := s.Check(ctx, dedupstore.CheckOptions{Full: cctx.Bool("full"), OnDamage: logImportDamage})
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(report)
+		}
+		fmt.Printf("Checked %d imports (%d blocks) and %d CAR files\n", report.Imports, report.Blocks, report.Cars)
+		for _, d := range report.Damaged {
+			fmt.Printf("Import %d:\n", d.ImportID)
+			if len(d.MissingBlocks) > 0 || len(d.CorruptBlocks) > 0 {
+				fmt.Printf("  %d missing and %d corrupt blocks (quarantined)\n", len(d.MissingBlocks), len(d.CorruptBlocks))
+			}
+			if d.MountedCar != "" {
+				fmt.Printf("  CAR file on mount is %s (quarantined)\n", d.MountedCar)
+			}
+			if d.MountUnavailable {
+				fmt.Printf("  mount is unavailable\n")
+			}
+			for _, car := range d.Cars {
+				fmt.Printf("  CAR file %s is %s\n", car.Path, car.Problem)
+			}
+			for _, id := range d.QuarantinedDeals {
+				fmt.Printf("  deal %s is quarantined\n", id)
+			}
+		}
+		for _, id := range report.Released {
+			fmt.Printf("Import %d is intact and was released from quarantine\n", id)
+		}
+		for _, id := range report.ReleasedDeals {
+			fmt.Printf("Deal %s is intact and was released from quarantine\n", id)
+		}
+		if len(report.Damaged) == 0 {
+			fmt.Println("No damage found")
+		}
+		return nil
+	},
+}
+
+var importDealsCmd = &cli.Command{
+	Name:  "deals",
+	Usage: "List the pending deals whose data is served from an import",
+	Description: "A deal made with deal --serve-car (with a CAR file written by import car) or deal --serve-import " +
+		"is pending until the provider has downloaded its data. Checks quarantine pending deals whose data " +
+		"is damaged.",
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		deals, err := s.Deals(ctx)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			if deals == nil {
+				deals = []dedupstore.ImportDeal{}
+			}
+			return cmd.PrintJson(deals)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Deal\tImport\tProvider\tPiece CID\tProposed\tStatus\n")
+		for _, d := range deals {
+			status := "ok"
+			if d.Quarantine != nil {
+				status = "quarantined: " + d.Quarantine.Reason
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+				d.UUID, d.ImportID, d.Provider, d.PieceCid, d.ProposedAt.Format(time.RFC3339), status)
+		}
+		return w.Flush()
+	},
+}
+
+var importMountCmd = &cli.Command{
+	Name:  "mount",
+	Usage: "Manage read-only mounts of CAR files that are imported without copying",
+	Description: "A mount is a directory of CAR files on read-only storage, eg an NFS export or an object " +
+		"storage FUSE mount. CAR files imported from a mount with import add --mount are indexed but their " +
+		"blocks are not copied: they are read directly from the mount, which is checked for availability " +
+		"before a transfer starts.",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Register a mount",
+			ArgsUsage: "<name> <path>",
+			Action: func(cctx *cli.Context) error {
+				if cctx.Args().Len() != 2 {
+					return fmt.Errorf("usage: import mount add <name> <path>")
+				}
+
+				ctx := lcli.ReqContext(cctx)
+				s, closer, err := openDedupStore(cctx)
+				if err != nil {
+					return err
+				}
+				defer closer()
+
+				m, err := s.AddMount(ctx, cctx.Args().Get(0), cctx.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Added mount %s at %s\n", m.Name, m.Path)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "List mounts, and check that they are available",
+			Action: func(cctx *cli.Context) error {
+				ctx := lcli.ReqContext(cctx)
+				s, closer, err := openDedupStore(cctx)
+				if err != nil {
+					return err
+				}
+				defer closer()
+
+				mounts, err := s.Mounts(ctx)
+				if err != nil {
+					return err
+				}
+				if cctx.Bool("json") {
+					return cmd.PrintJson(mounts)
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+				_, _ = fmt.Fprintf(w, "Name\tPath\tStatus\n")
+				for i := range mounts {
+					status := "available"
+					if err := dedupstore.CheckMount(ctx, &mounts[i]); err != nil {
+						status = err.Error()
+					}
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", mounts[i].Name, mounts[i].Path, status)
+				}
+				return w.Flush()
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "Remove a mount that no imports use",
+			ArgsUsage: "<name>",
+			Action: func(cctx *cli.Context) error {
+				if cctx.Args().Len() != 1 {
+					return fmt.Errorf("usage: import mount remove <name>")
+				}
+
+				ctx := lcli.ReqContext(cctx)
+				s, closer, err := openDedupStore(cctx)
+				if err != nil {
+					return err
+				}
+				defer closer()
+
+				return s.RemoveMount(ctx, cctx.Args().First())
+			},
+		},
+	},
+}
+
+func openDedupStore(cctx *cli.Context) (*dedupstore.Store, func(), error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ds, err := levelds.NewDatastore(filepath.Join(sdir, "dedupstore"), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening deduplicated blockstore: %w", err)
+	}
+	s := dedupstore.New(ds)
+	closer := func() { _ = ds.Close() }
+
+	// Run a quick check on startup if the last check was a while ago (the
+	// check command runs its own check)
+	if interval := cctx.Duration("check-interval"); interval > 0 && cctx.Command.Name != "check" {
+		ctx := lcli.ReqContext(cctx)
+		last, err := s.LastCheck(ctx)
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+		if time.Since(last) > interval {
+			log.Infow("checking imports", "last-check", last)
+			if _, err := s.Check(ctx, dedupstore.CheckOptions{OnDamage: logImportDamage}); err != nil {
+				closer()
+				return nil, nil, fmt.Errorf("checking imports: %w", err)
+			}
+		}
+	}
+	return s, closer, nil
+}
+
+func logImportDamage(d dedupstore.Damage) {
+	if d.Quarantined {
+		log.Warnw("import quarantined", "id", d.ImportID, "missing-blocks", len(d.MissingBlocks), "corrupt-blocks", len(d.CorruptBlocks),
+			"mounted-car", d.MountedCar)
+	}
+	if d.MountUnavailable {
+		log.Warnw("mount of import is unavailable", "id", d.ImportID)
+	}
+	for _, car := range d.Cars {
+		log.Warnw("CAR file for import is damaged: write it again with import car",
+			"id", d.ImportID, "path", car.Path, "problem", car.Problem)
+	}
+	for _, id := range d.QuarantinedDeals {
+		log.Warnw("deal quarantined: the data it is served from is damaged", "id", d.ImportID, "deal", id)
+	}
+}
+
+func importIDArg(cctx *cli.Context) (uint64, error) {
+	if !cctx.Args().Present() {
+		return 0, fmt.Errorf("must specify import id")
+	}
+	id, err := strconv.ParseUint(cctx.Args().First(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing import id '%s': %w", cctx.Args().First(), err)
+	}
+	return id, nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
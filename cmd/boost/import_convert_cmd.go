@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/convert"
+	"github.com/filecoin-project/go-state-types/abi"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+)
+
+// importPackOutput is the output of the import pack command in json mode
+type importPackOutput struct {
+	PayloadCid string              `json:"payloadCid"`
+	CarPath    string              `json:"carPath,omitempty"`
+	CarSize    uint64              `json:"carSize"`
+	PiecePath  string              `json:"piecePath,omitempty"`
+	CommP      string              `json:"commp,omitempty"`
+	PieceSize  abi.PaddedPieceSize `json:"pieceSize,omitempty"`
+}
+
+// importUnpackOutput is the output of the import unpack command in json mode
+type importUnpackOutput struct {
+	CarPath    string `json:"carPath,omitempty"`
+	CarSize    uint64 `json:"carSize"`
+	FilesPath  string `json:"filesPath,omitempty"`
+	PayloadCid string `json:"payloadCid,omitempty"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("import pack", importPackOutput{})
+	cmd.RegisterJsonOutput("import unpack", importUnpackOutput{})
+}
+
+var importPackCmd = &cli.Command{
+	Name:      "pack",
+	Usage:     "Pack a file or directory into a CAR file and / or a piece file",
+	ArgsUsage: "<input path>",
+	Description: "The input is packed into a unixfs DAG and written as a CARv1 file. If --piece is set, the CAR " +
+		"file is also written as a piece file (the CAR file zero-padded to the piece size) and its commp is " +
+		"calculated. If --car is not set the CAR file is written to a temporary file that is removed afterwards.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "car",
+			Usage: "the path to write the CAR file to",
+		},
+		&cli.StringFlag{
+			Name:  "piece",
+			Usage: "the path to write the piece file to",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: import pack [--car <path>] [--piece <path>] <input path>")
+		}
+		if cctx.String("car") == "" && cctx.String("piece") == "" {
+			return fmt.Errorf("must set at least one of --car and --piece")
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		carPath, cleanup, err := convertCarPath(cctx)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		p := newConvertProgress(cctx, "packing files")
+		root, err := convert.FilesToCar(ctx, cctx.Args().First(), carPath, p.update)
+		p.finish()
+		if err != nil {
+			return err
+		}
+		st, err := os.Stat(carPath)
+		if err != nil {
+			return err
+		}
+		out := importPackOutput{
+			PayloadCid: root.String(),
+			CarPath:    cctx.String("car"),
+			CarSize:    uint64(st.Size()),
+		}
+
+		if piecePath := cctx.String("piece"); piecePath != "" {
+			pi, err := writePiece(cctx, carPath, out.CarSize, piecePath)
+			if err != nil {
+				return err
+			}
+			out.PiecePath = piecePath
+			out.CommP = pi.PieceCid
+			out.PieceSize = pi.PieceSize
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(out)
+		}
+		fmt.Printf("Packed %s\n", cctx.Args().First())
+		fmt.Printf("  payload cid: %s\n", out.PayloadCid)
+		if out.CarPath != "" {
+			fmt.Printf("  car: %s\n", out.CarPath)
+		}
+		fmt.Printf("  car size: %d\n", out.CarSize)
+		if out.PiecePath != "" {
+			fmt.Printf("  piece: %s\n", out.PiecePath)
+			fmt.Printf("  commp: %s\n", out.CommP)
+			fmt.Printf("  piece size: %d\n", out.PieceSize)
+		}
+		return nil
+	},
+}
+
+var importUnpackCmd = &cli.Command{
+	Name:      "unpack",
+	Usage:     "Unpack a piece file into a CAR file and / or the files it contains",
+	ArgsUsage: "<piece path>",
+	Description: "The CAR file is streamed out of the piece file, stopping at the zero padding that fills the " +
+		"piece. If --files is set, the unixfs file or directory at the root of the CAR file is extracted. If " +
+		"--car is not set the CAR file is written to a temporary file that is removed afterwards.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "car",
+			Usage: "the path to write the CAR file to",
+		},
+		&cli.StringFlag{
+			Name:  "files",
+			Usage: "the path to extract the files to",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: import unpack [--car <path>] [--files <path>] <piece path>")
+		}
+		if cctx.String("car") == "" && cctx.String("files") == "" {
+			return fmt.Errorf("must set at least one of --car and --files")
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		piece, err := os.Open(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		defer piece.Close() //nolint:errcheck
+		st, err := piece.Stat()
+		if err != nil {
+			return err
+		}
+
+		carPath, cleanup, err := convertCarPath(cctx)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		car, err := os.Create(carPath)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", carPath, err)
+		}
+		p := newConvertProgress(cctx, "unpacking CAR file")
+		carSize, err := convert.PieceToCar(ctx, piece, uint64(st.Size()), car, p.update)
+		p.finish()
+		if cerr := car.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		out := importUnpackOutput{CarPath: cctx.String("car"), CarSize: carSize}
+
+		if filesPath := cctx.String("files"); filesPath != "" {
+			p := newConvertProgress(cctx, "extracting files")
+			root, err := convert.CarToFiles(ctx, carPath, filesPath, p.update)
+			p.finish()
+			if err != nil {
+				return err
+			}
+			out.FilesPath = filesPath
+			out.PayloadCid = root.String()
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(out)
+		}
+		fmt.Printf("Unpacked %s\n", cctx.Args().First())
+		if out.CarPath != "" {
+			fmt.Printf("  car: %s\n", out.CarPath)
+		}
+		fmt.Printf("  car size: %d\n", out.CarSize)
+		if out.FilesPath != "" {
+			fmt.Printf("  files: %s\n", out.FilesPath)
+			fmt.Printf("  payload cid: %s\n", out.PayloadCid)
+		}
+		return nil
+	},
+}
+
+// writePiece writes the CAR file at carPath as a piece file
+func writePiece(cctx *cli.Context, carPath string, carSize uint64, piecePath string) (*convert.PieceInfo, error) {
+	car, err := os.Open(carPath)
+	if err != nil {
+		return nil, err
+	}
+	defer car.Close() //nolint:errcheck
+
+	piece, err := os.Create(piecePath)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", piecePath, err)
+	}
+	p := newConvertProgress(cctx, "writing piece")
+	pi, err := convert.CarToPiece(lcli.ReqContext(cctx), car, carSize, piece, p.update)
+	p.finish()
+	if cerr := piece.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pi, nil
+}
+
+// convertCarPath returns the --car path, or the path of a temporary file if
+// it is not set. The cleanup function removes the temporary file.
+func convertCarPath(cctx *cli.Context) (string, func(), error) {
+	if p := cctx.String("car"); p != "" {
+		return p, func() {}, nil
+	}
+	f, err := os.CreateTemp(os.TempDir(), "boost-convert-*.car")
+	if err != nil {
+		return "", nil, fmt.Errorf("creating temporary CAR file: %w", err)
+	}
+	_ = f.Close()
+	return f.Name(), func() { _ = os.Remove(f.Name()) }, nil
+}
+
+// convertProgress prints the progress of a conversion to stderr at most once
+// a second. Nothing is printed in json mode.
+type convertProgress struct {
+	label   string
+	enabled bool
+
+	lk      sync.Mutex
+	printed time.Time
+	done    uint64
+	total   uint64
+}
+
+func newConvertProgress(cctx *cli.Context, label string) *convertProgress {
+	return &convertProgress{label: label, enabled: !cctx.Bool("json")}
+}
+
+func (p *convertProgress) update(done, total uint64) {
+	if !p.enabled {
+		return
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.done, p.total = done, total
+	if time.Since(p.printed) < time.Second {
+		return
+	}
+	p.printed = time.Now()
+	p.print()
+}
+
+func (p *convertProgress) print() {
+	if p.total > 0 {
+		fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%.1f%%)", p.label,
+			humanize.IBytes(p.done), humanize.IBytes(p.total), 100*float64(p.done)/float64(p.total))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%s: %s", p.label, humanize.IBytes(p.done))
+}
+
+// finish prints the final progress and ends the progress line
+func (p *convertProgress) finish() {
+	if !p.enabled {
+		return
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if p.printed.IsZero() && p.done == 0 {
+		return
+	}
+	p.print()
+	fmt.Fprintln(os.Stderr)
+}
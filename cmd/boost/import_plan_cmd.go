@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/boost/lib/dedupstore"
+	"github.com/filecoin-project/boost/lib/pieceplan"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+// importPlanOutput is the output of the import plan command in json mode
+type importPlanOutput struct {
+	Plan *pieceplan.Plan `json:"plan"`
+	// The CAR files written for the pieces, if the plan was applied
+	Cars []importPlanCar `json:"cars,omitempty"`
+}
+
+type importPlanCar struct {
+	Path       string              `json:"path"`
+	PayloadCid string              `json:"payloadCid"`
+	CommP      string              `json:"commp"`
+	PieceSize  abi.PaddedPieceSize `json:"pieceSize"`
+	CarSize    int64               `json:"carSize"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("import plan", importPlanOutput{})
+}
+
+var importPlanCmd = &cli.Command{
+	Name:      "plan",
+	Usage:     "Suggest how to split and merge imports into pieces that fit the providers' piece sizes with the least padding",
+	ArgsUsage: "<import id>...",
+	Description: "The piece size limits are the most restrictive of the storage asks and sector sizes of the " +
+		"providers, and of the piece size flags. Imports that are larger than the maximum piece size are split " +
+		"at block boundaries, smaller imports are merged into the same piece, and pieces that are only just over " +
+		"half full are split in two if that uses less space. The plan is only printed, unless --apply is set: " +
+		"then a CAR file is written for each piece of the plan, and the parameters needed to make a deal with it " +
+		"are printed.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "provider",
+			Usage: "a provider that deals will be made with (can be repeated)",
+		},
+		&cli.StringFlag{
+			Name:  "min-piece-size",
+			Usage: "the minimum piece size, eg '1GiB'",
+		},
+		&cli.StringFlag{
+			Name:  "max-piece-size",
+			Usage: "the maximum piece size, eg '32GiB'",
+		},
+		&cli.StringFlag{
+			Name:  "sector-size",
+			Usage: "the sector size that pieces must fit in, eg '32GiB' (defaults to the providers' sector size)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-split",
+			Usage: "don't split imports across pieces",
+		},
+		&cli.StringFlag{
+			Name:  "apply",
+			Usage: "write the CAR file for each piece of the plan to this directory",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() {
+			return fmt.Errorf("usage: import plan <import id>...")
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		cs, err := planConstraints(cctx)
+		if err != nil {
+			return err
+		}
+
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		var inputs []pieceplan.Input
+		imps := make(map[uint64]*dedupstore.Import)
+		for _, arg := range cctx.Args().Slice() {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("parsing import id '%s': %w", arg, err)
+			}
+			imp, err := s.Get(ctx, id)
+			if err != nil {
+				return fmt.Errorf("getting import %d: %w", id, err)
+			}
+			sizes, err := s.BlockSizes(ctx, id)
+			if err != nil {
+				return fmt.Errorf("getting block sizes of import %d: %w", id, err)
+			}
+			in := pieceplan.Input{ID: id, Blocks: sizes}
+			for _, size := range sizes {
+				in.Size += size
+			}
+			inputs = append(inputs, in)
+			imps[id] = imp
+		}
+
+		plan, err := pieceplan.Suggest(inputs, cs, pieceplan.Options{NoSplit: cctx.Bool("no-split")})
+		if err != nil {
+			return err
+		}
+		out := importPlanOutput{Plan: plan}
+
+		if dir := cctx.String("apply"); dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("creating %s: %w", dir, err)
+			}
+			for i, pc := range plan.Pieces {
+				car, err := writePlanCar(cctx, s, imps, pc, filepath.Join(dir, fmt.Sprintf("piece-%d.car", i)))
+				if err != nil {
+					return fmt.Errorf("writing CAR file for piece %d: %w", i, err)
+				}
+				out.Cars = append(out.Cars, *car)
+			}
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(out)
+		}
+		printPlan(plan)
+		for i, car := range out.Cars {
+			fmt.Printf("Wrote piece %d to %s\n", i, car.Path)
+			fmt.Printf("  payload cid: %s\n", car.PayloadCid)
+			fmt.Printf("  commp: %s\n", car.CommP)
+			fmt.Printf("  piece size: %d\n", car.PieceSize)
+			fmt.Printf("  car size: %d\n", car.CarSize)
+		}
+		return nil
+	},
+}
+
+// planConstraints combines the piece size limits of the providers and of
+// the flags
+func planConstraints(cctx *cli.Context) (pieceplan.Constraints, error) {
+	var cs []pieceplan.Constraints
+	var sectorSize abi.SectorSize
+	if providers := cctx.StringSlice("provider"); len(providers) > 0 {
+		ctx := lcli.ReqContext(cctx)
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return pieceplan.Constraints{}, err
+		}
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return pieceplan.Constraints{}, fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		for _, p := range providers {
+			maddr, err := address.NewFromString(p)
+			if err != nil {
+				return pieceplan.Constraints{}, fmt.Errorf("parsing provider address %s: %w", p, err)
+			}
+			ask, err := queryStorageAsk(ctx, n, api, maddr)
+			if err != nil {
+				return pieceplan.Constraints{}, fmt.Errorf("getting storage ask of %s: %w", maddr, err)
+			}
+			cs = append(cs, pieceplan.Constraints{MinPieceSize: ask.MinPieceSize, MaxPieceSize: ask.MaxPieceSize})
+
+			mi, err := api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+			if err != nil {
+				return pieceplan.Constraints{}, fmt.Errorf("getting miner info of %s: %w", maddr, err)
+			}
+			if sectorSize == 0 || mi.SectorSize < sectorSize {
+				sectorSize = mi.SectorSize
+			}
+		}
+	}
+
+	var flags pieceplan.Constraints
+	for name, size := range map[string]*abi.PaddedPieceSize{"min-piece-size": &flags.MinPieceSize, "max-piece-size": &flags.MaxPieceSize} {
+		if !cctx.IsSet(name) {
+			continue
+		}
+		v, err := humanize.ParseBytes(cctx.String(name))
+		if err != nil {
+			return pieceplan.Constraints{}, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		*size = abi.PaddedPieceSize(v)
+	}
+	if cctx.IsSet("sector-size") {
+		v, err := humanize.ParseBytes(cctx.String("sector-size"))
+		if err != nil {
+			return pieceplan.Constraints{}, fmt.Errorf("parsing sector-size: %w", err)
+		}
+		sectorSize = abi.SectorSize(v)
+	}
+	cs = append(cs, flags)
+	if sectorSize != 0 {
+		cs = append(cs, pieceplan.Constraints{MaxPieceSize: abi.PaddedPieceSize(sectorSize)})
+	}
+
+	c, err := pieceplan.Combine(cs...)
+	if err != nil {
+		return pieceplan.Constraints{}, fmt.Errorf("piece size limits: %w", err)
+	}
+	return c, nil
+}
+
+func printPlan(plan *pieceplan.Plan) {
+	fmt.Printf("Piece sizes from %s to %s\n",
+		humanize.IBytes(uint64(plan.Constraints.MinPieceSize)), humanize.IBytes(uint64(plan.Constraints.MaxPieceSize)))
+	w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Piece\tPiece Size\tPayload\tPadding\tImports\n")
+	for i, pc := range plan.Pieces {
+		var parts string
+		for j, part := range pc.Parts {
+			if j > 0 {
+				parts += ", "
+			}
+			parts += strconv.FormatUint(part.InputID, 10)
+			if !part.Whole {
+				parts += fmt.Sprintf(" (blocks %d-%d)", part.FirstBlock, part.FirstBlock+part.Blocks-1)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i, humanize.IBytes(uint64(pc.PieceSize)),
+			humanize.IBytes(pc.PayloadSize), humanize.IBytes(pc.Padding), parts)
+	}
+	_ = w.Flush()
+	fmt.Printf("\n%d pieces totalling %s for %s of data (%s padding, %.1f%% used)",
+		len(plan.Pieces), humanize.IBytes(plan.PieceSize), humanize.IBytes(plan.PayloadSize), humanize.IBytes(plan.Padding),
+		100*float64(plan.PayloadSize)/float64(plan.PieceSize))
+	if plan.SplitInputs > 0 {
+		fmt.Printf(", %d imports split across pieces", plan.SplitInputs)
+	}
+	fmt.Println()
+}
+
+// writePlanCar writes the CAR file for a piece of the plan, and calculates
+// its commp. The roots of the CAR file are the roots of the imports in the
+// piece whose root block is in the piece, or the first block of the piece
+// if there are none.
+func writePlanCar(cctx *cli.Context, s *dedupstore.Store, imps map[uint64]*dedupstore.Import, pc pieceplan.Piece, path string) (*importPlanCar, error) {
+	ctx := lcli.ReqContext(cctx)
+
+	var roots []cid.Cid
+	var ranges []dedupstore.BlockRange
+	firstBlock := cid.Undef
+	for _, part := range pc.Parts {
+		imp := imps[part.InputID]
+		ranges = append(ranges, dedupstore.BlockRange{ImportID: imp.ID, First: part.FirstBlock, Count: part.Blocks})
+		cids, err := s.CidRange(ctx, imp.ID, part.FirstBlock, part.Blocks)
+		if err != nil {
+			return nil, err
+		}
+		if !firstBlock.Defined() && len(cids) > 0 {
+			firstBlock = cids[0]
+		}
+		inPart := make(map[cid.Cid]struct{}, part.Blocks)
+		for _, c := range cids {
+			inPart[c] = struct{}{}
+		}
+		for _, r := range imp.Roots {
+			if _, ok := inPart[r]; ok {
+				roots = append(roots, r)
+			}
+		}
+	}
+	if len(roots) == 0 {
+		roots = []cid.Cid{firstBlock}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	// Calculate commp while writing out the CAR file
+	pr, pw := io.Pipe()
+	defer pr.Close() //nolint:errcheck
+	cw := &countWriter{}
+	go func() {
+		_ = pw.CloseWithError(s.WriteCarParts(ctx, roots, ranges, io.MultiWriter(f, pw, cw)))
+	}()
+	pi, err := commp.Default().Sum(ctx, pr)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return &importPlanCar{
+		Path:       path,
+		PayloadCid: roots[0].String(),
+		CommP:      pi.PieceCID.String(),
+		PieceSize:  pi.Size,
+		CarSize:    cw.n,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/ipfs/go-cid"
+)
+
+// The label modes determine what is written to the deal proposal label,
+// which is stored on chain
+const (
+	// The payload (root) CID of the CAR file
+	LabelModePayloadCid = "payload-cid"
+	// A hash of a salt and the payload CID. The client can later prove the
+	// deal is for the payload by revealing the salt.
+	LabelModeSaltedHash = "salted-hash"
+	// A random identifier with no relation to the payload
+	LabelModeOpaque = "opaque"
+	// An empty label
+	LabelModeEmpty = "empty"
+)
+
+var labelModes = []string{LabelModePayloadCid, LabelModeSaltedHash, LabelModeOpaque, LabelModeEmpty}
+
+// dealLabelParams is the output of creating a deal label
+type dealLabelParams struct {
+	Label market.DealLabel
+	// The salt used to create a salted-hash label (hex encoded)
+	Salt string
+}
+
+// dealLabel creates a deal proposal label for the root cid according to the
+// label mode.
+// For the salted-hash mode, saltHex is the hex-encoded salt; if it is empty
+// a random salt is generated.
+func dealLabel(mode string, rootCid cid.Cid, saltHex string) (*dealLabelParams, error) {
+	switch mode {
+	case LabelModePayloadCid, "":
+		l, err := market.NewLabelFromString(rootCid.String())
+		if err != nil {
+			return nil, err
+		}
+		return &dealLabelParams{Label: l}, nil
+
+	case LabelModeSaltedHash:
+		var salt []byte
+		if saltHex == "" {
+			salt = make([]byte, 32)
+			if _, err := rand.Read(salt); err != nil {
+				return nil, fmt.Errorf("generating label salt: %w", err)
+			}
+		} else {
+			var err error
+			salt, err = hex.DecodeString(saltHex)
+			if err != nil {
+				return nil, fmt.Errorf("parsing label salt %s: %w", saltHex, err)
+			}
+		}
+		l, err := market.NewLabelFromString(saltedLabelHash(salt, rootCid))
+		if err != nil {
+			return nil, err
+		}
+		return &dealLabelParams{Label: l, Salt: hex.EncodeToString(salt)}, nil
+
+	case LabelModeOpaque:
+		id := make([]byte, 32)
+		if _, err := rand.Read(id); err != nil {
+			return nil, fmt.Errorf("generating opaque label: %w", err)
+		}
+		l, err := market.NewLabelFromString(hex.EncodeToString(id))
+		if err != nil {
+			return nil, err
+		}
+		return &dealLabelParams{Label: l}, nil
+
+	case LabelModeEmpty:
+		return &dealLabelParams{Label: market.EmptyDealLabel}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized label mode '%s': must be one of %s", mode, labelModes)
+}
+
+// saltedLabelHash is the hex-encoded sha256 hash of the salt followed by
+// the bytes of the root cid
+func saltedLabelHash(salt []byte, rootCid cid.Cid) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(rootCid.Bytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealLabel(t *testing.T) {
+	root := testCid(t, "root")
+	salt := strings.Repeat("ab", 16)
+
+	// An identity cid embeds its data, so its string is longer than the
+	// maximum label size
+	mh, err := multihash.Sum([]byte(strings.Repeat("x", 200)), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	longCid := cid.NewCidV1(cid.Raw, mh)
+	require.Greater(t, len(longCid.String()), market.DealMaxLabelSize)
+
+	testCases := []struct {
+		name    string
+		mode    string
+		root    cid.Cid
+		salt    string
+		label   string
+		withErr string
+	}{{
+		name:  "payload cid",
+		mode:  LabelModePayloadCid,
+		root:  root,
+		label: root.String(),
+	}, {
+		name:  "default mode is payload cid",
+		mode:  "",
+		root:  root,
+		label: root.String(),
+	}, {
+		name:  "salted hash with salt",
+		mode:  LabelModeSaltedHash,
+		root:  root,
+		salt:  salt,
+		label: saltedLabelHash(mustDecodeHex(t, salt), root),
+	}, {
+		name:    "salted hash with invalid salt",
+		mode:    LabelModeSaltedHash,
+		root:    root,
+		salt:    "not hex",
+		withErr: "parsing label salt",
+	}, {
+		name:  "empty",
+		mode:  LabelModeEmpty,
+		root:  root,
+		label: "",
+	}, {
+		name:    "unknown mode",
+		mode:    "plaintext",
+		root:    root,
+		withErr: "unrecognized label mode 'plaintext'",
+	}, {
+		name:    "payload cid longer than the label size limit",
+		mode:    LabelModePayloadCid,
+		root:    longCid,
+		withErr: "too large to be a label",
+	}, {
+		// The hash has a fixed size however long the cid is
+		name:  "salted hash of long payload cid",
+		mode:  LabelModeSaltedHash,
+		root:  longCid,
+		salt:  salt,
+		label: saltedLabelHash(mustDecodeHex(t, salt), longCid),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params, err := dealLabel(tc.mode, tc.root, tc.salt)
+			if tc.withErr != "" {
+				require.ErrorContains(t, err, tc.withErr)
+				return
+			}
+			require.NoError(t, err)
+
+			label, err := params.Label.ToString()
+			require.NoError(t, err)
+			require.Equal(t, tc.label, label)
+			require.LessOrEqual(t, params.Label.Length(), market.DealMaxLabelSize)
+			if tc.mode == LabelModeSaltedHash {
+				require.Equal(t, tc.salt, params.Salt)
+			} else {
+				require.Empty(t, params.Salt)
+			}
+		})
+	}
+}
+
+func TestDealLabelRandom(t *testing.T) {
+	root := testCid(t, "root")
+
+	// Without a salt, a random salt is generated and returned, so that the
+	// client can later prove that the label is for the payload
+	p1, err := dealLabel(LabelModeSaltedHash, root, "")
+	require.NoError(t, err)
+	p2, err := dealLabel(LabelModeSaltedHash, root, "")
+	require.NoError(t, err)
+	require.Len(t, p1.Salt, 64)
+	require.NotEqual(t, p1.Salt, p2.Salt)
+	l1, err := p1.Label.ToString()
+	require.NoError(t, err)
+	require.Equal(t, saltedLabelHash(mustDecodeHex(t, p1.Salt), root), l1)
+	l2, err := p2.Label.ToString()
+	require.NoError(t, err)
+	require.NotEqual(t, l1, l2)
+
+	// Opaque labels are random and don't reveal the payload
+	o1, err := dealLabel(LabelModeOpaque, root, "")
+	require.NoError(t, err)
+	o2, err := dealLabel(LabelModeOpaque, root, "")
+	require.NoError(t, err)
+	ol1, err := o1.Label.ToString()
+	require.NoError(t, err)
+	ol2, err := o2.Label.ToString()
+	require.NoError(t, err)
+	require.Len(t, ol1, 64)
+	require.NotEqual(t, ol1, ol2)
+	require.NotContains(t, ol1, root.String())
+	require.Empty(t, o1.Salt)
+}
+
+func TestSaltedLabelHash(t *testing.T) {
+	root := testCid(t, "root")
+	other := testCid(t, "other")
+
+	testCases := []struct {
+		name string
+		salt []byte
+		root cid.Cid
+	}{{
+		name: "no salt",
+		root: root,
+	}, {
+		name: "salt",
+		salt: []byte("salt"),
+		root: root,
+	}, {
+		name: "other root",
+		salt: []byte("salt"),
+		root: other,
+	}}
+
+	seen := make(map[string]string)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The hash is sha256(salt || root cid bytes)
+			expected := sha256.Sum256(append(append([]byte{}, tc.salt...), tc.root.Bytes()...))
+			h := saltedLabelHash(tc.salt, tc.root)
+			require.Equal(t, hex.EncodeToString(expected[:]), h)
+			require.Equal(t, h, saltedLabelHash(tc.salt, tc.root))
+
+			// Each salt and root gives a different hash
+			require.NotContains(t, seen, h)
+			seen[h] = tc.name
+		})
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
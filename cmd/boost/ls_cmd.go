@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/unixfsls"
+	"github.com/filecoin-project/go-address"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("ls", []unixfsls.Entry{})
+}
+
+var lsCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "List the files in a UnixFS directory stored with a storage provider, without retrieving their content",
+	ArgsUsage: "<payload cid>",
+	Description: "Retrieves only the directory blocks, and the first block of each entry, from the provider's http " +
+		"endpoint (as partial CAR files selected with a shallow selector), to list the names, types, sizes and " +
+		"cids of the entries. Use it to browse a dataset before deciding what to retrieve. If the payload cid is " +
+		"a file, the file itself is listed.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "provider",
+			Usage:    "storage provider on-chain address",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r"},
+			Usage:   "list the entries of subdirectories too",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: ls <payload cid>")
+		}
+		payloadCid, err := cid.Parse(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("parsing payload cid %s: %w", cctx.Args().First(), err)
+		}
+		maddr, err := address.NewFromString(cctx.String("provider"))
+		if err != nil {
+			return fmt.Errorf("parsing provider address %s: %w", cctx.String("provider"), err)
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		src := retrievalSources(n, api, []address.Address{maddr})[0]
+		entries, err := unixfsls.List(ctx, unixfsls.HTTPFetcher(src), payloadCid, cctx.Bool("recursive"))
+		if err != nil {
+			return fmt.Errorf("listing %s from %s: %w", payloadCid, maddr, err)
+		}
+
+		if cctx.Bool("json") {
+			if entries == nil {
+				entries = []unixfsls.Entry{}
+			}
+			return cmd.PrintJson(entries)
+		}
+		if len(entries) == 0 {
+			fmt.Printf("%s is an empty directory\n", payloadCid)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TYPE\tSIZE\tCID\tPATH")
+		for _, e := range entries {
+			p := e.Path
+			if p == "" {
+				p = "."
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Type, humanize.IBytes(e.Size), e.Cid, p)
+		}
+		return w.Flush()
+	},
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/filecoin-project/boost/build"
 	cliutil "github.com/filecoin-project/boost/cli/util"
+	"github.com/filecoin-project/boost/lib/logctx"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/urfave/cli/v2"
 )
@@ -28,18 +29,45 @@ func main() {
 		Usage:                "Boost client for Filecoin",
 		EnableBashCompletion: true,
 		Version:              build.UserVersion(),
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cmd.FlagRepo,
 			cliutil.FlagVeryVerbose,
 			cmd.FlagJson,
-		},
+			cmd.FlagLogFormat,
+		}, metricsPushFlags...),
+		// Push the client's metrics while the command runs, and once more
+		// when it exits
+		Before: startMetricsPush,
+		After:  stopMetricsPush,
 		Commands: []*cli.Command{
 			initCmd,
 			dealCmd,
 			dealStatusCmd,
 			offlineDealCmd,
+			dealBatchCmd,
+			archiveCmd,
+			sendSignedDealCmd,
 			providerCmd,
 			walletCmd,
+			importCmd,
+			retrieveCmd,
+			retrieveManyCmd,
+			lsCmd,
+			watchDatasetCmd,
+			verifyStorageMapCmd,
+			verifyAttestationCmd,
+			serveRetrievalsCmd,
+			prefetchCmd,
+			erasureCmd,
+			reservationCmd,
+			retrievalStatsCmd,
+			diskUsageCmd,
+			identityCmd,
+			apiTokenCmd,
+			transferOverridesCmd,
+			prepApiCmd,
+			openapiCmd,
+			cmd.NewJsonSchemaCmd(),
 		},
 	}
 	app.Setup()
@@ -60,6 +88,12 @@ func main() {
 }
 
 func before(cctx *cli.Context) error {
+	if format := cctx.String(cmd.FlagLogFormat.Name); format != "" {
+		if err := logctx.SetupFormat(format); err != nil {
+			return err
+		}
+	}
+
 	_ = logging.SetLogLevel("boost", "INFO")
 
 	if cliutil.IsVeryVerbose {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/urfave/cli/v2"
+)
+
+// metricsPushFlags configure pushing the client's metrics to a Prometheus
+// pushgateway and / or a StatsD server. The client is short-lived so it
+// can't be scraped: the metrics are pushed while a command runs, and once
+// more when it exits.
+var metricsPushFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "metrics-pushgateway-url",
+		Usage:   "the url of the Prometheus pushgateway to push metrics to, eg http://localhost:9091",
+		EnvVars: []string{"BOOST_METRICS_PUSHGATEWAY_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-pushgateway-job",
+		Usage:   "the job label that metrics are grouped by in the pushgateway",
+		Value:   "boost",
+		EnvVars: []string{"BOOST_METRICS_PUSHGATEWAY_JOB"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-pushgateway-instance",
+		Usage:   "the instance label that metrics are grouped by in the pushgateway (defaults to the hostname)",
+		EnvVars: []string{"BOOST_METRICS_PUSHGATEWAY_INSTANCE"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-statsd-address",
+		Usage:   "the host:port of the StatsD server to send metrics to, eg localhost:8125",
+		EnvVars: []string{"BOOST_METRICS_STATSD_ADDRESS"},
+	},
+	&cli.StringFlag{
+		Name:    "metrics-statsd-prefix",
+		Usage:   "the prefix of the names of the metrics sent to the StatsD server",
+		EnvVars: []string{"BOOST_METRICS_STATSD_PREFIX"},
+	},
+	&cli.BoolFlag{
+		Name:    "metrics-dogstatsd",
+		Usage:   "send metric labels to the StatsD server as DogStatsD tags",
+		EnvVars: []string{"BOOST_METRICS_DOGSTATSD"},
+	},
+	&cli.DurationFlag{
+		Name:    "metrics-push-interval",
+		Usage:   "the interval between metrics pushes",
+		Value:   15 * time.Second,
+		EnvVars: []string{"BOOST_METRICS_PUSH_INTERVAL"},
+	},
+}
+
+// The key in the app metadata of the function that stops pushing metrics
+const metricsPushStopKey = "metricsPushStop"
+
+// How long to wait for the final push when the client exits
+const metricsFinalPushTimeout = 10 * time.Second
+
+// startMetricsPush starts pushing metrics if a pushgateway or StatsD server
+// is configured
+func startMetricsPush(cctx *cli.Context) error {
+	url := cctx.String("metrics-pushgateway-url")
+	statsd := cctx.String("metrics-statsd-address")
+	if url == "" && statsd == "" {
+		return nil
+	}
+
+	// Register the metric views in the default Prometheus registry, so that
+	// they are pushed along with the Go runtime metrics
+	metrics.Exporter("boost_client")
+
+	stop, err := metrics.StartPush(metrics.PushConfig{
+		PushgatewayURL: url,
+		Job:            cctx.String("metrics-pushgateway-job"),
+		Instance:       cctx.String("metrics-pushgateway-instance"),
+		StatsdAddress:  statsd,
+		StatsdPrefix:   cctx.String("metrics-statsd-prefix"),
+		DogStatsd:      cctx.Bool("metrics-dogstatsd"),
+		Interval:       cctx.Duration("metrics-push-interval"),
+	})
+	if err != nil {
+		return err
+	}
+	cctx.App.Metadata[metricsPushStopKey] = stop
+	return nil
+}
+
+// stopMetricsPush stops pushing metrics, after pushing the final values
+func stopMetricsPush(cctx *cli.Context) error {
+	stop, ok := cctx.App.Metadata[metricsPushStopKey].(func(context.Context) error)
+	if !ok {
+		return nil
+	}
+	delete(cctx.App.Metadata, metricsPushStopKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), metricsFinalPushTimeout)
+	defer cancel()
+	if err := stop(ctx); err != nil {
+		// Failing to push metrics doesn't fail the command
+		log.Warnw("pushing metrics on exit", "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/lib/offlinesign"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	types2 "github.com/filecoin-project/boost/transport/types"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+)
+
+// exportOutput is the output of the deal and offline-deal commands in json
+// mode when the deal is exported unsigned
+type exportOutput struct {
+	DealUUID string `json:"dealUuid"`
+	Bundle   string `json:"bundle"`
+	Client   string `json:"client"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("send-signed-deal", dealOutput{})
+}
+
+// exportUnsignedDeal writes the deal with an unsigned proposal to path, to be
+// signed on an air-gapped machine
+func exportUnsignedDeal(cctx *cli.Context, path string, params types.DealParams) error {
+	b, err := offlinesign.NewBundle(params)
+	if err != nil {
+		return err
+	}
+	if err := offlinesign.WriteBundle(path, b); err != nil {
+		return err
+	}
+
+	client := params.ClientDealProposal.Proposal.Client
+	if cctx.Bool("json") {
+		return cmd.PrintJson(exportOutput{DealUUID: params.DealUUID.String(), Bundle: path, Client: client.String()})
+	}
+	fmt.Printf("wrote unsigned deal %s to %s\n", params.DealUUID, path)
+	fmt.Printf("sign it with the key for %s on the signing machine:\n", client)
+	fmt.Printf("  boost-signer sign %s\n", path)
+	fmt.Printf("then send it with:\n")
+	fmt.Printf("  boost send-signed-deal --bundle %s --signature <signature file>\n", path)
+	return nil
+}
+
+var sendSignedDealCmd = &cli.Command{
+	Name:  "send-signed-deal",
+	Usage: "Send a deal exported with --export-unsigned, once its proposal has been signed with boost-signer",
+	Description: "The signature is checked against the client address of the proposal in the bundle " +
+		"before the deal is sent to the provider. The client's escrow is not checked or topped up, " +
+		"as that needs the client's key.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "bundle",
+			Usage:    "the unsigned deal written by --export-unsigned",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "signature",
+			Usage:    "the signature written by boost-signer",
+			Required: true,
+		},
+		&cli.DurationFlag{
+			Name:  "negotiation-timeout",
+			Usage: "the maximum time to wait for the provider to accept or reject the deal proposal (0 means no timeout)",
+		},
+		&cli.BoolFlag{
+			Name:  "ephemeral-identity",
+			Usage: "propose the deal with a newly generated libp2p identity, so that it can't be linked to the client's other deals",
+		},
+	},
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		b, err := offlinesign.ReadBundle(cctx.String("bundle"))
+		if err != nil {
+			return err
+		}
+		sig, err := offlinesign.ReadSignature(cctx.String("signature"))
+		if err != nil {
+			return err
+		}
+		params, err := b.Attach(sig)
+		if err != nil {
+			return err
+		}
+		ctx = logctx.WithDeal(ctx, params.DealUUID)
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name), clinode.EphemeralIdentity(cctx.Bool("ephemeral-identity")))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		proposal := params.ClientDealProposal.Proposal
+		addrInfo, err := cmd.GetAddrInfo(ctx, api, proposal.Provider)
+		if err != nil {
+			return err
+		}
+		if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+			return fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+		}
+
+		negCtx := ctx
+		if timeout := cctx.Duration("negotiation-timeout"); timeout > 0 {
+			var cancel context.CancelFunc
+			negCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		s, err := n.Host.NewStream(negCtx, addrInfo.ID, lp2pimpl.DealProtocols(*params)...)
+		if err != nil {
+			return fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
+		}
+		defer s.Close()
+
+		var resp types.DealResponse
+		if err := doRpc(negCtx, s, params, &resp); err != nil {
+			return fmt.Errorf("send proposal rpc: %w", err)
+		}
+		if !resp.Accepted {
+			return fmt.Errorf("deal proposal rejected: %s", resp.Message)
+		}
+
+		recordDealIdentity(cctx, n, params.DealUUID)
+
+		out := dealOutput{
+			DealUUID:           params.DealUUID.String(),
+			Provider:           proposal.Provider.String(),
+			ClientWallet:       proposal.Client.String(),
+			PayloadCid:         params.DealDataRoot.String(),
+			CommP:              proposal.PieceCID.String(),
+			StartEpoch:         proposal.StartEpoch.String(),
+			EndEpoch:           proposal.EndEpoch.String(),
+			ProviderCollateral: proposal.ProviderCollateral.String(),
+		}
+		if !params.IsOffline {
+			var transferParams types2.HttpRequest
+			if err := json.Unmarshal(params.Transfer.Params, &transferParams); err == nil {
+				out.URL = transferParams.URL
+			}
+		}
+		if cctx.Bool("json") {
+			return cmd.PrintJson(out)
+		}
+
+		msg := "sent signed deal proposal\n"
+		msg += fmt.Sprintf("  deal uuid: %s\n", out.DealUUID)
+		msg += fmt.Sprintf("  storage provider: %s\n", out.Provider)
+		msg += fmt.Sprintf("  client wallet: %s\n", out.ClientWallet)
+		msg += fmt.Sprintf("  payload cid: %s\n", out.PayloadCid)
+		if out.URL != "" {
+			msg += fmt.Sprintf("  url: %s\n", out.URL)
+		}
+		msg += fmt.Sprintf("  commp: %s\n", out.CommP)
+		msg += fmt.Sprintf("  start epoch: %d\n", proposal.StartEpoch)
+		msg += fmt.Sprintf("  end epoch: %d\n", proposal.EndEpoch)
+		msg += fmt.Sprintf("  provider collateral: %s\n", chain_types.FIL(proposal.ProviderCollateral).Short())
+		fmt.Println(msg)
+		return nil
+	},
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/openapi"
+	"github.com/filecoin-project/boost/lib/prepjobs"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/urfave/cli/v2"
+)
+
+// openapiDocs are the OpenAPI documents of the REST APIs served by the client
+var openapiDocs = map[string]func() *openapi.Document{
+	"jobs":       prepjobs.OpenAPI,
+	"retrievals": retrievals.OpenAPI,
+}
+
+var openapiCmd = &cli.Command{
+	Name:      "openapi",
+	Usage:     "Print the OpenAPI document of a REST API served by the client",
+	ArgsUsage: "<jobs|retrievals>",
+	Description: "Prints the OpenAPI document of the job API (served by prep-api) or the retrievals API " +
+		"(served by serve-retrievals), for generating typed clients. The running servers also serve " +
+		"the document at /openapi.json.",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("usage: openapi <jobs|retrievals>")
+		}
+		doc, ok := openapiDocs[cctx.Args().First()]
+		if !ok {
+			return fmt.Errorf("unknown API '%s': must be jobs or retrievals", cctx.Args().First())
+		}
+		return cmd.PrintJson(doc())
+	},
+}
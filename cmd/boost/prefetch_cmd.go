@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/prefetch"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/filecoin-project/go-address"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("prefetch add", []prefetch.Prediction{})
+	cmd.RegisterJsonOutput("prefetch list", []prefetch.Prediction{})
+	cmd.RegisterJsonOutput("prefetch stats", prefetch.Stats{})
+}
+
+var prefetchCmd = &cli.Command{
+	Name:  "prefetch",
+	Usage: "Fetch payload cids that are predicted to be needed soon into a cache while bandwidth is idle",
+	Description: "Predictions are registered with the add command, or by posting them to serve-retrievals. " +
+		"The run command fetches them into a cache in the client repo, highest priority first, while no " +
+		"other retrievals are in progress and within a daily byte budget. retrieve-many and serve-retrievals " +
+		"serve predicted payloads from the cache, and the stats command reports how often predictions were " +
+		"in the cache when they were needed (the hit rate) and how many fetched predictions were used " +
+		"(the accuracy):\n\n" +
+		"   GET    /prefetch             list predictions\n" +
+		"   POST   /prefetch             register predictions: [{\"payloadCid\": ..., \"provider\": ...}]\n" +
+		"   GET    /prefetch/stats       the hit rate and accuracy of the predictions\n" +
+		"   GET    /prefetch/{cid}/car   get a cached CAR file\n" +
+		"   DELETE /prefetch/{cid}       remove a prediction and its cached data",
+	Before: before,
+	Subcommands: []*cli.Command{
+		prefetchAddCmd,
+		prefetchListCmd,
+		prefetchStatsCmd,
+		prefetchRemoveCmd,
+		prefetchRunCmd,
+	},
+}
+
+var prefetchAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "Register payload cids that are predicted to be needed soon",
+	ArgsUsage: "<payload cid>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "provider",
+			Usage:    "the storage provider to retrieve the payloads from",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "priority",
+			Usage: "predictions with a higher priority are fetched first",
+		},
+		&cli.StringFlag{
+			Name:  "ttl",
+			Usage: "how long the prediction is valid for, eg 6h",
+			Value: prefetch.DefaultTTL.String(),
+		},
+	},
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() == 0 {
+			return fmt.Errorf("usage: prefetch add <payload cid>...")
+		}
+		maddr, err := address.NewFromString(cctx.String("provider"))
+		if err != nil {
+			return fmt.Errorf("parsing provider address %s: %w", cctx.String("provider"), err)
+		}
+
+		var reqs []prefetch.Request
+		for _, arg := range cctx.Args().Slice() {
+			c, err := cid.Parse(arg)
+			if err != nil {
+				return fmt.Errorf("parsing payload cid %s: %w", arg, err)
+			}
+			reqs = append(reqs, prefetch.Request{
+				PayloadCid: c,
+				Provider:   maddr.String(),
+				Priority:   cctx.Int("priority"),
+				TTL:        cctx.String("ttl"),
+			})
+		}
+
+		store, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		preds, err := store.Register(reqs)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(preds)
+		}
+		for _, p := range preds {
+			fmt.Printf("%s: %s, expires %s\n", p.PayloadCid, p.State, p.ExpiresAt.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var prefetchListCmd = &cli.Command{
+	Name:   "list",
+	Usage:  "List predictions, highest priority first",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		store, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		preds, err := store.List()
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			if preds == nil {
+				preds = []prefetch.Prediction{}
+			}
+			return cmd.PrintJson(preds)
+		}
+		if len(preds) == 0 {
+			fmt.Println("no predictions")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "PAYLOAD CID\tPROVIDER\tPRIORITY\tSTATE\tSIZE\tHITS\tLATE\tEXPIRES\n")
+		for _, p := range preds {
+			state := p.State
+			if p.Error != "" {
+				state += ": " + p.Error
+			}
+			size := ""
+			if p.Size > 0 {
+				size = humanize.IBytes(uint64(p.Size))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%s\n", p.PayloadCid, p.Provider, p.Priority,
+				state, size, p.Hits, p.Late, humanize.Time(p.ExpiresAt))
+		}
+		return w.Flush()
+	},
+}
+
+var prefetchStatsCmd = &cli.Command{
+	Name:   "stats",
+	Usage:  "Show the hit rate and accuracy of the predictions, and the size of the cache",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		store, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		st, err := store.Stats()
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(st)
+		}
+		fmt.Printf("predictions: %d pending, %d fetching, %d cached, %d failed, %d expired\n",
+			st.Pending, st.Fetching, st.Cached, st.Failed, st.Expired)
+		fmt.Printf("hit rate: %.1f%% (%d hits, %d late)\n", st.HitRate*100, st.Hits, st.Late)
+		fmt.Printf("accuracy: %.1f%% (%d used, %d expired unused)\n", st.Accuracy*100, st.Used, st.Unused)
+		fmt.Printf("cache: %s\n", humanize.IBytes(uint64(st.CacheBytes)))
+		fmt.Printf("fetched in the last day: %s\n", humanize.IBytes(uint64(st.FetchedLastDay)))
+		return nil
+	},
+}
+
+var prefetchRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove a prediction and its cached data",
+	ArgsUsage: "<payload cid>",
+	Before:    before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: prefetch remove <payload cid>")
+		}
+		c, err := cid.Parse(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("parsing payload cid %s: %w", cctx.Args().First(), err)
+		}
+		store, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		return store.Remove(c)
+	},
+}
+
+var prefetchRunCmd = &cli.Command{
+	Name:  "run",
+	Usage: "Fetch pending predictions into the cache while no other retrievals are in progress",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "bytes-per-day",
+			Usage: "the maximum number of bytes to prefetch in any 24 hours, eg 100GiB (0 for no limit)",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "cache-size",
+			Usage: "the maximum size of the cache, eg 500GiB (0 for no limit)",
+			Value: "0",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to check for pending predictions",
+			Value: time.Minute,
+		},
+	},
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		var budget prefetch.Budget
+		var err error
+		if budget.BytesPerDay, err = humanize.ParseBytes(cctx.String("bytes-per-day")); err != nil {
+			return fmt.Errorf("parsing bytes-per-day: %w", err)
+		}
+		if budget.CacheBytes, err = humanize.ParseBytes(cctx.String("cache-size")); err != nil {
+			return fmt.Errorf("parsing cache-size: %w", err)
+		}
+
+		store, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		retrievalStore, err := openRetrievalStore(cctx)
+		if err != nil {
+			return err
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		fetch := func(ctx context.Context, p prefetch.Prediction, outPath string) (int64, error) {
+			maddr, err := address.NewFromString(p.Provider)
+			if err != nil {
+				return 0, fmt.Errorf("parsing provider address %s: %w", p.Provider, err)
+			}
+			sources := retrievalSources(n, api, []address.Address{maddr})
+			query := url.Values{"payloadCid": {p.PayloadCid.String()}}
+			res, err := carfetch.Fetch(ctx, sources, query, outPath)
+			if err != nil {
+				return 0, err
+			}
+			for _, r := range res.Roots {
+				if r.Equals(p.PayloadCid) {
+					return res.Size, nil
+				}
+			}
+			return 0, fmt.Errorf("payload cid is not a root of the retrieved CAR file (roots: %s)", res.Roots)
+		}
+
+		pf := prefetch.NewPrefetcher(store, fetch, retrievalsIdle(retrievalStore), budget)
+		fmt.Println("Prefetching predicted payloads")
+		return pf.Run(ctx, cctx.Duration("interval"))
+	},
+}
+
+// retrievalsIdle returns an IdleFunc that reports bandwidth as idle when no
+// retrieval is in progress. A retrieval whose record says it is in progress
+// but whose CAR file hasn't been written to recently was interrupted, so it
+// doesn't count.
+func retrievalsIdle(store *retrievals.Store) prefetch.IdleFunc {
+	return func() bool {
+		recs, err := store.List()
+		if err != nil {
+			log.Warnw("listing retrievals", "err", err)
+			return false
+		}
+		for _, rec := range recs {
+			if rec.State != retrievals.StateInProgress {
+				continue
+			}
+			if fi, err := os.Stat(rec.Path); err == nil && time.Since(fi.ModTime()) < time.Minute {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// openPrefetchStore opens the store in the client repo that records
+// predictions and caches the data fetched for them
+func openPrefetchStore(cctx *cli.Context) (*prefetch.Store, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return prefetch.NewStore(filepath.Join(sdir, "prefetch"))
+}
@@ -0,0 +1,833 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/boost/lib/askwatch"
+	"github.com/filecoin-project/boost/lib/dealbatch"
+	"github.com/filecoin-project/boost/lib/encds"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/lib/prepjobs"
+	"github.com/filecoin-project/boost/lib/prewarm"
+	"github.com/filecoin-project/boost/lib/regions"
+	"github.com/filecoin-project/boost/lib/sla"
+	"github.com/filecoin-project/boost/lib/transferoverrides"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	types2 "github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+var prepApiCmd = &cli.Command{
+	Name:  "prep-api",
+	Usage: "Run a job API that external data preparation services can register prepared pieces with",
+	Description: "Each job has a deal policy (providers, replicas, duration, price). As pieces are " +
+		"registered with a job, online deals are made for them according to the policy. " +
+		"If the policy has a proposeAfter time, connections to its providers are pre-warmed " +
+		"ahead of that time so that proposals start immediately. " +
+		"If ephemeral-identity is set, the deals are proposed with a libp2p identity generated for this run " +
+		"of the API, so that they can't be linked to the client's other deals. " +
+		"If follow-ask-price is set, the storage asks of the providers of jobs with deals still to make " +
+		"are watched, and when a provider raises its price above a job's price, the job's deals with " +
+		"that provider are proposed at the new price (up to max-storage-price). " +
+		"Pieces that a provider rejected are proposed to it again after reject-retry-backoff, which doubles " +
+		"with each rejection up to reject-retry-max-backoff, or right away once the job's price for the " +
+		"provider has been raised. " +
+		"Jobs and their pieces are stored in the client repo, so deal making resumes after a restart. " +
+		"Job records (which include job names and piece URLs and headers) are encrypted at rest with a " +
+		"key in the client repo, and are decrypted by the API. Set a token to restrict API access to " +
+		"callers that present it in an 'Authorization: Bearer <token>' header. Callers may also present " +
+		"one of the API tokens created with the api-token command, in which case the deals for their jobs " +
+		"are charged to the token's daily quota. " +
+		"If response time SLAs are set, providers that respond to asks or deal proposals, or start transfers, " +
+		"more slowly than the SLA are logged; providers with repeated violations are proposed to after the " +
+		"job's other providers, and with sla-fail-over the slow step is abandoned and the deal is made with " +
+		"another provider. Response times are served at /sla. " +
+		"Deal proposals above the approve-above-* thresholds, to approve-provider providers, or (with " +
+		"approve-deprioritized) to providers deprioritized for SLA violations are held until the admin, or " +
+		"an API token created with --approver, approves or rejects them at /approvals; the name of the " +
+		"token is recorded as the approver of the decision. " +
+		"A job's policy may have a ladder, which staggers the end epochs of its deals across rungs so that " +
+		"the dataset doesn't expire all at once; /jobs/{id}/ladder lists the rungs in the order in which " +
+		"they come due for renewal. " +
+		"A job's policy may also have a region constraint (eg at least 3 distinct regions, none in X): " +
+		"providers' regions are taken from the job, then provider-region, then region-feed, and finally " +
+		"(with self-declared-regions) from the providers themselves. Deals are only proposed to providers " +
+		"whose region is known and not excluded, and the job status reports pieces whose deals don't " +
+		"satisfy the constraint. " +
+		"A job whose policy is offPeak is not urgent: its deals are only proposed to each provider when the " +
+		"transfer is expected to finish inside one of the provider's off-peak windows (from provider-offpeak, " +
+		"or as announced by the provider), so that the data is transferred when the provider prefers, at " +
+		"the window's discount. After the job's " +
+		"offPeakDeadline deals are proposed regardless of the windows. " +
+		"Job, piece and approval records are cached in memory as they are read, and updated as they change, " +
+		"so that dashboards that poll the job and approval lists don't read the repo each time. The cache " +
+		"hits and misses are served at /cache. " +
+		"Deals are made with each provider using its transfer overrides (see transfer-overrides), which " +
+		"admins can also edit at /transfer-overrides.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "the address to listen on for job API requests",
+			Value: "127.0.0.1:8766",
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "wallet address to be used to make deals",
+		},
+		&cli.DurationFlag{
+			Name:  "prewarm-lead",
+			Usage: "how long before a job's proposeAfter time to pre-dial its providers (0 to disable pre-warming)",
+			Value: 5 * time.Minute,
+		},
+		&cli.BoolFlag{
+			Name:  "ephemeral-identity",
+			Usage: "propose the deals made by this run of the API with a newly generated libp2p identity",
+		},
+		&cli.DurationFlag{
+			Name:  "reject-retry-backoff",
+			Usage: "how long to wait before proposing a piece again to a provider that rejected it (doubles with each rejection)",
+			Value: 10 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "reject-retry-max-backoff",
+			Usage: "the maximum time to wait before proposing a piece again to a provider that rejected it",
+			Value: 24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "follow-ask-price",
+			Usage: "raise the price of deals still to be proposed when a provider raises its ask price",
+		},
+		&cli.DurationFlag{
+			Name:  "ask-watch-interval",
+			Usage: "how often to query the storage asks of providers when follow-ask-price is set",
+			Value: askwatch.DefaultInterval,
+		},
+		&cli.StringFlag{
+			Name:  "max-storage-price",
+			Usage: "the maximum price in attoFIL per epoch per GiB that follow-ask-price raises deals to (if empty there is no maximum)",
+		},
+		&cli.DurationFlag{
+			Name:  "ask-sla",
+			Usage: "the maximum time a provider may take to respond to a storage ask (queried before each proposal when set)",
+		},
+		&cli.DurationFlag{
+			Name:  "proposal-sla",
+			Usage: "the maximum time a provider may take to accept or reject a deal proposal",
+		},
+		&cli.DurationFlag{
+			Name:  "transfer-sla",
+			Usage: "the maximum time a provider may take to start the data transfer after accepting a deal",
+		},
+		&cli.IntFlag{
+			Name:  "sla-deprioritize-after",
+			Usage: "the number of SLA violations within sla-window after which a provider is proposed to last (0 to disable)",
+			Value: 3,
+		},
+		&cli.DurationFlag{
+			Name:  "sla-window",
+			Usage: "the window in which SLA violations are counted",
+			Value: 24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "sla-fail-over",
+			Usage: "abandon a step that exceeds its SLA and make the deal with another provider",
+		},
+		&cli.StringFlag{
+			Name:  "approve-above-piece-size",
+			Usage: "hold proposals for pieces larger than this (eg '32GiB') for approval",
+		},
+		&cli.StringFlag{
+			Name:  "approve-above-cost",
+			Usage: "hold proposals for deals that cost more than this over their duration (eg '0.5 FIL') for approval",
+		},
+		&cli.StringSliceFlag{
+			Name:  "approve-provider",
+			Usage: "hold proposals to this provider for approval (may be repeated)",
+		},
+		&cli.BoolFlag{
+			Name:  "approve-deprioritized",
+			Usage: "hold proposals to providers that have been deprioritized for SLA violations for approval",
+		},
+		&cli.StringSliceFlag{
+			Name:  "provider-region",
+			Usage: "the region of a provider, in the format <provider>=<region> eg f01000=us-east (may be repeated)",
+		},
+		&cli.StringFlag{
+			Name:  "region-feed",
+			Usage: "the url of a reputation feed of provider regions: a JSON object of provider address to region",
+		},
+		&cli.DurationFlag{
+			Name:  "region-feed-ttl",
+			Usage: "how long to cache the region feed for",
+			Value: time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "self-declared-regions",
+			Usage: "ask providers whose region is not otherwise known for the region they declare",
+		},
+		&cli.StringSliceFlag{
+			Name: "provider-offpeak",
+			Usage: "an off-peak window of a provider (in UTC), in the format <provider>=<HH:MM>-<HH:MM>[/<discount percent>] " +
+				"eg f01000=22:00-06:00/10 (may be repeated). Overrides the windows the provider announces.",
+		},
+		&cli.DurationFlag{
+			Name:  "offpeak-ttl",
+			Usage: "how long to cache the off-peak windows that providers announce",
+			Value: time.Hour,
+		},
+		&cli.StringFlag{
+			Name: "offpeak-transfer-rate",
+			Usage: "the expected rate of transfers to providers, per second (eg 10MiB). Deals for off-peak jobs are " +
+				"only proposed when the transfer is expected to finish inside the provider's window (0 to only " +
+				"require that the transfer starts inside the window)",
+			Value: "10MiB",
+		},
+		&cli.IntFlag{
+			Name:  "cache-records",
+			Usage: "the maximum number of job, piece and approval records of each kind to cache in memory (0 to disable caching)",
+			Value: prepjobs.DefaultCacheRecords,
+		},
+		&cli.BoolFlag{
+			Name:  "skip-rule-check",
+			Usage: "send deal proposals without first checking them against the filter rules that providers publish",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "the bearer token that callers must present (if empty the API is not authenticated)",
+			EnvVars: []string{"BOOST_PREP_API_TOKEN"},
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name), clinode.EphemeralIdentity(cctx.Bool("ephemeral-identity")))
+		if err != nil {
+			return err
+		}
+		if n.Ephemeral {
+			log.Infow("making deals with ephemeral identity", "peer", n.Host.ID())
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+		ds, err := levelds.NewDatastore(filepath.Join(sdir, "prepjobs"), nil)
+		if err != nil {
+			return fmt.Errorf("opening job store: %w", err)
+		}
+		defer ds.Close() //nolint:errcheck
+
+		encDs, err := openEncryptedDatastore(ctx, sdir, ds)
+		if err != nil {
+			return fmt.Errorf("opening job store: %w", err)
+		}
+
+		store := prepjobs.NewStore(encDs, prepjobs.CacheRecords(cctx.Int("cache-records")))
+		tokens, err := openAPITokenStore(cctx)
+		if err != nil {
+			return err
+		}
+		opts := []prepjobs.SchedulerOption{
+			prepjobs.ChargeQuotas(tokens),
+			prepjobs.RetryRejected(cctx.Duration("reject-retry-backoff"), cctx.Duration("reject-retry-max-backoff")),
+		}
+		if lead := cctx.Duration("prewarm-lead"); lead > 0 {
+			resolve := func(ctx context.Context, maddr address.Address) (*peer.AddrInfo, error) {
+				return cmd.GetAddrInfo(ctx, api, maddr)
+			}
+			warmer := prewarm.New(n.Host, resolve, lp2pimpl.DealProtocolID)
+			opts = append(opts, prepjobs.PrewarmConnections(warmer, lead))
+		}
+		ids, err := clinode.LoadIdentities(sdir)
+		if err != nil {
+			return err
+		}
+		slas := sla.NewTracker(sla.Config{
+			Ask:               cctx.Duration("ask-sla"),
+			Proposal:          cctx.Duration("proposal-sla"),
+			Transfer:          cctx.Duration("transfer-sla"),
+			DeprioritizeAfter: cctx.Int("sla-deprioritize-after"),
+			Window:            cctx.Duration("sla-window"),
+			FailOver:          cctx.Bool("sla-fail-over"),
+		})
+		opts = append(opts, prepjobs.TrackSLAs(slas))
+		thresholds, err := approvalThresholds(cctx, slas)
+		if err != nil {
+			return err
+		}
+		if thresholds != nil {
+			opts = append(opts, prepjobs.RequireApproval(*thresholds))
+		}
+		resolver, err := regionResolver(cctx, n, api, walletAddr)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, prepjobs.ResolveRegions(resolver))
+		offPeak, err := offPeakSource(cctx, n, api, walletAddr)
+		if err != nil {
+			return err
+		}
+		offPeakRate, err := units.RAMInBytes(cctx.String("offpeak-transfer-rate"))
+		if err != nil {
+			return fmt.Errorf("parsing offpeak-transfer-rate: %w", err)
+		}
+		if offPeakRate < 0 {
+			return errors.New("offpeak-transfer-rate must not be negative")
+		}
+		opts = append(opts, prepjobs.ShiftToOffPeak(offPeak, uint64(offPeakRate)))
+		overrides, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		dm := &clientDealMaker{node: n, api: api, wallet: walletAddr, identities: ids, slas: slas, queryAsk: cctx.IsSet("ask-sla"), overrides: overrides}
+		if !cctx.Bool("skip-rule-check") {
+			dm.rules = newProviderRules(lp2pimpl.NewDealClient(n.Host, walletAddr, clinode.DealProposalSigner{LocalWallet: n.Wallet}))
+		}
+		sched := prepjobs.NewScheduler(store, dm, opts...)
+		go sched.Run(ctx)
+		go slas.Run(ctx, &transferChecker{node: n, api: api, wallet: walletAddr, overrides: overrides}, time.Minute)
+		go failOverSlowTransfers(ctx, slas, sched)
+
+		if cctx.Bool("follow-ask-price") {
+			maxPrice := abi.TokenAmount{}
+			if mp := cctx.String("max-storage-price"); mp != "" {
+				maxPrice, err = big.FromString(mp)
+				if err != nil {
+					return fmt.Errorf("parsing max storage price %s: %w", mp, err)
+				}
+			}
+			w := askwatch.NewWatcher(&askFetcher{node: n, api: api}, store.PendingProviders, cctx.Duration("ask-watch-interval"))
+			go followAskPrices(ctx, w, store, sched, maxPrice)
+			go w.Run(ctx)
+		}
+
+		ln, err := net.Listen("tcp", cctx.String("listen"))
+		if err != nil {
+			return fmt.Errorf("listening on %s: %w", cctx.String("listen"), err)
+		}
+		admin := apiquota.RequireAdmin(apiquota.NewAdminHandler(tokens))
+		mux := http.NewServeMux()
+		mux.Handle("/", prepjobs.NewHandler(store, sched))
+		mux.Handle("/api-tokens", admin)
+		mux.Handle("/api-tokens/", admin)
+		mux.Handle("/sla", apiquota.RequireAdmin(sla.NewHandler(slas)))
+		mux.Handle("/cache", apiquota.RequireAdmin(prepjobs.NewCacheStatsHandler(store)))
+		transferOverrides := apiquota.RequireAdmin(transferoverrides.NewHandler(overrides))
+		mux.Handle("/transfer-overrides", transferOverrides)
+		mux.Handle("/transfer-overrides/", transferOverrides)
+		approvals := apiquota.RequireApprover(tokens)(prepjobs.NewApprovalHandler(store, sched))
+		mux.Handle("/approvals", approvals)
+		mux.Handle("/approvals/", approvals)
+		var handler http.Handler = mux
+		existing, err := tokens.List()
+		if err != nil {
+			return err
+		}
+		if token := cctx.String("token"); token != "" || len(existing) > 0 {
+			handler = apiquota.Authenticate(token, tokens)(handler)
+		}
+		srv := &http.Server{Handler: handler}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+
+		fmt.Printf("Job API listening on http://%s with wallet %s\n", ln.Addr(), walletAddr)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+// followAskPrices raises the price of the deals that are still to be
+// proposed to a provider when the provider raises its ask price
+func followAskPrices(ctx context.Context, w *askwatch.Watcher, store *prepjobs.Store, sched *prepjobs.Scheduler, maxPrice abi.TokenAmount) {
+	changes, unsub := w.Subscribe()
+	defer unsub()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-changes:
+			if !c.PriceChanged() {
+				continue
+			}
+			adjusted, err := store.AdjustPrice(ctx, c.Provider, c.New.Price, c.New.VerifiedPrice, maxPrice)
+			if err != nil {
+				log.Errorw("adjusting job prices to provider ask", "provider", c.Provider, "err", err)
+				continue
+			}
+			if len(adjusted) > 0 {
+				log.Infow("raised job prices to provider ask", "provider", c.Provider, "price", c.New.Price,
+					"verified-price", c.New.VerifiedPrice, "jobs", adjusted)
+				sched.Notify()
+			}
+		}
+	}
+}
+
+// approvalThresholds returns the thresholds above which deal proposals are
+// held for approval, or nil if no threshold is set
+func approvalThresholds(cctx *cli.Context, slas *sla.Tracker) (*prepjobs.ApprovalThresholds, error) {
+	var t prepjobs.ApprovalThresholds
+	set := false
+	if ps := cctx.String("approve-above-piece-size"); ps != "" {
+		size, err := humanize.ParseBytes(ps)
+		if err != nil {
+			return nil, fmt.Errorf("parsing approve-above-piece-size %s: %w", ps, err)
+		}
+		t.PieceSize = abi.PaddedPieceSize(size)
+		set = true
+	}
+	if c := cctx.String("approve-above-cost"); c != "" {
+		cost, err := chain_types.ParseFIL(c)
+		if err != nil {
+			return nil, fmt.Errorf("parsing approve-above-cost %s: %w", c, err)
+		}
+		t.Spend = abi.TokenAmount(cost)
+		set = true
+	}
+	for _, p := range cctx.StringSlice("approve-provider") {
+		maddr, err := address.NewFromString(p)
+		if err != nil {
+			return nil, fmt.Errorf("parsing approve-provider %s: %w", p, err)
+		}
+		t.Providers = append(t.Providers, maddr)
+		set = true
+	}
+	if cctx.Bool("approve-deprioritized") {
+		t.ProviderRisk = func(provider address.Address) string {
+			if slas.Deprioritized(provider) {
+				return "provider has been deprioritized for repeated SLA violations"
+			}
+			return ""
+		}
+		set = true
+	}
+	if !set {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// regionResolver looks up provider regions from config, then the region
+// feed, then the providers' own declarations
+func regionResolver(cctx *cli.Context, n *clinode.Node, api lapi.Gateway, wallet address.Address) (regions.Resolver, error) {
+	static, err := regions.ParseStatic(cctx.StringSlice("provider-region"))
+	if err != nil {
+		return nil, err
+	}
+	resolvers := []regions.Resolver{static}
+	if url := cctx.String("region-feed"); url != "" {
+		resolvers = append(resolvers, regions.NewFeed(url, cctx.Duration("region-feed-ttl")))
+	}
+	if cctx.Bool("self-declared-regions") {
+		dc := lp2pimpl.NewDealClient(n.Host, wallet, clinode.DealProposalSigner{LocalWallet: n.Wallet})
+		resolvers = append(resolvers, regions.ResolverFunc(func(ctx context.Context, providers []address.Address) (map[address.Address]string, error) {
+			found := make(map[address.Address]string)
+			for _, maddr := range providers {
+				region, err := queryProviderRegion(ctx, n, api, dc, maddr)
+				if err != nil {
+					log.Warnw("querying provider region", "provider", maddr, "err", err)
+					continue
+				}
+				if region != "" {
+					found[maddr] = region
+				}
+			}
+			return found, nil
+		}))
+	}
+	return regions.Chain(resolvers...), nil
+}
+
+// queryProviderRegion asks the provider for the region it declares that it
+// stores data in
+func queryProviderRegion(ctx context.Context, n *clinode.Node, api lapi.Gateway, dc *lp2pimpl.DealClient, maddr address.Address) (string, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+	if err != nil {
+		return "", err
+	}
+	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+		return "", fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+	return dc.SendProviderRegionRequest(ctx, addrInfo.ID)
+}
+
+// offPeakSource looks up providers' off-peak windows from config, or else
+// asks the providers for the windows they announce
+func offPeakSource(cctx *cli.Context, n *clinode.Node, api lapi.Gateway, wallet address.Address) (prepjobs.OffPeakSource, error) {
+	static := make(map[address.Address][]types.OffPeakWindow)
+	for _, spec := range cctx.StringSlice("provider-offpeak") {
+		maddr, w, err := parseProviderOffPeak(spec)
+		if err != nil {
+			return nil, err
+		}
+		static[maddr] = append(static[maddr], w)
+	}
+
+	dc := lp2pimpl.NewDealClient(n.Host, wallet, clinode.DealProposalSigner{LocalWallet: n.Wallet})
+	announced := prepjobs.CacheOffPeakWindows(prepjobs.OffPeakSourceFunc(func(ctx context.Context, maddr address.Address) ([]types.OffPeakWindow, error) {
+		addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+		if err != nil {
+			return nil, err
+		}
+		if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+			return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+		}
+		return dc.SendProviderOffPeakRequest(ctx, addrInfo.ID)
+	}), cctx.Duration("offpeak-ttl"))
+
+	return prepjobs.OffPeakSourceFunc(func(ctx context.Context, maddr address.Address) ([]types.OffPeakWindow, error) {
+		if windows, ok := static[maddr]; ok {
+			return windows, nil
+		}
+		return announced.OffPeakWindows(ctx, maddr)
+	}), nil
+}
+
+// parseProviderOffPeak parses a provider off-peak window in the format
+// <provider>=<HH:MM>-<HH:MM>[/<discount percent>]
+func parseProviderOffPeak(spec string) (address.Address, types.OffPeakWindow, error) {
+	invalid := fmt.Errorf("provider off-peak window %s is not in the format <provider>=<HH:MM>-<HH:MM>[/<discount percent>]", spec)
+	prov, window, ok := strings.Cut(spec, "=")
+	if !ok {
+		return address.Undef, types.OffPeakWindow{}, invalid
+	}
+	maddr, err := address.NewFromString(prov)
+	if err != nil {
+		return address.Undef, types.OffPeakWindow{}, fmt.Errorf("parsing provider address in %s: %w", spec, err)
+	}
+	window, discount, hasDiscount := strings.Cut(window, "/")
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return address.Undef, types.OffPeakWindow{}, invalid
+	}
+	var pct uint64
+	if hasDiscount {
+		pct, err = strconv.ParseUint(strings.TrimSuffix(discount, "%"), 10, 64)
+		if err != nil {
+			return address.Undef, types.OffPeakWindow{}, invalid
+		}
+	}
+	w, err := types.ParseOffPeakWindow(start, end, pct)
+	if err != nil {
+		return address.Undef, types.OffPeakWindow{}, fmt.Errorf("%s: %w", spec, err)
+	}
+	return maddr, w, nil
+}
+
+// failOverSlowTransfers cancels and fails accepted deals when the provider
+// doesn't start the transfer within the SLA, so that the scheduler makes the
+// deal with another provider
+func failOverSlowTransfers(ctx context.Context, slas *sla.Tracker, sched *prepjobs.Scheduler) {
+	violations, unsub := slas.Subscribe()
+	defer unsub()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-violations:
+			if v.Step != sla.StepTransfer || !v.FailOver {
+				continue
+			}
+			reason := fmt.Sprintf("provider did not start transfer within SLA of %s", v.Limit)
+			failed, err := sched.FailOver(ctx, v.Provider, v.DealUUID, reason)
+			if err != nil {
+				log.Errorw("failing over deal with slow transfer", "provider", v.Provider, logctx.DealKey, v.DealUUID, "err", err)
+				continue
+			}
+			if !failed {
+				log.Infow("not failing over deal with slow transfer", "provider", v.Provider, logctx.DealKey, v.DealUUID)
+			}
+		}
+	}
+}
+
+// openEncryptedDatastore wraps a datastore in the client repo so that its
+// values are encrypted with the repo's datastore key. Any values that were
+// written before encryption was enabled are encrypted in place.
+func openEncryptedDatastore(ctx context.Context, repoDir string, ds datastore.Batching) (*encds.Datastore, error) {
+	key, err := encds.LoadOrCreateKey(filepath.Join(repoDir, "datastore.key"))
+	if err != nil {
+		return nil, err
+	}
+	encDs, err := encds.Wrap(ds, key)
+	if err != nil {
+		return nil, err
+	}
+	count, err := encDs.EncryptExisting(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting existing records: %w", err)
+	}
+	if count > 0 {
+		log.Infow("encrypted existing datastore records", "count", count)
+	}
+	return encDs, nil
+}
+
+// clientDealMaker makes online deals for the pieces in a job, in the same
+// way as the deal command
+type clientDealMaker struct {
+	node       *clinode.Node
+	api        lapi.Gateway
+	wallet     address.Address
+	identities *clinode.Identities
+	slas       *sla.Tracker
+	// Query the provider's storage ask before each proposal to measure its
+	// response time
+	queryAsk bool
+	// Check each proposal against the provider's published filter rules
+	// (nil to send every proposal)
+	rules     *providerRules
+	overrides *transferoverrides.Store
+}
+
+func (m *clientDealMaker) MakeDeal(ctx context.Context, policy prepjobs.Policy, maddr address.Address, piece prepjobs.Piece) (*prepjobs.Deal, error) {
+	override, err := providerTransferOverride(m.overrides, maddr)
+	if err != nil {
+		return nil, err
+	}
+	addrInfo, err := overriddenAddrInfo(ctx, m.api, maddr, override)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.node.Host.Connect(ctx, *addrInfo); err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+
+	dealUuid := uuid.New()
+	ctx = logctx.WithDeal(ctx, dealUuid)
+
+	if m.queryAsk {
+		askCtx, cancel := m.slas.StepContext(ctx, sla.StepAsk)
+		start := time.Now()
+		_, err := queryStorageAsk(askCtx, m.node, m.api, maddr)
+		cancel()
+		m.slas.Observe(maddr, dealUuid, sla.StepAsk, time.Since(start))
+		if err != nil {
+			return nil, fmt.Errorf("querying storage ask: %w", err)
+		}
+	}
+
+	transferParams, err := json.Marshal(&types2.HttpRequest{URL: piece.URL, Headers: piece.Headers})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling request parameters: %w", err)
+	}
+
+	bounds, err := m.api.StateDealProviderCollateralBounds(ctx, piece.PieceSize, policy.Verified, chain_types.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("node error getting collateral bounds: %w", err)
+	}
+	providerCollateral := big.Div(big.Mul(bounds.Min, big.NewInt(6)), big.NewInt(5)) // add 20%
+
+	tipset, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	startEpoch := tipset.Height() + policy.StartEpochOffset
+
+	label, err := dealLabel(LabelModePayloadCid, piece.PayloadCid, "")
+	if err != nil {
+		return nil, fmt.Errorf("creating deal label: %w", err)
+	}
+	proposal, err := dealProposal(ctx, m.node, m.wallet, piece.PayloadCid, piece.PieceSize, piece.PieceCid, maddr, startEpoch,
+		int(policy.DurationFor(piece.Rung)), policy.Verified, providerCollateral, policy.PriceFor(maddr), label.Label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a deal proposal: %w", err)
+	}
+
+	params := types.DealParams{
+		DealUUID:           dealUuid,
+		ClientDealProposal: *proposal,
+		DealDataRoot:       piece.PayloadCid,
+		Transfer: types.Transfer{
+			Type:   "http",
+			Params: transferParams,
+			Size:   piece.CarSize,
+		},
+		TransferStartTimeout: time.Duration(override.TransferStartTimeout),
+		TransferTimeout:      time.Duration(override.TransferTimeout),
+	}
+
+	if m.rules != nil {
+		if rule := m.rules.check(ctx, addrInfo.ID, params, tipset.Height()); rule != "" {
+			return &prepjobs.Deal{
+				DealUUID: dealUuid,
+				Accepted: false,
+				Message:  fmt.Sprintf("not proposed: deal would be rejected by the provider's published filter rule %s", rule),
+			}, nil
+		}
+	}
+
+	// If the response to the proposal is lost, the provider is asked
+	// whether it has the deal before it is proposed again. If that can't be
+	// found out the error is a ProposalOutcomeUnknownError, and the scheduler
+	// keeps the deal's uuid to check with CheckDeal.
+	resp, err := dealbatch.ProposeChecked(ctx, m.proposer(), addrInfo.ID, params, 2)
+	if err != nil {
+		return nil, fmt.Errorf("send deal proposal: %w", err)
+	}
+	if resp.Accepted {
+		if err := m.identities.RecordDeal(m.node.Host.ID(), m.node.Ephemeral, dealUuid); err != nil {
+			logctx.Logger(ctx, log).Warnw("recording deal identity", "err", err)
+		}
+	}
+
+	return &prepjobs.Deal{
+		DealUUID: dealUuid,
+		Accepted: resp.Accepted,
+		Message:  resp.Message,
+		EndEpoch: proposal.Proposal.EndEpoch,
+	}, nil
+}
+
+func (m *clientDealMaker) CheckDeal(ctx context.Context, maddr address.Address, dealUUID uuid.UUID) (*prepjobs.Deal, error) {
+	id, err := m.connect(ctx, maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := dealbatch.CheckProposed(ctx, m.proposer(), id, dealUUID)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	if err := m.identities.RecordDeal(m.node.Host.ID(), m.node.Ephemeral, dealUUID); err != nil {
+		logctx.Logger(ctx, log).Warnw("recording deal identity", "err", err)
+	}
+	return &prepjobs.Deal{DealUUID: dealUUID, Accepted: resp.Accepted, Message: resp.Message}, nil
+}
+
+func (m *clientDealMaker) CancelDeal(ctx context.Context, maddr address.Address, dealUUID uuid.UUID) (bool, error) {
+	id, err := m.connect(ctx, maddr)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := m.proposer().dc.SendDealCancelRequest(ctx, id, dealUUID)
+	if err != nil {
+		return false, err
+	}
+	if !resp.Cancelled {
+		logctx.Logger(ctx, log).Infow("provider did not cancel deal", "provider", maddr, logctx.DealKey, dealUUID, "reason", resp.Message)
+	}
+	return resp.Cancelled, nil
+}
+
+// connect connects to the provider's peer (or the peer it has been
+// overridden with), and returns the peer's id
+func (m *clientDealMaker) connect(ctx context.Context, maddr address.Address) (peer.ID, error) {
+	override, err := providerTransferOverride(m.overrides, maddr)
+	if err != nil {
+		return "", err
+	}
+	addrInfo, err := overriddenAddrInfo(ctx, m.api, maddr, override)
+	if err != nil {
+		return "", err
+	}
+	if err := m.node.Host.Connect(ctx, *addrInfo); err != nil {
+		return "", fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+	return addrInfo.ID, nil
+}
+
+func (m *clientDealMaker) proposer() *slaProposer {
+	dc := lp2pimpl.NewDealClient(m.node.Host, m.wallet, clinode.DealProposalSigner{LocalWallet: m.node.Wallet})
+	return &slaProposer{dealClientProposer: &dealClientProposer{dc: dc}, slas: m.slas}
+}
+
+// slaProposer sends deal proposals with the deal client, measuring how long
+// each provider takes to respond
+type slaProposer struct {
+	*dealClientProposer
+	slas *sla.Tracker
+}
+
+func (p *slaProposer) Propose(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
+	propCtx, cancel := p.slas.StepContext(ctx, sla.StepProposal)
+	defer cancel()
+	start := time.Now()
+	resp, err := p.dc.SendDealProposal(propCtx, id, params)
+	p.slas.Observe(params.ClientDealProposal.Proposal.Provider, params.DealUUID, sla.StepProposal, time.Since(start))
+	return resp, err
+}
+
+// transferChecker queries the status of deals to find out whether the
+// provider has started the data transfer
+type transferChecker struct {
+	node      *clinode.Node
+	api       lapi.Gateway
+	wallet    address.Address
+	overrides *transferoverrides.Store
+}
+
+func (c *transferChecker) TransferStarted(ctx context.Context, maddr address.Address, dealUUID uuid.UUID) (bool, error) {
+	override, err := providerTransferOverride(c.overrides, maddr)
+	if err != nil {
+		return false, err
+	}
+	addrInfo, err := overriddenAddrInfo(ctx, c.api, maddr, override)
+	if err != nil {
+		return false, err
+	}
+	if err := c.node.Host.Connect(ctx, *addrInfo); err != nil {
+		return false, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+
+	dc := lp2pimpl.NewDealClient(c.node.Host, c.wallet, clinode.DealProposalSigner{LocalWallet: c.node.Wallet})
+	resp, err := dc.SendDealStatusRequest(ctx, addrInfo.ID, dealUUID)
+	if err != nil {
+		return false, fmt.Errorf("send deal status request: %w", err)
+	}
+	if resp.Error != "" {
+		return false, fmt.Errorf("deal status error: %s", resp.Error)
+	}
+	if resp.DealStatus == nil {
+		return false, nil
+	}
+	return resp.NBytesReceived > 0 || resp.DealStatus.Status != dealcheckpoints.Accepted.String(), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
@@ -9,15 +10,19 @@ import (
 	"github.com/filecoin-project/boost/cli/ctxutil"
 	clinode "github.com/filecoin-project/boost/cli/node"
 	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/providerimpl"
 	"github.com/filecoin-project/boost/retrievalmarket/lp2pimpl"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 	// TODO: This multiaddr util library should probably live in its own repo
 	multiaddrutil "github.com/filecoin-project/go-legs/httpsync/multiaddr"
+	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/urfave/cli/v2"
 )
@@ -33,6 +38,7 @@ var providerCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		libp2pInfoCmd,
 		storageAskCmd,
+		watchAsksCmd,
 		retrievalAskCmd,
 		retrievalTransportsCmd,
 	},
@@ -42,7 +48,7 @@ var libp2pInfoCmd = &cli.Command{
 	Name:        "libp2p-info",
 	Usage:       "",
 	ArgsUsage:   "<provider address>",
-	Description: "Lists the libp2p address and protocols supported by the Storage Provider",
+	Description: "Lists the libp2p address and protocols supported by the Storage Provider, and the software it runs",
 	Before:      before,
 	Action: func(cctx *cli.Context) error {
 		ctx := ctxutil.ReqContext(cctx)
@@ -91,11 +97,13 @@ var libp2pInfoCmd = &cli.Command{
 			return fmt.Errorf("getting agent version for peer %s: %w", addrInfo.ID, err)
 		}
 		agentVersion, _ := agentVersionI.(string)
+		software := providerimpl.Detect(agentVersion, protos)
 
 		if cctx.Bool("json") {
 			return cmd.PrintJson(map[string]interface{}{
 				"provider":   addrStr,
 				"agent":      agentVersion,
+				"software":   software,
 				"id":         addrInfo.ID.String(),
 				"multiaddrs": addrInfo.Addrs,
 				"protocols":  protos,
@@ -104,6 +112,7 @@ var libp2pInfoCmd = &cli.Command{
 
 		fmt.Println("Provider: " + addrStr)
 		fmt.Println("Agent: " + agentVersion)
+		fmt.Println("Software: " + software.String())
 		fmt.Println("Peer ID: " + addrInfo.ID.String())
 		fmt.Println("Peer Addresses:")
 		for _, addr := range addrInfo.Addrs {
@@ -114,6 +123,20 @@ var libp2pInfoCmd = &cli.Command{
 	},
 }
 
+// providerSoftware identifies the software of a connected provider from the
+// agent version and protocols that its libp2p host reported
+func providerSoftware(n *clinode.Node, id peer.ID) providerimpl.Info {
+	protos, err := n.Host.Peerstore().GetProtocols(id)
+	if err != nil {
+		log.Debugw("getting provider protocols", "id", id, "err", err)
+	}
+	var agentVersion string
+	if v, err := n.Host.Peerstore().Get(id, "AgentVersion"); err == nil {
+		agentVersion, _ = v.(string)
+	}
+	return providerimpl.Detect(agentVersion, protos)
+}
+
 var storageAskCmd = &cli.Command{
 	Name:      "storage-ask",
 	Usage:     "Query a storage provider's storage ask",
@@ -154,35 +177,11 @@ var storageAskCmd = &cli.Command{
 			return err
 		}
 
-		addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+		ask, err := queryStorageAsk(ctx, n, api, maddr)
 		if err != nil {
 			return err
 		}
 
-		log.Debugw("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
-
-		if err := n.Host.Connect(ctx, *addrInfo); err != nil {
-			return fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
-		}
-
-		s, err := n.Host.NewStream(ctx, addrInfo.ID, AskProtocolID)
-		if err != nil {
-			return fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
-		}
-		defer s.Close()
-
-		var resp network.AskResponse
-
-		askRequest := network.AskRequest{
-			Miner: maddr,
-		}
-
-		if err := doRpc(ctx, s, &askRequest, &resp); err != nil {
-			return fmt.Errorf("send ask request rpc: %w", err)
-		}
-
-		ask := resp.Ask.Ask
-
 		afmt.Printf("Ask: %s\n", maddr)
 		afmt.Printf("Price per GiB: %s\n", types.FIL(ask.Price))
 		afmt.Printf("Verified Price per GiB: %s\n", types.FIL(ask.VerifiedPrice))
@@ -206,6 +205,41 @@ var storageAskCmd = &cli.Command{
 	},
 }
 
+// queryStorageAsk connects to the provider and queries its storage ask
+func queryStorageAsk(ctx context.Context, n *clinode.Node, api lapi.Gateway, maddr address.Address) (*storagemarket.StorageAsk, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugw("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
+
+	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+
+	s, err := n.Host.NewStream(ctx, addrInfo.ID, AskProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
+	}
+	defer s.Close()
+
+	var resp network.AskResponse
+
+	askRequest := network.AskRequest{
+		Miner: maddr,
+	}
+
+	if err := doRpc(ctx, s, &askRequest, &resp); err != nil {
+		return nil, fmt.Errorf("send ask request rpc: %w", err)
+	}
+	if resp.Ask == nil || resp.Ask.Ask == nil {
+		return nil, fmt.Errorf("provider %s returned an empty ask", maddr)
+	}
+
+	return resp.Ask.Ask, nil
+}
+
 var retrievalAskCmd = &cli.Command{
 	Name:      "retrieval-ask",
 	Usage:     "Query a storage provider's retrieval ask",
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+var reservationCmd = &cli.Command{
+	Name:  "reservation",
+	Usage: "Reserve capacity with a storage provider for a large onboarding campaign",
+	Description: "Deals made with the provider that start within the reservation window " +
+		"fill the reservation, until the reserved capacity has been used.",
+	Before: before,
+	Subcommands: []*cli.Command{
+		reservationCreateCmd,
+		reservationStatusCmd,
+	},
+}
+
+// reservationCreateOutput is the output of the reservation create command in
+// json mode
+type reservationCreateOutput struct {
+	ReservationID string         `json:"reservationId"`
+	Provider      string         `json:"provider"`
+	ClientWallet  string         `json:"clientWallet"`
+	Size          uint64         `json:"size"`
+	StartEpoch    abi.ChainEpoch `json:"startEpoch"`
+	EndEpoch      abi.ChainEpoch `json:"endEpoch"`
+	Deposit       string         `json:"deposit"`
+}
+
+// reservationStatusOutput is the output of the reservation status command in
+// json mode
+type reservationStatusOutput struct {
+	ReservationID string `json:"reservationId"`
+	Provider      string `json:"provider"`
+	ClientWallet  string `json:"clientWallet"`
+	// One of Active, Filled or Expired
+	State      string         `json:"state"`
+	Size       uint64         `json:"size"`
+	Filled     uint64         `json:"filled"`
+	DealCount  uint64         `json:"dealCount"`
+	StartEpoch abi.ChainEpoch `json:"startEpoch"`
+	EndEpoch   abi.ChainEpoch `json:"endEpoch"`
+	Deposit    string         `json:"deposit"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("reservation create", reservationCreateOutput{})
+	cmd.RegisterJsonOutput("reservation status", reservationStatusOutput{})
+}
+
+var reservationCreateCmd = &cli.Command{
+	Name:  "create",
+	Usage: "Reserve capacity with a storage provider",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "provider",
+			Usage:    "storage provider on-chain address",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "size",
+			Usage:    "the amount of capacity to reserve (total padded piece size of the deals), eg 100TiB",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:        "start-epoch",
+			Usage:       "the start of the window in which deals may start",
+			DefaultText: "current chain head",
+		},
+		&cli.IntFlag{
+			Name:  "duration",
+			Usage: "the duration of the reservation window in epochs",
+			Value: 86400, // default is 2880 * 30 == 30 days
+		},
+		&cli.StringFlag{
+			Name:  "deposit",
+			Usage: "an amount (in FIL) that the client commits to keep in market escrow for the reservation",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "wallet address to be used to make the reservation and the deals that fill it",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		size, err := humanize.ParseBytes(cctx.String("size"))
+		if err != nil {
+			return fmt.Errorf("parsing size %s: %w", cctx.String("size"), err)
+		}
+		deposit, err := chain_types.ParseFIL(cctx.String("deposit"))
+		if err != nil {
+			return fmt.Errorf("parsing deposit %s: %w", cctx.String("deposit"), err)
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		maddr, err := address.NewFromString(cctx.String("provider"))
+		if err != nil {
+			return err
+		}
+
+		var startEpoch abi.ChainEpoch
+		if cctx.IsSet("start-epoch") {
+			startEpoch = abi.ChainEpoch(cctx.Int("start-epoch"))
+		} else {
+			tipset, err := api.ChainHead(ctx)
+			if err != nil {
+				return fmt.Errorf("getting chain head: %w", err)
+			}
+			startEpoch = tipset.Height()
+		}
+
+		addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+		if err != nil {
+			return err
+		}
+
+		log.Debugw("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
+
+		if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+			return fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+		}
+
+		res := types.CapacityReservation{
+			ID:         uuid.New(),
+			Client:     walletAddr,
+			Provider:   maddr,
+			Size:       size,
+			StartEpoch: startEpoch,
+			EndEpoch:   startEpoch + abi.ChainEpoch(cctx.Int("duration")),
+			Deposit:    big.Int(deposit),
+		}
+
+		dc := lp2pimpl.NewDealClient(n.Host, walletAddr, clinode.DealProposalSigner{LocalWallet: n.Wallet})
+		resp, err := dc.SendCapacityReservation(ctx, addrInfo.ID, res)
+		if err != nil {
+			return fmt.Errorf("send capacity reservation failed: %w", err)
+		}
+		if !resp.Accepted {
+			return fmt.Errorf("capacity reservation rejected: %s", resp.Message)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(reservationCreateOutput{
+				ReservationID: res.ID.String(),
+				Provider:      maddr.String(),
+				ClientWallet:  walletAddr.String(),
+				Size:          res.Size,
+				StartEpoch:    res.StartEpoch,
+				EndEpoch:      res.EndEpoch,
+				Deposit:       res.Deposit.String(),
+			})
+		}
+
+		msg := "capacity reservation accepted\n"
+		msg += fmt.Sprintf("  reservation id: %s\n", res.ID)
+		msg += fmt.Sprintf("  size: %s\n", humanize.IBytes(res.Size))
+		msg += fmt.Sprintf("  window: epoch %d to %d\n", res.StartEpoch, res.EndEpoch)
+		msg += fmt.Sprintf("  deposit: %s\n", chain_types.FIL(res.Deposit))
+		fmt.Println(msg)
+		return nil
+	},
+}
+
+var reservationStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "Get the status of a capacity reservation",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "provider",
+			Usage:    "storage provider on-chain address",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "reservation-id",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "the wallet address that was used to make the reservation",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		id, err := uuid.Parse(cctx.String("reservation-id"))
+		if err != nil {
+			return err
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		maddr, err := address.NewFromString(cctx.String("provider"))
+		if err != nil {
+			return err
+		}
+
+		addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+		if err != nil {
+			return err
+		}
+		if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+			return fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+		}
+
+		dc := lp2pimpl.NewDealClient(n.Host, walletAddr, clinode.DealProposalSigner{LocalWallet: n.Wallet})
+		resp, err := dc.SendCapacityReservationStatusRequest(ctx, addrInfo.ID, id)
+		if err != nil {
+			return fmt.Errorf("send capacity reservation status request failed: %w", err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("getting capacity reservation status: %s", resp.Error)
+		}
+		if resp.Status == nil {
+			return fmt.Errorf("getting capacity reservation status: empty response")
+		}
+
+		st := resp.Status
+		res := st.Reservation
+		if cctx.Bool("json") {
+			return cmd.PrintJson(reservationStatusOutput{
+				ReservationID: res.ID.String(),
+				Provider:      res.Provider.String(),
+				ClientWallet:  res.Client.String(),
+				State:         st.State,
+				Size:          res.Size,
+				Filled:        st.Filled,
+				DealCount:     st.DealCount,
+				StartEpoch:    res.StartEpoch,
+				EndEpoch:      res.EndEpoch,
+				Deposit:       res.Deposit.String(),
+			})
+		}
+
+		msg := "got capacity reservation status response\n"
+		msg += fmt.Sprintf("  reservation id: %s\n", res.ID)
+		msg += fmt.Sprintf("  state: %s\n", st.State)
+		msg += fmt.Sprintf("  filled: %s / %s (%d deals)\n", humanize.IBytes(st.Filled), humanize.IBytes(res.Size), st.DealCount)
+		msg += fmt.Sprintf("  window: epoch %d to %d\n", res.StartEpoch, res.EndEpoch)
+		msg += fmt.Sprintf("  deposit: %s\n", chain_types.FIL(res.Deposit))
+		fmt.Println(msg)
+		return nil
+	},
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/httpretrieval"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/lib/retrievalcap"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// retrievalCapFlags are the flags of the retrieval commands that cap the
+// cost of retrievals
+var retrievalCapFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: "max-retrieval-cost",
+		Usage: "the most FIL to spend on a single retrieval, eg '0.01 FIL': offers above the cap are refused, " +
+			"and the retrieval is aborted if it would exceed the cap part way through",
+	},
+	&cli.StringFlag{
+		Name:  "max-daily-retrieval-cost",
+		Usage: "the most FIL to spend on retrievals per day (UTC), across all retrievals from the client repo",
+	},
+	&cli.DurationFlag{
+		Name:  "reprice-interval",
+		Usage: "how often to check whether the provider has changed its price during a capped retrieval",
+		Value: time.Minute,
+	},
+}
+
+// retrievalCaps creates metered sessions for retrievals, if a cap is set
+type retrievalCaps struct {
+	caps            retrievalcap.Caps
+	ledger          *retrievalcap.Ledger
+	repriceInterval time.Duration
+	n               *clinode.Node
+	api             lapi.Gateway
+}
+
+// newRetrievalCaps returns nil if no cap is set
+func newRetrievalCaps(cctx *cli.Context, n *clinode.Node, api lapi.Gateway) (*retrievalCaps, error) {
+	caps, err := retrievalcap.ParseCaps(cctx.String("max-retrieval-cost"), cctx.String("max-daily-retrieval-cost"))
+	if err != nil {
+		return nil, err
+	}
+	if !caps.Enabled() {
+		return nil, nil
+	}
+
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &retrievalCaps{
+		caps:            caps,
+		ledger:          retrievalcap.NewLedger(filepath.Join(sdir, "retrieval-spend.json")),
+		repriceInterval: cctx.Duration("reprice-interval"),
+		n:               n,
+		api:             api,
+	}, nil
+}
+
+// The transports over which a retrieval is priced
+const (
+	// A download of the CAR file from the provider's booster-http endpoint,
+	// which is free unless the endpoint announces payment terms
+	retrievalTransportHTTP = "http"
+	// A graphsync retrieval, priced by the provider's retrieval ask
+	retrievalTransportGraphsync = "graphsync"
+)
+
+// meter starts a metered session for a retrieval of the payload over the
+// transport, of the expected size (zero if not known). The piece cid may be
+// nil.
+func (c *retrievalCaps) meter(transport string, payloadCid cid.Cid, pieceCid *cid.Cid, expected uint64) (*retrievalMeter, error) {
+	if !payloadCid.Defined() {
+		return nil, errors.New("the payload cid must be known to check the retrieval price against the cost cap (set --payload-cid)")
+	}
+	s, err := retrievalcap.NewSession(c.caps, c.ledger, expected)
+	if err != nil {
+		return nil, err
+	}
+	return &retrievalMeter{caps: c, session: s, transport: transport, payloadCid: payloadCid, pieceCid: pieceCid}, nil
+}
+
+// retrievalMeter charges the data received from each provider at the price
+// it offers, and watches for the provider changing its price
+type retrievalMeter struct {
+	caps       *retrievalCaps
+	session    *retrievalcap.Session
+	transport  string
+	payloadCid cid.Cid
+	pieceCid   *cid.Cid
+
+	lk          sync.Mutex
+	stopReprice context.CancelFunc
+}
+
+var _ carfetch.Meter = (*retrievalMeter)(nil)
+
+func (m *retrievalMeter) Start(ctx context.Context, source string, endpoint string, offset int64) error {
+	m.stop()
+
+	maddr, err := address.NewFromString(source)
+	if err != nil {
+		return fmt.Errorf("parsing provider address %s: %w", source, err)
+	}
+	price, err := m.price(ctx, maddr, endpoint)
+	if err != nil {
+		return err
+	}
+	if err := m.session.Offer(source, price, uint64(offset)); err != nil {
+		return err
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	m.lk.Lock()
+	m.stopReprice = cancel
+	m.lk.Unlock()
+	go m.watchPrice(rctx, maddr, endpoint)
+	return nil
+}
+
+func (m *retrievalMeter) Received(n int64) error {
+	return m.session.Receive(uint64(n))
+}
+
+// watchPrice periodically queries the provider's price, and reprices the
+// rest of the retrieval if it changes
+func (m *retrievalMeter) watchPrice(ctx context.Context, maddr address.Address, endpoint string) {
+	t := time.NewTicker(m.caps.repriceInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		price, err := m.price(ctx, maddr, endpoint)
+		if err != nil {
+			if ctx.Err() == nil {
+				logctx.Logger(ctx, log).Infow("checking retrieval price", "provider", maddr, "err", err)
+			}
+			continue
+		}
+		if err := m.session.Reprice(price); err != nil {
+			logctx.Logger(ctx, log).Warnw("aborting retrieval", "provider", maddr, "reason", err)
+			return
+		}
+	}
+}
+
+func (m *retrievalMeter) stop() {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if m.stopReprice != nil {
+		m.stopReprice()
+		m.stopReprice = nil
+	}
+}
+
+// finish stops watching the price, records what was spent in the daily
+// ledger and on the retrieval record
+func (m *retrievalMeter) finish(ctx context.Context, rec *retrievals.Record) {
+	m.stop()
+	if err := m.session.Record(); err != nil {
+		logctx.Logger(ctx, log).Warnw("recording retrieval spend", "err", err)
+	}
+	rec.Spent = types.FIL(m.session.Spent()).Short()
+}
+
+// price queries the price of the retrieval from the provider over the
+// meter's transport
+func (m *retrievalMeter) price(ctx context.Context, maddr address.Address, endpoint string) (retrievalcap.Price, error) {
+	switch m.transport {
+	case retrievalTransportHTTP:
+		return httpPrice(ctx, endpoint, m.payloadCid)
+	case retrievalTransportGraphsync:
+		return m.caps.queryPrice(ctx, maddr, m.payloadCid, m.pieceCid)
+	default:
+		return retrievalcap.Price{}, fmt.Errorf("unknown retrieval transport %s", m.transport)
+	}
+}
+
+// httpPrice probes the provider's http endpoint for the payload. The
+// download is free unless the endpoint requires payment, in which case it
+// is charged at the endpoint's announced price per byte.
+func httpPrice(ctx context.Context, endpoint string, payloadCid cid.Cid) (retrievalcap.Price, error) {
+	avail, terms, err := httpretrieval.NewClient(nil).Probe(ctx, endpoint, payloadCid)
+	if err != nil {
+		return retrievalcap.Price{}, err
+	}
+	switch avail {
+	case httpretrieval.Free:
+		return retrievalcap.Price{UnsealPrice: big.Zero(), PricePerByte: big.Zero()}, nil
+	case httpretrieval.Paid:
+		return retrievalcap.Price{UnsealPrice: big.Zero(), PricePerByte: terms.PricePerByte}, nil
+	default:
+		return retrievalcap.Price{}, fmt.Errorf("endpoint %s does not serve %s", endpoint, payloadCid)
+	}
+}
+
+// queryPrice queries the provider's graphsync retrieval ask for the payload
+func (c *retrievalCaps) queryPrice(ctx context.Context, maddr address.Address, payloadCid cid.Cid, pieceCid *cid.Cid) (retrievalcap.Price, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, c.api, maddr)
+	if err != nil {
+		return retrievalcap.Price{}, err
+	}
+	if err := c.n.Host.Connect(ctx, *addrInfo); err != nil {
+		return retrievalcap.Price{}, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+	s, err := c.n.Host.NewStream(ctx, addrInfo.ID, QueryProtocolID)
+	if err != nil {
+		return retrievalcap.Price{}, fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
+	}
+	defer s.Close()
+
+	req := retrievalmarket.Query{
+		PayloadCID:  payloadCid,
+		QueryParams: retrievalmarket.QueryParams{PieceCID: pieceCid},
+	}
+	var ask retrievalmarket.QueryResponse
+	if err := doRpc(ctx, s, &req, &ask); err != nil {
+		return retrievalcap.Price{}, fmt.Errorf("send retrieval-ask request rpc: %w", err)
+	}
+	if ask.Status != retrievalmarket.QueryResponseAvailable {
+		return retrievalcap.Price{}, fmt.Errorf("provider %s did not offer a retrieval price (status %d): %s", maddr, ask.Status, ask.Message)
+	}
+	return retrievalcap.Price{UnsealPrice: ask.UnsealPrice, PricePerByte: ask.MinPricePerByte}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/contentscan"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// retrievalScanFlags are the flags of the retrieval commands that scan
+// retrieved content before the retrieval is completed
+var retrievalScanFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: "scanner",
+		Usage: "scan each retrieved CAR file before completing the retrieval, and quarantine it if it's flagged: " +
+			"clamd://host:port, clamd:///path/to/clamd.sock, icap://host:port/service or cmd:<command> " +
+			"(a command that reads the content on stdin and exits 1 to flag it)",
+		EnvVars: []string{"BOOST_RETRIEVAL_SCANNER"},
+	},
+	&cli.BoolFlag{
+		Name:  "scan-fail-open",
+		Usage: "complete the retrieval without a scan if the content can't be scanned (by default the retrieval fails)",
+	},
+	&cli.DurationFlag{
+		Name:  "scan-timeout",
+		Usage: "the maximum time to spend scanning a retrieved CAR file",
+		Value: 30 * time.Minute,
+	},
+}
+
+// retrievalScanner scans retrieved CAR files, and moves the files that are
+// flagged to the quarantine directory in the client repo
+type retrievalScanner struct {
+	scanner       contentscan.Scanner
+	failOpen      bool
+	timeout       time.Duration
+	quarantineDir string
+}
+
+// newRetrievalScanner returns nil if no scanner is set
+func newRetrievalScanner(cctx *cli.Context) (*retrievalScanner, error) {
+	spec := cctx.String("scanner")
+	if spec == "" {
+		return nil, nil
+	}
+	scanner, err := contentscan.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &retrievalScanner{
+		scanner:       scanner,
+		failOpen:      cctx.Bool("scan-fail-open"),
+		timeout:       cctx.Duration("scan-timeout"),
+		quarantineDir: filepath.Join(sdir, "quarantine"),
+	}, nil
+}
+
+// quarantinedError is returned when the scanner flags a retrieved CAR file,
+// which has been moved to quarantine
+type quarantinedError struct {
+	path    string
+	flagged *contentscan.FlaggedError
+}
+
+func (e *quarantinedError) Error() string {
+	return fmt.Sprintf("%s: moved the CAR file to %s", e.flagged, e.path)
+}
+
+func (e *quarantinedError) Unwrap() error {
+	return e.flagged
+}
+
+// scan scans the retrieved CAR file at path. If the scanner flags the file,
+// it is moved to quarantine and a *quarantinedError is returned. If the file
+// can't be scanned the scan fails, unless the scanner fails open.
+// A nil scanner doesn't scan.
+func (s *retrievalScanner) scan(ctx context.Context, path string) error {
+	if s == nil {
+		return nil
+	}
+	tlog := logctx.Logger(ctx, log)
+
+	sctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	start := time.Now()
+	err := contentscan.ScanFile(sctx, s.scanner, path)
+	if err == nil {
+		tlog.Debugw("scanned retrieved content", "path", path, "scanner", s.scanner, "took", time.Since(start))
+		return nil
+	}
+
+	var ferr *contentscan.FlaggedError
+	if !errors.As(err, &ferr) {
+		if s.failOpen {
+			tlog.Warnw("completing retrieval without a content scan", "path", path, "err", err)
+			return nil
+		}
+		return err
+	}
+
+	tlog.Warnw("retrieved content flagged by scanner", "path", path, "scanner", ferr.Scanner, "finding", ferr.Finding)
+	qpath, qerr := contentscan.Quarantine(path, s.quarantineDir)
+	if qerr != nil {
+		// Don't leave the flagged content where it would be used
+		if rerr := os.Remove(path); rerr != nil {
+			return fmt.Errorf("%w (%s, and failed to remove it: %s)", ferr, qerr, rerr)
+		}
+		return fmt.Errorf("%w (%s: removed the CAR file)", ferr, qerr)
+	}
+	return &quarantinedError{path: qpath, flagged: ferr}
+}
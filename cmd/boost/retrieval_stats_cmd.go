@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/go-address"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+)
+
+// pieceRetrievalStatsOutput is the output of the retrieval-stats command in
+// json mode, for each piece
+type pieceRetrievalStatsOutput struct {
+	PieceCid    string     `json:"pieceCid"`
+	Retrievals  uint64     `json:"retrievals"`
+	BytesServed uint64     `json:"bytesServed"`
+	LastAccess  *time.Time `json:"lastAccess,omitempty"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("retrieval-stats", []pieceRetrievalStatsOutput{})
+}
+
+var retrievalStatsCmd = &cli.Command{
+	Name:  "retrieval-stats",
+	Usage: "Get the retrieval statistics of the pieces stored with a storage provider",
+	Description: "The provider only returns statistics for the pieces of deals made by the wallet. " +
+		"The request is signed with the wallet to prove ownership.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "provider",
+			Usage:    "storage provider on-chain address",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "the wallet address that was used to make the deals",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		maddr, err := address.NewFromString(cctx.String("provider"))
+		if err != nil {
+			return err
+		}
+
+		addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+		if err != nil {
+			return err
+		}
+		if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+			return fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+		}
+
+		dc := lp2pimpl.NewDealClient(n.Host, walletAddr, clinode.DealProposalSigner{LocalWallet: n.Wallet})
+		resp, err := dc.SendRetrievalStatsRequest(ctx, addrInfo.ID, maddr)
+		if err != nil {
+			return fmt.Errorf("send retrieval stats request failed: %w", err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("getting retrieval stats: %s", resp.Error)
+		}
+
+		if cctx.Bool("json") {
+			out := make([]pieceRetrievalStatsOutput, 0, len(resp.Pieces))
+			for _, ps := range resp.Pieces {
+				o := pieceRetrievalStatsOutput{
+					PieceCid:    ps.PieceCid.String(),
+					Retrievals:  ps.Retrievals,
+					BytesServed: ps.BytesServed,
+				}
+				if ps.LastAccess != 0 {
+					at := time.Unix(ps.LastAccess, 0)
+					o.LastAccess = &at
+				}
+				out = append(out, o)
+			}
+			return cmd.PrintJson(out)
+		}
+
+		if len(resp.Pieces) == 0 {
+			fmt.Printf("no pieces stored with %s by %s\n", maddr, walletAddr)
+			return nil
+		}
+
+		msg := fmt.Sprintf("retrieval stats for %d pieces stored with %s by %s\n", len(resp.Pieces), maddr, walletAddr)
+		for _, ps := range resp.Pieces {
+			lastAccess := "never"
+			if ps.LastAccess != 0 {
+				lastAccess = humanize.Time(time.Unix(ps.LastAccess, 0))
+			}
+			msg += fmt.Sprintf("  %s: %d retrievals, %s served, last access %s\n",
+				ps.PieceCid, ps.Retrievals, humanize.IBytes(ps.BytesServed), lastAccess)
+		}
+		fmt.Print(msg)
+		return nil
+	},
+}
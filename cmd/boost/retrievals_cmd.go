@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/lib/retrievalcap"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+var serveRetrievalsCmd = &cli.Command{
+	Name:  "serve-retrievals",
+	Usage: "Serve the content of completed and in-progress retrievals over http",
+	Description: "Retrievals made with the retrieve and retrieve-many commands are recorded in the client repo. " +
+		"This command serves them so that downstream systems can consume retrieved data without access to " +
+		"the client's filesystem:\n\n" +
+		"   GET /retrievals              list retrievals\n" +
+		"   GET /retrievals/{id}         get a retrieval\n" +
+		"   GET /retrievals/{id}/car     stream the CAR file (in-progress retrievals are streamed as data arrives)\n" +
+		"   GET /retrievals/{id}/file    the unixfs file extracted from a completed retrieval\n\n" +
+		"   The prefetch API (see the prefetch command) is also served under /prefetch.\n\n" +
+		"   Requests must have an 'Authorization: Bearer <token>' header. If no token is given, a token\n" +
+		"   is generated and saved in the client repo. Requests may also be made with one of the API tokens\n" +
+		"   created with the api-token command, in which case the bytes served are charged to the token's quota.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "the address to listen on for http requests",
+			Value: "127.0.0.1:8767",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "the bearer token that callers must present",
+			EnvVars: []string{"BOOST_RETRIEVALS_TOKEN"},
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		store, err := openRetrievalStore(cctx)
+		if err != nil {
+			return err
+		}
+
+		token := cctx.String("token")
+		tokenPath := ""
+		if token == "" {
+			token, tokenPath, err = retrievalsToken(cctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		ln, err := net.Listen("tcp", cctx.String("listen"))
+		if err != nil {
+			return fmt.Errorf("listening on %s: %w", cctx.String("listen"), err)
+		}
+		tokens, err := openAPITokenStore(cctx)
+		if err != nil {
+			return err
+		}
+		predictions, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		handler := retrievals.NewHandler(store, token, retrievals.TokenQuotas(tokens), retrievals.Prefetch(predictions))
+		srv := &http.Server{Handler: handler}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+
+		fmt.Printf("Serving retrievals on http://%s\n", ln.Addr())
+		if tokenPath != "" {
+			fmt.Printf("Bearer token is in %s\n", tokenPath)
+		}
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+// openRetrievalStore opens the store in the client repo that records
+// retrievals
+func openRetrievalStore(cctx *cli.Context) (*retrievals.Store, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return retrievals.NewStore(filepath.Join(sdir, "retrievals"))
+}
+
+// retrievalsToken reads the bearer token from the client repo, generating
+// it if it doesn't exist yet
+func retrievalsToken(cctx *cli.Context) (string, string, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return "", "", err
+	}
+	path := filepath.Join(sdir, "retrievals-token")
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(b)), path, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", "", fmt.Errorf("reading retrievals token: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("generating retrievals token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		return "", "", fmt.Errorf("saving retrievals token: %w", err)
+	}
+	return token, path, nil
+}
+
+// startRetrievalRecord records an in-progress retrieval in the client repo,
+// and returns a function that records its result. Failing to record the
+// retrieval doesn't fail the retrieval itself.
+// The retrieval's record id is its transfer id: the returned context carries
+// it so that every log line for the retrieval includes it.
+func startRetrievalRecord(ctx context.Context, store *retrievals.Store, rec *retrievals.Record) (context.Context, func(payloadCid cid.Cid, path string, size int64, err error)) {
+	rec.ID = uuid.New()
+	ctx = logctx.WithTransfer(ctx, rec.ID.String())
+	tlog := logctx.Logger(ctx, log)
+
+	if abs, err := filepath.Abs(rec.Path); err == nil {
+		rec.Path = abs
+	}
+	if err := store.Start(rec); err != nil {
+		tlog.Warnw("recording retrieval", "err", err)
+		return ctx, func(cid.Cid, string, int64, error) {}
+	}
+
+	return ctx, func(payloadCid cid.Cid, path string, size int64, rerr error) {
+		var err error
+		var cerr *retrievalcap.ExceededError
+		var qerr *quarantinedError
+		if errors.As(rerr, &cerr) {
+			err = store.Abort(rec, cerr)
+		} else if errors.As(rerr, &qerr) {
+			rec.PayloadCid = payloadCid
+			err = store.Quarantine(rec, qerr.path, size, qerr.flagged)
+		} else if rerr != nil {
+			err = store.Fail(rec, rerr)
+		} else {
+			if abs, aerr := filepath.Abs(path); aerr == nil {
+				path = abs
+			}
+			rec.PayloadCid = payloadCid
+			err = store.Complete(rec, path, size)
+		}
+		if err != nil {
+			tlog.Warnw("recording retrieval result", "err", err)
+		}
+	}
+}
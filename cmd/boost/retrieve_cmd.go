@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/attestation"
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/boost/lib/diskusage"
+	"github.com/filecoin-project/boost/lib/nameresolve"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/filecoin-project/boost/retrievalmarket/lp2pimpl"
+	"github.com/filecoin-project/go-address"
+	multiaddrutil "github.com/filecoin-project/go-legs/httpsync/multiaddr"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	lapi "github.com/filecoin-project/lotus/api"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipns"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+// retrieveOutput is the output of the retrieve command in json mode
+type retrieveOutput struct {
+	DealID     uint64 `json:"dealId"`
+	Provider   string `json:"provider"`
+	PieceCid   string `json:"pieceCid"`
+	PayloadCid string `json:"payloadCid"`
+	// Where the payload cid came from: the flag, the deal label, the CAR
+	// header or the dnslink:// or ipns:// name it was resolved from
+	PayloadSource string `json:"payloadSource"`
+	Path          string `json:"path"`
+	Size          int64  `json:"size"`
+	// How the payload cid was resolved, if it was given as a name
+	Resolution *nameresolve.Resolution `json:"resolution,omitempty"`
+	// The path of the signed attestation for the retrieval
+	AttestationPath string `json:"attestationPath,omitempty"`
+	// The attempts to retrieve from each provider, if the retrieval failed
+	// over from one provider to another
+	Attempts []carfetch.Attempt `json:"attempts"`
+	// What was spent on the retrieval, if a cost cap was set
+	Spent string `json:"spent,omitempty"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("retrieve", retrieveOutput{})
+}
+
+var retrieveCmd = &cli.Command{
+	Name:      "retrieve",
+	Usage:     "Retrieve the data for an on-chain deal as a CAR file",
+	ArgsUsage: "<deal id> <output car path>",
+	Description: "Looks up the provider and piece for the deal on chain, discovers the payload root cid " +
+		"and retrieves the CAR file from the provider over http. The retrieved data is checked against the deal's " +
+		"piece cid, and the payload root cid against the CAR header. If the retrieval fails part way, it " +
+		"continues from the bytes already received with the next fallback provider. If a cost cap is set, " +
+		"providers whose price would exceed the cap are skipped, and the retrieval is aborted if the provider " +
+		"changes its price part way through such that the cap would be exceeded.",
+	Before: before,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name: "payload-cid",
+			Usage: "the payload root cid of the deal, if known (by default it is discovered from the deal label or the CAR file header); " +
+				"may be a dnslink://<domain> or ipns://<name> that resolves to the root cid",
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "wallet address used to sign the retrieval attestation (defaults to the default wallet)",
+		},
+		&cli.StringSliceFlag{
+			Name: "fallback-provider",
+			Usage: "a provider that also holds the deal's piece, to continue the retrieval from if it fails " +
+				"(can be repeated; providers are tried in order)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-attestation",
+			Usage: "don't write a signed attestation of the retrieval alongside the output CAR file",
+		},
+	}, append(append(retrievalCapFlags, retrievalScanFlags...), sparkFlags...)...),
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 2 {
+			return fmt.Errorf("usage: retrieve <deal id> <output car path>")
+		}
+		dealID, err := strconv.ParseUint(cctx.Args().Get(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing deal id '%s': %w", cctx.Args().Get(0), err)
+		}
+		outPath := cctx.Args().Get(1)
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		// Look up the deal on chain
+		deal, err := api.StateMarketStorageDeal(ctx, abi.DealID(dealID), chain_types.EmptyTSK)
+		if err != nil {
+			return fmt.Errorf("getting deal %d from chain: %w", dealID, err)
+		}
+		prop := deal.Proposal
+		log.Debugw("found deal", "id", dealID, "provider", prop.Provider, "piece", prop.PieceCID)
+
+		// The CAR file is no larger than the unpadded piece
+		if err := checkDiskSpace(cctx, diskusage.KindRetrieval, outPath, uint64(prop.PieceSize.Unpadded())); err != nil {
+			return err
+		}
+
+		// Use the payload cid from the flag or the deal label, if there is one
+		payloadCid := cid.Undef
+		payloadSource := ""
+		var resolution *nameresolve.Resolution
+		if target := cctx.String("payload-cid"); nameresolve.IsName(target) {
+			resolver, closeResolver, err := newNameResolver(ctx, n)
+			if err != nil {
+				return err
+			}
+			resolution, err = resolver.Resolve(ctx, target)
+			closeResolver()
+			if err != nil {
+				return err
+			}
+			payloadCid = resolution.Root
+			payloadSource = target
+		} else if cctx.IsSet("payload-cid") {
+			payloadCid, err = cid.Parse(target)
+			if err != nil {
+				return fmt.Errorf("parsing payload cid %s: %w", target, err)
+			}
+			payloadSource = "flag"
+		} else if c, ok := labelPayloadCid(prop.Label); ok {
+			payloadCid = c
+			payloadSource = "deal label"
+		}
+
+		// Retrieve from the deal's provider, and fail over to the fallback
+		// providers (which hold the same piece) if the retrieval fails
+		providers := []address.Address{prop.Provider}
+		for _, a := range cctx.StringSlice("fallback-provider") {
+			maddr, err := address.NewFromString(a)
+			if err != nil {
+				return fmt.Errorf("parsing fallback provider address %s: %w", a, err)
+			}
+			providers = append(providers, maddr)
+		}
+
+		// If there's a cost cap, check each provider's price before
+		// retrieving from it. The CAR file is no larger than the unpadded
+		// piece, so its cost is checked up front against that size.
+		var fetchOpts []carfetch.Option
+		var meter *retrievalMeter
+		caps, err := newRetrievalCaps(cctx, n, api)
+		if err != nil {
+			return err
+		}
+		if caps != nil {
+			meter, err = caps.meter(retrievalTransportHTTP, payloadCid, &prop.PieceCID, uint64(prop.PieceSize.Unpadded()))
+			if err != nil {
+				return err
+			}
+			fetchOpts = append(fetchOpts, carfetch.WithMeter(meter))
+		}
+
+		scanner, err := newRetrievalScanner(cctx)
+		if err != nil {
+			return err
+		}
+
+		// Record the retrieval in the client repo, so that it can be served
+		// by serve-retrievals while it's in progress and once it completes
+		store, err := openRetrievalStore(cctx)
+		if err != nil {
+			return err
+		}
+		rec := &retrievals.Record{
+			PayloadCid: payloadCid,
+			PieceCid:   &prop.PieceCID,
+			DealID:     dealID,
+			Provider:   prop.Provider.String(),
+			Path:       outPath,
+			Scanned:    scanner != nil,
+		}
+		ctx, finish := startRetrievalRecord(ctx, store, rec)
+
+		// Retrieve the CAR file for the piece. If the user opted in, the
+		// fallback providers are tried in order of their retrievability
+		// score, and the outcome of each attempt is reported.
+		spk := newSparkClient(ctx, cctx)
+		sources := retrievalSources(n, api, providers)
+		sources = append(sources[:1], spk.order(sources[1:])...)
+		query := url.Values{"pieceCid": {prop.PieceCID.String()}}
+		res, err := carfetch.Fetch(ctx, sources, query, outPath, fetchOpts...)
+		if res != nil {
+			defer func() {
+				spk.record(payloadCid, res.Attempts)
+				spk.flush(ctx)
+			}()
+		}
+		if meter != nil {
+			meter.finish(ctx, rec)
+		}
+		if err != nil {
+			finish(payloadCid, outPath, 0, err)
+			return err
+		}
+		size := res.Size
+		provider := res.Source()
+		rec.Provider = provider
+
+		// Check that the retrieved data is the deal's piece
+		if err := verifyPieceCid(ctx, outPath, prop.PieceCID, prop.PieceSize); err != nil {
+			finish(payloadCid, outPath, size, err)
+			return err
+		}
+
+		// Check the payload cid against the roots in the CAR file header,
+		// or discover it from the header if it's not known
+		payloadCid, payloadSource, err = checkPayloadRoot(payloadCid, payloadSource, res.Roots)
+		if err != nil {
+			finish(payloadCid, outPath, size, err)
+			return err
+		}
+
+		// Scan the content before completing the retrieval
+		if err := scanner.scan(ctx, outPath); err != nil {
+			finish(payloadCid, outPath, size, err)
+			return err
+		}
+		finish(payloadCid, outPath, size, nil)
+
+		// Sign an attestation of the verified retrieval, and store it
+		// alongside the CAR file
+		attestationPath := ""
+		if !cctx.Bool("no-attestation") {
+			attestationPath, err = writeRetrievalAttestation(ctx, n, cctx.String("wallet"), outPath, attestation.Attestation{
+				RootCid:   payloadCid,
+				PieceCid:  &prop.PieceCID,
+				Size:      size,
+				Provider:  provider,
+				DealID:    dealID,
+				Timestamp: time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(retrieveOutput{
+				DealID:          dealID,
+				Provider:        provider,
+				PieceCid:        prop.PieceCID.String(),
+				PayloadCid:      payloadCid.String(),
+				PayloadSource:   payloadSource,
+				Path:            outPath,
+				Size:            size,
+				Resolution:      resolution,
+				AttestationPath: attestationPath,
+				Attempts:        res.Attempts,
+				Spent:           rec.Spent,
+			})
+		}
+		fmt.Printf("Retrieved deal %d from %s\n", dealID, provider)
+		if len(res.Attempts) > 1 {
+			for _, a := range res.Attempts[:len(res.Attempts)-1] {
+				fmt.Printf("  failed over from %s after %d bytes: %s\n", a.Source, a.Received, a.Error)
+			}
+		}
+		fmt.Printf("  piece cid: %s\n", prop.PieceCID)
+		fmt.Printf("  payload cid: %s (from %s)\n", payloadCid, payloadSource)
+		if resolution != nil {
+			printResolution(resolution)
+		}
+		fmt.Printf("  wrote %d bytes to %s\n", size, outPath)
+		if rec.Spent != "" {
+			fmt.Printf("  spent %s\n", rec.Spent)
+		}
+		if attestationPath != "" {
+			fmt.Printf("  wrote signed attestation to %s\n", attestationPath)
+		}
+		return nil
+	},
+}
+
+// labelPayloadCid returns the payload cid in the deal label, if the label
+// is a cid
+func labelPayloadCid(label market.DealLabel) (cid.Cid, bool) {
+	str, err := label.ToString()
+	if err != nil {
+		return cid.Undef, false
+	}
+	c, err := cid.Parse(str)
+	if err != nil {
+		return cid.Undef, false
+	}
+	return c, true
+}
+
+// checkPayloadRoot checks that the payload cid is one of the roots in the
+// header of the retrieved CAR file. If the payload cid isn't known, it is
+// discovered from the header. It returns the payload cid and its source.
+func checkPayloadRoot(payloadCid cid.Cid, source string, roots []cid.Cid) (cid.Cid, string, error) {
+	if !payloadCid.Defined() {
+		if len(roots) == 0 {
+			return cid.Undef, "", fmt.Errorf("retrieved CAR file has no roots")
+		}
+		return roots[0], "CAR header", nil
+	}
+	for _, r := range roots {
+		if r.Equals(payloadCid) {
+			return payloadCid, source, nil
+		}
+	}
+	return payloadCid, source, fmt.Errorf("payload cid %s (from %s) is not a root of the retrieved CAR file (roots: %s)", payloadCid, source, roots)
+}
+
+// verifyPieceCid checks that the retrieved CAR file has the deal's piece
+// cid, so that data that is not the deal's piece (eg because the provider
+// or a fallback provider served the wrong data) is rejected
+func verifyPieceCid(ctx context.Context, path string, pieceCid cid.Cid, pieceSize abi.PaddedPieceSize) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening retrieved CAR file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	if err := commp.Verify(ctx, commp.Default(), f, pieceCid, pieceSize); err != nil {
+		return fmt.Errorf("verifying piece cid of retrieved CAR file %s: %w", path, err)
+	}
+	return nil
+}
+
+// httpRetrievalEndpoint queries the storage provider's retrieval transports
+// and returns the url of its http endpoint
+func httpRetrievalEndpoint(ctx context.Context, n *clinode.Node, api lapi.Gateway, maddr address.Address) (string, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, api, maddr)
+	if err != nil {
+		return "", err
+	}
+	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+		return "", fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+	resp, err := lp2pimpl.NewTransportsClient(n.Host).SendQuery(ctx, addrInfo.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch transports from peer %s: %w", addrInfo.ID, err)
+	}
+	for _, p := range resp.Protocols {
+		if p.Name != "http" && p.Name != "https" {
+			continue
+		}
+		for _, ma := range p.Addresses {
+			if u, err := multiaddrutil.ToURL(ma); err == nil {
+				return u.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("storage provider %s does not support retrieval over http", maddr)
+}
+
+// retrievalSources returns a source for each provider that looks up the
+// provider's http retrieval endpoint when it's needed
+func retrievalSources(n *clinode.Node, api lapi.Gateway, providers []address.Address) []carfetch.Source {
+	sources := make([]carfetch.Source, 0, len(providers))
+	for _, maddr := range providers {
+		maddr := maddr
+		sources = append(sources, carfetch.Source{
+			Name: maddr.String(),
+			Endpoint: func(ctx context.Context) (string, error) {
+				return httpRetrievalEndpoint(ctx, n, api, maddr)
+			},
+		})
+	}
+	return sources
+}
+
+// retrieveCar downloads a CAR file from the provider's http endpoint, and
+// returns the roots from the CAR header. The query selects the data by
+// piece cid or payload cid.
+func retrieveCar(ctx context.Context, endpoint string, query url.Values, outPath string) ([]cid.Cid, int64, error) {
+	src := carfetch.Source{
+		Name:     endpoint,
+		Endpoint: func(context.Context) (string, error) { return endpoint, nil },
+	}
+	res, err := carfetch.Fetch(ctx, []carfetch.Source{src}, query, outPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Roots, res.Size, nil
+}
+
+// newNameResolver creates a resolver for dnslink:// and ipns:// retrieval
+// targets. IPNS records are fetched from the public IPFS DHT.
+func newNameResolver(ctx context.Context, n *clinode.Node) (*nameresolve.Resolver, func(), error) {
+	validator := record.NamespacedValidator{
+		"pk":   record.PublicKeyValidator{},
+		"ipns": ipns.Validator{KeyBook: n.Host.Peerstore()},
+	}
+	bootstrapPeers := dht.GetDefaultBootstrapPeerAddrInfos()
+	d, err := dht.New(ctx, n.Host,
+		dht.Mode(dht.ModeClient),
+		dht.Validator(validator),
+		dht.BootstrapPeers(bootstrapPeers...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating dht client: %w", err)
+	}
+	closer := func() { _ = d.Close() }
+
+	// Connect to the bootstrap peers so that the routing table is populated
+	// before the first lookup
+	var wg sync.WaitGroup
+	for _, ai := range bootstrapPeers {
+		wg.Add(1)
+		go func(ai peer.AddrInfo) {
+			defer wg.Done()
+			if err := n.Host.Connect(ctx, ai); err != nil {
+				log.Debugw("connecting to dht bootstrap peer", "peer", ai.ID, "err", err)
+			}
+		}(ai)
+	}
+	wg.Wait()
+
+	return nameresolve.NewResolver(nameresolve.ValueStore(d)), closer, nil
+}
+
+func printResolution(res *nameresolve.Resolution) {
+	for _, step := range res.Steps {
+		msg := fmt.Sprintf("    %s %s -> %s", step.Method, step.Name, step.Value)
+		if step.Method == nameresolve.MethodIPNS {
+			msg += fmt.Sprintf(" (seq %d, expires %s)", step.Sequence, step.Expires.Format(time.RFC3339))
+		}
+		fmt.Println(msg)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func testCid(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func TestLabelPayloadCid(t *testing.T) {
+	root := testCid(t, "root")
+
+	label, err := market.NewLabelFromString(root.String())
+	require.NoError(t, err)
+	c, ok := labelPayloadCid(label)
+	require.True(t, ok)
+	require.Equal(t, root, c)
+
+	// A label that isn't a cid has no payload cid
+	label, err = market.NewLabelFromString("my dataset")
+	require.NoError(t, err)
+	_, ok = labelPayloadCid(label)
+	require.False(t, ok)
+
+	label, err = market.NewLabelFromBytes(root.Bytes())
+	require.NoError(t, err)
+	_, ok = labelPayloadCid(label)
+	require.False(t, ok)
+
+	_, ok = labelPayloadCid(market.EmptyDealLabel)
+	require.False(t, ok)
+}
+
+func TestCheckPayloadRoot(t *testing.T) {
+	root := testCid(t, "root")
+	other := testCid(t, "other")
+
+	// The payload cid is discovered from the CAR header
+	c, source, err := checkPayloadRoot(cid.Undef, "", []cid.Cid{root, other})
+	require.NoError(t, err)
+	require.Equal(t, root, c)
+	require.Equal(t, "CAR header", source)
+
+	_, _, err = checkPayloadRoot(cid.Undef, "", nil)
+	require.Error(t, err)
+
+	// A known payload cid must be one of the roots
+	c, source, err = checkPayloadRoot(other, "deal label", []cid.Cid{root, other})
+	require.NoError(t, err)
+	require.Equal(t, other, c)
+	require.Equal(t, "deal label", source)
+
+	_, _, err = checkPayloadRoot(other, "flag", []cid.Cid{root})
+	require.ErrorContains(t, err, "is not a root")
+}
+
+func TestVerifyPieceCid(t *testing.T) {
+	ctx := context.Background()
+
+	data := make([]byte, 4096)
+	_, err := rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "retrieved.car")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	pi, err := commp.Default().Sum(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, verifyPieceCid(ctx, path, pi.PieceCID, pi.Size))
+
+	// Data that isn't the deal's piece is rejected
+	data[0]++
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	err = verifyPieceCid(ctx, path, pi.PieceCID, pi.Size)
+	require.ErrorIs(t, err, commp.ErrMismatch)
+
+	err = verifyPieceCid(ctx, filepath.Join(t.TempDir(), "missing.car"), pi.PieceCID, pi.Size)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/diskusage"
+	"github.com/filecoin-project/boost/lib/nameresolve"
+	"github.com/filecoin-project/boost/lib/prefetch"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/filecoin-project/go-address"
+	lapi "github.com/filecoin-project/lotus/api"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	retrievalStatusPending   = "pending"
+	retrievalStatusSucceeded = "succeeded"
+	retrievalStatusSkipped   = "skipped"
+	retrievalStatusFailed    = "failed"
+)
+
+// retrievalItem is the status of the retrieval of a single payload cid
+type retrievalItem struct {
+	// The dnslink:// or ipns:// name that the payload cid is resolved from,
+	// if the input line was a name
+	Target     string
+	Resolution *nameresolve.Resolution
+	PayloadCid cid.Cid
+	Provider   address.Address
+	// Providers that also hold the payload, to fail over to if the
+	// retrieval from Provider fails
+	Fallbacks []address.Address
+	// The provider that the retrieval completed from, and the attempts to
+	// retrieve from each provider
+	RetrievedFrom string
+	Attempts      []carfetch.Attempt
+	Path          string
+	// What was spent on the retrieval, if a cost cap was set
+	Spent    string
+	Status   string
+	Error    string
+	Size     int64
+	Duration time.Duration
+}
+
+// retrieveManyOutput is the output of the retrieve-many command in json mode
+type retrieveManyOutput struct {
+	Items   []retrieveManyItem  `json:"items"`
+	Summary retrieveManySummary `json:"summary"`
+}
+
+type retrieveManyItem struct {
+	// Only set if the input line was a dnslink:// or ipns:// name
+	Target     string                  `json:"target,omitempty"`
+	Resolution *nameresolve.Resolution `json:"resolution,omitempty"`
+	PayloadCid string                  `json:"payloadCid"`
+	Provider   string                  `json:"provider"`
+	// The provider the retrieval completed from, if it failed over from
+	// one provider to another
+	RetrievedFrom string             `json:"retrievedFrom,omitempty"`
+	Attempts      []carfetch.Attempt `json:"attempts,omitempty"`
+	Path          string             `json:"path"`
+	// What was spent on the retrieval, if a cost cap was set
+	Spent string `json:"spent,omitempty"`
+	// One of pending, succeeded, skipped or failed
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Size     int64  `json:"size"`
+	Duration string `json:"duration"`
+}
+
+// output is the item in the json output of the command
+func (item *retrievalItem) output() retrieveManyItem {
+	return retrieveManyItem{
+		Target:        item.Target,
+		Resolution:    item.Resolution,
+		PayloadCid:    item.PayloadCid.String(),
+		Provider:      item.Provider.String(),
+		Path:          item.Path,
+		RetrievedFrom: item.RetrievedFrom,
+		Attempts:      item.Attempts,
+		Spent:         item.Spent,
+		Status:        item.Status,
+		Error:         item.Error,
+		Size:          item.Size,
+		Duration:      item.Duration.Round(time.Millisecond).String(),
+	}
+}
+
+type retrieveManySummary struct {
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+	Size      int64  `json:"size"`
+	Duration  string `json:"duration"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("retrieve-many", retrieveManyOutput{})
+}
+
+var retrieveManyCmd = &cli.Command{
+	Name:  "retrieve-many",
+	Usage: "Retrieve a list of payload cids as CAR files",
+	ArgsUsage: "<input file>\n\n" +
+		"   The input file has one payload cid per line, optionally followed by the address of the\n" +
+		"   storage provider to retrieve it from, eg 'bafy... f01234'. Use '-' to read from stdin.\n" +
+		"   Any further providers on the line also hold the payload, and the retrieval fails over to\n" +
+		"   them (continuing from the bytes already received if they serve the same piece).\n" +
+		"   Instead of a cid, a line may have a dnslink://<domain> or ipns://<name> that is resolved\n" +
+		"   to the root cid it currently points to.",
+	Description: "Retrievals are grouped by storage provider, and run in parallel with a limit on the " +
+		"number of concurrent retrievals in total and from each provider.",
+	Before: before,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "provider",
+			Usage: "the storage provider to retrieve from, for cids in the input that don't specify a provider",
+		},
+		&cli.StringSliceFlag{
+			Name:  "fallback-provider",
+			Usage: "a provider to fail over to for every cid, after the fallback providers in the input (can be repeated)",
+		},
+		&cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "the directory to write <payload cid>.car files to",
+			Value: ".",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "the maximum number of concurrent retrievals",
+			Value: 8,
+		},
+		&cli.IntFlag{
+			Name:  "provider-concurrency",
+			Usage: "the maximum number of concurrent retrievals from a single storage provider",
+			Value: 2,
+		},
+		&cli.BoolFlag{
+			Name:  "skip-existing",
+			Usage: "skip cids for which a CAR file already exists in the output directory (eg to resume a restore)",
+		},
+	}, append(append(retrievalCapFlags, retrievalScanFlags...), sparkFlags...)...),
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: retrieve-many <input file>")
+		}
+		if cctx.Int("concurrency") < 1 || cctx.Int("provider-concurrency") < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+
+		var defaultProvider address.Address
+		if cctx.IsSet("provider") {
+			var err error
+			defaultProvider, err = address.NewFromString(cctx.String("provider"))
+			if err != nil {
+				return fmt.Errorf("parsing provider address %s: %w", cctx.String("provider"), err)
+			}
+		}
+
+		var in io.Reader = os.Stdin
+		if path := cctx.Args().First(); path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("opening input file %s: %w", path, err)
+			}
+			defer f.Close() //nolint:errcheck
+			in = f
+		}
+
+		var fallbacks []address.Address
+		for _, a := range cctx.StringSlice("fallback-provider") {
+			maddr, err := address.NewFromString(a)
+			if err != nil {
+				return fmt.Errorf("parsing fallback provider address %s: %w", a, err)
+			}
+			fallbacks = append(fallbacks, maddr)
+		}
+
+		outDir := cctx.String("output-dir")
+		items, err := parseRetrievalList(in, defaultProvider, outDir)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			for _, fb := range fallbacks {
+				if fb != item.Provider && !containsAddress(item.Fallbacks, fb) {
+					item.Fallbacks = append(item.Fallbacks, fb)
+				}
+			}
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("no payload cids in input")
+		}
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return fmt.Errorf("creating output directory %s: %w", outDir, err)
+		}
+		// The size of the retrievals isn't known in advance, so just check
+		// that the disk isn't already full or over quota
+		if err := checkDiskSpace(cctx, diskusage.KindRetrieval, outDir, 0); err != nil {
+			return err
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		if err := resolveRetrievalTargets(ctx, n, items, outDir); err != nil {
+			return err
+		}
+
+		store, err := openRetrievalStore(cctx)
+		if err != nil {
+			return err
+		}
+		predictions, err := openPrefetchStore(cctx)
+		if err != nil {
+			return err
+		}
+		caps, err := newRetrievalCaps(cctx, n, api)
+		if err != nil {
+			return err
+		}
+		scanner, err := newRetrievalScanner(cctx)
+		if err != nil {
+			return err
+		}
+
+		// Group the retrievals by provider
+		var providers []address.Address
+		byProvider := make(map[address.Address][]*retrievalItem)
+		for _, item := range items {
+			if _, ok := byProvider[item.Provider]; !ok {
+				providers = append(providers, item.Provider)
+			}
+			byProvider[item.Provider] = append(byProvider[item.Provider], item)
+		}
+
+		start := time.Now()
+		var done int
+		var doneLk sync.Mutex
+		report := func(item *retrievalItem) {
+			doneLk.Lock()
+			defer doneLk.Unlock()
+			done++
+			msg := item.Status
+			switch item.Status {
+			case retrievalStatusSucceeded:
+				msg += fmt.Sprintf(" (%s in %s)", humanize.IBytes(uint64(item.Size)), item.Duration.Round(time.Millisecond))
+				if item.RetrievedFrom == retrievedFromPrefetch {
+					msg += " from the prefetch cache"
+				} else if item.RetrievedFrom != "" {
+					msg += " after failing over to " + item.RetrievedFrom
+				}
+			case retrievalStatusFailed:
+				msg += ": " + item.Error
+			}
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s from %s: %s\n", done, len(items), item.name(), item.Provider, msg)
+		}
+
+		// Providers that fail are tried later when failing over, and the
+		// endpoint of each provider is only looked up once
+		scores := carfetch.NewScoreboard()
+		endpoints := newEndpointCache(n, api)
+		spk := newSparkClient(ctx, cctx)
+
+		throttle := make(chan struct{}, cctx.Int("concurrency"))
+		skipExisting := cctx.Bool("skip-existing")
+		var wg sync.WaitGroup
+		for _, maddr := range providers {
+			wg.Add(1)
+			go func(provItems []*retrievalItem) {
+				defer wg.Done()
+
+				provThrottle := make(chan struct{}, cctx.Int("provider-concurrency"))
+				var provWg sync.WaitGroup
+				for _, item := range provItems {
+					if item.Status != retrievalStatusPending {
+						// The name could not be resolved
+						report(item)
+						continue
+					}
+					if skipExisting {
+						if _, err := os.Stat(item.Path); err == nil {
+							item.Status = retrievalStatusSkipped
+							report(item)
+							continue
+						}
+					}
+					if copyPrefetched(ctx, predictions, scanner, item) {
+						report(item)
+						continue
+					}
+
+					provThrottle <- struct{}{}
+					throttle <- struct{}{}
+					provWg.Add(1)
+					go func(item *retrievalItem) {
+						defer func() {
+							<-throttle
+							<-provThrottle
+							provWg.Done()
+						}()
+
+						retrieveItem(ctx, store, caps, scanner, endpoints.sources(item, scores, spk), item)
+						scores.Record(item.Attempts)
+						spk.record(item.PayloadCid, item.Attempts)
+						report(item)
+					}(item)
+				}
+				provWg.Wait()
+			}(byProvider[maddr])
+		}
+		wg.Wait()
+		spk.flush(ctx)
+
+		summary := retrieveManySummary{
+			Total:    len(items),
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		}
+		counts := make(map[string]int)
+		for _, item := range items {
+			counts[item.Status]++
+			summary.Size += item.Size
+		}
+		summary.Succeeded = counts[retrievalStatusSucceeded]
+		summary.Skipped = counts[retrievalStatusSkipped]
+		summary.Failed = counts[retrievalStatusFailed]
+
+		if cctx.Bool("json") {
+			out := retrieveManyOutput{
+				Items:   make([]retrieveManyItem, 0, len(items)),
+				Summary: summary,
+			}
+			for _, item := range items {
+				out.Items = append(out.Items, item.output())
+			}
+			if err := cmd.PrintJson(out); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Retrieved %d of %d cids (%s) in %s: %d skipped, %d failed\n",
+				summary.Succeeded, len(items), humanize.IBytes(uint64(summary.Size)),
+				summary.Duration, summary.Skipped, summary.Failed)
+		}
+
+		if counts[retrievalStatusFailed] > 0 {
+			return fmt.Errorf("%d of %d retrievals failed", counts[retrievalStatusFailed], len(items))
+		}
+		return nil
+	},
+}
+
+// parseRetrievalList parses lines of the form <payload cid> [<provider>...],
+// where the payload cid may also be a dnslink:// or ipns:// name
+func parseRetrievalList(r io.Reader, defaultProvider address.Address, outDir string) ([]*retrievalItem, error) {
+	var items []*retrievalItem
+	seen := make(map[cid.Cid]struct{})
+	seenNames := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		item := &retrievalItem{Status: retrievalStatusPending}
+		if nameresolve.IsName(fields[0]) {
+			if _, ok := seenNames[fields[0]]; ok {
+				continue
+			}
+			seenNames[fields[0]] = struct{}{}
+			item.Target = fields[0]
+		} else {
+			c, err := cid.Parse(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parsing payload cid %s: %w", lineNum, fields[0], err)
+			}
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			seen[c] = struct{}{}
+			item.PayloadCid = c
+			item.Path = filepath.Join(outDir, c.String()+".car")
+		}
+
+		var providers []address.Address
+		for _, f := range fields[1:] {
+			maddr, err := address.NewFromString(f)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parsing provider address %s: %w", lineNum, f, err)
+			}
+			providers = append(providers, maddr)
+		}
+		if len(providers) == 0 {
+			if defaultProvider == address.Undef {
+				return nil, fmt.Errorf("line %d: no provider for payload cid %s (use --provider to set a default)", lineNum, fields[0])
+			}
+			providers = append(providers, defaultProvider)
+		}
+		item.Provider = providers[0]
+		item.Fallbacks = providers[1:]
+
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading input: %w", err)
+	}
+	return items, nil
+}
+
+// resolveRetrievalTargets resolves the payload cid of each item that has a
+// dnslink:// or ipns:// name. If a name can't be resolved, or it resolves to
+// a cid that is already in the list, the item is marked as failed or skipped.
+func resolveRetrievalTargets(ctx context.Context, n *clinode.Node, items []*retrievalItem, outDir string) error {
+	var hasNames bool
+	seen := make(map[cid.Cid]string)
+	for _, item := range items {
+		if item.Target == "" {
+			seen[item.PayloadCid] = item.PayloadCid.String()
+		} else {
+			hasNames = true
+		}
+	}
+	if !hasNames {
+		return nil
+	}
+
+	resolver, closer, err := newNameResolver(ctx, n)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	for _, item := range items {
+		if item.Target == "" {
+			continue
+		}
+
+		res, err := resolver.Resolve(ctx, item.Target)
+		if err != nil {
+			item.Status = retrievalStatusFailed
+			item.Error = err.Error()
+			continue
+		}
+		item.Resolution = res
+		item.PayloadCid = res.Root
+		item.Path = filepath.Join(outDir, res.Root.String()+".car")
+		if prev, ok := seen[res.Root]; ok {
+			item.Status = retrievalStatusSkipped
+			item.Error = fmt.Sprintf("resolves to %s, which is also the root of %s", res.Root, prev)
+			continue
+		}
+		seen[res.Root] = item.Target
+		log.Debugw("resolved retrieval target", "target", item.Target, "root", res.Root)
+	}
+	return nil
+}
+
+// name is the name of the item as it appeared in the input
+func (item *retrievalItem) name() string {
+	if item.Target != "" {
+		return item.Target
+	}
+	return item.PayloadCid.String()
+}
+
+// retrievedFromPrefetch is the RetrievedFrom of items that were copied from
+// the prefetch cache
+const retrievedFromPrefetch = "prefetch cache"
+
+// copyPrefetched copies the item's payload from the prefetch cache, if it
+// has been prefetched. Whether or not it has, the prediction's use is
+// recorded so that the hit rate of the predictions can be measured.
+// If a scanner is set, the copy is scanned, and if it can't be scanned or is
+// flagged the item is retrieved instead (so that flagged content is
+// quarantined along with the record of its retrieval).
+func copyPrefetched(ctx context.Context, predictions *prefetch.Store, scanner *retrievalScanner, item *retrievalItem) bool {
+	start := time.Now()
+	pred, err := predictions.Use(item.PayloadCid)
+	if err != nil {
+		log.Warnw("checking prefetch cache", "payloadCid", item.PayloadCid, "err", err)
+		return false
+	}
+	if pred == nil {
+		return false
+	}
+
+	tmpPath := item.Path + ".tmp"
+	if err := copyFile(predictions.CarPath(item.PayloadCid), tmpPath); err != nil {
+		log.Warnw("copying from prefetch cache", "payloadCid", item.PayloadCid, "err", err)
+		_ = os.Remove(tmpPath)
+		return false
+	}
+	if err := scanner.scan(ctx, tmpPath); err != nil {
+		log.Warnw("scanning copy from prefetch cache", "payloadCid", item.PayloadCid, "err", err)
+		_ = os.Remove(tmpPath)
+		return false
+	}
+	if err := os.Rename(tmpPath, item.Path); err != nil {
+		log.Warnw("copying from prefetch cache", "payloadCid", item.PayloadCid, "err", err)
+		return false
+	}
+	item.Size = pred.Size
+	item.RetrievedFrom = retrievedFromPrefetch
+	item.Duration = time.Since(start)
+	item.Status = retrievalStatusSucceeded
+	return true
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// retrieveItem retrieves the item from the sources. If caps is not nil, the
+// cost of the retrieval is capped. If scanner is not nil, the content is
+// scanned before the retrieval completes.
+func retrieveItem(ctx context.Context, store *retrievals.Store, caps *retrievalCaps, scanner *retrievalScanner, sources []carfetch.Source, item *retrievalItem) {
+	start := time.Now()
+	// Write to a temporary file so that a partial retrieval isn't
+	// mistaken for a complete one
+	tmpPath := item.Path + ".tmp"
+	rec := &retrievals.Record{
+		PayloadCid: item.PayloadCid,
+		Provider:   item.Provider.String(),
+		Path:       tmpPath,
+		Scanned:    scanner != nil,
+	}
+	ctx, finish := startRetrievalRecord(ctx, store, rec)
+	err := func() error {
+		var fetchOpts []carfetch.Option
+		if caps != nil {
+			// The size of the retrieval isn't known, so it's checked
+			// against the caps as the data arrives
+			meter, err := caps.meter(retrievalTransportHTTP, item.PayloadCid, nil, 0)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				meter.finish(ctx, rec)
+				item.Spent = rec.Spent
+			}()
+			fetchOpts = append(fetchOpts, carfetch.WithMeter(meter))
+		}
+
+		query := url.Values{"payloadCid": {item.PayloadCid.String()}}
+		res, err := carfetch.Fetch(ctx, sources, query, tmpPath, fetchOpts...)
+		if res != nil {
+			item.Attempts = res.Attempts
+		}
+		if err != nil {
+			_ = os.Remove(tmpPath)
+			return err
+		}
+		item.Size = res.Size
+		if src := res.Source(); src != item.Provider.String() {
+			item.RetrievedFrom = src
+			rec.Provider = src
+		}
+		roots := res.Roots
+
+		found := false
+		for _, r := range roots {
+			found = found || r.Equals(item.PayloadCid)
+		}
+		if !found {
+			_ = os.Remove(tmpPath)
+			return fmt.Errorf("payload cid is not a root of the retrieved CAR file (roots: %s)", roots)
+		}
+		if err := scanner.scan(ctx, tmpPath); err != nil {
+			// A flagged file has already been moved to quarantine
+			_ = os.Remove(tmpPath)
+			return err
+		}
+		return os.Rename(tmpPath, item.Path)
+	}()
+	item.Duration = time.Since(start)
+	finish(item.PayloadCid, item.Path, item.Size, err)
+	if err != nil {
+		item.Status = retrievalStatusFailed
+		item.Error = err.Error()
+		return
+	}
+	item.Status = retrievalStatusSucceeded
+}
+
+// endpointCache looks up the http retrieval endpoint of each provider once
+type endpointCache struct {
+	n   *clinode.Node
+	api lapi.Gateway
+
+	lk        sync.Mutex
+	endpoints map[address.Address]*endpointLookup
+}
+
+type endpointLookup struct {
+	once     sync.Once
+	endpoint string
+	err      error
+}
+
+func newEndpointCache(n *clinode.Node, api lapi.Gateway) *endpointCache {
+	return &endpointCache{n: n, api: api, endpoints: make(map[address.Address]*endpointLookup)}
+}
+
+func (c *endpointCache) get(ctx context.Context, maddr address.Address) (string, error) {
+	c.lk.Lock()
+	l, ok := c.endpoints[maddr]
+	if !ok {
+		l = &endpointLookup{}
+		c.endpoints[maddr] = l
+	}
+	c.lk.Unlock()
+
+	l.once.Do(func() {
+		l.endpoint, l.err = httpRetrievalEndpoint(ctx, c.n, c.api, maddr)
+	})
+	return l.endpoint, l.err
+}
+
+// sources returns the item's provider followed by its fallback providers,
+// ordered by the fewest failures, and then by the highest network-wide
+// retrievability score (if the user opted in to the scores)
+func (c *endpointCache) sources(item *retrievalItem, scores *carfetch.Scoreboard, spk *sparkClient) []carfetch.Source {
+	providers := append([]address.Address{item.Provider}, item.Fallbacks...)
+	sources := make([]carfetch.Source, 0, len(providers))
+	for _, maddr := range providers {
+		maddr := maddr
+		sources = append(sources, carfetch.Source{
+			Name: maddr.String(),
+			Endpoint: func(ctx context.Context) (string, error) {
+				return c.get(ctx, maddr)
+			},
+		})
+	}
+	return append(sources[:1], scores.Order(spk.order(sources[1:]))...)
+}
+
+func containsAddress(addrs []address.Address, a address.Address) bool {
+	for _, addr := range addrs {
+		if addr == a {
+			return true
+		}
+	}
+	return false
+}
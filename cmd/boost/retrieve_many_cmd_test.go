@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/retrievalcap"
+	"github.com/filecoin-project/boost/lib/retrievals"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetrievalList(t *testing.T) {
+	outDir := t.TempDir()
+	root1 := testCid(t, "root1")
+	root2 := testCid(t, "root2")
+	p1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	p2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	input := strings.Join([]string{
+		"# payload cid and providers",
+		root1.String() + " f01000 f01001",
+		"",
+		// Duplicate payload cids are only retrieved once
+		root1.String() + " f01001",
+		// Without a provider, the default provider is used
+		"  " + root2.String() + "  ",
+	}, "\n")
+	items, err := parseRetrievalList(strings.NewReader(input), p2, outDir)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+
+	require.Equal(t, root1, items[0].PayloadCid)
+	require.Equal(t, p1, items[0].Provider)
+	require.Equal(t, []address.Address{p2}, items[0].Fallbacks)
+	require.Equal(t, filepath.Join(outDir, root1.String()+".car"), items[0].Path)
+	require.Equal(t, retrievalStatusPending, items[0].Status)
+
+	require.Equal(t, root2, items[1].PayloadCid)
+	require.Equal(t, p2, items[1].Provider)
+	require.Empty(t, items[1].Fallbacks)
+
+	errCases := []struct {
+		name    string
+		input   string
+		withErr string
+	}{{
+		name:    "invalid payload cid",
+		input:   "not-a-cid f01000",
+		withErr: "line 1: parsing payload cid not-a-cid",
+	}, {
+		name:    "invalid provider",
+		input:   "# comment\n" + root1.String() + " not-an-address",
+		withErr: "line 2: parsing provider address not-an-address",
+	}, {
+		name:    "no provider",
+		input:   root1.String(),
+		withErr: "line 1: no provider for payload cid",
+	}}
+	for _, tc := range errCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRetrievalList(strings.NewReader(tc.input), address.Undef, outDir)
+			require.ErrorContains(t, err, tc.withErr)
+		})
+	}
+}
+
+func TestRetrieveItem(t *testing.T) {
+	ctx := context.Background()
+	root := testCid(t, "root")
+	other := testCid(t, "other")
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	carFile := func(root cid.Cid) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, &buf))
+		buf.WriteString("block data")
+		return buf.Bytes()
+	}
+	var payloadCid string
+	content := carFile(root)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payloadCid = r.URL.Query().Get("payloadCid")
+		if content == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer svr.Close()
+	sources := []carfetch.Source{{
+		Name:     maddr.String(),
+		Endpoint: func(context.Context) (string, error) { return svr.URL, nil },
+	}}
+
+	store, err := retrievals.NewStore(t.TempDir())
+	require.NoError(t, err)
+	outDir := t.TempDir()
+	newItem := func() *retrievalItem {
+		return &retrievalItem{
+			PayloadCid: root,
+			Provider:   maddr,
+			Path:       filepath.Join(outDir, root.String()+".car"),
+			Status:     retrievalStatusPending,
+		}
+	}
+
+	t.Run("retrieves the CAR file", func(t *testing.T) {
+		item := newItem()
+		retrieveItem(ctx, store, nil, nil, sources, item)
+		require.Equal(t, retrievalStatusSucceeded, item.Status, item.Error)
+		require.Equal(t, root.String(), payloadCid)
+		require.EqualValues(t, len(content), item.Size)
+		require.Empty(t, item.RetrievedFrom)
+
+		data, err := os.ReadFile(item.Path)
+		require.NoError(t, err)
+		require.Equal(t, content, data)
+
+		recs, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+		require.Equal(t, retrievals.StateComplete, recs[0].State)
+		require.Equal(t, root, recs[0].PayloadCid)
+		require.NoError(t, os.Remove(item.Path))
+	})
+
+	t.Run("payload cid is not a root", func(t *testing.T) {
+		content = carFile(other)
+		defer func() { content = carFile(root) }()
+		item := newItem()
+		retrieveItem(ctx, store, nil, nil, sources, item)
+		require.Equal(t, retrievalStatusFailed, item.Status)
+		require.Contains(t, item.Error, "payload cid is not a root")
+		require.NoFileExists(t, item.Path)
+		require.NoFileExists(t, item.Path+".tmp")
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		content = nil
+		defer func() { content = carFile(root) }()
+		item := newItem()
+		retrieveItem(ctx, store, nil, nil, sources, item)
+		require.Equal(t, retrievalStatusFailed, item.Status)
+		require.NotEmpty(t, item.Error)
+		require.NoFileExists(t, item.Path)
+	})
+}
+
+func TestRetrieveManySpent(t *testing.T) {
+	ctx := context.Background()
+
+	ledger := retrievalcap.NewLedger(filepath.Join(t.TempDir(), "retrieval-spend.json"))
+	caps := &retrievalCaps{
+		caps:   retrievalcap.Caps{PerRetrieval: big.NewInt(1000), PerDay: big.Zero()},
+		ledger: ledger,
+	}
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	item := &retrievalItem{PayloadCid: testCid(t, "root"), Provider: maddr, Status: retrievalStatusSucceeded}
+
+	meter, err := caps.meter(retrievalTransportHTTP, item.PayloadCid, nil, 0)
+	require.NoError(t, err)
+	price := retrievalcap.Price{UnsealPrice: big.NewInt(100), PricePerByte: big.NewInt(2)}
+	require.NoError(t, meter.session.Offer(maddr.String(), price, 0))
+	require.NoError(t, meter.Received(200))
+
+	// The spend is recorded in the daily ledger, on the retrieval record and
+	// in the command's output
+	rec := &retrievals.Record{}
+	meter.finish(ctx, rec)
+	item.Spent = rec.Spent
+
+	spent := types.FIL(big.NewInt(500)).Short()
+	require.Equal(t, spent, rec.Spent)
+	require.Equal(t, spent, item.output().Spent)
+	daily, err := ledger.Spent()
+	require.NoError(t, err)
+	require.EqualValues(t, 500, daily.Int64())
+
+	// Nothing is spent on a retrieval that isn't capped
+	free := &retrievalItem{PayloadCid: item.PayloadCid, Provider: maddr}
+	require.Empty(t, free.output().Spent)
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/car"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/carserver"
+	"github.com/filecoin-project/boost/lib/dedupstore"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/transport/httptransport"
+	"github.com/filecoin-project/boost/transport/tcptransport"
+	types2 "github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-address"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	carbs "github.com/ipld/go-car/v2/blockstore"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/mitchellh/go-homedir"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/urfave/cli/v2"
+)
+
+var serveCarFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: "serve-car",
+		Usage: "path to a local CAR file to serve over http for the provider to download, instead of " +
+			"uploading it somewhere first. The command waits until the provider has downloaded the file.",
+	},
+	&cli.Uint64Flag{
+		Name: "serve-import",
+		Usage: "id of an import from a read-only mount (see import mount) to serve over http directly from the mount, " +
+			"without copying out its CAR file. The mount must be available when the deal is made.",
+	},
+	&cli.StringFlag{
+		Name: "serve-car-transport",
+		Usage: "the transport to serve the CAR file over: 'http', 'tcp' to serve it over a raw TLS connection, " +
+			"which can be faster where libp2p throughput is the bottleneck (the provider must have tcp transfers enabled), " +
+			"or 'libp2p' to serve the CAR file's DAG over the client's libp2p host, which doesn't need a public http endpoint " +
+			"(the CAR file must hold the DAG in depth-first order without duplicate blocks, eg as written by boostx generate-car)",
+		Value: "http",
+	},
+	&cli.StringFlag{
+		Name: "serve-car-max-traversal-memory",
+		Usage: "the maximum memory used to hold the pending links when traversing the DAG to serve it over libp2p. " +
+			"Links beyond the limit are spilled to disk, so that very wide DAGs can be served with bounded memory. " +
+			"Zero means no limit.",
+		Value: "64MiB",
+	},
+	&cli.StringFlag{
+		Name:  "serve-car-spill-dir",
+		Usage: "the directory in which to spill pending links when traversing the DAG (defaults to the temp directory)",
+	},
+	&cli.StringFlag{
+		Name:  "serve-car-listen",
+		Usage: "the address to listen on when serving the CAR file",
+		Value: "0.0.0.0:8443",
+	},
+	&cli.StringFlag{
+		Name: "serve-car-public-url",
+		Usage: "the base url at which the provider can reach the server, eg https://client.example.com:8443 " +
+			"(defaults to the listen address)",
+	},
+	&cli.StringFlag{
+		Name:  "serve-car-public-addr",
+		Usage: "the host:port at which the provider can reach the tcp server (defaults to the listen address)",
+	},
+	&cli.StringFlag{
+		Name:  "serve-car-tls-cert",
+		Usage: "path to a TLS certificate, to serve the CAR file over https",
+	},
+	&cli.StringFlag{
+		Name:  "serve-car-tls-key",
+		Usage: "path to the TLS certificate's private key",
+	},
+}
+
+// carFileServer tracks the provider's download of the CAR files it serves
+type carFileServer interface {
+	Done(id string) <-chan struct{}
+	Served(id string) (int64, int64)
+}
+
+// traversalStatser is implemented by servers that serve a CAR file by
+// traversing its DAG
+type traversalStatser interface {
+	TraversalStats() car.TraversalStatsSnapshot
+}
+
+// startCarServer starts an http server that serves CAR files for the
+// provider to download
+func startCarServer(cctx *cli.Context) (*carserver.Server, func(), error) {
+	certFile, keyFile := cctx.String("serve-car-tls-cert"), cctx.String("serve-car-tls-key")
+	if (certFile == "") != (keyFile == "") {
+		return nil, nil, fmt.Errorf("both --serve-car-tls-cert and --serve-car-tls-key must be set to serve over https")
+	}
+	scheme := "http"
+	if certFile != "" {
+		scheme = "https"
+	}
+
+	ln, err := net.Listen("tcp", cctx.String("serve-car-listen"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("listening on %s: %w", cctx.String("serve-car-listen"), err)
+	}
+
+	publicURL := cctx.String("serve-car-public-url")
+	if publicURL == "" {
+		publicURL = scheme + "://" + ln.Addr().String()
+	}
+	s := carserver.New(publicURL)
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		var err error
+		if certFile != "" {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorw("serving CAR file", "err", err)
+		}
+	}()
+	log.Infow("serving CAR files", "listen", ln.Addr(), "url", publicURL)
+
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+	return s, stop, nil
+}
+
+// startTcpCarServer starts a server that serves CAR files for the provider
+// to download over raw TLS connections, with a self-signed certificate
+func startTcpCarServer(cctx *cli.Context) (*tcptransport.Server, func(), error) {
+	ln, err := net.Listen("tcp", cctx.String("serve-car-listen"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("listening on %s: %w", cctx.String("serve-car-listen"), err)
+	}
+
+	publicAddr := cctx.String("serve-car-public-addr")
+	if publicAddr == "" {
+		publicAddr = ln.Addr().String()
+	}
+	s, err := tcptransport.NewServer(publicAddr)
+	if err != nil {
+		_ = ln.Close()
+		return nil, nil, err
+	}
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			log.Errorw("serving CAR file", "err", err)
+		}
+	}()
+	log.Infow("serving CAR files over tcp", "listen", ln.Addr(), "addr", publicAddr)
+
+	stop := func() {
+		_ = ln.Close()
+	}
+	return s, stop, nil
+}
+
+// libp2pCarFileServer serves the DAGs of CAR files over the client's libp2p
+// host, and tracks the provider's download of them
+type libp2pCarFileServer struct {
+	*httptransport.Libp2pCarServer
+	h      host.Host
+	authDB *httptransport.AuthTokenDB
+
+	lk     sync.Mutex
+	served map[string]int64
+	sizes  map[string]int64
+	done   map[string]chan struct{}
+}
+
+// startLibp2pCarServer starts a server that serves the DAG of the CAR file
+// set with --serve-car over the client's libp2p host, for the provider to
+// download with its libp2p http transport. The DAG is traversed with bounded
+// memory.
+func startLibp2pCarServer(cctx *cli.Context, h host.Host, root cid.Cid) (*libp2pCarFileServer, func(), error) {
+	if cctx.IsSet("serve-import") {
+		return nil, nil, fmt.Errorf("--serve-import can only be served over http")
+	}
+	if !cctx.IsSet("serve-car") {
+		return nil, nil, fmt.Errorf("--serve-car must be set to serve the CAR file over libp2p")
+	}
+	maxMemory, err := units.RAMInBytes(cctx.String("serve-car-max-traversal-memory"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing --serve-car-max-traversal-memory %q: %w", cctx.String("serve-car-max-traversal-memory"), err)
+	}
+	if maxMemory < 0 {
+		return nil, nil, fmt.Errorf("--serve-car-max-traversal-memory must not be negative")
+	}
+
+	bs, err := carbs.OpenReadOnly(cctx.String("serve-car"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening CAR file %s: %w", cctx.String("serve-car"), err)
+	}
+	roots, err := bs.Roots()
+	if err != nil || len(roots) != 1 || !roots[0].Equals(root) {
+		_ = bs.Close()
+		return nil, nil, fmt.Errorf("the CAR file %s must have the payload cid %s as its only root", cctx.String("serve-car"), root)
+	}
+
+	authDB := httptransport.NewAuthTokenDB(dssync.MutexWrap(datastore.NewMapDatastore()))
+	srv := httptransport.NewLibp2pCarServer(h, authDB, bs, httptransport.ServerConfig{
+		Traversal: &car.TraversalOptions{
+			MaxMemoryBytes: uint64(maxMemory),
+			SpillDir:       cctx.String("serve-car-spill-dir"),
+		},
+	})
+	s := &libp2pCarFileServer{
+		Libp2pCarServer: srv,
+		h:               h,
+		authDB:          authDB,
+		served:          make(map[string]int64),
+		sizes:           make(map[string]int64),
+		done:            make(map[string]chan struct{}),
+	}
+	unsub := srv.Subscribe(s.onTransferEvent)
+	if err := srv.Start(cctx.Context); err != nil {
+		unsub()
+		_ = bs.Close()
+		return nil, nil, fmt.Errorf("starting libp2p CAR server: %w", err)
+	}
+	log.Infow("serving CAR files over libp2p", "peer", h.ID(), "max-traversal-memory", humanize.IBytes(uint64(maxMemory)))
+
+	stop := func() {
+		unsub()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+		_ = bs.Close()
+		st := srv.TraversalStats()
+		log.Infow("DAG traversal stats", "blocks", st.BlocksVisited,
+			"peak-memory", humanize.IBytes(uint64(st.PeakMemoryBytes)), "spilled-links", st.SpilledLinks,
+			"spilled", humanize.IBytes(st.SpilledBytes))
+	}
+	return s, stop, nil
+}
+
+// Add serves the DAG with the given root under the id, and returns the
+// request with which the provider downloads it
+func (s *libp2pCarFileServer) Add(ctx context.Context, id string, root cid.Cid, size uint64) (*types2.HttpRequest, error) {
+	// The provider can't reach the client at a loopback address
+	var addr multiaddr.Multiaddr
+	for _, a := range s.h.Addrs() {
+		if !manet.IsIPLoopback(a) {
+			addr = a
+			break
+		}
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("the libp2p host is not listening on any address that the provider can reach")
+	}
+	token, err := httptransport.GenerateAuthToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authDB.Put(ctx, token, httptransport.AuthValue{ID: id, PayloadCid: root, Size: size}); err != nil {
+		return nil, fmt.Errorf("adding auth token: %w", err)
+	}
+
+	s.lk.Lock()
+	s.sizes[id] = int64(size)
+	if _, ok := s.done[id]; !ok {
+		s.done[id] = make(chan struct{})
+	}
+	s.lk.Unlock()
+
+	return &types2.HttpRequest{
+		URL:     "libp2p://" + addr.String() + "/p2p/" + s.ID().String(),
+		Headers: map[string]string{"Authorization": httptransport.BasicAuthHeader("", token)},
+	}, nil
+}
+
+func (s *libp2pCarFileServer) onTransferEvent(id string, st types2.TransferState) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.served[id] = int64(st.Sent)
+	if st.Status != types2.TransferStatusCompleted {
+		return
+	}
+	if done, ok := s.done[id]; ok {
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+	}
+}
+
+func (s *libp2pCarFileServer) Done(id string) <-chan struct{} {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.done[id]
+}
+
+func (s *libp2pCarFileServer) Served(id string) (int64, int64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.served[id], s.sizes[id]
+}
+
+// serveCarOverTcp serves the CAR file set with --serve-car for the deal, and
+// sets the deal's transfer to download it over tcp. It returns the address
+// the CAR file is served at.
+func serveCarOverTcp(cctx *cli.Context, s *tcptransport.Server, dealUuid uuid.UUID, transfer *types.Transfer, opts ...tcptransport.FileOption) (string, error) {
+	if cctx.IsSet("serve-import") {
+		return "", fmt.Errorf("--serve-import can only be served over http")
+	}
+	if !cctx.IsSet("serve-car") {
+		return "", fmt.Errorf("--serve-car must be set to serve the CAR file over tcp")
+	}
+	params, err := s.Add(dealUuid.String(), cctx.String("serve-car"), opts...)
+	if err != nil {
+		return "", err
+	}
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("marshalling request parameters: %w", err)
+	}
+	transfer.Type = "tcp"
+	transfer.Params = paramsBytes
+	return "tcp://" + params.Addr, nil
+}
+
+// serveMountedImport serves the CAR file of the import set with
+// --serve-import for the deal, reading it directly from the import's mount.
+// It fails if the mount is unavailable, so that a deal isn't proposed that
+// the provider can't download. The provider's requests are retried while the
+// mount is unavailable.
+func serveMountedImport(cctx *cli.Context, s *carserver.Server, dealUuid uuid.UUID) (*types2.HttpRequest, error) {
+	ctx := lcli.ReqContext(cctx)
+	ds, closer, err := openDedupStore(cctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	id := cctx.Uint64("serve-import")
+	path, mounted, err := ds.MountedCarPath(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("checking the mount of import %d: %w", id, err)
+	}
+	log.Infow("serving CAR file from mount", "import", id, "mount", mounted.Mount, "path", path)
+	return s.Add(dealUuid.String(), path,
+		carserver.Section(mounted.DataOffset, mounted.DataSize),
+		carserver.HealthCheck(func() error {
+			return dedupstore.CheckMountedCar(context.Background(), path, mounted.Size)
+		}))
+}
+
+// trackImportDeal records the deal as pending on the import that the deal's
+// data is served from, so that import checks quarantine the deal if the data
+// is damaged. The import is the one set with --serve-import, or the import
+// that the CAR file with the deal's piece cid was written for, if any. It
+// fails if the import is quarantined. The returned func removes the pending
+// deal once the data is no longer served.
+func trackImportDeal(cctx *cli.Context, dealUuid uuid.UUID, provider address.Address, pieceCid cid.Cid) (func(), error) {
+	ctx := lcli.ReqContext(cctx)
+	if !cctx.IsSet("serve-import") {
+		// Don't create the blockstore if nothing has been imported
+		sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.Join(sdir, "dedupstore")); errors.Is(err, os.ErrNotExist) {
+			return func() {}, nil
+		}
+	}
+
+	s, closer, err := openDedupStore(cctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	id := cctx.Uint64("serve-import")
+	if !cctx.IsSet("serve-import") {
+		imp, _, err := s.CarForPiece(ctx, pieceCid)
+		if errors.Is(err, dedupstore.ErrImportNotFound) {
+			return func() {}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		id = imp.ID
+	}
+
+	deal := dedupstore.Deal{UUID: dealUuid, Provider: provider.String(), PieceCid: pieceCid, ProposedAt: time.Now()}
+	if err := s.AddDeal(ctx, id, deal); err != nil {
+		return nil, fmt.Errorf("recording deal with the data of import %d: %w", id, err)
+	}
+
+	// The blockstore is closed in between, so that other commands (eg
+	// import check) can open it while the data is served
+	return func() {
+		s, closer, err := openDedupStore(cctx)
+		if err != nil {
+			log.Warnw("removing pending deal from import", "import", id, "deal", dealUuid, "err", err)
+			return
+		}
+		defer closer()
+		if err := s.RemoveDeal(context.Background(), dealUuid); err != nil {
+			log.Warnw("removing pending deal from import", "import", id, "deal", dealUuid, "err", err)
+		}
+	}, nil
+}
+
+// waitForCarDownload waits until the provider has downloaded the CAR file
+// served for the deal. It returns immediately if the CAR file is not served
+// by the client.
+func waitForCarDownload(ctx context.Context, s carFileServer, dealUuid uuid.UUID) error {
+	if s == nil {
+		return nil
+	}
+
+	id := dealUuid.String()
+	log.Infow("waiting for the provider to download the CAR file", "deal", id)
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.Done(id):
+			log.Infow("the provider has downloaded the CAR file", "deal", id)
+			return nil
+		case <-ticker.C:
+			served, size := s.Served(id)
+			log.Infow("CAR file download progress", "deal", id,
+				"downloaded", humanize.IBytes(uint64(served)), "size", humanize.IBytes(uint64(size)))
+			if ts, ok := s.(traversalStatser); ok {
+				st := ts.TraversalStats()
+				log.Infow("DAG traversal memory", "deal", id, "memory", humanize.IBytes(uint64(st.MemoryBytes)),
+					"peak-memory", humanize.IBytes(uint64(st.PeakMemoryBytes)), "spilled", humanize.IBytes(st.SpilledBytes))
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("stopped serving the CAR file before the provider downloaded it: %w", ctx.Err())
+		}
+	}
+}
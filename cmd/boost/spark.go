@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/spark"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+// sparkFlags are the flags of the retrieval commands that opt in to a
+// Spark-style retrieval checking network
+var sparkFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: "spark-report-url",
+		Usage: "report the outcome of each retrieval attempt to this measurement endpoint. Reports are " +
+			"anonymized: they contain the provider, the payload cid and the result, but nothing about the client",
+		EnvVars: []string{"BOOST_SPARK_REPORT_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "spark-scores-url",
+		Usage:   "get the network-wide retrievability score of each provider from this endpoint, and try the providers with the best scores first",
+		EnvVars: []string{"BOOST_SPARK_SCORES_URL"},
+	},
+}
+
+// sparkClient reports retrieval outcomes and orders providers by their
+// scores. A nil sparkClient does nothing, so that callers don't need to
+// check whether the user opted in.
+type sparkClient struct {
+	reporter *spark.Reporter
+	scores   spark.Scores
+}
+
+// newSparkClient returns nil if neither flag is set. If the scores can't be
+// fetched the retrievals go ahead without them.
+func newSparkClient(ctx context.Context, cctx *cli.Context) *sparkClient {
+	reportURL, scoresURL := cctx.String("spark-report-url"), cctx.String("spark-scores-url")
+	if reportURL == "" && scoresURL == "" {
+		return nil
+	}
+
+	s := &sparkClient{}
+	if reportURL != "" {
+		s.reporter = spark.NewReporter(reportURL)
+	}
+	if scoresURL != "" {
+		scores, err := spark.FetchScores(ctx, scoresURL)
+		if err != nil {
+			log.Warnw("could not get retrievability scores, providers are tried in the given order", "err", err)
+		} else {
+			s.scores = scores
+		}
+	}
+	return s
+}
+
+// order returns the sources ordered by the highest retrievability score
+func (s *sparkClient) order(sources []carfetch.Source) []carfetch.Source {
+	if s == nil || s.scores == nil {
+		return sources
+	}
+	return s.scores.Order(sources)
+}
+
+// record queues the outcome of the attempts to retrieve the payload
+func (s *sparkClient) record(payloadCid cid.Cid, attempts []carfetch.Attempt) {
+	if s == nil || s.reporter == nil || !payloadCid.Defined() {
+		return
+	}
+	s.reporter.Record(payloadCid, attempts)
+}
+
+// flush sends the queued outcomes. Failing to send them doesn't fail the
+// retrievals.
+func (s *sparkClient) flush(ctx context.Context) {
+	if s == nil || s.reporter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := s.reporter.Flush(ctx); err != nil {
+		log.Warnw("reporting retrieval outcomes", "err", err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/datasetwatch"
+	"github.com/filecoin-project/boost/lib/storagemap"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+// verifyStorageMapOutput is the output of the verify-storage-map command in
+// json mode
+type verifyStorageMapOutput struct {
+	Root     cid.Cid              `json:"root"`
+	Snapshot *storagemap.Snapshot `json:"snapshot"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("verify-storage-map", verifyStorageMapOutput{})
+}
+
+var ipfsApiFlag = &cli.StringFlag{
+	Name:  "ipfs-api",
+	Usage: "the url of the RPC API of the IPFS node that stores storage map snapshots",
+	Value: "http://127.0.0.1:5001",
+}
+
+var verifyStorageMapCmd = &cli.Command{
+	Name:      "verify-storage-map",
+	Usage:     "Fetch a dataset's storage map snapshot from IPFS and verify the client's signature",
+	ArgsUsage: "<snapshot root cid or /ipns/name>",
+	Description: "Storage map snapshots are published to IPFS by the watch-dataset command. " +
+		"The snapshot is fetched through the IPFS node's RPC API, and checked against its cid and the " +
+		"signature of the client wallet in the snapshot.",
+	Before: before,
+	Flags: []cli.Flag{
+		ipfsApiFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: verify-storage-map <snapshot root cid or /ipns/name>")
+		}
+
+		ipfs := storagemap.NewIpfsClient(cctx.String(ipfsApiFlag.Name))
+		arg := cctx.Args().First()
+		var root cid.Cid
+		var err error
+		if strings.HasPrefix(arg, "/ipns/") {
+			root, err = ipfs.Resolve(ctx, arg)
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", arg, err)
+			}
+		} else {
+			root, err = cid.Parse(strings.TrimPrefix(arg, "/ipfs/"))
+			if err != nil {
+				return fmt.Errorf("parsing snapshot root cid %s: %w", arg, err)
+			}
+		}
+
+		s, err := storagemap.Get(ctx, ipfs, root)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(verifyStorageMapOutput{Root: root, Snapshot: s})
+		}
+		fmt.Printf("Storage map snapshot %s is valid\n", root)
+		fmt.Printf("  dataset: %s\n", s.Dataset)
+		fmt.Printf("  signed by: %s\n", s.Client)
+		fmt.Printf("  at: %s\n", time.Unix(s.Timestamp, 0))
+		if s.Previous != nil {
+			fmt.Printf("  previous snapshot: %s\n", *s.Previous)
+		}
+		fmt.Println()
+
+		tw := tablewriter.New(
+			tablewriter.Col("Piece CID"),
+			tablewriter.Col("Deal ID"),
+			tablewriter.Col("Provider"),
+			tablewriter.Col("Risks"),
+		)
+		for _, p := range s.Pieces {
+			for _, d := range p.Deals {
+				risks := "-"
+				if len(d.Risks) > 0 {
+					risks = strings.Join(d.Risks, "; ")
+				}
+				tw.Write(map[string]interface{}{
+					"Piece CID": p.PieceCid,
+					"Deal ID":   d.DealID,
+					"Provider":  d.Provider,
+					"Risks":     risks,
+				})
+			}
+		}
+		return tw.Flush(cctx.App.Writer)
+	},
+}
+
+// newStorageMapPublisher creates a publisher of snapshots of the dataset's
+// storage map as last checked by the watcher, signed with the client wallet
+func newStorageMapPublisher(cctx *cli.Context, n *clinode.Node, client address.Address, dataset *datasetwatch.Dataset, w *datasetwatch.Watcher) (*storagemap.Publisher, error) {
+	var previous *cid.Cid
+	if p := cctx.String("previous-snapshot"); p != "" {
+		c, err := cid.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("parsing previous snapshot cid %s: %w", p, err)
+		}
+		previous = &c
+	}
+
+	sign := func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+		return n.Wallet.WalletSign(ctx, addr, msg, lapi.MsgMeta{Type: lapi.MTUnknown})
+	}
+	snapshot := func() ([]storagemap.Piece, bool) {
+		status := w.Status()
+		if status == nil {
+			return nil, false
+		}
+		return storageMapPieces(dataset, status), true
+	}
+
+	ipfs := storagemap.NewIpfsClient(cctx.String(ipfsApiFlag.Name))
+	return storagemap.NewPublisher(ipfs, ipfs, sign, snapshot, storagemap.PublisherConfig{
+		Dataset:  dataset.Name,
+		Client:   client,
+		IpnsKey:  cctx.String("ipns-key"),
+		Previous: previous,
+	}), nil
+}
+
+// storageMapPieces converts the status of each piece of the dataset to the
+// pieces of a storage map snapshot
+func storageMapPieces(dataset *datasetwatch.Dataset, status []datasetwatch.PieceStatus) []storagemap.Piece {
+	payloadCids := make(map[cid.Cid]cid.Cid)
+	for _, d := range dataset.Deals {
+		if d.PayloadCid.Defined() {
+			payloadCids[d.PieceCid] = d.PayloadCid
+		}
+	}
+
+	pieces := make([]storagemap.Piece, 0, len(status))
+	for _, ps := range status {
+		p := storagemap.Piece{PieceCid: ps.PieceCid}
+		if c, ok := payloadCids[ps.PieceCid]; ok {
+			p.PayloadCid = &c
+		}
+		for _, ds := range ps.Deals {
+			p.Deals = append(p.Deals, storagemap.Deal{
+				DealID:   int64(ds.DealID),
+				Provider: ds.Provider.String(),
+				Risks:    ds.Risks,
+			})
+		}
+		pieces = append(pieces, p)
+	}
+	return pieces
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/transferoverrides"
+	"github.com/filecoin-project/go-address"
+	lapi "github.com/filecoin-project/lotus/api"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// transferOverridesFile is the name of the per-provider transfer overrides
+// file in the client repo
+const transferOverridesFile = "transfer-overrides.json"
+
+func init() {
+	cmd.RegisterJsonOutput("transfer-overrides list", []transferoverrides.Override{})
+}
+
+var transferOverridesCmd = &cli.Command{
+	Name:  "transfer-overrides",
+	Usage: "Manage the transfer parameters used for particular storage providers",
+	Description: "The overrides for a provider are applied to every deal made with it by the deal, deal-batch " +
+		"and prep-api commands, in place of their defaults (flags that are set on the command line take " +
+		"precedence). prep-api also serves these commands to callers with the admin token:\n\n" +
+		"   GET    /transfer-overrides             list the overrides\n" +
+		"   GET    /transfer-overrides/{provider}  get the overrides for a provider\n" +
+		"   PUT    /transfer-overrides/{provider}  set the overrides for a provider: {\"transport\": \"tcp\", ...}\n" +
+		"   DELETE /transfer-overrides/{provider}  remove the overrides for a provider",
+	Before: before,
+	Subcommands: []*cli.Command{
+		transferOverridesListCmd,
+		transferOverridesSetCmd,
+		transferOverridesRemoveCmd,
+	},
+}
+
+var transferOverridesListCmd = &cli.Command{
+	Name:   "list",
+	Usage:  "List the providers with transfer overrides",
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		store, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		overrides, err := store.List()
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(overrides)
+		}
+		if len(overrides) == 0 {
+			fmt.Println("no transfer overrides")
+			return nil
+		}
+
+		orDash := func(v interface{}) string {
+			s := fmt.Sprint(v)
+			if s == "" || s == "0" || s == "0s" {
+				return "-"
+			}
+			return s
+		}
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "PROVIDER\tTRANSPORT\tMULTIADDRS\tFRAME SIZE\tPROPOSAL INTERVAL\tSTART TIMEOUT\tTIMEOUT\tRETRY\n")
+		for _, o := range overrides {
+			retry := "-"
+			if r := o.TransferRetry(); r != nil {
+				retry = fmt.Sprintf("%d attempts, %s-%s backoff", r.MaxAttempts, r.MinBackoff, r.MaxBackoff)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", o.Provider, orDash(o.Transport),
+				orDash(strings.Join(o.Multiaddrs, ",")), orDash(o.TcpFrameSize),
+				orDash(time.Duration(o.ProposalInterval)), orDash(time.Duration(o.TransferStartTimeout)),
+				orDash(time.Duration(o.TransferTimeout)), retry)
+		}
+		return w.Flush()
+	},
+}
+
+var transferOverridesSetCmd = &cli.Command{
+	Name:      "set",
+	Usage:     "Set transfer overrides for a provider",
+	ArgsUsage: "<provider>",
+	Description: "Only the parameters that are set are changed; the provider's other overrides are kept. " +
+		"Use remove to clear the provider's overrides.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "transport",
+			Usage: "the transport to serve CAR files over with --serve-car: 'http' or 'tcp'",
+		},
+		&cli.StringSliceFlag{
+			Name:  "multiaddr",
+			Usage: "a multiaddr to dial the provider at, in place of its on-chain multiaddrs (can be repeated)",
+		},
+		&cli.IntFlag{
+			Name:  "tcp-frame-size",
+			Usage: "the size in bytes of the data frames that CAR files are served in over tcp (at most 1MiB)",
+		},
+		&cli.DurationFlag{
+			Name:  "proposal-interval",
+			Usage: "the minimum time between proposals to the provider in a deal batch",
+		},
+		&cli.DurationFlag{
+			Name:  "transfer-start-timeout",
+			Usage: "the time the provider has to start the transfer",
+		},
+		&cli.DurationFlag{
+			Name:  "transfer-timeout",
+			Usage: "the time the provider has to complete the transfer",
+		},
+		&cli.Uint64Flag{
+			Name:  "transfer-retry-attempts",
+			Usage: "the maximum number of attempts the provider makes to transfer the data (requires deal protocol v1.3)",
+		},
+		&cli.DurationFlag{
+			Name:  "transfer-retry-min-backoff",
+			Usage: "how long the provider waits before retrying a failed transfer (requires deal protocol v1.3)",
+		},
+		&cli.DurationFlag{
+			Name:  "transfer-retry-max-backoff",
+			Usage: "the longest the provider waits between retries of a failed transfer (requires deal protocol v1.3)",
+		},
+	},
+	Before: before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: transfer-overrides set <provider>")
+		}
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("parsing provider address: %w", err)
+		}
+		store, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		o, err := store.Get(maddr)
+		if errors.Is(err, transferoverrides.ErrNotFound) {
+			o = &transferoverrides.Override{Provider: maddr.String()}
+		} else if err != nil {
+			return err
+		}
+
+		if cctx.IsSet("transport") {
+			o.Transport = cctx.String("transport")
+		}
+		if cctx.IsSet("multiaddr") {
+			o.Multiaddrs = cctx.StringSlice("multiaddr")
+		}
+		if cctx.IsSet("tcp-frame-size") {
+			o.TcpFrameSize = cctx.Int("tcp-frame-size")
+		}
+		durations := map[string]*transferoverrides.Duration{
+			"proposal-interval":          &o.ProposalInterval,
+			"transfer-start-timeout":     &o.TransferStartTimeout,
+			"transfer-timeout":           &o.TransferTimeout,
+			"transfer-retry-min-backoff": &o.TransferRetryMinBackoff,
+			"transfer-retry-max-backoff": &o.TransferRetryMaxBackoff,
+		}
+		for name, d := range durations {
+			if cctx.IsSet(name) {
+				*d = transferoverrides.Duration(cctx.Duration(name))
+			}
+		}
+		if cctx.IsSet("transfer-retry-attempts") {
+			o.TransferRetryAttempts = cctx.Uint64("transfer-retry-attempts")
+		}
+		return store.Set(*o)
+	},
+}
+
+var transferOverridesRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove the transfer overrides for a provider",
+	ArgsUsage: "<provider>",
+	Before:    before,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: transfer-overrides remove <provider>")
+		}
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("parsing provider address: %w", err)
+		}
+		store, err := openTransferOverrides(cctx)
+		if err != nil {
+			return err
+		}
+		return store.Remove(maddr)
+	},
+}
+
+// openTransferOverrides opens the transfer overrides in the client repo
+func openTransferOverrides(cctx *cli.Context) (*transferoverrides.Store, error) {
+	sdir, err := homedir.Expand(cctx.String(cmd.FlagRepo.Name))
+	if err != nil {
+		return nil, err
+	}
+	return transferoverrides.NewStore(filepath.Join(sdir, transferOverridesFile)), nil
+}
+
+// providerTransferOverride returns the transfer overrides for the provider,
+// or an empty override if the provider has none
+func providerTransferOverride(store *transferoverrides.Store, maddr address.Address) (*transferoverrides.Override, error) {
+	o, err := store.Get(maddr)
+	if errors.Is(err, transferoverrides.ErrNotFound) {
+		return &transferoverrides.Override{Provider: maddr.String()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// overriddenAddrInfo returns the provider's peer ID and multiaddrs. If the
+// override sets multiaddrs for the provider, they are used in place of the
+// provider's on-chain multiaddrs.
+func overriddenAddrInfo(ctx context.Context, api lapi.Gateway, maddr address.Address, o *transferoverrides.Override) (*peer.AddrInfo, error) {
+	if len(o.Multiaddrs) == 0 {
+		return cmd.GetAddrInfo(ctx, api, maddr)
+	}
+	minfo, err := api.StateMinerInfo(ctx, maddr, chain_types.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	if minfo.PeerId == nil {
+		return nil, fmt.Errorf("storage provider %s has no peer ID set on-chain", maddr)
+	}
+	addrs, err := o.ParsedMultiaddrs()
+	if err != nil {
+		return nil, err
+	}
+	return &peer.AddrInfo{ID: *minfo.PeerId, Addrs: addrs}, nil
+}
+
+// overriddenTransferTimeouts returns the time the provider has to start,
+// and to complete, the transfer: the flags if they are set, otherwise the
+// provider's overrides
+func overriddenTransferTimeouts(cctx *cli.Context, o *transferoverrides.Override) (time.Duration, time.Duration) {
+	start := cctx.Duration("transfer-start-timeout")
+	if !cctx.IsSet("transfer-start-timeout") {
+		start = time.Duration(o.TransferStartTimeout)
+	}
+	timeout := cctx.Duration("transfer-timeout")
+	if !cctx.IsSet("transfer-timeout") {
+		timeout = time.Duration(o.TransferTimeout)
+	}
+	return start, timeout
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/datasetwatch"
+	"github.com/filecoin-project/boost/lib/dealbatch"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	chain_types "github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var watchDatasetCmd = &cli.Command{
+	Name:      "watch-dataset",
+	Usage:     "Watch the providers storing a critical dataset, and rescue pieces at risk of being lost",
+	ArgsUsage: "<dataset json file>",
+	Description: "The dataset file lists the deals that store the dataset: " +
+		`{"name": "...", "deals": [{"dealId": 1, "provider": "f01000", "pieceCid": "...", "payloadCid": "..."}]}. ` +
+		"At each interval every deal is checked: whether it is still active on chain, whether its provider has " +
+		"lost power to faults, and whether the provider still serves the piece over http. When a piece has fewer " +
+		"healthy deals than min-healthy, it is retrieved from a healthy provider to rescue-dir, and offline deals " +
+		"for it are proposed to the replacement providers (one for each deal at risk). The rescued CAR file must " +
+		"then be imported by each replacement provider. With publish-storage-map, a snapshot of the dataset's " +
+		"deals and their health, signed with the client wallet, is published to IPFS (and optionally IPNS) at " +
+		"each publish-interval, so that third parties can find and verify where the dataset is stored with the " +
+		"verify-storage-map command.",
+	Before: before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "rescue-dir",
+			Usage:    "the directory to retrieve pieces at risk to",
+			Required: true,
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to check the dataset's deals",
+			Value: 10 * time.Minute,
+		},
+		&cli.IntFlag{
+			Name:  "min-healthy",
+			Usage: "the minimum number of healthy deals for each piece: a piece with fewer is rescued",
+			Value: 2,
+		},
+		&cli.IntFlag{
+			Name:  "probe-failures",
+			Usage: "the number of consecutive failed retrieval probes after which a deal is at risk (0 disables probing)",
+			Value: 3,
+		},
+		&cli.Float64Flag{
+			Name:  "max-power-loss",
+			Usage: "the fraction of its power a provider may lose to faults after the watch starts before its deals are at risk",
+			Value: 0.1,
+		},
+		&cli.StringSliceFlag{
+			Name:  "replacement-provider",
+			Usage: "a provider to re-replicate rescued pieces to (can be repeated; providers are used in order)",
+		},
+		&cli.StringFlag{
+			Name:  "wallet",
+			Usage: "wallet address to be used to make replacement deals",
+		},
+		&cli.IntFlag{
+			Name:  "duration",
+			Usage: "duration of replacement deals in epochs",
+			Value: 518400, // default is 2880 * 180 == 180 days
+		},
+		&cli.IntFlag{
+			Name:  "storage-price",
+			Usage: "storage price of replacement deals in attoFIL per epoch per GiB",
+			Value: 1,
+		},
+		&cli.BoolFlag{
+			Name:  "verified",
+			Usage: "whether replacement deals should be verified",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "publish-storage-map",
+			Usage: "periodically publish a signed snapshot of the dataset's storage map to IPFS",
+		},
+		ipfsApiFlag,
+		&cli.StringFlag{
+			Name:  "ipns-key",
+			Usage: "the name of the IPFS key to publish the latest storage map snapshot under (eg self); empty to not publish to IPNS",
+		},
+		&cli.DurationFlag{
+			Name:  "publish-interval",
+			Usage: "how often to publish the storage map snapshot",
+			Value: time.Hour,
+		},
+		&cli.StringFlag{
+			Name:  "previous-snapshot",
+			Usage: "the root cid of the last storage map snapshot published for the dataset, to link the first new snapshot to",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("usage: watch-dataset <dataset json file>")
+		}
+		dataset, err := datasetwatch.LoadDataset(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		rescueDir := cctx.String("rescue-dir")
+		if err := os.MkdirAll(rescueDir, 0755); err != nil {
+			return fmt.Errorf("creating rescue dir %s: %w", rescueDir, err)
+		}
+
+		var replacements []address.Address
+		for _, p := range cctx.StringSlice("replacement-provider") {
+			maddr, err := address.NewFromString(p)
+			if err != nil {
+				return fmt.Errorf("parsing replacement provider address %s: %w", p, err)
+			}
+			replacements = append(replacements, maddr)
+		}
+
+		n, err := clinode.Setup(cctx.String(cmd.FlagRepo.Name))
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := lcli.GetGatewayAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("cant setup gateway connection: %w", err)
+		}
+		defer closer()
+
+		walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, cctx.String("wallet"))
+		if err != nil {
+			return err
+		}
+
+		rescuer := &datasetRescuer{
+			node:         n,
+			api:          api,
+			wallet:       walletAddr,
+			dir:          rescueDir,
+			replacements: replacements,
+			duration:     abi.ChainEpoch(cctx.Int("duration")),
+			storagePrice: abi.NewTokenAmount(cctx.Int64("storage-price")),
+			verified:     cctx.Bool("verified"),
+		}
+		w := datasetwatch.New(dataset, &chainChecker{node: n, api: api}, rescuer, datasetwatch.Thresholds{
+			ProbeFailures: cctx.Int("probe-failures"),
+			MaxPowerLoss:  cctx.Float64("max-power-loss"),
+			MinHealthy:    cctx.Int("min-healthy"),
+		})
+
+		if cctx.Bool("publish-storage-map") {
+			p, err := newStorageMapPublisher(cctx, n, walletAddr, dataset, w)
+			if err != nil {
+				return err
+			}
+			log.Infow("publishing storage map snapshots", "dataset", dataset.Name, "ipfs api", cctx.String(ipfsApiFlag.Name),
+				"ipns key", cctx.String("ipns-key"), "interval", cctx.Duration("publish-interval"))
+			go p.Run(ctx, cctx.Duration("publish-interval"))
+		}
+
+		log.Infow("watching dataset", "dataset", dataset.Name, "deals", len(dataset.Deals), "interval", cctx.Duration("interval"))
+		w.Run(ctx, cctx.Duration("interval"))
+		return nil
+	},
+}
+
+// chainChecker checks deals on chain, and probes providers over http
+type chainChecker struct {
+	node *clinode.Node
+	api  lapi.Gateway
+}
+
+func (c *chainChecker) DealActive(ctx context.Context, deal datasetwatch.Deal) (bool, error) {
+	md, err := c.api.StateMarketStorageDeal(ctx, deal.DealID, chain_types.EmptyTSK)
+	if err != nil {
+		// Deals are removed from the market actor's state once they are
+		// terminated or expire
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, err
+	}
+	if md.State.SlashEpoch >= 0 {
+		return false, nil
+	}
+	head, err := c.api.ChainHead(ctx)
+	if err != nil {
+		return false, fmt.Errorf("getting chain head: %w", err)
+	}
+	return head.Height() < md.Proposal.EndEpoch, nil
+}
+
+func (c *chainChecker) ProviderPower(ctx context.Context, provider address.Address) (abi.StoragePower, error) {
+	power, err := c.api.StateMinerPower(ctx, provider, chain_types.EmptyTSK)
+	if err != nil {
+		return abi.StoragePower{}, err
+	}
+	return power.MinerPower.RawBytePower, nil
+}
+
+func (c *chainChecker) Probe(ctx context.Context, deal datasetwatch.Deal) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	src := retrievalSources(c.node, c.api, []address.Address{deal.Provider})[0]
+	return carfetch.Probe(ctx, src, url.Values{"pieceCid": {deal.PieceCid.String()}})
+}
+
+// datasetRescuer retrieves pieces at risk to a local directory, and proposes
+// offline deals for them to replacement providers
+type datasetRescuer struct {
+	node         *clinode.Node
+	api          lapi.Gateway
+	wallet       address.Address
+	dir          string
+	replacements []address.Address
+	duration     abi.ChainEpoch
+	storagePrice abi.TokenAmount
+	verified     bool
+
+	// The next replacement provider to use
+	next int
+}
+
+func (r *datasetRescuer) Rescue(ctx context.Context, pieceCid cid.Cid, healthy []datasetwatch.Deal, atRisk []datasetwatch.DealStatus) error {
+	if len(healthy) == 0 {
+		return fmt.Errorf("no healthy provider to retrieve piece %s from", pieceCid)
+	}
+
+	// The size of the piece is the same in every deal for it
+	md, err := r.api.StateMarketStorageDeal(ctx, healthy[0].DealID, chain_types.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("getting deal %d from chain: %w", healthy[0].DealID, err)
+	}
+	pieceSize := md.Proposal.PieceSize
+
+	providers := make([]address.Address, 0, len(healthy))
+	for _, d := range healthy {
+		providers = append(providers, d.Provider)
+	}
+	outPath := filepath.Join(r.dir, pieceCid.String()+".car")
+	query := url.Values{"pieceCid": {pieceCid.String()}}
+	res, err := carfetch.Fetch(ctx, retrievalSources(r.node, r.api, providers), query, outPath)
+	if err != nil {
+		return fmt.Errorf("retrieving piece %s: %w", pieceCid, err)
+	}
+	log.Infow("retrieved piece at risk", "piece", pieceCid, "path", outPath, "size", res.Size, "provider", res.Source())
+
+	payloadCid := healthy[0].PayloadCid
+	if !payloadCid.Defined() && len(res.Roots) > 0 {
+		payloadCid = res.Roots[0]
+	}
+
+	// Replace each deal at risk with a deal with a replacement provider
+	if len(r.replacements) == 0 {
+		log.Warnw("no replacement providers: piece has been retrieved but not re-replicated", "piece", pieceCid)
+		return nil
+	}
+	// Replacement deals that were made in an earlier rescue of the piece,
+	// or whose proposal outcome was unknown, are checked first so that they
+	// aren't made twice
+	replaced, err := r.checkReplacements(ctx, pieceCid)
+	if err != nil {
+		return err
+	}
+	// If a proposal fails the rescue fails, so that the piece is rescued
+	// again at the next check. The other deals at risk are still replaced.
+	var merr *multierror.Error
+	for i := replaced; i < len(atRisk); i++ {
+		maddr := r.replacements[r.next%len(r.replacements)]
+		r.next++
+		dealUuid, err := r.proposeOfflineDeal(ctx, maddr, payloadCid, pieceCid, pieceSize, uint64(res.Size))
+		var uerr *types.ProposalOutcomeUnknownError
+		if errors.As(err, &uerr) {
+			log.Warnw("replacement deal proposal outcome is unknown: the provider will be asked for its status before the piece is rescued again",
+				"piece", pieceCid, "provider", maddr, logctx.DealKey, uerr.DealUUID, "err", err)
+			if serr := r.addReplacement(pieceCid, replacementProposal{Provider: maddr, DealUUID: uerr.DealUUID}); serr != nil {
+				return serr
+			}
+			merr = multierror.Append(merr, fmt.Errorf("proposing replacement deal to %s: %w", maddr, err))
+			continue
+		}
+		if err != nil {
+			log.Errorw("proposing replacement deal", "piece", pieceCid, "provider", maddr, "err", err)
+			merr = multierror.Append(merr, fmt.Errorf("proposing replacement deal to %s: %w", maddr, err))
+			continue
+		}
+		log.Infow("proposed replacement offline deal: the provider must import the rescued CAR file",
+			"piece", pieceCid, "provider", maddr, logctx.DealKey, dealUuid, "path", outPath)
+		if err := r.addReplacement(pieceCid, replacementProposal{Provider: maddr, DealUUID: dealUuid, Accepted: true}); err != nil {
+			return err
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// replacementProposal is a replacement deal that was proposed for a piece.
+// If the deal isn't accepted, the proposal may have reached the provider but
+// no response was received.
+type replacementProposal struct {
+	Provider address.Address
+	DealUUID uuid.UUID
+	Accepted bool
+}
+
+// The replacement proposals for a piece are kept in the rescue dir, so that
+// they are checked even after a restart
+func (r *datasetRescuer) replacementsPath(pieceCid cid.Cid) string {
+	return filepath.Join(r.dir, pieceCid.String()+".replacements.json")
+}
+
+func (r *datasetRescuer) replacementProposals(pieceCid cid.Cid) ([]replacementProposal, error) {
+	data, err := os.ReadFile(r.replacementsPath(pieceCid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading replacement proposals for piece %s: %w", pieceCid, err)
+	}
+	var rps []replacementProposal
+	if err := json.Unmarshal(data, &rps); err != nil {
+		return nil, fmt.Errorf("parsing replacement proposals for piece %s: %w", pieceCid, err)
+	}
+	return rps, nil
+}
+
+func (r *datasetRescuer) saveReplacements(pieceCid cid.Cid, rps []replacementProposal) error {
+	path := r.replacementsPath(pieceCid)
+	if len(rps) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing replacement proposals for piece %s: %w", pieceCid, err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(rps)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("saving replacement proposals for piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+func (r *datasetRescuer) addReplacement(pieceCid cid.Cid, rp replacementProposal) error {
+	rps, err := r.replacementProposals(pieceCid)
+	if err != nil {
+		return err
+	}
+	return r.saveReplacements(pieceCid, append(rps, rp))
+}
+
+// checkReplacements returns the number of the piece's replacement deals
+// that must not be replaced again: the deals that were accepted, and the
+// deals whose proposal outcome was unknown that the provider has or whose
+// status still can't be checked.
+func (r *datasetRescuer) checkReplacements(ctx context.Context, pieceCid cid.Cid) (int, error) {
+	rps, err := r.replacementProposals(pieceCid)
+	if err != nil || len(rps) == 0 {
+		return 0, err
+	}
+
+	var keep []replacementProposal
+	for _, rp := range rps {
+		if rp.Accepted {
+			keep = append(keep, rp)
+			continue
+		}
+		resp, err := r.checkProposed(ctx, rp)
+		switch {
+		case err != nil:
+			log.Warnw("could not check the status of replacement deal whose proposal outcome is unknown",
+				"piece", pieceCid, "provider", rp.Provider, logctx.DealKey, rp.DealUUID, "err", err)
+			keep = append(keep, rp)
+		case resp != nil:
+			log.Infow("replacement deal was accepted (confirmed by deal status after the proposal response was lost)",
+				"piece", pieceCid, "provider", rp.Provider, logctx.DealKey, rp.DealUUID)
+			rp.Accepted = true
+			keep = append(keep, rp)
+		default:
+			log.Infow("provider does not have replacement deal whose proposal outcome was unknown",
+				"piece", pieceCid, "provider", rp.Provider, logctx.DealKey, rp.DealUUID)
+		}
+	}
+	return len(keep), r.saveReplacements(pieceCid, keep)
+}
+
+func (r *datasetRescuer) checkProposed(ctx context.Context, rp replacementProposal) (*types.DealResponse, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, r.api, rp.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.node.Host.Connect(ctx, *addrInfo); err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+	return dealbatch.CheckProposed(ctx, r.dealClient(), addrInfo.ID, rp.DealUUID)
+}
+
+func (r *datasetRescuer) dealClient() *dealClientProposer {
+	return &dealClientProposer{lp2pimpl.NewDealClient(r.node.Host, r.wallet, clinode.DealProposalSigner{LocalWallet: r.node.Wallet})}
+}
+
+// proposeOfflineDeal proposes an offline deal for the piece. If the outcome
+// of the proposal is unknown, the error is a ProposalOutcomeUnknownError.
+func (r *datasetRescuer) proposeOfflineDeal(ctx context.Context, maddr address.Address, payloadCid cid.Cid, pieceCid cid.Cid, pieceSize abi.PaddedPieceSize, carSize uint64) (uuid.UUID, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, r.api, maddr)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := r.node.Host.Connect(ctx, *addrInfo); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+
+	bounds, err := r.api.StateDealProviderCollateralBounds(ctx, pieceSize, r.verified, chain_types.EmptyTSK)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("node error getting collateral bounds: %w", err)
+	}
+	providerCollateral := big.Div(big.Mul(bounds.Min, big.NewInt(6)), big.NewInt(5)) // add 20%
+
+	tipset, err := r.api.ChainHead(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	startEpoch := tipset.Height() + abi.ChainEpoch(5760) // head + 2 days
+
+	label, err := dealLabel(LabelModePayloadCid, payloadCid, "")
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("creating deal label: %w", err)
+	}
+	proposal, err := dealProposal(ctx, r.node, r.wallet, payloadCid, pieceSize, pieceCid, maddr, startEpoch,
+		int(r.duration), r.verified, providerCollateral, r.storagePrice, label.Label)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create a deal proposal: %w", err)
+	}
+
+	dealUuid := uuid.New()
+	params := types.DealParams{
+		DealUUID:           dealUuid,
+		ClientDealProposal: *proposal,
+		DealDataRoot:       payloadCid,
+		IsOffline:          true,
+		Transfer:           types.Transfer{Size: carSize},
+	}
+	resp, err := dealbatch.ProposeChecked(ctx, r.dealClient(), addrInfo.ID, params, 2)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("send deal proposal: %w", err)
+	}
+	if !resp.Accepted {
+		return uuid.Nil, fmt.Errorf("deal proposal rejected: %s", resp.Message)
+	}
+	return dealUuid, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -12,6 +13,9 @@ import (
 	"github.com/urfave/cli/v2"
 	"gopkg.in/cheggaaa/pb.v1"
 
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/lib/repobackup"
 	"github.com/filecoin-project/boost/node"
 	"github.com/filecoin-project/lotus/lib/backupds"
 	lotus_repo "github.com/filecoin-project/lotus/node/repo"
@@ -26,45 +30,30 @@ var fm = []string{"boost.db",
 	"token"}
 
 var backupCmd = &cli.Command{
-	Name:        "backup",
-	Usage:       "boostd backup <backup directory>",
-	Description: "Performs offline backup of Boost",
-	Before:      before,
+	Name:      "backup",
+	Usage:     "Back up the boost repo to an archive",
+	ArgsUsage: "<backup directory>",
+	Description: `Writes the deals database (including funds and storage tags), the deal logs,
+the metadata datastore, the keystore, the config files and the dagstore
+(the shard datastore and the piece indexes) into a single archive named
+boost_backup_<timestamp>.tar.gz in the backup directory.
+
+By default boostd must be stopped while the backup is taken. With --online
+the running boostd takes the backup itself without stopping, and the backup
+directory is on the boostd host; it must be inside the directory in the
+BOOST_BACKUP_BASE_PATH env var of the boostd process.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "online",
+			Usage: "take the backup from the running boostd process",
+		},
+	},
+	Before: before,
 	Action: func(cctx *cli.Context) error {
 		if cctx.Args().Len() != 1 {
 			return fmt.Errorf("usage: boostd backup <backup directory>")
 		}
 
-		boostRepoPath := cctx.String(FlagBoostRepo)
-
-		r, err := lotus_repo.NewFS(boostRepoPath)
-		if err != nil {
-			return err
-		}
-		ok, err := r.Exists()
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return fmt.Errorf("repo at '%s' is not initialized", cctx.String(FlagBoostRepo))
-		}
-
-		lr, err := r.LockRO(node.Boost)
-		if err != nil {
-			return fmt.Errorf("locking repo: %w. Please stop the boostd process to take backup", err)
-		}
-		defer lr.Close()
-
-		mds, err := lr.Datastore(cctx.Context, metadataNamespace)
-		if err != nil {
-			return fmt.Errorf("getting metadata datastore: %w", err)
-		}
-
-		bds, err := backupds.Wrap(mds, backupds.NoLogdir)
-		if err != nil {
-			return err
-		}
-
 		bkpPath, err := homedir.Expand(cctx.Args().First())
 		if err != nil {
 			return fmt.Errorf("expanding backup directory path: %w", err)
@@ -75,108 +64,143 @@ var backupCmd = &cli.Command{
 			return fmt.Errorf("failed get absolute path for backup directory: %w", err)
 		}
 
-		fmt.Println("Creating backup directory")
+		fpath := path.Join(bpath, "boost_backup_"+time.Now().Format("20060102150405")+".tar.gz")
 
-		bkpDir := path.Join(bpath, "boost_backup_"+time.Now().Format("20060102150405"))
-
-		if err := os.Mkdir(bkpDir, 0755); err != nil {
-			return fmt.Errorf("error creating backup directory %s: %w", bkpDir, err)
-		}
-
-		b, err := lotus_repo.NewFS(bkpDir)
-		if err != nil {
-			return err
+		var m *repobackup.Manifest
+		if cctx.Bool("online") {
+			m, err = onlineBackup(cctx, fpath)
+		} else {
+			m, err = offlineBackup(cctx, fpath)
 		}
-
-		lb, err := b.Lock(node.Boost)
 		if err != nil {
 			return err
 		}
-		defer lb.Close()
-
-		fmt.Println("Copying keystore")
-
-		if err := os.Mkdir(path.Join(bkpDir, "keystore"), 0700); err != nil {
-			return fmt.Errorf("error creating keystore directory %s: %w", path.Join(bkpDir, "keystore"), err)
-		}
 
-		if err := migrateMarketsKeystore(lr, lb); err != nil {
-			return fmt.Errorf("error copying keys: %w", err)
-		}
-
-		if err := os.Mkdir(path.Join(bkpDir, "config"), 0755); err != nil {
-			return fmt.Errorf("error creating config directory %s: %w", path.Join(bkpDir, "config"), err)
-		}
+		fmt.Printf("Boost repo successfully backed up to %s (%d files)\n", fpath, len(m.Entries))
 
-		fpathName := path.Join(bkpDir, metadaFileName)
-
-		fpath, err := homedir.Expand(fpathName)
-		if err != nil {
-			return fmt.Errorf("expanding metadata file path: %w", err)
-		}
+		return nil
+	},
+}
 
-		fmt.Println("creating metadata backup")
+func onlineBackup(cctx *cli.Context, fpath string) (*repobackup.Manifest, error) {
+	ctx := bcli.ReqContext(cctx)
 
-		out, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("opening backup file %s: %w", fpath, err)
-		}
+	boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting boost api: %w", err)
+	}
+	defer ncloser()
 
-		defer func() {
-			if err := out.Close(); err != nil {
-				log.Errorw("closing backup file: %w", err)
-			}
-		}()
+	fmt.Println("Creating online backup")
 
-		if err := bds.Backup(cctx.Context, out); err != nil {
-			return fmt.Errorf("backup error: %w", err)
-		}
+	m, err := boostApi.BoostBackup(ctx, fpath)
+	if err != nil {
+		return nil, fmt.Errorf("backup error: %w", err)
+	}
+	return m, nil
+}
 
-		cfgFiles, err := ioutil.ReadDir(path.Join(lr.Path(), "config"))
-		if err != nil {
-			return fmt.Errorf("failed to read files from config directory: %w", err)
-		}
+func offlineBackup(cctx *cli.Context, fpath string) (*repobackup.Manifest, error) {
+	boostRepoPath := cctx.String(FlagBoostRepo)
 
-		for _, cfgFile := range cfgFiles {
-			f := path.Join("config", cfgFile.Name())
-			fm = append(fm, f)
-		}
+	r, err := lotus_repo.NewFS(boostRepoPath)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := r.Exists()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("repo at '%s' is not initialized", cctx.String(FlagBoostRepo))
+	}
 
-		fmt.Println("Copying the files to backup directory")
+	lr, err := r.LockRO(node.Boost)
+	if err != nil {
+		return nil, fmt.Errorf("locking repo: %w. Please stop the boostd process to take backup, "+
+			"or take an online backup with --online", err)
+	}
+	defer lr.Close()
 
-		destPath, err := homedir.Expand(bkpDir)
-		if err != nil {
-			return fmt.Errorf("expanding destination file path %s: %w", bkpDir, err)
-		}
+	mds, err := lr.Datastore(cctx.Context, metadataNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("getting metadata datastore: %w", err)
+	}
 
-		if err := copyFiles(lr.Path(), destPath, fm); err != nil {
-			return fmt.Errorf("error copying file: %w", err)
-		}
+	bds, err := backupds.Wrap(mds, backupds.NoLogdir)
+	if err != nil {
+		return nil, err
+	}
 
-		fmt.Println("Boost repo successfully backed up at " + bkpDir)
+	sqldb, err := db.SqlDB(path.Join(lr.Path(), repobackup.DBName))
+	if err != nil {
+		return nil, fmt.Errorf("opening boost database: %w", err)
+	}
+	defer sqldb.Close()
 
-		return nil
-	},
+	logsdb, err := db.SqlDB(path.Join(lr.Path(), repobackup.LogsDBName))
+	if err != nil {
+		return nil, fmt.Errorf("opening boost logs database: %w", err)
+	}
+	defer logsdb.Close()
+
+	fmt.Println("Creating backup")
+
+	m, err := repobackup.CreateFile(cctx.Context, fpath, repobackup.Source{
+		RepoPath: lr.Path(),
+		Metadata: bds,
+		DB:       sqldb,
+		LogsDB:   logsdb,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("backup error: %w", err)
+	}
+	return m, nil
 }
 
 var restoreCmd = &cli.Command{
-	Name:        "restore",
-	Usage:       "boostd restore <backup dir>",
-	Description: "Restores a boost repository from backup",
-	Before:      before,
+	Name:      "restore",
+	Usage:     "Restores a boost repository from backup",
+	ArgsUsage: "<backup archive or directory>",
+	Description: `Restores a boost repository from a backup archive, or from a directory
+written by an earlier version of boostd backup. The integrity of the
+archive and of the databases in it is checked before anything is restored.`,
+	Before: before,
 	Action: func(cctx *cli.Context) error {
 		if cctx.Args().Len() != 1 {
-			return fmt.Errorf("restore only takes one argument (backup directory path)")
+			return fmt.Errorf("restore only takes one argument (backup archive or directory path)")
 		}
 
 		bkpPath, err := homedir.Expand(cctx.Args().First())
 		if err != nil {
-			return fmt.Errorf("expanding backup directory path: %w", err)
+			return fmt.Errorf("expanding backup path: %w", err)
 		}
 
 		bpath, err := filepath.Abs(bkpPath)
 		if err != nil {
-			return fmt.Errorf("failed get absolute path for backup directory: %w", err)
+			return fmt.Errorf("failed get absolute path for backup: %w", err)
+		}
+
+		bst, err := os.Stat(bpath)
+		if err != nil {
+			return fmt.Errorf("getting status of backup %s: %w", bpath, err)
+		}
+		if !bst.IsDir() {
+			// Extract the archive next to the repo, and restore from there
+			repoPath, err := homedir.Expand(cctx.String(FlagBoostRepo))
+			if err != nil {
+				return fmt.Errorf("expanding repo path: %w", err)
+			}
+			tmpDir, err := os.MkdirTemp(filepath.Dir(repoPath), ".boost-restore-")
+			if err != nil {
+				return fmt.Errorf("creating temporary directory: %w", err)
+			}
+			defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+			if err := extractBackup(bpath, tmpDir); err != nil {
+				return err
+			}
+			bpath = tmpDir
 		}
 
 		fmt.Printf("Checking backup directory %s\n", bpath)
@@ -191,6 +215,12 @@ var restoreCmd = &cli.Command{
 			}
 		}
 
+		for _, fileName := range []string{repobackup.DBName, repobackup.LogsDBName} {
+			if err := repobackup.CheckDB(cctx.Context, path.Join(bpath, fileName)); err != nil {
+				return err
+			}
+		}
+
 		repoPath := cctx.String(FlagBoostRepo)
 		fmt.Printf("Checking if repo exists at %s\n", repoPath)
 
@@ -282,7 +312,7 @@ var restoreCmd = &cli.Command{
 		}
 
 		cfgFiles, err := ioutil.ReadDir(path.Join(lb.Path(), "config"))
-		if err != nil {
+		if err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("failed to read files from config directory: %w", err)
 		}
 
@@ -300,12 +330,57 @@ var restoreCmd = &cli.Command{
 			return fmt.Errorf("error copying file: %w", err)
 		}
 
+		fmt.Println("Restoring dagstore")
+
+		for _, dir := range repobackup.DagstoreDirs {
+			if err := copyTree(lb.Path(), lr.Path(), dir); err != nil {
+				return fmt.Errorf("error copying %s: %w", dir, err)
+			}
+		}
+
 		fmt.Println("Boost repo successfully restored at " + lr.Path())
 
 		return nil
 	},
 }
 
+func extractBackup(fpath string, dir string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return fmt.Errorf("opening backup archive: %w", err)
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat backup archive: %w", err)
+	}
+
+	fmt.Printf("Extracting backup archive %s\n", fpath)
+
+	bar := pb.New64(st.Size())
+	br := bar.NewProxyReader(f)
+	bar.ShowTimeLeft = true
+	bar.ShowPercent = true
+	bar.ShowSpeed = true
+	bar.Units = pb.U_BYTES
+
+	bar.Start()
+	m, err := repobackup.Extract(br, dir)
+	bar.Finish()
+
+	if err != nil {
+		return fmt.Errorf("extracting backup archive: %w", err)
+	}
+
+	kind := "offline"
+	if m.Online {
+		kind = "online"
+	}
+	fmt.Printf("Verified %d files in %s backup taken at %s\n", len(m.Entries), kind, m.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
 func copyFiles(srcDir, destDir string, flist []string) error {
 
 	for _, fName := range flist {
@@ -314,7 +389,7 @@ func copyFiles(srcDir, destDir string, flist []string) error {
 
 		if os.IsNotExist(err) {
 			fmt.Printf("Not copying %s as file does not exists\n", path.Join(srcDir, fName))
-			return nil
+			continue
 		}
 
 		if err != nil && !os.IsNotExist(err) {
@@ -346,3 +421,51 @@ func copyFiles(srcDir, destDir string, flist []string) error {
 
 	return nil
 }
+
+// copyTree copies the directory dir in srcDir, with all its subdirectories,
+// to destDir
+func copyTree(srcDir, destDir, dir string) error {
+	root := filepath.Join(srcDir, filepath.FromSlash(dir))
+	err := filepath.WalkDir(root, func(fpath string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, fpath)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+
+		st, err := d.Info()
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		defer in.Close() //nolint:errcheck
+
+		// piece indexes can be large, so stream them rather than reading
+		// them into memory
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, st.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			_ = out.Close()
+			return err
+		}
+		return out.Close()
+	})
+	if os.IsNotExist(err) {
+		fmt.Printf("Not copying %s as directory does not exist\n", root)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/urfave/cli/v2"
+)
+
+var configCmd = &cli.Command{
+	Name:  "config",
+	Usage: "Manage the configuration of the running boost node",
+	Subcommands: []*cli.Command{
+		configReloadCmd,
+	},
+}
+
+var configReloadCmd = &cli.Command{
+	Name: "reload",
+	Usage: "Reload tunable configuration (transfer limits and timeouts, commp settings, the retrieval ACL) " +
+		"from the config file without restarting boost. If the config file also changes settings that are " +
+		"only applied when boost starts, nothing is reloaded. Sending SIGHUP to the boostd process has the " +
+		"same effect.",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		if err := boostApi.BoostConfigReload(ctx); err != nil {
+			return fmt.Errorf("reloading config: %w", err)
+		}
+
+		fmt.Println("Config reloaded")
+		return nil
+	},
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/boost/api"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/dtcontrol"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("data-transfers pause", []dtcontrol.Result{})
+	cmd.RegisterJsonOutput("data-transfers resume", []dtcontrol.Result{})
+	cmd.RegisterJsonOutput("data-transfers cancel", []dtcontrol.Result{})
+	cmd.RegisterJsonOutput("data-transfers intents", []dtcontrol.Intent{})
+}
+
+// transferControlFlags are the flags of the commands that pause, resume and
+// cancel data transfers
+var transferControlFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "peerid",
+		Usage: "narrow to transfer with specific peer",
+	},
+	&cli.BoolFlag{
+		Name:  "initiator",
+		Usage: "specify only transfers where peer is/is not initiator",
+		Value: false,
+	},
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "apply to all the transfers (storage and retrieval) with the peer given by --peerid",
+	},
+}
+
+var marketPauseTransfer = &cli.Command{
+	Name:      "pause",
+	Usage:     "Pause a data transfer, or all the data transfers with a peer",
+	ArgsUsage: "<transfer id>",
+	Description: "A paused transfer stays paused until it is resumed, even if it is restarted (eg because boostd " +
+		"or the peer was restarted).",
+	Flags: transferControlFlags,
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() && !cctx.Bool("all") {
+			return cli.ShowCommandHelp(cctx, cctx.Command.Name)
+		}
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if cctx.Bool("all") {
+			other, err := transferPeerArg(cctx)
+			if err != nil {
+				return err
+			}
+			res, err := napi.BoostDataTransferPausePeer(ctx, other)
+			if err != nil {
+				return err
+			}
+			return printTransferControlResults(cctx, "paused", res)
+		}
+
+		transferID, other, initiator, err := transferChannelArgs(ctx, cctx, napi)
+		if err != nil {
+			return err
+		}
+		return napi.BoostDataTransferPause(ctx, transferID, other, initiator)
+	},
+}
+
+var marketResumeTransfer = &cli.Command{
+	Name:      "resume",
+	Usage:     "Resume a paused data transfer, or all the paused data transfers with a peer",
+	ArgsUsage: "<transfer id>",
+	Flags:     transferControlFlags,
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() && !cctx.Bool("all") {
+			return cli.ShowCommandHelp(cctx, cctx.Command.Name)
+		}
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if cctx.Bool("all") {
+			other, err := transferPeerArg(cctx)
+			if err != nil {
+				return err
+			}
+			res, err := napi.BoostDataTransferResumePeer(ctx, other)
+			if err != nil {
+				return err
+			}
+			return printTransferControlResults(cctx, "resumed", res)
+		}
+
+		transferID, other, initiator, err := transferChannelArgs(ctx, cctx, napi)
+		if err != nil {
+			return err
+		}
+		return napi.BoostDataTransferResume(ctx, transferID, other, initiator)
+	},
+}
+
+var transfersIntentsCmd = &cli.Command{
+	Name:  "intents",
+	Usage: "List the data transfers that were paused or cancelled, and are paused or cancelled again if restarted",
+	Action: func(cctx *cli.Context) error {
+		napi, closer, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		intents, err := napi.BoostDataTransferIntents(ctx)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(intents)
+		}
+		if len(intents) == 0 {
+			fmt.Println("No data transfers are paused or being cancelled")
+			return nil
+		}
+		for _, in := range intents {
+			fmt.Printf("%s  %s  since %s\n", in.ChannelID, in.Action, in.CreatedAt.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+// transferPeerArg returns the peer given by --peerid, for the commands that
+// apply to all the transfers with a peer
+func transferPeerArg(cctx *cli.Context) (peer.ID, error) {
+	pidstr := cctx.String("peerid")
+	if pidstr == "" {
+		return "", errors.New("--peerid must be set with --all")
+	}
+	return peer.Decode(pidstr)
+}
+
+// transferChannelArgs returns the transfer given by the transfer id
+// argument. If --peerid is not set, the peer is looked up from the ongoing
+// transfers.
+func transferChannelArgs(ctx context.Context, cctx *cli.Context, napi api.Boost) (datatransfer.TransferID, peer.ID, bool, error) {
+	transferUint, err := strconv.ParseUint(cctx.Args().First(), 10, 64)
+	if err != nil {
+		return 0, "", false, fmt.Errorf("Error reading transfer ID: %w", err)
+	}
+	transferID := datatransfer.TransferID(transferUint)
+	initiator := cctx.Bool("initiator")
+	if pidstr := cctx.String("peerid"); pidstr != "" {
+		p, err := peer.Decode(pidstr)
+		if err != nil {
+			return 0, "", false, err
+		}
+		return transferID, p, initiator, nil
+	}
+
+	channels, err := napi.MarketListDataTransfers(ctx)
+	if err != nil {
+		return 0, "", false, err
+	}
+	for _, channel := range channels {
+		if channel.IsInitiator == initiator && channel.TransferID == transferID {
+			return transferID, channel.OtherPeer, initiator, nil
+		}
+	}
+	return 0, "", false, errors.New("unable to find matching data transfer")
+}
+
+func printTransferControlResults(cctx *cli.Context, action string, res []dtcontrol.Result) error {
+	if cctx.Bool("json") {
+		return cmd.PrintJson(res)
+	}
+	if len(res) == 0 {
+		fmt.Println("No matching data transfers")
+		return nil
+	}
+	for _, r := range res {
+		if r.Error != "" {
+			fmt.Printf("%s: %s\n", r.ChannelID, r.Error)
+			continue
+		}
+		fmt.Printf("%s: %s\n", r.ChannelID, action)
+	}
+	return nil
+}
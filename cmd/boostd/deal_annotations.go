@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("deal-annotations list", []smtypes.DealAnnotation{})
+}
+
+var dealAnnotationsCmd = &cli.Command{
+	Name:  "deal-annotations",
+	Usage: "Attach notes, ticket links and other key / value annotations to deals, eg to track problem deals",
+	Description: "The well-known keys are '" + smtypes.AnnotationNote + "', '" + smtypes.AnnotationStatus +
+		"' and '" + smtypes.AnnotationTicket + "' (which must be an http(s) url), but any key may be used.",
+	Subcommands: []*cli.Command{
+		dealAnnotationsSetCmd,
+		dealAnnotationsRemoveCmd,
+		dealAnnotationsListCmd,
+	},
+}
+
+var dealAnnotationsSetCmd = &cli.Command{
+	Name:      "set",
+	Usage:     "Set an annotation on a deal, replacing any existing value for the key",
+	ArgsUsage: "<deal uuid> <key> <value>",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 3 {
+			return fmt.Errorf("must specify the deal uuid, the key and the value")
+		}
+		dealUuid, err := uuid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("parsing deal uuid %s: %w", cctx.Args().Get(0), err)
+		}
+		key, value := cctx.Args().Get(1), cctx.Args().Get(2)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		if err := boostApi.BoostDealAnnotate(ctx, dealUuid, key, value); err != nil {
+			return fmt.Errorf("setting deal annotation: %w", err)
+		}
+		fmt.Printf("set %s of deal %s to '%s'\n", key, dealUuid, value)
+		return nil
+	},
+}
+
+var dealAnnotationsRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove an annotation from a deal",
+	ArgsUsage: "<deal uuid> <key>",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("must specify the deal uuid and the key")
+		}
+		dealUuid, err := uuid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("parsing deal uuid %s: %w", cctx.Args().Get(0), err)
+		}
+		key := cctx.Args().Get(1)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		if err := boostApi.BoostDealAnnotationRemove(ctx, dealUuid, key); err != nil {
+			return fmt.Errorf("removing deal annotation: %w", err)
+		}
+		fmt.Printf("removed %s from deal %s\n", key, dealUuid)
+		return nil
+	},
+}
+
+var dealAnnotationsListCmd = &cli.Command{
+	Name:      "list",
+	Usage:     "List the annotations of a deal, or of all deals",
+	ArgsUsage: "[deal uuid]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "only list annotations with the key (when listing the annotations of all deals)",
+		},
+		&cli.StringFlag{
+			Name:  "value",
+			Usage: "only list annotations with the value (when listing the annotations of all deals)",
+		},
+		&cli.BoolFlag{
+			Name:  "csv",
+			Usage: "export the annotations as CSV",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.Args().Len() > 1 {
+			return fmt.Errorf("usage: list [deal uuid]")
+		}
+		var dealUuid uuid.UUID
+		if cctx.Args().Present() {
+			if cctx.IsSet("key") || cctx.IsSet("value") {
+				return fmt.Errorf("the key and value filters can only be used when listing the annotations of all deals")
+			}
+			var err error
+			dealUuid, err = uuid.Parse(cctx.Args().First())
+			if err != nil {
+				return fmt.Errorf("parsing deal uuid %s: %w", cctx.Args().First(), err)
+			}
+		}
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		var anns []smtypes.DealAnnotation
+		if dealUuid != uuid.Nil {
+			anns, err = boostApi.BoostDealAnnotations(ctx, dealUuid)
+		} else {
+			anns, err = boostApi.BoostDealAnnotationsList(ctx, cctx.String("key"), cctx.String("value"))
+		}
+		if err != nil {
+			return fmt.Errorf("getting deal annotations: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			if anns == nil {
+				anns = []smtypes.DealAnnotation{}
+			}
+			return cmd.PrintJson(anns)
+		}
+		if cctx.Bool("csv") {
+			w := csv.NewWriter(os.Stdout)
+			if err := w.Write([]string{"deal_uuid", "key", "value", "updated_at"}); err != nil {
+				return err
+			}
+			for _, a := range anns {
+				if err := w.Write([]string{a.DealUuid.String(), a.Key, a.Value, a.UpdatedAt.Format(time.RFC3339)}); err != nil {
+					return err
+				}
+			}
+			w.Flush()
+			return w.Error()
+		}
+		if len(anns) == 0 {
+			fmt.Println("no annotations")
+			return nil
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Deal"),
+			tablewriter.Col("Key"),
+			tablewriter.Col("Value"),
+			tablewriter.Col("Updated"),
+		)
+		for _, a := range anns {
+			tw.Write(map[string]interface{}{
+				"Deal":    a.DealUuid,
+				"Key":     a.Key,
+				"Value":   a.Value,
+				"Updated": humanize.Time(a.UpdatedAt),
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
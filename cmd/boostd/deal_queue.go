@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("deal-queue list", []smtypes.QueuedDeal{})
+}
+
+var dealQueueCmd = &cli.Command{
+	Name:  "deal-queue",
+	Usage: "Inspect and reorder the deals waiting for their data transfer to start",
+	Subcommands: []*cli.Command{
+		dealQueueListCmd,
+		dealQueueSetWeightCmd,
+	},
+}
+
+var dealQueueListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the deals waiting for their data transfer to start, in the order they will start",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		queue, err := boostApi.BoostDealQueue(ctx)
+		if err != nil {
+			return fmt.Errorf("getting deal queue: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(queue)
+		}
+		if len(queue) == 0 {
+			fmt.Println("no deals are waiting for their transfer to start")
+			return nil
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Position"),
+			tablewriter.Col("Deal"),
+			tablewriter.Col("Client"),
+			tablewriter.Col("Piece Size"),
+			tablewriter.Col("Verified"),
+			tablewriter.Col("Host"),
+			tablewriter.Col("Queued"),
+			tablewriter.Col("Priority"),
+			tablewriter.Col("Weight"),
+			tablewriter.Col("Window Opens"),
+		)
+		for _, d := range queue {
+			row := map[string]interface{}{
+				"Position":   d.Position,
+				"Deal":       d.DealUuid,
+				"Client":     d.Client,
+				"Piece Size": humanize.IBytes(uint64(d.PieceSize)),
+				"Verified":   d.Verified,
+				"Host":       d.Host,
+				"Queued":     humanize.Time(d.CreatedAt),
+				"Priority":   d.Priority,
+				"Weight":     d.Weight,
+			}
+			if d.TransferWindowOpen != nil {
+				row["Window Opens"] = humanize.Time(*d.TransferWindowOpen)
+			}
+			tw.Write(row)
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+var dealQueueSetWeightCmd = &cli.Command{
+	Name:      "set-weight",
+	Usage:     "Set a weight that is added to the priority of a queued deal, to move it up (or down) the queue",
+	ArgsUsage: "<deal uuid> <weight>",
+	Description: "To set a negative weight, put -- before the arguments, eg\n" +
+		"boostd deal-queue set-weight -- <deal uuid> -10",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("must specify the deal uuid and the weight")
+		}
+		dealUuid, err := uuid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("parsing deal uuid %s: %w", cctx.Args().Get(0), err)
+		}
+		weight, err := strconv.ParseInt(cctx.Args().Get(1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing weight %s: %w", cctx.Args().Get(1), err)
+		}
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		if err := boostApi.BoostDealQueueSetWeight(ctx, dealUuid, weight); err != nil {
+			return fmt.Errorf("setting deal weight: %w", err)
+		}
+		fmt.Printf("set the weight of deal %s to %d\n", dealUuid, weight)
+		return nil
+	},
+}
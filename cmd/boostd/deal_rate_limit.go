@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("deal-rate-limit list", smtypes.DealRateLimitStatus{})
+}
+
+var dealRateLimitCmd = &cli.Command{
+	Name:  "deal-rate-limit",
+	Usage: "Inspect and change the limits on the rate of deal proposals from each client",
+	Subcommands: []*cli.Command{
+		dealRateLimitListCmd,
+		dealRateLimitSetCmd,
+	},
+}
+
+var dealRateLimitListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "Show the deal rate limits, and the recent deal proposals of each client wallet address and peer",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		st, err := boostApi.BoostDealRateLimits(ctx)
+		if err != nil {
+			return fmt.Errorf("getting deal rate limits: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(st)
+		}
+		fmt.Printf("per client wallet address: %s\n", formatDealRateLimit(st.Limits.PerClient))
+		fmt.Printf("per client peer: %s\n", formatDealRateLimit(st.Limits.PerPeer))
+		if len(st.Usage) == 0 {
+			fmt.Println("\nno deal proposals in the last day")
+			return nil
+		}
+		fmt.Println()
+
+		tw := tablewriter.New(
+			tablewriter.Col("Kind"),
+			tablewriter.Col("ID"),
+			tablewriter.Col("Deals Last Hour"),
+			tablewriter.Col("Bytes Last Day"),
+			tablewriter.Col("Rejected"),
+		)
+		for _, u := range st.Usage {
+			tw.Write(map[string]interface{}{
+				"Kind":            u.Kind,
+				"ID":              u.ID,
+				"Deals Last Hour": u.DealsLastHour,
+				"Bytes Last Day":  humanize.IBytes(u.BytesLastDay),
+				"Rejected":        u.Rejected,
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+var dealRateLimitSetCmd = &cli.Command{
+	Name:  "set",
+	Usage: "Change the deal rate limits until boostd restarts or its config is reloaded",
+	Description: "Only the limits that are set with flags are changed. Set a limit to 0 to remove it. " +
+		"To keep the change, also set the limits in the DealRateLimits section of config.toml.",
+	Flags: []cli.Flag{
+		&cli.Uint64Flag{
+			Name:  "client-deals-per-hour",
+			Usage: "the maximum number of deal proposals from a client wallet address in any hour",
+		},
+		&cli.StringFlag{
+			Name:  "client-bytes-per-day",
+			Usage: "the maximum total piece size of deal proposals from a client wallet address in any day, eg 10TiB",
+		},
+		&cli.Uint64Flag{
+			Name:  "peer-deals-per-hour",
+			Usage: "the maximum number of deal proposals from a peer in any hour",
+		},
+		&cli.StringFlag{
+			Name:  "peer-bytes-per-day",
+			Usage: "the maximum total piece size of deal proposals from a peer in any day, eg 10TiB",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		st, err := boostApi.BoostDealRateLimits(ctx)
+		if err != nil {
+			return fmt.Errorf("getting deal rate limits: %w", err)
+		}
+		limits := st.Limits
+		if cctx.IsSet("client-deals-per-hour") {
+			limits.PerClient.DealsPerHour = cctx.Uint64("client-deals-per-hour")
+		}
+		if cctx.IsSet("peer-deals-per-hour") {
+			limits.PerPeer.DealsPerHour = cctx.Uint64("peer-deals-per-hour")
+		}
+		for _, f := range []struct {
+			name  string
+			limit *uint64
+		}{
+			{"client-bytes-per-day", &limits.PerClient.BytesPerDay},
+			{"peer-bytes-per-day", &limits.PerPeer.BytesPerDay},
+		} {
+			if !cctx.IsSet(f.name) {
+				continue
+			}
+			*f.limit, err = humanize.ParseBytes(cctx.String(f.name))
+			if err != nil {
+				return fmt.Errorf("parsing --%s: %w", f.name, err)
+			}
+		}
+
+		if err := boostApi.BoostDealRateLimitsSet(ctx, limits); err != nil {
+			return fmt.Errorf("setting deal rate limits: %w", err)
+		}
+		fmt.Printf("per client wallet address: %s\n", formatDealRateLimit(limits.PerClient))
+		fmt.Printf("per client peer: %s\n", formatDealRateLimit(limits.PerPeer))
+		return nil
+	},
+}
+
+func formatDealRateLimit(l smtypes.DealRateLimit) string {
+	deals := "unlimited deals"
+	if l.DealsPerHour > 0 {
+		deals = fmt.Sprintf("%d deals", l.DealsPerHour)
+	}
+	bytes := "unlimited bytes"
+	if l.BytesPerDay > 0 {
+		bytes = humanize.IBytes(l.BytesPerDay)
+	}
+	return fmt.Sprintf("%s per hour, %s per day", deals, bytes)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/faults"
+	"github.com/urfave/cli/v2"
+)
+
+var faultsCmd = &cli.Command{
+	Name:  "faults",
+	Usage: "Inject failures for testing client retry logic (requires Testing.EnableFaultInjection in config)",
+	Subcommands: []*cli.Command{
+		faultsGetCmd,
+		faultsSetCmd,
+		faultsClearCmd,
+	},
+}
+
+var faultsGetCmd = &cli.Command{
+	Name:  "get",
+	Usage: "Show the failures that are still to be injected",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		f, err := boostApi.BoostFaultsGet(ctx)
+		if err != nil {
+			return fmt.Errorf("getting faults: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(f)
+		}
+
+		fmt.Printf("drop vouchers: %d\n", f.DropVouchers)
+		fmt.Printf("response delay: %s\n", f.ResponseDelay)
+		fmt.Printf("corrupt blocks: %d\n", f.CorruptBlocks)
+		return nil
+	},
+}
+
+var faultsSetCmd = &cli.Command{
+	Name:  "set",
+	Usage: "Set the failures to inject (replaces any pending failures)",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "drop-vouchers",
+			Usage: "drop the next N data transfer vouchers received from retrieval clients",
+		},
+		&cli.DurationFlag{
+			Name:  "response-delay",
+			Usage: "delay each response to deal proposals and deal status requests",
+		},
+		&cli.IntFlag{
+			Name:  "corrupt-blocks",
+			Usage: "corrupt the next N blocks served from the blockstore",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		err = boostApi.BoostFaultsSet(ctx, faults.Faults{
+			DropVouchers:  cctx.Int("drop-vouchers"),
+			ResponseDelay: cctx.Duration("response-delay"),
+			CorruptBlocks: cctx.Int("corrupt-blocks"),
+		})
+		if err != nil {
+			return fmt.Errorf("setting faults: %w", err)
+		}
+
+		return nil
+	},
+}
+
+var faultsClearCmd = &cli.Command{
+	Name:  "clear",
+	Usage: "Stop injecting failures",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		err = boostApi.BoostFaultsSet(ctx, faults.Faults{})
+		if err != nil {
+			return fmt.Errorf("clearing faults: %w", err)
+		}
+
+		return nil
+	},
+}
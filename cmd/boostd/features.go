@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("features list", []features.Status{})
+}
+
+var featuresCmd = &cli.Command{
+	Name:  "features",
+	Usage: "Manage experimental features (runtime changes last until boostd is restarted, set Features in config to persist them)",
+	Subcommands: []*cli.Command{
+		featuresListCmd,
+		featuresEnableCmd,
+		featuresDisableCmd,
+	},
+}
+
+var featuresListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List experimental features and whether they are enabled",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		st, err := boostApi.BoostFeatures(ctx)
+		if err != nil {
+			return fmt.Errorf("getting features: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(st)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Feature\tEnabled\tDefault\tDescription\n")
+		for _, f := range st {
+			_, _ = fmt.Fprintf(w, "%s\t%t\t%t\t%s\n", f.Name, f.Enabled, f.Default, f.Description)
+		}
+		return w.Flush()
+	},
+}
+
+var featuresEnableCmd = &cli.Command{
+	Name:      "enable",
+	Usage:     "Enable an experimental feature",
+	ArgsUsage: "<feature>",
+	Action: func(cctx *cli.Context) error {
+		return setFeature(cctx, true)
+	},
+}
+
+var featuresDisableCmd = &cli.Command{
+	Name:      "disable",
+	Usage:     "Disable an experimental feature",
+	ArgsUsage: "<feature>",
+	Action: func(cctx *cli.Context) error {
+		return setFeature(cctx, false)
+	},
+}
+
+func setFeature(cctx *cli.Context, enabled bool) error {
+	if cctx.Args().Len() != 1 {
+		return fmt.Errorf("usage: %s", cctx.Command.ArgsUsage)
+	}
+	name := cctx.Args().First()
+
+	ctx := bcli.ReqContext(cctx)
+	boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+	if err != nil {
+		return fmt.Errorf("getting boost api: %w", err)
+	}
+	defer ncloser()
+
+	if err := boostApi.BoostFeatureSet(ctx, name, enabled); err != nil {
+		return fmt.Errorf("setting feature %s: %w", name, err)
+	}
+
+	if enabled {
+		fmt.Printf("enabled %s\n", name)
+	} else {
+		fmt.Printf("disabled %s\n", name)
+	}
+	return nil
+}
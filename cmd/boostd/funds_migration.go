@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/fundsmigration"
+	"github.com/filecoin-project/go-address"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("client-funds-migration status", fundsmigration.Status{})
+	cmd.RegisterJsonOutput("client-funds-migration run", fundsmigration.Status{})
+}
+
+var clientFundsMigrationCmd = &cli.Command{
+	Name:  "client-funds-migration",
+	Usage: "Manage the migration of client funds reserved by the legacy markets client",
+	Subcommands: []*cli.Command{
+		clientFundsMigrationStatusCmd,
+		clientFundsMigrationRunCmd,
+	},
+}
+
+var clientFundsMigrationStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "Show the status of the client funds migration",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		st, err := boostApi.BoostClientFundsMigrationStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("getting client funds migration status: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(st)
+		}
+		printFundsMigrationStatus(st)
+		return nil
+	},
+}
+
+var clientFundsMigrationRunCmd = &cli.Command{
+	Name:  "run",
+	Usage: "Reserve the legacy client funds with the full node's fund manager",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "wallet",
+			Usage:       "the wallet to reserve the funds for",
+			DefaultText: "the full node's default wallet",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the amount that would be reserved without reserving it",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		wallet := address.Undef
+		if cctx.IsSet("wallet") {
+			var err error
+			wallet, err = address.NewFromString(cctx.String("wallet"))
+			if err != nil {
+				return fmt.Errorf("parsing wallet address %s: %w", cctx.String("wallet"), err)
+			}
+		}
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		st, err := boostApi.BoostClientFundsMigrate(ctx, wallet, cctx.Bool("dry-run"))
+		if err != nil {
+			return fmt.Errorf("migrating client funds: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(st)
+		}
+		if st.DryRun {
+			fmt.Printf("dry run: would reserve %s for wallet %s\n", chaintypes.FIL(st.Amount), st.Wallet)
+			return nil
+		}
+		printFundsMigrationStatus(st)
+		return nil
+	},
+}
+
+func printFundsMigrationStatus(st *fundsmigration.Status) {
+	fmt.Printf("state: %s\n", st.State)
+	if st.State == fundsmigration.StateNone {
+		return
+	}
+	fmt.Printf("amount: %s\n", chaintypes.FIL(st.Amount))
+	if st.Wallet != address.Undef {
+		fmt.Printf("wallet: %s\n", st.Wallet)
+	}
+	if st.Message != nil {
+		fmt.Printf("message: %s\n", st.Message)
+	}
+	if st.Error != "" {
+		fmt.Printf("error: %s\n", st.Error)
+	}
+	if !st.UpdatedAt.IsZero() {
+		fmt.Printf("updated: %s\n", st.UpdatedAt.Format("2006-01-02 15:04:05"))
+	}
+}
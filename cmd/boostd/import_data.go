@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/ipfs/go-cid"
+	"io"
 	"os"
 	"strings"
 
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/api"
 	bcli "github.com/filecoin-project/boost/cli"
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
@@ -15,6 +19,25 @@ var importDataCmd = &cli.Command{
 	Name:      "import-data",
 	Usage:     "Import data for offline deal made with Boost",
 	ArgsUsage: "<proposal CID> <file> or <deal UUID> <file>",
+	Description: "By default the file must be on the boostd host. With --upload the file is streamed from this " +
+		"machine to the staging area of boostd over the API, in chunks. If the upload is interrupted, running " +
+		"the command again resumes it from the last chunk that boostd received. Once the upload is complete " +
+		"the deal is executed, starting with the verification of the piece commitment of the data.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "upload",
+			Usage: "upload the file from this machine instead of importing a file on the boostd host",
+		},
+		&cli.StringFlag{
+			Name:  "chunk-size",
+			Usage: "with --upload, the size of each chunk of the upload (a chunk that is interrupted is sent again)",
+			Value: "256MiB",
+		},
+		&cli.BoolFlag{
+			Name:  "restart",
+			Usage: "with --upload, discard the data received by a previous upload and start again from the beginning",
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		if cctx.Args().Len() < 2 {
 			return fmt.Errorf("must specify proposal CID / deal UUID and file path")
@@ -58,6 +81,9 @@ var importDataCmd = &cli.Command{
 
 				// The deal is not in the boost database, try the legacy
 				// markets datastore (v1.1.0 deal)
+				if cctx.Bool("upload") {
+					return fmt.Errorf("could not find boost deal with proposal cid %s: v1.1.0 deal data cannot be uploaded", proposalCid)
+				}
 				err := napi.MarketImportDealData(cctx.Context, *proposalCid, filePath)
 				if err != nil {
 					return fmt.Errorf("couldnt import v1.1.0 deal, or find boost deal: %w", err)
@@ -71,7 +97,28 @@ var importDataCmd = &cli.Command{
 		}
 
 		// Deal proposal by deal uuid (v1.2.0 deal)
-		rej, err := napi.BoostOfflineDealWithData(cctx.Context, dealUuid, filePath)
+		var rej *api.ProviderDealRejectionInfo
+		if cctx.Bool("upload") {
+			var chunkSize uint64
+			chunkSize, err = humanize.ParseBytes(cctx.String("chunk-size"))
+			if err != nil {
+				return fmt.Errorf("parsing chunk-size: %w", err)
+			}
+			if chunkSize == 0 {
+				return fmt.Errorf("chunk-size must be greater than zero")
+			}
+			if cctx.Bool("restart") {
+				if err := napi.BoostOfflineDealUploadCancel(cctx.Context, dealUuid); err != nil {
+					return fmt.Errorf("discarding previous upload: %w", err)
+				}
+			}
+			if err := uploadDealData(cctx.Context, napi, dealUuid, filePath, chunkSize); err != nil {
+				return err
+			}
+			rej, err = napi.BoostOfflineDealUploadFinish(cctx.Context, dealUuid)
+		} else {
+			rej, err = napi.BoostOfflineDealWithData(cctx.Context, dealUuid, filePath)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to execute offline deal: %w", err)
 		}
@@ -82,3 +129,73 @@ var importDataCmd = &cli.Command{
 		return nil
 	},
 }
+
+// uploadChunkAttempts is the number of times a chunk is sent before the
+// upload fails
+const uploadChunkAttempts = 3
+
+// uploadDealData streams the file to boostd in chunks, starting after the
+// bytes that boostd has already received
+func uploadDealData(ctx context.Context, napi api.Boost, dealUuid uuid.UUID, filePath string, chunkSize uint64) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("opening file %s: %w", filePath, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("getting size of %s: %w", filePath, err)
+	}
+	size := uint64(fi.Size())
+
+	st, err := napi.BoostOfflineDealUpload(ctx, dealUuid)
+	if err != nil {
+		return fmt.Errorf("getting upload progress: %w", err)
+	}
+	if size > st.MaxSize {
+		return fmt.Errorf("file %s is %d bytes but the deal's piece can hold at most %d bytes", filePath, size, st.MaxSize)
+	}
+	if st.Received > size {
+		return fmt.Errorf("boostd has received %d bytes, more than the %d bytes in %s: "+
+			"run the command with --restart to upload the file again", st.Received, size, filePath)
+	}
+	if st.Received > 0 {
+		fmt.Printf("Resuming upload after %s of %s\n", humanize.IBytes(st.Received), humanize.IBytes(size))
+	}
+
+	offset := st.Received
+	failures := 0
+	for offset < size {
+		n := size - offset
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+			return fmt.Errorf("seeking %s: %w", filePath, err)
+		}
+
+		received, err := napi.BoostOfflineDealUploadChunk(ctx, dealUuid, offset, io.LimitReader(f, int64(n)))
+		if err == nil && received <= offset {
+			err = fmt.Errorf("boostd received no data")
+		}
+		if err != nil {
+			failures++
+			if failures == uploadChunkAttempts || ctx.Err() != nil {
+				return fmt.Errorf("uploading chunk at offset %d (run the command again to resume the upload): %w", offset, err)
+			}
+			// Continue from however much of the chunk boostd received
+			st, serr := napi.BoostOfflineDealUpload(ctx, dealUuid)
+			if serr != nil {
+				return fmt.Errorf("uploading chunk at offset %d: %w (and getting upload progress: %s)", offset, err, serr)
+			}
+			fmt.Printf("Uploading chunk at offset %d failed, retrying: %s\n", offset, err)
+			offset = st.Received
+			continue
+		}
+		failures = 0
+		offset = received
+		fmt.Printf("Uploaded %s of %s\n", humanize.IBytes(offset), humanize.IBytes(size))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	// In json mode, imports list prints one ImportInfo per line
+	cmd.RegisterJsonOutput("imports list", types.ImportInfo{})
+}
+
+var importsCmd = &cli.Command{
+	Name:  "imports",
+	Usage: "Manage the data imported for deals",
+	Subcommands: []*cli.Command{
+		importsListCmd,
+	},
+}
+
+var importsListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the data imported for each piece",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "piece-cid",
+			Usage: "only show the import for this piece",
+		},
+		&cli.StringFlag{
+			Name:  "root",
+			Usage: "only show imports with this root cid",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "only show offline (true) or online (false) imports",
+		},
+		&cli.StringFlag{
+			Name:  "sort",
+			Usage: fmt.Sprintf("sort by one of %s, %s or %s", types.ImportsSortCreated, types.ImportsSortSize, types.ImportsSortDeals),
+			Value: types.ImportsSortCreated,
+		},
+		&cli.BoolFlag{
+			Name:  "asc",
+			Usage: "sort in ascending order",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "the maximum number of imports to show",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		filter := types.ImportsFilter{
+			SortBy:    cctx.String("sort"),
+			Ascending: cctx.Bool("asc"),
+			Limit:     cctx.Int("limit"),
+		}
+		if cctx.IsSet("piece-cid") {
+			pieceCid, err := cid.Parse(cctx.String("piece-cid"))
+			if err != nil {
+				return fmt.Errorf("parsing piece cid %s: %w", cctx.String("piece-cid"), err)
+			}
+			filter.PieceCID = &pieceCid
+		}
+		if cctx.IsSet("root") {
+			root, err := cid.Parse(cctx.String("root"))
+			if err != nil {
+				return fmt.Errorf("parsing root cid %s: %w", cctx.String("root"), err)
+			}
+			filter.Root = &root
+		}
+		if cctx.IsSet("offline") {
+			offline := cctx.Bool("offline")
+			filter.IsOffline = &offline
+		}
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		imports, err := boostApi.BoostListImports(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("listing imports: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			// Print one JSON object per line so that the output can be
+			// processed as a stream
+			enc := json.NewEncoder(os.Stdout)
+			for imp := range imports {
+				if err := enc.Encode(imp); err != nil {
+					return fmt.Errorf("writing json: %w", err)
+				}
+			}
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Piece CID\tRoots\tSize\tCreated\tDeals\tOffline\tPaths\n")
+		for imp := range imports {
+			roots := make([]string, 0, len(imp.Roots))
+			for _, r := range imp.Roots {
+				roots = append(roots, r.String())
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\t%s\n",
+				imp.PieceCID,
+				strings.Join(roots, ","),
+				humanize.IBytes(imp.Size),
+				imp.CreatedAt.Format("2006-01-02 15:04:05"),
+				imp.DealCount,
+				imp.IsOffline,
+				strings.Join(imp.Paths, ","))
+		}
+		return w.Flush()
+	},
+}
@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
 	tm "github.com/buger/goterm"
 	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
+	lapi "github.com/filecoin-project/lotus/api"
 	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/urfave/cli/v2"
@@ -24,7 +27,10 @@ var dataTransfersCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		transfersListCmd,
 		marketRestartTransfer,
+		marketPauseTransfer,
+		marketResumeTransfer,
 		marketCancelTransfer,
+		transfersIntentsCmd,
 		transfersDiagnosticsCmd,
 	},
 }
@@ -91,7 +97,9 @@ var marketRestartTransfer = &cli.Command{
 
 var marketCancelTransfer = &cli.Command{
 	Name:  "cancel",
-	Usage: "Force cancel a data transfer",
+	Usage: "Force cancel a data transfer, or all the data transfers with a peer",
+	Description: "The transfer is cancelled again if it is restarted (eg because boostd or the peer was " +
+		"restarted) before the cancellation completes.",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:  "peerid",
@@ -107,9 +115,13 @@ var marketCancelTransfer = &cli.Command{
 			Usage: "time to wait for cancel to be sent to client",
 			Value: 5 * time.Second,
 		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "cancel all the transfers (storage and retrieval) with the peer given by --peerid",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
-		if !cctx.Args().Present() {
+		if !cctx.Args().Present() && !cctx.Bool("all") {
 			return cli.ShowCommandHelp(cctx, cctx.Command.Name)
 		}
 		nodeApi, closer, err := bcli.GetBoostAPI(cctx)
@@ -119,6 +131,18 @@ var marketCancelTransfer = &cli.Command{
 		defer closer()
 		ctx := lcli.ReqContext(cctx)
 
+		if cctx.Bool("all") {
+			other, err := transferPeerArg(cctx)
+			if err != nil {
+				return err
+			}
+			res, err := nodeApi.BoostDataTransferCancelPeer(ctx, other)
+			if err != nil {
+				return err
+			}
+			return printTransferControlResults(cctx, "cancelled", res)
+		}
+
 		transferUint, err := strconv.ParseUint(cctx.Args().First(), 10, 64)
 		if err != nil {
 			return fmt.Errorf("Error reading transfer ID: %w", err)
@@ -152,7 +176,7 @@ var marketCancelTransfer = &cli.Command{
 
 		timeoutCtx, cancel := context.WithTimeout(ctx, cctx.Duration("cancel-timeout"))
 		defer cancel()
-		return nodeApi.MarketCancelDataTransfer(timeoutCtx, transferID, other, initiator)
+		return nodeApi.BoostDataTransferCancel(timeoutCtx, transferID, other, initiator)
 	},
 }
 
@@ -195,6 +219,12 @@ var transfersListCmd = &cli.Command{
 		completed := cctx.Bool("completed")
 		watch := cctx.Bool("watch")
 		showFailed := cctx.Bool("show-failed")
+		if cctx.Bool("json") {
+			if watch {
+				return fmt.Errorf("the watch flag cannot be used with json output")
+			}
+			return printDataTransfersJson(channels, completed, showFailed)
+		}
 		if watch {
 			channelUpdates, err := api.MarketDataTransferUpdates(ctx)
 			if err != nil {
@@ -236,6 +266,52 @@ var transfersListCmd = &cli.Command{
 	},
 }
 
+// dataTransferOutput is a data transfer in the output of the data-transfers
+// list command in json mode
+type dataTransferOutput struct {
+	TransferID  uint64 `json:"transferId"`
+	Status      string `json:"status"`
+	BaseCid     string `json:"baseCid"`
+	IsInitiator bool   `json:"isInitiator"`
+	IsSender    bool   `json:"isSender"`
+	Voucher     string `json:"voucher"`
+	Message     string `json:"message"`
+	OtherPeer   string `json:"otherPeer"`
+	Transferred uint64 `json:"transferred"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("data-transfers list", []dataTransferOutput{})
+}
+
+func printDataTransfersJson(channels []lapi.DataTransferChannel, completed bool, showFailed bool) error {
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].TransferID < channels[j].TransferID
+	})
+
+	out := make([]dataTransferOutput, 0, len(channels))
+	for _, channel := range channels {
+		if !completed && channel.Status == datatransfer.Completed {
+			continue
+		}
+		if !showFailed && (channel.Status == datatransfer.Failed || channel.Status == datatransfer.Cancelled) {
+			continue
+		}
+		out = append(out, dataTransferOutput{
+			TransferID:  uint64(channel.TransferID),
+			Status:      datatransfer.Statuses[channel.Status],
+			BaseCid:     channel.BaseCID.String(),
+			IsInitiator: channel.IsInitiator,
+			IsSender:    channel.IsSender,
+			Voucher:     channel.Voucher,
+			Message:     channel.Message,
+			OtherPeer:   channel.OtherPeer.String(),
+			Transferred: channel.Transferred,
+		})
+	}
+	return cmd.PrintJson(out)
+}
+
 var transfersDiagnosticsCmd = &cli.Command{
 	Name:  "diagnostics",
 	Usage: "Get detailed diagnostics on active transfers with a specific peer",
@@ -12,6 +12,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
 	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
 )
@@ -125,6 +126,22 @@ var retrievalDealSelectionRejectCmd = &cli.Command{
 	},
 }
 
+// retrievalDealOutput is a deal in the output of the retrieval-deals list
+// command in json mode
+type retrievalDealOutput struct {
+	Receiver     string `json:"receiver"`
+	DealID       uint64 `json:"dealId"`
+	PayloadCid   string `json:"payloadCid"`
+	State        string `json:"state"`
+	PricePerByte string `json:"pricePerByte"`
+	BytesSent    uint64 `json:"bytesSent"`
+	Message      string `json:"message"`
+}
+
+func init() {
+	cmd.RegisterJsonOutput("retrieval-deals list", []retrievalDealOutput{})
+}
+
 var retrievalDealsListCmd = &cli.Command{
 	Name:  "list",
 	Usage: "List all active retrieval deals for this miner",
@@ -144,6 +161,22 @@ var retrievalDealsListCmd = &cli.Command{
 			return deals[i].ID < deals[j].ID
 		})
 
+		if cctx.Bool("json") {
+			out := make([]retrievalDealOutput, 0, len(deals))
+			for _, deal := range deals {
+				out = append(out, retrievalDealOutput{
+					Receiver:     deal.Receiver.String(),
+					DealID:       uint64(deal.ID),
+					PayloadCid:   deal.PayloadCID.String(),
+					State:        retrievalmarket.DealStatuses[deal.Status],
+					PricePerByte: deal.PricePerByte.String(),
+					BytesSent:    deal.TotalSent,
+					Message:      deal.Message,
+				})
+			}
+			return cmd.PrintJson(out)
+		}
+
 		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
 
 		_, _ = fmt.Fprintf(w, "Receiver\tDealID\tPayload\tState\tPricePerByte\tBytesSent\tMessage\n")
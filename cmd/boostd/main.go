@@ -46,10 +46,22 @@ func main() {
 			retrievalDealsCmd,
 			indexProvCmd,
 			importDataCmd,
+			importsCmd,
 			logCmd,
 			dagstoreCmd,
 			piecesCmd,
 			netCmd,
+			faultsCmd,
+			featuresCmd,
+			configCmd,
+			reservationsCmd,
+			clientFundsMigrationCmd,
+			paychCmd,
+			dealQueueCmd,
+			dealAnnotationsCmd,
+			dealRateLimitCmd,
+			supportBundleCmd,
+			cmd.NewJsonSchemaCmd(),
 		},
 	}
 	app.Setup()
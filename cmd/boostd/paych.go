@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/paychmanager"
+	"github.com/filecoin-project/go-address"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("paych list", []paychmanager.Channel{})
+}
+
+var paychCmd = &cli.Command{
+	Name:  "paych",
+	Usage: "Manage payment channels",
+	Subcommands: []*cli.Command{
+		paychListCmd,
+		paychSettleCmd,
+	},
+}
+
+var paychListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List payment channels with their locked and collectable balances",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		chans, err := boostApi.BoostPaychInventory(ctx)
+		if err != nil {
+			return fmt.Errorf("getting payment channels: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(chans)
+		}
+		if len(chans) == 0 {
+			fmt.Println("no payment channels")
+			return nil
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Channel"),
+			tablewriter.Col("Direction"),
+			tablewriter.Col("From"),
+			tablewriter.Col("To"),
+			tablewriter.Col("State"),
+			tablewriter.Col("Balance"),
+			tablewriter.Col("Locked"),
+			tablewriter.Col("Available"),
+			tablewriter.Col("Collectable"),
+			tablewriter.Col("Scheduled"),
+		)
+		for _, c := range chans {
+			state := c.State
+			if c.Redundant {
+				state += " (redundant)"
+			}
+			tw.Write(map[string]interface{}{
+				"Channel":     c.Address,
+				"Direction":   c.Direction,
+				"From":        c.From,
+				"To":          c.To,
+				"State":       state,
+				"Balance":     chaintypes.FIL(c.Balance).Short(),
+				"Locked":      chaintypes.FIL(c.Locked).Short(),
+				"Available":   chaintypes.FIL(c.Available).Short(),
+				"Collectable": chaintypes.FIL(c.Collectable).Short(),
+				"Scheduled":   c.Scheduled,
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+var paychSettleCmd = &cli.Command{
+	Name:      "settle",
+	Usage:     "Schedule a payment channel to be settled (and later collected) when the base fee is low",
+	ArgsUsage: "<channel address>",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("must specify the payment channel address")
+		}
+		ch, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("parsing payment channel address %s: %w", cctx.Args().First(), err)
+		}
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		if err := boostApi.BoostPaychSettle(ctx, ch); err != nil {
+			return fmt.Errorf("scheduling payment channel settlement: %w", err)
+		}
+		fmt.Printf("payment channel %s scheduled to be settled\n", ch)
+		return nil
+	},
+}
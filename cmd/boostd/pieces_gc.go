@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/piecegc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("pieces gc", piecegc.Report{})
+}
+
+var piecesGCCmd = &cli.Command{
+	Name:  "gc",
+	Usage: "Remove the unsealed copies and indexes of pieces that are no longer stored in any active deal",
+	Description: "A piece is collected once all of its deals have expired, been slashed or had their sectors " +
+		"terminated, and the grace period (PieceGC.GracePeriod in the config) has passed. The piece's dagstore " +
+		"shard is destroyed, and the unsealed copy of each of its sectors is removed once no deal in the sector " +
+		"is active. The piece store entries of the piece are kept.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "report what would be removed without removing it",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		rpt, err := boostApi.BoostPieceGC(ctx, cctx.Bool("dry-run"))
+		if err != nil {
+			return fmt.Errorf("collecting pieces: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(rpt)
+		}
+
+		fmt.Printf("height %d, grace period %d epochs, %d pieces in active deals\n", rpt.Height, rpt.GracePeriod, rpt.ActivePieces)
+		if len(rpt.Pieces) == 0 && len(rpt.Sectors) == 0 {
+			fmt.Println("nothing to collect")
+			return nil
+		}
+
+		if len(rpt.Pieces) > 0 {
+			fmt.Println("\nIndexes:")
+			tw := tablewriter.New(
+				tablewriter.Col("Piece CID"),
+				tablewriter.Col("Deals"),
+				tablewriter.Col("Inactive Since"),
+				tablewriter.Col("Status"),
+			)
+			for _, p := range rpt.Pieces {
+				tw.Write(map[string]interface{}{
+					"Piece CID":      p.PieceCid,
+					"Deals":          p.Deals,
+					"Inactive Since": p.InactiveSince,
+					"Status":         gcStatus(rpt, p.Collected, p.CollectAt, p.Error),
+				})
+			}
+			if err := tw.Flush(os.Stdout); err != nil {
+				return err
+			}
+		}
+
+		if len(rpt.Sectors) > 0 {
+			fmt.Println("\nUnsealed sectors:")
+			tw := tablewriter.New(
+				tablewriter.Col("Sector"),
+				tablewriter.Col("Deals"),
+				tablewriter.Col("Inactive Since"),
+				tablewriter.Col("Status"),
+			)
+			for _, s := range rpt.Sectors {
+				tw.Write(map[string]interface{}{
+					"Sector":         s.Sector,
+					"Deals":          s.Deals,
+					"Inactive Since": s.InactiveSince,
+					"Status":         gcStatus(rpt, s.Collected, s.CollectAt, s.Error),
+				})
+			}
+			if err := tw.Flush(os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func gcStatus(rpt *piecegc.Report, collected bool, collectAt abi.ChainEpoch, errMsg string) string {
+	switch {
+	case errMsg != "":
+		return "error: " + errMsg
+	case collected && rpt.DryRun:
+		return "would remove"
+	case collected:
+		return "removed"
+	default:
+		return fmt.Sprintf("grace period (until epoch %d)", collectAt)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("reservations list", []types.CapacityReservationStatus{})
+}
+
+var reservationsCmd = &cli.Command{
+	Name:  "reservations",
+	Usage: "Manage capacity reserved by clients",
+	Subcommands: []*cli.Command{
+		reservationsListCmd,
+	},
+}
+
+var reservationsListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List capacity reservations",
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		list, err := boostApi.BoostCapacityReservations(ctx)
+		if err != nil {
+			return fmt.Errorf("listing capacity reservations: %w", err)
+		}
+
+		if cctx.Bool("json") {
+			return cmd.PrintJson(list)
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("ID"),
+			tablewriter.Col("Client"),
+			tablewriter.Col("State"),
+			tablewriter.Col("Filled"),
+			tablewriter.Col("Deals"),
+			tablewriter.Col("Start"),
+			tablewriter.Col("End"),
+			tablewriter.Col("Deposit"),
+		)
+		for _, st := range list {
+			res := st.Reservation
+			tw.Write(map[string]interface{}{
+				"ID":      res.ID,
+				"Client":  res.Client,
+				"State":   st.State,
+				"Filled":  fmt.Sprintf("%s / %s", humanize.IBytes(st.Filled), humanize.IBytes(res.Size)),
+				"Deals":   st.DealCount,
+				"Start":   res.StartEpoch,
+				"End":     res.EndEpoch,
+				"Deposit": chaintypes.FIL(res.Deposit),
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/filecoin-project/boost/api"
@@ -15,6 +16,9 @@ import (
 
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
 )
@@ -49,7 +53,10 @@ var runCmd = &cli.Command{
 		}
 		defer ncloser()
 
-		ctx := lcli.ReqContext(cctx)
+		// Don't use lcli.ReqContext here, as it cancels the context on
+		// SIGHUP, which is used to reload config
+		ctx, cancel := signal.NotifyContext(cctx.Context, syscall.SIGTERM, syscall.SIGINT)
+		defer cancel()
 
 		log.Debug("Checking full node version")
 
@@ -143,6 +150,9 @@ var runCmd = &cli.Command{
 			return fmt.Errorf("failed to start json-rpc endpoint: %s", err)
 		}
 
+		// Reload config on SIGHUP
+		go reloadConfigOnSighup(ctx, boostApi)
+
 		// Monitor for shutdown.
 		finishCh := node.MonitorShutdown(shutdownChan,
 			node.ShutdownHandler{Component: "rpc server", StopFunc: rpcStopper},
@@ -153,3 +163,21 @@ var runCmd = &cli.Command{
 		return nil
 	},
 }
+
+func reloadConfigOnSighup(ctx context.Context, boostApi api.Boost) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			log.Info("Received SIGHUP, reloading config")
+			if err := boostApi.BoostConfigReload(ctx); err != nil {
+				log.Errorf("Failed to reload config: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	bcli "github.com/filecoin-project/boost/cli"
+	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/supportbundle"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	cmd.RegisterJsonOutput("support-bundle diff", supportbundle.SnapshotDiff{})
+}
+
+var supportBundleCmd = &cli.Command{
+	Name:  "support-bundle",
+	Usage: "Create support bundles to attach to bug reports, and compare them",
+	Subcommands: []*cli.Command{
+		supportBundleCreateCmd,
+		supportBundleDiffCmd,
+	},
+}
+
+var supportBundleCreateCmd = &cli.Command{
+	Name:  "create",
+	Usage: "Snapshot the state of deals and transfers, their recent logs and the config (with secrets redacted) into a bundle",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "the path to write the bundle to (default boost_support_<timestamp>.tar.gz)",
+		},
+		&cli.IntFlag{
+			Name:  "recent-deals",
+			Usage: "the number of most recent deals to include, in addition to active deals",
+			Value: 100,
+		},
+		&cli.IntFlag{
+			Name:  "logs-per-deal",
+			Usage: "the maximum number of log lines to include for each deal",
+			Value: 50,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := bcli.ReqContext(cctx)
+
+		boostApi, ncloser, err := bcli.GetBoostAPI(cctx)
+		if err != nil {
+			return fmt.Errorf("getting boost api: %w", err)
+		}
+		defer ncloser()
+
+		s, err := boostApi.BoostSupportSnapshot(ctx, supportbundle.SnapshotParams{
+			RecentDeals: cctx.Int("recent-deals"),
+			LogsPerDeal: cctx.Int("logs-per-deal"),
+		})
+		if err != nil {
+			return fmt.Errorf("taking snapshot: %w", err)
+		}
+
+		fpath := cctx.String("output")
+		if fpath == "" {
+			fpath = "boost_support_" + s.TakenAt.Format("20060102150405") + ".tar.gz"
+		}
+		out, err := os.OpenFile(fpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("creating support bundle: %w", err)
+		}
+		if err := supportbundle.Write(out, s); err != nil {
+			_ = out.Close()
+			return fmt.Errorf("writing support bundle: %w", err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("closing support bundle: %w", err)
+		}
+
+		fmt.Printf("Wrote support bundle with %d deals, %d transfers and %d log lines to %s\n",
+			len(s.Deals), len(s.Transfers), len(s.Logs), fpath)
+		return nil
+	},
+}
+
+var supportBundleDiffCmd = &cli.Command{
+	Name:      "diff",
+	Usage:     "Show what changed between two support bundles",
+	ArgsUsage: "<earlier bundle> <later bundle>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("usage: boostd support-bundle diff <earlier bundle> <later bundle>")
+		}
+
+		var snapshots []*supportbundle.Snapshot
+		for _, fpath := range cctx.Args().Slice() {
+			s, err := readSupportBundle(fpath)
+			if err != nil {
+				return err
+			}
+			snapshots = append(snapshots, s)
+		}
+
+		d := supportbundle.Diff(snapshots[0], snapshots[1])
+		if cctx.Bool("json") {
+			return cmd.PrintJson(d)
+		}
+
+		fmt.Printf("%s -> %s (%s)\n", d.From.Format(time.RFC3339), d.To.Format(time.RFC3339), d.To.Sub(d.From).Round(time.Second))
+		if d.Empty() {
+			fmt.Println("no changes")
+			return nil
+		}
+		if d.FromVersion != d.ToVersion {
+			fmt.Printf("boost version: %s -> %s\n", d.FromVersion, d.ToVersion)
+		}
+		for _, deal := range d.DealsAdded {
+			fmt.Printf("+ deal %s: %s %s\n", deal.UUID, deal.Checkpoint, deal.Err)
+		}
+		for _, deal := range d.DealsRemoved {
+			fmt.Printf("- deal %s: %s %s\n", deal.UUID, deal.Checkpoint, deal.Err)
+		}
+		printSupportBundleChanges("deal", d.DealsChanged)
+		for _, xfer := range d.TransfersAdded {
+			fmt.Printf("+ %s transfer %s: %s\n", xfer.Kind, xfer.ID, xfer.Status)
+		}
+		for _, xfer := range d.TransfersRemoved {
+			fmt.Printf("- %s transfer %s: %s\n", xfer.Kind, xfer.ID, xfer.Status)
+		}
+		printSupportBundleChanges("transfer", d.TransfersChanged)
+		if len(d.ConfigChanges) > 0 {
+			fmt.Printf("config:\n  %s\n", strings.Join(d.ConfigChanges, "\n  "))
+		}
+		return nil
+	},
+}
+
+func printSupportBundleChanges(kind string, changes []supportbundle.Change) {
+	for _, c := range changes {
+		fmt.Printf("~ %s %s:\n", kind, c.ID)
+		for _, f := range c.Fields {
+			fmt.Printf("    %s: %s -> %s\n", f.Field, f.From, f.To)
+		}
+	}
+}
+
+func readSupportBundle(fpath string) (*supportbundle.Snapshot, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("opening support bundle: %w", err)
+	}
+	defer f.Close()
+
+	s, err := supportbundle.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fpath, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/cmd/booster-bitswap/peerlimit"
+	"github.com/filecoin-project/go-jsonrpc"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+const adminNamespace = "BoosterBitswap"
+
+var FlagAdminPort = &cli.UintFlag{
+	Name:  "admin-port",
+	Usage: "the port of the JSON-RPC endpoint used to inspect and ban peers (it only listens on localhost)",
+	Value: 8889,
+}
+
+// AdminAPI is served over JSON-RPC on the admin port, to inspect the
+// consumption of each peer and change the per-peer limits
+type AdminAPI struct {
+	limiter *peerlimit.Limiter
+}
+
+func (a *AdminAPI) PeerStats(ctx context.Context) ([]peerlimit.PeerStats, error) {
+	return a.limiter.Stats(), nil
+}
+
+func (a *AdminAPI) PeerLimits(ctx context.Context) (peerlimit.Config, error) {
+	return a.limiter.Config(), nil
+}
+
+func (a *AdminAPI) PeerLimitsSet(ctx context.Context, cfg peerlimit.Config) error {
+	return a.limiter.SetConfig(cfg)
+}
+
+func (a *AdminAPI) PeerBan(ctx context.Context, p peer.ID, duration time.Duration, reason string) (peerlimit.Ban, error) {
+	if duration <= 0 {
+		return peerlimit.Ban{}, fmt.Errorf("ban duration must be positive")
+	}
+	return a.limiter.Ban(p, duration, reason), nil
+}
+
+func (a *AdminAPI) PeerUnban(ctx context.Context, p peer.ID) error {
+	if !a.limiter.Unban(p) {
+		return fmt.Errorf("peer %s is not banned", p)
+	}
+	return nil
+}
+
+// adminClient is the client for AdminAPI
+type adminClient struct {
+	PeerStats     func(ctx context.Context) ([]peerlimit.PeerStats, error)
+	PeerLimits    func(ctx context.Context) (peerlimit.Config, error)
+	PeerLimitsSet func(ctx context.Context, cfg peerlimit.Config) error
+	PeerBan       func(ctx context.Context, p peer.ID, duration time.Duration, reason string) (peerlimit.Ban, error)
+	PeerUnban     func(ctx context.Context, p peer.ID) error
+}
+
+func adminAddr(port uint) string {
+	return fmt.Sprintf("localhost:%d", port)
+}
+
+// startAdminServer serves the admin JSON-RPC API on localhost
+func startAdminServer(port uint, limiter *peerlimit.Limiter) *http.Server {
+	rpcServer := jsonrpc.NewServer()
+	rpcServer.Register(adminNamespace, &AdminAPI{limiter: limiter})
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", rpcServer)
+
+	srv := &http.Server{Addr: adminAddr(port), Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorf("could not start admin server: %s", err)
+		}
+	}()
+	return srv
+}
+
+func getAdminClient(cctx *cli.Context) (*adminClient, jsonrpc.ClientCloser, error) {
+	var c adminClient
+	addr := "http://" + adminAddr(cctx.Uint(FlagAdminPort.Name)) + "/rpc/v0"
+	closer, err := jsonrpc.NewClient(cctx.Context, addr, adminNamespace, &c, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to booster-bitswap admin endpoint at %s: %w", addr, err)
+	}
+	return &c, closer, nil
+}
+
+var peersCmd = &cli.Command{
+	Name:  "peers",
+	Usage: "Inspect the consumption of each peer, change the per-peer bandwidth limits and ban abusive peers",
+	Flags: []cli.Flag{
+		FlagAdminPort,
+	},
+	Subcommands: []*cli.Command{
+		peersListCmd,
+		peersLimitsCmd,
+		peersBanCmd,
+		peersUnbanCmd,
+	},
+}
+
+var peersListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the peers that have requested blocks recently, and banned peers",
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		api, closer, err := getAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		stats, err := api.PeerStats(ctx)
+		if err != nil {
+			return fmt.Errorf("getting peer stats: %w", err)
+		}
+		if len(stats) == 0 {
+			fmt.Println("no recently active peers")
+			return nil
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Peer"),
+			tablewriter.Col("Sent"),
+			tablewriter.Col("Refused"),
+			tablewriter.Col("Tokens"),
+			tablewriter.Col("Last Active"),
+			tablewriter.Col("Banned Until"),
+			tablewriter.Col("Reason"),
+		)
+		for _, st := range stats {
+			row := map[string]interface{}{
+				"Peer":    st.Peer,
+				"Sent":    humanize.IBytes(st.Sent),
+				"Refused": st.Refused,
+				"Tokens":  st.Tokens,
+			}
+			if !st.LastActive.IsZero() {
+				row["Last Active"] = humanize.Time(st.LastActive)
+			}
+			if st.Ban != nil {
+				row["Banned Until"] = st.Ban.Until.Format(time.RFC3339)
+				row["Reason"] = st.Ban.Reason
+			}
+			tw.Write(row)
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+var peersLimitsCmd = &cli.Command{
+	Name:  "limits",
+	Usage: "Show the per-peer bandwidth limits, or change them until booster-bitswap restarts",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "bandwidth",
+			Usage: "the maximum rate at which blocks are sent to each peer, in bytes per second (eg 10MiB). 0 means unlimited",
+		},
+		&cli.StringFlag{
+			Name:  "burst",
+			Usage: "the most that may be sent to a peer at once after it has been idle, in bytes (eg 4MiB)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+		api, closer, err := getAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		cfg, err := api.PeerLimits(ctx)
+		if err != nil {
+			return fmt.Errorf("getting peer limits: %w", err)
+		}
+		if cctx.IsSet("bandwidth") || cctx.IsSet("burst") {
+			if cctx.IsSet("bandwidth") {
+				if cfg.Bandwidth, err = parseBytes(cctx.String("bandwidth")); err != nil {
+					return fmt.Errorf("parsing bandwidth: %w", err)
+				}
+			}
+			if cctx.IsSet("burst") {
+				if cfg.Burst, err = parseBytes(cctx.String("burst")); err != nil {
+					return fmt.Errorf("parsing burst: %w", err)
+				}
+			}
+			if err := api.PeerLimitsSet(ctx, cfg); err != nil {
+				return fmt.Errorf("setting peer limits: %w", err)
+			}
+		}
+
+		bandwidth := "unlimited"
+		if cfg.Bandwidth > 0 {
+			bandwidth = humanize.IBytes(cfg.Bandwidth) + "/s"
+		}
+		fmt.Printf("bandwidth per peer: %s\n", bandwidth)
+		fmt.Printf("burst: %s\n", humanize.IBytes(cfg.Burst))
+		return nil
+	},
+}
+
+var peersBanCmd = &cli.Command{
+	Name:      "ban",
+	Usage:     "Refuse all block requests from a peer for a time",
+	ArgsUsage: "<peer id>",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "how long to ban the peer for",
+			Value: time.Hour,
+		},
+		&cli.StringFlag{
+			Name:  "reason",
+			Usage: "why the peer is banned",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		p, err := peerArg(cctx)
+		if err != nil {
+			return err
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		api, closer, err := getAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ban, err := api.PeerBan(ctx, p, cctx.Duration("duration"), cctx.String("reason"))
+		if err != nil {
+			return fmt.Errorf("banning peer: %w", err)
+		}
+		fmt.Printf("banned %s until %s\n", ban.Peer, ban.Until.Format(time.RFC3339))
+		return nil
+	},
+}
+
+var peersUnbanCmd = &cli.Command{
+	Name:      "unban",
+	Usage:     "Lift a ban on a peer",
+	ArgsUsage: "<peer id>",
+	Action: func(cctx *cli.Context) error {
+		p, err := peerArg(cctx)
+		if err != nil {
+			return err
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		api, closer, err := getAdminClient(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := api.PeerUnban(ctx, p); err != nil {
+			return fmt.Errorf("unbanning peer: %w", err)
+		}
+		fmt.Printf("unbanned %s\n", p)
+		return nil
+	},
+}
+
+func peerArg(cctx *cli.Context) (peer.ID, error) {
+	if cctx.Args().Len() != 1 {
+		return "", fmt.Errorf("usage: %s %s", cctx.Command.Name, cctx.Command.ArgsUsage)
+	}
+	p, err := peer.Decode(cctx.Args().First())
+	if err != nil {
+		return "", fmt.Errorf("parsing peer id %s: %w", cctx.Args().First(), err)
+	}
+	return p, nil
+}
+
+func parseBytes(s string) (uint64, error) {
+	v, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return uint64(v), nil
+}
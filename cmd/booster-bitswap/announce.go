@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// kuboApiUrl converts the address of a Kubo RPC API to a base URL.
+// The address may be a multiaddr (eg /ip4/127.0.0.1/tcp/5001) or a URL.
+func kuboApiUrl(addr string) (string, error) {
+	if strings.HasPrefix(addr, "/") {
+		ma, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return "", fmt.Errorf("parsing multiaddr %s: %w", addr, err)
+		}
+		_, hostport, err := manet.DialArgs(ma)
+		if err != nil {
+			return "", fmt.Errorf("getting dial args for multiaddr %s: %w", addr, err)
+		}
+		return "http://" + hostport, nil
+	}
+	return strings.TrimSuffix(addr, "/"), nil
+}
+
+// importToKubo imports the blocks in the CAR file into a Kubo node using
+// the dag/import RPC, optionally pinning the CAR roots
+func importToKubo(ctx context.Context, apiAddr string, carPath string, pin bool) error {
+	baseUrl, err := kuboApiUrl(apiAddr)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(carPath)
+	if err != nil {
+		return fmt.Errorf("opening CAR file %s: %w", carPath, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	// Stream the CAR file as a multipart body
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filepath.Base(carPath))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err) //nolint:errcheck
+	}()
+
+	params := url.Values{}
+	params.Set("pin-roots", fmt.Sprintf("%t", pin))
+	reqUrl := baseUrl + "/api/v0/dag/import?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqUrl, pr)
+	if err != nil {
+		return fmt.Errorf("creating dag import request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending dag import request to %s: %w", baseUrl, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("dag import request to %s failed with status %d: %s", baseUrl, resp.StatusCode, body)
+	}
+
+	// The response is a stream of JSON objects. If there was a problem
+	// pinning a root it is reported in the PinErrorMsg field.
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var res struct {
+			Root *struct {
+				Cid         map[string]string
+				PinErrorMsg string
+			}
+		}
+		if err := dec.Decode(&res); err != nil {
+			return fmt.Errorf("parsing dag import response: %w", err)
+		}
+		if res.Root != nil && res.Root.PinErrorMsg != "" {
+			return fmt.Errorf("pinning root %s: %s", res.Root.Cid["/"], res.Root.PinErrorMsg)
+		}
+	}
+
+	return nil
+}
+
+// pinWithService asks a remote pinning service to pin the root cid, using
+// the IPFS Pinning Service API
+func pinWithService(ctx context.Context, endpoint string, token string, root cid.Cid, name string) error {
+	reqBody, err := json.Marshal(map[string]string{"cid": root.String(), "name": name})
+	if err != nil {
+		return fmt.Errorf("marshalling pin request: %w", err)
+	}
+
+	reqUrl := strings.TrimSuffix(endpoint, "/") + "/pins"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqUrl, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("creating pin request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending pin request to %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("pin request to %s failed with status %d: %s", endpoint, resp.StatusCode, body)
+	}
+
+	var status struct {
+		RequestID string `json:"requestid"`
+		Status    string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return fmt.Errorf("parsing pin response: %w", err)
+	}
+
+	log.Infow("pin request accepted", "service", endpoint, "cid", root, "request-id", status.RequestID, "status", status.Status)
+	return nil
+}
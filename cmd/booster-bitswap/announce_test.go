@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKuboApiUrl(t *testing.T) {
+	u, err := kuboApiUrl("/ip4/127.0.0.1/tcp/5001")
+	require.NoError(t, err)
+	require.Equal(t, "http://127.0.0.1:5001", u)
+
+	u, err = kuboApiUrl("http://localhost:5001/")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:5001", u)
+
+	_, err = kuboApiUrl("/ip4/127.0.0.1/tcp")
+	require.Error(t, err)
+}
+
+func TestImportToKubo(t *testing.T) {
+	ctx := context.Background()
+
+	carData := []byte("car file contents")
+	carPath := filepath.Join(t.TempDir(), "out.car")
+	require.NoError(t, os.WriteFile(carPath, carData, 0644))
+
+	var received []byte
+	var pinRoots string
+	response := `{"Root":{"Cid":{"/":"bafyroot"},"PinErrorMsg":""}}` + "\n"
+	status := http.StatusOK
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v0/dag/import" {
+			http.NotFound(w, r)
+			return
+		}
+		pinRoots = r.URL.Query().Get("pin-roots")
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received, _ = io.ReadAll(f)
+		w.WriteHeader(status)
+		fmt.Fprint(w, response)
+	}))
+	defer svr.Close()
+
+	t.Run("imports the CAR file", func(t *testing.T) {
+		require.NoError(t, importToKubo(ctx, svr.URL, carPath, true))
+		require.Equal(t, carData, received)
+		require.Equal(t, "true", pinRoots)
+
+		require.NoError(t, importToKubo(ctx, svr.URL+"/", carPath, false))
+		require.Equal(t, "false", pinRoots)
+	})
+
+	t.Run("multiaddr api address", func(t *testing.T) {
+		hostport := strings.TrimPrefix(svr.URL, "http://")
+		host, port, _ := strings.Cut(hostport, ":")
+		require.NoError(t, importToKubo(ctx, "/ip4/"+host+"/tcp/"+port, carPath, true))
+	})
+
+	t.Run("pin error", func(t *testing.T) {
+		response = `{"Root":{"Cid":{"/":"bafyroot"},"PinErrorMsg":"out of space"}}` + "\n"
+		defer func() { response = `{"Root":{"Cid":{"/":"bafyroot"},"PinErrorMsg":""}}` + "\n" }()
+		err := importToKubo(ctx, svr.URL, carPath, true)
+		require.ErrorContains(t, err, "pinning root bafyroot: out of space")
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		response = "dag import failed"
+		defer func() {
+			status = http.StatusOK
+			response = `{"Root":{"Cid":{"/":"bafyroot"},"PinErrorMsg":""}}` + "\n"
+		}()
+		err := importToKubo(ctx, svr.URL, carPath, true)
+		require.ErrorContains(t, err, "failed with status 500: dag import failed")
+	})
+
+	t.Run("malformed response", func(t *testing.T) {
+		response = "{not json"
+		defer func() { response = `{"Root":{"Cid":{"/":"bafyroot"},"PinErrorMsg":""}}` + "\n" }()
+		err := importToKubo(ctx, svr.URL, carPath, true)
+		require.ErrorContains(t, err, "parsing dag import response")
+	})
+
+	t.Run("missing CAR file", func(t *testing.T) {
+		err := importToKubo(ctx, svr.URL, filepath.Join(t.TempDir(), "missing.car"), true)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestPinWithService(t *testing.T) {
+	ctx := context.Background()
+
+	mh, err := multihash.Sum([]byte("root"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	root := cid.NewCidV1(cid.Raw, mh)
+
+	var auth string
+	var pin map[string]string
+	status := http.StatusAccepted
+	response := `{"requestid":"req1","status":"queued"}`
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/pins" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		pin = nil
+		if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, response)
+	}))
+	defer svr.Close()
+
+	t.Run("pin accepted", func(t *testing.T) {
+		require.NoError(t, pinWithService(ctx, svr.URL+"/", "secret", root, "out.car"))
+		require.Equal(t, "Bearer secret", auth)
+		require.Equal(t, map[string]string{"cid": root.String(), "name": "out.car"}, pin)
+	})
+
+	t.Run("no token", func(t *testing.T) {
+		status = http.StatusOK
+		defer func() { status = http.StatusAccepted }()
+		require.NoError(t, pinWithService(ctx, svr.URL, "", root, "out.car"))
+		require.Empty(t, auth)
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		status = http.StatusUnauthorized
+		response = "invalid token"
+		defer func() {
+			status = http.StatusAccepted
+			response = `{"requestid":"req1","status":"queued"}`
+		}()
+		err := pinWithService(ctx, svr.URL, "wrong", root, "out.car")
+		require.ErrorContains(t, err, "failed with status 401: invalid token")
+	})
+
+	t.Run("malformed response", func(t *testing.T) {
+		response = "{not json"
+		defer func() { response = `{"requestid":"req1","status":"queued"}` }()
+		err := pinWithService(ctx, svr.URL, "secret", root, "out.car")
+		require.ErrorContains(t, err, "parsing pin response")
+	})
+
+	t.Run("service unreachable", func(t *testing.T) {
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+		err := pinWithService(ctx, unreachable.URL, "secret", root, "out.car")
+		require.ErrorContains(t, err, "sending pin request")
+	})
+}
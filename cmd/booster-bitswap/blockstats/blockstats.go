@@ -0,0 +1,91 @@
+package blockstats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var log = logging.Logger("blockstats")
+
+// Recorder records the blocks served over bitswap in boostd's retrieval
+// statistics
+type Recorder func(ctx context.Context, blocks []types.BlockServed) error
+
+// Stats collects the number of bytes of each block sent by the bitswap
+// server, and periodically reports them to boostd, which adds them to the
+// retrieval statistics of the pieces that contain the blocks
+type Stats struct {
+	record Recorder
+
+	lk     sync.Mutex
+	served map[cid.Cid]uint64
+}
+
+func New(record Recorder) *Stats {
+	return &Stats{record: record, served: make(map[cid.Cid]uint64)}
+}
+
+// MessageSent records the blocks in a message sent by the bitswap server.
+// Together with MessageReceived it implements the bitswap tracer interface.
+func (s *Stats) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	blks := msg.Blocks()
+	if len(blks) == 0 {
+		return
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for _, b := range blks {
+		s.served[b.Cid()] += uint64(len(b.RawData()))
+	}
+}
+
+func (s *Stats) MessageReceived(peer.ID, bsmsg.BitSwapMessage) {}
+
+// Run reports the blocks served at each interval until the context is
+// cancelled, then makes a final report
+func (s *Stats) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			s.Flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			s.Flush(ctx)
+		}
+	}
+}
+
+// Flush reports the blocks served since the last report. If boostd can't be
+// reached the blocks are dropped, so that they don't accumulate without
+// bound while boostd is down.
+func (s *Stats) Flush(ctx context.Context) {
+	s.lk.Lock()
+	served := s.served
+	s.served = make(map[cid.Cid]uint64)
+	s.lk.Unlock()
+
+	if len(served) == 0 {
+		return
+	}
+
+	blocks := make([]types.BlockServed, 0, len(served))
+	for c, n := range served {
+		blocks = append(blocks, types.BlockServed{Cid: c, Bytes: n})
+	}
+	if err := s.record(ctx, blocks); err != nil {
+		log.Warnw("recording blocks served in retrieval stats", "blocks", len(blocks), "err", err)
+	}
+}
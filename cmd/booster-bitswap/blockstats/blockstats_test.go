@@ -0,0 +1,64 @@
+package blockstats
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var recorded []types.BlockServed
+	var fail bool
+	s := New(func(ctx context.Context, blks []types.BlockServed) error {
+		if fail {
+			return errors.New("boostd unavailable")
+		}
+		recorded = append(recorded, blks...)
+		return nil
+	})
+
+	blkA := blocks.NewBlock([]byte("aaaa"))
+	blkB := blocks.NewBlock([]byte("bb"))
+	send := func(blks ...blocks.Block) {
+		msg := bsmsg.New(false)
+		for _, b := range blks {
+			msg.AddBlock(b)
+		}
+		s.MessageSent(peer.ID("peer"), msg)
+	}
+
+	// Nothing is recorded if no blocks were sent
+	s.Flush(ctx)
+	req.Empty(recorded)
+
+	// The bytes sent of each block are summed
+	send(blkA, blkB)
+	send(blkA)
+	s.Flush(ctx)
+	req.ElementsMatch([]types.BlockServed{
+		{Cid: blkA.Cid(), Bytes: 8},
+		{Cid: blkB.Cid(), Bytes: 2},
+	}, recorded)
+
+	// Blocks are only reported once
+	recorded = nil
+	s.Flush(ctx)
+	req.Empty(recorded)
+
+	// Blocks that can't be reported are dropped
+	send(blkB)
+	fail = true
+	s.Flush(ctx)
+	fail = false
+	s.Flush(ctx)
+	req.Empty(recorded)
+}
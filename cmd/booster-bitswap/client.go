@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"path/filepath"
 	"sort"
 	"sync/atomic"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/filecoin-project/boost/cmd/booster-bitswap/bitswap"
 	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/ipfs/go-bitswap/client"
@@ -46,6 +48,37 @@ var fetchCmd = &cli.Command{
 			Usage: "concurrent request limit - 0 means unlimited",
 			Value: 10,
 		},
+		&cli.StringFlag{
+			Name:  "ipfs-api",
+			Usage: "after the fetch completes, import the blocks into the IPFS (Kubo) node with this RPC API address (eg /ip4/127.0.0.1/tcp/5001)",
+		},
+		&cli.BoolFlag{
+			Name:  "ipfs-pin",
+			Usage: "pin the root cid when importing blocks into the IPFS node",
+			Value: true,
+		},
+		&cli.StringFlag{
+			Name:  "pinning-service",
+			Usage: "after the fetch completes, request that the root cid is pinned by the Pinning Service API at this endpoint",
+		},
+		&cli.StringFlag{
+			Name:    "pinning-service-token",
+			Usage:   "the access token for the pinning service",
+			EnvVars: []string{"PINNING_SERVICE_TOKEN"},
+		},
+		&cli.BoolFlag{
+			Name:  "anonymous",
+			Usage: "connect to the server only through a circuit relay, so that the client's IP address is not revealed to the server",
+		},
+		&cli.StringSliceFlag{
+			Name: "relay",
+			Usage: "a circuit relay to connect through in anonymous mode, in the format [<region>=]<multiaddr>. " +
+				"The relay must not limit relayed connections, and the server must have a reservation on the relay",
+		},
+		&cli.StringFlag{
+			Name:  "server-region",
+			Usage: "the region of the server: in anonymous mode relays in other regions are preferred",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		if cctx.Bool("pprof") {
@@ -75,6 +108,19 @@ var fetchCmd = &cli.Command{
 
 		outputCarPath := cctx.Args().Get(2)
 
+		anonymous := cctx.Bool("anonymous")
+		var relays []relayCandidate
+		if anonymous {
+			relays, err = parseRelays(cctx.StringSlice("relay"))
+			if err != nil {
+				return err
+			}
+			if len(relays) == 0 {
+				return fmt.Errorf("at least one relay must be specified in anonymous mode")
+			}
+			relays = orderRelays(relays, cctx.String("server-region"))
+		}
+
 		ctx := lcli.ReqContext(cctx)
 
 		// setup libp2p host
@@ -84,14 +130,22 @@ var fetchCmd = &cli.Command{
 			return err
 		}
 
-		host, err := libp2p.New(
+		opts := []libp2p.Option{
 			libp2p.Transport(tcp.NewTCPTransport),
 			libp2p.Transport(quic.NewTransport),
 			libp2p.Muxer("/mplex/6.7.0", mplex.DefaultTransport),
 			libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport),
 			libp2p.Identity(privKey),
 			libp2p.ResourceManager(network.NullResourceManager),
-		)
+		}
+		if anonymous {
+			// Don't listen on any address, so that no addresses are
+			// advertised to the server, and only connect directly to the
+			// relays, so that the client's IP address is never revealed
+			// to the server or any other peer
+			opts = append(opts, libp2p.NoListenAddrs, libp2p.ConnectionGater(newAnonymousGater(relays)))
+		}
+		host, err := libp2p.New(opts...)
 		if err != nil {
 			return err
 		}
@@ -116,12 +170,23 @@ var fetchCmd = &cli.Command{
 
 		// Connect to host
 		connectStart := time.Now()
-		log.Infow("connecting to server", "server", serverAddrInfo.String())
-		err = host.Connect(ctx, *serverAddrInfo)
-		if err != nil {
-			return fmt.Errorf("connecting to %s: %w", serverAddrInfo, err)
+		var relay *relayCandidate
+		if anonymous {
+			log.Infow("connecting to server through relay", "server", serverAddrInfo.ID, "relays", len(relays))
+			relay, err = connectViaRelay(ctx, host, relays, serverAddrInfo.ID)
+			if err != nil {
+				return err
+			}
+			log.Infow("connected to server through relay", "relay", relay.String(), "duration", time.Since(connectStart).String())
+		} else {
+			log.Infow("connecting to server", "server", serverAddrInfo.String())
+			err = host.Connect(ctx, *serverAddrInfo)
+			if err != nil {
+				return fmt.Errorf("connecting to %s: %w", serverAddrInfo, err)
+			}
+			log.Debugw("connected to server", "duration", time.Since(connectStart).String())
 		}
-		log.Debugw("connected to server", "duration", time.Since(connectStart).String())
+		connectDuration := time.Since(connectStart)
 
 		// Check host's libp2p protocols
 		protos, err := host.Peerstore().GetProtocols(serverAddrInfo.ID)
@@ -154,11 +219,47 @@ var fetchCmd = &cli.Command{
 			return fmt.Errorf("getting blocks: %w", err)
 		}
 
-		log.Infow("fetch complete", "count", count, "size", size, "duration", time.Since(start).String())
+		fetchDuration := time.Since(start)
+		log.Infow("fetch complete", "count", count, "size", size, "duration", fetchDuration.String())
+		if relay != nil {
+			// Report the cost of routing traffic through the relay
+			var bytesPerSec uint64
+			if secs := fetchDuration.Seconds(); secs > 0 {
+				bytesPerSec = uint64(float64(size) / secs)
+			}
+			log.Infow("anonymous session",
+				"relay", relay.String(),
+				"relay-latency", host.Peerstore().LatencyEWMA(relay.Info.ID).String(),
+				"server-latency", host.Peerstore().LatencyEWMA(serverAddrInfo.ID).String(),
+				"connect-duration", connectDuration.String(),
+				"throughput", humanize.IBytes(bytesPerSec)+"/s")
+		}
 		log.Debug("finalizing")
 		finalizeStart := time.Now()
-		defer func() { log.Infow("finalize complete", "duration", time.Since(finalizeStart).String()) }()
-		return bs.Finalize()
+		err = bs.Finalize()
+		if err != nil {
+			return fmt.Errorf("finalizing %s: %w", outputCarPath, err)
+		}
+		log.Infow("finalize complete", "duration", time.Since(finalizeStart).String())
+
+		if apiAddr := cctx.String("ipfs-api"); apiAddr != "" {
+			log.Infow("importing blocks into IPFS node", "api", apiAddr, "pin", cctx.Bool("ipfs-pin"))
+			importStart := time.Now()
+			err = importToKubo(ctx, apiAddr, outputCarPath, cctx.Bool("ipfs-pin"))
+			if err != nil {
+				return fmt.Errorf("importing %s into IPFS node: %w", outputCarPath, err)
+			}
+			log.Infow("import complete", "duration", time.Since(importStart).String())
+		}
+
+		if endpoint := cctx.String("pinning-service"); endpoint != "" {
+			err = pinWithService(ctx, endpoint, cctx.String("pinning-service-token"), rootCid, filepath.Base(outputCarPath))
+			if err != nil {
+				return fmt.Errorf("pinning %s with pinning service: %w", rootCid, err)
+			}
+		}
+
+		return nil
 	},
 }
 
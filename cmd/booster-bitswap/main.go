@@ -32,6 +32,7 @@ func main() {
 			initCmd,
 			runCmd,
 			fetchCmd,
+			peersCmd,
 		},
 	}
 	app.Setup()
@@ -0,0 +1,290 @@
+package peerlimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// The state of peers that haven't requested or been sent a block for this
+// long is removed
+const peerIdleTimeout = 10 * time.Minute
+
+// Config is the configuration of the per-peer limits
+type Config struct {
+	// The rate at which each peer's bucket is refilled, in bytes per second.
+	// Zero means unlimited.
+	Bandwidth uint64
+	// The size of each peer's bucket in bytes: the most that may be sent to
+	// a peer in a burst after it has been idle
+	Burst uint64
+}
+
+func (c Config) validate() error {
+	if c.Bandwidth > 0 && c.Burst == 0 {
+		return fmt.Errorf("burst must be greater than zero when bandwidth is limited")
+	}
+	return nil
+}
+
+// PeerStats is the current consumption of a peer
+type PeerStats struct {
+	Peer peer.ID
+	// The number of bytes of blocks sent to the peer
+	Sent uint64
+	// The number of block requests refused because the peer's bucket was
+	// empty
+	Refused uint64
+	// The number of bytes in the peer's bucket. It is negative when the peer
+	// has been sent more than the bucket held (the peer must wait until it
+	// refills before it is sent more blocks).
+	Tokens int64
+	// The last time the peer requested or was sent a block
+	LastActive time.Time
+	// Set if the peer is banned
+	Ban *Ban `json:",omitempty"`
+}
+
+// Ban is a temporary ban of a peer
+type Ban struct {
+	Peer   peer.ID
+	Until  time.Time
+	Reason string
+}
+
+type peerState struct {
+	tokens     float64
+	refilled   time.Time
+	sent       uint64
+	refused    uint64
+	lastActive time.Time
+}
+
+// Limiter limits the rate at which blocks are sent to each peer with a token
+// bucket per peer, and refuses all requests from banned peers until the ban
+// expires.
+//
+// A block request is allowed if there are any tokens in the peer's bucket,
+// and the size of each block sent to the peer is taken from the bucket when
+// it is sent. This means the bucket may go negative, but avoids having to
+// look up the size of each block before deciding whether to send it.
+type Limiter struct {
+	clock clock.Clock
+
+	lk        sync.Mutex
+	cfg       Config
+	peers     map[peer.ID]*peerState
+	bans      map[peer.ID]*Ban
+	lastPrune time.Time
+}
+
+func New(cfg Config) (*Limiter, error) {
+	return newLimiter(cfg, clock.New())
+}
+
+func newLimiter(cfg Config, clock clock.Clock) (*Limiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Limiter{
+		clock:     clock,
+		cfg:       cfg,
+		peers:     make(map[peer.ID]*peerState),
+		bans:      make(map[peer.ID]*Ban),
+		lastPrune: clock.Now(),
+	}, nil
+}
+
+// Config returns the current configuration
+func (l *Limiter) Config() Config {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.cfg
+}
+
+// SetConfig changes the limits. Buckets that are fuller than the new burst
+// size are emptied down to it. If bandwidth was unlimited, each peer starts
+// with a full bucket.
+func (l *Limiter) SetConfig(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.clock.Now()
+	for _, ps := range l.peers {
+		l.refill(ps, now)
+		if l.cfg.Bandwidth == 0 || ps.tokens > float64(cfg.Burst) {
+			ps.tokens = float64(cfg.Burst)
+		}
+	}
+	l.cfg = cfg
+	return nil
+}
+
+// Allow returns whether a block request from the peer may be served
+func (l *Limiter) Allow(p peer.ID) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.clock.Now()
+	if l.banned(p, now) {
+		return false
+	}
+	ps := l.peer(p, now)
+	if l.cfg.Bandwidth == 0 {
+		return true
+	}
+	l.refill(ps, now)
+	if ps.tokens <= 0 {
+		ps.refused++
+		return false
+	}
+	return true
+}
+
+// Record takes the size of blocks sent to the peer from its bucket
+func (l *Limiter) Record(p peer.ID, size uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.clock.Now()
+	ps := l.peer(p, now)
+	ps.sent += size
+	if l.cfg.Bandwidth > 0 {
+		l.refill(ps, now)
+		ps.tokens -= float64(size)
+	}
+}
+
+// MessageSent records the blocks in a message sent by the bitswap server.
+// Together with MessageReceived it implements the bitswap tracer interface.
+func (l *Limiter) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	var size uint64
+	for _, b := range msg.Blocks() {
+		size += uint64(len(b.RawData()))
+	}
+	if size > 0 {
+		l.Record(p, size)
+	}
+}
+
+func (l *Limiter) MessageReceived(peer.ID, bsmsg.BitSwapMessage) {}
+
+// Ban refuses all requests from the peer for the given duration
+func (l *Limiter) Ban(p peer.ID, duration time.Duration, reason string) Ban {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	b := &Ban{Peer: p, Until: l.clock.Now().Add(duration), Reason: reason}
+	l.bans[p] = b
+	return *b
+}
+
+// Unban lifts a ban on the peer. It returns false if the peer was not banned.
+func (l *Limiter) Unban(p peer.ID) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	if !l.banned(p, l.clock.Now()) {
+		return false
+	}
+	delete(l.bans, p)
+	return true
+}
+
+// Stats returns the consumption of each peer that has been active recently
+// and of each banned peer, ordered by the number of bytes sent
+func (l *Limiter) Stats() []PeerStats {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.clock.Now()
+	stats := make([]PeerStats, 0, len(l.peers))
+	for p, ps := range l.peers {
+		l.refill(ps, now)
+		st := PeerStats{
+			Peer:       p,
+			Sent:       ps.sent,
+			Refused:    ps.refused,
+			Tokens:     int64(ps.tokens),
+			LastActive: ps.lastActive,
+		}
+		if l.banned(p, now) {
+			b := *l.bans[p]
+			st.Ban = &b
+		}
+		stats = append(stats, st)
+	}
+	for p, b := range l.bans {
+		if _, ok := l.peers[p]; ok || !l.banned(p, now) {
+			continue
+		}
+		b := *b
+		stats = append(stats, PeerStats{Peer: p, Ban: &b})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Sent != stats[j].Sent {
+			return stats[i].Sent > stats[j].Sent
+		}
+		return stats[i].Peer < stats[j].Peer
+	})
+	return stats
+}
+
+// banned returns whether the peer is banned, removing the ban if it has
+// expired. Must be called with the lock held.
+func (l *Limiter) banned(p peer.ID, now time.Time) bool {
+	b, ok := l.bans[p]
+	if !ok {
+		return false
+	}
+	if !now.Before(b.Until) {
+		delete(l.bans, p)
+		return false
+	}
+	return true
+}
+
+// peer gets the state of the peer, creating it with a full bucket if
+// necessary. Must be called with the lock held.
+func (l *Limiter) peer(p peer.ID, now time.Time) *peerState {
+	if now.Sub(l.lastPrune) > time.Minute {
+		for id, ps := range l.peers {
+			if now.Sub(ps.lastActive) > peerIdleTimeout {
+				delete(l.peers, id)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	ps, ok := l.peers[p]
+	if !ok {
+		ps = &peerState{tokens: float64(l.cfg.Burst), refilled: now}
+		l.peers[p] = ps
+	}
+	ps.lastActive = now
+	return ps
+}
+
+// refill adds the tokens accumulated since the bucket was last refilled.
+// Must be called with the lock held.
+func (l *Limiter) refill(ps *peerState, now time.Time) {
+	elapsed := now.Sub(ps.refilled)
+	ps.refilled = now
+	if l.cfg.Bandwidth == 0 || elapsed <= 0 {
+		return
+	}
+	ps.tokens += elapsed.Seconds() * float64(l.cfg.Bandwidth)
+	if ps.tokens > float64(l.cfg.Burst) {
+		ps.tokens = float64(l.cfg.Burst)
+	}
+}
@@ -0,0 +1,79 @@
+package peerlimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	peerA, err := peer.Decode("12D3KooWDqCNRAm6bJz8WYzUMHGMxzY3jrhiW2UMTpvazU5DLZNi")
+	require.NoError(t, err)
+	peerB, err := peer.Decode("12D3KooWLJHqAmQ2ndCsEDjwvUq3AtDcnbpj3ETstRgYCmnwRtv9")
+	require.NoError(t, err)
+
+	_, err = New(Config{Bandwidth: 1000})
+	require.Error(t, err)
+
+	clk := clock.NewMock()
+	l, err := newLimiter(Config{}, clk)
+	require.NoError(t, err)
+
+	// Unlimited peers are always allowed, and their consumption is recorded
+	require.True(t, l.Allow(peerA))
+	l.Record(peerA, 5000)
+	require.True(t, l.Allow(peerA))
+
+	// Limit bandwidth: the peer starts with a full bucket
+	require.NoError(t, l.SetConfig(Config{Bandwidth: 1000, Burst: 4000}))
+	require.True(t, l.Allow(peerA))
+	l.Record(peerA, 6000)
+	require.False(t, l.Allow(peerA))
+
+	// A new peer has its own bucket
+	require.True(t, l.Allow(peerB))
+
+	// The bucket refills at the bandwidth rate
+	clk.Add(time.Second)
+	require.False(t, l.Allow(peerA))
+	clk.Add(1500 * time.Millisecond)
+	require.True(t, l.Allow(peerA))
+
+	stats := l.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, peerA, stats[0].Peer)
+	require.EqualValues(t, 11000, stats[0].Sent)
+	require.EqualValues(t, 2, stats[0].Refused)
+	require.EqualValues(t, 500, stats[0].Tokens)
+	require.Nil(t, stats[0].Ban)
+
+	// The bucket never holds more than the burst size
+	clk.Add(time.Minute)
+	require.EqualValues(t, 4000, l.Stats()[0].Tokens)
+
+	// Banned peers are refused until the ban expires
+	l.Ban(peerB, time.Hour, "abuse")
+	require.False(t, l.Allow(peerB))
+	stats = l.Stats()
+	require.Equal(t, peerB, stats[1].Peer)
+	require.NotNil(t, stats[1].Ban)
+	require.Equal(t, "abuse", stats[1].Ban.Reason)
+	clk.Add(time.Hour)
+	require.True(t, l.Allow(peerB))
+	require.False(t, l.Unban(peerB))
+
+	// Lift a ban before it expires
+	l.Ban(peerB, time.Hour, "")
+	require.True(t, l.Unban(peerB))
+	require.True(t, l.Allow(peerB))
+
+	// Idle peers are removed
+	clk.Add(2 * peerIdleTimeout)
+	require.True(t, l.Allow(peerA))
+	stats = l.Stats()
+	require.Len(t, stats, 1)
+	require.EqualValues(t, 0, stats[0].Sent)
+}
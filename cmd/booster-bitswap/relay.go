@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// relayCandidate is a circuit relay, tagged with the region (jurisdiction)
+// that it runs in
+type relayCandidate struct {
+	Region string
+	Info   peer.AddrInfo
+}
+
+func (r relayCandidate) String() string {
+	if r.Region == "" {
+		return r.Info.ID.String()
+	}
+	return r.Region + "=" + r.Info.ID.String()
+}
+
+// parseRelays parses relays in the format [<region>=]<multiaddr>,
+// eg de=/ip4/1.2.3.4/tcp/4001/p2p/12D3KooW...
+func parseRelays(relays []string) ([]relayCandidate, error) {
+	cands := make([]relayCandidate, 0, len(relays))
+	for _, r := range relays {
+		var region string
+		addr := r
+		if i := strings.Index(r, "="); i >= 0 {
+			region, addr = r[:i], r[i+1:]
+		}
+		ai, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing relay multiaddr %s: %w", addr, err)
+		}
+		cands = append(cands, relayCandidate{Region: strings.ToLower(region), Info: *ai})
+	}
+	return cands, nil
+}
+
+// orderRelays returns the relays in the order in which they should be tried.
+// Relays in a different region to the provider are tried first, and
+// consecutive relays are taken from different regions (in random order) so
+// that a failure to connect falls back to a different jurisdiction.
+func orderRelays(cands []relayCandidate, providerRegion string) []relayCandidate {
+	providerRegion = strings.ToLower(providerRegion)
+
+	var regions, sameRegion []string
+	byRegion := make(map[string][]relayCandidate)
+	for _, c := range rand.Perm(len(cands)) {
+		cand := cands[c]
+		if _, ok := byRegion[cand.Region]; !ok {
+			if providerRegion != "" && cand.Region == providerRegion {
+				sameRegion = append(sameRegion, cand.Region)
+			} else {
+				regions = append(regions, cand.Region)
+			}
+		}
+		byRegion[cand.Region] = append(byRegion[cand.Region], cand)
+	}
+
+	ordered := make([]relayCandidate, 0, len(cands))
+	for _, group := range [][]string{regions, sameRegion} {
+		for len(ordered) < len(cands) {
+			added := false
+			for _, region := range group {
+				if rs := byRegion[region]; len(rs) > 0 {
+					ordered = append(ordered, rs[0])
+					byRegion[region] = rs[1:]
+					added = true
+				}
+			}
+			if !added {
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// circuitAddrInfo returns the addresses at which the server can be reached
+// through the relay
+func circuitAddrInfo(relay relayCandidate, server peer.ID) (peer.AddrInfo, error) {
+	ai := peer.AddrInfo{ID: server}
+	circuit, err := multiaddr.NewMultiaddr("/p2p/" + relay.Info.ID.String() + "/p2p-circuit")
+	if err != nil {
+		return ai, fmt.Errorf("creating circuit multiaddr for relay %s: %w", relay.Info.ID, err)
+	}
+	for _, addr := range relay.Info.Addrs {
+		ai.Addrs = append(ai.Addrs, addr.Encapsulate(circuit))
+	}
+	return ai, nil
+}
+
+// anonymousGater is the connection gater used in anonymous mode. It only
+// allows direct connections to the relays: connections to any other peer
+// must go through a relay.
+type anonymousGater struct {
+	relays map[peer.ID]struct{}
+}
+
+var _ connmgr.ConnectionGater = (*anonymousGater)(nil)
+
+func newAnonymousGater(relays []relayCandidate) *anonymousGater {
+	g := &anonymousGater{relays: make(map[peer.ID]struct{}, len(relays))}
+	for _, r := range relays {
+		g.relays[r.Info.ID] = struct{}{}
+	}
+	return g
+}
+
+func (g *anonymousGater) isRelay(p peer.ID) bool {
+	_, ok := g.relays[p]
+	return ok
+}
+
+// isCircuitAddr returns true if the address is a /p2p-circuit address
+// ie the connection goes through a relay
+func isCircuitAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+func (g *anonymousGater) InterceptPeerDial(peer.ID) bool {
+	return true
+}
+
+func (g *anonymousGater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return g.isRelay(p) || isCircuitAddr(addr)
+}
+
+func (g *anonymousGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return isCircuitAddr(addrs.RemoteMultiaddr())
+}
+
+func (g *anonymousGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if dir == network.DirOutbound && g.isRelay(p) {
+		return true
+	}
+	return isCircuitAddr(addrs.RemoteMultiaddr())
+}
+
+func (g *anonymousGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// connectViaRelay connects to the server through the first relay that
+// succeeds, and returns the relay that was used
+func connectViaRelay(ctx context.Context, h host.Host, relays []relayCandidate, server peer.ID) (*relayCandidate, error) {
+	var errs []string
+	for _, relay := range relays {
+		err := func() error {
+			if err := h.Connect(ctx, relay.Info); err != nil {
+				return fmt.Errorf("connecting to relay: %w", err)
+			}
+			ai, err := circuitAddrInfo(relay, server)
+			if err != nil {
+				return err
+			}
+			if err := h.Connect(ctx, ai); err != nil {
+				return fmt.Errorf("connecting to server through relay: %w", err)
+			}
+
+			// Bitswap ignores limited (transient) connections, so the
+			// relay must not impose limits on the connection
+			for _, conn := range h.Network().ConnsToPeer(server) {
+				if !conn.Stat().Transient {
+					return nil
+				}
+			}
+			return fmt.Errorf("relay only supports limited connections")
+		}()
+		if err == nil {
+			return &relay, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Warnw("failed to connect to server through relay", "relay", relay.String(), "err", err)
+		errs = append(errs, fmt.Sprintf("%s: %s", relay, err))
+	}
+	return nil, fmt.Errorf("could not connect to %s through any relay: %s", server, strings.Join(errs, "; "))
+}
+
+// keepRelayReservations reserves a slot on each relay, so that clients can
+// connect to this node through the relay, and refreshes the reservations
+// before they expire
+func keepRelayReservations(ctx context.Context, h host.Host, relays []relayCandidate) {
+	for _, relay := range relays {
+		go func(relay relayCandidate) {
+			for {
+				wait := time.Minute
+				rsvp, err := client.Reserve(ctx, h, relay.Info)
+				if err != nil {
+					log.Warnw("failed to reserve slot on relay", "relay", relay.String(), "err", err)
+				} else {
+					log.Debugw("reserved slot on relay", "relay", relay.String(), "expiration", rsvp.Expiration)
+					// Refresh the reservation before it expires
+					if untilExpiry := time.Until(rsvp.Expiration); untilExpiry > 2*wait {
+						wait = untilExpiry - wait
+					}
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+		}(relay)
+	}
+}
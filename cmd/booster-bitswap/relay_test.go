@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRelays(t *testing.T) {
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+
+	cands, err := parseRelays([]string{
+		"DE=/ip4/1.2.3.4/tcp/4001/p2p/" + p1.String(),
+		"/ip4/5.6.7.8/udp/4001/quic/p2p/" + p2.String(),
+	})
+	require.NoError(t, err)
+	require.Len(t, cands, 2)
+
+	// The region is lower-cased
+	require.Equal(t, "de", cands[0].Region)
+	require.Equal(t, p1, cands[0].Info.ID)
+	require.Len(t, cands[0].Info.Addrs, 1)
+	require.Equal(t, "/ip4/1.2.3.4/tcp/4001", cands[0].Info.Addrs[0].String())
+	require.Equal(t, "de="+p1.String(), cands[0].String())
+
+	// The region is optional
+	require.Empty(t, cands[1].Region)
+	require.Equal(t, p2, cands[1].Info.ID)
+	require.Equal(t, p2.String(), cands[1].String())
+
+	cands, err = parseRelays(nil)
+	require.NoError(t, err)
+	require.Empty(t, cands)
+
+	// The multiaddr must include the relay's peer id
+	_, err = parseRelays([]string{"de=/ip4/1.2.3.4/tcp/4001"})
+	require.ErrorContains(t, err, "parsing relay multiaddr /ip4/1.2.3.4/tcp/4001")
+
+	_, err = parseRelays([]string{"de=not a multiaddr"})
+	require.Error(t, err)
+}
+
+func TestOrderRelays(t *testing.T) {
+	relay := func(region string) relayCandidate {
+		return relayCandidate{Region: region, Info: peer.AddrInfo{ID: test.RandPeerIDFatal(t)}}
+	}
+	cands := []relayCandidate{relay("us"), relay("us"), relay("us"), relay("de"), relay("de"), relay("fr")}
+
+	regions := func(ordered []relayCandidate) []string {
+		var rs []string
+		for _, r := range ordered {
+			rs = append(rs, r.Region)
+		}
+		return rs
+	}
+
+	// The order is random, so check it several times
+	for i := 0; i < 20; i++ {
+		// Relays in the provider's region are tried last, and relays in
+		// other regions are interleaved
+		ordered := orderRelays(cands, "US")
+		require.ElementsMatch(t, cands, ordered)
+		rs := regions(ordered)
+		require.ElementsMatch(t, []string{"de", "fr"}, rs[:2])
+		require.Equal(t, "de", rs[2])
+		require.Equal(t, []string{"us", "us", "us"}, rs[3:])
+
+		// Without a provider region, consecutive relays are from
+		// different regions for as long as possible
+		ordered = orderRelays(cands, "")
+		require.ElementsMatch(t, cands, ordered)
+		rs = regions(ordered)
+		require.ElementsMatch(t, []string{"us", "de", "fr"}, rs[:3])
+		require.ElementsMatch(t, []string{"us", "de"}, rs[3:5])
+		require.Equal(t, "us", rs[5])
+	}
+
+	require.Empty(t, orderRelays(nil, "us"))
+}
+
+type testConnAddrs struct {
+	remote multiaddr.Multiaddr
+}
+
+func (c testConnAddrs) LocalMultiaddr() multiaddr.Multiaddr {
+	return multiaddr.StringCast("/ip4/127.0.0.1/tcp/1234")
+}
+
+func (c testConnAddrs) RemoteMultiaddr() multiaddr.Multiaddr {
+	return c.remote
+}
+
+func TestAnonymousGater(t *testing.T) {
+	relayID := test.RandPeerIDFatal(t)
+	serverID := test.RandPeerIDFatal(t)
+	g := newAnonymousGater([]relayCandidate{{Region: "de", Info: peer.AddrInfo{ID: relayID}}})
+
+	direct := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	circuit := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + relayID.String() + "/p2p-circuit")
+
+	// Dials to the relay may be direct
+	require.True(t, g.InterceptPeerDial(relayID))
+	require.True(t, g.InterceptAddrDial(relayID, direct))
+
+	// Dials to any other peer must go through a relay
+	require.True(t, g.InterceptPeerDial(serverID))
+	require.False(t, g.InterceptAddrDial(serverID, direct))
+	require.False(t, g.InterceptAddrDial(serverID, multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic")))
+	require.True(t, g.InterceptAddrDial(serverID, circuit))
+
+	// Only relayed connections are accepted
+	require.False(t, g.InterceptAccept(testConnAddrs{remote: direct}))
+	require.True(t, g.InterceptAccept(testConnAddrs{remote: circuit}))
+
+	require.True(t, g.InterceptSecured(network.DirOutbound, relayID, testConnAddrs{remote: direct}))
+	require.False(t, g.InterceptSecured(network.DirInbound, relayID, testConnAddrs{remote: direct}))
+	require.False(t, g.InterceptSecured(network.DirOutbound, serverID, testConnAddrs{remote: direct}))
+	require.True(t, g.InterceptSecured(network.DirOutbound, serverID, testConnAddrs{remote: circuit}))
+	require.True(t, g.InterceptSecured(network.DirInbound, serverID, testConnAddrs{remote: circuit}))
+
+	allow, _ := g.InterceptUpgraded(nil)
+	require.True(t, allow)
+}
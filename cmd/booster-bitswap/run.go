@@ -6,12 +6,16 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"strings"
+	"time"
 
 	"github.com/filecoin-project/boost/api"
 	bclient "github.com/filecoin-project/boost/api/client"
 	cliutil "github.com/filecoin-project/boost/cli/util"
 	"github.com/filecoin-project/boost/cmd/booster-bitswap/blockfilter"
+	"github.com/filecoin-project/boost/cmd/booster-bitswap/blockstats"
+	"github.com/filecoin-project/boost/cmd/booster-bitswap/peerlimit"
 	"github.com/filecoin-project/boost/cmd/booster-bitswap/remoteblockstore"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
 	"github.com/filecoin-project/boost/metrics"
 	"github.com/filecoin-project/boost/tracing"
 	"github.com/filecoin-project/go-jsonrpc"
@@ -53,6 +57,21 @@ var runCmd = &cli.Command{
 			Name:  "proxy",
 			Usage: "the multiaddr of the libp2p proxy that this node connects through",
 		},
+		&cli.StringSliceFlag{
+			Name:  "relay",
+			Usage: "the multiaddr of a circuit relay to reserve a slot on, so that anonymous clients can connect through the relay",
+		},
+		&cli.StringFlag{
+			Name:  "peer-bandwidth",
+			Usage: "the maximum rate at which blocks are sent to each peer, in bytes per second (eg 10MiB). 0 means unlimited",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "peer-burst",
+			Usage: "the most that may be sent to a peer at once after it has been idle, in bytes (eg 4MiB)",
+			Value: "4MiB",
+		},
+		FlagAdminPort,
 		&cli.BoolFlag{
 			Name:  "tracing",
 			Usage: "enables tracing of booster-bitswap calls",
@@ -113,7 +132,40 @@ var runCmd = &cli.Command{
 		if err != nil {
 			return fmt.Errorf("starting block filter: %w", err)
 		}
-		server := NewBitswapServer(remoteStore, host, blockFilter)
+
+		// Get the retrieval ACL from boost, and keep it up to date when the
+		// boost config is reloaded
+		aclCfg, err := bapi.BoostRetrievalACL(ctx)
+		if err != nil {
+			return fmt.Errorf("getting retrieval ACL from boost: %w", err)
+		}
+		acl, err := retrievalacl.New(*aclCfg)
+		if err != nil {
+			return fmt.Errorf("parsing retrieval ACL: %w", err)
+		}
+		go acl.Poll(ctx, time.Minute, bapi.BoostRetrievalACL)
+
+		// Set up the per-peer limits. They can be changed while
+		// booster-bitswap is running through the admin endpoint.
+		peerBandwidth, err := parseBytes(cctx.String("peer-bandwidth"))
+		if err != nil {
+			return fmt.Errorf("parsing peer-bandwidth: %w", err)
+		}
+		peerBurst, err := parseBytes(cctx.String("peer-burst"))
+		if err != nil {
+			return fmt.Errorf("parsing peer-burst: %w", err)
+		}
+		peerLimit, err := peerlimit.New(peerlimit.Config{Bandwidth: peerBandwidth, Burst: peerBurst})
+		if err != nil {
+			return err
+		}
+
+		// Report the blocks served to boost, so that they're included in
+		// the retrieval statistics of the pieces that contain them
+		stats := blockstats.New(bapi.BoostRetrievalStatsRecordBlocks)
+		go stats.Run(ctx, time.Minute)
+
+		server := NewBitswapServer(remoteStore, host, blockFilter, acl, peerLimit, stats)
 
 		var proxyAddrInfo *peer.AddrInfo
 		if cctx.IsSet("proxy") {
@@ -124,12 +176,20 @@ var runCmd = &cli.Command{
 			}
 		}
 
+		relays, err := parseRelays(cctx.StringSlice("relay"))
+		if err != nil {
+			return err
+		}
+
 		// Start the bitswap server
 		log.Infof("Starting booster-bitswap node on port %d", port)
 		err = server.Start(ctx, proxyAddrInfo)
 		if err != nil {
 			return err
 		}
+		if len(relays) > 0 {
+			keepRelayReservations(ctx, host, relays)
+		}
 
 		// Start the metrics web server
 		metricsPort := cctx.Int("metrics-port")
@@ -141,11 +201,18 @@ var runCmd = &cli.Command{
 			}
 		}()
 
+		// Start the admin endpoint
+		adminPort := cctx.Uint(FlagAdminPort.Name)
+		log.Infof("Starting booster-bitswap admin endpoint on %s", adminAddr(adminPort))
+		adminServer := startAdminServer(adminPort, peerLimit)
+
 		// Monitor for shutdown.
 		<-ctx.Done()
 
 		log.Info("Shutting down...")
 
+		_ = adminServer.Close()
+
 		err = server.Stop()
 		if err != nil {
 			return err
@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/filecoin-project/boost/cmd/booster-bitswap/blockstats"
+	"github.com/filecoin-project/boost/cmd/booster-bitswap/peerlimit"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
 	"github.com/filecoin-project/boost/protocolproxy"
+	bsmsg "github.com/ipfs/go-bitswap/message"
 	bsnetwork "github.com/ipfs/go-bitswap/network"
 	"github.com/ipfs/go-bitswap/server"
+	"github.com/ipfs/go-bitswap/tracer"
 	"github.com/ipfs/go-cid"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	nilrouting "github.com/ipfs/go-ipfs-routing/none"
@@ -22,6 +27,9 @@ type BlockFilter interface {
 type BitswapServer struct {
 	remoteStore blockstore.Blockstore
 	blockFilter BlockFilter
+	acl         *retrievalacl.ACL
+	peerLimit   *peerlimit.Limiter
+	stats       *blockstats.Stats
 	ctx         context.Context
 	cancel      context.CancelFunc
 	proxy       *peer.AddrInfo
@@ -29,8 +37,23 @@ type BitswapServer struct {
 	host        host.Host
 }
 
-func NewBitswapServer(remoteStore blockstore.Blockstore, host host.Host, blockFilter BlockFilter) *BitswapServer {
-	return &BitswapServer{remoteStore: remoteStore, host: host, blockFilter: blockFilter}
+func NewBitswapServer(remoteStore blockstore.Blockstore, host host.Host, blockFilter BlockFilter, acl *retrievalacl.ACL, peerLimit *peerlimit.Limiter, stats *blockstats.Stats) *BitswapServer {
+	return &BitswapServer{remoteStore: remoteStore, host: host, blockFilter: blockFilter, acl: acl, peerLimit: peerLimit, stats: stats}
+}
+
+// tracers passes the messages sent and received by bitswap to each tracer
+type tracers []tracer.Tracer
+
+func (ts tracers) MessageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, t := range ts {
+		t.MessageReceived(p, msg)
+	}
+}
+
+func (ts tracers) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, t := range ts {
+		t.MessageSent(p, msg)
+	}
 }
 
 const protectTag = "bitswap-server-to-proxy"
@@ -62,8 +85,16 @@ func (s *BitswapServer) Start(ctx context.Context, proxy *peer.AddrInfo) error {
 		filtered, err := s.blockFilter.IsFiltered(c)
 		// peer request block filter expects a true if the request should be fulfilled, so
 		// we only return true for cids that aren't filtered and have no errors
-		return !filtered && err == nil
-	})}
+		if filtered || err != nil {
+			return false
+		}
+		// Refuse requests from banned peers and peers that have used up
+		// their bandwidth
+		if !s.peerLimit.Allow(p) {
+			return false
+		}
+		return s.aclAllows(p, c)
+	}), server.WithTracer(tracers{s.peerLimit, s.stats})}
 	net := bsnetwork.NewFromIpfsHost(host, nilRouter)
 	s.server = server.New(s.ctx, net, s.remoteStore, bsopts...)
 	net.Start(s.server)
@@ -77,6 +108,31 @@ func (s *BitswapServer) Start(ctx context.Context, proxy *peer.AddrInfo) error {
 	return nil
 }
 
+// aclAllows checks the peer against the retrieval ACL, and whether the
+// block may be sent to the peer without exceeding its bandwidth limit.
+// Bitswap can't wait to send a block, so if the limit would be exceeded the
+// request is refused and the peer must ask again later.
+// Note that if the server is behind a proxy the peer's IP address is not
+// known, so only peer ID entries in the ACL apply.
+func (s *BitswapServer) aclAllows(p peer.ID, c cid.Cid) bool {
+	if s.acl == nil {
+		return true
+	}
+	client := retrievalacl.PeerClient(s.host, p)
+	if err := s.acl.Check(client); err != nil {
+		log.Debugw("refusing block request", "cid", c, "err", err)
+		return false
+	}
+	if s.acl.BandwidthLimit(client) == 0 {
+		return true
+	}
+	size, err := s.remoteStore.GetSize(s.ctx, c)
+	if err != nil {
+		return false
+	}
+	return s.acl.Allow(client, size)
+}
+
 func (s *BitswapServer) Stop() error {
 	if s.proxy != nil {
 		s.host.ConnManager().Unprotect(s.proxy.ID, protectTag)
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/boost/api"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	"github.com/filecoin-project/boost/lib/shaper"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/google/uuid"
+)
+
+// BandwidthLimitHeader is the request header with which a client caps the
+// bandwidth of its retrieval, in bytes per second (eg 1MiB)
+const BandwidthLimitHeader = "X-Boost-Bandwidth-Limit"
+
+// AuthVerifier verifies an API token and returns its permissions
+type AuthVerifier func(ctx context.Context, token string) ([]auth.Permission, error)
+
+// WithAdminAuth requires requests to the admin endpoints to have a token with
+// admin permission in the Authorization header, eg "Bearer <token>"
+func WithAdminAuth(verify AuthVerifier) HttpServerOption {
+	return func(s *HttpServer) {
+		s.authVerify = verify
+	}
+}
+
+// shapedResponseWriter sends the response body through a shaped writer
+type shapedResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (s *shapedResponseWriter) Write(bz []byte) (int, error) {
+	return s.w.Write(bz)
+}
+
+// remoteHost is the destination used to shape bandwidth for a request
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bandwidthLimit returns the bandwidth cap that the client asked for in the
+// request header, or zero if there is no cap
+func bandwidthLimit(r *http.Request) (uint64, error) {
+	val := r.Header.Get(BandwidthLimitHeader)
+	if val == "" {
+		return 0, nil
+	}
+	limit, err := units.RAMInBytes(val)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s header '%s': %w", BandwidthLimitHeader, val, err)
+	}
+	if limit <= 0 {
+		return 0, fmt.Errorf("%s header must be positive", BandwidthLimitHeader)
+	}
+	return uint64(limit), nil
+}
+
+// aclClient identifies the client of a request to the retrieval ACL
+func aclClient(r *http.Request) retrievalacl.Client {
+	return retrievalacl.Client{IP: net.ParseIP(remoteHost(r))}
+}
+
+func (s *HttpServer) bandwidthPath() string {
+	return s.path + "/admin/bandwidth"
+}
+
+// BandwidthUpdate changes the bandwidth limits of a running server.
+// Fields that are nil are left unchanged. All limits are in bytes per second
+// and zero means unlimited.
+type BandwidthUpdate struct {
+	Global         *uint64
+	PerDestination *uint64
+	// Override the limit for a single destination (an IP address)
+	Destination      string
+	DestinationLimit *uint64
+	// Change the limit of a session that is in progress
+	Session      *uuid.UUID
+	SessionLimit *uint64
+}
+
+// BandwidthState is the response to a request to the bandwidth endpoint
+type BandwidthState struct {
+	Limits   shaper.Limits
+	Sessions []shaper.SessionInfo
+}
+
+// checkAdmin checks that the request has a token with admin permission
+func (s *HttpServer) checkAdmin(r *http.Request) error {
+	if s.authVerify == nil {
+		return errors.New("admin endpoints are disabled")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		return errors.New("missing API token in Authorization header")
+	}
+	perms, err := s.authVerify(r.Context(), token)
+	if err != nil {
+		return fmt.Errorf("verifying API token: %w", err)
+	}
+	for _, p := range perms {
+		if p == api.PermAdmin {
+			return nil
+		}
+	}
+	return errors.New("API token does not have admin permission")
+}
+
+// handleBandwidth reports bandwidth limits and active sessions (GET) or
+// updates bandwidth limits (POST). It may only be called from the local
+// machine, with an API token that has admin permission.
+func (s *HttpServer) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(remoteHost(r))
+	if ip == nil || !ip.IsLoopback() {
+		writeError(w, r, http.StatusForbidden, "bandwidth endpoint may only be accessed from localhost")
+		return
+	}
+	if err := s.checkAdmin(r); err != nil {
+		writeError(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var upd BandwidthUpdate
+		if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("parsing bandwidth update: %s", err))
+			return
+		}
+		if upd.Global != nil {
+			s.shaper.SetGlobalLimit(*upd.Global)
+		}
+		if upd.PerDestination != nil {
+			s.shaper.SetDefaultDestinationLimit(*upd.PerDestination)
+		}
+		if upd.Destination != "" {
+			if upd.DestinationLimit != nil {
+				s.shaper.SetDestinationLimit(upd.Destination, *upd.DestinationLimit)
+			} else {
+				s.shaper.ClearDestinationLimit(upd.Destination)
+			}
+		}
+		if upd.Session != nil && upd.SessionLimit != nil {
+			if !s.shaper.SetSessionLimit(*upd.Session, *upd.SessionLimit) {
+				writeError(w, r, http.StatusNotFound, fmt.Sprintf("session %s not found", upd.Session))
+				return
+			}
+		}
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	state := BandwidthState{Limits: s.shaper.Limits(), Sessions: s.shaper.Sessions()}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Warnw("writing bandwidth state", "err", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/boost/api"
+	"github.com/filecoin-project/boost/lib/shaper"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthAdminAuth(t *testing.T) {
+	verify := func(ctx context.Context, token string) ([]auth.Permission, error) {
+		switch token {
+		case "admin":
+			return api.AllPermissions, nil
+		case "read":
+			return []auth.Permission{api.PermRead}, nil
+		}
+		return nil, errors.New("invalid token")
+	}
+	s := &HttpServer{shaper: shaper.New(0, 0)}
+	WithAdminAuth(verify)(s)
+
+	update := func(remoteAddr string, token string) int {
+		r := httptest.NewRequest(http.MethodPost, s.bandwidthPath(), strings.NewReader(`{"Global":1024}`))
+		r.RemoteAddr = remoteAddr
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.handleBandwidth(w, r)
+		return w.Code
+	}
+
+	// Requests must come from the local machine
+	require.Equal(t, http.StatusForbidden, update("192.0.2.1:1234", "admin"))
+
+	// Requests must have a token with admin permission
+	require.Equal(t, http.StatusUnauthorized, update("127.0.0.1:1234", ""))
+	require.Equal(t, http.StatusUnauthorized, update("127.0.0.1:1234", "invalid"))
+	require.Equal(t, http.StatusUnauthorized, update("127.0.0.1:1234", "read"))
+	require.Zero(t, s.shaper.Limits().Global)
+
+	require.Equal(t, http.StatusOK, update("127.0.0.1:1234", "admin"))
+	require.EqualValues(t, 1024, s.shaper.Limits().Global)
+
+	// Without an auth verifier the admin endpoints are disabled
+	s.authVerify = nil
+	require.Equal(t, http.StatusUnauthorized, update("127.0.0.1:1234", "admin"))
+}
+
+func TestBandwidthLimitHeader(t *testing.T) {
+	limit := func(val string) (uint64, error) {
+		r := httptest.NewRequest(http.MethodGet, "/piece", nil)
+		if val != "" {
+			r.Header.Set(BandwidthLimitHeader, val)
+		}
+		return bandwidthLimit(r)
+	}
+
+	l, err := limit("")
+	require.NoError(t, err)
+	require.Zero(t, l)
+
+	l, err = limit("1MiB")
+	require.NoError(t, err)
+	require.EqualValues(t, 1024*1024, l)
+
+	_, err = limit("0")
+	require.Error(t, err)
+	_, err = limit("fast")
+	require.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/filecoin-project/boost/lib/ipfsgateway"
+	"github.com/filecoin-project/boost/lib/retrievalevents"
+)
+
+// WithIPFSGateway serves the blocks in the provider's pieces according to
+// the IPFS trustless path gateway spec at /ipfs/{cid}/{path}
+func WithIPFSGateway(bg ipfsgateway.BlockGetter) HttpServerOption {
+	return func(s *HttpServer) {
+		s.gateway = ipfsgateway.New(bg, s.ipfsBasePath())
+	}
+}
+
+func (s *HttpServer) ipfsBasePath() string {
+	return s.path + ipfsgateway.PathPrefix
+}
+
+func (s *HttpServer) handleIpfsRequest(w http.ResponseWriter, r *http.Request) {
+	if s.acl != nil {
+		if err := s.acl.Check(aclClient(r)); err != nil {
+			writeError(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+	if _, err := bandwidthLimit(r); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	root := strings.SplitN(strings.TrimPrefix(r.URL.Path, s.ipfsBasePath()), "/", 2)[0]
+	evt := retrievalevents.Event{PayloadCid: root}
+
+	// Watch for errors writing the response, as the gateway can only log
+	// them once it has started streaming a CAR file
+	var err error
+	writeErrWatcher := &writeErrorWatcher{ResponseWriter: w, onError: func(e error) {
+		err = e
+	}}
+	rw, end := s.retrievalWriter(writeErrWatcher, r, evt)
+	alog("%s\t%s %s", color.New(color.FgGreen).Sprint("START"), r.Method, r.URL)
+	s.gateway.ServeHTTP(rw, r)
+	end(err)
+	if err != nil {
+		alog("%s\t%s %s: %s bytes transferred\n%s", color.New(color.FgRed).Sprint("FAIL"),
+			r.Method, r.URL, addCommas(writeErrWatcher.count), err)
+		return
+	}
+	alog("%s\t%s %s: %s bytes transferred", color.New(color.FgGreen).Sprint("DONE"),
+		r.Method, r.URL, addCommas(writeErrWatcher.count))
+}
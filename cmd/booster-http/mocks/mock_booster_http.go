@@ -54,6 +54,21 @@ func (mr *MockHttpServerApiMockRecorder) GetMaxPieceOffset(pieceCid interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMaxPieceOffset", reflect.TypeOf((*MockHttpServerApi)(nil).GetMaxPieceOffset), pieceCid)
 }
 
+// GetPieceIndex mocks base method.
+func (m *MockHttpServerApi) GetPieceIndex(pieceCid cid.Cid) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPieceIndex", pieceCid)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPieceIndex indicates an expected call of GetPieceIndex.
+func (mr *MockHttpServerApiMockRecorder) GetPieceIndex(pieceCid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPieceIndex", reflect.TypeOf((*MockHttpServerApi)(nil).GetPieceIndex), pieceCid)
+}
+
 // GetPieceInfo mocks base method.
 func (m *MockHttpServerApi) GetPieceInfo(pieceCID cid.Cid) (*piecestore.PieceInfo, error) {
 	m.ctrl.T.Helper()
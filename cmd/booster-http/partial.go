@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/fatih/color"
+	"github.com/filecoin-project/boost/lib/partialcar"
+	"github.com/filecoin-project/boost/lib/retrievalevents"
+	"github.com/filecoin-project/boost/tracing"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-ipld-prime"
+)
+
+const selectorParam = "selector"
+const bytesParam = "bytes"
+
+// partialCarParams select the part of the DAG under the payload CID that is
+// served in a partial CAR file: either the blocks matched by an IPLD
+// selector, or the blocks of a byte range of a UnixFS file
+type partialCarParams struct {
+	selector  ipld.Node
+	byteRange *partialcar.ByteRange
+}
+
+// parsePartialCarParams parses the selector and bytes query parameters. It
+// returns nil if neither is set.
+func parsePartialCarParams(q url.Values) (*partialCarParams, error) {
+	if len(q[selectorParam]) > 1 || len(q[bytesParam]) > 1 {
+		return nil, fmt.Errorf("single `%s` and `%s` query parameters are allowed", selectorParam, bytesParam)
+	}
+	sel, rng := q.Get(selectorParam), q.Get(bytesParam)
+	if sel == "" && rng == "" {
+		return nil, nil
+	}
+	if sel != "" && rng != "" {
+		return nil, fmt.Errorf("only one of the `%s` and `%s` query parameters may be set", selectorParam, bytesParam)
+	}
+
+	var p partialCarParams
+	var err error
+	if sel != "" {
+		p.selector, err = partialcar.ParseSelector(sel)
+	} else {
+		p.byteRange, err = partialcar.ParseByteRange(rng)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// handlePartialCar streams a CAR file with the part of the DAG under the
+// payload CID that is selected by the params. The blocks are read from the
+// piece at the offsets in the piece's index.
+func (s *HttpServer) handlePartialCar(payloadCid cid.Cid, params *partialCarParams, w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer.Start(r.Context(), "http.partial_car")
+	defer span.End()
+
+	pieceCid, content, idx, err := s.getPayloadPiece(ctx, payloadCid)
+	if content != nil {
+		defer closeContent(content)
+	}
+	if err == nil {
+		ra, ok := content.(io.ReaderAt)
+		if !ok {
+			err = fmt.Errorf("the reader for piece %s does not support random access", pieceCid)
+		} else {
+			bs := partialcar.NewPieceBlockstore(ra, idx)
+			// Check that the root is in the piece before sending the response
+			// status
+			if _, err = bs.Get(ctx, payloadCid); err == nil {
+				s.writePartialCar(ctx, w, r, bs, payloadCid, pieceCid, params)
+				return
+			}
+		}
+	}
+
+	if isNotFoundError(err) || errors.Is(err, partialcar.ErrNotFound) {
+		msg := fmt.Sprintf("getting partial CAR for payload CID %s: %s", payloadCid, err)
+		writeError(w, r, http.StatusNotFound, msg)
+		return
+	}
+	log.Errorf("getting partial CAR for payload CID %s: %s", payloadCid, err)
+	msg := fmt.Sprintf("server error getting partial CAR for payload CID %s", payloadCid)
+	writeError(w, r, http.StatusInternalServerError, msg)
+}
+
+func (s *HttpServer) writePartialCar(ctx context.Context, w http.ResponseWriter, r *http.Request, bs *partialcar.PieceBlockstore, payloadCid cid.Cid, pieceCid cid.Cid, params *partialCarParams) {
+	w.Header().Set("Content-Type", getContentType(true))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The status has already been sent, so an error part way through can
+	// only be logged, and the client will receive a truncated CAR file
+	var werr error
+	writeErrWatcher := &writeErrorWatcher{ResponseWriter: w, onError: func(e error) {
+		werr = e
+	}}
+	rw, end := s.retrievalWriter(writeErrWatcher, r, retrievalevents.Event{PayloadCid: payloadCid.String(), PieceCid: pieceCid.String()})
+	alog("%s\tGET %s", color.New(color.FgGreen).Sprintf("%d", http.StatusOK), r.URL)
+
+	var err error
+	if params.selector != nil {
+		err = partialcar.WriteSelector(ctx, rw, bs, payloadCid, params.selector)
+	} else {
+		err = partialcar.WriteByteRange(ctx, rw, bs, payloadCid, *params.byteRange)
+	}
+	if err == nil {
+		err = werr
+	}
+	end(err)
+
+	if err != nil {
+		alog("%s\tGET %s: %s bytes transferred\n%s", color.New(color.FgRed).Sprint("FAIL"),
+			r.URL, addCommas(writeErrWatcher.count), err)
+		return
+	}
+	alog("%s\tGET %s: %s bytes transferred", color.New(color.FgGreen).Sprint("DONE"),
+		r.URL, addCommas(writeErrWatcher.count))
+}
+
+// getPayloadPiece gets the content and the index of the first piece that
+// contains the payload CID
+func (s *HttpServer) getPayloadPiece(ctx context.Context, payloadCid cid.Cid) (cid.Cid, io.ReadSeeker, index.Index, error) {
+	pieces, err := s.api.PiecesContainingMultihash(ctx, payloadCid.Hash())
+	if err != nil {
+		return cid.Undef, nil, nil, fmt.Errorf("getting piece that contains payload CID '%s': %w", payloadCid, err)
+	}
+	pieceCid := pieces[0]
+	content, err := s.getPieceContent(ctx, pieceCid)
+	if err != nil {
+		return pieceCid, nil, nil, err
+	}
+	idx, err := s.getPieceIndex(pieceCid, content)
+	return pieceCid, content, idx, err
+}
+
+// getPieceIndex gets the CAR index of the piece, or if the piece hasn't been
+// indexed and indexing is allowed, builds the index from the piece content
+func (s *HttpServer) getPieceIndex(pieceCid cid.Cid, content io.ReadSeeker) (index.Index, error) {
+	idx, err := s.fetchPieceIndex(pieceCid)
+	if err == nil {
+		return idx, nil
+	}
+	if !s.allowIndexing {
+		return nil, fmt.Errorf("getting index for piece %s: %w", pieceCid, err)
+	}
+	return s.buildPieceIndex(pieceCid, content)
+}
+
+// fetchPieceIndex gets the CAR index of the piece from the cache, or from
+// boostd. The index of a piece never changes, so each index is only fetched
+// (and parsed) once while it's in the cache.
+func (s *HttpServer) fetchPieceIndex(pieceCid cid.Cid) (index.Index, error) {
+	if idx, ok := s.indexCache.Get(pieceCid); ok {
+		return idx.(index.Index), nil
+	}
+	bz, err := s.api.GetPieceIndex(pieceCid)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.ReadFrom(bytes.NewReader(bz))
+	if err != nil {
+		return nil, fmt.Errorf("reading index for piece %s: %w", pieceCid, err)
+	}
+	s.indexCache.Add(pieceCid, idx)
+	return idx, nil
+}
+
+// buildPieceIndex builds the CAR index of the piece from its content, and
+// caches it
+func (s *HttpServer) buildPieceIndex(pieceCid cid.Cid, content io.ReadSeeker) (index.Index, error) {
+	alog("%s\tbuilding index for %s", color.New(color.FgBlue).Sprintf("INFO"), pieceCid)
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking to start of piece %s: %w", pieceCid, err)
+	}
+	idx, err := car.GenerateIndex(content, car.ZeroLengthSectionAsEOF(true), car.StoreIdentityCIDs(true))
+	if err != nil {
+		return nil, fmt.Errorf("generating index for piece %s: %w", pieceCid, err)
+	}
+	s.indexCache.Add(pieceCid, idx)
+	return idx, nil
+}
+
+func (s *HttpServer) handleIndexByPayloadCid(payloadCid cid.Cid, w http.ResponseWriter, r *http.Request) {
+	pieces, err := s.api.PiecesContainingMultihash(r.Context(), payloadCid.Hash())
+	if err != nil {
+		msg := fmt.Sprintf("getting piece that contains payload CID '%s': %s", payloadCid, err)
+		if isNotFoundError(err) {
+			writeError(w, r, http.StatusNotFound, msg)
+			return
+		}
+		log.Error(msg)
+		writeError(w, r, http.StatusInternalServerError, msg)
+		return
+	}
+	s.handlePieceIndex(pieces[0], w, r)
+}
+
+// handlePieceIndex serves the CARv2 index of a piece (the same format as the
+// index of a CARv2 file), so that the client can fetch individual blocks
+// from the piece's CAR file with range requests
+func (s *HttpServer) handlePieceIndex(pieceCid cid.Cid, w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer.Start(r.Context(), "http.piece_index")
+	defer span.End()
+
+	idx, err := s.fetchPieceIndex(pieceCid)
+	if err != nil && s.allowIndexing {
+		var content io.ReadSeeker
+		content, err = s.getPieceContent(ctx, pieceCid)
+		if err == nil {
+			defer closeContent(content)
+			idx, err = s.buildPieceIndex(pieceCid, content)
+		}
+	}
+	var buf bytes.Buffer
+	if err == nil {
+		_, err = index.WriteTo(idx, &buf)
+	}
+	if err != nil {
+		if isNotFoundError(err) {
+			writeError(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Errorf("getting index for piece %s: %s", pieceCid, err)
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("server error getting index for piece CID %s", pieceCid))
+		return
+	}
+
+	w.Header().Set("Etag", pieceCid.String()+".idx")
+	s.serveContent(w, r, bytes.NewReader(buf.Bytes()), "application/octet-stream", retrievalevents.Event{PieceCid: pieceCid.String()})
+}
+
+func closeContent(content io.ReadSeeker) {
+	if c, ok := content.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/filecoin-project/boost/lib/httpretrieval"
+)
+
+// WithPayments requires that the content served (CAR files, pieces and piece
+// indexes) is paid for with payment channel vouchers, a chunk at a time (see
+// httpretrieval.Payments)
+func WithPayments(p *httpretrieval.Payments) HttpServerOption {
+	return func(s *HttpServer) {
+		s.payments = p
+	}
+}
+
+// acceptPayment checks that the request pays for the bytes of the content
+// that it requests. If it doesn't, it writes the error response and returns
+// false. Requests for the headers only are free.
+func (s *HttpServer) acceptPayment(w http.ResponseWriter, r *http.Request, content io.ReadSeeker) bool {
+	if s.payments == nil || r.Method == http.MethodHead {
+		return true
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Errorf("getting size of content for %s: %s", r.URL, err)
+		writeError(w, r, http.StatusInternalServerError, "server error getting size of content")
+		return false
+	}
+
+	err = s.payments.Accept(r.Context(), r, uint64(size))
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, httpretrieval.ErrPaymentRequired) {
+		s.writePaymentRequired(w, r, err.Error())
+		return false
+	}
+	log.Errorf("checking payment for %s: %s", r.URL, err)
+	writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("server error checking payment: %s", err))
+	return false
+}
+
+// writePaymentRequired writes a 402 (Payment Required) response with the
+// payment terms
+func (s *HttpServer) writePaymentRequired(w http.ResponseWriter, r *http.Request, msg string) {
+	s.payments.Terms().SetHeaders(w.Header())
+	writeError(w, r, http.StatusPaymentRequired, msg)
+}
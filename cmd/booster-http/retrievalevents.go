@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/filecoin-project/boost/lib/retrievalevents"
+	"github.com/ipfs/go-cid"
+)
+
+// RetrievalStatsRecorder records a completed retrieval of a piece in the
+// provider's retrieval statistics
+type RetrievalStatsRecorder func(ctx context.Context, pieceCid cid.Cid, bytesServed uint64) error
+
+// WithRetrievalStats records each completed retrieval in the provider's
+// retrieval statistics, which deal clients can query for their pieces
+func WithRetrievalStats(record RetrievalStatsRecorder) HttpServerOption {
+	return func(s *HttpServer) {
+		s.recordStats = record
+	}
+}
+
+// countingResponseWriter counts the number of bytes of the response body
+// that have been sent, and records the response status
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	count  uint64
+}
+
+func (c *countingResponseWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// succeeded is true if the response has a success status
+func (c *countingResponseWriter) succeeded() bool {
+	return c.status == 0 || (c.status >= 200 && c.status < 300)
+}
+
+func (c *countingResponseWriter) Write(bz []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(bz)
+	c.count += uint64(n)
+	return n, err
+}
+
+// recordRetrievalStats records a completed retrieval in the background, so
+// as not to hold up the response. If the retrieval was by payload cid, it's
+// recorded against the first piece that contains the payload.
+func (s *HttpServer) recordRetrievalStats(evt retrievalevents.Event, bytesServed uint64) {
+	go func() {
+		var pieceCid cid.Cid
+		var err error
+		if evt.PieceCid != "" {
+			pieceCid, err = cid.Parse(evt.PieceCid)
+		} else {
+			var payloadCid cid.Cid
+			payloadCid, err = cid.Parse(evt.PayloadCid)
+			if err == nil {
+				var pieces []cid.Cid
+				pieces, err = s.api.PiecesContainingMultihash(s.ctx, payloadCid.Hash())
+				if err == nil && len(pieces) > 0 {
+					pieceCid = pieces[0]
+				}
+			}
+		}
+		if err != nil || !pieceCid.Defined() {
+			log.Debugw("getting piece to record retrieval stats", "payload", evt.PayloadCid, "err", err)
+			return
+		}
+		if err := s.recordStats(s.ctx, pieceCid, bytesServed); err != nil {
+			log.Warnw("recording retrieval stats", "piece", pieceCid, "err", err)
+		}
+	}()
+}
+
+// WithRetrievalEvents records the events of each retrieval in the journal
+func WithRetrievalEvents(j *retrievalevents.Journal) HttpServerOption {
+	return func(s *HttpServer) {
+		s.events = j
+	}
+}
+
+// servedResponseWriter records the number of bytes of the response body
+// that have been sent
+type servedResponseWriter struct {
+	http.ResponseWriter
+	retrieval *retrievalevents.Retrieval
+	count     uint64
+}
+
+func (s *servedResponseWriter) Write(bz []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(bz)
+	if n > 0 {
+		s.count += uint64(n)
+		s.retrieval.Served(s.count)
+	}
+	return n, err
+}
+
+// end records that the retrieval completed, or failed with err
+func (s *servedResponseWriter) end(err error) {
+	if err != nil {
+		s.retrieval.Fail(s.count, err)
+		return
+	}
+	s.retrieval.Complete(s.count)
+}
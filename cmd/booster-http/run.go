@@ -7,15 +7,22 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"strings"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/filecoin-project/boost/api"
 	bclient "github.com/filecoin-project/boost/api/client"
 	cliutil "github.com/filecoin-project/boost/cli/util"
+	"github.com/filecoin-project/boost/lib/httpretrieval"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	"github.com/filecoin-project/boost/lib/retrievalevents"
+	"github.com/filecoin-project/boost/lib/shaper"
 	"github.com/filecoin-project/boost/tracing"
 	"github.com/filecoin-project/dagstore/mount"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/client"
 	"github.com/filecoin-project/lotus/api/v0api"
@@ -57,6 +64,11 @@ var runCmd = &cli.Command{
 			Usage: "allow booster-http to build an index for a CAR file on the fly if necessary (requires doing an extra pass over the CAR file)",
 			Value: false,
 		},
+		&cli.BoolFlag{
+			Name:  "serve-ipfs-gateway",
+			Usage: "serve verifiable CAR files and raw blocks at /ipfs/{cid}/{path}, according to the IPFS trustless gateway spec",
+			Value: false,
+		},
 		&cli.StringFlag{
 			Name:     "api-boost",
 			Usage:    "the endpoint for the boost API",
@@ -72,6 +84,43 @@ var runCmd = &cli.Command{
 			Usage:    "the endpoint for the storage node API",
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name:  "bandwidth-limit",
+			Usage: "the maximum total download bandwidth across all retrievals, in bytes per second (eg 100MiB). 0 means unlimited",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "bandwidth-limit-per-destination",
+			Usage: "the maximum download bandwidth to each destination IP address, in bytes per second (eg 10MiB). 0 means unlimited",
+			Value: "0",
+		},
+		&cli.StringSliceFlag{
+			Name: "retrieval-event-sink",
+			Usage: "publish the events of each retrieval (accepted, first-byte, bytes-served, completed, failed) to the sink: " +
+				"file:///path/to/retrievals.jsonl, otel (requires --tracing), nats://host:4222/subject " +
+				"or kafka://host:9092/topic (nats+tls:// and kafka+tls:// connect over TLS) (may be repeated)",
+		},
+		&cli.IntFlag{
+			Name:  "piece-index-cache-size",
+			Usage: "the number of piece CAR indexes to cache, so that partial CAR files can be served without fetching the index from boostd for each request",
+			Value: defaultPieceIndexCacheSize,
+		},
+		&cli.DurationFlag{
+			Name:  "retrieval-event-progress-interval",
+			Usage: "the minimum time between the bytes-served events of a retrieval",
+			Value: 10 * time.Second,
+		},
+		&cli.StringFlag{
+			Name: "price-per-byte",
+			Usage: "the price per byte of retrievals, in attoFIL. If set, clients pay for retrievals with vouchers on " +
+				"a payment channel to the full node's wallet, a payment interval at a time",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "payment-interval",
+			Usage: "the maximum number of bytes that a client may request per payment voucher (eg 1MiB)",
+			Value: "1MiB",
+		},
 		&cli.BoolFlag{
 			Name:  "tracing",
 			Usage: "enables tracing of booster-http calls",
@@ -163,6 +212,100 @@ var runCmd = &cli.Command{
 		pp := sealer.NewPieceProvider(storage, storageService, storageService)
 		sa := sectoraccessor.NewSectorAccessor(dtypes.MinerAddress(maddr), storageService, pp, fullnodeApi)
 		allowIndexing := cctx.Bool("allow-indexing")
+
+		// Set up bandwidth shaping. The limits can be changed while
+		// booster-http is running through the bandwidth endpoint.
+		bwLimit, err := units.RAMInBytes(cctx.String("bandwidth-limit"))
+		if err != nil {
+			return fmt.Errorf("parsing bandwidth-limit: %w", err)
+		}
+		bwDestLimit, err := units.RAMInBytes(cctx.String("bandwidth-limit-per-destination"))
+		if err != nil {
+			return fmt.Errorf("parsing bandwidth-limit-per-destination: %w", err)
+		}
+		if bwLimit < 0 || bwDestLimit < 0 {
+			return errors.New("bandwidth limits must not be negative")
+		}
+		bwShaper := shaper.New(uint64(bwLimit), uint64(bwDestLimit))
+
+		// Get the retrieval ACL from boost, and keep it up to date when the
+		// boost config is reloaded
+		aclCfg, err := bapi.BoostRetrievalACL(ctx)
+		if err != nil {
+			return fmt.Errorf("getting retrieval ACL from boost: %w", err)
+		}
+		acl, err := retrievalacl.New(*aclCfg)
+		if err != nil {
+			return fmt.Errorf("parsing retrieval ACL: %w", err)
+		}
+		go acl.Poll(ctx, time.Minute, bapi.BoostRetrievalACL)
+
+		// Publish retrieval events to the sinks until the server has stopped
+		var sinks []retrievalevents.Sink
+		for _, spec := range cctx.StringSlice("retrieval-event-sink") {
+			sink, err := retrievalevents.New(spec)
+			if err != nil {
+				for _, s := range sinks {
+					_ = s.Close()
+				}
+				return err
+			}
+			sinks = append(sinks, sink)
+		}
+		events := retrievalevents.NewJournal(sinks, retrievalevents.Config{
+			ProgressInterval: cctx.Duration("retrieval-event-progress-interval"),
+		})
+		eventsCtx, stopEvents := context.WithCancel(context.Background())
+		eventsDone := make(chan struct{})
+		go func() {
+			defer close(eventsDone)
+			events.Run(eventsCtx)
+		}()
+		defer func() {
+			stopEvents()
+			<-eventsDone
+		}()
+		indexCacheSize := cctx.Int("piece-index-cache-size")
+		if indexCacheSize <= 0 {
+			return errors.New("piece-index-cache-size must be positive")
+		}
+		opts := []HttpServerOption{
+			WithShaper(bwShaper),
+			WithACL(acl),
+			WithPieceIndexCacheSize(indexCacheSize),
+			// Only boost API tokens with admin permission may change the
+			// bandwidth limits
+			WithAdminAuth(bapi.AuthVerify),
+			WithRetrievalStats(bapi.BoostRetrievalStatsRecord),
+		}
+		if len(sinks) > 0 {
+			opts = append(opts, WithRetrievalEvents(events))
+		}
+		pricePerByte, err := big.FromString(cctx.String("price-per-byte"))
+		if err != nil {
+			return fmt.Errorf("parsing price-per-byte: %w", err)
+		}
+		if pricePerByte.GreaterThan(big.Zero()) {
+			if cctx.Bool("serve-ipfs-gateway") {
+				return errors.New("the IPFS gateway can't be served when retrievals must be paid for")
+			}
+			interval, err := units.RAMInBytes(cctx.String("payment-interval"))
+			if err != nil {
+				return fmt.Errorf("parsing payment-interval: %w", err)
+			}
+			if interval <= 0 {
+				return errors.New("payment-interval must be positive")
+			}
+			log.Infof("Retrievals must be paid for at %s attoFIL per byte, every %d bytes", pricePerByte, interval)
+			opts = append(opts, WithPayments(httpretrieval.NewPayments(fullnodeApi, httpretrieval.Terms{
+				PricePerByte:    pricePerByte,
+				PaymentInterval: uint64(interval),
+			})))
+		}
+		if cctx.Bool("serve-ipfs-gateway") {
+			opts = append(opts, WithIPFSGateway(bapi))
+		}
+
 		// Create the server API
 		sapi := serverApi{ctx: ctx, bapi: bapi, sa: sa}
 		server := NewHttpServer(
@@ -170,6 +313,7 @@ var runCmd = &cli.Command{
 			cctx.Int("port"),
 			allowIndexing,
 			sapi,
+			opts...,
 		)
 
 		// Start the server
@@ -235,6 +379,10 @@ func (s serverApi) GetMaxPieceOffset(pieceCid cid.Cid) (uint64, error) {
 	return s.bapi.PiecesGetMaxOffset(s.ctx, pieceCid)
 }
 
+func (s serverApi) GetPieceIndex(pieceCid cid.Cid) ([]byte, error) {
+	return s.bapi.PiecesGetIndex(s.ctx, pieceCid)
+}
+
 func (s serverApi) GetPieceInfo(pieceCID cid.Cid) (*piecestore.PieceInfo, error) {
 	return s.bapi.PiecesGetPieceInfo(s.ctx, pieceCID)
 }
@@ -14,13 +14,20 @@ import (
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/fatih/color"
+	"github.com/filecoin-project/boost/lib/httpretrieval"
+	"github.com/filecoin-project/boost/lib/ipfsgateway"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	"github.com/filecoin-project/boost/lib/retrievalevents"
+	"github.com/filecoin-project/boost/lib/shaper"
 	"github.com/filecoin-project/boost/metrics"
 	"github.com/filecoin-project/boost/tracing"
 	"github.com/filecoin-project/dagstore/mount"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
+	lru "github.com/hnlq715/golang-lru"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipld/go-car/v2"
@@ -48,6 +55,15 @@ type HttpServer struct {
 	port          int
 	allowIndexing bool
 	api           HttpServerApi
+	shaper        *shaper.Shaper
+	acl           *retrievalacl.ACL
+	events        *retrievalevents.Journal
+	gateway       *ipfsgateway.Gateway
+	payments      *httpretrieval.Payments
+	authVerify    AuthVerifier
+	recordStats   RetrievalStatsRecorder
+	// The CAR indexes of the most recently served pieces
+	indexCache *lru.Cache
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -57,13 +73,52 @@ type HttpServer struct {
 type HttpServerApi interface {
 	PiecesContainingMultihash(ctx context.Context, mh multihash.Multihash) ([]cid.Cid, error)
 	GetMaxPieceOffset(pieceCid cid.Cid) (uint64, error)
+	GetPieceIndex(pieceCid cid.Cid) ([]byte, error)
 	GetPieceInfo(pieceCID cid.Cid) (*piecestore.PieceInfo, error)
 	IsUnsealed(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (bool, error)
 	UnsealSectorAt(ctx context.Context, sectorID abi.SectorNumber, pieceOffset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (mount.Reader, error)
 }
 
-func NewHttpServer(path string, port int, allowIndexing bool, api HttpServerApi) *HttpServer {
-	return &HttpServer{path: path, port: port, allowIndexing: allowIndexing, api: api}
+// HttpServerOption is an option for configuring the HttpServer
+type HttpServerOption func(*HttpServer)
+
+// WithShaper limits the bandwidth used to serve content with the given shaper
+func WithShaper(sh *shaper.Shaper) HttpServerOption {
+	return func(s *HttpServer) {
+		s.shaper = sh
+	}
+}
+
+// WithACL refuses requests from clients that are denied by the retrieval ACL,
+// and limits the bandwidth used to serve each client
+func WithACL(acl *retrievalacl.ACL) HttpServerOption {
+	return func(s *HttpServer) {
+		s.acl = acl
+	}
+}
+
+// WithPieceIndexCacheSize sets the number of piece CAR indexes that are
+// cached to serve partial CAR files and piece indexes
+func WithPieceIndexCacheSize(size int) HttpServerOption {
+	return func(s *HttpServer) {
+		// lru.New only fails if the size isn't positive, in which case the
+		// default size is used
+		s.indexCache, _ = lru.New(size)
+	}
+}
+
+// The default number of piece CAR indexes that are cached
+const defaultPieceIndexCacheSize = 32
+
+func NewHttpServer(path string, port int, allowIndexing bool, api HttpServerApi, opts ...HttpServerOption) *HttpServer {
+	s := &HttpServer{path: path, port: port, allowIndexing: allowIndexing, api: api}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.indexCache == nil {
+		s.indexCache, _ = lru.New(defaultPieceIndexCacheSize)
+	}
+	return s
 }
 
 func (s *HttpServer) pieceBasePath() string {
@@ -79,6 +134,12 @@ func (s *HttpServer) Start(ctx context.Context) {
 	handler.HandleFunc("/", s.handleIndex)
 	handler.HandleFunc("/index.html", s.handleIndex)
 	handler.Handle("/metrics", metrics.Exporter("booster_http")) // metrics
+	if s.shaper != nil {
+		handler.HandleFunc(s.bandwidthPath(), s.handleBandwidth)
+	}
+	if s.gateway != nil {
+		handler.HandleFunc(s.ipfsBasePath(), s.handleIpfsRequest)
+	}
 	s.server = &http.Server{
 		Addr:    listenAddr,
 		Handler: handler,
@@ -140,6 +201,30 @@ const idxPage = `
           <a href="/piece?pieceCid=bagaSomePieceCID&format=car" > /piece?payloadCid=<piece cid>&format=car</a>
         </td>
       </tr>
+      <tr>
+        <td>
+          Download part of a CAR file by payload CID, with an IPLD selector (dag-json) or a byte range of a UnixFS file
+        </td>
+        <td>
+          <a href="/piece?payloadCid=bafySomePayloadCid&format=car&bytes=0:1048575" > /piece?payloadCid=<payload cid>&format=car&selector=<selector>|bytes=<from>:<to></a>
+        </td>
+      </tr>
+      <tr>
+        <td>
+          Download the CARv2 index of a piece, for random access to the CAR file with range requests
+        </td>
+        <td>
+          <a href="/piece?pieceCid=bagaSomePieceCID&format=index" > /piece?pieceCid=<piece cid>&format=index</a>
+        </td>
+      </tr>
+      <tr>
+        <td>
+          Download a verifiable CAR file or raw block by IPFS path (if the IPFS gateway is enabled)
+        </td>
+        <td>
+          <a href="/ipfs/bafySomePayloadCid?format=car" > /ipfs/<cid>[/path]?format=car|raw</a>
+        </td>
+      </tr>
       </tbody>
     </table>
   </body>
@@ -152,6 +237,17 @@ func (s *HttpServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *HttpServer) handlePieceRequest(w http.ResponseWriter, r *http.Request) {
+	if s.acl != nil {
+		if err := s.acl.Check(aclClient(r)); err != nil {
+			writeError(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+	if _, err := bandwidthLimit(r); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	q, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
 		msg := fmt.Sprintf("parsing query: %s", err.Error())
@@ -160,11 +256,16 @@ func (s *HttpServer) handlePieceRequest(w http.ResponseWriter, r *http.Request)
 	}
 
 	isCar := false
+	isIndex := false
 
 	if len(q["format"]) == 1 {
-		if q["format"][0] == "car" { // Check if format value is car
+		switch q["format"][0] {
+		case "car":
 			isCar = true
-		} else if q["format"][0] != "piece" { // Check if format value is not piece
+		case "index":
+			isIndex = true
+		case "piece":
+		default:
 			writeError(w, r, http.StatusBadRequest, "incorrect `format` query parameter")
 			return
 		}
@@ -175,6 +276,23 @@ func (s *HttpServer) handlePieceRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// A selector or byte range requests a partial CAR file
+	partial, err := parsePartialCarParams(q)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if partial != nil && (!isCar || len(q[payloadCidParam]) != 1) {
+		writeError(w, r, http.StatusBadRequest, "the `selector` and `bytes` query parameters require `format=car` and a `payloadCid`")
+		return
+	}
+	// The size of a partial CAR file isn't known until it has been sent, so
+	// it can't be paid for up front
+	if partial != nil && s.payments != nil {
+		s.writePaymentRequired(w, r, "partial CAR files can't be paid for: retrieve the whole CAR file")
+		return
+	}
+
 	// Check provided cid and format and redirect the request appropriately
 	if len(q[payloadCidParam]) == 1 {
 		payloadCid, err := cid.Parse(q[payloadCidParam][0])
@@ -184,7 +302,14 @@ func (s *HttpServer) handlePieceRequest(w http.ResponseWriter, r *http.Request)
 			stats.Record(r.Context(), metrics.HttpPayloadByCidRequestCount.M(1))
 			return
 		}
-		s.handleByPayloadCid(payloadCid, isCar, w, r)
+		switch {
+		case partial != nil:
+			s.handlePartialCar(payloadCid, partial, w, r)
+		case isIndex:
+			s.handleIndexByPayloadCid(payloadCid, w, r)
+		default:
+			s.handleByPayloadCid(payloadCid, isCar, w, r)
+		}
 	} else if len(q[pieceCidParam]) == 1 {
 		pieceCid, err := cid.Parse(q[pieceCidParam][0])
 		if err != nil {
@@ -193,6 +318,10 @@ func (s *HttpServer) handlePieceRequest(w http.ResponseWriter, r *http.Request)
 			stats.Record(r.Context(), metrics.HttpPieceByCidRequestCount.M(1))
 			return
 		}
+		if isIndex {
+			s.handlePieceIndex(pieceCid, w, r)
+			return
+		}
 		s.handleByPieceCid(pieceCid, isCar, w, r)
 	} else {
 		writeError(w, r, http.StatusBadRequest, "unsupported query")
@@ -250,7 +379,7 @@ func (s *HttpServer) handleByPayloadCid(payloadCid cid.Cid, isCar bool, w http.R
 	}
 	w.Header().Set("Etag", etag)
 
-	serveContent(w, r, content, getContentType(isCar))
+	s.serveContent(w, r, content, getContentType(isCar), retrievalevents.Event{PayloadCid: payloadCid.String(), PieceCid: pieceCid.String()})
 
 	stats.Record(ctx, metrics.HttpPayloadByCid200ResponseCount.M(1))
 	stats.Record(ctx, metrics.HttpPayloadByCidRequestDuration.M(float64(time.Since(startTime).Milliseconds())))
@@ -287,7 +416,7 @@ func (s *HttpServer) handleByPieceCid(pieceCid cid.Cid, isCar bool, w http.Respo
 	}
 	w.Header().Set("Etag", etag)
 
-	serveContent(w, r, content, getContentType(isCar))
+	s.serveContent(w, r, content, getContentType(isCar), retrievalevents.Event{PieceCid: pieceCid.String()})
 
 	stats.Record(ctx, metrics.HttpPieceByCid200ResponseCount.M(1))
 	stats.Record(ctx, metrics.HttpPieceByCidRequestDuration.M(float64(time.Since(startTime).Milliseconds())))
@@ -300,7 +429,66 @@ func getContentType(isCar bool) string {
 	return "application/piece"
 }
 
-func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType string) {
+// serveContent serves the content once it has been paid for (if payment is
+// required), shaping the bandwidth if a shaper is configured (capped at the
+// bandwidth the client asked for, if any), and limiting it to the client's
+// retrieval ACL bandwidth.
+// If retrieval events are enabled, the events of the retrieval described by
+// evt are recorded.
+func (s *HttpServer) serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType string, evt retrievalevents.Event) {
+	if !s.acceptPayment(w, r, content) {
+		return
+	}
+
+	w, end := s.retrievalWriter(w, r, evt)
+	err := serveContent(w, r, content, contentType)
+	end(err)
+}
+
+// retrievalWriter wraps the response writer to shape the bandwidth, and to
+// record retrieval events and statistics (see serveContent). The returned end function must
+// be called with the error writing the response, if any, once the response
+// has been written.
+func (s *HttpServer) retrievalWriter(w http.ResponseWriter, r *http.Request, evt retrievalevents.Event) (http.ResponseWriter, func(error)) {
+	var served *servedResponseWriter
+	if s.events != nil && r.Method != http.MethodHead {
+		evt.RetrievalID = uuid.New().String()
+		evt.Transport = "http"
+		evt.Client = remoteHost(r)
+		served = &servedResponseWriter{ResponseWriter: w, retrieval: s.events.Start(evt)}
+		w = served
+	}
+	var counted *countingResponseWriter
+	if s.recordStats != nil && r.Method != http.MethodHead {
+		counted = &countingResponseWriter{ResponseWriter: w}
+		w = counted
+	}
+	var sess *shaper.Session
+	if s.shaper != nil {
+		// The bandwidth cap was validated when the request was received
+		limit, _ := bandwidthLimit(r)
+		sess = s.shaper.NewSession(remoteHost(r), limit)
+		w = &shapedResponseWriter{ResponseWriter: w, w: sess.Writer(r.Context(), w)}
+	}
+	if s.acl != nil {
+		w = &shapedResponseWriter{ResponseWriter: w, w: s.acl.Writer(r.Context(), aclClient(r), w)}
+	}
+	return w, func(err error) {
+		if sess != nil {
+			sess.Close()
+		}
+		if served != nil {
+			served.end(err)
+		}
+		if counted != nil && err == nil && counted.succeeded() {
+			s.recordRetrievalStats(evt, counted.count)
+		}
+	}
+}
+
+// serveContent serves the content, and returns the error writing the
+// response, if any
+func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType string) error {
 	// Set the Content-Type header explicitly so that http.ServeContent doesn't
 	// try to do it implicitly
 	w.Header().Set("Content-Type", contentType)
@@ -336,7 +524,7 @@ func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker,
 		// For an HTTP HEAD request ServeContent doesn't send any data (just headers)
 		http.ServeContent(writer, r, "", time.Time{}, content)
 		alog("%s\tHEAD %s", color.New(color.FgGreen).Sprintf("%d", http.StatusOK), r.URL)
-		return
+		return nil
 	}
 
 	// Send the content
@@ -355,6 +543,7 @@ func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker,
 		alogAt(end, "%s\t%s\n%s",
 			color.New(color.FgRed).Sprint("FAIL"), completeMsg, err)
 	}
+	return err
 }
 
 // isNotFoundError falls back to checking the error string for "not found".
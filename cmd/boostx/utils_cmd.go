@@ -3,15 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 
 	clinode "github.com/filecoin-project/boost/cli/node"
 	"github.com/filecoin-project/boost/cmd"
+	"github.com/filecoin-project/boost/lib/commp"
 	"github.com/filecoin-project/boost/node"
 	"github.com/filecoin-project/boost/node/config"
-	"github.com/filecoin-project/go-commp-utils/writer"
 	"github.com/filecoin-project/go-fil-markets/stores"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
@@ -199,6 +198,17 @@ var commpCmd = &cli.Command{
 	Usage:     "",
 	ArgsUsage: "<inputPath>",
 	Before:    before,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "backend",
+			Usage: fmt.Sprintf("the backend used to calculate commp, one of %v", commp.Backends()),
+			Value: commp.BackendGo,
+		},
+		&cli.StringFlag{
+			Name:  "backend-endpoint",
+			Usage: "the address of the commp service, for backends that run out of process",
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		if cctx.Args().Len() != 1 {
 			return fmt.Errorf("usage: commP <inputPath>")
@@ -212,13 +222,15 @@ var commpCmd = &cli.Command{
 		}
 		defer rdr.Close() //nolint:errcheck
 
-		w := &writer.Writer{}
-		_, err = io.CopyBuffer(w, rdr, make([]byte, writer.CommPBuf))
+		calc, err := commp.New(commp.Config{
+			Backend:  cctx.String("backend"),
+			Endpoint: cctx.String("backend-endpoint"),
+		})
 		if err != nil {
-			return fmt.Errorf("copy into commp writer: %w", err)
+			return err
 		}
 
-		commp, err := w.Sum()
+		pi, err := calc.Sum(cctx.Context, rdr)
 		if err != nil {
 			return fmt.Errorf("computing commP failed: %w", err)
 		}
@@ -230,8 +242,8 @@ var commpCmd = &cli.Command{
 			return err
 		}
 
-		fmt.Println("CommP CID: ", encoder.Encode(commp.PieceCID))
-		fmt.Println("Piece size: ", types.NewInt(uint64(commp.PieceSize.Unpadded().Padded())))
+		fmt.Println("CommP CID: ", encoder.Encode(pi.PieceCID))
+		fmt.Println("Piece size: ", types.NewInt(uint64(pi.Size.Unpadded().Padded())))
 		fmt.Println("Car file size: ", stat.Size())
 		return nil
 	},
@@ -14,3 +14,10 @@ var FlagJson = &cli.BoolFlag{
 	Usage: "output results in json format",
 	Value: false,
 }
+
+var FlagLogFormat = &cli.StringFlag{
+	Name:        "log-format",
+	Usage:       "the format of log output: color, text or json (one json object per line, eg for journald)",
+	DefaultText: "color",
+	EnvVars:     []string{"BOOST_LOG_FORMAT"},
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/filecoin-project/boost/lib/openapi"
+	"github.com/urfave/cli/v2"
+)
+
+// JsonSchemaVersion is the version of the json output schemas of the CLI
+// commands. Fields may be added to the output of a command without changing
+// the version, but the version is incremented if a field is removed or
+// changes type.
+const JsonSchemaVersion = 1
+
+// jsonOutputs maps the name of a command to an example of the value it
+// outputs in json mode
+var jsonOutputs = map[string]interface{}{}
+
+// RegisterJsonOutput registers the type of the value that a command outputs
+// when the --json flag is set, so that its schema can be printed with the
+// json-schema command. The name is the full command, eg "import list".
+func RegisterJsonOutput(command string, v interface{}) {
+	if _, ok := jsonOutputs[command]; ok {
+		panic(fmt.Sprintf("json output for command %s already registered", command))
+	}
+	jsonOutputs[command] = v
+}
+
+// JsonSchemas returns the schemas for the json output of each registered
+// command
+func JsonSchemas() map[string]*jsonschema.Schema {
+	r := &jsonschema.Reflector{
+		AllowAdditionalProperties: true,
+		ExpandedStruct:            true,
+		DoNotReference:            true,
+		TypeMapper:                openapi.TypeMapper,
+	}
+
+	schemas := make(map[string]*jsonschema.Schema, len(jsonOutputs))
+	for command, v := range jsonOutputs {
+		s := r.Reflect(v)
+		s.Definitions = nil
+		s.Title = command
+		schemas[command] = s
+	}
+	return schemas
+}
+
+// NewJsonSchemaCmd creates a command that prints the schemas of the json
+// output of the CLI commands
+func NewJsonSchemaCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "json-schema",
+		Usage:     "Print the schemas of the json output of commands",
+		ArgsUsage: "[command]",
+		Description: "Prints the JSON Schema of the output of each command when run with --json, " +
+			"eg 'json-schema import list'. Scripts can compare the version with the version " +
+			"they were written against.",
+		Action: func(cctx *cli.Context) error {
+			schemas := JsonSchemas()
+			if cctx.Args().Present() {
+				command := strings.Join(cctx.Args().Slice(), " ")
+				s, ok := schemas[command]
+				if !ok {
+					return fmt.Errorf("no json schema for command '%s' (available: %s)",
+						command, strings.Join(sortedKeys(schemas), ", "))
+				}
+				return PrintJson(map[string]interface{}{
+					"version": JsonSchemaVersion,
+					"command": command,
+					"schema":  s,
+				})
+			}
+
+			return PrintJson(map[string]interface{}{
+				"version":  JsonSchemaVersion,
+				"commands": schemas,
+			})
+		},
+	}
+}
+
+func sortedKeys(m map[string]*jsonschema.Schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
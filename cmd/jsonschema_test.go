@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJsonSchemas(t *testing.T) {
+	type output struct {
+		Name  string    `json:"name"`
+		Roots []cid.Cid `json:"roots"`
+		Note  string    `json:"note,omitempty"`
+	}
+	RegisterJsonOutput("test cmd", output{})
+	defer delete(jsonOutputs, "test cmd")
+
+	require.Panics(t, func() { RegisterJsonOutput("test cmd", output{}) })
+
+	s, ok := JsonSchemas()["test cmd"]
+	require.True(t, ok)
+	bz, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	var schema struct {
+		Title      string
+		Type       string
+		Required   []string
+		Properties map[string]struct {
+			Type  string
+			Items struct {
+				Type     string
+				Required []string
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(bz, &schema))
+	require.Equal(t, "test cmd", schema.Title)
+	require.Equal(t, "object", schema.Type)
+	require.ElementsMatch(t, []string{"name", "roots"}, schema.Required)
+	require.Equal(t, "string", schema.Properties["name"].Type)
+	require.Equal(t, "array", schema.Properties["roots"].Type)
+	require.Equal(t, "object", schema.Properties["roots"].Items.Type)
+	require.Equal(t, []string{"/"}, schema.Properties["roots"].Items.Required)
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/google/uuid"
+)
+
+// DealAnnotationsDB keeps the key / value annotations that operators attach
+// to deals
+type DealAnnotationsDB struct {
+	db *sql.DB
+}
+
+func NewDealAnnotationsDB(db *sql.DB) *DealAnnotationsDB {
+	return &DealAnnotationsDB{db: db}
+}
+
+// Set sets the value of the annotation with the key on the deal, replacing
+// any existing value
+func (a *DealAnnotationsDB) Set(ctx context.Context, dealUuid uuid.UUID, key string, value string, at time.Time) error {
+	qry := "INSERT INTO DealAnnotations (DealUUID, Key, Value, UpdatedAt) VALUES (?, ?, ?, ?) "
+	qry += "ON CONFLICT(DealUUID, Key) DO UPDATE SET Value = excluded.Value, UpdatedAt = excluded.UpdatedAt"
+	if _, err := a.db.ExecContext(ctx, qry, dealUuid.String(), key, value, at); err != nil {
+		return fmt.Errorf("setting annotation %s of deal %s: %w", key, dealUuid, err)
+	}
+	return nil
+}
+
+// Remove removes the annotation with the key from the deal. It returns
+// ErrNotFound if the deal doesn't have the annotation.
+func (a *DealAnnotationsDB) Remove(ctx context.Context, dealUuid uuid.UUID, key string) error {
+	res, err := a.db.ExecContext(ctx, "DELETE FROM DealAnnotations WHERE DealUUID = ? AND Key = ?", dealUuid.String(), key)
+	if err != nil {
+		return fmt.Errorf("removing annotation %s of deal %s: %w", key, dealUuid, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("deal %s has no annotation %s: %w", dealUuid, key, ErrNotFound)
+	}
+	return nil
+}
+
+// ByDeal returns the deal's annotations, ordered by key
+func (a *DealAnnotationsDB) ByDeal(ctx context.Context, dealUuid uuid.UUID) ([]types.DealAnnotation, error) {
+	return a.list(ctx, "WHERE DealUUID = ? ORDER BY Key", dealUuid.String())
+}
+
+// List returns the annotations with the key (or all annotations if key is
+// empty) and the value (or any value if value is empty), ordered by deal and
+// key
+func (a *DealAnnotationsDB) List(ctx context.Context, key string, value string) ([]types.DealAnnotation, error) {
+	where := "WHERE (? = '' OR Key = ?) AND (? = '' OR Value = ?) ORDER BY DealUUID, Key"
+	return a.list(ctx, where, key, key, value, value)
+}
+
+func (a *DealAnnotationsDB) list(ctx context.Context, whereClause string, whereArgs ...interface{}) ([]types.DealAnnotation, error) {
+	qry := "SELECT DealUUID, Key, Value, UpdatedAt FROM DealAnnotations " + whereClause
+	rows, err := a.db.QueryContext(ctx, qry, whereArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("getting deal annotations: %w", err)
+	}
+	defer rows.Close()
+
+	var list []types.DealAnnotation
+	for rows.Next() {
+		var dealUuid string
+		var an types.DealAnnotation
+		if err := rows.Scan(&dealUuid, &an.Key, &an.Value, &an.UpdatedAt); err != nil {
+			return nil, err
+		}
+		an.DealUuid, err = uuid.Parse(dealUuid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing deal uuid %s: %w", dealUuid, err)
+		}
+		list = append(list, an)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealAnnotationsDB(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	req.NoError(CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
+
+	adb := NewDealAnnotationsDB(sqldb)
+	deal1 := uuid.New()
+	deal2 := uuid.New()
+	now := time.Now().Truncate(time.Second)
+	req.NoError(adb.Set(ctx, deal1, "status", "investigating", now))
+	req.NoError(adb.Set(ctx, deal1, "note", "transfer keeps stalling", now))
+	req.NoError(adb.Set(ctx, deal2, "status", "investigating", now))
+
+	// Setting an existing key replaces its value
+	later := now.Add(time.Minute)
+	req.NoError(adb.Set(ctx, deal1, "note", "client is resending data", later))
+
+	anns, err := adb.ByDeal(ctx, deal1)
+	req.NoError(err)
+	req.Len(anns, 2)
+	req.Equal("note", anns[0].Key)
+	req.Equal("client is resending data", anns[0].Value)
+	req.Equal(deal1, anns[0].DealUuid)
+	req.True(later.Equal(anns[0].UpdatedAt))
+	req.Equal("status", anns[1].Key)
+
+	// Find the deals with an annotation
+	anns, err = adb.List(ctx, "status", "investigating")
+	req.NoError(err)
+	req.Len(anns, 2)
+	anns, err = adb.List(ctx, "status", "resolved")
+	req.NoError(err)
+	req.Empty(anns)
+	anns, err = adb.List(ctx, "", "")
+	req.NoError(err)
+	req.Len(anns, 3)
+
+	req.NoError(adb.Remove(ctx, deal1, "status"))
+	err = adb.Remove(ctx, deal1, "status")
+	req.True(errors.Is(err, ErrNotFound))
+	anns, err = adb.ByDeal(ctx, deal1)
+	req.NoError(err)
+	req.Len(anns, 1)
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/db/fielddef"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+)
+
+const capacityReservationFields = "ID, ClientAddress, ProviderAddress, Size, StartEpoch, EndEpoch, Deposit, Filled, DealCount"
+
+type CapacityReservationsDB struct {
+	db *sql.DB
+}
+
+func NewCapacityReservationsDB(db *sql.DB) *CapacityReservationsDB {
+	return &CapacityReservationsDB{db: db}
+}
+
+func (r *CapacityReservationsDB) Insert(ctx context.Context, res *types.CapacityReservation) error {
+	qry := "INSERT INTO CapacityReservations (ID, CreatedAt, ClientAddress, ProviderAddress, Size, StartEpoch, EndEpoch, Deposit) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	values := []interface{}{
+		res.ID,
+		time.Now(),
+		res.Client.String(),
+		res.Provider.String(),
+		res.Size,
+		res.StartEpoch,
+		res.EndEpoch,
+		res.Deposit.String(),
+	}
+	_, err := r.db.ExecContext(ctx, qry, values...)
+	return err
+}
+
+// Delete removes the capacity reservation with the given id
+func (r *CapacityReservationsDB) Delete(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM CapacityReservations WHERE ID = ?", id)
+	return err
+}
+
+func (r *CapacityReservationsDB) ByID(ctx context.Context, id uuid.UUID) (*types.CapacityReservationStatus, error) {
+	qry := "SELECT " + capacityReservationFields + " FROM CapacityReservations WHERE ID = ?"
+	row := r.db.QueryRowContext(ctx, qry, id)
+	st, err := r.scanRow(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return st, nil
+}
+
+// List returns all capacity reservations, most recent first
+func (r *CapacityReservationsDB) List(ctx context.Context) ([]types.CapacityReservationStatus, error) {
+	qry := "SELECT " + capacityReservationFields + " FROM CapacityReservations ORDER BY CreatedAt DESC, RowID"
+	rows, err := r.db.QueryContext(ctx, qry)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []types.CapacityReservationStatus
+	for rows.Next() {
+		st, err := r.scanRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Unfilled returns the total unfilled capacity of the reservations that have
+// not yet expired at the given epoch
+func (r *CapacityReservationsDB) Unfilled(ctx context.Context, epoch abi.ChainEpoch) (uint64, error) {
+	qry := "SELECT COALESCE(SUM(Size - Filled), 0) FROM CapacityReservations WHERE EndEpoch >= ? AND Filled < Size"
+	var total int64
+	if err := r.db.QueryRowContext(ctx, qry, epoch).Scan(&total); err != nil {
+		return 0, fmt.Errorf("getting unfilled capacity: %w", err)
+	}
+	return uint64(total), nil
+}
+
+// Fill adds a deal of the given size to the oldest reservation for the client
+// that covers the deal's start epoch and has enough unfilled capacity.
+// Returns ErrNotFound if there is no such reservation.
+func (r *CapacityReservationsDB) Fill(ctx context.Context, client address.Address, startEpoch abi.ChainEpoch, size uint64) (uuid.UUID, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	qry := "SELECT ID FROM CapacityReservations "
+	qry += "WHERE ClientAddress = ? AND StartEpoch <= ? AND EndEpoch >= ? AND Size - Filled >= ? "
+	qry += "ORDER BY CreatedAt, RowID LIMIT 1"
+	var id uuid.UUID
+	err = tx.QueryRowContext(ctx, qry, client.String(), startEpoch, startEpoch, size).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrNotFound
+		}
+		return uuid.Nil, fmt.Errorf("finding capacity reservation: %w", err)
+	}
+
+	qry = "UPDATE CapacityReservations SET Filled = Filled + ?, DealCount = DealCount + 1 WHERE ID = ?"
+	if _, err := tx.ExecContext(ctx, qry, size, id); err != nil {
+		return uuid.Nil, fmt.Errorf("filling capacity reservation %s: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("committing capacity reservation %s: %w", id, err)
+	}
+	return id, nil
+}
+
+func (r *CapacityReservationsDB) scanRow(row Scannable) (*types.CapacityReservationStatus, error) {
+	var st types.CapacityReservationStatus
+	res := &st.Reservation
+	clientFD := &fielddef.AddrFieldDef{F: &res.Client}
+	providerFD := &fielddef.AddrFieldDef{F: &res.Provider}
+	depositFD := &fielddef.BigIntFieldDef{F: &res.Deposit}
+	err := row.Scan(
+		&res.ID,
+		clientFD.FieldPtr(),
+		providerFD.FieldPtr(),
+		&res.Size,
+		&res.StartEpoch,
+		&res.EndEpoch,
+		depositFD.FieldPtr(),
+		&st.Filled,
+		&st.DealCount)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fd := range []fielddef.FieldDefinition{clientFD, providerFD, depositFD} {
+		if err := fd.Unmarshall(); err != nil {
+			return nil, fmt.Errorf("unmarshalling capacity reservation %s: %w", res.ID, err)
+		}
+	}
+	return &st, nil
+}
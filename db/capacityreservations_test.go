@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapacityReservationsDB(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	req.NoError(CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
+
+	rdb := NewCapacityReservationsDB(sqldb)
+
+	client, err := address.NewIDAddress(1)
+	req.NoError(err)
+	prov, err := address.NewIDAddress(2)
+	req.NoError(err)
+
+	r1 := &types.CapacityReservation{
+		ID:         uuid.New(),
+		Client:     client,
+		Provider:   prov,
+		Size:       1000,
+		StartEpoch: 100,
+		EndEpoch:   200,
+		Deposit:    abi.NewTokenAmount(55),
+	}
+	req.NoError(rdb.Insert(ctx, r1))
+
+	st, err := rdb.ByID(ctx, r1.ID)
+	req.NoError(err)
+	req.Equal(*r1, st.Reservation)
+	req.EqualValues(0, st.Filled)
+
+	_, err = rdb.ByID(ctx, uuid.New())
+	req.True(errors.Is(err, ErrNotFound))
+
+	unfilled, err := rdb.Unfilled(ctx, 150)
+	req.NoError(err)
+	req.EqualValues(1000, unfilled)
+
+	// Deal starts outside the reservation window
+	_, err = rdb.Fill(ctx, client, 250, 100)
+	req.True(errors.Is(err, ErrNotFound))
+
+	// Deal is bigger than the reservation
+	_, err = rdb.Fill(ctx, client, 150, 1001)
+	req.True(errors.Is(err, ErrNotFound))
+
+	id, err := rdb.Fill(ctx, client, 150, 600)
+	req.NoError(err)
+	req.Equal(r1.ID, id)
+
+	st, err = rdb.ByID(ctx, r1.ID)
+	req.NoError(err)
+	req.EqualValues(600, st.Filled)
+	req.EqualValues(1, st.DealCount)
+
+	unfilled, err = rdb.Unfilled(ctx, 150)
+	req.NoError(err)
+	req.EqualValues(400, unfilled)
+
+	// Expired reservations are not counted
+	unfilled, err = rdb.Unfilled(ctx, 201)
+	req.NoError(err)
+	req.EqualValues(0, unfilled)
+
+	list, err := rdb.List(ctx)
+	req.NoError(err)
+	req.Len(list, 1)
+
+	// Deleting a reservation frees its capacity
+	req.NoError(rdb.Delete(ctx, r1.ID))
+	_, err = rdb.ByID(ctx, r1.ID)
+	req.True(errors.Is(err, ErrNotFound))
+	unfilled, err = rdb.Unfilled(ctx, 150)
+	req.NoError(err)
+	req.EqualValues(0, unfilled)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/filecoin-project/boost/db/fielddef"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/builtin/v9/market"
 	"github.com/google/uuid"
 	"github.com/graph-gophers/graphql-go"
@@ -78,6 +79,13 @@ func newDealAccessor(db *sql.DB, deal *types.ProviderDealState) *dealAccessor {
 			"CheckpointAt":          &fielddef.FieldDef{F: &deal.CheckpointAt},
 			"Error":                 &fielddef.FieldDef{F: &deal.Err},
 			"Retry":                 &fielddef.FieldDef{F: &deal.Retry},
+			"TransferStartTimeout":  &fielddef.FieldDef{F: &deal.TransferStartTimeout},
+			"TransferTimeout":       &fielddef.FieldDef{F: &deal.TransferTimeout},
+			"CompletionTimeout":     &fielddef.FieldDef{F: &deal.CompletionTimeout},
+			"TransferOrigin":        &fielddef.FieldDef{F: &deal.TransferOrigin},
+			"TransferCompression":   &fielddef.FieldDef{F: &deal.TransferCompression},
+			"TransferWireBytes":     &fielddef.FieldDef{F: &deal.TransferWireBytes},
+			"TransferDecodedBytes":  &fielddef.FieldDef{F: &deal.TransferDecodedBytes},
 
 			// Needed so the deal can be looked up by signed proposal cid
 			"SignedProposalCID": &fielddef.SignedPropFieldDef{Prop: deal.ClientDealProposal},
@@ -250,6 +258,23 @@ func (d *DealsDB) ListCompleted(ctx context.Context) ([]*types.ProviderDealState
 	return d.list(ctx, 0, 0, "Checkpoint = ?", dealcheckpoints.Complete.String())
 }
 
+// PendingVerified returns the number and total piece size of the client's
+// verified deals that have not yet been published on chain. The datacap
+// for these deals has not yet been deducted from the client's datacap.
+func (d *DealsDB) PendingVerified(ctx context.Context, client address.Address) (int, uint64, error) {
+	qry := "SELECT count(*), COALESCE(SUM(PieceSize), 0) FROM Deals " +
+		"WHERE ClientAddress = ? AND VerifiedDeal = ? AND Checkpoint IN (?, ?, ?)"
+	row := d.db.QueryRowContext(ctx, qry, client.String(), true,
+		dealcheckpoints.Accepted.String(), dealcheckpoints.Transferred.String(), dealcheckpoints.Published.String())
+
+	var count int
+	var size uint64
+	if err := row.Scan(&count, &size); err != nil {
+		return 0, 0, fmt.Errorf("getting pending verified deals for client %s: %w", client, err)
+	}
+	return count, size, nil
+}
+
 func (d *DealsDB) List(ctx context.Context, query string, cursor *graphql.ID, offset int, limit int) ([]*types.ProviderDealState, error) {
 	where := ""
 	whereArgs := []interface{}{}
@@ -8,7 +8,9 @@ import (
 
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/stretchr/testify/require"
 )
 
@@ -186,3 +188,48 @@ func TestDealsDBSearch(t *testing.T) {
 		})
 	}
 }
+
+func TestDealsDBPendingVerified(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	require.NoError(t, CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, migrations.Migrate(sqldb))
+
+	db := NewDealsDB(sqldb)
+	deals, err := GenerateDeals()
+	req.NoError(err)
+
+	client := deals[0].ClientDealProposal.Proposal.Client
+	checkpoints := []dealcheckpoints.Checkpoint{
+		dealcheckpoints.Accepted,
+		dealcheckpoints.Published,
+		dealcheckpoints.PublishConfirmed,
+		dealcheckpoints.Complete,
+	}
+	for i, cp := range checkpoints {
+		deal := deals[i]
+		deal.ClientDealProposal.Proposal.Client = client
+		deal.ClientDealProposal.Proposal.VerifiedDeal = true
+		deal.ClientDealProposal.Proposal.PieceSize = abi.PaddedPieceSize(1024 << i)
+		deal.Checkpoint = cp
+		req.NoError(db.Insert(ctx, &deal))
+	}
+	// An unverified deal that is pending publish
+	deal := deals[len(checkpoints)]
+	deal.ClientDealProposal.Proposal.Client = client
+	req.NoError(db.Insert(ctx, &deal))
+
+	count, size, err := db.PendingVerified(ctx, client)
+	req.NoError(err)
+	req.Equal(2, count)
+	req.EqualValues(1024+2048, size)
+
+	other, err := address.NewIDAddress(99999)
+	req.NoError(err)
+	count, size, err = db.PendingVerified(ctx, other)
+	req.NoError(err)
+	req.Zero(count)
+	req.Zero(size)
+}
@@ -92,6 +92,10 @@ func GenerateNDeals(count int) ([]types.ProviderDealState, error) {
 				Checkpoint:  dealcheckpoints.Accepted,
 				Retry:       types.DealRetryAuto,
 				Err:         dealErr,
+
+				TransferStartTimeout: time.Duration(rand.Intn(1000)) * time.Minute,
+				TransferTimeout:      time.Duration(rand.Intn(1000)) * time.Minute,
+				CompletionTimeout:    time.Duration(rand.Intn(1000)) * time.Minute,
 			}
 
 			deals = append(deals, deal)
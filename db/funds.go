@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/filecoin-project/boost/db/fielddef"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/google/uuid"
@@ -28,10 +29,17 @@ func NewFundsDB(db *sql.DB) *FundsDB {
 	return &FundsDB{db: db}
 }
 
-func (f *FundsDB) Tag(ctx context.Context, dealUuid uuid.UUID, collateral abi.TokenAmount, pubMsg abi.TokenAmount) error {
-	qry := "INSERT INTO FundsTagged (DealUUID, CreatedAt, Collateral, PubMsg) "
-	qry += "VALUES (?, ?, ?, ?)"
-	values := []interface{}{dealUuid, time.Now(), collateral.String(), pubMsg.String()}
+// Tag tags funds for a deal with the given provider. The funds may be
+// released once expiresAt has passed (a zero expiresAt means the funds are
+// tagged until they are untagged).
+func (f *FundsDB) Tag(ctx context.Context, dealUuid uuid.UUID, provider address.Address, collateral abi.TokenAmount, pubMsg abi.TokenAmount, expiresAt time.Time) error {
+	var expires sql.NullTime
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt, Valid: true}
+	}
+	qry := "INSERT INTO FundsTagged (DealUUID, CreatedAt, ProviderAddress, Collateral, PubMsg, ExpiresAt) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?)"
+	values := []interface{}{dealUuid, time.Now(), provider.String(), collateral.String(), pubMsg.String(), expires}
 	_, err := f.db.ExecContext(ctx, qry, values...)
 	return err
 }
@@ -147,7 +155,23 @@ type TotalTagged struct {
 }
 
 func (f *FundsDB) TotalTagged(ctx context.Context) (*TotalTagged, error) {
-	rows, err := f.db.QueryContext(ctx, "SELECT Collateral, PubMsg FROM FundsTagged")
+	return f.totalTagged(ctx, address.Undef)
+}
+
+// TotalTaggedForProvider returns the total funds tagged for deals with the
+// given provider
+func (f *FundsDB) TotalTaggedForProvider(ctx context.Context, provider address.Address) (*TotalTagged, error) {
+	return f.totalTagged(ctx, provider)
+}
+
+func (f *FundsDB) totalTagged(ctx context.Context, provider address.Address) (*TotalTagged, error) {
+	qry := "SELECT Collateral, PubMsg FROM FundsTagged"
+	var args []interface{}
+	if provider != address.Undef {
+		qry += " WHERE ProviderAddress = ?"
+		args = append(args, provider.String())
+	}
+	rows, err := f.db.QueryContext(ctx, qry, args...)
 	if err != nil {
 		return nil, fmt.Errorf("getting total tagged: %w", err)
 	}
@@ -188,3 +212,55 @@ func (f *FundsDB) TotalTagged(ctx context.Context) (*TotalTagged, error) {
 
 	return tt, nil
 }
+
+// FundsTagged is the amount of funds tagged for a deal
+type FundsTagged struct {
+	DealUUID   uuid.UUID
+	CreatedAt  time.Time
+	Collateral abi.TokenAmount
+	PubMsg     abi.TokenAmount
+	// The time after which the funds may be released (zero if the funds
+	// don't expire)
+	ExpiresAt time.Time
+}
+
+// ListTagged lists the funds tagged for each deal, oldest first
+func (f *FundsDB) ListTagged(ctx context.Context) ([]FundsTagged, error) {
+	qry := "SELECT DealUUID, CreatedAt, Collateral, PubMsg, ExpiresAt FROM FundsTagged ORDER BY CreatedAt, RowID"
+	rows, err := f.db.QueryContext(ctx, qry)
+	if err != nil {
+		return nil, fmt.Errorf("getting tagged funds: %w", err)
+	}
+	defer rows.Close()
+
+	tagged := make([]FundsTagged, 0, 16)
+	for rows.Next() {
+		var ft FundsTagged
+		collat := &fielddef.BigIntFieldDef{F: &ft.Collateral}
+		pubMsg := &fielddef.BigIntFieldDef{F: &ft.PubMsg}
+		var expiresAt sql.NullTime
+		err := rows.Scan(&ft.DealUUID, &ft.CreatedAt, &collat.Marshalled, &pubMsg.Marshalled, &expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("getting tagged funds: %w", err)
+		}
+		if expiresAt.Valid {
+			ft.ExpiresAt = expiresAt.Time
+		}
+
+		err = collat.Unmarshall()
+		if err != nil {
+			return nil, fmt.Errorf("unmarshalling tagged Collateral: %w", err)
+		}
+		err = pubMsg.Unmarshall()
+		if err != nil {
+			return nil, fmt.Errorf("unmarshalling tagged PubMsg: %w", err)
+		}
+
+		tagged = append(tagged, ft)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting tagged funds: %w", err)
+	}
+
+	return tagged, nil
+}
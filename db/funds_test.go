@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/google/uuid"
 
@@ -18,6 +20,7 @@ func TestFundsDB(t *testing.T) {
 
 	sqldb := CreateTestTmpDB(t)
 	require.NoError(t, CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
 
 	db := NewFundsDB(sqldb)
 	tt, err := db.TotalTagged(ctx)
@@ -31,7 +34,13 @@ func TestFundsDB(t *testing.T) {
 	req.Equal(int64(0), collat.Int64())
 	req.True(pub.IsZero())
 
-	err = db.Tag(ctx, dealUUID, abi.NewTokenAmount(1111), abi.NewTokenAmount(2222))
+	prov1, err := address.NewIDAddress(1001)
+	req.NoError(err)
+	prov2, err := address.NewIDAddress(1002)
+	req.NoError(err)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = db.Tag(ctx, dealUUID, prov1, abi.NewTokenAmount(1111), abi.NewTokenAmount(2222), expiresAt)
 	req.NoError(err)
 
 	tt, err = db.TotalTagged(ctx)
@@ -39,6 +48,42 @@ func TestFundsDB(t *testing.T) {
 	req.Equal(int64(1111), tt.Collateral.Int64())
 	req.Equal(int64(2222), tt.PubMsg.Int64())
 
+	// Funds tagged for a deal with another provider are only counted in
+	// the total for that provider
+	dealUUID2 := uuid.New()
+	err = db.Tag(ctx, dealUUID2, prov2, abi.NewTokenAmount(3333), abi.NewTokenAmount(2222), time.Time{})
+	req.NoError(err)
+
+	tt, err = db.TotalTagged(ctx)
+	req.NoError(err)
+	req.Equal(int64(4444), tt.Collateral.Int64())
+	req.Equal(int64(4444), tt.PubMsg.Int64())
+
+	tt, err = db.TotalTaggedForProvider(ctx, prov1)
+	req.NoError(err)
+	req.Equal(int64(1111), tt.Collateral.Int64())
+
+	tt, err = db.TotalTaggedForProvider(ctx, prov2)
+	req.NoError(err)
+	req.Equal(int64(3333), tt.Collateral.Int64())
+
+	// Funds tagged without an expiry time don't expire
+	tagged, err := db.ListTagged(ctx)
+	req.NoError(err)
+	req.Len(tagged, 2)
+	req.True(tagged[1].ExpiresAt.IsZero())
+
+	_, _, err = db.Untag(ctx, dealUUID2)
+	req.NoError(err)
+
+	tagged, err = db.ListTagged(ctx)
+	req.NoError(err)
+	req.Len(tagged, 1)
+	req.Equal(dealUUID, tagged[0].DealUUID)
+	req.Equal(int64(1111), tagged[0].Collateral.Int64())
+	req.Equal(int64(2222), tagged[0].PubMsg.Int64())
+	req.True(expiresAt.Equal(tagged[0].ExpiresAt))
+
 	collat, pub, err = db.Untag(ctx, dealUUID)
 	req.NoError(err)
 	req.Equal(int64(1111), collat.Int64())
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/ipfs/go-cid"
+)
+
+// The expressions that imports are sorted by, aggregated across the deals
+// for each piece
+var importsSortExprs = map[string]string{
+	"":                       "MIN(CreatedAt)",
+	types.ImportsSortCreated: "MIN(CreatedAt)",
+	types.ImportsSortSize:    "MAX(TransferSize)",
+	types.ImportsSortDeals:   "COUNT(*)",
+}
+
+// ListImports lists the data imported for each piece, aggregated across
+// all the deals for the piece
+func (d *DealsDB) ListImports(ctx context.Context, filter types.ImportsFilter) ([]types.ImportInfo, error) {
+	sortExpr, ok := importsSortExprs[filter.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized sort field '%s'", filter.SortBy)
+	}
+
+	// Select the pieces to list, sorted and limited by the database
+	pieces, err := d.importPieces(ctx, filter, sortExpr)
+	if err != nil {
+		return nil, fmt.Errorf("listing import pieces: %w", err)
+	}
+	if len(pieces) == 0 {
+		return []types.ImportInfo{}, nil
+	}
+
+	// Get all the deals for the selected pieces
+	placeholders := make([]string, 0, len(pieces))
+	args := make([]interface{}, 0, len(pieces))
+	for _, c := range pieces {
+		placeholders = append(placeholders, "?")
+		args = append(args, c.String())
+	}
+	where := "PieceCID IN (" + strings.Join(placeholders, ",") + ")"
+	deals, err := d.list(ctx, 0, 0, where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing deals: %w", err)
+	}
+
+	// Aggregate the deals by piece cid
+	byPiece := make(map[cid.Cid]*types.ImportInfo, len(pieces))
+	for _, deal := range deals {
+		prop := deal.ClientDealProposal.Proposal
+		imp, ok := byPiece[prop.PieceCID]
+		if !ok {
+			imp = &types.ImportInfo{
+				PieceCID:  prop.PieceCID,
+				PieceSize: prop.PieceSize,
+				CreatedAt: deal.CreatedAt,
+				IsOffline: true,
+			}
+			byPiece[prop.PieceCID] = imp
+		}
+
+		imp.DealCount++
+		imp.IsOffline = imp.IsOffline && deal.IsOffline
+		if deal.CreatedAt.Before(imp.CreatedAt) {
+			imp.CreatedAt = deal.CreatedAt
+		}
+		if deal.Transfer.Size > imp.Size {
+			imp.Size = deal.Transfer.Size
+		}
+		if !containsCid(imp.Roots, deal.DealDataRoot) {
+			imp.Roots = append(imp.Roots, deal.DealDataRoot)
+		}
+		if deal.InboundFilePath != "" && !containsString(imp.Paths, deal.InboundFilePath) {
+			imp.Paths = append(imp.Paths, deal.InboundFilePath)
+		}
+	}
+
+	// Return the imports in the order the pieces were selected
+	imports := make([]types.ImportInfo, 0, len(pieces))
+	for _, c := range pieces {
+		if imp, ok := byPiece[c]; ok {
+			imports = append(imports, *imp)
+		}
+	}
+	return imports, nil
+}
+
+// importPieces returns the cids of the pieces that match the filter, in
+// sort order
+func (d *DealsDB) importPieces(ctx context.Context, filter types.ImportsFilter, sortExpr string) ([]cid.Cid, error) {
+	qry := "SELECT PieceCID FROM Deals"
+	var args []interface{}
+	if filter.PieceCID != nil {
+		qry += " WHERE PieceCID=?"
+		args = append(args, filter.PieceCID.String())
+	} else if filter.Root != nil {
+		// Include all deals for pieces that have the root, so that the
+		// deal count is correct
+		qry += " WHERE PieceCID IN (SELECT PieceCID FROM Deals WHERE DealDataRoot=?)"
+		args = append(args, filter.Root.String())
+	}
+	qry += " GROUP BY PieceCID"
+
+	// An import is offline if all of the deals for the piece are offline
+	if filter.IsOffline != nil {
+		qry += " HAVING MIN(IsOffline)=?"
+		args = append(args, *filter.IsOffline)
+	}
+
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	qry += fmt.Sprintf(" ORDER BY %s %s, MIN(CreatedAt) %s", sortExpr, order, order)
+	if filter.Limit > 0 {
+		qry += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pieces []cid.Cid
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, fmt.Errorf("scanning piece cid: %w", err)
+		}
+		c, err := cid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing piece cid %s: %w", s, err)
+		}
+		pieces = append(pieces, c)
+	}
+	return pieces, rows.Err()
+}
+
+func containsCid(cids []cid.Cid, c cid.Cid) bool {
+	for _, e := range cids {
+		if e.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, s string) bool {
+	for _, e := range strs {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListImports(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	req.NoError(CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
+
+	db := NewDealsDB(sqldb)
+	deals, err := GenerateNDeals(3)
+	req.NoError(err)
+
+	// Make the first two deals for the same piece
+	now := time.Now()
+	for i := range deals {
+		deals[i].CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		deals[i].IsOffline = false
+	}
+	deals[1].ClientDealProposal.Proposal.PieceCID = deals[0].ClientDealProposal.Proposal.PieceCID
+	deals[1].IsOffline = true
+	deals[2].IsOffline = true
+	deals[2].Transfer.Size = deals[0].Transfer.Size + deals[1].Transfer.Size + 1
+
+	for _, deal := range deals {
+		req.NoError(db.Insert(ctx, &deal))
+	}
+
+	// By default imports are sorted by creation time, newest first
+	imports, err := db.ListImports(ctx, types.ImportsFilter{})
+	req.NoError(err)
+	req.Len(imports, 2)
+	req.Equal(deals[2].ClientDealProposal.Proposal.PieceCID, imports[0].PieceCID)
+	req.Equal(1, imports[0].DealCount)
+	req.True(imports[0].IsOffline)
+
+	imp := imports[1]
+	req.Equal(deals[0].ClientDealProposal.Proposal.PieceCID, imp.PieceCID)
+	req.Equal(2, imp.DealCount)
+	req.False(imp.IsOffline)
+	req.Len(imp.Roots, 2)
+	req.ElementsMatch([]string{deals[0].InboundFilePath, deals[1].InboundFilePath}, imp.Paths)
+
+	// Filter by root includes all deals for the piece
+	imports, err = db.ListImports(ctx, types.ImportsFilter{Root: &deals[1].DealDataRoot})
+	req.NoError(err)
+	req.Len(imports, 1)
+	req.Equal(2, imports[0].DealCount)
+
+	// Filter by offline
+	offline := false
+	imports, err = db.ListImports(ctx, types.ImportsFilter{IsOffline: &offline})
+	req.NoError(err)
+	req.Len(imports, 1)
+	req.Equal(deals[0].ClientDealProposal.Proposal.PieceCID, imports[0].PieceCID)
+
+	// Sort by deal count ascending, with a limit
+	imports, err = db.ListImports(ctx, types.ImportsFilter{SortBy: types.ImportsSortDeals, Ascending: true, Limit: 1})
+	req.NoError(err)
+	req.Len(imports, 1)
+	req.Equal(1, imports[0].DealCount)
+
+	// Sort by size
+	imports, err = db.ListImports(ctx, types.ImportsFilter{SortBy: types.ImportsSortSize})
+	req.NoError(err)
+	req.Equal(deals[2].Transfer.Size, imports[0].Size)
+
+	// Sort by creation time ascending, with a limit and the offline filter
+	offline = true
+	imports, err = db.ListImports(ctx, types.ImportsFilter{IsOffline: &offline, Ascending: true, Limit: 1})
+	req.NoError(err)
+	req.Len(imports, 1)
+	req.Equal(deals[2].ClientDealProposal.Proposal.PieceCID, imports[0].PieceCID)
+
+	imports, err = db.ListImports(ctx, types.ImportsFilter{Ascending: true, Limit: 1})
+	req.NoError(err)
+	req.Len(imports, 1)
+	req.Equal(deals[0].ClientDealProposal.Proposal.PieceCID, imports[0].PieceCID)
+	req.Equal(2, imports[0].DealCount)
+
+	_, err = db.ListImports(ctx, types.ImportsFilter{SortBy: "foo"})
+	req.Error(err)
+}
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS CapacityReservations (
+    ID TEXT PRIMARY KEY,
+    CreatedAt DateTime,
+    ClientAddress TEXT,
+    ProviderAddress TEXT,
+    Size INT,
+    StartEpoch INT,
+    EndEpoch INT,
+    Deposit TEXT,
+    Filled INT DEFAULT 0 NOT NULL,
+    DealCount INT DEFAULT 0 NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS index_capacityreservations_client on CapacityReservations(ClientAddress);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE CapacityReservations;
+-- +goose StatementEnd
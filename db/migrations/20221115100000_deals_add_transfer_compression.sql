@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE Deals
+    ADD TransferCompression TEXT DEFAULT '' NOT NULL;
+ALTER TABLE Deals
+    ADD TransferWireBytes INT DEFAULT 0 NOT NULL;
+ALTER TABLE Deals
+    ADD TransferDecodedBytes INT DEFAULT 0 NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
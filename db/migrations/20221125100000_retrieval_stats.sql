@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS RetrievalStats (
+    PieceCID TEXT PRIMARY KEY,
+    Retrievals INT DEFAULT 0 NOT NULL,
+    BytesServed INT DEFAULT 0 NOT NULL,
+    LastAccess DateTime
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE RetrievalStats;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE FundsTagged
+    ADD ProviderAddress TEXT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE StorageTagged
+    ADD ProviderAddress TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
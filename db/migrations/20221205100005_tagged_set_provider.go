@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(UpSetTaggedProviderAddress, DownSetTaggedProviderAddress)
+}
+
+// UpSetTaggedProviderAddress sets the provider address of the funds and
+// storage tagged for each deal to the address of the deal's provider
+func UpSetTaggedProviderAddress(tx *sql.Tx) error {
+	for _, table := range []string{"FundsTagged", "StorageTagged"} {
+		qry := "UPDATE " + table + " SET ProviderAddress = " +
+			"(SELECT Deals.ProviderAddress FROM Deals WHERE Deals.ID = " + table + ".DealUUID)"
+		if _, err := tx.Exec(qry); err != nil {
+			return fmt.Errorf("setting %s.ProviderAddress: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func DownSetTaggedProviderAddress(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	// Do nothing because sqlite doesn't support removing a column.
+	return nil
+}
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS DealAnnotations (
+    DealUUID TEXT NOT NULL,
+    Key TEXT NOT NULL,
+    Value TEXT NOT NULL,
+    UpdatedAt DateTime NOT NULL,
+    PRIMARY KEY (DealUUID, Key)
+);
+
+CREATE INDEX IF NOT EXISTS index_deal_annotations_key on DealAnnotations(Key);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE DealAnnotations;
+-- +goose StatementEnd
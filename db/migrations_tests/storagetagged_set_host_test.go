@@ -18,10 +18,12 @@ func TestStorageTaggedSetHost(t *testing.T) {
 	sqldb := db.CreateTestTmpDB(t)
 	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
 
-	// Run migrations up to the one that adds the TransferHost field to StorageTagged
-	goose.SetBaseFS(migrations.EmbedMigrations)
-	req.NoError(goose.SetDialect("sqlite3"))
-	req.NoError(goose.UpTo(sqldb, ".", 20220908122510))
+	// Run all migrations so that deals can be inserted with the latest
+	// schema, then roll back to the one that adds the TransferHost field to
+	// StorageTagged. Rolling back the later migrations that add columns
+	// leaves the columns in place, because sqlite can't remove them.
+	req.NoError(migrations.Migrate(sqldb))
+	req.NoError(goose.DownTo(sqldb, ".", 20220908122510))
 
 	// Generate 2 deals
 	dealsDB := db.NewDealsDB(sqldb)
@@ -44,12 +46,15 @@ func TestStorageTaggedSetHost(t *testing.T) {
 
 	// Simulate tagging a deal
 	taggedStorageDB := db.NewStorageDB(sqldb)
-	err = taggedStorageDB.Tag(ctx, deals[0].DealUuid, 1024, "")
+	err = taggedStorageDB.Tag(ctx, deals[0].DealUuid, deals[0].ClientDealProposal.Proposal.Provider, 1024, "")
 	req.NoError(err)
 
 	// Run the migration that reads the deal transfer params and sets
 	// StorageTagged.TransferHost
 	req.NoError(goose.UpByOne(sqldb, "."))
+	ver, err := goose.GetDBVersion(sqldb)
+	req.NoError(err)
+	req.EqualValues(20220908122516, ver)
 
 	// Check that after migrating up, the host is set correctly
 	rows, err := sqldb.QueryContext(ctx, "SELECT TransferHost FROM StorageTagged")
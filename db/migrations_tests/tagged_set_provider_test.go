@@ -0,0 +1,62 @@
+package migrations_tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaggedSetProviderAddress(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+
+	// Run all migrations so that deals can be inserted with the latest
+	// schema. The migration under test is run again manually below.
+	req.NoError(migrations.Migrate(sqldb))
+
+	dealsDB := db.NewDealsDB(sqldb)
+	deals, err := db.GenerateNDeals(1)
+	req.NoError(err)
+	deal := deals[0]
+	req.NoError(dealsDB.Insert(ctx, &deal))
+
+	// Simulate tagging funds and storage for the deal
+	fundsDB := db.NewFundsDB(sqldb)
+	err = fundsDB.Tag(ctx, deal.DealUuid, address.Undef, abi.NewTokenAmount(1), abi.NewTokenAmount(2), time.Time{})
+	req.NoError(err)
+	storageDB := db.NewStorageDB(sqldb)
+	err = storageDB.Tag(ctx, deal.DealUuid, address.Undef, 1024, "files.org:1000")
+	req.NoError(err)
+
+	// Clear the provider address to simulate the state before the migration
+	_, err = sqldb.ExecContext(ctx, "UPDATE FundsTagged SET ProviderAddress = NULL")
+	req.NoError(err)
+	_, err = sqldb.ExecContext(ctx, "UPDATE StorageTagged SET ProviderAddress = NULL")
+	req.NoError(err)
+
+	// Run the migration that sets the provider address from the deal
+	tx, err := sqldb.BeginTx(ctx, nil)
+	req.NoError(err)
+	req.NoError(migrations.UpSetTaggedProviderAddress(tx))
+	req.NoError(tx.Commit())
+
+	// Check that after migrating up, the tagged funds and storage are
+	// counted against the deal's provider
+	prov := deal.ClientDealProposal.Proposal.Provider
+	funds, err := fundsDB.TotalTaggedForProvider(ctx, prov)
+	req.NoError(err)
+	req.EqualValues(1, funds.Collateral.Int64())
+
+	storage, err := storageDB.TotalTaggedForProvider(ctx, prov)
+	req.NoError(err)
+	req.EqualValues(1024, storage)
+}
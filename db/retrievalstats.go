@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+)
+
+// RetrievalStatsDB keeps a count of the retrievals served for each piece
+type RetrievalStatsDB struct {
+	db *sql.DB
+}
+
+func NewRetrievalStatsDB(db *sql.DB) *RetrievalStatsDB {
+	return &RetrievalStatsDB{db: db}
+}
+
+// Record adds a completed retrieval of the piece that served the given number
+// of bytes
+func (r *RetrievalStatsDB) Record(ctx context.Context, pieceCid cid.Cid, bytesServed uint64, at time.Time) error {
+	qry := "INSERT INTO RetrievalStats (PieceCID, Retrievals, BytesServed, LastAccess) VALUES (?, 1, ?, ?) "
+	qry += "ON CONFLICT(PieceCID) DO UPDATE SET Retrievals = Retrievals + 1, "
+	qry += "BytesServed = BytesServed + excluded.BytesServed, LastAccess = excluded.LastAccess"
+	if _, err := r.db.ExecContext(ctx, qry, pieceCid.String(), bytesServed, at); err != nil {
+		return fmt.Errorf("recording retrieval of piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// AddBytes adds bytes served from the piece outside of a retrieval with a
+// defined start and end (ie blocks served over bitswap)
+func (r *RetrievalStatsDB) AddBytes(ctx context.Context, pieceCid cid.Cid, bytesServed uint64, at time.Time) error {
+	qry := "INSERT INTO RetrievalStats (PieceCID, Retrievals, BytesServed, LastAccess) VALUES (?, 0, ?, ?) "
+	qry += "ON CONFLICT(PieceCID) DO UPDATE SET "
+	qry += "BytesServed = BytesServed + excluded.BytesServed, LastAccess = excluded.LastAccess"
+	if _, err := r.db.ExecContext(ctx, qry, pieceCid.String(), bytesServed, at); err != nil {
+		return fmt.Errorf("adding bytes served from piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// ByClient returns the retrieval statistics of each piece in the client's
+// deals, including pieces that have never been retrieved
+func (r *RetrievalStatsDB) ByClient(ctx context.Context, client address.Address) ([]types.PieceRetrievalStats, error) {
+	qry := "SELECT d.PieceCID, COALESCE(s.Retrievals, 0), COALESCE(s.BytesServed, 0), s.LastAccess "
+	qry += "FROM (SELECT DISTINCT PieceCID FROM Deals WHERE ClientAddress = ?) d "
+	qry += "LEFT JOIN RetrievalStats s ON s.PieceCID = d.PieceCID ORDER BY d.PieceCID"
+	rows, err := r.db.QueryContext(ctx, qry, client.String())
+	if err != nil {
+		return nil, fmt.Errorf("getting retrieval stats for client %s: %w", client, err)
+	}
+	defer rows.Close()
+
+	var list []types.PieceRetrievalStats
+	for rows.Next() {
+		var pieceCid string
+		var lastAccess sql.NullTime
+		var st types.PieceRetrievalStats
+		if err := rows.Scan(&pieceCid, &st.Retrievals, &st.BytesServed, &lastAccess); err != nil {
+			return nil, err
+		}
+		st.PieceCid, err = cid.Parse(pieceCid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing piece cid %s: %w", pieceCid, err)
+		}
+		if lastAccess.Valid {
+			st.LastAccess = lastAccess.Time.Unix()
+		}
+		list = append(list, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
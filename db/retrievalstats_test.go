@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrievalStatsDB(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := CreateTestTmpDB(t)
+	req.NoError(CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
+
+	// Make two deals for the same piece and one for another piece with the
+	// same client
+	deals, err := GenerateDeals()
+	req.NoError(err)
+	client := deals[0].ClientDealProposal.Proposal.Client
+	deals[1].ClientDealProposal.Proposal.Client = client
+	deals[1].ClientDealProposal.Proposal.PieceCID = deals[0].ClientDealProposal.Proposal.PieceCID
+	deals[2].ClientDealProposal.Proposal.Client = client
+	otherPiece := testutil.GenerateCid()
+	deals[2].ClientDealProposal.Proposal.PieceCID = otherPiece
+	dealsDB := NewDealsDB(sqldb)
+	for i := range deals[:3] {
+		req.NoError(dealsDB.Insert(ctx, &deals[i]))
+	}
+
+	sdb := NewRetrievalStatsDB(sqldb)
+	piece := deals[0].ClientDealProposal.Proposal.PieceCID
+	now := time.Now()
+	req.NoError(sdb.Record(ctx, piece, 100, now.Add(-time.Hour)))
+	req.NoError(sdb.Record(ctx, piece, 50, now.Add(-time.Minute)))
+	// Blocks served over bitswap add to the bytes served, but aren't
+	// counted as retrievals
+	req.NoError(sdb.AddBytes(ctx, piece, 25, now))
+	// Retrievals of another client's piece are not included
+	req.NoError(sdb.Record(ctx, testutil.GenerateCid(), 10, now))
+
+	stats, err := sdb.ByClient(ctx, client)
+	req.NoError(err)
+	req.Len(stats, 2)
+	byPiece := make(map[string]int)
+	for i, st := range stats {
+		byPiece[st.PieceCid.String()] = i
+	}
+
+	st := stats[byPiece[piece.String()]]
+	req.EqualValues(2, st.Retrievals)
+	req.EqualValues(175, st.BytesServed)
+	req.Equal(now.Unix(), st.LastAccess)
+
+	// A piece that has never been retrieved
+	st = stats[byPiece[otherPiece.String()]]
+	req.EqualValues(0, st.Retrievals)
+	req.EqualValues(0, st.BytesServed)
+	req.EqualValues(0, st.LastAccess)
+
+	// A piece that has only been served over bitswap
+	req.NoError(sdb.AddBytes(ctx, otherPiece, 10, now))
+	stats, err = sdb.ByClient(ctx, client)
+	req.NoError(err)
+	st = stats[byPiece[otherPiece.String()]]
+	req.EqualValues(0, st.Retrievals)
+	req.EqualValues(10, st.BytesServed)
+	req.Equal(now.Unix(), st.LastAccess)
+
+	unknown, err := address.NewIDAddress(999)
+	req.NoError(err)
+	stats, err = sdb.ByClient(ctx, unknown)
+	req.NoError(err)
+	req.Empty(stats)
+}
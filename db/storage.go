@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/filecoin-project/boost/db/fielddef"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/google/uuid"
 )
@@ -26,10 +27,12 @@ func NewStorageDB(db *sql.DB) *StorageDB {
 	return &StorageDB{db: db}
 }
 
-func (s *StorageDB) Tag(ctx context.Context, dealUuid uuid.UUID, size uint64, host string) error {
-	qry := "INSERT INTO StorageTagged (DealUUID, CreatedAt, TransferSize, TransferHost) "
-	qry += "VALUES (?, ?, ?, ?)"
-	values := []interface{}{dealUuid, time.Now(), fmt.Sprintf("%d", size), host}
+// Tag tags storage space for a deal with the given provider, that downloads
+// the deal data from the given host
+func (s *StorageDB) Tag(ctx context.Context, dealUuid uuid.UUID, provider address.Address, size uint64, host string) error {
+	qry := "INSERT INTO StorageTagged (DealUUID, CreatedAt, ProviderAddress, TransferSize, TransferHost) "
+	qry += "VALUES (?, ?, ?, ?, ?)"
+	values := []interface{}{dealUuid, time.Now(), provider.String(), fmt.Sprintf("%d", size), host}
 	_, err := s.db.ExecContext(ctx, qry, values...)
 	return err
 }
@@ -113,19 +116,25 @@ func (s *StorageDB) Logs(ctx context.Context) ([]StorageLog, error) {
 }
 
 func (s *StorageDB) TotalTaggedForHost(ctx context.Context, host string) (uint64, error) {
-	return s.totalTagged(ctx, host)
+	return s.totalTagged(ctx, "TransferHost", host)
+}
+
+// TotalTaggedForProvider returns the total storage space tagged for deals
+// with the given provider
+func (s *StorageDB) TotalTaggedForProvider(ctx context.Context, provider address.Address) (uint64, error) {
+	return s.totalTagged(ctx, "ProviderAddress", provider.String())
 }
 
 func (s *StorageDB) TotalTagged(ctx context.Context) (uint64, error) {
-	return s.totalTagged(ctx, "")
+	return s.totalTagged(ctx, "", "")
 }
 
-func (s *StorageDB) totalTagged(ctx context.Context, host string) (uint64, error) {
+func (s *StorageDB) totalTagged(ctx context.Context, column string, value string) (uint64, error) {
 	qry := "SELECT TransferSize FROM StorageTagged"
 	var args []interface{}
-	if host != "" {
-		qry += " WHERE TransferHost = ?"
-		args = append(args, host)
+	if column != "" {
+		qry += " WHERE " + column + " = ?"
+		args = append(args, value)
 	}
 	rows, err := s.db.QueryContext(ctx, qry, args...)
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/go-address"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
@@ -29,11 +30,16 @@ func TestStorageDB(t *testing.T) {
 	req.True(errors.Is(err, ErrNotFound))
 	req.Equal(uint64(0), amt)
 
-	err = db.Tag(ctx, dealUUID, 1111, "foo.bar:1234")
+	prov1, err := address.NewIDAddress(1001)
+	req.NoError(err)
+	prov2, err := address.NewIDAddress(1002)
+	req.NoError(err)
+
+	err = db.Tag(ctx, dealUUID, prov1, 1111, "foo.bar:1234")
 	req.NoError(err)
 
 	dealUUID2 := uuid.New()
-	err = db.Tag(ctx, dealUUID2, 2222, "my.host:5678")
+	err = db.Tag(ctx, dealUUID2, prov2, 2222, "my.host:5678")
 	req.NoError(err)
 
 	total, err := db.TotalTagged(ctx)
@@ -44,6 +50,10 @@ func TestStorageDB(t *testing.T) {
 	req.NoError(err)
 	req.Equal(uint64(2222), total)
 
+	total, err = db.TotalTaggedForProvider(ctx, prov1)
+	req.NoError(err)
+	req.Equal(uint64(1111), total)
+
 	amt, err = db.Untag(ctx, dealUUID)
 	req.NoError(err)
 	req.Equal(uint64(1111), amt)
@@ -0,0 +1,130 @@
+package fdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/filecoin-project/boost/cmd/boostd-data/model"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multihash"
+)
+
+// Keys are built by concatenating a table prefix with binary CIDs and
+// multihashes. Both are self-delimiting, so no separator is needed.
+var (
+	// PieceCid -> json encoded model.Metadata
+	prefixPieceCidToMetadata = []byte("m/")
+
+	// PieceCid + Multihash -> uvarint encoded offset of the block in the piece
+	prefixOffsets = []byte("o/")
+
+	// Multihash + PieceCid -> empty value
+	// Lists the pieces that contain a multihash with a range read.
+	prefixMhToPieceCids = []byte("p/")
+)
+
+// The number of index records written per transaction, keeping each
+// transaction well under FoundationDB's 10MB write limit
+const recordsPerTx = 5000
+
+// The number of keys read per transaction when reading a range
+const rangeReadBatch = 10000
+
+func key(prefix []byte, parts ...[]byte) []byte {
+	k := append([]byte{}, prefix...)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+func metadataKey(pieceCid cid.Cid) []byte {
+	return key(prefixPieceCidToMetadata, pieceCid.Bytes())
+}
+
+func offsetsPrefix(pieceCid cid.Cid) []byte {
+	return key(prefixOffsets, pieceCid.Bytes())
+}
+
+func offsetKey(pieceCid cid.Cid, m multihash.Multihash) []byte {
+	return key(prefixOffsets, pieceCid.Bytes(), m)
+}
+
+func mhToPieceCidKey(m multihash.Multihash, pieceCid cid.Cid) []byte {
+	return key(prefixMhToPieceCids, m, pieceCid.Bytes())
+}
+
+func encodeOffset(offset uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, offset)
+	return buf[:n]
+}
+
+func decodeOffset(b []byte) (uint64, error) {
+	offset, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid offset value %x", b)
+	}
+	return offset, nil
+}
+
+// getMetadata returns the metadata of a piece, or ds.ErrNotFound
+func getMetadata(tx Tx, pieceCid cid.Cid) (model.Metadata, error) {
+	var md model.Metadata
+
+	b, err := tx.Get(metadataKey(pieceCid))
+	if err != nil {
+		return md, err
+	}
+	if b == nil {
+		return md, ds.ErrNotFound
+	}
+
+	if err := json.Unmarshal(b, &md); err != nil {
+		return md, fmt.Errorf("failed to unmarshal metadata for piece %s: %w", pieceCid, err)
+	}
+	return md, nil
+}
+
+func setMetadata(tx Tx, pieceCid cid.Cid, md model.Metadata) error {
+	b, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	tx.Set(metadataKey(pieceCid), b)
+	return nil
+}
+
+// readRange reads every key with the given prefix, in batches of one
+// transaction each, calling f for each key-value
+func readRange(kv KV, prefix []byte, f func(KeyValue) error) error {
+	begin := prefix
+	end := prefixEnd(prefix)
+	for {
+		var kvs []KeyValue
+		err := kv.Transact(func(tx Tx) error {
+			var err error
+			kvs, err = tx.GetRange(begin, end, rangeReadBatch)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, kv := range kvs {
+			if err := f(kv); err != nil {
+				return err
+			}
+		}
+
+		if len(kvs) < rangeReadBatch {
+			return nil
+		}
+
+		// continue from the key after the last one read
+		begin = key(kvs[len(kvs)-1].Key, []byte{0})
+	}
+}
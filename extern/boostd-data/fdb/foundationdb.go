@@ -0,0 +1,70 @@
+//go:build fdb
+// +build fdb
+
+package fdb
+
+import (
+	"fmt"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+)
+
+// The FoundationDB client library (libfdb_c) must be installed to build
+// with the fdb tag. 710 is the API version of FoundationDB 7.1.
+const apiVersion = 710
+
+// Open connects to the FoundationDB cluster described by clusterFile, or
+// by the default cluster file if clusterFile is empty
+func Open(clusterFile string) (KV, error) {
+	if err := fdb.APIVersion(apiVersion); err != nil {
+		return nil, fmt.Errorf("selecting FoundationDB API version %d: %w", apiVersion, err)
+	}
+
+	db, err := fdb.OpenDatabase(clusterFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening FoundationDB database (cluster file %q): %w", clusterFile, err)
+	}
+
+	return &foundationDB{db: db}, nil
+}
+
+type foundationDB struct {
+	db fdb.Database
+}
+
+func (f *foundationDB) Transact(fn func(tx Tx) error) error {
+	_, err := f.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		return nil, fn(&foundationTx{tr: tr})
+	})
+	return err
+}
+
+type foundationTx struct {
+	tr fdb.Transaction
+}
+
+func (t *foundationTx) Get(key []byte) ([]byte, error) {
+	return t.tr.Get(fdb.Key(key)).Get()
+}
+
+func (t *foundationTx) Set(key, value []byte) {
+	t.tr.Set(fdb.Key(key), value)
+}
+
+func (t *foundationTx) Clear(key []byte) {
+	t.tr.Clear(fdb.Key(key))
+}
+
+func (t *foundationTx) GetRange(begin, end []byte, limit int) ([]KeyValue, error) {
+	rng := fdb.KeyRange{Begin: fdb.Key(begin), End: fdb.Key(end)}
+	res, err := t.tr.GetRange(rng, fdb.RangeOptions{Limit: limit}).GetSliceWithError()
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := make([]KeyValue, 0, len(res))
+	for _, kv := range res {
+		kvs = append(kvs, KeyValue{Key: kv.Key, Value: kv.Value})
+	}
+	return kvs, nil
+}
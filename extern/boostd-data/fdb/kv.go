@@ -0,0 +1,39 @@
+package fdb
+
+// KV is an ordered, transactional key-value store with the semantics of
+// FoundationDB: a transaction either commits all of its writes or none of
+// them, and is limited in size (10MB of writes) and duration (5s), so large
+// operations must be split across several transactions.
+type KV interface {
+	// Transact runs f in a transaction and commits it if f returns nil.
+	// f may be run more than once if the transaction conflicts.
+	Transact(f func(tx Tx) error) error
+}
+
+// Tx is a single KV transaction
+type Tx interface {
+	// Get returns the value of key, or nil if the key is not set
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte)
+	Clear(key []byte)
+	// GetRange returns up to limit key-values with begin <= key < end, in
+	// key order
+	GetRange(begin, end []byte, limit int) ([]KeyValue, error)
+}
+
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// prefixEnd returns the first key after every key that starts with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0xff}
+}
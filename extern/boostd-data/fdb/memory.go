@@ -0,0 +1,89 @@
+package fdb
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// memoryKV is an in-memory KV, used in tests and to run boostd-data
+// without a FoundationDB cluster during development
+type memoryKV struct {
+	lk   sync.Mutex
+	data map[string][]byte
+}
+
+func NewMemoryKV() KV {
+	return &memoryKV{data: make(map[string][]byte)}
+}
+
+func (m *memoryKV) Transact(f func(tx Tx) error) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	tx := &memoryTx{kv: m, writes: make(map[string][]byte)}
+	if err := f(tx); err != nil {
+		return err
+	}
+
+	// commit
+	for k, v := range tx.writes {
+		if v == nil {
+			delete(m.data, k)
+		} else {
+			m.data[k] = v
+		}
+	}
+	return nil
+}
+
+// memoryTx buffers writes until the transaction commits. A nil value in
+// writes means the key was cleared.
+type memoryTx struct {
+	kv     *memoryKV
+	writes map[string][]byte
+}
+
+func (tx *memoryTx) Get(key []byte) ([]byte, error) {
+	if v, ok := tx.writes[string(key)]; ok {
+		return v, nil
+	}
+	return tx.kv.data[string(key)], nil
+}
+
+func (tx *memoryTx) Set(key, value []byte) {
+	tx.writes[string(key)] = append([]byte{}, value...)
+}
+
+func (tx *memoryTx) Clear(key []byte) {
+	tx.writes[string(key)] = nil
+}
+
+func (tx *memoryTx) GetRange(begin, end []byte, limit int) ([]KeyValue, error) {
+	inRange := func(k string) bool {
+		return bytes.Compare([]byte(k), begin) >= 0 && bytes.Compare([]byte(k), end) < 0
+	}
+
+	var keys []string
+	for k := range tx.kv.data {
+		if _, written := tx.writes[k]; !written && inRange(k) {
+			keys = append(keys, k)
+		}
+	}
+	for k, v := range tx.writes {
+		if v != nil && inRange(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	kvs := make([]KeyValue, 0, len(keys))
+	for _, k := range keys {
+		v, _ := tx.Get([]byte(k))
+		kvs = append(kvs, KeyValue{Key: []byte(k), Value: v})
+	}
+	return kvs, nil
+}
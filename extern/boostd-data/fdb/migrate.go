@@ -0,0 +1,64 @@
+package fdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/boost/cmd/boostd-data/ldb"
+)
+
+// MigrateFromLevelDB copies the index and deals of every piece in the
+// leveldb store to the FoundationDB store, and returns the number of pieces
+// that were copied. The indexed-at time of each piece is kept. Pieces that
+// are already indexed in the FoundationDB store are not indexed again, so an
+// interrupted migration can be resumed by running it again.
+func MigrateFromLevelDB(ctx context.Context, src *ldb.Store, dst *Store) (int, error) {
+	pcids, err := src.ListPieces()
+	if err != nil {
+		return 0, fmt.Errorf("listing pieces in leveldb store: %w", err)
+	}
+
+	log.Infow("migrating pieces from leveldb", "pieces", len(pcids))
+
+	migrated := 0
+	for i, pieceCid := range pcids {
+		if ctx.Err() != nil {
+			return migrated, ctx.Err()
+		}
+
+		indexedAt, err := src.IndexedAt(pieceCid)
+		if err != nil {
+			return migrated, fmt.Errorf("getting indexed-at time of piece %s: %w", pieceCid, err)
+		}
+
+		dstIndexedAt, err := dst.IndexedAt(pieceCid)
+		if err != nil {
+			return migrated, fmt.Errorf("getting indexed-at time of piece %s in fdb: %w", pieceCid, err)
+		}
+
+		if !indexedAt.IsZero() && dstIndexedAt.IsZero() {
+			records, err := src.GetRecords(pieceCid)
+			if err != nil {
+				return migrated, fmt.Errorf("getting index of piece %s: %w", pieceCid, err)
+			}
+			if err := dst.addIndex(pieceCid, records, indexedAt); err != nil {
+				return migrated, fmt.Errorf("adding index of piece %s: %w", pieceCid, err)
+			}
+		}
+
+		deals, err := src.GetPieceDeals(pieceCid)
+		if err != nil {
+			return migrated, fmt.Errorf("getting deals of piece %s: %w", pieceCid, err)
+		}
+		for _, d := range deals {
+			if err := dst.AddDealForPiece(pieceCid, d); err != nil {
+				return migrated, fmt.Errorf("adding deal %s of piece %s: %w", d.DealUuid, pieceCid, err)
+			}
+		}
+
+		migrated++
+		log.Infow("migrated piece", "piece-cid", pieceCid, "deals", len(deals), "progress", fmt.Sprintf("%d/%d", i+1, len(pcids)))
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,12 @@
+//go:build !fdb
+// +build !fdb
+
+package fdb
+
+import "errors"
+
+// Open fails when boostd-data is built without the fdb tag, because the
+// FoundationDB bindings need the FoundationDB client library (libfdb_c)
+func Open(clusterFile string) (KV, error) {
+	return nil, errors.New("boostd-data was built without FoundationDB support: install the FoundationDB client library and rebuild with -tags fdb")
+}
@@ -0,0 +1,269 @@
+package fdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/cmd/boostd-data/model"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("boostd-data-fdb")
+
+// Store is a piece directory backed by FoundationDB. Unlike the leveldb
+// store it can be shared by several boostd-data instances, so it relies on
+// FoundationDB transactions rather than a process-wide lock.
+type Store struct {
+	kv KV
+}
+
+// NewStore opens the FoundationDB cluster described by clusterFile (the
+// default cluster file if empty)
+func NewStore(clusterFile string) (*Store, error) {
+	kv, err := Open(clusterFile)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugw("new piece meta service", "cluster file", clusterFile)
+
+	return NewStoreWithKV(kv), nil
+}
+
+func NewStoreWithKV(kv KV) *Store {
+	return &Store{kv: kv}
+}
+
+func (s *Store) AddDealForPiece(pieceCid cid.Cid, dealInfo model.DealInfo) error {
+	log.Debugw("handle.add-deal-for-piece", "piece-cid", pieceCid)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.add-deal-for-piece", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	return s.kv.Transact(func(tx Tx) error {
+		md, err := getMetadata(tx, pieceCid)
+		if err != nil && !errors.Is(err, ds.ErrNotFound) {
+			return err
+		}
+
+		// the same deal may be added again, eg when a migration is resumed
+		for _, d := range md.Deals {
+			if d.DealUuid == dealInfo.DealUuid {
+				return nil
+			}
+		}
+
+		md.Deals = append(md.Deals, dealInfo)
+		return setMetadata(tx, pieceCid, md)
+	})
+}
+
+func (s *Store) GetRecords(pieceCid cid.Cid) ([]model.Record, error) {
+	log.Debugw("handle.get-iterable-index", "piece-cid", pieceCid)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-iterable-index", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	return s.records(pieceCid)
+}
+
+func (s *Store) GetOffset(pieceCid cid.Cid, hash mh.Multihash) (uint64, error) {
+	log.Debugw("handle.get-offset", "piece-cid", pieceCid)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-offset", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	var offset uint64
+	err := s.kv.Transact(func(tx Tx) error {
+		b, err := tx.Get(offsetKey(pieceCid, hash))
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return fmt.Errorf("no offset for multihash %s in piece %s: %w", hash, pieceCid, ds.ErrNotFound)
+		}
+
+		offset, err = decodeOffset(b)
+		return err
+	})
+	return offset, err
+}
+
+func (s *Store) GetPieceDeals(pieceCid cid.Cid) ([]model.DealInfo, error) {
+	log.Debugw("handle.get-piece-deals", "piece-cid", pieceCid)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-piece-deals", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	var md model.Metadata
+	err := s.kv.Transact(func(tx Tx) error {
+		var err error
+		md, err = getMetadata(tx, pieceCid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return md.Deals, nil
+}
+
+// Get all pieces that contain a multihash (used when retrieving by payload CID)
+func (s *Store) PiecesContainingMultihash(m mh.Multihash) ([]cid.Cid, error) {
+	log.Debugw("handle.pieces-containing-mh", "mh", m)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.pieces-containing-mh", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	prefix := key(prefixMhToPieceCids, m)
+
+	var pcids []cid.Cid
+	err := readRange(s.kv, prefix, func(kv KeyValue) error {
+		pieceCid, err := cid.Cast(kv.Key[len(prefix):])
+		if err != nil {
+			return fmt.Errorf("parsing piece cid in key %x: %w", kv.Key, err)
+		}
+		pcids = append(pcids, pieceCid)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pcids) == 0 {
+		return nil, fmt.Errorf("no pieces contain multihash %s: %w", m, ds.ErrNotFound)
+	}
+	return pcids, nil
+}
+
+func (s *Store) GetIndex(pieceCid cid.Cid) ([]model.Record, error) {
+	log.Debugw("handle.get-index", "pieceCid", pieceCid)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.get-index", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	records, err := s.records(pieceCid)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugw("handle.get-index.records", "len(records)", len(records))
+
+	return records, nil
+}
+
+func (s *Store) records(pieceCid cid.Cid) ([]model.Record, error) {
+	var indexedAt time.Time
+	err := s.kv.Transact(func(tx Tx) error {
+		md, err := getMetadata(tx, pieceCid)
+		indexedAt = md.IndexedAt
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if indexedAt.IsZero() {
+		return nil, fmt.Errorf("piece %s is not indexed: %w", pieceCid, ds.ErrNotFound)
+	}
+
+	prefix := offsetsPrefix(pieceCid)
+
+	var records []model.Record
+	err = readRange(s.kv, prefix, func(kv KeyValue) error {
+		m, err := mh.Cast(kv.Key[len(prefix):])
+		if err != nil {
+			return fmt.Errorf("parsing multihash in key %x: %w", kv.Key, err)
+		}
+
+		offset, err := decodeOffset(kv.Value)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, model.Record{
+			Cid:    cid.NewCidV1(cid.Raw, m),
+			Offset: offset,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (s *Store) AddIndex(pieceCid cid.Cid, records []model.Record) error {
+	log.Debugw("handle.add-index", "records", len(records))
+
+	defer func(now time.Time) {
+		log.Debugw("handled.add-index", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	return s.addIndex(pieceCid, records, time.Now())
+}
+
+// addIndex writes the records in batches, one transaction per batch, and
+// only then marks the piece as indexed, so that a piece whose index was
+// partially written is never reported as indexed
+func (s *Store) addIndex(pieceCid cid.Cid, records []model.Record, indexedAt time.Time) error {
+	for start := 0; start < len(records); start += recordsPerTx {
+		end := start + recordsPerTx
+		if end > len(records) {
+			end = len(records)
+		}
+
+		err := s.kv.Transact(func(tx Tx) error {
+			for _, r := range records[start:end] {
+				m := r.Cid.Hash()
+				tx.Set(offsetKey(pieceCid, m), encodeOffset(r.Offset))
+				tx.Set(mhToPieceCidKey(m, pieceCid), []byte{})
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add records %d-%d of piece %s: %w", start, end, pieceCid, err)
+		}
+	}
+
+	// mark that indexing is complete
+	return s.kv.Transact(func(tx Tx) error {
+		md, err := getMetadata(tx, pieceCid)
+		if err != nil && !errors.Is(err, ds.ErrNotFound) {
+			return err
+		}
+
+		md.IndexedAt = indexedAt
+		return setMetadata(tx, pieceCid, md)
+	})
+}
+
+func (s *Store) IndexedAt(pieceCid cid.Cid) (time.Time, error) {
+	log.Debugw("handle.indexed-at", "pieceCid", pieceCid)
+
+	defer func(now time.Time) {
+		log.Debugw("handled.indexed-at", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	var md model.Metadata
+	err := s.kv.Transact(func(tx Tx) error {
+		var err error
+		md, err = getMetadata(tx, pieceCid)
+		return err
+	})
+	if err != nil && !errors.Is(err, ds.ErrNotFound) {
+		return time.Time{}, err
+	}
+
+	return md.IndexedAt, nil
+}
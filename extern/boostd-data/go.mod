@@ -3,6 +3,7 @@ module github.com/filecoin-project/boost/cmd/boostd-data
 go 1.18
 
 require (
+	github.com/apple/foundationdb/bindings/go v0.0.0-20250116223954-78cf3bf80071
 	github.com/couchbase/gocb/v2 v2.5.0
 	github.com/docker/docker v20.10.7+incompatible
 	github.com/docker/go-connections v0.4.0
@@ -16,6 +16,7 @@ import (
 	"github.com/multiformats/go-multihash"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var (
@@ -209,6 +210,32 @@ func (db *DB) GetPieceCidToMetadata(ctx context.Context, pieceCid cid.Cid) (mode
 	return metadata, nil
 }
 
+// ListPieceCids
+func (db *DB) ListPieceCids(ctx context.Context) ([]cid.Cid, error) {
+	// The PieceCid is concatenated to the table prefix without a "/", so the
+	// table can't be listed with a datastore query, which only matches whole
+	// path segments. Iterate over the underlying leveldb instead.
+	lds, ok := db.Batching.(*levelds.Datastore)
+	if !ok {
+		return nil, fmt.Errorf("listing piece cids: unexpected datastore type %T", db.Batching)
+	}
+
+	prefix := datastore.NewKey(sprefixPieceCidToCursor).String()
+	iter := lds.DB.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var pcids []cid.Cid
+	for iter.Next() {
+		pieceCid, err := cid.Parse(string(iter.Key()[len(prefix):]))
+		if err != nil {
+			return nil, fmt.Errorf("parsing piece cid in key %q: %w", iter.Key(), err)
+		}
+		pcids = append(pcids, pieceCid)
+	}
+
+	return pcids, iter.Error()
+}
+
 // AllRecords
 func (db *DB) AllRecords(ctx context.Context, cursor uint64) ([]model.Record, error) {
 	var records []model.Record
@@ -271,6 +271,19 @@ func (s *Store) AddIndex(pieceCid cid.Cid, records []model.Record) error {
 	return nil
 }
 
+func (s *Store) ListPieces() ([]cid.Cid, error) {
+	log.Debugw("handle.list-pieces")
+
+	defer func(now time.Time) {
+		log.Debugw("handled.list-pieces", "took", fmt.Sprintf("%s", time.Since(now)))
+	}(time.Now())
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.db.ListPieceCids(context.Background())
+}
+
 func (s *Store) IndexedAt(pieceCid cid.Cid) (time.Time, error) {
 	log.Debugw("handle.indexed-at", "pieceCid", pieceCid)
 
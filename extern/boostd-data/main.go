@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"net"
 	"net/http"
@@ -10,13 +11,16 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/filecoin-project/boost/cmd/boostd-data/fdb"
+	"github.com/filecoin-project/boost/cmd/boostd-data/ldb"
 	"github.com/filecoin-project/boost/cmd/boostd-data/svc"
 	logging "github.com/ipfs/go-log/v2"
 )
 
 var (
-	repopath string
-	db       string
+	repopath        string
+	db              string
+	migrateFromPath string
 
 	log = logging.Logger("boostd-data")
 )
@@ -24,16 +28,27 @@ var (
 func init() {
 	logging.SetLogLevel("*", "debug")
 
-	flag.StringVar(&db, "db", "", "db type for boostd-data (couchbase or ldb)")
-	flag.StringVar(&repopath, "repopath", "", "path for repo")
+	flag.StringVar(&db, "db", "", "db type for boostd-data (couchbase, ldb or fdb)")
+	flag.StringVar(&repopath, "repopath", "", "path for repo (ldb), or path of the FoundationDB cluster file (fdb, uses the default cluster file if empty)")
+	flag.StringVar(&migrateFromPath, "migrate-from-ldb", "", "copy the pieces in the leveldb repo at this path to the fdb store, then exit (requires -db fdb)")
 }
 
 func main() {
 	flag.Parse()
 
+	if migrateFromPath != "" {
+		if err := migrate(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	done := make(chan struct{})
 
-	srv := svc.New(db, repopath)
+	srv, err := svc.New(db, repopath)
+	if err != nil {
+		log.Fatal(err)
+	}
 	addr := "localhost:8089"
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -70,3 +85,27 @@ func main() {
 
 	<-done
 }
+
+// migrate copies the pieces in the leveldb repo to FoundationDB. boostd-data
+// must not be running on the leveldb repo during the migration.
+func migrate() error {
+	if db != "fdb" {
+		return errors.New("-migrate-from-ldb requires -db fdb")
+	}
+
+	dst, err := fdb.NewStore(repopath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	migrated, err := fdb.MigrateFromLevelDB(ctx, ldb.NewStore(migrateFromPath), dst)
+	if err != nil {
+		return err
+	}
+
+	log.Infow("migration complete", "pieces", migrated)
+	return nil
+}
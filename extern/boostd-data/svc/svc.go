@@ -9,6 +9,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/filecoin-project/boost/cmd/boostd-data/couchbase"
+	"github.com/filecoin-project/boost/cmd/boostd-data/fdb"
 	"github.com/filecoin-project/boost/cmd/boostd-data/ldb"
 	"github.com/gorilla/mux"
 	logging "github.com/ipfs/go-log/v2"
@@ -18,19 +19,29 @@ var (
 	log = logging.Logger("svc")
 )
 
-func New(db string, repopath string) *http.Server {
-	server := rpc.NewServer()
-
+// New creates the boostd-data server for the given db. For ldb, repopath
+// is the path of the leveldb repo. For fdb, it is the path of the
+// FoundationDB cluster file (the default cluster file if empty).
+func New(db string, repopath string) (*http.Server, error) {
 	switch db {
 	case "couchbase":
-		ds := couchbase.NewStore()
-		server.RegisterName("boostddata", ds)
+		return newServer(couchbase.NewStore()), nil
 	case "ldb":
-		ds := ldb.NewStore(repopath)
-		server.RegisterName("boostddata", ds)
+		return newServer(ldb.NewStore(repopath)), nil
+	case "fdb":
+		ds, err := fdb.NewStore(repopath)
+		if err != nil {
+			return nil, fmt.Errorf("creating fdb store: %w", err)
+		}
+		return newServer(ds), nil
 	default:
-		panic(fmt.Sprintf("unknown db: %s", db))
+		return nil, fmt.Errorf("unknown db: %s", db)
 	}
+}
+
+func newServer(ds interface{}) *http.Server {
+	server := rpc.NewServer()
+	server.RegisterName("boostddata", ds)
 
 	router := mux.NewRouter()
 	router.Handle("/", server)
@@ -41,12 +52,16 @@ func New(db string, repopath string) *http.Server {
 }
 
 func Setup(db string) (string, func(), error) {
+	srv, err := New(db, "")
+	if err != nil {
+		return "", nil, err
+	}
+
 	addr := "localhost:0"
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return "", nil, err
 	}
-	srv := New(db, "")
 
 	done := make(chan struct{})
 
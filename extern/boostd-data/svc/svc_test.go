@@ -14,6 +14,8 @@ import (
 	"time"
 
 	"github.com/filecoin-project/boost/cmd/boostd-data/client"
+	"github.com/filecoin-project/boost/cmd/boostd-data/fdb"
+	"github.com/filecoin-project/boost/cmd/boostd-data/ldb"
 	"github.com/filecoin-project/boost/cmd/boostd-data/model"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/google/uuid"
@@ -34,6 +36,122 @@ func TestLdbService(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	testService(t, addr)
+
+	cleanup()
+}
+
+func TestFdbService(t *testing.T) {
+	addr, cleanup := setupServer(t, newServer(fdb.NewStoreWithKV(fdb.NewMemoryKV())))
+
+	testService(t, addr)
+
+	cleanup()
+}
+
+func TestNewUnavailableDb(t *testing.T) {
+	// boostd-data is built without the fdb tag in tests, so it can't
+	// connect to FoundationDB
+	if _, err := New("fdb", ""); err == nil {
+		t.Fatal("expected an error creating an fdb server without FoundationDB support")
+	}
+
+	if _, err := New("mongodb", ""); err == nil {
+		t.Fatal("expected an error creating a server for an unknown db")
+	}
+}
+
+func TestFdbMigrateFromLdb(t *testing.T) {
+	pieceCid, err := cid.Parse("baga6ea4seaqnfhocd544oidrgsss2ahoaomvxuaqxfmlsizljtzsuivjl5hamka")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := loadIndex("fixtures/baga6ea4seaqnfhocd544oidrgsss2ahoaomvxuaqxfmlsizljtzsuivjl5hamka.full.idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := getRecords(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := ldb.NewStore("")
+	err = src.AddIndex(pieceCid, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	di := model.DealInfo{
+		DealUuid:    uuid.New(),
+		SectorID:    abi.SectorNumber(1),
+		PieceOffset: 1,
+		PieceLength: 2,
+		CarLength:   3,
+	}
+	err = src.AddDealForPiece(pieceCid, di)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexedAt, err := src.IndexedAt(pieceCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := fdb.NewStoreWithKV(fdb.NewMemoryKV())
+
+	// running the migration a second time must not duplicate anything
+	for i := 0; i < 2; i++ {
+		migrated, err := fdb.MigrateFromLevelDB(context.Background(), src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated != 1 {
+			t.Fatalf("expected 1 piece to be migrated, got: %d", migrated)
+		}
+	}
+
+	addr, cleanup := setupServer(t, newServer(dst))
+	defer cleanup()
+
+	cl, err := client.NewStore("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dis, err := cl.GetPieceDeals(pieceCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dis) != 1 || dis[0] != di {
+		t.Fatalf("expected the deal to be migrated, got: %v", dis)
+	}
+
+	dstIndexedAt, err := cl.IndexedAt(pieceCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dstIndexedAt.Equal(indexedAt) {
+		t.Fatalf("expected indexed-at time %s to be kept, got: %s", indexedAt, dstIndexedAt)
+	}
+
+	loadedSubject, err := cl.GetIndex(pieceCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := compareIndices(subject, loadedSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("compare failed")
+	}
+}
+
+func testService(t *testing.T, addr string) {
 	cl, err := client.NewStore("http://" + addr)
 	if err != nil {
 		t.Fatal(err)
@@ -147,17 +265,22 @@ func TestLdbService(t *testing.T) {
 	}
 
 	log.Debug("sleeping for a while.. running tests..")
-
-	cleanup()
 }
 
 func setupService(t *testing.T, db string) (string, func()) {
+	srv, err := New(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return setupServer(t, srv)
+}
+
+func setupServer(t *testing.T, srv *http.Server) (string, func()) {
 	addr := "localhost:0"
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		t.Fatal(err)
 	}
-	srv := New(db, "")
 
 	done := make(chan struct{})
 
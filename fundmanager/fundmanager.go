@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/filecoin-project/boost/db"
 	"github.com/filecoin-project/go-address"
@@ -13,6 +15,7 @@ import (
 	"github.com/filecoin-project/go-state-types/builtin/v9/market"
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/types"
 
 	"github.com/google/uuid"
@@ -26,6 +29,7 @@ type fundManagerAPI interface {
 	MarketAddBalance(ctx context.Context, wallet, addr address.Address, amt types.BigInt) (cid.Cid, error)
 	StateMarketBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MarketBalance, error)
 	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+	ChainHead(context.Context) (*types.TipSet, error)
 }
 
 type Config struct {
@@ -39,20 +43,33 @@ type Config struct {
 	PubMsgWallet address.Address
 	// How much to reserve for each publish message
 	PubMsgBalMin abi.TokenAmount
+	// How long after a deal's start epoch the funds reserved for the deal
+	// expire. A deal can't be published once its start epoch has passed, so
+	// after that its funds are only still reserved if the deal was
+	// abandoned.
+	ReservationExpiryGrace time.Duration
+	// Whether to release expired reservations when reconciling funds
+	ReleaseExpiredReservations bool
 }
 
 type FundManager struct {
-	api fundManagerAPI
-	db  *db.FundsDB
-	cfg Config
+	api   fundManagerAPI
+	db    *db.FundsDB
+	deals dealsDB
+	cfg   Config
+
+	lk         sync.Mutex
+	lastLedger *Ledger
+	releases   []*ReleaseReport
 }
 
-func New(cfg Config) func(api v1api.FullNode, fundsDB *db.FundsDB) *FundManager {
-	return func(api api.FullNode, fundsDB *db.FundsDB) *FundManager {
+func New(cfg Config) func(api v1api.FullNode, fundsDB *db.FundsDB, dealsDB *db.DealsDB) *FundManager {
+	return func(api api.FullNode, fundsDB *db.FundsDB, dealsDB *db.DealsDB) *FundManager {
 		return &FundManager{
-			api: api,
-			db:  fundsDB,
-			cfg: cfg,
+			api:   api,
+			db:    fundsDB,
+			deals: dealsDB,
+			cfg:   cfg,
 		}
 	}
 }
@@ -74,8 +91,10 @@ var ErrInsufficientFunds = errors.New("insufficient funds")
 // deals message, so those funds cannot be used for other deals.
 // It returns ErrInsufficientFunds if there are not enough funds available
 // in the respective wallets to cover either of these operations.
+// The collateral is checked against the escrow of the miner that the deal
+// is proposed to, while the publish message wallet is shared by all miners.
 func (m *FundManager) TagFunds(ctx context.Context, dealUuid uuid.UUID, proposal market.DealProposal) (*TagFundsResp, error) {
-	marketBal, err := m.BalanceMarket(ctx)
+	marketBal, err := m.balanceMarket(ctx, proposal.Provider)
 	if err != nil {
 		return nil, fmt.Errorf("getting market balance: %w", err)
 	}
@@ -91,13 +110,17 @@ func (m *FundManager) TagFunds(ctx context.Context, dealUuid uuid.UUID, proposal
 	if err != nil {
 		return nil, fmt.Errorf("getting total tagged: %w", err)
 	}
+	minerTagged, err := m.db.TotalTaggedForProvider(ctx, proposal.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("getting total tagged for miner %s from DB: %w", proposal.Provider, err)
+	}
 
 	dealCollateral := proposal.ProviderBalanceRequirement()
-	availForDealCollat := big.Sub(marketBal.Available, tagged.Collateral)
+	availForDealCollat := big.Sub(marketBal.Available, minerTagged.Collateral)
 	if availForDealCollat.LessThan(dealCollateral) {
 		err := fmt.Errorf("%w: available funds %d is less than collateral needed for deal %d: "+
-			"available = funds in escrow %d - amount reserved for other deals %d",
-			ErrInsufficientFunds, availForDealCollat, dealCollateral, marketBal.Available, tagged.Collateral)
+			"available = funds in escrow of %s %d - amount reserved for other deals %d",
+			ErrInsufficientFunds, availForDealCollat, dealCollateral, proposal.Provider, marketBal.Available, minerTagged.Collateral)
 		return nil, err
 	}
 
@@ -110,8 +133,13 @@ func (m *FundManager) TagFunds(ctx context.Context, dealUuid uuid.UUID, proposal
 		return nil, err
 	}
 
+	expiresAt, err := m.reservationExpiry(ctx, proposal.StartEpoch)
+	if err != nil {
+		return nil, err
+	}
+
 	// Provider has enough funds to make deal, so persist tagged funds
-	err = m.persistTagged(ctx, dealUuid, dealCollateral, m.cfg.PubMsgBalMin)
+	err = m.persistTagged(ctx, dealUuid, proposal.Provider, dealCollateral, m.cfg.PubMsgBalMin, expiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("saving total tagged: %w", err)
 	}
@@ -121,7 +149,7 @@ func (m *FundManager) TagFunds(ctx context.Context, dealUuid uuid.UUID, proposal
 		PublishMessage: m.cfg.PubMsgBalMin,
 
 		TotalPublishMessage: big.Add(tagged.PubMsg, m.cfg.PubMsgBalMin),
-		TotalCollateral:     big.Add(tagged.Collateral, dealCollateral),
+		TotalCollateral:     big.Add(minerTagged.Collateral, dealCollateral),
 
 		AvailablePublishMessage: big.Sub(availForPubMsg, m.cfg.PubMsgBalMin),
 		AvailableCollateral:     big.Sub(availForDealCollat, dealCollateral),
@@ -147,6 +175,10 @@ func (m *FundManager) totalTagged(ctx context.Context) (*db.TotalTagged, error)
 // It's called when it's no longer necessary to prevent the funds from being
 // used for a different deal (eg because the deal failed / was published)
 func (m *FundManager) UntagFunds(ctx context.Context, dealUuid uuid.UUID) (collat, pub abi.TokenAmount, err error) {
+	return m.untag(ctx, dealUuid, "Untag funds for deal")
+}
+
+func (m *FundManager) untag(ctx context.Context, dealUuid uuid.UUID, logText string) (collat, pub abi.TokenAmount, err error) {
 	untaggedCollat, untaggedPublish, err := m.db.Untag(ctx, dealUuid)
 	if err != nil {
 		return abi.NewTokenAmount(0), abi.NewTokenAmount(0), fmt.Errorf("persisting untag funds for deal to DB: %w", err)
@@ -156,7 +188,7 @@ func (m *FundManager) UntagFunds(ctx context.Context, dealUuid uuid.UUID) (colla
 
 	fundsLog := &db.FundsLog{
 		DealUUID: dealUuid,
-		Text:     "Untag funds for deal",
+		Text:     logText,
 		Amount:   tot,
 	}
 	err = m.db.InsertLog(ctx, fundsLog)
@@ -168,8 +200,21 @@ func (m *FundManager) UntagFunds(ctx context.Context, dealUuid uuid.UUID) (colla
 	return untaggedCollat, untaggedPublish, nil
 }
 
-func (m *FundManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, dealCollateral abi.TokenAmount, pubMsgBal abi.TokenAmount) error {
-	err := m.db.Tag(ctx, dealUuid, dealCollateral, pubMsgBal)
+// reservationExpiry returns the time at which the funds reserved for a deal
+// with the given start epoch expire
+func (m *FundManager) reservationExpiry(ctx context.Context, startEpoch abi.ChainEpoch) (time.Time, error) {
+	head, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("getting chain head: %w", err)
+	}
+
+	headTime := time.Unix(int64(head.MinTimestamp()), 0)
+	untilStart := time.Duration(startEpoch-head.Height()) * time.Duration(build.BlockDelaySecs) * time.Second
+	return headTime.Add(untilStart).Add(m.cfg.ReservationExpiryGrace), nil
+}
+
+func (m *FundManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, provider address.Address, dealCollateral abi.TokenAmount, pubMsgBal abi.TokenAmount, expiresAt time.Time) error {
+	err := m.db.Tag(ctx, dealUuid, provider, dealCollateral, pubMsgBal, expiresAt)
 	if err != nil {
 		return fmt.Errorf("persisting tag funds for deal to DB: %w", err)
 	}
@@ -207,7 +252,11 @@ func (m *FundManager) MoveFundsToEscrow(ctx context.Context, amt abi.TokenAmount
 // BalanceMarket returns available and locked amounts in escrow
 // (on chain with the Storage Market Actor)
 func (m *FundManager) BalanceMarket(ctx context.Context) (storagemarket.Balance, error) {
-	bal, err := m.api.StateMarketBalance(ctx, m.cfg.StorageMiner, types.EmptyTSK)
+	return m.balanceMarket(ctx, m.cfg.StorageMiner)
+}
+
+func (m *FundManager) balanceMarket(ctx context.Context, maddr address.Address) (storagemarket.Balance, error) {
+	bal, err := m.api.StateMarketBalance(ctx, maddr, types.EmptyTSK)
 	if err != nil {
 		return storagemarket.Balance{}, err
 	}
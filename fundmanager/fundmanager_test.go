@@ -3,13 +3,17 @@ package fundmanager
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
 	logging "github.com/ipfs/go-log/v2"
@@ -24,6 +28,7 @@ func TestFundManager(t *testing.T) {
 
 	sqldb := db.CreateTestTmpDB(t)
 	require.NoError(t, db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, migrations.Migrate(sqldb))
 
 	fundsDB := db.NewFundsDB(sqldb)
 
@@ -105,9 +110,162 @@ func TestFundManager(t *testing.T) {
 	req.NoError(err)
 	req.EqualValues(3, total.Collateral.Int64())
 	req.EqualValues(10, total.PubMsg.Int64())
+
+	// The escrow of the first deal's miner has 10 - 3 = 7 available, so a
+	// deal with collateral 8 is rejected
+	deal3 := deals[2]
+	prop3 := deal3.ClientDealProposal.Proposal
+	prop3.Provider = prop.Provider
+	prop3.ProviderCollateral = abi.NewTokenAmount(8)
+	_, err = fm.TagFunds(ctx, deal3.DealUuid, prop3)
+	req.ErrorIs(err, ErrInsufficientFunds)
+
+	// Funds tagged for another miner's deals are not reserved from its
+	// escrow, so the deal is accepted by another miner
+	prop3.Provider = address.TestAddress2
+	rsp, err = fm.TagFunds(ctx, deal3.DealUuid, prop3)
+	req.NoError(err)
+	req.EqualValues(8, rsp.TotalCollateral.Int64())
+	req.EqualValues(20, rsp.TotalPublishMessage.Int64())
+}
+
+func TestFundManagerLedger(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	require.NoError(t, db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, migrations.Migrate(sqldb))
+
+	dealsDB := db.NewDealsDB(sqldb)
+	fm := &FundManager{
+		api:   &mockApi{},
+		db:    db.NewFundsDB(sqldb),
+		deals: dealsDB,
+		cfg: Config{
+			StorageMiner: address.TestAddress,
+			PubMsgWallet: address.TestAddress2,
+			PubMsgBalMin: abi.NewTokenAmount(10),
+		},
+	}
+
+	// Tag funds for three deals:
+	// - one deal that is still waiting to be published
+	// - one deal that has already been published (the reservation is stale)
+	// - one deal that is not in the deals DB (the reservation is stale)
+	deals, err := db.GenerateNDeals(3)
+	req.NoError(err)
+	deals[0].Checkpoint = dealcheckpoints.Transferred
+	deals[1].Checkpoint = dealcheckpoints.AddedPiece
+	for i, deal := range deals {
+		if i < 2 {
+			req.NoError(dealsDB.Insert(ctx, &deal))
+		}
+		prop := deal.ClientDealProposal.Proposal
+		prop.ProviderCollateral = abi.NewTokenAmount(int64(i + 1))
+		_, err := fm.TagFunds(ctx, deal.DealUuid, prop)
+		req.NoError(err)
+	}
+
+	req.Nil(fm.LastReconciled())
+	l, err := fm.Reconcile(ctx)
+	req.NoError(err)
+	req.Equal(l, fm.LastReconciled())
+
+	req.Len(l.Reservations, 3)
+	req.False(l.Reservations[0].Stale)
+	req.Equal(dealcheckpoints.Transferred.String(), l.Reservations[0].Checkpoint)
+	req.True(l.Reservations[1].Stale)
+	req.True(l.Reservations[2].Stale)
+	req.Empty(l.Reservations[2].Checkpoint)
+
+	req.EqualValues(1+2+3, l.TotalCollateral.Int64())
+	req.EqualValues(2+3, l.StaleCollateral.Int64())
+	req.EqualValues(30, l.TotalPubMsg.Int64())
+	req.EqualValues(20, l.StalePubMsg.Int64())
+
+	// escrow available = escrow 30 - locked 20
+	req.EqualValues(10-6, l.UnreservedCollateral.Int64())
+	// publish wallet balance is 50
+	req.EqualValues(50-30, l.UnreservedPubMsg.Int64())
+}
+
+func TestFundManagerReleaseExpired(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	require.NoError(t, db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	require.NoError(t, migrations.Migrate(sqldb))
+
+	api := &mockApi{head: 1000}
+	fundsDB := db.NewFundsDB(sqldb)
+	fm := &FundManager{
+		api: api,
+		db:  fundsDB,
+		cfg: Config{
+			StorageMiner:           address.TestAddress,
+			PubMsgWallet:           address.TestAddress2,
+			PubMsgBalMin:           abi.NewTokenAmount(10),
+			ReservationExpiryGrace: time.Hour,
+		},
+	}
+
+	// Tag funds for three deals:
+	// - one deal whose start epoch is well in the past (expired)
+	// - one deal whose start epoch has just passed (within the grace period)
+	// - one deal whose start epoch is in the future
+	deals, err := db.GenerateNDeals(3)
+	req.NoError(err)
+	startEpochs := []abi.ChainEpoch{800, 995, 2000}
+	for i, deal := range deals {
+		prop := deal.ClientDealProposal.Proposal
+		prop.ProviderCollateral = abi.NewTokenAmount(int64(i + 1))
+		prop.StartEpoch = startEpochs[i]
+		_, err := fm.TagFunds(ctx, deal.DealUuid, prop)
+		req.NoError(err)
+	}
+
+	tagged, err := fundsDB.ListTagged(ctx)
+	req.NoError(err)
+	req.Len(tagged, 3)
+	req.WithinDuration(time.Now().Add(1000*30*time.Second+time.Hour), tagged[2].ExpiresAt, time.Minute)
+
+	// Only the reservation for the first deal should be released
+	req.Empty(fm.ReleaseReports())
+	rpt, err := fm.ReleaseExpired(ctx)
+	req.NoError(err)
+	req.Len(rpt.Released, 1)
+	req.Equal(deals[0].DealUuid, rpt.Released[0].DealUUID)
+	req.EqualValues(1, rpt.ReclaimedCollateral.Int64())
+	req.EqualValues(10, rpt.ReclaimedPubMsg.Int64())
+	req.Equal([]*ReleaseReport{rpt}, fm.ReleaseReports())
+
+	total, err := fm.TotalTagged(ctx)
+	req.NoError(err)
+	req.EqualValues(2+3, total.Collateral.Int64())
+	req.EqualValues(20, total.PubMsg.Int64())
+
+	// The release is recorded in the funds log
+	logs, err := fundsDB.Logs(ctx, nil, 0, 1)
+	req.NoError(err)
+	req.Equal(deals[0].DealUuid, logs[0].DealUUID)
+	req.Equal("Release expired funds reservation for deal", logs[0].Text)
+
+	// Untagging the released deal's funds again should fail with not found
+	_, _, err = fm.UntagFunds(ctx, deals[0].DealUuid)
+	req.ErrorIs(err, db.ErrNotFound)
+
+	// Nothing else has expired, so nothing is released and no report is
+	// added
+	rpt, err = fm.ReleaseExpired(ctx)
+	req.NoError(err)
+	req.Empty(rpt.Released)
+	req.Len(fm.ReleaseReports(), 1)
 }
 
 type mockApi struct {
+	head abi.ChainEpoch
 }
 
 func (m mockApi) MarketAddBalance(ctx context.Context, wallet, addr address.Address, amt types.BigInt) (cid.Cid, error) {
@@ -125,4 +283,25 @@ func (m mockApi) WalletBalance(ctx context.Context, a address.Address) (types.Bi
 	return big.NewInt(50), nil
 }
 
+func (m mockApi) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	c, err := cid.Decode("bafyreicmaj5hhoy5mgqvamfhgexxyergw7hdeshizghodwkjg6qmpoco7i")
+	if err != nil {
+		return nil, err
+	}
+	return types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 address.TestAddress,
+		Ticket:                &types.Ticket{VRFProof: []byte("ticket")},
+		ElectionProof:         &types.ElectionProof{VRFProof: []byte("proof")},
+		ParentWeight:          big.Zero(),
+		Height:                m.head,
+		ParentStateRoot:       c,
+		ParentMessageReceipts: c,
+		Messages:              c,
+		BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+		Timestamp:             uint64(time.Now().Unix()),
+		BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+		ParentBaseFee:         big.Zero(),
+	}})
+}
+
 var _ fundManagerAPI = (*mockApi)(nil)
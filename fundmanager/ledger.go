@@ -0,0 +1,312 @@
+package fundmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/google/uuid"
+)
+
+type dealsDB interface {
+	ByID(ctx context.Context, id uuid.UUID) (*types.ProviderDealState, error)
+}
+
+// Reservation is the amount of funds reserved (tagged) for a single deal
+type Reservation struct {
+	DealUUID   uuid.UUID
+	CreatedAt  time.Time
+	Collateral abi.TokenAmount
+	PubMsg     abi.TokenAmount
+	// The time at which the reservation expires (zero if it doesn't expire)
+	ExpiresAt time.Time
+	// The deal's checkpoint (empty if the deal could not be found)
+	Checkpoint string
+	// A reservation is stale if the deal no longer needs the funds, eg
+	// because it has already been published or has failed
+	Stale       bool
+	StaleReason string
+}
+
+// Expired returns true if the reservation has expired by the given time
+func (r *Reservation) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+// Ledger is a breakdown of funds reserved for deals, reconciled against
+// the provider's balances
+type Ledger struct {
+	// The time at which the ledger was created
+	At           time.Time
+	Reservations []Reservation
+
+	TotalCollateral abi.TokenAmount
+	TotalPubMsg     abi.TokenAmount
+	// The amount reserved by stale reservations
+	StaleCollateral abi.TokenAmount
+	StalePubMsg     abi.TokenAmount
+
+	EscrowAvailable abi.TokenAmount
+	EscrowLocked    abi.TokenAmount
+	PubMsgBalance   abi.TokenAmount
+	// The funds that are not reserved for any deal (may be negative if
+	// more funds are reserved than are available)
+	UnreservedCollateral abi.TokenAmount
+	UnreservedPubMsg     abi.TokenAmount
+}
+
+// Ledger returns a breakdown of the funds reserved for each deal
+func (m *FundManager) Ledger(ctx context.Context) (*Ledger, error) {
+	tagged, err := m.db.ListTagged(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing tagged funds: %w", err)
+	}
+
+	marketBal, err := m.BalanceMarket(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting market balance: %w", err)
+	}
+
+	pubMsgBal, err := m.BalancePublishMsg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting publish deals message wallet balance: %w", err)
+	}
+
+	l := &Ledger{
+		At:              time.Now(),
+		Reservations:    make([]Reservation, 0, len(tagged)),
+		TotalCollateral: big.Zero(),
+		TotalPubMsg:     big.Zero(),
+		StaleCollateral: big.Zero(),
+		StalePubMsg:     big.Zero(),
+		EscrowAvailable: marketBal.Available,
+		EscrowLocked:    marketBal.Locked,
+		PubMsgBalance:   pubMsgBal,
+	}
+	for _, t := range tagged {
+		res := Reservation{
+			DealUUID:   t.DealUUID,
+			CreatedAt:  t.CreatedAt,
+			Collateral: tokenOrZero(t.Collateral),
+			PubMsg:     tokenOrZero(t.PubMsg),
+			ExpiresAt:  t.ExpiresAt,
+		}
+		if err := m.checkStale(ctx, &res); err != nil {
+			return nil, err
+		}
+		if !res.Stale && res.Expired(l.At) {
+			res.Stale = true
+			res.StaleReason = "reservation expired"
+		}
+
+		l.TotalCollateral = big.Add(l.TotalCollateral, res.Collateral)
+		l.TotalPubMsg = big.Add(l.TotalPubMsg, res.PubMsg)
+		if res.Stale {
+			l.StaleCollateral = big.Add(l.StaleCollateral, res.Collateral)
+			l.StalePubMsg = big.Add(l.StalePubMsg, res.PubMsg)
+		}
+		l.Reservations = append(l.Reservations, res)
+	}
+
+	l.UnreservedCollateral = big.Sub(l.EscrowAvailable, l.TotalCollateral)
+	l.UnreservedPubMsg = big.Sub(l.PubMsgBalance, l.TotalPubMsg)
+	return l, nil
+}
+
+// checkStale checks if the deal that the funds are reserved for still needs
+// them
+func (m *FundManager) checkStale(ctx context.Context, res *Reservation) error {
+	if m.deals == nil {
+		return nil
+	}
+
+	deal, err := m.deals.ByID(ctx, res.DealUUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Stale = true
+			res.StaleReason = "deal not found"
+			return nil
+		}
+		return fmt.Errorf("getting deal %s: %w", res.DealUUID, err)
+	}
+
+	res.Checkpoint = deal.Checkpoint.String()
+	switch {
+	case deal.Checkpoint > dealcheckpoints.PublishConfirmed:
+		// Funds are untagged once the deal has been published
+		res.Stale = true
+		res.StaleReason = "deal has been published"
+	case deal.Err != "" && deal.Retry == types.DealRetryFatal:
+		res.Stale = true
+		res.StaleReason = "deal failed: " + deal.Err
+	}
+	return nil
+}
+
+// Reconcile creates a ledger and logs any discrepancies in it
+func (m *FundManager) Reconcile(ctx context.Context) (*Ledger, error) {
+	l, err := m.Ledger(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, res := range l.Reservations {
+		if res.Stale {
+			log.Warnw("stale funds reservation", "id", res.DealUUID, "collateral", res.Collateral,
+				"pubmsg", res.PubMsg, "checkpoint", res.Checkpoint, "reason", res.StaleReason)
+		}
+	}
+	if l.UnreservedCollateral.LessThan(big.Zero()) {
+		log.Warnw("funds reserved for deal collateral exceed available escrow",
+			"reserved", l.TotalCollateral, "escrow-available", l.EscrowAvailable)
+	}
+	if l.UnreservedPubMsg.LessThan(big.Zero()) {
+		log.Warnw("funds reserved for publish messages exceed publish wallet balance",
+			"reserved", l.TotalPubMsg, "balance", l.PubMsgBalance)
+	}
+	log.Debugw("reconciled funds", "reservations", len(l.Reservations),
+		"collateral", l.TotalCollateral, "pubmsg", l.TotalPubMsg,
+		"stale-collateral", l.StaleCollateral, "stale-pubmsg", l.StalePubMsg)
+
+	m.lk.Lock()
+	m.lastLedger = l
+	m.lk.Unlock()
+
+	return l, nil
+}
+
+// LastReconciled returns the ledger from the last reconciliation, or nil if
+// there hasn't been a reconciliation yet
+func (m *FundManager) LastReconciled() *Ledger {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.lastLedger
+}
+
+// The number of release reports that are kept
+const maxReleaseReports = 100
+
+// ReleaseReport lists the expired reservations that were released at one
+// time, and the funds that were reclaimed
+type ReleaseReport struct {
+	At                  time.Time
+	Released            []Reservation
+	ReclaimedCollateral abi.TokenAmount
+	ReclaimedPubMsg     abi.TokenAmount
+}
+
+// ReleaseExpired releases the funds reserved for deals whose reservations
+// have expired, so that the funds can be used for other deals. It returns
+// a report of the funds that were reclaimed.
+func (m *FundManager) ReleaseExpired(ctx context.Context) (*ReleaseReport, error) {
+	tagged, err := m.db.ListTagged(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing tagged funds: %w", err)
+	}
+
+	rpt := &ReleaseReport{
+		At:                  time.Now(),
+		Released:            []Reservation{},
+		ReclaimedCollateral: big.Zero(),
+		ReclaimedPubMsg:     big.Zero(),
+	}
+	for _, t := range tagged {
+		res := Reservation{
+			DealUUID:   t.DealUUID,
+			CreatedAt:  t.CreatedAt,
+			Collateral: tokenOrZero(t.Collateral),
+			PubMsg:     tokenOrZero(t.PubMsg),
+			ExpiresAt:  t.ExpiresAt,
+		}
+		if !res.Expired(rpt.At) {
+			continue
+		}
+		if err := m.checkStale(ctx, &res); err != nil {
+			return nil, err
+		}
+
+		collat, pub, err := m.untag(ctx, res.DealUUID, "Release expired funds reservation for deal")
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				// The funds were untagged since they were listed
+				continue
+			}
+			return nil, fmt.Errorf("releasing funds reserved for deal %s: %w", res.DealUUID, err)
+		}
+		res.Collateral = tokenOrZero(collat)
+		res.PubMsg = tokenOrZero(pub)
+
+		log.Infow("released expired funds reservation", "id", res.DealUUID, "collateral", res.Collateral,
+			"pubmsg", res.PubMsg, "expired-at", res.ExpiresAt, "checkpoint", res.Checkpoint)
+		rpt.Released = append(rpt.Released, res)
+		rpt.ReclaimedCollateral = big.Add(rpt.ReclaimedCollateral, res.Collateral)
+		rpt.ReclaimedPubMsg = big.Add(rpt.ReclaimedPubMsg, res.PubMsg)
+	}
+
+	if len(rpt.Released) == 0 {
+		return rpt, nil
+	}
+
+	log.Infow("released expired funds reservations", "count", len(rpt.Released),
+		"collateral", rpt.ReclaimedCollateral, "pubmsg", rpt.ReclaimedPubMsg)
+
+	m.lk.Lock()
+	m.releases = append(m.releases, rpt)
+	if len(m.releases) > maxReleaseReports {
+		m.releases = m.releases[len(m.releases)-maxReleaseReports:]
+	}
+	m.lk.Unlock()
+
+	return rpt, nil
+}
+
+// ReleaseReports returns the reports of the most recent releases of
+// expired reservations, newest first
+func (m *FundManager) ReleaseReports() []*ReleaseReport {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	rpts := make([]*ReleaseReport, 0, len(m.releases))
+	for i := len(m.releases) - 1; i >= 0; i-- {
+		rpts = append(rpts, m.releases[i])
+	}
+	return rpts
+}
+
+// RunReconciler reconciles funds every interval until the context is
+// cancelled. If configured to, it first releases expired reservations.
+func (m *FundManager) RunReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if m.cfg.ReleaseExpiredReservations {
+			if _, err := m.ReleaseExpired(ctx); err != nil && ctx.Err() == nil {
+				log.Errorw("releasing expired funds reservations", "err", err)
+			}
+		}
+		if _, err := m.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			log.Errorw("reconciling funds", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func tokenOrZero(amt abi.TokenAmount) abi.TokenAmount {
+	if amt.Int == nil {
+		return big.Zero()
+	}
+	return amt
+}
@@ -0,0 +1,182 @@
+package fundmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/ipfs/go-cid"
+)
+
+type topUpAPI interface {
+	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+	MpoolPushMessage(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec) (*types.SignedMessage, error)
+	StateWaitMsg(ctx context.Context, cid cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
+}
+
+type TopUpConfig struct {
+	// The wallet that is topped up (the deal collateral wallet)
+	Wallet address.Address
+	// The wallet that funds are moved from
+	SourceWallet address.Address
+	// Top up the wallet when its balance drops below the threshold
+	Threshold abi.TokenAmount
+	// The balance to top the wallet up to
+	Target abi.TokenAmount
+	// The minimum amount to move in a single transfer
+	MinTransfer abi.TokenAmount
+	// The maximum amount to move in a single transfer. Zero means no limit.
+	MaxTransfer abi.TokenAmount
+	// How often to check the wallet balance
+	CheckInterval time.Duration
+	// Log the transfers that would be made instead of making them
+	DryRun bool
+}
+
+// TopUpEvt is recorded in the journal for each transfer
+type TopUpEvt struct {
+	From   address.Address
+	To     address.Address
+	Amount abi.TokenAmount
+	// The balance of the wallet before the transfer
+	Balance abi.TokenAmount
+	DryRun  bool
+	MsgCid  cid.Cid
+	Error   string
+}
+
+var ErrInsufficientSourceFunds = errors.New("insufficient funds in source wallet")
+
+// TopUp watches the balance of the deal collateral wallet, and moves funds
+// to it from the source wallet when the balance drops below a threshold
+type TopUp struct {
+	api     topUpAPI
+	cfg     TopUpConfig
+	journal journal.Journal
+	evtType journal.EventType
+}
+
+func NewTopUp(api topUpAPI, j journal.Journal, cfg TopUpConfig) *TopUp {
+	return &TopUp{
+		api:     api,
+		cfg:     cfg,
+		journal: j,
+		evtType: j.RegisterEventType("funds", "collateral_topup"),
+	}
+}
+
+// amount returns the amount needed to top up a wallet with the given
+// balance to the target, within the min / max transfer limits. It returns
+// zero if the balance is not below the threshold.
+func (t *TopUp) amount(bal abi.TokenAmount) abi.TokenAmount {
+	if !bal.LessThan(t.cfg.Threshold) {
+		return big.Zero()
+	}
+	amt := big.Sub(t.cfg.Target, bal)
+	if amt.LessThan(tokenOrZero(t.cfg.MinTransfer)) {
+		amt = t.cfg.MinTransfer
+	}
+	if max := tokenOrZero(t.cfg.MaxTransfer); !max.IsZero() && amt.GreaterThan(max) {
+		amt = max
+	}
+	return amt
+}
+
+// Check tops up the wallet if its balance is below the threshold, and waits
+// for the transfer message to land on chain. It returns the transfer that
+// was made, or nil if the wallet didn't need topping up.
+func (t *TopUp) Check(ctx context.Context) (*TopUpEvt, error) {
+	bal, err := t.api.WalletBalance(ctx, t.cfg.Wallet)
+	if err != nil {
+		return nil, fmt.Errorf("getting balance of wallet %s: %w", t.cfg.Wallet, err)
+	}
+
+	amt := t.amount(bal)
+	if amt.IsZero() {
+		return nil, nil
+	}
+
+	evt := &TopUpEvt{
+		From:    t.cfg.SourceWallet,
+		To:      t.cfg.Wallet,
+		Amount:  amt,
+		Balance: bal,
+		DryRun:  t.cfg.DryRun,
+	}
+	err = t.transfer(ctx, evt)
+	if err != nil {
+		evt.Error = err.Error()
+	}
+	t.journal.RecordEvent(t.evtType, func() interface{} { return evt })
+	return evt, err
+}
+
+func (t *TopUp) transfer(ctx context.Context, evt *TopUpEvt) error {
+	srcBal, err := t.api.WalletBalance(ctx, evt.From)
+	if err != nil {
+		return fmt.Errorf("getting balance of source wallet %s: %w", evt.From, err)
+	}
+	if srcBal.LessThan(evt.Amount) {
+		return fmt.Errorf("%w: %s has %s but top-up needs %s",
+			ErrInsufficientSourceFunds, evt.From, types.FIL(srcBal), types.FIL(evt.Amount))
+	}
+
+	if evt.DryRun {
+		log.Infow("dry run: would top up collateral wallet", "from", evt.From, "to", evt.To,
+			"amount", types.FIL(evt.Amount), "balance", types.FIL(evt.Balance))
+		return nil
+	}
+
+	smsg, err := t.api.MpoolPushMessage(ctx, &types.Message{
+		From:  evt.From,
+		To:    evt.To,
+		Value: evt.Amount,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("pushing top-up message: %w", err)
+	}
+	evt.MsgCid = smsg.Cid()
+	log.Infow("topping up collateral wallet", "from", evt.From, "to", evt.To,
+		"amount", types.FIL(evt.Amount), "balance", types.FIL(evt.Balance), "msg", evt.MsgCid)
+
+	// Wait for the message to land on chain, so that the next check sees
+	// the new balance
+	lookup, err := t.api.StateWaitMsg(ctx, evt.MsgCid, build.MessageConfidence, api.LookbackNoLimit, true)
+	if err != nil {
+		return fmt.Errorf("waiting for top-up message %s: %w", evt.MsgCid, err)
+	}
+	if lookup.Receipt.ExitCode.IsError() {
+		return fmt.Errorf("top-up message %s failed with exit code %s", evt.MsgCid, lookup.Receipt.ExitCode)
+	}
+	return nil
+}
+
+// Run checks the wallet balance every check interval until the context is
+// cancelled
+func (t *TopUp) Run(ctx context.Context) {
+	log.Infow("starting collateral wallet top-up", "wallet", t.cfg.Wallet, "source", t.cfg.SourceWallet,
+		"threshold", types.FIL(t.cfg.Threshold), "target", types.FIL(t.cfg.Target), "dry run", t.cfg.DryRun)
+
+	ticker := time.NewTicker(t.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := t.Check(ctx); err != nil && ctx.Err() == nil {
+			log.Errorw("topping up collateral wallet", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package fundmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopUp(t *testing.T) {
+	ctx := context.Background()
+	wallet := address.TestAddress
+	source := address.TestAddress2
+	cfg := TopUpConfig{
+		Wallet:       wallet,
+		SourceWallet: source,
+		Threshold:    abi.NewTokenAmount(100),
+		Target:       abi.NewTokenAmount(120),
+		MinTransfer:  abi.NewTokenAmount(50),
+		MaxTransfer:  abi.NewTokenAmount(100),
+	}
+
+	tcs := []struct {
+		name    string
+		balance int64
+		source  int64
+		dryRun  bool
+		exit    exitcode.ExitCode
+		// The expected transfer amount, or zero if there should be no transfer
+		expected int64
+		err      error
+	}{{
+		name:    "balance above threshold",
+		balance: 100,
+		source:  1000,
+	}, {
+		name:     "top up to target",
+		balance:  40,
+		source:   1000,
+		expected: 80,
+	}, {
+		name:     "limited to max transfer",
+		balance:  10,
+		source:   1000,
+		expected: 100,
+	}, {
+		name:     "at least min transfer",
+		balance:  99,
+		source:   1000,
+		expected: 50,
+	}, {
+		name:     "insufficient source funds",
+		balance:  40,
+		source:   70,
+		expected: 80,
+		err:      ErrInsufficientSourceFunds,
+	}, {
+		name:     "dry run",
+		balance:  40,
+		source:   1000,
+		dryRun:   true,
+		expected: 80,
+	}, {
+		name:     "message fails",
+		balance:  40,
+		source:   1000,
+		exit:     exitcode.SysErrInsufficientFunds,
+		expected: 80,
+	}}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &mockTopUpApi{
+				balances: map[address.Address]abi.TokenAmount{
+					wallet: abi.NewTokenAmount(tc.balance),
+					source: abi.NewTokenAmount(tc.source),
+				},
+				exit: tc.exit,
+			}
+			j := &mockJournal{EventTypeRegistry: journal.NewEventTypeRegistry(nil)}
+			c := cfg
+			c.DryRun = tc.dryRun
+			topUp := NewTopUp(api, j, c)
+
+			evt, err := topUp.Check(ctx)
+			if tc.expected == 0 {
+				require.NoError(t, err)
+				require.Nil(t, evt)
+				require.Empty(t, j.events)
+				require.Empty(t, api.pushed)
+				return
+			}
+
+			require.NotNil(t, evt)
+			require.EqualValues(t, tc.expected, evt.Amount.Int64())
+			require.EqualValues(t, tc.balance, evt.Balance.Int64())
+			require.Equal(t, source, evt.From)
+			require.Equal(t, wallet, evt.To)
+			require.Equal(t, tc.dryRun, evt.DryRun)
+
+			// Every transfer (or attempted transfer) is recorded in the journal
+			require.Len(t, j.events, 1)
+			require.Equal(t, evt, j.events[0])
+
+			switch {
+			case tc.err != nil:
+				require.ErrorIs(t, err, tc.err)
+				require.NotEmpty(t, evt.Error)
+				require.Empty(t, api.pushed)
+			case tc.dryRun:
+				require.NoError(t, err)
+				require.Empty(t, api.pushed)
+			case tc.exit.IsError():
+				require.Error(t, err)
+				require.NotEmpty(t, evt.Error)
+				require.Len(t, api.pushed, 1)
+			default:
+				require.NoError(t, err)
+				require.Empty(t, evt.Error)
+				require.Len(t, api.pushed, 1)
+				msg := api.pushed[0]
+				require.Equal(t, source, msg.From)
+				require.Equal(t, wallet, msg.To)
+				require.EqualValues(t, tc.expected, msg.Value.Int64())
+				require.Equal(t, msg.Cid(), evt.MsgCid)
+			}
+		})
+	}
+}
+
+type mockTopUpApi struct {
+	balances map[address.Address]abi.TokenAmount
+	exit     exitcode.ExitCode
+	pushed   []*types.Message
+}
+
+func (m *mockTopUpApi) WalletBalance(ctx context.Context, a address.Address) (types.BigInt, error) {
+	return m.balances[a], nil
+}
+
+func (m *mockTopUpApi) MpoolPushMessage(ctx context.Context, msg *types.Message, spec *lapi.MessageSendSpec) (*types.SignedMessage, error) {
+	m.pushed = append(m.pushed, msg)
+	return &types.SignedMessage{Message: *msg, Signature: crypto.Signature{Type: crypto.SigTypeBLS}}, nil
+}
+
+func (m *mockTopUpApi) StateWaitMsg(ctx context.Context, c cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*lapi.MsgLookup, error) {
+	return &lapi.MsgLookup{
+		Message: c,
+		Receipt: types.MessageReceipt{ExitCode: m.exit},
+	}, nil
+}
+
+var _ topUpAPI = (*mockTopUpApi)(nil)
+
+type mockJournal struct {
+	journal.EventTypeRegistry
+
+	lk     sync.Mutex
+	events []interface{}
+}
+
+func (j *mockJournal) RecordEvent(evtType journal.EventType, supplier func() interface{}) {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	j.events = append(j.events, supplier())
+}
+
+func (j *mockJournal) Close() error {
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
 	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/bandwidth"
 	"github.com/filecoin-project/dagstore"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
@@ -40,46 +41,50 @@ type dealListResolver struct {
 // resolver translates from a request for a graphql field to the data for
 // that field
 type resolver struct {
-	cfg        *config.Boost
-	repo       lotus_repo.LockedRepo
-	h          host.Host
-	dealsDB    *db.DealsDB
-	logsDB     *db.LogsDB
-	plDB       *db.ProposalLogsDB
-	fundsDB    *db.FundsDB
-	fundMgr    *fundmanager.FundManager
-	storageMgr *storagemanager.StorageManager
-	provider   *storagemarket.Provider
-	legacyProv lotus_storagemarket.StorageProvider
-	legacyDT   lotus_dtypes.ProviderDataTransfer
-	ps         piecestore.PieceStore
-	sa         retrievalmarket.SectorAccessor
-	dagst      dagstore.Interface
-	publisher  *storageadapter.DealPublisher
-	spApi      sealingpipeline.API
-	fullNode   v1api.FullNode
-}
-
-func NewResolver(cfg *config.Boost, r lotus_repo.LockedRepo, h host.Host, dealsDB *db.DealsDB, logsDB *db.LogsDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, spApi sealingpipeline.API, provider *storagemarket.Provider, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, ps piecestore.PieceStore, sa retrievalmarket.SectorAccessor, dagst dagstore.Interface, publisher *storageadapter.DealPublisher, fullNode v1api.FullNode) *resolver {
+	cfg           *config.Boost
+	repo          lotus_repo.LockedRepo
+	h             host.Host
+	dealsDB       *db.DealsDB
+	logsDB        *db.LogsDB
+	annotationsDB *db.DealAnnotationsDB
+	plDB          *db.ProposalLogsDB
+	fundsDB       *db.FundsDB
+	fundMgr       *fundmanager.FundManager
+	storageMgr    *storagemanager.StorageManager
+	provider      *storagemarket.Provider
+	legacyProv    lotus_storagemarket.StorageProvider
+	legacyDT      lotus_dtypes.ProviderDataTransfer
+	ps            piecestore.PieceStore
+	sa            retrievalmarket.SectorAccessor
+	dagst         dagstore.Interface
+	publisher     *storageadapter.DealPublisher
+	spApi         sealingpipeline.API
+	fullNode      v1api.FullNode
+	bandwidth     *bandwidth.Manager
+}
+
+func NewResolver(cfg *config.Boost, r lotus_repo.LockedRepo, h host.Host, dealsDB *db.DealsDB, logsDB *db.LogsDB, annotationsDB *db.DealAnnotationsDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, spApi sealingpipeline.API, provider *storagemarket.Provider, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, ps piecestore.PieceStore, sa retrievalmarket.SectorAccessor, dagst dagstore.Interface, publisher *storageadapter.DealPublisher, fullNode v1api.FullNode, bw *bandwidth.Manager) *resolver {
 	return &resolver{
-		cfg:        cfg,
-		repo:       r,
-		h:          h,
-		dealsDB:    dealsDB,
-		logsDB:     logsDB,
-		plDB:       plDB,
-		fundsDB:    fundsDB,
-		fundMgr:    fundMgr,
-		storageMgr: storageMgr,
-		provider:   provider,
-		legacyProv: legacyProv,
-		legacyDT:   legacyDT,
-		ps:         ps,
-		sa:         sa,
-		dagst:      dagst,
-		publisher:  publisher,
-		spApi:      spApi,
-		fullNode:   fullNode,
+		cfg:           cfg,
+		repo:          r,
+		h:             h,
+		dealsDB:       dealsDB,
+		logsDB:        logsDB,
+		annotationsDB: annotationsDB,
+		plDB:          plDB,
+		fundsDB:       fundsDB,
+		fundMgr:       fundMgr,
+		storageMgr:    storageMgr,
+		provider:      provider,
+		legacyProv:    legacyProv,
+		legacyDT:      legacyDT,
+		ps:            ps,
+		sa:            sa,
+		dagst:         dagst,
+		publisher:     publisher,
+		spApi:         spApi,
+		fullNode:      fullNode,
+		bandwidth:     bw,
 	}
 }
 
@@ -103,7 +108,7 @@ func (r *resolver) Deal(ctx context.Context, args struct{ ID graphql.ID }) (*dea
 		return nil, err
 	}
 
-	return newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.spApi), nil
+	return newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.annotationsDB, r.spApi), nil
 }
 
 type dealsArgs struct {
@@ -136,7 +141,7 @@ func (r *resolver) Deals(ctx context.Context, args dealsArgs) (*dealListResolver
 
 	resolvers := make([]*dealResolver, 0, len(deals))
 	for _, deal := range deals {
-		resolvers = append(resolvers, newDealResolver(&deal, r.provider, r.dealsDB, r.logsDB, r.spApi))
+		resolvers = append(resolvers, newDealResolver(&deal, r.provider, r.dealsDB, r.logsDB, r.annotationsDB, r.spApi))
 	}
 
 	return &dealListResolver{
@@ -169,7 +174,7 @@ func (r *resolver) DealUpdate(ctx context.Context, args struct{ ID graphql.ID })
 	}
 
 	net := make(chan *dealResolver, 1)
-	net <- newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.spApi)
+	net <- newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.annotationsDB, r.spApi)
 
 	// Updates to deal state are broadcast on pubsub. Pipe these updates to the
 	// client
@@ -181,7 +186,7 @@ func (r *resolver) DealUpdate(ctx context.Context, args struct{ ID graphql.ID })
 		}
 		return nil, fmt.Errorf("%s: subscribing to deal updates: %w", args.ID, err)
 	}
-	sub := &subLastUpdate{sub: dealUpdatesSub, provider: r.provider, dealsDB: r.dealsDB, logsDB: r.logsDB, spApi: r.spApi}
+	sub := &subLastUpdate{sub: dealUpdatesSub, provider: r.provider, dealsDB: r.dealsDB, logsDB: r.logsDB, annotationsDB: r.annotationsDB, spApi: r.spApi}
 	go func() {
 		sub.Pipe(ctx, net) // blocks until connection is closed
 		close(net)
@@ -220,7 +225,7 @@ func (r *resolver) DealNew(ctx context.Context) (<-chan *dealNewResolver, error)
 			case evti := <-sub.Out():
 				// Pipe the deal to the new deal channel
 				di := evti.(types.ProviderDealState)
-				rsv := newDealResolver(&di, r.provider, r.dealsDB, r.logsDB, r.spApi)
+				rsv := newDealResolver(&di, r.provider, r.dealsDB, r.logsDB, r.annotationsDB, r.spApi)
 				totalCount, err := r.dealsDB.Count(ctx, "")
 				if err != nil {
 					log.Errorf("getting total deal count: %w", err)
@@ -331,20 +336,22 @@ func (r *resolver) dealList(ctx context.Context, query string, cursor *graphql.I
 
 type dealResolver struct {
 	types.ProviderDealState
-	provider    *storagemarket.Provider
-	transferred uint64
-	dealsDB     *db.DealsDB
-	logsDB      *db.LogsDB
-	spApi       sealingpipeline.API
+	provider      *storagemarket.Provider
+	transferred   uint64
+	dealsDB       *db.DealsDB
+	logsDB        *db.LogsDB
+	annotationsDB *db.DealAnnotationsDB
+	spApi         sealingpipeline.API
 }
 
-func newDealResolver(deal *types.ProviderDealState, provider *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, spApi sealingpipeline.API) *dealResolver {
+func newDealResolver(deal *types.ProviderDealState, provider *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, annotationsDB *db.DealAnnotationsDB, spApi sealingpipeline.API) *dealResolver {
 	return &dealResolver{
 		ProviderDealState: *deal,
 		provider:          provider,
 		transferred:       uint64(deal.NBytesReceived),
 		dealsDB:           dealsDB,
 		logsDB:            logsDB,
+		annotationsDB:     annotationsDB,
 		spApi:             spApi,
 	}
 }
@@ -435,6 +442,11 @@ type dealTransfer struct {
 	Size     gqltypes.Uint64
 	Params   string
 	ClientID string
+	Origin   string
+	// The encoding of the data on the wire, and the ratio of decompressed
+	// to compressed bytes (zero if the data was not compressed)
+	Compression      string
+	CompressionRatio float64
 }
 
 func (dr *dealResolver) Transfer() dealTransfer {
@@ -452,7 +464,18 @@ func (dr *dealResolver) Transfer() dealTransfer {
 		Size:     gqltypes.Uint64(transfer.Size),
 		Params:   params,
 		ClientID: transfer.ClientID,
+		Origin:   dr.ProviderDealState.TransferOrigin,
+
+		Compression:      dr.ProviderDealState.TransferCompression,
+		CompressionRatio: compressionRatio(dr.ProviderDealState),
+	}
+}
+
+func compressionRatio(deal types.ProviderDealState) float64 {
+	if deal.TransferWireBytes == 0 {
+		return 0
 	}
+	return float64(deal.TransferDecodedBytes) / float64(deal.TransferWireBytes)
 }
 
 func (dr *dealResolver) ProviderCollateral() gqltypes.Uint64 {
@@ -579,6 +602,27 @@ func (dr *dealResolver) Logs(ctx context.Context) ([]*logsResolver, error) {
 	return logResolvers, nil
 }
 
+func (dr *dealResolver) Annotations(ctx context.Context) ([]*dealAnnotationResolver, error) {
+	anns, err := dr.annotationsDB.ByDeal(ctx, dr.ProviderDealState.DealUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*dealAnnotationResolver, 0, len(anns))
+	for _, a := range anns {
+		resolvers = append(resolvers, &dealAnnotationResolver{a})
+	}
+	return resolvers, nil
+}
+
+type dealAnnotationResolver struct {
+	types.DealAnnotation
+}
+
+func (ar *dealAnnotationResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: ar.DealAnnotation.UpdatedAt}
+}
+
 type logsResolver struct {
 	db.DealLog
 }
@@ -617,11 +661,12 @@ func toUuid(id graphql.ID) (uuid.UUID, error) {
 }
 
 type subLastUpdate struct {
-	sub      event.Subscription
-	provider *storagemarket.Provider
-	dealsDB  *db.DealsDB
-	logsDB   *db.LogsDB
-	spApi    sealingpipeline.API
+	sub           event.Subscription
+	provider      *storagemarket.Provider
+	dealsDB       *db.DealsDB
+	logsDB        *db.LogsDB
+	annotationsDB *db.DealAnnotationsDB
+	spApi         sealingpipeline.API
 }
 
 func (s *subLastUpdate) Pipe(ctx context.Context, net chan *dealResolver) {
@@ -660,7 +705,7 @@ func (s *subLastUpdate) Pipe(ctx context.Context, net chan *dealResolver) {
 	loop:
 		for {
 			di := lastUpdate.(types.ProviderDealState)
-			rsv := newDealResolver(&di, s.provider, s.dealsDB, s.logsDB, s.spApi)
+			rsv := newDealResolver(&di, s.provider, s.dealsDB, s.logsDB, s.annotationsDB, s.spApi)
 
 			select {
 			case <-ctx.Done():
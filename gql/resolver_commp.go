@@ -0,0 +1,42 @@
+package gql
+
+import (
+	"context"
+
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type commpJob struct {
+	DealID    graphql.ID
+	Backend   string
+	State     string
+	Size      gqltypes.Uint64
+	Read      gqltypes.Uint64
+	Position  int32
+	QueuedAt  graphql.Time
+	StartedAt *graphql.Time
+}
+
+// query: commpJobs: [CommpJob]
+func (r *resolver) CommpJobs(_ context.Context) []*commpJob {
+	jobs := r.provider.CommpJobs()
+	res := make([]*commpJob, 0, len(jobs))
+	for _, j := range jobs {
+		var startedAt *graphql.Time
+		if j.StartedAt != nil {
+			startedAt = &graphql.Time{Time: *j.StartedAt}
+		}
+		res = append(res, &commpJob{
+			DealID:    graphql.ID(j.ID),
+			Backend:   j.Backend,
+			State:     j.State,
+			Size:      gqltypes.Uint64(j.Size),
+			Read:      gqltypes.Uint64(j.Read),
+			Position:  int32(j.Position),
+			QueuedAt:  graphql.Time{Time: j.QueuedAt},
+			StartedAt: startedAt,
+		})
+	}
+	return res
+}
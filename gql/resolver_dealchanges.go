@@ -0,0 +1,255 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gqltypes "github.com/filecoin-project/boost/gql/types"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// The kinds of deal state change
+const (
+	// The deal moved to a new checkpoint
+	dealChangeCheckpoint = "Checkpoint"
+	// The deal's data transfer progressed by at least one percent
+	dealChangeTransfer = "Transfer"
+	// The sealing state of the deal's sector changed
+	dealChangeSealing = "Sealing"
+)
+
+// How often to check the sealing state of the sectors of deals that are
+// being sealed. There is no event for sealing state changes, so they are
+// polled.
+var sealingStatePollInterval = 30 * time.Second
+
+type dealStateChangeResolver struct {
+	Kind                 string
+	DealID               graphql.ID
+	Checkpoint           string
+	PreviousCheckpoint   string
+	Transferred          gqltypes.Uint64
+	TransferSize         gqltypes.Uint64
+	TransferPercent      float64
+	SealingState         string
+	PreviousSealingState string
+	At                   graphql.Time
+
+	deal *dealResolver
+}
+
+func (c *dealStateChangeResolver) Message(ctx context.Context) string {
+	return c.deal.Message(ctx)
+}
+
+func (c *dealStateChangeResolver) Deal() *dealResolver {
+	return c.deal
+}
+
+// dealState is the last state of a deal sent to a subscriber
+type dealState struct {
+	checkpoint dealcheckpoints.Checkpoint
+	percent    int
+	sector     abi.SectorNumber
+	sealing    string
+}
+
+func transferPercent(deal *types.ProviderDealState) float64 {
+	if deal.Transfer.Size == 0 {
+		return 0
+	}
+	return 100 * float64(deal.NBytesReceived) / float64(deal.Transfer.Size)
+}
+
+// subscription: dealStateChanges(kinds) <-chan DealStateChange
+func (r *resolver) DealStateChanges(ctx context.Context, args struct{ Kinds *[]string }) (<-chan *dealStateChangeResolver, error) {
+	kinds := map[string]bool{dealChangeCheckpoint: true, dealChangeTransfer: true, dealChangeSealing: true}
+	if args.Kinds != nil {
+		kinds = make(map[string]bool)
+		for _, k := range *args.Kinds {
+			switch k {
+			case dealChangeCheckpoint, dealChangeTransfer, dealChangeSealing:
+				kinds[k] = true
+			default:
+				return nil, fmt.Errorf("unknown deal state change kind '%s': must be one of %s, %s or %s",
+					k, dealChangeCheckpoint, dealChangeTransfer, dealChangeSealing)
+			}
+		}
+	}
+
+	sub, err := r.provider.SubscribeAllDealUpdates()
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to deal updates: %w", err)
+	}
+
+	// Start from the current state of the active deals, so that only
+	// changes from now on are sent
+	active, err := r.dealsDB.ListActive(ctx)
+	if err != nil {
+		sub.Close() //nolint:errcheck
+		return nil, fmt.Errorf("listing active deals: %w", err)
+	}
+	states := make(map[uuid.UUID]*dealState, len(active))
+	for _, deal := range active {
+		states[deal.DealUuid] = &dealState{
+			checkpoint: deal.Checkpoint,
+			percent:    int(transferPercent(deal)),
+			sector:     deal.SectorID,
+		}
+	}
+	if kinds[dealChangeSealing] {
+		// Record the current sealing states without sending them
+		r.pollSealingStates(ctx, states, nil)
+	}
+
+	net := make(chan *dealStateChangeResolver, 256)
+	send := func(c *dealStateChangeResolver) {
+		if !kinds[c.Kind] {
+			return
+		}
+		if c.Kind == dealChangeTransfer {
+			// Transfer progress is superseded by the next update, so drop it
+			// rather than block if the client is falling behind
+			select {
+			case net <- c:
+			default:
+			}
+			return
+		}
+		select {
+		case net <- c:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		// When the connection ends, unsubscribe
+		defer close(net)
+		defer sub.Close()
+
+		ticker := time.NewTicker(sealingStatePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if kinds[dealChangeSealing] {
+					r.pollSealingStates(ctx, states, send)
+				}
+
+			case evti, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				deal := evti.(types.ProviderDealState)
+				if c := r.dealStateChange(states, &deal); c != nil {
+					send(c)
+				}
+			}
+		}
+	}()
+
+	return net, nil
+}
+
+// dealStateChange updates the last state of the deal, and returns the change
+// from the last state, or nil if there is no change to send
+func (r *resolver) dealStateChange(states map[uuid.UUID]*dealState, deal *types.ProviderDealState) *dealStateChangeResolver {
+	st, ok := states[deal.DealUuid]
+	if !ok {
+		// A new deal: its first checkpoint is a change from no checkpoint
+		st = &dealState{checkpoint: -1}
+		states[deal.DealUuid] = st
+	}
+
+	pct := transferPercent(deal)
+	var kind, prevCheckpoint string
+	switch {
+	case deal.Checkpoint != st.checkpoint:
+		kind = dealChangeCheckpoint
+		if st.checkpoint >= 0 {
+			prevCheckpoint = st.checkpoint.String()
+		}
+	case deal.Checkpoint == dealcheckpoints.Accepted && int(pct) > st.percent:
+		kind = dealChangeTransfer
+	default:
+		return nil
+	}
+
+	st.checkpoint = deal.Checkpoint
+	st.percent = int(pct)
+	st.sector = deal.SectorID
+	if deal.Checkpoint == dealcheckpoints.Complete {
+		delete(states, deal.DealUuid)
+	}
+
+	return &dealStateChangeResolver{
+		Kind:               kind,
+		DealID:             graphql.ID(deal.DealUuid.String()),
+		Checkpoint:         deal.Checkpoint.String(),
+		PreviousCheckpoint: prevCheckpoint,
+		Transferred:        gqltypes.Uint64(deal.NBytesReceived),
+		TransferSize:       gqltypes.Uint64(deal.Transfer.Size),
+		TransferPercent:    pct,
+		SealingState:       st.sealing,
+		At:                 graphql.Time{Time: time.Now()},
+		deal:               newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.annotationsDB, r.spApi),
+	}
+}
+
+// pollSealingStates checks the sealing state of the sector of each deal
+// that is being sealed, and sends the deals whose sealing state changed. If
+// send is nil the sealing states are recorded without being sent.
+func (r *resolver) pollSealingStates(ctx context.Context, states map[uuid.UUID]*dealState, send func(*dealStateChangeResolver)) {
+	sectorStates := make(map[abi.SectorNumber]string)
+	for dealUuid, st := range states {
+		if st.checkpoint != dealcheckpoints.IndexedAndAnnounced {
+			continue
+		}
+
+		sealing, ok := sectorStates[st.sector]
+		if !ok {
+			si, err := r.spApi.SectorsStatus(ctx, st.sector, false)
+			if err != nil {
+				log.Warnw("error getting sealing status for sector", "sector", st.sector, "error", err)
+				continue
+			}
+			sealing = string(si.State)
+			sectorStates[st.sector] = sealing
+		}
+		if sealing == st.sealing {
+			continue
+		}
+		prev := st.sealing
+		st.sealing = sealing
+		if send == nil {
+			continue
+		}
+
+		deal, err := r.dealsDB.ByID(ctx, dealUuid)
+		if err != nil {
+			log.Warnw("error getting deal to send sealing state change", "id", dealUuid, "error", err)
+			continue
+		}
+		send(&dealStateChangeResolver{
+			Kind:                 dealChangeSealing,
+			DealID:               graphql.ID(dealUuid.String()),
+			Checkpoint:           st.checkpoint.String(),
+			Transferred:          gqltypes.Uint64(deal.NBytesReceived),
+			TransferSize:         gqltypes.Uint64(deal.Transfer.Size),
+			TransferPercent:      transferPercent(deal),
+			SealingState:         sealing,
+			PreviousSealingState: prev,
+			At:                   graphql.Time{Time: time.Now()},
+			deal:                 newDealResolver(deal, r.provider, r.dealsDB, r.logsDB, r.annotationsDB, r.spApi),
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package gql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/db/migrations"
+	"github.com/filecoin-project/boost/sealingpipeline/mock"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealStateChange(t *testing.T) {
+	req := require.New(t)
+
+	r := &resolver{}
+	states := make(map[uuid.UUID]*dealState)
+	deal := &types.ProviderDealState{
+		DealUuid:   uuid.New(),
+		Checkpoint: dealcheckpoints.Accepted,
+		Transfer:   types.Transfer{Size: 1000},
+	}
+
+	// A new deal's first checkpoint is a change from no checkpoint
+	c := r.dealStateChange(states, deal)
+	req.NotNil(c)
+	req.Equal(dealChangeCheckpoint, c.Kind)
+	req.Equal(dealcheckpoints.Accepted.String(), c.Checkpoint)
+	req.Empty(c.PreviousCheckpoint)
+
+	// Transfer progress of less than one percent isn't a change
+	deal.NBytesReceived = 5
+	req.Nil(r.dealStateChange(states, deal))
+
+	deal.NBytesReceived = 25
+	c = r.dealStateChange(states, deal)
+	req.NotNil(c)
+	req.Equal(dealChangeTransfer, c.Kind)
+	req.Equal(2.5, c.TransferPercent)
+	req.Nil(r.dealStateChange(states, deal))
+
+	// A new checkpoint is a change from the previous checkpoint
+	deal.NBytesReceived = 1000
+	deal.Checkpoint = dealcheckpoints.Transferred
+	c = r.dealStateChange(states, deal)
+	req.NotNil(c)
+	req.Equal(dealChangeCheckpoint, c.Kind)
+	req.Equal(dealcheckpoints.Accepted.String(), c.PreviousCheckpoint)
+	req.Equal(100.0, c.TransferPercent)
+
+	// Completed deals are no longer tracked
+	deal.Checkpoint = dealcheckpoints.Complete
+	c = r.dealStateChange(states, deal)
+	req.NotNil(c)
+	req.Equal(dealChangeCheckpoint, c.Kind)
+	req.NotContains(states, deal.DealUuid)
+}
+
+func TestPollSealingStates(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	sqldb := db.CreateTestTmpDB(t)
+	req.NoError(db.CreateAllBoostTables(ctx, sqldb, sqldb))
+	req.NoError(migrations.Migrate(sqldb))
+	dealsDB := db.NewDealsDB(sqldb)
+	deals, err := db.GenerateNDeals(2)
+	req.NoError(err)
+	for i := range deals {
+		deals[i].SectorID = abi.SectorNumber(10 + i)
+		req.NoError(dealsDB.Insert(ctx, &deals[i]))
+	}
+
+	ctrl := gomock.NewController(t)
+	spApi := mock.NewMockAPI(ctrl)
+	sealing := map[abi.SectorNumber]api.SectorState{10: "PreCommit1", 11: "PreCommit1"}
+	spApi.EXPECT().SectorsStatus(gomock.Any(), gomock.Any(), false).DoAndReturn(
+		func(_ context.Context, sid abi.SectorNumber, _ bool) (api.SectorInfo, error) {
+			return api.SectorInfo{SectorID: sid, State: sealing[sid]}, nil
+		}).AnyTimes()
+
+	r := &resolver{dealsDB: dealsDB, spApi: spApi}
+	// Only deals that are being sealed are polled
+	states := map[uuid.UUID]*dealState{
+		deals[0].DealUuid: {checkpoint: dealcheckpoints.IndexedAndAnnounced, sector: 10},
+		deals[1].DealUuid: {checkpoint: dealcheckpoints.AddedPiece, sector: 11},
+	}
+
+	// The current sealing states are recorded without being sent
+	r.pollSealingStates(ctx, states, nil)
+	req.Equal("PreCommit1", states[deals[0].DealUuid].sealing)
+	req.Empty(states[deals[1].DealUuid].sealing)
+
+	var sent []*dealStateChangeResolver
+	send := func(c *dealStateChangeResolver) { sent = append(sent, c) }
+	r.pollSealingStates(ctx, states, send)
+	req.Empty(sent)
+
+	// A change to the sealing state is sent
+	sealing[10] = "WaitSeed"
+	sealing[11] = "WaitSeed"
+	r.pollSealingStates(ctx, states, send)
+	req.Len(sent, 1)
+	req.Equal(dealChangeSealing, sent[0].Kind)
+	req.EqualValues(deals[0].DealUuid.String(), sent[0].DealID)
+	req.Equal("WaitSeed", sent[0].SealingState)
+	req.Equal("PreCommit1", sent[0].PreviousSealingState)
+}
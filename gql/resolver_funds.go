@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/filecoin-project/boost/fundmanager"
 	gqltypes "github.com/filecoin-project/boost/gql/types"
 	"github.com/graph-gophers/graphql-go"
 )
@@ -141,6 +142,90 @@ func (r *resolver) FundsLogs(ctx context.Context, args fundsLogsArgs) (*fundsLog
 	}, nil
 }
 
+type fundsReservation struct {
+	DealUUID    graphql.ID
+	CreatedAt   graphql.Time
+	Collateral  gqltypes.BigInt
+	PubMsg      gqltypes.BigInt
+	ExpiresAt   *graphql.Time
+	Checkpoint  string
+	Stale       bool
+	StaleReason string
+}
+
+type fundsLedger struct {
+	At                   graphql.Time
+	Reservations         []*fundsReservation
+	TotalCollateral      gqltypes.BigInt
+	TotalPubMsg          gqltypes.BigInt
+	StaleCollateral      gqltypes.BigInt
+	StalePubMsg          gqltypes.BigInt
+	UnreservedCollateral gqltypes.BigInt
+	UnreservedPubMsg     gqltypes.BigInt
+}
+
+// query: fundsLedger: FundsLedger
+func (r *resolver) FundsLedger(ctx context.Context) (*fundsLedger, error) {
+	l, err := r.fundMgr.Ledger(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting funds ledger: %w", err)
+	}
+
+	return &fundsLedger{
+		At:                   graphql.Time{Time: l.At},
+		Reservations:         toFundsReservations(l.Reservations),
+		TotalCollateral:      gqltypes.BigInt{Int: l.TotalCollateral},
+		TotalPubMsg:          gqltypes.BigInt{Int: l.TotalPubMsg},
+		StaleCollateral:      gqltypes.BigInt{Int: l.StaleCollateral},
+		StalePubMsg:          gqltypes.BigInt{Int: l.StalePubMsg},
+		UnreservedCollateral: gqltypes.BigInt{Int: l.UnreservedCollateral},
+		UnreservedPubMsg:     gqltypes.BigInt{Int: l.UnreservedPubMsg},
+	}, nil
+}
+
+type fundsReleaseReport struct {
+	At                  graphql.Time
+	Released            []*fundsReservation
+	ReclaimedCollateral gqltypes.BigInt
+	ReclaimedPubMsg     gqltypes.BigInt
+}
+
+// query: fundsReleases: [FundsReleaseReport]
+func (r *resolver) FundsReleases() []*fundsReleaseReport {
+	rpts := r.fundMgr.ReleaseReports()
+	releases := make([]*fundsReleaseReport, 0, len(rpts))
+	for _, rpt := range rpts {
+		releases = append(releases, &fundsReleaseReport{
+			At:                  graphql.Time{Time: rpt.At},
+			Released:            toFundsReservations(rpt.Released),
+			ReclaimedCollateral: gqltypes.BigInt{Int: rpt.ReclaimedCollateral},
+			ReclaimedPubMsg:     gqltypes.BigInt{Int: rpt.ReclaimedPubMsg},
+		})
+	}
+	return releases
+}
+
+func toFundsReservations(rs []fundmanager.Reservation) []*fundsReservation {
+	reservations := make([]*fundsReservation, 0, len(rs))
+	for _, res := range rs {
+		var expiresAt *graphql.Time
+		if !res.ExpiresAt.IsZero() {
+			expiresAt = &graphql.Time{Time: res.ExpiresAt}
+		}
+		reservations = append(reservations, &fundsReservation{
+			DealUUID:    graphql.ID(res.DealUUID.String()),
+			CreatedAt:   graphql.Time{Time: res.CreatedAt},
+			Collateral:  gqltypes.BigInt{Int: res.Collateral},
+			PubMsg:      gqltypes.BigInt{Int: res.PubMsg},
+			ExpiresAt:   expiresAt,
+			Checkpoint:  res.Checkpoint,
+			Stale:       res.Stale,
+			StaleReason: res.StaleReason,
+		})
+	}
+	return reservations
+}
+
 // mutation: moveFundsToEscrow(amount): Boolean
 func (r *resolver) FundsMoveToEscrow(ctx context.Context, args struct{ Amount gqltypes.BigInt }) (bool, error) {
 	_, err := r.fundMgr.MoveFundsToEscrow(ctx, args.Amount.Int)
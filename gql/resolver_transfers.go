@@ -2,11 +2,13 @@ package gql
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"time"
 
 	gqltypes "github.com/filecoin-project/boost/gql/types"
 	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/google/uuid"
 	"github.com/graph-gophers/graphql-go"
 )
@@ -104,3 +106,133 @@ func (r *resolver) getTransferSamples(deals map[uuid.UUID][]storagemarket.Transf
 
 	return pts
 }
+
+type queuedDeal struct {
+	ID                 graphql.ID
+	ClientAddress      string
+	PieceSize          gqltypes.Uint64
+	IsVerified         bool
+	Host               string
+	CreatedAt          graphql.Time
+	Priority           gqltypes.BigInt
+	Weight             gqltypes.BigInt
+	Position           int32
+	TransferType       string
+	TransferWindowOpen *graphql.Time
+}
+
+// query: transferQueue: [QueuedDeal]
+func (r *resolver) TransferQueue(_ context.Context) []*queuedDeal {
+	queue := r.provider.TransferQueue()
+	deals := make([]*queuedDeal, 0, len(queue))
+	for _, d := range queue {
+		var windowOpen *graphql.Time
+		if d.TransferWindowOpen != nil {
+			windowOpen = &graphql.Time{Time: *d.TransferWindowOpen}
+		}
+		deals = append(deals, &queuedDeal{
+			ID:                 graphql.ID(d.DealUuid.String()),
+			ClientAddress:      d.Client.String(),
+			PieceSize:          gqltypes.Uint64(d.PieceSize),
+			IsVerified:         d.Verified,
+			Host:               d.Host,
+			CreatedAt:          graphql.Time{Time: d.CreatedAt},
+			Priority:           gqltypes.BigInt{Int: big.NewInt(d.Priority)},
+			Weight:             gqltypes.BigInt{Int: big.NewInt(d.Weight)},
+			Position:           int32(d.Position),
+			TransferType:       d.TransferType,
+			TransferWindowOpen: windowOpen,
+		})
+	}
+	return deals
+}
+
+// mutation: transferQueueSetWeight(id, weight): ID
+func (r *resolver) TransferQueueSetWeight(_ context.Context, args struct {
+	ID     graphql.ID
+	Weight gqltypes.BigInt
+}) (graphql.ID, error) {
+	dealUuid, err := toUuid(args.ID)
+	if err != nil {
+		return args.ID, err
+	}
+	if args.Weight.Int.Int == nil || !args.Weight.IsInt64() {
+		return args.ID, fmt.Errorf("weight %s is out of range", args.Weight.String())
+	}
+	err = r.provider.SetTransferQueueWeight(dealUuid, args.Weight.Int64())
+	return args.ID, err
+}
+
+type transferBandwidth struct {
+	GlobalLimit gqltypes.Uint64
+	Transfers   []*bandwidthTransfer
+}
+
+type bandwidthTransfer struct {
+	ID        graphql.ID
+	Kind      string
+	Peer      string
+	StartedAt graphql.Time
+	Limit     gqltypes.Uint64
+	Paused    bool
+	Share     gqltypes.Uint64
+	Received  gqltypes.Uint64
+}
+
+// query: transferBandwidth: TransferBandwidth
+func (r *resolver) TransferBandwidth(_ context.Context) *transferBandwidth {
+	xfers := r.bandwidth.Transfers()
+	gqlXfers := make([]*bandwidthTransfer, 0, len(xfers))
+	for _, x := range xfers {
+		gqlXfers = append(gqlXfers, &bandwidthTransfer{
+			ID:        graphql.ID(x.ID.String()),
+			Kind:      x.Kind,
+			Peer:      x.Peer,
+			StartedAt: graphql.Time{Time: x.StartedAt},
+			Limit:     gqltypes.Uint64(x.Limit),
+			Paused:    x.Paused,
+			Share:     gqltypes.Uint64(x.Share),
+			Received:  gqltypes.Uint64(x.Received),
+		})
+	}
+	return &transferBandwidth{
+		GlobalLimit: gqltypes.Uint64(r.bandwidth.GlobalLimit()),
+		Transfers:   gqlXfers,
+	}
+}
+
+// mutation: transferBandwidthSetGlobalLimit(limit): Boolean
+func (r *resolver) TransferBandwidthSetGlobalLimit(args struct{ Limit gqltypes.Uint64 }) bool {
+	r.bandwidth.SetGlobalLimit(uint64(args.Limit))
+	return true
+}
+
+// mutation: transferSetBandwidthLimit(id, limit): ID
+func (r *resolver) TransferSetBandwidthLimit(args struct {
+	ID    graphql.ID
+	Limit gqltypes.Uint64
+}) (graphql.ID, error) {
+	id, err := toUuid(args.ID)
+	if err != nil {
+		return args.ID, err
+	}
+	return args.ID, r.bandwidth.SetTransferLimit(id, uint64(args.Limit))
+}
+
+// mutation: transferPause(id): ID
+func (r *resolver) TransferPause(args struct{ ID graphql.ID }) (graphql.ID, error) {
+	id, err := toUuid(args.ID)
+	if err != nil {
+		return args.ID, err
+	}
+	return args.ID, r.bandwidth.Pause(id)
+}
+
+// mutation: transferResume(id): ID
+func (r *resolver) TransferResume(args struct{ ID graphql.ID }) (graphql.ID, error) {
+	id, err := toUuid(args.ID)
+	if err != nil {
+		return args.ID, err
+	}
+	return args.ID, r.bandwidth.Resume(id)
+}
@@ -0,0 +1,398 @@
+// Package apiquota manages the API tokens that callers of the client's http
+// APIs (the job API and the retrievals API) present, and enforces a daily
+// quota for each token on the number of deals proposed, the number of bytes
+// stored or retrieved, and the FIL spent on deals.
+//
+// Tokens, quotas and the day's usage are kept in a json file in the client
+// repo. The file is locked while it is updated, so that the usage counted by
+// several client processes (and the quotas set by the admin commands) are
+// shared and survive restarts.
+package apiquota
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/lib/lockedfile"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var ErrTokenNotFound = errors.New("api token not found")
+
+// Quota limits what a token may use per day (UTC). An empty or zero limit
+// means there is no limit.
+type Quota struct {
+	// The number of deals that may be proposed
+	DealsPerDay int64 `json:"dealsPerDay,omitempty"`
+	// The number of bytes that may be proposed in deals or retrieved,
+	// eg "100GiB"
+	BytesPerDay string `json:"bytesPerDay,omitempty"`
+	// The FIL that may be spent on deals, eg "0.5 FIL"
+	SpendPerDay string `json:"spendPerDay,omitempty"`
+
+	bytes uint64
+	spend abi.TokenAmount
+}
+
+// Validate parses the limits of the quota
+func (q *Quota) Validate() error {
+	if q.DealsPerDay < 0 {
+		return fmt.Errorf("deals per day must not be negative: %d", q.DealsPerDay)
+	}
+	q.bytes = 0
+	if q.BytesPerDay != "" {
+		b, err := humanize.ParseBytes(q.BytesPerDay)
+		if err != nil {
+			return fmt.Errorf("parsing bytes per day %s: %w", q.BytesPerDay, err)
+		}
+		q.bytes = b
+	}
+	q.spend = big.Zero()
+	if q.SpendPerDay != "" {
+		f, err := types.ParseFIL(q.SpendPerDay)
+		if err != nil {
+			return fmt.Errorf("parsing spend per day %s: %w", q.SpendPerDay, err)
+		}
+		q.spend = abi.TokenAmount(f)
+	}
+	return nil
+}
+
+// Usage is what a token has used on a day
+type Usage struct {
+	// The day, as YYYY-MM-DD in UTC
+	Day   string          `json:"day"`
+	Deals int64           `json:"deals"`
+	Bytes uint64          `json:"bytes"`
+	Spend abi.TokenAmount `json:"spend"`
+}
+
+// Charge is the usage of a single request
+type Charge struct {
+	Deals int64
+	Bytes uint64
+	Spend abi.TokenAmount
+}
+
+func (c Charge) spend() abi.TokenAmount {
+	if c.Spend.Int == nil {
+		return big.Zero()
+	}
+	return c.Spend
+}
+
+// Token is an API token, its quota and its usage today
+type Token struct {
+	Name string `json:"name"`
+	// The sha256 hash of the token's secret
+	SecretHash string    `json:"secretHash"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Quota      Quota     `json:"quota"`
+	Usage      Usage     `json:"usage"`
+	// The token may approve or reject deal proposals that are held for
+	// approval, and is recorded as the approver of its decisions
+	Approver bool `json:"approver,omitempty"`
+}
+
+// ExceededError is returned when a charge would exceed a token's quota
+type ExceededError struct {
+	Token string
+	// One of "deals", "bytes" or "spend"
+	Limit     string
+	Used      string
+	Requested string
+	Quota     string
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("api token %s has exceeded its daily %s quota: used %s, requested %s, quota %s",
+		e.Token, e.Limit, e.Used, e.Requested, e.Quota)
+}
+
+// Store is the set of API tokens in the client repo
+type Store struct {
+	file *lockedfile.File
+	now  func() time.Time
+}
+
+// NewStore returns the store of API tokens in the file at path
+func NewStore(path string) *Store {
+	return &Store{file: lockedfile.New(path), now: time.Now}
+}
+
+// Create creates a token with the quota, and returns the token's secret,
+// which callers present in an 'Authorization: Bearer <secret>' header.
+// The secret itself is not stored. If approver is true the token may decide
+// deal proposals that are held for approval.
+func (s *Store) Create(name string, q Quota, approver bool) (string, error) {
+	if name == "" {
+		return "", errors.New("api token name must not be empty")
+	}
+	if err := q.Validate(); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generating api token: %w", err)
+	}
+	secret := hex.EncodeToString(buf)
+
+	err := s.update(func(tokens map[string]*Token) error {
+		if _, ok := tokens[name]; ok {
+			return fmt.Errorf("api token %s already exists", name)
+		}
+		tokens[name] = &Token{Name: name, SecretHash: hashSecret(secret), CreatedAt: s.now(), Quota: q, Approver: approver}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// SetQuota replaces the token's quota
+func (s *Store) SetQuota(name string, q Quota) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	return s.update(func(tokens map[string]*Token) error {
+		t, ok := tokens[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+		}
+		t.Quota = q
+		return nil
+	})
+}
+
+// Remove removes the token, so that its secret is no longer accepted
+func (s *Store) Remove(name string) error {
+	return s.update(func(tokens map[string]*Token) error {
+		if _, ok := tokens[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+		}
+		delete(tokens, name)
+		return nil
+	})
+}
+
+// List returns the tokens ordered by name, with their usage today
+func (s *Store) List() ([]Token, error) {
+	var list []Token
+	err := s.read(func(tokens map[string]*Token) {
+		for _, t := range tokens {
+			list = append(list, *t)
+		}
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, err
+}
+
+// Authenticate returns the name of the token with the secret, or
+// ErrTokenNotFound if there is none
+func (s *Store) Authenticate(secret string) (string, error) {
+	if secret == "" {
+		return "", ErrTokenNotFound
+	}
+	hash := hashSecret(secret)
+	var name string
+	err := s.read(func(tokens map[string]*Token) {
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t.SecretHash), []byte(hash)) == 1 {
+				name = t.Name
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", ErrTokenNotFound
+	}
+	return name, nil
+}
+
+// IsApprover returns true if the token may decide deal proposals that are
+// held for approval
+func (s *Store) IsApprover(name string) (bool, error) {
+	var approver bool
+	found := false
+	err := s.read(func(tokens map[string]*Token) {
+		if t, ok := tokens[name]; ok {
+			approver = t.Approver
+			found = true
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+	}
+	return approver, nil
+}
+
+// Charge adds the charge to the token's usage today. It returns an
+// *ExceededError (and doesn't charge the token) if the charge would exceed
+// the token's quota.
+func (s *Store) Charge(name string, c Charge) error {
+	return s.update(func(tokens map[string]*Token) error {
+		t, ok := tokens[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+		}
+		if err := t.check(c); err != nil {
+			return err
+		}
+		t.Usage.Deals += c.Deals
+		t.Usage.Bytes += c.Bytes
+		t.Usage.Spend = big.Add(t.Usage.Spend, c.spend())
+		return nil
+	})
+}
+
+// Record adds the usage to the token's usage today without checking the
+// token's quota, eg for data that has already been served
+func (s *Store) Record(name string, c Charge) error {
+	return s.update(func(tokens map[string]*Token) error {
+		t, ok := tokens[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+		}
+		t.Usage.Deals += c.Deals
+		t.Usage.Bytes += c.Bytes
+		t.Usage.Spend = big.Add(t.Usage.Spend, c.spend())
+		return nil
+	})
+}
+
+// Refund removes a charge made earlier today from the token's usage, eg
+// when a deal that was charged for is rejected
+func (s *Store) Refund(name string, c Charge) error {
+	return s.update(func(tokens map[string]*Token) error {
+		t, ok := tokens[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+		}
+		t.Usage.Deals = max64(t.Usage.Deals-c.Deals, 0)
+		if c.Bytes > t.Usage.Bytes {
+			t.Usage.Bytes = 0
+		} else {
+			t.Usage.Bytes -= c.Bytes
+		}
+		t.Usage.Spend = big.Max(big.Sub(t.Usage.Spend, c.spend()), big.Zero())
+		return nil
+	})
+}
+
+func (t *Token) check(c Charge) error {
+	q := t.Quota
+	if q.DealsPerDay > 0 && t.Usage.Deals+c.Deals > q.DealsPerDay {
+		return &ExceededError{Token: t.Name, Limit: "deals", Used: fmt.Sprint(t.Usage.Deals),
+			Requested: fmt.Sprint(c.Deals), Quota: fmt.Sprint(q.DealsPerDay)}
+	}
+	if q.bytes > 0 && t.Usage.Bytes+c.Bytes > q.bytes {
+		return &ExceededError{Token: t.Name, Limit: "bytes", Used: humanize.IBytes(t.Usage.Bytes),
+			Requested: humanize.IBytes(c.Bytes), Quota: q.BytesPerDay}
+	}
+	if !q.spend.IsZero() && big.Add(t.Usage.Spend, c.spend()).GreaterThan(q.spend) {
+		return &ExceededError{Token: t.Name, Limit: "spend", Used: types.FIL(t.Usage.Spend).Short(),
+			Requested: types.FIL(c.spend()).Short(), Quota: q.SpendPerDay}
+	}
+	return nil
+}
+
+// read calls fn with the tokens, with the usage of each token reset if it
+// was counted on an earlier day
+func (s *Store) read(fn func(map[string]*Token)) error {
+	return s.locked(func() error {
+		tokens, err := s.load()
+		if err != nil {
+			return err
+		}
+		fn(tokens)
+		return nil
+	})
+}
+
+// update calls fn with the tokens and saves the tokens if fn succeeds
+func (s *Store) update(fn func(map[string]*Token) error) error {
+	return s.locked(func() error {
+		tokens, err := s.load()
+		if err != nil {
+			return err
+		}
+		if err := fn(tokens); err != nil {
+			return err
+		}
+		return s.save(tokens)
+	})
+}
+
+func (s *Store) locked(fn func() error) error {
+	return s.file.Locked(fn)
+}
+
+func (s *Store) load() (map[string]*Token, error) {
+	tokens := make(map[string]*Token)
+	var list []*Token
+	if _, err := s.file.Read(&list); err != nil {
+		return nil, fmt.Errorf("reading api tokens: %w", err)
+	}
+
+	today := s.now().UTC().Format("2006-01-02")
+	for _, t := range list {
+		if err := t.Quota.Validate(); err != nil {
+			return nil, fmt.Errorf("api token %s: %w", t.Name, err)
+		}
+		if t.Usage.Day != today || t.Usage.Spend.Int == nil {
+			t.Usage = Usage{Day: today, Spend: big.Zero()}
+		}
+		tokens[t.Name] = t
+	}
+	return tokens, nil
+}
+
+func (s *Store) save(tokens map[string]*Token) error {
+	list := make([]*Token, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Usage.Day == "" {
+			t.Usage = Usage{Day: s.now().UTC().Format("2006-01-02"), Spend: big.Zero()}
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	if err := s.file.Write(list); err != nil {
+		return fmt.Errorf("writing api tokens: %w", err)
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// DealSpend is the total FIL that a deal for a piece of the size costs at
+// the price (in attoFIL per epoch per GiB) over the duration
+func DealSpend(price abi.TokenAmount, pieceSize abi.PaddedPieceSize, duration abi.ChainEpoch) abi.TokenAmount {
+	total := big.Mul(big.Mul(price, big.NewIntUnsigned(uint64(pieceSize))), big.NewInt(int64(duration)))
+	return big.Div(total, big.NewInt(1<<30))
+}
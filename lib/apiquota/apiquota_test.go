@@ -0,0 +1,146 @@
+package apiquota
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-tokens.json")
+	s := NewStore(path)
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	secret, err := s.Create("etl", Quota{DealsPerDay: 2, BytesPerDay: "4KiB", SpendPerDay: "1 FIL"}, false)
+	require.NoError(t, err)
+	_, err = s.Create("etl", Quota{}, false)
+	require.Error(t, err)
+	_, err = s.Create("bad", Quota{BytesPerDay: "lots"}, false)
+	require.Error(t, err)
+
+	name, err := s.Authenticate(secret)
+	require.NoError(t, err)
+	require.Equal(t, "etl", name)
+	_, err = s.Authenticate("wrong")
+	require.ErrorIs(t, err, ErrTokenNotFound)
+
+	deal := Charge{Deals: 1, Bytes: 2048, Spend: big.NewInt(1)}
+	require.NoError(t, s.Charge("etl", deal))
+	require.NoError(t, s.Charge("etl", deal))
+
+	// A third deal exceeds the deals quota, and isn't charged
+	var ee *ExceededError
+	err = s.Charge("etl", deal)
+	require.True(t, errors.As(err, &ee))
+	require.Equal(t, "deals", ee.Limit)
+
+	// A refunded deal frees up the quota
+	require.NoError(t, s.Refund("etl", deal))
+	err = s.Charge("etl", Charge{Deals: 1, Bytes: 4096})
+	require.True(t, errors.As(err, &ee))
+	require.Equal(t, "bytes", ee.Limit)
+
+	// Recorded usage isn't checked against the quota
+	require.NoError(t, s.Record("etl", Charge{Bytes: 4096}))
+	require.True(t, errors.As(s.Charge("etl", Charge{Bytes: 1}), &ee))
+
+	// Usage is persisted
+	s2 := NewStore(path)
+	s2.now = s.now
+	tokens, err := s2.List()
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	require.EqualValues(t, 1, tokens[0].Usage.Deals)
+	require.EqualValues(t, 2048+4096, tokens[0].Usage.Bytes)
+
+	// Raising the quota allows more usage
+	require.NoError(t, s.SetQuota("etl", Quota{SpendPerDay: "1 FIL"}))
+	require.NoError(t, s.Charge("etl", Charge{Bytes: 1 << 40}))
+	err = s.Charge("etl", Charge{Spend: abi.TokenAmount(big.Mul(big.NewInt(2), big.NewInt(1e18)))})
+	require.True(t, errors.As(err, &ee))
+	require.Equal(t, "spend", ee.Limit)
+
+	// Usage resets the next day
+	now = now.Add(24 * time.Hour)
+	tokens, err = s.List()
+	require.NoError(t, err)
+	require.Zero(t, tokens[0].Usage.Bytes)
+
+	require.NoError(t, s.Remove("etl"))
+	_, err = s.Authenticate(secret)
+	require.ErrorIs(t, err, ErrTokenNotFound)
+}
+
+func TestChargeConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-tokens.json")
+	_, err := NewStore(path).Create("etl", Quota{DealsPerDay: 5}, false)
+	require.NoError(t, err)
+
+	// Concurrent charges from several processes (each with its own store)
+	// don't together exceed the quota
+	var wg sync.WaitGroup
+	var charged int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if NewStore(path).Charge("etl", Charge{Deals: 1}) == nil {
+				atomic.AddInt64(&charged, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 5, charged)
+
+	tokens, err := NewStore(path).List()
+	require.NoError(t, err)
+	require.EqualValues(t, 5, tokens[0].Usage.Deals)
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "api-tokens.json"))
+	secret, err := s.Create("etl", Quota{}, false)
+	require.NoError(t, err)
+
+	var gotToken string
+	handler := Authenticate("admin", s)(RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	open := Authenticate("admin", s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotToken, _ = TokenFromContext(r.Context())
+	}))
+
+	do := func(h http.Handler, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do(open, ""))
+	require.Equal(t, http.StatusUnauthorized, do(open, "wrong"))
+	require.Equal(t, http.StatusOK, do(open, secret))
+	require.Equal(t, "etl", gotToken)
+
+	require.Equal(t, http.StatusOK, do(handler, "admin"))
+	require.Equal(t, http.StatusForbidden, do(handler, secret))
+
+	// Only the admin token and approver tokens may decide approvals
+	approverSecret, err := s.Create("alice", Quota{}, true)
+	require.NoError(t, err)
+	approvals := Authenticate("admin", s)(RequireApprover(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	require.Equal(t, http.StatusOK, do(approvals, "admin"))
+	require.Equal(t, http.StatusOK, do(approvals, approverSecret))
+	require.Equal(t, http.StatusForbidden, do(approvals, secret))
+}
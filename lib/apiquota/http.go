@@ -0,0 +1,192 @@
+package apiquota
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("apiquota")
+
+type tokenKey struct{}
+
+// WithToken returns a context that carries the name of the API token that
+// authenticated the request
+func WithToken(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, name)
+}
+
+// TokenFromContext returns the name of the API token that authenticated the
+// request. It returns false if the request was authenticated with the admin
+// token (or wasn't authenticated), in which case there is no quota.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(tokenKey{}).(string)
+	return name, ok && name != ""
+}
+
+// Authenticate returns middleware that accepts requests with either the admin
+// token or the secret of one of the API tokens in the store in an
+// 'Authorization: Bearer <token>' header. Requests made with an API token
+// carry the token's name in their context (see TokenFromContext).
+func Authenticate(adminToken string, s *Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if adminToken != "" && subtle.ConstantTimeCompare([]byte(reqToken), []byte(adminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name, err := s.Authenticate(reqToken)
+			if err != nil {
+				if !errors.Is(err, ErrTokenNotFound) {
+					log.Warnw("authenticating api token", "err", err)
+				}
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithToken(r.Context(), name)))
+		})
+	}
+}
+
+// RequireAdmin rejects requests that were authenticated with an API token
+// rather than the admin token
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name, ok := TokenFromContext(r.Context()); ok {
+			writeError(w, http.StatusForbidden, fmt.Errorf("api token %s may not manage api tokens", name))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireApprover returns middleware that rejects requests that were
+// authenticated with an API token that may not decide deal proposals held
+// for approval. Requests authenticated with the admin token are accepted.
+func RequireApprover(s *Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name, ok := TokenFromContext(r.Context()); ok {
+				approver, err := s.IsApprover(name)
+				if err != nil {
+					writeError(w, http.StatusForbidden, err)
+					return
+				}
+				if !approver {
+					writeError(w, http.StatusForbidden, fmt.Errorf("api token %s may not decide approvals", name))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CreateTokenRequest is the body of a request to create an API token
+type CreateTokenRequest struct {
+	Name  string `json:"name"`
+	Quota Quota  `json:"quota"`
+	// The token may approve or reject deal proposals held for approval
+	Approver bool `json:"approver,omitempty"`
+}
+
+// CreateTokenResponse is the response to a request to create an API token.
+// The secret is only returned when the token is created.
+type CreateTokenResponse struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+// NewAdminHandler returns an http handler for managing API tokens:
+//
+//	GET    /api-tokens               list tokens with their quotas and usage today
+//	POST   /api-tokens               create a token
+//	PUT    /api-tokens/{name}/quota  replace a token's quota
+//	DELETE /api-tokens/{name}        remove a token
+func NewAdminHandler(s *Store) http.Handler {
+	h := &handler{store: s}
+	r := mux.NewRouter()
+	r.HandleFunc("/api-tokens", h.listTokens).Methods(http.MethodGet)
+	r.HandleFunc("/api-tokens", h.createToken).Methods(http.MethodPost)
+	r.HandleFunc("/api-tokens/{name}/quota", h.setQuota).Methods(http.MethodPut)
+	r.HandleFunc("/api-tokens/{name}", h.removeToken).Methods(http.MethodDelete)
+	return r
+}
+
+type handler struct {
+	store *Store
+}
+
+func (h *handler) listTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.store.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if tokens == nil {
+		tokens = []Token{}
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func (h *handler) createToken(w http.ResponseWriter, r *http.Request) {
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	secret, err := h.store.Create(req.Name, req.Quota, req.Approver)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, CreateTokenResponse{Name: req.Name, Secret: secret})
+}
+
+func (h *handler) setQuota(w http.ResponseWriter, r *http.Request) {
+	var q Quota
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	if err := h.store.SetQuota(mux.Vars(r)["name"], q); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) removeToken(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.Remove(mux.Vars(r)["name"]); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func statusFor(err error) int {
+	if errors.Is(err, ErrTokenNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnw("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
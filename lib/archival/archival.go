@@ -0,0 +1,493 @@
+// Package archival automates the verify-then-free workflow for the local
+// copies of data that a client has made deals for.
+//
+// A deal batch that opts in to archival is recorded with the local CAR file
+// of each piece and the deals that providers accepted for it. The archiver
+// then checks each piece periodically. Once every deal for the piece is
+// active on chain, it retrieves randomly chosen byte ranges of the piece
+// from a randomly chosen provider and compares them with the local CAR
+// file. If they match, the local data is deleted and a tombstone records
+// where the piece is now stored (its replication manifest). If a deal fails
+// the local data is kept.
+package archival
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("archival")
+
+var ErrNotFound = errors.New("not found")
+
+const (
+	// The piece's deals are not all active yet
+	StateWaiting = "waiting"
+	// The piece was verified and its local data deleted
+	StateArchived = "archived"
+	// A deal for the piece failed or the probe of the piece failed, so its
+	// local data is kept
+	StateFailed = "failed"
+)
+
+// DefaultProbeRanges is the number of byte ranges of a piece that are
+// compared with the local CAR file, if the policy doesn't say
+const DefaultProbeRanges = 4
+
+// probeRangeSize is the size of each byte range that is compared
+const probeRangeSize = 64 * 1024
+
+// Policy is the archival policy of a batch
+type Policy struct {
+	// The number of randomly chosen byte ranges of each piece to retrieve
+	// and compare with the local CAR file
+	ProbeRanges int `json:"probeRanges"`
+	// Also remove the import in the client blockstore that the CAR file was
+	// written from
+	RemoveImport bool `json:"removeImport"`
+}
+
+// Deal is a deal for a piece that was accepted by a provider
+type Deal struct {
+	DealUUID uuid.UUID       `json:"dealUuid"`
+	Provider address.Address `json:"provider"`
+	// The on-chain deal id, once the deal has been published
+	DealID abi.DealID `json:"dealId,omitempty"`
+	Active bool       `json:"active"`
+}
+
+// Piece is a piece whose local data is deleted once it is verified
+type Piece struct {
+	PayloadCid cid.Cid `json:"payloadCid"`
+	PieceCid   cid.Cid `json:"pieceCid"`
+	// The path of the local CAR file
+	CarPath string `json:"carPath"`
+	Deals   []Deal `json:"deals"`
+	State   string `json:"state"`
+	// Why the piece failed
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+// Batch is a deal batch that opted in to archival
+type Batch struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// The wallet that signed the deal proposals
+	Wallet address.Address `json:"wallet"`
+	Policy Policy          `json:"policy"`
+	Pieces []Piece         `json:"pieces"`
+}
+
+// Done returns true once no piece of the batch is waiting
+func (b *Batch) Done() bool {
+	for _, p := range b.Pieces {
+		if p.State == StateWaiting {
+			return false
+		}
+	}
+	return true
+}
+
+// Replica is a deal that stores a piece whose local data was deleted
+type Replica struct {
+	Provider address.Address `json:"provider"`
+	DealUUID uuid.UUID       `json:"dealUuid"`
+	DealID   abi.DealID      `json:"dealId"`
+}
+
+// Tombstone records that the local data of a piece was deleted, and where
+// the piece is stored
+type Tombstone struct {
+	PayloadCid cid.Cid   `json:"payloadCid"`
+	PieceCid   cid.Cid   `json:"pieceCid"`
+	CarPath    string    `json:"carPath"`
+	CarSize    int64     `json:"carSize"`
+	BatchID    uuid.UUID `json:"batchId"`
+	DeletedAt  time.Time `json:"deletedAt"`
+	// The replication manifest: the active deals that store the piece
+	Replicas []Replica `json:"replicas"`
+	// The provider whose copy of the piece was compared with the local data,
+	// and the number of byte ranges that were compared
+	ProbedProvider address.Address `json:"probedProvider"`
+	ProbedRanges   int             `json:"probedRanges"`
+}
+
+// Store keeps each batch and each tombstone as a JSON file in the client
+// repo, like the prefetch store
+type Store struct {
+	batchesDir    string
+	tombstonesDir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	s := &Store{batchesDir: filepath.Join(dir, "batches"), tombstonesDir: filepath.Join(dir, "tombstones")}
+	for _, d := range []string{s.batchesDir, s.tombstonesDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("creating archival dir %s: %w", d, err)
+		}
+	}
+	return s, nil
+}
+
+// AddBatch records a batch. Pieces without any accepted deal can never be
+// archived, so they are recorded as failed.
+func (s *Store) AddBatch(b *Batch) error {
+	if b.Policy.ProbeRanges <= 0 {
+		b.Policy.ProbeRanges = DefaultProbeRanges
+	}
+	for i := range b.Pieces {
+		p := &b.Pieces[i]
+		if p.CarPath == "" {
+			return fmt.Errorf("piece %s has no local CAR file", p.PieceCid)
+		}
+		p.State = StateWaiting
+		if len(p.Deals) == 0 {
+			p.State = StateFailed
+			p.Error = "no deals were accepted"
+		}
+	}
+	return s.writeJSON(s.batchPath(b.ID), b)
+}
+
+// Batch returns the batch with the given id
+func (s *Store) Batch(id uuid.UUID) (*Batch, error) {
+	var b Batch
+	if err := s.readJSON(s.batchPath(id), &b); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("batch %s: %w", id, ErrNotFound)
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Batches returns all batches, oldest first
+func (s *Store) Batches() ([]Batch, error) {
+	var batches []Batch
+	err := s.list(s.batchesDir, func(path string) error {
+		var b Batch
+		if err := s.readJSON(path, &b); err != nil {
+			return err
+		}
+		batches = append(batches, b)
+		return nil
+	})
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.Before(batches[j].CreatedAt)
+	})
+	return batches, err
+}
+
+// Tombstones returns the tombstones of all deleted pieces, most recently
+// deleted first
+func (s *Store) Tombstones() ([]Tombstone, error) {
+	var tombstones []Tombstone
+	err := s.list(s.tombstonesDir, func(path string) error {
+		var t Tombstone
+		if err := s.readJSON(path, &t); err != nil {
+			return err
+		}
+		tombstones = append(tombstones, t)
+		return nil
+	})
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].DeletedAt.After(tombstones[j].DeletedAt)
+	})
+	return tombstones, err
+}
+
+// Tombstone returns the tombstone of a deleted piece
+func (s *Store) Tombstone(pieceCid cid.Cid) (*Tombstone, error) {
+	var t Tombstone
+	if err := s.readJSON(s.tombstonePath(pieceCid), &t); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("tombstone for %s: %w", pieceCid, ErrNotFound)
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Store) batchPath(id uuid.UUID) string {
+	return filepath.Join(s.batchesDir, id.String()+".json")
+}
+
+func (s *Store) tombstonePath(pieceCid cid.Cid) string {
+	return filepath.Join(s.tombstonesDir, pieceCid.String()+".json")
+}
+
+func (s *Store) list(dir string, read func(path string) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading archival dir %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		if err := read(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) readJSON(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSON writes to a temporary file and renames it, so that readers
+// never see a partially written file
+func (s *Store) writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling %s: %w", filepath.Base(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// DealState is the state of a deal as far as archival is concerned
+type DealState struct {
+	// The on-chain deal id (zero until the deal is published)
+	DealID abi.DealID
+	// True once the deal is active on chain
+	Active bool
+	// Set if the deal failed (eg it was rejected after it was accepted, or
+	// it was slashed)
+	Failed string
+}
+
+// Checker checks the state of deals and retrieves data from providers
+type Checker interface {
+	// DealState returns the state of the deal. The checker must make sure
+	// that the on-chain deal is for the piece, between the wallet and the
+	// deal's provider, and report it as failed if it is not.
+	DealState(ctx context.Context, wallet address.Address, pieceCid cid.Cid, d Deal) (DealState, error)
+	// ReadRange retrieves length bytes of the piece from the provider,
+	// starting at offset
+	ReadRange(ctx context.Context, provider address.Address, pieceCid cid.Cid, offset int64, length int64) ([]byte, error)
+}
+
+// RemoveFunc deletes the local data of a piece that has been verified
+type RemoveFunc func(ctx context.Context, p Piece, policy Policy) error
+
+// Archiver checks the pieces of each batch, and deletes the local data of
+// the pieces that have been verified
+type Archiver struct {
+	store   *Store
+	checker Checker
+	remove  RemoveFunc
+	rand    *rand.Rand
+}
+
+func NewArchiver(store *Store, checker Checker, remove RemoveFunc) *Archiver {
+	return &Archiver{
+		store:   store,
+		checker: checker,
+		remove:  remove,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Run checks the batches every interval until the context is cancelled
+func (a *Archiver) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		archived, err := a.RunOnce(ctx)
+		if err != nil {
+			log.Warnw("checking archival batches", "err", err)
+		} else if archived > 0 {
+			log.Infow("archived pieces", "count", archived)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RunOnce checks the waiting pieces of every batch once, and returns the
+// number of pieces whose local data was deleted
+func (a *Archiver) RunOnce(ctx context.Context) (int, error) {
+	batches, err := a.store.Batches()
+	if err != nil {
+		return 0, err
+	}
+
+	var archived int
+	for i := range batches {
+		b := &batches[i]
+		if b.Done() {
+			continue
+		}
+		for j := range b.Pieces {
+			p := &b.Pieces[j]
+			if p.State != StateWaiting {
+				continue
+			}
+			if err := a.checkPiece(ctx, b, p); err != nil {
+				if ctx.Err() != nil {
+					return archived, ctx.Err()
+				}
+				// Errors checking a piece (eg the provider is offline) are
+				// temporary, so the piece is checked again next time
+				log.Infow("checking piece for archival", "batch", b.ID, "piece", p.PieceCid, "err", err)
+			}
+			now := time.Now()
+			p.CheckedAt = &now
+			if p.State == StateArchived {
+				archived++
+			}
+		}
+		if err := a.store.writeJSON(a.store.batchPath(b.ID), b); err != nil {
+			return archived, err
+		}
+	}
+	return archived, nil
+}
+
+// checkPiece updates the state of the piece's deals and, if they are all
+// active, verifies the piece and deletes its local data
+func (a *Archiver) checkPiece(ctx context.Context, b *Batch, p *Piece) error {
+	for i := range p.Deals {
+		d := &p.Deals[i]
+		if d.Active {
+			continue
+		}
+		st, err := a.checker.DealState(ctx, b.Wallet, p.PieceCid, *d)
+		if err != nil {
+			return fmt.Errorf("getting state of deal %s with %s: %w", d.DealUUID, d.Provider, err)
+		}
+		if st.Failed != "" {
+			p.State = StateFailed
+			p.Error = fmt.Sprintf("deal %s with %s failed: %s", d.DealUUID, d.Provider, st.Failed)
+			return nil
+		}
+		d.DealID = st.DealID
+		d.Active = st.Active
+	}
+	for _, d := range p.Deals {
+		if !d.Active {
+			return nil
+		}
+	}
+
+	// All the deals are active: check that a randomly chosen provider
+	// serves the same data as the local copy
+	fi, err := os.Stat(p.CarPath)
+	if err != nil {
+		p.State = StateFailed
+		p.Error = fmt.Sprintf("local CAR file: %s", err)
+		return nil
+	}
+	provider := p.Deals[a.rand.Intn(len(p.Deals))].Provider
+	if err := a.probe(ctx, provider, p, fi.Size(), b.Policy.ProbeRanges); err != nil {
+		var mismatch *mismatchError
+		if errors.As(err, &mismatch) {
+			p.State = StateFailed
+			p.Error = err.Error()
+			return nil
+		}
+		return fmt.Errorf("probing %s: %w", provider, err)
+	}
+
+	// Write the tombstone before deleting the data, so that there is always
+	// a record of where the data went
+	t := &Tombstone{
+		PayloadCid:     p.PayloadCid,
+		PieceCid:       p.PieceCid,
+		CarPath:        p.CarPath,
+		CarSize:        fi.Size(),
+		BatchID:        b.ID,
+		DeletedAt:      time.Now(),
+		ProbedProvider: provider,
+		ProbedRanges:   b.Policy.ProbeRanges,
+	}
+	for _, d := range p.Deals {
+		t.Replicas = append(t.Replicas, Replica{Provider: d.Provider, DealUUID: d.DealUUID, DealID: d.DealID})
+	}
+	if err := a.store.writeJSON(a.store.tombstonePath(p.PieceCid), t); err != nil {
+		return err
+	}
+	if err := a.remove(ctx, *p, b.Policy); err != nil {
+		return fmt.Errorf("deleting local data: %w", err)
+	}
+
+	p.State = StateArchived
+	log.Infow("verified piece and deleted its local data", "piece", p.PieceCid, "car", p.CarPath, "probed", provider)
+	return nil
+}
+
+type mismatchError struct {
+	offset int64
+}
+
+func (e *mismatchError) Error() string {
+	return fmt.Sprintf("the data retrieved at offset %d does not match the local CAR file", e.offset)
+}
+
+// probe compares randomly chosen byte ranges of the piece served by the
+// provider with the local CAR file
+func (a *Archiver) probe(ctx context.Context, provider address.Address, p *Piece, size int64, ranges int) error {
+	f, err := os.Open(p.CarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	for i := 0; i < ranges; i++ {
+		length := int64(probeRangeSize)
+		if length > size {
+			length = size
+		}
+		var offset int64
+		if size > length {
+			offset = a.rand.Int63n(size - length + 1)
+		}
+
+		local := make([]byte, length)
+		if _, err := f.ReadAt(local, offset); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading local CAR file: %w", err)
+		}
+		remote, err := a.checker.ReadRange(ctx, provider, p.PieceCid, offset, length)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(local, remote) {
+			return &mismatchError{offset: offset}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package archival
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChecker struct {
+	states map[uuid.UUID]DealState
+	data   []byte
+	// The pieces that providers serve different data for
+	corrupt map[cid.Cid]bool
+	// The providers that are offline
+	offline map[address.Address]bool
+}
+
+func (c *mockChecker) DealState(ctx context.Context, wallet address.Address, pieceCid cid.Cid, d Deal) (DealState, error) {
+	return c.states[d.DealUUID], nil
+}
+
+func (c *mockChecker) ReadRange(ctx context.Context, provider address.Address, pieceCid cid.Cid, offset int64, length int64) ([]byte, error) {
+	if c.offline[provider] {
+		return nil, errors.New("connection refused")
+	}
+	b := append([]byte{}, c.data[offset:offset+length]...)
+	if c.corrupt[pieceCid] {
+		b[0]++
+	}
+	return b, nil
+}
+
+func testCid(t *testing.T, s string) cid.Cid {
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func testAddr(t *testing.T, id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return addr
+}
+
+func TestArchiver(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "archival"))
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("0123456789"), 20000)
+	writeCar := func(name string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		return path
+	}
+
+	p1, p2 := testAddr(t, 1001), testAddr(t, 1002)
+	checker := &mockChecker{
+		states:  make(map[uuid.UUID]DealState),
+		data:    data,
+		corrupt: map[cid.Cid]bool{testCid(t, "corrupt"): true},
+		offline: make(map[address.Address]bool),
+	}
+	var removed []string
+	archiver := NewArchiver(store, checker, func(ctx context.Context, p Piece, policy Policy) error {
+		removed = append(removed, p.CarPath)
+		return os.Remove(p.CarPath)
+	})
+
+	// A piece with two deals, a piece whose deal will fail, a piece that a
+	// provider serves different data for, and a piece with no deals
+	okDeals := []Deal{{DealUUID: uuid.New(), Provider: p1}, {DealUUID: uuid.New(), Provider: p2}}
+	failDeal := Deal{DealUUID: uuid.New(), Provider: p1}
+	corruptDeal := Deal{DealUUID: uuid.New(), Provider: p2}
+	b := &Batch{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		Wallet:    testAddr(t, 100),
+		Pieces: []Piece{
+			{PieceCid: testCid(t, "ok"), CarPath: writeCar("ok.car"), Deals: okDeals},
+			{PieceCid: testCid(t, "fail"), CarPath: writeCar("fail.car"), Deals: []Deal{failDeal}},
+			{PieceCid: testCid(t, "corrupt"), CarPath: writeCar("corrupt.car"), Deals: []Deal{corruptDeal}},
+			{PieceCid: testCid(t, "none"), CarPath: writeCar("none.car")},
+		},
+	}
+	require.NoError(t, store.AddBatch(b))
+
+	stored, err := store.Batch(b.ID)
+	require.NoError(t, err)
+	require.Equal(t, DefaultProbeRanges, stored.Policy.ProbeRanges)
+	require.Equal(t, StateFailed, stored.Pieces[3].State)
+
+	// Nothing is deleted while the deals are not active
+	checker.states[okDeals[0].DealUUID] = DealState{DealID: 1, Active: true}
+	checker.states[okDeals[1].DealUUID] = DealState{DealID: 2}
+	archived, err := archiver.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, archived)
+	stored, err = store.Batch(b.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateWaiting, stored.Pieces[0].State)
+	require.True(t, stored.Pieces[0].Deals[0].Active)
+	require.False(t, stored.Pieces[0].Deals[1].Active)
+	require.NotNil(t, stored.Pieces[0].CheckedAt)
+
+	// The deals become active, but a provider is offline so the probe can't
+	// be made yet
+	checker.states[okDeals[1].DealUUID] = DealState{DealID: 2, Active: true}
+	checker.states[failDeal.DealUUID] = DealState{Failed: "slashed"}
+	checker.states[corruptDeal.DealUUID] = DealState{DealID: 3, Active: true}
+	checker.offline[p1] = true
+	checker.offline[p2] = true
+	archived, err = archiver.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, archived)
+	stored, err = store.Batch(b.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateWaiting, stored.Pieces[0].State)
+	require.Equal(t, StateFailed, stored.Pieces[1].State)
+	require.Contains(t, stored.Pieces[1].Error, "slashed")
+	require.Equal(t, StateWaiting, stored.Pieces[2].State)
+	require.Empty(t, removed)
+
+	// Once the providers are back, the piece whose data matches is deleted
+	// and a tombstone records its replicas. The piece that a provider serves
+	// different data for is kept.
+	checker.offline[p1] = false
+	checker.offline[p2] = false
+	archived, err = archiver.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, archived)
+	require.Equal(t, []string{b.Pieces[0].CarPath}, removed)
+	_, err = os.Stat(b.Pieces[0].CarPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(b.Pieces[2].CarPath)
+	require.NoError(t, err)
+
+	stored, err = store.Batch(b.ID)
+	require.NoError(t, err)
+	require.True(t, stored.Done())
+	require.Equal(t, StateArchived, stored.Pieces[0].State)
+	require.Equal(t, StateFailed, stored.Pieces[2].State)
+	require.Contains(t, stored.Pieces[2].Error, "does not match")
+
+	ts, err := store.Tombstone(b.Pieces[0].PieceCid)
+	require.NoError(t, err)
+	require.Equal(t, b.ID, ts.BatchID)
+	require.EqualValues(t, len(data), ts.CarSize)
+	require.Equal(t, []Replica{
+		{Provider: p1, DealUUID: okDeals[0].DealUUID, DealID: abi.DealID(1)},
+		{Provider: p2, DealUUID: okDeals[1].DealUUID, DealID: abi.DealID(2)},
+	}, ts.Replicas)
+	require.Contains(t, []address.Address{p1, p2}, ts.ProbedProvider)
+	require.Equal(t, DefaultProbeRanges, ts.ProbedRanges)
+
+	_, err = store.Tombstone(b.Pieces[2].PieceCid)
+	require.ErrorIs(t, err, ErrNotFound)
+	tombstones, err := store.Tombstones()
+	require.NoError(t, err)
+	require.Len(t, tombstones, 1)
+
+	// A batch that is done is not checked again
+	archived, err = archiver.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, archived)
+	require.Len(t, removed, 1)
+}
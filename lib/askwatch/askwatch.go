@@ -0,0 +1,217 @@
+// Package askwatch watches the storage asks of a list of providers and emits
+// an event when a provider changes its price or piece size bounds.
+//
+// The storage ask protocol is request / response only, so changes are
+// detected by querying each provider's ask periodically. A subscriber can
+// ask for a provider to be re-queried immediately (eg when the provider
+// rejects a deal because the price is too low).
+package askwatch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("askwatch")
+
+// DefaultInterval is the default interval at which asks are polled
+const DefaultInterval = 10 * time.Minute
+
+// Ask is the part of a provider's storage ask that the watcher compares
+type Ask struct {
+	// attoFIL per GiB per epoch
+	Price         abi.TokenAmount
+	VerifiedPrice abi.TokenAmount
+	MinPieceSize  abi.PaddedPieceSize
+	MaxPieceSize  abi.PaddedPieceSize
+}
+
+// Fetcher queries a provider's current storage ask
+type Fetcher interface {
+	StorageAsk(ctx context.Context, provider address.Address) (*Ask, error)
+}
+
+// Watchlist returns the providers to watch. It is called before each round
+// of polling, so the list can change over time.
+type Watchlist func(ctx context.Context) ([]address.Address, error)
+
+// StaticWatchlist watches a fixed list of providers
+func StaticWatchlist(providers ...address.Address) Watchlist {
+	return func(context.Context) ([]address.Address, error) {
+		return providers, nil
+	}
+}
+
+// Change is emitted when a provider's ask changes
+type Change struct {
+	Provider address.Address
+	Old      Ask
+	New      Ask
+	At       time.Time
+}
+
+// PriceChanged returns true if the price or verified price changed
+func (c *Change) PriceChanged() bool {
+	return !c.Old.Price.Equals(c.New.Price) || !c.Old.VerifiedPrice.Equals(c.New.VerifiedPrice)
+}
+
+// SizeChanged returns true if the min or max piece size changed
+func (c *Change) SizeChanged() bool {
+	return c.Old.MinPieceSize != c.New.MinPieceSize || c.Old.MaxPieceSize != c.New.MaxPieceSize
+}
+
+// Watcher polls the asks of the providers in the watchlist, and sends a
+// Change to subscribers when an ask changes. The first ask seen for a
+// provider is the baseline that later asks are compared with, so it doesn't
+// generate a Change.
+type Watcher struct {
+	fetcher   Fetcher
+	watchlist Watchlist
+	interval  time.Duration
+	refresh   chan address.Address
+
+	lk     sync.Mutex
+	asks   map[address.Address]Ask
+	subs   map[int]chan Change
+	nextID int
+}
+
+func NewWatcher(fetcher Fetcher, watchlist Watchlist, interval time.Duration) *Watcher {
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return &Watcher{
+		fetcher:   fetcher,
+		watchlist: watchlist,
+		interval:  interval,
+		refresh:   make(chan address.Address, 16),
+		asks:      make(map[address.Address]Ask),
+		subs:      make(map[int]chan Change),
+	}
+}
+
+// Subscribe returns a channel of ask changes, and a function that cancels
+// the subscription. Changes are dropped if the subscriber falls behind.
+func (w *Watcher) Subscribe() (<-chan Change, func()) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	id := w.nextID
+	w.nextID++
+	ch := make(chan Change, 16)
+	w.subs[id] = ch
+	return ch, func() {
+		w.lk.Lock()
+		defer w.lk.Unlock()
+		if _, ok := w.subs[id]; ok {
+			delete(w.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// Ask returns the last ask seen for the provider
+func (w *Watcher) Ask(provider address.Address) (Ask, bool) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	ask, ok := w.asks[provider]
+	return ask, ok
+}
+
+// Refresh queries the provider's ask as soon as possible, instead of waiting
+// for the next round of polling
+func (w *Watcher) Refresh(provider address.Address) {
+	select {
+	case w.refresh <- provider:
+	default:
+	}
+}
+
+// Run polls asks until the context is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Poll(ctx); err != nil && ctx.Err() == nil {
+			log.Errorw("polling storage asks", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-w.refresh:
+			w.check(ctx, p)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll queries the ask of each provider in the watchlist once
+func (w *Watcher) Poll(ctx context.Context) error {
+	providers, err := w.watchlist(ctx)
+	if err != nil {
+		return err
+	}
+
+	watched := make(map[address.Address]struct{}, len(providers))
+	for _, p := range providers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		watched[p] = struct{}{}
+		w.check(ctx, p)
+	}
+
+	// Forget the asks of providers that are no longer watched
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	for p := range w.asks {
+		if _, ok := watched[p]; !ok {
+			delete(w.asks, p)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) check(ctx context.Context, provider address.Address) {
+	ask, err := w.fetcher.StorageAsk(ctx, provider)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Infow("could not query storage ask", "provider", provider, "err", err)
+		}
+		return
+	}
+
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	old, ok := w.asks[provider]
+	w.asks[provider] = *ask
+	if !ok {
+		return
+	}
+
+	c := Change{Provider: provider, Old: old, New: *ask, At: time.Now()}
+	if !c.PriceChanged() && !c.SizeChanged() {
+		return
+	}
+
+	log.Infow("storage ask changed", "provider", provider,
+		"old-price", old.Price, "new-price", ask.Price,
+		"old-verified-price", old.VerifiedPrice, "new-verified-price", ask.VerifiedPrice,
+		"old-min-size", old.MinPieceSize, "new-min-size", ask.MinPieceSize,
+		"old-max-size", old.MaxPieceSize, "new-max-size", ask.MaxPieceSize)
+	for _, ch := range w.subs {
+		select {
+		case ch <- c:
+		default:
+			log.Warnw("dropping storage ask change for slow subscriber", "provider", provider)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package askwatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/stretchr/testify/require"
+)
+
+type mockFetcher struct {
+	lk   sync.Mutex
+	asks map[address.Address]*Ask
+}
+
+func (m *mockFetcher) StorageAsk(ctx context.Context, provider address.Address) (*Ask, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	ask, ok := m.asks[provider]
+	if !ok {
+		return nil, fmt.Errorf("provider %s unreachable", provider)
+	}
+	a := *ask
+	return &a, nil
+}
+
+func (m *mockFetcher) set(provider address.Address, ask *Ask) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.asks[provider] = ask
+}
+
+func TestWatcher(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov1, err := address.NewIDAddress(1)
+	req.NoError(err)
+	prov2, err := address.NewIDAddress(2)
+	req.NoError(err)
+
+	ask := Ask{Price: big.NewInt(10), VerifiedPrice: big.Zero(), MinPieceSize: 256, MaxPieceSize: 1 << 30}
+	f := &mockFetcher{asks: map[address.Address]*Ask{prov1: &ask}}
+	w := NewWatcher(f, StaticWatchlist(prov1, prov2), time.Hour)
+	changes, unsub := w.Subscribe()
+	defer unsub()
+
+	// The first poll establishes the baseline (and provider 2 is unreachable)
+	req.NoError(w.Poll(ctx))
+	got, ok := w.Ask(prov1)
+	req.True(ok)
+	req.Equal(ask, got)
+	_, ok = w.Ask(prov2)
+	req.False(ok)
+
+	// An unchanged ask doesn't generate a change
+	req.NoError(w.Poll(ctx))
+	req.Len(changes, 0)
+
+	// A price change generates a change
+	raised := ask
+	raised.Price = big.NewInt(20)
+	f.set(prov1, &raised)
+	req.NoError(w.Poll(ctx))
+	req.Len(changes, 1)
+	c := <-changes
+	req.Equal(prov1, c.Provider)
+	req.True(c.PriceChanged())
+	req.False(c.SizeChanged())
+	req.Equal(big.NewInt(10), c.Old.Price)
+	req.Equal(big.NewInt(20), c.New.Price)
+
+	// A piece size change generates a change
+	smaller := raised
+	smaller.MaxPieceSize = 1 << 20
+	f.set(prov1, &smaller)
+	req.NoError(w.Poll(ctx))
+	c = <-changes
+	req.False(c.PriceChanged())
+	req.True(c.SizeChanged())
+}
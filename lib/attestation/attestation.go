@@ -0,0 +1,134 @@
+// Package attestation creates and verifies signed statements from a client
+// that it retrieved (and verified) some data from a storage provider.
+// An attestation is stored alongside the retrieved CAR file, so that the
+// data can be handed on to others with a verifiable record of where it came
+// from.
+package attestation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/ipfs/go-cid"
+)
+
+// Version is the version of the attestation format
+const Version = 1
+
+// FileExt is appended to the path of the retrieved CAR file to get the path
+// of the attestation
+const FileExt = ".attestation.json"
+
+// Attestation describes a completed retrieval
+type Attestation struct {
+	Version int `json:"version"`
+	// The payload root cid of the retrieved data
+	RootCid cid.Cid `json:"rootCid"`
+	// The piece cid of the deal, if the data was retrieved by piece
+	PieceCid *cid.Cid `json:"pieceCid,omitempty"`
+	// The number of bytes retrieved
+	Size int64 `json:"size"`
+	// The address of the storage provider the data was retrieved from
+	Provider string `json:"provider"`
+	// The on-chain id of the deal
+	DealID uint64 `json:"dealId"`
+	// When the retrieval completed
+	Timestamp time.Time `json:"timestamp"`
+	// The address of the client wallet that signs the attestation
+	Client string `json:"client"`
+}
+
+// Signed is an attestation with the client's signature over the attestation
+type Signed struct {
+	Attestation Attestation `json:"attestation"`
+	// Signature is the signature over the bytes returned by
+	// Attestation.SigningBytes
+	Signature *crypto.Signature `json:"signature"`
+}
+
+// SigningBytes returns the bytes of the attestation that are signed
+func (a *Attestation) SigningBytes() ([]byte, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("serializing attestation: %w", err)
+	}
+	return b, nil
+}
+
+// SignFn signs msg with the private key for addr
+type SignFn func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error)
+
+// Sign signs the attestation with the client's wallet key
+func Sign(ctx context.Context, sign SignFn, client address.Address, a Attestation) (*Signed, error) {
+	a.Version = Version
+	a.Client = client.String()
+	a.Timestamp = a.Timestamp.UTC()
+	msg, err := a.SigningBytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(ctx, client, msg)
+	if err != nil {
+		return nil, fmt.Errorf("signing attestation with %s: %w", client, err)
+	}
+	return &Signed{Attestation: a, Signature: sig}, nil
+}
+
+// Verify checks that the attestation was signed by the client address in
+// the attestation
+func (s *Signed) Verify() error {
+	if s.Signature == nil {
+		return fmt.Errorf("attestation is not signed")
+	}
+	if s.Attestation.Version != Version {
+		return fmt.Errorf("unsupported attestation version %d", s.Attestation.Version)
+	}
+	client, err := address.NewFromString(s.Attestation.Client)
+	if err != nil {
+		return fmt.Errorf("parsing attestation client address '%s': %w", s.Attestation.Client, err)
+	}
+	msg, err := s.Attestation.SigningBytes()
+	if err != nil {
+		return err
+	}
+	if err := sigs.Verify(s.Signature, client, msg); err != nil {
+		return fmt.Errorf("invalid attestation signature for client %s: %w", client, err)
+	}
+	return nil
+}
+
+// Path returns the path of the attestation for the CAR file at carPath
+func Path(carPath string) string {
+	return carPath + FileExt
+}
+
+// Write writes the signed attestation to path
+func Write(path string, s *Signed) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing signed attestation: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing attestation to %s: %w", path, err)
+	}
+	return nil
+}
+
+// Read reads a signed attestation from path
+func Read(path string) (*Signed, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading attestation: %w", err)
+	}
+	var s Signed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parsing attestation %s: %w", path, err)
+	}
+	return &s, nil
+}
@@ -0,0 +1,72 @@
+package attestation
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet/key"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	k, err := key.GenerateKey(types.KTSecp256k1)
+	req.NoError(err)
+	sign := func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+		return sigs.Sign(crypto.SigTypeSecp256k1, k.PrivateKey, msg)
+	}
+
+	pieceCid := testutil.GenerateCid()
+	signed, err := Sign(ctx, sign, k.Address, Attestation{
+		RootCid:   testutil.GenerateCid(),
+		PieceCid:  &pieceCid,
+		Size:      1234,
+		Provider:  "f01000",
+		DealID:    42,
+		Timestamp: time.Now(),
+	})
+	req.NoError(err)
+	req.NoError(signed.Verify())
+
+	// The attestation can be verified after being written and read back
+	carPath := filepath.Join(t.TempDir(), "out.car")
+	req.NoError(Write(Path(carPath), signed))
+	read, err := Read(carPath + ".attestation.json")
+	req.NoError(err)
+	req.NoError(read.Verify())
+	req.Equal(k.Address.String(), read.Attestation.Client)
+	req.EqualValues(42, read.Attestation.DealID)
+
+	// Changing any field invalidates the signature
+	read.Attestation.Size = 4321
+	req.Error(read.Verify())
+
+	// An attestation that claims to be signed by another client is rejected
+	other, err := key.GenerateKey(types.KTSecp256k1)
+	req.NoError(err)
+	forged := *signed
+	forged.Attestation.Client = other.Address.String()
+	req.Error(forged.Verify())
+
+	// Tampering with the file is detected
+	b, err := os.ReadFile(Path(carPath))
+	req.NoError(err)
+	var raw map[string]json.RawMessage
+	req.NoError(json.Unmarshal(b, &raw))
+	req.NoError(os.WriteFile(Path(carPath), []byte(`{"attestation":{"version":1,"size":1},"signature":`+string(raw["signature"])+`}`), 0644))
+	tampered, err := Read(Path(carPath))
+	req.NoError(err)
+	req.Error(tampered.Verify())
+}
@@ -0,0 +1,63 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	carbs "github.com/ipld/go-car/v2/blockstore"
+)
+
+// VerifyCar checks that the data in the CAR file at path can be attested to:
+// that each block's data matches its cid, and that the file contains the
+// complete DAG under root
+func VerifyCar(ctx context.Context, path string, root cid.Cid) error {
+	if err := verifyBlocks(path); err != nil {
+		return err
+	}
+
+	bs, err := carbs.OpenReadOnly(path)
+	if err != nil {
+		return fmt.Errorf("opening CAR file %s: %w", path, err)
+	}
+	defer bs.Close() //nolint:errcheck
+
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	err = merkledag.Walk(ctx, merkledag.GetLinksWithDAG(dag), root, cid.NewSet().Visit)
+	if err != nil {
+		return fmt.Errorf("CAR file %s does not contain the complete DAG under %s: %w", path, root, err)
+	}
+	return nil
+}
+
+// verifyBlocks checks that the data of each block in the CAR file matches
+// its cid
+func verifyBlocks(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening CAR file %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	// The block reader checks each block's data against its cid
+	br, err := carv2.NewBlockReader(f)
+	if err != nil {
+		return fmt.Errorf("reading CAR header of %s: %w", path, err)
+	}
+	for {
+		_, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("verifying blocks of CAR file %s: %w", path, err)
+		}
+	}
+}
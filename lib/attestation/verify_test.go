@@ -0,0 +1,65 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	dstest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/importer"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCar(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	// A UnixFS file of 2000 bytes in 256 byte chunks
+	dag := dstest.Mock()
+	data := make([]byte, 2000)
+	_, err := rand.Read(data)
+	req.NoError(err)
+	file, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	req.NoError(err)
+
+	var buf bytes.Buffer
+	req.NoError(car.WriteCar(ctx, dag, []cid.Cid{file.Cid()}, &buf))
+	carBytes := append([]byte{}, buf.Bytes()...)
+	dir := t.TempDir()
+	writeCar := func(name string, b []byte) string {
+		path := filepath.Join(dir, name)
+		req.NoError(os.WriteFile(path, b, 0644))
+		return path
+	}
+
+	req.NoError(VerifyCar(ctx, writeCar("ok.car", carBytes), file.Cid()))
+
+	// A block whose data doesn't match its cid
+	corrupt := append([]byte{}, carBytes...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	req.Error(VerifyCar(ctx, writeCar("corrupt.car", corrupt), file.Cid()))
+
+	// A CAR file that is missing a block of the DAG
+	buf.Reset()
+	req.NoError(car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{file.Cid()}, Version: 1}, &buf))
+	req.NoError(util.LdWrite(&buf, file.Cid().Bytes(), file.RawData()))
+	for _, l := range file.Links()[1:] {
+		nd, err := dag.Get(ctx, l.Cid)
+		req.NoError(err)
+		req.NoError(util.LdWrite(&buf, nd.Cid().Bytes(), nd.RawData()))
+	}
+	req.Error(VerifyCar(ctx, writeCar("incomplete.car", buf.Bytes()), file.Cid()))
+
+	// The DAG under any of the blocks can be verified, but not the DAG
+	// under a block that isn't in the CAR file
+	req.NoError(VerifyCar(ctx, writeCar("sub-dag.car", carBytes), file.Links()[0].Cid))
+	req.Error(VerifyCar(ctx, writeCar("other-root.car", carBytes), testutil.GenerateCid()))
+}
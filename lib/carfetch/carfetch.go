@@ -0,0 +1,390 @@
+// Package carfetch downloads a CAR file over http from one of several
+// providers that hold the same data, as one logical retrieval.
+//
+// If the download from a provider fails part way, the download continues
+// from the next provider. When the next provider serves the same piece
+// (the piece cid is in the Etag returned by booster-http), the download
+// resumes from the bytes already received with a Range request. Otherwise
+// the bytes already received are discarded and the download starts again.
+package carfetch
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	car "github.com/ipld/go-car"
+)
+
+var log = logging.Logger("carfetch")
+
+// Source is a provider that may serve the CAR file
+type Source struct {
+	// The name of the source, eg the provider address
+	Name string
+	// Endpoint returns the url of the source's http retrieval endpoint
+	Endpoint func(ctx context.Context) (string, error)
+}
+
+// Attempt is the result of trying to download the CAR file from a source
+type Attempt struct {
+	Source string `json:"source"`
+	// The offset in the CAR file that the source was asked for
+	Offset int64 `json:"offset"`
+	// The number of bytes received from the source
+	Received int64  `json:"received"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Meter is charged for the data received from each source, eg to enforce a
+// cap on the cost of the retrieval
+type Meter interface {
+	// Start is called before data is requested from the source's http
+	// endpoint, starting at offset. If it returns an error the source is
+	// skipped.
+	Start(ctx context.Context, source string, endpoint string, offset int64) error
+	// Received is called as data is received from the source. If it returns
+	// an error the retrieval is aborted (it is not continued from the next
+	// source).
+	Received(n int64) error
+}
+
+type Option func(*options)
+
+type options struct {
+	meter Meter
+}
+
+// WithMeter charges the data received to the meter
+func WithMeter(m Meter) Option {
+	return func(o *options) {
+		o.meter = m
+	}
+}
+
+// AbortedError is returned when the meter aborts the retrieval
+type AbortedError struct {
+	Err error
+}
+
+func (e *AbortedError) Error() string {
+	return "retrieval aborted: " + e.Err.Error()
+}
+
+func (e *AbortedError) Unwrap() error {
+	return e.Err
+}
+
+// Result is the result of a retrieval
+type Result struct {
+	Roots    []cid.Cid
+	Size     int64
+	Attempts []Attempt
+}
+
+// Source returns the name of the source that completed the retrieval
+func (r *Result) Source() string {
+	if len(r.Attempts) == 0 {
+		return ""
+	}
+	return r.Attempts[len(r.Attempts)-1].Source
+}
+
+// Fetch downloads the CAR file selected by query (eg by piece cid or payload
+// cid) from the first source that serves it, moving on to the next source if
+// a download fails. It writes the CAR file to outPath and returns the roots
+// from the CAR header.
+func Fetch(ctx context.Context, sources []Source, query url.Values, outPath string, opts ...Option) (*Result, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no sources to retrieve from")
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", outPath, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	query.Set("format", "car")
+	res := &Result{}
+	var etag string
+	var offset int64
+	done := false
+	for _, src := range sources {
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+
+		a := Attempt{Source: src.Name, Offset: offset}
+		etag, err = fetchFrom(ctx, src, query, f, &a, etag, o.meter)
+		offset = a.Offset + a.Received
+		res.Attempts = append(res.Attempts, a)
+		var aerr *AbortedError
+		if errors.As(err, &aerr) {
+			return res, err
+		}
+		if err != nil {
+			logctx.Logger(ctx, log).Infow("retrieval from source failed, trying next source", "source", src.Name, "offset", offset, "err", err)
+			continue
+		}
+		done = true
+		break
+	}
+	if !done {
+		return res, fmt.Errorf("retrieval failed from all %d sources: %s", len(sources), res.Attempts[len(res.Attempts)-1].Error)
+	}
+
+	res.Size = offset
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+	hdr, err := car.ReadHeader(bufio.NewReader(f))
+	if err != nil {
+		return res, fmt.Errorf("reading CAR header: %w", err)
+	}
+	res.Roots = hdr.Roots
+	return res, nil
+}
+
+// fetchFrom downloads the CAR file from the source starting at a.Offset, and
+// writes it to f. If the source doesn't serve the same data as the bytes
+// already received (according to etag), the file is truncated and the
+// download starts from the beginning (and a.Offset is set to zero). It
+// returns the etag of the data, and sets the number of bytes received on a.
+func fetchFrom(ctx context.Context, src Source, query url.Values, f *os.File, a *Attempt, etag string, meter Meter) (string, error) {
+	err := func() error {
+		endpoint, err := src.Endpoint(ctx)
+		if err != nil {
+			return err
+		}
+
+		if meter != nil {
+			if err := meter.Start(ctx, src.Name, endpoint, a.Offset); err != nil {
+				return err
+			}
+		}
+
+		resp, err := get(ctx, endpoint, query, a.Offset)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close() //nolint:errcheck
+
+		start := a.Offset
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			if a.Offset == 0 || resp.Header.Get("Etag") == "" || resp.Header.Get("Etag") != etag {
+				// The source serves different data (or it can't be
+				// verified that it's the same), so start again
+				_ = resp.Body.Close()
+				resp, err = get(ctx, endpoint, query, 0)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close() //nolint:errcheck
+				if resp.StatusCode != http.StatusOK {
+					return statusError(resp)
+				}
+				start = 0
+			}
+		case http.StatusOK:
+			// The whole file is being sent
+			start = 0
+		default:
+			return statusError(resp)
+		}
+		if start != a.Offset {
+			logctx.Logger(ctx, log).Infow("source does not serve the same data, restarting retrieval", "source", src.Name, "discarded", a.Offset)
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			a.Offset = 0
+		}
+		etag = resp.Header.Get("Etag")
+
+		if _, err := f.Seek(a.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		var body io.Reader = resp.Body
+		if meter != nil {
+			body = &meteredReader{r: resp.Body, meter: meter}
+		}
+		a.Received, err = io.Copy(f, body)
+		if err != nil {
+			var aerr *AbortedError
+			if errors.As(err, &aerr) {
+				return err
+			}
+			return fmt.Errorf("receiving data from %s: %w", src.Name, err)
+		}
+		return nil
+	}()
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return etag, err
+}
+
+// meteredReader charges the data read to the meter
+type meteredReader struct {
+	r     io.Reader
+	meter Meter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if merr := r.meter.Received(int64(n)); merr != nil {
+			// Data that the meter refuses is discarded
+			return 0, &AbortedError{Err: merr}
+		}
+	}
+	return n, err
+}
+
+func get(ctx context.Context, endpoint string, query url.Values, offset int64) (*http.Response, error) {
+	u := endpoint + "/piece?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	logctx.Logger(ctx, log).Debugw("retrieving piece", "url", u, "offset", offset)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving %s: %w", u, err)
+	}
+	return resp, nil
+}
+
+// Open requests the CAR file selected by query (eg a partial CAR file
+// selected by payload cid and selector) from the source, and returns the
+// response body to stream it from
+func Open(ctx context.Context, src Source, query url.Values) (io.ReadCloser, error) {
+	endpoint, err := src.Endpoint(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting retrieval endpoint: %w", err)
+	}
+	query.Set("format", "car")
+	resp, err := get(ctx, endpoint, query, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close() //nolint:errcheck
+		return nil, statusError(resp)
+	}
+	return resp.Body, nil
+}
+
+// Probe checks that the source serves the CAR file selected by query, by
+// requesting only its first byte
+func Probe(ctx context.Context, src Source, query url.Values) error {
+	endpoint, err := src.Endpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("getting retrieval endpoint: %w", err)
+	}
+	u := endpoint + "/piece?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("probing %s: %w", u, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// ReadRange reads length bytes of the piece from the source, starting at
+// offset, eg to check that the source serves the same data as a local copy
+func ReadRange(ctx context.Context, src Source, query url.Values, offset int64, length int64) ([]byte, error) {
+	endpoint, err := src.Endpoint(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting retrieval endpoint: %w", err)
+	}
+	u := endpoint + "/piece?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading range from %s: %w", u, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("reading range from %s: range requests are not supported", u)
+		}
+		return nil, statusError(resp)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, length))
+	if err != nil {
+		return nil, fmt.Errorf("reading range from %s: %w", u, err)
+	}
+	return b, nil
+}
+
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("retrieving %s: status %d: %s", resp.Request.URL, resp.StatusCode, msg)
+}
+
+// Scoreboard ranks sources by the number of times retrievals from them have
+// failed, so that across many retrievals the next-best source is tried first
+type Scoreboard struct {
+	lk       sync.Mutex
+	failures map[string]int
+}
+
+func NewScoreboard() *Scoreboard {
+	return &Scoreboard{failures: make(map[string]int)}
+}
+
+// Record records the failed attempts of a retrieval
+func (s *Scoreboard) Record(attempts []Attempt) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for _, a := range attempts {
+		if a.Error != "" {
+			s.failures[a.Source]++
+		}
+	}
+}
+
+// Order returns the sources ordered by the fewest failures, keeping the
+// given order for sources with the same number of failures
+func (s *Scoreboard) Order(sources []Source) []Source {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	ordered := append([]Source{}, sources...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.failures[ordered[i].Name] < s.failures[ordered[j].Name]
+	})
+	return ordered
+}
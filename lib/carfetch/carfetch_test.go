@@ -0,0 +1,182 @@
+package carfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+
+	mh, err := multihash.Sum([]byte("root"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	root := cid.NewCidV1(cid.Raw, mh)
+
+	var buf bytes.Buffer
+	require.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, &buf))
+	data := make([]byte, 64*1024)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	buf.Write(data)
+	content := buf.Bytes()
+
+	// serve serves the content with the etag. If failAfter is non-zero the
+	// connection is dropped after failAfter bytes.
+	var ranges []string
+	serve := func(etag string, content []byte, failAfter int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.Header().Set("Etag", etag)
+			if failAfter > 0 {
+				w.Header().Set("Content-Length", "100000")
+				_, _ = w.Write(content[:failAfter])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}))
+	}
+	source := func(name string, svr *httptest.Server) Source {
+		return Source{Name: name, Endpoint: func(context.Context) (string, error) { return svr.URL, nil }}
+	}
+	unreachable := Source{Name: "unreachable", Endpoint: func(context.Context) (string, error) {
+		return "", errors.New("no http endpoint")
+	}}
+
+	failing := serve("piece.car", content, 1000)
+	defer failing.Close()
+	same := serve("piece.car", content, 0)
+	defer same.Close()
+	other := serve("other.car", content, 0)
+	defer other.Close()
+
+	t.Run("resume from provider with same piece", func(t *testing.T) {
+		ranges = nil
+		out := filepath.Join(t.TempDir(), "out.car")
+		res, err := Fetch(ctx, []Source{unreachable, source("failing", failing), source("same", same)}, url.Values{}, out)
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{root}, res.Roots)
+		require.EqualValues(t, len(content), res.Size)
+		require.Equal(t, "same", res.Source())
+		require.Len(t, res.Attempts, 3)
+		require.NotEmpty(t, res.Attempts[0].Error)
+		require.EqualValues(t, 1000, res.Attempts[1].Received)
+		require.EqualValues(t, 1000, res.Attempts[2].Offset)
+		require.EqualValues(t, len(content)-1000, res.Attempts[2].Received)
+		require.Equal(t, []string{"", "bytes=1000-"}, ranges)
+
+		got, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, content, got)
+	})
+
+	t.Run("restart from provider with different piece", func(t *testing.T) {
+		ranges = nil
+		out := filepath.Join(t.TempDir(), "out.car")
+		res, err := Fetch(ctx, []Source{source("failing", failing), source("other", other)}, url.Values{}, out)
+		require.NoError(t, err)
+		require.EqualValues(t, len(content), res.Size)
+		require.Zero(t, res.Attempts[1].Offset)
+		require.Equal(t, []string{"", "bytes=1000-", ""}, ranges)
+
+		got, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, content, got)
+	})
+
+	t.Run("all sources fail", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.car")
+		res, err := Fetch(ctx, []Source{unreachable, source("failing", failing)}, url.Values{}, out)
+		require.Error(t, err)
+		require.Len(t, res.Attempts, 2)
+
+		sb := NewScoreboard()
+		sb.Record(res.Attempts)
+		ordered := sb.Order([]Source{source("failing", failing), source("same", same)})
+		require.Equal(t, "same", ordered[0].Name)
+	})
+	t.Run("meter refuses source and aborts retrieval", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.car")
+		m := &testMeter{refuse: "same", limit: 2000}
+		res, err := Fetch(ctx, []Source{source("same", same), source("other", other), source("failing", failing)}, url.Values{}, out, WithMeter(m))
+		var aerr *AbortedError
+		require.ErrorAs(t, err, &aerr)
+		// The refused source is skipped, and the retrieval isn't continued
+		// from the last source once it's aborted
+		require.Len(t, res.Attempts, 2)
+		require.Equal(t, "refused", res.Attempts[0].Error)
+		require.Equal(t, []string{"other"}, m.started)
+		require.LessOrEqual(t, m.received, int64(2000))
+	})
+	t.Run("probe", func(t *testing.T) {
+		ranges = nil
+		require.NoError(t, Probe(ctx, source("same", same), url.Values{}))
+		require.Equal(t, []string{"bytes=0-0"}, ranges)
+		require.Error(t, Probe(ctx, unreachable, url.Values{}))
+	})
+}
+
+type testMeter struct {
+	refuse   string
+	limit    int64
+	started  []string
+	received int64
+}
+
+func (m *testMeter) Start(ctx context.Context, source string, endpoint string, offset int64) error {
+	if source == m.refuse {
+		return errors.New("refused")
+	}
+	m.started = append(m.started, source)
+	return nil
+}
+
+func (m *testMeter) Received(n int64) error {
+	if m.received+n > m.limit {
+		return errors.New("over limit")
+	}
+	m.received += n
+	return nil
+}
+
+func TestReadRange(t *testing.T) {
+	ctx := context.Background()
+
+	content := make([]byte, 4096)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/piece", r.URL.Path)
+		if r.URL.Query().Get("pieceCid") == "norange" {
+			_, _ = w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer svr.Close()
+	src := Source{Name: "src", Endpoint: func(context.Context) (string, error) { return svr.URL, nil }}
+
+	b, err := ReadRange(ctx, src, url.Values{"pieceCid": {"piece"}}, 1000, 100)
+	require.NoError(t, err)
+	require.Equal(t, content[1000:1100], b)
+
+	// A source that ignores the range is an error, rather than the start of
+	// the piece being compared with the range
+	_, err = ReadRange(ctx, src, url.Values{"pieceCid": {"norange"}}, 1000, 100)
+	require.ErrorContains(t, err, "range requests are not supported")
+}
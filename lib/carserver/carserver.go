@@ -0,0 +1,255 @@
+// Package carserver serves CAR files over http (or https) so that a storage
+// provider can download a deal's data directly from the client, with the
+// same http transfer that it uses to download from any other URL.
+//
+// Each CAR file is served at its own path, and requests must have an
+// Authorization header with a random token for the file. Range requests are
+// supported, so if a download fails part way the provider resumes it from the
+// bytes it has already received.
+package carserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/boost/transport/types"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("carserver")
+
+// PathPrefix is the path under which CAR files are served
+const PathPrefix = "/car/"
+
+type file struct {
+	path  string
+	token string
+	// The section of the file that is served
+	offset int64
+	size   int64
+	// Checks that the file can be served, eg that the storage it is on is
+	// available
+	check func() error
+	// The number of bytes from the start of the file that have been served
+	served int64
+	done   chan struct{}
+}
+
+// Server serves CAR files that have been added to it
+type Server struct {
+	publicURL string
+
+	lk    sync.Mutex
+	files map[string]*file
+}
+
+// New creates a server. The public URL is the base URL at which providers
+// can reach the server, eg https://client.example.com:8443
+func New(publicURL string) *Server {
+	return &Server{
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		files:     make(map[string]*file),
+	}
+}
+
+// FileOption configures how a CAR file is served
+type FileOption func(*file)
+
+// Section serves only the size bytes of the file starting at offset, eg the
+// CARv1 data inside a CARv2 file, so that it doesn't have to be copied out
+// first
+func Section(offset, size int64) FileOption {
+	return func(f *file) {
+		f.offset = offset
+		f.size = size
+	}
+}
+
+// HealthCheck is called before each request for the file is served. If it
+// returns an error the request fails with 503 Service Unavailable, so that
+// the provider retries the download later (eg when the file is on a network
+// mount that is temporarily unavailable).
+func HealthCheck(check func() error) FileOption {
+	return func(f *file) {
+		f.check = check
+	}
+}
+
+// Add serves the CAR file at path under the id (eg the deal uuid), and
+// returns the transfer parameters for a provider to download it
+func (s *Server) Add(id string, path string, opts ...FileOption) (*types.HttpRequest, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("getting size of CAR file %s: %w", path, err)
+	}
+	tok := make([]byte, 32)
+	if _, err := rand.Read(tok); err != nil {
+		return nil, fmt.Errorf("generating token: %w", err)
+	}
+	f := &file{
+		path:  path,
+		token: hex.EncodeToString(tok),
+		size:  st.Size(),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.offset < 0 || f.size < 0 || f.offset+f.size > st.Size() {
+		return nil, fmt.Errorf("section %d-%d is outside CAR file %s of size %d", f.offset, f.offset+f.size, path, st.Size())
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, ok := s.files[id]; ok {
+		return nil, fmt.Errorf("a CAR file is already served with id %s", id)
+	}
+	s.files[id] = f
+	return &types.HttpRequest{
+		URL:     s.publicURL + PathPrefix + id,
+		Headers: map[string]string{"Authorization": "Bearer " + f.token},
+	}, nil
+}
+
+// Remove stops serving the CAR file with the id
+func (s *Server) Remove(id string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	delete(s.files, id)
+}
+
+// Done returns a channel that is closed once a request has been served
+// through to the end of the CAR file with the id, ie the provider has
+// downloaded all of it. It returns nil if there is no file with the id.
+func (s *Server) Done(id string) <-chan struct{} {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return nil
+	}
+	return f.done
+}
+
+// Served returns the number of bytes of the CAR file with the id that have
+// been served, and its size
+func (s *Server) Served(id string) (int64, int64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return 0, 0
+	}
+	return f.served, f.size
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, PathPrefix)
+
+	s.lk.Lock()
+	f, ok := s.files[id]
+	s.lk.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if f.check != nil {
+		if err := f.check(); err != nil {
+			log.Warnw("CAR file is unavailable", "id", id, "path", f.path, "err", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	fd, err := os.Open(f.path)
+	if err != nil {
+		log.Errorw("opening CAR file", "id", id, "path", f.path, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer fd.Close() //nolint:errcheck
+	st, err := fd.Stat()
+	if err != nil {
+		log.Errorw("getting CAR file info", "id", id, "path", f.path, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugw("serving CAR file", "id", id, "range", r.Header.Get("Range"), "remote", r.RemoteAddr)
+	cw := &countWriter{ResponseWriter: w}
+	// ServeContent handles range requests
+	http.ServeContent(cw, r, "", st.ModTime(), io.NewSectionReader(fd, f.offset, f.size))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Work out where the response started from, to find out if it reached
+	// the end of the file
+	start := int64(0)
+	if cw.status == http.StatusPartialContent {
+		var end, size int64
+		cr := w.Header().Get("Content-Range")
+		if _, err := fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+			return
+		}
+	} else if cw.status != http.StatusOK {
+		return
+	}
+
+	// Only count the bytes that follow on from the bytes already served, eg
+	// when a download resumes from where it failed
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if start <= f.served && start+cw.n > f.served {
+		f.served = start + cw.n
+		if f.served == f.size {
+			log.Infow("CAR file has been downloaded", "id", id, "size", f.size)
+			close(f.done)
+		}
+	}
+}
+
+// countWriter counts the bytes written to the response body, and records the
+// status code
+type countWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
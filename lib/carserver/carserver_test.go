@@ -0,0 +1,156 @@
+package carserver
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	data := make([]byte, 1<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data.car")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	s := New("")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	s.publicURL = ts.URL
+
+	params, err := s.Add("deal", path)
+	require.NoError(t, err)
+	_, err = s.Add("deal", path)
+	require.Error(t, err)
+
+	get := func(rangeHdr string, auth bool) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, params.URL, nil)
+		require.NoError(t, err)
+		if auth {
+			for k, v := range params.Headers {
+				req.Header.Set(k, v)
+			}
+		}
+		if rangeHdr != "" {
+			req.Header.Set("Range", rangeHdr)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Requests without the token are rejected
+	resp := get("", false)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	// Download the first half of the file
+	resp = get("bytes=0-524287", true)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, data[:1<<19], body)
+
+	served, size := s.Served("deal")
+	require.EqualValues(t, 1<<19, served)
+	require.EqualValues(t, len(data), size)
+	select {
+	case <-s.Done("deal"):
+		require.Fail(t, "download should not be done")
+	default:
+	}
+
+	// A range that does not follow on from the bytes already served is not
+	// counted
+	resp = get("bytes=786432-", true)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	served, _ = s.Served("deal")
+	require.EqualValues(t, 1<<19, served)
+
+	// Resume the download
+	resp = get("bytes=524288-", true)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, data[1<<19:], body)
+
+	select {
+	case <-s.Done("deal"):
+	default:
+		require.Fail(t, "download should be done")
+	}
+
+	s.Remove("deal")
+	resp = get("", true)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_ = resp.Body.Close()
+	require.Nil(t, s.Done("deal"))
+}
+
+func TestServerSection(t *testing.T) {
+	data := make([]byte, 1<<16)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data.car")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	s := New("")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	s.publicURL = ts.URL
+
+	_, err = s.Add("outside", path, Section(1024, int64(len(data))))
+	require.Error(t, err)
+
+	var unavailable int32
+	params, err := s.Add("deal", path, Section(1024, 4096), HealthCheck(func() error {
+		if atomic.LoadInt32(&unavailable) == 1 {
+			return errors.New("mount is unavailable")
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+
+	get := func() *http.Response {
+		req, err := http.NewRequest(http.MethodGet, params.URL, nil)
+		require.NoError(t, err)
+		for k, v := range params.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// While the file is unavailable, requests fail so that they are retried
+	atomic.StoreInt32(&unavailable, 1)
+	resp := get()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	// Only the section of the file is served
+	atomic.StoreInt32(&unavailable, 0)
+	resp = get()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, data[1024:1024+4096], body)
+
+	select {
+	case <-s.Done("deal"):
+	default:
+		require.Fail(t, "download should be done")
+	}
+}
@@ -0,0 +1,206 @@
+// Package chunksum computes and compares checksums of fixed-size chunks of a
+// stream of data, so that corruption of data in transit can be localized to
+// the chunks that were corrupted, and only those chunks transferred again.
+//
+// Chunks are aligned to multiples of the chunk size from the start of the
+// data (not from the start of the range being checked), so that the
+// checksums of the same chunk computed by each side of a transfer match even
+// if the transfer was resumed part way through a chunk. The first and last
+// chunks of a range may be partial chunks.
+package chunksum
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Algorithm is the checksum of each chunk: CRC-32C (Castagnoli), which is
+// fast to compute and detects the kinds of corruption that happen in transit
+const Algorithm = "crc32c"
+
+// DefaultChunkSize is the default size of a chunk: 4MiB
+const DefaultChunkSize = 4 << 20
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Range is a range of bytes in the data
+type Range struct {
+	Offset uint64
+	Length uint64
+}
+
+// End is the offset of the byte after the range
+func (r Range) End() uint64 {
+	return r.Offset + r.Length
+}
+
+// String formats the range like a HTTP byte range, eg "0-1023"
+func (r Range) String() string {
+	return fmt.Sprintf("%d-%d", r.Offset, r.End()-1)
+}
+
+// Checksums are the checksums of the chunks of a range of the data
+type Checksums struct {
+	ChunkSize uint64
+	Range
+	Sums []uint32
+}
+
+// Chunks returns the ranges of the chunks that make up the range
+func (c *Checksums) Chunks() []Range {
+	return chunks(c.Range, c.ChunkSize)
+}
+
+func chunks(r Range, chunkSize uint64) []Range {
+	var rs []Range
+	for off := r.Offset; off < r.End(); {
+		end := (off/chunkSize + 1) * chunkSize
+		if end > r.End() {
+			end = r.End()
+		}
+		rs = append(rs, Range{Offset: off, Length: end - off})
+		off = end
+	}
+	return rs
+}
+
+// String encodes the checksums as a header value, eg
+// "crc32c;chunk=4194304;offset=0;length=5000000;sums=1b3f0a2c,99e0c4d1"
+func (c *Checksums) String() string {
+	sums := make([]string, 0, len(c.Sums))
+	for _, s := range c.Sums {
+		sums = append(sums, fmt.Sprintf("%08x", s))
+	}
+	return fmt.Sprintf("%s;chunk=%d;offset=%d;length=%d;sums=%s",
+		Algorithm, c.ChunkSize, c.Offset, c.Length, strings.Join(sums, ","))
+}
+
+// Parse decodes checksums encoded with Checksums.String
+func Parse(s string) (*Checksums, error) {
+	parts := strings.Split(s, ";")
+	if strings.TrimSpace(parts[0]) != Algorithm {
+		return nil, fmt.Errorf("unsupported checksum algorithm '%s'", parts[0])
+	}
+
+	c := &Checksums{}
+	var sums string
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("parsing '%s': expected key=value", p)
+		}
+		var err error
+		switch kv[0] {
+		case "chunk":
+			c.ChunkSize, err = strconv.ParseUint(kv[1], 10, 64)
+		case "offset":
+			c.Offset, err = strconv.ParseUint(kv[1], 10, 64)
+		case "length":
+			c.Length, err = strconv.ParseUint(kv[1], 10, 64)
+		case "sums":
+			sums = kv[1]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", kv[0], err)
+		}
+	}
+	if c.ChunkSize == 0 {
+		return nil, errors.New("chunk size must be set")
+	}
+
+	if sums != "" {
+		for _, sum := range strings.Split(sums, ",") {
+			v, err := strconv.ParseUint(sum, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parsing checksum '%s': %w", sum, err)
+			}
+			c.Sums = append(c.Sums, uint32(v))
+		}
+	}
+	if n := len(c.Chunks()); n != len(c.Sums) {
+		return nil, fmt.Errorf("expected %d checksums for range %s but got %d", n, c.Range, len(c.Sums))
+	}
+	return c, nil
+}
+
+// Mismatches compares checksums of the same range, and returns the chunks
+// whose checksums don't match
+func Mismatches(want *Checksums, got *Checksums) ([]Range, error) {
+	if want.ChunkSize != got.ChunkSize || want.Range != got.Range {
+		return nil, fmt.Errorf("checksums are for different chunks: %s (chunk size %d) vs %s (chunk size %d)",
+			want.Range, want.ChunkSize, got.Range, got.ChunkSize)
+	}
+	var bad []Range
+	for i, r := range want.Chunks() {
+		if want.Sums[i] != got.Sums[i] {
+			bad = append(bad, r)
+		}
+	}
+	return bad, nil
+}
+
+// Writer computes the checksums of the chunks of the data written to it
+type Writer struct {
+	chunkSize uint64
+	start     uint64
+	offset    uint64
+	sums      []uint32
+	sum       uint32
+}
+
+// NewWriter returns a writer for data that starts at offset
+func NewWriter(offset uint64, chunkSize uint64) *Writer {
+	return &Writer{chunkSize: chunkSize, start: offset, offset: offset}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// Write up to the end of the current chunk
+		end := (w.offset/w.chunkSize + 1) * w.chunkSize
+		l := uint64(len(p))
+		if l > end-w.offset {
+			l = end - w.offset
+		}
+		w.sum = crc32.Update(w.sum, table, p[:l])
+		w.offset += l
+		p = p[l:]
+		if w.offset == end {
+			w.sums = append(w.sums, w.sum)
+			w.sum = 0
+		}
+	}
+	return n, nil
+}
+
+// Checksums returns the checksums of the data written so far. The last chunk
+// is a partial chunk if the data doesn't end on a chunk boundary.
+func (w *Writer) Checksums() *Checksums {
+	sums := append([]uint32(nil), w.sums...)
+	if w.offset%w.chunkSize != 0 && w.offset > w.start {
+		sums = append(sums, w.sum)
+	}
+	return &Checksums{
+		ChunkSize: w.chunkSize,
+		Range:     Range{Offset: w.start, Length: w.offset - w.start},
+		Sums:      sums,
+	}
+}
+
+// Compute reads the range of data from r, which is positioned at the
+// start of the range, and returns the checksums of its chunks
+func Compute(r io.Reader, rg Range, chunkSize uint64) (*Checksums, error) {
+	w := NewWriter(rg.Offset, chunkSize)
+	n, err := io.Copy(w, io.LimitReader(r, int64(rg.Length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) != rg.Length {
+		return nil, fmt.Errorf("range %s: read %d bytes but expected %d: %w", rg, n, rg.Length, io.ErrUnexpectedEOF)
+	}
+	return w.Checksums(), nil
+}
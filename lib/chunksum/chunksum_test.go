@@ -0,0 +1,94 @@
+package chunksum
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunks(t *testing.T) {
+	req := require.New(t)
+
+	c := &Checksums{ChunkSize: 10, Range: Range{Offset: 5, Length: 22}}
+	req.Equal([]Range{{5, 5}, {10, 10}, {20, 7}}, c.Chunks())
+
+	c = &Checksums{ChunkSize: 10, Range: Range{Offset: 10, Length: 20}}
+	req.Equal([]Range{{10, 10}, {20, 10}}, c.Chunks())
+
+	req.Empty((&Checksums{ChunkSize: 10}).Chunks())
+	req.Equal("10-19", Range{Offset: 10, Length: 10}.String())
+}
+
+func TestWriter(t *testing.T) {
+	req := require.New(t)
+
+	data := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(data) //nolint:gosec
+
+	// The checksums don't depend on how the data is split into writes
+	all, err := Compute(bytes.NewReader(data[7:]), Range{Offset: 7, Length: 93}, 16)
+	req.NoError(err)
+	req.Len(all.Sums, 7)
+	w := NewWriter(7, 16)
+	off := 7
+	for _, n := range []int{1, 20, 3, 40, 29} {
+		_, err := w.Write(data[off : off+n])
+		req.NoError(err)
+		off += n
+	}
+	req.Equal(all, w.Checksums())
+
+	// The checksums of a chunk are the same when computed over a range that
+	// starts at the chunk
+	part, err := Compute(bytes.NewReader(make([]byte, 32)), Range{Offset: 16, Length: 32}, 16)
+	req.NoError(err)
+	whole, err := Compute(bytes.NewReader(make([]byte, 48)), Range{Offset: 0, Length: 48}, 16)
+	req.NoError(err)
+	req.Equal(whole.Sums[1:], part.Sums)
+
+	// There's no partial chunk at the end if the data ends on a chunk boundary
+	req.Len(whole.Sums, 3)
+
+	_, err = Compute(bytes.NewReader(make([]byte, 10)), Range{Offset: 0, Length: 20}, 16)
+	req.Error(err)
+}
+
+func TestParseAndMismatches(t *testing.T) {
+	req := require.New(t)
+
+	data := bytes.Repeat([]byte("chunk of data "), 10)
+	want, err := Compute(bytes.NewReader(data), Range{Length: uint64(len(data))}, 32)
+	req.NoError(err)
+
+	parsed, err := Parse(want.String())
+	req.NoError(err)
+	req.Equal(want, parsed)
+
+	// Corrupt a byte in the third chunk
+	data[70] ^= 0xff
+	got, err := Compute(bytes.NewReader(data), Range{Length: uint64(len(data))}, 32)
+	req.NoError(err)
+	bad, err := Mismatches(want, got)
+	req.NoError(err)
+	req.Equal([]Range{{Offset: 64, Length: 32}}, bad)
+
+	// Checksums of different ranges can't be compared
+	_, err = Mismatches(want, &Checksums{ChunkSize: 32, Range: Range{Offset: 32, Length: 10}, Sums: []uint32{1}})
+	req.Error(err)
+
+	for _, s := range []string{
+		"md5;chunk=32;offset=0;length=10;sums=00000000",
+		"crc32c;offset=0;length=10;sums=00000000",
+		"crc32c;chunk=32;offset=0;length=40;sums=00000000",
+		"crc32c;chunk=32;offset=0;length=10;sums=xyz",
+	} {
+		_, err := Parse(s)
+		req.Error(err, s)
+	}
+
+	empty, err := Parse("crc32c;chunk=32;offset=10;length=0;sums=")
+	req.NoError(err)
+	req.Empty(empty.Sums)
+}
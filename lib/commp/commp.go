@@ -0,0 +1,177 @@
+// Package commp provides pluggable backends for calculating the piece
+// commitment (commP) of deal data.
+//
+// The default "go" backend uses the pure-Go commP calculator, which hashes with
+// sha256-simd and so automatically makes use of the SHA extensions or
+// AVX-512 when the CPU supports them. Accelerated implementations (eg an
+// external GPU service) can be plugged in with Register.
+//
+// A Pool runs calculations with any backend on a limited number of workers
+// and reports the progress of each.
+package commp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// BackendGo is the name of the default, pure-Go backend
+const BackendGo = "go"
+
+// The size of the buffer used to read data into the pure-Go calculator
+const readBufSize = 1 << 20
+
+// Calculator calculates commP over a stream of data
+type Calculator interface {
+	// Name returns the name of the backend
+	Name() string
+	// Sum reads data until EOF and returns the unpadded piece info of the data
+	Sum(ctx context.Context, data io.Reader) (abi.PieceInfo, error)
+}
+
+// Config is the configuration used to create a Calculator
+type Config struct {
+	// The name of the backend (defaults to BackendGo)
+	Backend string
+	// The address of the backend service, for backends that run out of process
+	Endpoint string
+}
+
+// Constructor creates a Calculator from config
+type Constructor func(cfg Config) (Calculator, error)
+
+var (
+	backendsLk sync.RWMutex
+	backends   = map[string]Constructor{
+		BackendGo:   func(Config) (Calculator, error) { return &goCalculator{}, nil },
+		BackendHttp: func(cfg Config) (Calculator, error) { return newHttpCalculator(cfg) },
+	}
+)
+
+// Register makes a backend available by name. It is intended to be called
+// from the init function of packages that implement accelerated backends.
+func Register(name string, ctor Constructor) {
+	backendsLk.Lock()
+	defer backendsLk.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("commp backend %s already registered", name))
+	}
+	backends[name] = ctor
+}
+
+// Backends returns the names of all registered backends
+func Backends() []string {
+	backendsLk.RLock()
+	defer backendsLk.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a Calculator for the backend in the config
+func New(cfg Config) (Calculator, error) {
+	if cfg.Backend == "" {
+		cfg.Backend = BackendGo
+	}
+
+	backendsLk.RLock()
+	ctor, ok := backends[cfg.Backend]
+	backendsLk.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown commp backend '%s': must be one of %v", cfg.Backend, Backends())
+	}
+
+	calc, err := ctor(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating commp backend %s: %w", cfg.Backend, err)
+	}
+	return calc, nil
+}
+
+// Default returns the pure-Go Calculator
+func Default() Calculator {
+	return &goCalculator{}
+}
+
+type goCalculator struct{}
+
+func (c *goCalculator) Name() string {
+	return BackendGo
+}
+
+func (c *goCalculator) Sum(ctx context.Context, data io.Reader) (abi.PieceInfo, error) {
+	calc := &commp.Calc{}
+	_, err := io.CopyBuffer(calc, &ctxReader{ctx: ctx, r: data}, make([]byte, readBufSize))
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("writing to commp calculator: %w", err)
+	}
+
+	rawCommP, paddedSize, err := calc.Digest()
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("calculating commp: %w", err)
+	}
+
+	pieceCid, err := commcid.DataCommitmentV1ToCID(rawCommP)
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("converting commp to cid: %w", err)
+	}
+	return abi.PieceInfo{Size: abi.PaddedPieceSize(paddedSize), PieceCID: pieceCid}, nil
+}
+
+// ErrMismatch is returned by Verify when the data doesn't have the expected
+// piece cid
+var ErrMismatch = errors.New("piece cid mismatch")
+
+// Verify calculates the piece cid of the data with calc, padded with zeros
+// to fill a piece of pieceSize, and checks that it is pieceCid
+func Verify(ctx context.Context, calc Calculator, data io.Reader, pieceCid cid.Cid, pieceSize abi.PaddedPieceSize) error {
+	pi, err := calc.Sum(ctx, data)
+	if err != nil {
+		return err
+	}
+	if pi.Size > pieceSize {
+		return fmt.Errorf("%w: the data fills a piece of %d bytes, larger than the piece of %d bytes", ErrMismatch, pi.Size, pieceSize)
+	}
+	if pi.Size < pieceSize {
+		// we know how long a pieceCid "hash" is, just blindly extract the trailing 32 bytes
+		rawPaddedCommp, err := commp.PadCommP(pi.PieceCID.Hash()[len(pi.PieceCID.Hash())-32:], uint64(pi.Size), uint64(pieceSize))
+		if err != nil {
+			return fmt.Errorf("padding commp: %w", err)
+		}
+		pi.PieceCID, err = commcid.DataCommitmentV1ToCID(rawPaddedCommp)
+		if err != nil {
+			return fmt.Errorf("converting commp to cid: %w", err)
+		}
+	}
+	if !pi.PieceCID.Equals(pieceCid) {
+		return fmt.Errorf("%w: the data has piece cid %s, expected %s", ErrMismatch, pi.PieceCID, pieceCid)
+	}
+	return nil
+}
+
+// ctxReader stops reading when the context is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,102 @@
+package commp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpBackend(t *testing.T) {
+	ctx := context.Background()
+
+	data := make([]byte, 4096)
+	_, err := rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+
+	expected, err := Default().Sum(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// Run a commp service that uses the go backend
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/commp" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pi, err := Default().Sum(r.Context(), r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(HttpResponse{PieceCID: pi.PieceCID.String(), PieceSize: pi.Size})
+	}))
+	defer srv.Close()
+
+	calc, err := New(Config{Backend: BackendHttp, Endpoint: srv.URL})
+	require.NoError(t, err)
+	require.Equal(t, BackendHttp, calc.Name())
+
+	pi, err := calc.Sum(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, expected, pi)
+
+	_, err = New(Config{Backend: BackendHttp})
+	require.Error(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", func(Config) (Calculator, error) { return &goCalculator{}, nil })
+	require.Contains(t, Backends(), "test")
+	require.Panics(t, func() {
+		Register("test", nil)
+	})
+
+	calc, err := New(Config{})
+	require.NoError(t, err)
+	require.Equal(t, BackendGo, calc.Name())
+
+	_, err = New(Config{Backend: "unknown"})
+	require.Error(t, err)
+
+	pi, err := calc.Sum(context.Background(), bytes.NewReader(make([]byte, 127)))
+	require.NoError(t, err)
+	require.Equal(t, abi.PaddedPieceSize(128), pi.Size)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	data := make([]byte, 4096)
+	_, err := rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	pi, err := Default().Sum(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, Verify(ctx, Default(), bytes.NewReader(data), pi.PieceCID, pi.Size))
+
+	// Data that doesn't fill the piece is padded with zeros
+	pieceSize := abi.PaddedPieceSize(32 << 10)
+	padded := make([]byte, pieceSize.Unpadded())
+	copy(padded, data)
+	piPadded, err := Default().Sum(ctx, bytes.NewReader(padded))
+	require.NoError(t, err)
+	require.Equal(t, pieceSize, piPadded.Size)
+	require.NoError(t, Verify(ctx, Default(), bytes.NewReader(data), piPadded.PieceCID, pieceSize))
+	require.NoError(t, Verify(ctx, Default(), bytes.NewReader(padded), piPadded.PieceCID, pieceSize))
+
+	// Data that has changed doesn't match
+	data[100]++
+	err = Verify(ctx, Default(), bytes.NewReader(data), piPadded.PieceCID, pieceSize)
+	require.ErrorIs(t, err, ErrMismatch)
+
+	// Data that is larger than the piece doesn't match
+	err = Verify(ctx, Default(), bytes.NewReader(padded), piPadded.PieceCID, pi.Size)
+	require.ErrorIs(t, err, ErrMismatch)
+}
@@ -0,0 +1,82 @@
+package commp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// BackendHttp is the name of the backend that streams data to an external
+// commP service (eg a GPU accelerated service) over HTTP
+const BackendHttp = "http"
+
+// HttpResponse is the response expected from an external commP service.
+//
+// The service receives the data as the body of a POST request to
+// <endpoint>/commp and responds with the json-encoded piece cid and the
+// padded piece size.
+type HttpResponse struct {
+	PieceCID  string
+	PieceSize abi.PaddedPieceSize
+}
+
+type httpCalculator struct {
+	url    string
+	client *http.Client
+}
+
+func newHttpCalculator(cfg Config) (*httpCalculator, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("no endpoint configured for http commp backend")
+	}
+	if !strings.HasPrefix(cfg.Endpoint, "http://") && !strings.HasPrefix(cfg.Endpoint, "https://") {
+		return nil, fmt.Errorf("http commp backend endpoint %s must start with http:// or https://", cfg.Endpoint)
+	}
+	return &httpCalculator{
+		url:    strings.TrimSuffix(cfg.Endpoint, "/") + "/commp",
+		client: http.DefaultClient,
+	}, nil
+}
+
+func (c *httpCalculator) Name() string {
+	return BackendHttp
+}
+
+func (c *httpCalculator) Sum(ctx context.Context, data io.Reader) (abi.PieceInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, data)
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("sending data to %s: %w", c.url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return abi.PieceInfo{}, fmt.Errorf("commp service %s returned status %d: %s", c.url, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var res HttpResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("decoding response from %s: %w", c.url, err)
+	}
+
+	pieceCid, err := cid.Parse(res.PieceCID)
+	if err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("parsing piece cid %s from %s: %w", res.PieceCID, c.url, err)
+	}
+	if err := res.PieceSize.Validate(); err != nil {
+		return abi.PieceInfo{}, fmt.Errorf("invalid piece size from %s: %w", c.url, err)
+	}
+	return abi.PieceInfo{Size: res.PieceSize, PieceCID: pieceCid}, nil
+}
@@ -0,0 +1,209 @@
+package commp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// The states of a job in a Pool
+const (
+	// JobQueued means the job is waiting for a worker
+	JobQueued = "queued"
+	// JobRunning means a worker is calculating commP for the job
+	JobRunning = "running"
+)
+
+// Job is the status of a commP calculation in a Pool
+type Job struct {
+	// The id of the job (eg the deal uuid)
+	ID string
+	// The name of the backend that calculates commP for the job
+	Backend string
+	// One of JobQueued or JobRunning
+	State string
+	// The number of bytes of data to read (zero if not known)
+	Size int64
+	// The number of bytes read so far
+	Read int64
+	// The position of the job in the queue (zero once it is running)
+	Position int
+	QueuedAt time.Time
+	// The time at which a worker started the job
+	StartedAt *time.Time
+}
+
+type poolJob struct {
+	id       string
+	backend  string
+	size     int64
+	read     int64 // accessed atomically
+	queuedAt time.Time
+	// closed when the job is given a worker
+	start     chan struct{}
+	startedAt *time.Time
+}
+
+// Pool calculates commP with a limited number of workers, so that the commP
+// calculations of large CAR files don't compete with each other (and with
+// the rest of boost) for CPU, or overload an external commP service. Jobs
+// wait in a queue for a worker in the order they were submitted, and the
+// progress of each job can be inspected with Jobs.
+type Pool struct {
+	lk      sync.Mutex
+	workers int
+	running int
+	queue   []*poolJob
+	jobs    map[*poolJob]struct{}
+}
+
+// NewPool creates a pool with the given number of workers (at least one)
+func NewPool(workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{workers: workers, jobs: make(map[*poolJob]struct{})}
+}
+
+// SetWorkers changes the number of workers. If the number is reduced, jobs
+// that are already running are allowed to finish.
+func (p *Pool) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.workers = workers
+	p.schedule()
+}
+
+// Sum waits for a worker and then calculates commP over data with calc. The
+// size is the number of bytes that will be read from data, and is used to
+// report progress (it may be zero if it isn't known).
+func (p *Pool) Sum(ctx context.Context, calc Calculator, id string, size int64, data io.Reader) (abi.PieceInfo, error) {
+	j := &poolJob{
+		id:       id,
+		backend:  calc.Name(),
+		size:     size,
+		queuedAt: time.Now(),
+		start:    make(chan struct{}),
+	}
+
+	p.lk.Lock()
+	p.jobs[j] = struct{}{}
+	p.queue = append(p.queue, j)
+	p.schedule()
+	p.lk.Unlock()
+
+	select {
+	case <-j.start:
+	case <-ctx.Done():
+		p.lk.Lock()
+		select {
+		case <-j.start:
+			// The job was given a worker at the same time as the context was
+			// cancelled
+			p.running--
+		default:
+			p.dequeue(j)
+		}
+		delete(p.jobs, j)
+		p.schedule()
+		p.lk.Unlock()
+		return abi.PieceInfo{}, fmt.Errorf("waiting for a commp worker: %w", ctx.Err())
+	}
+
+	defer func() {
+		p.lk.Lock()
+		p.running--
+		delete(p.jobs, j)
+		p.schedule()
+		p.lk.Unlock()
+	}()
+
+	return calc.Sum(ctx, &progressReader{r: data, n: &j.read})
+}
+
+// Jobs returns the running jobs followed by the queued jobs, in the order
+// they were submitted
+func (p *Pool) Jobs() []Job {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	positions := make(map[*poolJob]int, len(p.queue))
+	for i, j := range p.queue {
+		positions[j] = i + 1
+	}
+
+	jobs := make([]Job, 0, len(p.jobs))
+	for j := range p.jobs {
+		job := Job{
+			ID:       j.id,
+			Backend:  j.backend,
+			State:    JobRunning,
+			Size:     j.size,
+			Read:     atomic.LoadInt64(&j.read),
+			QueuedAt: j.queuedAt,
+		}
+		if pos, ok := positions[j]; ok {
+			job.State = JobQueued
+			job.Position = pos
+		} else if j.startedAt != nil {
+			startedAt := *j.startedAt
+			job.StartedAt = &startedAt
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		if jobs[i].Position != jobs[k].Position {
+			return jobs[i].Position < jobs[k].Position
+		}
+		return jobs[i].QueuedAt.Before(jobs[k].QueuedAt)
+	})
+	return jobs
+}
+
+// schedule gives workers to queued jobs while there are free workers. It
+// must be called with the lock held.
+func (p *Pool) schedule() {
+	for p.running < p.workers && len(p.queue) > 0 {
+		j := p.queue[0]
+		p.queue = p.queue[1:]
+		now := time.Now()
+		j.startedAt = &now
+		p.running++
+		close(j.start)
+	}
+}
+
+// dequeue removes a job from the queue. It must be called with the lock
+// held.
+func (p *Pool) dequeue(j *poolJob) {
+	for i, qj := range p.queue {
+		if qj == j {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// progressReader counts the bytes read from r
+type progressReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
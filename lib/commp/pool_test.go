@@ -0,0 +1,109 @@
+package commp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCalculator reads the data up to a limit and then blocks until it
+// is released
+type blockingCalculator struct {
+	limit   int64
+	release chan struct{}
+}
+
+func (c *blockingCalculator) Name() string {
+	return "blocking"
+}
+
+func (c *blockingCalculator) Sum(ctx context.Context, data io.Reader) (abi.PieceInfo, error) {
+	if _, err := io.CopyN(io.Discard, data, c.limit); err != nil {
+		return abi.PieceInfo{}, err
+	}
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		return abi.PieceInfo{}, ctx.Err()
+	}
+	return Default().Sum(ctx, data)
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 2048)
+
+	calc := &blockingCalculator{limit: 1024, release: make(chan struct{})}
+	pool := NewPool(1)
+
+	type result struct {
+		pi  abi.PieceInfo
+		err error
+	}
+	sum := func(ctx context.Context, id string) chan result {
+		res := make(chan result, 1)
+		go func() {
+			pi, err := pool.Sum(ctx, calc, id, int64(len(data)), bytes.NewReader(data))
+			res <- result{pi, err}
+		}()
+		return res
+	}
+	waitForJobs := func(n int) []Job {
+		var jobs []Job
+		require.Eventually(t, func() bool {
+			jobs = pool.Jobs()
+			return len(jobs) == n && (n == 0 || jobs[0].Read == calc.limit)
+		}, time.Second, time.Millisecond)
+		return jobs
+	}
+
+	// The first job runs and reports its progress, the others wait in the
+	// queue in the order they were submitted
+	res1 := sum(ctx, "1")
+	waitForJobs(1)
+	res2 := sum(ctx, "2")
+	waitForJobs(2)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	res3 := sum(cancelCtx, "3")
+	jobs := waitForJobs(3)
+
+	require.Equal(t, "1", jobs[0].ID)
+	require.Equal(t, JobRunning, jobs[0].State)
+	require.Equal(t, "blocking", jobs[0].Backend)
+	require.EqualValues(t, len(data), jobs[0].Size)
+	require.NotNil(t, jobs[0].StartedAt)
+	require.Equal(t, "2", jobs[1].ID)
+	require.Equal(t, JobQueued, jobs[1].State)
+	require.Equal(t, 1, jobs[1].Position)
+	require.Zero(t, jobs[1].Read)
+	require.Nil(t, jobs[1].StartedAt)
+	require.Equal(t, "3", jobs[2].ID)
+	require.Equal(t, 2, jobs[2].Position)
+
+	// A job that is cancelled while queued leaves the queue
+	cancel()
+	require.ErrorIs(t, (<-res3).err, context.Canceled)
+	jobs = waitForJobs(2)
+	require.Equal(t, "2", jobs[1].ID)
+	require.Equal(t, 1, jobs[1].Position)
+
+	// Adding a worker starts the next job
+	pool.SetWorkers(2)
+	require.Eventually(t, func() bool {
+		jobs = pool.Jobs()
+		return len(jobs) == 2 && jobs[1].State == JobRunning && jobs[1].Read == calc.limit
+	}, time.Second, time.Millisecond)
+
+	// Both jobs complete once they are released
+	close(calc.release)
+	r1, r2 := <-res1, <-res2
+	require.NoError(t, r1.err)
+	require.NoError(t, r2.err)
+	require.Equal(t, r1.pi, r2.pi)
+	waitForJobs(0)
+}
@@ -0,0 +1,115 @@
+package contentscan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// clamdChunkSize is the size of the chunks that content is streamed to
+// clamd in. It must be smaller than clamd's StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// Clamd scans content with a clamd daemon, using the INSTREAM command
+type Clamd struct {
+	// "tcp" or "unix"
+	Network string
+	Address string
+}
+
+func (c *Clamd) String() string {
+	// The address of a unix socket is an absolute path, so this gives the
+	// clamd:///path/to/clamd.sock form
+	return "clamd://" + c.Address
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	// Stop the scan if the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("sending INSTREAM to clamd: %w", err)
+	}
+
+	// The content is sent as chunks, each prefixed with its length, and
+	// terminated by a zero length chunk
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, rerr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd closes the connection if the stream exceeds its
+				// limits: read its reply to report why
+				if reply, rerr := readClamdReply(conn); rerr == nil {
+					return nil, fmt.Errorf("clamd: %s", reply)
+				}
+				return nil, fmt.Errorf("streaming content to clamd: %w", err)
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return nil, fmt.Errorf("reading content to scan: %w", rerr)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("streaming content to clamd: %w", err)
+	}
+
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || reply == "") {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseClamdReply parses a reply of the form
+// "stream: OK", "stream: <signature> FOUND" or "<message> ERROR"
+func parseClamdReply(reply string) (*Result, error) {
+	msg := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case msg == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return &Result{Flagged: true, Finding: strings.TrimSuffix(msg, " FOUND")}, nil
+	case strings.HasSuffix(msg, " ERROR"):
+		return nil, fmt.Errorf("clamd: %s", strings.TrimSuffix(msg, " ERROR"))
+	default:
+		return nil, fmt.Errorf("unexpected clamd reply '%s'", reply)
+	}
+}
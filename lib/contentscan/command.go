@@ -0,0 +1,52 @@
+package contentscan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Command scans content with a command, for scanners that don't speak the
+// clamd or ICAP protocols. The content is written to the command's stdin.
+// The command exits 0 if the content is clean, or 1 if it is flagged, with
+// what it found on stdout. Any other exit status means the content could
+// not be scanned.
+type Command struct {
+	Cmd string
+}
+
+func (c *Command) String() string {
+	return "cmd:" + c.Cmd
+}
+
+func (c *Command) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Cmd)
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return &Result{}, nil
+	}
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) && ctx.Err() == nil {
+		if eerr.ExitCode() == 1 {
+			finding := strings.TrimSpace(stdout.String())
+			if finding == "" {
+				finding = "flagged by scan command"
+			}
+			return &Result{Flagged: true, Finding: finding}, nil
+		}
+		return nil, fmt.Errorf("scan command exited with status %d: %s", eerr.ExitCode(), strings.TrimSpace(stderr.String()))
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, fmt.Errorf("running scan command: %w", err)
+}
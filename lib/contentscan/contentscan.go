@@ -0,0 +1,151 @@
+// Package contentscan streams retrieved content through an external scanner
+// (eg a virus scanner) and quarantines content that the scanner flags.
+package contentscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Result is the outcome of scanning content
+type Result struct {
+	// Whether the scanner flagged the content
+	Flagged bool
+	// What the scanner found, eg the name of a virus signature
+	Finding string
+}
+
+// Scanner scans a stream of content
+type Scanner interface {
+	// Scan reads r to the end (or until the scanner has reached a verdict)
+	// and returns the result. It returns an error if the content could not
+	// be scanned.
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+	// String describes the scanner, eg for log messages
+	String() string
+}
+
+// FlaggedError is returned when a scanner flags content
+type FlaggedError struct {
+	Scanner string
+	Finding string
+}
+
+func (e *FlaggedError) Error() string {
+	return fmt.Sprintf("content flagged by %s: %s", e.Scanner, e.Finding)
+}
+
+// Parse returns the scanner for a scanner spec:
+//   - clamd://host:port or clamd:///path/to/clamd.sock: a clamd daemon
+//   - icap://host:port/service: an ICAP server (eg c-icap, squidclamav)
+//   - cmd:<command>: a command that reads the content on stdin, and exits 0
+//     if the content is clean, 1 if it is flagged (with the finding on
+//     stdout) or with any other status if the content could not be scanned
+func Parse(spec string) (Scanner, error) {
+	if cmd := strings.TrimPrefix(spec, "cmd:"); cmd != spec {
+		if cmd == "" {
+			return nil, fmt.Errorf("scanner %s: missing command", spec)
+		}
+		return &Command{Cmd: cmd}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing scanner %s: %w", spec, err)
+	}
+	switch u.Scheme {
+	case "clamd":
+		if u.Host != "" {
+			return &Clamd{Network: "tcp", Address: u.Host}, nil
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("scanner %s: missing clamd address or socket path", spec)
+		}
+		return &Clamd{Network: "unix", Address: u.Path}, nil
+	case "icap":
+		if u.Host == "" {
+			return nil, fmt.Errorf("scanner %s: missing ICAP server address", spec)
+		}
+		return &ICAP{URL: u}, nil
+	default:
+		return nil, fmt.Errorf("scanner %s: unsupported scanner type '%s' (must be clamd, icap or cmd)", spec, u.Scheme)
+	}
+}
+
+// ScanFile scans the file at path. It returns a *FlaggedError if the
+// scanner flags the file.
+func ScanFile(ctx context.Context, s Scanner, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s to scan: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	res, err := s.Scan(ctx, f)
+	if err != nil {
+		return fmt.Errorf("scanning %s with %s: %w", path, s, err)
+	}
+	if res.Flagged {
+		return &FlaggedError{Scanner: s.String(), Finding: res.Finding}
+	}
+	return nil
+}
+
+// Quarantine moves the file at path into the quarantine directory, so that
+// flagged content is not left where it would be used. It returns the path
+// of the file in quarantine.
+func Quarantine(path string, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating quarantine dir %s: %w", dir, err)
+	}
+
+	dst := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(dst); err == nil {
+		// Don't overwrite an earlier file with the same name
+		for i := 1; ; i++ {
+			dst = filepath.Join(dir, fmt.Sprintf("%s.%d", filepath.Base(path), i))
+			if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+				break
+			}
+		}
+	}
+
+	if err := os.Rename(path, dst); err == nil {
+		return dst, nil
+	}
+
+	// The quarantine dir may be on another filesystem: copy the file and
+	// remove the original
+	if err := copyFile(path, dst); err != nil {
+		_ = os.Remove(dst)
+		return "", fmt.Errorf("moving %s to quarantine: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("removing %s after copying it to quarantine: %w", path, err)
+	}
+	return dst, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,208 @@
+package contentscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const eicar = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+
+// listen serves each connection with handle, and returns the listener's
+// address
+func listen(t *testing.T, handle func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:errcheck
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// fakeClamd flags streams that contain the EICAR test string
+func fakeClamd(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	cmd, err := br.ReadString(0)
+	if err != nil || cmd != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00")) //nolint:errcheck
+		return
+	}
+	var content bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&content, br, int64(size)); err != nil {
+			return
+		}
+	}
+	if bytes.Contains(content.Bytes(), []byte(eicar)) {
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00")) //nolint:errcheck
+		return
+	}
+	conn.Write([]byte("stream: OK\x00")) //nolint:errcheck
+}
+
+// fakeICAP flags RESPMOD bodies that contain the EICAR test string
+func fakeICAP(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+	if _, err := tp.ReadLine(); err != nil {
+		return
+	}
+	if _, err := tp.ReadMIMEHeader(); err != nil {
+		return
+	}
+	// Skip the encapsulated request and response headers, which each end
+	// with a blank line
+	for i := 0; i < 2; {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		if line == "" {
+			i++
+		}
+	}
+	content, err := io.ReadAll(httputil.NewChunkedReader(br))
+	if err != nil {
+		return
+	}
+	// Read the blank line that ends the chunked body
+	if _, err := tp.ReadLine(); err != nil {
+		return
+	}
+	if bytes.Contains(content, []byte(eicar)) {
+		fmt.Fprintf(conn, "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\nEncapsulated: null-body=0\r\n\r\n")
+		return
+	}
+	fmt.Fprintf(conn, "ICAP/1.0 204 No Content\r\n\r\n")
+}
+
+func TestScanners(t *testing.T) {
+	ctx := context.Background()
+	clean := bytes.Repeat([]byte("clean content "), 20000)
+	infected := append(append([]byte{}, clean...), []byte(eicar)...)
+
+	icapURL, err := url.Parse("icap://" + listen(t, fakeICAP) + "/avscan")
+	require.NoError(t, err)
+	scanners := []Scanner{
+		&Clamd{Network: "tcp", Address: listen(t, fakeClamd)},
+		&ICAP{URL: icapURL},
+		&Command{Cmd: "if grep -q EICAR; then echo Eicar-Test-Signature; exit 1; fi"},
+	}
+	for _, s := range scanners {
+		t.Run(s.String(), func(t *testing.T) {
+			res, err := s.Scan(ctx, bytes.NewReader(clean))
+			require.NoError(t, err)
+			require.False(t, res.Flagged)
+
+			res, err = s.Scan(ctx, bytes.NewReader(infected))
+			require.NoError(t, err)
+			require.True(t, res.Flagged)
+			require.Equal(t, "Eicar-Test-Signature", res.Finding)
+		})
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// clamd reports an error, eg because the stream is too large
+	s := &Clamd{Network: "tcp", Address: listen(t, func(conn net.Conn) {
+		conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00")) //nolint:errcheck
+	})}
+	_, err := s.Scan(ctx, strings.NewReader("content"))
+	require.ErrorContains(t, err, "size limit exceeded")
+
+	// The ICAP server doesn't know the service
+	icapURL, err := url.Parse("icap://" + listen(t, func(conn net.Conn) {
+		fmt.Fprintf(conn, "ICAP/1.0 404 ICAP Service not found\r\n\r\n")
+	}) + "/unknown")
+	require.NoError(t, err)
+	_, err = (&ICAP{URL: icapURL}).Scan(ctx, strings.NewReader("content"))
+	require.ErrorContains(t, err, "404")
+
+	// The scan command fails
+	_, err = (&Command{Cmd: "cat > /dev/null; echo oops >&2; exit 2"}).Scan(ctx, strings.NewReader("content"))
+	require.ErrorContains(t, err, "oops")
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse("clamd://localhost:3310")
+	require.NoError(t, err)
+	require.Equal(t, &Clamd{Network: "tcp", Address: "localhost:3310"}, s)
+	require.Equal(t, "clamd://localhost:3310", s.String())
+
+	s, err = Parse("clamd:///var/run/clamd.sock")
+	require.NoError(t, err)
+	require.Equal(t, &Clamd{Network: "unix", Address: "/var/run/clamd.sock"}, s)
+	require.Equal(t, "clamd:///var/run/clamd.sock", s.String())
+
+	s, err = Parse("icap://localhost/avscan")
+	require.NoError(t, err)
+	require.Equal(t, "icap://localhost/avscan", s.String())
+
+	s, err = Parse("cmd:my-scanner --stdin")
+	require.NoError(t, err)
+	require.Equal(t, &Command{Cmd: "my-scanner --stdin"}, s)
+
+	for _, spec := range []string{"cmd:", "clamd://", "icap:///avscan", "http://localhost"} {
+		_, err = Parse(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestScanFileAndQuarantine(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.car")
+	require.NoError(t, os.WriteFile(path, []byte(eicar), 0644))
+
+	s := &Clamd{Network: "tcp", Address: listen(t, fakeClamd)}
+	err := ScanFile(ctx, s, path)
+	var ferr *FlaggedError
+	require.ErrorAs(t, err, &ferr)
+	require.Equal(t, "Eicar-Test-Signature", ferr.Finding)
+
+	qdir := filepath.Join(dir, "quarantine")
+	qpath, err := Quarantine(path, qdir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(qdir, "out.car"), qpath)
+	require.NoFileExists(t, path)
+	require.FileExists(t, qpath)
+
+	// A file with the same name doesn't overwrite the quarantined file
+	require.NoError(t, os.WriteFile(path, []byte(eicar), 0644))
+	qpath, err = Quarantine(path, qdir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(qdir, "out.car.1"), qpath)
+	require.FileExists(t, filepath.Join(qdir, "out.car"))
+}
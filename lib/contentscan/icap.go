@@ -0,0 +1,170 @@
+package contentscan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const icapDefaultPort = "1344"
+
+// The HTTP request and response that encapsulate the content sent to the
+// ICAP server
+const (
+	icapReqHdr = "GET / HTTP/1.1\r\nHost: boost\r\n\r\n"
+	icapResHdr = "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.ipld.car\r\nTransfer-Encoding: chunked\r\n\r\n"
+)
+
+// The headers that ICAP servers report what they found in
+var icapFindingHeaders = []string{"X-Infection-Found", "X-Virus-Id", "X-Violations-Found"}
+
+// ICAP scans content with an ICAP server (RFC 3507), by sending it as the
+// body of a RESPMOD request. The server replies 204 if the content is clean.
+type ICAP struct {
+	URL *url.URL
+}
+
+func (c *ICAP) String() string {
+	return c.URL.String()
+}
+
+func (c *ICAP) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	addr := c.URL.Host
+	if c.URL.Port() == "" {
+		addr = net.JoinHostPort(c.URL.Hostname(), icapDefaultPort)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ICAP server: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	// Send the request while reading the reply, as the server may reply
+	// before it has read all the content (eg with an error)
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- c.writeRequest(conn, r)
+	}()
+
+	// Stop the scan if the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	status, hdr, err := readICAPReply(bufio.NewReader(conn))
+	if err != nil {
+		// If the request couldn't be written, report that rather than the
+		// failure to read the reply
+		_ = conn.Close()
+		if werr := <-writeErr; werr != nil {
+			err = werr
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	switch status.code {
+	case 204:
+		return &Result{}, nil
+	case 200:
+		// We allow 204, so the server only replies with a (modified)
+		// response if it flagged the content
+		finding := "content modified by ICAP server"
+		for _, h := range icapFindingHeaders {
+			if v := hdr.Get(h); v != "" {
+				finding = icapFinding(v)
+				break
+			}
+		}
+		return &Result{Flagged: true, Finding: finding}, nil
+	default:
+		return nil, fmt.Errorf("ICAP server replied '%s'", status.line)
+	}
+}
+
+func (c *ICAP) writeRequest(conn net.Conn, r io.Reader) error {
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.URL.String())
+	fmt.Fprintf(w, "Host: %s\r\n", c.URL.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(icapReqHdr), len(icapReqHdr)+len(icapResHdr))
+	w.WriteString(icapReqHdr) //nolint:errcheck
+	w.WriteString(icapResHdr) //nolint:errcheck
+
+	// The body is sent with chunked encoding
+	buf := make([]byte, 64*1024)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])      //nolint:errcheck
+			w.WriteString("\r\n") //nolint:errcheck
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return fmt.Errorf("reading content to scan: %w", rerr)
+		}
+	}
+	w.WriteString("0\r\n\r\n") //nolint:errcheck
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("streaming content to ICAP server: %w", err)
+	}
+	return nil
+}
+
+type icapStatus struct {
+	line string
+	code int
+}
+
+func readICAPReply(br *bufio.Reader) (*icapStatus, textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading ICAP reply: %w", err)
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "ICAP/") {
+		return nil, nil, fmt.Errorf("unexpected ICAP reply '%s'", line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("unexpected ICAP reply '%s'", line)
+	}
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading ICAP reply headers: %w", err)
+	}
+	return &icapStatus{line: line, code: code}, hdr, nil
+}
+
+// icapFinding extracts the threat from an X-Infection-Found header of the
+// form "Type=0; Resolution=2; Threat=<name>;", or returns the header as is
+func icapFinding(v string) string {
+	for _, f := range strings.Split(v, ";") {
+		if t := strings.TrimPrefix(strings.TrimSpace(f), "Threat="); t != strings.TrimSpace(f) {
+			return t
+		}
+	}
+	return strings.TrimSpace(v)
+}
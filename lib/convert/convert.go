@@ -0,0 +1,135 @@
+// Package convert converts between the forms that deal data takes on the
+// client: a piece file (the CAR file zero-padded to the unpadded piece size,
+// as transferred to and stored by providers), the CAR file, and the unixfs
+// files and directories in the CAR file.
+//
+// Conversions between a piece and a CAR file are streamed. Extracting files
+// needs random access to the blocks, so it reads the CAR file from disk.
+package convert
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/multiformats/go-varint"
+)
+
+// The largest CAR section (a cid and block) that will be read from a piece.
+// It guards against treating garbage as a huge section length.
+const maxSectionSize = 32 << 20
+
+// Progress is called as a conversion proceeds with the number of bytes
+// processed so far, and the total number of bytes (zero if not known)
+type Progress func(done, total uint64)
+
+// PieceInfo describes a piece written by CarToPiece
+type PieceInfo struct {
+	PieceCid  string
+	PieceSize abi.PaddedPieceSize
+	CarSize   uint64
+}
+
+// CarToPiece streams the CAR file from car to piece, followed by the zero
+// padding that fills the piece, and returns the piece's commp and size
+func CarToPiece(ctx context.Context, car io.Reader, carSize uint64, piece io.Writer, progress Progress) (*PieceInfo, error) {
+	// Calculate commp over the padded data while writing it out
+	pr, pw := io.Pipe()
+	defer pr.Close() //nolint:errcheck
+	go func() {
+		cr := &countingReader{r: io.LimitReader(car, int64(carSize)), total: carSize, progress: progress}
+		padded, err := padreader.NewInflator(cr, carSize, padreader.PaddedSize(carSize))
+		if err != nil {
+			_ = pw.CloseWithError(fmt.Errorf("padding CAR file: %w", err))
+			return
+		}
+		_, err = io.Copy(io.MultiWriter(piece, pw), padded)
+		if err == nil && atomic.LoadUint64(&cr.n) != carSize {
+			err = fmt.Errorf("CAR file is %d bytes, expected %d", atomic.LoadUint64(&cr.n), carSize)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	pi, err := commp.Default().Sum(ctx, pr)
+	if err != nil {
+		return nil, fmt.Errorf("writing piece: %w", err)
+	}
+	if progress != nil {
+		progress(carSize, carSize)
+	}
+	return &PieceInfo{PieceCid: pi.PieceCID.String(), PieceSize: pi.Size, CarSize: carSize}, nil
+}
+
+// PieceToCar streams the CAR file in piece to car, stopping at the zero
+// padding after the last block. It returns the size of the CAR file.
+func PieceToCar(ctx context.Context, piece io.Reader, pieceSize uint64, car io.Writer, progress Progress) (uint64, error) {
+	br := bufio.NewReaderSize(&countingReader{r: piece, total: pieceSize, progress: progress}, 1<<20)
+	bw := bufio.NewWriterSize(car, 1<<20)
+
+	var carSize uint64
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		// Each section (and the header) is prefixed with its length. Zero
+		// padding reads as a zero length, and ends the CAR file.
+		length, err := varint.ReadUvarint(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading section length at offset %d: %w", carSize, err)
+		}
+		if length == 0 {
+			break
+		}
+		if length > maxSectionSize {
+			return 0, fmt.Errorf("section at offset %d is too large (%d bytes): not a CAR file", carSize, length)
+		}
+		if i == 0 && length > 1<<16 {
+			return 0, fmt.Errorf("header is too large (%d bytes): not a CAR file", length)
+		}
+
+		prefix := varint.ToUvarint(length)
+		if _, err := bw.Write(prefix); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(bw, br, int64(length)); err != nil {
+			return 0, fmt.Errorf("reading section at offset %d: %w", carSize, err)
+		}
+		carSize += uint64(len(prefix)) + length
+	}
+	if carSize == 0 {
+		return 0, fmt.Errorf("piece does not contain a CAR file")
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	if progress != nil {
+		progress(pieceSize, pieceSize)
+	}
+	return carSize, nil
+}
+
+// countingReader reports progress as data is read
+type countingReader struct {
+	r        io.Reader
+	n        uint64
+	total    uint64
+	progress Progress
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	done := atomic.AddUint64(&r.n, uint64(n))
+	if r.progress != nil && n > 0 {
+		r.progress(done, r.total)
+	}
+	return n, err
+}
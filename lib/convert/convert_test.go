@@ -0,0 +1,77 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-padreader"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Pack a directory into a CAR file
+	in := filepath.Join(dir, "in")
+	req.NoError(os.MkdirAll(filepath.Join(in, "sub"), 0755))
+	big := bytes.Repeat([]byte("0123456789"), 300000)
+	req.NoError(os.WriteFile(filepath.Join(in, "a.txt"), []byte("hello"), 0644))
+	req.NoError(os.WriteFile(filepath.Join(in, "sub", "b.bin"), big, 0644))
+
+	var lastDone, lastTotal uint64
+	progress := func(done, total uint64) {
+		lastDone, lastTotal = done, total
+	}
+	carPath := filepath.Join(dir, "data.car")
+	root, err := FilesToCar(ctx, in, carPath, progress)
+	req.NoError(err)
+	req.Equal(lastTotal, lastDone)
+	req.EqualValues(2*(5+len(big)), lastTotal)
+
+	// Packing the same files again gives the same root
+	root2, err := FilesToCar(ctx, in, filepath.Join(dir, "data2.car"), nil)
+	req.NoError(err)
+	req.Equal(root, root2)
+
+	// Convert the CAR file to a piece
+	carBytes, err := os.ReadFile(carPath)
+	req.NoError(err)
+	var piece bytes.Buffer
+	pi, err := CarToPiece(ctx, bytes.NewReader(carBytes), uint64(len(carBytes)), &piece, nil)
+	req.NoError(err)
+	req.EqualValues(padreader.PaddedSize(uint64(len(carBytes))), piece.Len())
+	req.Equal(pi.PieceSize.Unpadded(), padreader.PaddedSize(uint64(len(carBytes))))
+
+	// A CAR file that is shorter than its stated size is an error
+	_, err = CarToPiece(ctx, bytes.NewReader(carBytes[:100]), uint64(len(carBytes)), &bytes.Buffer{}, nil)
+	req.Error(err)
+
+	// Convert the piece back to the same CAR file
+	var car bytes.Buffer
+	carSize, err := PieceToCar(ctx, bytes.NewReader(piece.Bytes()), uint64(piece.Len()), &car, nil)
+	req.NoError(err)
+	req.EqualValues(len(carBytes), carSize)
+	req.Equal(carBytes, car.Bytes())
+
+	// Zeros are not a CAR file
+	_, err = PieceToCar(ctx, bytes.NewReader(make([]byte, 1024)), 1024, &bytes.Buffer{}, nil)
+	req.Error(err)
+
+	// Extract the files from the CAR file
+	out := filepath.Join(dir, "out")
+	extracted, err := CarToFiles(ctx, carPath, out, progress)
+	req.NoError(err)
+	req.Equal(root, extracted)
+	req.EqualValues(5+len(big), lastDone)
+	got, err := os.ReadFile(filepath.Join(out, "a.txt"))
+	req.NoError(err)
+	req.Equal("hello", string(got))
+	got, err = os.ReadFile(filepath.Join(out, "sub", "b.bin"))
+	req.NoError(err)
+	req.Equal(big, got)
+}
@@ -0,0 +1,312 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-car/v2/blockstore"
+)
+
+const (
+	unixfsChunkSize     = 1 << 20
+	unixfsLinksPerLevel = 1024
+)
+
+// FilesToCar packs the file or directory at inPath into a unixfs DAG, and
+// writes it to a CARv1 file at carPath. It returns the root cid.
+//
+// The root cid is needed for the CAR header before any blocks are written,
+// so the files are read twice: once to calculate the root and once to write
+// the blocks. Progress is reported over both passes.
+func FilesToCar(ctx context.Context, inPath string, carPath string, progress Progress) (cid.Cid, error) {
+	size, err := pathSize(inPath)
+	if err != nil {
+		return cid.Undef, err
+	}
+	b := &dagBuilder{total: 2 * size, progress: progress}
+
+	b.dserv = discardDAG{}
+	root, err := b.add(ctx, inPath)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	rw, err := blockstore.OpenReadWrite(carPath, []cid.Cid{root}, blockstore.WriteAsCarV1(true))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("creating CAR file %s: %w", carPath, err)
+	}
+	b.dserv = merkledag.NewDAGService(blockservice.New(rw, nil))
+	root2, err := b.add(ctx, inPath)
+	if err != nil {
+		rw.Discard()
+		return cid.Undef, err
+	}
+	if err := rw.Finalize(); err != nil {
+		return cid.Undef, fmt.Errorf("finalizing CAR file %s: %w", carPath, err)
+	}
+	if !root.Equals(root2) {
+		return cid.Undef, fmt.Errorf("DAG root mismatch for %s: %s != %s", inPath, root, root2)
+	}
+	return root, nil
+}
+
+// CarToFiles extracts the unixfs file or directory at the root of the CAR
+// file to outPath. It returns the root cid.
+func CarToFiles(ctx context.Context, carPath string, outPath string, progress Progress) (cid.Cid, error) {
+	bs, err := blockstore.OpenReadOnly(carPath)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("opening CAR file %s: %w", carPath, err)
+	}
+	defer bs.Close() //nolint:errcheck
+
+	roots, err := bs.Roots()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("reading CAR file roots: %w", err)
+	}
+	if len(roots) != 1 {
+		return cid.Undef, fmt.Errorf("CAR file must have exactly one root to extract files, it has %d", len(roots))
+	}
+	root := roots[0]
+
+	dserv := merkledag.NewDAGService(blockservice.New(bs, nil))
+	nd, err := dserv.Get(ctx, root)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("getting root %s: %w", root, err)
+	}
+	node, err := unixfile.NewUnixfsFile(ctx, dserv, nd)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("reading root %s as unixfs: %w", root, err)
+	}
+	defer node.Close() //nolint:errcheck
+
+	// The size of a unixfs node includes the size of its children
+	total, err := node.Size()
+	if err != nil {
+		total = 0
+	}
+	w := &extractor{total: uint64(total), progress: progress}
+	if err := w.write(ctx, node, outPath); err != nil {
+		return cid.Undef, err
+	}
+	return root, nil
+}
+
+// dagBuilder builds a unixfs DAG from files on disk
+type dagBuilder struct {
+	dserv    ipldformat.DAGService
+	done     uint64
+	total    uint64
+	progress Progress
+}
+
+func (b *dagBuilder) add(ctx context.Context, path string) (cid.Cid, error) {
+	nd, err := b.addNode(ctx, path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+func (b *dagBuilder) addNode(ctx context.Context, path string) (ipldformat.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return b.addFile(ctx, path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory %s: %w", path, err)
+	}
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, err
+	}
+	dir := uio.NewDirectory(b.dserv)
+	dir.SetCidBuilder(prefix)
+	for _, e := range entries {
+		child, err := b.addNode(ctx, filepath.Join(path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(ctx, e.Name(), child); err != nil {
+			return nil, fmt.Errorf("adding %s to directory: %w", e.Name(), err)
+		}
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return nil, fmt.Errorf("building directory %s: %w", path, err)
+	}
+	if err := b.dserv.Add(ctx, nd); err != nil {
+		return nil, fmt.Errorf("adding directory %s: %w", path, err)
+	}
+	return nd, nil
+}
+
+func (b *dagBuilder) addFile(ctx context.Context, path string) (ipldformat.Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, err
+	}
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, b.dserv)
+	params := ihelper.DagBuilderParams{
+		Maxlinks:   unixfsLinksPerLevel,
+		RawLeaves:  true,
+		CidBuilder: prefix,
+		Dagserv:    bufferedDS,
+	}
+	db, err := params.New(chunk.NewSizeSplitter(&progressReader{r: f, b: b}, unixfsChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return nil, fmt.Errorf("building DAG for %s: %w", path, err)
+	}
+	if err := bufferedDS.Commit(); err != nil {
+		return nil, fmt.Errorf("building DAG for %s: %w", path, err)
+	}
+	return nd, nil
+}
+
+type progressReader struct {
+	r io.Reader
+	b *dagBuilder
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.b.done += uint64(n)
+		if r.b.progress != nil {
+			r.b.progress(r.b.done, r.b.total)
+		}
+	}
+	return n, err
+}
+
+// pathSize returns the total size of the files at path
+func pathSize(path string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// extractor writes unixfs files and directories to disk
+type extractor struct {
+	done     uint64
+	total    uint64
+	progress Progress
+}
+
+func (w *extractor) write(ctx context.Context, node files.Node, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	switch nd := node.(type) {
+	case files.File:
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, &extractReader{r: nd, w: w})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		return nil
+	case files.Directory:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		it := nd.Entries()
+		for it.Next() {
+			name := it.Name()
+			if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+				return fmt.Errorf("invalid file name in directory %s: %q", path, name)
+			}
+			if err := w.write(ctx, it.Node(), filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	default:
+		return fmt.Errorf("unsupported unixfs node type %T at %s", node, path)
+	}
+}
+
+type extractReader struct {
+	r io.Reader
+	w *extractor
+}
+
+func (r *extractReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.w.done += uint64(n)
+		if r.w.progress != nil {
+			r.w.progress(r.w.done, r.w.total)
+		}
+	}
+	return n, err
+}
+
+// discardDAG is a DAG service that drops all nodes. It is used to compute
+// the root of a DAG without storing it.
+type discardDAG struct{}
+
+var _ ipldformat.DAGService = discardDAG{}
+
+func (discardDAG) Get(ctx context.Context, c cid.Cid) (ipldformat.Node, error) {
+	return nil, ipldformat.ErrNotFound{Cid: c}
+}
+
+func (discardDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipldformat.NodeOption {
+	ch := make(chan *ipldformat.NodeOption, len(cids))
+	for _, c := range cids {
+		ch <- &ipldformat.NodeOption{Err: ipldformat.ErrNotFound{Cid: c}}
+	}
+	close(ch)
+	return ch
+}
+
+func (discardDAG) Add(context.Context, ipldformat.Node) error       { return nil }
+func (discardDAG) AddMany(context.Context, []ipldformat.Node) error { return nil }
+func (discardDAG) Remove(context.Context, cid.Cid) error            { return nil }
+func (discardDAG) RemoveMany(context.Context, []cid.Cid) error      { return nil }
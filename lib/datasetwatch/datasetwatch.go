@@ -0,0 +1,341 @@
+// Package datasetwatch monitors the health of the providers storing a
+// critical dataset, and rescues the pieces that are at risk of being lost.
+//
+// Each deal in the dataset is checked periodically:
+//   - is the deal still active on chain (it may have been slashed or expired)
+//   - has the provider lost power to faults since the watch started
+//   - does the provider still serve the piece (a retrieval probe)
+//
+// A deal is at risk once any check crosses its threshold. When a piece has
+// fewer healthy deals than the minimum, the piece is rescued: it is
+// retrieved from a healthy provider to a safe location and re-replicated.
+// A deal whose checks fail (eg because the chain node can't be reached) is
+// neither healthy nor at risk until it can be checked again: it doesn't
+// cause its piece to be rescued, and it doesn't stop the other deals from
+// being checked.
+package datasetwatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("datasetwatch")
+
+// Deal is a deal that stores a piece of the dataset
+type Deal struct {
+	DealID     abi.DealID      `json:"dealId"`
+	Provider   address.Address `json:"provider"`
+	PieceCid   cid.Cid         `json:"pieceCid"`
+	PayloadCid cid.Cid         `json:"payloadCid"`
+}
+
+// Dataset is the deals that store a dataset. Each piece may be stored by
+// several deals (its replicas).
+type Dataset struct {
+	Name  string `json:"name"`
+	Deals []Deal `json:"deals"`
+}
+
+// LoadDataset reads a dataset from a json file
+func LoadDataset(path string) (*Dataset, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading dataset: %w", err)
+	}
+	var ds Dataset
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return nil, fmt.Errorf("parsing dataset %s: %w", path, err)
+	}
+	if len(ds.Deals) == 0 {
+		return nil, fmt.Errorf("dataset %s has no deals", path)
+	}
+	return &ds, nil
+}
+
+// Checker checks the health of a deal and its provider
+type Checker interface {
+	// DealActive returns false if the deal has been slashed or has expired
+	DealActive(ctx context.Context, deal Deal) (bool, error)
+	// ProviderPower returns the provider's raw byte power, which drops when
+	// the provider's sectors become faulty
+	ProviderPower(ctx context.Context, provider address.Address) (abi.StoragePower, error)
+	// Probe returns an error if the provider doesn't serve the piece
+	Probe(ctx context.Context, deal Deal) error
+}
+
+// Rescuer rescues a piece that is at risk
+type Rescuer interface {
+	// Rescue retrieves the piece from one of the healthy deals to a safe
+	// location, and re-replicates it to replace the deals at risk
+	Rescue(ctx context.Context, pieceCid cid.Cid, healthy []Deal, atRisk []DealStatus) error
+}
+
+// Thresholds determine when a deal, and then a piece, is at risk
+type Thresholds struct {
+	// The number of consecutive failed retrieval probes after which a deal
+	// is at risk (0 disables probing)
+	ProbeFailures int
+	// The fraction of its power a provider may lose after the watch starts
+	// before its deals are at risk (0 means any loss puts them at risk)
+	MaxPowerLoss float64
+	// The minimum number of healthy deals for each piece. A piece with
+	// fewer is rescued.
+	MinHealthy int
+}
+
+// DealStatus is the result of the latest checks of a deal
+type DealStatus struct {
+	Deal
+	// Why the deal is at risk (empty if it is healthy)
+	Risks []string `json:"risks,omitempty"`
+	// The number of consecutive failed retrieval probes
+	ProbeFailures int `json:"probeFailures"`
+	// Why the deal couldn't be checked, if it couldn't
+	CheckError string `json:"checkError,omitempty"`
+}
+
+func (s *DealStatus) AtRisk() bool {
+	return len(s.Risks) > 0
+}
+
+// Unknown is true if the deal couldn't be checked
+func (s *DealStatus) Unknown() bool {
+	return s.CheckError != ""
+}
+
+// PieceStatus is the health of a piece's deals
+type PieceStatus struct {
+	PieceCid cid.Cid `json:"pieceCid"`
+	Healthy  int     `json:"healthy"`
+	// The number of deals that couldn't be checked
+	Unknown int          `json:"unknown,omitempty"`
+	Deals   []DealStatus `json:"deals"`
+	// When the piece was rescued, if it has been
+	RescuedAt   time.Time `json:"rescuedAt,omitempty"`
+	RescueError string    `json:"rescueError,omitempty"`
+}
+
+// Watcher checks the dataset's deals and rescues pieces at risk
+type Watcher struct {
+	dataset    *Dataset
+	checker    Checker
+	rescuer    Rescuer
+	thresholds Thresholds
+
+	lk sync.Mutex
+	// The power of each provider when it was first checked
+	basePower map[address.Address]abi.StoragePower
+	// Consecutive probe failures of each deal
+	probeFailures map[abi.DealID]int
+	// Pieces that have been rescued (each piece is rescued at most once)
+	rescued map[cid.Cid]time.Time
+	status  []PieceStatus
+}
+
+func New(dataset *Dataset, checker Checker, rescuer Rescuer, t Thresholds) *Watcher {
+	return &Watcher{
+		dataset:       dataset,
+		checker:       checker,
+		rescuer:       rescuer,
+		thresholds:    t,
+		basePower:     make(map[address.Address]abi.StoragePower),
+		probeFailures: make(map[abi.DealID]int),
+		rescued:       make(map[cid.Cid]time.Time),
+	}
+}
+
+// Run checks the dataset at each interval until the context is cancelled
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Check(ctx); err != nil && ctx.Err() == nil {
+			log.Warnw("checking dataset", "dataset", w.dataset.Name, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the result of the latest check
+func (w *Watcher) Status() []PieceStatus {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.status
+}
+
+// Check checks each deal in the dataset, and rescues the pieces that have
+// fewer healthy deals than the minimum. The deals that couldn't be checked
+// are recorded in their status, and the errors are returned once all the
+// deals have been checked.
+func (w *Watcher) Check(ctx context.Context) ([]PieceStatus, error) {
+	var merr *multierror.Error
+	powerLoss, powerErrs := w.checkPower(ctx)
+
+	// Group the deals by piece, keeping the order of the dataset
+	var pieces []*PieceStatus
+	byPiece := make(map[cid.Cid]*PieceStatus)
+	for _, d := range w.dataset.Deals {
+		st := w.checkDeal(ctx, d, powerLoss[d.Provider], powerErrs[d.Provider])
+		if st.Unknown() {
+			merr = multierror.Append(merr, fmt.Errorf("deal %d: %s", d.DealID, st.CheckError))
+		}
+		ps, ok := byPiece[d.PieceCid]
+		if !ok {
+			ps = &PieceStatus{PieceCid: d.PieceCid}
+			byPiece[d.PieceCid] = ps
+			pieces = append(pieces, ps)
+		}
+		ps.Deals = append(ps.Deals, *st)
+		switch {
+		case st.Unknown():
+			ps.Unknown++
+		case !st.AtRisk():
+			ps.Healthy++
+		}
+	}
+
+	status := make([]PieceStatus, 0, len(pieces))
+	for _, ps := range pieces {
+		// Only rescue a piece if it would be below the minimum even if
+		// the deals that couldn't be checked are healthy
+		if ps.Healthy+ps.Unknown < w.thresholds.MinHealthy {
+			w.rescue(ctx, ps)
+		}
+		status = append(status, *ps)
+	}
+
+	w.lk.Lock()
+	w.status = status
+	w.lk.Unlock()
+	return status, merr.ErrorOrNil()
+}
+
+// checkPower returns the fraction of its power each provider has lost since
+// it was first checked, and the error for each provider whose power couldn't
+// be checked
+func (w *Watcher) checkPower(ctx context.Context) (map[address.Address]float64, map[address.Address]error) {
+	loss := make(map[address.Address]float64)
+	errs := make(map[address.Address]error)
+	for _, d := range w.dataset.Deals {
+		if _, ok := loss[d.Provider]; ok {
+			continue
+		}
+		if _, ok := errs[d.Provider]; ok {
+			continue
+		}
+		power, err := w.checker.ProviderPower(ctx, d.Provider)
+		if err != nil {
+			errs[d.Provider] = fmt.Errorf("getting power of provider %s: %w", d.Provider, err)
+			continue
+		}
+
+		w.lk.Lock()
+		base, ok := w.basePower[d.Provider]
+		if !ok || power.GreaterThan(base) {
+			w.basePower[d.Provider] = power
+			base = power
+		}
+		w.lk.Unlock()
+
+		loss[d.Provider] = 0
+		if !base.IsZero() {
+			lost := big.Sub(base, power)
+			loss[d.Provider] = float64(big.Div(big.Mul(lost, big.NewInt(1e6)), base).Int64()) / 1e6
+		}
+	}
+	return loss, errs
+}
+
+// checkDeal checks the deal. If the deal's chain state or its provider's
+// power couldn't be checked, the deal's status is unknown.
+func (w *Watcher) checkDeal(ctx context.Context, d Deal, powerLoss float64, powerErr error) *DealStatus {
+	st := &DealStatus{Deal: d}
+
+	active, err := w.checker.DealActive(ctx, d)
+	if err != nil {
+		st.CheckError = fmt.Sprintf("checking deal on chain: %s", err)
+		return st
+	}
+	if powerErr != nil {
+		st.CheckError = powerErr.Error()
+		return st
+	}
+	if !active {
+		st.Risks = append(st.Risks, "deal is no longer active on chain")
+	}
+
+	if powerLoss > w.thresholds.MaxPowerLoss {
+		st.Risks = append(st.Risks, fmt.Sprintf("provider has lost %.1f%% of its power to faults", powerLoss*100))
+	}
+
+	if w.thresholds.ProbeFailures > 0 {
+		perr := w.checker.Probe(ctx, d)
+		w.lk.Lock()
+		if perr != nil {
+			w.probeFailures[d.DealID]++
+		} else {
+			w.probeFailures[d.DealID] = 0
+		}
+		st.ProbeFailures = w.probeFailures[d.DealID]
+		w.lk.Unlock()
+
+		if perr != nil {
+			log.Infow("retrieval probe failed", "deal", d.DealID, "provider", d.Provider, "failures", st.ProbeFailures, "err", perr)
+		}
+		if st.ProbeFailures >= w.thresholds.ProbeFailures {
+			st.Risks = append(st.Risks, fmt.Sprintf("%d consecutive retrieval probes failed", st.ProbeFailures))
+		}
+	}
+	return st
+}
+
+func (w *Watcher) rescue(ctx context.Context, ps *PieceStatus) {
+	w.lk.Lock()
+	at, done := w.rescued[ps.PieceCid]
+	w.lk.Unlock()
+	if done {
+		ps.RescuedAt = at
+		return
+	}
+
+	var healthy []Deal
+	var atRisk []DealStatus
+	for _, d := range ps.Deals {
+		switch {
+		case d.Unknown():
+		case d.AtRisk():
+			atRisk = append(atRisk, d)
+		default:
+			healthy = append(healthy, d.Deal)
+		}
+	}
+	log.Warnw("rescuing piece at risk", "dataset", w.dataset.Name, "piece", ps.PieceCid,
+		"healthy", len(healthy), "min-healthy", w.thresholds.MinHealthy)
+	if err := w.rescuer.Rescue(ctx, ps.PieceCid, healthy, atRisk); err != nil {
+		// The rescue is tried again at the next check
+		log.Errorw("rescuing piece", "dataset", w.dataset.Name, "piece", ps.PieceCid, "err", err)
+		ps.RescueError = err.Error()
+		return
+	}
+
+	ps.RescuedAt = time.Now()
+	w.lk.Lock()
+	w.rescued[ps.PieceCid] = ps.RescuedAt
+	w.lk.Unlock()
+}
@@ -0,0 +1,195 @@
+package datasetwatch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChecker struct {
+	lk       sync.Mutex
+	inactive map[abi.DealID]bool
+	power    map[address.Address]int64
+	probeErr map[abi.DealID]error
+	dealErr  map[abi.DealID]error
+	powerErr map[address.Address]error
+}
+
+func (m *mockChecker) DealActive(ctx context.Context, deal Deal) (bool, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if err := m.dealErr[deal.DealID]; err != nil {
+		return false, err
+	}
+	return !m.inactive[deal.DealID], nil
+}
+
+func (m *mockChecker) ProviderPower(ctx context.Context, provider address.Address) (abi.StoragePower, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if err := m.powerErr[provider]; err != nil {
+		return big.Zero(), err
+	}
+	return big.NewInt(m.power[provider]), nil
+}
+
+func (m *mockChecker) Probe(ctx context.Context, deal Deal) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.probeErr[deal.DealID]
+}
+
+type mockRescuer struct {
+	rescued map[cid.Cid][]Deal
+	err     error
+}
+
+func (m *mockRescuer) Rescue(ctx context.Context, pieceCid cid.Cid, healthy []Deal, atRisk []DealStatus) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.rescued[pieceCid] = healthy
+	return nil
+}
+
+func TestWatcher(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	// Piece 1 is stored by all three providers, piece 2 by providers 1 and 2
+	piece1 := testutil.GenerateCid()
+	piece2 := testutil.GenerateCid()
+	ds := &Dataset{Name: "test", Deals: []Deal{
+		{DealID: 1, Provider: provs[0], PieceCid: piece1},
+		{DealID: 2, Provider: provs[1], PieceCid: piece1},
+		{DealID: 3, Provider: provs[2], PieceCid: piece1},
+		{DealID: 4, Provider: provs[0], PieceCid: piece2},
+		{DealID: 5, Provider: provs[1], PieceCid: piece2},
+	}}
+	checker := &mockChecker{
+		inactive: map[abi.DealID]bool{},
+		power:    map[address.Address]int64{provs[0]: 1000, provs[1]: 1000, provs[2]: 1000},
+		probeErr: map[abi.DealID]error{},
+	}
+	rescuer := &mockRescuer{rescued: make(map[cid.Cid][]Deal)}
+	w := New(ds, checker, rescuer, Thresholds{ProbeFailures: 2, MaxPowerLoss: 0.1, MinHealthy: 2})
+
+	st, err := w.Check(ctx)
+	req.NoError(err)
+	req.Len(st, 2)
+	req.Equal(3, st[0].Healthy)
+	req.Equal(2, st[1].Healthy)
+	req.Empty(rescuer.rescued)
+
+	// A single failed probe doesn't put the deal at risk, and a small power
+	// loss is tolerated
+	checker.probeErr[5] = errors.New("connection refused")
+	checker.power[provs[1]] = 950
+	st, err = w.Check(ctx)
+	req.NoError(err)
+	req.Equal(2, st[1].Healthy)
+	req.Equal(1, st[1].Deals[1].ProbeFailures)
+
+	// The second consecutive failure puts piece 2 below the minimum, so it
+	// is rescued from the healthy deal
+	st, err = w.Check(ctx)
+	req.NoError(err)
+	req.Equal(1, st[1].Healthy)
+	req.False(st[1].RescuedAt.IsZero())
+	req.Equal([]Deal{ds.Deals[3]}, rescuer.rescued[piece2])
+
+	// Provider 3 loses power to faults and deal 1 is slashed: piece 1 is
+	// rescued once the rescue succeeds, and only once
+	checker.power[provs[2]] = 500
+	checker.inactive[1] = true
+	rescuer.err = errors.New("no space")
+	st, err = w.Check(ctx)
+	req.NoError(err)
+	req.Equal(1, st[0].Healthy)
+	req.Equal("no space", st[0].RescueError)
+	req.Contains(st[0].Deals[2].Risks[0], "50.0%")
+
+	rescuer.err = nil
+	_, err = w.Check(ctx)
+	req.NoError(err)
+	req.Equal([]Deal{ds.Deals[1]}, rescuer.rescued[piece1])
+
+	delete(rescuer.rescued, piece1)
+	st, err = w.Check(ctx)
+	req.NoError(err)
+	req.Empty(rescuer.rescued[piece1])
+	req.False(st[0].RescuedAt.IsZero())
+	req.Equal(st, w.Status())
+}
+
+func TestWatcherCheckErrors(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	piece1 := testutil.GenerateCid()
+	piece2 := testutil.GenerateCid()
+	ds := &Dataset{Name: "test", Deals: []Deal{
+		{DealID: 1, Provider: provs[0], PieceCid: piece1},
+		{DealID: 2, Provider: provs[1], PieceCid: piece1},
+		{DealID: 3, Provider: provs[0], PieceCid: piece2},
+		{DealID: 4, Provider: provs[2], PieceCid: piece2},
+	}}
+	checker := &mockChecker{
+		inactive: map[abi.DealID]bool{4: true},
+		power:    map[address.Address]int64{provs[0]: 1000, provs[1]: 1000, provs[2]: 1000},
+		probeErr: map[abi.DealID]error{},
+		dealErr:  map[abi.DealID]error{1: errors.New("rpc timeout")},
+		powerErr: map[address.Address]error{provs[1]: errors.New("rpc timeout")},
+	}
+	rescuer := &mockRescuer{rescued: make(map[cid.Cid][]Deal)}
+	w := New(ds, checker, rescuer, Thresholds{ProbeFailures: 2, MaxPowerLoss: 0.1, MinHealthy: 2})
+
+	// The deals that couldn't be checked are unknown: they don't cause
+	// piece 1 to be rescued, and they don't stop piece 2 from being checked
+	// and rescued
+	st, err := w.Check(ctx)
+	req.Error(err)
+	req.Len(st, 2)
+	req.Equal(0, st[0].Healthy)
+	req.Equal(2, st[0].Unknown)
+	req.Contains(st[0].Deals[0].CheckError, "rpc timeout")
+	req.Contains(st[0].Deals[1].CheckError, "rpc timeout")
+	req.True(st[0].RescuedAt.IsZero())
+	req.NotContains(rescuer.rescued, piece1)
+
+	req.Equal(1, st[1].Healthy)
+	req.Equal(0, st[1].Unknown)
+	req.False(st[1].RescuedAt.IsZero())
+	req.Equal([]Deal{ds.Deals[2]}, rescuer.rescued[piece2])
+
+	// Once the deals can be checked again, piece 1 is healthy
+	checker.dealErr = nil
+	checker.powerErr = nil
+	st, err = w.Check(ctx)
+	req.NoError(err)
+	req.Equal(2, st[0].Healthy)
+	req.Equal(0, st[0].Unknown)
+	req.Empty(st[0].Deals[0].CheckError)
+}
@@ -0,0 +1,364 @@
+// Package dealbatch proposes a batch of storage deals, eg for the CAR files
+// of a large dataset, to a set of providers as a unit.
+//
+// The funds for all the deals in the batch are reserved before any deal is
+// proposed, so that a batch that can't be paid for fails up front instead of
+// part way through. Proposals to each provider are sent one at a time,
+// spaced out so as not to exceed the provider's rate limit, while proposals
+// to different providers are sent in parallel. A proposal that fails to be
+// sent (eg because the provider can't be reached) is retried; a proposal
+// that the provider rejects is not.
+//
+// If a proposal may have reached the provider but no response was received
+// (eg the response timed out), the provider may have accepted the deal. So
+// before it is proposed again, the provider is asked for the status of the
+// deal by its uuid, and the deal is only proposed again if the provider
+// doesn't have it. This prevents the same deal from being made twice, and
+// the funds for it from being released while the provider holds the deal.
+package dealbatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var log = logging.Logger("dealbatch")
+
+// Deal is a deal in the batch
+type Deal struct {
+	Params types.DealParams
+	// The libp2p peer id of the deal's provider
+	Peer peer.ID
+}
+
+// Proposer sends a deal proposal to a provider, and asks the provider for
+// the status of a deal
+type Proposer interface {
+	// Propose returns a ProposalOutcomeUnknownError if the proposal may have
+	// reached the provider but no response was received
+	Propose(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error)
+	DealStatus(ctx context.Context, id peer.ID, dealUUID uuid.UUID) (*types.DealStatusResponse, error)
+}
+
+// Funds reserves the client's funds for the deals in a batch
+type Funds interface {
+	// Reserve makes sure that the client has amt available in market escrow
+	// for the batch's deals, adding funds to escrow if necessary
+	Reserve(ctx context.Context, client address.Address, amt abi.TokenAmount) error
+	// Release releases funds reserved for deals that weren't accepted
+	Release(ctx context.Context, client address.Address, amt abi.TokenAmount) error
+}
+
+// Config configures how a batch is proposed
+type Config struct {
+	// The minimum time between proposals to the same provider
+	ProviderInterval time.Duration
+	// Overrides ProviderInterval for a provider, eg to space out the
+	// transfers of a provider that is slow to transfer data
+	ProviderIntervals map[address.Address]time.Duration
+	// The maximum number of times to try to send a proposal.
+	// If zero, one attempt is made.
+	MaxAttempts int
+	// The time to wait before retrying a proposal, multiplied by the number
+	// of attempts so far
+	RetryInterval time.Duration
+	// Overrides MaxAttempts and RetryInterval for a provider, eg because
+	// the provider's software takes longer to reply to proposals
+	ProviderRetry map[address.Address]Retry
+	// If nil, funds are not reserved for the batch
+	Funds Funds
+}
+
+// Retry is how proposals to a provider are retried
+type Retry struct {
+	MaxAttempts   int
+	RetryInterval time.Duration
+}
+
+// retry returns how proposals to the provider are retried
+func (cfg Config) retry(prov address.Address) Retry {
+	if r, ok := cfg.ProviderRetry[prov]; ok {
+		return r
+	}
+	return Retry{MaxAttempts: cfg.MaxAttempts, RetryInterval: cfg.RetryInterval}
+}
+
+// interval returns the minimum time between proposals to the provider
+func (cfg Config) interval(prov address.Address) time.Duration {
+	if i, ok := cfg.ProviderIntervals[prov]; ok {
+		return i
+	}
+	return cfg.ProviderInterval
+}
+
+// Result is the outcome of proposing a deal in the batch
+type Result struct {
+	DealUUID uuid.UUID
+	Provider address.Address
+	Accepted bool
+	// The reason the provider gave for rejecting the deal
+	Message string
+	// The number of times the proposal was sent
+	Attempts int
+	// The error sending the proposal, if it couldn't be sent
+	Err error
+	// True if the proposal may have reached the provider but it's not known
+	// whether the provider accepted it (the deal's funds remain reserved)
+	OutcomeUnknown bool
+}
+
+// Run reserves funds for the batch of deals and proposes them to their
+// providers. It returns the result of each deal, in the order of the deals,
+// or an error if the funds for the batch could not be reserved (in which
+// case no deal was proposed).
+//
+// Funds reserved for deals that are not accepted are released once the
+// batch is complete. Funds for accepted deals, and for deals whose outcome
+// is unknown, remain reserved.
+func Run(ctx context.Context, p Proposer, deals []Deal, cfg Config) ([]Result, error) {
+	required := make(map[address.Address]abi.TokenAmount)
+	for _, d := range deals {
+		proposal := d.Params.ClientDealProposal.Proposal
+		req, ok := required[proposal.Client]
+		if !ok {
+			req = big.Zero()
+		}
+		required[proposal.Client] = big.Add(req, proposal.ClientBalanceRequirement())
+	}
+
+	if cfg.Funds != nil {
+		reserved := make(map[address.Address]abi.TokenAmount)
+		for client, amt := range required {
+			if err := cfg.Funds.Reserve(ctx, client, amt); err != nil {
+				release(cfg.Funds, reserved)
+				return nil, fmt.Errorf("reserving %s for batch of deals from %s: %w", amt, client, err)
+			}
+			reserved[client] = amt
+		}
+	}
+
+	// Propose the deals to each provider in order, in a go routine per
+	// provider
+	byProvider := make(map[address.Address][]int)
+	for i, d := range deals {
+		prov := d.Params.ClientDealProposal.Proposal.Provider
+		byProvider[prov] = append(byProvider[prov], i)
+	}
+	results := make([]Result, len(deals))
+	var wg sync.WaitGroup
+	for prov, indexes := range byProvider {
+		wg.Add(1)
+		go func(prov address.Address, indexes []int) {
+			defer wg.Done()
+			var last time.Time
+			for _, i := range indexes {
+				results[i] = propose(ctx, p, deals[i], cfg, &last)
+			}
+		}(prov, indexes)
+	}
+	wg.Wait()
+
+	if cfg.Funds != nil {
+		unused := make(map[address.Address]abi.TokenAmount)
+		for i, res := range results {
+			if res.Accepted || res.OutcomeUnknown {
+				continue
+			}
+			proposal := deals[i].Params.ClientDealProposal.Proposal
+			amt, ok := unused[proposal.Client]
+			if !ok {
+				amt = big.Zero()
+			}
+			unused[proposal.Client] = big.Add(amt, proposal.ClientBalanceRequirement())
+		}
+		release(cfg.Funds, unused)
+	}
+
+	return results, nil
+}
+
+// propose sends the proposal to the provider, retrying if it fails to send.
+// last is the time of the previous proposal to the provider: every attempt,
+// including retries, waits until the provider interval has passed since
+// then.
+func propose(ctx context.Context, p Proposer, d Deal, cfg Config, last *time.Time) Result {
+	res := Result{DealUUID: d.Params.DealUUID, Provider: d.Params.ClientDealProposal.Proposal.Provider}
+	retry := cfg.retry(res.Provider)
+	interval := cfg.interval(res.Provider)
+	for {
+		if !last.IsZero() && !sleep(ctx, time.Until(last.Add(interval))) {
+			res.Err = ctx.Err()
+			return res
+		}
+
+		// If an earlier proposal may have reached the provider, only
+		// propose the deal again if the provider doesn't have it
+		if res.OutcomeUnknown && checkOutcome(ctx, p, d, &res) {
+			return res
+		}
+
+		res.Attempts++
+		resp, err := p.Propose(ctx, d.Peer, d.Params)
+		*last = time.Now()
+		if err == nil {
+			res.Accepted = resp.Accepted
+			res.Message = resp.Message
+			res.Err = nil
+			// If the provider says it already has the deal, an earlier
+			// proposal did reach it after all
+			if !resp.Accepted && res.Attempts > 1 && types.IsDuplicateRejection(resp.Message) {
+				sresp, serr := CheckProposed(ctx, p, d.Peer, d.Params.DealUUID)
+				if serr != nil {
+					res.OutcomeUnknown = true
+				} else if sresp != nil {
+					res.Accepted = sresp.Accepted
+					res.Message = sresp.Message
+				}
+			}
+			return res
+		}
+
+		res.Err = err
+		var uerr *types.ProposalOutcomeUnknownError
+		res.OutcomeUnknown = errors.As(err, &uerr)
+		if res.Attempts >= retry.MaxAttempts {
+			if res.OutcomeUnknown {
+				checkOutcome(ctx, p, d, &res)
+			}
+			return res
+		}
+		log.Infow("retrying deal proposal", "id", d.Params.DealUUID, "provider", res.Provider, "attempts", res.Attempts, "err", err)
+		if !sleep(ctx, retry.RetryInterval*time.Duration(res.Attempts)) {
+			return res
+		}
+	}
+}
+
+// checkOutcome asks the provider for the status of a deal whose proposal
+// outcome is unknown. It returns true if the provider has the deal (in
+// which case the deal is accepted), or if its status could not be checked
+// (in which case the outcome remains unknown). It returns false if the
+// provider doesn't have the deal, and so it is safe to propose it again.
+func checkOutcome(ctx context.Context, p Proposer, d Deal, res *Result) bool {
+	resp, err := CheckProposed(ctx, p, d.Peer, d.Params.DealUUID)
+	if err != nil {
+		log.Warnw("checking whether the provider has a deal whose proposal outcome is unknown", "id", d.Params.DealUUID, "provider", res.Provider, "err", err)
+		res.Err = fmt.Errorf("%w (checking whether the provider has the deal failed: %s)", res.Err, err)
+		return true
+	}
+	res.OutcomeUnknown = false
+	if resp == nil {
+		return false
+	}
+	res.Accepted = resp.Accepted
+	res.Message = resp.Message
+	res.Err = nil
+	return true
+}
+
+// CheckProposed asks the provider whether it has a deal whose proposal may
+// have reached it, but for which no response was received. If the provider
+// has the deal it returns the response that was lost. If the provider
+// doesn't have the deal it returns nil, and it is safe to propose the deal
+// again.
+func CheckProposed(ctx context.Context, p Proposer, id peer.ID, dealUUID uuid.UUID) (*types.DealResponse, error) {
+	resp, err := p.DealStatus(ctx, id, dealUUID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsDealNotFound() {
+		return nil, nil
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.DealStatus == nil {
+		return nil, fmt.Errorf("provider returned an empty status for deal %s", dealUUID)
+	}
+	msg := "accepted (confirmed by deal status after the proposal response was lost)"
+	if resp.DealStatus.Error != "" {
+		msg += ", deal has since failed: " + resp.DealStatus.Error
+	}
+	return &types.DealResponse{Accepted: true, Message: msg}, nil
+}
+
+// ProposeChecked proposes a single deal, and if the outcome of the proposal
+// is unknown (eg the response timed out), asks the provider whether it has
+// the deal before proposing it again with the same uuid, up to maxAttempts
+// times. If the outcome is still unknown (because the provider's deal
+// status could not be checked) it returns the ProposalOutcomeUnknownError:
+// the caller must keep the deal's uuid, and check the deal's status before
+// proposing the piece again.
+func ProposeChecked(ctx context.Context, p Proposer, id peer.ID, params types.DealParams, maxAttempts int) (*types.DealResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := p.Propose(ctx, id, params)
+		if err == nil {
+			// If the provider says it already has the deal, an earlier
+			// proposal did reach it after all
+			if !resp.Accepted && attempt > 1 && types.IsDuplicateRejection(resp.Message) {
+				if sresp, serr := CheckProposed(ctx, p, id, params.DealUUID); serr == nil && sresp != nil {
+					return sresp, nil
+				}
+			}
+			return resp, nil
+		}
+
+		var uerr *types.ProposalOutcomeUnknownError
+		if !errors.As(err, &uerr) {
+			return nil, err
+		}
+		sresp, serr := CheckProposed(ctx, p, id, params.DealUUID)
+		if serr != nil {
+			log.Warnw("checking whether the provider has a deal whose proposal outcome is unknown", "id", params.DealUUID, "err", serr)
+			return nil, err
+		}
+		if sresp != nil {
+			return sresp, nil
+		}
+		// The provider doesn't have the deal, so it's safe to propose it
+		// again
+		if attempt >= maxAttempts {
+			return nil, uerr.Err
+		}
+		log.Infow("retrying deal proposal that did not reach the provider", "id", params.DealUUID, "attempts", attempt, "err", err)
+	}
+}
+
+func release(funds Funds, amts map[address.Address]abi.TokenAmount) {
+	// Release funds even if the batch was cancelled
+	ctx := context.Background()
+	for client, amt := range amts {
+		if amt.IsZero() {
+			continue
+		}
+		if err := funds.Release(ctx, client, amt); err != nil {
+			log.Warnw("releasing funds reserved for batch of deals", "client", client, "amount", amt, "err", err)
+		}
+	}
+}
+
+// sleep waits for d, returning false if the context is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
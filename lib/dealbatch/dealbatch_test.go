@@ -0,0 +1,383 @@
+package dealbatch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type mockProposer struct {
+	lk     sync.Mutex
+	sent   map[peer.ID][]time.Time
+	fail   map[uuid.UUID]int
+	reject map[uuid.UUID]bool
+	// Proposals for these deals are accepted, but the response is lost
+	lose map[uuid.UUID]int
+	// Proposals for these deals are lost before they reach the provider,
+	// but the client can't tell
+	drop map[uuid.UUID]int
+	// The deals that the provider has accepted
+	deals map[uuid.UUID]bool
+}
+
+func (m *mockProposer) Propose(_ context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.sent[id] = append(m.sent[id], time.Now())
+	if m.fail[params.DealUUID] > 0 {
+		m.fail[params.DealUUID]--
+		return nil, errors.New("stream reset")
+	}
+	if m.reject[params.DealUUID] {
+		return &types.DealResponse{Message: "no thanks"}, nil
+	}
+	if m.drop[params.DealUUID] > 0 {
+		m.drop[params.DealUUID]--
+		return nil, &types.ProposalOutcomeUnknownError{DealUUID: params.DealUUID, Err: errors.New("stream reset")}
+	}
+	if m.deals[params.DealUUID] {
+		return &types.DealResponse{Message: types.DealUuidNotUnique + " " + params.DealUUID.String()}, nil
+	}
+	if m.deals == nil {
+		m.deals = make(map[uuid.UUID]bool)
+	}
+	m.deals[params.DealUUID] = true
+	if m.lose[params.DealUUID] > 0 {
+		m.lose[params.DealUUID]--
+		return nil, &types.ProposalOutcomeUnknownError{DealUUID: params.DealUUID, Err: errors.New("read timeout")}
+	}
+	return &types.DealResponse{Accepted: true}, nil
+}
+
+func (m *mockProposer) DealStatus(_ context.Context, _ peer.ID, dealUUID uuid.UUID) (*types.DealStatusResponse, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if !m.deals[dealUUID] {
+		return &types.DealStatusResponse{DealUUID: dealUUID, Error: types.DealStatusNotFound + " with deal UUID " + dealUUID.String()}, nil
+	}
+	return &types.DealStatusResponse{DealUUID: dealUUID, DealStatus: &types.DealStatus{Status: "Accepted"}}, nil
+}
+
+type mockFunds struct {
+	available abi.TokenAmount
+	reserved  abi.TokenAmount
+}
+
+func (m *mockFunds) Reserve(_ context.Context, _ address.Address, amt abi.TokenAmount) error {
+	if big.Add(m.reserved, amt).GreaterThan(m.available) {
+		return errors.New("not enough funds")
+	}
+	m.reserved = big.Add(m.reserved, amt)
+	return nil
+}
+
+func (m *mockFunds) Release(_ context.Context, _ address.Address, amt abi.TokenAmount) error {
+	m.reserved = big.Sub(m.reserved, amt)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	client, err := address.NewIDAddress(100)
+	req.NoError(err)
+	var deals []Deal
+	for _, p := range []struct {
+		id   uint64
+		peer peer.ID
+	}{{1000, "peer1"}, {1000, "peer1"}, {1000, "peer1"}, {1001, "peer2"}} {
+		prov, err := address.NewIDAddress(p.id)
+		req.NoError(err)
+		deals = append(deals, Deal{
+			Peer: p.peer,
+			Params: types.DealParams{
+				DealUUID: uuid.New(),
+				ClientDealProposal: market.ClientDealProposal{Proposal: market.DealProposal{
+					Client:               client,
+					Provider:             prov,
+					StartEpoch:           10,
+					EndEpoch:             20,
+					StoragePricePerEpoch: big.NewInt(1),
+					ClientCollateral:     big.Zero(),
+				}},
+			},
+		})
+	}
+
+	// The batch costs 10 per deal, so 40 can't be reserved from 30
+	funds := &mockFunds{available: big.NewInt(30), reserved: big.Zero()}
+	prop := &mockProposer{sent: make(map[peer.ID][]time.Time)}
+	cfg := Config{ProviderInterval: 50 * time.Millisecond, MaxAttempts: 2, Funds: funds}
+	_, err = Run(ctx, prop, deals, cfg)
+	req.Error(err)
+	req.Empty(prop.sent)
+	req.True(funds.reserved.IsZero())
+
+	// The first deal fails to send once then is accepted, the second is
+	// rejected and the third fails to send every time
+	funds.available = big.NewInt(40)
+	prop.fail = map[uuid.UUID]int{deals[0].Params.DealUUID: 1, deals[2].Params.DealUUID: 2}
+	prop.reject = map[uuid.UUID]bool{deals[1].Params.DealUUID: true}
+	res, err := Run(ctx, prop, deals, cfg)
+	req.NoError(err)
+	req.Len(res, 4)
+
+	req.True(res[0].Accepted)
+	req.Equal(2, res[0].Attempts)
+	req.False(res[1].Accepted)
+	req.Equal("no thanks", res[1].Message)
+	req.NoError(res[1].Err)
+	req.False(res[2].Accepted)
+	req.Equal(2, res[2].Attempts)
+	req.Error(res[2].Err)
+	req.True(res[3].Accepted)
+	for i, r := range res {
+		req.Equal(deals[i].Params.DealUUID, r.DealUUID)
+	}
+
+	// Proposals to the same provider are spaced out
+	req.Len(prop.sent["peer1"], 5)
+	for i := 1; i < len(prop.sent["peer1"]); i++ {
+		req.GreaterOrEqual(prop.sent["peer1"][i].Sub(prop.sent["peer1"][i-1]), cfg.ProviderInterval)
+	}
+
+	// Only the funds for the accepted deals remain reserved
+	req.Equal(big.NewInt(20), funds.reserved)
+}
+
+func TestRunUnknownOutcome(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	client, err := address.NewIDAddress(100)
+	req.NoError(err)
+	prov, err := address.NewIDAddress(1000)
+	req.NoError(err)
+	var deals []Deal
+	for i := 0; i < 2; i++ {
+		deals = append(deals, Deal{
+			Peer: "peer1",
+			Params: types.DealParams{
+				DealUUID: uuid.New(),
+				ClientDealProposal: market.ClientDealProposal{Proposal: market.DealProposal{
+					Client:               client,
+					Provider:             prov,
+					StartEpoch:           10,
+					EndEpoch:             20,
+					StoragePricePerEpoch: big.NewInt(1),
+					ClientCollateral:     big.Zero(),
+				}},
+			},
+		})
+	}
+
+	// The outcome of the first proposal of each deal is unknown. The
+	// provider has the first deal, so it is not proposed again. The second
+	// proposal never reached the provider, so it is proposed again.
+	funds := &mockFunds{available: big.NewInt(20), reserved: big.Zero()}
+	prop := &mockProposer{
+		sent: make(map[peer.ID][]time.Time),
+		lose: map[uuid.UUID]int{deals[0].Params.DealUUID: 1},
+		drop: map[uuid.UUID]int{deals[1].Params.DealUUID: 1},
+	}
+	res, err := Run(ctx, prop, deals, Config{MaxAttempts: 3, Funds: funds})
+	req.NoError(err)
+
+	req.True(res[0].Accepted)
+	req.Equal(1, res[0].Attempts)
+	req.False(res[0].OutcomeUnknown)
+	req.Contains(res[0].Message, "confirmed by deal status")
+	req.True(res[1].Accepted)
+	req.Equal(2, res[1].Attempts)
+	req.Len(prop.sent["peer1"], 3)
+	req.Equal(big.NewInt(20), funds.reserved)
+
+	// The response to a re-proposal says that the provider already has the
+	// deal: the earlier proposal reached it
+	deals[0].Params.DealUUID = uuid.New()
+	prop.deals = nil
+	p := &racyProposer{mockProposer: prop}
+	funds.reserved = big.Zero()
+	res, err = Run(ctx, p, deals[:1], Config{MaxAttempts: 3, Funds: funds})
+	req.NoError(err)
+	req.True(res[0].Accepted)
+	req.Equal(2, res[0].Attempts)
+	req.Equal(big.NewInt(10), funds.reserved)
+}
+
+// racyProposer loses the response to the first proposal, and the provider
+// only records the deal after the client has checked its status
+type racyProposer struct {
+	*mockProposer
+	proposed int
+}
+
+func (r *racyProposer) Propose(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
+	r.proposed++
+	if r.proposed == 1 {
+		return nil, &types.ProposalOutcomeUnknownError{DealUUID: params.DealUUID, Err: errors.New("read timeout")}
+	}
+	if r.proposed == 2 {
+		// The first proposal is recorded just before the second arrives
+		r.deals = map[uuid.UUID]bool{params.DealUUID: true}
+	}
+	return r.mockProposer.Propose(ctx, id, params)
+}
+
+func TestRunProviderRetry(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	client, err := address.NewIDAddress(100)
+	req.NoError(err)
+	var deals []Deal
+	for _, p := range []struct {
+		id   uint64
+		peer peer.ID
+	}{{1000, "peer1"}, {1001, "peer2"}} {
+		prov, err := address.NewIDAddress(p.id)
+		req.NoError(err)
+		deals = append(deals, Deal{
+			Peer: p.peer,
+			Params: types.DealParams{
+				DealUUID: uuid.New(),
+				ClientDealProposal: market.ClientDealProposal{Proposal: market.DealProposal{
+					Client:               client,
+					Provider:             prov,
+					StoragePricePerEpoch: big.Zero(),
+					ClientCollateral:     big.Zero(),
+				}},
+			},
+		})
+	}
+
+	// Both deals fail to send twice, but only the second provider's
+	// proposals are retried enough times to be accepted
+	prop := &mockProposer{
+		sent: make(map[peer.ID][]time.Time),
+		fail: map[uuid.UUID]int{deals[0].Params.DealUUID: 2, deals[1].Params.DealUUID: 2},
+	}
+	cfg := Config{
+		MaxAttempts:   2,
+		RetryInterval: time.Millisecond,
+		ProviderRetry: map[address.Address]Retry{
+			deals[1].Params.ClientDealProposal.Proposal.Provider: {MaxAttempts: 3, RetryInterval: time.Millisecond},
+		},
+	}
+	res, err := Run(ctx, prop, deals, cfg)
+	req.NoError(err)
+	req.False(res[0].Accepted)
+	req.Equal(2, res[0].Attempts)
+	req.Error(res[0].Err)
+	req.True(res[1].Accepted)
+	req.Equal(3, res[1].Attempts)
+}
+
+func TestRunProviderInterval(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	client, err := address.NewIDAddress(100)
+	req.NoError(err)
+	slow, err := address.NewIDAddress(1001)
+	req.NoError(err)
+	var deals []Deal
+	for _, p := range []struct {
+		id   uint64
+		peer peer.ID
+	}{{1000, "peer1"}, {1000, "peer1"}, {1001, "peer2"}, {1001, "peer2"}} {
+		prov, err := address.NewIDAddress(p.id)
+		req.NoError(err)
+		deals = append(deals, Deal{
+			Peer: p.peer,
+			Params: types.DealParams{
+				DealUUID: uuid.New(),
+				ClientDealProposal: market.ClientDealProposal{Proposal: market.DealProposal{
+					Client:               client,
+					Provider:             prov,
+					StoragePricePerEpoch: big.Zero(),
+					ClientCollateral:     big.Zero(),
+				}},
+			},
+		})
+	}
+
+	// Only the proposals to the provider with an interval are spaced out
+	prop := &mockProposer{sent: make(map[peer.ID][]time.Time)}
+	cfg := Config{ProviderIntervals: map[address.Address]time.Duration{slow: 100 * time.Millisecond}}
+	res, err := Run(ctx, prop, deals, cfg)
+	req.NoError(err)
+	for _, r := range res {
+		req.True(r.Accepted)
+	}
+	req.Len(prop.sent["peer2"], 2)
+	req.GreaterOrEqual(prop.sent["peer2"][1].Sub(prop.sent["peer2"][0]), 100*time.Millisecond)
+}
+
+func TestProposeChecked(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	params := func() types.DealParams {
+		return types.DealParams{DealUUID: uuid.New()}
+	}
+
+	// The response to an accepted proposal is lost: the deal's status shows
+	// that it was accepted, so it is not proposed again
+	p := &mockProposer{sent: make(map[peer.ID][]time.Time), lose: make(map[uuid.UUID]int)}
+	lost := params()
+	p.lose[lost.DealUUID] = 1
+	resp, err := ProposeChecked(ctx, p, "peer1", lost, 3)
+	req.NoError(err)
+	req.True(resp.Accepted)
+	req.Len(p.sent["peer1"], 1)
+
+	// The proposal never reached the provider: it is proposed again with the
+	// same uuid
+	p = &mockProposer{sent: make(map[peer.ID][]time.Time), drop: make(map[uuid.UUID]int)}
+	dropped := params()
+	p.drop[dropped.DealUUID] = 1
+	resp, err = ProposeChecked(ctx, p, "peer1", dropped, 3)
+	req.NoError(err)
+	req.True(resp.Accepted)
+	req.Len(p.sent["peer1"], 2)
+	req.True(p.deals[dropped.DealUUID])
+
+	// Every proposal is dropped: once the attempts are used up the error
+	// is no longer an unknown outcome, as the provider doesn't have the deal
+	p = &mockProposer{sent: make(map[peer.ID][]time.Time), drop: make(map[uuid.UUID]int)}
+	p.drop[dropped.DealUUID] = 5
+	_, err = ProposeChecked(ctx, p, "peer1", dropped, 2)
+	req.Error(err)
+	var uerr *types.ProposalOutcomeUnknownError
+	req.False(errors.As(err, &uerr))
+	req.Len(p.sent["peer1"], 2)
+
+	// The deal status can't be checked: the outcome remains unknown
+	p = &mockProposer{sent: make(map[peer.ID][]time.Time), lose: make(map[uuid.UUID]int)}
+	p.lose[lost.DealUUID] = 1
+	_, err = ProposeChecked(ctx, unreachableStatus{p}, "peer1", lost, 3)
+	req.ErrorAs(err, &uerr)
+	req.Equal(lost.DealUUID, uerr.DealUUID)
+}
+
+// unreachableStatus is a proposer whose deal status requests fail
+type unreachableStatus struct {
+	*mockProposer
+}
+
+func (u unreachableStatus) DealStatus(context.Context, peer.ID, uuid.UUID) (*types.DealStatusResponse, error) {
+	return nil, errors.New("stream reset")
+}
@@ -0,0 +1,211 @@
+package dealsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockSink struct {
+	lk       sync.Mutex
+	fail     bool
+	initFail int
+	inits    int
+	batches  [][]Event
+}
+
+func (s *mockSink) Close() error { return nil }
+
+func (s *mockSink) Init(ctx context.Context) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.inits++
+	if s.inits <= s.initFail {
+		return errors.New("database unavailable")
+	}
+	return nil
+}
+
+func (s *mockSink) Write(ctx context.Context, events []Event) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.fail {
+		return errors.New("database unavailable")
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *mockSink) written() []Event {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	var evts []Event
+	for _, b := range s.batches {
+		evts = append(evts, b...)
+	}
+	return evts
+}
+
+func TestForwarder(t *testing.T) {
+	req := require.New(t)
+
+	sink := &mockSink{fail: true}
+	f := NewForwarder(sink, Config{
+		BatchSize:                2,
+		FlushInterval:            time.Hour,
+		MaxBuffered:              3,
+		TransferProgressInterval: time.Second,
+	})
+
+	// Transfer progress updates within the interval are dropped, except for
+	// the update when the transfer completes
+	now := time.Now()
+	evt := func(dealUuid string, ckpt string, received int64, at time.Duration) Event {
+		return Event{DealUuid: dealUuid, Checkpoint: ckpt, TransferSize: 100, BytesReceived: received, EventTime: now.Add(at)}
+	}
+	f.Add(evt("a", "Accepted", 0, 0))
+	f.Add(evt("a", "Accepted", 10, 100*time.Millisecond))
+	f.Add(evt("a", "Accepted", 50, 1100*time.Millisecond))
+	f.Add(evt("a", "Accepted", 100, 1200*time.Millisecond))
+	f.Add(evt("a", "Transferred", 100, 1300*time.Millisecond))
+
+	// The buffer only holds 3 events, so the oldest is dropped
+	req.EqualValues(1, f.Dropped())
+
+	// Writes fail while the sink is unavailable, and the events are kept
+	ctx, cancel := context.WithCancel(context.Background())
+	f.flush(ctx)
+	req.Empty(sink.written())
+
+	// Once the sink is available the buffered events are written in batches
+	sink.lk.Lock()
+	sink.fail = false
+	sink.lk.Unlock()
+	f.flush(ctx)
+	written := sink.written()
+	req.Len(written, 3)
+	req.EqualValues(50, written[0].BytesReceived)
+	req.EqualValues(100, written[1].BytesReceived)
+	req.Equal("Transferred", written[2].Checkpoint)
+	req.Len(sink.batches, 2)
+
+	// Remaining events are flushed on shutdown
+	f.Add(evt("b", "Accepted", 0, 0))
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+	req.Len(sink.written(), 4)
+}
+
+func TestForwarderInitRetry(t *testing.T) {
+	req := require.New(t)
+
+	defer func(min, max time.Duration) {
+		initRetryMinBackoff, initRetryMaxBackoff = min, max
+	}(initRetryMinBackoff, initRetryMaxBackoff)
+	initRetryMinBackoff = time.Millisecond
+	initRetryMaxBackoff = 2 * time.Millisecond
+
+	// Events are buffered while the sink can't be initialized, and written
+	// once it has been
+	sink := &mockSink{initFail: 3}
+	f := NewForwarder(sink, Config{BatchSize: 1, FlushInterval: time.Millisecond})
+	f.Add(Event{DealUuid: "a", Checkpoint: "Accepted"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+	req.Eventually(func() bool { return len(sink.written()) == 1 }, time.Second, time.Millisecond)
+	sink.lk.Lock()
+	req.Equal(4, sink.inits)
+	sink.lk.Unlock()
+
+	// If the sink is never initialized, Run returns when the context is
+	// cancelled
+	sink = &mockSink{initFail: 1000}
+	f = NewForwarder(sink, Config{})
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+	req.Empty(sink.written())
+}
+
+func TestClickHouse(t *testing.T) {
+	req := require.New(t)
+
+	var lk sync.Mutex
+	var queries []string
+	var rows []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		defer lk.Unlock()
+
+		query := r.URL.Query().Get("query")
+		if query == "" {
+			b, _ := io.ReadAll(r.Body)
+			queries = append(queries, string(b))
+			return
+		}
+		queries = append(queries, query)
+		if strings.Contains(query, "missing") {
+			http.Error(w, "Table boost.missing doesn't exist", http.StatusNotFound)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row map[string]interface{}
+			req.NoError(json.Unmarshal(scanner.Bytes(), &row))
+			rows = append(rows, row)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ch, err := NewClickHouse(srv.URL+"/?database=boost", "deal_states")
+	req.NoError(err)
+	req.NoError(ch.Init(ctx))
+	req.NoError(ch.Write(ctx, []Event{{DealUuid: "a", Checkpoint: "Accepted"}, {DealUuid: "b", PieceSize: 1024}}))
+
+	req.Len(queries, 2)
+	req.Contains(queries[0], "CREATE TABLE IF NOT EXISTS deal_states")
+	req.Contains(queries[0], "storage_price_per_epoch String")
+	req.Equal("INSERT INTO deal_states FORMAT JSONEachRow", queries[1])
+	req.Len(rows, 2)
+	req.Equal("a", rows[0]["deal_uuid"])
+	req.Equal("Accepted", rows[0]["checkpoint"])
+	req.EqualValues(1024, rows[1]["piece_size"])
+
+	// Every column in the schema is written
+	for _, c := range columns {
+		req.Contains(rows[0], c.name)
+	}
+
+	// Errors from the server are returned
+	missing, err := NewClickHouse(srv.URL, "missing")
+	req.NoError(err)
+	err = missing.Write(ctx, []Event{{DealUuid: "a"}})
+	req.ErrorContains(err, "doesn't exist")
+
+	// Table names are checked
+	_, err = NewClickHouse(srv.URL, "deals; DROP TABLE deals")
+	req.Error(err)
+}
@@ -0,0 +1,176 @@
+// Package dealsink streams deal and transfer state changes into an external
+// analytical database, so that deal data can be queried and graphed without
+// scraping the boost RPC.
+//
+// Each state update of a deal is written as one row. The sinks create the
+// table if it doesn't already exist, with the following columns:
+//
+//	event_time               time the state update happened
+//	deal_uuid                the deal's unique id
+//	created_at               time the deal was accepted
+//	checkpoint               the deal's checkpoint (Accepted, Transferred, Published, ...)
+//	checkpoint_at            time the deal reached the checkpoint
+//	client_address           the client's wallet address
+//	provider_address         the storage provider's address
+//	client_peer_id           the client's libp2p peer ID
+//	piece_cid                the deal's piece cid
+//	piece_size               the padded piece size in bytes
+//	payload_cid              the root cid of the deal data
+//	verified                 true for verified (datacap) deals
+//	start_epoch              the deal proposal's start epoch
+//	end_epoch                the deal proposal's end epoch
+//	storage_price_per_epoch  the deal price in attoFIL per epoch
+//	provider_collateral      the provider collateral in attoFIL
+//	is_offline               true for offline deals
+//	transfer_type            the transfer type (eg http, libp2p)
+//	transfer_size            the size of the data to be transferred in bytes
+//	bytes_received           the number of bytes transferred so far
+//	chain_deal_id            the on-chain deal ID, once published
+//	publish_cid              the publish message cid, once published
+//	sector_id                the sector the deal was added to
+//	error                    the deal's error, if any
+//	retry                    how the deal can be retried after an error (auto, manual, fatal)
+//
+// Rows are append-only: the current state of a deal is the row with the
+// latest event_time for its deal_uuid.
+package dealsink
+
+import (
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+)
+
+// Event is a single deal state update, as it is written to the sink
+type Event struct {
+	EventTime            time.Time `json:"event_time"`
+	DealUuid             string    `json:"deal_uuid"`
+	CreatedAt            time.Time `json:"created_at"`
+	Checkpoint           string    `json:"checkpoint"`
+	CheckpointAt         time.Time `json:"checkpoint_at"`
+	ClientAddress        string    `json:"client_address"`
+	ProviderAddress      string    `json:"provider_address"`
+	ClientPeerID         string    `json:"client_peer_id"`
+	PieceCid             string    `json:"piece_cid"`
+	PieceSize            uint64    `json:"piece_size"`
+	PayloadCid           string    `json:"payload_cid"`
+	Verified             bool      `json:"verified"`
+	StartEpoch           int64     `json:"start_epoch"`
+	EndEpoch             int64     `json:"end_epoch"`
+	StoragePricePerEpoch string    `json:"storage_price_per_epoch"`
+	ProviderCollateral   string    `json:"provider_collateral"`
+	IsOffline            bool      `json:"is_offline"`
+	TransferType         string    `json:"transfer_type"`
+	TransferSize         uint64    `json:"transfer_size"`
+	BytesReceived        int64     `json:"bytes_received"`
+	ChainDealID          uint64    `json:"chain_deal_id"`
+	PublishCid           string    `json:"publish_cid"`
+	SectorID             uint64    `json:"sector_id"`
+	Error                string    `json:"error"`
+	Retry                string    `json:"retry"`
+}
+
+// column describes a column in the sink table, with its type in each of the
+// supported databases
+type column struct {
+	name       string
+	postgres   string
+	clickhouse string
+}
+
+// columns must be in the same order as the values returned by Event.values
+var columns = []column{
+	{"event_time", "TIMESTAMPTZ NOT NULL", "DateTime64(3, 'UTC')"},
+	{"deal_uuid", "TEXT NOT NULL", "String"},
+	{"created_at", "TIMESTAMPTZ", "DateTime64(3, 'UTC')"},
+	{"checkpoint", "TEXT", "LowCardinality(String)"},
+	{"checkpoint_at", "TIMESTAMPTZ", "DateTime64(3, 'UTC')"},
+	{"client_address", "TEXT", "String"},
+	{"provider_address", "TEXT", "LowCardinality(String)"},
+	{"client_peer_id", "TEXT", "String"},
+	{"piece_cid", "TEXT", "String"},
+	{"piece_size", "BIGINT", "UInt64"},
+	{"payload_cid", "TEXT", "String"},
+	{"verified", "BOOLEAN", "Bool"},
+	{"start_epoch", "BIGINT", "Int64"},
+	{"end_epoch", "BIGINT", "Int64"},
+	{"storage_price_per_epoch", "TEXT", "String"},
+	{"provider_collateral", "TEXT", "String"},
+	{"is_offline", "BOOLEAN", "Bool"},
+	{"transfer_type", "TEXT", "LowCardinality(String)"},
+	{"transfer_size", "BIGINT", "UInt64"},
+	{"bytes_received", "BIGINT", "Int64"},
+	{"chain_deal_id", "BIGINT", "UInt64"},
+	{"publish_cid", "TEXT", "String"},
+	{"sector_id", "BIGINT", "UInt64"},
+	{"error", "TEXT", "String"},
+	{"retry", "TEXT", "LowCardinality(String)"},
+}
+
+func (e *Event) values() []interface{} {
+	return []interface{}{
+		e.EventTime,
+		e.DealUuid,
+		e.CreatedAt,
+		e.Checkpoint,
+		e.CheckpointAt,
+		e.ClientAddress,
+		e.ProviderAddress,
+		e.ClientPeerID,
+		e.PieceCid,
+		// Postgres BIGINT is signed
+		int64(e.PieceSize),
+		e.PayloadCid,
+		e.Verified,
+		e.StartEpoch,
+		e.EndEpoch,
+		e.StoragePricePerEpoch,
+		e.ProviderCollateral,
+		e.IsOffline,
+		e.TransferType,
+		int64(e.TransferSize),
+		e.BytesReceived,
+		int64(e.ChainDealID),
+		e.PublishCid,
+		int64(e.SectorID),
+		e.Error,
+		e.Retry,
+	}
+}
+
+// EventFromDeal creates an event from the state of a deal at the given time
+func EventFromDeal(deal *types.ProviderDealState, at time.Time) Event {
+	prop := deal.ClientDealProposal.Proposal
+	evt := Event{
+		EventTime:            at,
+		DealUuid:             deal.DealUuid.String(),
+		CreatedAt:            deal.CreatedAt,
+		Checkpoint:           deal.Checkpoint.String(),
+		CheckpointAt:         deal.CheckpointAt,
+		ClientAddress:        prop.Client.String(),
+		ProviderAddress:      prop.Provider.String(),
+		ClientPeerID:         deal.ClientPeerID.String(),
+		PieceCid:             prop.PieceCID.String(),
+		PieceSize:            uint64(prop.PieceSize),
+		Verified:             prop.VerifiedDeal,
+		StartEpoch:           int64(prop.StartEpoch),
+		EndEpoch:             int64(prop.EndEpoch),
+		StoragePricePerEpoch: prop.StoragePricePerEpoch.String(),
+		ProviderCollateral:   prop.ProviderCollateral.String(),
+		IsOffline:            deal.IsOffline,
+		TransferType:         deal.Transfer.Type,
+		TransferSize:         deal.Transfer.Size,
+		BytesReceived:        deal.NBytesReceived,
+		ChainDealID:          uint64(deal.ChainDealID),
+		SectorID:             uint64(deal.SectorID),
+		Error:                deal.Err,
+		Retry:                string(deal.Retry),
+	}
+	if deal.DealDataRoot.Defined() {
+		evt.PayloadCid = deal.DealDataRoot.String()
+	}
+	if deal.PublishCID != nil {
+		evt.PublishCid = deal.PublishCID.String()
+	}
+	return evt
+}
@@ -0,0 +1,246 @@
+package dealsink
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("dealsink")
+
+// Sink writes deal state events to an external database
+type Sink interface {
+	// Init creates the sink's table if it doesn't already exist
+	Init(ctx context.Context) error
+	// Write writes a batch of events
+	Write(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// New creates a sink of the given type ("postgres" or "clickhouse")
+func New(sinkType string, url string, table string) (Sink, error) {
+	switch sinkType {
+	case "postgres":
+		return NewPostgres(url, table)
+	case "clickhouse":
+		return NewClickHouse(url, table)
+	}
+	return nil, fmt.Errorf("unrecognized deal state sink type '%s': must be one of postgres, clickhouse", sinkType)
+}
+
+var tableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// checkTableName makes sure the table name is safe to use in a query
+func checkTableName(table string) error {
+	if !tableNameRegex.MatchString(table) {
+		return fmt.Errorf("invalid deal state sink table name '%s'", table)
+	}
+	return nil
+}
+
+// Config configures the Forwarder
+type Config struct {
+	// The maximum number of events written in a single batch
+	BatchSize int
+	// The interval at which buffered events are written to the sink
+	FlushInterval time.Duration
+	// The maximum number of events to buffer while the sink is unavailable.
+	// When the buffer is full the oldest events are dropped.
+	MaxBuffered int
+	// Transfer progress updates for a deal are written at most once per
+	// TransferProgressInterval. Zero means write every update.
+	TransferProgressInterval time.Duration
+}
+
+// Forwarder buffers deal state events and periodically writes them to a
+// sink in batches. Adding an event never blocks, so that a slow or
+// unavailable database never holds up deal execution.
+type Forwarder struct {
+	sink Sink
+	cfg  Config
+
+	lk       sync.Mutex
+	buffered []Event
+	dropped  uint64
+	// the last event that was accepted for each active deal, used to
+	// throttle transfer progress updates
+	last map[string]Event
+	// signalled when the buffer reaches the batch size
+	full chan struct{}
+}
+
+// NewForwarder creates a Forwarder that writes to the sink
+func NewForwarder(sink Sink, cfg Config) *Forwarder {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxBuffered < cfg.BatchSize {
+		cfg.MaxBuffered = 100 * cfg.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	return &Forwarder{
+		sink: sink,
+		cfg:  cfg,
+		last: make(map[string]Event),
+		full: make(chan struct{}, 1),
+	}
+}
+
+// AddDeal adds an event for the current state of the deal
+func (f *Forwarder) AddDeal(deal *types.ProviderDealState) {
+	f.Add(EventFromDeal(deal, time.Now()))
+}
+
+// Add buffers an event to be written to the sink
+func (f *Forwarder) Add(evt Event) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	if f.isThrottled(evt) {
+		return
+	}
+	if evt.Checkpoint == dealcheckpoints.Complete.String() || evt.Error != "" {
+		delete(f.last, evt.DealUuid)
+	} else {
+		f.last[evt.DealUuid] = evt
+	}
+
+	if len(f.buffered) >= f.cfg.MaxBuffered {
+		f.buffered = f.buffered[1:]
+		f.dropped++
+		if f.dropped%1000 == 1 {
+			log.Warnw("deal state sink buffer is full: dropping oldest events", "dropped", f.dropped)
+		}
+	}
+	f.buffered = append(f.buffered, evt)
+	if len(f.buffered) >= f.cfg.BatchSize {
+		select {
+		case f.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isThrottled is true if the event is a transfer progress update (ie only
+// the number of bytes received has changed) that arrived too soon after the
+// last update for the deal
+func (f *Forwarder) isThrottled(evt Event) bool {
+	if f.cfg.TransferProgressInterval == 0 {
+		return false
+	}
+	prev, ok := f.last[evt.DealUuid]
+	if !ok {
+		return false
+	}
+	if prev.Checkpoint != evt.Checkpoint || prev.Error != evt.Error || evt.BytesReceived == int64(evt.TransferSize) {
+		return false
+	}
+	return evt.EventTime.Sub(prev.EventTime) < f.cfg.TransferProgressInterval
+}
+
+// Dropped is the number of events that were dropped because the buffer
+// was full
+func (f *Forwarder) Dropped() uint64 {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return f.dropped
+}
+
+// The bounds of the interval between attempts to initialize the sink
+var (
+	initRetryMinBackoff = time.Second
+	initRetryMaxBackoff = time.Minute
+)
+
+// Run initializes the sink, then writes buffered events to the sink until
+// the context is cancelled, then makes a final attempt to write any remaining
+// events.
+// If the sink can't be initialized (eg because the database is down) Run
+// keeps retrying with backoff, and events are buffered in the meantime.
+func (f *Forwarder) Run(ctx context.Context) {
+	if !f.init(ctx) {
+		return
+	}
+
+	ticker := time.NewTicker(f.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			f.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-f.full:
+		}
+		f.flush(ctx)
+	}
+}
+
+// init initializes the sink, retrying with backoff until it succeeds.
+// It returns false if the context is cancelled first.
+func (f *Forwarder) init(ctx context.Context) bool {
+	backoff := initRetryMinBackoff
+	for {
+		err := f.sink.Init(ctx)
+		if err == nil {
+			return true
+		}
+		log.Warnw("initializing deal state sink: retrying", "in", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > initRetryMaxBackoff {
+			backoff = initRetryMaxBackoff
+		}
+	}
+}
+
+// flush writes buffered events to the sink in batches. If a write fails
+// the events stay in the buffer to be retried on the next flush.
+func (f *Forwarder) flush(ctx context.Context) {
+	for {
+		f.lk.Lock()
+		n := len(f.buffered)
+		if n > f.cfg.BatchSize {
+			n = f.cfg.BatchSize
+		}
+		batch := make([]Event, n)
+		copy(batch, f.buffered)
+		droppedBefore := f.dropped
+		f.lk.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		if err := f.sink.Write(ctx, batch); err != nil {
+			log.Warnw("writing deal state events to sink", "events", n, "err", err)
+			return
+		}
+
+		// Remove the written events from the buffer. If the buffer
+		// overflowed while writing, some of the written events will
+		// already have been dropped from the front of the buffer.
+		f.lk.Lock()
+		written := n - int(f.dropped-droppedBefore)
+		if written > 0 {
+			f.buffered = f.buffered[written:]
+		}
+		f.lk.Unlock()
+	}
+}
@@ -0,0 +1,431 @@
+// Package dealwatch watches the chain for a deal's publish message to land,
+// and for the deal to be activated (or slashed), without polling the state
+// of each deal.
+//
+// The watcher subscribes to chain head changes. For each new tipset it reads
+// the messages that were executed in the tipset once, and:
+//   - reports the publish messages that are being watched, or the messages
+//     that replaced them (eg because the provider bumped the gas)
+//   - for watched deals whose provider submitted a prove commit (or replica
+//     update) message, checks the deal state to see if it has been activated
+//
+// So activation is detected within an epoch, with one state call per
+// proving provider rather than one per deal per poll. If the notify stream
+// drops, the watcher falls back to polling the state of each watch until it
+// can subscribe again.
+package dealwatch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("dealwatch")
+
+// DefaultPollInterval is the interval at which watches are polled while the
+// notify stream is down (about one epoch)
+const DefaultPollInterval = 30 * time.Second
+
+// ChainAPI is the subset of the gateway API used by the watcher
+type ChainAPI interface {
+	ChainNotify(ctx context.Context) (<-chan []*lapi.HeadChange, error)
+	ChainGetParentMessages(ctx context.Context, blockCid cid.Cid) ([]lapi.Message, error)
+	ChainGetParentReceipts(ctx context.Context, blockCid cid.Cid) ([]*types.MessageReceipt, error)
+	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*lapi.MsgLookup, error)
+	StateMarketStorageDeal(ctx context.Context, dealID abi.DealID, tsk types.TipSetKey) (*lapi.MarketDeal, error)
+}
+
+type EventType string
+
+const (
+	// The publish message landed on chain (check the exit code to see if
+	// it succeeded)
+	EventPublished EventType = "published"
+	// The deal was activated in a sector
+	EventActivated EventType = "activated"
+	// The deal was slashed
+	EventSlashed EventType = "slashed"
+)
+
+// Event is sent to subscribers when a watched publish message lands or a
+// watched deal changes state. Each watch generates at most one event, after
+// which it is removed.
+type Event struct {
+	Type       EventType
+	PublishCid cid.Cid
+	// If the watched publish message was replaced (eg because the provider
+	// bumped its gas), the cid of the message that landed in its place
+	ReplacedBy cid.Cid
+	ExitCode   exitcode.ExitCode
+	DealID     abi.DealID
+	Provider   address.Address
+	// The height of the tipset at which the change was seen
+	Height abi.ChainEpoch
+	// The epoch at which the deal's sector was activated, or at which the
+	// deal was slashed
+	Epoch abi.ChainEpoch
+}
+
+// Methods of the miner actor that activate deals
+var activatingMethods = map[abi.MethodNum]struct{}{
+	builtin.MethodsMiner.ProveCommitSector:    {},
+	builtin.MethodsMiner.ProveCommitAggregate: {},
+	builtin.MethodsMiner.ProveReplicaUpdates:  {},
+	builtin.MethodsMiner.ProveReplicaUpdates2: {},
+}
+
+// How far back from a tipset to search for a replacement of a watched
+// publish message. The replacement is executed in the tipset's parent, so
+// the search only needs to cover the tipset.
+const replacedSearchLookback = abi.ChainEpoch(2)
+
+type Watcher struct {
+	api          ChainAPI
+	pollInterval time.Duration
+
+	lk       sync.Mutex
+	publish  map[cid.Cid]struct{}
+	deals    map[abi.DealID]address.Address
+	subs     map[int]chan Event
+	nextID   int
+	notifyUp bool
+}
+
+func NewWatcher(api ChainAPI, pollInterval time.Duration) *Watcher {
+	if pollInterval == 0 {
+		pollInterval = DefaultPollInterval
+	}
+	return &Watcher{
+		api:          api,
+		pollInterval: pollInterval,
+		publish:      make(map[cid.Cid]struct{}),
+		deals:        make(map[abi.DealID]address.Address),
+		subs:         make(map[int]chan Event),
+	}
+}
+
+// WatchPublish watches for the publish message to land on chain
+func (w *Watcher) WatchPublish(publishCid cid.Cid) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	w.publish[publishCid] = struct{}{}
+}
+
+// WatchDeal watches for the deal to be activated or slashed
+func (w *Watcher) WatchDeal(dealID abi.DealID, provider address.Address) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	w.deals[dealID] = provider
+}
+
+// Pending returns the number of watches that have not yet generated an event
+func (w *Watcher) Pending() int {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return len(w.publish) + len(w.deals)
+}
+
+// Subscribe returns a channel of events, and a function that cancels the
+// subscription. Events are dropped if the subscriber falls behind.
+func (w *Watcher) Subscribe() (<-chan Event, func()) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	id := w.nextID
+	w.nextID++
+	ch := make(chan Event, 64)
+	w.subs[id] = ch
+	return ch, func() {
+		w.lk.Lock()
+		defer w.lk.Unlock()
+		if _, ok := w.subs[id]; ok {
+			delete(w.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// Run watches the chain until the context is cancelled. While the notify
+// stream is down, watches are polled.
+func (w *Watcher) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		notifs, err := w.api.ChainNotify(ctx)
+		if err != nil {
+			log.Warnw("subscribing to chain notifications, falling back to polling", "err", err)
+			w.pollOnce(ctx)
+			continue
+		}
+
+		w.setNotifyUp(true)
+		// Check the current state once, in case anything changed while
+		// the stream was down
+		w.Poll(ctx)
+		w.consume(ctx, notifs)
+		w.setNotifyUp(false)
+		if ctx.Err() == nil {
+			log.Warnw("chain notify stream closed, falling back to polling")
+			w.pollOnce(ctx)
+		}
+	}
+}
+
+func (w *Watcher) setNotifyUp(up bool) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	w.notifyUp = up
+}
+
+// NotifyUp returns true if the watcher is receiving chain notifications,
+// and false if it is polling
+func (w *Watcher) NotifyUp() bool {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.notifyUp
+}
+
+// pollOnce waits for the poll interval then polls the watches
+func (w *Watcher) pollOnce(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(w.pollInterval):
+	}
+	w.Poll(ctx)
+}
+
+func (w *Watcher) consume(ctx context.Context, notifs <-chan []*lapi.HeadChange) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case changes, ok := <-notifs:
+			if !ok {
+				return
+			}
+			for _, hc := range changes {
+				// Watches generate an event when they are first seen on
+				// chain, so reverts are ignored: a reverted publish message
+				// is normally included again in a later tipset
+				if hc.Type != "apply" || hc.Val == nil {
+					continue
+				}
+				if err := w.ApplyTipSet(ctx, hc.Val); err != nil && ctx.Err() == nil {
+					log.Warnw("checking tipset for watched deals", "height", hc.Val.Height(), "err", err)
+				}
+			}
+		}
+	}
+}
+
+// ApplyTipSet checks the messages executed in the tipset for watched
+// publish messages, and the state of watched deals with providers that
+// proved sectors in the tipset
+func (w *Watcher) ApplyTipSet(ctx context.Context, ts *types.TipSet) error {
+	if w.Pending() == 0 || len(ts.Blocks()) == 0 {
+		return nil
+	}
+
+	// The parent messages of any block in the tipset are the messages
+	// executed to produce the tipset's state
+	blk := ts.Cids()[0]
+	msgs, err := w.api.ChainGetParentMessages(ctx, blk)
+	if err != nil {
+		return err
+	}
+	receipts, err := w.api.ChainGetParentReceipts(ctx, blk)
+	if err != nil {
+		return err
+	}
+
+	proving := make(map[address.Address]struct{})
+	var evts []Event
+	// Whether the tipset executed publish messages that aren't watched,
+	// which may be replacements of watched publish messages
+	var otherPublish bool
+	w.lk.Lock()
+	for i, m := range msgs {
+		if _, ok := w.publish[m.Cid]; ok && i < len(receipts) {
+			delete(w.publish, m.Cid)
+			evts = append(evts, Event{Type: EventPublished, PublishCid: m.Cid, ExitCode: receipts[i].ExitCode, Height: ts.Height()})
+		} else if m.Message.To == builtin.StorageMarketActorAddr && m.Message.Method == builtin.MethodsMarket.PublishStorageDeals {
+			otherPublish = true
+		}
+		if _, ok := activatingMethods[m.Message.Method]; ok {
+			proving[m.Message.To] = struct{}{}
+		}
+	}
+	var publish []cid.Cid
+	if otherPublish {
+		for c := range w.publish {
+			publish = append(publish, c)
+		}
+	}
+	var check []abi.DealID
+	for id, provider := range w.deals {
+		// Messages to a miner may use its robust address, in which case it
+		// can't be matched to the deal's provider without a state lookup
+		_, ok := proving[provider]
+		if ok || hasNonIDAddress(proving) {
+			check = append(check, id)
+		}
+	}
+	w.lk.Unlock()
+
+	w.emit(evts)
+	w.findReplaced(ctx, ts, publish)
+	return w.checkDeals(ctx, ts.Key(), ts.Height(), check)
+}
+
+// findReplaced searches the tipset for messages that replaced the watched
+// publish messages. A message is replaced by a message with the same sender
+// and nonce, so it can't be matched by cid: the node's message search is
+// used to match it.
+func (w *Watcher) findReplaced(ctx context.Context, ts *types.TipSet, publish []cid.Cid) {
+	var evts []Event
+	for _, c := range publish {
+		lookup, err := w.api.StateSearchMsg(ctx, ts.Key(), c, replacedSearchLookback, true)
+		if err != nil {
+			log.Debugw("searching for replaced publish message", "publish-cid", c, "height", ts.Height(), "err", err)
+			continue
+		}
+		if lookup == nil {
+			continue
+		}
+		w.lk.Lock()
+		if _, ok := w.publish[c]; ok {
+			delete(w.publish, c)
+			evts = append(evts, publishedEvent(c, lookup))
+		}
+		w.lk.Unlock()
+	}
+	w.emit(evts)
+}
+
+// publishedEvent is the event for the watched publish message that was
+// found by a message search
+func publishedEvent(publishCid cid.Cid, lookup *lapi.MsgLookup) Event {
+	evt := Event{Type: EventPublished, PublishCid: publishCid, ExitCode: lookup.Receipt.ExitCode, Height: lookup.Height}
+	if lookup.Message.Defined() && lookup.Message != publishCid {
+		evt.ReplacedBy = lookup.Message
+	}
+	return evt
+}
+
+func hasNonIDAddress(addrs map[address.Address]struct{}) bool {
+	for a := range addrs {
+		if a.Protocol() != address.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll checks the state of every watch at the chain head
+func (w *Watcher) Poll(ctx context.Context) {
+	w.lk.Lock()
+	publish := make([]cid.Cid, 0, len(w.publish))
+	for c := range w.publish {
+		publish = append(publish, c)
+	}
+	deals := make([]abi.DealID, 0, len(w.deals))
+	for id := range w.deals {
+		deals = append(deals, id)
+	}
+	w.lk.Unlock()
+
+	var evts []Event
+	for _, c := range publish {
+		lookup, err := w.api.StateSearchMsg(ctx, types.EmptyTSK, c, lapi.LookbackNoLimit, true)
+		if err != nil {
+			log.Infow("searching for publish message", "publish-cid", c, "err", err)
+			continue
+		}
+		if lookup == nil {
+			continue
+		}
+		w.lk.Lock()
+		if _, ok := w.publish[c]; ok {
+			delete(w.publish, c)
+			evts = append(evts, publishedEvent(c, lookup))
+		}
+		w.lk.Unlock()
+	}
+	w.emit(evts)
+
+	if err := w.checkDeals(ctx, types.EmptyTSK, 0, deals); err != nil && ctx.Err() == nil {
+		log.Infow("polling watched deals", "err", err)
+	}
+}
+
+// checkDeals gets the state of each deal at the tipset, and emits an event
+// for deals that have been activated or slashed
+func (w *Watcher) checkDeals(ctx context.Context, tsk types.TipSetKey, height abi.ChainEpoch, deals []abi.DealID) error {
+	var evts []Event
+	defer func() { w.emit(evts) }()
+
+	for _, id := range deals {
+		md, err := w.api.StateMarketStorageDeal(ctx, id, tsk)
+		if err != nil {
+			// The deal may not exist yet at the tipset (eg because of
+			// a reorg), or the call failed: try again at the next change
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Debugw("getting market deal state", "deal", id, "err", err)
+			continue
+		}
+
+		var evt Event
+		switch {
+		case md.State.SlashEpoch > 0:
+			evt = Event{Type: EventSlashed, Epoch: md.State.SlashEpoch}
+		case md.State.SectorStartEpoch > 0:
+			evt = Event{Type: EventActivated, Epoch: md.State.SectorStartEpoch}
+		default:
+			continue
+		}
+
+		w.lk.Lock()
+		provider, ok := w.deals[id]
+		delete(w.deals, id)
+		w.lk.Unlock()
+		if !ok {
+			continue
+		}
+		evt.DealID = id
+		evt.Provider = provider
+		evt.Height = height
+		evts = append(evts, evt)
+	}
+	return nil
+}
+
+func (w *Watcher) emit(evts []Event) {
+	if len(evts) == 0 {
+		return
+	}
+
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	for _, evt := range evts {
+		log.Infow("deal chain event", "type", evt.Type, "publish-cid", evt.PublishCid, "replaced-by", evt.ReplacedBy, "deal", evt.DealID,
+			"provider", evt.Provider, "height", evt.Height, "epoch", evt.Epoch)
+		for _, ch := range w.subs {
+			select {
+			case ch <- evt:
+			default:
+				log.Warnw("dropping deal chain event for slow subscriber", "type", evt.Type, "deal", evt.DealID)
+			}
+		}
+	}
+}
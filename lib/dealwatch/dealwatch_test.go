@@ -0,0 +1,239 @@
+package dealwatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChain struct {
+	lk sync.Mutex
+	// the messages and receipts executed in the tipset with the block cid
+	msgs     map[cid.Cid][]lapi.Message
+	receipts map[cid.Cid][]*types.MessageReceipt
+	// the messages found by a search (when polling)
+	found map[cid.Cid]*lapi.MsgLookup
+	// the number of message searches
+	searchCalls int
+	deals       map[abi.DealID]*lapi.MarketDeal
+	// the number of calls to get deal state
+	dealCalls int
+	notify    chan []*lapi.HeadChange
+}
+
+func (m *mockChain) ChainNotify(ctx context.Context) (<-chan []*lapi.HeadChange, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if m.notify == nil {
+		return nil, fmt.Errorf("notify unavailable")
+	}
+	return m.notify, nil
+}
+
+func (m *mockChain) ChainGetParentMessages(ctx context.Context, blockCid cid.Cid) ([]lapi.Message, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.msgs[blockCid], nil
+}
+
+func (m *mockChain) ChainGetParentReceipts(ctx context.Context, blockCid cid.Cid) ([]*types.MessageReceipt, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.receipts[blockCid], nil
+}
+
+func (m *mockChain) StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*lapi.MsgLookup, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.searchCalls++
+	return m.found[msg], nil
+}
+
+func (m *mockChain) StateMarketStorageDeal(ctx context.Context, dealID abi.DealID, tsk types.TipSetKey) (*lapi.MarketDeal, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.dealCalls++
+	md, ok := m.deals[dealID]
+	if !ok {
+		return nil, fmt.Errorf("deal %d not found", dealID)
+	}
+	return md, nil
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func testTipSet(t *testing.T, height abi.ChainEpoch) *types.TipSet {
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	c := testCid(t, fmt.Sprintf("block-%d", height))
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte{byte(height)}},
+		Height:                height,
+		ParentWeight:          big.Zero(),
+		ParentStateRoot:       c,
+		ParentMessageReceipts: c,
+		Messages:              c,
+		ParentBaseFee:         big.Zero(),
+		BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+		BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+	}})
+	require.NoError(t, err)
+	return ts
+}
+
+func TestWatcherApplyTipSet(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov1, err := address.NewIDAddress(1)
+	req.NoError(err)
+	prov2, err := address.NewIDAddress(2)
+	req.NoError(err)
+
+	chain := &mockChain{
+		msgs:     make(map[cid.Cid][]lapi.Message),
+		receipts: make(map[cid.Cid][]*types.MessageReceipt),
+		deals: map[abi.DealID]*lapi.MarketDeal{
+			10: {},
+			20: {},
+		},
+	}
+	w := NewWatcher(chain, time.Hour)
+	evts, unsub := w.Subscribe()
+	defer unsub()
+
+	publishCid := testCid(t, "publish")
+	w.WatchPublish(publishCid)
+	w.WatchDeal(10, prov1)
+	w.WatchDeal(20, prov2)
+	req.Equal(3, w.Pending())
+
+	// A tipset that executes the publish message
+	ts := testTipSet(t, 100)
+	chain.msgs[ts.Cids()[0]] = []lapi.Message{
+		{Cid: testCid(t, "other"), Message: &types.Message{To: prov1, Method: builtin.MethodSend}},
+		{Cid: publishCid, Message: &types.Message{Method: builtin.MethodsMarket.PublishStorageDeals}},
+	}
+	chain.receipts[ts.Cids()[0]] = []*types.MessageReceipt{{ExitCode: exitcode.Ok}, {ExitCode: exitcode.Ok}}
+	req.NoError(w.ApplyTipSet(ctx, ts))
+	req.Len(evts, 1)
+	evt := <-evts
+	req.Equal(EventPublished, evt.Type)
+	req.Equal(publishCid, evt.PublishCid)
+	req.Equal(exitcode.Ok, evt.ExitCode)
+	req.EqualValues(100, evt.Height)
+
+	// No provider proved a sector, so deal state isn't checked
+	req.Equal(0, chain.dealCalls)
+
+	// Provider 1 proves the sector with deal 10
+	chain.deals[10] = &lapi.MarketDeal{}
+	chain.deals[10].State.SectorStartEpoch = 101
+	ts = testTipSet(t, 101)
+	chain.msgs[ts.Cids()[0]] = []lapi.Message{
+		{Cid: testCid(t, "prove"), Message: &types.Message{To: prov1, Method: builtin.MethodsMiner.ProveCommitSector}},
+	}
+	chain.receipts[ts.Cids()[0]] = []*types.MessageReceipt{{ExitCode: exitcode.Ok}}
+	req.NoError(w.ApplyTipSet(ctx, ts))
+	req.Equal(1, chain.dealCalls)
+	evt = <-evts
+	req.Equal(EventActivated, evt.Type)
+	req.EqualValues(10, evt.DealID)
+	req.Equal(prov1, evt.Provider)
+	req.EqualValues(101, evt.Epoch)
+	req.Equal(1, w.Pending())
+}
+
+func TestWatcherReplacedPublish(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	chain := &mockChain{
+		msgs:     make(map[cid.Cid][]lapi.Message),
+		receipts: make(map[cid.Cid][]*types.MessageReceipt),
+		found:    make(map[cid.Cid]*lapi.MsgLookup),
+	}
+	w := NewWatcher(chain, time.Hour)
+	evts, unsub := w.Subscribe()
+	defer unsub()
+
+	publishCid := testCid(t, "publish")
+	w.WatchPublish(publishCid)
+
+	// A tipset without publish messages doesn't need a search
+	ts := testTipSet(t, 100)
+	chain.msgs[ts.Cids()[0]] = []lapi.Message{
+		{Cid: testCid(t, "other"), Message: &types.Message{Method: builtin.MethodSend}},
+	}
+	chain.receipts[ts.Cids()[0]] = []*types.MessageReceipt{{ExitCode: exitcode.Ok}}
+	req.NoError(w.ApplyTipSet(ctx, ts))
+	req.Equal(0, chain.searchCalls)
+	req.Len(evts, 0)
+
+	// The provider replaced the publish message, and the replacement is
+	// executed in the next tipset
+	replacementCid := testCid(t, "replacement")
+	ts = testTipSet(t, 101)
+	chain.msgs[ts.Cids()[0]] = []lapi.Message{
+		{Cid: replacementCid, Message: &types.Message{To: builtin.StorageMarketActorAddr, Method: builtin.MethodsMarket.PublishStorageDeals}},
+	}
+	chain.receipts[ts.Cids()[0]] = []*types.MessageReceipt{{ExitCode: exitcode.Ok}}
+	chain.found[publishCid] = &lapi.MsgLookup{Message: replacementCid, Receipt: types.MessageReceipt{ExitCode: exitcode.Ok}, Height: 101}
+	req.NoError(w.ApplyTipSet(ctx, ts))
+	req.Equal(1, chain.searchCalls)
+	req.Len(evts, 1)
+	evt := <-evts
+	req.Equal(EventPublished, evt.Type)
+	req.Equal(publishCid, evt.PublishCid)
+	req.Equal(replacementCid, evt.ReplacedBy)
+	req.EqualValues(101, evt.Height)
+	req.Equal(0, w.Pending())
+}
+
+func TestWatcherFallsBackToPolling(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+
+	// The notify stream is unavailable, so the watcher polls
+	md := &lapi.MarketDeal{}
+	md.State.SlashEpoch = 50
+	chain := &mockChain{deals: map[abi.DealID]*lapi.MarketDeal{10: md}}
+	w := NewWatcher(chain, 10*time.Millisecond)
+	evts, unsub := w.Subscribe()
+	defer unsub()
+	w.WatchDeal(10, prov)
+	go w.Run(ctx)
+
+	select {
+	case evt := <-evts:
+		req.Equal(EventSlashed, evt.Type)
+		req.EqualValues(10, evt.DealID)
+		req.EqualValues(50, evt.Epoch)
+	case <-time.After(5 * time.Second):
+		req.Fail("timed out waiting for slashed event")
+	}
+	req.False(w.NotifyUp())
+}
@@ -0,0 +1,363 @@
+package dedupstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+var ErrImportQuarantined = errors.New("import is quarantined")
+
+var lastCheckKey = datastore.NewKey("/last-check")
+
+// The problems that a CAR file written for an import may have
+const (
+	CarMissing   = "missing"
+	CarTruncated = "truncated"
+	CarCorrupt   = "corrupt"
+)
+
+// CarFile is a CAR file that was written out for an import, eg so that a
+// provider can download it to make a deal
+type CarFile struct {
+	Path      string
+	Size      int64
+	PieceCid  cid.Cid
+	WrittenAt time.Time
+}
+
+// Quarantine records why an import was quarantined. The CAR file of a
+// quarantined import cannot be written until a check finds that it is no
+// longer damaged.
+type Quarantine struct {
+	Reason string
+	At     time.Time
+	// A quick check cannot tell whether corrupt blocks have been repaired,
+	// so only a full check releases an import with corrupt blocks
+	CorruptBlocks int
+}
+
+// Damage is the damage found to an import by a check
+type Damage struct {
+	ImportID uint64
+	// Blocks that are not in the store
+	MissingBlocks []cid.Cid `json:",omitempty"`
+	// Blocks whose data does not match their cid (only found by a full
+	// check)
+	CorruptBlocks []cid.Cid `json:",omitempty"`
+	// CAR files written for the import that are missing, truncated or
+	// corrupt. They can be written again from the import if its blocks are
+	// not damaged.
+	Cars []CarDamage `json:",omitempty"`
+	// The problem with the CAR file of an import from a mount, if the CAR
+	// file is missing or has changed
+	MountedCar string `json:",omitempty"`
+	// Set if the import is from a mount that is unavailable. The import is
+	// not quarantined, as the mount may become available again.
+	MountUnavailable bool `json:",omitempty"`
+	// Whether the import was quarantined because its blocks are damaged
+	Quarantined bool
+	// The pending deals backed by the import that are quarantined, because
+	// the import or the CAR file that the provider downloads is damaged
+	QuarantinedDeals []uuid.UUID `json:",omitempty"`
+}
+
+type CarDamage struct {
+	Path    string
+	Problem string
+}
+
+// CheckReport is the result of a check of the store
+type CheckReport struct {
+	Imports int
+	Blocks  int
+	Cars    int
+	Damaged []Damage
+	// Imports that were released from quarantine because they are no longer
+	// damaged
+	Released []uint64
+	// Pending deals that were released from quarantine because their data
+	// is no longer damaged
+	ReleasedDeals []uuid.UUID
+}
+
+type CheckOptions struct {
+	// Rehash each block and recalculate the commp of each CAR file, instead
+	// of only checking that blocks are present and CAR files have the
+	// expected size
+	Full bool
+	// Called with the damage to each import as it is found
+	OnDamage func(Damage)
+}
+
+// RecordCar records that a CAR file was written for the import, so that
+// checks verify that it is still intact
+func (s *Store) RecordCar(ctx context.Context, id uint64, car CarFile) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	cars := imp.Cars[:0]
+	for _, c := range imp.Cars {
+		if c.Path != car.Path {
+			cars = append(cars, c)
+		}
+	}
+	imp.Cars = append(cars, car)
+	return s.putImport(ctx, imp)
+}
+
+// Check cross-checks each import against the blocks in the store and the CAR
+// files that were written for it. Imports with missing or corrupt blocks are
+// quarantined, along with the pending deals backed by them or by a damaged
+// CAR file. Quarantined imports and deals that are no longer damaged are
+// released.
+func (s *Store) Check(ctx context.Context, opts CheckOptions) (*CheckReport, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imps, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CheckReport{Imports: len(imps)}
+	for i := range imps {
+		imp := &imps[i]
+		if imp.Incomplete {
+			// Boost stopped while the import's blocks were being added or
+			// removed: it can't be used, so there's nothing to check
+			continue
+		}
+		dmg := Damage{ImportID: imp.ID}
+		if imp.Mounted != nil {
+			// The blocks of an import from a mount are in its CAR file on
+			// the mount, rather than in the store
+			report.Cars++
+			problem, err := s.checkMounted(ctx, imp)
+			switch {
+			case errors.Is(err, ErrMountUnavailable):
+				dmg.MountUnavailable = true
+			case err != nil:
+				return nil, err
+			default:
+				dmg.MountedCar = problem
+			}
+		}
+		if imp.Mounted == nil {
+			err := s.forEachCid(ctx, imp.ID, func(c cid.Cid) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				report.Blocks++
+				ok, err := s.checkBlock(ctx, c, opts.Full)
+				if err != nil {
+					if errors.Is(err, datastore.ErrNotFound) {
+						dmg.MissingBlocks = append(dmg.MissingBlocks, c)
+						return nil
+					}
+					return err
+				}
+				if !ok {
+					dmg.CorruptBlocks = append(dmg.CorruptBlocks, c)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, car := range imp.Cars {
+			report.Cars++
+			problem, err := checkCar(ctx, car, opts.Full)
+			if err != nil {
+				return nil, err
+			}
+			if problem != "" {
+				dmg.Cars = append(dmg.Cars, CarDamage{Path: car.Path, Problem: problem})
+			}
+		}
+
+		blocksDamaged := len(dmg.MissingBlocks) > 0 || len(dmg.CorruptBlocks) > 0 || dmg.MountedCar != ""
+		changed := false
+		switch {
+		case blocksDamaged:
+			dmg.Quarantined = true
+			corrupt := len(dmg.CorruptBlocks)
+			at := time.Now()
+			if imp.Quarantine != nil {
+				if !opts.Full {
+					// Keep the corrupt block count of the last full check
+					corrupt = imp.Quarantine.CorruptBlocks
+				}
+				at = imp.Quarantine.At
+			}
+			q := Quarantine{
+				Reason:        fmt.Sprintf("%d missing and %d corrupt blocks", len(dmg.MissingBlocks), corrupt),
+				At:            at,
+				CorruptBlocks: corrupt,
+			}
+			if dmg.MountedCar != "" {
+				q.Reason = fmt.Sprintf("CAR file on mount %s is %s", imp.Mounted.Mount, dmg.MountedCar)
+			}
+			if imp.Quarantine == nil || *imp.Quarantine != q {
+				imp.Quarantine = &q
+				changed = true
+			}
+		case imp.Quarantine != nil && !dmg.MountUnavailable && (opts.Full || imp.Quarantine.CorruptBlocks == 0):
+			imp.Quarantine = nil
+			changed = true
+			report.Released = append(report.Released, imp.ID)
+		}
+
+		// Quarantine the pending deals whose data is damaged, and release
+		// those whose data is intact again. The deals of an import whose
+		// mount is unavailable are left as they are.
+		for j := range imp.Deals {
+			d := &imp.Deals[j]
+			if dmg.MountUnavailable {
+				continue
+			}
+			reason := dealDamage(imp, &dmg, d)
+			switch {
+			case reason != "":
+				dmg.QuarantinedDeals = append(dmg.QuarantinedDeals, d.UUID)
+				if d.Quarantine == nil || d.Quarantine.Reason != reason {
+					at := time.Now()
+					if d.Quarantine != nil {
+						at = d.Quarantine.At
+					}
+					d.Quarantine = &Quarantine{Reason: reason, At: at}
+					changed = true
+				}
+			case d.Quarantine != nil:
+				d.Quarantine = nil
+				changed = true
+				report.ReleasedDeals = append(report.ReleasedDeals, d.UUID)
+			}
+		}
+
+		if changed {
+			if err := s.putImport(ctx, imp); err != nil {
+				return nil, err
+			}
+		}
+
+		if blocksDamaged || dmg.MountUnavailable || len(dmg.Cars) > 0 || len(dmg.QuarantinedDeals) > 0 {
+			report.Damaged = append(report.Damaged, dmg)
+			if opts.OnDamage != nil {
+				opts.OnDamage(dmg)
+			}
+		}
+	}
+
+	if err := s.ds.Put(ctx, lastCheckKey, encodeCount(uint64(time.Now().Unix()))); err != nil {
+		return nil, fmt.Errorf("putting last check time: %w", err)
+	}
+	return report, nil
+}
+
+// LastCheck returns the time of the last check of the store, or the zero
+// time if the store has never been checked
+func (s *Store) LastCheck(ctx context.Context) (time.Time, error) {
+	data, err := s.ds.Get(ctx, lastCheckKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("getting last check time: %w", err)
+	}
+	secs, n := binary.Uvarint(data)
+	if n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid last check time")
+	}
+	return time.Unix(int64(secs), 0), nil
+}
+
+// checkBlock returns datastore.ErrNotFound if the block is not in the store.
+// In a full check it returns false if the block's data does not match its cid.
+func (s *Store) checkBlock(ctx context.Context, c cid.Cid, full bool) (bool, error) {
+	key := dshelp.MultihashToDsKey(c.Hash())
+	if !full {
+		has, err := s.blocks.Has(ctx, key)
+		if err != nil {
+			return false, fmt.Errorf("checking block %s: %w", c, err)
+		}
+		if !has {
+			return false, datastore.ErrNotFound
+		}
+		return true, nil
+	}
+
+	data, err := s.blocks.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return false, err
+		}
+		return false, fmt.Errorf("getting block %s: %w", c, err)
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return false, nil
+	}
+	return sum.Equals(c), nil
+}
+
+// checkCar returns the problem with the CAR file, or "" if it is intact
+func checkCar(ctx context.Context, car CarFile, full bool) (string, error) {
+	st, err := os.Stat(car.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return CarMissing, nil
+		}
+		return "", fmt.Errorf("checking CAR file %s: %w", car.Path, err)
+	}
+	if st.Size() < car.Size {
+		return CarTruncated, nil
+	}
+	if st.Size() != car.Size {
+		return CarCorrupt, nil
+	}
+	if !full || !car.PieceCid.Defined() {
+		return "", nil
+	}
+
+	f, err := os.Open(car.Path)
+	if err != nil {
+		return "", fmt.Errorf("opening CAR file %s: %w", car.Path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	pi, err := commp.Default().Sum(ctx, f)
+	if err != nil {
+		return "", fmt.Errorf("calculating commp of CAR file %s: %w", car.Path, err)
+	}
+	if !pi.PieceCID.Equals(car.PieceCid) {
+		return CarCorrupt, nil
+	}
+	return "", nil
+}
+
+func (s *Store) putImport(ctx context.Context, imp *Import) error {
+	impBytes, err := json.Marshal(imp)
+	if err != nil {
+		return fmt.Errorf("marshalling import: %w", err)
+	}
+	if err := s.imports.Put(ctx, importKey(imp.ID), impBytes); err != nil {
+		return fmt.Errorf("putting import %d: %w", imp.ID, err)
+	}
+	return nil
+}
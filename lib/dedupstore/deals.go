@@ -0,0 +1,129 @@
+package dedupstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+var ErrDealNotFound = errors.New("deal not found")
+
+// Deal is a pending deal whose data is backed by an import: the provider
+// downloads the CAR file written for the import (or, for an import from a
+// mount, the import's CAR file on the mount). The deal is pending until the
+// provider has downloaded the data.
+type Deal struct {
+	UUID       uuid.UUID
+	Provider   string
+	PieceCid   cid.Cid
+	ProposedAt time.Time
+	// Set if a check found that the data backing the deal is damaged
+	Quarantine *Quarantine `json:",omitempty"`
+}
+
+// ImportDeal is a pending deal and the import that backs it
+type ImportDeal struct {
+	ImportID uint64
+	Deal
+}
+
+// AddDeal records a pending deal backed by the import, so that checks
+// quarantine the deal if the data backing it is damaged. It fails if the
+// import is quarantined or incomplete.
+func (s *Store) AddDeal(ctx context.Context, id uint64, d Deal) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkUsable(imp); err != nil {
+		return err
+	}
+	d.Quarantine = nil
+	imp.Deals = append(imp.Deals, d)
+	return s.putImport(ctx, imp)
+}
+
+// RemoveDeal removes a pending deal, once the provider has downloaded the
+// deal's data
+func (s *Store) RemoveDeal(ctx context.Context, dealUUID uuid.UUID) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imps, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range imps {
+		imp := &imps[i]
+		for j, d := range imp.Deals {
+			if d.UUID == dealUUID {
+				imp.Deals = append(imp.Deals[:j], imp.Deals[j+1:]...)
+				return s.putImport(ctx, imp)
+			}
+		}
+	}
+	return fmt.Errorf("deal %s: %w", dealUUID, ErrDealNotFound)
+}
+
+// Deals lists the pending deals backed by imports, ordered by import id
+func (s *Store) Deals(ctx context.Context) ([]ImportDeal, error) {
+	imps, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var deals []ImportDeal
+	for _, imp := range imps {
+		for _, d := range imp.Deals {
+			deals = append(deals, ImportDeal{ImportID: imp.ID, Deal: d})
+		}
+	}
+	return deals, nil
+}
+
+// CarForPiece returns the CAR file with the piece cid that was written for
+// an import, and the import. It returns ErrImportNotFound if there is none.
+func (s *Store) CarForPiece(ctx context.Context, pieceCid cid.Cid) (*Import, *CarFile, error) {
+	imps, err := s.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range imps {
+		for j, c := range imps[i].Cars {
+			if c.PieceCid.Equals(pieceCid) {
+				return &imps[i], &imps[i].Cars[j], nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("piece %s: %w", pieceCid, ErrImportNotFound)
+}
+
+// dealDamage returns the reason the data backing the deal is damaged, or ""
+// if it is intact. It is called after the import's own quarantine has been
+// updated by the check.
+func dealDamage(imp *Import, dmg *Damage, d *Deal) string {
+	if imp.Quarantine != nil {
+		return fmt.Sprintf("import %d is quarantined: %s", imp.ID, imp.Quarantine.Reason)
+	}
+	if imp.Mounted != nil {
+		// The deal's data is served from the mount
+		return ""
+	}
+	for _, car := range imp.Cars {
+		if !car.PieceCid.Equals(d.PieceCid) {
+			continue
+		}
+		for _, cd := range dmg.Cars {
+			if cd.Path == car.Path {
+				return fmt.Sprintf("CAR file %s is %s", cd.Path, cd.Problem)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,458 @@
+package dedupstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	format "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+)
+
+var (
+	ErrMountNotFound    = errors.New("mount not found")
+	ErrMountUnavailable = errors.New("mount is unavailable")
+	ErrNotMounted       = errors.New("import is not from a mount")
+)
+
+var mountsPrefix = datastore.NewKey("/mounts")
+
+// How long a health check waits for a mount to respond. Network mounts (eg
+// NFS) can hang rather than fail when the server is unreachable.
+var mountCheckTimeout = 10 * time.Second
+
+// Mount is a directory of CAR files on read-only storage, eg an NFS export
+// or an object storage FUSE mount. CAR files on a mount are imported by
+// reference: their blocks are not copied into the store, and their data is
+// read directly from the mount when it is needed.
+type Mount struct {
+	Name    string
+	Path    string
+	AddedAt time.Time
+}
+
+// MountedCar is the CAR file on a mount that an import was made from
+type MountedCar struct {
+	// The name of the mount
+	Mount string
+	// The path of the CAR file relative to the mount
+	Path string
+	// The size of the CAR file when it was imported
+	Size int64
+	// The section of the CAR file that holds the CARv1 data (the whole file
+	// for a CARv1 file)
+	DataOffset int64
+	DataSize   int64
+}
+
+// AddMount registers a directory of CAR files on read-only storage under
+// the name
+func (s *Store) AddMount(ctx context.Context, name string, path string) (*Mount, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid mount name '%s'", name)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mount{Name: name, Path: abs, AddedAt: time.Now()}
+	if err := CheckMount(ctx, m); err != nil {
+		return nil, err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, err := s.GetMount(ctx, name); err == nil {
+		return nil, fmt.Errorf("a mount named %s already exists", name)
+	} else if !errors.Is(err, ErrMountNotFound) {
+		return nil, err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling mount: %w", err)
+	}
+	if err := s.ds.Put(ctx, mountsPrefix.ChildString(name), data); err != nil {
+		return nil, fmt.Errorf("putting mount %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// GetMount returns the mount with the given name
+func (s *Store) GetMount(ctx context.Context, name string) (*Mount, error) {
+	data, err := s.ds.Get(ctx, mountsPrefix.ChildString(name))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, fmt.Errorf("mount %s: %w", name, ErrMountNotFound)
+		}
+		return nil, fmt.Errorf("getting mount %s: %w", name, err)
+	}
+	var m Mount
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unmarshalling mount %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// Mounts lists the registered mounts
+func (s *Store) Mounts(ctx context.Context) ([]Mount, error) {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: mountsPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("querying mounts: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var mounts []Mount
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("reading mounts: %w", r.Error)
+		}
+		var m Mount
+		if err := json.Unmarshal(r.Value, &m); err != nil {
+			return nil, fmt.Errorf("unmarshalling mount %s: %w", r.Key, err)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// RemoveMount unregisters the mount. It fails if there are imports from the
+// mount.
+func (s *Store) RemoveMount(ctx context.Context, name string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, err := s.GetMount(ctx, name); err != nil {
+		return err
+	}
+	imps, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, imp := range imps {
+		if imp.Mounted != nil && imp.Mounted.Mount == name {
+			return fmt.Errorf("mount %s is used by import %d", name, imp.ID)
+		}
+	}
+	if err := s.ds.Delete(ctx, mountsPrefix.ChildString(name)); err != nil {
+		return fmt.Errorf("deleting mount %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddFromMount imports the CAR file at the path relative to the mount,
+// without copying its blocks into the store
+func (s *Store) AddFromMount(ctx context.Context, mountName string, path string) (*Import, error) {
+	m, err := s.GetMount(ctx, mountName)
+	if err != nil {
+		return nil, err
+	}
+	carPath, err := m.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := statWithTimeout(ctx, carPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := carv2.OpenReader(carPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening CAR file %s: %w", carPath, err)
+	}
+	defer rd.Close() //nolint:errcheck
+
+	roots, err := rd.Roots()
+	if err != nil {
+		return nil, fmt.Errorf("getting roots of CAR file %s: %w", carPath, err)
+	}
+	mounted := &MountedCar{Mount: m.Name, Size: st.Size(), DataSize: st.Size()}
+	mounted.Path, err = filepath.Rel(m.Path, carPath)
+	if err != nil {
+		return nil, err
+	}
+	if rd.Version == 2 {
+		mounted.DataOffset = int64(rd.Header.DataOffset)
+		mounted.DataSize = int64(rd.Header.DataSize)
+	}
+	dr, err := rd.DataReader()
+	if err != nil {
+		return nil, fmt.Errorf("getting data reader for CAR file %s: %w", carPath, err)
+	}
+	br, err := carv2.NewBlockReader(dr)
+	if err != nil {
+		return nil, fmt.Errorf("reading blocks from CAR file %s: %w", carPath, err)
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imp := &Import{
+		Source:    carPath,
+		CreatedAt: time.Now(),
+		Roots:     roots,
+		Mounted:   mounted,
+	}
+	if err := s.addImport(ctx, imp, br); err != nil {
+		return nil, fmt.Errorf("importing CAR file %s: %w", carPath, err)
+	}
+	return imp, nil
+}
+
+// MountedCarPath checks that the mount of the import is available and that
+// the import's CAR file on the mount hasn't changed, and returns the path of
+// the CAR file
+func (s *Store) MountedCarPath(ctx context.Context, id uint64) (string, *MountedCar, error) {
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if imp.Mounted == nil {
+		return "", nil, fmt.Errorf("import %d: %w", id, ErrNotMounted)
+	}
+	if err := checkUsable(imp); err != nil {
+		return "", nil, err
+	}
+	m, err := s.GetMount(ctx, imp.Mounted.Mount)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := CheckMount(ctx, m); err != nil {
+		return "", nil, err
+	}
+	path, err := m.resolve(imp.Mounted.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := CheckMountedCar(ctx, path, imp.Mounted.Size); err != nil {
+		return "", nil, fmt.Errorf("import %d: %w", id, err)
+	}
+	return path, imp.Mounted, nil
+}
+
+// CheckMount checks that the mount's directory can be read, failing with
+// ErrMountUnavailable if it doesn't respond in time
+func CheckMount(ctx context.Context, m *Mount) error {
+	st, err := statWithTimeout(ctx, m.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("mount %s: %w: %s", m.Name, ErrMountUnavailable, err)
+		}
+		return fmt.Errorf("mount %s: %w", m.Name, err)
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("mount %s: %s is not a directory", m.Name, m.Path)
+	}
+	return nil
+}
+
+// CheckMountedCar checks that the CAR file on a mount is available and has
+// the expected size
+func CheckMountedCar(ctx context.Context, path string, size int64) error {
+	st, err := statWithTimeout(ctx, path)
+	if err != nil {
+		return err
+	}
+	if st.Size() != size {
+		return fmt.Errorf("CAR file %s has changed since it was imported: size is %d, expected %d", path, st.Size(), size)
+	}
+	return nil
+}
+
+// checkMounted returns the problem with the CAR file of an import from a
+// mount, or "" if it is intact. It returns ErrMountUnavailable if the mount
+// can't be read.
+func (s *Store) checkMounted(ctx context.Context, imp *Import) (string, error) {
+	m, err := s.GetMount(ctx, imp.Mounted.Mount)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckMount(ctx, m); err != nil {
+		return "", err
+	}
+	path, err := m.resolve(imp.Mounted.Path)
+	if err != nil {
+		return "", err
+	}
+	st, err := statWithTimeout(ctx, path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return CarMissing, nil
+		}
+		return "", err
+	}
+	if st.Size() < imp.Mounted.Size {
+		return CarTruncated, nil
+	}
+	if st.Size() != imp.Mounted.Size {
+		return CarCorrupt, nil
+	}
+	return "", nil
+}
+
+// writeMountedCar writes the CARv1 data of the import's CAR file, read
+// directly from the mount
+func (s *Store) writeMountedCar(ctx context.Context, id uint64, w io.Writer) error {
+	path, mounted, err := s.MountedCarPath(ctx, id)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening CAR file %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	if _, err := io.Copy(w, io.NewSectionReader(f, mounted.DataOffset, mounted.DataSize)); err != nil {
+		return fmt.Errorf("reading CAR file %s: %w", path, err)
+	}
+	return nil
+}
+
+// resolve returns the path of the file relative to the mount, which must be
+// inside the mount
+func (m *Mount) resolve(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.Path, path)
+	}
+	path = filepath.Clean(path)
+	if rel, err := filepath.Rel(m.Path, path); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is not inside mount %s (%s)", path, m.Name, m.Path)
+	}
+	return path, nil
+}
+
+// statWithTimeout stats the path, failing with ErrMountUnavailable if the
+// storage doesn't respond within the mount check timeout
+func statWithTimeout(ctx context.Context, path string) (os.FileInfo, error) {
+	type result struct {
+		st  os.FileInfo
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		st, err := os.Stat(path)
+		done <- result{st, err}
+	}()
+
+	timer := time.NewTimer(mountCheckTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if errors.Is(res.err, os.ErrNotExist) {
+			return nil, res.err
+		}
+		if res.err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMountUnavailable, res.err)
+		}
+		return res.st, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: no response for %s from %s", ErrMountUnavailable, mountCheckTimeout, path)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ReadOnlyBlockstore gives read access to the blocks of an import
+type ReadOnlyBlockstore interface {
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	Get(ctx context.Context, c cid.Cid) (blocks.Block, error)
+	GetSize(ctx context.Context, c cid.Cid) (int, error)
+	Close() error
+}
+
+// Blockstore opens a read-only blockstore of the import's blocks. The blocks
+// of an import from a mount are read directly from its CAR file on the
+// mount. The blockstore must be closed when it is no longer needed.
+func (s *Store) Blockstore(ctx context.Context, id uint64) (ReadOnlyBlockstore, error) {
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUsable(imp); err != nil {
+		return nil, err
+	}
+	if imp.Mounted == nil {
+		return newImportBlockstore(ctx, s, imp)
+	}
+
+	path, _, err := s.MountedCarPath(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := blockstore.OpenReadOnly(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening blockstore for CAR file %s: %w", path, err)
+	}
+	return bs, nil
+}
+
+// importBlockstore gives read access to the blocks of an import that are in
+// the store
+type importBlockstore struct {
+	s    *Store
+	cids map[cid.Cid]struct{}
+}
+
+func newImportBlockstore(ctx context.Context, s *Store, imp *Import) (*importBlockstore, error) {
+	cids := make(map[cid.Cid]struct{}, imp.Blocks)
+	err := s.forEachCid(ctx, imp.ID, func(c cid.Cid) error {
+		cids[c] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &importBlockstore{s: s, cids: cids}, nil
+}
+
+func (b *importBlockstore) Has(_ context.Context, c cid.Cid) (bool, error) {
+	_, ok := b.cids[c]
+	return ok, nil
+}
+
+func (b *importBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	data, err := b.getData(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (b *importBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	data, err := b.getData(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (b *importBlockstore) getData(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if _, ok := b.cids[c]; !ok {
+		return nil, format.ErrNotFound{Cid: c}
+	}
+	data, err := b.s.blocks.Get(ctx, dshelp.MultihashToDsKey(c.Hash()))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, format.ErrNotFound{Cid: c}
+		}
+		return nil, fmt.Errorf("getting block %s: %w", c, err)
+	}
+	return data, nil
+}
+
+func (b *importBlockstore) Close() error {
+	return nil
+}
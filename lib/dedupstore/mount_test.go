@@ -0,0 +1,102 @@
+package dedupstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMount(t *testing.T) {
+	ctx := context.Background()
+	mountDir := t.TempDir()
+	blks := testutil.GenerateBlocksOfSize(3, 1024)
+	carPath := writeCar(t, filepath.Join(mountDir, "data.car"), blks...)
+
+	s := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+
+	_, err := s.AddMount(ctx, "nfs", filepath.Join(mountDir, "missing"))
+	require.ErrorIs(t, err, ErrMountUnavailable)
+	_, err = s.AddMount(ctx, "nfs", mountDir)
+	require.NoError(t, err)
+	_, err = s.AddMount(ctx, "nfs", mountDir)
+	require.Error(t, err)
+
+	// Files outside the mount can't be imported from it
+	_, err = s.AddFromMount(ctx, "nfs", "../other.car")
+	require.Error(t, err)
+
+	imp, err := s.AddFromMount(ctx, "nfs", "data.car")
+	require.NoError(t, err)
+	require.Equal(t, "data.car", imp.Mounted.Path)
+	require.Equal(t, 3, imp.Blocks)
+
+	// The blocks are not copied into the store
+	u, err := s.Usage(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, u.Blocks)
+	require.Equal(t, imp.Size, u.ImportsSize)
+
+	// The CARv1 data is read directly from the mount
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteCar(ctx, imp.ID, &buf))
+	require.EqualValues(t, imp.Mounted.DataSize, buf.Len())
+	br, err := carv2.NewBlockReader(&buf)
+	require.NoError(t, err)
+	for _, expected := range blks {
+		blk, err := br.Next()
+		require.NoError(t, err)
+		require.Equal(t, expected.Cid(), blk.Cid())
+	}
+
+	bs, err := s.Blockstore(ctx, imp.ID)
+	require.NoError(t, err)
+	blk, err := bs.Get(ctx, blks[1].Cid())
+	require.NoError(t, err)
+	require.Equal(t, blks[1].RawData(), blk.RawData())
+	require.NoError(t, bs.Close())
+
+	// A mount can't be removed while there are imports from it
+	require.Error(t, s.RemoveMount(ctx, "nfs"))
+
+	// If the mount is unavailable the import is not quarantined
+	require.NoError(t, os.Rename(mountDir, mountDir+".offline"))
+	_, err = s.Blockstore(ctx, imp.ID)
+	require.ErrorIs(t, err, ErrMountUnavailable)
+	report, err := s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Damaged, 1)
+	require.True(t, report.Damaged[0].MountUnavailable)
+	require.False(t, report.Damaged[0].Quarantined)
+	require.NoError(t, os.Rename(mountDir+".offline", mountDir))
+
+	// If the CAR file on the mount changes the import is quarantined
+	require.NoError(t, os.Truncate(carPath, imp.Mounted.Size-10))
+	report, err = s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Damaged, 1)
+	require.Equal(t, CarTruncated, report.Damaged[0].MountedCar)
+	require.True(t, report.Damaged[0].Quarantined)
+	require.ErrorIs(t, s.WriteCar(ctx, imp.ID, &bytes.Buffer{}), ErrImportQuarantined)
+
+	// Once the CAR file is restored the import is released
+	require.NoError(t, os.Remove(carPath))
+	writeCar(t, carPath, blks...)
+	report, err = s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, report.Damaged)
+	require.Equal(t, []uint64{imp.ID}, report.Released)
+
+	require.NoError(t, s.Remove(ctx, imp.ID))
+	require.NoError(t, s.RemoveMount(ctx, "nfs"))
+	mounts, err := s.Mounts(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mounts)
+}
@@ -0,0 +1,116 @@
+package dedupstore
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// BlockRange is a run of the blocks of an import, in the order in which they
+// appear in the import
+type BlockRange struct {
+	ImportID uint64
+	First    int
+	Count    int
+}
+
+// BlockSizes returns the size that each of the import's blocks takes up in
+// a CAR file (the block with its cid and length prefix), in order
+func (s *Store) BlockSizes(ctx context.Context, id uint64) ([]uint64, error) {
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := s.Blockstore(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer bs.Close() //nolint:errcheck
+
+	var prefix [binary.MaxVarintLen64]byte
+	sizes := make([]uint64, 0, imp.Blocks)
+	err = s.forEachCid(ctx, id, func(c cid.Cid) error {
+		n, err := bs.GetSize(ctx, c)
+		if err != nil {
+			return fmt.Errorf("getting size of block %s: %w", c, err)
+		}
+		size := uint64(len(c.Bytes()) + n)
+		sizes = append(sizes, uint64(binary.PutUvarint(prefix[:], size))+size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+// WriteCarParts writes the blocks in each of the ranges of blocks to w as
+// a CARv1 with the given roots. It fails if an import in the ranges is
+// quarantined, or is from a mount that is unavailable.
+func (s *Store) WriteCarParts(ctx context.Context, roots []cid.Cid, ranges []BlockRange, w io.Writer) error {
+	if err := car.WriteHeader(&car.CarHeader{Roots: roots, Version: 1}, w); err != nil {
+		return fmt.Errorf("writing CAR header: %w", err)
+	}
+	for _, r := range ranges {
+		if err := s.writeBlockRange(ctx, r, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) writeBlockRange(ctx context.Context, r BlockRange, w io.Writer) error {
+	imp, err := s.Get(ctx, r.ImportID)
+	if err != nil {
+		return err
+	}
+	if r.First < 0 || r.Count < 0 || r.First+r.Count > imp.Blocks {
+		return fmt.Errorf("import %d has %d blocks: range of %d blocks from block %d is out of bounds",
+			r.ImportID, imp.Blocks, r.Count, r.First)
+	}
+	bs, err := s.Blockstore(ctx, r.ImportID)
+	if err != nil {
+		return err
+	}
+	defer bs.Close() //nolint:errcheck
+
+	cids, err := s.CidRange(ctx, r.ImportID, r.First, r.Count)
+	if err != nil {
+		return err
+	}
+	for _, c := range cids {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("getting block %s of import %d: %w", c, r.ImportID, err)
+		}
+		if err := carutil.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+			return fmt.Errorf("writing block %s: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// CidRange returns count of the import's cids, starting from the cid at
+// index first, reading only the records of the import's cid list that hold
+// them
+func (s *Store) CidRange(ctx context.Context, id uint64, first int, count int) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	skip := first % addBatchSize
+	for index := first / addBatchSize; len(cids) < count; index++ {
+		rec, err := s.cidsAt(ctx, cidsKey(id, index))
+		if err != nil {
+			return nil, err
+		}
+		if skip >= len(rec) {
+			return nil, fmt.Errorf("import %d has fewer than %d blocks", id, first+count)
+		}
+		cids = append(cids, rec[skip:]...)
+		skip = 0
+	}
+	return cids[:count], nil
+}
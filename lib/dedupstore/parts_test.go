@@ -0,0 +1,57 @@
+package dedupstore
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCarParts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	blks := testutil.GenerateBlocksOfSize(5, 1024)
+	car1 := writeCar(t, filepath.Join(dir, "1.car"), blks[0], blks[1], blks[2])
+	car2 := writeCar(t, filepath.Join(dir, "2.car"), blks[3], blks[4])
+
+	s := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+	imp1, err := s.Add(ctx, car1)
+	require.NoError(t, err)
+	imp2, err := s.Add(ctx, car2)
+	require.NoError(t, err)
+
+	// The block sizes add up to the size of the CAR file after its header
+	sizes, err := s.BlockSizes(ctx, imp1.ID)
+	require.NoError(t, err)
+	require.Len(t, sizes, 3)
+	var header, full bytes.Buffer
+	require.NoError(t, s.WriteCarParts(ctx, imp1.Roots, nil, &header))
+	require.NoError(t, s.WriteCar(ctx, imp1.ID, &full))
+	require.EqualValues(t, full.Len()-header.Len(), sizes[0]+sizes[1]+sizes[2])
+
+	// Write the last two blocks of the first import and the whole of the
+	// second import
+	var buf bytes.Buffer
+	roots := []cid.Cid{blks[1].Cid(), imp2.Roots[0]}
+	ranges := []BlockRange{{ImportID: imp1.ID, First: 1, Count: 2}, {ImportID: imp2.ID, Count: 2}}
+	require.NoError(t, s.WriteCarParts(ctx, roots, ranges, &buf))
+	br, err := carv2.NewBlockReader(&buf)
+	require.NoError(t, err)
+	require.Equal(t, roots, br.Roots)
+	for _, expected := range blks[1:] {
+		blk, err := br.Next()
+		require.NoError(t, err)
+		require.Equal(t, expected.Cid(), blk.Cid())
+	}
+
+	err = s.WriteCarParts(ctx, roots, []BlockRange{{ImportID: imp2.ID, First: 1, Count: 2}}, &bytes.Buffer{})
+	require.ErrorContains(t, err, "out of bounds")
+}
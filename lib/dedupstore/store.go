@@ -0,0 +1,578 @@
+// Package dedupstore is a content-addressed blockstore that is shared by
+// multiple imports. Blocks that appear in more than one import (eg in
+// overlapping versions of a dataset) are stored only once, and are reference
+// counted so that they are deleted when the last import that references them
+// is removed. The CAR file for an import is materialized on demand.
+package dedupstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+var (
+	ErrImportNotFound   = errors.New("import not found")
+	ErrImportIncomplete = errors.New("import is incomplete")
+)
+
+var (
+	blocksPrefix  = datastore.NewKey("/blocks")
+	refsPrefix    = datastore.NewKey("/refs")
+	importsPrefix = datastore.NewKey("/imports")
+	cidsPrefix    = datastore.NewKey("/import-cids")
+	nextIDKey     = datastore.NewKey("/next-id")
+)
+
+// addBatchSize is the number of blocks that are written in each batch when
+// an import is added or removed, and the number of cids in each record of an
+// import's cid list
+var addBatchSize = 4096
+
+// Import is the data imported from a single CAR file
+type Import struct {
+	ID        uint64
+	Source    string
+	CreatedAt time.Time
+	Roots     []cid.Cid
+	// The number of blocks in the import. The cids of the blocks are kept
+	// out of the import record, as there may be millions of them (see
+	// Store.Cids).
+	Blocks int
+	// The total size of the blocks in the import
+	Size uint64
+	// Set while the import's blocks are being added or removed. If boost
+	// stops part way, the import is left incomplete: it can't be written
+	// out, and can only be removed.
+	Incomplete bool `json:",omitempty"`
+	// The CAR files that were written for the import
+	Cars []CarFile `json:",omitempty"`
+	// Set if a check found that the import's blocks are damaged
+	Quarantine *Quarantine `json:",omitempty"`
+	// The pending deals whose data is backed by the import
+	Deals []Deal `json:",omitempty"`
+	// Set if the import's blocks are read from a CAR file on a mount,
+	// instead of being stored in the store
+	Mounted *MountedCar `json:",omitempty"`
+}
+
+// Usage is the amount of space used by the store
+type Usage struct {
+	// The number of unique blocks in the store
+	Blocks uint64
+	// The total size of the unique blocks in the store
+	Size uint64
+	// The sum of the size of each import
+	ImportsSize uint64
+}
+
+type Store struct {
+	blocks  datastore.Batching
+	refs    datastore.Batching
+	imports datastore.Batching
+	ds      datastore.Batching
+
+	// Serializes reference count changes
+	lk sync.Mutex
+}
+
+func New(ds datastore.Batching) *Store {
+	return &Store{
+		blocks:  namespace.Wrap(ds, blocksPrefix),
+		refs:    namespace.Wrap(ds, refsPrefix),
+		imports: namespace.Wrap(ds, importsPrefix),
+		ds:      ds,
+	}
+}
+
+// Add imports the blocks in the CAR file at carPath into the store
+func (s *Store) Add(ctx context.Context, carPath string) (*Import, error) {
+	rd, err := carv2.OpenReader(carPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening CAR file %s: %w", carPath, err)
+	}
+	defer rd.Close() //nolint:errcheck
+
+	roots, err := rd.Roots()
+	if err != nil {
+		return nil, fmt.Errorf("getting roots of CAR file %s: %w", carPath, err)
+	}
+	dr, err := rd.DataReader()
+	if err != nil {
+		return nil, fmt.Errorf("getting data reader for CAR file %s: %w", carPath, err)
+	}
+	br, err := carv2.NewBlockReader(dr)
+	if err != nil {
+		return nil, fmt.Errorf("reading blocks from CAR file %s: %w", carPath, err)
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imp := &Import{
+		Source:    carPath,
+		CreatedAt: time.Now(),
+		Roots:     roots,
+	}
+	if err := s.addImport(ctx, imp, br); err != nil {
+		return nil, fmt.Errorf("importing CAR file %s: %w", carPath, err)
+	}
+	return imp, nil
+}
+
+// addImport assigns the import an id and adds the blocks read from br to
+// it. The import is recorded as incomplete before its blocks are added, so
+// that if adding them fails part way the blocks that were added can be
+// removed with the import. It must be called while holding the lock.
+func (s *Store) addImport(ctx context.Context, imp *Import, br *carv2.BlockReader) error {
+	id, err := s.nextID(ctx)
+	if err != nil {
+		return err
+	}
+	imp.ID = id
+	imp.Incomplete = true
+	if err := s.putImport(ctx, imp); err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, nextIDKey, encodeCount(id+1)); err != nil {
+		return fmt.Errorf("putting next import id: %w", err)
+	}
+
+	if err := s.addBlocks(ctx, imp, br); err != nil {
+		if rerr := s.remove(ctx, imp); rerr != nil {
+			return fmt.Errorf("%w (removing the incomplete import %d failed: %s)", err, id, rerr)
+		}
+		return err
+	}
+
+	imp.Incomplete = false
+	return s.putImport(ctx, imp)
+}
+
+// addBlocks reads the blocks of the import, and in batches of addBatchSize
+// blocks writes the blocks that are not already in the store, increments
+// their reference counts and appends their cids to the import's cid list.
+// The blocks of an import from a mount stay on the mount: only their cids
+// are recorded.
+func (s *Store) addBlocks(ctx context.Context, imp *Import, br *carv2.BlockReader) error {
+	// Each block is only counted once per import
+	seen := make(map[datastore.Key]struct{})
+	var chunk []cid.Cid
+	// The reference counts that have been changed in the current batch
+	refs := make(map[datastore.Key]uint64)
+
+	batch, err := s.ds.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("creating batch: %w", err)
+	}
+	commit := func() error {
+		for key, count := range refs {
+			if err := batch.Put(ctx, refsPrefix.Child(key), encodeCount(count)); err != nil {
+				return fmt.Errorf("putting ref count: %w", err)
+			}
+		}
+		index := (imp.Blocks - len(chunk)) / addBatchSize
+		if err := batch.Put(ctx, cidsKey(imp.ID, index), encodeCids(chunk)); err != nil {
+			return fmt.Errorf("putting cids of import %d: %w", imp.ID, err)
+		}
+		if err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("committing blocks of import %d: %w", imp.ID, err)
+		}
+		chunk = chunk[:0]
+		refs = make(map[datastore.Key]uint64)
+		batch, err = s.ds.Batch(ctx)
+		if err != nil {
+			return fmt.Errorf("creating batch: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading block: %w", err)
+		}
+
+		key := dshelp.MultihashToDsKey(blk.Cid().Hash())
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		if imp.Mounted == nil {
+			count, err := s.refCount(ctx, key)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				err = batch.Put(ctx, blocksPrefix.Child(key), blk.RawData())
+				if err != nil {
+					return fmt.Errorf("putting block %s: %w", blk.Cid(), err)
+				}
+			}
+			refs[key] = count + 1
+		}
+		chunk = append(chunk, blk.Cid())
+		imp.Blocks++
+		imp.Size += uint64(len(blk.RawData()))
+
+		if len(chunk) == addBatchSize {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(chunk) > 0 {
+		return commit()
+	}
+	return nil
+}
+
+// Get the import with the given id
+func (s *Store) Get(ctx context.Context, id uint64) (*Import, error) {
+	data, err := s.imports.Get(ctx, importKey(id))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, fmt.Errorf("import %d: %w", id, ErrImportNotFound)
+		}
+		return nil, fmt.Errorf("getting import %d: %w", id, err)
+	}
+
+	var imp Import
+	if err := json.Unmarshal(data, &imp); err != nil {
+		return nil, fmt.Errorf("unmarshalling import %d: %w", id, err)
+	}
+	return &imp, nil
+}
+
+// List all imports, ordered by id
+func (s *Store) List(ctx context.Context) ([]Import, error) {
+	res, err := s.imports.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, fmt.Errorf("querying imports: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var imps []Import
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("reading imports: %w", r.Error)
+		}
+		var imp Import
+		if err := json.Unmarshal(r.Value, &imp); err != nil {
+			return nil, fmt.Errorf("unmarshalling import %s: %w", r.Key, err)
+		}
+		imps = append(imps, imp)
+	}
+
+	sort.Slice(imps, func(i, j int) bool {
+		return imps[i].ID < imps[j].ID
+	})
+	return imps, nil
+}
+
+// Remove the import, and delete any blocks that are no longer referenced by
+// another import
+func (s *Store) Remove(ctx context.Context, id uint64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, imp)
+}
+
+// remove removes the import and its cid list, one record of the cid list at
+// a time, decrementing the reference counts of the blocks in each record.
+// The import is marked incomplete first, so that if the removal stops part
+// way the import is not used again, and the removal can be repeated. It
+// must be called while holding the lock.
+func (s *Store) remove(ctx context.Context, imp *Import) error {
+	if !imp.Incomplete {
+		imp.Incomplete = true
+		if err := s.putImport(ctx, imp); err != nil {
+			return err
+		}
+	}
+
+	keys, err := s.cidsKeys(ctx, imp.ID)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		cids, err := s.cidsAt(ctx, k)
+		if err != nil {
+			return err
+		}
+		batch, err := s.ds.Batch(ctx)
+		if err != nil {
+			return fmt.Errorf("creating batch: %w", err)
+		}
+		for _, c := range cids {
+			if imp.Mounted != nil {
+				// The blocks of an import from a mount are not in the store
+				break
+			}
+			key := dshelp.MultihashToDsKey(c.Hash())
+			count, err := s.refCount(ctx, key)
+			if err != nil {
+				return err
+			}
+
+			if count <= 1 {
+				if err := batch.Delete(ctx, blocksPrefix.Child(key)); err != nil {
+					return fmt.Errorf("deleting block %s: %w", c, err)
+				}
+				if err := batch.Delete(ctx, refsPrefix.Child(key)); err != nil {
+					return fmt.Errorf("deleting ref count for %s: %w", c, err)
+				}
+				continue
+			}
+			if err := batch.Put(ctx, refsPrefix.Child(key), encodeCount(count-1)); err != nil {
+				return fmt.Errorf("putting ref count for %s: %w", c, err)
+			}
+		}
+		if err := batch.Delete(ctx, k); err != nil {
+			return fmt.Errorf("deleting cids of import %d: %w", imp.ID, err)
+		}
+		if err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("committing removal of import %d: %w", imp.ID, err)
+		}
+	}
+
+	if err := s.imports.Delete(ctx, importKey(imp.ID)); err != nil {
+		return fmt.Errorf("deleting import %d: %w", imp.ID, err)
+	}
+	return nil
+}
+
+// Cids returns the cids of the blocks in the import, in the order in which
+// they appeared in the CAR file
+func (s *Store) Cids(ctx context.Context, id uint64) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	err := s.forEachCid(ctx, id, func(c cid.Cid) error {
+		cids = append(cids, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cids, nil
+}
+
+// forEachCid calls fn with the cid of each block in the import, in order,
+// reading one record of the import's cid list at a time
+func (s *Store) forEachCid(ctx context.Context, id uint64, fn func(cid.Cid) error) error {
+	keys, err := s.cidsKeys(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		cids, err := s.cidsAt(ctx, k)
+		if err != nil {
+			return err
+		}
+		for _, c := range cids {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cidsKeys returns the keys of the records of the import's cid list, in
+// order
+func (s *Store) cidsKeys(ctx context.Context, id uint64) ([]datastore.Key, error) {
+	prefix := cidsPrefix.Child(importKey(id))
+	res, err := s.ds.Query(ctx, query.Query{
+		Prefix:   prefix.String(),
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying cids of import %d: %w", id, err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var keys []datastore.Key
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("reading cids of import %d: %w", id, r.Error)
+		}
+		keys = append(keys, datastore.NewKey(r.Key))
+	}
+	return keys, nil
+}
+
+func (s *Store) cidsAt(ctx context.Context, key datastore.Key) ([]cid.Cid, error) {
+	data, err := s.ds.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("getting cids %s: %w", key, err)
+	}
+	cids, err := decodeCids(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding cids %s: %w", key, err)
+	}
+	return cids, nil
+}
+
+// WriteCar writes the blocks of the import to w as a CARv1. It fails if the
+// import is quarantined, or if the import is from a mount that is
+// unavailable.
+func (s *Store) WriteCar(ctx context.Context, id uint64, w io.Writer) error {
+	imp, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkUsable(imp); err != nil {
+		return err
+	}
+	if imp.Mounted != nil {
+		return s.writeMountedCar(ctx, id, w)
+	}
+
+	if err := car.WriteHeader(&car.CarHeader{Roots: imp.Roots, Version: 1}, w); err != nil {
+		return fmt.Errorf("writing CAR header: %w", err)
+	}
+	return s.forEachCid(ctx, id, func(c cid.Cid) error {
+		data, err := s.blocks.Get(ctx, dshelp.MultihashToDsKey(c.Hash()))
+		if err != nil {
+			return fmt.Errorf("getting block %s: %w", c, err)
+		}
+		if err := carutil.LdWrite(w, c.Bytes(), data); err != nil {
+			return fmt.Errorf("writing block %s: %w", c, err)
+		}
+		return nil
+	})
+}
+
+// checkUsable returns an error if the import's blocks can't be read because
+// it is incomplete or quarantined
+func checkUsable(imp *Import) error {
+	if imp.Incomplete {
+		return fmt.Errorf("import %d: %w", imp.ID, ErrImportIncomplete)
+	}
+	if imp.Quarantine != nil {
+		return fmt.Errorf("import %d: %w (%s)", imp.ID, ErrImportQuarantined, imp.Quarantine.Reason)
+	}
+	return nil
+}
+
+// Usage returns the amount of space used by the store
+func (s *Store) Usage(ctx context.Context) (*Usage, error) {
+	imps, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var u Usage
+	for _, imp := range imps {
+		u.ImportsSize += imp.Size
+	}
+
+	res, err := s.blocks.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, fmt.Errorf("querying blocks: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("reading blocks: %w", r.Error)
+		}
+		u.Blocks++
+		u.Size += uint64(len(r.Value))
+	}
+	return &u, nil
+}
+
+func (s *Store) refCount(ctx context.Context, key datastore.Key) (uint64, error) {
+	data, err := s.refs.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("getting ref count: %w", err)
+	}
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid ref count for %s", key)
+	}
+	return count, nil
+}
+
+func (s *Store) nextID(ctx context.Context) (uint64, error) {
+	data, err := s.ds.Get(ctx, nextIDKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return 1, nil
+		}
+		return 0, fmt.Errorf("getting next import id: %w", err)
+	}
+	id, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid next import id")
+	}
+	return id, nil
+}
+
+func importKey(id uint64) datastore.Key {
+	return datastore.NewKey(strconv.FormatUint(id, 10))
+}
+
+// cidsKey is the key of a record of the import's cid list. The index is
+// zero-padded so that the records are listed in order.
+func cidsKey(id uint64, index int) datastore.Key {
+	return cidsPrefix.Child(importKey(id)).ChildString(fmt.Sprintf("%010d", index))
+}
+
+func encodeCids(cids []cid.Cid) []byte {
+	var buf []byte
+	for _, c := range cids {
+		buf = append(buf, c.Bytes()...)
+	}
+	return buf
+}
+
+func decodeCids(data []byte) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	for len(data) > 0 {
+		n, c, err := cid.CidFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, c)
+		data = data[n:]
+	}
+	return cids, nil
+}
+
+func encodeCount(count uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, count)]
+}
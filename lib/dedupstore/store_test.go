@@ -0,0 +1,320 @@
+package dedupstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/google/uuid"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Create two CAR files that share a block
+	shared := testutil.GenerateBlocksOfSize(1, 1024)[0]
+	blks := testutil.GenerateBlocksOfSize(2, 1024)
+	car1 := writeCar(t, filepath.Join(dir, "1.car"), shared, blks[0])
+	car2 := writeCar(t, filepath.Join(dir, "2.car"), shared, blks[1])
+
+	s := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+
+	imp1, err := s.Add(ctx, car1)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, imp1.ID)
+	require.Equal(t, 2, imp1.Blocks)
+
+	imp2, err := s.Add(ctx, car2)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, imp2.ID)
+
+	imps, err := s.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, imps, 2)
+
+	// The shared block should only be stored once
+	u, err := s.Usage(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, u.Blocks)
+	require.Equal(t, imp1.Size+imp2.Size, u.ImportsSize)
+	require.Less(t, u.Size, u.ImportsSize)
+
+	// The materialized CAR file should have the same blocks as the original
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteCar(ctx, imp2.ID, &buf))
+	br, err := carv2.NewBlockReader(&buf)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{shared.Cid()}, br.Roots)
+	for _, expected := range []blocks.Block{shared, blks[1]} {
+		blk, err := br.Next()
+		require.NoError(t, err)
+		require.Equal(t, expected.Cid(), blk.Cid())
+		require.Equal(t, expected.RawData(), blk.RawData())
+	}
+
+	// Removing the first import should keep the shared block
+	require.NoError(t, s.Remove(ctx, imp1.ID))
+	u, err = s.Usage(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, u.Blocks)
+	require.NoError(t, s.WriteCar(ctx, imp2.ID, &bytes.Buffer{}))
+
+	_, err = s.Get(ctx, imp1.ID)
+	require.ErrorIs(t, err, ErrImportNotFound)
+
+	// Removing the second import should delete all blocks
+	require.NoError(t, s.Remove(ctx, imp2.ID))
+	u, err = s.Usage(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, u.Blocks)
+
+	// Import ids should not be reused
+	imp3, err := s.Add(ctx, car1)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, imp3.ID)
+}
+
+func TestStoreBatches(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Write blocks and cids in batches of two blocks
+	defer func(size int) { addBatchSize = size }(addBatchSize)
+	addBatchSize = 2
+
+	blks := testutil.GenerateBlocksOfSize(5, 1024)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	s := New(ds)
+	imp, err := s.Add(ctx, writeCar(t, filepath.Join(dir, "1.car"), blks...))
+	require.NoError(t, err)
+	require.Equal(t, 5, imp.Blocks)
+	require.False(t, imp.Incomplete)
+
+	// The cids are kept out of the import record
+	rec, err := ds.Get(ctx, importsPrefix.Child(importKey(imp.ID)))
+	require.NoError(t, err)
+	require.NotContains(t, string(rec), blks[1].Cid().String())
+
+	cids, err := s.Cids(ctx, imp.ID)
+	require.NoError(t, err)
+	for i, blk := range blks {
+		require.Equal(t, blk.Cid(), cids[i])
+	}
+	cids, err = s.CidRange(ctx, imp.ID, 1, 3)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{blks[1].Cid(), blks[2].Cid(), blks[3].Cid()}, cids)
+
+	// If a block can't be read, the blocks that were already added are
+	// removed along with the import
+	f, err := os.Create(filepath.Join(dir, "bad.car"))
+	require.NoError(t, err)
+	require.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, f))
+	for _, blk := range blks[:3] {
+		require.NoError(t, carutil.LdWrite(f, blk.Cid().Bytes(), blk.RawData()))
+	}
+	require.NoError(t, carutil.LdWrite(f, []byte{0xff, 0xff}))
+	require.NoError(t, f.Close())
+	require.NoError(t, s.Remove(ctx, imp.ID))
+	_, err = s.Add(ctx, f.Name())
+	require.Error(t, err)
+
+	imps, err := s.List(ctx)
+	require.NoError(t, err)
+	require.Empty(t, imps)
+	u, err := s.Usage(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, u.Blocks)
+	keys, err := s.cidsKeys(ctx, imp.ID+1)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func writeCar(t *testing.T, path string, blks ...blocks.Block) string {
+	bs, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()})
+	require.NoError(t, err)
+	for _, blk := range blks {
+		require.NoError(t, bs.Put(context.Background(), blk))
+	}
+	require.NoError(t, bs.Finalize())
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	blks := testutil.GenerateBlocksOfSize(3, 1024)
+	car1 := writeCar(t, filepath.Join(dir, "1.car"), blks[0], blks[1])
+	car2 := writeCar(t, filepath.Join(dir, "2.car"), blks[2])
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	s := New(ds)
+	imp1, err := s.Add(ctx, car1)
+	require.NoError(t, err)
+	imp2, err := s.Add(ctx, car2)
+	require.NoError(t, err)
+
+	// Write out the CAR file for the second import, and record it
+	outPath := filepath.Join(dir, "out.car")
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteCar(ctx, imp2.ID, &buf))
+	require.NoError(t, os.WriteFile(outPath, buf.Bytes(), 0644))
+	require.NoError(t, s.RecordCar(ctx, imp2.ID, CarFile{Path: outPath, Size: int64(buf.Len())}))
+
+	last, err := s.LastCheck(ctx)
+	require.NoError(t, err)
+	require.True(t, last.IsZero())
+
+	report, err := s.Check(ctx, CheckOptions{Full: true})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Imports)
+	require.Equal(t, 3, report.Blocks)
+	require.Equal(t, 1, report.Cars)
+	require.Empty(t, report.Damaged)
+
+	last, err = s.LastCheck(ctx)
+	require.NoError(t, err)
+	require.False(t, last.IsZero())
+
+	// Delete a block of the first import, corrupt a block of the second
+	// import and truncate its CAR file
+	missingKey := blocksPrefix.Child(dshelp.MultihashToDsKey(blks[0].Cid().Hash()))
+	require.NoError(t, ds.Delete(ctx, missingKey))
+	corruptKey := blocksPrefix.Child(dshelp.MultihashToDsKey(blks[2].Cid().Hash()))
+	require.NoError(t, ds.Put(ctx, corruptKey, []byte("corrupt")))
+	require.NoError(t, os.Truncate(outPath, int64(buf.Len()/2)))
+
+	// A quick check should not find the corrupt block
+	var damaged []Damage
+	report, err = s.Check(ctx, CheckOptions{OnDamage: func(d Damage) { damaged = append(damaged, d) }})
+	require.NoError(t, err)
+	require.Equal(t, report.Damaged, damaged)
+	require.Len(t, report.Damaged, 2)
+	require.Equal(t, []cid.Cid{blks[0].Cid()}, report.Damaged[0].MissingBlocks)
+	require.True(t, report.Damaged[0].Quarantined)
+	require.Empty(t, report.Damaged[1].CorruptBlocks)
+	require.False(t, report.Damaged[1].Quarantined)
+	require.Equal(t, []CarDamage{{Path: outPath, Problem: CarTruncated}}, report.Damaged[1].Cars)
+
+	report, err = s.Check(ctx, CheckOptions{Full: true})
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{blks[2].Cid()}, report.Damaged[1].CorruptBlocks)
+	require.True(t, report.Damaged[1].Quarantined)
+
+	// The CAR file of a quarantined import cannot be written
+	err = s.WriteCar(ctx, imp1.ID, &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrImportQuarantined)
+
+	// Once the block is restored, the import should be released
+	require.NoError(t, ds.Put(ctx, missingKey, blks[0].RawData()))
+	report, err = s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{imp1.ID}, report.Released)
+	require.NoError(t, s.WriteCar(ctx, imp1.ID, &bytes.Buffer{}))
+}
+
+func TestCheckDeals(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	blks := testutil.GenerateBlocksOfSize(2, 1024)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	s := New(ds)
+	imp, err := s.Add(ctx, writeCar(t, filepath.Join(dir, "1.car"), blks[0], blks[1]))
+	require.NoError(t, err)
+
+	// Write out the CAR file for the import, and record it with its piece
+	// cid (quick checks don't recalculate the piece cid)
+	pieceCid := testutil.GenerateCid()
+	outPath := filepath.Join(dir, "out.car")
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteCar(ctx, imp.ID, &buf))
+	require.NoError(t, os.WriteFile(outPath, buf.Bytes(), 0644))
+	require.NoError(t, s.RecordCar(ctx, imp.ID, CarFile{Path: outPath, Size: int64(buf.Len()), PieceCid: pieceCid}))
+
+	carImp, carFile, err := s.CarForPiece(ctx, pieceCid)
+	require.NoError(t, err)
+	require.Equal(t, imp.ID, carImp.ID)
+	require.Equal(t, outPath, carFile.Path)
+	_, _, err = s.CarForPiece(ctx, testutil.GenerateCid())
+	require.ErrorIs(t, err, ErrImportNotFound)
+
+	// One deal's data is the CAR file, the other's is a CAR file that
+	// wasn't recorded
+	carDeal := Deal{UUID: uuid.New(), Provider: "f01000", PieceCid: pieceCid}
+	otherDeal := Deal{UUID: uuid.New(), Provider: "f01000", PieceCid: testutil.GenerateCid()}
+	require.NoError(t, s.AddDeal(ctx, imp.ID, carDeal))
+	require.NoError(t, s.AddDeal(ctx, imp.ID, otherDeal))
+
+	quarantined := func() []uuid.UUID {
+		deals, err := s.Deals(ctx)
+		require.NoError(t, err)
+		var ids []uuid.UUID
+		for _, d := range deals {
+			require.Equal(t, imp.ID, d.ImportID)
+			if d.Quarantine != nil {
+				ids = append(ids, d.UUID)
+			}
+		}
+		return ids
+	}
+
+	// Only the deal whose CAR file is truncated is quarantined
+	require.NoError(t, os.Truncate(outPath, int64(buf.Len()/2)))
+	report, err := s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Damaged, 1)
+	require.False(t, report.Damaged[0].Quarantined)
+	require.Equal(t, []uuid.UUID{carDeal.UUID}, report.Damaged[0].QuarantinedDeals)
+	require.Equal(t, []uuid.UUID{carDeal.UUID}, quarantined())
+
+	// The deal is released once the CAR file is written again
+	require.NoError(t, os.WriteFile(outPath, buf.Bytes(), 0644))
+	report, err = s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Empty(t, report.Damaged)
+	require.Equal(t, []uuid.UUID{carDeal.UUID}, report.ReleasedDeals)
+	require.Empty(t, quarantined())
+
+	// All the deals backed by an import with missing blocks are quarantined,
+	// and no more deals can be added
+	missingKey := blocksPrefix.Child(dshelp.MultihashToDsKey(blks[1].Cid().Hash()))
+	require.NoError(t, ds.Delete(ctx, missingKey))
+	report, err = s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.True(t, report.Damaged[0].Quarantined)
+	require.Equal(t, []uuid.UUID{carDeal.UUID, otherDeal.UUID}, report.Damaged[0].QuarantinedDeals)
+	require.Len(t, quarantined(), 2)
+	err = s.AddDeal(ctx, imp.ID, Deal{UUID: uuid.New(), PieceCid: pieceCid})
+	require.ErrorIs(t, err, ErrImportQuarantined)
+
+	require.NoError(t, ds.Put(ctx, missingKey, blks[1].RawData()))
+	report, err = s.Check(ctx, CheckOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{imp.ID}, report.Released)
+	require.Equal(t, []uuid.UUID{carDeal.UUID, otherDeal.UUID}, report.ReleasedDeals)
+
+	// Deals are removed once their data has been downloaded
+	require.NoError(t, s.RemoveDeal(ctx, carDeal.UUID))
+	deals, err := s.Deals(ctx)
+	require.NoError(t, err)
+	require.Len(t, deals, 1)
+	require.Equal(t, otherDeal.UUID, deals[0].UUID)
+	require.ErrorIs(t, s.RemoveDeal(ctx, carDeal.UUID), ErrDealNotFound)
+}
@@ -0,0 +1,236 @@
+// Package diskusage measures the disk space used by the client repo, and
+// checks that there is enough space (and quota) before starting an import or
+// a retrieval, so that it fails fast instead of filling the disk part way
+// through.
+package diskusage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Kind is the kind of data that is about to be written
+type Kind string
+
+const (
+	KindImport    Kind = "import"
+	KindRetrieval Kind = "retrieval"
+)
+
+// InsufficientSpaceError is returned by Check when there isn't enough free
+// space or quota for the data that is about to be written
+type InsufficientSpaceError struct {
+	Kind Kind
+	// The path the data would be written to
+	Path string
+	// The limit that would be exceeded: "free space", "min free space"
+	// or "<kind> quota"
+	Limit     string
+	Required  uint64
+	Available uint64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough disk space for %s to %s: requires %s but %s allows %s",
+		e.Kind, e.Path, humanize.IBytes(e.Required), e.Limit, humanize.IBytes(e.Available))
+}
+
+// Quotas limits the disk space used by the client repo. Sizes are
+// human-readable, eg "100GiB". An empty size means there is no limit.
+type Quotas struct {
+	// The maximum size of the imports blockstore
+	Imports string `json:"imports,omitempty"`
+	// The maximum size of retrieved CAR files
+	Retrievals string `json:"retrievals,omitempty"`
+	// The minimum free space to leave on the disk
+	MinFree string `json:"minFree,omitempty"`
+
+	imports    uint64
+	retrievals uint64
+	minFree    uint64
+}
+
+// LoadQuotas reads quotas from the json file at path. If the file doesn't
+// exist there are no quotas.
+func LoadQuotas(path string) (*Quotas, error) {
+	q := &Quotas{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("reading disk quotas: %w", err)
+	}
+	if err := json.Unmarshal(b, q); err != nil {
+		return nil, fmt.Errorf("parsing disk quotas %s: %w", path, err)
+	}
+	if err := q.init(); err != nil {
+		return nil, fmt.Errorf("disk quotas %s: %w", path, err)
+	}
+	return q, nil
+}
+
+func (q *Quotas) init() error {
+	for _, f := range []struct {
+		s string
+		v *uint64
+	}{{q.Imports, &q.imports}, {q.Retrievals, &q.retrievals}, {q.MinFree, &q.minFree}} {
+		if f.s == "" {
+			continue
+		}
+		v, err := humanize.ParseBytes(f.s)
+		if err != nil {
+			return fmt.Errorf("parsing size '%s': %w", f.s, err)
+		}
+		*f.v = v
+	}
+	return nil
+}
+
+// Usage is a breakdown of the disk space used by the client repo
+type Usage struct {
+	At time.Time `json:"at"`
+	// The size of the imports blockstore
+	Imports uint64 `json:"imports"`
+	// The size of retrieved CAR files and retrieval records
+	Retrievals uint64 `json:"retrievals"`
+	// The size of everything else in the repo (job store, wallet, keys etc)
+	Datastore uint64 `json:"datastore"`
+	// The free space on the disk that the repo is on
+	Free uint64 `json:"free"`
+}
+
+// Total returns the total disk space used
+func (u *Usage) Total() uint64 {
+	return u.Imports + u.Retrievals + u.Datastore
+}
+
+// Measure measures the disk space used by the repo. importsDir and
+// retrievalsDir are directories in the repo, and retrieved is the size of
+// the retrieved CAR files (which may be outside the repo).
+func Measure(repoDir, importsDir, retrievalsDir string, retrieved uint64) (*Usage, error) {
+	repoSize, err := DirSize(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	importsSize, err := DirSize(importsDir)
+	if err != nil {
+		return nil, err
+	}
+	recordsSize, err := DirSize(retrievalsDir)
+	if err != nil {
+		return nil, err
+	}
+	free, err := Free(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Usage{
+		At:         time.Now(),
+		Imports:    importsSize,
+		Retrievals: recordsSize + retrieved,
+		Free:       free,
+	}
+	if rest := importsSize + recordsSize; repoSize > rest {
+		u.Datastore = repoSize - rest
+	}
+	return u, nil
+}
+
+// Check returns an *InsufficientSpaceError if writing required bytes of the
+// given kind to path would exceed the free space on the disk or the quotas
+func Check(u *Usage, q *Quotas, kind Kind, path string, required uint64) error {
+	free, err := Free(path)
+	if err != nil {
+		return err
+	}
+
+	if required > free {
+		return &InsufficientSpaceError{Kind: kind, Path: path, Limit: "free space", Required: required, Available: free}
+	}
+	if q.minFree > 0 {
+		allowed := uint64(0)
+		if free > q.minFree {
+			allowed = free - q.minFree
+		}
+		if required > allowed || free < q.minFree {
+			return &InsufficientSpaceError{Kind: kind, Path: path, Limit: "min free space", Required: required, Available: allowed}
+		}
+	}
+
+	used, quota := u.Imports, q.imports
+	if kind == KindRetrieval {
+		used, quota = u.Retrievals, q.retrievals
+	}
+	if quota > 0 {
+		allowed := uint64(0)
+		if quota > used {
+			allowed = quota - used
+		}
+		if required > allowed {
+			return &InsufficientSpaceError{Kind: kind, Path: path, Limit: string(kind) + " quota", Required: required, Available: allowed}
+		}
+	}
+	return nil
+}
+
+// DirSize returns the total size of the files under dir. If dir doesn't
+// exist, the size is zero.
+func DirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// The file was removed while walking the directory
+				return nil
+			}
+			return err
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("getting size of %s: %w", dir, err)
+	}
+	return size, nil
+}
+
+// Free returns the space available to unprivileged users on the disk that
+// path is on. If path doesn't exist yet, its closest existing parent is used.
+func Free(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return uint64(st.Bavail) * uint64(st.Bsize), nil //nolint:unconvert
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, os.ErrNotExist) || parent == path {
+			return 0, fmt.Errorf("getting free space for %s: %w", path, err)
+		}
+		path = parent
+	}
+}
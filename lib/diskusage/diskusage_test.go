@@ -0,0 +1,87 @@
+package diskusage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasure(t *testing.T) {
+	repo := t.TempDir()
+	imports := filepath.Join(repo, "dedupstore")
+	retrievals := filepath.Join(repo, "retrievals")
+	require.NoError(t, os.MkdirAll(imports, 0755))
+	require.NoError(t, os.MkdirAll(retrievals, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(imports, "blocks"), make([]byte, 1000), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(retrievals, "record.json"), make([]byte, 10), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "wallet"), make([]byte, 100), 0644))
+
+	u, err := Measure(repo, imports, retrievals, 5000)
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, u.Imports)
+	require.EqualValues(t, 5010, u.Retrievals)
+	require.EqualValues(t, 100, u.Datastore)
+	require.EqualValues(t, 6110, u.Total())
+	require.NotZero(t, u.Free)
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Free(dir)
+	require.NoError(t, err)
+
+	// A path that doesn't exist yet uses its parent's disk
+	_, err = Free(filepath.Join(dir, "not", "yet"))
+	require.NoError(t, err)
+
+	u := &Usage{Imports: 900, Retrievals: 100}
+	noQuotas := &Quotas{}
+	require.NoError(t, Check(u, noQuotas, KindImport, dir, 100))
+
+	var ise *InsufficientSpaceError
+	err = Check(u, noQuotas, KindRetrieval, dir, free+1)
+	require.True(t, errors.As(err, &ise))
+	require.Equal(t, "free space", ise.Limit)
+
+	q := &Quotas{Imports: "1KB"}
+	require.NoError(t, q.init())
+	require.NoError(t, Check(u, q, KindImport, dir, 100))
+	err = Check(u, q, KindImport, dir, 101)
+	require.True(t, errors.As(err, &ise))
+	require.Equal(t, "import quota", ise.Limit)
+	require.EqualValues(t, 100, ise.Available)
+	// The imports quota doesn't apply to retrievals
+	require.NoError(t, Check(u, q, KindRetrieval, dir, 101))
+
+	q = &Quotas{MinFree: "1EiB"}
+	require.NoError(t, q.init())
+	err = Check(u, q, KindRetrieval, dir, 0)
+	require.True(t, errors.As(err, &ise))
+	require.Equal(t, "min free space", ise.Limit)
+}
+
+func TestHistoryTrend(t *testing.T) {
+	h, err := LoadHistory(filepath.Join(t.TempDir(), "history.json"))
+	require.NoError(t, err)
+	require.Nil(t, h.Trend(time.Hour))
+
+	now := time.Now()
+	require.NoError(t, h.Record(Usage{At: now.Add(-48 * time.Hour), Imports: 0}))
+	require.NoError(t, h.Record(Usage{At: now.Add(-24 * time.Hour), Imports: 1000, Retrievals: 500}))
+	require.NoError(t, h.Record(Usage{At: now, Imports: 3000, Retrievals: 0}))
+
+	tr := h.Trend(36 * time.Hour)
+	require.NotNil(t, tr)
+	require.EqualValues(t, 2000, tr.Imports)
+	require.EqualValues(t, -500, tr.Retrievals)
+	require.EqualValues(t, 1500, tr.Total)
+
+	// The history is saved
+	h, err = LoadHistory(h.path)
+	require.NoError(t, err)
+	require.Len(t, h.Samples, 3)
+}
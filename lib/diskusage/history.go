@@ -0,0 +1,96 @@
+package diskusage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// maxSamples is the number of usage samples kept in the history
+const maxSamples = 500
+
+// History keeps past usage measurements so that usage trends can be shown
+type History struct {
+	path    string
+	Samples []Usage `json:"samples"`
+}
+
+// LoadHistory reads the usage history from the json file at path
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return h, nil
+		}
+		return nil, fmt.Errorf("reading disk usage history: %w", err)
+	}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, fmt.Errorf("parsing disk usage history %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Record adds a usage sample to the history and saves it. The oldest
+// samples are dropped once there are more than maxSamples.
+func (h *History) Record(u Usage) error {
+	h.Samples = append(h.Samples, u)
+	if len(h.Samples) > maxSamples {
+		h.Samples = h.Samples[len(h.Samples)-maxSamples:]
+	}
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("serializing disk usage history: %w", err)
+	}
+	if err := os.WriteFile(h.path, b, 0644); err != nil {
+		return fmt.Errorf("writing disk usage history: %w", err)
+	}
+	return nil
+}
+
+// Trend is the rate at which usage changed over a period, in bytes per day
+// (negative if usage decreased)
+type Trend struct {
+	Since      time.Time `json:"since"`
+	Imports    int64     `json:"imports"`
+	Retrievals int64     `json:"retrievals"`
+	Datastore  int64     `json:"datastore"`
+	Total      int64     `json:"total"`
+}
+
+// Trend compares the latest sample with the oldest sample from within the
+// window. It returns nil if there aren't two samples to compare.
+func (h *History) Trend(window time.Duration) *Trend {
+	if len(h.Samples) < 2 {
+		return nil
+	}
+	latest := h.Samples[len(h.Samples)-1]
+	var first *Usage
+	for i := range h.Samples[:len(h.Samples)-1] {
+		if latest.At.Sub(h.Samples[i].At) <= window {
+			first = &h.Samples[i]
+			break
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	elapsed := latest.At.Sub(first.At)
+	if elapsed <= 0 {
+		return nil
+	}
+
+	perDay := func(from, to uint64) int64 {
+		return int64(float64(int64(to)-int64(from)) * float64(24*time.Hour) / float64(elapsed))
+	}
+	return &Trend{
+		Since:      first.At,
+		Imports:    perDay(first.Imports, latest.Imports),
+		Retrievals: perDay(first.Retrievals, latest.Retrievals),
+		Datastore:  perDay(first.Datastore, latest.Datastore),
+		Total:      perDay(first.Total(), latest.Total()),
+	}
+}
@@ -0,0 +1,314 @@
+// Package dtcontrol pauses, resumes and cancels data transfer channels on
+// request of the operator (eg while dealing with an incident).
+//
+// The operator's intent for each channel is recorded in the datastore, so
+// that a channel that was paused stays paused, and a channel that was
+// cancelled stays cancelled, when the channel is restarted (eg because boost
+// or the other peer was restarted).
+package dtcontrol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var log = logging.Logger("dtcontrol")
+
+// The prefix of the keys under which intents are stored
+var intentsPrefix = datastore.NewKey("/datatransfer/control/intents")
+
+const (
+	// The channel was paused, and is paused again when it is restarted
+	ActionPause = "pause"
+	// The channel was cancelled, and is cancelled again if it is restarted
+	// before the cancellation completes
+	ActionCancel = "cancel"
+)
+
+// Intent is the operator's intent for a data transfer channel
+type Intent struct {
+	ChannelID datatransfer.ChannelID
+	// One of ActionPause or ActionCancel
+	Action    string
+	CreatedAt time.Time
+}
+
+// Result is the result of applying an action to one of the channels in a
+// bulk operation
+type Result struct {
+	ChannelID datatransfer.ChannelID
+	// Set if the action could not be applied to the channel
+	Error string
+}
+
+type dataTransfer interface {
+	PauseDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error
+	ResumeDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error
+	CloseDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error
+	InProgressChannels(ctx context.Context) (map[datatransfer.ChannelID]datatransfer.ChannelState, error)
+	SubscribeToEvents(subscriber datatransfer.Subscriber) datatransfer.Unsubscribe
+}
+
+// Controller applies the operator's actions to data transfer channels and
+// records them as intents
+type Controller struct {
+	ds   datastore.Datastore
+	dt   dataTransfer
+	self peer.ID
+
+	lk    sync.Mutex
+	unsub datatransfer.Unsubscribe
+	ctx   context.Context
+}
+
+func New(ds datastore.Datastore, dt dataTransfer, self peer.ID) *Controller {
+	return &Controller{ds: ds, dt: dt, self: self}
+}
+
+// Start applies the recorded intents to the channels that are in progress,
+// and watches for restarted channels so as to apply the intents again. The
+// intents of channels that are no longer in progress are removed.
+func (c *Controller) Start(ctx context.Context) error {
+	c.lk.Lock()
+	c.ctx = ctx
+	c.unsub = c.dt.SubscribeToEvents(c.onEvent)
+	c.lk.Unlock()
+
+	intents, err := c.Intents(ctx)
+	if err != nil {
+		return err
+	}
+	if len(intents) == 0 {
+		return nil
+	}
+
+	channels, err := c.dt.InProgressChannels(ctx)
+	if err != nil {
+		return fmt.Errorf("listing data transfer channels: %w", err)
+	}
+	for _, in := range intents {
+		if _, ok := channels[in.ChannelID]; !ok {
+			if err := c.removeIntent(ctx, in.ChannelID); err != nil {
+				return err
+			}
+			continue
+		}
+		c.apply(ctx, in)
+	}
+	return nil
+}
+
+// Stop stops watching for restarted channels
+func (c *Controller) Stop() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.unsub != nil {
+		c.unsub()
+		c.unsub = nil
+	}
+}
+
+// Pause pauses the channel until it is resumed with Resume
+func (c *Controller) Pause(ctx context.Context, chid datatransfer.ChannelID) error {
+	if err := c.dt.PauseDataTransferChannel(ctx, chid); err != nil {
+		return fmt.Errorf("pausing data transfer channel %s: %w", chid, err)
+	}
+	return c.saveIntent(ctx, Intent{ChannelID: chid, Action: ActionPause, CreatedAt: time.Now()})
+}
+
+// Resume resumes a channel that was paused with Pause
+func (c *Controller) Resume(ctx context.Context, chid datatransfer.ChannelID) error {
+	if err := c.removeIntent(ctx, chid); err != nil {
+		return err
+	}
+	if err := c.dt.ResumeDataTransferChannel(ctx, chid); err != nil {
+		return fmt.Errorf("resuming data transfer channel %s: %w", chid, err)
+	}
+	return nil
+}
+
+// Cancel cancels the channel. The intent is recorded before the channel is
+// cancelled, so that the channel is cancelled again if it is restarted
+// before the cancellation completes.
+func (c *Controller) Cancel(ctx context.Context, chid datatransfer.ChannelID) error {
+	if err := c.saveIntent(ctx, Intent{ChannelID: chid, Action: ActionCancel, CreatedAt: time.Now()}); err != nil {
+		return err
+	}
+	if err := c.dt.CloseDataTransferChannel(ctx, chid); err != nil {
+		return fmt.Errorf("cancelling data transfer channel %s: %w", chid, err)
+	}
+	return nil
+}
+
+// PausePeer pauses all the channels in progress with the peer
+func (c *Controller) PausePeer(ctx context.Context, p peer.ID) ([]Result, error) {
+	return c.forPeer(ctx, p, c.Pause)
+}
+
+// ResumePeer resumes all the channels with the peer that were paused
+func (c *Controller) ResumePeer(ctx context.Context, p peer.ID) ([]Result, error) {
+	intents, err := c.Intents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res []Result
+	for _, in := range intents {
+		if in.Action != ActionPause || in.ChannelID.OtherParty(c.self) != p {
+			continue
+		}
+		r := Result{ChannelID: in.ChannelID}
+		if err := c.Resume(ctx, in.ChannelID); err != nil {
+			r.Error = err.Error()
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// CancelPeer cancels all the channels in progress with the peer
+func (c *Controller) CancelPeer(ctx context.Context, p peer.ID) ([]Result, error) {
+	return c.forPeer(ctx, p, c.Cancel)
+}
+
+func (c *Controller) forPeer(ctx context.Context, p peer.ID, action func(context.Context, datatransfer.ChannelID) error) ([]Result, error) {
+	channels, err := c.dt.InProgressChannels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing data transfer channels: %w", err)
+	}
+	var res []Result
+	for chid := range channels {
+		if chid.OtherParty(c.self) != p {
+			continue
+		}
+		r := Result{ChannelID: chid}
+		if err := action(ctx, chid); err != nil {
+			r.Error = err.Error()
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// Intents returns the recorded intents
+func (c *Controller) Intents(ctx context.Context) ([]Intent, error) {
+	qres, err := c.ds.Query(ctx, query.Query{Prefix: intentsPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("querying data transfer intents: %w", err)
+	}
+	defer qres.Close() //nolint:errcheck
+
+	var intents []Intent
+	for r := range qres.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("reading data transfer intents: %w", r.Error)
+		}
+		var in Intent
+		if err := json.Unmarshal(r.Value, &in); err != nil {
+			return nil, fmt.Errorf("parsing data transfer intent %s: %w", r.Key, err)
+		}
+		intents = append(intents, in)
+	}
+	return intents, nil
+}
+
+func (c *Controller) intent(ctx context.Context, chid datatransfer.ChannelID) (*Intent, error) {
+	b, err := c.ds.Get(ctx, intentKey(chid))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting data transfer intent for %s: %w", chid, err)
+	}
+	var in Intent
+	if err := json.Unmarshal(b, &in); err != nil {
+		return nil, fmt.Errorf("parsing data transfer intent for %s: %w", chid, err)
+	}
+	return &in, nil
+}
+
+func (c *Controller) saveIntent(ctx context.Context, in Intent) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("marshalling data transfer intent: %w", err)
+	}
+	if err := c.ds.Put(ctx, intentKey(in.ChannelID), b); err != nil {
+		return fmt.Errorf("saving data transfer intent for %s: %w", in.ChannelID, err)
+	}
+	return nil
+}
+
+func (c *Controller) removeIntent(ctx context.Context, chid datatransfer.ChannelID) error {
+	if err := c.ds.Delete(ctx, intentKey(chid)); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return fmt.Errorf("removing data transfer intent for %s: %w", chid, err)
+	}
+	return nil
+}
+
+func intentKey(chid datatransfer.ChannelID) datastore.Key {
+	return intentsPrefix.ChildString(chid.Initiator.String()).ChildString(chid.Responder.String()).ChildString(fmt.Sprint(chid.ID))
+}
+
+// apply applies the intent to the channel again
+func (c *Controller) apply(ctx context.Context, in Intent) {
+	var err error
+	switch in.Action {
+	case ActionPause:
+		err = c.dt.PauseDataTransferChannel(ctx, in.ChannelID)
+	case ActionCancel:
+		err = c.dt.CloseDataTransferChannel(ctx, in.ChannelID)
+	}
+	if err != nil {
+		log.Warnw("applying data transfer intent", "channel", in.ChannelID, "action", in.Action, "err", err)
+		return
+	}
+	log.Infow("applied data transfer intent", "channel", in.ChannelID, "action", in.Action)
+}
+
+// onEvent applies the intent for a channel again when it is restarted, and
+// removes the intent once the channel has finished
+func (c *Controller) onEvent(evt datatransfer.Event, st datatransfer.ChannelState) {
+	switch evt.Code {
+	case datatransfer.Restart, datatransfer.Complete, datatransfer.Cancel, datatransfer.Error, datatransfer.CleanupComplete:
+	default:
+		return
+	}
+
+	c.lk.Lock()
+	ctx := c.ctx
+	c.lk.Unlock()
+	if ctx == nil {
+		return
+	}
+
+	// Events are published from the data transfer event loop, which must
+	// not be blocked by calls back into the data transfer manager
+	chid := st.ChannelID()
+	go func() {
+		in, err := c.intent(ctx, chid)
+		if err != nil {
+			log.Warnw("getting data transfer intent", "channel", chid, "err", err)
+			return
+		}
+		if in == nil {
+			return
+		}
+		if evt.Code == datatransfer.Restart {
+			c.apply(ctx, *in)
+			return
+		}
+		if err := c.removeIntent(ctx, chid); err != nil {
+			log.Warnw("removing data transfer intent", "channel", chid, "err", err)
+		}
+	}()
+}
@@ -0,0 +1,184 @@
+package dtcontrol
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChannelState struct {
+	datatransfer.ChannelState
+	chid datatransfer.ChannelID
+}
+
+func (s *mockChannelState) ChannelID() datatransfer.ChannelID {
+	return s.chid
+}
+
+type mockDataTransfer struct {
+	lk         sync.Mutex
+	inProgress map[datatransfer.ChannelID]bool
+	paused     map[datatransfer.ChannelID]bool
+	closed     map[datatransfer.ChannelID]bool
+	subscriber datatransfer.Subscriber
+}
+
+func newMockDataTransfer(chids ...datatransfer.ChannelID) *mockDataTransfer {
+	dt := &mockDataTransfer{
+		inProgress: make(map[datatransfer.ChannelID]bool),
+		paused:     make(map[datatransfer.ChannelID]bool),
+		closed:     make(map[datatransfer.ChannelID]bool),
+	}
+	for _, chid := range chids {
+		dt.inProgress[chid] = true
+	}
+	return dt
+}
+
+func (m *mockDataTransfer) PauseDataTransferChannel(_ context.Context, chid datatransfer.ChannelID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.paused[chid] = true
+	return nil
+}
+
+func (m *mockDataTransfer) ResumeDataTransferChannel(_ context.Context, chid datatransfer.ChannelID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.paused[chid] = false
+	return nil
+}
+
+func (m *mockDataTransfer) CloseDataTransferChannel(_ context.Context, chid datatransfer.ChannelID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.closed[chid] = true
+	return nil
+}
+
+func (m *mockDataTransfer) InProgressChannels(context.Context) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	channels := make(map[datatransfer.ChannelID]datatransfer.ChannelState)
+	for chid, ok := range m.inProgress {
+		if ok {
+			channels[chid] = &mockChannelState{chid: chid}
+		}
+	}
+	return channels, nil
+}
+
+func (m *mockDataTransfer) SubscribeToEvents(subscriber datatransfer.Subscriber) datatransfer.Unsubscribe {
+	m.subscriber = subscriber
+	return func() { m.subscriber = nil }
+}
+
+func (m *mockDataTransfer) publish(code datatransfer.EventCode, chid datatransfer.ChannelID) {
+	m.subscriber(datatransfer.Event{Code: code}, &mockChannelState{chid: chid})
+}
+
+func (m *mockDataTransfer) isPaused(chid datatransfer.ChannelID) bool {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.paused[chid]
+}
+
+func (m *mockDataTransfer) isClosed(chid datatransfer.ChannelID) bool {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.closed[chid]
+}
+
+func TestController(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	self, client1, client2 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	// Storage transfers are initiated by the client, retrieval transfers by
+	// the provider
+	storage1 := datatransfer.ChannelID{Initiator: client1, Responder: self, ID: 1}
+	storage2 := datatransfer.ChannelID{Initiator: client1, Responder: self, ID: 2}
+	retrieval := datatransfer.ChannelID{Initiator: self, Responder: client1, ID: 3}
+	other := datatransfer.ChannelID{Initiator: client2, Responder: self, ID: 4}
+
+	dt := newMockDataTransfer(storage1, storage2, retrieval, other)
+	c := New(ds, dt, self)
+	require.NoError(t, c.Start(ctx))
+
+	// Pause a single channel
+	require.NoError(t, c.Pause(ctx, storage1))
+	require.True(t, dt.isPaused(storage1))
+	intents, err := c.Intents(ctx)
+	require.NoError(t, err)
+	require.Len(t, intents, 1)
+	require.Equal(t, storage1, intents[0].ChannelID)
+	require.Equal(t, ActionPause, intents[0].Action)
+
+	// A paused channel is paused again when it is restarted
+	dt.ResumeDataTransferChannel(ctx, storage1) //nolint:errcheck
+	dt.publish(datatransfer.Restart, storage1)
+	require.Eventually(t, func() bool { return dt.isPaused(storage1) }, time.Second, time.Millisecond)
+
+	// Resuming the channel removes the intent
+	require.NoError(t, c.Resume(ctx, storage1))
+	require.False(t, dt.isPaused(storage1))
+	intents, err = c.Intents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, intents)
+
+	// Pause all the channels with a peer, in either direction
+	res, err := c.PausePeer(ctx, client1)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	for _, r := range res {
+		require.Empty(t, r.Error)
+	}
+	require.True(t, dt.isPaused(storage1))
+	require.True(t, dt.isPaused(storage2))
+	require.True(t, dt.isPaused(retrieval))
+	require.False(t, dt.isPaused(other))
+
+	// Cancel a channel with another peer
+	res, err = c.CancelPeer(ctx, client2)
+	require.NoError(t, err)
+	require.Equal(t, []Result{{ChannelID: other}}, res)
+	require.True(t, dt.isClosed(other))
+
+	// After a restart, the intents are applied to the channels that are
+	// still in progress, and dropped for the others
+	c.Stop()
+	dt = newMockDataTransfer(storage1, retrieval, other)
+	c = New(ds, dt, self)
+	require.NoError(t, c.Start(ctx))
+	require.True(t, dt.isPaused(storage1))
+	require.True(t, dt.isPaused(retrieval))
+	require.True(t, dt.isClosed(other))
+	intents, err = c.Intents(ctx)
+	require.NoError(t, err)
+	require.Len(t, intents, 3)
+
+	// The intent is removed once the cancellation completes
+	dt.publish(datatransfer.Cancel, other)
+	require.Eventually(t, func() bool {
+		intents, err = c.Intents(ctx)
+		require.NoError(t, err)
+		return len(intents) == 2
+	}, time.Second, time.Millisecond)
+
+	// Resume all the paused channels with the peer
+	res, err = c.ResumePeer(ctx, client1)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.False(t, dt.isPaused(storage1))
+	require.False(t, dt.isPaused(retrieval))
+	intents, err = c.Intents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, intents)
+}
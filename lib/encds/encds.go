@@ -0,0 +1,266 @@
+// Package encds wraps a datastore so that values are encrypted at rest with
+// a key managed by the repo. Reads through the wrapper transparently decrypt
+// values, so code (and APIs) that use the datastore see the plaintext, while
+// anyone reading the datastore files directly only sees ciphertext.
+//
+// Values are encrypted with AES-256-GCM, using the datastore key as
+// additional data so that encrypted values can't be moved between keys.
+// Values that were written before encryption was enabled are returned as-is,
+// and are encrypted the next time they're written (or by EncryptExisting).
+// Keys are not encrypted, so they shouldn't contain sensitive data.
+package encds
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// KeySize is the size of the encryption key in bytes
+const KeySize = 32
+
+// magic is the prefix of encrypted values. It's used to distinguish encrypted
+// values from values written before encryption was enabled.
+var magic = []byte{0xff, 'e', 'n', 'c', 1}
+
+// ErrDecrypt is returned when a value can't be decrypted, eg because it was
+// encrypted with a different key
+var ErrDecrypt = errors.New("failed to decrypt datastore value")
+
+// Datastore encrypts the values written to the child datastore, and
+// decrypts them when they are read
+type Datastore struct {
+	child datastore.Batching
+	aead  cipher.AEAD
+}
+
+var _ datastore.Batching = (*Datastore)(nil)
+
+// Wrap returns a datastore that encrypts values with the key before writing
+// them to child
+func Wrap(child datastore.Batching, key []byte) (*Datastore, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("datastore encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Datastore{child: child, aead: aead}, nil
+}
+
+// NewKey generates a random encryption key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating datastore encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// LoadOrCreateKey reads the hex-encoded key from the file at path, or
+// generates a new key and writes it to the file if it doesn't exist
+func LoadOrCreateKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, fmt.Errorf("parsing datastore encryption key %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading datastore encryption key: %w", err)
+	}
+
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("saving datastore encryption key: %w", err)
+	}
+	return key, nil
+}
+
+func (d *Datastore) encrypt(key datastore.Key, value []byte) ([]byte, error) {
+	nonceSize := d.aead.NonceSize()
+	out := make([]byte, len(magic)+nonceSize, len(magic)+nonceSize+len(value)+d.aead.Overhead())
+	copy(out, magic)
+	nonce := out[len(magic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return d.aead.Seal(out, nonce, value, key.Bytes()), nil
+}
+
+func (d *Datastore) decrypt(key datastore.Key, value []byte) ([]byte, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	nonceSize := d.aead.NonceSize()
+	if len(value) < len(magic)+nonceSize {
+		return nil, fmt.Errorf("%w %s: value is too short", ErrDecrypt, key)
+	}
+	nonce := value[len(magic) : len(magic)+nonceSize]
+	plain, err := d.aead.Open(nil, nonce, value[len(magic)+nonceSize:], key.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %s", ErrDecrypt, key, err)
+	}
+	return plain, nil
+}
+
+func isEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, magic)
+}
+
+func (d *Datastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	value, err := d.child.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return d.decrypt(key, value)
+}
+
+func (d *Datastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	return d.child.Has(ctx, key)
+}
+
+func (d *Datastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	value, err := d.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	return len(value), nil
+}
+
+func (d *Datastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	enc, err := d.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return d.child.Put(ctx, key, enc)
+}
+
+func (d *Datastore) Delete(ctx context.Context, key datastore.Key) error {
+	return d.child.Delete(ctx, key)
+}
+
+// Query decrypts the values in the query results. Filters and orders that
+// depend on values are applied after decryption.
+func (d *Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	cq := query.Query{
+		Prefix:            q.Prefix,
+		KeysOnly:          q.KeysOnly,
+		ReturnExpirations: q.ReturnExpirations,
+	}
+	cqr, err := d.child.Query(ctx, cq)
+	if err != nil {
+		return nil, err
+	}
+
+	qr := query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := cqr.NextSync()
+			if !ok {
+				return r, false
+			}
+			if r.Error == nil && !q.KeysOnly {
+				r.Value, r.Error = d.decrypt(datastore.RawKey(r.Key), r.Value)
+				r.Size = len(r.Value)
+			}
+			return r, true
+		},
+		Close: func() error {
+			return cqr.Close()
+		},
+	})
+
+	nq := q
+	nq.Prefix = ""
+	return query.NaiveQueryApply(nq, qr), nil
+}
+
+func (d *Datastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+func (d *Datastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &batch{d: d, child: b}, nil
+}
+
+type batch struct {
+	d     *Datastore
+	child datastore.Batch
+}
+
+func (b *batch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	enc, err := b.d.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return b.child.Put(ctx, key, enc)
+}
+
+func (b *batch) Delete(ctx context.Context, key datastore.Key) error {
+	return b.child.Delete(ctx, key)
+}
+
+func (b *batch) Commit(ctx context.Context) error {
+	return b.child.Commit(ctx)
+}
+
+// EncryptExisting encrypts any values that were written to the datastore
+// before encryption was enabled. It returns the number of values that were
+// encrypted.
+func (d *Datastore) EncryptExisting(ctx context.Context) (int, error) {
+	qr, err := d.child.Query(ctx, query.Query{})
+	if err != nil {
+		return 0, fmt.Errorf("querying datastore: %w", err)
+	}
+	defer qr.Close() //nolint:errcheck
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for r := range qr.Next() {
+		if r.Error != nil {
+			return 0, fmt.Errorf("querying datastore: %w", r.Error)
+		}
+		if isEncrypted(r.Value) {
+			continue
+		}
+		if err := b.Put(ctx, datastore.RawKey(r.Key), r.Value); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if err := b.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("writing encrypted values: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,94 @@
+package encds
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatastore(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	key, err := LoadOrCreateKey(filepath.Join(t.TempDir(), "key"))
+	req.NoError(err)
+
+	child := dssync.MutexWrap(datastore.NewMapDatastore())
+	// A value written before encryption was enabled
+	legacy := datastore.NewKey("/legacy")
+	req.NoError(child.Put(ctx, legacy, []byte("legacy label")))
+
+	ds, err := Wrap(child, key)
+	req.NoError(err)
+
+	secret := datastore.NewKey("/deal/1")
+	req.NoError(ds.Put(ctx, secret, []byte("confidential dataset name")))
+
+	b, err := ds.Batch(ctx)
+	req.NoError(err)
+	req.NoError(b.Put(ctx, datastore.NewKey("/deal/2"), []byte("another label")))
+	req.NoError(b.Commit(ctx))
+
+	// Values are encrypted in the child datastore
+	raw, err := child.Get(ctx, secret)
+	req.NoError(err)
+	req.False(bytes.Contains(raw, []byte("confidential")))
+
+	// and decrypted when read through the wrapper
+	v, err := ds.Get(ctx, secret)
+	req.NoError(err)
+	req.Equal("confidential dataset name", string(v))
+	sz, err := ds.GetSize(ctx, secret)
+	req.NoError(err)
+	req.Equal(len("confidential dataset name"), sz)
+	v, err = ds.Get(ctx, legacy)
+	req.NoError(err)
+	req.Equal("legacy label", string(v))
+
+	res, err := ds.Query(ctx, query.Query{Prefix: "/deal", Orders: []query.Order{query.OrderByValue{}}})
+	req.NoError(err)
+	entries, err := res.Rest()
+	req.NoError(err)
+	req.Len(entries, 2)
+	req.Equal("another label", string(entries[0].Value))
+	req.Equal("confidential dataset name", string(entries[1].Value))
+
+	// Encrypted values can't be moved to another key
+	req.NoError(child.Put(ctx, datastore.NewKey("/moved"), raw))
+	_, err = ds.Get(ctx, datastore.NewKey("/moved"))
+	req.ErrorIs(err, ErrDecrypt)
+	req.NoError(child.Delete(ctx, datastore.NewKey("/moved")))
+
+	// or read with a different key
+	otherKey, err := NewKey()
+	req.NoError(err)
+	other, err := Wrap(child, otherKey)
+	req.NoError(err)
+	_, err = other.Get(ctx, secret)
+	req.ErrorIs(err, ErrDecrypt)
+
+	// Legacy values can be encrypted in place
+	count, err := ds.EncryptExisting(ctx)
+	req.NoError(err)
+	req.Equal(1, count)
+	raw, err = child.Get(ctx, legacy)
+	req.NoError(err)
+	req.True(isEncrypted(raw))
+	v, err = ds.Get(ctx, legacy)
+	req.NoError(err)
+	req.Equal("legacy label", string(v))
+
+	// The key is reloaded from the same file
+	dir := t.TempDir()
+	k1, err := LoadOrCreateKey(filepath.Join(dir, "key"))
+	req.NoError(err)
+	k2, err := LoadOrCreateKey(filepath.Join(dir, "key"))
+	req.NoError(err)
+	req.Equal(k1, k2)
+}
@@ -0,0 +1,225 @@
+// Package erasure splits a dataset into k data shards and m parity shards
+// with a systematic Reed-Solomon code, so that the dataset can be rebuilt
+// from any k of the k+m shards. Each shard can be stored with a different
+// storage provider, so that the dataset survives the loss of up to m
+// providers at a storage cost of (k+m)/k instead of full replication.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultBlockSize is the number of bytes of each shard in each stripe
+const DefaultBlockSize = 1 << 20
+
+var ErrTooFewShards = errors.New("not enough shards to reconstruct the data")
+
+// Codec encodes data into k+m shards and reconstructs it from any k shards
+type Codec struct {
+	k         int
+	m         int
+	blockSize int
+	// The (k+m) x k encoding matrix. The first k rows are the identity
+	// matrix, so the data shards contain the data unchanged.
+	matrix matrix
+}
+
+// New creates a Codec with k data shards and m parity shards. Data is
+// striped across the data shards blockSize bytes at a time.
+func New(k, m, blockSize int) (*Codec, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("the number of data shards must be at least 1, got %d", k)
+	}
+	if m < 1 {
+		return nil, fmt.Errorf("the number of parity shards must be at least 1, got %d", m)
+	}
+	if k+m > 256 {
+		return nil, fmt.Errorf("the total number of shards must be at most 256, got %d", k+m)
+	}
+	if blockSize < 1 {
+		return nil, fmt.Errorf("block size must be positive, got %d", blockSize)
+	}
+
+	// Make the vandermonde matrix systematic by multiplying it by the
+	// inverse of its top square. Any k rows of the result are still
+	// linearly independent.
+	v := vandermonde(k+m, k)
+	top, err := v[:k].invert()
+	if err != nil {
+		return nil, fmt.Errorf("creating encoding matrix: %w", err)
+	}
+	return &Codec{k: k, m: m, blockSize: blockSize, matrix: v.mul(top)}, nil
+}
+
+// DataShards is the number of data shards (k)
+func (c *Codec) DataShards() int {
+	return c.k
+}
+
+// ParityShards is the number of parity shards (m)
+func (c *Codec) ParityShards() int {
+	return c.m
+}
+
+// ShardSize is the size of each shard for data of the given size
+func (c *Codec) ShardSize(dataSize int64) int64 {
+	stripeSize := int64(c.k * c.blockSize)
+	stripes := (dataSize + stripeSize - 1) / stripeSize
+	if stripes == 0 {
+		stripes = 1
+	}
+	return stripes * int64(c.blockSize)
+}
+
+// Encode reads the data from r and writes each of the k+m shards to the
+// corresponding writer. The last stripe is padded with zeros. It returns
+// the number of bytes of data read.
+func (c *Codec) Encode(r io.Reader, shards []io.Writer) (int64, error) {
+	if len(shards) != c.k+c.m {
+		return 0, fmt.Errorf("expected %d shard writers, got %d", c.k+c.m, len(shards))
+	}
+
+	s := c.newStripe()
+	var total int64
+	for {
+		// Read a stripe of data into the data blocks
+		n, err := io.ReadFull(r, s.data)
+		total += int64(n)
+		if errors.Is(err, io.EOF) && total > 0 {
+			return total, nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return total, fmt.Errorf("reading data: %w", err)
+		}
+		last := err != nil
+		if last {
+			// Pad the last stripe
+			for i := n; i < len(s.data); i++ {
+				s.data[i] = 0
+			}
+		}
+
+		c.encodeStripe(s.shards)
+		for i, w := range shards {
+			if _, err := w.Write(s.shards[i]); err != nil {
+				return total, fmt.Errorf("writing shard %d: %w", i, err)
+			}
+		}
+		if last {
+			return total, nil
+		}
+	}
+}
+
+// Reconstruct rebuilds dataSize bytes of data from the shards and writes it
+// to w. There must be one reader per shard, with nil for missing shards.
+// Only the first k shards that are present are read.
+func (c *Codec) Reconstruct(shards []io.Reader, dataSize int64, w io.Writer) error {
+	if len(shards) != c.k+c.m {
+		return fmt.Errorf("expected %d shard readers, got %d", c.k+c.m, len(shards))
+	}
+
+	// Pick the first k shards that are present
+	var idx []int
+	for i, r := range shards {
+		if r != nil && len(idx) < c.k {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) < c.k {
+		return fmt.Errorf("%w: need %d shards, have %d", ErrTooFewShards, c.k, len(idx))
+	}
+
+	// Work out the decoding matrix, unless all the data shards are present
+	var decode matrix
+	if idx[c.k-1] != c.k-1 {
+		sub := make(matrix, c.k)
+		for i, si := range idx {
+			sub[i] = c.matrix[si]
+		}
+		var err error
+		decode, err = sub.invert()
+		if err != nil {
+			return fmt.Errorf("creating decoding matrix: %w", err)
+		}
+	}
+
+	s := c.newStripe()
+	in := make([][]byte, c.k)
+	for i := range in {
+		in[i] = make([]byte, c.blockSize)
+	}
+	remaining := dataSize
+	for remaining > 0 {
+		for i, si := range idx {
+			if _, err := io.ReadFull(shards[si], in[i]); err != nil {
+				return fmt.Errorf("reading shard %d: %w", si, err)
+			}
+		}
+
+		out := in
+		if decode != nil {
+			out = s.shards[:c.k]
+			for i := range out {
+				for j := range out[i] {
+					out[i][j] = 0
+				}
+				for j := range in {
+					mulAdd(decode[i][j], in[j], out[i])
+				}
+			}
+		}
+
+		for _, blk := range out {
+			if remaining <= 0 {
+				break
+			}
+			if int64(len(blk)) > remaining {
+				blk = blk[:remaining]
+			}
+			if _, err := w.Write(blk); err != nil {
+				return fmt.Errorf("writing data: %w", err)
+			}
+			remaining -= int64(len(blk))
+		}
+	}
+	return nil
+}
+
+// encodeStripe computes the parity blocks from the data blocks
+func (c *Codec) encodeStripe(shards [][]byte) {
+	for p := 0; p < c.m; p++ {
+		parity := shards[c.k+p]
+		for i := range parity {
+			parity[i] = 0
+		}
+		row := c.matrix[c.k+p]
+		for j := 0; j < c.k; j++ {
+			mulAdd(row[j], shards[j], parity)
+		}
+	}
+}
+
+type stripe struct {
+	// The data blocks as a single contiguous buffer
+	data []byte
+	// The data blocks followed by the parity blocks. The data blocks are
+	// slices of data.
+	shards [][]byte
+}
+
+func (c *Codec) newStripe() *stripe {
+	s := &stripe{
+		data:   make([]byte, c.k*c.blockSize),
+		shards: make([][]byte, c.k+c.m),
+	}
+	for i := 0; i < c.k; i++ {
+		s.shards[i] = s.data[i*c.blockSize : (i+1)*c.blockSize]
+	}
+	for i := c.k; i < c.k+c.m; i++ {
+		s.shards[i] = make([]byte, c.blockSize)
+	}
+	return s
+}
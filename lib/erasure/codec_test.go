@@ -0,0 +1,144 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	req := require.New(t)
+
+	const k, m, blockSize = 4, 2, 64
+	c, err := New(k, m, blockSize)
+	req.NoError(err)
+
+	for _, size := range []int{0, 1, blockSize, k * blockSize, 3*k*blockSize + 17} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		bufs := make([]*bytes.Buffer, k+m)
+		ws := make([]io.Writer, k+m)
+		for i := range bufs {
+			bufs[i] = &bytes.Buffer{}
+			ws[i] = bufs[i]
+		}
+		n, err := c.Encode(bytes.NewReader(data), ws)
+		req.NoError(err)
+		req.EqualValues(size, n)
+		for _, b := range bufs {
+			req.EqualValues(c.ShardSize(int64(size)), b.Len())
+		}
+
+		// Reconstruct from each combination of missing shards
+		for missing1 := 0; missing1 < k+m; missing1++ {
+			for missing2 := missing1; missing2 < k+m; missing2++ {
+				rs := make([]io.Reader, k+m)
+				for i, b := range bufs {
+					if i != missing1 && i != missing2 {
+						rs[i] = bytes.NewReader(b.Bytes())
+					}
+				}
+				var out bytes.Buffer
+				req.NoError(c.Reconstruct(rs, int64(size), &out))
+				req.Equal(len(data), out.Len())
+				req.True(bytes.Equal(data, out.Bytes()), "size %d missing %d and %d", size, missing1, missing2)
+			}
+		}
+
+		// Losing more than m shards is fatal
+		rs := make([]io.Reader, k+m)
+		for i := 0; i < k-1; i++ {
+			rs[i] = bytes.NewReader(bufs[i].Bytes())
+		}
+		req.ErrorIs(c.Reconstruct(rs, int64(size), io.Discard), ErrTooFewShards)
+	}
+}
+
+func TestShardCar(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	data := make([]byte, 3*unixfsChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	shardPath := filepath.Join(dir, "shard")
+	req.NoError(os.WriteFile(shardPath, data, 0644))
+
+	carPath := filepath.Join(dir, "shard.car")
+	root, err := WriteShardCar(ctx, shardPath, carPath)
+	req.NoError(err)
+
+	r, err := OpenShardCar(ctx, carPath, root)
+	req.NoError(err)
+	defer r.Close() //nolint:errcheck
+	got, err := io.ReadAll(r)
+	req.NoError(err)
+	req.Equal(data, got)
+}
+
+func TestEncodeFileAndRebuild(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(2)).Read(data)
+	inPath := filepath.Join(dir, "data")
+	req.NoError(os.WriteFile(inPath, data, 0644))
+
+	c, err := New(3, 2, 1024)
+	req.NoError(err)
+	m, err := EncodeFile(ctx, c, inPath, dir)
+	req.NoError(err)
+	req.Len(m.Shards, 5)
+	req.EqualValues(len(data), m.DataSize)
+
+	manifestPath := filepath.Join(dir, "manifest.json")
+	req.NoError(m.Save(manifestPath))
+	m, err = LoadManifest(manifestPath)
+	req.NoError(err)
+
+	// Shard 0 can't be fetched and shard 1 is corrupt, so the data must be
+	// reconstructed from shard 2 and the parity shards
+	fetch := func(ctx context.Context, s Shard) (string, error) {
+		switch s.Index {
+		case 0:
+			return "", fmt.Errorf("provider is offline")
+		case 1:
+			corrupt := filepath.Join(dir, "corrupt.car")
+			other, err := os.ReadFile(m.Shards[2].Path)
+			if err != nil {
+				return "", err
+			}
+			return corrupt, os.WriteFile(corrupt, other, 0644)
+		}
+		return s.Path, nil
+	}
+
+	var out bytes.Buffer
+	results, err := Rebuild(ctx, m, fetch, &out)
+	req.NoError(err)
+	req.Equal(data, out.Bytes())
+	req.Len(results, 5)
+	req.NotEmpty(results[0].Error)
+	req.NotEmpty(results[1].Error)
+	req.Empty(results[2].Error)
+
+	// With three shards unavailable the data can't be rebuilt
+	fetchFewer := func(ctx context.Context, s Shard) (string, error) {
+		if s.Index < 3 {
+			return "", fmt.Errorf("provider is offline")
+		}
+		return s.Path, nil
+	}
+	_, err = Rebuild(ctx, m, fetchFewer, io.Discard)
+	req.ErrorIs(err, ErrTooFewShards)
+}
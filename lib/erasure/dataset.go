@@ -0,0 +1,187 @@
+package erasure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/commp"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("erasure")
+
+// EncodeFile erasure codes the file at inPath into k+m shards, and writes
+// each shard to outDir as a CAR file that is ready to be stored with a
+// storage provider. It returns the manifest describing the shards; the
+// caller is responsible for assigning providers and saving it.
+func EncodeFile(ctx context.Context, c *Codec, inPath string, outDir string) (*Manifest, error) {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", inPath, err)
+	}
+	defer in.Close() //nolint:errcheck
+
+	// Write the raw shards to temporary files, hashing them on the way
+	n := c.k + c.m
+	shardPaths := make([]string, n)
+	shardHashes := make([]hash.Hash, n)
+	writers := make([]io.Writer, n)
+	for i := 0; i < n; i++ {
+		shardPaths[i] = filepath.Join(outDir, fmt.Sprintf("shard-%03d.bin", i))
+		f, err := os.Create(shardPaths[i])
+		if err != nil {
+			return nil, fmt.Errorf("creating shard file: %w", err)
+		}
+		defer os.Remove(shardPaths[i]) //nolint:errcheck
+		defer f.Close()                //nolint:errcheck
+		shardHashes[i] = sha256.New()
+		writers[i] = io.MultiWriter(f, shardHashes[i])
+	}
+
+	dataHash := sha256.New()
+	size, err := c.Encode(io.TeeReader(in, dataHash), writers)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", inPath, err)
+	}
+
+	m := &Manifest{
+		Version:      ManifestVersion,
+		Name:         filepath.Base(inPath),
+		DataShards:   c.k,
+		ParityShards: c.m,
+		BlockSize:    c.blockSize,
+		DataSize:     size,
+		DataSha256:   hex.EncodeToString(dataHash.Sum(nil)),
+		CreatedAt:    time.Now(),
+	}
+
+	// Pack each shard into a CAR file and calculate its commp
+	for i := 0; i < n; i++ {
+		carPath := filepath.Join(outDir, fmt.Sprintf("shard-%03d.car", i))
+		root, err := WriteShardCar(ctx, shardPaths[i], carPath)
+		if err != nil {
+			return nil, fmt.Errorf("writing CAR file for shard %d: %w", i, err)
+		}
+
+		car, err := os.Open(carPath)
+		if err != nil {
+			return nil, err
+		}
+		st, err := car.Stat()
+		if err != nil {
+			_ = car.Close()
+			return nil, err
+		}
+		pi, err := commp.Default().Sum(ctx, car)
+		_ = car.Close()
+		if err != nil {
+			return nil, fmt.Errorf("calculating commp for shard %d: %w", i, err)
+		}
+
+		m.Shards = append(m.Shards, Shard{
+			Index:      i,
+			Size:       c.ShardSize(size),
+			Sha256:     hex.EncodeToString(shardHashes[i].Sum(nil)),
+			Path:       carPath,
+			PayloadCid: root,
+			PieceCid:   pi.PieceCID,
+			PieceSize:  pi.Size,
+			CarSize:    uint64(st.Size()),
+		})
+		log.Debugw("wrote shard", "index", i, "root", root, "piece", pi.PieceCID)
+	}
+	return m, nil
+}
+
+// FetchShard gets the CAR file for a shard (eg by retrieving it from the
+// storage provider it is stored with) and returns its path
+type FetchShard func(ctx context.Context, s Shard) (string, error)
+
+// ShardResult is the result of fetching one shard for reconstruction
+type ShardResult struct {
+	Index    int    `json:"index"`
+	Provider string `json:"provider"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Rebuild fetches shards until it has k valid shards, preferring data
+// shards (which don't need to be decoded), and writes the reconstructed
+// data to w. Shards that can't be fetched or whose contents don't match the
+// manifest are skipped. It returns the result for each shard that was
+// attempted.
+func Rebuild(ctx context.Context, m *Manifest, fetch FetchShard, w io.Writer) ([]ShardResult, error) {
+	c, err := m.Codec()
+	if err != nil {
+		return nil, err
+	}
+
+	readers := make([]io.Reader, len(m.Shards))
+	var results []ShardResult
+	have := 0
+	for _, s := range m.Shards {
+		if have == m.DataShards {
+			break
+		}
+
+		res := ShardResult{Index: s.Index, Provider: s.Provider}
+		r, err := openVerifiedShard(ctx, s, fetch)
+		if err != nil {
+			res.Error = err.Error()
+			log.Infow("skipping shard", "index", s.Index, "provider", s.Provider, "err", err)
+			results = append(results, res)
+			continue
+		}
+		defer r.Close() //nolint:errcheck
+		readers[s.Index] = r
+		have++
+		results = append(results, res)
+	}
+	if have < m.DataShards {
+		return results, fmt.Errorf("%w: only %d of the %d shards needed could be fetched", ErrTooFewShards, have, m.DataShards)
+	}
+
+	h := sha256.New()
+	if err := c.Reconstruct(readers, m.DataSize, io.MultiWriter(w, h)); err != nil {
+		return results, err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.DataSha256 {
+		return results, fmt.Errorf("reconstructed data sha256 %s does not match manifest %s", sum, m.DataSha256)
+	}
+	return results, nil
+}
+
+// openVerifiedShard fetches the shard and checks its contents against the
+// hash in the manifest before returning a reader for it
+func openVerifiedShard(ctx context.Context, s Shard, fetch FetchShard) (io.ReadCloser, error) {
+	carPath, err := fetch(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := OpenShardCar(ctx, carPath, s.PayloadCid)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	_ = r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading shard: %w", err)
+	}
+	if n != s.Size {
+		return nil, fmt.Errorf("shard size %d does not match manifest size %d", n, s.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != s.Sha256 {
+		return nil, errors.New("shard sha256 does not match manifest")
+	}
+
+	return OpenShardCar(ctx, carPath, s.PayloadCid)
+}
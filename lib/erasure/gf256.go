@@ -0,0 +1,158 @@
+package erasure
+
+import "errors"
+
+var errSingularMatrix = errors.New("matrix is singular")
+
+// Arithmetic in GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+// (0x11d) and generator 2
+var (
+	expTable [512]byte
+	logTable [256]byte
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// Duplicate the table so that the sum of two logs can be looked up
+	// without taking it modulo 255
+	for i := 255; i < len(expTable); i++ {
+		expTable[i] = expTable[i-255]
+	}
+
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			mulTable[a][b] = gfMulSlow(byte(a), byte(b))
+		}
+	}
+}
+
+func gfMulSlow(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func gfMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+func gfExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// mulAdd sets out[i] ^= c * in[i]
+func mulAdd(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	tbl := &mulTable[c]
+	for i, v := range in {
+		out[i] ^= tbl[v]
+	}
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde returns a rows x cols matrix where element (r, c) is r^c.
+// Any cols rows of the matrix are linearly independent.
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			m[r][c] = gfExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) mul(o matrix) matrix {
+	res := newMatrix(len(m), len(o[0]))
+	for r := range m {
+		for c := range o[0] {
+			var v byte
+			for i := range o {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+			res[r][c] = v
+		}
+	}
+	return res
+}
+
+// invert returns the inverse of a square matrix, using Gauss-Jordan
+// elimination
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	// Augment the matrix with the identity matrix
+	work := newMatrix(n, 2*n)
+	for r := 0; r < n; r++ {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		// Find a row with a non-zero value in this column
+		if work[c][c] == 0 {
+			swapped := false
+			for r := c + 1; r < n; r++ {
+				if work[r][c] != 0 {
+					work[c], work[r] = work[r], work[c]
+					swapped = true
+					break
+				}
+			}
+			if !swapped {
+				return nil, errSingularMatrix
+			}
+		}
+
+		// Scale the row so that the pivot is 1
+		if work[c][c] != 1 {
+			inv := gfInv(work[c][c])
+			for i := range work[c] {
+				work[c][i] = gfMul(work[c][i], inv)
+			}
+		}
+
+		// Clear the column in all other rows
+		for r := 0; r < n; r++ {
+			if r != c && work[r][c] != 0 {
+				mulAdd(work[r][c], work[c], work[r])
+			}
+		}
+	}
+
+	res := newMatrix(n, n)
+	for r := 0; r < n; r++ {
+		copy(res[r], work[r][n:])
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package erasure
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+// ManifestVersion is the version of the manifest format
+const ManifestVersion = 1
+
+// Manifest describes how a dataset was erasure coded, and where each of its
+// shards is stored
+type Manifest struct {
+	Version int `json:"version"`
+	// The name of the original file
+	Name string `json:"name"`
+	// The number of data shards (k) and parity shards (m)
+	DataShards   int `json:"dataShards"`
+	ParityShards int `json:"parityShards"`
+	BlockSize    int `json:"blockSize"`
+	// The size and sha256 of the original data
+	DataSize   int64     `json:"dataSize"`
+	DataSha256 string    `json:"dataSha256"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Shards     []Shard   `json:"shards"`
+}
+
+// Shard is a single data or parity shard, stored as a CAR file with a
+// storage provider
+type Shard struct {
+	Index int `json:"index"`
+	// The size and sha256 of the shard data (before it is packed into a
+	// CAR file)
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// The local path of the shard's CAR file
+	Path       string              `json:"path"`
+	PayloadCid cid.Cid             `json:"payloadCid"`
+	PieceCid   cid.Cid             `json:"pieceCid"`
+	PieceSize  abi.PaddedPieceSize `json:"pieceSize"`
+	CarSize    uint64              `json:"carSize"`
+	// The storage provider that the shard is (to be) stored with
+	Provider string `json:"provider"`
+	// The deal for the shard, once it has been proposed
+	DealUUID  *uuid.UUID `json:"dealUuid,omitempty"`
+	DealError string     `json:"dealError,omitempty"`
+}
+
+// IsParity is true if the shard is a parity shard
+func (m *Manifest) IsParity(s Shard) bool {
+	return s.Index >= m.DataShards
+}
+
+// Codec returns the codec that the dataset was encoded with
+func (m *Manifest) Codec() (*Codec, error) {
+	return New(m.DataShards, m.ParityShards, m.BlockSize)
+}
+
+// LoadManifest reads a manifest from a file
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	if m.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d (expected %d)", m.Version, ManifestVersion)
+	}
+	if len(m.Shards) != m.DataShards+m.ParityShards {
+		return nil, fmt.Errorf("manifest has %d shards, expected %d data + %d parity shards",
+			len(m.Shards), m.DataShards, m.ParityShards)
+	}
+	for i, s := range m.Shards {
+		if s.Index != i {
+			return nil, fmt.Errorf("manifest shard %d has index %d", i, s.Index)
+		}
+	}
+	return &m, nil
+}
+
+// Save writes the manifest to a file
+func (m *Manifest) Save(path string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling manifest: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package erasure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipld/go-car/v2/blockstore"
+)
+
+const (
+	unixfsChunkSize     = 1 << 20
+	unixfsLinksPerLevel = 1024
+)
+
+// WriteShardCar packs the shard data at shardPath into a unixfs file and
+// writes it to a CARv1 file at carPath, so that the shard can be stored and
+// retrieved like any other deal payload. It returns the root cid.
+func WriteShardCar(ctx context.Context, shardPath string, carPath string) (cid.Cid, error) {
+	// The root cid is needed for the CAR header before any blocks are
+	// written, so build the DAG once without storing it to get the root
+	root, err := buildShardDag(ctx, shardPath, discardDAG{})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	rw, err := blockstore.OpenReadWrite(carPath, []cid.Cid{root}, blockstore.WriteAsCarV1(true))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("creating CAR file %s: %w", carPath, err)
+	}
+	dserv := merkledag.NewDAGService(blockservice.New(rw, nil))
+	root2, err := buildShardDag(ctx, shardPath, dserv)
+	if err != nil {
+		rw.Discard()
+		return cid.Undef, err
+	}
+	if err := rw.Finalize(); err != nil {
+		return cid.Undef, fmt.Errorf("finalizing CAR file %s: %w", carPath, err)
+	}
+	if !root.Equals(root2) {
+		return cid.Undef, fmt.Errorf("shard DAG root mismatch: %s != %s", root, root2)
+	}
+	return root, nil
+}
+
+func buildShardDag(ctx context.Context, shardPath string, dserv ipldformat.DAGService) (cid.Cid, error) {
+	f, err := os.Open(shardPath)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("opening shard: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, dserv)
+	params := ihelper.DagBuilderParams{
+		Maxlinks:   unixfsLinksPerLevel,
+		RawLeaves:  true,
+		CidBuilder: prefix,
+		Dagserv:    bufferedDS,
+	}
+	db, err := params.New(chunk.NewSizeSplitter(f, unixfsChunkSize))
+	if err != nil {
+		return cid.Undef, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("building shard DAG: %w", err)
+	}
+	if err := bufferedDS.Commit(); err != nil {
+		return cid.Undef, fmt.Errorf("building shard DAG: %w", err)
+	}
+	return nd.Cid(), nil
+}
+
+// OpenShardCar returns a reader for the shard data in a CAR file written by
+// WriteShardCar (or retrieved from a storage provider)
+func OpenShardCar(ctx context.Context, carPath string, root cid.Cid) (io.ReadCloser, error) {
+	bs, err := blockstore.OpenReadOnly(carPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening CAR file %s: %w", carPath, err)
+	}
+
+	dserv := merkledag.NewDAGService(blockservice.New(bs, nil))
+	nd, err := dserv.Get(ctx, root)
+	if err != nil {
+		_ = bs.Close()
+		return nil, fmt.Errorf("getting shard root %s: %w", root, err)
+	}
+	node, err := unixfile.NewUnixfsFile(ctx, dserv, nd)
+	if err != nil {
+		_ = bs.Close()
+		return nil, fmt.Errorf("reading shard root %s: %w", root, err)
+	}
+	f, ok := node.(files.File)
+	if !ok {
+		_ = bs.Close()
+		return nil, fmt.Errorf("shard root %s is not a file", root)
+	}
+	return &shardReader{File: f, bs: bs}, nil
+}
+
+type shardReader struct {
+	files.File
+	bs *blockstore.ReadOnly
+}
+
+func (r *shardReader) Close() error {
+	_ = r.File.Close()
+	return r.bs.Close()
+}
+
+// discardDAG is a DAG service that drops all nodes. It is used to compute
+// the root of a DAG without storing it.
+type discardDAG struct{}
+
+var _ ipldformat.DAGService = discardDAG{}
+
+func (discardDAG) Get(ctx context.Context, c cid.Cid) (ipldformat.Node, error) {
+	return nil, ipldformat.ErrNotFound{Cid: c}
+}
+
+func (discardDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipldformat.NodeOption {
+	ch := make(chan *ipldformat.NodeOption, len(cids))
+	for _, c := range cids {
+		ch <- &ipldformat.NodeOption{Err: ipldformat.ErrNotFound{Cid: c}}
+	}
+	close(ch)
+	return ch
+}
+
+func (discardDAG) Add(context.Context, ipldformat.Node) error       { return nil }
+func (discardDAG) AddMany(context.Context, []ipldformat.Node) error { return nil }
+func (discardDAG) Remove(context.Context, cid.Cid) error            { return nil }
+func (discardDAG) RemoveMany(context.Context, []cid.Cid) error      { return nil }
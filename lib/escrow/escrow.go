@@ -0,0 +1,202 @@
+// Package escrow decides how the client tops up its storage market escrow
+// before proposing a deal.
+//
+// By default the client makes sure that its available escrow covers the
+// deal before sending the proposal, and if it needs to add funds it waits
+// for the add balance message to land on chain. For small deals with
+// providers on the client's trust list the client takes a fast path: it
+// adds funds in batches that cover many deals, and doesn't wait for a
+// top-up to land before proposing. Because each top-up covers many deals,
+// most fast path deals don't need a top-up at all; a deal proposed before a
+// top-up lands may be rejected by the provider, which is a risk the client
+// only takes with providers it trusts.
+package escrow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+)
+
+// TrustList configures the providers that small deals take the fast path with
+type TrustList struct {
+	// The addresses of the trusted providers, eg "f01000"
+	Providers []string `json:"providers"`
+	// Deals with a padded piece size up to this size take the fast path.
+	// If zero, DefaultMaxPieceSize is used.
+	MaxPieceSize abi.PaddedPieceSize `json:"maxPieceSize,omitempty"`
+	// When escrow needs to be topped up for a fast path deal, add at least
+	// this amount (eg "0.5 FIL") so that one message covers many deals
+	TopUpAmount string `json:"topUpAmount,omitempty"`
+
+	providers map[address.Address]struct{}
+	topUp     abi.TokenAmount
+}
+
+// DefaultMaxPieceSize is the default maximum piece size for fast path deals
+const DefaultMaxPieceSize = abi.PaddedPieceSize(1 << 30)
+
+// LoadTrustList reads the trust list from the json file at path. If the file
+// doesn't exist, an empty trust list is returned.
+func LoadTrustList(path string) (*TrustList, error) {
+	l := &TrustList{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return l, l.init()
+		}
+		return nil, fmt.Errorf("reading provider trust list: %w", err)
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("parsing provider trust list %s: %w", path, err)
+	}
+	if err := l.init(); err != nil {
+		return nil, fmt.Errorf("provider trust list %s: %w", path, err)
+	}
+	return l, nil
+}
+
+func (l *TrustList) init() error {
+	l.providers = make(map[address.Address]struct{}, len(l.Providers))
+	for _, p := range l.Providers {
+		a, err := address.NewFromString(p)
+		if err != nil {
+			return fmt.Errorf("parsing provider address '%s': %w", p, err)
+		}
+		l.providers[a] = struct{}{}
+	}
+	if l.MaxPieceSize == 0 {
+		l.MaxPieceSize = DefaultMaxPieceSize
+	}
+	l.topUp = big.Zero()
+	if l.TopUpAmount != "" {
+		f, err := types.ParseFIL(l.TopUpAmount)
+		if err != nil {
+			return fmt.Errorf("parsing top up amount '%s': %w", l.TopUpAmount, err)
+		}
+		l.topUp = abi.TokenAmount(f)
+	}
+	return nil
+}
+
+// FastPath returns true if a deal with the provider for a piece of the given
+// size should take the fast path
+func (l *TrustList) FastPath(provider address.Address, pieceSize abi.PaddedPieceSize) bool {
+	_, ok := l.providers[provider]
+	return ok && pieceSize <= l.MaxPieceSize
+}
+
+// Plan describes how to top up escrow before proposing a deal
+type Plan struct {
+	// The amount to add to escrow (zero if no top up is needed)
+	TopUp abi.TokenAmount
+	// Whether to wait for the top up to land on chain before proposing
+	Wait bool
+}
+
+// PlanTopUp works out how much to add to escrow so that the available
+// balance covers the deal. Top ups that have been sent but haven't landed
+// yet count towards the balance on the fast path.
+func (l *TrustList) PlanTopUp(fast bool, available, pending, required abi.TokenAmount) Plan {
+	if !fast {
+		if available.GreaterThanEqual(required) {
+			return Plan{TopUp: big.Zero()}
+		}
+		return Plan{TopUp: big.Sub(required, available), Wait: true}
+	}
+
+	expected := big.Add(available, pending)
+	if expected.GreaterThanEqual(required) {
+		return Plan{TopUp: big.Zero()}
+	}
+	return Plan{TopUp: big.Max(big.Sub(required, expected), l.topUp)}
+}
+
+// TopUp is an add balance message that was sent on the fast path
+type TopUp struct {
+	Cid    cid.Cid         `json:"cid"`
+	Amount abi.TokenAmount `json:"amount"`
+	SentAt time.Time       `json:"sentAt"`
+}
+
+// PendingTopUps keeps track of top ups that haven't landed on chain yet, so
+// that consecutive fast path deals don't each send a top up
+type PendingTopUps struct {
+	path   string
+	TopUps []TopUp `json:"topUps"`
+}
+
+// pendingTopUpExpiry is the time after which a top up that hasn't been
+// found on chain is assumed to have failed
+const pendingTopUpExpiry = time.Hour
+
+// LoadPendingTopUps reads the pending top ups from the json file at path
+func LoadPendingTopUps(path string) (*PendingTopUps, error) {
+	p := &PendingTopUps{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		}
+		return nil, fmt.Errorf("reading pending escrow top ups: %w", err)
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("parsing pending escrow top ups %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Prune removes top ups that have landed on chain (according to landed) or
+// that have expired
+func (p *PendingTopUps) Prune(now time.Time, landed func(c cid.Cid) (bool, error)) error {
+	kept := p.TopUps[:0]
+	for _, t := range p.TopUps {
+		if now.Sub(t.SentAt) > pendingTopUpExpiry {
+			continue
+		}
+		ok, err := landed(t.Cid)
+		if err != nil {
+			return fmt.Errorf("checking if escrow top up %s landed: %w", t.Cid, err)
+		}
+		if !ok {
+			kept = append(kept, t)
+		}
+	}
+	p.TopUps = kept
+	return nil
+}
+
+// Total returns the sum of the pending top ups
+func (p *PendingTopUps) Total() abi.TokenAmount {
+	total := big.Zero()
+	for _, t := range p.TopUps {
+		total = big.Add(total, t.Amount)
+	}
+	return total
+}
+
+// Add records a top up that was sent, and saves the pending top ups
+func (p *PendingTopUps) Add(t TopUp) error {
+	p.TopUps = append(p.TopUps, t)
+	return p.Save()
+}
+
+// Save writes the pending top ups to the file they were loaded from
+func (p *PendingTopUps) Save() error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing pending escrow top ups: %w", err)
+	}
+	if err := os.WriteFile(p.path, b, 0644); err != nil {
+		return fmt.Errorf("writing pending escrow top ups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package escrow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustList(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+
+	// With no trust list, deals never take the fast path
+	empty, err := LoadTrustList(filepath.Join(dir, "missing.json"))
+	req.NoError(err)
+	trusted, err := address.NewFromString("f01000")
+	req.NoError(err)
+	req.False(empty.FastPath(trusted, 1024))
+
+	path := filepath.Join(dir, "trusted-providers.json")
+	req.NoError(os.WriteFile(path, []byte(`{"providers": ["f01000"], "maxPieceSize": 1048576, "topUpAmount": "1 FIL"}`), 0644))
+	l, err := LoadTrustList(path)
+	req.NoError(err)
+
+	other, err := address.NewFromString("f01001")
+	req.NoError(err)
+	req.True(l.FastPath(trusted, 1<<20))
+	req.False(l.FastPath(trusted, 1<<21))
+	req.False(l.FastPath(other, 1024))
+
+	fil := func(f int64) abi.TokenAmount { return big.Mul(big.NewInt(f), big.NewInt(1e18)) }
+	nano := func(n int64) abi.TokenAmount { return big.NewInt(n) }
+
+	// The standard path tops up the shortfall and waits for it to land
+	plan := l.PlanTopUp(false, nano(10), nano(0), nano(30))
+	req.True(plan.Wait)
+	req.Equal(nano(20), plan.TopUp)
+	plan = l.PlanTopUp(false, nano(30), nano(0), nano(30))
+	req.True(plan.TopUp.IsZero())
+
+	// The fast path tops up a batch and doesn't wait
+	plan = l.PlanTopUp(true, nano(10), nano(0), nano(30))
+	req.False(plan.Wait)
+	req.Equal(fil(1), plan.TopUp)
+
+	// Pending top ups count towards the balance on the fast path
+	plan = l.PlanTopUp(true, nano(10), fil(1), nano(30))
+	req.True(plan.TopUp.IsZero())
+
+	// Invalid trust lists are rejected
+	req.NoError(os.WriteFile(path, []byte(`{"providers": ["not-an-address"]}`), 0644))
+	_, err = LoadTrustList(path)
+	req.Error(err)
+}
+
+func TestPendingTopUps(t *testing.T) {
+	req := require.New(t)
+	path := filepath.Join(t.TempDir(), "topups.json")
+
+	p, err := LoadPendingTopUps(path)
+	req.NoError(err)
+	req.Equal(big.Zero(), p.Total())
+
+	now := time.Now()
+	cids := testutil.GenerateCids(3)
+	req.NoError(p.Add(TopUp{Cid: cids[0], Amount: big.NewInt(10), SentAt: now}))
+	req.NoError(p.Add(TopUp{Cid: cids[1], Amount: big.NewInt(20), SentAt: now}))
+	req.NoError(p.Add(TopUp{Cid: cids[2], Amount: big.NewInt(40), SentAt: now.Add(-2 * time.Hour)}))
+
+	p, err = LoadPendingTopUps(path)
+	req.NoError(err)
+	req.Equal(big.NewInt(70), p.Total())
+
+	// Top ups that landed or expired are removed
+	req.NoError(p.Prune(now, func(c cid.Cid) (bool, error) {
+		return c.Equals(cids[0]), nil
+	}))
+	req.Len(p.TopUps, 1)
+	req.Equal(big.NewInt(20), p.Total())
+}
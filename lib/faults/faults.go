@@ -0,0 +1,165 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("faults")
+
+var ErrDisabled = errors.New("fault injection is disabled: set Testing.EnableFaultInjection in the config file to enable it")
+
+// Faults are the failures that will be injected.
+// Counts are decremented each time a failure is injected.
+type Faults struct {
+	// Drop the next N data transfer vouchers received from retrieval clients
+	DropVouchers int
+	// Delay each response to a deal proposal or deal status request
+	ResponseDelay time.Duration
+	// Corrupt the next N blocks served from the blockstore
+	CorruptBlocks int
+}
+
+// Injector injects failures into the provider so that clients can test
+// their retry and repair logic. It should only be enabled in staging
+// environments.
+type Injector struct {
+	enabled bool
+
+	lk     sync.Mutex
+	faults Faults
+}
+
+func New(enabled bool) *Injector {
+	return &Injector{enabled: enabled}
+}
+
+func (i *Injector) Enabled() bool {
+	return i != nil && i.enabled
+}
+
+// Get returns the faults that are still to be injected
+func (i *Injector) Get() Faults {
+	if !i.Enabled() {
+		return Faults{}
+	}
+
+	i.lk.Lock()
+	defer i.lk.Unlock()
+	return i.faults
+}
+
+// Set replaces the faults that will be injected
+func (i *Injector) Set(f Faults) error {
+	if !i.Enabled() {
+		return ErrDisabled
+	}
+	if f.DropVouchers < 0 || f.CorruptBlocks < 0 || f.ResponseDelay < 0 {
+		return errors.New("fault counts and delays must not be negative")
+	}
+
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	log.Warnw("setting injected faults", "drop-vouchers", f.DropVouchers, "response-delay", f.ResponseDelay, "corrupt-blocks", f.CorruptBlocks)
+	i.faults = f
+	return nil
+}
+
+// DropVoucher returns true if the next voucher should be dropped
+func (i *Injector) DropVoucher() bool {
+	if !i.Enabled() {
+		return false
+	}
+
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	if i.faults.DropVouchers == 0 {
+		return false
+	}
+	i.faults.DropVouchers--
+	return true
+}
+
+// Delay waits for the configured response delay, or until the context is
+// cancelled
+func (i *Injector) Delay(ctx context.Context) {
+	if !i.Enabled() {
+		return
+	}
+
+	i.lk.Lock()
+	delay := i.faults.ResponseDelay
+	i.lk.Unlock()
+
+	if delay == 0 {
+		return
+	}
+
+	log.Warnw("delaying response", "delay", delay)
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
+
+// MaybeCorrupt returns a corrupted copy of the block data if a block
+// corruption is pending, otherwise it returns the data unchanged
+func (i *Injector) MaybeCorrupt(data []byte) []byte {
+	if !i.Enabled() || len(data) == 0 {
+		return data
+	}
+
+	i.lk.Lock()
+	if i.faults.CorruptBlocks == 0 {
+		i.lk.Unlock()
+		return data
+	}
+	i.faults.CorruptBlocks--
+	i.lk.Unlock()
+
+	log.Warnw("corrupting block", "size", len(data))
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+	corrupted[len(corrupted)/2] ^= 0xff
+	return corrupted
+}
+
+// WrapDataTransfer wraps a data transfer manager such that vouchers sent to
+// revalidators registered with the manager may be dropped
+func (i *Injector) WrapDataTransfer(dt datatransfer.Manager) datatransfer.Manager {
+	if !i.Enabled() {
+		return dt
+	}
+	return &faultyManager{Manager: dt, inj: i}
+}
+
+type faultyManager struct {
+	datatransfer.Manager
+	inj *Injector
+}
+
+func (m *faultyManager) RegisterRevalidator(voucherType datatransfer.Voucher, revalidator datatransfer.Revalidator) error {
+	return m.Manager.RegisterRevalidator(voucherType, &faultyRevalidator{Revalidator: revalidator, inj: m.inj})
+}
+
+type faultyRevalidator struct {
+	datatransfer.Revalidator
+	inj *Injector
+}
+
+func (r *faultyRevalidator) Revalidate(chid datatransfer.ChannelID, voucher datatransfer.Voucher) (datatransfer.VoucherResult, error) {
+	if r.inj.DropVoucher() {
+		// Ignore the voucher, leaving the transfer paused as if the voucher
+		// was never received
+		log.Warnw("dropping voucher", "channel-id", chid, "voucher-type", voucher.Type())
+		return nil, datatransfer.ErrPause
+	}
+	return r.Revalidator.Revalidate(chid, voucher)
+}
@@ -0,0 +1,41 @@
+package faults
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectorDisabled(t *testing.T) {
+	inj := New(false)
+	require.ErrorIs(t, inj.Set(Faults{DropVouchers: 1}), ErrDisabled)
+	require.False(t, inj.DropVoucher())
+
+	data := []byte("hello")
+	require.Equal(t, data, inj.MaybeCorrupt(data))
+}
+
+func TestInjector(t *testing.T) {
+	inj := New(true)
+	require.NoError(t, inj.Set(Faults{DropVouchers: 2, CorruptBlocks: 1, ResponseDelay: 50 * time.Millisecond}))
+
+	// Vouchers are dropped until the count is used up
+	require.True(t, inj.DropVoucher())
+	require.True(t, inj.DropVoucher())
+	require.False(t, inj.DropVoucher())
+
+	// Only the first block is corrupted, and the original data is unchanged
+	data := []byte("hello")
+	corrupted := inj.MaybeCorrupt(data)
+	require.NotEqual(t, data, corrupted)
+	require.Equal(t, []byte("hello"), data)
+	require.Equal(t, data, inj.MaybeCorrupt(data))
+
+	start := time.Now()
+	inj.Delay(context.Background())
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	require.Equal(t, Faults{ResponseDelay: 50 * time.Millisecond}, inj.Get())
+}
@@ -0,0 +1,176 @@
+// Package features gates experimental subsystems behind feature flags, so
+// that they can ship incrementally and operators can enable them
+// selectively. Flags are set in the config file, and can be changed at
+// runtime through the API (runtime changes last until the next restart).
+package features
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("features")
+
+const (
+	// HttpTransferCompression negotiates zstd compression of deal data with
+	// the client's http server
+	HttpTransferCompression = "http-transfer-compression"
+	// HttpTransferMirrors pulls deal data from the closest of the mirrors
+	// supplied by the client, and fails over between them
+	HttpTransferMirrors = "http-transfer-mirrors"
+	// HttpTransferChecksums verifies per-chunk checksums of deal data sent
+	// by the client's http server, and fetches corrupted chunks again
+	HttpTransferChecksums = "http-transfer-checksums"
+	// TcpTransfers accepts deals whose data is transferred over raw TLS/TCP
+	TcpTransfers = "tcp-transfers"
+	// S3Transfers accepts deals whose data is pulled from S3-compatible
+	// stores
+	S3Transfers = "s3-transfers"
+	// OfflineDealUpload accepts the data for offline deals uploaded to the
+	// staging area over the API
+	OfflineDealUpload = "offline-deal-upload"
+)
+
+// Feature describes an experimental feature that can be enabled or disabled
+type Feature struct {
+	Name        string
+	Description string
+	// Whether the feature is enabled if it's not set in the config
+	Default bool
+}
+
+// Known is the list of features that can be enabled or disabled
+var Known = []Feature{{
+	Name:        HttpTransferCompression,
+	Description: "Ask the client to compress deal data transferred over http with zstd",
+	Default:     false,
+}, {
+	Name:        HttpTransferMirrors,
+	Description: "Pull deal data from the closest of the http mirrors supplied by the client",
+	Default:     true,
+}, {
+	Name:        HttpTransferChecksums,
+	Description: "Verify per-chunk checksums of deal data transferred over http, and fetch corrupted chunks again",
+	Default:     true,
+}, {
+	Name:        TcpTransfers,
+	Description: "Accept deals with the tcp transfer type (if EnableTcpTransfers is set in the Dealmaking config)",
+	Default:     true,
+}, {
+	Name:        S3Transfers,
+	Description: "Accept deals with the s3 transfer type (if S3Transfers are enabled in the Dealmaking config)",
+	Default:     true,
+}, {
+	Name:        OfflineDealUpload,
+	Description: "Accept the data for offline deals uploaded to the staging area over the API",
+	Default:     true,
+}}
+
+func lookup(name string) (Feature, bool) {
+	for _, f := range Known {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Feature{}, false
+}
+
+// Status is the current state of a feature
+type Status struct {
+	Feature
+	Enabled bool
+}
+
+// Flags keeps track of which features are enabled
+type Flags struct {
+	lk      sync.RWMutex
+	enabled map[string]bool
+}
+
+// New returns flags with the default features, and the features in enable
+// and disable enabled and disabled respectively
+func New(enable []string, disable []string) (*Flags, error) {
+	f := &Flags{enabled: make(map[string]bool, len(Known))}
+	for _, k := range Known {
+		f.enabled[k.Name] = k.Default
+	}
+	for _, name := range enable {
+		if _, ok := lookup(name); !ok {
+			return nil, fmt.Errorf("unknown feature '%s' in Features.Enable", name)
+		}
+		f.enabled[name] = true
+	}
+	for _, name := range disable {
+		if _, ok := lookup(name); !ok {
+			return nil, fmt.Errorf("unknown feature '%s' in Features.Disable", name)
+		}
+		f.enabled[name] = false
+	}
+	return f, nil
+}
+
+// Enabled returns true if the feature is enabled. If f is nil, it returns
+// the feature's default.
+func (f *Flags) Enabled(name string) bool {
+	if f == nil {
+		k, _ := lookup(name)
+		return k.Default
+	}
+
+	f.lk.RLock()
+	defer f.lk.RUnlock()
+	return f.enabled[name]
+}
+
+// DisabledError is returned by a subsystem that is gated by a feature when
+// it is used while the feature is disabled
+type DisabledError struct {
+	Feature string
+}
+
+func (e *DisabledError) Error() string {
+	return fmt.Sprintf("experimental feature '%s' is disabled", e.Feature)
+}
+
+// Check returns a DisabledError if the feature is not enabled, so that the
+// subsystems that are gated by the feature can refuse to be used
+func (f *Flags) Check(name string) error {
+	if !f.Enabled(name) {
+		return &DisabledError{Feature: name}
+	}
+	return nil
+}
+
+// Set enables or disables a feature at runtime
+func (f *Flags) Set(name string, enabled bool) error {
+	if _, ok := lookup(name); !ok {
+		return fmt.Errorf("unknown feature '%s'", name)
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	if f.enabled[name] != enabled {
+		log.Infow("setting feature flag", "feature", name, "enabled", enabled)
+	}
+	f.enabled[name] = enabled
+	return nil
+}
+
+// List returns the status of all known features, ordered by name
+func (f *Flags) List() []Status {
+	f.lk.RLock()
+	defer f.lk.RUnlock()
+
+	st := make([]Status, 0, len(Known))
+	for _, k := range Known {
+		st = append(st, Status{Feature: k, Enabled: f.enabled[k.Name]})
+	}
+	sort.Slice(st, func(i, j int) bool {
+		return st[i].Name < st[j].Name
+	})
+	return st
+}
@@ -0,0 +1,50 @@
+package features
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlags(t *testing.T) {
+	req := require.New(t)
+
+	// Features have their defaults unless set in the config
+	f, err := New(nil, nil)
+	req.NoError(err)
+	req.False(f.Enabled(HttpTransferCompression))
+	req.True(f.Enabled(HttpTransferMirrors))
+
+	f, err = New([]string{HttpTransferCompression}, []string{HttpTransferMirrors})
+	req.NoError(err)
+	req.True(f.Enabled(HttpTransferCompression))
+	req.False(f.Enabled(HttpTransferMirrors))
+
+	// Features can be changed at runtime
+	req.NoError(f.Set(HttpTransferMirrors, true))
+	req.True(f.Enabled(HttpTransferMirrors))
+	st := f.List()
+	req.Len(st, len(Known))
+	req.Equal(HttpTransferChecksums, st[0].Name)
+	req.True(st[0].Default)
+	req.Equal(HttpTransferCompression, st[1].Name)
+	req.True(st[1].Enabled)
+	req.False(st[1].Default)
+
+	// Gated subsystems refuse to be used while their feature is disabled
+	req.NoError(f.Check(TcpTransfers))
+	req.NoError(f.Set(TcpTransfers, false))
+	var derr *DisabledError
+	req.ErrorAs(f.Check(TcpTransfers), &derr)
+	req.Equal(TcpTransfers, derr.Feature)
+
+	// Unknown features are rejected
+	req.Error(f.Set("no-such-feature", true))
+	_, err = New([]string{"no-such-feature"}, nil)
+	req.Error(err)
+
+	// Nil flags return the defaults
+	var nilFlags *Flags
+	req.True(nilFlags.Enabled(HttpTransferMirrors))
+	req.False(nilFlags.Enabled(HttpTransferCompression))
+}
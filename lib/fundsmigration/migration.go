@@ -0,0 +1,215 @@
+// Package fundsmigration migrates the client funds that were reserved by the
+// legacy markets client (and recorded in the metadata datastore) to the
+// fund manager of the full node.
+package fundsmigration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("fundsmigration")
+
+var ErrNothingToMigrate = errors.New("there are no legacy client funds to migrate")
+
+var (
+	// The key under which the legacy markets client recorded the amount of
+	// funds reserved for the default wallet
+	legacyClientFundsKey = datastore.NewKey("/marketfunds/client")
+	// The key under which the result of the migration is stored
+	statusKey = datastore.NewKey("/marketfunds/migration/client")
+)
+
+const (
+	// There are no legacy funds and the migration has never been applied
+	StateNone = "none"
+	// There are legacy funds that have not yet been migrated
+	StatePending = "pending"
+	// The funds were reserved with the full node's fund manager
+	StateApplied = "applied"
+	// The last attempt to migrate the funds failed
+	StateFailed = "failed"
+)
+
+// Status is the status of the client funds migration
+type Status struct {
+	State string
+	// The amount of funds to migrate (or that were migrated)
+	Amount abi.TokenAmount
+	// The wallet that the funds are (or were) reserved for
+	Wallet address.Address
+	// The message that added the funds to escrow, if one was needed
+	Message *cid.Cid
+	// The error from the last failed attempt
+	Error string
+	// True if the migration was not actually applied
+	DryRun    bool
+	UpdatedAt time.Time
+}
+
+type migrationAPI interface {
+	MarketReserveFunds(ctx context.Context, wallet address.Address, addr address.Address, amt types.BigInt) (cid.Cid, error)
+	WalletDefaultAddress(ctx context.Context) (address.Address, error)
+}
+
+// Migration reserves the legacy client funds with the full node's fund
+// manager, and records the result so that the status can be queried
+type Migration struct {
+	ds  datastore.Datastore
+	api migrationAPI
+
+	lk sync.Mutex
+}
+
+func New(ds datastore.Datastore, api migrationAPI) *Migration {
+	return &Migration{ds: ds, api: api}
+}
+
+// Status returns the status of the migration
+func (m *Migration) Status(ctx context.Context) (*Status, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	return m.status(ctx)
+}
+
+func (m *Migration) status(ctx context.Context) (*Status, error) {
+	st, err := m.savedStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	amt, err := m.legacyFunds(ctx)
+	if err != nil {
+		if !errors.Is(err, ErrNothingToMigrate) {
+			return nil, err
+		}
+		if st == nil {
+			return &Status{State: StateNone, Amount: big.Zero()}, nil
+		}
+		return st, nil
+	}
+
+	if st == nil || st.State == StateApplied {
+		// The legacy funds are still present (eg they were added to the
+		// datastore after a previous migration was applied)
+		return &Status{State: StatePending, Amount: amt}, nil
+	}
+	st.Amount = amt
+	return st, nil
+}
+
+// Migrate reserves the legacy client funds for the given wallet. If the
+// wallet is undefined, the full node's default wallet is used (the legacy
+// markets client reserved funds for the default wallet).
+// If dryRun is true, Migrate returns the amount that would be reserved
+// without reserving it.
+func (m *Migration) Migrate(ctx context.Context, wallet address.Address, dryRun bool) (*Status, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	amt, err := m.legacyFunds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if wallet == address.Undef {
+		wallet, err = m.api.WalletDefaultAddress(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting default wallet address: %w", err)
+		}
+		if wallet == address.Undef {
+			return nil, fmt.Errorf("there is no default wallet: specify the wallet to migrate the funds to")
+		}
+	}
+
+	st := &Status{
+		State:     StatePending,
+		Amount:    amt,
+		Wallet:    wallet,
+		DryRun:    dryRun,
+		UpdatedAt: time.Now(),
+	}
+	if dryRun {
+		return st, nil
+	}
+
+	msgCid, err := m.api.MarketReserveFunds(ctx, wallet, wallet, amt)
+	if err != nil {
+		st.State = StateFailed
+		st.Error = err.Error()
+		if serr := m.saveStatus(ctx, st); serr != nil {
+			log.Errorw("saving client funds migration status", "err", serr)
+		}
+		return st, fmt.Errorf("reserving %s for wallet %s: %w", types.FIL(amt), wallet, err)
+	}
+
+	st.State = StateApplied
+	if msgCid != cid.Undef {
+		st.Message = &msgCid
+	}
+	if err := m.saveStatus(ctx, st); err != nil {
+		return nil, err
+	}
+	if err := m.ds.Delete(ctx, legacyClientFundsKey); err != nil {
+		return nil, fmt.Errorf("deleting legacy client funds record: %w", err)
+	}
+	log.Infow("migrated legacy client funds", "wallet", wallet, "amount", types.FIL(amt), "msg", msgCid)
+	return st, nil
+}
+
+func (m *Migration) legacyFunds(ctx context.Context) (abi.TokenAmount, error) {
+	b, err := m.ds.Get(ctx, legacyClientFundsKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return abi.TokenAmount{}, ErrNothingToMigrate
+		}
+		return abi.TokenAmount{}, fmt.Errorf("getting legacy client funds record: %w", err)
+	}
+
+	var value abi.TokenAmount
+	if err := value.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return abi.TokenAmount{}, fmt.Errorf("unmarshalling legacy client funds record: %w", err)
+	}
+	return value, nil
+}
+
+func (m *Migration) savedStatus(ctx context.Context) (*Status, error) {
+	b, err := m.ds.Get(ctx, statusKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting client funds migration status: %w", err)
+	}
+
+	var st Status
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("unmarshalling client funds migration status: %w", err)
+	}
+	return &st, nil
+}
+
+func (m *Migration) saveStatus(ctx context.Context, st *Status) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("marshalling client funds migration status: %w", err)
+	}
+	if err := m.ds.Put(ctx, statusKey, b); err != nil {
+		return fmt.Errorf("saving client funds migration status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package fundsmigration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAPI struct {
+	defaultWallet address.Address
+	err           error
+	reserved      map[address.Address]abi.TokenAmount
+}
+
+func (m *mockAPI) MarketReserveFunds(ctx context.Context, wallet address.Address, addr address.Address, amt types.BigInt) (cid.Cid, error) {
+	if m.err != nil {
+		return cid.Undef, m.err
+	}
+	m.reserved[addr] = amt
+	return cid.Undef, nil
+}
+
+func (m *mockAPI) WalletDefaultAddress(ctx context.Context) (address.Address, error) {
+	return m.defaultWallet, nil
+}
+
+func TestMigration(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	defaultWallet, err := address.NewIDAddress(1)
+	req.NoError(err)
+	otherWallet, err := address.NewIDAddress(2)
+	req.NoError(err)
+
+	ds := datastore.NewMapDatastore()
+	api := &mockAPI{defaultWallet: defaultWallet, reserved: make(map[address.Address]abi.TokenAmount)}
+	m := New(ds, api)
+
+	// Nothing to migrate
+	st, err := m.Status(ctx)
+	req.NoError(err)
+	req.Equal(StateNone, st.State)
+	_, err = m.Migrate(ctx, address.Undef, false)
+	req.ErrorIs(err, ErrNothingToMigrate)
+
+	// Record legacy funds
+	amt := abi.NewTokenAmount(1000)
+	var buf bytes.Buffer
+	req.NoError(amt.MarshalCBOR(&buf))
+	req.NoError(ds.Put(ctx, legacyClientFundsKey, buf.Bytes()))
+
+	st, err = m.Status(ctx)
+	req.NoError(err)
+	req.Equal(StatePending, st.State)
+	req.Equal(amt, st.Amount)
+
+	// A dry run returns the amount and wallet without reserving funds
+	st, err = m.Migrate(ctx, address.Undef, true)
+	req.NoError(err)
+	req.True(st.DryRun)
+	req.Equal(defaultWallet, st.Wallet)
+	req.Equal(amt, st.Amount)
+	req.Empty(api.reserved)
+
+	// A failed migration is recorded
+	api.err = fmt.Errorf("not enough funds")
+	_, err = m.Migrate(ctx, otherWallet, false)
+	req.Error(err)
+	st, err = m.Status(ctx)
+	req.NoError(err)
+	req.Equal(StateFailed, st.State)
+	req.Contains(st.Error, "not enough funds")
+
+	// Migrate to a non-default wallet
+	api.err = nil
+	st, err = m.Migrate(ctx, otherWallet, false)
+	req.NoError(err)
+	req.Equal(StateApplied, st.State)
+	req.Equal(amt, api.reserved[otherWallet])
+
+	st, err = m.Status(ctx)
+	req.NoError(err)
+	req.Equal(StateApplied, st.State)
+	req.Equal(otherWallet, st.Wallet)
+	req.Equal(amt, st.Amount)
+
+	// The legacy record is removed so the funds can't be migrated twice
+	_, err = m.Migrate(ctx, otherWallet, false)
+	req.ErrorIs(err, ErrNothingToMigrate)
+}
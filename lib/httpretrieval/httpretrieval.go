@@ -0,0 +1,237 @@
+// Package httpretrieval retrieves the DAG under a payload cid from a
+// provider's booster-http endpoint, block by block, into a blockstore.
+//
+// Payment for a paid retrieval is negotiated out-of-band: the client sets up
+// a payment channel with the provider on chain, and pays for the retrieval
+// as it goes. An endpoint that requires payment responds to requests without
+// a voucher with status 402 (Payment Required) and its payment terms. The
+// client then requests the CAR file in byte ranges of at most the payment
+// interval, sending with each request a voucher that pays for its bytes (see
+// Payments).
+package httpretrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/filecoin-project/go-state-types/big"
+	paychtypes "github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+var log = logging.Logger("httpretrieval")
+
+// PaymentVoucherHeader is the http request header in which a client sends a
+// signed payment channel voucher to pay for a retrieval. The voucher is
+// encoded in the same way as by the lotus paych voucher commands (base64url
+// of the voucher's cbor encoding).
+const PaymentVoucherHeader = "X-Filecoin-Payment-Voucher"
+
+// ErrPaymentRequired is returned when the endpoint requires payment for the
+// retrieval
+var ErrPaymentRequired = errors.New("payment required")
+
+// Availability is whether an endpoint serves the DAG under a payload cid
+type Availability int
+
+const (
+	// Unavailable means the endpoint doesn't serve the DAG (or can't be
+	// reached)
+	Unavailable Availability = iota
+	// Free means the endpoint serves the DAG without payment
+	Free
+	// Paid means the endpoint serves the DAG for a payment voucher
+	Paid
+)
+
+func (a Availability) String() string {
+	switch a {
+	case Free:
+		return "free"
+	case Paid:
+		return "paid"
+	default:
+		return "unavailable"
+	}
+}
+
+// Blockstore is the blockstore that retrieved blocks are written to
+type Blockstore interface {
+	Put(context.Context, blocks.Block) error
+}
+
+// Client retrieves DAGs from booster-http endpoints
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{httpClient: httpClient}
+}
+
+// Probe checks whether the endpoint serves the DAG under the payload cid, by
+// requesting only the first byte of the CAR file. If the endpoint requires
+// payment, it returns the endpoint's payment terms.
+func (c *Client) Probe(ctx context.Context, endpoint string, payloadCid cid.Cid) (Availability, *Terms, error) {
+	req, err := c.request(ctx, http.MethodGet, endpoint, payloadCid)
+	if err != nil {
+		return Unavailable, nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Unavailable, nil, fmt.Errorf("probing %s: %w", req.URL, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return Free, nil, nil
+	case http.StatusPaymentRequired:
+		terms, err := parseTerms(resp.Header)
+		if err != nil {
+			return Unavailable, nil, fmt.Errorf("probing %s: %w", req.URL, err)
+		}
+		return Paid, terms, nil
+	default:
+		return Unavailable, nil, statusError(resp)
+	}
+}
+
+// Retrieve streams the CAR file for the DAG under the payload cid from the
+// endpoint, and writes its blocks to the blockstore. If pay is not nil the
+// retrieval is paid for a chunk at a time (see Payment). The progress
+// callback (which may be nil) is called with the total size of the blocks
+// received so far after each block. It returns the total size of the
+// blocks.
+func (c *Client) Retrieve(ctx context.Context, endpoint string, payloadCid cid.Cid, pay *Payment, bs Blockstore, progress func(uint64)) (uint64, error) {
+	var body io.ReadCloser
+	if pay == nil {
+		req, err := c.request(ctx, http.MethodGet, endpoint, payloadCid)
+		if err != nil {
+			return 0, err
+		}
+		log.Debugw("retrieving over http", "url", req.URL)
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("retrieving %s: %w", req.URL, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close() //nolint:errcheck
+			if resp.StatusCode == http.StatusPaymentRequired {
+				return 0, fmt.Errorf("retrieving %s: %w", req.URL, ErrPaymentRequired)
+			}
+			return 0, statusError(resp)
+		}
+		body = resp.Body
+	} else {
+		size, err := c.size(ctx, endpoint, payloadCid)
+		if err != nil {
+			return 0, err
+		}
+		log.Debugw("retrieving over http with payment", "endpoint", endpoint, "payload", payloadCid, "size", size,
+			"price-per-byte", pay.Terms.PricePerByte, "payment-interval", pay.Terms.PaymentInterval)
+		body = &paidReader{ctx: ctx, c: c, endpoint: endpoint, payloadCid: payloadCid, pay: pay, size: size, total: big.Zero()}
+	}
+	defer body.Close() //nolint:errcheck
+
+	// The block reader checks that each block's data matches its cid, as
+	// the endpoint is not trusted
+	br, err := carv2.NewBlockReader(body)
+	if err != nil {
+		return 0, fmt.Errorf("reading CAR header from %s: %w", endpoint, err)
+	}
+	if len(br.Roots) == 0 || !br.Roots[0].Equals(payloadCid) {
+		return 0, fmt.Errorf("CAR file from %s has roots %v, expected %s", endpoint, br.Roots, payloadCid)
+	}
+
+	var received uint64
+	for {
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return received, fmt.Errorf("reading block from %s: %w", endpoint, err)
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return received, fmt.Errorf("writing block %s: %w", blk.Cid(), err)
+		}
+		received += uint64(len(blk.RawData()))
+		if progress != nil {
+			progress(received)
+		}
+	}
+	return received, nil
+}
+
+// size returns the size of the CAR file, which is free to request
+func (c *Client) size(ctx context.Context, endpoint string, payloadCid cid.Cid) (uint64, error) {
+	req, err := c.request(ctx, http.MethodHead, endpoint, payloadCid)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("getting size of %s: %w", req.URL, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError(resp)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("getting size of %s: no content length", req.URL)
+	}
+	return uint64(resp.ContentLength), nil
+}
+
+func (c *Client) request(ctx context.Context, method string, endpoint string, payloadCid cid.Cid) (*http.Request, error) {
+	query := url.Values{"payloadCid": []string{payloadCid.String()}, "format": []string{"car"}}
+	u := endpoint + "/piece?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	// The byte ranges of a paid retrieval are of the uncompressed CAR file
+	req.Header.Set("Accept-Encoding", "identity")
+	return req, nil
+}
+
+// EncodeVoucher encodes the voucher to send in the PaymentVoucherHeader
+func EncodeVoucher(sv *paychtypes.SignedVoucher) (string, error) {
+	var buf bytes.Buffer
+	if err := sv.MarshalCBOR(&buf); err != nil {
+		return "", fmt.Errorf("encoding voucher: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeVoucher decodes a voucher received in the PaymentVoucherHeader
+func DecodeVoucher(enc string) (*paychtypes.SignedVoucher, error) {
+	b, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding voucher: %w", err)
+	}
+	var sv paychtypes.SignedVoucher
+	if err := sv.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("decoding voucher: %w", err)
+	}
+	return &sv, nil
+}
+
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("retrieving %s: status %d: %s", resp.Request.URL, resp.StatusCode, msg)
+}
@@ -0,0 +1,219 @@
+package httpretrieval
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	paychtypes "github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/require"
+)
+
+type memBlockstore struct {
+	lk   sync.Mutex
+	blks map[cid.Cid]blocks.Block
+}
+
+func (m *memBlockstore) Put(_ context.Context, blk blocks.Block) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.blks[blk.Cid()] = blk
+	return nil
+}
+
+func carBytes(t *testing.T, blks []blocks.Block, corrupt bool) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, &buf))
+	for i, blk := range blks {
+		data := blk.RawData()
+		if corrupt && i == len(blks)-1 {
+			data = append([]byte{}, data...)
+			data[0] ^= 0xff
+		}
+		require.NoError(t, util.LdWrite(&buf, blk.Cid().Bytes(), data))
+	}
+	return buf.Bytes()
+}
+
+func TestRetrieve(t *testing.T) {
+	ctx := context.Background()
+	blks := testutil.GenerateBlocksOfSize(3, 1024)
+	root := blks[0].Cid()
+
+	var corrupt bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("payloadCid") != root.String() {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(carBytes(t, blks, corrupt)))
+	}))
+	defer srv.Close()
+
+	c := NewClient(nil)
+
+	av, terms, err := c.Probe(ctx, srv.URL, root)
+	require.NoError(t, err)
+	require.Equal(t, Free, av)
+	require.Nil(t, terms)
+
+	bs := &memBlockstore{blks: make(map[cid.Cid]blocks.Block)}
+	var progress uint64
+	size, err := c.Retrieve(ctx, srv.URL, root, nil, bs, func(n uint64) { progress = n })
+	require.NoError(t, err)
+	require.EqualValues(t, 3*1024, size)
+	require.Equal(t, size, progress)
+	require.Len(t, bs.blks, 3)
+
+	// Unknown payload
+	av, _, err = c.Probe(ctx, srv.URL, blks[1].Cid())
+	require.Error(t, err)
+	require.Equal(t, Unavailable, av)
+
+	// Blocks whose data doesn't match their cid are rejected
+	corrupt = true
+	_, err = c.Retrieve(ctx, srv.URL, root, nil, bs, nil)
+	require.ErrorContains(t, err, "integrity")
+}
+
+// mockVoucherAPI accepts vouchers whose amount has increased by at least
+// minDelta since the last voucher on the lane
+type mockVoucherAPI struct {
+	lk     sync.Mutex
+	lanes  map[uint64]big.Int
+	reject bool
+}
+
+func (m *mockVoucherAPI) PaychVoucherAdd(_ context.Context, _ address.Address, sv *paychtypes.SignedVoucher, _ []byte, minDelta big.Int) (big.Int, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.reject {
+		return big.Zero(), errors.New("channel has insufficient funds")
+	}
+	prev, ok := m.lanes[sv.Lane]
+	if !ok {
+		prev = big.Zero()
+	}
+	delta := big.Sub(sv.Amount, prev)
+	if delta.LessThan(minDelta) {
+		return big.Zero(), fmt.Errorf("delta %s is less than %s", delta, minDelta)
+	}
+	m.lanes[sv.Lane] = sv.Amount
+	return delta, nil
+}
+
+func TestRetrievePaid(t *testing.T) {
+	ctx := context.Background()
+	blks := testutil.GenerateBlocksOfSize(3, 1024)
+	root := blks[0].Cid()
+	content := carBytes(t, blks, false)
+	ch, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	// Serve the CAR file for a voucher per 1000 bytes
+	vapi := &mockVoucherAPI{lanes: make(map[uint64]big.Int)}
+	payments := NewPayments(vapi, Terms{PricePerByte: big.NewInt(2), PaymentInterval: 1000})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			if err := payments.Accept(r.Context(), r, uint64(len(content))); err != nil {
+				require.ErrorIs(t, err, ErrPaymentRequired)
+				payments.Terms().SetHeaders(w.Header())
+				http.Error(w, err.Error(), http.StatusPaymentRequired)
+				return
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	c := NewClient(nil)
+	av, terms, err := c.Probe(ctx, srv.URL, root)
+	require.NoError(t, err)
+	require.Equal(t, Paid, av)
+	require.Equal(t, big.NewInt(2), terms.PricePerByte)
+	require.EqualValues(t, 1000, terms.PaymentInterval)
+
+	bs := &memBlockstore{blks: make(map[cid.Cid]blocks.Block)}
+	_, err = c.Retrieve(ctx, srv.URL, root, nil, bs, nil)
+	require.ErrorIs(t, err, ErrPaymentRequired)
+
+	newPayment := func(lane uint64) (*Payment, *[]abi.TokenAmount, *abi.TokenAmount) {
+		var vouchers []abi.TokenAmount
+		accepted := big.Zero()
+		return &Payment{
+			Terms: *terms,
+			Voucher: func(ctx context.Context, total abi.TokenAmount) (*paychtypes.SignedVoucher, error) {
+				vouchers = append(vouchers, total)
+				return &paychtypes.SignedVoucher{ChannelAddr: ch, Lane: lane, Nonce: uint64(len(vouchers)), Amount: total}, nil
+			},
+			Accepted: func(total abi.TokenAmount, bytesPaidFor uint64) {
+				accepted = total
+			},
+		}, &vouchers, &accepted
+	}
+
+	// The retrieval is paid for a chunk at a time
+	pay, vouchers, accepted := newPayment(1)
+	size, err := c.Retrieve(ctx, srv.URL, root, pay, bs, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 3*1024, size)
+	require.Len(t, bs.blks, 3)
+	chunks := (len(content) + 999) / 1000
+	require.Len(t, *vouchers, chunks)
+	require.Equal(t, big.NewInt(int64(2*len(content))), *accepted)
+
+	// Only the vouchers that the endpoint accepted are counted as paid
+	pay, vouchers, accepted = newPayment(2)
+	pay.Voucher = func(ctx context.Context, total abi.TokenAmount) (*paychtypes.SignedVoucher, error) {
+		*vouchers = append(*vouchers, total)
+		if len(*vouchers) == 2 {
+			vapi.reject = true
+		}
+		return &paychtypes.SignedVoucher{ChannelAddr: ch, Lane: 2, Nonce: uint64(len(*vouchers)), Amount: total}, nil
+	}
+	_, err = c.Retrieve(ctx, srv.URL, root, pay, bs, nil)
+	require.ErrorIs(t, err, ErrPaymentRequired)
+	require.Len(t, *vouchers, 2)
+	require.Equal(t, big.NewInt(2000), *accepted)
+}
+
+func TestRangeLength(t *testing.T) {
+	for _, tc := range []struct {
+		hdr    string
+		length uint64
+		err    bool
+	}{
+		{hdr: "", length: 100},
+		{hdr: "bytes=0-9", length: 10},
+		{hdr: "bytes=90-", length: 10},
+		{hdr: "bytes=90-200", length: 10},
+		{hdr: "bytes=-20", length: 20},
+		{hdr: "bytes=100-", length: 0},
+		{hdr: "bytes=0-9,20-29", err: true},
+		{hdr: "bytes=9-0", err: true},
+		{hdr: "items=0-9", err: true},
+	} {
+		length, err := rangeLength(tc.hdr, 100)
+		if tc.err {
+			require.Error(t, err, tc.hdr)
+			continue
+		}
+		require.NoError(t, err, tc.hdr)
+		require.Equal(t, tc.length, length, tc.hdr)
+	}
+}
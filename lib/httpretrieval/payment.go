@@ -0,0 +1,270 @@
+package httpretrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	paychtypes "github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	"github.com/ipfs/go-cid"
+)
+
+// The http response headers in which an endpoint that requires payment
+// announces its price, with status 402 (Payment Required)
+const (
+	// The price per byte, in attoFIL
+	PricePerByteHeader = "X-Filecoin-Payment-Price-Per-Byte"
+	// The maximum number of bytes that may be requested per voucher
+	PaymentIntervalHeader = "X-Filecoin-Payment-Interval"
+)
+
+// Terms are the payment terms of an endpoint that requires payment
+type Terms struct {
+	PricePerByte abi.TokenAmount
+	// The maximum number of bytes that may be requested per voucher
+	PaymentInterval uint64
+}
+
+// SetHeaders sets the headers that announce the terms
+func (t Terms) SetHeaders(h http.Header) {
+	h.Set(PricePerByteHeader, t.PricePerByte.String())
+	h.Set(PaymentIntervalHeader, strconv.FormatUint(t.PaymentInterval, 10))
+}
+
+func parseTerms(h http.Header) (*Terms, error) {
+	price, err := big.FromString(h.Get(PricePerByteHeader))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s header: %w", PricePerByteHeader, err)
+	}
+	if price.LessThan(big.Zero()) {
+		return nil, fmt.Errorf("%s header is negative", PricePerByteHeader)
+	}
+	interval, err := strconv.ParseUint(h.Get(PaymentIntervalHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s header: %w", PaymentIntervalHeader, err)
+	}
+	if interval == 0 {
+		return nil, fmt.Errorf("%s header is zero", PaymentIntervalHeader)
+	}
+	return &Terms{PricePerByte: price, PaymentInterval: interval}, nil
+}
+
+// VoucherAPI adds the payment vouchers received from clients (the lotus full
+// node paych API). The full node checks that the voucher is valid, and that
+// the payment channel pays one of its wallets.
+type VoucherAPI interface {
+	PaychVoucherAdd(ctx context.Context, ch address.Address, sv *paychtypes.SignedVoucher, proof []byte, minDelta big.Int) (big.Int, error)
+}
+
+// Payments checks that each request to an endpoint is paid for.
+//
+// A client pays for a retrieval in chunks: each request is for a byte range
+// of at most the payment interval, and carries a voucher on the client's
+// payment channel lane whose amount has increased by at least the price of
+// the requested bytes since the last voucher on the lane.
+type Payments struct {
+	api   VoucherAPI
+	terms Terms
+}
+
+func NewPayments(api VoucherAPI, terms Terms) *Payments {
+	return &Payments{api: api, terms: terms}
+}
+
+func (p *Payments) Terms() Terms {
+	return p.terms
+}
+
+// Accept checks that the request carries a voucher that pays for the bytes
+// of the content that it requests, and adds the voucher to its payment
+// channel. size is the size of the content. The error wraps
+// ErrPaymentRequired if the request is not paid for.
+func (p *Payments) Accept(ctx context.Context, r *http.Request, size uint64) error {
+	length, err := rangeLength(r.Header.Get("Range"), size)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPaymentRequired, err)
+	}
+	if length == 0 {
+		return nil
+	}
+	if length > p.terms.PaymentInterval {
+		return fmt.Errorf("%w: request is for %d bytes, but at most %d bytes may be requested per voucher",
+			ErrPaymentRequired, length, p.terms.PaymentInterval)
+	}
+
+	enc := r.Header.Get(PaymentVoucherHeader)
+	if enc == "" {
+		return ErrPaymentRequired
+	}
+	sv, err := DecodeVoucher(enc)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPaymentRequired, err)
+	}
+
+	owed := big.Mul(p.terms.PricePerByte, big.NewIntUnsigned(length))
+	if _, err := p.api.PaychVoucherAdd(ctx, sv.ChannelAddr, sv, nil, owed); err != nil {
+		return fmt.Errorf("%w: voucher for %s was not accepted: %s", ErrPaymentRequired, owed, err)
+	}
+	log.Debugw("accepted payment voucher", "channel", sv.ChannelAddr, "lane", sv.Lane, "amount", sv.Amount, "bytes", length)
+	return nil
+}
+
+// rangeLength returns the number of bytes of the content of the given size
+// that a Range header requests. Only a single byte range is supported.
+func rangeLength(rangeHdr string, size uint64) (uint64, error) {
+	if rangeHdr == "" {
+		return size, nil
+	}
+	spec := strings.TrimPrefix(rangeHdr, "bytes=")
+	if spec == rangeHdr || strings.Contains(spec, ",") {
+		return 0, fmt.Errorf("unsupported range %q: only a single byte range may be requested", rangeHdr)
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid range %q", rangeHdr)
+	}
+
+	// A suffix range requests the last bytes of the content
+	if startStr == "" {
+		n, err := strconv.ParseUint(endStr, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid range %q", rangeHdr)
+		}
+		if n > size {
+			return size, nil
+		}
+		return n, nil
+	}
+
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %q", rangeHdr)
+	}
+	if start >= size {
+		return 0, nil
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseUint(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, fmt.Errorf("invalid range %q", rangeHdr)
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return end - start + 1, nil
+}
+
+// Payment pays for a retrieval from an endpoint that requires payment. The
+// retrieval is requested in chunks of the payment interval, and each chunk
+// is paid for with a voucher for the total amount paid so far.
+type Payment struct {
+	Terms Terms
+	// Voucher creates a voucher for the total amount
+	Voucher func(ctx context.Context, total abi.TokenAmount) (*paychtypes.SignedVoucher, error)
+	// Accepted is called once the endpoint has accepted the voucher for a
+	// chunk, with the total amount paid and the number of bytes paid for
+	Accepted func(total abi.TokenAmount, bytesPaidFor uint64)
+}
+
+// paidReader reads the content from an endpoint that requires payment, a
+// chunk at a time, paying for each chunk before it is requested
+type paidReader struct {
+	ctx        context.Context
+	c          *Client
+	endpoint   string
+	payloadCid cid.Cid
+	pay        *Payment
+
+	size     uint64
+	offset   uint64
+	chunkEnd uint64
+	total    abi.TokenAmount
+	body     io.ReadCloser
+}
+
+func (r *paidReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if r.offset >= r.size {
+				return 0, io.EOF
+			}
+			if err := r.next(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += uint64(n)
+		if errors.Is(err, io.EOF) {
+			_ = r.body.Close()
+			r.body = nil
+			if r.offset != r.chunkEnd {
+				return n, fmt.Errorf("received %d bytes of chunk ending at %d: %w", r.offset, r.chunkEnd, io.ErrUnexpectedEOF)
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+// next pays for and requests the next chunk
+func (r *paidReader) next() error {
+	length := r.pay.Terms.PaymentInterval
+	if remaining := r.size - r.offset; remaining < length {
+		length = remaining
+	}
+	total := big.Add(r.total, big.Mul(r.pay.Terms.PricePerByte, big.NewIntUnsigned(length)))
+	sv, err := r.pay.Voucher(r.ctx, total)
+	if err != nil {
+		return fmt.Errorf("creating voucher for %s: %w", total, err)
+	}
+	enc, err := EncodeVoucher(sv)
+	if err != nil {
+		return err
+	}
+
+	req, err := r.c.request(r.ctx, http.MethodGet, r.endpoint, r.payloadCid)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.offset, r.offset+length-1))
+	req.Header.Set(PaymentVoucherHeader, enc)
+	resp, err := r.c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("retrieving %s: %w", req.URL, err)
+	}
+	if resp.StatusCode == http.StatusPaymentRequired {
+		defer resp.Body.Close() //nolint:errcheck
+		return fmt.Errorf("%w: %s", ErrPaymentRequired, statusError(resp))
+	}
+	if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && length == r.size) {
+		defer resp.Body.Close() //nolint:errcheck
+		return statusError(resp)
+	}
+
+	// The endpoint only serves the chunk once it has accepted the voucher
+	r.total = total
+	r.pay.Accepted(total, r.offset+length)
+	r.chunkEnd = r.offset + length
+	r.body = resp.Body
+	return nil
+}
+
+func (r *paidReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,481 @@
+// Package ipfsgateway serves the blocks that a storage provider stores
+// according to the IPFS path gateway spec, so that the provider can act as a
+// trustless gateway: GET /ipfs/{cid}/{path} responds with either the raw
+// block that the path resolves to, or a CAR file with the blocks needed to
+// verify the path and the DAG under it. The response format is negotiated
+// with the format query parameter or the Accept header.
+//
+// Deserialized responses (eg the contents of a UnixFS file) are not served,
+// as the client can't verify them.
+package ipfsgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("ipfsgateway")
+
+// PathPrefix is the path under which content is served
+const PathPrefix = "/ipfs/"
+
+const (
+	ContentTypeRaw = "application/vnd.ipld.raw"
+	ContentTypeCar = "application/vnd.ipld.car"
+)
+
+// The response formats
+const (
+	FormatRaw = "raw"
+	FormatCar = "car"
+)
+
+// The scopes of the DAG under the requested path that are included in a
+// CAR response
+const (
+	// Only the block that the path resolves to
+	DagScopeBlock = "block"
+	// The block that the path resolves to, and if it is a UnixFS file, all
+	// the blocks of the file
+	DagScopeEntity = "entity"
+	// The whole DAG under the path
+	DagScopeAll = "all"
+)
+
+var (
+	// ErrNotFound is returned when the path doesn't resolve to a block
+	ErrNotFound = errors.New("not found")
+	// ErrNotAcceptable is returned when the request doesn't accept any of the
+	// response formats that the gateway serves
+	ErrNotAcceptable = errors.New("not acceptable")
+)
+
+// BlockGetter gets the data of the blocks in the provider's pieces (eg the
+// boost API)
+type BlockGetter interface {
+	BlockstoreGet(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+// Request is a request for /ipfs/{cid}/{path}
+type Request struct {
+	Root     cid.Cid
+	Path     []string
+	Format   string
+	DagScope string
+}
+
+// Resolved is a path that has been resolved to a block
+type Resolved struct {
+	Root cid.Cid
+	// The cid of the block that the path resolves to
+	Cid cid.Cid
+	// The cids of the blocks that were traversed to resolve the path,
+	// starting with the root (not including the block the path resolves to)
+	PathBlocks []cid.Cid
+}
+
+// ParseRequest parses the path of the request under the prefix (eg
+// /ipfs/{cid}/{path}), and negotiates the response format
+func ParseRequest(r *http.Request, prefix string) (*Request, error) {
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return nil, fmt.Errorf("path must start with %s", prefix)
+	}
+	var segments []string
+	for _, s := range strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("path must be %s{cid}[/{path}]", prefix)
+	}
+	root, err := cid.Decode(segments[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing cid '%s': %w", segments[0], err)
+	}
+
+	req := &Request{Root: root, Path: segments[1:], DagScope: DagScopeAll}
+	req.Format, err = negotiateFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	if scope := r.URL.Query().Get("dag-scope"); scope != "" {
+		switch scope {
+		case DagScopeBlock, DagScopeEntity, DagScopeAll:
+			req.DagScope = scope
+		default:
+			return nil, fmt.Errorf("unsupported dag-scope '%s'", scope)
+		}
+	}
+	return req, nil
+}
+
+// negotiateFormat gets the response format from the format query parameter
+// if it's set, otherwise from the first media type in the Accept header that
+// the gateway can serve
+func negotiateFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if f != FormatRaw && f != FormatCar {
+			return "", fmt.Errorf("unsupported format '%s': must be '%s' or '%s'", f, FormatRaw, FormatCar)
+		}
+		return f, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		switch mediaType {
+		case ContentTypeRaw:
+			return FormatRaw, nil
+		case ContentTypeCar:
+			// Only CARv1 in depth-first order is served
+			if v, ok := params["version"]; ok && v != "1" {
+				continue
+			}
+			if o, ok := params["order"]; ok && o != "dfs" && o != "unk" {
+				continue
+			}
+			return FormatCar, nil
+		}
+	}
+	return "", fmt.Errorf("%w: only verifiable responses are served: request %s or %s, or set the format query parameter",
+		ErrNotAcceptable, ContentTypeRaw, ContentTypeCar)
+}
+
+// Gateway serves the blocks from a BlockGetter over http
+type Gateway struct {
+	bg     BlockGetter
+	prefix string
+}
+
+// New creates a gateway that serves requests for paths under the prefix
+// (eg /ipfs/)
+func New(bg BlockGetter, prefix string) *Gateway {
+	return &Gateway{bg: bg, prefix: prefix}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := ParseRequest(r, g.prefix)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrNotAcceptable) {
+			status = http.StatusNotAcceptable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	ctx := r.Context()
+	res, err := g.Resolve(ctx, req.Root, req.Path)
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	roots := make([]string, 0, len(res.PathBlocks)+1)
+	for _, c := range res.PathBlocks {
+		roots = append(roots, c.String())
+	}
+	roots = append(roots, res.Cid.String())
+
+	h := w.Header()
+	h.Set("X-Ipfs-Path", r.URL.Path)
+	h.Set("X-Ipfs-Roots", strings.Join(roots, ","))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Vary", "Accept")
+	// The content under an immutable path never changes
+	h.Set("Cache-Control", "public, max-age=29030400, immutable")
+
+	if req.Format == FormatRaw {
+		data, err := g.Block(ctx, res.Cid)
+		if err != nil {
+			g.writeError(w, r, err)
+			return
+		}
+		h.Set("Content-Type", ContentTypeRaw)
+		h.Set("Content-Length", strconv.Itoa(len(data)))
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.bin"`, res.Cid))
+		h.Set("Etag", fmt.Sprintf(`"%s.raw"`, res.Cid))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+		return
+	}
+
+	h.Set("Content-Type", ContentTypeCar+"; version=1; order=dfs; dups=n")
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.car"`, res.Cid))
+	h.Set("Etag", fmt.Sprintf(`W/"%s.car.%s"`, res.Cid, req.DagScope))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	// The status has already been sent, so the client can only find out
+	// that the CAR is incomplete when it fails to verify it
+	if err := g.WriteCar(ctx, w, res, req.DagScope); err != nil {
+		log.Warnw("writing CAR response", "path", r.URL.Path, "err", err)
+	}
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) || isNotFoundError(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Errorw("serving gateway request", "path", r.URL.Path, "err", err)
+	http.Error(w, fmt.Sprintf("server error resolving %s", r.URL.Path), http.StatusInternalServerError)
+}
+
+// isNotFoundError falls back to checking the error string, as the error
+// may have crossed an RPC boundary
+func isNotFoundError(err error) bool {
+	var nf format.ErrNotFound
+	if errors.As(err, &nf) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
+// Block gets the data of the block, and checks that it matches the cid
+func (g *Gateway) Block(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if c.Prefix().MhType == multihash.IDENTITY {
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return nil, err
+		}
+		return dmh.Digest, nil
+	}
+
+	data, err := g.bg.BlockstoreGet(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("getting block %s: %w", c, err)
+	}
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, fmt.Errorf("hashing block %s: %w", c, err)
+	}
+	if !chk.Equals(c) {
+		return nil, fmt.Errorf("data of block %s does not match its cid", c)
+	}
+	return data, nil
+}
+
+func (g *Gateway) node(ctx context.Context, c cid.Cid) (format.Node, []byte, error) {
+	data, err := g.Block(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	nd, err := format.Decode(blk)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding block %s: %w", c, err)
+	}
+	return nd, data, nil
+}
+
+// Resolve resolves the path from the root cid. UnixFS paths are resolved by
+// link name (including in HAMT sharded directories), and paths in other
+// codecs (eg dag-cbor) by field name.
+func (g *Gateway) Resolve(ctx context.Context, root cid.Cid, path []string) (*Resolved, error) {
+	// Record the blocks that are fetched to resolve the path, so that
+	// they can be included in a CAR response
+	dag := &recordingDAG{g: g}
+	cur := root
+	for i := 0; i < len(path); {
+		nd, err := dag.Get(ctx, cur)
+		if err != nil {
+			return nil, err
+		}
+
+		var next cid.Cid
+		switch pn := nd.(type) {
+		case *merkledag.ProtoNode:
+			seg := path[i]
+			i++
+			dir, err := uio.NewDirectoryFromNode(dag, pn)
+			if err == nil {
+				child, err := dir.Find(ctx, seg)
+				if errors.Is(err, os.ErrNotExist) {
+					return nil, fmt.Errorf("no link named '%s' under %s: %w", seg, cur, ErrNotFound)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("resolving '%s' under %s: %w", seg, cur, err)
+				}
+				next = child.Cid()
+				break
+			}
+			if !errors.Is(err, uio.ErrNotADir) {
+				return nil, fmt.Errorf("reading directory %s: %w", cur, err)
+			}
+			// A UnixFS file, or a dag-pb node that isn't UnixFS
+			lnk, err := pn.GetNodeLink(seg)
+			if err != nil {
+				return nil, fmt.Errorf("no link named '%s' under %s: %w", seg, cur, ErrNotFound)
+			}
+			next = lnk.Cid
+		default:
+			lnk, rest, err := nd.ResolveLink(path[i:])
+			if err != nil {
+				return nil, fmt.Errorf("resolving '%s' under %s: %s: %w", strings.Join(path[i:], "/"), cur, err, ErrNotFound)
+			}
+			i = len(path) - len(rest)
+			next = lnk.Cid
+		}
+		cur = next
+	}
+
+	res := &Resolved{Root: root, Cid: cur}
+	for _, c := range dag.visited {
+		if !c.Equals(cur) {
+			res.PathBlocks = append(res.PathBlocks, c)
+		}
+	}
+	return res, nil
+}
+
+// WriteCar writes a CARv1 with the root of the path as its root. It contains
+// the blocks traversed to resolve the path, followed by the blocks in the
+// scope of the DAG under the path, in depth-first order without duplicates.
+func (g *Gateway) WriteCar(ctx context.Context, w io.Writer, res *Resolved, scope string) error {
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{res.Root}, Version: 1}, w); err != nil {
+		return fmt.Errorf("writing CAR header: %w", err)
+	}
+
+	seen := cid.NewSet()
+	for _, c := range res.PathBlocks {
+		if !seen.Visit(c) {
+			continue
+		}
+		data, err := g.Block(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, c.Bytes(), data); err != nil {
+			return err
+		}
+	}
+	return g.writeDag(ctx, w, res.Cid, scope, seen)
+}
+
+func (g *Gateway) writeDag(ctx context.Context, w io.Writer, c cid.Cid, scope string, seen *cid.Set) error {
+	if !seen.Visit(c) {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	nd, data, err := g.node(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := carutil.LdWrite(w, c.Bytes(), data); err != nil {
+		return err
+	}
+
+	switch scope {
+	case DagScopeBlock:
+		return nil
+	case DagScopeEntity:
+		if !isUnixFSFile(nd) {
+			return nil
+		}
+		// All the blocks under a file are part of the file
+		scope = DagScopeAll
+	}
+	for _, lnk := range nd.Links() {
+		if err := g.writeDag(ctx, w, lnk.Cid, scope, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isUnixFSFile(nd format.Node) bool {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		// A raw block is a file with a single block
+		_, ok := nd.(*merkledag.RawNode)
+		return ok
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.Type() == unixfs.TFile || fsn.Type() == unixfs.TRaw
+}
+
+// recordingDAG is a read-only DAGService over the gateway's blocks, that
+// records the cids of the nodes it gets
+type recordingDAG struct {
+	g       *Gateway
+	visited []cid.Cid
+}
+
+var _ format.DAGService = (*recordingDAG)(nil)
+
+func (d *recordingDAG) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	nd, _, err := d.g.node(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	d.visited = append(d.visited, c)
+	return nd, nil
+}
+
+func (d *recordingDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := d.Get(ctx, c)
+		out <- &format.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (d *recordingDAG) Add(context.Context, format.Node) error {
+	return errors.New("the gateway DAG is read-only")
+}
+
+func (d *recordingDAG) AddMany(context.Context, []format.Node) error {
+	return errors.New("the gateway DAG is read-only")
+}
+
+func (d *recordingDAG) Remove(context.Context, cid.Cid) error {
+	return errors.New("the gateway DAG is read-only")
+}
+
+func (d *recordingDAG) RemoveMany(context.Context, []cid.Cid) error {
+	return errors.New("the gateway DAG is read-only")
+}
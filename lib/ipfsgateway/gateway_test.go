@@ -0,0 +1,218 @@
+package ipfsgateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	format "github.com/ipfs/go-ipld-format"
+	dstest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/hamt"
+	"github.com/ipfs/go-unixfs/importer"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+)
+
+type dagGetter struct {
+	dag format.DAGService
+}
+
+func (d *dagGetter) BlockstoreGet(ctx context.Context, c cid.Cid) ([]byte, error) {
+	nd, err := d.dag.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return nd.RawData(), nil
+}
+
+type testDag struct {
+	dag format.DAGService
+	// root/file.bin and root/sharded/entry-{n}
+	root    cid.Cid
+	file    format.Node
+	data    []byte
+	sharded cid.Cid
+	entry   format.Node
+}
+
+func newTestDag(t *testing.T) *testDag {
+	ctx := context.Background()
+	dag := dstest.Mock()
+
+	data := make([]byte, 2000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	file, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	require.NotEmpty(t, file.Links())
+
+	// A HAMT sharded directory, narrow enough to have nested shards
+	shard, err := hamt.NewShard(dag, 16)
+	require.NoError(t, err)
+	var entry format.Node
+	for i := 0; i < 100; i++ {
+		nd, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader([]byte(fmt.Sprintf("entry %d", i))), 256))
+		require.NoError(t, err)
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), nd))
+		if i == 42 {
+			entry = nd
+		}
+	}
+	sharded, err := shard.Node()
+	require.NoError(t, err)
+
+	dir := uio.NewDirectory(dag)
+	require.NoError(t, dir.AddChild(ctx, "file.bin", file))
+	require.NoError(t, dir.AddChild(ctx, "sharded", sharded))
+	root, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dag.Add(ctx, root))
+
+	return &testDag{dag: dag, root: root.Cid(), file: file, data: data, sharded: sharded.Cid(), entry: entry}
+}
+
+func (td *testDag) get(t *testing.T, path string, accept string) *http.Response {
+	srv := httptest.NewServer(New(&dagGetter{dag: td.dag}, PathPrefix))
+	t.Cleanup(srv.Close)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	require.NoError(t, err)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// readCar reads the roots and the blocks (in order) of a CAR response
+func readCar(t *testing.T, r io.Reader) ([]cid.Cid, []cid.Cid) {
+	cr, err := car.NewCarReader(bufio.NewReader(r))
+	require.NoError(t, err)
+	var cids []cid.Cid
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		// Check that the block data matches its cid
+		chk, err := blk.Cid().Prefix().Sum(blk.RawData())
+		require.NoError(t, err)
+		require.Equal(t, blk.Cid(), chk)
+		cids = append(cids, blk.Cid())
+	}
+	return cr.Header.Roots, cids
+}
+
+func TestGatewayRaw(t *testing.T) {
+	td := newTestDag(t)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		accept string
+	}{{
+		name:   "accept header",
+		path:   "/ipfs/" + td.root.String() + "/file.bin",
+		accept: "text/html, " + ContentTypeRaw,
+	}, {
+		name: "format query parameter",
+		path: "/ipfs/" + td.root.String() + "/file.bin?format=raw",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := td.get(t, tc.path, tc.accept)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, ContentTypeRaw, resp.Header.Get("Content-Type"))
+			require.Equal(t, `"`+td.file.Cid().String()+`.raw"`, resp.Header.Get("Etag"))
+			require.Equal(t, td.root.String()+","+td.file.Cid().String(), resp.Header.Get("X-Ipfs-Roots"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, td.file.RawData(), body)
+		})
+	}
+}
+
+func TestGatewayCar(t *testing.T) {
+	td := newTestDag(t)
+	fileBlocks := []cid.Cid{td.file.Cid()}
+	for _, l := range td.file.Links() {
+		fileBlocks = append(fileBlocks, l.Cid)
+	}
+
+	t.Run("whole dag under path", func(t *testing.T) {
+		resp := td.get(t, "/ipfs/"+td.root.String()+"/file.bin", ContentTypeCar+"; version=1")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Contains(t, resp.Header.Get("Content-Type"), ContentTypeCar)
+
+		roots, cids := readCar(t, resp.Body)
+		require.Equal(t, []cid.Cid{td.root}, roots)
+		require.Equal(t, append([]cid.Cid{td.root}, fileBlocks...), cids)
+	})
+
+	t.Run("block scope", func(t *testing.T) {
+		resp := td.get(t, "/ipfs/"+td.root.String()+"/file.bin?format=car&dag-scope=block", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_, cids := readCar(t, resp.Body)
+		require.Equal(t, []cid.Cid{td.root, td.file.Cid()}, cids)
+	})
+
+	t.Run("entity scope of file", func(t *testing.T) {
+		resp := td.get(t, "/ipfs/"+td.file.Cid().String()+"?format=car&dag-scope=entity", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_, cids := readCar(t, resp.Body)
+		require.Equal(t, fileBlocks, cids)
+	})
+
+	t.Run("path through sharded directory", func(t *testing.T) {
+		resp := td.get(t, "/ipfs/"+td.root.String()+"/sharded/entry-42?format=car", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_, cids := readCar(t, resp.Body)
+
+		// The CAR should start with the root and the shard nodes traversed
+		// to find the entry, and end with the entry
+		require.Greater(t, len(cids), 3)
+		require.Equal(t, td.root, cids[0])
+		require.Equal(t, td.sharded, cids[1])
+		require.Equal(t, td.entry.Cid(), cids[len(cids)-1])
+	})
+}
+
+func TestGatewayErrors(t *testing.T) {
+	td := newTestDag(t)
+
+	resp := td.get(t, "/ipfs/"+td.root.String()+"/nope?format=raw", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = td.get(t, "/ipfs/"+td.root.String()+"/sharded/nope?format=raw", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	missing, err := cid.Decode("bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4")
+	require.NoError(t, err)
+	resp = td.get(t, "/ipfs/"+missing.String()+"?format=raw", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Deserialized responses aren't served
+	resp = td.get(t, "/ipfs/"+td.root.String()+"/file.bin", "text/html")
+	require.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+
+	// Only CARv1 is served
+	resp = td.get(t, "/ipfs/"+td.root.String(), ContentTypeCar+"; version=2")
+	require.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+
+	resp = td.get(t, "/ipfs/not-a-cid?format=raw", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = td.get(t, "/ipfs/"+td.root.String()+"?format=car&dag-scope=everything", "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
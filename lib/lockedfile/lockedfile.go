@@ -0,0 +1,79 @@
+// Package lockedfile serializes the updates to a json file in the client repo
+// that may be shared by several boost processes (eg the ledger of retrieval
+// spend, and the API tokens with their daily usage).
+package lockedfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// File is a json file whose updates are serialized within the process with
+// a mutex, and across processes with a lock on a file alongside it
+type File struct {
+	path string
+	lk   sync.Mutex
+}
+
+func New(path string) *File {
+	return &File{path: path}
+}
+
+// Path returns the path of the file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Locked calls fn while holding the lock on the file
+func (f *File) Locked(fn func() error) error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	lf, err := os.OpenFile(f.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("opening lock on %s: %w", f.path, err)
+	}
+	defer lf.Close() //nolint:errcheck
+	if err := syscall.Flock(int(lf.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("locking %s: %w", f.path, err)
+	}
+	defer syscall.Flock(int(lf.Fd()), syscall.LOCK_UN) //nolint:errcheck
+
+	return fn()
+}
+
+// Read parses the json in the file into v. It returns false if the file
+// doesn't exist. It must be called while holding the lock.
+func (f *File) Read(v interface{}) (bool, error) {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return true, nil
+}
+
+// Write writes v to the file as json. It must be called while holding the
+// lock.
+func (f *File) Write(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing %s: %w", f.path, err)
+	}
+	// Write to a temporary file and rename it, so that the file is never
+	// left partially written
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,43 @@
+package lockedfile
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	req := require.New(t)
+	f := New(filepath.Join(t.TempDir(), "counter.json"))
+
+	// A file that doesn't exist yet is reported as not found
+	var n int
+	found, err := f.Read(&n)
+	req.NoError(err)
+	req.False(found)
+
+	// Concurrent updates are serialized
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := f.Locked(func() error {
+				var n int
+				if _, err := f.Read(&n); err != nil {
+					return err
+				}
+				return f.Write(n + 1)
+			})
+			req.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	found, err = f.Read(&n)
+	req.NoError(err)
+	req.True(found)
+	req.Equal(10, n)
+}
@@ -0,0 +1,95 @@
+// Package logctx carries correlation IDs, such as a deal uuid or a transfer
+// id, in a context so that every log line written while working on a deal or
+// transfer includes them. Log aggregation tools can then group the log lines
+// of a deal or transfer by its correlation IDs.
+package logctx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+)
+
+// The keys of the correlation IDs in log lines
+const (
+	DealKey     = "dealUuid"
+	TransferKey = "transferId"
+	JobKey      = "jobId"
+)
+
+type fieldsKey struct{}
+
+// With returns a context that carries the key / value pairs in addition to
+// the correlation IDs already in ctx. A key that is already in ctx is
+// overwritten.
+func With(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	existing := Fields(ctx)
+	fields := make([]interface{}, 0, len(existing)+len(keysAndValues))
+	for i := 0; i+1 < len(existing); i += 2 {
+		if !hasKey(keysAndValues, existing[i]) {
+			fields = append(fields, existing[i], existing[i+1])
+		}
+	}
+	fields = append(fields, keysAndValues...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+func hasKey(keysAndValues []interface{}, key interface{}) bool {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if keysAndValues[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// WithDeal returns a context that carries the deal uuid
+func WithDeal(ctx context.Context, dealUuid uuid.UUID) context.Context {
+	return With(ctx, DealKey, dealUuid.String())
+}
+
+// WithTransfer returns a context that carries the transfer id
+func WithTransfer(ctx context.Context, transferID string) context.Context {
+	return With(ctx, TransferKey, transferID)
+}
+
+// Fields returns the correlation IDs in ctx as key / value pairs
+func Fields(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
+
+// Logger returns a logger that adds the correlation IDs in ctx to each line
+func Logger(ctx context.Context, l *logging.ZapEventLogger) *zap.SugaredLogger {
+	return l.With(Fields(ctx)...)
+}
+
+// The log output formats
+const (
+	FormatColor = "color"
+	FormatText  = "text"
+	FormatJSON  = "json"
+)
+
+// SetupFormat sets the format of the log output. The json format writes one
+// json object per line, which can be collected by journald and other log
+// aggregation tools. It keeps the rest of the logging config (eg levels set
+// with GOLOG_LOG_LEVEL).
+func SetupFormat(format string) error {
+	cfg := logging.GetConfig()
+	switch format {
+	case FormatColor:
+		cfg.Format = logging.ColorizedOutput
+	case FormatText:
+		cfg.Format = logging.PlaintextOutput
+	case FormatJSON:
+		cfg.Format = logging.JSONOutput
+	default:
+		return fmt.Errorf("unrecognized log format '%s': must be one of %s, %s, %s", format, FormatColor, FormatText, FormatJSON)
+	}
+	logging.SetupLogging(cfg)
+	return nil
+}
@@ -0,0 +1,31 @@
+package logctx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWith(t *testing.T) {
+	ctx := context.Background()
+	require.Empty(t, Fields(ctx))
+
+	dealUuid := uuid.New()
+	dealCtx := WithDeal(With(ctx, JobKey, "job"), dealUuid)
+	require.Equal(t, []interface{}{JobKey, "job", DealKey, dealUuid.String()}, Fields(dealCtx))
+
+	// A key that is already in the context is overwritten
+	tctx := WithTransfer(WithTransfer(dealCtx, "a"), "b")
+	require.Equal(t, []interface{}{JobKey, "job", DealKey, dealUuid.String(), TransferKey, "b"}, Fields(tctx))
+
+	// The parent context is unchanged
+	require.Len(t, Fields(dealCtx), 4)
+}
+
+func TestSetupFormat(t *testing.T) {
+	require.NoError(t, SetupFormat(FormatJSON))
+	require.NoError(t, SetupFormat(FormatColor))
+	require.Error(t, SetupFormat("xml"))
+}
@@ -0,0 +1,287 @@
+// Package multiminer serves retrievals for the deals of several miners.
+//
+// Sector numbers are only unique per miner, so each miner has its own piece
+// store (which records the sectors that its deals are in) and its own dagstore
+// mount API (which reads pieces from its sectors). MinerAPIs and PieceStores
+// combine them, so that the dagstore and the retrieval provider can read the
+// pieces of all the miners.
+package multiminer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("multiminer")
+
+// MinerAPI reads the pieces in the sectors of a single miner (the lotus
+// markets/dagstore MinerAPI)
+type MinerAPI interface {
+	FetchUnsealedPiece(ctx context.Context, pieceCid cid.Cid) (mount.Reader, error)
+	GetUnpaddedCARSize(ctx context.Context, pieceCid cid.Cid) (uint64, error)
+	IsUnsealed(ctx context.Context, pieceCid cid.Cid) (bool, error)
+	Start(ctx context.Context) error
+}
+
+// MinerAPIs is a MinerAPI that reads a piece from the sectors of any of the
+// miners that store it, preferring a miner with an unsealed copy
+type MinerAPIs struct {
+	lk   sync.RWMutex
+	apis []MinerAPI
+}
+
+// NewMinerAPIs creates a MinerAPIs with the MinerAPI of the provider's own
+// miner. The MinerAPIs of other miners are added with Add.
+func NewMinerAPIs(primary MinerAPI) *MinerAPIs {
+	return &MinerAPIs{apis: []MinerAPI{primary}}
+}
+
+// Add adds the MinerAPI of a miner
+func (m *MinerAPIs) Add(api MinerAPI) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.apis = append(m.apis, api)
+}
+
+func (m *MinerAPIs) all() []MinerAPI {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+
+	return append([]MinerAPI{}, m.apis...)
+}
+
+// Start does nothing: each miner's MinerAPI is started once its piece
+// store is ready
+func (m *MinerAPIs) Start(ctx context.Context) error {
+	return nil
+}
+
+// IsUnsealed returns true if any miner has an unsealed copy of the piece.
+// An error is only returned if the check failed for every miner.
+func (m *MinerAPIs) IsUnsealed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	var merr *multierror.Error
+	apis := m.all()
+	for _, api := range apis {
+		isUnsealed, err := api.IsUnsealed(ctx, pieceCid)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		if isUnsealed {
+			return true, nil
+		}
+	}
+	if merr.Len() == len(apis) {
+		return false, merr
+	}
+	return false, nil
+}
+
+func (m *MinerAPIs) FetchUnsealedPiece(ctx context.Context, pieceCid cid.Cid) (mount.Reader, error) {
+	apis := m.all()
+
+	// prefer a miner with an unsealed copy of the piece, so that no miner
+	// unseals a sector while another has the piece unsealed
+	for _, api := range apis {
+		isUnsealed, err := api.IsUnsealed(ctx, pieceCid)
+		if err != nil || !isUnsealed {
+			continue
+		}
+		r, err := api.FetchUnsealedPiece(ctx, pieceCid)
+		if err == nil {
+			return r, nil
+		}
+		log.Warnw("failed to fetch unsealed piece", "piece", pieceCid, "err", err)
+	}
+
+	lastErr := fmt.Errorf("no miner stores piece %s", pieceCid)
+	for _, api := range apis {
+		r, err := api.FetchUnsealedPiece(ctx, pieceCid)
+		if err == nil {
+			return r, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (m *MinerAPIs) GetUnpaddedCARSize(ctx context.Context, pieceCid cid.Cid) (uint64, error) {
+	lastErr := fmt.Errorf("no miner stores piece %s", pieceCid)
+	for _, api := range m.all() {
+		size, err := api.GetUnpaddedCARSize(ctx, pieceCid)
+		if err == nil {
+			return size, nil
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+// PieceStores is a piece store that reads the deals for a piece from the
+// piece stores of all the miners. It is only used to look up pieces: the
+// deals of each miner are added to the miner's own piece store, so writes
+// go to the piece store of the provider's own miner.
+type PieceStores struct {
+	lk     sync.RWMutex
+	stores []piecestore.PieceStore
+}
+
+var _ piecestore.PieceStore = (*PieceStores)(nil)
+
+// NewPieceStores creates a PieceStores with the piece store of the
+// provider's own miner. The piece stores of other miners are added with Add.
+func NewPieceStores(primary piecestore.PieceStore) *PieceStores {
+	return &PieceStores{stores: []piecestore.PieceStore{primary}}
+}
+
+// Add adds the piece store of a miner
+func (p *PieceStores) Add(ps piecestore.PieceStore) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.stores = append(p.stores, ps)
+}
+
+func (p *PieceStores) all() []piecestore.PieceStore {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	return append([]piecestore.PieceStore{}, p.stores...)
+}
+
+// Primary returns the piece store of the provider's own miner
+func (p *PieceStores) Primary() piecestore.PieceStore {
+	return p.all()[0]
+}
+
+// Start does nothing: each piece store is started by its owner
+func (p *PieceStores) Start(ctx context.Context) error {
+	return nil
+}
+
+// OnReady calls ready once all the piece stores are ready, with the first
+// error if any of them failed to start
+func (p *PieceStores) OnReady(ready shared.ReadyFunc) {
+	stores := p.all()
+
+	var lk sync.Mutex
+	var firstErr error
+	remaining := len(stores)
+	for _, ps := range stores {
+		ps.OnReady(func(err error) {
+			lk.Lock()
+			defer lk.Unlock()
+
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			remaining--
+			if remaining == 0 {
+				ready(firstErr)
+			}
+		})
+	}
+}
+
+func (p *PieceStores) AddDealForPiece(pieceCID cid.Cid, dealInfo piecestore.DealInfo) error {
+	return p.Primary().AddDealForPiece(pieceCID, dealInfo)
+}
+
+func (p *PieceStores) AddPieceBlockLocations(pieceCID cid.Cid, blockLocations map[cid.Cid]piecestore.BlockLocation) error {
+	return p.Primary().AddPieceBlockLocations(pieceCID, blockLocations)
+}
+
+// GetPieceInfo returns the deals for the piece from all the miners. The
+// error of the first piece store is returned if no piece store has the
+// piece.
+func (p *PieceStores) GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, error) {
+	var info piecestore.PieceInfo
+	var firstErr error
+	found := false
+	for i, ps := range p.all() {
+		pi, err := ps.GetPieceInfo(pieceCID)
+		if err != nil {
+			if i == 0 {
+				firstErr = err
+			}
+			continue
+		}
+		if !found {
+			info = pi
+			found = true
+			continue
+		}
+		info.Deals = append(info.Deals, pi.Deals...)
+	}
+	if !found {
+		return piecestore.PieceInfoUndefined, firstErr
+	}
+	return info, nil
+}
+
+func (p *PieceStores) GetCIDInfo(payloadCID cid.Cid) (piecestore.CIDInfo, error) {
+	var info piecestore.CIDInfo
+	var firstErr error
+	found := false
+	for i, ps := range p.all() {
+		ci, err := ps.GetCIDInfo(payloadCID)
+		if err != nil {
+			if i == 0 {
+				firstErr = err
+			}
+			continue
+		}
+		if !found {
+			info = ci
+			found = true
+			continue
+		}
+		info.PieceBlockLocations = append(info.PieceBlockLocations, ci.PieceBlockLocations...)
+	}
+	if !found {
+		return piecestore.CIDInfoUndefined, firstErr
+	}
+	return info, nil
+}
+
+func (p *PieceStores) ListCidInfoKeys() ([]cid.Cid, error) {
+	return p.listKeys(func(ps piecestore.PieceStore) ([]cid.Cid, error) {
+		return ps.ListCidInfoKeys()
+	})
+}
+
+func (p *PieceStores) ListPieceInfoKeys() ([]cid.Cid, error) {
+	return p.listKeys(func(ps piecestore.PieceStore) ([]cid.Cid, error) {
+		return ps.ListPieceInfoKeys()
+	})
+}
+
+func (p *PieceStores) listKeys(list func(piecestore.PieceStore) ([]cid.Cid, error)) ([]cid.Cid, error) {
+	seen := make(map[cid.Cid]struct{})
+	var keys []cid.Cid
+	var merr error
+	for _, ps := range p.all() {
+		ks, err := list(ps)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		for _, k := range ks {
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			keys = append(keys, k)
+		}
+	}
+	return keys, merr
+}
@@ -0,0 +1,163 @@
+package multiminer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	piecestoreimpl "github.com/filecoin-project/go-fil-markets/piecestore/impl"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type reader struct {
+	*bytes.Reader
+}
+
+func (r reader) Close() error {
+	return nil
+}
+
+// mockMinerAPI stores pieces in sectors that are either unsealed or sealed
+type mockMinerAPI struct {
+	pieces   map[cid.Cid][]byte
+	unsealed map[cid.Cid]bool
+	// The pieces that were fetched (and unsealed if they were sealed)
+	fetched []cid.Cid
+}
+
+func (m *mockMinerAPI) FetchUnsealedPiece(ctx context.Context, pieceCid cid.Cid) (mount.Reader, error) {
+	data, ok := m.pieces[pieceCid]
+	if !ok {
+		return nil, errors.New("no storage deals found")
+	}
+	m.fetched = append(m.fetched, pieceCid)
+	return reader{bytes.NewReader(data)}, nil
+}
+
+func (m *mockMinerAPI) GetUnpaddedCARSize(ctx context.Context, pieceCid cid.Cid) (uint64, error) {
+	data, ok := m.pieces[pieceCid]
+	if !ok {
+		return 0, errors.New("no storage deals found")
+	}
+	return uint64(len(data)), nil
+}
+
+func (m *mockMinerAPI) IsUnsealed(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	if _, ok := m.pieces[pieceCid]; !ok {
+		return false, errors.New("no storage deals found")
+	}
+	return m.unsealed[pieceCid], nil
+}
+
+func (m *mockMinerAPI) Start(ctx context.Context) error {
+	return nil
+}
+
+func testCid(t *testing.T, s string) cid.Cid {
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func TestMinerAPIs(t *testing.T) {
+	ctx := context.Background()
+
+	shared, onlyAdded, missing := testCid(t, "shared"), testCid(t, "added"), testCid(t, "missing")
+	primary := &mockMinerAPI{
+		pieces:   map[cid.Cid][]byte{shared: []byte("shared")},
+		unsealed: map[cid.Cid]bool{},
+	}
+	added := &mockMinerAPI{
+		pieces:   map[cid.Cid][]byte{shared: []byte("shared"), onlyAdded: []byte("added")},
+		unsealed: map[cid.Cid]bool{shared: true},
+	}
+	apis := NewMinerAPIs(primary)
+	apis.Add(added)
+
+	// The piece is read from the miner with an unsealed copy, rather than
+	// unsealed by the primary miner
+	isUnsealed, err := apis.IsUnsealed(ctx, shared)
+	require.NoError(t, err)
+	require.True(t, isUnsealed)
+	r, err := apis.FetchUnsealedPiece(ctx, shared)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Empty(t, primary.fetched)
+	require.Equal(t, []cid.Cid{shared}, added.fetched)
+
+	// A sealed piece that only an added miner stores is unsealed by it
+	isUnsealed, err = apis.IsUnsealed(ctx, onlyAdded)
+	require.NoError(t, err)
+	require.False(t, isUnsealed)
+	_, err = apis.FetchUnsealedPiece(ctx, onlyAdded)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{shared, onlyAdded}, added.fetched)
+	size, err := apis.GetUnpaddedCARSize(ctx, onlyAdded)
+	require.NoError(t, err)
+	require.EqualValues(t, len("added"), size)
+
+	// A piece that no miner stores
+	_, err = apis.IsUnsealed(ctx, missing)
+	require.Error(t, err)
+	_, err = apis.FetchUnsealedPiece(ctx, missing)
+	require.Error(t, err)
+	_, err = apis.GetUnpaddedCARSize(ctx, missing)
+	require.Error(t, err)
+}
+
+func newPieceStore(t *testing.T) piecestore.PieceStore {
+	ps, err := piecestoreimpl.NewPieceStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, err)
+	ready := make(chan error, 1)
+	ps.OnReady(func(err error) {
+		ready <- err
+	})
+	require.NoError(t, ps.Start(context.Background()))
+	require.NoError(t, <-ready)
+	return ps
+}
+
+func TestPieceStores(t *testing.T) {
+	primary, added := newPieceStore(t), newPieceStore(t)
+	stores := NewPieceStores(primary)
+	stores.Add(added)
+
+	shared, onlyAdded, missing := testCid(t, "shared"), testCid(t, "added"), testCid(t, "missing")
+
+	// Both miners have sector 1, holding different pieces
+	require.NoError(t, primary.AddDealForPiece(shared, piecestore.DealInfo{DealID: 1, SectorID: 1, Length: 128}))
+	require.NoError(t, added.AddDealForPiece(shared, piecestore.DealInfo{DealID: 2, SectorID: 5, Length: 128}))
+	require.NoError(t, added.AddDealForPiece(onlyAdded, piecestore.DealInfo{DealID: 3, SectorID: 1, Length: 256}))
+
+	pi, err := stores.GetPieceInfo(shared)
+	require.NoError(t, err)
+	require.Len(t, pi.Deals, 2)
+	require.ElementsMatch(t, []abi.DealID{1, 2}, []abi.DealID{pi.Deals[0].DealID, pi.Deals[1].DealID})
+
+	pi, err = stores.GetPieceInfo(onlyAdded)
+	require.NoError(t, err)
+	require.Len(t, pi.Deals, 1)
+	require.EqualValues(t, 3, pi.Deals[0].DealID)
+
+	_, err = stores.GetPieceInfo(missing)
+	require.Error(t, err)
+
+	keys, err := stores.ListPieceInfoKeys()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{shared, onlyAdded}, keys)
+
+	// Writes go to the primary miner's piece store
+	require.NoError(t, stores.AddDealForPiece(missing, piecestore.DealInfo{DealID: 4, SectorID: 2, Length: 128}))
+	_, err = primary.GetPieceInfo(missing)
+	require.NoError(t, err)
+	_, err = added.GetPieceInfo(missing)
+	require.Error(t, err)
+}
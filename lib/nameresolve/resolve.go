@@ -0,0 +1,224 @@
+// Package nameresolve resolves mutable names (DNSLink domains and IPNS names)
+// to the root CID that they currently point to, so that the latest version
+// of a dataset can be retrieved.
+package nameresolve
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+const (
+	SchemeDNSLink = "dnslink://"
+	SchemeIPNS    = "ipns://"
+)
+
+const (
+	MethodDNSLink = "dnslink"
+	MethodIPNS    = "ipns"
+)
+
+// maxDepth is the maximum number of names that will be followed when a name
+// points to another name
+const maxDepth = 32
+
+// Step is a single name lookup made while resolving a target
+type Step struct {
+	// The name that was looked up, eg a domain or an IPNS name
+	Name string `json:"name"`
+	// The method used to look up the name: dnslink or ipns
+	Method string `json:"method"`
+	// The path that the name pointed to, eg /ipfs/<cid> or /ipns/<name>
+	Value string `json:"value"`
+	// For IPNS records, the sequence number and expiry of the record
+	Sequence uint64    `json:"sequence,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+// Resolution is the result of resolving a target, and records how the target
+// was resolved
+type Resolution struct {
+	Target string  `json:"target"`
+	Root   cid.Cid `json:"root"`
+	// The path under the root, if the name pointed to a sub-path
+	Path       string    `json:"path,omitempty"`
+	Steps      []Step    `json:"steps"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// IsName returns true if the target is a DNSLink or IPNS name rather than a
+// CID
+func IsName(target string) bool {
+	return strings.HasPrefix(target, SchemeDNSLink) || strings.HasPrefix(target, SchemeIPNS)
+}
+
+type Option func(*Resolver)
+
+// TXTLookup sets the function used to look up DNS TXT records
+func TXTLookup(lookup func(ctx context.Context, name string) ([]string, error)) Option {
+	return func(r *Resolver) {
+		r.lookupTXT = lookup
+	}
+}
+
+// ValueStore sets the routing system from which IPNS records are fetched
+// (usually the IPFS DHT). Without a value store IPNS names that are not
+// domains cannot be resolved.
+func ValueStore(vs routing.ValueStore) Option {
+	return func(r *Resolver) {
+		r.valueStore = vs
+	}
+}
+
+type Resolver struct {
+	lookupTXT  func(ctx context.Context, name string) ([]string, error)
+	valueStore routing.ValueStore
+}
+
+func NewResolver(opts ...Option) *Resolver {
+	r := &Resolver{lookupTXT: net.DefaultResolver.LookupTXT}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Resolve resolves a target of the form dnslink://<domain> or
+// ipns://<name> to the root CID it currently points to
+func (r *Resolver) Resolve(ctx context.Context, target string) (*Resolution, error) {
+	var value string
+	switch {
+	case strings.HasPrefix(target, SchemeDNSLink):
+		value = "/dnslink/" + strings.TrimPrefix(target, SchemeDNSLink)
+	case strings.HasPrefix(target, SchemeIPNS):
+		value = "/ipns/" + strings.TrimPrefix(target, SchemeIPNS)
+	default:
+		return nil, fmt.Errorf("%s is not a dnslink:// or ipns:// name", target)
+	}
+
+	res := &Resolution{Target: target, ResolvedAt: time.Now()}
+	for depth := 0; ; depth++ {
+		if depth == maxDepth {
+			return nil, fmt.Errorf("resolving %s: exceeded maximum depth of %d names", target, maxDepth)
+		}
+
+		ns, name, rest := splitPath(value)
+		if name == "" {
+			return nil, fmt.Errorf("resolving %s: invalid path %s", target, value)
+		}
+
+		var step *Step
+		var err error
+		switch ns {
+		case "ipfs":
+			c, perr := cid.Parse(name)
+			if perr != nil {
+				return nil, fmt.Errorf("resolving %s: parsing cid in %s: %w", target, value, perr)
+			}
+			res.Root = c
+			res.Path = rest
+			return res, nil
+		case "dnslink":
+			step, err = r.resolveDNSLink(ctx, name)
+		case "ipns":
+			// An IPNS name may be a peer ID (or a libp2p-key CID) or a domain
+			if pid, perr := peer.Decode(name); perr == nil {
+				step, err = r.resolveIPNS(ctx, name, pid)
+			} else {
+				step, err = r.resolveDNSLink(ctx, name)
+			}
+		default:
+			return nil, fmt.Errorf("resolving %s: unsupported namespace in %s", target, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", target, err)
+		}
+
+		res.Steps = append(res.Steps, *step)
+		value = step.Value + rest
+	}
+}
+
+func (r *Resolver) resolveDNSLink(ctx context.Context, domain string) (*Step, error) {
+	// Look up the _dnslink subdomain first, then fall back to the domain
+	// itself
+	var lastErr error
+	for _, name := range []string{"_dnslink." + domain, domain} {
+		txts, err := r.lookupTXT(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, txt := range txts {
+			if !strings.HasPrefix(txt, "dnslink=") {
+				continue
+			}
+			value := strings.TrimPrefix(txt, "dnslink=")
+			if !strings.HasPrefix(value, "/ipfs/") && !strings.HasPrefix(value, "/ipns/") {
+				continue
+			}
+			return &Step{Name: domain, Method: MethodDNSLink, Value: value}, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("looking up dnslink for %s: %w", domain, lastErr)
+	}
+	return nil, fmt.Errorf("no dnslink TXT record found for %s", domain)
+}
+
+func (r *Resolver) resolveIPNS(ctx context.Context, name string, pid peer.ID) (*Step, error) {
+	if r.valueStore == nil {
+		return nil, fmt.Errorf("cannot resolve IPNS name %s: no routing system", name)
+	}
+
+	key := ipns.RecordKey(pid)
+	val, err := r.valueStore.GetValue(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("getting IPNS record for %s: %w", name, err)
+	}
+
+	// Validate the record, in case the value store doesn't
+	if err := (ipns.Validator{}).Validate(key, val); err != nil {
+		return nil, fmt.Errorf("validating IPNS record for %s: %w", name, err)
+	}
+	entry := new(ipnspb.IpnsEntry)
+	if err := proto.Unmarshal(val, entry); err != nil {
+		return nil, fmt.Errorf("unmarshalling IPNS record for %s: %w", name, err)
+	}
+
+	step := &Step{
+		Name:     name,
+		Method:   MethodIPNS,
+		Value:    string(entry.GetValue()),
+		Sequence: entry.GetSequence(),
+	}
+	if eol, err := ipns.GetEOL(entry); err == nil {
+		step.Expires = eol
+	}
+	return step, nil
+}
+
+// splitPath splits a path like /ipfs/<cid>/some/path into its namespace,
+// name and the rest of the path
+func splitPath(p string) (string, string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 {
+		return "", "", ""
+	}
+	ns, name := parts[0], strings.TrimSuffix(parts[1], ".")
+	var rest string
+	if len(parts) == 3 {
+		rest = "/" + parts[2]
+	}
+	return ns, name, rest
+}
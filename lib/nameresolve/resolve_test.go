@@ -0,0 +1,113 @@
+package nameresolve
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipns"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type mockValueStore map[string][]byte
+
+func (m mockValueStore) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	m[key] = val
+	return nil
+}
+
+func (m mockValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	val, ok := m[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return val, nil
+}
+
+func (m mockValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	root := testCid(t, "root")
+
+	// Create an IPNS record that points to the root cid
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	req.NoError(err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	req.NoError(err)
+	entry, err := ipns.Create(sk, []byte("/ipfs/"+root.String()), 3, time.Now().Add(time.Hour), time.Minute)
+	req.NoError(err)
+	rec, err := proto.Marshal(entry)
+	req.NoError(err)
+	vs := mockValueStore{ipns.RecordKey(pid): rec}
+
+	txts := map[string][]string{
+		"_dnslink.direct.example.com":  {"v=spf1 -all", "dnslink=/ipfs/" + root.String() + "/data"},
+		"apex.example.com":             {"dnslink=/ipfs/" + root.String()},
+		"_dnslink.viaipns.example.com": {"dnslink=/ipns/" + peer.ToCid(pid).String()},
+		"_dnslink.loop.example.com":    {"dnslink=/ipns/loop.example.com"},
+	}
+	lookup := func(ctx context.Context, name string) ([]string, error) {
+		if txt, ok := txts[name]; ok {
+			return txt, nil
+		}
+		return nil, fmt.Errorf("no such host %s", name)
+	}
+	r := NewResolver(TXTLookup(lookup), ValueStore(vs))
+
+	res, err := r.Resolve(ctx, "dnslink://direct.example.com")
+	req.NoError(err)
+	req.Equal(root, res.Root)
+	req.Equal("/data", res.Path)
+	req.Len(res.Steps, 1)
+	req.Equal(MethodDNSLink, res.Steps[0].Method)
+
+	// Falls back to the TXT record of the domain itself
+	res, err = r.Resolve(ctx, "dnslink://apex.example.com")
+	req.NoError(err)
+	req.Equal(root, res.Root)
+
+	// DNSLink that points to an IPNS name
+	res, err = r.Resolve(ctx, "ipns://viaipns.example.com")
+	req.NoError(err)
+	req.Equal(root, res.Root)
+	req.Len(res.Steps, 2)
+	req.Equal(MethodIPNS, res.Steps[1].Method)
+	req.EqualValues(3, res.Steps[1].Sequence)
+
+	res, err = r.Resolve(ctx, "ipns://"+pid.String())
+	req.NoError(err)
+	req.Equal(root, res.Root)
+
+	_, err = r.Resolve(ctx, "dnslink://loop.example.com")
+	req.ErrorContains(err, "maximum depth")
+
+	_, err = r.Resolve(ctx, "dnslink://missing.example.com")
+	req.Error(err)
+
+	// A record that has been tampered with should fail validation
+	entry.Value = []byte("/ipfs/" + testCid(t, "other").String())
+	rec, err = proto.Marshal(entry)
+	req.NoError(err)
+	vs[ipns.RecordKey(pid)] = rec
+	_, err = r.Resolve(ctx, "ipns://"+pid.String())
+	req.ErrorContains(err, "validating")
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
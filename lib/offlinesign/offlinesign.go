@@ -0,0 +1,183 @@
+// Package offlinesign lets a client make deals with a wallet key that never
+// lives on a networked host. The client exports the unsigned deal as a
+// bundle, the bundle is carried to an air-gapped machine where the proposal
+// is signed, and the signature is carried back and attached to the bundle so
+// that the deal can be sent to the provider.
+package offlinesign
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/google/uuid"
+)
+
+// Version is the version of the bundle and signature formats
+const Version = 1
+
+// Bundle is a deal whose proposal has not been signed by the client
+type Bundle struct {
+	Version int `json:"version"`
+	// The deal to send to the provider once the proposal has been signed.
+	// The client signature is empty.
+	DealParams types.DealParams `json:"dealParams"`
+	// ProposalBytes is the cbor encoding of the deal proposal, which is
+	// what the client signs
+	ProposalBytes []byte    `json:"proposalBytes"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Signature is the client's signature over the proposal in a bundle
+type Signature struct {
+	Version  int       `json:"version"`
+	DealUUID uuid.UUID `json:"dealUuid"`
+	// The address of the client wallet that signed the proposal
+	Client    string           `json:"client"`
+	Signature crypto.Signature `json:"signature"`
+	SignedAt  time.Time        `json:"signedAt"`
+}
+
+// NewBundle creates a bundle for the deal. Any client signature in the deal
+// params is discarded.
+func NewBundle(params types.DealParams) (*Bundle, error) {
+	params.ClientDealProposal.ClientSignature = crypto.Signature{}
+	buf, err := cborutil.Dump(&params.ClientDealProposal.Proposal)
+	if err != nil {
+		return nil, fmt.Errorf("serializing deal proposal: %w", err)
+	}
+	return &Bundle{
+		Version:       Version,
+		DealParams:    params,
+		ProposalBytes: buf,
+		CreatedAt:     time.Now().UTC(),
+	}, nil
+}
+
+// Proposal returns the proposal that the client signs. It returns an error
+// if the bundle's proposal bytes don't match its deal params (eg because the
+// bundle was edited), so that the signer never signs anything other than
+// the deal that will be sent.
+func (b *Bundle) Proposal() (*market.DealProposal, error) {
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	var proposal market.DealProposal
+	if err := proposal.UnmarshalCBOR(bytes.NewReader(b.ProposalBytes)); err != nil {
+		return nil, fmt.Errorf("parsing deal proposal: %w", err)
+	}
+	buf, err := cborutil.Dump(&b.DealParams.ClientDealProposal.Proposal)
+	if err != nil {
+		return nil, fmt.Errorf("serializing deal proposal: %w", err)
+	}
+	if !bytes.Equal(buf, b.ProposalBytes) {
+		return nil, fmt.Errorf("deal %s: the proposal bytes don't match the deal params", b.DealParams.DealUUID)
+	}
+	return &proposal, nil
+}
+
+// SignFn signs msg with the private key for addr
+type SignFn func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error)
+
+// Sign signs the bundle's proposal with the key of the proposal's client
+func Sign(ctx context.Context, sign SignFn, b *Bundle) (*Signature, error) {
+	proposal, err := b.Proposal()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(ctx, proposal.Client, b.ProposalBytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing deal proposal with %s: %w", proposal.Client, err)
+	}
+	return &Signature{
+		Version:   Version,
+		DealUUID:  b.DealParams.DealUUID,
+		Client:    proposal.Client.String(),
+		Signature: *sig,
+		SignedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// Attach checks that the signature is the client's signature over the
+// bundle's proposal, and returns the deal params with the signature attached
+func (b *Bundle) Attach(s *Signature) (*types.DealParams, error) {
+	if s.Version != Version {
+		return nil, fmt.Errorf("unsupported signature version %d", s.Version)
+	}
+	if s.DealUUID != b.DealParams.DealUUID {
+		return nil, fmt.Errorf("the signature is for deal %s, not deal %s", s.DealUUID, b.DealParams.DealUUID)
+	}
+	proposal, err := b.Proposal()
+	if err != nil {
+		return nil, err
+	}
+	if s.Client != proposal.Client.String() {
+		return nil, fmt.Errorf("the signature is from %s, but the deal client is %s", s.Client, proposal.Client)
+	}
+	if err := sigs.Verify(&s.Signature, proposal.Client, b.ProposalBytes); err != nil {
+		return nil, fmt.Errorf("invalid deal proposal signature for client %s: %w", proposal.Client, err)
+	}
+
+	params := b.DealParams
+	params.ClientDealProposal.ClientSignature = s.Signature
+	return &params, nil
+}
+
+// WriteBundle writes the bundle to path
+func WriteBundle(path string, b *Bundle) error {
+	return writeJSON(path, b)
+}
+
+// ReadBundle reads a bundle from path
+func ReadBundle(path string) (*Bundle, error) {
+	var b Bundle
+	if err := readJSON(path, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// WriteSignature writes the signature to path
+func WriteSignature(path string, s *Signature) error {
+	return writeJSON(path, s)
+}
+
+// ReadSignature reads a signature from path
+func ReadSignature(path string) (*Signature, error) {
+	var s Signature
+	if err := readJSON(path, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
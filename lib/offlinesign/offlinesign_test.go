@@ -0,0 +1,101 @@
+package offlinesign
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/go-state-types/crypto"
+	lotus_types "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet/key"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndAttach(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	k, err := key.GenerateKey(lotus_types.KTSecp256k1)
+	req.NoError(err)
+	sign := func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+		req.Equal(k.Address, addr)
+		return sigs.Sign(crypto.SigTypeSecp256k1, k.PrivateKey, msg)
+	}
+
+	provider, err := address.NewIDAddress(1000)
+	req.NoError(err)
+	label, err := market.NewLabelFromString("label")
+	req.NoError(err)
+	params := types.DealParams{
+		DealUUID: uuid.New(),
+		ClientDealProposal: market.ClientDealProposal{
+			Proposal: market.DealProposal{
+				PieceCID:             testutil.GenerateCid(),
+				PieceSize:            abi.PaddedPieceSize(2048),
+				Client:               k.Address,
+				Provider:             provider,
+				Label:                label,
+				StartEpoch:           100,
+				EndEpoch:             1000,
+				StoragePricePerEpoch: big.NewInt(1),
+				ProviderCollateral:   big.NewInt(2),
+				ClientCollateral:     big.Zero(),
+			},
+		},
+		DealDataRoot: testutil.GenerateCid(),
+		IsOffline:    true,
+	}
+
+	// The bundle and signature are carried between machines as files
+	dir := t.TempDir()
+	b, err := NewBundle(params)
+	req.NoError(err)
+	req.NoError(WriteBundle(filepath.Join(dir, "deal.json"), b))
+	b, err = ReadBundle(filepath.Join(dir, "deal.json"))
+	req.NoError(err)
+
+	sig, err := Sign(ctx, sign, b)
+	req.NoError(err)
+	req.NoError(WriteSignature(filepath.Join(dir, "deal.sig.json"), sig))
+	sig, err = ReadSignature(filepath.Join(dir, "deal.sig.json"))
+	req.NoError(err)
+
+	signed, err := b.Attach(sig)
+	req.NoError(err)
+	req.Equal(sig.Signature, signed.ClientDealProposal.ClientSignature)
+	req.Equal(params.ClientDealProposal.Proposal.PieceCID, signed.ClientDealProposal.Proposal.PieceCID)
+
+	// A bundle whose deal params were changed after the proposal was
+	// serialized is refused by the signer
+	edited := *b
+	edited.DealParams.ClientDealProposal.Proposal.StoragePricePerEpoch = big.NewInt(100)
+	_, err = Sign(ctx, sign, &edited)
+	req.Error(err)
+	_, err = edited.Attach(sig)
+	req.Error(err)
+
+	// A signature for another deal is refused
+	other := *sig
+	other.DealUUID = uuid.New()
+	_, err = b.Attach(&other)
+	req.Error(err)
+
+	// A signature by another key is refused
+	k2, err := key.GenerateKey(lotus_types.KTSecp256k1)
+	req.NoError(err)
+	forged, err := sigs.Sign(crypto.SigTypeSecp256k1, k2.PrivateKey, b.ProposalBytes)
+	req.NoError(err)
+	other = *sig
+	other.Signature = *forged
+	_, err = b.Attach(&other)
+	req.Error(err)
+}
@@ -0,0 +1,272 @@
+// Package openapi builds OpenAPI 3 documents that describe the REST APIs
+// served by boost, so that typed clients can be generated for them (eg with
+// openapi-generator for Python or TypeScript).
+//
+// The schemas of request and response bodies are reflected from the Go
+// types that the handlers encode and decode, so the document stays in sync
+// with the handlers.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("openapi")
+
+// Version is the version of the OpenAPI specification of the documents
+const Version = "3.0.3"
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// Document is an OpenAPI document. It is served as json by ServeHTTP.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	Security   []map[string][]string            `json:"security,omitempty"`
+
+	reflector *jsonschema.Reflector
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*jsonschema.Type `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme   `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// Operation is an API method on a path
+type Operation struct {
+	// The name of the method in generated clients
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string           `json:"name"`
+	In          string           `json:"in"`
+	Description string           `json:"description,omitempty"`
+	Required    bool             `json:"required,omitempty"`
+	Schema      *jsonschema.Type `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *jsonschema.Type `json:"schema"`
+}
+
+// New creates an empty document for an API
+func New(title, description, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Description: description, Version: version},
+		Paths:   make(map[string]map[string]*Operation),
+		Components: Components{
+			Schemas: make(map[string]*jsonschema.Type),
+		},
+		reflector: &jsonschema.Reflector{
+			AllowAdditionalProperties: true,
+			TypeMapper:                TypeMapper,
+		},
+	}
+}
+
+// BearerAuth declares that requests must have an Authorization header with a
+// bearer token
+func (d *Document) BearerAuth() {
+	d.Components.SecuritySchemes = map[string]SecurityScheme{
+		"bearerAuth": {Type: "http", Scheme: "bearer"},
+	}
+	d.Security = []map[string][]string{{"bearerAuth": {}}}
+}
+
+// Add adds an operation to the document. The path is in gorilla mux syntax,
+// which is the same as OpenAPI syntax for path parameters (eg /jobs/{id}).
+func (d *Document) Add(method, path string, op Operation) {
+	ops, ok := d.Paths[path]
+	if !ok {
+		ops = make(map[string]*Operation)
+		d.Paths[path] = ops
+	}
+	ops[strings.ToLower(method)] = &op
+}
+
+// Schema returns the schema of the value's type. Named struct types are added
+// to the document's component schemas, and the returned schema references
+// them.
+func (d *Document) Schema(v interface{}) *jsonschema.Type {
+	s := d.reflector.Reflect(v)
+	for name, def := range s.Definitions {
+		rewriteRefs(def)
+		d.Components.Schemas[name] = def
+	}
+	t := s.Type
+	rewriteRefs(t)
+	return t
+}
+
+// Enum adds a string enum to the component schemas, and returns a reference
+// to it
+func (d *Document) Enum(name, description string, values ...string) *jsonschema.Type {
+	enum := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		enum = append(enum, v)
+	}
+	d.Components.Schemas[name] = &jsonschema.Type{Type: "string", Description: description, Enum: enum}
+	return &jsonschema.Type{Ref: schemaRefPrefix + name}
+}
+
+// SetProperty replaces the schema of a property of a component schema, eg
+// to make a string field that holds one of a set of states refer to an
+// enum. Call it once all the operations have been added, as reflecting the
+// component's type again replaces it. It panics if the component or property
+// does not exist, as that is a mistake in the code that describes the API.
+func (d *Document) SetProperty(component, property string, t *jsonschema.Type) {
+	c, ok := d.Components.Schemas[component]
+	if !ok || c.Properties == nil {
+		panic("openapi: no component schema " + component)
+	}
+	if _, ok := c.Properties.Get(property); !ok {
+		panic("openapi: component schema " + component + " has no property " + property)
+	}
+	c.Properties.Set(property, t)
+}
+
+// JSONBody returns a required json request body with the value's schema
+func (d *Document) JSONBody(v interface{}) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]MediaType{"application/json": {Schema: d.Schema(v)}},
+	}
+}
+
+// JSON returns a response with a json body with the value's schema
+func (d *Document) JSON(description string, v interface{}) Response {
+	return Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: d.Schema(v)}},
+	}
+}
+
+// Error returns a response with a json error body: {"error": "<message>"}
+func (d *Document) Error(description string) Response {
+	return d.JSON(description, ErrorResponse{})
+}
+
+// ErrorResponse is the body of an error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Binary returns a response with a binary body of the content type
+func Binary(description, contentType string) Response {
+	return Response{
+		Description: description,
+		Content: map[string]MediaType{
+			contentType: {Schema: &jsonschema.Type{Type: "string", Format: "binary"}},
+		},
+	}
+}
+
+// PathParam returns a required path parameter
+func PathParam(name, description string, t *jsonschema.Type) Parameter {
+	return Parameter{Name: name, In: "path", Description: description, Required: true, Schema: t}
+}
+
+// QueryParam returns an optional query parameter
+func QueryParam(name, description string, t *jsonschema.Type) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: t}
+}
+
+// ServeHTTP serves the document as json
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		log.Warnw("writing openapi document", "err", err)
+	}
+}
+
+// TypeMapper maps types that have custom json marshalling to the type they
+// are marshalled as
+func TypeMapper(t reflect.Type) *jsonschema.Type {
+	switch t {
+	case reflect.TypeOf(cid.Cid{}):
+		// cids are marshalled as {"/": "<cid>"}
+		return &jsonschema.Type{
+			Type:              "object",
+			Required:          []string{"/"},
+			PatternProperties: map[string]*jsonschema.Type{"^/$": {Type: "string"}},
+		}
+	case reflect.TypeOf(big.Int{}):
+		return &jsonschema.Type{Type: "string", Description: "attoFIL"}
+	case reflect.TypeOf(address.Address{}):
+		return &jsonschema.Type{Type: "string"}
+	case reflect.TypeOf(uuid.UUID{}):
+		return &jsonschema.Type{Type: "string", Format: "uuid"}
+	}
+	return nil
+}
+
+// rewriteRefs points the references to json schema definitions at the
+// document's component schemas, and removes the json schema version, which
+// is not allowed in OpenAPI schemas
+func rewriteRefs(t *jsonschema.Type) {
+	if t == nil {
+		return
+	}
+	t.Version = ""
+	if strings.HasPrefix(t.Ref, "#/definitions/") {
+		t.Ref = schemaRefPrefix + strings.TrimPrefix(t.Ref, "#/definitions/")
+	}
+	rewriteRefs(t.Items)
+	rewriteRefs(t.AdditionalItems)
+	rewriteRefs(t.Not)
+	for _, ts := range [][]*jsonschema.Type{t.AllOf, t.AnyOf, t.OneOf} {
+		for _, sub := range ts {
+			rewriteRefs(sub)
+		}
+	}
+	for _, sub := range t.PatternProperties {
+		rewriteRefs(sub)
+	}
+	if t.Properties != nil {
+		for _, k := range t.Properties.Keys() {
+			v, _ := t.Properties.Get(k)
+			if sub, ok := v.(*jsonschema.Type); ok {
+				rewriteRefs(sub)
+			}
+		}
+	}
+}
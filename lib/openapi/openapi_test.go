@@ -0,0 +1,71 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name     string          `json:"name"`
+	Provider address.Address `json:"provider"`
+	Root     cid.Cid         `json:"root"`
+	State    string          `json:"state"`
+	Parts    []part          `json:"parts,omitempty"`
+}
+
+type part struct {
+	Size int64 `json:"size"`
+}
+
+func TestDocument(t *testing.T) {
+	req := require.New(t)
+
+	d := New("test", "", "1.0.0")
+	d.BearerAuth()
+	d.Add(http.MethodGet, "/items", Operation{
+		OperationID: "listItems",
+		Responses:   map[string]Response{"200": d.JSON("the items", []item{})},
+	})
+	d.Add(http.MethodPost, "/items", Operation{
+		OperationID: "addItem",
+		RequestBody: d.JSONBody(item{}),
+		Responses:   map[string]Response{"400": d.Error("the item is not valid")},
+	})
+	d.SetProperty("item", "state", d.Enum("ItemState", "", "new", "done"))
+	req.Panics(func() { d.SetProperty("item", "missing", nil) })
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	req.Equal("application/json", w.Header().Get("Content-Type"))
+
+	var doc map[string]interface{}
+	req.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+	req.Equal(Version, doc["openapi"])
+
+	// References point at the component schemas, and have no json schema
+	// version
+	get := doc["paths"].(map[string]interface{})["/items"].(map[string]interface{})["get"].(map[string]interface{})
+	schema := get["responses"].(map[string]interface{})["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	req.Equal(map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"$ref": "#/components/schemas/item"},
+	}, schema)
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	props := schemas["item"].(map[string]interface{})["properties"].(map[string]interface{})
+	req.Equal(map[string]interface{}{"type": "string"}, props["provider"])
+	req.Equal(map[string]interface{}{"$ref": "#/components/schemas/ItemState"}, props["state"])
+	req.Equal(map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"$ref": "#/components/schemas/part"},
+	}, props["parts"])
+	req.Contains(schemas, "part")
+	req.Contains(schemas, "ErrorResponse")
+	req.Equal([]interface{}{"new", "done"}, schemas["ItemState"].(map[string]interface{})["enum"])
+}
@@ -0,0 +1,222 @@
+// Package partialcar writes CAR files with a subset of the DAG in a piece:
+// the blocks matched by an IPLD selector, or the blocks needed to read a
+// byte range of a UnixFS file. Blocks are read directly from the piece data,
+// at the offsets recorded in the piece's CAR index.
+package partialcar
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/multiformats/go-varint"
+)
+
+// The largest CAR section (cid and block) that is read from a piece
+const maxSectionSize = 4 << 20
+
+// ErrNotFound is returned when a block isn't in the piece
+var ErrNotFound = errors.New("block not found in piece")
+
+// PieceBlockstore gets blocks from the CAR data in a piece, at the offsets
+// in the piece's CAR index
+type PieceBlockstore struct {
+	r   io.ReaderAt
+	idx index.Index
+}
+
+var _ car.ReadStore = (*PieceBlockstore)(nil)
+
+func NewPieceBlockstore(r io.ReaderAt, idx index.Index) *PieceBlockstore {
+	return &PieceBlockstore{r: r, idx: idx}
+}
+
+func (bs *PieceBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	offset, err := index.GetFirst(bs.idx, c)
+	if err != nil {
+		if errors.Is(err, index.ErrNotFound) {
+			return nil, fmt.Errorf("%s: %w", c, ErrNotFound)
+		}
+		return nil, fmt.Errorf("looking up %s in piece index: %w", c, err)
+	}
+
+	// A section is <size of cid+block><cid><block>
+	var lenBuf [binary.MaxVarintLen64]byte
+	n, err := bs.r.ReadAt(lenBuf[:], int64(offset))
+	if n == 0 && err != nil {
+		return nil, fmt.Errorf("reading section length of %s at offset %d: %w", c, offset, err)
+	}
+	sectionLen, lenSize, err := varint.FromUvarint(lenBuf[:n])
+	if err != nil {
+		return nil, fmt.Errorf("reading section length of %s at offset %d: %w", c, offset, err)
+	}
+	if sectionLen > maxSectionSize {
+		return nil, fmt.Errorf("section of %s at offset %d is too large (%d bytes)", c, offset, sectionLen)
+	}
+	section := make([]byte, sectionLen)
+	if _, err := bs.r.ReadAt(section, int64(offset)+int64(lenSize)); err != nil {
+		return nil, fmt.Errorf("reading section of %s at offset %d: %w", c, offset, err)
+	}
+
+	cidLen, readCid, err := cid.CidFromBytes(section)
+	if err != nil {
+		return nil, fmt.Errorf("reading cid of section at offset %d: %w", offset, err)
+	}
+	// The index is keyed by multihash, so the cid in the piece may have a
+	// different codec to the requested cid
+	if string(readCid.Hash()) != string(c.Hash()) {
+		return nil, fmt.Errorf("section at offset %d has cid %s, expected %s", offset, readCid, c)
+	}
+	data := section[cidLen:]
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !chk.Equals(c) {
+		return nil, fmt.Errorf("data of block %s at offset %d does not match its cid", c, offset)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// ByteRange is a range of the bytes of a UnixFS file. To is inclusive.
+type ByteRange struct {
+	From uint64
+	To   uint64
+}
+
+// ParseByteRange parses a range of the form from:to (inclusive), where to
+// may be * for the end of the file
+func ParseByteRange(s string) (*ByteRange, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("byte range '%s' must be of the form from:to", s)
+	}
+	from, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing start of byte range '%s': %w", s, err)
+	}
+	to := uint64(math.MaxUint64)
+	if parts[1] != "*" {
+		to, err = strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing end of byte range '%s': %w", s, err)
+		}
+		if to < from {
+			return nil, fmt.Errorf("end of byte range '%s' is before its start", s)
+		}
+	}
+	return &ByteRange{From: from, To: to}, nil
+}
+
+// ParseSelector parses a dag-json encoded IPLD selector
+func ParseSelector(s string) (ipld.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, strings.NewReader(s)); err != nil {
+		return nil, fmt.Errorf("decoding selector: %w", err)
+	}
+	sel := nb.Build()
+	if _, err := selector.ParseSelector(sel); err != nil {
+		return nil, fmt.Errorf("parsing selector: %w", err)
+	}
+	return sel, nil
+}
+
+// WriteSelector writes a CARv1 with the root, containing the blocks that the
+// selector matches when it traverses the DAG from the root
+func WriteSelector(ctx context.Context, w io.Writer, bs car.ReadStore, root cid.Cid, sel ipld.Node) error {
+	sc := car.NewSelectiveCar(ctx, bs, []car.Dag{{Root: root, Selector: sel}}, car.TraverseLinksOnlyOnce())
+	return sc.Write(w)
+}
+
+// WriteByteRange writes a CARv1 with the root, containing the blocks of the
+// UnixFS file under the root that are needed to read the byte range of the
+// file, in depth-first order
+func WriteByteRange(ctx context.Context, w io.Writer, bs car.ReadStore, root cid.Cid, rng ByteRange) error {
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, w); err != nil {
+		return fmt.Errorf("writing CAR header: %w", err)
+	}
+	// The range is exclusive of the end while walking
+	end := rng.To
+	if end < math.MaxUint64 {
+		end++
+	}
+	return writeRange(ctx, w, bs, root, rng.From, end)
+}
+
+// writeRange writes the block, and the blocks under it that contain the
+// file bytes [from, to) relative to the start of the block's data
+func writeRange(ctx context.Context, w io.Writer, bs car.ReadStore, c cid.Cid, from uint64, to uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := carutil.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+		return err
+	}
+
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return nil
+	case cid.DagProtobuf:
+	default:
+		return fmt.Errorf("block %s is not part of a UnixFS file (codec %x)", c, c.Prefix().Codec)
+	}
+
+	pn, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return fmt.Errorf("decoding block %s: %w", c, err)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return fmt.Errorf("decoding UnixFS data of block %s: %w", c, err)
+	}
+	if fsn.Type() != unixfs.TFile && fsn.Type() != unixfs.TRaw {
+		return fmt.Errorf("block %s is not a UnixFS file", c)
+	}
+	if fsn.NumChildren() != len(pn.Links()) {
+		return fmt.Errorf("UnixFS file block %s has %d links but %d block sizes", c, len(pn.Links()), fsn.NumChildren())
+	}
+
+	// The node's own data comes before the data of its children
+	offset := uint64(len(fsn.Data()))
+	for i, lnk := range pn.Links() {
+		start := offset
+		end := start + fsn.BlockSize(i)
+		offset = end
+		if end <= from || start >= to {
+			continue
+		}
+		childFrom := uint64(0)
+		if from > start {
+			childFrom = from - start
+		}
+		childTo := end - start
+		if to < end {
+			childTo = to - start
+		}
+		if err := writeRange(ctx, w, bs, lnk.Cid, childFrom, childTo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
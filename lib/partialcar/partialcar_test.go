@@ -0,0 +1,131 @@
+package partialcar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	format "github.com/ipfs/go-ipld-format"
+	dstest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/importer"
+	"github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPiece creates a CAR file with a UnixFS file of 2000 bytes in
+// 256 byte chunks, and returns a blockstore over it
+func newTestPiece(t *testing.T) (*PieceBlockstore, format.Node) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+
+	data := make([]byte, 2000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	file, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	require.Len(t, file.Links(), 8)
+
+	var buf bytes.Buffer
+	require.NoError(t, car.WriteCar(ctx, dag, []cid.Cid{file.Cid()}, &buf))
+	idx, err := carv2.GenerateIndex(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	// Pad the piece like the data in a sector
+	piece := append(buf.Bytes(), make([]byte, 1024)...)
+	return NewPieceBlockstore(bytes.NewReader(piece), idx), file
+}
+
+func readCarCids(t *testing.T, r io.Reader) []cid.Cid {
+	cr, err := car.NewCarReader(bufio.NewReader(r))
+	require.NoError(t, err)
+	var cids []cid.Cid
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return cids
+		}
+		require.NoError(t, err)
+		cids = append(cids, blk.Cid())
+	}
+}
+
+func TestPieceBlockstore(t *testing.T) {
+	ctx := context.Background()
+	bs, file := newTestPiece(t)
+
+	blk, err := bs.Get(ctx, file.Links()[3].Cid)
+	require.NoError(t, err)
+	require.Equal(t, file.Links()[3].Cid, blk.Cid())
+
+	missing, err := cid.Decode("bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4")
+	require.NoError(t, err)
+	_, err = bs.Get(ctx, missing)
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestWriteSelector(t *testing.T) {
+	ctx := context.Background()
+	bs, file := newTestPiece(t)
+
+	// Match only the root
+	sel, err := ParseSelector(`{".": {}}`)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, WriteSelector(ctx, &buf, bs, file.Cid(), sel))
+	require.Equal(t, []cid.Cid{file.Cid()}, readCarCids(t, &buf))
+
+	// Explore the whole DAG
+	sel, err = ParseSelector(`{"R": {"l": {"none": {}}, ":>": {"a": {">": {"@": {}}}}}}`)
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, WriteSelector(ctx, &buf, bs, file.Cid(), sel))
+	cids := readCarCids(t, &buf)
+	require.Len(t, cids, 9)
+	require.Equal(t, file.Cid(), cids[0])
+
+	_, err = ParseSelector(`{"nope": {}}`)
+	require.Error(t, err)
+}
+
+func TestWriteByteRange(t *testing.T) {
+	ctx := context.Background()
+	bs, file := newTestPiece(t)
+	leaves := file.Links()
+
+	for _, tc := range []struct {
+		rng      string
+		expected []cid.Cid
+	}{{
+		rng:      "300:600",
+		expected: []cid.Cid{file.Cid(), leaves[1].Cid, leaves[2].Cid},
+	}, {
+		rng:      "256:511",
+		expected: []cid.Cid{file.Cid(), leaves[1].Cid},
+	}, {
+		rng:      "1900:*",
+		expected: []cid.Cid{file.Cid(), leaves[7].Cid},
+	}, {
+		rng:      "5000:*",
+		expected: []cid.Cid{file.Cid()},
+	}} {
+		t.Run(tc.rng, func(t *testing.T) {
+			rng, err := ParseByteRange(tc.rng)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, WriteByteRange(ctx, &buf, bs, file.Cid(), *rng))
+			require.Equal(t, tc.expected, readCarCids(t, &buf))
+		})
+	}
+
+	for _, rng := range []string{"10", "a:10", "10:5", "5:b"} {
+		_, err := ParseByteRange(rng)
+		require.Error(t, err, rng)
+	}
+}
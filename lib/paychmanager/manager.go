@@ -0,0 +1,456 @@
+// Package paychmanager keeps track of the node's payment channels, and
+// settles and collects them when gas is cheap.
+//
+// Each time a retrieval client wallet pays a provider, the full node gets
+// (or creates) a payment channel from the wallet to the provider. Over time
+// there may be several open channels to the same provider (eg from
+// different wallets), each with funds that are locked up until the channel
+// is settled and collected. The manager consolidates these: it keeps the
+// channel with the most available funds open, and settles the redundant
+// channels so that their unspent funds can be collected back to the wallet.
+//
+// Settle and collect messages are only sent while the chain's base fee is
+// at or below a configured maximum, unless they have been due for longer
+// than a configured maximum wait.
+package paychmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("paychmanager")
+
+const (
+	DirectionOutbound = "outbound"
+	DirectionInbound  = "inbound"
+)
+
+const (
+	// The channel is open for payments
+	StateOpen = "open"
+	// The channel has been settled and can be collected once the
+	// settlement period is over
+	StateSettling = "settling"
+	// The settlement period is over, and the channel's funds can be
+	// collected
+	StateCollectable = "collectable"
+	// The channel's funds have been collected
+	StateCollected = "collected"
+)
+
+const (
+	ActionSettle  = "settle"
+	ActionCollect = "collect"
+)
+
+// If a settle or collect message has been sent but the channel's state
+// hasn't changed after this long, the message is sent again
+const resubmitAfter = time.Hour
+
+const defaultCheckInterval = 10 * time.Minute
+
+// Channel is the state of a payment channel
+type Channel struct {
+	Address   address.Address
+	From      address.Address
+	To        address.Address
+	Direction string
+	State     string
+	// The channel actor's balance
+	Balance abi.TokenAmount
+	// The amount redeemed by vouchers, which is locked until the channel is
+	// collected, when it's paid to the recipient
+	Locked abi.TokenAmount
+	// For outbound channels, the amount that is available for new vouchers
+	Available abi.TokenAmount
+	// The amount that will be paid to this node when the channel is
+	// collected: the unspent balance of an outbound channel, or the
+	// redeemed amount of an inbound channel
+	Collectable abi.TokenAmount
+	// The epoch at which the channel can be collected, if it is settling
+	SettlingAt abi.ChainEpoch
+	// True if there is another outbound channel to the same recipient that
+	// is kept open instead of this one
+	Redundant bool
+	// The action that is waiting for a low base fee, if any
+	Scheduled string
+	// The last settle or collect message sent for the channel
+	LastMessage *cid.Cid
+}
+
+// ChainState is the on-chain state of a payment channel
+type ChainState struct {
+	Balance    abi.TokenAmount
+	ToSend     abi.TokenAmount
+	SettlingAt abi.ChainEpoch
+}
+
+// StateReader reads the on-chain state of a payment channel
+type StateReader func(ctx context.Context, ch address.Address) (*ChainState, error)
+
+type managerAPI interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	WalletHas(ctx context.Context, addr address.Address) (bool, error)
+	PaychList(ctx context.Context) ([]address.Address, error)
+	PaychAvailableFunds(ctx context.Context, ch address.Address) (*lapi.ChannelAvailableFunds, error)
+	PaychSettle(ctx context.Context, ch address.Address) (cid.Cid, error)
+	PaychCollect(ctx context.Context, ch address.Address) (cid.Cid, error)
+}
+
+type Config struct {
+	// How often to check the payment channels and the base fee
+	CheckInterval time.Duration
+	// Settle and collect channels only while the base fee is at most this
+	// amount. If zero, channels are settled and collected at any base fee.
+	MaxBaseFee abi.TokenAmount
+	// Settle or collect a channel regardless of the base fee once it has
+	// been due for this long. If zero, always wait for a low base fee.
+	MaxWait time.Duration
+}
+
+type submission struct {
+	action string
+	msg    cid.Cid
+	at     time.Time
+}
+
+// Manager consolidates, settles and collects payment channels
+type Manager struct {
+	api       managerAPI
+	readState StateReader
+	cfg       Config
+	now       func() time.Time
+
+	lk sync.Mutex
+	// Channels that were requested to be settled through the API
+	settle map[address.Address]struct{}
+	// The time at which each action became due, by channel
+	due map[address.Address]time.Time
+	// The last message sent for each channel
+	sent map[address.Address]submission
+	// Set once the node has used payment channels
+	used bool
+	// Called the first time that the node uses payment channels
+	onFirstUse []func()
+}
+
+func New(api managerAPI, readState StateReader, cfg Config) *Manager {
+	if cfg.MaxBaseFee.Int == nil {
+		cfg.MaxBaseFee = big.Zero()
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	return &Manager{
+		api:       api,
+		readState: readState,
+		cfg:       cfg,
+		now:       time.Now,
+		settle:    make(map[address.Address]struct{}),
+		due:       make(map[address.Address]time.Time),
+		sent:      make(map[address.Address]submission),
+	}
+}
+
+// Run checks the payment channels every check interval until the context is
+// cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil {
+			log.Warnw("checking payment channels", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// OnFirstUse calls f the first time that the node uses payment channels (see
+// Use), or straight away if it already has. It allows the background checks
+// to be deferred until they are needed, so that nodes that only make storage
+// deals don't run them.
+func (m *Manager) OnFirstUse(f func()) {
+	m.lk.Lock()
+	if !m.used {
+		m.onFirstUse = append(m.onFirstUse, f)
+		m.lk.Unlock()
+		return
+	}
+	m.lk.Unlock()
+	f()
+}
+
+// Use marks that the node uses payment channels: it is called when the
+// retrieval client is created, and when the channels are listed or settled
+// through the API
+func (m *Manager) Use() {
+	m.lk.Lock()
+	if m.used {
+		m.lk.Unlock()
+		return
+	}
+	m.used = true
+	fs := m.onFirstUse
+	m.onFirstUse = nil
+	m.lk.Unlock()
+
+	for _, f := range fs {
+		f()
+	}
+}
+
+// Settle schedules the channel to be settled at the next low base fee
+// period. The request is not persisted: if the node restarts before the
+// channel is settled it must be requested again.
+func (m *Manager) Settle(ctx context.Context, ch address.Address) error {
+	m.Use()
+
+	chans, err := m.Inventory(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range chans {
+		if c.Address != ch {
+			continue
+		}
+		if c.State != StateOpen {
+			return fmt.Errorf("payment channel %s is already %s", ch, c.State)
+		}
+		m.lk.Lock()
+		m.settle[ch] = struct{}{}
+		m.lk.Unlock()
+		return nil
+	}
+	return fmt.Errorf("payment channel %s not found", ch)
+}
+
+// Inventory returns the state of each of the node's payment channels
+func (m *Manager) Inventory(ctx context.Context) ([]Channel, error) {
+	m.Use()
+
+	head, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	return m.inventory(ctx, head.Height())
+}
+
+func (m *Manager) inventory(ctx context.Context, height abi.ChainEpoch) ([]Channel, error) {
+	addrs, err := m.api.PaychList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing payment channels: %w", err)
+	}
+
+	chans := make([]Channel, 0, len(addrs))
+	for _, addr := range addrs {
+		c, err := m.channel(ctx, addr, height)
+		if err != nil {
+			log.Warnw("getting payment channel state", "channel", addr, "err", err)
+			continue
+		}
+		chans = append(chans, *c)
+	}
+
+	m.markRedundant(chans)
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	for i := range chans {
+		c := &chans[i]
+		c.Scheduled = m.action(c)
+		if s, ok := m.sent[c.Address]; ok {
+			msg := s.msg
+			c.LastMessage = &msg
+		}
+	}
+	return chans, nil
+}
+
+func (m *Manager) channel(ctx context.Context, addr address.Address, height abi.ChainEpoch) (*Channel, error) {
+	funds, err := m.api.PaychAvailableFunds(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	st, err := m.readState(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Channel{
+		Address:     addr,
+		From:        funds.From,
+		To:          funds.To,
+		Direction:   DirectionOutbound,
+		State:       StateOpen,
+		Balance:     st.Balance,
+		Locked:      big.Max(st.ToSend, funds.VoucherReedeemedAmt),
+		Available:   funds.NonReservedAmt,
+		Collectable: big.Zero(),
+		SettlingAt:  st.SettlingAt,
+	}
+	ours, err := m.api.WalletHas(ctx, funds.From)
+	if err != nil {
+		return nil, fmt.Errorf("checking wallet for %s: %w", funds.From, err)
+	}
+	if !ours {
+		c.Direction = DirectionInbound
+		c.Available = big.Zero()
+	}
+
+	switch {
+	case st.SettlingAt == 0:
+	case st.Balance.IsZero():
+		c.State = StateCollected
+	case height >= st.SettlingAt:
+		c.State = StateCollectable
+	default:
+		c.State = StateSettling
+	}
+	if c.State == StateSettling || c.State == StateCollectable {
+		if c.Direction == DirectionOutbound {
+			c.Collectable = big.Max(big.Sub(st.Balance, st.ToSend), big.Zero())
+		} else {
+			c.Collectable = big.Min(st.ToSend, st.Balance)
+		}
+	}
+	return c, nil
+}
+
+// markRedundant marks all but one of the open outbound channels to each
+// recipient as redundant, keeping the channel with the most available funds
+func (m *Manager) markRedundant(chans []Channel) {
+	byRecipient := make(map[address.Address][]*Channel)
+	for i := range chans {
+		c := &chans[i]
+		if c.Direction == DirectionOutbound && c.State == StateOpen {
+			byRecipient[c.To] = append(byRecipient[c.To], c)
+		}
+	}
+	for _, cs := range byRecipient {
+		if len(cs) < 2 {
+			continue
+		}
+		sort.Slice(cs, func(i, j int) bool {
+			if !cs[i].Available.Equals(cs[j].Available) {
+				return cs[i].Available.GreaterThan(cs[j].Available)
+			}
+			return cs[i].Address.String() < cs[j].Address.String()
+		})
+		for _, c := range cs[1:] {
+			c.Redundant = true
+		}
+	}
+}
+
+// action returns the action that is due for the channel, if any.
+// Must be called with the lock held.
+func (m *Manager) action(c *Channel) string {
+	switch c.State {
+	case StateOpen:
+		if _, ok := m.settle[c.Address]; ok {
+			return ActionSettle
+		}
+		if c.Redundant {
+			return ActionSettle
+		}
+	case StateCollectable:
+		if c.Direction == DirectionOutbound {
+			return ActionCollect
+		}
+	}
+	return ""
+}
+
+// Check settles and collects the channels that are due, if the base fee
+// is low enough (or they have been due for long enough)
+func (m *Manager) Check(ctx context.Context) error {
+	head, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("getting chain head: %w", err)
+	}
+	chans, err := m.inventory(ctx, head.Height())
+	if err != nil {
+		return err
+	}
+
+	baseFee := head.MinTicketBlock().ParentBaseFee
+	lowFee := m.cfg.MaxBaseFee.IsZero() || baseFee.LessThanEqual(m.cfg.MaxBaseFee)
+	now := m.now()
+
+	type pending struct {
+		ch      address.Address
+		action  string
+		overdue bool
+	}
+	var toSend []pending
+
+	m.lk.Lock()
+	due := make(map[address.Address]time.Time)
+	for _, c := range chans {
+		if c.State != StateOpen {
+			delete(m.settle, c.Address)
+		}
+		if c.Scheduled == "" {
+			continue
+		}
+		dueAt, ok := m.due[c.Address]
+		if !ok {
+			dueAt = now
+		}
+		due[c.Address] = dueAt
+
+		if s, ok := m.sent[c.Address]; ok && s.action == c.Scheduled && now.Sub(s.at) < resubmitAfter {
+			// Wait for the message that was already sent to land
+			continue
+		}
+		overdue := m.cfg.MaxWait > 0 && now.Sub(dueAt) >= m.cfg.MaxWait
+		if !lowFee && !overdue {
+			log.Debugw("waiting for low base fee", "channel", c.Address, "action", c.Scheduled, "base-fee", baseFee,
+				"max-base-fee", m.cfg.MaxBaseFee)
+			continue
+		}
+		toSend = append(toSend, pending{ch: c.Address, action: c.Scheduled, overdue: overdue && !lowFee})
+	}
+	m.due = due
+	m.lk.Unlock()
+
+	for _, p := range toSend {
+		msg, err := m.send(ctx, p.ch, p.action)
+		if err != nil {
+			log.Warnw("sending payment channel message", "channel", p.ch, "action", p.action, "err", err)
+			continue
+		}
+		log.Infow("sent payment channel message", "channel", p.ch, "action", p.action, "cid", msg,
+			"base-fee", baseFee, "overdue", p.overdue)
+
+		m.lk.Lock()
+		m.sent[p.ch] = submission{action: p.action, msg: msg, at: now}
+		m.lk.Unlock()
+	}
+	return nil
+}
+
+func (m *Manager) send(ctx context.Context, ch address.Address, action string) (cid.Cid, error) {
+	if action == ActionCollect {
+		return m.api.PaychCollect(ctx, ch)
+	}
+	return m.api.PaychSettle(ctx, ch)
+}
@@ -0,0 +1,209 @@
+package paychmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChannel struct {
+	funds lapi.ChannelAvailableFunds
+	state ChainState
+}
+
+type mockAPI struct {
+	lk      sync.Mutex
+	height  abi.ChainEpoch
+	baseFee abi.TokenAmount
+	wallets map[address.Address]bool
+	chans   map[address.Address]*mockChannel
+	settled []address.Address
+	collect []address.Address
+}
+
+func (m *mockAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	miner, err := address.NewIDAddress(1000)
+	if err != nil {
+		return nil, err
+	}
+	c := testutil.GenerateCid()
+	blk := &types.BlockHeader{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte("ticket")},
+		ParentWeight:          big.Zero(),
+		Height:                m.height,
+		ParentStateRoot:       c,
+		ParentMessageReceipts: c,
+		Messages:              c,
+		ParentBaseFee:         m.baseFee,
+	}
+	return types.NewTipSet([]*types.BlockHeader{blk})
+}
+
+func (m *mockAPI) WalletHas(_ context.Context, addr address.Address) (bool, error) {
+	return m.wallets[addr], nil
+}
+
+func (m *mockAPI) PaychList(context.Context) ([]address.Address, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	var addrs []address.Address
+	for a := range m.chans {
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+func (m *mockAPI) PaychAvailableFunds(_ context.Context, ch address.Address) (*lapi.ChannelAvailableFunds, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	funds := m.chans[ch].funds
+	return &funds, nil
+}
+
+func (m *mockAPI) PaychSettle(_ context.Context, ch address.Address) (cid.Cid, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.settled = append(m.settled, ch)
+	return testutil.GenerateCid(), nil
+}
+
+func (m *mockAPI) PaychCollect(_ context.Context, ch address.Address) (cid.Cid, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.collect = append(m.collect, ch)
+	return testutil.GenerateCid(), nil
+}
+
+func (m *mockAPI) readState(_ context.Context, ch address.Address) (*ChainState, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	st := m.chans[ch].state
+	return &st, nil
+}
+
+func TestManager(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	addr := func(id uint64) address.Address {
+		a, err := address.NewIDAddress(id)
+		req.NoError(err)
+		return a
+	}
+	wallet1, wallet2, provider, client := addr(100), addr(101), addr(1000), addr(200)
+	ch1, ch2, ch3 := addr(2001), addr(2002), addr(2003)
+	channel := func(from, to address.Address, available, balance int64) *mockChannel {
+		return &mockChannel{
+			funds: lapi.ChannelAvailableFunds{From: from, To: to, NonReservedAmt: big.NewInt(available), VoucherReedeemedAmt: big.NewInt(balance - available)},
+			state: ChainState{Balance: big.NewInt(balance), ToSend: big.Zero()},
+		}
+	}
+
+	api := &mockAPI{
+		height:  100,
+		baseFee: big.NewInt(500),
+		wallets: map[address.Address]bool{wallet1: true, wallet2: true},
+		chans: map[address.Address]*mockChannel{
+			// Two outbound channels to the same provider, ch1 with more
+			// available funds
+			ch1: channel(wallet1, provider, 80, 100),
+			ch2: channel(wallet2, provider, 20, 100),
+			// An inbound channel from a client
+			ch3: channel(client, wallet1, 0, 50),
+		},
+	}
+	mgr := New(api, api.readState, Config{MaxBaseFee: big.NewInt(100), MaxWait: time.Hour})
+	now := time.Now()
+	mgr.now = func() time.Time { return now }
+
+	chans, err := mgr.Inventory(ctx)
+	req.NoError(err)
+	req.Len(chans, 3)
+	byAddr := make(map[address.Address]Channel)
+	for _, c := range chans {
+		byAddr[c.Address] = c
+	}
+	req.False(byAddr[ch1].Redundant)
+	req.True(byAddr[ch2].Redundant)
+	req.Equal(ActionSettle, byAddr[ch2].Scheduled)
+	req.Equal(DirectionInbound, byAddr[ch3].Direction)
+	req.Empty(byAddr[ch3].Scheduled)
+
+	// The base fee is too high, so the redundant channel isn't settled yet
+	req.NoError(mgr.Check(ctx))
+	req.Empty(api.settled)
+
+	// Once the base fee drops the redundant channel is settled
+	api.baseFee = big.NewInt(50)
+	req.NoError(mgr.Check(ctx))
+	req.Equal([]address.Address{ch2}, api.settled)
+
+	// The settle message isn't sent again while it lands
+	req.NoError(mgr.Check(ctx))
+	req.Len(api.settled, 1)
+
+	// A channel can be settled on request
+	req.NoError(mgr.Settle(ctx, ch1))
+	req.NoError(mgr.Check(ctx))
+	req.Equal([]address.Address{ch2, ch1}, api.settled)
+
+	// ch2 is settling and becomes collectable at height 200, when gas is
+	// expensive again
+	api.chans[ch2].state.SettlingAt = 200
+	api.chans[ch2].state.ToSend = big.NewInt(80)
+	api.baseFee = big.NewInt(500)
+	chans, err = mgr.Inventory(ctx)
+	req.NoError(err)
+	for _, c := range chans {
+		if c.Address == ch2 {
+			req.Equal(StateSettling, c.State)
+			req.Equal(big.NewInt(20), c.Collectable)
+		}
+	}
+	api.height = 200
+	req.NoError(mgr.Check(ctx))
+	req.Empty(api.collect)
+
+	// Once the collection has been due for longer than the max wait, it is
+	// sent regardless of the base fee
+	now = now.Add(time.Hour)
+	req.NoError(mgr.Check(ctx))
+	req.Equal([]address.Address{ch2}, api.collect)
+}
+
+func TestManagerOnFirstUse(t *testing.T) {
+	req := require.New(t)
+
+	api := &mockAPI{height: 100, baseFee: big.NewInt(100), chans: map[address.Address]*mockChannel{}}
+	mgr := New(api, api.readState, Config{})
+
+	calls := 0
+	mgr.OnFirstUse(func() { calls++ })
+	req.Equal(0, calls)
+
+	// The callback is only called the first time that payment channels are
+	// used
+	_, err := mgr.Inventory(context.Background())
+	req.NoError(err)
+	req.Equal(1, calls)
+	mgr.Use()
+	req.Equal(1, calls)
+
+	// A callback that is registered after the first use is called straight
+	// away
+	mgr.OnFirstUse(func() { calls++ })
+	req.Equal(2, calls)
+}
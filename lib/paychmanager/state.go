@@ -0,0 +1,42 @@
+package paychmanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/paych"
+	"github.com/filecoin-project/lotus/chain/types"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+type chainStateAPI interface {
+	blockstore.ChainIO
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+}
+
+// ChainStateReader reads the state of payment channel actors from the chain
+func ChainStateReader(api chainStateAPI) StateReader {
+	store := cbor.NewCborStore(blockstore.NewAPIBlockstore(api))
+	return func(ctx context.Context, ch address.Address) (*ChainState, error) {
+		act, err := api.StateGetActor(ctx, ch, types.EmptyTSK)
+		if err != nil {
+			return nil, fmt.Errorf("getting payment channel actor: %w", err)
+		}
+		st, err := paych.Load(adt.WrapStore(ctx, store), act)
+		if err != nil {
+			return nil, fmt.Errorf("loading payment channel state: %w", err)
+		}
+		settlingAt, err := st.SettlingAt()
+		if err != nil {
+			return nil, err
+		}
+		toSend, err := st.ToSend()
+		if err != nil {
+			return nil, err
+		}
+		return &ChainState{Balance: act.Balance, ToSend: toSend, SettlingAt: settlingAt}, nil
+	}
+}
@@ -0,0 +1,432 @@
+// Package piecegc removes the data that boost keeps for pieces that are no
+// longer stored in any active deal: the unsealed copies of their sectors,
+// and the pieces' indexes.
+//
+// For each piece in the piece store, the collector checks each deal for the
+// piece in the sector it was sealed in. A deal is no longer active once its
+// end epoch has passed, once it has been slashed, or once its sector has
+// been terminated. When none of a piece's deals are active, and the grace
+// period has passed since the last one ended, the piece's index is removed.
+// The unsealed copy of a sector is removed when the grace period has passed
+// for every deal in the sector (not just the deals in the piece store, so
+// that other markets' deals are protected too).
+//
+// In a dry run the collector reports what would be removed without removing
+// anything.
+package piecegc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-state-types/abi"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("piecegc")
+
+// ChainAPI is the subset of the full node API used by the collector
+type ChainAPI interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	StateMarketStorageDeal(ctx context.Context, dealID abi.DealID, tsk types.TipSetKey) (*lapi.MarketDeal, error)
+}
+
+// SealingAPI is the subset of the sealing API used by the collector
+type SealingAPI interface {
+	SectorsStatus(ctx context.Context, sid abi.SectorNumber, showOnChainInfo bool) (lapi.SectorInfo, error)
+}
+
+// PieceStore lists the pieces and the deals (and sectors) they are stored in
+type PieceStore interface {
+	ListPieceInfoKeys() ([]cid.Cid, error)
+	GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, error)
+}
+
+// SectorStorage finds and removes the files of sectors
+type SectorStorage interface {
+	StorageFindSector(ctx context.Context, sector abi.SectorID, ft storiface.SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]storiface.SectorStorageInfo, error)
+	Remove(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, force bool, keepIn []storiface.ID) error
+}
+
+// IndexStore holds the indexes of pieces (eg the dagstore)
+type IndexStore interface {
+	HasIndex(ctx context.Context, pieceCid cid.Cid) (bool, error)
+	RemoveIndex(ctx context.Context, pieceCid cid.Cid) error
+}
+
+type Config struct {
+	// The miner whose sectors are collected
+	Miner address.Address
+	// How long to keep the data of a piece after its last deal ended
+	GracePeriod abi.ChainEpoch
+	// How often the periodic collection runs
+	Interval time.Duration
+	// Report what the periodic collection would remove, without removing it
+	DryRun bool
+}
+
+// Piece is a piece that is no longer stored in any active deal
+type Piece struct {
+	PieceCid cid.Cid      `json:"pieceCid"`
+	Deals    []abi.DealID `json:"deals"`
+	// The epoch at which the last of the piece's deals ended
+	InactiveSince abi.ChainEpoch `json:"inactiveSince"`
+	// The epoch after which the piece's index may be removed
+	CollectAt abi.ChainEpoch `json:"collectAt"`
+	// The index was removed (in a dry run: would have been removed)
+	Collected bool   `json:"collected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Sector is a sector with an unsealed copy, in which no deal is active
+type Sector struct {
+	Sector abi.SectorNumber `json:"sector"`
+	Deals  []abi.DealID     `json:"deals"`
+	// The epoch at which the last of the sector's deals ended
+	InactiveSince abi.ChainEpoch `json:"inactiveSince"`
+	// The epoch after which the unsealed copy may be removed
+	CollectAt abi.ChainEpoch `json:"collectAt"`
+	// The unsealed copy was removed (in a dry run: would have been removed)
+	Collected bool   `json:"collected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Report is the result of a collection
+type Report struct {
+	Height      abi.ChainEpoch `json:"height"`
+	DryRun      bool           `json:"dryRun"`
+	GracePeriod abi.ChainEpoch `json:"gracePeriod"`
+	// The number of pieces that are still stored in an active deal
+	ActivePieces int      `json:"activePieces"`
+	Pieces       []Piece  `json:"pieces"`
+	Sectors      []Sector `json:"sectors"`
+}
+
+// Collector removes the unsealed copies and indexes of pieces that are no
+// longer stored in any active deal
+type Collector struct {
+	cfg     Config
+	chain   ChainAPI
+	sealing SealingAPI
+	pieces  PieceStore
+	storage SectorStorage
+	indexes IndexStore
+
+	// Only one collection runs at a time
+	runLk sync.Mutex
+	// The epoch at which each terminated sector was first seen. The chain
+	// does not keep the epoch at which a sector was terminated once its
+	// deals have been cleaned up, so the grace period starts when the
+	// collector first sees the termination (and restarts when boost
+	// restarts).
+	terminated map[abi.SectorNumber]abi.ChainEpoch
+}
+
+func New(cfg Config, chain ChainAPI, sealing SealingAPI, pieces PieceStore, storage SectorStorage, indexes IndexStore) *Collector {
+	return &Collector{
+		cfg:        cfg,
+		chain:      chain,
+		sealing:    sealing,
+		pieces:     pieces,
+		storage:    storage,
+		indexes:    indexes,
+		terminated: make(map[abi.SectorNumber]abi.ChainEpoch),
+	}
+}
+
+// Run collects periodically until the context is cancelled
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		rpt, err := c.Collect(ctx, c.cfg.DryRun)
+		if err != nil && ctx.Err() == nil {
+			log.Errorw("piece garbage collection", "err", err)
+		} else if rpt != nil {
+			c.logReport(rpt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Collector) logReport(rpt *Report) {
+	var pieces, sectors int
+	for _, p := range rpt.Pieces {
+		if p.Collected {
+			pieces++
+		}
+	}
+	for _, s := range rpt.Sectors {
+		if s.Collected {
+			sectors++
+		}
+	}
+	log.Infow("piece garbage collection", "dryRun", rpt.DryRun, "height", rpt.Height,
+		"activePieces", rpt.ActivePieces, "inactivePieces", len(rpt.Pieces),
+		"collectedIndexes", pieces, "collectedUnsealedSectors", sectors)
+}
+
+// sectorState is the state of the deals in a sector
+type sectorState struct {
+	// The epoch at which each deal in the sector ended, or -1 if the deal
+	// is still active
+	deals map[abi.DealID]abi.ChainEpoch
+	// Whether any deal in the sector is still active, and if not the
+	// epoch at which the last one ended
+	active        bool
+	inactiveSince abi.ChainEpoch
+	err           error
+}
+
+// Collect removes the unsealed copies and indexes of pieces whose deals
+// ended more than the grace period ago. If dryRun is true it only reports
+// what would be removed.
+func (c *Collector) Collect(ctx context.Context, dryRun bool) (*Report, error) {
+	c.runLk.Lock()
+	defer c.runLk.Unlock()
+
+	minerID, err := address.IDFromAddress(c.cfg.Miner)
+	if err != nil {
+		return nil, fmt.Errorf("getting id of miner %s: %w", c.cfg.Miner, err)
+	}
+
+	head, err := c.chain.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	rpt := &Report{Height: head.Height(), DryRun: dryRun, GracePeriod: c.cfg.GracePeriod}
+
+	pieceCids, err := c.pieces.ListPieceInfoKeys()
+	if err != nil {
+		return nil, fmt.Errorf("listing pieces: %w", err)
+	}
+
+	sectors := make(map[abi.SectorNumber]*sectorState)
+	for _, pieceCid := range pieceCids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pi, err := c.pieces.GetPieceInfo(pieceCid)
+		if err != nil {
+			return nil, fmt.Errorf("getting info for piece %s: %w", pieceCid, err)
+		}
+		if len(pi.Deals) == 0 {
+			// The piece has not been stored in a sector yet
+			rpt.ActivePieces++
+			continue
+		}
+
+		p := Piece{PieceCid: pieceCid}
+		active := false
+		for _, d := range pi.Deals {
+			p.Deals = append(p.Deals, d.DealID)
+			ss, ok := sectors[d.SectorID]
+			if !ok {
+				ss = c.sectorState(ctx, head, d.SectorID)
+				sectors[d.SectorID] = ss
+			}
+			ended, inSector := ss.deals[d.DealID]
+			if ss.err != nil || !inSector {
+				// If the sector's state is not known, or the deal is not in
+				// the sector (eg because it's a deal for another miner),
+				// keep the piece
+				if ss.err != nil {
+					p.Error = ss.err.Error()
+				}
+				active = true
+				continue
+			}
+			if ended < 0 {
+				active = true
+				continue
+			}
+			p.InactiveSince = maxEpoch(p.InactiveSince, ended)
+		}
+		if active {
+			if p.Error != "" {
+				log.Warnw("checking deals for piece", "piece", pieceCid, "err", p.Error)
+			}
+			rpt.ActivePieces++
+			continue
+		}
+
+		p.CollectAt = p.InactiveSince + c.cfg.GracePeriod
+		has, err := c.indexes.HasIndex(ctx, pieceCid)
+		if err != nil {
+			p.Error = fmt.Sprintf("checking for index: %s", err)
+			rpt.Pieces = append(rpt.Pieces, p)
+			continue
+		}
+		if !has {
+			// There is no index to remove
+			continue
+		}
+		if head.Height() >= p.CollectAt {
+			c.collectIndex(ctx, &p, dryRun)
+		}
+		rpt.Pieces = append(rpt.Pieces, p)
+	}
+
+	for num, ss := range sectors {
+		if ss.err != nil || ss.active {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s := Sector{Sector: num, InactiveSince: ss.inactiveSince, CollectAt: ss.inactiveSince + c.cfg.GracePeriod}
+		for id := range ss.deals {
+			s.Deals = append(s.Deals, id)
+		}
+		sort.Slice(s.Deals, func(i, j int) bool { return s.Deals[i] < s.Deals[j] })
+
+		sid := abi.SectorID{Miner: abi.ActorID(minerID), Number: num}
+		found, err := c.storage.StorageFindSector(ctx, sid, storiface.FTUnsealed, 0, false)
+		if err != nil {
+			s.Error = fmt.Sprintf("finding unsealed copy: %s", err)
+			rpt.Sectors = append(rpt.Sectors, s)
+			continue
+		}
+		if len(found) == 0 {
+			// There is no unsealed copy to remove
+			continue
+		}
+		if head.Height() >= s.CollectAt {
+			c.collectUnsealed(ctx, &s, sid, dryRun)
+		}
+		rpt.Sectors = append(rpt.Sectors, s)
+	}
+
+	sort.Slice(rpt.Pieces, func(i, j int) bool {
+		return rpt.Pieces[i].PieceCid.String() < rpt.Pieces[j].PieceCid.String()
+	})
+	sort.Slice(rpt.Sectors, func(i, j int) bool { return rpt.Sectors[i].Sector < rpt.Sectors[j].Sector })
+	return rpt, nil
+}
+
+func (c *Collector) collectIndex(ctx context.Context, p *Piece, dryRun bool) {
+	if dryRun {
+		p.Collected = true
+		log.Infow("dry run: would remove index of piece", "piece", p.PieceCid, "inactiveSince", p.InactiveSince)
+		return
+	}
+	if err := c.indexes.RemoveIndex(ctx, p.PieceCid); err != nil {
+		p.Error = fmt.Sprintf("removing index: %s", err)
+		log.Warnw("removing index of piece", "piece", p.PieceCid, "err", err)
+		return
+	}
+	p.Collected = true
+	log.Infow("removed index of piece", "piece", p.PieceCid, "inactiveSince", p.InactiveSince)
+}
+
+func (c *Collector) collectUnsealed(ctx context.Context, s *Sector, sid abi.SectorID, dryRun bool) {
+	if dryRun {
+		s.Collected = true
+		log.Infow("dry run: would remove unsealed copy of sector", "sector", s.Sector, "inactiveSince", s.InactiveSince)
+		return
+	}
+	if err := c.storage.Remove(ctx, sid, storiface.FTUnsealed, false, nil); err != nil {
+		s.Error = fmt.Sprintf("removing unsealed copy: %s", err)
+		log.Warnw("removing unsealed copy of sector", "sector", s.Sector, "err", err)
+		return
+	}
+	s.Collected = true
+	log.Infow("removed unsealed copy of sector", "sector", s.Sector, "inactiveSince", s.InactiveSince)
+}
+
+// sectorState checks whether any of the deals in the sector are still active
+func (c *Collector) sectorState(ctx context.Context, head *types.TipSet, num abi.SectorNumber) *sectorState {
+	ss := &sectorState{deals: make(map[abi.DealID]abi.ChainEpoch)}
+	si, err := c.sealing.SectorsStatus(ctx, num, false)
+	if err != nil {
+		ss.err = fmt.Errorf("getting status of sector %d: %w", num, err)
+		return ss
+	}
+
+	var terminatedAt abi.ChainEpoch
+	if terminatedStates[si.State] {
+		var ok bool
+		if terminatedAt, ok = c.terminated[num]; !ok {
+			terminatedAt = head.Height()
+			c.terminated[num] = terminatedAt
+		}
+	}
+
+	for _, p := range si.Pieces {
+		if p.DealInfo == nil {
+			// A filler piece
+			continue
+		}
+		ended := terminatedAt
+		if terminatedAt == 0 {
+			ended, err = c.dealEnded(ctx, head, p.DealInfo)
+			if err != nil {
+				ss.err = fmt.Errorf("checking deal %d in sector %d: %w", p.DealInfo.DealID, num, err)
+				return ss
+			}
+		}
+		ss.deals[p.DealInfo.DealID] = ended
+		if ended < 0 {
+			ss.active = true
+		} else {
+			ss.inactiveSince = maxEpoch(ss.inactiveSince, ended)
+		}
+	}
+	if len(ss.deals) == 0 {
+		// The piece store says there are deals in the sector, but the
+		// sealing API doesn't know about them
+		ss.err = fmt.Errorf("sector %d has no deals", num)
+	}
+	return ss
+}
+
+// dealEnded returns the epoch at which the deal ended, or -1 if it's still
+// active
+func (c *Collector) dealEnded(ctx context.Context, head *types.TipSet, di *lapi.PieceDealInfo) (abi.ChainEpoch, error) {
+	if di.DealProposal != nil && di.DealProposal.EndEpoch <= head.Height() {
+		return di.DealProposal.EndEpoch, nil
+	}
+
+	md, err := c.chain.StateMarketStorageDeal(ctx, di.DealID, head.Key())
+	if err != nil {
+		return 0, fmt.Errorf("getting market deal state: %w", err)
+	}
+	if md.State.SlashEpoch > 0 {
+		return md.State.SlashEpoch, nil
+	}
+	if md.Proposal.EndEpoch <= head.Height() {
+		return md.Proposal.EndEpoch, nil
+	}
+	return -1, nil
+}
+
+// The sealing states of a sector that has been terminated or removed
+var terminatedStates = map[lapi.SectorState]bool{
+	"Terminating":       true,
+	"TerminateWait":     true,
+	"TerminateFinality": true,
+	"Removing":          true,
+	"Removed":           true,
+}
+
+func maxEpoch(a, b abi.ChainEpoch) abi.ChainEpoch {
+	if a > b {
+		return a
+	}
+	return b
+}
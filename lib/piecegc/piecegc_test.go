@@ -0,0 +1,281 @@
+package piecegc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChain struct {
+	head  *types.TipSet
+	deals map[abi.DealID]*lapi.MarketDeal
+}
+
+func (m *mockChain) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return m.head, nil
+}
+
+func (m *mockChain) StateMarketStorageDeal(ctx context.Context, dealID abi.DealID, tsk types.TipSetKey) (*lapi.MarketDeal, error) {
+	md, ok := m.deals[dealID]
+	if !ok {
+		return nil, fmt.Errorf("deal %d not found", dealID)
+	}
+	return md, nil
+}
+
+type mockSealing struct {
+	sectors map[abi.SectorNumber]lapi.SectorInfo
+}
+
+func (m *mockSealing) SectorsStatus(ctx context.Context, sid abi.SectorNumber, showOnChainInfo bool) (lapi.SectorInfo, error) {
+	si, ok := m.sectors[sid]
+	if !ok {
+		return lapi.SectorInfo{}, fmt.Errorf("sector %d not found", sid)
+	}
+	return si, nil
+}
+
+type mockPieceStore struct {
+	pieces map[cid.Cid]piecestore.PieceInfo
+}
+
+func (m *mockPieceStore) ListPieceInfoKeys() ([]cid.Cid, error) {
+	var keys []cid.Cid
+	for k := range m.pieces {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (m *mockPieceStore) GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, error) {
+	return m.pieces[pieceCID], nil
+}
+
+type mockStorage struct {
+	lk       sync.Mutex
+	unsealed map[abi.SectorNumber]bool
+	removed  []abi.SectorNumber
+}
+
+func (m *mockStorage) StorageFindSector(ctx context.Context, sector abi.SectorID, ft storiface.SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]storiface.SectorStorageInfo, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if ft != storiface.FTUnsealed || !m.unsealed[sector.Number] {
+		return nil, nil
+	}
+	return []storiface.SectorStorageInfo{{ID: "store"}}, nil
+}
+
+func (m *mockStorage) Remove(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, force bool, keepIn []storiface.ID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if typ != storiface.FTUnsealed {
+		return fmt.Errorf("unexpected file type %s", typ)
+	}
+	delete(m.unsealed, sid.Number)
+	m.removed = append(m.removed, sid.Number)
+	return nil
+}
+
+type mockIndexes struct {
+	lk      sync.Mutex
+	indexes map[cid.Cid]bool
+	removed []cid.Cid
+}
+
+func (m *mockIndexes) HasIndex(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.indexes[pieceCid], nil
+}
+
+func (m *mockIndexes) RemoveIndex(ctx context.Context, pieceCid cid.Cid) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	delete(m.indexes, pieceCid)
+	m.removed = append(m.removed, pieceCid)
+	return nil
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.FilCommitmentUnsealed, mh)
+}
+
+func testTipSet(t *testing.T, height abi.ChainEpoch) *types.TipSet {
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	c := testCid(t, fmt.Sprintf("block-%d", height))
+	ts, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte{byte(height)}},
+		Height:                height,
+		ParentWeight:          big.Zero(),
+		ParentStateRoot:       c,
+		ParentMessageReceipts: c,
+		Messages:              c,
+		ParentBaseFee:         big.Zero(),
+		BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+		BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+	}})
+	require.NoError(t, err)
+	return ts
+}
+
+func TestCollect(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	maddr, err := address.NewIDAddress(1000)
+	req.NoError(err)
+
+	pieceA := testCid(t, "a") // deal 1 in sector 1, ended at 800
+	pieceB := testCid(t, "b") // deal 2 in sector 2, ended at 950
+	pieceC := testCid(t, "c") // deal 3 in sector 3, active
+	pieceD := testCid(t, "d") // deal 4 in sector 3, slashed at 500
+	pieceE := testCid(t, "e") // deal 5 in sector 4, which was terminated
+	pieceF := testCid(t, "f") // deal 6, for another miner's sector 1
+
+	dealInfo := func(id abi.DealID, end abi.ChainEpoch) lapi.SectorPiece {
+		return lapi.SectorPiece{DealInfo: &lapi.PieceDealInfo{DealID: id, DealProposal: &market.DealProposal{EndEpoch: end}}}
+	}
+	sealing := &mockSealing{sectors: map[abi.SectorNumber]lapi.SectorInfo{
+		1: {State: "Proving", Pieces: []lapi.SectorPiece{dealInfo(1, 800), {}}},
+		2: {State: "Proving", Pieces: []lapi.SectorPiece{dealInfo(2, 950)}},
+		3: {State: "Proving", Pieces: []lapi.SectorPiece{dealInfo(3, 5000), dealInfo(4, 5000)}},
+		4: {State: "Removed", Pieces: []lapi.SectorPiece{dealInfo(5, 5000)}},
+	}}
+	chain := &mockChain{head: testTipSet(t, 1000), deals: map[abi.DealID]*lapi.MarketDeal{
+		3: {Proposal: market.DealProposal{EndEpoch: 5000}, State: market.DealState{SlashEpoch: -1}},
+		4: {Proposal: market.DealProposal{EndEpoch: 5000}, State: market.DealState{SlashEpoch: 500}},
+	}}
+	pieceDeal := func(pieceCid cid.Cid, deal abi.DealID, sector abi.SectorNumber) piecestore.PieceInfo {
+		return piecestore.PieceInfo{PieceCID: pieceCid, Deals: []piecestore.DealInfo{{DealID: deal, SectorID: sector}}}
+	}
+	pieces := &mockPieceStore{pieces: map[cid.Cid]piecestore.PieceInfo{
+		pieceA: pieceDeal(pieceA, 1, 1),
+		pieceB: pieceDeal(pieceB, 2, 2),
+		pieceC: pieceDeal(pieceC, 3, 3),
+		pieceD: pieceDeal(pieceD, 4, 3),
+		pieceE: pieceDeal(pieceE, 5, 4),
+		pieceF: pieceDeal(pieceF, 6, 1),
+	}}
+	storage := &mockStorage{unsealed: map[abi.SectorNumber]bool{1: true, 2: true, 3: true, 4: true}}
+	indexes := &mockIndexes{indexes: map[cid.Cid]bool{}}
+	for c := range pieces.pieces {
+		indexes.indexes[c] = true
+	}
+
+	gc := New(Config{Miner: maddr, GracePeriod: 100}, chain, sealing, pieces, storage, indexes)
+
+	collected := func(rpt *Report) (map[cid.Cid]bool, map[abi.SectorNumber]bool) {
+		ps := make(map[cid.Cid]bool)
+		for _, p := range rpt.Pieces {
+			req.Empty(p.Error)
+			ps[p.PieceCid] = p.Collected
+		}
+		ss := make(map[abi.SectorNumber]bool)
+		for _, s := range rpt.Sectors {
+			req.Empty(s.Error)
+			ss[s.Sector] = s.Collected
+		}
+		return ps, ss
+	}
+
+	// A dry run reports what would be collected without removing anything
+	rpt, err := gc.Collect(ctx, true)
+	req.NoError(err)
+	req.True(rpt.DryRun)
+	req.EqualValues(1000, rpt.Height)
+	req.Equal(2, rpt.ActivePieces)
+	ps, ss := collected(rpt)
+	req.Equal(map[cid.Cid]bool{pieceA: true, pieceB: false, pieceD: true, pieceE: false}, ps)
+	req.Equal(map[abi.SectorNumber]bool{1: true, 2: false, 4: false}, ss)
+	req.Empty(storage.removed)
+	req.Empty(indexes.removed)
+
+	for _, p := range rpt.Pieces {
+		switch p.PieceCid {
+		case pieceB:
+			req.EqualValues(950, p.InactiveSince)
+			req.EqualValues(1050, p.CollectAt)
+		case pieceD:
+			req.EqualValues(500, p.InactiveSince)
+		case pieceE:
+			// The grace period of a terminated sector starts when the
+			// termination is first seen
+			req.EqualValues(1000, p.InactiveSince)
+		}
+	}
+
+	// Collect for real
+	rpt, err = gc.Collect(ctx, false)
+	req.NoError(err)
+	ps, ss = collected(rpt)
+	req.Equal(map[cid.Cid]bool{pieceA: true, pieceB: false, pieceD: true, pieceE: false}, ps)
+	req.Equal(map[abi.SectorNumber]bool{1: true, 2: false, 4: false}, ss)
+	req.ElementsMatch([]cid.Cid{pieceA, pieceD}, indexes.removed)
+	req.Equal([]abi.SectorNumber{1}, storage.removed)
+
+	// Once the grace period has passed for the other pieces they are
+	// collected too, and the ones that were already collected are no
+	// longer reported
+	chain.head = testTipSet(t, 1200)
+	rpt, err = gc.Collect(ctx, false)
+	req.NoError(err)
+	ps, ss = collected(rpt)
+	req.Equal(map[cid.Cid]bool{pieceB: true, pieceE: true}, ps)
+	req.Equal(map[abi.SectorNumber]bool{2: true, 4: true}, ss)
+	req.ElementsMatch([]cid.Cid{pieceA, pieceD, pieceB, pieceE}, indexes.removed)
+	req.ElementsMatch([]abi.SectorNumber{1, 2, 4}, storage.removed)
+
+	// The active piece and the other miner's piece are kept
+	req.True(indexes.indexes[pieceC])
+	req.True(indexes.indexes[pieceF])
+	req.True(storage.unsealed[3])
+}
+
+func TestCollectDealStateError(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	maddr, err := address.NewIDAddress(1000)
+	req.NoError(err)
+
+	// The deal has not ended, and its state can't be read from the chain,
+	// so the piece is kept
+	piece := testCid(t, "a")
+	sealing := &mockSealing{sectors: map[abi.SectorNumber]lapi.SectorInfo{
+		1: {State: "Proving", Pieces: []lapi.SectorPiece{{DealInfo: &lapi.PieceDealInfo{DealID: 1, DealProposal: &market.DealProposal{EndEpoch: 5000}}}}},
+	}}
+	chain := &mockChain{head: testTipSet(t, 1000)}
+	pieces := &mockPieceStore{pieces: map[cid.Cid]piecestore.PieceInfo{
+		piece: {PieceCID: piece, Deals: []piecestore.DealInfo{{DealID: 1, SectorID: 1}}},
+	}}
+	storage := &mockStorage{unsealed: map[abi.SectorNumber]bool{1: true}}
+	indexes := &mockIndexes{indexes: map[cid.Cid]bool{piece: true}}
+
+	gc := New(Config{Miner: maddr}, chain, sealing, pieces, storage, indexes)
+	rpt, err := gc.Collect(ctx, false)
+	req.NoError(err)
+	req.Equal(1, rpt.ActivePieces)
+	req.Empty(rpt.Pieces)
+	req.Empty(rpt.Sectors)
+	req.Empty(indexes.removed)
+	req.Empty(storage.removed)
+}
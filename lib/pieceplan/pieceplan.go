@@ -0,0 +1,318 @@
+// Package pieceplan suggests how to split and merge imports into pieces that
+// fit the piece size limits of the providers that deals will be made with,
+// with as little padding as possible.
+//
+// The size of a piece is a power of two, and a CAR file only fills ~127/128
+// of it (the rest is fr32 padding), so a CAR file that is just over a power
+// of two wastes almost half of its piece. The plan
+//   - splits inputs that are larger than the maximum piece size at block
+//     boundaries,
+//   - merges inputs that are smaller than the maximum piece size into the
+//     same piece (first fit decreasing), and
+//   - splits a piece that is only a little more than half full into a piece
+//     of half the size and a smaller piece, when that uses less space.
+package pieceplan
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+const (
+	// The minimum piece size allowed by the market actor
+	minPieceSize = abi.PaddedPieceSize(128)
+
+	// Room left in the CAR file of a piece for its header, and for the
+	// root cids that each part adds to the header
+	headerAllowance = 64
+	partAllowance   = 64
+)
+
+// Constraints are the piece sizes that the providers accept
+type Constraints struct {
+	// The minimum piece size; smaller pieces are padded up to it
+	MinPieceSize abi.PaddedPieceSize `json:"minPieceSize"`
+	// The maximum piece size
+	MaxPieceSize abi.PaddedPieceSize `json:"maxPieceSize"`
+}
+
+// Combine returns the constraints that satisfy each of cs: the largest
+// minimum piece size and the smallest maximum piece size
+func Combine(cs ...Constraints) (Constraints, error) {
+	if len(cs) == 0 {
+		return Constraints{}, errors.New("no piece size constraints")
+	}
+	c := cs[0]
+	for _, o := range cs[1:] {
+		if o.MinPieceSize > c.MinPieceSize {
+			c.MinPieceSize = o.MinPieceSize
+		}
+		if o.MaxPieceSize != 0 && (c.MaxPieceSize == 0 || o.MaxPieceSize < c.MaxPieceSize) {
+			c.MaxPieceSize = o.MaxPieceSize
+		}
+	}
+	return c, c.Validate()
+}
+
+// WithSectorSize caps the maximum piece size at the sector size, so that
+// each piece fits in a sector
+func (c Constraints) WithSectorSize(ss abi.SectorSize) Constraints {
+	if ss != 0 && (c.MaxPieceSize == 0 || abi.PaddedPieceSize(ss) < c.MaxPieceSize) {
+		c.MaxPieceSize = abi.PaddedPieceSize(ss)
+	}
+	return c
+}
+
+// Validate checks that the piece sizes are powers of two, and that there
+// is a piece size that satisfies both the minimum and the maximum
+func (c Constraints) Validate() error {
+	if c.MaxPieceSize == 0 {
+		return errors.New("the maximum piece size must be set")
+	}
+	if err := c.MaxPieceSize.Validate(); err != nil {
+		return fmt.Errorf("maximum piece size: %w", err)
+	}
+	if c.MinPieceSize != 0 {
+		if err := c.MinPieceSize.Validate(); err != nil {
+			return fmt.Errorf("minimum piece size: %w", err)
+		}
+	}
+	if c.MinPieceSize > c.MaxPieceSize {
+		return fmt.Errorf("minimum piece size %d is larger than maximum piece size %d", c.MinPieceSize, c.MaxPieceSize)
+	}
+	return nil
+}
+
+// Input is data to be put into pieces, eg an import
+type Input struct {
+	ID uint64 `json:"id"`
+	// The size of the input's data in a CAR file
+	Size uint64 `json:"size"`
+	// The size in the CAR file of each of the input's blocks, in order.
+	// An input without blocks can't be split, and is put into a piece whole.
+	Blocks []uint64 `json:"blocks,omitempty"`
+}
+
+// Part is an input, or a run of the blocks of an input, that is put into
+// a piece
+type Part struct {
+	InputID uint64 `json:"inputId"`
+	// Whether the part is the whole input
+	Whole bool `json:"whole"`
+	// The index of the first block of the input in the part, and the number
+	// of blocks in the part (zero for an input without blocks)
+	FirstBlock int    `json:"firstBlock"`
+	Blocks     int    `json:"blocks"`
+	Size       uint64 `json:"size"`
+}
+
+// Piece is a piece in the plan
+type Piece struct {
+	Parts []Part `json:"parts"`
+	// The size of the data of the parts
+	PayloadSize uint64              `json:"payloadSize"`
+	PieceSize   abi.PaddedPieceSize `json:"pieceSize"`
+	// The space in the piece that is not used by the data of the parts: the
+	// CAR header, fr32 padding and zero padding
+	Padding uint64 `json:"padding"`
+}
+
+// Plan is the pieces that the inputs are split and merged into
+type Plan struct {
+	Constraints Constraints `json:"constraints"`
+	Pieces      []Piece     `json:"pieces"`
+	PayloadSize uint64      `json:"payloadSize"`
+	PieceSize   uint64      `json:"pieceSize"`
+	Padding     uint64      `json:"padding"`
+	// The number of inputs that are split across more than one piece
+	SplitInputs int `json:"splitInputs"`
+}
+
+// Options are the options for making a plan
+type Options struct {
+	// Don't split inputs across pieces. Inputs that are larger than the
+	// maximum piece size are rejected.
+	NoSplit bool
+}
+
+// Suggest makes a plan for putting the inputs into pieces that satisfy
+// the constraints
+func Suggest(inputs []Input, c Constraints, opts Options) (*Plan, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.MinPieceSize < minPieceSize {
+		c.MinPieceSize = minPieceSize
+	}
+
+	p := &planner{c: c, noSplit: opts.NoSplit, blocks: make(map[uint64][]uint64, len(inputs))}
+	maxCap := p.capacity(c.MaxPieceSize)
+
+	// Split each input that is larger than the maximum piece size into
+	// full pieces, and a remainder that is merged with the other inputs
+	var full [][]Part
+	var parts []Part
+	for _, in := range inputs {
+		if _, ok := p.blocks[in.ID]; ok {
+			return nil, fmt.Errorf("input %d appears more than once", in.ID)
+		}
+		p.blocks[in.ID] = in.Blocks
+		part := Part{InputID: in.ID, Whole: true, Blocks: len(in.Blocks), Size: in.Size}
+		if used([]Part{part}) <= maxCap {
+			parts = append(parts, part)
+			continue
+		}
+		if p.noSplit || len(in.Blocks) == 0 {
+			return nil, fmt.Errorf("input %d of %d bytes doesn't fit in the maximum piece size %d and can't be split",
+				in.ID, in.Size, c.MaxPieceSize)
+		}
+		rest := []Part{part}
+		for used(rest) > maxCap {
+			var taken []Part
+			taken, rest = p.take(rest, maxCap)
+			if len(taken) == 0 {
+				return nil, fmt.Errorf("input %d has a block that doesn't fit in the maximum piece size %d",
+					in.ID, c.MaxPieceSize)
+			}
+			full = append(full, taken)
+		}
+		parts = append(parts, rest...)
+	}
+
+	// Merge the remaining parts into as few pieces of the maximum size as
+	// possible, largest first
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].Size > parts[j].Size
+	})
+	bins := full
+	for _, part := range parts {
+		placed := false
+		for i := len(full); i < len(bins); i++ {
+			if used(bins[i])+part.Size+partAllowance <= maxCap {
+				bins[i] = append(bins[i], part)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			bins = append(bins, []Part{part})
+		}
+	}
+
+	plan := &Plan{Constraints: c}
+	for _, bin := range bins {
+		for _, pc := range p.shrink(bin) {
+			plan.Pieces = append(plan.Pieces, pc)
+			plan.PayloadSize += pc.PayloadSize
+			plan.PieceSize += uint64(pc.PieceSize)
+			plan.Padding += pc.Padding
+		}
+	}
+
+	split := make(map[uint64]struct{})
+	for _, pc := range plan.Pieces {
+		for _, part := range pc.Parts {
+			if !part.Whole {
+				split[part.InputID] = struct{}{}
+			}
+		}
+	}
+	plan.SplitInputs = len(split)
+	return plan, nil
+}
+
+type planner struct {
+	c       Constraints
+	noSplit bool
+	blocks  map[uint64][]uint64
+}
+
+// shrink returns the pieces for the parts. If the parts only just fill
+// more than half of the piece, the piece is split into a piece of half the
+// size and a piece for the rest, if the rest fits in a smaller piece.
+func (p *planner) shrink(parts []Part) []Piece {
+	var pieces []Piece
+	size := p.pieceSize(used(parts))
+	for !p.noSplit && size/2 >= p.c.MinPieceSize {
+		taken, rest := p.take(parts, p.capacity(size/2))
+		if len(taken) == 0 || len(rest) == 0 {
+			break
+		}
+		restSize := p.pieceSize(used(rest))
+		if restSize >= size/2 {
+			break
+		}
+		pieces = append(pieces, newPiece(taken, size/2))
+		parts, size = rest, restSize
+	}
+	return append(pieces, newPiece(parts, size))
+}
+
+// take takes parts, in order, until they fill the capacity. The part that
+// crosses the capacity is split at a block boundary if it has blocks.
+func (p *planner) take(parts []Part, capacity uint64) ([]Part, []Part) {
+	var taken []Part
+	for i, part := range parts {
+		if used(taken)+part.Size+partAllowance <= capacity {
+			taken = append(taken, part)
+			continue
+		}
+		rest := append([]Part{}, parts[i+1:]...)
+		if p.noSplit || part.Blocks == 0 {
+			return taken, append([]Part{part}, rest...)
+		}
+
+		// Split the part after the last block that fits
+		room := int64(capacity) - int64(used(taken)) - partAllowance
+		blocks := p.blocks[part.InputID][part.FirstBlock : part.FirstBlock+part.Blocks]
+		var n int
+		var size uint64
+		for n < len(blocks) && int64(size+blocks[n]) <= room {
+			size += blocks[n]
+			n++
+		}
+		if n == 0 {
+			return taken, append([]Part{part}, rest...)
+		}
+		head := Part{InputID: part.InputID, FirstBlock: part.FirstBlock, Blocks: n, Size: size}
+		tail := Part{InputID: part.InputID, FirstBlock: part.FirstBlock + n, Blocks: part.Blocks - n, Size: part.Size - size}
+		return append(taken, head), append([]Part{tail}, rest...)
+	}
+	return taken, nil
+}
+
+// pieceSize is the smallest piece size that satisfies the constraints and
+// has room for a CAR file of the given size
+func (p *planner) pieceSize(carSize uint64) abi.PaddedPieceSize {
+	size := p.c.MinPieceSize
+	for uint64(size.Unpadded()) < carSize {
+		size *= 2
+	}
+	return size
+}
+
+// capacity is the size of the largest CAR file that fits in the piece size
+func (p *planner) capacity(size abi.PaddedPieceSize) uint64 {
+	return uint64(size.Unpadded())
+}
+
+// used is the size of a CAR file with the parts
+func used(parts []Part) uint64 {
+	size := uint64(headerAllowance)
+	for _, part := range parts {
+		size += part.Size + partAllowance
+	}
+	return size
+}
+
+func newPiece(parts []Part, size abi.PaddedPieceSize) Piece {
+	pc := Piece{Parts: parts, PieceSize: size}
+	for _, part := range parts {
+		pc.PayloadSize += part.Size
+	}
+	pc.Padding = uint64(size) - pc.PayloadSize
+	return pc
+}
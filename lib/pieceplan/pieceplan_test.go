@@ -0,0 +1,144 @@
+package pieceplan
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/require"
+)
+
+const kib = 1024
+
+// input returns an input with n blocks of the given size
+func input(id uint64, n int, blockSize uint64) Input {
+	in := Input{ID: id}
+	for i := 0; i < n; i++ {
+		in.Blocks = append(in.Blocks, blockSize)
+		in.Size += blockSize
+	}
+	return in
+}
+
+// checkPlan checks that each block of each input is in exactly one piece,
+// and that each piece fits its piece size
+func checkPlan(t *testing.T, inputs []Input, plan *Plan) {
+	placed := make(map[uint64]uint64)
+	for _, pc := range plan.Pieces {
+		require.LessOrEqual(t, used(pc.Parts), uint64(pc.PieceSize.Unpadded()))
+		require.GreaterOrEqual(t, pc.PieceSize, plan.Constraints.MinPieceSize)
+		require.LessOrEqual(t, pc.PieceSize, plan.Constraints.MaxPieceSize)
+		for _, part := range pc.Parts {
+			placed[part.InputID] += part.Size
+		}
+	}
+	for _, in := range inputs {
+		require.Equal(t, in.Size, placed[in.ID], "input %d", in.ID)
+	}
+}
+
+func TestSuggestMerge(t *testing.T) {
+	req := require.New(t)
+
+	// Small inputs are merged into one piece
+	inputs := []Input{input(1, 10, kib), input(2, 20, kib), input(3, 5, kib)}
+	c := Constraints{MinPieceSize: 64 * kib, MaxPieceSize: 1024 * kib}
+	plan, err := Suggest(inputs, c, Options{})
+	req.NoError(err)
+	checkPlan(t, inputs, plan)
+	req.Len(plan.Pieces, 1)
+	req.Len(plan.Pieces[0].Parts, 3)
+	req.Equal(abi.PaddedPieceSize(64*kib), plan.Pieces[0].PieceSize)
+	req.EqualValues(35*kib, plan.PayloadSize)
+	req.EqualValues(64*kib-35*kib, plan.Padding)
+	req.Zero(plan.SplitInputs)
+
+	// Inputs that don't fit together go into separate pieces
+	inputs = []Input{input(1, 600, kib), input(2, 600, kib)}
+	plan, err = Suggest(inputs, c, Options{NoSplit: true})
+	req.NoError(err)
+	checkPlan(t, inputs, plan)
+	req.Len(plan.Pieces, 2)
+	req.Equal(abi.PaddedPieceSize(1024*kib), plan.Pieces[0].PieceSize)
+}
+
+func TestSuggestSplit(t *testing.T) {
+	req := require.New(t)
+
+	// An input larger than the maximum piece size is split into full
+	// pieces and a remainder
+	inputs := []Input{input(1, 2500, kib)}
+	c := Constraints{MaxPieceSize: 1024 * kib}
+	plan, err := Suggest(inputs, c, Options{})
+	req.NoError(err)
+	checkPlan(t, inputs, plan)
+	req.Len(plan.Pieces, 3)
+	req.Equal(1, plan.SplitInputs)
+	req.Equal(abi.PaddedPieceSize(1024*kib), plan.Pieces[0].PieceSize)
+	req.Equal(abi.PaddedPieceSize(1024*kib), plan.Pieces[1].PieceSize)
+	req.Equal(0, plan.Pieces[0].Parts[0].FirstBlock)
+	req.Equal(plan.Pieces[0].Parts[0].Blocks, plan.Pieces[1].Parts[0].FirstBlock)
+	req.False(plan.Pieces[0].Parts[0].Whole)
+
+	// Without splitting the input is rejected
+	_, err = Suggest(inputs, c, Options{NoSplit: true})
+	req.ErrorContains(err, "can't be split")
+
+	// An input without blocks can't be split
+	_, err = Suggest([]Input{{ID: 1, Size: 2500 * kib}}, c, Options{})
+	req.ErrorContains(err, "can't be split")
+
+	// A block that is larger than the maximum piece size can't be placed
+	_, err = Suggest([]Input{input(1, 2, 1024*kib)}, c, Options{})
+	req.ErrorContains(err, "has a block that doesn't fit")
+}
+
+func TestSuggestShrink(t *testing.T) {
+	req := require.New(t)
+
+	// An input just over half of a piece is split into a piece of half the
+	// size and a small piece, instead of padding out a piece twice the size
+	inputs := []Input{input(1, 520, kib)}
+	c := Constraints{MinPieceSize: 16 * kib, MaxPieceSize: 1024 * kib}
+	plan, err := Suggest(inputs, c, Options{})
+	req.NoError(err)
+	checkPlan(t, inputs, plan)
+	req.Len(plan.Pieces, 2)
+	req.Equal(abi.PaddedPieceSize(512*kib), plan.Pieces[0].PieceSize)
+	req.Equal(abi.PaddedPieceSize(16*kib), plan.Pieces[1].PieceSize)
+	req.Less(plan.PieceSize, uint64(1024*kib))
+
+	// Without splitting the input is padded out to the larger piece
+	plan, err = Suggest(inputs, c, Options{NoSplit: true})
+	req.NoError(err)
+	req.Len(plan.Pieces, 1)
+	req.Equal(abi.PaddedPieceSize(1024*kib), plan.Pieces[0].PieceSize)
+
+	// The piece isn't split if the minimum piece size means that it
+	// wouldn't save space
+	c.MinPieceSize = 512 * kib
+	plan, err = Suggest(inputs, c, Options{})
+	req.NoError(err)
+	req.Len(plan.Pieces, 1)
+}
+
+func TestConstraints(t *testing.T) {
+	req := require.New(t)
+
+	c, err := Combine(
+		Constraints{MinPieceSize: 256, MaxPieceSize: 64 << 30},
+		Constraints{MinPieceSize: 1 << 20, MaxPieceSize: 32 << 30},
+		Constraints{},
+	)
+	req.NoError(err)
+	req.Equal(Constraints{MinPieceSize: 1 << 20, MaxPieceSize: 32 << 30}, c)
+	req.Equal(abi.PaddedPieceSize(16<<30), c.WithSectorSize(16<<30).MaxPieceSize)
+	req.Equal(abi.PaddedPieceSize(32<<30), c.WithSectorSize(64<<30).MaxPieceSize)
+
+	_, err = Combine(Constraints{MinPieceSize: 1 << 30, MaxPieceSize: 1 << 30}, Constraints{MaxPieceSize: 1 << 20})
+	req.ErrorContains(err, "larger than maximum")
+	req.Error(Constraints{MaxPieceSize: 1000}.Validate())
+	req.Error(Constraints{}.Validate())
+
+	_, err = Suggest([]Input{input(1, 1, kib), input(1, 1, kib)}, Constraints{MaxPieceSize: 1 << 20}, Options{})
+	req.ErrorContains(err, "more than once")
+}
@@ -0,0 +1,511 @@
+// Package prefetch retrieves payload cids that are predicted to be needed
+// soon into a cache in the client repo. Predictions are fetched while the
+// client's bandwidth is idle, subject to a byte budget, and the store keeps
+// count of how often each prediction was used, so that callers can see how
+// accurate their predictions are.
+package prefetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("prefetch")
+
+var ErrNotFound = errors.New("prediction not found")
+
+const (
+	// The payload has not been fetched yet
+	StatePending = "pending"
+	// The payload is being fetched
+	StateFetching = "fetching"
+	// The payload is in the cache
+	StateCached = "cached"
+	// Fetching the payload failed
+	StateFailed = "failed"
+	// The prediction expired, and its cached data (if any) was removed
+	StateExpired = "expired"
+)
+
+// How long a prediction is valid for, if the request doesn't say
+const DefaultTTL = 24 * time.Hour
+
+// Request registers a prediction that a payload will be needed soon
+type Request struct {
+	PayloadCid cid.Cid `json:"payloadCid"`
+	// The storage provider to retrieve the payload from
+	Provider string `json:"provider"`
+	// Predictions with a higher priority are fetched first
+	Priority int `json:"priority,omitempty"`
+	// How long the prediction is valid for, eg 6h (default 24h)
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Prediction is a payload cid that is predicted to be needed soon
+type Prediction struct {
+	PayloadCid   cid.Cid    `json:"payloadCid"`
+	Provider     string     `json:"provider"`
+	Priority     int        `json:"priority"`
+	State        string     `json:"state"`
+	Error        string     `json:"error,omitempty"`
+	Size         int64      `json:"size"`
+	RegisteredAt time.Time  `json:"registeredAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	FetchedAt    *time.Time `json:"fetchedAt,omitempty"`
+	// The number of times the payload was served from the cache
+	Hits int `json:"hits"`
+	// The number of times the payload was needed before it was in the cache
+	Late int `json:"late"`
+}
+
+// Stats describe how accurate the predictions have been
+type Stats struct {
+	Pending  int `json:"pending"`
+	Fetching int `json:"fetching"`
+	Cached   int `json:"cached"`
+	Failed   int `json:"failed"`
+	Expired  int `json:"expired"`
+	// The number of times a predicted payload was served from the cache, and
+	// the number of times one was needed before it was in the cache
+	Hits int `json:"hits"`
+	Late int `json:"late"`
+	// Of the times a predicted payload was needed, the fraction that were
+	// served from the cache
+	HitRate float64 `json:"hitRate"`
+	// Predictions that were fetched and then used, and predictions that were
+	// fetched but expired without being used
+	Used   int `json:"used"`
+	Unused int `json:"unused"`
+	// The fraction of fetched predictions that were used (of those that
+	// have been used or have expired)
+	Accuracy float64 `json:"accuracy"`
+	// The size of the cache
+	CacheBytes int64 `json:"cacheBytes"`
+	// The number of bytes fetched in the last 24 hours
+	FetchedLastDay int64 `json:"fetchedLastDay"`
+}
+
+// Store keeps each prediction as a JSON file in a directory, and the CAR
+// files of fetched predictions in a cache directory. Like the retrievals
+// store, it is file based so that predictions can be registered by one
+// process while they are fetched by another.
+type Store struct {
+	dir      string
+	cacheDir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	s := &Store{dir: filepath.Join(dir, "predictions"), cacheDir: filepath.Join(dir, "cache")}
+	for _, d := range []string{s.dir, s.cacheDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("creating prefetch dir %s: %w", d, err)
+		}
+	}
+	return s, nil
+}
+
+// CarPath is the path of the prediction's CAR file in the cache
+func (s *Store) CarPath(c cid.Cid) string {
+	return filepath.Join(s.cacheDir, c.String()+".car")
+}
+
+// Register adds predictions. If a payload cid has already been predicted,
+// its provider, priority and expiry are updated, and it is fetched again if
+// it failed or expired.
+func (s *Store) Register(reqs []Request) ([]Prediction, error) {
+	now := time.Now()
+	preds := make([]Prediction, 0, len(reqs))
+	for _, req := range reqs {
+		if !req.PayloadCid.Defined() {
+			return nil, errors.New("payload cid is required")
+		}
+		ttl := DefaultTTL
+		if req.TTL != "" {
+			var err error
+			ttl, err = time.ParseDuration(req.TTL)
+			if err != nil {
+				return nil, fmt.Errorf("parsing ttl for %s: %w", req.PayloadCid, err)
+			}
+			if ttl <= 0 {
+				return nil, fmt.Errorf("ttl for %s must be positive", req.PayloadCid)
+			}
+		}
+
+		p, err := s.Get(req.PayloadCid)
+		if errors.Is(err, ErrNotFound) {
+			p = &Prediction{PayloadCid: req.PayloadCid, State: StatePending, RegisteredAt: now}
+		} else if err != nil {
+			return nil, err
+		}
+		if p.State == StateFailed || p.State == StateExpired {
+			p.State = StatePending
+			p.Error = ""
+		}
+		p.Provider = req.Provider
+		p.Priority = req.Priority
+		p.ExpiresAt = now.Add(ttl)
+		if err := s.update(p); err != nil {
+			return nil, err
+		}
+		preds = append(preds, *p)
+	}
+	return preds, nil
+}
+
+// Get returns the prediction for the payload cid
+func (s *Store) Get(c cid.Cid) (*Prediction, error) {
+	b, err := os.ReadFile(s.recordPath(c))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, c)
+		}
+		return nil, fmt.Errorf("reading prediction %s: %w", c, err)
+	}
+
+	var p Prediction
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("unmarshalling prediction %s: %w", c, err)
+	}
+	return &p, nil
+}
+
+// List returns all predictions, in the order that they are fetched: highest
+// priority first, then oldest first
+func (s *Store) List() ([]Prediction, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading prefetch dir %s: %w", s.dir, err)
+	}
+
+	var preds []Prediction
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		c, err := cid.Parse(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		p, err := s.Get(c)
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, *p)
+	}
+
+	sort.Slice(preds, func(i, j int) bool {
+		if preds[i].Priority != preds[j].Priority {
+			return preds[i].Priority > preds[j].Priority
+		}
+		return preds[i].RegisteredAt.Before(preds[j].RegisteredAt)
+	})
+	return preds, nil
+}
+
+// Remove deletes the prediction and its cached data
+func (s *Store) Remove(c cid.Cid) error {
+	if err := os.Remove(s.recordPath(c)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotFound, c)
+		}
+		return fmt.Errorf("removing prediction %s: %w", c, err)
+	}
+	if err := os.Remove(s.CarPath(c)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing cached data for %s: %w", c, err)
+	}
+	return nil
+}
+
+// Use is called when a payload is needed. If the payload was predicted and
+// is in the cache, the hit is recorded and the prediction is returned (its
+// data is at CarPath). Otherwise, if the payload was predicted but has not
+// been fetched yet, the prediction is recorded as late and nil is returned.
+func (s *Store) Use(c cid.Cid) (*Prediction, error) {
+	p, err := s.Get(c)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.State {
+	case StateCached:
+		if _, err := os.Stat(s.CarPath(c)); err != nil {
+			return nil, fmt.Errorf("cached data for %s: %w", c, err)
+		}
+		p.Hits++
+		if err := s.update(p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case StatePending, StateFetching, StateFailed:
+		p.Late++
+		return nil, s.update(p)
+	default:
+		return nil, nil
+	}
+}
+
+// Stats summarizes the predictions
+func (s *Store) Stats() (*Stats, error) {
+	preds, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var st Stats
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	for _, p := range preds {
+		switch p.State {
+		case StatePending:
+			st.Pending++
+		case StateFetching:
+			st.Fetching++
+		case StateCached:
+			st.Cached++
+			st.CacheBytes += p.Size
+		case StateFailed:
+			st.Failed++
+		case StateExpired:
+			st.Expired++
+		}
+		st.Hits += p.Hits
+		st.Late += p.Late
+		if p.FetchedAt != nil {
+			if p.Hits > 0 {
+				st.Used++
+			} else if p.State == StateExpired {
+				st.Unused++
+			}
+			if p.FetchedAt.After(dayAgo) {
+				st.FetchedLastDay += p.Size
+			}
+		}
+	}
+	if st.Hits+st.Late > 0 {
+		st.HitRate = float64(st.Hits) / float64(st.Hits+st.Late)
+	}
+	if st.Used+st.Unused > 0 {
+		st.Accuracy = float64(st.Used) / float64(st.Used+st.Unused)
+	}
+	return &st, nil
+}
+
+// expire marks predictions that have expired, and removes their cached data
+func (s *Store) expire(now time.Time) error {
+	preds, err := s.List()
+	if err != nil {
+		return err
+	}
+	for i := range preds {
+		p := &preds[i]
+		if p.State == StateExpired || now.Before(p.ExpiresAt) {
+			continue
+		}
+		if err := os.Remove(s.CarPath(p.PayloadCid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing cached data for %s: %w", p.PayloadCid, err)
+		}
+		p.State = StateExpired
+		if err := s.update(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) update(p *Prediction) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshalling prediction: %w", err)
+	}
+
+	// Write to a temporary file and rename, so that readers never see a
+	// partially written prediction
+	path := s.recordPath(p.PayloadCid)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing prediction: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing prediction: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) recordPath(c cid.Cid) string {
+	return filepath.Join(s.dir, c.String()+".json")
+}
+
+// Budget limits how much data is prefetched
+type Budget struct {
+	// The maximum number of bytes to fetch in any 24 hours (0 means
+	// unlimited)
+	BytesPerDay uint64
+	// The maximum total size of the cache (0 means unlimited)
+	CacheBytes uint64
+}
+
+// Fetcher retrieves the predicted payload as a CAR file to outPath, and
+// returns its size
+type Fetcher func(ctx context.Context, p Prediction, outPath string) (int64, error)
+
+// IdleFunc returns whether the client's bandwidth is idle, ie it is not
+// retrieving anything that was asked for directly
+type IdleFunc func() bool
+
+// Prefetcher fetches pending predictions into the cache while bandwidth is
+// idle, one at a time, until the budget is used up. Only one prefetcher
+// should run against a store at a time.
+type Prefetcher struct {
+	store  *Store
+	fetch  Fetcher
+	idle   IdleFunc
+	budget Budget
+}
+
+func NewPrefetcher(store *Store, fetch Fetcher, idle IdleFunc, budget Budget) *Prefetcher {
+	return &Prefetcher{store: store, fetch: fetch, idle: idle, budget: budget}
+}
+
+// Run fetches predictions every interval until the context is cancelled
+func (p *Prefetcher) Run(ctx context.Context, interval time.Duration) error {
+	// Predictions that were being fetched when the prefetcher last stopped
+	// are fetched again
+	preds, err := p.store.List()
+	if err != nil {
+		return err
+	}
+	for i := range preds {
+		if preds[i].State == StateFetching {
+			preds[i].State = StatePending
+			if err := p.store.update(&preds[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := p.RunOnce(ctx); err != nil {
+			log.Warnw("prefetching", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce expires old predictions, then fetches pending predictions while
+// bandwidth is idle and the budget allows. It returns the number of
+// predictions that were fetched. The size of a payload isn't known until it
+// has been fetched, so the last fetch may take the cache over budget.
+func (p *Prefetcher) RunOnce(ctx context.Context) (int, error) {
+	if err := p.store.expire(time.Now()); err != nil {
+		return 0, err
+	}
+
+	fetched := 0
+	for ctx.Err() == nil {
+		if !p.idle() {
+			log.Debugw("not prefetching: bandwidth is not idle")
+			return fetched, nil
+		}
+		st, err := p.store.Stats()
+		if err != nil {
+			return fetched, err
+		}
+		if p.budget.BytesPerDay > 0 && uint64(st.FetchedLastDay) >= p.budget.BytesPerDay {
+			log.Debugw("not prefetching: daily budget used up", "fetched", st.FetchedLastDay)
+			return fetched, nil
+		}
+		if p.budget.CacheBytes > 0 && uint64(st.CacheBytes) >= p.budget.CacheBytes {
+			log.Debugw("not prefetching: cache is full", "size", st.CacheBytes)
+			return fetched, nil
+		}
+
+		next, err := p.next()
+		if err != nil || next == nil {
+			return fetched, err
+		}
+		if err := p.fetchPrediction(ctx, next); err != nil {
+			return fetched, err
+		}
+		if next.State == StateCached {
+			fetched++
+		}
+	}
+	return fetched, nil
+}
+
+// next returns the pending prediction to fetch next
+func (p *Prefetcher) next() (*Prediction, error) {
+	preds, err := p.store.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range preds {
+		if preds[i].State == StatePending {
+			return &preds[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *Prefetcher) fetchPrediction(ctx context.Context, pred *Prediction) error {
+	pred.State = StateFetching
+	if err := p.store.update(pred); err != nil {
+		return err
+	}
+
+	carPath := p.store.CarPath(pred.PayloadCid)
+	tmpPath := carPath + ".tmp"
+	size, err := p.fetch(ctx, *pred, tmpPath)
+	if err == nil {
+		err = os.Rename(tmpPath, carPath)
+	}
+
+	// The prediction may have been updated (eg with a hit or a new expiry)
+	// while it was being fetched
+	if cur, gerr := p.store.Get(pred.PayloadCid); gerr == nil {
+		*pred = *cur
+	} else if errors.Is(gerr, ErrNotFound) {
+		// The prediction was removed while it was being fetched
+		_ = os.Remove(tmpPath)
+		_ = os.Remove(carPath)
+		return nil
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		if ctx.Err() != nil {
+			// Fetch it again next time
+			pred.State = StatePending
+		} else {
+			log.Infow("prefetch failed", "payload", pred.PayloadCid, "provider", pred.Provider, "err", err)
+			pred.State = StateFailed
+			pred.Error = err.Error()
+		}
+	} else {
+		log.Infow("prefetched", "payload", pred.PayloadCid, "provider", pred.Provider, "size", size)
+		now := time.Now()
+		pred.State = StateCached
+		pred.Error = ""
+		pred.Size = size
+		pred.FetchedAt = &now
+	}
+	return p.store.update(pred)
+}
@@ -0,0 +1,116 @@
+package prefetch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func testCid(t *testing.T, s string) cid.Cid {
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	a, b, c := testCid(t, "a"), testCid(t, "b"), testCid(t, "c")
+	_, err = store.Register([]Request{{PayloadCid: a, TTL: "-1h"}})
+	require.Error(t, err)
+	preds, err := store.Register([]Request{
+		{PayloadCid: a, Provider: "f01000"},
+		{PayloadCid: b, Provider: "f01000", Priority: 1},
+		{PayloadCid: c, Provider: "f02000", Priority: -1, TTL: "1h"},
+	})
+	require.NoError(t, err)
+	require.Len(t, preds, 3)
+
+	// Each fetch writes 100 bytes, and fetching c fails
+	var fetched []cid.Cid
+	fetch := func(ctx context.Context, p Prediction, outPath string) (int64, error) {
+		fetched = append(fetched, p.PayloadCid)
+		if p.PayloadCid == c {
+			return 0, errors.New("provider unavailable")
+		}
+		return 100, os.WriteFile(outPath, make([]byte, 100), 0644)
+	}
+	idle := true
+	pf := NewPrefetcher(store, fetch, func() bool { return idle }, Budget{BytesPerDay: 150})
+
+	// Nothing is fetched while bandwidth is busy
+	idle = false
+	n, err := pf.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// Predictions are fetched in priority order until the budget is used up
+	idle = true
+	n, err = pf.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []cid.Cid{b, a}, fetched)
+	p, err := store.Get(c)
+	require.NoError(t, err)
+	require.Equal(t, StatePending, p.State)
+
+	// Needing c before it is fetched is late; needing a is a hit
+	p, err = store.Use(c)
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p, err = store.Use(a)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	require.FileExists(t, store.CarPath(a))
+
+	// Raise the budget: fetching c fails
+	pf.budget.BytesPerDay = 0
+	_, err = pf.RunOnce(ctx)
+	require.NoError(t, err)
+	p, err = store.Get(c)
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, p.State)
+	require.Contains(t, p.Error, "provider unavailable")
+
+	// Expire b without it being used
+	b2, err := store.Get(b)
+	require.NoError(t, err)
+	b2.ExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, store.update(b2))
+	_, err = pf.RunOnce(ctx)
+	require.NoError(t, err)
+	require.NoFileExists(t, store.CarPath(b))
+
+	st, err := store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, st.Cached)
+	require.Equal(t, 1, st.Failed)
+	require.Equal(t, 1, st.Expired)
+	require.Equal(t, 1, st.Hits)
+	require.Equal(t, 1, st.Late)
+	require.Equal(t, 0.5, st.HitRate)
+	require.Equal(t, 1, st.Used)
+	require.Equal(t, 1, st.Unused)
+	require.Equal(t, 0.5, st.Accuracy)
+	require.EqualValues(t, 100, st.CacheBytes)
+	require.EqualValues(t, 200, st.FetchedLastDay)
+
+	// Registering an expired prediction again fetches it again
+	_, err = store.Register([]Request{{PayloadCid: b, Provider: "f01000"}})
+	require.NoError(t, err)
+	n, err = pf.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	require.NoError(t, store.Remove(a))
+	require.NoFileExists(t, store.CarPath(a))
+	require.ErrorIs(t, store.Remove(a), ErrNotFound)
+}
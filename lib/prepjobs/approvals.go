@@ -0,0 +1,203 @@
+package prepjobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+)
+
+var ErrApprovalNotFound = errors.New("approval not found")
+var ErrApprovalDecided = errors.New("approval has already been decided")
+
+const (
+	// The proposal is waiting to be approved or rejected
+	ApprovalPending = "pending"
+	// The proposal was approved, and is sent to the provider
+	ApprovalApproved = "approved"
+	// The proposal was rejected, and is not sent to the provider
+	ApprovalRejected = "rejected"
+)
+
+// Approvals are keyed by an id derived from the job, piece and provider, so
+// that there is at most one approval for each proposal
+var approvalNamespace = uuid.MustParse("6b1e8f1c-3c5e-4d55-9a43-2f6d1d0b7a52")
+
+// ApprovalThresholds determine which deal proposals are held for approval
+// before they are sent. A zero threshold is not checked.
+type ApprovalThresholds struct {
+	// Proposals for pieces larger than this need approval
+	PieceSize abi.PaddedPieceSize
+	// Proposals for deals that cost more than this over their duration
+	// need approval
+	Spend abi.TokenAmount
+	// Proposals to these providers need approval
+	Providers []address.Address
+	// ProviderRisk returns why proposals to the provider are risky (eg it
+	// has repeatedly missed its SLAs), or an empty string if they are not
+	ProviderRisk func(provider address.Address) string
+}
+
+// reasons returns why the proposal needs approval, if it does
+func (t *ApprovalThresholds) reasons(pieceSize abi.PaddedPieceSize, spend abi.TokenAmount, provider address.Address) []string {
+	var reasons []string
+	if t.PieceSize > 0 && pieceSize > t.PieceSize {
+		reasons = append(reasons, fmt.Sprintf("piece size %s is above %s",
+			humanize.IBytes(uint64(pieceSize)), humanize.IBytes(uint64(t.PieceSize))))
+	}
+	if t.Spend.Int != nil && !t.Spend.IsZero() && spend.GreaterThan(t.Spend) {
+		reasons = append(reasons, fmt.Sprintf("deal cost %s is above %s", types.FIL(spend).Short(), types.FIL(t.Spend).Short()))
+	}
+	for _, p := range t.Providers {
+		if p == provider {
+			reasons = append(reasons, fmt.Sprintf("provider %s requires approval", provider))
+		}
+	}
+	if t.ProviderRisk != nil {
+		if risk := t.ProviderRisk(provider); risk != "" {
+			reasons = append(reasons, risk)
+		}
+	}
+	return reasons
+}
+
+// Approval is a deal proposal that is held until it is approved or rejected
+type Approval struct {
+	ID        uuid.UUID
+	JobID     uuid.UUID
+	PieceCid  cid.Cid
+	PieceSize abi.PaddedPieceSize
+	Provider  address.Address
+	// The cost of the deal over its duration
+	Spend abi.TokenAmount
+	// Why the proposal needs approval
+	Reasons   []string
+	State     string
+	CreatedAt time.Time
+	// Who approved or rejected the proposal, when and why
+	Approver  string    `json:",omitempty"`
+	Comment   string    `json:",omitempty"`
+	DecidedAt time.Time `json:",omitempty"`
+}
+
+func approvalID(jobID uuid.UUID, pieceCid cid.Cid, provider address.Address) uuid.UUID {
+	return uuid.NewSHA1(approvalNamespace, []byte(jobID.String()+"/"+pieceCid.String()+"/"+provider.String()))
+}
+
+// RequestApproval holds the proposal for approval. If the proposal already
+// has an approval (in any state), the existing approval is returned.
+func (s *Store) RequestApproval(ctx context.Context, jobID uuid.UUID, piece Piece, provider address.Address, spend abi.TokenAmount, reasons []string) (*Approval, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	id := approvalID(jobID, piece.PieceCid, provider)
+	if a, err := s.approval(ctx, id); err == nil {
+		return a, nil
+	} else if !errors.Is(err, ErrApprovalNotFound) {
+		return nil, err
+	}
+
+	a := &Approval{
+		ID:        id,
+		JobID:     jobID,
+		PieceCid:  piece.PieceCid,
+		PieceSize: piece.PieceSize,
+		Provider:  provider,
+		Spend:     spend,
+		Reasons:   reasons,
+		State:     ApprovalPending,
+		CreatedAt: time.Now(),
+	}
+	if err := s.putJSON(ctx, s.approvals, jobKey(id), a); err != nil {
+		return nil, fmt.Errorf("saving approval %s: %w", id, err)
+	}
+	return a, nil
+}
+
+// ApprovalFor returns the approval for a proposal, or ErrApprovalNotFound if
+// the proposal hasn't been held for approval
+func (s *Store) ApprovalFor(ctx context.Context, jobID uuid.UUID, pieceCid cid.Cid, provider address.Address) (*Approval, error) {
+	return s.Approval(ctx, approvalID(jobID, pieceCid, provider))
+}
+
+func (s *Store) Approval(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.approval(ctx, id)
+}
+
+func (s *Store) approval(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	var a Approval
+	if err := s.getJSON(ctx, s.approvals, jobKey(id), &a); err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, fmt.Errorf("approval %s: %w", id, ErrApprovalNotFound)
+		}
+		return nil, fmt.Errorf("getting approval %s: %w", id, err)
+	}
+	return &a, nil
+}
+
+// Approvals lists the approvals in the state (or in all states if state is
+// empty), oldest first
+func (s *Store) Approvals(ctx context.Context, state string) ([]Approval, error) {
+	recs, err := s.approvals.list(ctx, datastore.NewKey("/"))
+	if err != nil {
+		return nil, fmt.Errorf("listing approvals: %w", err)
+	}
+
+	var approvals []Approval
+	for _, r := range recs {
+		var a Approval
+		if err := json.Unmarshal(r.value, &a); err != nil {
+			return nil, fmt.Errorf("unmarshalling approval %s: %w", r.key, err)
+		}
+		if state == "" || a.State == state {
+			approvals = append(approvals, a)
+		}
+	}
+
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].CreatedAt.Before(approvals[j].CreatedAt)
+	})
+	return approvals, nil
+}
+
+// DecideApproval approves or rejects a pending proposal, recording who made
+// the decision and why
+func (s *Store) DecideApproval(ctx context.Context, id uuid.UUID, approve bool, approver string, comment string) (*Approval, error) {
+	if approver == "" {
+		return nil, errors.New("approver must not be empty")
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	a, err := s.approval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.State != ApprovalPending {
+		return nil, fmt.Errorf("%w: %s was %s by %s", ErrApprovalDecided, id, a.State, a.Approver)
+	}
+	a.State = ApprovalRejected
+	if approve {
+		a.State = ApprovalApproved
+	}
+	a.Approver = approver
+	a.Comment = comment
+	a.DecidedAt = time.Now()
+	if err := s.putJSON(ctx, s.approvals, jobKey(id), a); err != nil {
+		return nil, fmt.Errorf("saving approval %s: %w", id, err)
+	}
+	return a, nil
+}
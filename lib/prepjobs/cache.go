@@ -0,0 +1,205 @@
+package prepjobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// DefaultCacheRecords is the default maximum number of records that the
+// store caches in memory for each kind of record
+const DefaultCacheRecords = 100_000
+
+// CacheStats counts the reads of a kind of record that were served from the
+// cache, and the reads that went to the datastore
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	// The number of records in the cache
+	Records int `json:"records"`
+}
+
+// record is a record in the datastore, as json
+type record struct {
+	key   datastore.Key
+	value []byte
+}
+
+// recordCache is a read-through cache of the records in a datastore
+// namespace. Records are written through the cache, so that each state
+// change (eg a deal being added to a piece) updates the cached record. Once
+// all the records under a prefix have been read from the datastore, they
+// are listed from the cache, which saves a datastore query (and the
+// decryption of each record) for dashboards that poll the list endpoints.
+type recordCache struct {
+	ds  datastore.Batching
+	max int
+
+	lk      sync.Mutex
+	records map[datastore.Key][]byte
+	// The prefixes whose records are all in the cache. The root prefix
+	// means that all records are in the cache.
+	listed map[datastore.Key]struct{}
+	// Incremented on each write, so that a read that raced with a write
+	// doesn't put a stale record in the cache
+	gen   uint64
+	stats CacheStats
+}
+
+// newRecordCache caches up to max records of ds. If max is zero nothing is
+// cached.
+func newRecordCache(ds datastore.Batching, max int) *recordCache {
+	return &recordCache{
+		ds:      ds,
+		max:     max,
+		records: make(map[datastore.Key][]byte),
+		listed:  make(map[datastore.Key]struct{}),
+	}
+}
+
+func (c *recordCache) get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	c.lk.Lock()
+	if v, ok := c.records[key]; ok {
+		c.stats.Hits++
+		c.lk.Unlock()
+		return v, nil
+	}
+	if c.isListed(key.Parent()) {
+		c.stats.Hits++
+		c.lk.Unlock()
+		return nil, datastore.ErrNotFound
+	}
+	c.stats.Misses++
+	gen := c.gen
+	c.lk.Unlock()
+
+	v, err := c.ds.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.gen == gen {
+		c.add(key, v)
+	}
+	return v, nil
+}
+
+func (c *recordCache) has(ctx context.Context, key datastore.Key) (bool, error) {
+	_, err := c.get(ctx, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *recordCache) put(ctx context.Context, key datastore.Key, value []byte) error {
+	err := c.ds.Put(ctx, key, value)
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.gen++
+	if err != nil {
+		// The record may or may not have been written
+		c.evict(key)
+		return err
+	}
+	c.add(key, value)
+	return nil
+}
+
+// list returns the records under the prefix, in no particular order
+func (c *recordCache) list(ctx context.Context, prefix datastore.Key) ([]record, error) {
+	c.lk.Lock()
+	if c.isListed(prefix) {
+		c.stats.Hits++
+		var recs []record
+		for k, v := range c.records {
+			if prefix.IsAncestorOf(k) {
+				recs = append(recs, record{key: k, value: v})
+			}
+		}
+		c.lk.Unlock()
+		return recs, nil
+	}
+	c.stats.Misses++
+	gen := c.gen
+	c.lk.Unlock()
+
+	res, err := c.ds.Query(ctx, query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("querying %s: %w", strings.TrimPrefix(prefix.String(), "/"), err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var recs []record
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("reading %s: %w", r.Key, r.Error)
+		}
+		recs = append(recs, record{key: datastore.NewKey(r.Key), value: r.Value})
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	uncached := 0
+	for _, r := range recs {
+		if _, ok := c.records[r.key]; !ok {
+			uncached++
+		}
+	}
+	if c.max > 0 && c.gen == gen && len(c.records)+uncached <= c.max {
+		for _, r := range recs {
+			c.add(r.key, r.value)
+		}
+		c.listed[prefix] = struct{}{}
+	}
+	return recs, nil
+}
+
+func (c *recordCache) isListed(prefix datastore.Key) bool {
+	if _, ok := c.listed[datastore.NewKey("/")]; ok {
+		return true
+	}
+	_, ok := c.listed[prefix]
+	return ok
+}
+
+// add adds the record to the cache, evicting another record if the cache
+// is full
+func (c *recordCache) add(key datastore.Key, value []byte) {
+	if c.max == 0 {
+		return
+	}
+	if _, ok := c.records[key]; !ok && len(c.records) >= c.max {
+		for k := range c.records {
+			c.evict(k)
+			c.stats.Evictions++
+			break
+		}
+	}
+	c.records[key] = value
+}
+
+// evict removes the record from the cache. The prefixes that the record is
+// under are no longer completely in the cache.
+func (c *recordCache) evict(key datastore.Key) {
+	delete(c.records, key)
+	delete(c.listed, datastore.NewKey("/"))
+	delete(c.listed, key.Parent())
+}
+
+func (c *recordCache) cacheStats() CacheStats {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	st := c.stats
+	st.Records = len(c.records)
+	return st
+}
@@ -0,0 +1,155 @@
+package prepjobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDatastore counts the reads that reach the datastore
+type countingDatastore struct {
+	datastore.Batching
+
+	lk      sync.Mutex
+	gets    int
+	queries int
+}
+
+func (d *countingDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	d.lk.Lock()
+	d.gets++
+	d.lk.Unlock()
+	return d.Batching.Get(ctx, key)
+}
+
+func (d *countingDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	d.lk.Lock()
+	d.queries++
+	d.lk.Unlock()
+	return d.Batching.Query(ctx, q)
+}
+
+func (d *countingDatastore) reads() int {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return d.gets + d.queries
+}
+
+func TestStoreCache(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	ds := &countingDatastore{Batching: dssync.MutexWrap(datastore.NewMapDatastore())}
+	store := NewStore(ds)
+
+	prov, err := address.NewIDAddress(1000)
+	req.NoError(err)
+	policy := Policy{Providers: []address.Address{prov}, Replicas: 1, Duration: 1000, StoragePrice: big.Zero()}
+	job, err := store.CreateJob(ctx, "job", policy)
+	req.NoError(err)
+	pieceCid := testCid(t, "piece")
+	req.NoError(store.AddPiece(ctx, job.ID, Piece{PieceCid: pieceCid, PieceSize: abi.PaddedPieceSize(1 << 20)}))
+
+	// The first list reads from the datastore, and later lists are served
+	// from the cache
+	jobs, err := store.Jobs(ctx)
+	req.NoError(err)
+	req.Len(jobs, 1)
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces, 1)
+	reads := ds.reads()
+	for i := 0; i < 10; i++ {
+		_, err = store.Jobs(ctx)
+		req.NoError(err)
+		_, err = store.Pieces(ctx, job.ID)
+		req.NoError(err)
+		_, err = store.Job(ctx, job.ID)
+		req.NoError(err)
+	}
+	req.Equal(reads, ds.reads())
+
+	// Changes are reflected in the cached records
+	req.NoError(store.AddDeal(ctx, job.ID, pieceCid, Deal{Provider: prov, DealUUID: uuid.New(), Accepted: true}))
+	req.NoError(store.CloseJob(ctx, job.ID))
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces[0].Deals, 1)
+	j, err := store.Job(ctx, job.ID)
+	req.NoError(err)
+	req.True(j.Closed)
+	req.Equal(reads, ds.reads())
+
+	// A job that is not in the fully listed cache is not found without a
+	// read
+	_, err = store.Job(ctx, uuid.New())
+	req.ErrorIs(err, ErrJobNotFound)
+	req.Equal(reads, ds.reads())
+
+	stats := store.CacheStats()
+	req.NotZero(stats["jobs"].Hits)
+	req.Equal(1, stats["jobs"].Records)
+	req.NotZero(stats["pieces"].Hits)
+}
+
+func TestRecordCacheEviction(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	ds := &countingDatastore{Batching: dssync.MutexWrap(datastore.NewMapDatastore())}
+	for _, k := range []string{"/a/1", "/a/2", "/b/1"} {
+		req.NoError(ds.Put(ctx, datastore.NewKey(k), []byte(k)))
+	}
+	c := newRecordCache(ds, 2)
+
+	// Once the records under a prefix have been listed, they are listed
+	// from the cache
+	recs, err := c.list(ctx, datastore.NewKey("/a"))
+	req.NoError(err)
+	req.Len(recs, 2)
+	req.True(c.isListed(datastore.NewKey("/a")))
+	reads := ds.reads()
+	recs, err = c.list(ctx, datastore.NewKey("/a"))
+	req.NoError(err)
+	req.Len(recs, 2)
+	req.Equal(reads, ds.reads())
+
+	// There are too many records to cache them all
+	recs, err = c.list(ctx, datastore.NewKey("/"))
+	req.NoError(err)
+	req.Len(recs, 3)
+	req.False(c.isListed(datastore.NewKey("/")))
+
+	// Caching another record evicts one of the listed records, so the
+	// prefix is no longer completely in the cache
+	req.NoError(c.put(ctx, datastore.NewKey("/b/1"), []byte("/b/1")))
+	req.False(c.isListed(datastore.NewKey("/a")))
+	st := c.cacheStats()
+	req.Equal(2, st.Records)
+	req.EqualValues(1, st.Evictions)
+	req.EqualValues(1, st.Hits)
+	req.EqualValues(2, st.Misses)
+
+	// With caching disabled, every read goes to the datastore
+	c = newRecordCache(ds, 0)
+	reads = ds.reads()
+	for i := 0; i < 3; i++ {
+		_, err := c.list(ctx, datastore.NewKey("/a"))
+		req.NoError(err)
+		v, err := c.get(ctx, datastore.NewKey("/b/1"))
+		req.NoError(err)
+		req.Equal("/b/1", string(v))
+	}
+	req.Equal(reads+6, ds.reads())
+	_, err = c.get(ctx, datastore.NewKey("/c/1"))
+	req.ErrorIs(err, datastore.ErrNotFound)
+}
@@ -0,0 +1,587 @@
+package prepjobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/boost/lib/pieceplan"
+	"github.com/filecoin-project/boost/lib/regions"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultStartEpochOffset is the start epoch offset used when the job policy
+// doesn't specify one (2 days)
+const DefaultStartEpochOffset = abi.ChainEpoch(5760)
+
+// CreateJobRequest is the body of a request to create a job
+type CreateJobRequest struct {
+	Name   string        `json:"name"`
+	Policy PolicyRequest `json:"policy"`
+}
+
+// PolicyRequest is the policy of a job in a request to create the job
+type PolicyRequest struct {
+	Providers []string `json:"providers"`
+	Replicas  int      `json:"replicas"`
+	Duration  int64    `json:"duration"`
+	// Zero means DefaultStartEpochOffset
+	StartEpochOffset int64 `json:"startEpochOffset,omitempty"`
+	Verified         bool  `json:"verified,omitempty"`
+	// attoFIL per epoch per GiB
+	StoragePrice string `json:"storagePrice,omitempty"`
+	// RFC 3339 time before which deals are not proposed
+	ProposeAfter time.Time `json:"proposeAfter,omitempty"`
+	// Staggers the end epochs of the job's deals (optional)
+	Ladder *LadderRequest `json:"ladder,omitempty"`
+	// Constrains the regions of the providers of each piece (optional)
+	Regions *RegionsRequest `json:"regions,omitempty"`
+	// The region of each provider, keyed by provider address. These
+	// override the regions from config, reputation feeds and the providers'
+	// own declarations.
+	ProviderRegions map[string]string `json:"providerRegions,omitempty"`
+	// The job is not urgent: deals are proposed during the providers'
+	// announced off-peak windows
+	OffPeak bool `json:"offPeak,omitempty"`
+	// RFC 3339 time after which deals for an off-peak job are proposed
+	// regardless of the providers' windows (optional)
+	OffPeakDeadline time.Time `json:"offPeakDeadline,omitempty"`
+}
+
+// RegionsRequest is the region constraint of a job's policy in a request to
+// create the job, eg at least 3 distinct regions, none in cn-north
+type RegionsRequest struct {
+	MinRegions int      `json:"minRegions,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+}
+
+// LadderRequest is the ladder of a job's policy in a request to create the
+// job
+type LadderRequest struct {
+	Rungs int `json:"rungs"`
+	// Epochs between the end epochs of consecutive rungs
+	Spacing int64 `json:"spacing"`
+}
+
+// AddPieceRequest is the body of a request to register a prepared piece
+type AddPieceRequest struct {
+	PieceCid   string            `json:"pieceCid"`
+	PieceSize  uint64            `json:"pieceSize"`
+	PayloadCid string            `json:"payloadCid"`
+	CarSize    uint64            `json:"carSize"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// PlanRequest is the body of a request for a plan of how to split and merge
+// data into pieces, before the pieces are prepared
+type PlanRequest struct {
+	// The data to put into pieces. Inputs with the size of each of their
+	// blocks may be split across pieces at block boundaries.
+	Inputs []pieceplan.Input `json:"inputs"`
+	// The piece size limits of the providers
+	MinPieceSize uint64 `json:"minPieceSize,omitempty"`
+	MaxPieceSize uint64 `json:"maxPieceSize"`
+	// The sector size of the providers, which the maximum piece size is
+	// capped at (optional)
+	SectorSize uint64 `json:"sectorSize,omitempty"`
+	// Don't split inputs across pieces
+	NoSplit bool `json:"noSplit,omitempty"`
+}
+
+// JobStatus is a job, its pieces and a summary of progress
+type JobStatus struct {
+	Job    Job     `json:"job"`
+	Pieces []Piece `json:"pieces,omitempty"`
+	// The number of pieces with all the replicas required by the policy
+	Complete int `json:"complete"`
+	// The number of pieces that still need more replicas
+	Pending       int `json:"pending"`
+	DealsAccepted int `json:"dealsAccepted"`
+	DealsRejected int `json:"dealsRejected"`
+	DealsFailed   int `json:"dealsFailed"`
+	// The number of deal proposals whose outcome is unknown, until the
+	// provider is asked whether it has the deal
+	DealsUnknown int `json:"dealsUnknown,omitempty"`
+	// The number of deal proposals waiting to be approved
+	PendingApprovals int `json:"pendingApprovals"`
+	// The number of accepted deals in each provider region, if the policy
+	// has a region constraint
+	Regions map[string]int `json:"regions,omitempty"`
+	// The complete pieces whose accepted deals don't satisfy the policy's
+	// region constraint
+	RegionViolations []RegionViolation `json:"regionViolations,omitempty"`
+}
+
+// DecideApprovalRequest is the body of a request to approve or reject a deal
+// proposal. The approver is the API token that authenticated the request.
+type DecideApprovalRequest struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// AdminApprover is recorded as the approver of decisions made with the admin
+// token, or when the API isn't authenticated
+const AdminApprover = "admin"
+
+// NewHandler returns an http handler for the job API:
+//
+//	POST /jobs                   create a job
+//	GET  /jobs                   list jobs
+//	GET  /jobs/{id}              get the status of a job and its pieces
+//	POST /jobs/{id}/pieces       register a piece (or an array of pieces)
+//	POST /jobs/{id}/close        stop accepting pieces for the job
+//	GET  /jobs/{id}/ladder       get the job's pieces by ladder rung, soonest to expire first
+//	POST /plan                   suggest how to split and merge data into pieces
+//	GET  /openapi.json           the OpenAPI document describing the job and approval APIs
+func NewHandler(store *Store, sched *Scheduler) http.Handler {
+	h := &handler{store: store, sched: sched}
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", h.createJob).Methods(http.MethodPost)
+	r.HandleFunc("/jobs", h.listJobs).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id}", h.getJob).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id}/pieces", h.addPieces).Methods(http.MethodPost)
+	r.HandleFunc("/jobs/{id}/close", h.closeJob).Methods(http.MethodPost)
+	r.HandleFunc("/jobs/{id}/ladder", h.getLadder).Methods(http.MethodGet)
+	r.HandleFunc("/plan", h.plan).Methods(http.MethodPost)
+	r.Handle("/openapi.json", OpenAPI()).Methods(http.MethodGet)
+	return r
+}
+
+type handler struct {
+	store *Store
+	sched *Scheduler
+}
+
+// NewApprovalHandler returns an http handler for approving deal proposals
+// that are held for approval:
+//
+//	GET  /approvals?state=pending     list approvals (in all states if state is not set)
+//	GET  /approvals/{id}              get an approval
+//	POST /approvals/{id}/approve      approve the proposal so that it is sent
+//	POST /approvals/{id}/reject       reject the proposal so that it is never sent
+func NewApprovalHandler(store *Store, sched *Scheduler) http.Handler {
+	h := &handler{store: store, sched: sched}
+	r := mux.NewRouter()
+	r.HandleFunc("/approvals", h.listApprovals).Methods(http.MethodGet)
+	r.HandleFunc("/approvals/{id}", h.getApproval).Methods(http.MethodGet)
+	r.HandleFunc("/approvals/{id}/approve", h.decideApproval(true)).Methods(http.MethodPost)
+	r.HandleFunc("/approvals/{id}/reject", h.decideApproval(false)).Methods(http.MethodPost)
+	return r
+}
+
+// NewCacheStatsHandler returns an http handler for the store's cache
+// statistics:
+//
+//	GET  /cache                       the cache hits and misses for jobs, pieces and approvals
+func NewCacheStatsHandler(store *Store) http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/cache", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.CacheStats())
+	}).Methods(http.MethodGet)
+	return r
+}
+
+func (h *handler) createJob(w http.ResponseWriter, r *http.Request) {
+	var req CreateJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+
+	policy := Policy{
+		Replicas:         req.Policy.Replicas,
+		Duration:         abi.ChainEpoch(req.Policy.Duration),
+		StartEpochOffset: abi.ChainEpoch(req.Policy.StartEpochOffset),
+		Verified:         req.Policy.Verified,
+		StoragePrice:     big.Zero(),
+		ProposeAfter:     req.Policy.ProposeAfter,
+		OffPeak:          req.Policy.OffPeak,
+		OffPeakDeadline:  req.Policy.OffPeakDeadline,
+	}
+	if policy.StartEpochOffset == 0 {
+		policy.StartEpochOffset = DefaultStartEpochOffset
+	}
+	for _, p := range req.Policy.Providers {
+		addr, err := address.NewFromString(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing provider address %s: %w", p, err))
+			return
+		}
+		policy.Providers = append(policy.Providers, addr)
+	}
+	if l := req.Policy.Ladder; l != nil {
+		policy.Ladder = &Ladder{Rungs: l.Rungs, Spacing: abi.ChainEpoch(l.Spacing)}
+	}
+	if rr := req.Policy.Regions; rr != nil {
+		policy.Regions = &RegionConstraint{MinRegions: rr.MinRegions}
+		for _, r := range rr.Exclude {
+			policy.Regions.Exclude = append(policy.Regions.Exclude, regions.Normalize(r))
+		}
+	}
+	for p, r := range req.Policy.ProviderRegions {
+		addr, err := address.NewFromString(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing provider address %s in provider regions: %w", p, err))
+			return
+		}
+		if r = regions.Normalize(r); r != "" {
+			if policy.ProviderRegions == nil {
+				policy.ProviderRegions = make(map[string]string)
+			}
+			policy.ProviderRegions[addr.String()] = r
+		}
+	}
+	if policy.Regions != nil {
+		if err := resolveRegions(r.Context(), h.sched.regions, &policy); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if req.Policy.StoragePrice != "" {
+		price, err := big.FromString(req.Policy.StoragePrice)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing storage price %s: %w", req.Policy.StoragePrice, err))
+			return
+		}
+		policy.StoragePrice = price
+	}
+	if err := policy.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.store.CreateJob(r.Context(), req.Name, policy)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (h *handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.store.Jobs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		if !canAccess(r, job) {
+			continue
+		}
+		st, err := h.jobStatus(r, job)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		st.Pieces = nil
+		statuses = append(statuses, *st)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (h *handler) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	st, err := h.jobStatus(r, *job)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *handler) addPieces(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Accept either a single piece or an array of pieces
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	var reqs []AddPieceRequest
+	if err := json.Unmarshal(raw, &reqs); err != nil {
+		var req AddPieceRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+			return
+		}
+		reqs = []AddPieceRequest{req}
+	}
+
+	pieces := make([]Piece, 0, len(reqs))
+	for _, req := range reqs {
+		piece, err := req.piece()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		pieces = append(pieces, *piece)
+	}
+
+	for _, piece := range pieces {
+		if err := h.store.AddPiece(r.Context(), job.ID, piece); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrJobClosed) {
+				status = http.StatusConflict
+			}
+			writeError(w, status, err)
+			return
+		}
+	}
+	h.sched.Notify()
+
+	writeJSON(w, http.StatusAccepted, map[string]int{"added": len(pieces)})
+}
+
+func (h *handler) closeJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.CloseJob(r.Context(), job.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) getLadder(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	rungs, err := h.store.Ladder(r.Context(), job.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rungs)
+}
+
+func (h *handler) plan(w http.ResponseWriter, r *http.Request) {
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	c := pieceplan.Constraints{
+		MinPieceSize: abi.PaddedPieceSize(req.MinPieceSize),
+		MaxPieceSize: abi.PaddedPieceSize(req.MaxPieceSize),
+	}.WithSectorSize(abi.SectorSize(req.SectorSize))
+	plan, err := pieceplan.Suggest(req.Inputs, c, pieceplan.Options{NoSplit: req.NoSplit})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+func (h *handler) listApprovals(w http.ResponseWriter, r *http.Request) {
+	approvals, err := h.store.Approvals(r.Context(), r.URL.Query().Get("state"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if approvals == nil {
+		approvals = []Approval{}
+	}
+	writeJSON(w, http.StatusOK, approvals)
+}
+
+func (h *handler) getApproval(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing approval id: %w", err))
+		return
+	}
+	a, err := h.store.Approval(r.Context(), id)
+	if err != nil {
+		writeError(w, approvalErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *handler) decideApproval(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing approval id: %w", err))
+			return
+		}
+		// The body is optional, as it only holds a comment
+		var req DecideApprovalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+			return
+		}
+		approver, ok := apiquota.TokenFromContext(r.Context())
+		if !ok {
+			approver = AdminApprover
+		}
+
+		a, err := h.store.DecideApproval(r.Context(), id, approve, approver, req.Comment)
+		if err != nil {
+			writeError(w, approvalErrorStatus(err), err)
+			return
+		}
+		log.Infow("deal proposal decided", "approval", a.ID, "job", a.JobID, "piece", a.PieceCid,
+			"provider", a.Provider, "state", a.State, "approver", a.Approver)
+		if approve {
+			h.sched.Notify()
+		}
+		writeJSON(w, http.StatusOK, a)
+	}
+}
+
+func approvalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrApprovalNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrApprovalDecided):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *handler) jobFromPath(w http.ResponseWriter, r *http.Request) (*Job, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing job id: %w", err))
+		return nil, false
+	}
+	job, err := h.store.Job(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return nil, false
+	}
+	if !canAccess(r, *job) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s: %w", id, ErrJobNotFound))
+		return nil, false
+	}
+	return job, true
+}
+
+// canAccess returns false if the request was made with an API token other
+// than the one that created the job. Requests made with the admin token can
+// access all jobs.
+func canAccess(r *http.Request, job Job) bool {
+	owner, ok := apiquota.TokenFromContext(r.Context())
+	return !ok || owner == job.Owner
+}
+
+func (h *handler) jobStatus(r *http.Request, job Job) (*JobStatus, error) {
+	pieces, err := h.store.Pieces(r.Context(), job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	approvals, err := h.store.Approvals(r.Context(), ApprovalPending)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &JobStatus{Job: job, Pieces: pieces}
+	for _, a := range approvals {
+		if a.JobID == job.ID {
+			st.PendingApprovals++
+		}
+	}
+	if job.Policy.Regions != nil {
+		st.Regions = make(map[string]int)
+	}
+	for _, piece := range pieces {
+		if piece.Accepted() >= job.Policy.Replicas {
+			st.Complete++
+			if reason := job.Policy.CheckRegions(piece); reason != "" {
+				st.RegionViolations = append(st.RegionViolations, RegionViolation{PieceCid: piece.PieceCid, Reason: reason})
+			}
+		} else {
+			st.Pending++
+		}
+		for _, d := range piece.Deals {
+			switch {
+			case d.Accepted:
+				st.DealsAccepted++
+				if st.Regions != nil {
+					region := job.Policy.RegionOf(d.Provider)
+					if region == "" {
+						region = "unknown"
+					}
+					st.Regions[region]++
+				}
+			case d.OutcomeUnknown:
+				st.DealsUnknown++
+			case d.Error != "":
+				st.DealsFailed++
+			default:
+				st.DealsRejected++
+			}
+		}
+	}
+	return st, nil
+}
+
+func (req *AddPieceRequest) piece() (*Piece, error) {
+	pieceCid, err := cid.Parse(req.PieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("parsing piece cid %s: %w", req.PieceCid, err)
+	}
+	payloadCid, err := cid.Parse(req.PayloadCid)
+	if err != nil {
+		return nil, fmt.Errorf("parsing payload cid %s: %w", req.PayloadCid, err)
+	}
+	pieceSize := abi.PaddedPieceSize(req.PieceSize)
+	if err := pieceSize.Validate(); err != nil {
+		return nil, fmt.Errorf("piece %s: %w", pieceCid, err)
+	}
+	if req.CarSize == 0 {
+		return nil, fmt.Errorf("piece %s: car size must be greater than zero", pieceCid)
+	}
+	if req.URL == "" {
+		return nil, fmt.Errorf("piece %s: url must not be empty", pieceCid)
+	}
+
+	return &Piece{
+		PieceCid:   pieceCid,
+		PieceSize:  pieceSize,
+		PayloadCid: payloadCid,
+		CarSize:    req.CarSize,
+		URL:        req.URL,
+		Headers:    req.Headers,
+	}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnw("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,604 @@
+// Package prepjobs lets an external data preparation service register
+// prepared pieces with a job as it produces them. Each job has a deal
+// policy, and deals are scheduled for the pieces as they arrive so that data
+// can be onboarded continuously.
+package prepjobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobClosed = errors.New("job is closed")
+
+var (
+	jobsPrefix      = datastore.NewKey("/jobs")
+	piecesPrefix    = datastore.NewKey("/pieces")
+	approvalsPrefix = datastore.NewKey("/approvals")
+)
+
+// Policy determines how deals are made for the pieces in a job
+type Policy struct {
+	// The storage providers to make deals with
+	Providers []address.Address
+	// The number of providers to make a deal with for each piece
+	Replicas int
+	// The duration of each deal in epochs
+	Duration abi.ChainEpoch
+	// The number of epochs after the deal is proposed that the deal starts
+	StartEpochOffset abi.ChainEpoch
+	Verified         bool
+	// The storage price in attoFIL per epoch per GiB
+	StoragePrice abi.TokenAmount
+	// Storage prices that override StoragePrice for individual providers,
+	// keyed by provider address (eg when a provider raises its ask)
+	ProviderPrices map[string]abi.TokenAmount `json:",omitempty"`
+	// Deals are not proposed before this time (the start of the transfer
+	// window). If zero, deals are proposed as soon as pieces are added.
+	ProposeAfter time.Time
+	// Ladder staggers the end epochs of the job's deals. If nil, all deals
+	// have the same duration.
+	Ladder *Ladder `json:",omitempty"`
+	// Regions constrains the regions of the providers that store each
+	// piece's replicas. If nil, providers are chosen regardless of region.
+	Regions *RegionConstraint `json:",omitempty"`
+	// The region of each provider, keyed by provider address. Regions are
+	// recorded when the job is created, so that a job's deals are checked
+	// against the regions that were used to choose its providers.
+	ProviderRegions map[string]string `json:",omitempty"`
+	// OffPeak marks the job as not urgent: deals are only proposed to each
+	// provider when the transfer is expected to finish inside one of the
+	// off-peak windows that the provider announces (if it announces any), so
+	// that the data is transferred when the provider prefers, at the
+	// provider's off-peak discount
+	OffPeak bool `json:",omitempty"`
+	// After this time deals for an off-peak job are proposed regardless of
+	// the providers' windows. If zero, deals are always held for the
+	// providers' windows.
+	OffPeakDeadline time.Time `json:",omitempty"`
+}
+
+// Ladder staggers the end epochs of a job's deals across rungs, so that the
+// whole dataset doesn't expire at once and renewing it can be spread over
+// time. Pieces are assigned to rungs in turn as they are added to the job,
+// and the deals for pieces on each rung last Spacing epochs longer than
+// those on the rung before.
+type Ladder struct {
+	Rungs   int
+	Spacing abi.ChainEpoch
+}
+
+func (p *Policy) Validate() error {
+	if len(p.Providers) == 0 {
+		return fmt.Errorf("policy must have at least one provider")
+	}
+	if p.Replicas < 1 {
+		return fmt.Errorf("policy replicas must be at least 1")
+	}
+	if p.Replicas > len(p.Providers) {
+		return fmt.Errorf("policy replicas (%d) must not be more than the number of providers (%d)", p.Replicas, len(p.Providers))
+	}
+	if p.Duration <= 0 {
+		return fmt.Errorf("policy duration must be greater than zero")
+	}
+	if p.StartEpochOffset < 0 {
+		return fmt.Errorf("policy start epoch offset must not be negative")
+	}
+	if !p.OffPeakDeadline.IsZero() && !p.OffPeak {
+		return fmt.Errorf("policy off-peak deadline is only allowed for off-peak jobs")
+	}
+	if l := p.Ladder; l != nil {
+		if l.Rungs < 1 {
+			return fmt.Errorf("policy ladder must have at least 1 rung")
+		}
+		if l.Spacing <= 0 {
+			return fmt.Errorf("policy ladder spacing must be greater than zero")
+		}
+		if d := p.DurationFor(l.Rungs - 1); d > market.DealMaxDuration {
+			return fmt.Errorf("the duration of the last ladder rung (%d) is more than the maximum deal duration (%d)", d, market.DealMaxDuration)
+		}
+	}
+	return p.validateRegions()
+}
+
+// DurationFor returns the duration of deals for pieces on the ladder rung
+func (p *Policy) DurationFor(rung int) abi.ChainEpoch {
+	if p.Ladder == nil {
+		return p.Duration
+	}
+	return p.Duration + abi.ChainEpoch(rung%p.Ladder.Rungs)*p.Ladder.Spacing
+}
+
+// PriceFor returns the storage price for deals with the provider
+func (p *Policy) PriceFor(provider address.Address) abi.TokenAmount {
+	if price, ok := p.ProviderPrices[provider.String()]; ok {
+		return price
+	}
+	return p.StoragePrice
+}
+
+type Job struct {
+	ID        uuid.UUID
+	Name      string
+	CreatedAt time.Time
+	Policy    Policy
+	// The name of the API token that created the job, or empty if the job
+	// was created with the admin token. Deals for the job are charged to
+	// the token's quota.
+	Owner string `json:",omitempty"`
+	// A closed job does not accept new pieces
+	Closed bool
+}
+
+// Piece is a prepared piece that has been registered with a job
+type Piece struct {
+	PieceCid   cid.Cid
+	PieceSize  abi.PaddedPieceSize
+	PayloadCid cid.Cid
+	CarSize    uint64
+	// The location from which providers can download the CAR file
+	URL     string
+	Headers map[string]string
+	AddedAt time.Time
+	// The ladder rung the piece is on, if the job's policy has a ladder
+	Rung  int `json:",omitempty"`
+	Deals []Deal
+}
+
+// Accepted returns the number of deals for the piece that were accepted
+func (p *Piece) Accepted() int {
+	var count int
+	for _, d := range p.Deals {
+		if d.Accepted {
+			count++
+		}
+	}
+	return count
+}
+
+// Deal is an attempt to make a deal with a provider for a piece
+type Deal struct {
+	Provider address.Address
+	DealUUID uuid.UUID
+	Accepted bool
+	// The reason the deal was rejected by the provider
+	Message string
+	// The error if the proposal could not be sent to the provider
+	Error string
+	// OutcomeUnknown is set if the proposal may have reached the provider
+	// but no response was received (eg the response timed out). The
+	// provider is asked whether it has the deal before the piece is proposed
+	// to it again.
+	OutcomeUnknown bool `json:",omitempty"`
+	// The api token quota charged for a deal that is accepted or whose
+	// outcome is unknown. It is refunded if the provider doesn't have the
+	// deal, or if the deal is failed over to another provider.
+	Charge     *apiquota.Charge `json:",omitempty"`
+	ProposedAt time.Time
+	// The job's storage price for the provider when the deal was proposed
+	// (before any off-peak discount). If the provider rejected the deal and
+	// the price has since been raised, the piece is proposed again.
+	Price abi.TokenAmount
+	// The epoch at which the deal ends
+	EndEpoch abi.ChainEpoch `json:",omitempty"`
+}
+
+// Store persists jobs and their pieces. The records that are read are
+// cached in memory.
+type Store struct {
+	jobs      *recordCache
+	pieces    *recordCache
+	approvals *recordCache
+
+	lk sync.Mutex
+}
+
+// StoreOption configures the store
+type StoreOption func(*storeOptions)
+
+type storeOptions struct {
+	cacheRecords int
+}
+
+// CacheRecords sets the maximum number of records of each kind (jobs,
+// pieces and approvals) that are cached in memory. Zero disables caching.
+func CacheRecords(max int) StoreOption {
+	return func(o *storeOptions) {
+		o.cacheRecords = max
+	}
+}
+
+func NewStore(ds datastore.Batching, opts ...StoreOption) *Store {
+	o := storeOptions{cacheRecords: DefaultCacheRecords}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Store{
+		jobs:      newRecordCache(namespace.Wrap(ds, jobsPrefix), o.cacheRecords),
+		pieces:    newRecordCache(namespace.Wrap(ds, piecesPrefix), o.cacheRecords),
+		approvals: newRecordCache(namespace.Wrap(ds, approvalsPrefix), o.cacheRecords),
+	}
+}
+
+// CacheStats returns the cache hits and misses for each kind of record
+func (s *Store) CacheStats() map[string]CacheStats {
+	return map[string]CacheStats{
+		"jobs":      s.jobs.cacheStats(),
+		"pieces":    s.pieces.cacheStats(),
+		"approvals": s.approvals.cacheStats(),
+	}
+}
+
+func (s *Store) CreateJob(ctx context.Context, name string, policy Policy) (*Job, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        uuid.New(),
+		Name:      name,
+		CreatedAt: time.Now(),
+		Policy:    policy,
+	}
+	// The job is owned by the API token that authenticated the request that
+	// created it, if any
+	job.Owner, _ = apiquota.TokenFromContext(ctx)
+	if err := s.putJSON(ctx, s.jobs, jobKey(job.ID), job); err != nil {
+		return nil, fmt.Errorf("saving job %s: %w", job.ID, err)
+	}
+	return job, nil
+}
+
+func (s *Store) Job(ctx context.Context, id uuid.UUID) (*Job, error) {
+	var job Job
+	if err := s.getJSON(ctx, s.jobs, jobKey(id), &job); err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, fmt.Errorf("job %s: %w", id, ErrJobNotFound)
+		}
+		return nil, fmt.Errorf("getting job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// Jobs lists all jobs, oldest first
+func (s *Store) Jobs(ctx context.Context) ([]Job, error) {
+	recs, err := s.jobs.list(ctx, datastore.NewKey("/"))
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+
+	jobs := make([]Job, 0, len(recs))
+	for _, r := range recs {
+		var job Job
+		if err := json.Unmarshal(r.value, &job); err != nil {
+			return nil, fmt.Errorf("unmarshalling job %s: %w", r.key, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+func (s *Store) CloseJob(ctx context.Context, id uuid.UUID) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	job, err := s.Job(ctx, id)
+	if err != nil {
+		return err
+	}
+	job.Closed = true
+	if err := s.putJSON(ctx, s.jobs, jobKey(id), job); err != nil {
+		return fmt.Errorf("saving job %s: %w", id, err)
+	}
+	return nil
+}
+
+// AdjustPrice raises the storage price for deals with the provider, in jobs
+// that still have deals to make, to the provider's new ask price (or
+// verified ask price for verified deals). The price is not raised above
+// maxPrice, unless maxPrice is nil. It returns the IDs of the jobs that were
+// adjusted.
+func (s *Store) AdjustPrice(ctx context.Context, provider address.Address, price, verifiedPrice, maxPrice abi.TokenAmount) ([]uuid.UUID, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	jobs, err := s.Jobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var adjusted []uuid.UUID
+	for _, job := range jobs {
+		if !hasProvider(job.Policy, provider) {
+			continue
+		}
+
+		newPrice := price
+		if job.Policy.Verified {
+			newPrice = verifiedPrice
+		}
+		if maxPrice.Int != nil && newPrice.GreaterThan(maxPrice) {
+			newPrice = maxPrice
+		}
+		if !job.Policy.PriceFor(provider).LessThan(newPrice) {
+			continue
+		}
+
+		pending, err := s.hasPendingPieces(ctx, &job)
+		if err != nil {
+			return nil, err
+		}
+		if !pending {
+			continue
+		}
+
+		if job.Policy.ProviderPrices == nil {
+			job.Policy.ProviderPrices = make(map[string]abi.TokenAmount)
+		}
+		job.Policy.ProviderPrices[provider.String()] = newPrice
+		if err := s.putJSON(ctx, s.jobs, jobKey(job.ID), &job); err != nil {
+			return nil, fmt.Errorf("saving job %s: %w", job.ID, err)
+		}
+		adjusted = append(adjusted, job.ID)
+	}
+	return adjusted, nil
+}
+
+// PendingProviders returns the providers of the jobs that may still have
+// deals to make
+func (s *Store) PendingProviders(ctx context.Context) ([]address.Address, error) {
+	jobs, err := s.Jobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[address.Address]struct{})
+	var providers []address.Address
+	for _, job := range jobs {
+		pending, err := s.hasPendingPieces(ctx, &job)
+		if err != nil {
+			return nil, err
+		}
+		if !pending {
+			continue
+		}
+		for _, p := range job.Policy.Providers {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				providers = append(providers, p)
+			}
+		}
+	}
+	return providers, nil
+}
+
+// hasPendingPieces returns true if the job may still have deals to make: it
+// is open, or it has pieces that need more replicas
+func (s *Store) hasPendingPieces(ctx context.Context, job *Job) (bool, error) {
+	if !job.Closed {
+		return true, nil
+	}
+	pieces, err := s.Pieces(ctx, job.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, piece := range pieces {
+		if piece.Accepted() < job.Policy.Replicas {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func hasProvider(policy Policy, provider address.Address) bool {
+	for _, p := range policy.Providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPiece registers a prepared piece with the job. Adding a piece that has
+// already been added is a no-op.
+func (s *Store) AddPiece(ctx context.Context, jobID uuid.UUID, piece Piece) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	job, err := s.Job(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Closed {
+		return fmt.Errorf("adding piece to job %s: %w", jobID, ErrJobClosed)
+	}
+
+	key := pieceKey(jobID, piece.PieceCid)
+	has, err := s.pieces.has(ctx, key)
+	if err != nil {
+		return fmt.Errorf("checking for piece %s: %w", piece.PieceCid, err)
+	}
+	if has {
+		return nil
+	}
+
+	piece.AddedAt = time.Now()
+	piece.Deals = nil
+	if job.Policy.Ladder != nil {
+		count, err := s.countPieces(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		piece.Rung = count % job.Policy.Ladder.Rungs
+	}
+	if err := s.putJSON(ctx, s.pieces, key, &piece); err != nil {
+		return fmt.Errorf("saving piece %s: %w", piece.PieceCid, err)
+	}
+	return nil
+}
+
+func (s *Store) countPieces(ctx context.Context, jobID uuid.UUID) (int, error) {
+	recs, err := s.pieces.list(ctx, jobKey(jobID))
+	if err != nil {
+		return 0, fmt.Errorf("listing pieces: %w", err)
+	}
+	return len(recs), nil
+}
+
+// Pieces lists the pieces in the job, in the order in which they were added
+func (s *Store) Pieces(ctx context.Context, jobID uuid.UUID) ([]Piece, error) {
+	recs, err := s.pieces.list(ctx, jobKey(jobID))
+	if err != nil {
+		return nil, fmt.Errorf("listing pieces: %w", err)
+	}
+
+	pieces := make([]Piece, 0, len(recs))
+	for _, r := range recs {
+		var piece Piece
+		if err := json.Unmarshal(r.value, &piece); err != nil {
+			return nil, fmt.Errorf("unmarshalling piece %s: %w", r.key, err)
+		}
+		pieces = append(pieces, piece)
+	}
+
+	sort.SliceStable(pieces, func(i, j int) bool {
+		return pieces[i].AddedAt.Before(pieces[j].AddedAt)
+	})
+	return pieces, nil
+}
+
+// AddDeal records a deal attempt for a piece
+func (s *Store) AddDeal(ctx context.Context, jobID uuid.UUID, pieceCid cid.Cid, deal Deal) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	key := pieceKey(jobID, pieceCid)
+	var piece Piece
+	if err := s.getJSON(ctx, s.pieces, key, &piece); err != nil {
+		return fmt.Errorf("getting piece %s: %w", pieceCid, err)
+	}
+	piece.Deals = append(piece.Deals, deal)
+	if err := s.putJSON(ctx, s.pieces, key, &piece); err != nil {
+		return fmt.Errorf("saving piece %s: %w", pieceCid, err)
+	}
+	return nil
+}
+
+// ResolveDeal records the outcome of a deal whose proposal outcome was
+// unknown, once the provider has been asked whether it has the deal
+func (s *Store) ResolveDeal(ctx context.Context, jobID uuid.UUID, pieceCid cid.Cid, dealUUID uuid.UUID, accepted bool, message string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	key := pieceKey(jobID, pieceCid)
+	var piece Piece
+	if err := s.getJSON(ctx, s.pieces, key, &piece); err != nil {
+		return fmt.Errorf("getting piece %s: %w", pieceCid, err)
+	}
+	for i, d := range piece.Deals {
+		if d.DealUUID != dealUUID || !d.OutcomeUnknown {
+			continue
+		}
+		piece.Deals[i].OutcomeUnknown = false
+		if accepted {
+			piece.Deals[i].Accepted = true
+			piece.Deals[i].Error = ""
+			piece.Deals[i].Message = message
+		} else {
+			piece.Deals[i].Charge = nil
+		}
+		if err := s.putJSON(ctx, s.pieces, key, &piece); err != nil {
+			return fmt.Errorf("saving piece %s: %w", pieceCid, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("piece %s has no deal %s whose outcome is unknown", pieceCid, dealUUID)
+}
+
+// FailedDeal is an accepted deal that was marked as failed
+type FailedDeal struct {
+	JobID    uuid.UUID
+	PieceCid cid.Cid
+	// The deal as it was before it failed
+	Deal Deal
+}
+
+// FailDeal marks an accepted deal as failed (eg because the provider did not
+// start the transfer in time), so that the piece's replica is made with
+// another provider. It returns nil if no accepted deal has the uuid.
+func (s *Store) FailDeal(ctx context.Context, dealUUID uuid.UUID, reason string) (*FailedDeal, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	recs, err := s.pieces.list(ctx, datastore.NewKey("/"))
+	if err != nil {
+		return nil, fmt.Errorf("listing pieces: %w", err)
+	}
+
+	for _, r := range recs {
+		var piece Piece
+		if err := json.Unmarshal(r.value, &piece); err != nil {
+			return nil, fmt.Errorf("unmarshalling piece %s: %w", r.key, err)
+		}
+		for i, d := range piece.Deals {
+			if d.DealUUID != dealUUID || !d.Accepted {
+				continue
+			}
+			jobID, err := uuid.Parse(r.key.Parent().Name())
+			if err != nil {
+				return nil, fmt.Errorf("parsing job id of piece %s: %w", r.key, err)
+			}
+			piece.Deals[i].Accepted = false
+			piece.Deals[i].Error = reason
+			piece.Deals[i].Charge = nil
+			if err := s.putJSON(ctx, s.pieces, r.key, &piece); err != nil {
+				return nil, fmt.Errorf("saving piece %s: %w", piece.PieceCid, err)
+			}
+			return &FailedDeal{JobID: jobID, PieceCid: piece.PieceCid, Deal: d}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Store) putJSON(ctx context.Context, c *recordCache, key datastore.Key, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.put(ctx, key, data)
+}
+
+func (s *Store) getJSON(ctx context.Context, c *recordCache, key datastore.Key, v interface{}) error {
+	data, err := c.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func jobKey(id uuid.UUID) datastore.Key {
+	return datastore.NewKey(id.String())
+}
+
+func pieceKey(jobID uuid.UUID, pieceCid cid.Cid) datastore.Key {
+	return jobKey(jobID).ChildString(pieceCid.String())
+}
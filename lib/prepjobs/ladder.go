@@ -0,0 +1,85 @@
+package prepjobs
+
+import (
+	"context"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+// LadderRung is the pieces on one rung of a job's ladder, and the end epochs
+// of their accepted deals
+type LadderRung struct {
+	Rung   int       `json:"rung"`
+	Pieces []cid.Cid `json:"pieces"`
+	// The number of accepted deals for the rung's pieces
+	Deals int `json:"deals"`
+	// The earliest and latest end epochs of the accepted deals (zero if
+	// there are none)
+	FirstEndEpoch abi.ChainEpoch `json:"firstEndEpoch"`
+	LastEndEpoch  abi.ChainEpoch `json:"lastEndEpoch"`
+}
+
+// Ladder returns the job's pieces grouped by ladder rung, with the rungs
+// whose deals end soonest first. A renewal controller renews the dataset a
+// rung at a time as each rung comes due, so that repair work is spread out
+// over time. A job without a ladder has a single rung.
+func (s *Store) Ladder(ctx context.Context, jobID uuid.UUID) ([]LadderRung, error) {
+	job, err := s.Job(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	pieces, err := s.Pieces(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 1
+	if job.Policy.Ladder != nil {
+		count = job.Policy.Ladder.Rungs
+	}
+	rungs := make([]LadderRung, count)
+	for i := range rungs {
+		rungs[i].Rung = i
+	}
+	for _, piece := range pieces {
+		r := &rungs[piece.Rung%count]
+		r.Pieces = append(r.Pieces, piece.PieceCid)
+		for _, d := range piece.Deals {
+			if !d.Accepted || d.EndEpoch == 0 {
+				continue
+			}
+			r.Deals++
+			if r.FirstEndEpoch == 0 || d.EndEpoch < r.FirstEndEpoch {
+				r.FirstEndEpoch = d.EndEpoch
+			}
+			if d.EndEpoch > r.LastEndEpoch {
+				r.LastEndEpoch = d.EndEpoch
+			}
+		}
+	}
+
+	// Rungs without deals yet go last
+	sort.SliceStable(rungs, func(i, j int) bool {
+		ei, ej := rungs[i].FirstEndEpoch, rungs[j].FirstEndEpoch
+		if ei == 0 || ej == 0 {
+			return ej == 0 && ei != 0
+		}
+		return ei < ej
+	})
+	return rungs, nil
+}
+
+// DueForRenewal returns the rungs with deals that end before the epoch, in
+// the order in which they are due
+func DueForRenewal(rungs []LadderRung, before abi.ChainEpoch) []LadderRung {
+	var due []LadderRung
+	for _, r := range rungs {
+		if r.Deals > 0 && r.FirstEndEpoch < before {
+			due = append(due, r)
+		}
+	}
+	return due
+}
@@ -0,0 +1,143 @@
+package prepjobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"go.uber.org/zap"
+)
+
+// OffPeakSource looks up the off-peak windows in which a provider prefers to
+// receive deal data
+type OffPeakSource interface {
+	OffPeakWindows(ctx context.Context, provider address.Address) ([]types.OffPeakWindow, error)
+}
+
+// OffPeakSourceFunc is a function that implements OffPeakSource
+type OffPeakSourceFunc func(ctx context.Context, provider address.Address) ([]types.OffPeakWindow, error)
+
+func (f OffPeakSourceFunc) OffPeakWindows(ctx context.Context, provider address.Address) ([]types.OffPeakWindow, error) {
+	return f(ctx, provider)
+}
+
+// CacheOffPeakWindows caches the windows of each provider from the source
+// for the TTL, so that providers aren't asked for their windows every time
+// the scheduler runs
+func CacheOffPeakWindows(src OffPeakSource, ttl time.Duration) OffPeakSource {
+	type entry struct {
+		windows   []types.OffPeakWindow
+		fetchedAt time.Time
+	}
+	var lk sync.Mutex
+	cache := make(map[address.Address]entry)
+	return OffPeakSourceFunc(func(ctx context.Context, provider address.Address) ([]types.OffPeakWindow, error) {
+		lk.Lock()
+		defer lk.Unlock()
+
+		if e, ok := cache[provider]; ok && time.Since(e.fetchedAt) <= ttl {
+			return e.windows, nil
+		}
+		windows, err := src.OffPeakWindows(ctx, provider)
+		if err != nil {
+			return nil, err
+		}
+		cache[provider] = entry{windows: windows, fetchedAt: time.Now()}
+		return windows, nil
+	})
+}
+
+// offPeakStartSlack is how long after an off-peak window opens that a deal
+// whose transfer is too long to fit in the window may still be proposed
+const offPeakStartSlack = 15 * time.Minute
+
+// canProposeOffPeak returns whether a deal for the piece in a non-urgent job
+// may be proposed to the provider now, and the discount that the provider
+// gives on deals proposed now. The provider starts the transfer as soon as it
+// accepts the deal, so the deal is only proposed if the transfer is expected
+// to finish inside one of the provider's windows (or, if the transfer is too
+// long for the window, just after the window opens). Deals for other jobs may
+// always be proposed.
+func (s *Scheduler) canProposeOffPeak(ctx context.Context, jlog *zap.SugaredLogger, policy *Policy, provider address.Address, piece Piece, now time.Time) (bool, uint64) {
+	if !policy.OffPeak || s.offPeak == nil {
+		return true, 0
+	}
+	if !policy.OffPeakDeadline.IsZero() && !now.Before(policy.OffPeakDeadline) {
+		return true, 0
+	}
+
+	windows, err := s.offPeak.OffPeakWindows(ctx, provider)
+	if err != nil {
+		// Don't hold up the job if the provider's windows can't be found
+		jlog.Infow("could not get provider off-peak windows", "provider", provider, "err", err)
+		return true, 0
+	}
+	valid := make([]types.OffPeakWindow, 0, len(windows))
+	for _, w := range windows {
+		if err := w.Validate(); err != nil {
+			jlog.Warnw("ignoring invalid provider off-peak window", "provider", provider, "window", w, "err", err)
+			continue
+		}
+		valid = append(valid, w)
+	}
+	if len(valid) == 0 {
+		return true, 0
+	}
+
+	transfer := s.transferDuration(piece)
+	var best types.OffPeakWindow
+	found := false
+	for _, w := range valid {
+		remaining := w.Remaining(now)
+		if remaining == 0 {
+			continue
+		}
+		fits := remaining >= transfer
+		if transfer > w.Length() {
+			fits = w.Length()-remaining < offPeakStartSlack
+		}
+		if fits && (!found || w.DiscountPercent > best.DiscountPercent) {
+			best = w
+			found = true
+		}
+	}
+	if !found {
+		jlog.Debugw("deferring deal proposal to provider's off-peak window", "provider", provider,
+			"transfer", transfer, "next", types.NextOffPeak(valid, now))
+		return false, 0
+	}
+	return true, best.DiscountPercent
+}
+
+// transferDuration estimates how long the transfer of the piece's data will
+// take at the expected transfer rate
+func (s *Scheduler) transferDuration(piece Piece) time.Duration {
+	if s.offPeakRate == 0 {
+		return 0
+	}
+	size := piece.CarSize
+	if size == 0 {
+		size = uint64(piece.PieceSize)
+	}
+	return time.Duration(float64(size) / float64(s.offPeakRate) * float64(time.Second))
+}
+
+// withDiscount returns a copy of the policy with the storage price for the
+// provider reduced by the discount percentage
+func (p Policy) withDiscount(provider address.Address, discountPercent uint64) Policy {
+	if discountPercent == 0 || discountPercent > 100 {
+		return p
+	}
+	price := p.PriceFor(provider)
+	prices := make(map[string]abi.TokenAmount, len(p.ProviderPrices)+1)
+	for k, v := range p.ProviderPrices {
+		prices[k] = v
+	}
+	prices[provider.String()] = big.Div(big.Mul(price, big.NewIntUnsigned(100-discountPercent)), big.NewInt(100))
+	p.ProviderPrices = prices
+	return p
+}
@@ -0,0 +1,130 @@
+package prepjobs
+
+import (
+	"net/http"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/filecoin-project/boost/build"
+	"github.com/filecoin-project/boost/lib/openapi"
+	"github.com/filecoin-project/boost/lib/pieceplan"
+)
+
+// OpenAPI returns the OpenAPI document that describes the job API and the
+// approval API. It is served at /openapi.json.
+func OpenAPI() *openapi.Document {
+	d := openapi.New("Boost job API",
+		"Register prepared pieces with jobs, which make deals for them according to the job's policy",
+		build.BuildVersion)
+	d.BearerAuth()
+
+	id := openapi.PathParam("id", "the job id", &jsonschema.Type{Type: "string", Format: "uuid"})
+	notFound := d.Error("the job was not found")
+	d.Add(http.MethodPost, "/jobs", openapi.Operation{
+		OperationID: "createJob",
+		Summary:     "Create a job",
+		RequestBody: d.JSONBody(CreateJobRequest{}),
+		Responses: map[string]openapi.Response{
+			"201": d.JSON("the job that was created", Job{}),
+			"400": d.Error("the policy is not valid"),
+		},
+	})
+	d.Add(http.MethodGet, "/jobs", openapi.Operation{
+		OperationID: "listJobs",
+		Summary:     "List jobs, without their pieces",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the status of each job", []JobStatus{}),
+		},
+	})
+	d.Add(http.MethodGet, "/jobs/{id}", openapi.Operation{
+		OperationID: "getJob",
+		Summary:     "Get the status of a job and its pieces",
+		Parameters:  []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the job's status", JobStatus{}),
+			"404": notFound,
+		},
+	})
+	d.Add(http.MethodPost, "/jobs/{id}/pieces", openapi.Operation{
+		OperationID: "addPieces",
+		Summary:     "Register prepared pieces with a job",
+		Parameters:  []openapi.Parameter{id},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]openapi.MediaType{"application/json": {Schema: &jsonschema.Type{
+				// Either a single piece or an array of pieces
+				OneOf: []*jsonschema.Type{d.Schema(AddPieceRequest{}), d.Schema([]AddPieceRequest{})},
+			}}},
+		},
+		Responses: map[string]openapi.Response{
+			"202": d.JSON("the number of pieces added", map[string]int{}),
+			"400": d.Error("a piece is not valid"),
+			"404": notFound,
+			"409": d.Error("the job is closed"),
+		},
+	})
+	d.Add(http.MethodPost, "/jobs/{id}/close", openapi.Operation{
+		OperationID: "closeJob",
+		Summary:     "Stop accepting pieces for a job",
+		Parameters:  []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"204": {Description: "the job was closed"},
+			"404": notFound,
+		},
+	})
+	d.Add(http.MethodGet, "/jobs/{id}/ladder", openapi.Operation{
+		OperationID: "getLadder",
+		Summary:     "Get a job's pieces by ladder rung, soonest to expire first",
+		Parameters:  []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the rungs of the job's ladder", []LadderRung{}),
+			"404": notFound,
+		},
+	})
+	d.Add(http.MethodPost, "/plan", openapi.Operation{
+		OperationID: "plan",
+		Summary:     "Suggest how to split and merge data into pieces that fit the providers' piece sizes with the least padding",
+		RequestBody: d.JSONBody(PlanRequest{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the suggested pieces", pieceplan.Plan{}),
+			"400": d.Error("the piece size limits are not valid, or an input doesn't fit in a piece"),
+		},
+	})
+
+	approvalID := openapi.PathParam("id", "the approval id", &jsonschema.Type{Type: "string", Format: "uuid"})
+	approvalNotFound := d.Error("the approval was not found")
+	state := d.Enum("ApprovalState", "The state of a deal proposal held for approval",
+		ApprovalPending, ApprovalApproved, ApprovalRejected)
+	d.Add(http.MethodGet, "/approvals", openapi.Operation{
+		OperationID: "listApprovals",
+		Summary:     "List deal proposals held for approval",
+		Parameters:  []openapi.Parameter{openapi.QueryParam("state", "only list approvals in this state", state)},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the approvals", []Approval{}),
+		},
+	})
+	d.Add(http.MethodGet, "/approvals/{id}", openapi.Operation{
+		OperationID: "getApproval",
+		Summary:     "Get a deal proposal held for approval",
+		Parameters:  []openapi.Parameter{approvalID},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the approval", Approval{}),
+			"404": approvalNotFound,
+		},
+	})
+	for _, decision := range []string{"approve", "reject"} {
+		d.Add(http.MethodPost, "/approvals/{id}/"+decision, openapi.Operation{
+			OperationID: decision + "Approval",
+			Summary:     "Decide to " + decision + " a deal proposal held for approval",
+			Parameters:  []openapi.Parameter{approvalID},
+			RequestBody: d.JSONBody(DecideApprovalRequest{}),
+			Responses: map[string]openapi.Response{
+				"200": d.JSON("the decided approval", Approval{}),
+				"400": d.Error("the request body is invalid"),
+				"404": approvalNotFound,
+				"409": d.Error("the approval has already been decided"),
+			},
+		})
+	}
+	d.SetProperty("Approval", "State", state)
+	return d
+}
@@ -0,0 +1,169 @@
+package prepjobs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/boost/lib/regions"
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+)
+
+// RegionConstraint restricts the regions of the providers that store the
+// replicas of each piece. When a policy has a region constraint, deals are
+// only proposed to providers whose region is known.
+type RegionConstraint struct {
+	// The minimum number of distinct regions that the accepted deals for
+	// each piece must span
+	MinRegions int `json:",omitempty"`
+	// Deals are never proposed to providers in these regions
+	Exclude []string `json:",omitempty"`
+}
+
+func (c *RegionConstraint) excludes(region string) bool {
+	for _, r := range c.Exclude {
+		if regions.Normalize(r) == region {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionOf returns the region of the provider, or an empty string if it is
+// not known
+func (p *Policy) RegionOf(provider address.Address) string {
+	return p.ProviderRegions[provider.String()]
+}
+
+// eligible returns true if deals for the job may be proposed to the provider
+func (p *Policy) eligible(provider address.Address) bool {
+	if p.Regions == nil {
+		return true
+	}
+	region := p.RegionOf(provider)
+	return region != "" && !p.Regions.excludes(region)
+}
+
+func (p *Policy) validateRegions() error {
+	c := p.Regions
+	if c == nil {
+		return nil
+	}
+	if c.MinRegions < 0 {
+		return fmt.Errorf("policy min regions must not be negative")
+	}
+	if c.MinRegions > p.Replicas {
+		return fmt.Errorf("policy min regions (%d) must not be more than the number of replicas (%d)", c.MinRegions, p.Replicas)
+	}
+
+	eligible := 0
+	available := make(map[string]struct{})
+	for _, provider := range p.Providers {
+		if p.eligible(provider) {
+			eligible++
+			available[p.RegionOf(provider)] = struct{}{}
+		}
+	}
+	if eligible < p.Replicas {
+		return fmt.Errorf("policy replicas (%d) must not be more than the number of providers with a known region "+
+			"that is not excluded (%d)", p.Replicas, eligible)
+	}
+	if len(available) < c.MinRegions {
+		return fmt.Errorf("policy min regions is %d but the providers are only in %d regions that are not excluded",
+			c.MinRegions, len(available))
+	}
+	return nil
+}
+
+// CheckRegions verifies that the piece's accepted deals satisfy the policy's
+// region constraint. It returns the reason the constraint is not satisfied,
+// or an empty string if it is (or the policy doesn't have one).
+func (p *Policy) CheckRegions(piece Piece) string {
+	c := p.Regions
+	if c == nil {
+		return ""
+	}
+
+	var reasons []string
+	covered := make(map[string]struct{})
+	for _, d := range piece.Deals {
+		if !d.Accepted {
+			continue
+		}
+		region := p.RegionOf(d.Provider)
+		switch {
+		case region == "":
+			reasons = append(reasons, fmt.Sprintf("provider %s has no known region", d.Provider))
+		case c.excludes(region):
+			reasons = append(reasons, fmt.Sprintf("provider %s is in excluded region %s", d.Provider, region))
+		default:
+			covered[region] = struct{}{}
+		}
+	}
+	if len(covered) < c.MinRegions {
+		reasons = append(reasons, fmt.Sprintf("deals span %d regions, policy requires at least %d", len(covered), c.MinRegions))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// coveredRegions returns the regions of the providers that have accepted
+// deals for the piece
+func (p *Policy) coveredRegions(piece Piece) map[string]struct{} {
+	covered := make(map[string]struct{})
+	for _, d := range piece.Deals {
+		if d.Accepted {
+			if region := p.RegionOf(d.Provider); region != "" {
+				covered[region] = struct{}{}
+			}
+		}
+	}
+	return covered
+}
+
+// orderByRegion moves the providers in regions that don't yet have a replica
+// of the piece ahead of the others, keeping the order otherwise
+func (p *Policy) orderByRegion(providers []address.Address, covered map[string]struct{}) []address.Address {
+	ordered := make([]address.Address, len(providers))
+	copy(ordered, providers)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		_, ci := covered[p.RegionOf(ordered[i])]
+		_, cj := covered[p.RegionOf(ordered[j])]
+		return !ci && cj
+	})
+	return ordered
+}
+
+// resolveRegions fills in the regions of the policy's providers that were
+// not given when the job was created
+func resolveRegions(ctx context.Context, r regions.Resolver, policy *Policy) error {
+	var unknown []address.Address
+	for _, provider := range policy.Providers {
+		if policy.RegionOf(provider) == "" {
+			unknown = append(unknown, provider)
+		}
+	}
+	if r == nil || len(unknown) == 0 {
+		return nil
+	}
+
+	found, err := r.Regions(ctx, unknown)
+	if err != nil {
+		return fmt.Errorf("looking up provider regions: %w", err)
+	}
+	for provider, region := range found {
+		if policy.ProviderRegions == nil {
+			policy.ProviderRegions = make(map[string]string)
+		}
+		policy.ProviderRegions[provider.String()] = regions.Normalize(region)
+	}
+	return nil
+}
+
+// RegionViolation is a piece whose accepted deals don't satisfy the region
+// constraint of the job's policy
+type RegionViolation struct {
+	PieceCid cid.Cid `json:"pieceCid"`
+	Reason   string  `json:"reason"`
+}
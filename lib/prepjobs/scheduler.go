@@ -0,0 +1,608 @@
+package prepjobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/boost/lib/logctx"
+	"github.com/filecoin-project/boost/lib/prewarm"
+	"github.com/filecoin-project/boost/lib/regions"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+)
+
+var log = logging.Logger("prepjobs")
+
+// DealMaker proposes a deal for the piece to the provider. It returns an
+// error if the proposal could not be sent (eg the provider could not be
+// reached), in which case the deal will be retried later. If the proposal may
+// have reached the provider but no response was received, the error is a
+// types.ProposalOutcomeUnknownError, and the provider is asked for the status
+// of the deal with CheckDeal before the piece is proposed to it again.
+type DealMaker interface {
+	MakeDeal(ctx context.Context, policy Policy, provider address.Address, piece Piece) (*Deal, error)
+	// CheckDeal returns the deal if the provider has it, or nil if it
+	// doesn't
+	CheckDeal(ctx context.Context, provider address.Address, dealUUID uuid.UUID) (*Deal, error)
+	// CancelDeal asks the provider to cancel a deal whose data has not been
+	// transferred. It returns false if the provider did not cancel the deal.
+	CancelDeal(ctx context.Context, provider address.Address, dealUUID uuid.UUID) (bool, error)
+}
+
+// Prewarmer connects to providers ahead of time so that proposals and
+// transfers can start without waiting for dialing and negotiation
+type Prewarmer interface {
+	Warm(ctx context.Context, providers []address.Address) []prewarm.Result
+	Release(providers []address.Address)
+}
+
+type SchedulerOption func(*Scheduler)
+
+// RetryParams changes the interval at which failed deal proposals are
+// retried, and the maximum number of attempts per provider for a piece
+func RetryParams(interval time.Duration, maxAttempts int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.retryInterval = interval
+		s.maxAttempts = maxAttempts
+	}
+}
+
+// RetryRejected changes how long to wait before proposing a piece again to a
+// provider that rejected it, in case the reason for the rejection has cleared.
+// The wait doubles with each rejection, up to maxBackoff. If the job's price
+// for the provider has been raised since the last rejection, the piece is
+// proposed again right away.
+func RetryRejected(backoff, maxBackoff time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.rejectBackoff = backoff
+		s.rejectMaxBackoff = maxBackoff
+	}
+}
+
+// PrewarmConnections pre-warms connections to the providers of each job
+// that has pieces still to be scheduled, starting lead before the job's
+// ProposeAfter time. Connections are released once they are no longer needed.
+func PrewarmConnections(p Prewarmer, lead time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.prewarmer = p
+		s.prewarmLead = lead
+	}
+}
+
+// QuotaCharger charges the deals for a job to the daily quota of the API
+// token that owns the job
+type QuotaCharger interface {
+	Charge(name string, c apiquota.Charge) error
+	Refund(name string, c apiquota.Charge) error
+}
+
+// ChargeQuotas charges each deal proposed for a job that is owned by an API
+// token to the token's quota. Deals that would exceed the quota are not
+// proposed until the quota resets the next day.
+func ChargeQuotas(q QuotaCharger) SchedulerOption {
+	return func(s *Scheduler) {
+		s.quotas = q
+	}
+}
+
+// SLATracker orders providers so that those that often exceed their
+// response time SLAs are proposed to last, and measures how long providers
+// take to start the transfers for the deals they accept
+type SLATracker interface {
+	Order(providers []address.Address) []address.Address
+	AwaitTransfer(provider address.Address, dealUUID uuid.UUID)
+}
+
+// TrackSLAs proposes deals to deprioritized providers only after the job's
+// other providers, and tracks the transfer start time of accepted deals
+func TrackSLAs(t SLATracker) SchedulerOption {
+	return func(s *Scheduler) {
+		s.slas = t
+	}
+}
+
+// RequireApproval holds deal proposals that are above the thresholds until
+// they are approved. Rejected proposals are never sent.
+func RequireApproval(t ApprovalThresholds) SchedulerOption {
+	return func(s *Scheduler) {
+		s.approvals = &t
+	}
+}
+
+// ResolveRegions looks up the regions of a job's providers when the job is
+// created, for providers whose region is not given in the job's policy
+func ResolveRegions(r regions.Resolver) SchedulerOption {
+	return func(s *Scheduler) {
+		s.regions = r
+	}
+}
+
+// ShiftToOffPeak holds the deals for jobs that are not urgent until the
+// provider's off-peak windows, which are looked up with the source. The
+// transfer rate (in bytes per second) is used to estimate when the transfer
+// of each piece will end, so that the whole transfer falls inside a window.
+// If it is zero, only the start of the transfer is shifted into a window.
+func ShiftToOffPeak(src OffPeakSource, transferRate uint64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.offPeak = src
+		s.offPeakRate = transferRate
+	}
+}
+
+// Scheduler makes deals for the pieces in each job according to the job's
+// policy
+type Scheduler struct {
+	store         *Store
+	maker         DealMaker
+	retryInterval time.Duration
+	maxAttempts   int
+	notify        chan struct{}
+
+	rejectBackoff    time.Duration
+	rejectMaxBackoff time.Duration
+
+	prewarmer   Prewarmer
+	prewarmLead time.Duration
+	warm        map[address.Address]struct{}
+
+	quotas    QuotaCharger
+	slas      SLATracker
+	approvals *ApprovalThresholds
+	regions   regions.Resolver
+	offPeak   OffPeakSource
+	// The expected transfer rate in bytes per second
+	offPeakRate uint64
+}
+
+func NewScheduler(store *Store, maker DealMaker, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		store:         store,
+		maker:         maker,
+		retryInterval: time.Minute,
+		maxAttempts:   3,
+		notify:        make(chan struct{}, 1),
+		warm:          make(map[address.Address]struct{}),
+
+		rejectBackoff:    10 * time.Minute,
+		rejectMaxBackoff: 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Notify tells the scheduler that there are new pieces to schedule
+func (s *Scheduler) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run schedules deals until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Schedule(ctx); err != nil && ctx.Err() == nil {
+			log.Errorw("scheduling deals", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Schedule makes deals for all pieces that don't yet have the number of
+// replicas required by the job's policy
+func (s *Scheduler) Schedule(ctx context.Context) error {
+	jobs, err := s.store.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	jobPieces := make([][]Piece, len(jobs))
+	for i, job := range jobs {
+		pieces, err := s.store.Pieces(ctx, job.ID)
+		if err != nil {
+			return err
+		}
+		jobPieces[i] = pieces
+	}
+
+	now := time.Now()
+	s.prewarm(ctx, now, jobs, jobPieces)
+
+	for i, job := range jobs {
+		if now.Before(job.Policy.ProposeAfter) {
+			continue
+		}
+		for j, piece := range jobPieces[i] {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := s.schedulePiece(ctx, &job, j, piece); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prewarm warms the connections to the providers of jobs that will have
+// deals proposed soon, and releases the connections to providers that are no
+// longer needed
+func (s *Scheduler) prewarm(ctx context.Context, now time.Time, jobs []Job, jobPieces [][]Piece) {
+	if s.prewarmer == nil {
+		return
+	}
+
+	needed := make(map[address.Address]struct{})
+	for i, job := range jobs {
+		if now.Before(job.Policy.ProposeAfter.Add(-s.prewarmLead)) {
+			continue
+		}
+		// Pieces may still be added to an open job, otherwise only warm the
+		// connections if there are pieces that need more replicas
+		pending := !job.Closed
+		for _, piece := range jobPieces[i] {
+			pending = pending || piece.Accepted() < job.Policy.Replicas
+		}
+		if !pending {
+			continue
+		}
+		for _, p := range job.Policy.Providers {
+			needed[p] = struct{}{}
+		}
+	}
+
+	var release []address.Address
+	for p := range s.warm {
+		if _, ok := needed[p]; !ok {
+			release = append(release, p)
+			delete(s.warm, p)
+		}
+	}
+	if len(release) > 0 {
+		s.prewarmer.Release(release)
+	}
+
+	if len(needed) == 0 {
+		return
+	}
+	providers := make([]address.Address, 0, len(needed))
+	for p := range needed {
+		providers = append(providers, p)
+		s.warm[p] = struct{}{}
+	}
+	for _, res := range s.prewarmer.Warm(ctx, providers) {
+		if res.Error != "" {
+			log.Infow("could not pre-warm connection to provider", "provider", res.Provider, "err", res.Error)
+		}
+	}
+}
+
+func (s *Scheduler) schedulePiece(ctx context.Context, job *Job, index int, piece Piece) error {
+	policy := job.Policy
+	accepted := piece.Accepted()
+	if accepted >= policy.Replicas {
+		return nil
+	}
+
+	// Spread pieces across providers by starting with a different provider
+	// for each piece
+	providers := make([]address.Address, 0, len(policy.Providers))
+	for n := range policy.Providers {
+		providers = append(providers, policy.Providers[(index+n)%len(policy.Providers)])
+	}
+	if s.slas != nil {
+		providers = s.slas.Order(providers)
+	}
+	covered := policy.coveredRegions(piece)
+	if policy.Regions != nil {
+		providers = policy.orderByRegion(providers, covered)
+	}
+	jctx := logctx.With(ctx, logctx.JobKey, job.ID.String())
+	jlog := logctx.Logger(jctx, log)
+	for n := 0; n < len(providers) && accepted < policy.Replicas; n++ {
+		provider := providers[n]
+		resolved, err := s.resolveUnknown(ctx, jlog, job, &piece, provider)
+		if err != nil {
+			return err
+		}
+		if resolved {
+			accepted++
+			if region := policy.RegionOf(provider); region != "" {
+				covered[region] = struct{}{}
+			}
+			continue
+		}
+		if !policy.eligible(provider) || !s.canPropose(piece, provider, policy.PriceFor(provider)) {
+			continue
+		}
+		ok, discount := s.canProposeOffPeak(ctx, jlog, &policy, provider, piece, time.Now())
+		if !ok {
+			continue
+		}
+		dealPolicy := policy.withDiscount(provider, discount)
+		if policy.Regions != nil {
+			// Once the remaining replicas are only enough to reach the
+			// minimum number of regions, skip providers in regions that
+			// already have a replica
+			region := policy.RegionOf(provider)
+			_, ok := covered[region]
+			if ok && policy.Replicas-accepted <= policy.Regions.MinRegions-len(covered) {
+				continue
+			}
+		}
+
+		charge := apiquota.Charge{
+			Deals: 1,
+			Bytes: uint64(piece.PieceSize),
+			Spend: apiquota.DealSpend(dealPolicy.PriceFor(provider), piece.PieceSize, policy.DurationFor(piece.Rung)),
+		}
+		approved, err := s.approved(ctx, jlog, job, piece, provider, charge.Spend)
+		if err != nil {
+			return err
+		}
+		if !approved {
+			continue
+		}
+		if !s.charge(jlog, job, charge) {
+			return nil
+		}
+
+		deal, err := s.maker.MakeDeal(jctx, dealPolicy, provider, piece)
+		var uerr *types.ProposalOutcomeUnknownError
+		unknown := err != nil && errors.As(err, &uerr)
+		if (err != nil || !deal.Accepted) && !unknown {
+			s.refund(jlog, job, charge)
+		}
+		if err != nil {
+			if ctx.Err() != nil && !unknown {
+				return ctx.Err()
+			}
+			jlog.Warnw("failed to propose deal", "piece", piece.PieceCid, "provider", provider, "err", err)
+			deal = &Deal{Provider: provider, Error: err.Error()}
+			if unknown {
+				// Keep the deal's uuid and charge until the provider has
+				// been asked whether it has the deal
+				deal.DealUUID = uerr.DealUUID
+				deal.OutcomeUnknown = true
+				deal.Charge = &charge
+			}
+		}
+		if deal.Accepted {
+			// Keep the charge so that it can be refunded if the deal is
+			// failed over to another provider
+			deal.Charge = &charge
+		}
+		deal.Provider = provider
+		deal.ProposedAt = time.Now()
+		deal.Price = policy.PriceFor(provider)
+		if err := s.store.AddDeal(ctx, job.ID, piece.PieceCid, *deal); err != nil {
+			return err
+		}
+		piece.Deals = append(piece.Deals, *deal)
+
+		if deal.Accepted {
+			accepted++
+			if region := policy.RegionOf(provider); region != "" {
+				covered[region] = struct{}{}
+			}
+			if s.slas != nil {
+				s.slas.AwaitTransfer(provider, deal.DealUUID)
+			}
+			jlog.Infow("deal accepted", "piece", piece.PieceCid, "provider", provider, logctx.DealKey, deal.DealUUID)
+		} else if deal.Error == "" {
+			jlog.Infow("deal rejected", "piece", piece.PieceCid, "provider", provider, "reason", deal.Message)
+		}
+	}
+	return nil
+}
+
+// approved returns true if the proposal doesn't need approval, or has been
+// approved. Proposals that need approval are held until they are decided.
+func (s *Scheduler) approved(ctx context.Context, jlog *zap.SugaredLogger, job *Job, piece Piece, provider address.Address, spend abi.TokenAmount) (bool, error) {
+	if s.approvals == nil {
+		return true, nil
+	}
+	reasons := s.approvals.reasons(piece.PieceSize, spend, provider)
+	if len(reasons) == 0 {
+		return true, nil
+	}
+
+	a, err := s.store.ApprovalFor(ctx, job.ID, piece.PieceCid, provider)
+	if errors.Is(err, ErrApprovalNotFound) {
+		a, err = s.store.RequestApproval(ctx, job.ID, piece, provider, spend, reasons)
+		if err != nil {
+			return false, err
+		}
+		jlog.Infow("deal proposal is pending approval", "piece", piece.PieceCid, "provider", provider,
+			"approval", a.ID, "reasons", reasons)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return a.State == ApprovalApproved, nil
+}
+
+// charge charges the deal to the quota of the job's owner, and returns false
+// if the deal should not be proposed
+func (s *Scheduler) charge(jlog *zap.SugaredLogger, job *Job, c apiquota.Charge) bool {
+	if s.quotas == nil || job.Owner == "" {
+		return true
+	}
+	if err := s.quotas.Charge(job.Owner, c); err != nil {
+		var ee *apiquota.ExceededError
+		if errors.As(err, &ee) {
+			jlog.Infow("not proposing deal until api token quota resets", "token", job.Owner, "err", err)
+		} else {
+			jlog.Warnw("charging deal to api token quota", "token", job.Owner, "err", err)
+		}
+		return false
+	}
+	return true
+}
+
+func (s *Scheduler) refund(jlog *zap.SugaredLogger, job *Job, c apiquota.Charge) {
+	if s.quotas == nil || job.Owner == "" {
+		return
+	}
+	if err := s.quotas.Refund(job.Owner, c); err != nil {
+		jlog.Warnw("refunding deal to api token quota", "token", job.Owner, "err", err)
+	}
+}
+
+// resolveUnknown asks the provider for the status of the piece's deal with
+// the provider whose proposal outcome is unknown, if there is one, and
+// records the outcome. It returns true if the provider has the deal. While
+// the deal's status can't be checked the piece is not proposed to the
+// provider again.
+func (s *Scheduler) resolveUnknown(ctx context.Context, jlog *zap.SugaredLogger, job *Job, piece *Piece, provider address.Address) (bool, error) {
+	for i, d := range piece.Deals {
+		if d.Provider != provider || !d.OutcomeUnknown {
+			continue
+		}
+
+		found, err := s.maker.CheckDeal(ctx, provider, d.DealUUID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			jlog.Infow("could not check the status of deal whose proposal outcome is unknown", "piece", piece.PieceCid,
+				"provider", provider, logctx.DealKey, d.DealUUID, "err", err)
+			return false, nil
+		}
+
+		accepted := found != nil
+		var message string
+		if accepted {
+			message = found.Message
+		}
+		if err := s.store.ResolveDeal(ctx, job.ID, piece.PieceCid, d.DealUUID, accepted, message); err != nil {
+			return false, err
+		}
+		if !accepted {
+			if d.Charge != nil {
+				s.refund(jlog, job, *d.Charge)
+			}
+			piece.Deals[i].OutcomeUnknown = false
+			piece.Deals[i].Charge = nil
+			jlog.Infow("provider does not have deal whose proposal outcome was unknown", "piece", piece.PieceCid,
+				"provider", provider, logctx.DealKey, d.DealUUID)
+			return false, nil
+		}
+
+		piece.Deals[i] = Deal{Provider: provider, DealUUID: d.DealUUID, Accepted: true, Message: message,
+			Charge: d.Charge, ProposedAt: d.ProposedAt, EndEpoch: d.EndEpoch}
+		if s.slas != nil {
+			s.slas.AwaitTransfer(provider, d.DealUUID)
+		}
+		jlog.Infow("deal accepted (confirmed by deal status after the proposal response was lost)",
+			"piece", piece.PieceCid, "provider", provider, logctx.DealKey, d.DealUUID)
+		return true, nil
+	}
+	return false, nil
+}
+
+// FailOver fails an accepted deal (eg because the provider did not start the
+// transfer in time) so that the piece's replica is made with another
+// provider. The provider is asked to cancel the deal first, so that the
+// client doesn't pay for both deals: if the provider doesn't cancel it, the
+// deal is kept and FailOver returns false. The deal's quota charge is
+// refunded to the job's owner.
+func (s *Scheduler) FailOver(ctx context.Context, provider address.Address, dealUUID uuid.UUID, reason string) (bool, error) {
+	cancelled, err := s.maker.CancelDeal(ctx, provider, dealUUID)
+	if err != nil {
+		return false, fmt.Errorf("cancelling deal %s with %s: %w", dealUUID, provider, err)
+	}
+	if !cancelled {
+		return false, nil
+	}
+
+	failed, err := s.store.FailDeal(ctx, dealUUID, reason)
+	if err != nil || failed == nil {
+		return false, err
+	}
+
+	job, err := s.store.Job(ctx, failed.JobID)
+	if err != nil {
+		return false, err
+	}
+	jlog := logctx.Logger(logctx.With(ctx, logctx.JobKey, job.ID.String()), log)
+	if failed.Deal.Charge != nil {
+		s.refund(jlog, job, *failed.Deal.Charge)
+	}
+	jlog.Infow("failing over deal to another provider", "piece", failed.PieceCid, "provider", provider,
+		logctx.DealKey, dealUUID, "reason", reason)
+	s.Notify()
+	return true, nil
+}
+
+// canPropose returns false if the provider has already accepted a deal for
+// the piece, or if a deal's proposal outcome is unknown, or if proposals to
+// the provider have failed too many times or too recently, or if the
+// provider rejected the piece within the rejection backoff (unless the
+// job's price for the provider has been raised since)
+func (s *Scheduler) canPropose(piece Piece, provider address.Address, price abi.TokenAmount) bool {
+	var attempts, rejections int
+	var last, lastRejected time.Time
+	var rejectedPrice abi.TokenAmount
+	for _, d := range piece.Deals {
+		if d.Provider != provider {
+			continue
+		}
+		if d.Accepted || d.OutcomeUnknown {
+			return false
+		}
+		if d.Error == "" {
+			rejections++
+			lastRejected = d.ProposedAt
+			rejectedPrice = d.Price
+			continue
+		}
+		attempts++
+		last = d.ProposedAt
+	}
+	if attempts >= s.maxAttempts {
+		return false
+	}
+	if attempts > 0 && time.Since(last) < s.retryInterval {
+		return false
+	}
+	if rejections == 0 {
+		return true
+	}
+	if rejectedPrice.Int != nil && rejectedPrice.LessThan(price) {
+		return true
+	}
+	return time.Since(lastRejected) >= s.rejectionBackoff(rejections)
+}
+
+// rejectionBackoff returns how long to wait after the provider's nth
+// rejection of a piece before proposing it again
+func (s *Scheduler) rejectionBackoff(rejections int) time.Duration {
+	backoff := s.rejectBackoff
+	for i := 1; i < rejections && backoff < s.rejectMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.rejectMaxBackoff {
+		backoff = s.rejectMaxBackoff
+	}
+	return backoff
+}
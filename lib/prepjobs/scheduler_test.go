@@ -0,0 +1,988 @@
+package prepjobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/boost/lib/prewarm"
+	"github.com/filecoin-project/boost/lib/regions"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type mockDealMaker struct {
+	lk sync.Mutex
+	// the response for each provider, overridden by errors (which are
+	// consumed on each call)
+	reject map[address.Address]string
+	errs   map[address.Address][]error
+	calls  map[address.Address]int
+	// the storage price of the last deal proposed to each provider
+	prices map[address.Address]abi.TokenAmount
+	// the deals whose proposal outcome was unknown that the provider has
+	has map[uuid.UUID]bool
+	// the error returned when checking the status of a deal
+	statusErr error
+	// the deals whose data has been transferred, which can't be cancelled
+	transferred map[uuid.UUID]bool
+}
+
+func (m *mockDealMaker) MakeDeal(ctx context.Context, policy Policy, provider address.Address, piece Piece) (*Deal, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.calls[provider]++
+	if m.prices != nil {
+		m.prices[provider] = policy.PriceFor(provider)
+	}
+	if errs := m.errs[provider]; len(errs) > 0 {
+		m.errs[provider] = errs[1:]
+		return nil, errs[0]
+	}
+	if msg, ok := m.reject[provider]; ok {
+		return &Deal{Message: msg}, nil
+	}
+	return &Deal{DealUUID: uuid.New(), Accepted: true}, nil
+}
+
+func (m *mockDealMaker) CheckDeal(ctx context.Context, provider address.Address, dealUUID uuid.UUID) (*Deal, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.statusErr != nil {
+		return nil, m.statusErr
+	}
+	if !m.has[dealUUID] {
+		return nil, nil
+	}
+	return &Deal{DealUUID: dealUUID, Accepted: true, Message: "accepted"}, nil
+}
+
+func (m *mockDealMaker) CancelDeal(ctx context.Context, provider address.Address, dealUUID uuid.UUID) (bool, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	return !m.transferred[dealUUID], nil
+}
+
+func TestScheduler(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(ctx, "test", Policy{
+		Providers:    provs,
+		Replicas:     2,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+	})
+	req.NoError(err)
+
+	piece := Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/piece.car",
+	}
+	req.NoError(store.AddPiece(ctx, job.ID, piece))
+	// Adding the same piece again is a no-op
+	req.NoError(store.AddPiece(ctx, job.ID, piece))
+
+	// Provider 1 rejects deals, provider 2 can't be reached the first time
+	dm := &mockDealMaker{
+		reject: map[address.Address]string{provs[0]: "no thanks"},
+		errs:   map[address.Address][]error{provs[1]: {fmt.Errorf("connection refused")}},
+		calls:  make(map[address.Address]int),
+	}
+	retryInterval := 50 * time.Millisecond
+	sched := NewScheduler(store, dm, RetryParams(retryInterval, 3))
+
+	req.NoError(sched.Schedule(ctx))
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces, 1)
+	req.Len(pieces[0].Deals, 3)
+	req.Equal(1, pieces[0].Accepted())
+
+	// Provider 1 rejected the deal and provider 2 failed recently, so there
+	// should be no more proposals yet
+	req.NoError(sched.Schedule(ctx))
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces[0].Deals, 3)
+
+	// After the retry interval provider 2 should be retried
+	time.Sleep(retryInterval)
+	req.NoError(sched.Schedule(ctx))
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces[0].Deals, 4)
+	req.Equal(2, pieces[0].Accepted())
+	req.Equal(1, dm.calls[provs[0]])
+	req.Equal(2, dm.calls[provs[1]])
+	req.Equal(1, dm.calls[provs[2]])
+
+	// The piece has all its replicas so no more deals should be made
+	time.Sleep(retryInterval)
+	req.NoError(sched.Schedule(ctx))
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces[0].Deals, 4)
+
+	// A closed job doesn't accept new pieces
+	req.NoError(store.CloseJob(ctx, job.ID))
+	piece.PieceCid = testCid(t, "piece2")
+	req.ErrorIs(store.AddPiece(ctx, job.ID, piece), ErrJobClosed)
+}
+
+func TestSchedulerRetryRejected(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(ctx, "test", Policy{
+		Providers:    []address.Address{prov},
+		Replicas:     1,
+		Duration:     1000,
+		StoragePrice: big.NewInt(10),
+	})
+	req.NoError(err)
+	req.NoError(store.AddPiece(ctx, job.ID, Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/piece.car",
+	}))
+
+	dm := &mockDealMaker{
+		reject: map[address.Address]string{prov: "price too low"},
+		calls:  make(map[address.Address]int),
+		prices: make(map[address.Address]abi.TokenAmount),
+	}
+	backoff := 100 * time.Millisecond
+	sched := NewScheduler(store, dm, RetryRejected(backoff, 2*backoff))
+
+	// The rejected piece is not proposed again until the backoff has passed
+	req.NoError(sched.Schedule(ctx))
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(1, dm.calls[prov])
+	time.Sleep(backoff)
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(2, dm.calls[prov])
+
+	// The backoff doubles with each rejection
+	time.Sleep(backoff)
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(2, dm.calls[prov])
+	time.Sleep(backoff)
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(3, dm.calls[prov])
+
+	// Once the job's price for the provider is raised, the piece is proposed
+	// again right away
+	delete(dm.reject, prov)
+	_, err = store.AdjustPrice(ctx, prov, big.NewInt(20), big.Zero(), abi.TokenAmount{})
+	req.NoError(err)
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(4, dm.calls[prov])
+	req.Equal(big.NewInt(20), dm.prices[prov])
+
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces[0].Deals, 4)
+	req.Equal(1, pieces[0].Accepted())
+	req.Equal(big.NewInt(10), pieces[0].Deals[0].Price)
+}
+
+type mockPrewarmer struct {
+	warm map[address.Address]bool
+}
+
+func (m *mockPrewarmer) Warm(ctx context.Context, providers []address.Address) []prewarm.Result {
+	var res []prewarm.Result
+	for _, p := range providers {
+		m.warm[p] = true
+		res = append(res, prewarm.Result{Provider: p})
+	}
+	return res
+}
+
+func (m *mockPrewarmer) Release(providers []address.Address) {
+	for _, p := range providers {
+		delete(m.warm, p)
+	}
+}
+
+func TestSchedulerPrewarm(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	proposeAfter := time.Now().Add(100 * time.Millisecond)
+	job, err := store.CreateJob(ctx, "test", Policy{
+		Providers:    []address.Address{prov},
+		Replicas:     1,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+		ProposeAfter: proposeAfter,
+	})
+	req.NoError(err)
+	req.NoError(store.AddPiece(ctx, job.ID, Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/piece.car",
+	}))
+
+	dm := &mockDealMaker{calls: make(map[address.Address]int)}
+	pw := &mockPrewarmer{warm: make(map[address.Address]bool)}
+	sched := NewScheduler(store, dm, PrewarmConnections(pw, time.Hour))
+
+	// Before the window starts, the connection should be warmed but no deals
+	// should be proposed
+	req.NoError(sched.Schedule(ctx))
+	req.True(pw.warm[prov])
+	req.Zero(dm.calls[prov])
+
+	// Once the window starts, deals should be proposed
+	time.Sleep(time.Until(proposeAfter))
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(1, dm.calls[prov])
+	req.True(pw.warm[prov])
+
+	// When the job is closed and all pieces have their replicas, the
+	// connection should be released
+	req.NoError(store.CloseJob(ctx, job.ID))
+	req.NoError(sched.Schedule(ctx))
+	req.False(pw.warm[prov])
+}
+
+func TestSchedulerQuotas(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	tokens := apiquota.NewStore(filepath.Join(t.TempDir(), "api-tokens.json"))
+	_, err := tokens.Create("etl", apiquota.Quota{DealsPerDay: 2}, false)
+	req.NoError(err)
+
+	// The job is owned by the token that created it
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(apiquota.WithToken(ctx, "etl"), "test", Policy{
+		Providers:    provs,
+		Replicas:     3,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+	})
+	req.NoError(err)
+	req.Equal("etl", job.Owner)
+	req.NoError(store.AddPiece(ctx, job.ID, Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/piece.car",
+	}))
+
+	// The first proposal to provider 1 fails, which doesn't count towards
+	// the quota
+	dm := &mockDealMaker{
+		errs:  map[address.Address][]error{provs[0]: {fmt.Errorf("connection refused")}},
+		calls: make(map[address.Address]int),
+	}
+	sched := NewScheduler(store, dm, ChargeQuotas(tokens), RetryParams(0, 3))
+	req.NoError(sched.Schedule(ctx))
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(2, pieces[0].Accepted())
+
+	// The quota of two deals a day has been used
+	req.NoError(sched.Schedule(ctx))
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(2, pieces[0].Accepted())
+	list, err := tokens.List()
+	req.NoError(err)
+	req.EqualValues(2, list[0].Usage.Deals)
+
+	// Once the quota is raised the third replica is made
+	req.NoError(tokens.SetQuota("etl", apiquota.Quota{DealsPerDay: 3}))
+	req.NoError(sched.Schedule(ctx))
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(3, pieces[0].Accepted())
+}
+
+func TestSchedulerQuotaLadderSpend(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+	tokens := apiquota.NewStore(filepath.Join(t.TempDir(), "api-tokens.json"))
+	_, err = tokens.Create("etl", apiquota.Quota{SpendPerDay: "1 FIL"}, false)
+	req.NoError(err)
+
+	// A price at which a 2KiB piece costs 2 attoFIL per epoch
+	price := big.NewInt(1 << 20)
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	policy := Policy{
+		Providers:    []address.Address{prov},
+		Replicas:     1,
+		Duration:     1000,
+		StoragePrice: price,
+		Ladder:       &Ladder{Rungs: 2, Spacing: 500},
+	}
+	job, err := store.CreateJob(apiquota.WithToken(ctx, "etl"), "test", policy)
+	req.NoError(err)
+	for i := 0; i < 2; i++ {
+		req.NoError(store.AddPiece(ctx, job.ID, Piece{
+			PieceCid:   testCid(t, fmt.Sprintf("piece%d", i)),
+			PieceSize:  abi.PaddedPieceSize(2048),
+			PayloadCid: testCid(t, "payload"),
+			CarSize:    1000,
+			URL:        "http://localhost/piece.car",
+		}))
+	}
+
+	dm := &mockDealMaker{calls: make(map[address.Address]int)}
+	sched := NewScheduler(store, dm, ChargeQuotas(tokens), RetryParams(0, 3))
+	req.NoError(sched.Schedule(ctx))
+
+	// Each deal is charged for the duration of its piece's rung
+	list, err := tokens.List()
+	req.NoError(err)
+	req.EqualValues(2, list[0].Usage.Deals)
+	expected := big.Add(
+		apiquota.DealSpend(price, 2048, policy.DurationFor(0)),
+		apiquota.DealSpend(price, 2048, policy.DurationFor(1)))
+	req.Equal(big.NewInt(2*1000+2*1500), expected)
+	req.Equal(expected, list[0].Usage.Spend)
+}
+
+func TestSchedulerOutcomeUnknown(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 2; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	tokens := apiquota.NewStore(filepath.Join(t.TempDir(), "api-tokens.json"))
+	_, err := tokens.Create("etl", apiquota.Quota{DealsPerDay: 2}, false)
+	req.NoError(err)
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(apiquota.WithToken(ctx, "etl"), "test", Policy{
+		Providers:    provs,
+		Replicas:     2,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+	})
+	req.NoError(err)
+	req.NoError(store.AddPiece(ctx, job.ID, Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+	}))
+
+	// The responses to both proposals time out. Provider 1 accepted its
+	// deal, but the proposal to provider 2 never reached it.
+	lost, dropped := uuid.New(), uuid.New()
+	dm := &mockDealMaker{
+		errs: map[address.Address][]error{
+			provs[0]: {&types.ProposalOutcomeUnknownError{DealUUID: lost, Err: errors.New("read timeout")}},
+			provs[1]: {&types.ProposalOutcomeUnknownError{DealUUID: dropped, Err: errors.New("read timeout")}},
+		},
+		calls:     make(map[address.Address]int),
+		has:       map[uuid.UUID]bool{lost: true},
+		statusErr: errors.New("stream reset"),
+	}
+	sched := NewScheduler(store, dm, ChargeQuotas(tokens), RetryParams(0, 3))
+	req.NoError(sched.Schedule(ctx))
+
+	// The deals' uuids are kept, and the quota stays charged
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Len(pieces[0].Deals, 2)
+	for _, d := range pieces[0].Deals {
+		req.True(d.OutcomeUnknown)
+		req.Contains([]uuid.UUID{lost, dropped}, d.DealUUID)
+	}
+	list, err := tokens.List()
+	req.NoError(err)
+	req.EqualValues(2, list[0].Usage.Deals)
+
+	// While the deals' status can't be checked, the piece is not proposed
+	// again
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(map[address.Address]int{provs[0]: 1, provs[1]: 1}, dm.calls)
+
+	// Once the status can be checked, provider 1's deal is accepted without
+	// proposing it again, and the piece is proposed to provider 2 again
+	dm.statusErr = nil
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(map[address.Address]int{provs[0]: 1, provs[1]: 2}, dm.calls)
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(2, pieces[0].Accepted())
+	for _, d := range pieces[0].Deals {
+		req.False(d.OutcomeUnknown)
+	}
+	list, err = tokens.List()
+	req.NoError(err)
+	req.EqualValues(2, list[0].Usage.Deals)
+}
+
+type mockSLATracker struct {
+	slow     map[address.Address]bool
+	awaiting []uuid.UUID
+}
+
+func (m *mockSLATracker) Order(providers []address.Address) []address.Address {
+	var ordered, slow []address.Address
+	for _, p := range providers {
+		if m.slow[p] {
+			slow = append(slow, p)
+		} else {
+			ordered = append(ordered, p)
+		}
+	}
+	return append(ordered, slow...)
+}
+
+func (m *mockSLATracker) AwaitTransfer(provider address.Address, dealUUID uuid.UUID) {
+	m.awaiting = append(m.awaiting, dealUUID)
+}
+
+func TestSchedulerSLAs(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	tokens := apiquota.NewStore(filepath.Join(t.TempDir(), "api-tokens.json"))
+	_, err := tokens.Create("etl", apiquota.Quota{DealsPerDay: 1}, false)
+	req.NoError(err)
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(apiquota.WithToken(ctx, "etl"), "test", Policy{
+		Providers:    provs,
+		Replicas:     1,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+	})
+	req.NoError(err)
+	req.NoError(store.AddPiece(ctx, job.ID, Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/piece.car",
+	}))
+
+	// Provider 1 is deprioritized, so the deal is made with provider 2
+	dm := &mockDealMaker{calls: make(map[address.Address]int)}
+	slas := &mockSLATracker{slow: map[address.Address]bool{provs[0]: true}}
+	sched := NewScheduler(store, dm, TrackSLAs(slas), ChargeQuotas(tokens), RetryParams(0, 3))
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(0, dm.calls[provs[0]])
+	req.Equal(1, dm.calls[provs[1]])
+	req.Len(slas.awaiting, 1)
+
+	list, err := tokens.List()
+	req.NoError(err)
+	req.EqualValues(1, list[0].Usage.Deals)
+
+	// A deal that the provider won't cancel (because the data has been
+	// transferred) is not failed over
+	slow := slas.awaiting[0]
+	dm.transferred = map[uuid.UUID]bool{slow: true}
+	failed, err := sched.FailOver(ctx, provs[1], slow, "transfer not started")
+	req.NoError(err)
+	req.False(failed)
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(1, pieces[0].Accepted())
+
+	// When provider 2 cancels the deal that it didn't start the transfer
+	// for in time, the deal's quota is refunded and the deal fails over to
+	// provider 3
+	dm.transferred = nil
+	failed, err = sched.FailOver(ctx, provs[1], slow, "transfer not started")
+	req.NoError(err)
+	req.True(failed)
+	list, err = tokens.List()
+	req.NoError(err)
+	req.EqualValues(0, list[0].Usage.Deals)
+	failed, err = sched.FailOver(ctx, provs[1], uuid.New(), "transfer not started")
+	req.NoError(err)
+	req.False(failed)
+
+	slas.slow[provs[1]] = true
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(1, dm.calls[provs[2]])
+	pieces, err = store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(1, pieces[0].Accepted())
+	req.Equal("transfer not started", pieces[0].Deals[0].Error)
+	list, err = tokens.List()
+	req.NoError(err)
+	req.EqualValues(1, list[0].Usage.Deals)
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func TestSchedulerApprovals(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(ctx, "test", Policy{
+		Providers:    provs,
+		Replicas:     3,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+	})
+	req.NoError(err)
+	small := Piece{
+		PieceCid:   testCid(t, "small"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/small.car",
+	}
+	large := small
+	large.PieceCid = testCid(t, "large")
+	large.PieceSize = abi.PaddedPieceSize(1 << 20)
+	req.NoError(store.AddPiece(ctx, job.ID, small))
+	req.NoError(store.AddPiece(ctx, job.ID, large))
+
+	// Large pieces and proposals to provider 3 need approval
+	dm := &mockDealMaker{calls: make(map[address.Address]int)}
+	sched := NewScheduler(store, dm, RequireApproval(ApprovalThresholds{
+		PieceSize: 4096,
+		Providers: []address.Address{provs[2]},
+	}))
+
+	req.NoError(sched.Schedule(ctx))
+	deals := func() map[cid.Cid]int {
+		pieces, err := store.Pieces(ctx, job.ID)
+		req.NoError(err)
+		res := make(map[cid.Cid]int)
+		for _, p := range pieces {
+			res[p.PieceCid] = len(p.Deals)
+		}
+		return res
+	}
+	req.Equal(map[cid.Cid]int{small.PieceCid: 2, large.PieceCid: 0}, deals())
+
+	// Three proposals for the large piece and one for provider 3 are pending
+	pending, err := store.Approvals(ctx, ApprovalPending)
+	req.NoError(err)
+	req.Len(pending, 4)
+
+	// Scheduling again doesn't request approval again
+	req.NoError(sched.Schedule(ctx))
+	pending, err = store.Approvals(ctx, ApprovalPending)
+	req.NoError(err)
+	req.Len(pending, 4)
+
+	// Approve the large piece with provider 1, and reject the small piece
+	// with provider 3
+	a, err := store.ApprovalFor(ctx, job.ID, large.PieceCid, provs[0])
+	req.NoError(err)
+	req.Contains(a.Reasons[0], "piece size")
+	_, err = store.DecideApproval(ctx, a.ID, true, "", "")
+	req.Error(err)
+	a, err = store.DecideApproval(ctx, a.ID, true, "alice", "looks fine")
+	req.NoError(err)
+	req.Equal(ApprovalApproved, a.State)
+	req.Equal("alice", a.Approver)
+	_, err = store.DecideApproval(ctx, a.ID, false, "bob", "")
+	req.ErrorIs(err, ErrApprovalDecided)
+
+	r, err := store.ApprovalFor(ctx, job.ID, small.PieceCid, provs[2])
+	req.NoError(err)
+	_, err = store.DecideApproval(ctx, r.ID, false, "bob", "too risky")
+	req.NoError(err)
+
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(map[cid.Cid]int{small.PieceCid: 2, large.PieceCid: 1}, deals())
+	req.Equal(3, dm.calls[provs[0]]+dm.calls[provs[1]]+dm.calls[provs[2]])
+	req.Equal(0, dm.calls[provs[2]])
+
+	pending, err = store.Approvals(ctx, ApprovalPending)
+	req.NoError(err)
+	req.Len(pending, 2)
+}
+
+func TestStoreAdjustPrice(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov1, err := address.NewIDAddress(1)
+	req.NoError(err)
+	prov2, err := address.NewIDAddress(2)
+	req.NoError(err)
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(ctx, "test", Policy{
+		Providers:    []address.Address{prov1, prov2},
+		Replicas:     1,
+		Duration:     1000,
+		StoragePrice: big.NewInt(10),
+	})
+	req.NoError(err)
+	verifiedJob, err := store.CreateJob(ctx, "verified", Policy{
+		Providers:    []address.Address{prov1},
+		Replicas:     1,
+		Duration:     1000,
+		Verified:     true,
+		StoragePrice: big.Zero(),
+	})
+	req.NoError(err)
+
+	// The provider's price is raised to 20, capped at 15
+	adjusted, err := store.AdjustPrice(ctx, prov1, big.NewInt(20), big.Zero(), big.NewInt(15))
+	req.NoError(err)
+	req.Equal([]uuid.UUID{job.ID}, adjusted)
+
+	job, err = store.Job(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(big.NewInt(15), job.Policy.PriceFor(prov1))
+	req.Equal(big.NewInt(10), job.Policy.PriceFor(prov2))
+
+	// A price that the job already pays doesn't adjust the job
+	adjusted, err = store.AdjustPrice(ctx, prov1, big.NewInt(12), big.Zero(), abi.TokenAmount{})
+	req.NoError(err)
+	req.Empty(adjusted)
+
+	// A closed job with no pending pieces isn't adjusted
+	req.NoError(store.CloseJob(ctx, verifiedJob.ID))
+	adjusted, err = store.AdjustPrice(ctx, prov1, big.NewInt(12), big.NewInt(5), abi.TokenAmount{})
+	req.NoError(err)
+	req.Empty(adjusted)
+}
+
+func TestStoreLadder(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	policy := Policy{
+		Providers:    []address.Address{prov},
+		Replicas:     1,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+		Ladder:       &Ladder{Rungs: 3, Spacing: 100},
+	}
+	job, err := store.CreateJob(ctx, "test", policy)
+	req.NoError(err)
+	req.Equal(abi.ChainEpoch(1200), policy.DurationFor(2))
+
+	// The last rung can't last longer than the maximum deal duration
+	long := policy
+	long.Duration = 1540000
+	long.Ladder = &Ladder{Rungs: 3, Spacing: 10000}
+	req.Error(long.Validate())
+
+	// Pieces are assigned to rungs in turn
+	var pieces []Piece
+	for i := 0; i < 5; i++ {
+		piece := Piece{
+			PieceCid:   testCid(t, fmt.Sprintf("piece%d", i)),
+			PieceSize:  abi.PaddedPieceSize(2048),
+			PayloadCid: testCid(t, "payload"),
+			CarSize:    1000,
+			URL:        "http://localhost/piece.car",
+		}
+		req.NoError(store.AddPiece(ctx, job.ID, piece))
+		pieces = append(pieces, piece)
+	}
+	added, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	for i, p := range added {
+		req.Equal(i%3, p.Rung)
+	}
+
+	// Only rungs 0 and 1 have deals so far
+	for i, p := range added[:2] {
+		req.NoError(store.AddDeal(ctx, job.ID, p.PieceCid, Deal{
+			Provider: prov,
+			DealUUID: uuid.New(),
+			Accepted: true,
+			EndEpoch: 100 + policy.DurationFor(i),
+		}))
+	}
+
+	rungs, err := store.Ladder(ctx, job.ID)
+	req.NoError(err)
+	req.Len(rungs, 3)
+	req.Equal(0, rungs[0].Rung)
+	req.Equal([]cid.Cid{pieces[0].PieceCid, pieces[3].PieceCid}, rungs[0].Pieces)
+	req.Equal(abi.ChainEpoch(1100), rungs[0].FirstEndEpoch)
+	req.Equal(1, rungs[1].Rung)
+	req.Equal(abi.ChainEpoch(1200), rungs[1].FirstEndEpoch)
+	req.Equal(2, rungs[2].Rung)
+	req.Equal(0, rungs[2].Deals)
+
+	due := DueForRenewal(rungs, 1150)
+	req.Len(due, 1)
+	req.Equal(0, due[0].Rung)
+}
+
+func TestSchedulerRegions(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 5; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	// Provider 5's region is looked up when the job is created, and
+	// provider 4's region is excluded
+	policy := Policy{
+		Providers:    provs,
+		Replicas:     2,
+		Duration:     1000,
+		StoragePrice: big.Zero(),
+		Regions:      &RegionConstraint{MinRegions: 2, Exclude: []string{"cn-north"}},
+		ProviderRegions: map[string]string{
+			provs[0].String(): "us-east",
+			provs[1].String(): "us-east",
+			provs[3].String(): "cn-north",
+		},
+	}
+	req.ErrorContains(policy.Validate(), "only in 1 regions")
+	req.NoError(resolveRegions(ctx, regions.Static{provs[4]: "eu-west", provs[0]: "ignored"}, &policy))
+	req.Equal("us-east", policy.RegionOf(provs[0]))
+	req.Equal("eu-west", policy.RegionOf(provs[4]))
+	req.Equal("", policy.RegionOf(provs[2]))
+	req.NoError(policy.Validate())
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	job, err := store.CreateJob(ctx, "test", policy)
+	req.NoError(err)
+	piece := Piece{
+		PieceCid:   testCid(t, "piece"),
+		PieceSize:  abi.PaddedPieceSize(2048),
+		PayloadCid: testCid(t, "payload"),
+		CarSize:    1000,
+		URL:        "http://localhost/piece.car",
+	}
+	req.NoError(store.AddPiece(ctx, job.ID, piece))
+
+	// Provider 2 is skipped because its region already has a replica, and
+	// providers 3 and 4 because their regions are unknown or excluded
+	dm := &mockDealMaker{calls: make(map[address.Address]int)}
+	sched := NewScheduler(store, dm)
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(map[address.Address]int{provs[0]: 1, provs[4]: 1}, dm.calls)
+
+	pieces, err := store.Pieces(ctx, job.ID)
+	req.NoError(err)
+	req.Equal(2, pieces[0].Accepted())
+	req.Equal("", job.Policy.CheckRegions(pieces[0]))
+
+	// Deals in the same region don't satisfy the constraint
+	piece.Deals = []Deal{{Provider: provs[0], Accepted: true}, {Provider: provs[1], Accepted: true}}
+	req.Contains(job.Policy.CheckRegions(piece), "deals span 1 regions")
+	piece.Deals = append(piece.Deals, Deal{Provider: provs[3], Accepted: true})
+	req.Contains(job.Policy.CheckRegions(piece), "excluded region cn-north")
+}
+
+func TestSchedulerOffPeak(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	// Provider 1 is in an off-peak window with a 20% discount, provider 2's
+	// window starts in two hours and provider 3 doesn't announce any windows
+	now := time.Now().UTC()
+	m := uint64(now.Hour()*60 + now.Minute())
+	windows := map[address.Address][]types.OffPeakWindow{
+		provs[0]: {{Start: (m + 1440 - 60) % 1440, End: (m + 60) % 1440, DiscountPercent: 20}},
+		provs[1]: {{Start: (m + 120) % 1440, End: (m + 180) % 1440}},
+	}
+	var lookups int
+	src := CacheOffPeakWindows(OffPeakSourceFunc(func(ctx context.Context, provider address.Address) ([]types.OffPeakWindow, error) {
+		lookups++
+		return windows[provider], nil
+	}), time.Hour)
+
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	policy := Policy{
+		Providers:    provs,
+		Replicas:     3,
+		Duration:     1000,
+		StoragePrice: abi.NewTokenAmount(1000),
+		OffPeak:      true,
+	}
+	req.NoError(policy.Validate())
+	job, err := store.CreateJob(ctx, "test", policy)
+	req.NoError(err)
+	piece := Piece{PieceCid: testCid(t, "piece"), PieceSize: 2048, PayloadCid: testCid(t, "payload"), CarSize: 1000}
+	req.NoError(store.AddPiece(ctx, job.ID, piece))
+
+	dm := &mockDealMaker{calls: make(map[address.Address]int), prices: make(map[address.Address]abi.TokenAmount)}
+	sched := NewScheduler(store, dm, ShiftToOffPeak(src, 0))
+	req.NoError(sched.Schedule(ctx))
+	req.NoError(sched.Schedule(ctx))
+
+	// The deal for provider 2 is held until its off-peak window, and the deal
+	// for provider 1 gets its off-peak discount
+	req.Equal(map[address.Address]int{provs[0]: 1, provs[2]: 1}, dm.calls)
+	req.Equal(abi.NewTokenAmount(800), dm.prices[provs[0]])
+	req.Equal(abi.NewTokenAmount(1000), dm.prices[provs[2]])
+	req.Equal(abi.NewTokenAmount(1000), job.Policy.PriceFor(provs[0]))
+	// Windows are cached
+	req.Equal(3, lookups)
+
+	// After the job's off-peak deadline, deals are proposed regardless of the
+	// providers' windows
+	policy.Providers = provs[1:2]
+	policy.Replicas = 1
+	policy.OffPeakDeadline = now.Add(-time.Minute)
+	late, err := store.CreateJob(ctx, "late", policy)
+	req.NoError(err)
+	req.NoError(store.AddPiece(ctx, late.ID, piece))
+	req.NoError(sched.Schedule(ctx))
+	req.Equal(1, dm.calls[provs[1]])
+	req.Equal(abi.NewTokenAmount(1000), dm.prices[provs[1]])
+
+	policy.OffPeak = false
+	req.ErrorContains(policy.Validate(), "only allowed for off-peak jobs")
+}
+
+func TestSchedulerOffPeakTransferTime(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	var provs []address.Address
+	for i := 1; i <= 3; i++ {
+		addr, err := address.NewIDAddress(uint64(i))
+		req.NoError(err)
+		provs = append(provs, addr)
+	}
+
+	// Provider 1's window opened an hour ago and ends in an hour, provider
+	// 2's window has just opened, and provider 3 announces a window with an
+	// invalid discount
+	now := time.Now().UTC()
+	m := uint64(now.Hour()*60 + now.Minute())
+	windows := map[address.Address][]types.OffPeakWindow{
+		provs[0]: {{Start: (m + 1440 - 60) % 1440, End: (m + 60) % 1440, DiscountPercent: 20}},
+		provs[1]: {{Start: m, End: (m + 60) % 1440, DiscountPercent: 20}},
+		provs[2]: {{Start: (m + 1440 - 60) % 1440, End: (m + 60) % 1440, DiscountPercent: 200}},
+	}
+	src := OffPeakSourceFunc(func(ctx context.Context, provider address.Address) ([]types.OffPeakWindow, error) {
+		return windows[provider], nil
+	})
+
+	// Transfers run at 1000 bytes per second
+	store := NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	dm := &mockDealMaker{calls: make(map[address.Address]int), prices: make(map[address.Address]abi.TokenAmount)}
+	sched := NewScheduler(store, dm, ShiftToOffPeak(src, 1000))
+
+	proposed := func(provider address.Address, transfer time.Duration) bool {
+		policy := Policy{
+			Providers:    []address.Address{provider},
+			Replicas:     1,
+			Duration:     1000,
+			StoragePrice: abi.NewTokenAmount(1000),
+			OffPeak:      true,
+		}
+		job, err := store.CreateJob(ctx, "test", policy)
+		req.NoError(err)
+		carSize := uint64(transfer.Seconds()) * 1000
+		piece := Piece{PieceCid: testCid(t, transfer.String()), PieceSize: 2048, PayloadCid: testCid(t, "payload"), CarSize: carSize}
+		req.NoError(store.AddPiece(ctx, job.ID, piece))
+
+		calls := dm.calls[provider]
+		req.NoError(sched.Schedule(ctx))
+		return dm.calls[provider] > calls
+	}
+
+	// A transfer that ends inside the window is proposed, with the discount
+	req.True(proposed(provs[0], 30*time.Minute))
+	req.Equal(abi.NewTokenAmount(800), dm.prices[provs[0]])
+	// A transfer that would run past the end of the window is held
+	req.False(proposed(provs[0], 90*time.Minute))
+	// A transfer that is longer than the window is only proposed just after
+	// the window opens
+	req.False(proposed(provs[0], 3*time.Hour))
+	req.True(proposed(provs[1], 3*time.Hour))
+
+	// An invalid window is ignored, so the deal is proposed without the
+	// discount
+	req.True(proposed(provs[2], 30*time.Minute))
+	req.Equal(abi.NewTokenAmount(1000), dm.prices[provs[2]])
+}
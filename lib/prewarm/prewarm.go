@@ -0,0 +1,165 @@
+// Package prewarm dials storage providers ahead of time, so that deal
+// proposals and transfers can start as soon as they are scheduled instead of
+// waiting for peer lookup, dialing and protocol negotiation.
+package prewarm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var log = logging.Logger("prewarm")
+
+// The connection manager tag used to protect pre-warmed connections
+const protectTag = "boost-prewarm"
+
+// AddrInfoResolver looks up the peer ID and addresses of a storage provider
+type AddrInfoResolver func(ctx context.Context, maddr address.Address) (*peer.AddrInfo, error)
+
+// Result is the result of pre-warming the connection to a provider
+type Result struct {
+	Provider address.Address
+	Peer     peer.ID
+	// The protocol that was negotiated with the provider
+	Protocol protocol.ID
+	Error    string
+	// How long it took to connect and negotiate
+	Duration time.Duration
+	WarmedAt time.Time
+}
+
+// Warmer pre-dials providers, protects the connections from being pruned by
+// the connection manager, and negotiates the protocol that will be used so
+// that it is cached in the peerstore
+type Warmer struct {
+	host      host.Host
+	resolve   AddrInfoResolver
+	protocols []protocol.ID
+
+	lk    sync.Mutex
+	warm  map[address.Address]*Result
+	peers map[address.Address]peer.ID
+}
+
+// New creates a Warmer that negotiates one of the protocols with each
+// provider, in order of preference
+func New(h host.Host, resolve AddrInfoResolver, protocols ...protocol.ID) *Warmer {
+	return &Warmer{
+		host:      h,
+		resolve:   resolve,
+		protocols: protocols,
+		warm:      make(map[address.Address]*Result),
+		peers:     make(map[address.Address]peer.ID),
+	}
+}
+
+// Warm connects to each of the providers in parallel. Providers that already
+// have a warm connection are skipped.
+func (w *Warmer) Warm(ctx context.Context, providers []address.Address) []Result {
+	results := make([]Result, len(providers))
+	var wg sync.WaitGroup
+	for i, maddr := range providers {
+		wg.Add(1)
+		go func(i int, maddr address.Address) {
+			defer wg.Done()
+			results[i] = w.warmProvider(ctx, maddr)
+		}(i, maddr)
+	}
+	wg.Wait()
+	return results
+}
+
+func (w *Warmer) warmProvider(ctx context.Context, maddr address.Address) Result {
+	w.lk.Lock()
+	if res, ok := w.warm[maddr]; ok && w.host.Network().Connectedness(res.Peer) == network.Connected {
+		w.lk.Unlock()
+		return *res
+	}
+	w.lk.Unlock()
+
+	start := time.Now()
+	res := Result{Provider: maddr, WarmedAt: start}
+	proto, pid, err := w.connect(ctx, maddr)
+	res.Duration = time.Since(start)
+	res.Peer = pid
+	if err != nil {
+		res.Error = err.Error()
+		log.Infow("failed to pre-warm connection to provider", "provider", maddr, "err", err)
+		return res
+	}
+	res.Protocol = proto
+	log.Debugw("pre-warmed connection to provider", "provider", maddr, "peer", pid, "protocol", proto, "duration", res.Duration)
+
+	w.lk.Lock()
+	w.warm[maddr] = &res
+	w.peers[maddr] = pid
+	w.lk.Unlock()
+	return res
+}
+
+func (w *Warmer) connect(ctx context.Context, maddr address.Address) (protocol.ID, peer.ID, error) {
+	addrInfo, err := w.resolve(ctx, maddr)
+	if err != nil {
+		return "", "", fmt.Errorf("looking up peer for %s: %w", maddr, err)
+	}
+
+	if err := w.host.Connect(ctx, *addrInfo); err != nil {
+		return "", addrInfo.ID, fmt.Errorf("connecting to %s: %w", addrInfo.ID, err)
+	}
+	w.host.ConnManager().Protect(addrInfo.ID, protectTag)
+
+	if len(w.protocols) == 0 {
+		return "", addrInfo.ID, nil
+	}
+
+	// Open a stream to negotiate the protocol. The host records the protocols
+	// supported by the peer in the peerstore, so later streams don't need a
+	// round trip to negotiate.
+	s, err := w.host.NewStream(network.WithNoDial(ctx, "prewarm"), addrInfo.ID, w.protocols...)
+	if err != nil {
+		w.host.ConnManager().Unprotect(addrInfo.ID, protectTag)
+		return "", addrInfo.ID, fmt.Errorf("negotiating protocol with %s: %w", addrInfo.ID, err)
+	}
+	proto := s.Protocol()
+	_ = s.Reset()
+	return proto, addrInfo.ID, nil
+}
+
+// Release removes the protection from the connections to the providers, so
+// that the connection manager can close them when they are idle
+func (w *Warmer) Release(providers []address.Address) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	for _, maddr := range providers {
+		pid, ok := w.peers[maddr]
+		if !ok {
+			continue
+		}
+		w.host.ConnManager().Unprotect(pid, protectTag)
+		delete(w.peers, maddr)
+		delete(w.warm, maddr)
+	}
+}
+
+// Warmed returns the results for the providers that currently have a warm
+// connection
+func (w *Warmer) Warmed() []Result {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	results := make([]Result, 0, len(w.warm))
+	for _, res := range w.warm {
+		results = append(results, *res)
+	}
+	return results
+}
@@ -0,0 +1,64 @@
+package prewarm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarm(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	mn := mocknet.New()
+	t.Cleanup(func() { _ = mn.Close() })
+	newHost := func() host.Host {
+		h, err := mn.GenPeer()
+		req.NoError(err)
+		return h
+	}
+
+	client := newHost()
+	prov := newHost()
+	req.NoError(mn.LinkAll())
+	// The provider only supports the older protocol version
+	prov.SetStreamHandler("/test/1.1.0", func(s network.Stream) { _ = s.Close() })
+
+	provAddr, err := address.NewIDAddress(1000)
+	req.NoError(err)
+	unknownAddr, err := address.NewIDAddress(1001)
+	req.NoError(err)
+
+	resolve := func(ctx context.Context, maddr address.Address) (*peer.AddrInfo, error) {
+		if maddr == provAddr {
+			return &peer.AddrInfo{ID: prov.ID(), Addrs: prov.Addrs()}, nil
+		}
+		return nil, fmt.Errorf("no peer for %s", maddr)
+	}
+	w := New(client, resolve, "/test/1.2.0", "/test/1.1.0")
+
+	results := w.Warm(ctx, []address.Address{provAddr, unknownAddr})
+	req.Len(results, 2)
+	req.Empty(results[0].Error)
+	req.EqualValues("/test/1.1.0", results[0].Protocol)
+	req.Equal(prov.ID(), results[0].Peer)
+	req.NotEmpty(results[1].Error)
+
+	req.Equal(network.Connected, client.Network().Connectedness(prov.ID()))
+	req.Len(w.Warmed(), 1)
+
+	// Warming again should reuse the existing connection
+	results = w.Warm(ctx, []address.Address{provAddr})
+	req.Empty(results[0].Error)
+	req.Equal(results[0].WarmedAt, w.Warmed()[0].WarmedAt)
+
+	w.Release([]address.Address{provAddr})
+	req.Empty(w.Warmed())
+}
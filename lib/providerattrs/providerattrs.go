@@ -0,0 +1,224 @@
+// Package providerattrs looks up attested attributes of storage providers,
+// such as a renewable-energy certification, so that a client with
+// environmental (ESG) requirements can take them into account when choosing
+// providers.
+//
+// Attributes come from the client's own config, or from external attestation
+// feeds. Attributes are free-form labels such as "renewable-energy" or
+// "iso-14001"; they are compared case-insensitively. A client can require
+// attributes (a hard constraint: providers without them are not used) or
+// prefer them (a soft constraint: providers with more of them are used
+// first).
+package providerattrs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/providerfeed"
+	"github.com/filecoin-project/go-address"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("providerattrs")
+
+// Source looks up the attributes of providers
+type Source interface {
+	// Attributes returns the attributes of each of the providers that has
+	// any. Providers without attributes are left out of the result.
+	Attributes(ctx context.Context, providers []address.Address) (map[address.Address][]string, error)
+}
+
+// SourceFunc is a function that implements Source
+type SourceFunc func(ctx context.Context, providers []address.Address) (map[address.Address][]string, error)
+
+func (f SourceFunc) Attributes(ctx context.Context, providers []address.Address) (map[address.Address][]string, error) {
+	return f(ctx, providers)
+}
+
+// Normalize returns the attribute in the form in which attributes are
+// compared
+func Normalize(attr string) string {
+	return strings.ToLower(strings.TrimSpace(attr))
+}
+
+// Static is a fixed set of provider attributes, eg from the client's config
+type Static map[address.Address][]string
+
+func (s Static) Attributes(_ context.Context, providers []address.Address) (map[address.Address][]string, error) {
+	attrs := make(map[address.Address][]string)
+	for _, p := range providers {
+		if a, ok := s[p]; ok && len(a) > 0 {
+			attrs[p] = a
+		}
+	}
+	return attrs, nil
+}
+
+// ParseStatic parses provider attributes in the format
+// <provider>=<attribute>[,<attribute>...], eg f01000=renewable-energy.
+// A provider may appear more than once.
+func ParseStatic(specs []string) (Static, error) {
+	s := make(Static, len(specs))
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("provider attribute %s is not in the format <provider>=<attribute>", spec)
+		}
+		addr, err := address.NewFromString(spec[:i])
+		if err != nil {
+			return nil, fmt.Errorf("parsing provider address in %s: %w", spec, err)
+		}
+		attrs := normalizeAll(strings.Split(spec[i+1:], ","))
+		if len(attrs) == 0 {
+			return nil, fmt.Errorf("provider attribute %s has no attributes", spec)
+		}
+		s[addr] = union(s[addr], attrs)
+	}
+	return s, nil
+}
+
+// Feed fetches provider attributes from an attestation feed: an http
+// endpoint that serves a JSON object mapping provider addresses to the
+// attributes that have been attested for them, eg
+//
+//	{"f01000": ["renewable-energy"], "f01001": ["renewable-energy", "iso-14001"]}
+//
+// The feed is cached, and re-fetched once the cache is older than the TTL.
+type Feed struct {
+	feed *providerfeed.Feed
+}
+
+func NewFeed(url string, ttl time.Duration) *Feed {
+	return &Feed{feed: providerfeed.New("attestation feed", url, ttl)}
+}
+
+func (f *Feed) Attributes(ctx context.Context, providers []address.Address) (map[address.Address][]string, error) {
+	values, err := f.feed.Values(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[address.Address][]string)
+	for _, p := range providers {
+		v, ok := values[p]
+		if !ok {
+			continue
+		}
+		var a []string
+		if err := json.Unmarshal(v, &a); err != nil {
+			log.Debugw("skipping invalid attributes in attestation feed", "url", f.feed.URL(), "provider", p, "err", err)
+			continue
+		}
+		if a = normalizeAll(a); len(a) > 0 {
+			attrs[p] = a
+		}
+	}
+	return attrs, nil
+}
+
+// Merge returns a source that combines the attributes of each provider from
+// all of the sources (eg a provider attested as using renewable energy by
+// one feed and as ISO 14001 certified by another has both attributes). If a
+// source fails, the error is logged and the other sources are still used.
+func Merge(sources ...Source) Source {
+	return SourceFunc(func(ctx context.Context, providers []address.Address) (map[address.Address][]string, error) {
+		attrs := make(map[address.Address][]string)
+		for _, s := range sources {
+			found, err := s.Attributes(ctx, providers)
+			if err != nil {
+				log.Warnw("looking up provider attributes", "err", err)
+				continue
+			}
+			for p, a := range found {
+				if a = normalizeAll(a); len(a) > 0 {
+					attrs[p] = union(attrs[p], a)
+				}
+			}
+		}
+		return attrs, nil
+	})
+}
+
+// Constraint is a client's requirements and preferences for the attributes
+// of the providers that store its data
+type Constraint struct {
+	// Providers that don't have all of these attributes are not used
+	Require []string `json:",omitempty"`
+	// Providers with more of these attributes are used first
+	Prefer []string `json:",omitempty"`
+}
+
+// IsZero returns true if the constraint neither requires nor prefers any
+// attribute
+func (c Constraint) IsZero() bool {
+	return len(c.Require) == 0 && len(c.Prefer) == 0
+}
+
+// Missing returns the attributes in want that are not in attrs
+func Missing(want []string, attrs []string) []string {
+	var missing []string
+	for _, w := range want {
+		if w = Normalize(w); w != "" && !contains(attrs, w) {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
+
+// Score returns the number of preferred attributes that the provider has
+func (c Constraint) Score(attrs []string) int {
+	return len(normalizeAll(c.Prefer)) - len(Missing(c.Prefer, attrs))
+}
+
+// Select returns the providers that have all the required attributes, with
+// the providers that have the most preferred attributes first (otherwise
+// keeping the order of providers), and the reason each of the other
+// providers was excluded.
+func (c Constraint) Select(providers []address.Address, attrs map[address.Address][]string) ([]address.Address, map[address.Address]string) {
+	selected := make([]address.Address, 0, len(providers))
+	excluded := make(map[address.Address]string)
+	for _, p := range providers {
+		if missing := Missing(c.Require, attrs[p]); len(missing) > 0 {
+			excluded[p] = fmt.Sprintf("missing required attributes %s", strings.Join(missing, ", "))
+			continue
+		}
+		selected = append(selected, p)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return c.Score(attrs[selected[i]]) > c.Score(attrs[selected[j]])
+	})
+	return selected, excluded
+}
+
+func normalizeAll(attrs []string) []string {
+	var normalized []string
+	for _, a := range attrs {
+		if a = Normalize(a); a != "" && !contains(normalized, a) {
+			normalized = append(normalized, a)
+		}
+	}
+	return normalized
+}
+
+func union(a, b []string) []string {
+	for _, x := range b {
+		if !contains(a, x) {
+			a = append(a, x)
+		}
+	}
+	return a
+}
+
+func contains(attrs []string, attr string) bool {
+	for _, a := range attrs {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
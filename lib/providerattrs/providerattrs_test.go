@@ -0,0 +1,104 @@
+package providerattrs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/stretchr/testify/require"
+)
+
+func testProviders(t *testing.T, n int) []address.Address {
+	var provs []address.Address
+	for i := 0; i < n; i++ {
+		p, err := address.NewIDAddress(uint64(1000 + i))
+		require.NoError(t, err)
+		provs = append(provs, p)
+	}
+	return provs
+}
+
+func TestParseStatic(t *testing.T) {
+	provs := testProviders(t, 2)
+	s, err := ParseStatic([]string{"f01000=Renewable-Energy, iso-14001", "f01001=renewable-energy", "f01000=iso-14001,b-corp"})
+	require.NoError(t, err)
+	require.Equal(t, Static{
+		provs[0]: {"renewable-energy", "iso-14001", "b-corp"},
+		provs[1]: {"renewable-energy"},
+	}, s)
+
+	for _, spec := range []string{"f01000", "abc=renewable-energy", "f01000= , "} {
+		_, err := ParseStatic([]string{spec})
+		require.Error(t, err, spec)
+	}
+}
+
+func TestFeedAndMerge(t *testing.T) {
+	ctx := context.Background()
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		fmt.Fprint(w, `{"f01000": ["Renewable-Energy"], "f01001": ["iso-14001", ""], "not-an-address": ["b-corp"]}`)
+	}))
+	defer srv.Close()
+
+	provs := testProviders(t, 3)
+	feed := NewFeed(srv.URL, time.Hour)
+	attrs, err := feed.Attributes(ctx, provs)
+	require.NoError(t, err)
+	require.Equal(t, map[address.Address][]string{provs[0]: {"renewable-energy"}, provs[1]: {"iso-14001"}}, attrs)
+
+	// The feed is cached until the TTL expires
+	_, err = feed.Attributes(ctx, provs)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fetches))
+
+	// Attributes from all sources are combined, and sources that fail are
+	// skipped
+	failing := SourceFunc(func(context.Context, []address.Address) (map[address.Address][]string, error) {
+		return nil, fmt.Errorf("unreachable")
+	})
+	static := Static{provs[0]: {"iso-14001", "renewable-energy"}, provs[2]: {"b-corp"}}
+	attrs, err = Merge(static, failing, feed).Attributes(ctx, provs)
+	require.NoError(t, err)
+	require.Equal(t, map[address.Address][]string{
+		provs[0]: {"iso-14001", "renewable-energy"},
+		provs[1]: {"iso-14001"},
+		provs[2]: {"b-corp"},
+	}, attrs)
+}
+
+func TestSelect(t *testing.T) {
+	provs := testProviders(t, 4)
+	attrs := map[address.Address][]string{
+		provs[0]: {"iso-14001"},
+		provs[1]: {"renewable-energy"},
+		provs[2]: {"renewable-energy", "iso-14001"},
+	}
+
+	// Without a constraint, all providers are selected in order
+	selected, excluded := Constraint{}.Select(provs, attrs)
+	require.Equal(t, provs, selected)
+	require.Empty(t, excluded)
+
+	// Preferred attributes only change the order
+	c := Constraint{Prefer: []string{"Renewable-Energy", "iso-14001"}}
+	selected, excluded = c.Select(provs, attrs)
+	require.Equal(t, []address.Address{provs[2], provs[0], provs[1], provs[3]}, selected)
+	require.Empty(t, excluded)
+	require.Equal(t, 2, c.Score(attrs[provs[2]]))
+	require.Equal(t, 0, c.Score(nil))
+
+	// Providers without the required attributes are excluded
+	c = Constraint{Require: []string{"renewable-energy"}, Prefer: []string{"iso-14001"}}
+	selected, excluded = c.Select(provs, attrs)
+	require.Equal(t, []address.Address{provs[2], provs[1]}, selected)
+	require.Len(t, excluded, 2)
+	require.Equal(t, "missing required attributes renewable-energy", excluded[provs[3]])
+	require.Equal(t, []string{"iso-14001"}, Missing(c.Prefer, attrs[provs[1]]))
+}
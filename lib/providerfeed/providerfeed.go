@@ -0,0 +1,103 @@
+// Package providerfeed fetches feeds of information about storage providers
+// (eg their regions, or the attributes that have been attested for them):
+// http endpoints that serve a JSON object mapping provider addresses to a
+// value, eg
+//
+//	{"f01000": "us-east", "f01001": "eu-west"}
+//
+// The feed is cached, and re-fetched once the cache is older than the TTL.
+package providerfeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("providerfeed")
+
+type Feed struct {
+	// What the feed is, for errors and logs (eg "region feed")
+	name   string
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	lk        sync.Mutex
+	values    map[address.Address]json.RawMessage
+	fetchedAt time.Time
+}
+
+func New(name string, url string, ttl time.Duration) *Feed {
+	return &Feed{
+		name:   name,
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// URL returns the url of the feed
+func (f *Feed) URL() string {
+	return f.url
+}
+
+// Values returns the JSON value of each provider in the feed. If the cache
+// has expired the feed is fetched again, and if that fails the values from
+// the last fetch are returned. The returned map must not be modified.
+func (f *Feed) Values(ctx context.Context) (map[address.Address]json.RawMessage, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	if f.values == nil || time.Since(f.fetchedAt) > f.ttl {
+		values, err := f.fetch(ctx)
+		if err != nil {
+			// Fall back to the values from the last fetch, if there are any
+			if f.values == nil {
+				return nil, err
+			}
+			log.Warnw("fetching "+f.name+", using cached values", "url", f.url, "err", err)
+		} else {
+			f.values = values
+			f.fetchedAt = time.Now()
+		}
+	}
+	return f.values, nil
+}
+
+func (f *Feed) fetch(ctx context.Context) (map[address.Address]json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s request: %w", f.name, err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s %s: %w", f.name, f.url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s %s: unexpected status %d", f.name, f.url, resp.StatusCode)
+	}
+	var feed map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("parsing %s %s: %w", f.name, f.url, err)
+	}
+
+	values := make(map[address.Address]json.RawMessage, len(feed))
+	for p, v := range feed {
+		addr, err := address.NewFromString(p)
+		if err != nil {
+			log.Debugw("skipping invalid provider address in "+f.name, "url", f.url, "provider", p, "err", err)
+			continue
+		}
+		values[addr] = v
+	}
+	return values, nil
+}
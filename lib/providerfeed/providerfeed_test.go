@@ -0,0 +1,61 @@
+package providerfeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeed(t *testing.T) {
+	ctx := context.Background()
+	var fetches int32
+	var fail int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"f01000": "us-east", "f01001": ["iso-14001"], "not-an-address": "ap-south"}`)
+	}))
+	defer srv.Close()
+
+	prov1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	prov2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	// Invalid provider addresses are skipped
+	feed := New("test feed", srv.URL, time.Hour)
+	values, err := feed.Values(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[address.Address]json.RawMessage{
+		prov1: json.RawMessage(`"us-east"`),
+		prov2: json.RawMessage(`["iso-14001"]`),
+	}, values)
+
+	// The feed is cached until the TTL expires
+	_, err = feed.Values(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fetches))
+
+	// If the feed can't be fetched, the cached values are used
+	atomic.StoreInt32(&fail, 1)
+	feed.ttl = 0
+	values, err = feed.Values(ctx)
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	require.EqualValues(t, 2, atomic.LoadInt32(&fetches))
+
+	// Unless there aren't any
+	_, err = New("test feed", srv.URL, time.Hour).Values(ctx)
+	require.Error(t, err)
+}
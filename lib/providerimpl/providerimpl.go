@@ -0,0 +1,157 @@
+// Package providerimpl identifies the software that a storage provider runs
+// (Boost, Curio or the legacy lotus-miner markets) from the agent version
+// and protocols that its libp2p host reports, so that a client can adapt
+// its deal proposals to the implementation.
+//
+// The implementations accept deals differently: Curio seals deals in
+// batches through its own pipeline and checks the deal data before it
+// replies to a proposal, so it needs a later start epoch and more time to
+// reply than Boost. The legacy lotus-miner markets only speak the go-fil-
+// markets deal protocols, which the boost client doesn't support.
+package providerimpl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// Software is the storage provider software that handles deals
+type Software string
+
+const (
+	Boost        Software = "boost"
+	Curio        Software = "curio"
+	LotusMarkets Software = "lotus-markets"
+	Unknown      Software = "unknown"
+)
+
+// The deal protocols that identify the implementation if its agent version
+// doesn't
+const (
+	dealProtocolv110 = "/fil/storage/mk/1.1.0"
+	dealProtocolv120 = "/fil/storage/mk/1.2.0"
+	// Deal protocol v1.3 is only supported by boost
+	dealProtocolv130 = "/fil/storage/mk/1.3.0"
+)
+
+// Info is the software that a storage provider runs
+type Info struct {
+	Software Software `json:"software"`
+	// The version that the provider reports, eg 1.23.0+mainnet+git.1a2b3c,
+	// or empty if it doesn't report one
+	Version string `json:"version,omitempty"`
+}
+
+func (i Info) String() string {
+	if i.Version == "" {
+		return string(i.Software)
+	}
+	return fmt.Sprintf("%s %s", i.Software, i.Version)
+}
+
+// Detect identifies the provider's software from the agent version and
+// protocols of its libp2p host
+func Detect(agent string, protocols []string) Info {
+	name, version := splitAgent(agent)
+	switch name {
+	case "boost", "boostd":
+		return Info{Software: Boost, Version: version}
+	case "curio":
+		return Info{Software: Curio, Version: version}
+	case "lotus", "lotus-miner":
+		// A lotus-miner that uses boost for deals doesn't handle deals on
+		// its own libp2p host, so this is the legacy markets subsystem
+		return Info{Software: LotusMarkets, Version: version}
+	}
+
+	// The agent is not one we know (eg the provider has set a custom
+	// agent), so fall back to the deal protocols
+	supports := make(map[string]bool, len(protocols))
+	for _, p := range protocols {
+		supports[p] = true
+	}
+	switch {
+	case supports[dealProtocolv130]:
+		return Info{Software: Boost}
+	case supports[dealProtocolv110] && !supports[dealProtocolv120]:
+		return Info{Software: LotusMarkets}
+	}
+	return Info{Software: Unknown}
+}
+
+// splitAgent splits an agent version such as "curio-v1.23.0" or
+// "github.com/filecoin-project/boost@v1.7.0" into the lower case name of
+// the software and its version
+func splitAgent(agent string) (string, string) {
+	agent = strings.ToLower(strings.TrimSpace(agent))
+	var name, version string
+	if i := strings.LastIndex(agent, "@"); i >= 0 {
+		// The default libp2p agent is the go module path and version
+		name, version = agent[:i], agent[i+1:]
+		if j := strings.LastIndex(name, "/"); j >= 0 {
+			name = name[j+1:]
+		}
+	} else if i := strings.IndexAny(agent, "-/ "); i >= 0 {
+		name, version = agent[:i], agent[i+1:]
+		// lotus-miner reports eg "lotus-miner-1.23.0"
+		if name == "lotus" && strings.HasPrefix(version, "miner-") {
+			name, version = "lotus-miner", strings.TrimPrefix(version, "miner-")
+		}
+	} else {
+		name = agent
+	}
+	if version == "(devel)" {
+		version = ""
+	}
+	return name, strings.TrimPrefix(version, "v")
+}
+
+// Heuristics are how a client adapts its deal proposals to the provider's
+// implementation, when the user hasn't set them explicitly
+type Heuristics struct {
+	// The number of epochs after the chain head at which deals start
+	StartEpochDelay abi.ChainEpoch
+	// The maximum number of times to try to send a proposal
+	ProposalAttempts int
+	// The time to wait before retrying a proposal, multiplied by the number
+	// of attempts so far
+	ProposalRetryInterval time.Duration
+	// The time to wait for the provider to reply to a proposal
+	ProposalTimeout time.Duration
+}
+
+// StartEpoch returns the start epoch for a deal made at the chain head
+func (h Heuristics) StartEpoch(head abi.ChainEpoch) abi.ChainEpoch {
+	return head + h.StartEpochDelay
+}
+
+var defaultHeuristics = Heuristics{
+	StartEpochDelay:       5760, // 2 days
+	ProposalAttempts:      3,
+	ProposalRetryInterval: 10 * time.Second,
+	ProposalTimeout:       time.Minute,
+}
+
+// HeuristicsFor returns the heuristics for the provider's implementation
+func HeuristicsFor(i Info) Heuristics {
+	h := defaultHeuristics
+	if i.Software == Curio {
+		// Curio batches sectors through its sealing pipeline, and fetches
+		// the head of the deal data before it replies to a proposal
+		h.StartEpochDelay = 8640 // 3 days
+		h.ProposalAttempts = 5
+		h.ProposalRetryInterval = 30 * time.Second
+		h.ProposalTimeout = 3 * time.Minute
+	}
+	return h
+}
+
+// SupportsTransferOptions returns false if the implementation is known
+// not to support the transfer options of deal protocol v1.3 (alternate urls
+// and a client retry policy)
+func (i Info) SupportsTransferOptions() bool {
+	return i.Software != Curio && i.Software != LotusMarkets
+}
@@ -0,0 +1,53 @@
+package providerimpl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	tcs := []struct {
+		agent     string
+		protocols []string
+		expected  Info
+	}{
+		{agent: "curio-v1.23.1+mainnet+git_1a2b3c", expected: Info{Software: Curio, Version: "1.23.1+mainnet+git_1a2b3c"}},
+		{agent: "Curio/1.24.0", expected: Info{Software: Curio, Version: "1.24.0"}},
+		{agent: "boost-1.7.0+git.abcdef", expected: Info{Software: Boost, Version: "1.7.0+git.abcdef"}},
+		{agent: "github.com/filecoin-project/boost@v1.7.0", expected: Info{Software: Boost, Version: "1.7.0"}},
+		{agent: "github.com/filecoin-project/boost@(devel)", expected: Info{Software: Boost}},
+		{agent: "lotus-1.20.0+mainnet+git.123456", expected: Info{Software: LotusMarkets, Version: "1.20.0+mainnet+git.123456"}},
+		{agent: "lotus-miner-1.20.0", expected: Info{Software: LotusMarkets, Version: "1.20.0"}},
+		// An unknown agent falls back to the deal protocols
+		{agent: "my-sp", protocols: []string{dealProtocolv120, dealProtocolv130}, expected: Info{Software: Boost}},
+		{agent: "", protocols: []string{dealProtocolv110}, expected: Info{Software: LotusMarkets}},
+		{agent: "my-sp", protocols: []string{dealProtocolv110, dealProtocolv120}, expected: Info{Software: Unknown}},
+		{agent: "", expected: Info{Software: Unknown}},
+	}
+	for _, tc := range tcs {
+		require.Equal(t, tc.expected, Detect(tc.agent, tc.protocols), tc.agent)
+	}
+
+	require.Equal(t, "curio 1.23.1", Info{Software: Curio, Version: "1.23.1"}.String())
+	require.Equal(t, "unknown", Info{Software: Unknown}.String())
+}
+
+func TestHeuristics(t *testing.T) {
+	boost := HeuristicsFor(Info{Software: Boost})
+	require.Equal(t, defaultHeuristics, boost)
+	require.Equal(t, defaultHeuristics, HeuristicsFor(Info{Software: Unknown}))
+	require.EqualValues(t, 5860, boost.StartEpoch(100))
+
+	// Curio needs a later start epoch and more time to reply to proposals
+	curio := HeuristicsFor(Info{Software: Curio})
+	require.Greater(t, curio.StartEpochDelay, boost.StartEpochDelay)
+	require.Greater(t, curio.ProposalAttempts, boost.ProposalAttempts)
+	require.Greater(t, curio.ProposalTimeout, boost.ProposalTimeout)
+	require.Equal(t, 30*time.Second, curio.ProposalRetryInterval)
+
+	require.True(t, Info{Software: Boost}.SupportsTransferOptions())
+	require.True(t, Info{Software: Unknown}.SupportsTransferOptions())
+	require.False(t, Info{Software: Curio}.SupportsTransferOptions())
+}
@@ -0,0 +1,145 @@
+// Package regions looks up the geographic regions of storage providers, so
+// that a client can spread the replicas of its data across regions.
+//
+// A provider's region can come from the client's own config, from a
+// reputation feed, or from the provider itself (a provider declares its
+// region in its config, and serves it over libp2p). Regions are free-form
+// labels such as "us-east" or "eu-west"; they are compared case-insensitively.
+package regions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/providerfeed"
+	"github.com/filecoin-project/go-address"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("regions")
+
+// Resolver looks up the regions of providers
+type Resolver interface {
+	// Regions returns the region of each of the providers whose region is
+	// known. Providers with an unknown region are left out of the result.
+	Regions(ctx context.Context, providers []address.Address) (map[address.Address]string, error)
+}
+
+// ResolverFunc is a function that implements Resolver
+type ResolverFunc func(ctx context.Context, providers []address.Address) (map[address.Address]string, error)
+
+func (f ResolverFunc) Regions(ctx context.Context, providers []address.Address) (map[address.Address]string, error) {
+	return f(ctx, providers)
+}
+
+// Normalize returns the region in the form in which regions are compared
+func Normalize(region string) string {
+	return strings.ToLower(strings.TrimSpace(region))
+}
+
+// Static is a fixed set of provider regions, eg from the client's config
+type Static map[address.Address]string
+
+func (s Static) Regions(_ context.Context, providers []address.Address) (map[address.Address]string, error) {
+	regions := make(map[address.Address]string)
+	for _, p := range providers {
+		if r, ok := s[p]; ok {
+			regions[p] = r
+		}
+	}
+	return regions, nil
+}
+
+// ParseStatic parses provider regions in the format <provider>=<region>,
+// eg f01000=us-east
+func ParseStatic(specs []string) (Static, error) {
+	s := make(Static, len(specs))
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("provider region %s is not in the format <provider>=<region>", spec)
+		}
+		addr, err := address.NewFromString(spec[:i])
+		if err != nil {
+			return nil, fmt.Errorf("parsing provider address in %s: %w", spec, err)
+		}
+		region := Normalize(spec[i+1:])
+		if region == "" {
+			return nil, fmt.Errorf("provider region %s has an empty region", spec)
+		}
+		s[addr] = region
+	}
+	return s, nil
+}
+
+// Feed fetches provider regions from a reputation feed: an http endpoint
+// that serves a JSON object mapping provider addresses to regions, eg
+//
+//	{"f01000": "us-east", "f01001": "eu-west"}
+//
+// The feed is cached, and re-fetched once the cache is older than the TTL.
+type Feed struct {
+	feed *providerfeed.Feed
+}
+
+func NewFeed(url string, ttl time.Duration) *Feed {
+	return &Feed{feed: providerfeed.New("region feed", url, ttl)}
+}
+
+func (f *Feed) Regions(ctx context.Context, providers []address.Address) (map[address.Address]string, error) {
+	values, err := f.feed.Values(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make(map[address.Address]string)
+	for _, p := range providers {
+		v, ok := values[p]
+		if !ok {
+			continue
+		}
+		var r string
+		if err := json.Unmarshal(v, &r); err != nil {
+			log.Debugw("skipping invalid region in region feed", "url", f.feed.URL(), "provider", p, "err", err)
+			continue
+		}
+		if r = Normalize(r); r != "" {
+			regions[p] = r
+		}
+	}
+	return regions, nil
+}
+
+// Chain returns a resolver that looks up the region of each provider with
+// each resolver in turn, until one of them knows it. So the first resolver
+// takes precedence (eg regions from config override those from a feed). If
+// a resolver fails, the error is logged and the next resolver is tried.
+func Chain(rs ...Resolver) Resolver {
+	return ResolverFunc(func(ctx context.Context, providers []address.Address) (map[address.Address]string, error) {
+		regions := make(map[address.Address]string)
+		remaining := providers
+		for _, r := range rs {
+			if len(remaining) == 0 {
+				break
+			}
+			found, err := r.Regions(ctx, remaining)
+			if err != nil {
+				log.Warnw("looking up provider regions", "err", err)
+				continue
+			}
+			var unknown []address.Address
+			for _, p := range remaining {
+				if region := Normalize(found[p]); region != "" {
+					regions[p] = region
+				} else {
+					unknown = append(unknown, p)
+				}
+			}
+			remaining = unknown
+		}
+		return regions, nil
+	})
+}
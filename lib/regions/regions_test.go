@@ -0,0 +1,65 @@
+package regions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatic(t *testing.T) {
+	s, err := ParseStatic([]string{"f01000=US-East", "f01001= eu-west "})
+	require.NoError(t, err)
+	p1, _ := address.NewIDAddress(1000)
+	p2, _ := address.NewIDAddress(1001)
+	require.Equal(t, Static{p1: "us-east", p2: "eu-west"}, s)
+
+	for _, spec := range []string{"f01000", "abc=us-east", "f01000="} {
+		_, err := ParseStatic([]string{spec})
+		require.Error(t, err, spec)
+	}
+}
+
+func TestFeedAndChain(t *testing.T) {
+	ctx := context.Background()
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		fmt.Fprint(w, `{"f01000": "us-east", "f01001": "EU-West", "not-an-address": "ap-south"}`)
+	}))
+	defer srv.Close()
+
+	var provs []address.Address
+	for i := 1000; i < 1003; i++ {
+		p, err := address.NewIDAddress(uint64(i))
+		require.NoError(t, err)
+		provs = append(provs, p)
+	}
+
+	feed := NewFeed(srv.URL, time.Hour)
+	regions, err := feed.Regions(ctx, provs)
+	require.NoError(t, err)
+	require.Equal(t, map[address.Address]string{provs[0]: "us-east", provs[1]: "eu-west"}, regions)
+
+	// The feed is cached until the TTL expires
+	_, err = feed.Regions(ctx, provs)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&fetches))
+
+	// Config regions take precedence over the feed, and resolvers that fail
+	// are skipped
+	failing := ResolverFunc(func(context.Context, []address.Address) (map[address.Address]string, error) {
+		return nil, fmt.Errorf("unreachable")
+	})
+	self := Static{provs[2]: "ap-south", provs[0]: "ignored"}
+	r := Chain(Static{provs[0]: "us-west"}, failing, feed, self)
+	regions, err = r.Regions(ctx, provs)
+	require.NoError(t, err)
+	require.Equal(t, map[address.Address]string{provs[0]: "us-west", provs[1]: "eu-west", provs[2]: "ap-south"}, regions)
+}
@@ -0,0 +1,491 @@
+// Package repobackup writes the state of a boost repo into a single
+// versioned archive, and extracts an archive after checking its integrity.
+//
+// The archive is a gzipped tar file with the same layout as the repo (the
+// metadata datastore is in the "metadata" file, in the backupds format). Its
+// last entry is a manifest with the size and sha256 hash of every other
+// entry.
+package repobackup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The version of the archive format that is written. Archives with a
+// later version can't be extracted.
+const Version = 1
+
+const manifestName = "MANIFEST.json"
+
+var ErrCorrupt = errors.New("backup archive is corrupt")
+
+// The names of the entries in the archive
+const (
+	MetadataName = "metadata"
+	DBName       = "boost.db"
+	LogsDBName   = "boost.logs.db"
+)
+
+// The files and directories in the repo that are copied into the archive
+var (
+	repoFiles = []string{"config.toml", "storage.json", "token"}
+	repoDirs  = []string{"config", "keystore"}
+)
+
+// DagstoreDirs are the directories in the repo with the dagstore state: the
+// shard datastore, and the piece indexes. They are copied into the archive
+// with all their subdirectories.
+var DagstoreDirs = []string{"dagstore/datastore", "dagstore/index"}
+
+// Manifest describes the contents of an archive
+type Manifest struct {
+	Version   int
+	CreatedAt time.Time
+	// Whether the backup was taken while boostd was running
+	Online  bool
+	Entries []Entry
+}
+
+// Entry is a file in an archive
+type Entry struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// Backuper writes a backup of a datastore (eg backupds.Datastore)
+type Backuper interface {
+	Backup(ctx context.Context, out io.Writer) error
+}
+
+// Source is the repo that a backup is taken from
+type Source struct {
+	// The path to the repo
+	RepoPath string
+	// The metadata datastore
+	Metadata Backuper
+	// The boost database (with deals, and funds and storage tags) and the
+	// deal logs database
+	DB     *sql.DB
+	LogsDB *sql.DB
+	// Whether boostd is running
+	Online bool
+}
+
+// CreateFile writes a backup of the repo to a new archive file at the path.
+// The archive only appears at the path once it is complete.
+func CreateFile(ctx context.Context, fpath string, src Source) (*Manifest, error) {
+	if _, err := os.Stat(fpath); err == nil {
+		return nil, fmt.Errorf("backup file %s already exists", fpath)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(fpath), ".boost-backup-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+	tmpPath := filepath.Join(tmpDir, filepath.Base(fpath))
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating backup file: %w", err)
+	}
+	m, err := Create(ctx, out, tmpDir, src)
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return nil, fmt.Errorf("syncing backup file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("closing backup file: %w", err)
+	}
+	if err := os.Rename(tmpPath, fpath); err != nil {
+		return nil, fmt.Errorf("moving backup file into place: %w", err)
+	}
+	return m, nil
+}
+
+// Create writes a backup of the repo to out. The databases are snapshotted
+// into tmpDir before they are written to the archive, so that a consistent
+// copy is taken while boostd is running.
+func Create(ctx context.Context, out io.Writer, tmpDir string, src Source) (*Manifest, error) {
+	w := NewWriter(out, src.Online)
+
+	metaPath := filepath.Join(tmpDir, MetadataName)
+	if err := backupMetadata(ctx, src.Metadata, metaPath); err != nil {
+		return nil, fmt.Errorf("backing up metadata datastore: %w", err)
+	}
+	if err := w.AddFile(MetadataName, metaPath); err != nil {
+		return nil, err
+	}
+
+	dbs := []struct {
+		name string
+		db   *sql.DB
+	}{{DBName, src.DB}, {LogsDBName, src.LogsDB}}
+	for _, d := range dbs {
+		dbPath := filepath.Join(tmpDir, d.name)
+		if err := SnapshotDB(ctx, d.db, dbPath); err != nil {
+			return nil, fmt.Errorf("backing up %s: %w", d.name, err)
+		}
+		if err := w.AddFile(d.name, dbPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range repoFiles {
+		err := w.AddFile(name, filepath.Join(src.RepoPath, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	for _, name := range repoDirs {
+		if err := w.AddDir(name, filepath.Join(src.RepoPath, name)); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range DagstoreDirs {
+		if err := w.AddTree(name, filepath.Join(src.RepoPath, filepath.FromSlash(name))); err != nil {
+			return nil, fmt.Errorf("backing up %s: %w", name, err)
+		}
+	}
+
+	return w.Close()
+}
+
+func backupMetadata(ctx context.Context, b Backuper, fpath string) error {
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := b.Backup(ctx, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SnapshotDB writes a consistent copy of the sqlite database to a new file
+// at the path. The database may be in use while the copy is taken.
+func SnapshotDB(ctx context.Context, db *sql.DB, fpath string) error {
+	_, err := db.ExecContext(ctx, "VACUUM INTO ?", fpath)
+	return err
+}
+
+// CheckDB runs an integrity check on the sqlite database at the path
+func CheckDB(ctx context.Context, fpath string) error {
+	db, err := sql.Open("sqlite3", "file:"+fpath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close() //nolint:errcheck
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%w: checking %s: %s", ErrCorrupt, filepath.Base(fpath), err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var problems []string
+	for rows.Next() {
+		var res string
+		if err := rows.Scan(&res); err != nil {
+			return err
+		}
+		if res != "ok" {
+			problems = append(problems, res)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s failed integrity check: %s", ErrCorrupt, filepath.Base(fpath), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Writer writes entries to an archive
+type Writer struct {
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+func NewWriter(out io.Writer, online bool) *Writer {
+	gz := gzip.NewWriter(out)
+	return &Writer{
+		gz: gz,
+		tw: tar.NewWriter(gz),
+		manifest: Manifest{
+			Version:   Version,
+			CreatedAt: time.Now(),
+			Online:    online,
+		},
+	}
+}
+
+// Add writes an entry with the given size, read from r
+func (w *Writer) Add(name string, mode os.FileMode, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		ModTime:  w.manifest.CreatedAt,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing %s to archive: %w", name, err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w.tw, h), r)
+	if err != nil {
+		return fmt.Errorf("writing %s to archive: %w", name, err)
+	}
+	if n != size {
+		return fmt.Errorf("writing %s to archive: expected %d bytes but read %d", name, size, n)
+	}
+	w.manifest.Entries = append(w.manifest.Entries, Entry{Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// AddFile writes the file at the path (following symlinks) as an entry
+func (w *Writer) AddFile(name string, fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", fpath)
+	}
+	return w.Add(name, st.Mode(), st.Size(), f)
+}
+
+// AddDir writes each file in the directory as an entry under the name.
+// Nothing is written if the directory doesn't exist.
+func (w *Writer) AddDir(name string, dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if err := w.AddFile(path.Join(name, f.Name()), filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTree writes each file in the directory and its subdirectories as an
+// entry under the name. Nothing is written if the directory doesn't exist.
+//
+// The files may be written to while boostd is running. Each file is copied
+// up to the size it had when it was opened, and a file that is removed
+// before it is opened is skipped. The dagstore's leveldb datastore only
+// appends to its files, and recovers a copy whose tables were compacted
+// away when it is opened.
+func (w *Writer) AddTree(name string, dir string) error {
+	err := filepath.WalkDir(dir, func(fpath string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		err = w.addFileSnapshot(path.Join(name, filepath.ToSlash(rel)), fpath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (w *Writer) addFileSnapshot(name string, fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", fpath)
+	}
+	return w.Add(name, st.Mode(), st.Size(), io.LimitReader(f, st.Size()))
+}
+
+// Close writes the manifest and finishes the archive
+func (w *Writer) Close() (*Manifest, error) {
+	bz, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestName,
+		Mode:     0644,
+		Size:     int64(len(bz)),
+		ModTime:  w.manifest.CreatedAt,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err := w.tw.Write(bz); err != nil {
+		return nil, err
+	}
+	if err := w.tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := w.gz.Close(); err != nil {
+		return nil, err
+	}
+	m := w.manifest
+	return &m, nil
+}
+
+// Extract writes the entries in the archive to the directory, and checks
+// them against the archive's manifest. An error wrapping ErrCorrupt is
+// returned if the archive is incomplete or an entry has been modified.
+func Extract(in io.Reader, dir string) (*Manifest, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	defer gz.Close() //nolint:errcheck
+
+	var manifest *Manifest
+	extracted := make(map[string]Entry)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+		}
+		if manifest != nil {
+			return nil, fmt.Errorf("%w: entry %s after manifest", ErrCorrupt, hdr.Name)
+		}
+
+		if hdr.Name == manifestName {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("%w: reading manifest: %s", ErrCorrupt, err)
+			}
+			continue
+		}
+
+		e, err := extractEntry(tr, hdr, dir)
+		if err != nil {
+			return nil, err
+		}
+		extracted[e.Name] = e
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: missing manifest", ErrCorrupt)
+	}
+	if manifest.Version < 1 || manifest.Version > Version {
+		return nil, fmt.Errorf("unsupported backup archive version %d (this version of boost supports up to version %d)",
+			manifest.Version, Version)
+	}
+	for _, e := range manifest.Entries {
+		got, ok := extracted[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing %s", ErrCorrupt, e.Name)
+		}
+		if got != e {
+			return nil, fmt.Errorf("%w: %s does not match the manifest", ErrCorrupt, e.Name)
+		}
+		delete(extracted, e.Name)
+	}
+	if len(extracted) > 0 {
+		var names []string
+		for name := range extracted {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w: %s not in the manifest", ErrCorrupt, strings.Join(names, ", "))
+	}
+	return manifest, nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dir string) (Entry, error) {
+	if hdr.Typeflag != tar.TypeReg {
+		return Entry{}, fmt.Errorf("%w: %s is not a regular file", ErrCorrupt, hdr.Name)
+	}
+	name := path.Clean(hdr.Name)
+	if name != hdr.Name || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return Entry{}, fmt.Errorf("%w: invalid entry name %s", ErrCorrupt, hdr.Name)
+	}
+
+	fpath := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return Entry{}, err
+	}
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), tr)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: reading %s: %s", ErrCorrupt, name, err)
+	}
+	if err := f.Close(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{Name: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
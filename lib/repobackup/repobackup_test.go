@@ -0,0 +1,147 @@
+package repobackup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/stretchr/testify/require"
+)
+
+type testMetadata string
+
+func (m testMetadata) Backup(_ context.Context, out io.Writer) error {
+	_, err := io.WriteString(out, string(m))
+	return err
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+
+	repo := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "config.toml"), []byte("[Dealmaking]"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "token"), []byte("secret"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(repo, "keystore"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "keystore", "key"), []byte("key"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "dagstore", "datastore"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "dagstore", "datastore", "000001.ldb"), []byte("shards"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "dagstore", "index"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "dagstore", "index", "piece.full.idx"), []byte("index"), 0644))
+
+	mainDB, err := db.SqlDB(filepath.Join(repo, DBName))
+	require.NoError(t, err)
+	defer mainDB.Close() //nolint:errcheck
+	logsDB, err := db.SqlDB(filepath.Join(repo, LogsDBName))
+	require.NoError(t, err)
+	defer logsDB.Close() //nolint:errcheck
+	require.NoError(t, db.CreateAllBoostTables(ctx, mainDB, logsDB))
+	_, err = mainDB.ExecContext(ctx, "INSERT INTO FundsTagged (DealUUID, CreatedAt, Collateral, PubMsg) VALUES ('deal', 0, '1', '2')")
+	require.NoError(t, err)
+
+	fpath := filepath.Join(t.TempDir(), "backup.tar.gz")
+	m, err := CreateFile(ctx, fpath, Source{
+		RepoPath: repo,
+		Metadata: testMetadata("metadata"),
+		DB:       mainDB,
+		LogsDB:   logsDB,
+		Online:   true,
+	})
+	require.NoError(t, err)
+	require.True(t, m.Online)
+	var names []string
+	for _, e := range m.Entries {
+		names = append(names, e.Name)
+	}
+	require.Equal(t, []string{MetadataName, DBName, LogsDBName, "config.toml", "token", "keystore/key",
+		"dagstore/datastore/000001.ldb", "dagstore/index/piece.full.idx"}, names)
+
+	// The backup file can't be overwritten
+	_, err = CreateFile(ctx, fpath, Source{RepoPath: repo, Metadata: testMetadata(""), DB: mainDB, LogsDB: logsDB})
+	require.Error(t, err)
+
+	archive, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+
+	out := t.TempDir()
+	extracted, err := Extract(bytes.NewReader(archive), out)
+	require.NoError(t, err)
+	require.Equal(t, m.Entries, extracted.Entries)
+
+	bz, err := os.ReadFile(filepath.Join(out, MetadataName))
+	require.NoError(t, err)
+	require.Equal(t, "metadata", string(bz))
+	st, err := os.Stat(filepath.Join(out, "keystore", "key"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), st.Mode().Perm())
+	bz, err = os.ReadFile(filepath.Join(out, "dagstore", "index", "piece.full.idx"))
+	require.NoError(t, err)
+	require.Equal(t, "index", string(bz))
+
+	require.NoError(t, CheckDB(ctx, filepath.Join(out, DBName)))
+	restored, err := db.SqlDB(filepath.Join(out, DBName))
+	require.NoError(t, err)
+	defer restored.Close() //nolint:errcheck
+	var count int
+	require.NoError(t, restored.QueryRowContext(ctx, "SELECT COUNT(*) FROM FundsTagged").Scan(&count))
+	require.Equal(t, 1, count)
+
+	// A truncated archive is rejected
+	_, err = Extract(bytes.NewReader(archive[:len(archive)/2]), t.TempDir())
+	require.ErrorIs(t, err, ErrCorrupt)
+
+	// A database that isn't sqlite fails the integrity check
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, bytes.Repeat([]byte("x"), 4096), 0644))
+	require.ErrorIs(t, CheckDB(ctx, garbage), ErrCorrupt)
+}
+
+func TestExtractChecksManifest(t *testing.T) {
+	write := func(fn func(w *Writer)) []byte {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, false)
+		fn(w)
+		_, err := w.Close()
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	add := func(w *Writer, name, content string) {
+		require.NoError(t, w.Add(name, 0644, int64(len(content)), strings.NewReader(content)))
+	}
+
+	// An entry that was modified after the manifest was written
+	archive := write(func(w *Writer) {
+		add(w, "a", "aaa")
+		w.manifest.Entries[0].SHA256 = "0000"
+	})
+	_, err := Extract(bytes.NewReader(archive), t.TempDir())
+	require.ErrorIs(t, err, ErrCorrupt)
+
+	// An entry that is missing
+	archive = write(func(w *Writer) {
+		add(w, "a", "aaa")
+		w.manifest.Entries = append(w.manifest.Entries, Entry{Name: "b", Size: 1})
+	})
+	_, err = Extract(bytes.NewReader(archive), t.TempDir())
+	require.ErrorIs(t, err, ErrCorrupt)
+
+	// An entry outside the directory
+	archive = write(func(w *Writer) {
+		add(w, "../a", "aaa")
+	})
+	_, err = Extract(bytes.NewReader(archive), t.TempDir())
+	require.ErrorIs(t, err, ErrCorrupt)
+
+	// An archive written by a later version
+	archive = write(func(w *Writer) {
+		add(w, "a", "aaa")
+		w.manifest.Version = Version + 1
+	})
+	_, err = Extract(bytes.NewReader(archive), t.TempDir())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported")
+}
@@ -0,0 +1,429 @@
+// Package retrievalacl controls which clients may retrieve data from the
+// provider, and how fast data is served to each client. The same ACL is
+// applied to retrievals over graphsync, booster-http and booster-bitswap.
+//
+// A client is identified by its peer ID (for libp2p retrievals) and by its
+// IP address. ACL entries are peer IDs, IP addresses or CIDR ranges. A client
+// that matches a deny entry is refused. If there are any allow entries, a
+// client must match one of them to retrieve data.
+package retrievalacl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/time/rate"
+)
+
+var log = logging.Logger("retrievalacl")
+
+// Limiters that haven't been used for this long are removed
+const limiterIdleTimeout = 10 * time.Minute
+
+// The smallest burst allowed by a bandwidth limit, so that a client with a
+// low limit can still be sent a whole block
+const minBurst = 1 << 20
+
+// Config is the ACL configuration
+type Config struct {
+	// Clients that may retrieve data. If empty, any client that is not
+	// denied may retrieve data.
+	Allow []string
+	// Clients that may not retrieve data. Takes precedence over Allow.
+	Deny []string
+	// The maximum rate at which data is served to each client, in bytes
+	// per second. Zero means unlimited.
+	ClientBandwidth uint64
+	// Clients with a different maximum rate than ClientBandwidth
+	BandwidthOverrides []BandwidthOverride
+}
+
+type BandwidthOverride struct {
+	// A peer ID, IP address or CIDR range
+	Client string
+	// Bytes per second (zero means unlimited)
+	Bandwidth uint64
+}
+
+// Client identifies the client of a retrieval. Either field may be empty.
+type Client struct {
+	Peer peer.ID
+	IP   net.IP
+}
+
+func (c Client) String() string {
+	switch {
+	case c.Peer != "" && c.IP != nil:
+		return c.Peer.String() + " (" + c.IP.String() + ")"
+	case c.Peer != "":
+		return c.Peer.String()
+	case c.IP != nil:
+		return c.IP.String()
+	default:
+		return "unknown client"
+	}
+}
+
+// key identifies the client for bandwidth limiting
+func (c Client) key() string {
+	if c.Peer != "" {
+		return c.Peer.String()
+	}
+	if c.IP != nil {
+		return c.IP.String()
+	}
+	return ""
+}
+
+// PeerClient gets the client for a libp2p peer, with the IP address of the
+// peer's connection to the host
+func PeerClient(h host.Host, p peer.ID) Client {
+	c := Client{Peer: p}
+	for _, conn := range h.Network().ConnsToPeer(p) {
+		if ip, err := manet.ToIP(conn.RemoteMultiaddr()); err == nil {
+			c.IP = ip
+			break
+		}
+	}
+	return c
+}
+
+// DeniedError is returned when a client may not retrieve data
+type DeniedError struct {
+	Client Client
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("retrieval by %s denied: %s", e.Client, e.Reason)
+}
+
+// matcher matches clients against a list of entries
+type matcher struct {
+	peers map[peer.ID]string
+	nets  []entryNet
+}
+
+type entryNet struct {
+	entry string
+	net   *net.IPNet
+}
+
+func newMatcher(entries []string) (*matcher, error) {
+	m := &matcher{peers: make(map[peer.ID]string)}
+	for _, e := range entries {
+		if err := m.add(e); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *matcher) add(entry string) error {
+	e := strings.TrimSpace(entry)
+	if _, ipnet, err := net.ParseCIDR(e); err == nil {
+		m.nets = append(m.nets, entryNet{entry: entry, net: ipnet})
+		return nil
+	}
+	if ip := net.ParseIP(e); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		m.nets = append(m.nets, entryNet{entry: entry, net: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+		return nil
+	}
+	p, err := peer.Decode(e)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a peer ID, IP address or CIDR range", entry)
+	}
+	m.peers[p] = entry
+	return nil
+}
+
+func (m *matcher) empty() bool {
+	return len(m.peers) == 0 && len(m.nets) == 0
+}
+
+// match returns the entry that the client matches. A peer ID entry matches
+// before an IP entry, and a narrower IP range before a wider one.
+func (m *matcher) match(c Client) (string, bool) {
+	if c.Peer != "" {
+		if e, ok := m.peers[c.Peer]; ok {
+			return e, true
+		}
+	}
+	if c.IP == nil {
+		return "", false
+	}
+	var best *entryNet
+	bestOnes := -1
+	for i, n := range m.nets {
+		if !n.net.Contains(c.IP) {
+			continue
+		}
+		if ones, _ := n.net.Mask.Size(); ones > bestOnes {
+			best = &m.nets[i]
+			bestOnes = ones
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.entry, true
+}
+
+type rules struct {
+	cfg       Config
+	allow     *matcher
+	deny      *matcher
+	overrides *matcher
+	// bandwidth by override entry
+	bandwidth map[string]uint64
+}
+
+func newRules(cfg Config) (*rules, error) {
+	allow, err := newMatcher(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("parsing allow list: %w", err)
+	}
+	deny, err := newMatcher(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("parsing deny list: %w", err)
+	}
+	overrides := &matcher{peers: make(map[peer.ID]string)}
+	bandwidth := make(map[string]uint64, len(cfg.BandwidthOverrides))
+	for _, o := range cfg.BandwidthOverrides {
+		if err := overrides.add(o.Client); err != nil {
+			return nil, fmt.Errorf("parsing bandwidth overrides: %w", err)
+		}
+		bandwidth[o.Client] = o.Bandwidth
+	}
+	return &rules{cfg: cfg, allow: allow, deny: deny, overrides: overrides, bandwidth: bandwidth}, nil
+}
+
+type clientLimiter struct {
+	client   Client
+	lim      *rate.Limiter
+	limit    uint64
+	lastUsed time.Time
+}
+
+// ACL checks clients against the configured allow and deny lists, and
+// limits the rate at which data is served to each client
+type ACL struct {
+	lk        sync.Mutex
+	rules     *rules
+	limiters  map[string]*clientLimiter
+	lastPrune time.Time
+}
+
+func New(cfg Config) (*ACL, error) {
+	r, err := newRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ACL{rules: r, limiters: make(map[string]*clientLimiter), lastPrune: time.Now()}, nil
+}
+
+// Config returns the current configuration
+func (a *ACL) Config() Config {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return a.rules.cfg
+}
+
+// Update replaces the configuration. Bandwidth limit changes take effect
+// immediately for retrievals that are in progress.
+func (a *ACL) Update(cfg Config) error {
+	r, err := newRules(cfg)
+	if err != nil {
+		return err
+	}
+
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	a.rules = r
+	for key, cl := range a.limiters {
+		limit := a.bandwidthForClient(cl.client)
+		if limit != cl.limit {
+			if limit == 0 {
+				delete(a.limiters, key)
+				continue
+			}
+			cl.limit = limit
+			cl.lim.SetLimit(rate.Limit(limit))
+			cl.lim.SetBurst(burst(limit))
+		}
+	}
+	return nil
+}
+
+// Check returns a *DeniedError if the client may not retrieve data
+func (a *ACL) Check(c Client) error {
+	a.lk.Lock()
+	r := a.rules
+	a.lk.Unlock()
+
+	if e, ok := r.deny.match(c); ok {
+		return &DeniedError{Client: c, Reason: "matches deny entry " + e}
+	}
+	if r.allow.empty() {
+		return nil
+	}
+	if _, ok := r.allow.match(c); !ok {
+		return &DeniedError{Client: c, Reason: "not in allow list"}
+	}
+	return nil
+}
+
+// BandwidthLimit returns the maximum rate at which data may be served to
+// the client, in bytes per second (zero means unlimited)
+func (a *ACL) BandwidthLimit(c Client) uint64 {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return a.bandwidthForClient(c)
+}
+
+func (a *ACL) bandwidthForClient(c Client) uint64 {
+	if e, ok := a.rules.overrides.match(c); ok {
+		return a.rules.bandwidth[e]
+	}
+	return a.rules.cfg.ClientBandwidth
+}
+
+func burst(limit uint64) int {
+	if limit < minBurst {
+		return minBurst
+	}
+	return int(limit)
+}
+
+// limiter returns the client's limiter, or nil if the client's bandwidth is
+// unlimited
+func (a *ACL) limiter(c Client) *rate.Limiter {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastPrune) > time.Minute {
+		for key, cl := range a.limiters {
+			if now.Sub(cl.lastUsed) > limiterIdleTimeout {
+				delete(a.limiters, key)
+			}
+		}
+		a.lastPrune = now
+	}
+
+	key := c.key()
+	if cl, ok := a.limiters[key]; ok {
+		cl.lastUsed = now
+		return cl.lim
+	}
+	limit := a.bandwidthForClient(c)
+	if limit == 0 {
+		return nil
+	}
+	cl := &clientLimiter{client: c, lim: rate.NewLimiter(rate.Limit(limit), burst(limit)), limit: limit, lastUsed: now}
+	a.limiters[key] = cl
+	return cl.lim
+}
+
+// Wait blocks until n bytes may be sent to the client
+func (a *ACL) Wait(ctx context.Context, c Client, n int) error {
+	lim := a.limiter(c)
+	if lim == nil {
+		return nil
+	}
+	for n > 0 {
+		k := n
+		if b := lim.Burst(); k > b {
+			k = b
+		}
+		if err := lim.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
+// Allow returns whether n bytes may be sent to the client now, for servers
+// that can't wait (if they may, the bytes are counted against the client's
+// limit)
+func (a *ACL) Allow(c Client, n int) bool {
+	lim := a.limiter(c)
+	if lim == nil {
+		return true
+	}
+	if b := lim.Burst(); n > b {
+		n = b
+	}
+	return lim.AllowN(time.Now(), n)
+}
+
+// Writer returns a writer that limits the rate at which data is written to
+// the client
+func (a *ACL) Writer(ctx context.Context, c Client, w io.Writer) io.Writer {
+	return &limitedWriter{ctx: ctx, acl: a, client: c, w: w}
+}
+
+type limitedWriter struct {
+	ctx    context.Context
+	acl    *ACL
+	client Client
+	w      io.Writer
+}
+
+func (w *limitedWriter) Write(bz []byte) (int, error) {
+	var written int
+	for len(bz) > 0 {
+		chunk := bz
+		if len(chunk) > minBurst {
+			chunk = chunk[:minBurst]
+		}
+		if err := w.acl.Wait(w.ctx, w.client, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		bz = bz[n:]
+	}
+	return written, nil
+}
+
+// Poll updates the ACL with the config returned by fetch every interval,
+// until the context is cancelled
+func (a *ACL) Poll(ctx context.Context, interval time.Duration, fetch func(context.Context) (*Config, error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			cfg, err := fetch(ctx)
+			if err != nil {
+				log.Warnw("fetching retrieval ACL", "err", err)
+				continue
+			}
+			if err := a.Update(*cfg); err != nil {
+				log.Warnw("updating retrieval ACL", "err", err)
+			}
+		}
+	}
+}
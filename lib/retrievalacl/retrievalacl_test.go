@@ -0,0 +1,112 @@
+package retrievalacl
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	peerA = "12D3KooWDqCNRAm6bJz8WYzUMHGMxzY3jrhiW2UMTpvazU5DLZNi"
+	peerB = "12D3KooWLJHqAmQ2ndCsEDjwvUq3AtDcnbpj3ETstRgYCmnwRtv9"
+)
+
+func client(t *testing.T, p string, ip string) Client {
+	var c Client
+	if p != "" {
+		id, err := peer.Decode(p)
+		require.NoError(t, err)
+		c.Peer = id
+	}
+	if ip != "" {
+		c.IP = net.ParseIP(ip)
+	}
+	return c
+}
+
+func TestCheck(t *testing.T) {
+	_, err := New(Config{Deny: []string{"not-a-client"}})
+	require.Error(t, err)
+
+	acl, err := New(Config{})
+	require.NoError(t, err)
+	require.NoError(t, acl.Check(client(t, peerA, "10.0.0.1")))
+	require.NoError(t, acl.Check(Client{}))
+
+	acl, err = New(Config{
+		Allow: []string{peerA, "10.0.0.0/8"},
+		Deny:  []string{"10.1.0.0/16", peerB},
+	})
+	require.NoError(t, err)
+
+	// Allowed by peer ID or IP address
+	require.NoError(t, acl.Check(client(t, peerA, "")))
+	require.NoError(t, acl.Check(client(t, "", "10.0.0.1")))
+
+	// Not in the allow list
+	var derr *DeniedError
+	require.ErrorAs(t, acl.Check(client(t, "", "192.168.0.1")), &derr)
+	require.Contains(t, derr.Error(), "not in allow list")
+
+	// Deny takes precedence over allow
+	require.ErrorAs(t, acl.Check(client(t, peerA, "10.1.2.3")), &derr)
+	require.Contains(t, derr.Error(), "10.1.0.0/16")
+	require.ErrorAs(t, acl.Check(client(t, peerB, "10.0.0.1")), &derr)
+
+	// Remove the deny list
+	require.NoError(t, acl.Update(Config{Allow: []string{peerA, "10.0.0.0/8"}}))
+	require.NoError(t, acl.Check(client(t, peerA, "10.1.2.3")))
+}
+
+func TestBandwidth(t *testing.T) {
+	acl, err := New(Config{
+		ClientBandwidth: 1000,
+		BandwidthOverrides: []BandwidthOverride{
+			{Client: "10.0.0.0/8", Bandwidth: 2000},
+			{Client: "10.1.0.0/16", Bandwidth: 0},
+			{Client: peerA, Bandwidth: 3000},
+		},
+	})
+	require.NoError(t, err)
+
+	require.EqualValues(t, 1000, acl.BandwidthLimit(client(t, peerB, "")))
+	require.EqualValues(t, 2000, acl.BandwidthLimit(client(t, peerB, "10.2.0.1")))
+	// The narrowest range applies
+	require.EqualValues(t, 0, acl.BandwidthLimit(client(t, peerB, "10.1.0.1")))
+	// A peer ID applies before an IP address
+	require.EqualValues(t, 3000, acl.BandwidthLimit(client(t, peerA, "10.1.0.1")))
+
+	// The burst allows a whole block to be sent immediately, but not the
+	// next one
+	c := client(t, peerB, "")
+	require.True(t, acl.Allow(c, minBurst))
+	require.False(t, acl.Allow(c, 1))
+
+	// Unlimited clients are always allowed
+	c = client(t, "", "10.1.0.1")
+	require.True(t, acl.Allow(c, minBurst))
+	require.True(t, acl.Allow(c, minBurst))
+
+	// Raising the limit takes effect immediately
+	require.NoError(t, acl.Update(Config{ClientBandwidth: 1 << 30}))
+	c = client(t, peerB, "")
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n, err := acl.Writer(ctx, c, &buf).Write(make([]byte, 3*minBurst))
+	require.NoError(t, err)
+	require.Equal(t, 3*minBurst, n)
+	require.Equal(t, 3*minBurst, buf.Len())
+
+	// Writes that exceed the limit wait until the context is done
+	require.NoError(t, acl.Update(Config{ClientBandwidth: 1}))
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = acl.Writer(ctx, c, &buf).Write(make([]byte, 2*minBurst))
+	require.Error(t, err)
+}
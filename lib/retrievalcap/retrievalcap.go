@@ -0,0 +1,380 @@
+// Package retrievalcap enforces a hard cap on the FIL the client spends on
+// retrievals, per retrieval and per day (UTC).
+//
+// Before data is requested from a provider, the provider's offer (its
+// retrieval ask) is checked against the caps: an offer that would take the
+// retrieval over a cap is refused. While data is received, each byte is
+// charged at the offered price, and if the provider reprices mid-retrieval
+// the remaining bytes are charged at the new price. As soon as the cost of
+// the retrieval would exceed a cap the retrieval is aborted.
+//
+// The day's spend is kept in a json file in the client repo, locked while it
+// is updated, so that it is shared by several client processes.
+package retrievalcap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/lockedfile"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Caps are the limits on retrieval spend. A zero cap means there is no limit.
+type Caps struct {
+	PerRetrieval abi.TokenAmount
+	PerDay       abi.TokenAmount
+}
+
+// ParseCaps parses the caps from FIL amounts, eg "0.1 FIL". An empty string
+// means there is no limit.
+func ParseCaps(perRetrieval string, perDay string) (Caps, error) {
+	var caps Caps
+	var err error
+	if caps.PerRetrieval, err = parseFIL(perRetrieval); err != nil {
+		return Caps{}, fmt.Errorf("parsing per-retrieval cap: %w", err)
+	}
+	if caps.PerDay, err = parseFIL(perDay); err != nil {
+		return Caps{}, fmt.Errorf("parsing daily cap: %w", err)
+	}
+	return caps, nil
+}
+
+func parseFIL(s string) (abi.TokenAmount, error) {
+	if s == "" {
+		return big.Zero(), nil
+	}
+	f, err := types.ParseFIL(s)
+	if err != nil {
+		return big.Zero(), err
+	}
+	if big.Cmp(abi.TokenAmount(f), big.Zero()) < 0 {
+		return big.Zero(), fmt.Errorf("%s is negative", s)
+	}
+	return abi.TokenAmount(f), nil
+}
+
+// Enabled is true if either cap is set
+func (c Caps) Enabled() bool {
+	return isSet(c.PerRetrieval) || isSet(c.PerDay)
+}
+
+func isSet(a abi.TokenAmount) bool {
+	return a.Int != nil && !a.IsZero()
+}
+
+// Price is what a provider charges for a retrieval
+type Price struct {
+	UnsealPrice  abi.TokenAmount
+	PricePerByte abi.TokenAmount
+}
+
+// Cost is the cost of transferring the number of bytes at the price, not
+// including the unseal price
+func (p Price) Cost(bytes uint64) abi.TokenAmount {
+	if p.PricePerByte.Int == nil {
+		return big.Zero()
+	}
+	return big.Mul(p.PricePerByte, big.NewIntUnsigned(bytes))
+}
+
+func (p Price) unseal() abi.TokenAmount {
+	if p.UnsealPrice.Int == nil {
+		return big.Zero()
+	}
+	return p.UnsealPrice
+}
+
+func (p Price) String() string {
+	return fmt.Sprintf("%s unseal + %s / byte", types.FIL(p.unseal()).Short(), types.FIL(p.Cost(1)).Short())
+}
+
+// ExceededError is returned when a retrieval would exceed a cap
+type ExceededError struct {
+	// "retrieval" or "day"
+	Limit string
+	// What has been spent so far, on the retrieval or on the day
+	Spent abi.TokenAmount
+	// The additional spend that would exceed the cap
+	Requested abi.TokenAmount
+	Cap       abi.TokenAmount
+	// Why the additional spend was requested, eg "offer from f01234"
+	Reason string
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s would exceed the %s retrieval cost cap of %s: spent %s, requested %s",
+		e.Reason, perLimit(e.Limit), types.FIL(e.Cap).Short(), types.FIL(e.Spent).Short(), types.FIL(e.Requested).Short())
+}
+
+func perLimit(limit string) string {
+	if limit == "day" {
+		return "daily"
+	}
+	return "per-retrieval"
+}
+
+// Session tracks the spend of a single retrieval against the caps
+type Session struct {
+	caps   Caps
+	ledger *Ledger
+	// The expected size of the retrieval in bytes, or zero if not known
+	expected uint64
+
+	lk       sync.Mutex
+	source   string
+	price    Price
+	received uint64
+	spent    abi.TokenAmount
+	recorded abi.TokenAmount
+	aborted  error
+}
+
+// NewSession starts tracking a retrieval of the expected size (zero if not
+// known). The ledger may be nil if there is no daily cap.
+func NewSession(caps Caps, ledger *Ledger, expected uint64) (*Session, error) {
+	if ledger != nil {
+		// Pick up what other processes have spent today
+		if _, err := ledger.Spent(); err != nil {
+			return nil, err
+		}
+	}
+	return &Session{caps: caps, ledger: ledger, expected: expected, spent: big.Zero(), recorded: big.Zero()}, nil
+}
+
+// Offer checks the price offered by a provider before data is requested
+// from it, starting at offset bytes. The unseal price and the remaining
+// bytes at the offered price must fit within the caps, otherwise an
+// *ExceededError is returned and nothing is charged. If the offer is
+// accepted, the unseal price is charged and received bytes are charged at
+// the offered price.
+func (s *Session) Offer(source string, price Price, offset uint64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.aborted != nil {
+		return s.aborted
+	}
+	if err := s.check(price.unseal(), price.Cost(s.remaining(offset)), "offer from "+source+" ("+price.String()+")"); err != nil {
+		return err
+	}
+	s.source = source
+	s.price = price
+	s.charge(price.unseal())
+	return nil
+}
+
+// Reprice changes the price of the rest of the retrieval, eg because the
+// provider changed its ask mid-retrieval. If the remaining bytes at the new
+// price would exceed a cap, the retrieval is aborted: the error is returned,
+// and returned by every later call to Receive.
+func (s *Session) Reprice(price Price) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.aborted != nil {
+		return s.aborted
+	}
+	if price.Cost(1).Equals(s.price.Cost(1)) {
+		return nil
+	}
+	reason := fmt.Sprintf("repricing by %s to %s", s.source, price.String())
+	if err := s.check(big.Zero(), price.Cost(s.remaining(s.received)), reason); err != nil {
+		s.aborted = err
+		return err
+	}
+	s.price = price
+	return nil
+}
+
+// Receive charges the bytes received at the current price. Once the cost of
+// the retrieval would exceed a cap, it returns an *ExceededError and the
+// retrieval should be aborted.
+func (s *Session) Receive(n uint64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.aborted != nil {
+		return s.aborted
+	}
+	cost := s.price.Cost(n)
+	if err := s.check(cost, big.Zero(), "receiving data from "+s.source); err != nil {
+		s.aborted = err
+		return err
+	}
+	s.received += n
+	s.charge(cost)
+	return nil
+}
+
+func (s *Session) charge(amt abi.TokenAmount) {
+	s.spent = big.Add(s.spent, amt)
+	if s.ledger != nil {
+		s.ledger.charge(amt)
+	}
+}
+
+// remaining is the number of expected bytes still to be received after
+// offset
+func (s *Session) remaining(offset uint64) uint64 {
+	if s.expected <= offset {
+		return 0
+	}
+	return s.expected - offset
+}
+
+// check returns an *ExceededError if spending charge now, and projected
+// later, would exceed a cap
+func (s *Session) check(charge abi.TokenAmount, projected abi.TokenAmount, reason string) error {
+	requested := big.Add(charge, projected)
+	if isSet(s.caps.PerRetrieval) && big.Add(s.spent, requested).GreaterThan(s.caps.PerRetrieval) {
+		return &ExceededError{Limit: "retrieval", Spent: s.spent, Requested: requested, Cap: s.caps.PerRetrieval, Reason: reason}
+	}
+	if isSet(s.caps.PerDay) && s.ledger != nil {
+		daySpent := s.ledger.total()
+		if big.Add(daySpent, requested).GreaterThan(s.caps.PerDay) {
+			return &ExceededError{Limit: "day", Spent: daySpent, Requested: requested, Cap: s.caps.PerDay, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// Spent returns what has been spent on the retrieval so far
+func (s *Session) Spent() abi.TokenAmount {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.spent
+}
+
+// Aborted returns the reason the retrieval was aborted, or nil
+func (s *Session) Aborted() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.aborted
+}
+
+// Record adds what has been spent on the retrieval (since the last call to
+// Record) to the day's spend in the ledger
+func (s *Session) Record() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.ledger == nil {
+		return nil
+	}
+	amt := big.Sub(s.spent, s.recorded)
+	if amt.IsZero() {
+		return nil
+	}
+	if err := s.ledger.record(amt); err != nil {
+		return err
+	}
+	s.recorded = s.spent
+	return nil
+}
+
+// Ledger is the client's retrieval spend for the day, kept in a file.
+// Spend by retrievals in the process that hasn't been recorded in the file
+// yet is counted in memory, so that concurrent retrievals share the cap.
+type Ledger struct {
+	file *lockedfile.File
+	now  func() time.Time
+
+	memLk sync.Mutex
+	// The spend in the file when it was last read
+	fileSpent abi.TokenAmount
+	// Spend in the process that hasn't been recorded in the file yet
+	inflight abi.TokenAmount
+}
+
+type daySpend struct {
+	// The day, as YYYY-MM-DD in UTC
+	Day   string          `json:"day"`
+	Spend abi.TokenAmount `json:"spend"`
+}
+
+// NewLedger returns the ledger in the file at path
+func NewLedger(path string) *Ledger {
+	return &Ledger{file: lockedfile.New(path), now: time.Now, fileSpent: big.Zero(), inflight: big.Zero()}
+}
+
+// Spent returns what has been spent on retrievals today, including spend
+// in the process that hasn't been recorded yet
+func (l *Ledger) Spent() (abi.TokenAmount, error) {
+	err := l.locked(func() error {
+		d, err := l.load()
+		if err != nil {
+			return err
+		}
+		l.setFileSpent(d.Spend, big.Zero())
+		return nil
+	})
+	if err != nil {
+		return big.Zero(), err
+	}
+	return l.total(), nil
+}
+
+// record moves the amount from the in-memory spend to today's spend in the
+// file
+func (l *Ledger) record(amt abi.TokenAmount) error {
+	return l.locked(func() error {
+		d, err := l.load()
+		if err != nil {
+			return err
+		}
+		d.Spend = big.Add(d.Spend, amt)
+		if err := l.save(d); err != nil {
+			return err
+		}
+		l.setFileSpent(d.Spend, amt)
+		return nil
+	})
+}
+
+func (l *Ledger) setFileSpent(spent abi.TokenAmount, recorded abi.TokenAmount) {
+	l.memLk.Lock()
+	defer l.memLk.Unlock()
+	l.fileSpent = spent
+	l.inflight = big.Max(big.Sub(l.inflight, recorded), big.Zero())
+}
+
+func (l *Ledger) charge(amt abi.TokenAmount) {
+	l.memLk.Lock()
+	defer l.memLk.Unlock()
+	l.inflight = big.Add(l.inflight, amt)
+}
+
+func (l *Ledger) total() abi.TokenAmount {
+	l.memLk.Lock()
+	defer l.memLk.Unlock()
+	return big.Add(l.fileSpent, l.inflight)
+}
+
+func (l *Ledger) locked(fn func() error) error {
+	return l.file.Locked(fn)
+}
+
+// load reads the spend, resetting it if it was counted on an earlier day
+func (l *Ledger) load() (*daySpend, error) {
+	today := l.now().UTC().Format("2006-01-02")
+	d := &daySpend{Day: today, Spend: big.Zero()}
+	var stored daySpend
+	if _, err := l.file.Read(&stored); err != nil {
+		return nil, fmt.Errorf("reading retrieval spend: %w", err)
+	}
+	if stored.Day == today && stored.Spend.Int != nil {
+		d.Spend = stored.Spend
+	}
+	return d, nil
+}
+
+func (l *Ledger) save(d *daySpend) error {
+	if err := l.file.Write(d); err != nil {
+		return fmt.Errorf("writing retrieval spend: %w", err)
+	}
+	return nil
+}
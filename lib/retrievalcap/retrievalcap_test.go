@@ -0,0 +1,119 @@
+package retrievalcap
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/stretchr/testify/require"
+)
+
+func price(unseal int64, perByte int64) Price {
+	return Price{UnsealPrice: big.NewInt(unseal), PricePerByte: big.NewInt(perByte)}
+}
+
+func TestSession(t *testing.T) {
+	caps := Caps{PerRetrieval: big.NewInt(1000), PerDay: big.Zero()}
+
+	t.Run("refuses offer above cap", func(t *testing.T) {
+		s, err := NewSession(caps, nil, 100)
+		require.NoError(t, err)
+
+		// 100 + 100 * 10 > 1000
+		var eerr *ExceededError
+		require.ErrorAs(t, s.Offer("f01", price(100, 10), 0), &eerr)
+		require.Equal(t, "retrieval", eerr.Limit)
+		require.EqualValues(t, 0, s.Spent().Int64())
+
+		// The remaining bytes after the offset fit
+		require.NoError(t, s.Offer("f02", price(100, 10), 20))
+		require.EqualValues(t, 100, s.Spent().Int64())
+	})
+
+	t.Run("aborts on repricing", func(t *testing.T) {
+		s, err := NewSession(caps, nil, 100)
+		require.NoError(t, err)
+		require.NoError(t, s.Offer("f01", price(0, 5), 0))
+		require.NoError(t, s.Receive(50))
+		require.EqualValues(t, 250, s.Spent().Int64())
+
+		// 250 + 50 * 20 > 1000
+		var eerr *ExceededError
+		require.ErrorAs(t, s.Reprice(price(0, 20)), &eerr)
+		require.ErrorAs(t, s.Receive(1), &eerr)
+		require.Equal(t, eerr, s.Aborted())
+		require.EqualValues(t, 250, s.Spent().Int64())
+	})
+
+	t.Run("aborts when data exceeds cap", func(t *testing.T) {
+		// The size isn't known, so the offer can't be checked up front
+		s, err := NewSession(caps, nil, 0)
+		require.NoError(t, err)
+		require.NoError(t, s.Offer("f01", price(0, 10), 0))
+		require.NoError(t, s.Receive(100))
+		require.Error(t, s.Receive(1))
+		require.EqualValues(t, 1000, s.Spent().Int64())
+	})
+}
+
+func TestDailyCap(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLedger(filepath.Join(t.TempDir(), "spend.json"))
+	l.now = func() time.Time { return now }
+	caps := Caps{PerRetrieval: big.Zero(), PerDay: big.NewInt(1000)}
+
+	s1, err := NewSession(caps, l, 60)
+	require.NoError(t, err)
+	require.NoError(t, s1.Offer("f01", price(0, 10), 0))
+	require.NoError(t, s1.Receive(60))
+	require.NoError(t, s1.Record())
+	// Recording again doesn't double count
+	require.NoError(t, s1.Record())
+
+	spent, err := l.Spent()
+	require.NoError(t, err)
+	require.EqualValues(t, 600, spent.Int64())
+
+	// Only 400 is left today
+	s2, err := NewSession(caps, l, 60)
+	require.NoError(t, err)
+	var eerr *ExceededError
+	require.ErrorAs(t, s2.Offer("f01", price(0, 10), 0), &eerr)
+	require.Equal(t, "day", eerr.Limit)
+
+	// Concurrent retrievals share what's left before they are recorded
+	s3, err := NewSession(caps, l, 30)
+	require.NoError(t, err)
+	s4, err := NewSession(caps, l, 30)
+	require.NoError(t, err)
+	require.NoError(t, s3.Offer("f01", price(0, 10), 0))
+	require.NoError(t, s3.Receive(30))
+	require.ErrorAs(t, s4.Offer("f01", price(0, 10), 0), &eerr)
+	require.NoError(t, s3.Record())
+	spent, err = l.Spent()
+	require.NoError(t, err)
+	require.EqualValues(t, 900, spent.Int64())
+
+	// The spend is reset the next day
+	now = now.Add(24 * time.Hour)
+	spent, err = l.Spent()
+	require.NoError(t, err)
+	require.True(t, spent.IsZero())
+}
+
+func TestParseCaps(t *testing.T) {
+	caps, err := ParseCaps("0.5", "")
+	require.NoError(t, err)
+	require.True(t, caps.Enabled())
+	require.Equal(t, abi.TokenAmount(big.Div(big.NewInt(1e18), big.NewInt(2))), caps.PerRetrieval)
+	require.True(t, caps.PerDay.IsZero())
+
+	_, err = ParseCaps("", "-1")
+	require.Error(t, err)
+
+	caps, err = ParseCaps("", "")
+	require.NoError(t, err)
+	require.False(t, caps.Enabled())
+}
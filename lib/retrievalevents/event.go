@@ -0,0 +1,61 @@
+// Package retrievalevents publishes the events of the retrievals that a
+// storage provider serves to pluggable sinks, so that the provider's
+// analytics stack can follow retrievals without scraping logs.
+//
+// Each retrieval produces the events:
+//
+//	accepted      the provider accepted the retrieval request
+//	first-byte    the provider sent the first bytes of the content
+//	bytes-served  progress: the number of bytes sent so far
+//	completed     the provider sent all the content
+//	failed        the retrieval failed or was cancelled
+//
+// A retrieval always ends with one completed or failed event. Every event
+// is published as a JSON object with the fields:
+//
+//	time            time the event happened
+//	type            the event type (see above)
+//	retrieval_id    the retrieval's id, unique for the transport
+//	transport       the transport the content is served over (graphsync, http)
+//	client          the client's peer ID (graphsync) or remote address (http)
+//	payload_cid     the root cid of the retrieved content, if known
+//	piece_cid       the cid of the piece the content is served from, if known
+//	bytes_served    the number of bytes sent so far
+//	error           the reason the retrieval failed
+//
+// Events are buffered and written to each sink in batches, so that a slow
+// or unavailable sink never holds up a retrieval.
+package retrievalevents
+
+import (
+	"time"
+)
+
+// EventType is the type of a retrieval event
+type EventType string
+
+const (
+	Accepted    EventType = "accepted"
+	FirstByte   EventType = "first-byte"
+	BytesServed EventType = "bytes-served"
+	Completed   EventType = "completed"
+	Failed      EventType = "failed"
+)
+
+// Event is a single retrieval event, as it is published to the sinks
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        EventType `json:"type"`
+	RetrievalID string    `json:"retrieval_id"`
+	Transport   string    `json:"transport"`
+	Client      string    `json:"client,omitempty"`
+	PayloadCid  string    `json:"payload_cid,omitempty"`
+	PieceCid    string    `json:"piece_cid,omitempty"`
+	BytesServed uint64    `json:"bytes_served"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// IsFinal is true for the event that ends a retrieval
+func (e Event) IsFinal() bool {
+	return e.Type == Completed || e.Type == Failed
+}
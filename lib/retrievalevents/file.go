@@ -0,0 +1,91 @@
+package retrievalevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File appends events to a file as JSON lines. If the file is moved away
+// (eg by logrotate) a new file is created at the path.
+type File struct {
+	path string
+
+	lk sync.Mutex
+	f  *os.File
+}
+
+var _ Sink = (*File)(nil)
+
+// NewFile creates a sink that appends events to the file at path
+func NewFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating retrieval event journal directory: %w", err)
+	}
+	f := &File{path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening retrieval event journal: %w", err)
+	}
+	f.f = file
+	return nil
+}
+
+// reopen opens the file at the path again if it's no longer the file that
+// is being written to
+func (f *File) reopen() error {
+	if f.f != nil {
+		current, err := f.f.Stat()
+		if err == nil {
+			atPath, err := os.Stat(f.path)
+			if err == nil && os.SameFile(current, atPath) {
+				return nil
+			}
+		}
+		_ = f.f.Close()
+		f.f = nil
+	}
+	return f.open()
+}
+
+func (f *File) Write(_ context.Context, events []Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range events {
+		if err := enc.Encode(&events[i]); err != nil {
+			return fmt.Errorf("encoding retrieval %s event: %w", events[i].RetrievalID, err)
+		}
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if err := f.reopen(); err != nil {
+		return err
+	}
+	if _, err := f.f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing %d events to retrieval event journal: %w", len(events), err)
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
@@ -0,0 +1,321 @@
+package retrievalevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opentelemetry.io/otel"
+)
+
+var log = logging.Logger("retrievalevents")
+
+// Sink publishes retrieval events
+type Sink interface {
+	// Write publishes a batch of events, in the order they happened
+	Write(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// New creates the sink described by spec:
+//
+//	file:///path/to/events.jsonl              append JSON lines to a file
+//	otel                                      record a span per retrieval with the global tracer
+//	nats://[user:pass@]host:4222/subject      publish to a NATS subject
+//	kafka://[user:pass@]host:9092[,...]/topic produce to a Kafka topic
+//
+// The nats+tls and kafka+tls schemes connect over TLS, with the optional
+// query parameters ca, cert and key naming the CA certificates to verify
+// the server with and the client certificate and key.
+func New(spec string) (Sink, error) {
+	if spec == "otel" {
+		return NewOTel(otel.Tracer("github.com/filecoin-project/boost/lib/retrievalevents")), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing retrieval event sink '%s': %w", spec, err)
+	}
+	switch u.Scheme {
+	case "file":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		if path == "" {
+			return nil, fmt.Errorf("retrieval event sink '%s' has no file path", spec)
+		}
+		return NewFile(path)
+	case "nats", "nats+tls":
+		return NewNATS(u)
+	case "kafka", "kafka+tls":
+		return NewKafka(u)
+	}
+	return nil, fmt.Errorf("unrecognized retrieval event sink '%s': must be one of file://<path>, otel, "+
+		"nats[+tls]://<host:port>/<subject> or kafka[+tls]://<broker host:port>/<topic>", spec)
+}
+
+// Config configures the Journal
+type Config struct {
+	// The maximum number of events written to a sink in a single batch
+	BatchSize int
+	// The interval at which buffered events are written to the sinks
+	FlushInterval time.Duration
+	// The maximum number of events to buffer for a sink while it's
+	// unavailable. When the buffer is full the oldest events are dropped.
+	MaxBuffered int
+	// Bytes served events for a retrieval are recorded at most once per
+	// ProgressInterval. Zero means record every update.
+	ProgressInterval time.Duration
+}
+
+// Journal records retrieval events and writes them to each of its sinks.
+// Recording an event never blocks.
+type Journal struct {
+	cfg    Config
+	queues []*queue
+}
+
+// NewJournal creates a Journal that writes to the sinks
+func NewJournal(sinks []Sink, cfg Config) *Journal {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxBuffered < cfg.BatchSize {
+		cfg.MaxBuffered = 100 * cfg.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	j := &Journal{cfg: cfg}
+	for _, s := range sinks {
+		j.queues = append(j.queues, &queue{sink: s, cfg: cfg, full: make(chan struct{}, 1)})
+	}
+	return j
+}
+
+// Record buffers the event to be written to each sink
+func (j *Journal) Record(evt Event) {
+	for _, q := range j.queues {
+		q.add(evt)
+	}
+}
+
+// Dropped is the number of events that were dropped because a sink's
+// buffer was full
+func (j *Journal) Dropped() uint64 {
+	var dropped uint64
+	for _, q := range j.queues {
+		q.lk.Lock()
+		dropped += q.dropped
+		q.lk.Unlock()
+	}
+	return dropped
+}
+
+// Run writes buffered events to the sinks until the context is cancelled,
+// then makes a final attempt to write any remaining events and closes the
+// sinks
+func (j *Journal) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, q := range j.queues {
+		wg.Add(1)
+		go func(q *queue) {
+			defer wg.Done()
+			q.run(ctx)
+			if err := q.sink.Close(); err != nil {
+				log.Warnw("closing retrieval event sink", "err", err)
+			}
+		}(q)
+	}
+	wg.Wait()
+}
+
+// queue buffers the events for one sink
+type queue struct {
+	sink Sink
+	cfg  Config
+
+	lk       sync.Mutex
+	buffered []Event
+	dropped  uint64
+	// signalled when the buffer reaches the batch size
+	full chan struct{}
+}
+
+func (q *queue) add(evt Event) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	if len(q.buffered) >= q.cfg.MaxBuffered {
+		q.buffered = q.buffered[1:]
+		q.dropped++
+		if q.dropped%1000 == 1 {
+			log.Warnw("retrieval event sink buffer is full: dropping oldest events", "dropped", q.dropped)
+		}
+	}
+	q.buffered = append(q.buffered, evt)
+	if len(q.buffered) >= q.cfg.BatchSize {
+		select {
+		case q.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *queue) run(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			q.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-q.full:
+		}
+		q.flush(ctx)
+	}
+}
+
+// flush writes buffered events to the sink in batches. If a write fails
+// the events stay in the buffer to be retried on the next flush.
+func (q *queue) flush(ctx context.Context) {
+	for {
+		q.lk.Lock()
+		n := len(q.buffered)
+		if n > q.cfg.BatchSize {
+			n = q.cfg.BatchSize
+		}
+		batch := make([]Event, n)
+		copy(batch, q.buffered)
+		droppedBefore := q.dropped
+		q.lk.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		if err := q.sink.Write(ctx, batch); err != nil {
+			log.Warnw("writing retrieval events to sink", "events", n, "err", err)
+			return
+		}
+
+		// Remove the written events from the buffer. If the buffer
+		// overflowed while writing, some of the written events will
+		// already have been dropped from the front of the buffer.
+		q.lk.Lock()
+		written := n - int(q.dropped-droppedBefore)
+		if written > 0 {
+			q.buffered = q.buffered[written:]
+		}
+		q.lk.Unlock()
+	}
+}
+
+// Retrieval records the events of a single retrieval
+type Retrieval struct {
+	j *Journal
+
+	lk        sync.Mutex
+	evt       Event
+	firstByte bool
+	lastSent  time.Time
+	done      bool
+}
+
+// Start records that the retrieval described by evt (the id, transport,
+// client and cids) was accepted, and returns the Retrieval that records its
+// subsequent events. A nil Journal returns a nil Retrieval, which records
+// nothing.
+func (j *Journal) Start(evt Event) *Retrieval {
+	if j == nil {
+		return nil
+	}
+	evt.Type = Accepted
+	evt.Time = time.Now()
+	evt.BytesServed = 0
+	evt.Error = ""
+	j.Record(evt)
+	return &Retrieval{j: j, evt: evt}
+}
+
+// Served records that total bytes have been sent so far
+func (r *Retrieval) Served(total uint64) {
+	if r == nil || total == 0 {
+		return
+	}
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if r.done || total <= r.evt.BytesServed {
+		return
+	}
+	now := time.Now()
+	r.evt.BytesServed = total
+	if !r.firstByte {
+		r.firstByte = true
+		r.lastSent = now
+		r.record(FirstByte, now, "")
+		return
+	}
+	if r.j.cfg.ProgressInterval > 0 && now.Sub(r.lastSent) < r.j.cfg.ProgressInterval {
+		return
+	}
+	r.lastSent = now
+	r.record(BytesServed, now, "")
+}
+
+// Complete records that the retrieval completed after sending total bytes
+func (r *Retrieval) Complete(total uint64) {
+	r.end(total, nil)
+}
+
+// Fail records that the retrieval failed after sending total bytes
+func (r *Retrieval) Fail(total uint64, err error) {
+	if err == nil {
+		err = errors.New("retrieval failed")
+	}
+	r.end(total, err)
+}
+
+func (r *Retrieval) end(total uint64, err error) {
+	if r == nil {
+		return
+	}
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if r.done {
+		return
+	}
+	r.done = true
+	now := time.Now()
+	if total > r.evt.BytesServed {
+		r.evt.BytesServed = total
+	}
+	if !r.firstByte && r.evt.BytesServed > 0 {
+		r.firstByte = true
+		r.record(FirstByte, now, "")
+	}
+	if err != nil {
+		r.record(Failed, now, strings.TrimSpace(err.Error()))
+		return
+	}
+	r.record(Completed, now, "")
+}
+
+func (r *Retrieval) record(typ EventType, at time.Time, errMsg string) {
+	evt := r.evt
+	evt.Type = typ
+	evt.Time = at
+	evt.Error = errMsg
+	r.j.Record(evt)
+}
@@ -0,0 +1,104 @@
+package retrievalevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	kafkaDefaultPort = "9092"
+	kafkaTimeout     = 30 * time.Second
+)
+
+// Kafka produces each event as a record on a Kafka topic. The record key is
+// the retrieval id, so that the events of a retrieval go to the same
+// partition.
+type Kafka struct {
+	brokers   []string
+	transport *kafka.Transport
+	w         *kafka.Writer
+}
+
+var _ Sink = (*Kafka)(nil)
+
+// NewKafka creates a sink that produces to the topic on the Kafka cluster
+// with the brokers at kafka://[user:pass@]host[:port][,host[:port]...]/topic,
+// or over TLS at kafka+tls://...?[ca=<file>&cert=<file>&key=<file>].
+// If a user is given, the client authenticates with SASL PLAIN.
+func NewKafka(u *url.URL) (*Kafka, error) {
+	topic := strings.Trim(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("kafka retrieval event sink must be of the form kafka://host:port/topic")
+	}
+
+	var brokers []string
+	for _, b := range strings.Split(u.Host, ",") {
+		if b == "" {
+			return nil, fmt.Errorf("kafka retrieval event sink has an empty broker address")
+		}
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			b = net.JoinHostPort(b, kafkaDefaultPort)
+		}
+		brokers = append(brokers, b)
+	}
+
+	transport := &kafka.Transport{ClientID: "boost", DialTimeout: kafkaTimeout}
+	if u.Scheme == "kafka+tls" {
+		tf, err := parseTLSFiles(u.Query())
+		if err != nil {
+			return nil, fmt.Errorf("kafka retrieval event sink: %w", err)
+		}
+		transport.TLS, err = tf.config()
+		if err != nil {
+			return nil, fmt.Errorf("kafka retrieval event sink: %w", err)
+		}
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		transport.SASL = plain.Mechanism{Username: u.User.Username(), Password: pass}
+	}
+
+	return &Kafka{
+		brokers:   brokers,
+		transport: transport,
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// The journal batches the events itself
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: kafkaTimeout,
+			Transport:    transport,
+		},
+	}, nil
+}
+
+func (k *Kafka) Write(ctx context.Context, events []Event) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for i := range events {
+		value, err := json.Marshal(&events[i])
+		if err != nil {
+			return fmt.Errorf("encoding retrieval %s event: %w", events[i].RetrievalID, err)
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(events[i].RetrievalID), Value: value})
+	}
+	if err := k.w.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("producing %d events to kafka: %w", len(events), err)
+	}
+	return nil
+}
+
+func (k *Kafka) Close() error {
+	err := k.w.Close()
+	k.transport.CloseIdleConnections()
+	return err
+}
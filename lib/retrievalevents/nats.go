@@ -0,0 +1,133 @@
+package retrievalevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	natsDefaultPort = "4222"
+	natsTimeout     = 30 * time.Second
+)
+
+// NATS publishes each event as a message on a NATS subject. The connection
+// is made when events are first written; once connected, the client
+// reconnects by itself, and the connection is made again if the client
+// gives up.
+type NATS struct {
+	url     string
+	subject string
+	// Set to require TLS (otherwise TLS is only used if the server
+	// requires it)
+	tls *tlsFiles
+
+	lk   sync.Mutex
+	conn *nats.Conn
+}
+
+var _ Sink = (*NATS)(nil)
+
+// NewNATS creates a sink that publishes to the NATS server at
+// nats://[user:pass@|token@]host[:port]/subject, or over TLS at
+// nats+tls://[user:pass@|token@]host[:port]/subject[?ca=<file>&cert=<file>&key=<file>]
+func NewNATS(u *url.URL) (*NATS, error) {
+	subject := strings.Trim(u.Path, "/")
+	if u.Hostname() == "" || subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("nats retrieval event sink must be of the form nats://host:port/subject")
+	}
+	server := url.URL{Scheme: "nats", User: u.User, Host: u.Host}
+	if u.Port() == "" {
+		server.Host = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	n := &NATS{subject: subject}
+	if u.Scheme == "nats+tls" {
+		server.Scheme = "tls"
+		tf, err := parseTLSFiles(u.Query())
+		if err != nil {
+			return nil, fmt.Errorf("nats retrieval event sink: %w", err)
+		}
+		n.tls = tf
+	}
+	n.url = server.String()
+	return n, nil
+}
+
+func (n *NATS) Write(ctx context.Context, events []Event) error {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	if n.conn == nil || n.conn.IsClosed() {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+
+	// The server reports errors such as a permissions violation
+	// asynchronously, so check for a new error once the events are flushed
+	lastErr := n.conn.LastError()
+	for i := range events {
+		msg, err := json.Marshal(&events[i])
+		if err != nil {
+			return fmt.Errorf("encoding retrieval %s event: %w", events[i].RetrievalID, err)
+		}
+		if err := n.conn.Publish(n.subject, msg); err != nil {
+			return fmt.Errorf("publishing %d events to nats: %w", len(events), err)
+		}
+	}
+
+	// The server replies to the flush once it has processed the messages
+	// before it, or replies with an error
+	fctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+	if err := n.conn.FlushWithContext(fctx); err != nil {
+		return fmt.Errorf("publishing %d events to nats: %w", len(events), err)
+	}
+	if err := n.conn.LastError(); err != nil && err != lastErr { //nolint:errorlint
+		return fmt.Errorf("publishing %d events to nats: %w", len(events), err)
+	}
+	return nil
+}
+
+func (n *NATS) connect() error {
+	opts := []nats.Option{
+		nats.Name("boost"),
+		nats.Timeout(natsTimeout),
+		// Errors are returned by Write, rather than being printed by the
+		// default handler
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			log.Debugw("nats retrieval event sink", "err", err)
+		}),
+	}
+	if n.tls != nil {
+		cfg, err := n.tls.config()
+		if err != nil {
+			return fmt.Errorf("nats retrieval event sink: %w", err)
+		}
+		opts = append(opts, nats.Secure(cfg))
+	}
+	conn, err := nats.Connect(n.url, opts...)
+	if err != nil {
+		return fmt.Errorf("connecting to nats server: %w", err)
+	}
+	n.conn = conn
+	return nil
+}
+
+func (n *NATS) Close() error {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	n.conn.Close()
+	n.conn = nil
+	return nil
+}
@@ -0,0 +1,104 @@
+package retrievalevents
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelSpanIdleTimeout is how long a retrieval's span is kept open without
+// any event for the retrieval (eg because the final event was dropped)
+const otelSpanIdleTimeout = time.Hour
+
+// OTel records each retrieval as an OpenTelemetry span that starts when the
+// retrieval is accepted and ends when it completes or fails. The first byte
+// and bytes served events are added to the span as span events.
+type OTel struct {
+	tracer trace.Tracer
+
+	lk        sync.Mutex
+	spans     map[string]*otelSpan
+	lastSweep time.Time
+}
+
+type otelSpan struct {
+	span trace.Span
+	last time.Time
+}
+
+var _ Sink = (*OTel)(nil)
+
+// NewOTel creates a sink that records spans with the tracer
+func NewOTel(tracer trace.Tracer) *OTel {
+	return &OTel{tracer: tracer, spans: make(map[string]*otelSpan)}
+}
+
+func (o *OTel) Write(_ context.Context, events []Event) error {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	for _, evt := range events {
+		key := evt.Transport + "/" + evt.RetrievalID
+		s, ok := o.spans[key]
+		if !ok {
+			// If the accepted event was dropped, the span starts at the
+			// first event for the retrieval
+			_, span := o.tracer.Start(context.Background(), "retrieval",
+				trace.WithTimestamp(evt.Time),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("retrieval.id", evt.RetrievalID),
+					attribute.String("retrieval.transport", evt.Transport),
+					attribute.String("retrieval.client", evt.Client),
+					attribute.String("retrieval.payload_cid", evt.PayloadCid),
+					attribute.String("retrieval.piece_cid", evt.PieceCid),
+				))
+			s = &otelSpan{span: span}
+			o.spans[key] = s
+		}
+		s.last = evt.Time
+
+		bytesServed := attribute.Int64("retrieval.bytes_served", int64(evt.BytesServed))
+		switch evt.Type {
+		case FirstByte, BytesServed:
+			s.span.AddEvent(string(evt.Type), trace.WithTimestamp(evt.Time), trace.WithAttributes(bytesServed))
+		case Completed, Failed:
+			s.span.SetAttributes(bytesServed)
+			if evt.Type == Failed {
+				s.span.SetStatus(codes.Error, evt.Error)
+			}
+			s.span.End(trace.WithTimestamp(evt.Time))
+			delete(o.spans, key)
+		}
+	}
+
+	// End the spans of retrievals that have had no events for a long time
+	now := time.Now()
+	if now.Sub(o.lastSweep) > time.Minute {
+		o.lastSweep = now
+		for key, s := range o.spans {
+			if now.Sub(s.last) > otelSpanIdleTimeout {
+				s.span.SetStatus(codes.Error, "no events for retrieval")
+				s.span.End()
+				delete(o.spans, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Close ends the spans of retrievals that are still open
+func (o *OTel) Close() error {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+	for key, s := range o.spans {
+		s.span.SetStatus(codes.Error, "retrieval event journal closed")
+		s.span.End()
+		delete(o.spans, key)
+	}
+	return nil
+}
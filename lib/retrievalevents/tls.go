@@ -0,0 +1,54 @@
+package retrievalevents
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// tlsFiles are the files that the TLS config of a connection to a sink is
+// loaded from, given in the query of the sink's url:
+//
+//	ca    the CA certificates to verify the server with, instead of the
+//	      system's certificates
+//	cert  the client certificate (with key), for mutual TLS
+//	key   the client certificate's key
+type tlsFiles struct {
+	ca   string
+	cert string
+	key  string
+}
+
+func parseTLSFiles(q url.Values) (*tlsFiles, error) {
+	tf := &tlsFiles{ca: q.Get("ca"), cert: q.Get("cert"), key: q.Get("key")}
+	if (tf.cert == "") != (tf.key == "") {
+		return nil, errors.New("a TLS client certificate requires both the cert and the key parameter")
+	}
+	return tf, nil
+}
+
+// config loads the TLS config from the files
+func (tf *tlsFiles) config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tf.ca != "" {
+		pem, err := os.ReadFile(tf.ca)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA certificates: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no TLS CA certificates found in %s", tf.ca)
+		}
+	}
+	if tf.cert != "" {
+		cert, err := tls.LoadX509KeyPair(tf.cert, tf.key)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
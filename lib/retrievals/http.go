@@ -0,0 +1,360 @@
+package retrievals
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/boost/lib/prefetch"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-blockservice"
+	files "github.com/ipfs/go-ipfs-files"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipld/go-car/v2/blockstore"
+)
+
+var log = logging.Logger("retrievals")
+
+// How often to check for more data when streaming an in-progress retrieval
+var followPollInterval = 500 * time.Millisecond
+
+// NewHandler returns an http handler that serves the client's retrievals:
+//
+//	GET /retrievals              list retrievals
+//	GET /retrievals/{id}         get a retrieval's record
+//	GET /retrievals/{id}/car     stream the CAR file. If the retrieval is
+//	                             in progress, data is streamed as it arrives,
+//	                             unless the content must be scanned first.
+//	GET /retrievals/{id}/file    the file extracted from the CAR file (the
+//	                             payload root must be a unixfs file)
+//	GET /openapi.json            the OpenAPI document describing the API
+//
+// With the Prefetch option it also serves the prefetch API (see
+// prefetchRoutes).
+//
+// If token is not empty, requests must have an Authorization header with
+// the bearer token.
+func NewHandler(store *Store, token string, opts ...HandlerOption) http.Handler {
+	h := &handler{store: store}
+	for _, opt := range opts {
+		opt(h)
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/retrievals", h.listRetrievals).Methods(http.MethodGet)
+	r.HandleFunc("/retrievals/{id}", h.getRetrieval).Methods(http.MethodGet)
+	r.HandleFunc("/retrievals/{id}/car", h.getCar).Methods(http.MethodGet)
+	r.HandleFunc("/retrievals/{id}/file", h.getFile).Methods(http.MethodGet)
+	r.Handle("/openapi.json", OpenAPI()).Methods(http.MethodGet)
+	if h.prefetch != nil {
+		h.prefetchRoutes(r)
+	}
+	if h.quotas != nil {
+		r.Use(apiquota.Authenticate(token, h.quotas))
+	} else if token != "" {
+		r.Use(bearerAuth(token))
+	}
+	return r
+}
+
+type HandlerOption func(*handler)
+
+// TokenQuotas accepts the API tokens in the store as well as the handler's
+// token. The bytes of CAR files and files served to a request made with an
+// API token are charged to the token's daily quota.
+func TokenQuotas(q *apiquota.Store) HandlerOption {
+	return func(h *handler) {
+		h.quotas = q
+	}
+}
+
+func bearerAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type handler struct {
+	store    *Store
+	quotas   *apiquota.Store
+	prefetch *prefetch.Store
+}
+
+func (h *handler) listRetrievals(w http.ResponseWriter, r *http.Request) {
+	records, err := h.store.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []Record{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (h *handler) getRetrieval(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.recordFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (h *handler) getCar(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.recordFromPath(w, r)
+	if !ok {
+		return
+	}
+	if rec.State == StateFailed || rec.State == StateAborted || rec.State == StateQuarantined {
+		writeError(w, http.StatusGone, fmt.Errorf("retrieval %s %s: %s", rec.ID, rec.State, rec.Error))
+		return
+	}
+	// Content that will be scanned is withheld until the scan passes
+	if rec.State != StateComplete && rec.Scanned {
+		writeError(w, http.StatusConflict, fmt.Errorf("retrieval %s is %s: its content is served once it has been scanned", rec.ID, rec.State))
+		return
+	}
+
+	size := uint64(0)
+	if rec.State == StateComplete {
+		size = uint64(rec.Size)
+	}
+	w, done, ok := h.meter(w, r, size)
+	if !ok {
+		return
+	}
+	defer done()
+
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("opening CAR file: %w", err))
+		return
+	}
+	defer f.Close() //nolint:errcheck
+
+	w.Header().Set("Content-Type", "application/vnd.ipld.car")
+	if rec.State == StateComplete {
+		// Serve the complete file with support for range requests
+		http.ServeContent(w, r, rec.PayloadCid.String()+".car", rec.UpdatedAt, f)
+		return
+	}
+
+	// The retrieval is in progress: stream the file as it's written
+	w.WriteHeader(http.StatusOK)
+	fr := &followReader{r: f, store: h.store, id: rec.ID, done: r.Context().Done()}
+	if _, err := io.Copy(flushWriter{w}, fr); err != nil {
+		log.Infow("streaming in-progress retrieval", "id", rec.ID, "err", err)
+		// Abort the response so the caller can tell that the CAR file is
+		// incomplete
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *handler) getFile(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.recordFromPath(w, r)
+	if !ok {
+		return
+	}
+	if rec.State != StateComplete {
+		writeError(w, http.StatusConflict, fmt.Errorf("retrieval %s is %s: the file can only be extracted from a complete retrieval", rec.ID, rec.State))
+		return
+	}
+
+	bs, err := blockstore.OpenReadOnly(rec.Path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("opening CAR file: %w", err))
+		return
+	}
+	defer bs.Close() //nolint:errcheck
+
+	dserv := merkledag.NewDAGService(blockservice.New(bs, nil))
+	nd, err := dserv.Get(r.Context(), rec.PayloadCid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("getting payload root %s: %w", rec.PayloadCid, err))
+		return
+	}
+	node, err := unixfile.NewUnixfsFile(r.Context(), dserv, nd)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("payload root %s is not unixfs: %w", rec.PayloadCid, err))
+		return
+	}
+	defer node.Close() //nolint:errcheck
+
+	f, ok := node.(files.File)
+	if !ok {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("payload root %s is a directory, not a file", rec.PayloadCid))
+		return
+	}
+	fsize, err := f.Size()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("getting file size: %w", err))
+		return
+	}
+	w, done, ok := h.meter(w, r, uint64(fsize))
+	if !ok {
+		return
+	}
+	defer done()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, rec.PayloadCid.String(), rec.UpdatedAt, f)
+}
+
+// meter charges the API token that made the request (if any) for size
+// bytes, failing the request if the token doesn't have quota left for them.
+// The charge is made up front, so that concurrent requests can't together
+// exceed the quota. It returns a writer that counts the bytes served: when
+// done is called the token is refunded for the bytes that were not served.
+func (h *handler) meter(w http.ResponseWriter, r *http.Request, size uint64) (http.ResponseWriter, func(), bool) {
+	name, ok := apiquota.TokenFromContext(r.Context())
+	if h.quotas == nil || !ok {
+		return w, func() {}, true
+	}
+	if err := h.quotas.Charge(name, apiquota.Charge{Bytes: size}); err != nil {
+		status := http.StatusInternalServerError
+		var ee *apiquota.ExceededError
+		if errors.As(err, &ee) {
+			status = http.StatusTooManyRequests
+		}
+		writeError(w, status, err)
+		return nil, nil, false
+	}
+
+	cw := &countingWriter{ResponseWriter: w}
+	done := func() {
+		var err error
+		switch {
+		case cw.n < size:
+			err = h.quotas.Refund(name, apiquota.Charge{Bytes: size - cw.n})
+		case cw.n > size:
+			err = h.quotas.Record(name, apiquota.Charge{Bytes: cw.n - size})
+		}
+		if err != nil {
+			log.Warnw("charging bytes served to api token", "token", name, "bytes", cw.n, "err", err)
+		}
+	}
+	return cw, done, true
+}
+
+// countingWriter counts the bytes of the response body
+type countingWriter struct {
+	http.ResponseWriter
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+func (cw *countingWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (h *handler) recordFromPath(w http.ResponseWriter, r *http.Request) (*Record, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing retrieval id: %w", err))
+		return nil, false
+	}
+	rec, err := h.store.Get(id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return nil, false
+	}
+	return rec, true
+}
+
+// followReader reads a file that is still being written. When it reaches
+// the end of the file it waits for more data, until the retrieval completes
+// or fails.
+type followReader struct {
+	r     io.Reader
+	store *Store
+	id    uuid.UUID
+	done  <-chan struct{}
+	// set once the retrieval is no longer in progress, so the next EOF is
+	// the real end of the file
+	finished bool
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if f.finished {
+			return 0, io.EOF
+		}
+
+		rec, err := f.store.Get(f.id)
+		if err != nil {
+			return 0, err
+		}
+		switch rec.State {
+		case StateComplete:
+			// Read whatever was written between the last read and the
+			// retrieval completing
+			f.finished = true
+			continue
+		case StateFailed, StateAborted, StateQuarantined:
+			return 0, fmt.Errorf("retrieval %s: %s", rec.State, rec.Error)
+		}
+
+		select {
+		case <-f.done:
+			return 0, errors.New("request cancelled")
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+// flushWriter flushes each write so that streamed data reaches the caller
+// as soon as it is read
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnw("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
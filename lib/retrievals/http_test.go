@@ -0,0 +1,240 @@
+package retrievals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/apiquota"
+	"github.com/filecoin-project/boost/lib/prefetch"
+	"github.com/ipfs/go-cid"
+	merkledag "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+	car "github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	followPollInterval = 10 * time.Millisecond
+
+	// Create a CAR file with a single raw block
+	content := []byte("hello retrieved world")
+	dserv := dstest.Mock()
+	nd := merkledag.NewRawNode(content)
+	req.NoError(dserv.Add(ctx, nd))
+	var carBuf bytes.Buffer
+	req.NoError(car.WriteCar(ctx, dserv, []cid.Cid{nd.Cid()}, &carBuf))
+	carBytes := carBuf.Bytes()
+
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "retrievals"))
+	req.NoError(err)
+
+	// Start a retrieval and write half the CAR file
+	carPath := filepath.Join(dir, "out.car")
+	rec := &Record{PayloadCid: nd.Cid(), Provider: "f01000", Path: carPath}
+	req.NoError(store.Start(rec))
+	half := len(carBytes) / 2
+	req.NoError(os.WriteFile(carPath, carBytes[:half], 0644))
+
+	srv := httptest.NewServer(NewHandler(store, "secret"))
+	defer srv.Close()
+
+	get := func(path string, token string) *http.Response {
+		r, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.NoError(err)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		req.NoError(err)
+		return resp
+	}
+
+	// Requests without the token are rejected
+	resp := get("/retrievals", "")
+	req.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp = get("/retrievals", "wrong")
+	req.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("/retrievals", "secret")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	var records []Record
+	req.NoError(json.NewDecoder(resp.Body).Decode(&records))
+	req.Len(records, 1)
+	req.Equal(rec.ID, records[0].ID)
+	req.Equal(StateInProgress, records[0].State)
+
+	// The file can't be extracted until the retrieval is complete
+	resp = get(fmt.Sprintf("/retrievals/%s/file", rec.ID), "secret")
+	req.Equal(http.StatusConflict, resp.StatusCode)
+
+	// Stream the in-progress CAR file, and complete the retrieval while
+	// it's streaming
+	resp = get(fmt.Sprintf("/retrievals/%s/car", rec.ID), "secret")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f, err := os.OpenFile(carPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		_, _ = f.Write(carBytes[half:])
+		_ = f.Close()
+		_ = store.Complete(rec, carPath, int64(len(carBytes)))
+	}()
+	streamed, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Equal(carBytes, streamed)
+
+	// Get the complete CAR file
+	resp = get(fmt.Sprintf("/retrievals/%s/car", rec.ID), "secret")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Equal(carBytes, b)
+
+	// Get the extracted file
+	resp = get(fmt.Sprintf("/retrievals/%s/file", rec.ID), "secret")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	b, err = io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Equal(content, b)
+
+	// The CAR file of a quarantined retrieval is not served
+	qrec := &Record{PayloadCid: nd.Cid(), Provider: "f01000", Path: carPath}
+	req.NoError(store.Start(qrec))
+	req.NoError(store.Quarantine(qrec, filepath.Join(dir, "quarantine", "out.car"), int64(len(carBytes)), fmt.Errorf("content flagged")))
+	resp = get(fmt.Sprintf("/retrievals/%s/car", qrec.ID), "secret")
+	req.Equal(http.StatusGone, resp.StatusCode)
+	resp = get(fmt.Sprintf("/retrievals/%s/file", qrec.ID), "secret")
+	req.Equal(http.StatusConflict, resp.StatusCode)
+
+	// The CAR file of an in-progress retrieval whose content is scanned is
+	// withheld until the scan passes
+	srec := &Record{PayloadCid: nd.Cid(), Provider: "f01000", Path: carPath, Scanned: true}
+	req.NoError(store.Start(srec))
+	resp = get(fmt.Sprintf("/retrievals/%s/car", srec.ID), "secret")
+	req.Equal(http.StatusConflict, resp.StatusCode)
+	req.NoError(store.Complete(srec, carPath, int64(len(carBytes))))
+	resp = get(fmt.Sprintf("/retrievals/%s/car", srec.ID), "secret")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	b, err = io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Equal(carBytes, b)
+
+	// Unknown retrieval
+	resp = get("/retrievals/00000000-0000-0000-0000-000000000000/car", "secret")
+	req.Equal(http.StatusNotFound, resp.StatusCode)
+
+	// The OpenAPI document describes each route, and the states of a record
+	resp = get("/openapi.json", "secret")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	var doc struct {
+		Paths      map[string]map[string]interface{}
+		Components struct {
+			Schemas map[string]struct {
+				Enum []string
+			}
+		}
+	}
+	req.NoError(json.NewDecoder(resp.Body).Decode(&doc))
+	for _, path := range []string{"/retrievals", "/retrievals/{id}", "/retrievals/{id}/car", "/retrievals/{id}/file"} {
+		req.Contains(doc.Paths[path], "get", path)
+	}
+	req.Contains(doc.Components.Schemas, "Record")
+	req.Equal([]string{StateInProgress, StateComplete, StateFailed, StateAborted, StateQuarantined}, doc.Components.Schemas["RetrievalState"].Enum)
+}
+
+func TestMeter(t *testing.T) {
+	req := require.New(t)
+
+	quotas := apiquota.NewStore(filepath.Join(t.TempDir(), "api-tokens.json"))
+	_, err := quotas.Create("etl", apiquota.Quota{BytesPerDay: "100B"}, false)
+	req.NoError(err)
+	h := &handler{quotas: quotas}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(apiquota.WithToken(r.Context(), "etl"))
+
+	usage := func() uint64 {
+		tokens, err := quotas.List()
+		req.NoError(err)
+		return tokens[0].Usage.Bytes
+	}
+
+	// The full size is charged up front, so a second request that would
+	// exceed the quota is rejected while the first is still being served
+	w, done, ok := h.meter(httptest.NewRecorder(), r, 60)
+	req.True(ok)
+	req.EqualValues(60, usage())
+	rec := httptest.NewRecorder()
+	_, _, ok = h.meter(rec, r, 60)
+	req.False(ok)
+	req.Equal(http.StatusTooManyRequests, rec.Code)
+
+	// The bytes that were not served are refunded when the request is done
+	_, err = w.Write(make([]byte, 40))
+	req.NoError(err)
+	done()
+	req.EqualValues(40, usage())
+
+	_, done, ok = h.meter(httptest.NewRecorder(), r, 60)
+	req.True(ok)
+	done()
+	req.EqualValues(40, usage())
+}
+
+func TestPrefetchHandler(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "retrievals"))
+	req.NoError(err)
+	predictions, err := prefetch.NewStore(filepath.Join(dir, "prefetch"))
+	req.NoError(err)
+
+	srv := httptest.NewServer(NewHandler(store, "secret", Prefetch(predictions)))
+	defer srv.Close()
+
+	do := func(method string, path string, body string) *http.Response {
+		r, err := http.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+		req.NoError(err)
+		r.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(r)
+		req.NoError(err)
+		return resp
+	}
+
+	nd := merkledag.NewRawNode([]byte("predicted"))
+	resp := do(http.MethodPost, "/prefetch", fmt.Sprintf(`[{"payloadCid": {"/": "%s"}, "provider": "f01000"}]`, nd.Cid()))
+	req.Equal(http.StatusOK, resp.StatusCode)
+	var preds []prefetch.Prediction
+	req.NoError(json.NewDecoder(resp.Body).Decode(&preds))
+	req.Len(preds, 1)
+	req.Equal(prefetch.StatePending, preds[0].State)
+
+	// The payload hasn't been fetched yet, so needing it is late
+	resp = do(http.MethodGet, "/prefetch/"+nd.Cid().String()+"/car", "")
+	req.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/prefetch/stats", "")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	var st prefetch.Stats
+	req.NoError(json.NewDecoder(resp.Body).Decode(&st))
+	req.Equal(1, st.Pending)
+	req.Equal(1, st.Late)
+
+	resp = do(http.MethodDelete, "/prefetch/"+nd.Cid().String(), "")
+	req.Equal(http.StatusNoContent, resp.StatusCode)
+	resp = do(http.MethodDelete, "/prefetch/"+nd.Cid().String(), "")
+	req.Equal(http.StatusNotFound, resp.StatusCode)
+}
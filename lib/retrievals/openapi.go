@@ -0,0 +1,114 @@
+package retrievals
+
+import (
+	"net/http"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/filecoin-project/boost/build"
+	"github.com/filecoin-project/boost/lib/openapi"
+	"github.com/filecoin-project/boost/lib/prefetch"
+)
+
+// OpenAPI returns the OpenAPI document that describes the retrievals API. It
+// is served at /openapi.json.
+func OpenAPI() *openapi.Document {
+	d := openapi.New("Boost retrievals API",
+		"The retrievals made by the boost client, and their content",
+		build.BuildVersion)
+	d.BearerAuth()
+
+	id := openapi.PathParam("id", "the retrieval id", &jsonschema.Type{Type: "string", Format: "uuid"})
+	notFound := d.Error("the retrieval was not found")
+	quota := d.Error("the API token's daily quota would be exceeded")
+	d.Add(http.MethodGet, "/retrievals", openapi.Operation{
+		OperationID: "listRetrievals",
+		Summary:     "List retrievals",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the retrieval records", []Record{}),
+		},
+	})
+	d.Add(http.MethodGet, "/retrievals/{id}", openapi.Operation{
+		OperationID: "getRetrieval",
+		Summary:     "Get a retrieval's record",
+		Parameters:  []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the retrieval record", Record{}),
+			"404": notFound,
+		},
+	})
+	d.Add(http.MethodGet, "/retrievals/{id}/car", openapi.Operation{
+		OperationID: "getRetrievalCar",
+		Summary:     "Stream a retrieval's CAR file. If the retrieval is in progress, data is streamed as it arrives, unless the content must be scanned first.",
+		Parameters:  []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"200": openapi.Binary("the CAR file", "application/vnd.ipld.car"),
+			"404": notFound,
+			"409": d.Error("the retrieval is in progress and its content has not been scanned yet"),
+			"410": d.Error("the retrieval failed, was aborted or its content was quarantined"),
+			"429": quota,
+		},
+	})
+	d.Add(http.MethodGet, "/retrievals/{id}/file", openapi.Operation{
+		OperationID: "getRetrievalFile",
+		Summary:     "Get the file extracted from a complete retrieval's CAR file",
+		Parameters:  []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"200": openapi.Binary("the file", "application/octet-stream"),
+			"404": notFound,
+			"409": d.Error("the retrieval is not complete"),
+			"422": d.Error("the payload root is not a unixfs file"),
+			"429": quota,
+		},
+	})
+
+	payloadCid := openapi.PathParam("cid", "the payload cid", &jsonschema.Type{Type: "string"})
+	notPredicted := d.Error("the payload cid was not predicted")
+	d.Add(http.MethodGet, "/prefetch", openapi.Operation{
+		OperationID: "listPredictions",
+		Summary:     "List the payload cids that are predicted to be needed soon",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the predictions", []prefetch.Prediction{}),
+		},
+	})
+	d.Add(http.MethodPost, "/prefetch", openapi.Operation{
+		OperationID: "registerPredictions",
+		Summary:     "Register payload cids that are predicted to be needed soon, to be fetched into the cache while bandwidth is idle",
+		RequestBody: d.JSONBody([]prefetch.Request{}),
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the registered predictions", []prefetch.Prediction{}),
+			"400": d.Error("a prediction is invalid"),
+		},
+	})
+	d.Add(http.MethodGet, "/prefetch/stats", openapi.Operation{
+		OperationID: "getPrefetchStats",
+		Summary:     "Get the cache hit rate and accuracy of the predictions",
+		Responses: map[string]openapi.Response{
+			"200": d.JSON("the prefetch stats", prefetch.Stats{}),
+		},
+	})
+	d.Add(http.MethodGet, "/prefetch/{cid}/car", openapi.Operation{
+		OperationID: "getPrefetchedCar",
+		Summary:     "Get a prefetched CAR file from the cache. Records a cache hit, or a late prediction if the payload has not been fetched yet.",
+		Parameters:  []openapi.Parameter{payloadCid},
+		Responses: map[string]openapi.Response{
+			"200": openapi.Binary("the CAR file", "application/vnd.ipld.car"),
+			"404": d.Error("the payload cid is not in the cache"),
+			"429": quota,
+		},
+	})
+	d.Add(http.MethodDelete, "/prefetch/{cid}", openapi.Operation{
+		OperationID: "removePrediction",
+		Summary:     "Remove a prediction and its cached data",
+		Parameters:  []openapi.Parameter{payloadCid},
+		Responses: map[string]openapi.Response{
+			"204": {Description: "the prediction was removed"},
+			"404": notPredicted,
+		},
+	})
+
+	d.SetProperty("Record", "state", d.Enum("RetrievalState", "The state of a retrieval",
+		StateInProgress, StateComplete, StateFailed, StateAborted, StateQuarantined))
+	d.SetProperty("Prediction", "state", d.Enum("PredictionState", "The state of a prediction",
+		prefetch.StatePending, prefetch.StateFetching, prefetch.StateCached, prefetch.StateFailed, prefetch.StateExpired))
+	return d
+}
@@ -0,0 +1,134 @@
+package retrievals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/filecoin-project/boost/lib/prefetch"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+)
+
+// Prefetch serves the prefetch API, through which callers register payload
+// cids that they predict will be needed soon so that the client can fetch
+// them into its cache while bandwidth is idle
+func Prefetch(s *prefetch.Store) HandlerOption {
+	return func(h *handler) {
+		h.prefetch = s
+	}
+}
+
+// prefetchRoutes adds the prefetch API:
+//
+//	GET    /prefetch             list predictions
+//	POST   /prefetch             register predictions
+//	GET    /prefetch/stats       the hit rate and accuracy of the predictions
+//	GET    /prefetch/{cid}/car   get the cached CAR file, recording a hit (or
+//	                             a late prediction if it isn't cached yet)
+//	DELETE /prefetch/{cid}       remove a prediction and its cached data
+func (h *handler) prefetchRoutes(r *mux.Router) {
+	r.HandleFunc("/prefetch", h.listPredictions).Methods(http.MethodGet)
+	r.HandleFunc("/prefetch", h.registerPredictions).Methods(http.MethodPost)
+	r.HandleFunc("/prefetch/stats", h.prefetchStats).Methods(http.MethodGet)
+	r.HandleFunc("/prefetch/{cid}/car", h.getPrefetchedCar).Methods(http.MethodGet)
+	r.HandleFunc("/prefetch/{cid}", h.removePrediction).Methods(http.MethodDelete)
+}
+
+func (h *handler) listPredictions(w http.ResponseWriter, r *http.Request) {
+	preds, err := h.prefetch.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if preds == nil {
+		preds = []prefetch.Prediction{}
+	}
+	writeJSON(w, http.StatusOK, preds)
+}
+
+func (h *handler) registerPredictions(w http.ResponseWriter, r *http.Request) {
+	var reqs []prefetch.Request
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing predictions: %w", err))
+		return
+	}
+	preds, err := h.prefetch.Register(reqs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, preds)
+}
+
+func (h *handler) prefetchStats(w http.ResponseWriter, r *http.Request) {
+	st, err := h.prefetch.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *handler) getPrefetchedCar(w http.ResponseWriter, r *http.Request) {
+	c, ok := cidFromPath(w, r)
+	if !ok {
+		return
+	}
+	pred, err := h.prefetch.Use(c)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if pred == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not in the prefetch cache", c))
+		return
+	}
+
+	w, done, ok := h.meter(w, r, uint64(pred.Size))
+	if !ok {
+		return
+	}
+	defer done()
+
+	f, err := os.Open(h.prefetch.CarPath(c))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("opening CAR file: %w", err))
+		return
+	}
+	defer f.Close() //nolint:errcheck
+
+	w.Header().Set("Content-Type", "application/vnd.ipld.car")
+	modTime := pred.RegisteredAt
+	if pred.FetchedAt != nil {
+		modTime = *pred.FetchedAt
+	}
+	http.ServeContent(w, r, c.String()+".car", modTime, f)
+}
+
+func (h *handler) removePrediction(w http.ResponseWriter, r *http.Request) {
+	c, ok := cidFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := h.prefetch.Remove(c); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, prefetch.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func cidFromPath(w http.ResponseWriter, r *http.Request) (cid.Cid, bool) {
+	c, err := cid.Parse(mux.Vars(r)["cid"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing payload cid: %w", err))
+		return cid.Undef, false
+	}
+	return c, true
+}
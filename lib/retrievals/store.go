@@ -0,0 +1,188 @@
+// Package retrievals records the retrievals made by the boost client in the
+// client repo, and serves their content over http so that it can be consumed
+// without access to the client's filesystem.
+package retrievals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+var ErrNotFound = errors.New("retrieval not found")
+
+const (
+	// The CAR file is being downloaded
+	StateInProgress = "in-progress"
+	// The CAR file has been completely downloaded
+	StateComplete = "complete"
+	// The retrieval failed
+	StateFailed = "failed"
+	// The retrieval was aborted by the client, eg because it would have
+	// exceeded the retrieval cost cap
+	StateAborted = "aborted"
+	// The content scanner flagged the CAR file, and it was moved to
+	// quarantine
+	StateQuarantined = "quarantined"
+)
+
+// Record is a retrieval made by the client
+type Record struct {
+	ID         uuid.UUID `json:"id"`
+	PayloadCid cid.Cid   `json:"payloadCid"`
+	// The piece cid, if the retrieval was made by deal
+	PieceCid *cid.Cid `json:"pieceCid,omitempty"`
+	DealID   uint64   `json:"dealId,omitempty"`
+	Provider string   `json:"provider"`
+	// The path of the CAR file. While the retrieval is in progress this is
+	// the path that data is being written to.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Whether the content is scanned before the retrieval completes. The
+	// CAR file of such a retrieval is not served until the scan passes.
+	Scanned bool   `json:"scanned,omitempty"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+	// What was spent on the retrieval (in FIL), if it was metered
+	Spent     string    `json:"spent,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store keeps a record of each retrieval as a JSON file in a directory.
+// Records are stored as files (rather than in a datastore) so that they can
+// be written by retrieval commands while the content is served by another
+// process.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating retrievals dir %s: %w", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Start records a new in-progress retrieval. If the record doesn't have an
+// ID yet, a new ID is assigned.
+func (s *Store) Start(r *Record) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	r.State = StateInProgress
+	r.StartedAt = time.Now()
+	return s.Update(r)
+}
+
+// Complete records that the retrieval finished, with the final path and
+// size of the CAR file
+func (s *Store) Complete(r *Record, path string, size int64) error {
+	r.Path = path
+	r.Size = size
+	r.State = StateComplete
+	return s.Update(r)
+}
+
+// Fail records that the retrieval failed
+func (s *Store) Fail(r *Record, err error) error {
+	r.State = StateFailed
+	r.Error = err.Error()
+	return s.Update(r)
+}
+
+// Abort records that the client aborted the retrieval, and why
+func (s *Store) Abort(r *Record, reason error) error {
+	r.State = StateAborted
+	r.Error = reason.Error()
+	return s.Update(r)
+}
+
+// Quarantine records that the content scanner flagged the retrieved CAR
+// file, with the path that the file was moved to in quarantine
+func (s *Store) Quarantine(r *Record, path string, size int64, reason error) error {
+	r.Path = path
+	r.Size = size
+	r.State = StateQuarantined
+	r.Error = reason.Error()
+	return s.Update(r)
+}
+
+// Update writes the record to the store
+func (s *Store) Update(r *Record) error {
+	r.UpdatedAt = time.Now()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshalling retrieval record: %w", err)
+	}
+
+	// Write to a temporary file and rename, so that readers never see a
+	// partially written record
+	path := s.recordPath(r.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing retrieval record: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing retrieval record: %w", err)
+	}
+	return nil
+}
+
+// Get returns the record with the given id
+func (s *Store) Get(id uuid.UUID) (*Record, error) {
+	b, err := os.ReadFile(s.recordPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("reading retrieval record %s: %w", id, err)
+	}
+
+	var r Record
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("unmarshalling retrieval record %s: %w", id, err)
+	}
+	return &r, nil
+}
+
+// List returns all records, newest first
+func (s *Store) List() ([]Record, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading retrievals dir %s: %w", s.dir, err)
+	}
+
+	var records []Record
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		id, err := uuid.Parse(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		r, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *r)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+	return records, nil
+}
+
+func (s *Store) recordPath(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String()+".json")
+}
@@ -0,0 +1,312 @@
+package shaper
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// The maximum number of bytes written in a single call to the underlying
+// writer. Writes larger than this are split up so that the token buckets
+// can smooth out the flow of data.
+const chunkSize = 64 * 1024
+
+// Shaper limits the bandwidth used by retrieval sessions with token buckets.
+// There is a global limit across all sessions, a limit for each destination
+// (eg a peer ID or IP address) and an optional limit for each session.
+// All limits are in bytes per second, and a limit of zero means unlimited.
+// Limits can be changed at any time, and take effect immediately for
+// sessions that are in progress.
+type Shaper struct {
+	lk            sync.Mutex
+	global        *rate.Limiter
+	globalLimit   uint64
+	destDefault   uint64
+	destOverrides map[string]uint64
+	dests         map[string]*destLimiter
+	sessions      map[uuid.UUID]*Session
+}
+
+type destLimiter struct {
+	lim  *rate.Limiter
+	refs int
+}
+
+// Limits are the bandwidth limits of a Shaper, in bytes per second
+type Limits struct {
+	// The limit across all sessions
+	Global uint64
+	// The default limit for each destination
+	PerDestination uint64
+	// Destinations with a limit that is different to the default
+	DestinationOverrides map[string]uint64
+}
+
+// SessionInfo describes an active session
+type SessionInfo struct {
+	ID          uuid.UUID
+	Destination string
+	// The limit for the session (zero means there is no session-specific
+	// limit)
+	Limit uint64
+	// The number of bytes sent so far
+	Sent uint64
+}
+
+func New(globalLimit uint64, perDestinationLimit uint64) *Shaper {
+	return &Shaper{
+		global:        rate.NewLimiter(toLimit(globalLimit), chunkSize),
+		globalLimit:   globalLimit,
+		destDefault:   perDestinationLimit,
+		destOverrides: make(map[string]uint64),
+		dests:         make(map[string]*destLimiter),
+		sessions:      make(map[uuid.UUID]*Session),
+	}
+}
+
+func toLimit(bytesPerSecond uint64) rate.Limit {
+	if bytesPerSecond == 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bytesPerSecond)
+}
+
+// NewSession starts a new session sending data to the given destination.
+// The session must be closed when it is complete.
+func (s *Shaper) NewSession(dest string, limit uint64) *Session {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	dl, ok := s.dests[dest]
+	if !ok {
+		dl = &destLimiter{lim: rate.NewLimiter(toLimit(s.destLimitLocked(dest)), chunkSize)}
+		s.dests[dest] = dl
+	}
+	dl.refs++
+
+	sess := &Session{
+		id:     uuid.New(),
+		dest:   dest,
+		shaper: s,
+		destLk: dl.lim,
+		lim:    rate.NewLimiter(toLimit(limit), chunkSize),
+		limit:  limit,
+	}
+	s.sessions[sess.id] = sess
+	return sess
+}
+
+func (s *Shaper) destLimitLocked(dest string) uint64 {
+	if l, ok := s.destOverrides[dest]; ok {
+		return l
+	}
+	return s.destDefault
+}
+
+func (s *Shaper) release(sess *Session) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, ok := s.sessions[sess.id]; !ok {
+		return
+	}
+	delete(s.sessions, sess.id)
+
+	dl, ok := s.dests[sess.dest]
+	if !ok {
+		return
+	}
+	dl.refs--
+	if dl.refs == 0 {
+		delete(s.dests, sess.dest)
+	}
+}
+
+// SetGlobalLimit sets the limit across all sessions
+func (s *Shaper) SetGlobalLimit(limit uint64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.globalLimit = limit
+	s.global.SetLimit(toLimit(limit))
+}
+
+// SetDefaultDestinationLimit sets the limit for all destinations that don't
+// have an override
+func (s *Shaper) SetDefaultDestinationLimit(limit uint64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.destDefault = limit
+	for dest, dl := range s.dests {
+		dl.lim.SetLimit(toLimit(s.destLimitLocked(dest)))
+	}
+}
+
+// SetDestinationLimit overrides the default limit for the given destination
+func (s *Shaper) SetDestinationLimit(dest string, limit uint64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.destOverrides[dest] = limit
+	if dl, ok := s.dests[dest]; ok {
+		dl.lim.SetLimit(toLimit(limit))
+	}
+}
+
+// ClearDestinationLimit removes the override for the given destination, so
+// that the default destination limit applies
+func (s *Shaper) ClearDestinationLimit(dest string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	delete(s.destOverrides, dest)
+	if dl, ok := s.dests[dest]; ok {
+		dl.lim.SetLimit(toLimit(s.destDefault))
+	}
+}
+
+// SetSessionLimit sets the limit for an active session.
+// Returns false if there is no active session with the given id.
+func (s *Shaper) SetSessionLimit(id uuid.UUID, limit uint64) bool {
+	s.lk.Lock()
+	sess, ok := s.sessions[id]
+	s.lk.Unlock()
+
+	if !ok {
+		return false
+	}
+	sess.SetLimit(limit)
+	return true
+}
+
+// Limits returns the current limits
+func (s *Shaper) Limits() Limits {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	overrides := make(map[string]uint64, len(s.destOverrides))
+	for dest, l := range s.destOverrides {
+		overrides[dest] = l
+	}
+	return Limits{
+		Global:               s.globalLimit,
+		PerDestination:       s.destDefault,
+		DestinationOverrides: overrides,
+	}
+}
+
+// Sessions returns information about all active sessions
+func (s *Shaper) Sessions() []SessionInfo {
+	s.lk.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.lk.Unlock()
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, sess.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID.String() < infos[j].ID.String()
+	})
+	return infos
+}
+
+// Session is a stream of data sent to a destination
+type Session struct {
+	id     uuid.UUID
+	dest   string
+	shaper *Shaper
+	destLk *rate.Limiter
+
+	lk    sync.Mutex
+	lim   *rate.Limiter
+	limit uint64
+	sent  uint64
+}
+
+func (s *Session) ID() uuid.UUID {
+	return s.id
+}
+
+// SetLimit sets the limit for this session
+func (s *Session) SetLimit(limit uint64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.limit = limit
+	s.lim.SetLimit(toLimit(limit))
+}
+
+func (s *Session) Info() SessionInfo {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return SessionInfo{
+		ID:          s.id,
+		Destination: s.dest,
+		Limit:       s.limit,
+		Sent:        s.sent,
+	}
+}
+
+// Close releases the session's resources
+func (s *Session) Close() {
+	s.shaper.release(s)
+}
+
+// Writer wraps w such that writes to it are shaped by the session, the
+// session's destination, and the global limits
+func (s *Session) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return &shapedWriter{ctx: ctx, w: w, sess: s}
+}
+
+// wait blocks until n bytes may be sent
+func (s *Session) wait(ctx context.Context, n int) error {
+	if err := s.lim.WaitN(ctx, n); err != nil {
+		return err
+	}
+	if err := s.destLk.WaitN(ctx, n); err != nil {
+		return err
+	}
+	return s.shaper.global.WaitN(ctx, n)
+}
+
+type shapedWriter struct {
+	ctx  context.Context
+	w    io.Writer
+	sess *Session
+}
+
+func (sw *shapedWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunkSize {
+			n = chunkSize
+		}
+
+		if err := sw.sess.wait(sw.ctx, n); err != nil {
+			return total, err
+		}
+
+		written, err := sw.w.Write(p[:n])
+		total += written
+		sw.sess.lk.Lock()
+		sw.sess.sent += uint64(written)
+		sw.sess.lk.Unlock()
+		if err != nil {
+			return total, err
+		}
+
+		p = p[n:]
+	}
+	return total, nil
+}
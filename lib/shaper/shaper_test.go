@@ -0,0 +1,66 @@
+package shaper
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShaperUnlimited(t *testing.T) {
+	s := New(0, 0)
+	sess := s.NewSession("peer1", 0)
+	defer sess.Close()
+
+	var buff bytes.Buffer
+	data := make([]byte, 10*chunkSize+1)
+	n, err := sess.Writer(context.Background(), &buff).Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, data, buff.Bytes())
+	require.EqualValues(t, len(data), sess.Info().Sent)
+}
+
+func TestShaperLimits(t *testing.T) {
+	ctx := context.Background()
+	s := New(0, 0)
+
+	// Limit the destination to one chunk per second. The first chunk uses up
+	// the burst, so writing three chunks should take about two seconds.
+	s.SetDestinationLimit("peer1", chunkSize)
+	sess := s.NewSession("peer1", 0)
+	var buff bytes.Buffer
+	start := time.Now()
+	_, err := sess.Writer(ctx, &buff).Write(make([]byte, 3*chunkSize))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 1500*time.Millisecond)
+
+	// Sessions to other destinations are not affected
+	other := s.NewSession("peer2", 0)
+	start = time.Now()
+	_, err = other.Writer(ctx, &buff).Write(make([]byte, 3*chunkSize))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// Removing the limit mid-session takes effect immediately
+	s.ClearDestinationLimit("peer1")
+	start = time.Now()
+	_, err = sess.Writer(ctx, &buff).Write(make([]byte, 3*chunkSize))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// A cancelled context aborts a write that is waiting for tokens
+	require.True(t, s.SetSessionLimit(sess.ID(), 1))
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = sess.Writer(cctx, &buff).Write(make([]byte, 3*chunkSize))
+	require.Error(t, err)
+
+	require.Len(t, s.Sessions(), 2)
+	sess.Close()
+	other.Close()
+	require.Len(t, s.Sessions(), 0)
+	require.False(t, s.SetSessionLimit(sess.ID(), 1))
+}
@@ -0,0 +1,61 @@
+package sla
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// StepStatsResponse is the response times of a provider at one step
+type StepStatsResponse struct {
+	Count      int    `json:"count"`
+	Violations int    `json:"violations"`
+	Mean       string `json:"mean"`
+	Max        string `json:"max"`
+	SLA        string `json:"sla,omitempty"`
+}
+
+// ProviderStatsResponse is the response times of a provider at each step
+type ProviderStatsResponse struct {
+	Provider      string                     `json:"provider"`
+	Deprioritized bool                       `json:"deprioritized"`
+	Steps         map[Step]StepStatsResponse `json:"steps"`
+}
+
+// NewHandler returns a handler that serves the response times of each
+// provider as json
+func NewHandler(t *Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		stats := t.Stats()
+		resp := make([]ProviderStatsResponse, 0, len(stats))
+		for _, ps := range stats {
+			psr := ProviderStatsResponse{
+				Provider:      ps.Provider.String(),
+				Deprioritized: ps.Deprioritized,
+				Steps:         make(map[Step]StepStatsResponse, len(ps.Steps)),
+			}
+			for step, st := range ps.Steps {
+				ssr := StepStatsResponse{
+					Count:      st.Count,
+					Violations: st.Violations,
+					Mean:       st.Mean().String(),
+					Max:        st.Max.String(),
+				}
+				if limit := t.cfg.Limit(step); limit > 0 {
+					ssr.SLA = limit.String()
+				}
+				psr.Steps[step] = ssr
+			}
+			resp = append(resp, psr)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Warnw("writing SLA stats response", "err", err)
+		}
+	})
+}
@@ -0,0 +1,372 @@
+// Package sla measures how long providers take to respond at each step of
+// making a deal, and escalates when a provider is slower than the configured
+// service level:
+//
+//   - every violation is logged and sent to subscribers
+//   - a provider with too many recent violations is deprioritized, so that
+//     deals are proposed to other providers first
+//   - if fail over is enabled, a step that exceeds its SLA is abandoned so
+//     that the deal can be made with another provider
+package sla
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("sla")
+
+// Step is a step of the deal protocol at which the provider's response time
+// is measured
+type Step string
+
+const (
+	// StepAsk is the time the provider takes to respond to a storage ask
+	StepAsk Step = "ask"
+	// StepProposal is the time the provider takes to accept or reject a
+	// deal proposal
+	StepProposal Step = "proposal"
+	// StepTransfer is the time between the provider accepting a deal and
+	// starting the data transfer
+	StepTransfer Step = "transfer"
+)
+
+var Steps = []Step{StepAsk, StepProposal, StepTransfer}
+
+// Config is the SLA for each step, and how to escalate violations
+type Config struct {
+	// The maximum response time for each step. A step with no SLA (zero) is
+	// measured but never violated.
+	Ask      time.Duration
+	Proposal time.Duration
+	Transfer time.Duration
+	// The number of violations within Window after which a provider is
+	// deprioritized. Zero disables deprioritization.
+	DeprioritizeAfter int
+	Window            time.Duration
+	// Abandon a step that exceeds its SLA so that the deal can be made with
+	// another provider
+	FailOver bool
+}
+
+// Limit returns the SLA for the step
+func (c *Config) Limit(step Step) time.Duration {
+	switch step {
+	case StepAsk:
+		return c.Ask
+	case StepProposal:
+		return c.Proposal
+	case StepTransfer:
+		return c.Transfer
+	}
+	return 0
+}
+
+// Violation is sent to subscribers when a provider exceeds the SLA for a step
+type Violation struct {
+	Provider address.Address
+	DealUUID uuid.UUID
+	Step     Step
+	Latency  time.Duration
+	Limit    time.Duration
+	At       time.Time
+	// True if the step was abandoned, and the deal should be made with
+	// another provider
+	FailOver bool
+}
+
+// StepStats is the response times of a provider at one step
+type StepStats struct {
+	Count      int
+	Violations int
+	Total      time.Duration
+	Max        time.Duration
+}
+
+// Mean returns the mean response time
+func (s *StepStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// ProviderStats is the response times of a provider at each step
+type ProviderStats struct {
+	Provider      address.Address
+	Steps         map[Step]StepStats
+	Deprioritized bool
+}
+
+// TransferChecker reports whether the provider has started the data
+// transfer for a deal
+type TransferChecker interface {
+	TransferStarted(ctx context.Context, provider address.Address, dealUUID uuid.UUID) (bool, error)
+}
+
+type pendingTransfer struct {
+	provider   address.Address
+	acceptedAt time.Time
+}
+
+// Tracker records provider response times and escalates SLA violations
+type Tracker struct {
+	cfg Config
+
+	lk         sync.Mutex
+	stats      map[address.Address]map[Step]StepStats
+	violations map[address.Address][]time.Time
+	transfers  map[uuid.UUID]pendingTransfer
+	subs       map[int]chan Violation
+	nextID     int
+}
+
+func NewTracker(cfg Config) *Tracker {
+	return &Tracker{
+		cfg:        cfg,
+		stats:      make(map[address.Address]map[Step]StepStats),
+		violations: make(map[address.Address][]time.Time),
+		transfers:  make(map[uuid.UUID]pendingTransfer),
+		subs:       make(map[int]chan Violation),
+	}
+}
+
+// Subscribe returns a channel of SLA violations, and a function that cancels
+// the subscription. Violations are dropped if the subscriber falls behind.
+func (t *Tracker) Subscribe() (<-chan Violation, func()) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	id := t.nextID
+	t.nextID++
+	ch := make(chan Violation, 16)
+	t.subs[id] = ch
+	return ch, func() {
+		t.lk.Lock()
+		defer t.lk.Unlock()
+		if _, ok := t.subs[id]; ok {
+			delete(t.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// StepContext returns a context that is cancelled when the step exceeds its
+// SLA, if fail over is enabled
+func (t *Tracker) StepContext(ctx context.Context, step Step) (context.Context, context.CancelFunc) {
+	limit := t.cfg.Limit(step)
+	if !t.cfg.FailOver || limit == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, limit)
+}
+
+// Observe records the time the provider took to respond at a step, and
+// returns true if the response time exceeded the SLA
+func (t *Tracker) Observe(provider address.Address, dealUUID uuid.UUID, step Step, latency time.Duration) bool {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.observe(provider, dealUUID, step, latency, time.Now())
+}
+
+func (t *Tracker) observe(provider address.Address, dealUUID uuid.UUID, step Step, latency time.Duration, now time.Time) bool {
+	steps, ok := t.stats[provider]
+	if !ok {
+		steps = make(map[Step]StepStats)
+		t.stats[provider] = steps
+	}
+	st := steps[step]
+	st.Count++
+	st.Total += latency
+	if latency > st.Max {
+		st.Max = latency
+	}
+
+	limit := t.cfg.Limit(step)
+	violated := limit > 0 && latency > limit
+	if violated {
+		st.Violations++
+	}
+	steps[step] = st
+	if !violated {
+		return false
+	}
+
+	t.recordViolation(provider, now)
+	v := Violation{
+		Provider: provider,
+		DealUUID: dealUUID,
+		Step:     step,
+		Latency:  latency,
+		Limit:    limit,
+		At:       now,
+		FailOver: t.cfg.FailOver,
+	}
+	log.Warnw("provider exceeded response time SLA", "provider", provider, "deal", dealUUID, "step", step,
+		"latency", latency, "sla", limit, "fail-over", v.FailOver, "deprioritized", t.deprioritized(provider, now))
+	for _, ch := range t.subs {
+		select {
+		case ch <- v:
+		default:
+			log.Warnw("dropping SLA violation for slow subscriber", "provider", provider)
+		}
+	}
+	return true
+}
+
+// recordViolation records the time of a violation, if violations are used
+// to deprioritize providers. Only the most recent violations that can
+// deprioritize the provider are kept.
+func (t *Tracker) recordViolation(provider address.Address, now time.Time) {
+	if t.cfg.DeprioritizeAfter == 0 {
+		return
+	}
+	vs := append(t.recentViolations(provider, now), now)
+	if len(vs) > t.cfg.DeprioritizeAfter {
+		vs = vs[len(vs)-t.cfg.DeprioritizeAfter:]
+	}
+	// copy the violations so that the slice doesn't keep the backing array
+	// of the expired violations alive
+	t.violations[provider] = append([]time.Time(nil), vs...)
+}
+
+// pruneViolations forgets the providers that have no recent violations
+func (t *Tracker) pruneViolations(now time.Time) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	for provider := range t.violations {
+		if len(t.recentViolations(provider, now)) == 0 {
+			delete(t.violations, provider)
+		}
+	}
+}
+
+// recentViolations returns the times of the provider's violations that are
+// within the window
+func (t *Tracker) recentViolations(provider address.Address, now time.Time) []time.Time {
+	vs := t.violations[provider]
+	if t.cfg.Window == 0 {
+		return vs
+	}
+	cutoff := now.Add(-t.cfg.Window)
+	i := sort.Search(len(vs), func(i int) bool { return vs[i].After(cutoff) })
+	return vs[i:]
+}
+
+// Deprioritized returns true if the provider has had too many recent
+// violations
+func (t *Tracker) Deprioritized(provider address.Address) bool {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.deprioritized(provider, time.Now())
+}
+
+func (t *Tracker) deprioritized(provider address.Address, now time.Time) bool {
+	if t.cfg.DeprioritizeAfter == 0 {
+		return false
+	}
+	return len(t.recentViolations(provider, now)) >= t.cfg.DeprioritizeAfter
+}
+
+// Order returns the providers with deprioritized providers moved to the
+// end. The order is otherwise unchanged.
+func (t *Tracker) Order(providers []address.Address) []address.Address {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	now := time.Now()
+	ordered := make([]address.Address, len(providers))
+	copy(ordered, providers)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !t.deprioritized(ordered[i], now) && t.deprioritized(ordered[j], now)
+	})
+	return ordered
+}
+
+// AwaitTransfer starts measuring the time until the provider starts the
+// data transfer for a deal that it has accepted
+func (t *Tracker) AwaitTransfer(provider address.Address, dealUUID uuid.UUID) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.transfers[dealUUID] = pendingTransfer{provider: provider, acceptedAt: time.Now()}
+}
+
+// CheckTransfers checks whether providers have started the transfers for
+// the deals they accepted. A transfer that has not started within the SLA
+// is a violation, and is no longer watched.
+func (t *Tracker) CheckTransfers(ctx context.Context, checker TransferChecker) {
+	t.lk.Lock()
+	pending := make(map[uuid.UUID]pendingTransfer, len(t.transfers))
+	for id, p := range t.transfers {
+		pending[id] = p
+	}
+	t.lk.Unlock()
+
+	for dealUUID, p := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		started, err := checker.TransferStarted(ctx, p.provider, dealUUID)
+		if err != nil {
+			log.Infow("could not check deal transfer status", "provider", p.provider, "deal", dealUUID, "err", err)
+		}
+
+		now := time.Now()
+		latency := now.Sub(p.acceptedAt)
+		limit := t.cfg.Limit(StepTransfer)
+		if !started && (limit == 0 || latency <= limit) {
+			continue
+		}
+
+		t.lk.Lock()
+		if _, ok := t.transfers[dealUUID]; ok {
+			delete(t.transfers, dealUUID)
+			t.observe(p.provider, dealUUID, StepTransfer, latency, now)
+		}
+		t.lk.Unlock()
+	}
+}
+
+// Run checks transfers and prunes expired violations at the interval until
+// the context is cancelled
+func (t *Tracker) Run(ctx context.Context, checker TransferChecker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.CheckTransfers(ctx, checker)
+			t.pruneViolations(time.Now())
+		}
+	}
+}
+
+// Stats returns the response times of each provider, ordered by provider
+func (t *Tracker) Stats() []ProviderStats {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	now := time.Now()
+	stats := make([]ProviderStats, 0, len(t.stats))
+	for p, steps := range t.stats {
+		ps := ProviderStats{Provider: p, Steps: make(map[Step]StepStats, len(steps)), Deprioritized: t.deprioritized(p, now)}
+		for step, st := range steps {
+			ps.Steps[step] = st
+		}
+		stats = append(stats, ps)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Provider.String() < stats[j].Provider.String()
+	})
+	return stats
+}
@@ -0,0 +1,134 @@
+package sla
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChecker struct {
+	lk      sync.Mutex
+	started map[uuid.UUID]bool
+}
+
+func (m *mockChecker) TransferStarted(ctx context.Context, provider address.Address, dealUUID uuid.UUID) (bool, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.started[dealUUID], nil
+}
+
+func TestTracker(t *testing.T) {
+	req := require.New(t)
+
+	prov1, err := address.NewIDAddress(1)
+	req.NoError(err)
+	prov2, err := address.NewIDAddress(2)
+	req.NoError(err)
+
+	tr := NewTracker(Config{Proposal: time.Second, DeprioritizeAfter: 2, Window: time.Hour})
+	violations, unsub := tr.Subscribe()
+	defer unsub()
+
+	// A response within the SLA is not a violation
+	req.False(tr.Observe(prov1, uuid.New(), StepProposal, 500*time.Millisecond))
+	req.Len(violations, 0)
+
+	// Steps without an SLA are never violated
+	req.False(tr.Observe(prov1, uuid.New(), StepAsk, time.Hour))
+
+	// A slow response is a violation
+	dealUUID := uuid.New()
+	req.True(tr.Observe(prov1, dealUUID, StepProposal, 2*time.Second))
+	v := <-violations
+	req.Equal(prov1, v.Provider)
+	req.Equal(dealUUID, v.DealUUID)
+	req.Equal(StepProposal, v.Step)
+	req.Equal(time.Second, v.Limit)
+	req.False(v.FailOver)
+	req.False(tr.Deprioritized(prov1))
+	req.Equal([]address.Address{prov1, prov2}, tr.Order([]address.Address{prov1, prov2}))
+
+	// After a second violation the provider is deprioritized
+	req.True(tr.Observe(prov1, uuid.New(), StepProposal, 2*time.Second))
+	req.True(tr.Deprioritized(prov1))
+	req.Equal([]address.Address{prov2, prov1}, tr.Order([]address.Address{prov1, prov2}))
+
+	stats := tr.Stats()
+	req.Len(stats, 1)
+	req.True(stats[0].Deprioritized)
+	st := stats[0].Steps[StepProposal]
+	req.Equal(3, st.Count)
+	req.Equal(2, st.Violations)
+	req.Equal(2*time.Second, st.Max)
+	req.Equal(1500*time.Millisecond, st.Mean())
+}
+
+func TestTrackerTransfers(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+
+	tr := NewTracker(Config{Transfer: 50 * time.Millisecond, FailOver: true})
+	violations, unsub := tr.Subscribe()
+	defer unsub()
+
+	started := uuid.New()
+	stalled := uuid.New()
+	checker := &mockChecker{started: map[uuid.UUID]bool{started: true}}
+	tr.AwaitTransfer(prov, started)
+	tr.AwaitTransfer(prov, stalled)
+
+	// The started transfer is measured, the stalled one is still within
+	// the SLA
+	tr.CheckTransfers(ctx, checker)
+	req.Len(violations, 0)
+	req.Equal(1, tr.Stats()[0].Steps[StepTransfer].Count)
+
+	// Once the SLA has passed the stalled transfer is a violation, and is
+	// no longer watched
+	time.Sleep(60 * time.Millisecond)
+	tr.CheckTransfers(ctx, checker)
+	req.Len(violations, 1)
+	v := <-violations
+	req.Equal(stalled, v.DealUUID)
+	req.Equal(StepTransfer, v.Step)
+	req.True(v.FailOver)
+
+	tr.CheckTransfers(ctx, checker)
+	req.Len(violations, 0)
+	req.Equal(2, tr.Stats()[0].Steps[StepTransfer].Count)
+}
+
+func TestTrackerPrunesViolations(t *testing.T) {
+	req := require.New(t)
+
+	prov, err := address.NewIDAddress(1)
+	req.NoError(err)
+
+	tr := NewTracker(Config{Proposal: time.Second, DeprioritizeAfter: 2, Window: time.Hour})
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		tr.observe(prov, uuid.New(), StepProposal, 2*time.Second, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	// Only the violations that can deprioritize the provider are kept
+	req.Len(tr.violations[prov], 2)
+	req.True(tr.deprioritized(prov, start.Add(10*time.Minute)))
+
+	// Once the violations are outside the window the provider is forgotten
+	tr.pruneViolations(start.Add(2 * time.Hour))
+	req.NotContains(tr.violations, prov)
+	req.False(tr.deprioritized(prov, start.Add(2*time.Hour)))
+
+	// Violations are not recorded if they can't deprioritize providers
+	tr = NewTracker(Config{Proposal: time.Second})
+	req.True(tr.Observe(prov, uuid.New(), StepProposal, 2*time.Second))
+	req.Empty(tr.violations)
+}
@@ -0,0 +1,238 @@
+// Package spark integrates with Spark-style retrieval checking networks
+// (such as Filecoin Station's Spark), which measure how reliably storage
+// providers serve retrievals.
+//
+// A Reporter sends the outcomes of the client's retrieval attempts to a
+// measurement endpoint. The measurements are anonymized: they identify the
+// provider and the payload, but nothing about the client (no wallet, peer ID
+// or address), error messages are reduced to a result code, and timestamps
+// are truncated to the hour.
+//
+// Scores are the network-wide retrievability of each provider, as published
+// by a scores endpoint, and are used to try the providers that are most
+// likely to serve a retrieval first.
+package spark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("spark")
+
+const (
+	// ResultOK is the result of an attempt that served the data
+	ResultOK = "OK"
+	// ResultTimeout is the result of an attempt that timed out
+	ResultTimeout = "TIMEOUT"
+	// ResultError is the result of an attempt that failed for any other
+	// reason
+	ResultError = "ERROR"
+)
+
+// Measurement is the anonymized outcome of an attempt to retrieve a payload
+// from a provider
+type Measurement struct {
+	ProviderID string  `json:"providerId"`
+	Cid        cid.Cid `json:"cid"`
+	Protocol   string  `json:"protocol"`
+	// One of OK, TIMEOUT or ERROR
+	Result string `json:"retrievalResult"`
+	// The number of bytes received from the provider
+	ByteLength int64 `json:"byteLength"`
+	// The hour in which the attempt was made
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Measurements returns the anonymized measurements of the attempts to
+// retrieve a payload. Attempts that were aborted by the client (eg because
+// the retrieval was cancelled) say nothing about the provider, so they are
+// left out.
+func Measurements(payloadCid cid.Cid, attempts []carfetch.Attempt, at time.Time) []Measurement {
+	ms := make([]Measurement, 0, len(attempts))
+	for _, a := range attempts {
+		result := ResultOK
+		switch {
+		case a.Error == "":
+		case containsAny(a.Error, context.Canceled.Error(), "retrieval aborted"):
+			continue
+		case containsAny(a.Error, context.DeadlineExceeded.Error(), "timeout"):
+			result = ResultTimeout
+		default:
+			result = ResultError
+		}
+		ms = append(ms, Measurement{
+			ProviderID: a.Source,
+			Cid:        payloadCid,
+			Protocol:   "http",
+			Result:     result,
+			ByteLength: a.Received,
+			Timestamp:  at.UTC().Truncate(time.Hour),
+		})
+	}
+	return ms
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reporter collects measurements and sends them to a measurement endpoint
+// in batches
+type Reporter struct {
+	url    string
+	client *http.Client
+
+	lk      sync.Mutex
+	pending []Measurement
+}
+
+func NewReporter(url string) *Reporter {
+	return &Reporter{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Record queues the measurements of the attempts to retrieve a payload, to
+// be sent with the next Flush
+func (r *Reporter) Record(payloadCid cid.Cid, attempts []carfetch.Attempt) {
+	ms := Measurements(payloadCid, attempts, time.Now())
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.pending = append(r.pending, ms...)
+}
+
+// Flush sends the queued measurements to the endpoint as a JSON array. If
+// sending fails, the measurements are kept to be sent with the next Flush.
+func (r *Reporter) Flush(ctx context.Context) error {
+	r.lk.Lock()
+	batch := r.pending
+	r.pending = nil
+	r.lk.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := r.send(ctx, batch)
+	if err != nil {
+		r.lk.Lock()
+		r.pending = append(batch, r.pending...)
+		r.lk.Unlock()
+		return err
+	}
+	log.Debugw("reported retrieval measurements", "url", r.url, "count", len(batch))
+	return nil
+}
+
+func (r *Reporter) send(ctx context.Context, batch []Measurement) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("reporting measurements to %s: %w", r.url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("reporting measurements to %s: status %d: %s", r.url, resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// MinSamples is the number of measurements a provider must have for its
+// score to be used. Providers with fewer measurements are treated as unknown.
+const MinSamples = 10
+
+// unknownSuccessRate is assumed for providers without a usable score, so
+// that they are tried before providers that are known to fail more often
+// than not, and after providers that are known to succeed
+const unknownSuccessRate = 0.5
+
+// Score is the network-wide retrievability of a provider
+type Score struct {
+	ProviderID string `json:"providerId"`
+	// The fraction of measured retrievals from the provider that succeeded
+	SuccessRate float64 `json:"successRate"`
+	// The number of measurements the success rate is based on
+	Total int `json:"total"`
+}
+
+// Scores are the scores of each provider, by provider ID
+type Scores map[string]Score
+
+// FetchScores gets the scores from a scores endpoint, which returns a JSON
+// array of scores
+func FetchScores(ctx context.Context, url string) (Scores, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching retrievability scores from %s: %w", url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("fetching retrievability scores from %s: status %d: %s", url, resp.StatusCode, msg)
+	}
+
+	var list []Score
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("parsing retrievability scores: %w", err)
+	}
+	scores := make(Scores, len(list))
+	for _, s := range list {
+		if s.ProviderID == "" {
+			return nil, errors.New("parsing retrievability scores: score has no provider id")
+		}
+		scores[s.ProviderID] = s
+	}
+	return scores, nil
+}
+
+// SuccessRate returns the provider's success rate, and false if the
+// provider doesn't have enough measurements for it to be used
+func (s Scores) SuccessRate(providerID string) (float64, bool) {
+	sc, ok := s[providerID]
+	if !ok || sc.Total < MinSamples {
+		return unknownSuccessRate, false
+	}
+	return sc.SuccessRate, true
+}
+
+// Order returns the sources ordered by the highest success rate, keeping the
+// given order for sources with the same success rate
+func (s Scores) Order(sources []carfetch.Source) []carfetch.Source {
+	ordered := append([]carfetch.Source{}, sources...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, _ := s.SuccessRate(ordered[i].Name)
+		rj, _ := s.SuccessRate(ordered[j].Name)
+		return ri > rj
+	})
+	return ordered
+}
@@ -0,0 +1,92 @@
+package spark
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporter(t *testing.T) {
+	ctx := context.Background()
+	mh, err := multihash.Sum([]byte("payload"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	payloadCid := cid.NewCidV1(cid.Raw, mh)
+
+	var received []Measurement
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []Measurement
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		received = append(received, batch...)
+	}))
+	defer srv.Close()
+
+	r := NewReporter(srv.URL)
+	require.NoError(t, r.Flush(ctx))
+	r.Record(payloadCid, []carfetch.Attempt{
+		{Source: "f01000", Error: "retrieving http://10.0.0.1/ipfs: status 500: oops"},
+		{Source: "f02000", Error: "context deadline exceeded"},
+		{Source: "f03000", Received: 100},
+	})
+	r.Record(payloadCid, []carfetch.Attempt{{Source: "f01000", Error: "context canceled"}})
+
+	// Measurements are kept if they can't be sent
+	require.Error(t, r.Flush(ctx))
+	fail = false
+	require.NoError(t, r.Flush(ctx))
+	require.NoError(t, r.Flush(ctx))
+
+	// The cancelled attempt isn't reported, and nothing but the result code
+	// of an error is reported
+	require.Len(t, received, 3)
+	require.Equal(t, "f01000", received[0].ProviderID)
+	require.Equal(t, ResultError, received[0].Result)
+	require.Equal(t, ResultTimeout, received[1].Result)
+	require.Equal(t, ResultOK, received[2].Result)
+	require.EqualValues(t, 100, received[2].ByteLength)
+	require.Equal(t, payloadCid, received[2].Cid)
+	require.Equal(t, received[2].Timestamp, received[2].Timestamp.Truncate(time.Hour))
+}
+
+func TestScores(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]Score{
+			{ProviderID: "f01000", SuccessRate: 0.2, Total: 100},
+			{ProviderID: "f02000", SuccessRate: 0.9, Total: 100},
+			{ProviderID: "f03000", SuccessRate: 1, Total: 2},
+		})
+	}))
+	defer srv.Close()
+
+	scores, err := FetchScores(context.Background(), srv.URL)
+	require.NoError(t, err)
+	rate, ok := scores.SuccessRate("f02000")
+	require.True(t, ok)
+	require.Equal(t, 0.9, rate)
+
+	// Providers with too few measurements are treated as unknown
+	_, ok = scores.SuccessRate("f03000")
+	require.False(t, ok)
+
+	var sources []carfetch.Source
+	for _, name := range []string{"f01000", "f03000", "f04000", "f02000"} {
+		sources = append(sources, carfetch.Source{Name: name})
+	}
+	var names []string
+	for _, src := range scores.Order(sources) {
+		names = append(names, src.Name)
+	}
+	require.Equal(t, []string{"f02000", "f03000", "f04000", "f01000"}, names)
+}
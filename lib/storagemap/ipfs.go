@@ -0,0 +1,130 @@
+package storagemap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// IpfsClient stores and fetches snapshot blocks, and publishes and
+// resolves IPNS names, through the HTTP RPC API of an IPFS node (eg kubo)
+type IpfsClient struct {
+	apiURL string
+	client *http.Client
+}
+
+var _ Blockstore = (*IpfsClient)(nil)
+
+// NewIpfsClient returns a client for the IPFS node with the given RPC API
+// url, eg http://127.0.0.1:5001
+func NewIpfsClient(apiURL string) *IpfsClient {
+	return &IpfsClient{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Put stores a dag-cbor block, and pins it so that the IPFS node keeps it
+func (c *IpfsClient) Put(ctx context.Context, data []byte) (cid.Cid, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "block")
+	if err != nil {
+		return cid.Undef, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return cid.Undef, err
+	}
+	if err := mw.Close(); err != nil {
+		return cid.Undef, err
+	}
+
+	args := url.Values{"cid-codec": {"dag-cbor"}, "mhtype": {"sha2-256"}, "pin": {"true"}}
+	var res struct {
+		Key string
+	}
+	if err := c.call(ctx, "block/put", args, mw.FormDataContentType(), &body, &res); err != nil {
+		return cid.Undef, err
+	}
+	return cid.Parse(res.Key)
+}
+
+// Get returns the data of the block with the given cid
+func (c *IpfsClient) Get(ctx context.Context, blk cid.Cid) ([]byte, error) {
+	resp, err := c.post(ctx, "block/get", url.Values{"arg": {blk.String()}}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	return io.ReadAll(resp.Body)
+}
+
+// Publish publishes root under the IPNS name of the key with the given name
+// in the IPFS node's keystore ("self" is the node's own key), and returns
+// the IPNS name
+func (c *IpfsClient) Publish(ctx context.Context, root cid.Cid, key string) (string, error) {
+	args := url.Values{"arg": {"/ipfs/" + root.String()}, "key": {key}, "allow-offline": {"true"}}
+	var res struct {
+		Name string
+	}
+	if err := c.call(ctx, "name/publish", args, "", nil, &res); err != nil {
+		return "", err
+	}
+	return res.Name, nil
+}
+
+// Resolve returns the root that an IPNS name points to
+func (c *IpfsClient) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	var res struct {
+		Path string
+	}
+	if err := c.call(ctx, "name/resolve", url.Values{"arg": {name}}, "", nil, &res); err != nil {
+		return cid.Undef, err
+	}
+	return cid.Parse(strings.TrimPrefix(res.Path, "/ipfs/"))
+}
+
+// call calls an RPC API method and decodes the json response into res
+func (c *IpfsClient) call(ctx context.Context, method string, args url.Values, contentType string, body io.Reader, res interface{}) error {
+	resp, err := c.post(ctx, method, args, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("decoding response to %s: %w", method, err)
+	}
+	return nil
+}
+
+// post sends a request to an RPC API method. All methods of the RPC API
+// must be called with POST.
+func (c *IpfsClient) post(ctx context.Context, method string, args url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	u := c.apiURL + "/api/v0/" + method + "?" + args.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling ipfs %s: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close() //nolint:errcheck
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("ipfs %s returned status %d: %s", method, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
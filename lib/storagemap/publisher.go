@@ -0,0 +1,114 @@
+package storagemap
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+)
+
+// Namer publishes a root under an IPNS name
+type Namer interface {
+	Publish(ctx context.Context, root cid.Cid, key string) (string, error)
+}
+
+// SnapshotFn returns the pieces of the dataset and their deals, or false if
+// the pieces are not yet known (eg the deals have not been checked yet)
+type SnapshotFn func() ([]Piece, bool)
+
+type PublisherConfig struct {
+	// The name of the dataset
+	Dataset string
+	// The address of the client wallet that signs snapshots
+	Client address.Address
+	// The name of the IPFS key to publish the latest snapshot under, or
+	// empty to not publish to IPNS
+	IpnsKey string
+	// The root of the last snapshot published by a previous run, if any.
+	// The first snapshot links to it.
+	Previous *cid.Cid
+}
+
+// Publisher periodically publishes snapshots of a dataset's storage map
+type Publisher struct {
+	bs       Blockstore
+	namer    Namer
+	sign     SignFn
+	snapshot SnapshotFn
+	cfg      PublisherConfig
+
+	lk         sync.Mutex
+	last       *cid.Cid
+	lastPieces []Piece
+}
+
+func NewPublisher(bs Blockstore, namer Namer, sign SignFn, snapshot SnapshotFn, cfg PublisherConfig) *Publisher {
+	return &Publisher{
+		bs:       bs,
+		namer:    namer,
+		sign:     sign,
+		snapshot: snapshot,
+		cfg:      cfg,
+		last:     cfg.Previous,
+	}
+}
+
+// Publish stores a new signed snapshot if the storage map has changed since
+// the last snapshot, and (re-)publishes the latest snapshot to IPNS. It
+// returns the root of the latest snapshot, or cid.Undef if there is
+// nothing to publish yet.
+func (p *Publisher) Publish(ctx context.Context) (cid.Cid, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	pieces, ok := p.snapshot()
+	if !ok {
+		return cid.Undef, nil
+	}
+
+	if p.last == nil || p.lastPieces == nil || !reflect.DeepEqual(pieces, p.lastPieces) {
+		root, err := Put(ctx, p.bs, p.sign, p.cfg.Client, Snapshot{
+			Dataset:   p.cfg.Dataset,
+			Timestamp: time.Now().Unix(),
+			Pieces:    pieces,
+			Previous:  p.last,
+		})
+		if err != nil {
+			return cid.Undef, err
+		}
+		log.Infow("stored storage map snapshot", "dataset", p.cfg.Dataset, "root", root, "pieces", len(pieces))
+		p.last = &root
+		p.lastPieces = pieces
+	}
+
+	// Publish the latest snapshot to IPNS even if it hasn't changed, so
+	// that the IPNS record doesn't expire
+	if p.cfg.IpnsKey != "" {
+		name, err := p.namer.Publish(ctx, *p.last, p.cfg.IpnsKey)
+		if err != nil {
+			return *p.last, fmt.Errorf("publishing storage map snapshot %s to ipns: %w", *p.last, err)
+		}
+		log.Infow("published storage map snapshot to ipns", "dataset", p.cfg.Dataset, "root", *p.last, "name", name)
+	}
+	return *p.last, nil
+}
+
+// Run publishes a snapshot at each interval until the context is cancelled
+func (p *Publisher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Publish(ctx); err != nil && ctx.Err() == nil {
+			log.Warnw("publishing storage map snapshot", "dataset", p.cfg.Dataset, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package storagemap publishes signed snapshots of a client's storage map:
+// the deals that store each piece of a dataset, and the health of each deal
+// as last seen by the client.
+//
+// A snapshot is stored as dag-cbor IPLD blocks on IPFS, so that anyone can
+// discover where the dataset is stored, and verify that the map was signed
+// by the client, without access to the client node. The root block is a
+// SignedSnapshot, which links to the Snapshot and holds the client's
+// signature over the Snapshot's cid. Each snapshot links to the previous
+// one, so the history of the storage map can be followed back. The latest
+// root may also be published under an IPNS name.
+package storagemap
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	bindnoderegistry "github.com/ipld/go-ipld-prime/node/bindnode/registry"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("storagemap")
+
+// Version is the version of the snapshot format
+const Version = 1
+
+// Deal is a deal that stores a replica of a piece
+type Deal struct {
+	DealID   int64
+	Provider string
+	// Why the client considers the deal to be at risk (empty if it is
+	// healthy)
+	Risks []string
+}
+
+// Piece is a piece of the dataset, and the deals that store its replicas
+type Piece struct {
+	PieceCid   cid.Cid
+	PayloadCid *cid.Cid
+	Deals      []Deal
+}
+
+// Snapshot is the storage map of a dataset at a point in time
+type Snapshot struct {
+	Version int64
+	Dataset string
+	// The address of the client wallet that signs the snapshot
+	Client string
+	// When the snapshot was taken, in seconds since the unix epoch
+	Timestamp int64
+	Pieces    []Piece
+	// The root of the previous signed snapshot of the dataset, if any
+	Previous *cid.Cid
+}
+
+// SignedSnapshot is the root block of a published snapshot
+type SignedSnapshot struct {
+	Snapshot cid.Cid
+	// The client's signature over the bytes of the snapshot cid, in the
+	// format of crypto.Signature.MarshalBinary
+	Signature []byte
+}
+
+//go:embed storagemap.ipldsch
+var embedSchema []byte
+
+var bindnodeRegistry = bindnoderegistry.NewRegistry()
+
+func init() {
+	for _, r := range []struct {
+		typ     interface{}
+		typName string
+	}{
+		{(*Snapshot)(nil), "Snapshot"},
+		{(*SignedSnapshot)(nil), "SignedSnapshot"},
+	} {
+		if err := bindnodeRegistry.RegisterType(r.typ, string(embedSchema), r.typName); err != nil {
+			panic(err.Error())
+		}
+	}
+}
+
+var cidPrefix = cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}
+
+// Blockstore stores and fetches the blocks of snapshots (eg on IPFS)
+type Blockstore interface {
+	// Put stores a dag-cbor block and returns its cid
+	Put(ctx context.Context, data []byte) (cid.Cid, error)
+	// Get returns the data of the block with the given cid
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+// SignFn signs msg with the private key for addr
+type SignFn func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error)
+
+// Put signs the snapshot with the client's wallet key and stores it, and
+// returns the cid of the root (SignedSnapshot) block
+func Put(ctx context.Context, bs Blockstore, sign SignFn, client address.Address, s Snapshot) (cid.Cid, error) {
+	s.Version = Version
+	s.Client = client.String()
+
+	snapshotCid, err := putBlock(ctx, bs, &s)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("storing snapshot: %w", err)
+	}
+
+	sig, err := sign(ctx, client, snapshotCid.Bytes())
+	if err != nil {
+		return cid.Undef, fmt.Errorf("signing snapshot with %s: %w", client, err)
+	}
+	sigBytes, err := sig.MarshalBinary()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("serializing snapshot signature: %w", err)
+	}
+
+	root, err := putBlock(ctx, bs, &SignedSnapshot{Snapshot: snapshotCid, Signature: sigBytes})
+	if err != nil {
+		return cid.Undef, fmt.Errorf("storing signed snapshot: %w", err)
+	}
+	return root, nil
+}
+
+// Get fetches the snapshot with the given root, and verifies that it was
+// signed by the client address in the snapshot
+func Get(ctx context.Context, bs Blockstore, root cid.Cid) (*Snapshot, error) {
+	v, err := getBlock(ctx, bs, root, (*SignedSnapshot)(nil))
+	if err != nil {
+		return nil, fmt.Errorf("fetching signed snapshot: %w", err)
+	}
+	signed := v.(*SignedSnapshot)
+	v, err = getBlock(ctx, bs, signed.Snapshot, (*Snapshot)(nil))
+	if err != nil {
+		return nil, fmt.Errorf("fetching snapshot: %w", err)
+	}
+	s := v.(*Snapshot)
+
+	if s.Version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	client, err := address.NewFromString(s.Client)
+	if err != nil {
+		return nil, fmt.Errorf("parsing snapshot client address '%s': %w", s.Client, err)
+	}
+	var sig crypto.Signature
+	if err := sig.UnmarshalBinary(signed.Signature); err != nil {
+		return nil, fmt.Errorf("parsing snapshot signature: %w", err)
+	}
+	if err := sigs.Verify(&sig, client, signed.Snapshot.Bytes()); err != nil {
+		return nil, fmt.Errorf("invalid snapshot signature for client %s: %w", client, err)
+	}
+	return s, nil
+}
+
+func putBlock(ctx context.Context, bs Blockstore, v interface{}) (cid.Cid, error) {
+	data, err := bindnodeRegistry.TypeToBytes(v, dagcbor.Encode)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("encoding block: %w", err)
+	}
+	expected, err := cidPrefix.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c, err := bs.Put(ctx, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !c.Equals(expected) {
+		return cid.Undef, fmt.Errorf("block was stored with cid %s but expected %s", c, expected)
+	}
+	return c, nil
+}
+
+// getBlock fetches a block and checks that its data matches its cid, so
+// that the blocks can be fetched from an untrusted source. It returns a
+// pointer to a new value of the type of ptrType.
+func getBlock(ctx context.Context, bs Blockstore, c cid.Cid, ptrType interface{}) (interface{}, error) {
+	if c.Prefix().Codec != cid.DagCBOR {
+		return nil, fmt.Errorf("block %s is not dag-cbor", c)
+	}
+	data, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	actual, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, fmt.Errorf("hashing block %s: %w", c, err)
+	}
+	if !actual.Equals(c) {
+		return nil, fmt.Errorf("block data does not match cid %s", c)
+	}
+	v, err := bindnodeRegistry.TypeFromBytes(data, ptrType, dagcbor.Decode)
+	if err != nil {
+		return nil, fmt.Errorf("decoding block %s: %w", c, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,229 @@
+package storagemap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet/key"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+type memBlockstore struct {
+	lk     sync.Mutex
+	blocks map[cid.Cid][]byte
+}
+
+func newMemBlockstore() *memBlockstore {
+	return &memBlockstore{blocks: make(map[cid.Cid][]byte)}
+}
+
+func (bs *memBlockstore) Put(ctx context.Context, data []byte) (cid.Cid, error) {
+	bs.lk.Lock()
+	defer bs.lk.Unlock()
+	c, err := cidPrefix.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	bs.blocks[c] = data
+	return c, nil
+}
+
+func (bs *memBlockstore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	bs.lk.Lock()
+	defer bs.lk.Unlock()
+	data, ok := bs.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return data, nil
+}
+
+func newSigner(t *testing.T) (address.Address, SignFn) {
+	k, err := key.GenerateKey(types.KTSecp256k1)
+	require.NoError(t, err)
+	return k.Address, func(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+		return sigs.Sign(crypto.SigTypeSecp256k1, k.PrivateKey, msg)
+	}
+}
+
+func testPieces() []Piece {
+	payloadCid := testutil.GenerateCid()
+	return []Piece{{
+		PieceCid:   testutil.GenerateCid(),
+		PayloadCid: &payloadCid,
+		Deals: []Deal{
+			{DealID: 1, Provider: "f01000"},
+			{DealID: 2, Provider: "f01001", Risks: []string{"deal is no longer active"}},
+		},
+	}, {
+		PieceCid: testutil.GenerateCid(),
+		Deals:    []Deal{{DealID: 3, Provider: "f01002"}},
+	}}
+}
+
+func TestPutAndGet(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	bs := newMemBlockstore()
+	client, sign := newSigner(t)
+
+	pieces := testPieces()
+	root, err := Put(ctx, bs, sign, client, Snapshot{Dataset: "ds", Timestamp: 1234, Pieces: pieces})
+	req.NoError(err)
+
+	s, err := Get(ctx, bs, root)
+	req.NoError(err)
+	req.EqualValues(Version, s.Version)
+	req.Equal("ds", s.Dataset)
+	req.Equal(client.String(), s.Client)
+	req.EqualValues(1234, s.Timestamp)
+	req.Equal(pieces, s.Pieces)
+	req.Nil(s.Previous)
+
+	// A snapshot that claims to be signed by another client is rejected
+	v, err := getBlock(ctx, bs, root, (*SignedSnapshot)(nil))
+	req.NoError(err)
+	signed := v.(*SignedSnapshot)
+	other, _ := newSigner(t)
+	forged := *s
+	forged.Client = other.String()
+	forgedCid, err := putBlock(ctx, bs, &forged)
+	req.NoError(err)
+	forgedRoot, err := putBlock(ctx, bs, &SignedSnapshot{Snapshot: forgedCid, Signature: signed.Signature})
+	req.NoError(err)
+	_, err = Get(ctx, bs, forgedRoot)
+	req.ErrorContains(err, "invalid snapshot signature")
+
+	// A block whose data doesn't match its cid is rejected
+	bs.blocks[signed.Snapshot] = bs.blocks[forgedCid]
+	_, err = Get(ctx, bs, root)
+	req.ErrorContains(err, "does not match cid")
+}
+
+func TestPublisher(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	bs := newMemBlockstore()
+	client, sign := newSigner(t)
+
+	var pieces []Piece
+	snapshot := func() ([]Piece, bool) {
+		return pieces, pieces != nil
+	}
+	namer := &mockNamer{}
+	p := NewPublisher(bs, namer, sign, snapshot, PublisherConfig{Dataset: "ds", Client: client, IpnsKey: "self"})
+
+	// Nothing is published until the pieces are known
+	root, err := p.Publish(ctx)
+	req.NoError(err)
+	req.Equal(cid.Undef, root)
+	req.Empty(namer.published)
+
+	pieces = testPieces()
+	first, err := p.Publish(ctx)
+	req.NoError(err)
+	req.Equal([]cid.Cid{first}, namer.published)
+
+	// If the storage map hasn't changed, the same snapshot is re-published
+	root, err = p.Publish(ctx)
+	req.NoError(err)
+	req.Equal(first, root)
+	req.Equal([]cid.Cid{first, first}, namer.published)
+
+	// A changed storage map is published in a new snapshot that links to
+	// the previous one
+	pieces = testPieces()[:1]
+	second, err := p.Publish(ctx)
+	req.NoError(err)
+	req.NotEqual(first, second)
+	req.Equal([]cid.Cid{first, first, second}, namer.published)
+
+	s, err := Get(ctx, bs, second)
+	req.NoError(err)
+	req.Len(s.Pieces, 1)
+	req.NotNil(s.Previous)
+	req.Equal(first, *s.Previous)
+}
+
+type mockNamer struct {
+	published []cid.Cid
+}
+
+func (n *mockNamer) Publish(ctx context.Context, root cid.Cid, key string) (string, error) {
+	n.published = append(n.published, root)
+	return "k51name", nil
+}
+
+// TestIpfsClient checks the client against a fake IPFS RPC API
+func TestIpfsClient(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	bs := newMemBlockstore()
+	var ipnsRoot string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v0/block/put", func(w http.ResponseWriter, r *http.Request) {
+		req.Equal(http.MethodPost, r.Method)
+		req.Equal("dag-cbor", r.URL.Query().Get("cid-codec"))
+		f, _, err := r.FormFile("file")
+		req.NoError(err)
+		data, err := io.ReadAll(f)
+		req.NoError(err)
+		c, err := bs.Put(ctx, data)
+		req.NoError(err)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"Key": c.String(), "Size": len(data)})
+	})
+	mux.HandleFunc("/api/v0/block/get", func(w http.ResponseWriter, r *http.Request) {
+		c, err := cid.Parse(r.URL.Query().Get("arg"))
+		req.NoError(err)
+		data, err := bs.Get(ctx, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("/api/v0/name/publish", func(w http.ResponseWriter, r *http.Request) {
+		req.Equal("self", r.URL.Query().Get("key"))
+		ipnsRoot = r.URL.Query().Get("arg")
+		_ = json.NewEncoder(w).Encode(map[string]string{"Name": "k51name", "Value": ipnsRoot})
+	})
+	mux.HandleFunc("/api/v0/name/resolve", func(w http.ResponseWriter, r *http.Request) {
+		req.Equal("/ipns/k51name", r.URL.Query().Get("arg"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"Path": ipnsRoot})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ipfs := NewIpfsClient(srv.URL + "/")
+	client, sign := newSigner(t)
+	root, err := Put(ctx, ipfs, sign, client, Snapshot{Dataset: "ds", Pieces: testPieces()})
+	req.NoError(err)
+
+	name, err := ipfs.Publish(ctx, root, "self")
+	req.NoError(err)
+	req.Equal("k51name", name)
+	resolved, err := ipfs.Resolve(ctx, "/ipns/"+name)
+	req.NoError(err)
+	req.Equal(root, resolved)
+
+	s, err := Get(ctx, ipfs, resolved)
+	req.NoError(err)
+	req.Equal("ds", s.Dataset)
+
+	_, err = ipfs.Get(ctx, testutil.GenerateCid())
+	req.ErrorContains(err, "returned status 500")
+}
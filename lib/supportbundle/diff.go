@@ -0,0 +1,156 @@
+package supportbundle
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotDiff is the difference between two snapshots
+type SnapshotDiff struct {
+	From, To                 time.Time
+	FromVersion, ToVersion   string
+	DealsAdded, DealsRemoved []Deal
+	DealsChanged             []Change
+	TransfersAdded           []Transfer
+	TransfersRemoved         []Transfer
+	TransfersChanged         []Change
+	// Lines of the config that were removed ("-") or added ("+")
+	ConfigChanges []string
+}
+
+// Empty is true if nothing changed between the snapshots
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.DealsAdded) == 0 && len(d.DealsRemoved) == 0 && len(d.DealsChanged) == 0 &&
+		len(d.TransfersAdded) == 0 && len(d.TransfersRemoved) == 0 && len(d.TransfersChanged) == 0 &&
+		len(d.ConfigChanges) == 0 && d.FromVersion == d.ToVersion
+}
+
+// Change is the fields of a deal or transfer that changed
+type Change struct {
+	ID     string
+	Fields []FieldChange
+}
+
+type FieldChange struct {
+	Field    string
+	From, To string
+}
+
+// Diff compares the snapshot a with the later snapshot b
+func Diff(a, b *Snapshot) *SnapshotDiff {
+	d := &SnapshotDiff{
+		From:        a.TakenAt,
+		To:          b.TakenAt,
+		FromVersion: a.BoostVersion,
+		ToVersion:   b.BoostVersion,
+	}
+
+	aDeals := make(map[string]Deal, len(a.Deals))
+	for _, deal := range a.Deals {
+		aDeals[deal.UUID] = deal
+	}
+	bDeals := make(map[string]Deal, len(b.Deals))
+	for _, deal := range b.Deals {
+		bDeals[deal.UUID] = deal
+		prev, ok := aDeals[deal.UUID]
+		if !ok {
+			d.DealsAdded = append(d.DealsAdded, deal)
+			continue
+		}
+		if fields := diffFields(prev, deal); len(fields) > 0 {
+			d.DealsChanged = append(d.DealsChanged, Change{ID: deal.UUID, Fields: fields})
+		}
+	}
+	for _, deal := range a.Deals {
+		if _, ok := bDeals[deal.UUID]; !ok {
+			d.DealsRemoved = append(d.DealsRemoved, deal)
+		}
+	}
+
+	aXfers := make(map[string]Transfer, len(a.Transfers))
+	for _, xfer := range a.Transfers {
+		aXfers[xfer.ID] = xfer
+	}
+	bXfers := make(map[string]Transfer, len(b.Transfers))
+	for _, xfer := range b.Transfers {
+		bXfers[xfer.ID] = xfer
+		prev, ok := aXfers[xfer.ID]
+		if !ok {
+			d.TransfersAdded = append(d.TransfersAdded, xfer)
+			continue
+		}
+		if fields := diffFields(prev, xfer); len(fields) > 0 {
+			d.TransfersChanged = append(d.TransfersChanged, Change{ID: xfer.ID, Fields: fields})
+		}
+	}
+	for _, xfer := range a.Transfers {
+		if _, ok := bXfers[xfer.ID]; !ok {
+			d.TransfersRemoved = append(d.TransfersRemoved, xfer)
+		}
+	}
+
+	d.ConfigChanges = diffLines(a.Config, b.Config)
+	return d
+}
+
+// diffFields returns the fields of the two structs (of the same type) that
+// are different
+func diffFields(a, b interface{}) []FieldChange {
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+	var fields []FieldChange
+	for i := 0; i < av.NumField(); i++ {
+		af := av.Field(i).Interface()
+		bf := bv.Field(i).Interface()
+		if reflect.DeepEqual(af, bf) {
+			continue
+		}
+		fields = append(fields, FieldChange{
+			Field: av.Type().Field(i).Name,
+			From:  formatField(af),
+			To:    formatField(bf),
+		})
+	}
+	return fields
+}
+
+func formatField(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// diffLines returns the lines that are only in a (prefixed with "-") and
+// the lines that are only in b (prefixed with "+")
+func diffLines(a, b string) []string {
+	count := func(s string) map[string]int {
+		counts := make(map[string]int)
+		for _, l := range strings.Split(s, "\n") {
+			if strings.TrimSpace(l) != "" {
+				counts[l]++
+			}
+		}
+		return counts
+	}
+	aLines := count(a)
+	bLines := count(b)
+
+	var removed, added []string
+	for l, n := range aLines {
+		for i := bLines[l]; i < n; i++ {
+			removed = append(removed, "-"+l)
+		}
+	}
+	for l, n := range bLines {
+		for i := aLines[l]; i < n; i++ {
+			added = append(added, "+"+l)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	return append(removed, added...)
+}
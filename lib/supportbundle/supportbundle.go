@@ -0,0 +1,297 @@
+// Package supportbundle snapshots the state of boost's deals and transfers,
+// their recent logs and boost's config into an archive that can be attached
+// to a bug report, and compares two snapshots to show what changed between
+// them.
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+)
+
+// The version of the snapshot format
+const Version = 1
+
+const (
+	snapshotName = "snapshot.json"
+	configName   = "config.toml"
+	logsName     = "deal-logs.txt"
+)
+
+// SnapshotParams controls what is included in a snapshot
+type SnapshotParams struct {
+	// The number of most recently created deals to include, in addition to
+	// the active deals
+	RecentDeals int
+	// The maximum number of log lines to include for each deal (the most
+	// recent lines are kept)
+	LogsPerDeal int
+}
+
+// Snapshot is the state of boost at a point in time
+type Snapshot struct {
+	Version      int
+	TakenAt      time.Time
+	BoostVersion string
+	Deals        []Deal
+	Transfers    []Transfer
+	Logs         []Log
+	// The config file, with secrets redacted
+	Config string
+}
+
+// Deal is the state of a deal. It doesn't include the transfer parameters,
+// which may contain credentials.
+type Deal struct {
+	UUID         string
+	CreatedAt    time.Time
+	Client       string
+	ClientPeer   string
+	PieceCid     string
+	PieceSize    uint64
+	Verified     bool
+	StartEpoch   int64
+	EndEpoch     int64
+	IsOffline    bool
+	TransferType string
+	TransferHost string
+	TransferSize uint64
+	Transferred  int64
+	Checkpoint   string
+	CheckpointAt time.Time
+	Err          string
+	Retry        string
+	ChainDealID  uint64
+	PublishCID   string
+	SectorID     uint64
+}
+
+// NewDeal gets the snapshot of the deal state
+func NewDeal(d *types.ProviderDealState) Deal {
+	prop := d.ClientDealProposal.Proposal
+	deal := Deal{
+		UUID:         d.DealUuid.String(),
+		CreatedAt:    d.CreatedAt,
+		Client:       prop.Client.String(),
+		ClientPeer:   d.ClientPeerID.String(),
+		PieceCid:     prop.PieceCID.String(),
+		PieceSize:    uint64(prop.PieceSize),
+		Verified:     prop.VerifiedDeal,
+		StartEpoch:   int64(prop.StartEpoch),
+		EndEpoch:     int64(prop.EndEpoch),
+		IsOffline:    d.IsOffline,
+		TransferType: d.Transfer.Type,
+		TransferSize: d.Transfer.Size,
+		Transferred:  d.NBytesReceived,
+		Checkpoint:   d.Checkpoint.String(),
+		CheckpointAt: d.CheckpointAt,
+		Err:          RedactText(d.Err),
+		Retry:        string(d.Retry),
+		ChainDealID:  uint64(d.ChainDealID),
+		SectorID:     uint64(d.SectorID),
+	}
+	if !d.IsOffline {
+		if host, err := d.Transfer.Host(); err == nil {
+			deal.TransferHost = host
+		}
+	}
+	if d.PublishCID != nil {
+		deal.PublishCID = d.PublishCID.String()
+	}
+	return deal
+}
+
+// Transfer is the state of a data transfer
+type Transfer struct {
+	// The deal uuid for boost transfers, or the channel ID for legacy
+	// (go-data-transfer) transfers
+	ID string
+	// "boost" or "legacy"
+	Kind        string
+	Status      string
+	Peer        string
+	Transferred uint64
+	Message     string
+}
+
+// Log is a line of a deal's log
+type Log struct {
+	DealUUID  string
+	CreatedAt time.Time
+	Level     string
+	Subsystem string
+	Msg       string
+	Params    string
+}
+
+// redactKeys matches config keys whose values are redacted
+var redactKeys = regexp.MustCompile(`(?i)(token|secret|password|passphrase|privatekey)`)
+
+// apiInfoKeys matches config keys whose values have the form token:multiaddr
+var apiInfoKeys = regexp.MustCompile(`(?i)apiinfo$`)
+
+var configLine = regexp.MustCompile(`^(\s*)([A-Za-z0-9_]+)(\s*=\s*)"(.*)"(\s*)$`)
+
+const redacted = "<redacted>"
+
+// RedactConfig returns the config file with secrets (API tokens, and
+// passwords in URLs) replaced by placeholders
+func RedactConfig(cfg string) string {
+	lines := strings.Split(cfg, "\n")
+	for i, line := range lines {
+		m := configLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		indent, key, eq, val, trail := m[1], m[2], m[3], m[4], m[5]
+		if val == "" {
+			continue
+		}
+		switch {
+		case redactKeys.MatchString(key):
+			val = redacted
+		case apiInfoKeys.MatchString(key):
+			if tok, maddr, ok := strings.Cut(val, ":"); ok && tok != "" {
+				val = redacted + ":" + maddr
+			}
+		default:
+			if u, err := url.Parse(val); err == nil && u.User != nil {
+				val = u.Redacted()
+			}
+		}
+		lines[i] = indent + key + eq + `"` + val + `"` + trail
+	}
+	return strings.Join(lines, "\n")
+}
+
+// urlPattern matches the urls in text
+var urlPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s"'<>\\]+`)
+
+// authHeaderPattern matches auth headers and their values, quoted or not,
+// eg "Authorization":"Bearer abc" or Cookie: abc
+var authHeaderPattern = regexp.MustCompile(`(?i)("?(?:authorization|proxy-authorization|cookie|x-api-key|x-auth-token)"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|\S+)`)
+
+// authSchemePattern matches credentials that follow an auth scheme
+var authSchemePattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+
+// RedactText returns the text (eg a deal log line or error) with secrets
+// replaced by placeholders: passwords and query strings in urls (which
+// often carry signatures or tokens), and auth headers
+func RedactText(s string) string {
+	s = urlPattern.ReplaceAllStringFunc(s, redactURL)
+	s = authHeaderPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sm := authHeaderPattern.FindStringSubmatch(m)
+		if strings.HasPrefix(sm[2], `"`) {
+			return sm[1] + `"` + redacted + `"`
+		}
+		return sm[1] + redacted
+	})
+	return authSchemePattern.ReplaceAllString(s, "$1 "+redacted)
+}
+
+// redactURL replaces the password and the values of the query parameters of
+// the url
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			if k, _, ok := strings.Cut(p, "="); ok {
+				params[i] = k + "=xxxxx"
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.Redacted()
+}
+
+// Write writes the snapshot as a bundle: a gzipped tar file with the
+// snapshot, and the config and logs in a form that is easy to read
+func Write(out io.Writer, s *Snapshot) error {
+	bz, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling snapshot: %w", err)
+	}
+
+	var logs strings.Builder
+	for _, l := range s.Logs {
+		fmt.Fprintf(&logs, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			l.CreatedAt.Format(time.RFC3339Nano), l.DealUUID, l.Level, l.Subsystem, l.Msg, l.Params)
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{snapshotName, bz},
+		{configName, []byte(s.Config)},
+		{logsName, []byte(logs.String())},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.content)),
+			ModTime:  s.TakenAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read reads the snapshot from a bundle
+func Read(in io.Reader) (*Snapshot, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("reading support bundle: %w", err)
+	}
+	defer gz.Close() //nolint:errcheck
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("support bundle has no snapshot")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading support bundle: %w", err)
+		}
+		if hdr.Name != snapshotName {
+			continue
+		}
+
+		var s Snapshot
+		if err := json.NewDecoder(tr).Decode(&s); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if s.Version > Version {
+			return nil, fmt.Errorf("unsupported snapshot version %d (this version of boost supports up to version %d)",
+				s.Version, Version)
+		}
+		return &s, nil
+	}
+}
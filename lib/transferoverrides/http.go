@@ -0,0 +1,119 @@
+package transferoverrides
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/gorilla/mux"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("transferoverrides")
+
+// NewHandler returns an http handler for editing the transfer overrides:
+//
+//	GET    /transfer-overrides              list the overrides
+//	GET    /transfer-overrides/{provider}   get the override for a provider
+//	PUT    /transfer-overrides/{provider}   set the override for a provider
+//	DELETE /transfer-overrides/{provider}   remove the override for a provider
+func NewHandler(store *Store) http.Handler {
+	h := &handler{store: store}
+	r := mux.NewRouter()
+	r.HandleFunc("/transfer-overrides", h.list).Methods(http.MethodGet)
+	r.HandleFunc("/transfer-overrides/{provider}", h.get).Methods(http.MethodGet)
+	r.HandleFunc("/transfer-overrides/{provider}", h.set).Methods(http.MethodPut)
+	r.HandleFunc("/transfer-overrides/{provider}", h.remove).Methods(http.MethodDelete)
+	return r
+}
+
+type handler struct {
+	store *Store
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	overrides, err := h.store.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, overrides)
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	provider, ok := providerFromPath(w, r)
+	if !ok {
+		return
+	}
+	o, err := h.store.Get(provider)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *handler) set(w http.ResponseWriter, r *http.Request) {
+	provider, ok := providerFromPath(w, r)
+	if !ok {
+		return
+	}
+	var o Override
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %w", err))
+		return
+	}
+	o.Provider = provider.String()
+	if err := o.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.store.Set(o); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *handler) remove(w http.ResponseWriter, r *http.Request) {
+	provider, ok := providerFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.Remove(provider); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func providerFromPath(w http.ResponseWriter, r *http.Request) (address.Address, bool) {
+	provider, err := address.NewFromString(mux.Vars(r)["provider"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("parsing provider address: %w", err))
+		return address.Undef, false
+	}
+	return provider, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnw("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
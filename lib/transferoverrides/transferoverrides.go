@@ -0,0 +1,259 @@
+// Package transferoverrides stores the transfer parameters that the client
+// uses for particular storage providers, in place of the parameters it
+// uses by default.
+//
+// Providers differ in how they transfer deal data best: one provider may
+// download fastest over raw tcp while another can only reach the client
+// over http, one may be reachable on a private multiaddr that isn't on
+// chain, and one may need a longer transfer timeout or more retries. The
+// operator records these differences once, and they are applied to every
+// deal made with the provider.
+package transferoverrides
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/multiformats/go-multiaddr"
+)
+
+var ErrNotFound = errors.New("no transfer overrides for provider")
+
+// Duration is a time.Duration that is stored as a string, eg "10m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Override is the transfer parameters for a provider. Parameters that are
+// not set (the zero value) are not overridden.
+type Override struct {
+	// The address of the provider, eg "f01000"
+	Provider string `json:"provider"`
+	// The transport to serve CAR files over: "http" or "tcp"
+	Transport string `json:"transport,omitempty"`
+	// The multiaddrs to dial the provider at, in place of the multiaddrs
+	// in the provider's on-chain miner info
+	Multiaddrs []string `json:"multiaddrs,omitempty"`
+	// The size of the data frames that CAR files are served in over tcp
+	TcpFrameSize int `json:"tcpFrameSize,omitempty"`
+	// The minimum time between proposals to the provider in a deal batch,
+	// which spaces out the provider's transfers
+	ProposalInterval Duration `json:"proposalInterval,omitempty"`
+	// The time the provider has to start, and to complete, the transfer
+	TransferStartTimeout Duration `json:"transferStartTimeout,omitempty"`
+	TransferTimeout      Duration `json:"transferTimeout,omitempty"`
+	// How the provider retries a failed transfer (requires a provider that
+	// supports deal protocol v1.3)
+	TransferRetryAttempts   uint64   `json:"transferRetryAttempts,omitempty"`
+	TransferRetryMinBackoff Duration `json:"transferRetryMinBackoff,omitempty"`
+	TransferRetryMaxBackoff Duration `json:"transferRetryMaxBackoff,omitempty"`
+}
+
+// Validate checks that the override's parameters are well formed
+func (o *Override) Validate() error {
+	if _, err := address.NewFromString(o.Provider); err != nil {
+		return fmt.Errorf("parsing provider address '%s': %w", o.Provider, err)
+	}
+	if o.Transport != "" && o.Transport != "http" && o.Transport != "tcp" {
+		return fmt.Errorf("unrecognized transport '%s': must be 'http' or 'tcp'", o.Transport)
+	}
+	if _, err := o.ParsedMultiaddrs(); err != nil {
+		return err
+	}
+	if o.TcpFrameSize < 0 {
+		return fmt.Errorf("tcp frame size must not be negative")
+	}
+	if o.ProposalInterval < 0 || o.TransferStartTimeout < 0 || o.TransferTimeout < 0 ||
+		o.TransferRetryMinBackoff < 0 || o.TransferRetryMaxBackoff < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if o.TransferRetryMaxBackoff > 0 && o.TransferRetryMinBackoff > o.TransferRetryMaxBackoff {
+		return fmt.Errorf("transfer retry min backoff %s is greater than max backoff %s",
+			time.Duration(o.TransferRetryMinBackoff), time.Duration(o.TransferRetryMaxBackoff))
+	}
+	if o.Transport == "tcp" && o.TransferRetry() != nil {
+		return fmt.Errorf("a transfer retry policy can only be set for http transfers")
+	}
+	return nil
+}
+
+// ParsedMultiaddrs returns the override's multiaddrs
+func (o *Override) ParsedMultiaddrs() ([]multiaddr.Multiaddr, error) {
+	maddrs := make([]multiaddr.Multiaddr, 0, len(o.Multiaddrs))
+	for _, s := range o.Multiaddrs {
+		ma, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing multiaddr '%s': %w", s, err)
+		}
+		maddrs = append(maddrs, ma)
+	}
+	return maddrs, nil
+}
+
+// TransferRetry returns the transfer retry policy, or nil if the override
+// doesn't set one
+func (o *Override) TransferRetry() *types.TransferRetryPolicy {
+	if o.TransferRetryAttempts == 0 && o.TransferRetryMinBackoff == 0 && o.TransferRetryMaxBackoff == 0 {
+		return nil
+	}
+	return &types.TransferRetryPolicy{
+		MaxAttempts: o.TransferRetryAttempts,
+		MinBackoff:  time.Duration(o.TransferRetryMinBackoff),
+		MaxBackoff:  time.Duration(o.TransferRetryMaxBackoff),
+	}
+}
+
+// Store keeps the overrides in a json file. The file is read on every
+// access, so that changes made by another process (eg with the
+// transfer-overrides command while the job API is running) take effect
+// immediately.
+type Store struct {
+	path string
+	lk   sync.Mutex
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Get returns the override for the provider, or ErrNotFound
+func (s *Store) Get(provider address.Address) (*Override, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	overrides, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	o, ok := overrides[provider.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return o, nil
+}
+
+// List returns all the overrides, ordered by provider
+func (s *Store) List() ([]*Override, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	overrides, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Override, 0, len(overrides))
+	for _, o := range overrides {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Provider < list[j].Provider
+	})
+	return list, nil
+}
+
+// Set validates the override and saves it, replacing any existing override
+// for the provider
+func (s *Store) Set(o Override) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	// Store the address in its canonical form, so that it matches lookups
+	// by address
+	a, _ := address.NewFromString(o.Provider)
+	o.Provider = a.String()
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	overrides, err := s.load()
+	if err != nil {
+		return err
+	}
+	overrides[o.Provider] = &o
+	return s.save(overrides)
+}
+
+// Remove removes the override for the provider, or returns ErrNotFound
+func (s *Store) Remove(provider address.Address) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	overrides, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := overrides[provider.String()]; !ok {
+		return ErrNotFound
+	}
+	delete(overrides, provider.String())
+	return s.save(overrides)
+}
+
+func (s *Store) load() (map[string]*Override, error) {
+	overrides := make(map[string]*Override)
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return overrides, nil
+		}
+		return nil, fmt.Errorf("reading transfer overrides: %w", err)
+	}
+	var list []*Override
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("parsing transfer overrides %s: %w", s.path, err)
+	}
+	for _, o := range list {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("transfer overrides %s: %w", s.path, err)
+		}
+		a, _ := address.NewFromString(o.Provider)
+		overrides[a.String()] = o
+	}
+	return overrides, nil
+}
+
+func (s *Store) save(overrides map[string]*Override) error {
+	list := make([]*Override, 0, len(overrides))
+	for _, o := range overrides {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Provider < list[j].Provider
+	})
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing transfer overrides: %w", err)
+	}
+	// Write to a temporary file and rename it, so that a process reading
+	// the file never sees a partial write
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing transfer overrides: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("writing transfer overrides: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package transferoverrides
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	req := require.New(t)
+	path := filepath.Join(t.TempDir(), "transfer-overrides.json")
+	s := NewStore(path)
+
+	prov, err := address.NewFromString("f01000")
+	req.NoError(err)
+	_, err = s.Get(prov)
+	req.ErrorIs(err, ErrNotFound)
+
+	o := Override{
+		Provider:                "t01000",
+		Transport:               "http",
+		Multiaddrs:              []string{"/ip4/10.0.0.1/tcp/24001"},
+		TransferTimeout:         Duration(2 * time.Hour),
+		TransferRetryAttempts:   5,
+		TransferRetryMinBackoff: Duration(time.Minute),
+	}
+	req.NoError(s.Set(o))
+
+	// The override is saved with the canonical form of the address, and is
+	// read back by another store on the same file
+	got, err := NewStore(path).Get(prov)
+	req.NoError(err)
+	req.Equal(prov.String(), got.Provider)
+	req.Equal(Duration(2*time.Hour), got.TransferTimeout)
+	req.Equal(uint64(5), got.TransferRetry().MaxAttempts)
+	maddrs, err := got.ParsedMultiaddrs()
+	req.NoError(err)
+	req.Len(maddrs, 1)
+
+	other := Override{Provider: "f01001", Transport: "tcp", TcpFrameSize: 256 * 1024}
+	req.NoError(s.Set(other))
+	list, err := s.List()
+	req.NoError(err)
+	req.Len(list, 2)
+	req.Equal(prov.String(), list[0].Provider)
+	req.Nil(list[1].TransferRetry())
+
+	req.NoError(s.Remove(prov))
+	req.ErrorIs(s.Remove(prov), ErrNotFound)
+	list, err = s.List()
+	req.NoError(err)
+	req.Len(list, 1)
+}
+
+func TestValidate(t *testing.T) {
+	tcs := []struct {
+		name string
+		o    Override
+	}{{
+		name: "bad provider",
+		o:    Override{Provider: "nope"},
+	}, {
+		name: "bad transport",
+		o:    Override{Provider: "f01000", Transport: "graphsync"},
+	}, {
+		name: "bad multiaddr",
+		o:    Override{Provider: "f01000", Multiaddrs: []string{"10.0.0.1:24001"}},
+	}, {
+		name: "min backoff above max",
+		o:    Override{Provider: "f01000", TransferRetryMinBackoff: Duration(time.Hour), TransferRetryMaxBackoff: Duration(time.Minute)},
+	}, {
+		name: "retry with tcp",
+		o:    Override{Provider: "f01000", Transport: "tcp", TransferRetryAttempts: 3},
+	}}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Error(t, tc.o.Validate())
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	req := require.New(t)
+	s := NewStore(filepath.Join(t.TempDir(), "transfer-overrides.json"))
+	svr := httptest.NewServer(NewHandler(s))
+	defer svr.Close()
+
+	do := func(method string, path string, body string) *http.Response {
+		r, err := http.NewRequest(method, svr.URL+path, bytes.NewBufferString(body))
+		req.NoError(err)
+		resp, err := http.DefaultClient.Do(r)
+		req.NoError(err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	resp := do(http.MethodPut, "/transfer-overrides/f01000", `{"transport": "tcp", "transferTimeout": "90m"}`)
+	req.Equal(http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodPut, "/transfer-overrides/f01000", `{"transport": "ftp"}`)
+	req.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/transfer-overrides/f01000", "")
+	req.Equal(http.StatusOK, resp.StatusCode)
+	var o Override
+	req.NoError(json.NewDecoder(resp.Body).Decode(&o))
+	req.Equal("tcp", o.Transport)
+	req.Equal(Duration(90*time.Minute), o.TransferTimeout)
+
+	resp = do(http.MethodGet, "/transfer-overrides", "")
+	var list []Override
+	req.NoError(json.NewDecoder(resp.Body).Decode(&list))
+	req.Len(list, 1)
+
+	resp = do(http.MethodDelete, "/transfer-overrides/f01000", "")
+	req.Equal(http.StatusNoContent, resp.StatusCode)
+	resp = do(http.MethodGet, "/transfer-overrides/f01000", "")
+	req.Equal(http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,307 @@
+// Package unixfsls lists the contents of a UnixFS directory (names, types,
+// sizes and cids) without retrieving the content of its files.
+//
+// Each directory is retrieved with a shallow selector that matches only the
+// directory's block and the blocks it links to directly. The root block of
+// each entry is enough to know its type and size, so the blocks under a file
+// are never retrieved.
+package unixfsls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/filecoin-project/boost/lib/carfetch"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	TypeFile      = "file"
+	TypeDirectory = "directory"
+	TypeSymlink   = "symlink"
+	// The block is not UnixFS, eg a dag-cbor block
+	TypeOther = "other"
+)
+
+// Entry is a file or directory in the listing
+type Entry struct {
+	// The path of the entry, relative to the root
+	Path string  `json:"path"`
+	Name string  `json:"name"`
+	Cid  cid.Cid `json:"cid"`
+	Type string  `json:"type"`
+	// The size of a file's data. For a directory it's the cumulative size of
+	// the blocks under it (as recorded in the link to it).
+	Size uint64 `json:"size"`
+}
+
+// Fetcher retrieves a CAR file with the blocks that the selector matches
+// when it traverses the DAG from root
+type Fetcher func(ctx context.Context, root cid.Cid, sel ipld.Node) (io.ReadCloser, error)
+
+// ShallowSelector matches a dag-pb block and the blocks it links to
+// directly, but nothing below them
+func ShallowSelector() ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	return ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Links", ssb.ExploreAll(ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("Hash", ssb.Matcher())
+		})))
+	}).Node()
+}
+
+// blockSelector matches just the root block
+func blockSelector() ipld.Node {
+	return builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+}
+
+// List lists the entries of the UnixFS directory at root. If recursive is
+// true it also lists the entries of each subdirectory, after the entry for
+// the subdirectory. If root is not a directory, the listing is just root.
+func List(ctx context.Context, fetch Fetcher, root cid.Cid, recursive bool) ([]Entry, error) {
+	l := &lister{fetch: fetch, blocks: make(map[cid.Cid]blocks.Block), explored: make(map[cid.Cid]bool), recursive: recursive}
+	// Check the type of the root before retrieving the blocks it links to,
+	// as for a file they are its content
+	blk, err := l.get(ctx, root, blockSelector())
+	if err != nil {
+		return nil, err
+	}
+	isDir, err := l.isDirectory(blk)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		e, err := l.entry(ctx, "", root, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Entry{e}, nil
+	}
+	if err := l.listDir(ctx, "", root); err != nil {
+		return nil, err
+	}
+	return l.entries, nil
+}
+
+type lister struct {
+	fetch  Fetcher
+	blocks map[cid.Cid]blocks.Block
+	// The blocks that were retrieved with the shallow selector, so the
+	// blocks they link to have been retrieved too
+	explored  map[cid.Cid]bool
+	recursive bool
+	entries   []Entry
+}
+
+// get returns the block, retrieving it with the selector (and keeping any
+// other blocks that are retrieved with it) if it hasn't been retrieved yet
+func (l *lister) get(ctx context.Context, c cid.Cid, sel ipld.Node) (blocks.Block, error) {
+	if blk, ok := l.blocks[c]; ok {
+		return blk, nil
+	}
+	if c.Prefix().MhType == multihash.IDENTITY {
+		// An identity cid holds the data in the cid itself
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return nil, err
+		}
+		return blocks.NewBlockWithCid(dmh.Digest, c)
+	}
+	if err := l.retrieve(ctx, c, sel); err != nil {
+		return nil, err
+	}
+	blk, ok := l.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s was not in the retrieved CAR file", c)
+	}
+	return blk, nil
+}
+
+// explore returns the block, retrieving it and the blocks it links to with
+// the shallow selector if they haven't been retrieved yet
+func (l *lister) explore(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if !l.explored[c] {
+		if err := l.retrieve(ctx, c, ShallowSelector()); err != nil {
+			return nil, err
+		}
+		l.explored[c] = true
+	}
+	return l.get(ctx, c, blockSelector())
+}
+
+func (l *lister) retrieve(ctx context.Context, root cid.Cid, sel ipld.Node) error {
+	rc, err := l.fetch(ctx, root, sel)
+	if err != nil {
+		return fmt.Errorf("retrieving %s: %w", root, err)
+	}
+	defer rc.Close() //nolint:errcheck
+
+	cr, err := car.NewCarReader(bufio.NewReader(rc))
+	if err != nil {
+		return fmt.Errorf("reading CAR file for %s: %w", root, err)
+	}
+	for {
+		blk, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading CAR file for %s: %w", root, err)
+		}
+		chk, err := blk.Cid().Prefix().Sum(blk.RawData())
+		if err != nil {
+			return err
+		}
+		if !chk.Equals(blk.Cid()) {
+			return fmt.Errorf("data of block %s in CAR file for %s does not match its cid", blk.Cid(), root)
+		}
+		l.blocks[blk.Cid()] = blk
+	}
+}
+
+func (l *lister) isDirectory(blk blocks.Block) (bool, error) {
+	if blk.Cid().Prefix().Codec != cid.DagProtobuf {
+		return false, nil
+	}
+	_, fsn, err := decode(blk)
+	if err != nil {
+		return false, err
+	}
+	return fsn.Type() == unixfs.TDirectory || fsn.Type() == unixfs.THAMTShard, nil
+}
+
+// listDir adds the entries of the directory to the listing
+func (l *lister) listDir(ctx context.Context, dirPath string, c cid.Cid) error {
+	blk, err := l.explore(ctx, c)
+	if err != nil {
+		return err
+	}
+	pn, fsn, err := decode(blk)
+	if err != nil {
+		return err
+	}
+
+	// The entries of a sharded directory are prefixed with the (hex) index
+	// of the entry in the shard. A link that is just the index is to a
+	// sub-shard, that contains more of the directory's entries.
+	prefixLen := 0
+	if fsn.Type() == unixfs.THAMTShard {
+		if fsn.Fanout() < 2 {
+			return fmt.Errorf("directory shard %s has invalid fanout %d", c, fsn.Fanout())
+		}
+		prefixLen = len(fmt.Sprintf("%X", fsn.Fanout()-1))
+	}
+
+	for _, lnk := range pn.Links() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := lnk.Name
+		if prefixLen > 0 {
+			if len(name) < prefixLen {
+				return fmt.Errorf("link '%s' of directory shard %s is shorter than the shard prefix", name, c)
+			}
+			if len(name) == prefixLen {
+				if err := l.listDir(ctx, dirPath, lnk.Cid); err != nil {
+					return err
+				}
+				continue
+			}
+			name = name[prefixLen:]
+		}
+
+		e, err := l.entry(ctx, path.Join(dirPath, name), lnk.Cid, lnk.Size)
+		if err != nil {
+			return err
+		}
+		l.entries = append(l.entries, e)
+		if l.recursive && e.Type == TypeDirectory {
+			if err := l.listDir(ctx, e.Path, e.Cid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entry describes the block at c. linkSize is the cumulative size from the
+// link to the block.
+func (l *lister) entry(ctx context.Context, entryPath string, c cid.Cid, linkSize uint64) (Entry, error) {
+	e := Entry{Path: entryPath, Name: path.Base(entryPath), Cid: c, Size: linkSize}
+	if entryPath == "" {
+		e.Name = ""
+	}
+	blk, err := l.get(ctx, c, blockSelector())
+	if err != nil {
+		return Entry{}, err
+	}
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		e.Type = TypeFile
+		e.Size = uint64(len(blk.RawData()))
+		return e, nil
+	case cid.DagProtobuf:
+	default:
+		e.Type = TypeOther
+		e.Size = uint64(len(blk.RawData()))
+		return e, nil
+	}
+
+	_, fsn, err := decode(blk)
+	if err != nil {
+		return Entry{}, err
+	}
+	switch fsn.Type() {
+	case unixfs.TFile, unixfs.TRaw:
+		e.Type = TypeFile
+		e.Size = fsn.FileSize()
+	case unixfs.TDirectory, unixfs.THAMTShard:
+		e.Type = TypeDirectory
+	case unixfs.TSymlink:
+		e.Type = TypeSymlink
+		e.Size = uint64(len(fsn.Data()))
+	default:
+		e.Type = TypeOther
+	}
+	return e, nil
+}
+
+func decode(blk blocks.Block) (*merkledag.ProtoNode, *unixfs.FSNode, error) {
+	pn, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding block %s: %w", blk.Cid(), err)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding UnixFS data of block %s: %w", blk.Cid(), err)
+	}
+	return pn, fsn, nil
+}
+
+// HTTPFetcher retrieves the CAR files from the source's http retrieval
+// endpoint, as partial CAR files selected by the payload cid and selector
+func HTTPFetcher(src carfetch.Source) Fetcher {
+	return func(ctx context.Context, root cid.Cid, sel ipld.Node) (io.ReadCloser, error) {
+		var buf bytes.Buffer
+		if err := dagjson.Encode(sel, &buf); err != nil {
+			return nil, fmt.Errorf("encoding selector: %w", err)
+		}
+		query := url.Values{"payloadCid": {root.String()}, "selector": {buf.String()}}
+		return carfetch.Open(ctx, src, query)
+	}
+}
@@ -0,0 +1,175 @@
+package unixfsls
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/boost/lib/carfetch"
+	"github.com/filecoin-project/boost/lib/partialcar"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	format "github.com/ipfs/go-ipld-format"
+	dstest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/hamt"
+	"github.com/ipfs/go-unixfs/importer"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/stretchr/testify/require"
+)
+
+// dagStore serves the blocks of a DAG service, and records the blocks that
+// are retrieved
+type dagStore struct {
+	dag format.DAGService
+
+	lk        sync.Mutex
+	retrieved map[cid.Cid]int
+	requests  int
+}
+
+func (d *dagStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	nd, err := d.dag.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	d.lk.Lock()
+	d.retrieved[c]++
+	d.lk.Unlock()
+	return nd, nil
+}
+
+func (d *dagStore) fetch(ctx context.Context, root cid.Cid, sel ipld.Node) (io.ReadCloser, error) {
+	d.lk.Lock()
+	d.requests++
+	d.lk.Unlock()
+	var buf bytes.Buffer
+	if err := partialcar.WriteSelector(ctx, &buf, d, root, sel); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+func addFile(t *testing.T, dag format.DAGService, size int) format.Node {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	nd, err := importer.BuildDagFromReader(dag, chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	return nd
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	dag := dstest.Mock()
+
+	// root/big.bin, root/sub/small.bin and root/sharded/entry-{n}
+	big := addFile(t, dag, 4000)
+	require.NotEmpty(t, big.Links())
+	small := addFile(t, dag, 100)
+
+	sub := uio.NewDirectory(dag)
+	require.NoError(t, sub.AddChild(ctx, "small.bin", small))
+	subNd, err := sub.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dag.Add(ctx, subNd))
+
+	// A HAMT sharded directory, narrow enough to have nested shards
+	shard, err := hamt.NewShard(dag, 16)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, shard.Set(ctx, fmt.Sprintf("entry-%d", i), addFile(t, dag, 10)))
+	}
+	sharded, err := shard.Node()
+	require.NoError(t, err)
+
+	dir := uio.NewDirectory(dag)
+	require.NoError(t, dir.AddChild(ctx, "big.bin", big))
+	require.NoError(t, dir.AddChild(ctx, "sub", subNd))
+	require.NoError(t, dir.AddChild(ctx, "sharded", sharded))
+	root, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dag.Add(ctx, root))
+
+	t.Run("shallow", func(t *testing.T) {
+		ds := &dagStore{dag: dag, retrieved: make(map[cid.Cid]int)}
+		entries, err := List(ctx, ds.fetch, root.Cid(), false)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+
+		byPath := make(map[string]Entry)
+		for _, e := range entries {
+			byPath[e.Path] = e
+		}
+		require.Equal(t, Entry{Path: "big.bin", Name: "big.bin", Cid: big.Cid(), Type: TypeFile, Size: 4000}, byPath["big.bin"])
+		require.Equal(t, TypeDirectory, byPath["sub"].Type)
+		require.Equal(t, subNd.Cid(), byPath["sub"].Cid)
+		require.Equal(t, TypeDirectory, byPath["sharded"].Type)
+
+		// Only the root and the blocks it links to are retrieved
+		require.Equal(t, 2, ds.requests)
+		require.Len(t, ds.retrieved, 4)
+		for _, l := range big.Links() {
+			require.Zero(t, ds.retrieved[l.Cid])
+		}
+	})
+
+	t.Run("recursive", func(t *testing.T) {
+		ds := &dagStore{dag: dag, retrieved: make(map[cid.Cid]int)}
+		entries, err := List(ctx, ds.fetch, root.Cid(), true)
+		require.NoError(t, err)
+		require.Len(t, entries, 3+1+50)
+
+		byPath := make(map[string]Entry)
+		for _, e := range entries {
+			byPath[e.Path] = e
+		}
+		require.Equal(t, Entry{Path: "sub/small.bin", Name: "small.bin", Cid: small.Cid(), Type: TypeFile, Size: 100}, byPath["sub/small.bin"])
+		for i := 0; i < 50; i++ {
+			e, ok := byPath[fmt.Sprintf("sharded/entry-%d", i)]
+			require.True(t, ok, i)
+			require.Equal(t, TypeFile, e.Type)
+			require.EqualValues(t, 10, e.Size)
+		}
+
+		// The content of the big file is never retrieved
+		for _, l := range big.Links() {
+			require.Zero(t, ds.retrieved[l.Cid])
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		ds := &dagStore{dag: dag, retrieved: make(map[cid.Cid]int)}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			payloadCid, err := cid.Parse(q.Get("payloadCid"))
+			require.NoError(t, err)
+			sel, err := partialcar.ParseSelector(q.Get("selector"))
+			require.NoError(t, err)
+			require.Equal(t, "/piece", r.URL.Path)
+			require.Equal(t, "car", q.Get("format"))
+			require.NoError(t, partialcar.WriteSelector(r.Context(), w, ds, payloadCid, sel))
+		}))
+		defer srv.Close()
+
+		src := carfetch.Source{Name: "test", Endpoint: func(context.Context) (string, error) { return srv.URL, nil }}
+		entries, err := List(ctx, HTTPFetcher(src), subNd.Cid(), false)
+		require.NoError(t, err)
+		require.Equal(t, []Entry{{Path: "small.bin", Name: "small.bin", Cid: small.Cid(), Type: TypeFile, Size: 100}}, entries)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		ds := &dagStore{dag: dag, retrieved: make(map[cid.Cid]int)}
+		entries, err := List(ctx, ds.fetch, big.Cid(), false)
+		require.NoError(t, err)
+		require.Equal(t, []Entry{{Cid: big.Cid(), Type: TypeFile, Size: 4000}}, entries)
+		require.Len(t, ds.retrieved, 1)
+	})
+}
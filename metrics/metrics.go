@@ -45,6 +45,9 @@ var (
 	TaskType, _       = tag.NewKey("task_type")
 	WorkerHostname, _ = tag.NewKey("worker_hostname")
 	StorageID, _      = tag.NewKey("storage_id")
+
+	// markets graphsync
+	GraphsyncParam, _ = tag.NewKey("param")
 )
 
 // Measures
@@ -129,6 +132,11 @@ var (
 	HttpPieceByCid404ResponseCount   = stats.Int64("http/piece_by_cid_404_response_count", "Counter of /piece/<piece-cid> 404 responses", stats.UnitDimensionless)
 	HttpPieceByCid500ResponseCount   = stats.Int64("http/piece_by_cid_500_response_count", "Counter of /piece/<piece-cid> 500 responses", stats.UnitDimensionless)
 
+	// markets graphsync
+	MarketsGraphsyncLimit   = stats.Int64("markets_graphsync/limit", "Configured value of a markets graphsync engine parameter", stats.UnitDimensionless)
+	MarketsGraphsyncUsage   = stats.Int64("markets_graphsync/usage", "Current usage of the resource limited by a markets graphsync engine parameter", stats.UnitDimensionless)
+	MarketsGraphsyncPending = stats.Int64("markets_graphsync/pending", "Current amount waiting on the resource limited by a markets graphsync engine parameter", stats.UnitDimensionless)
+
 	// bitswap
 	BitswapRblsGetRequestCount             = stats.Int64("bitswap/rbls_get_request_count", "Counter of RemoteBlockstore Get requests", stats.UnitDimensionless)
 	BitswapRblsGetSuccessResponseCount     = stats.Int64("bitswap/rbls_get_success_response_count", "Counter of successful RemoteBlockstore Get responses", stats.UnitDimensionless)
@@ -230,6 +238,22 @@ var (
 		Aggregation: view.Count(),
 	}
 
+	MarketsGraphsyncLimitView = &view.View{
+		Measure:     MarketsGraphsyncLimit,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{GraphsyncParam},
+	}
+	MarketsGraphsyncUsageView = &view.View{
+		Measure:     MarketsGraphsyncUsage,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{GraphsyncParam},
+	}
+	MarketsGraphsyncPendingView = &view.View{
+		Measure:     MarketsGraphsyncPending,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{GraphsyncParam},
+	}
+
 	InfoView = &view.View{
 		Name:        "info",
 		Description: "Lotus node information",
@@ -521,6 +545,9 @@ var DefaultViews = func() []*view.View {
 		BitswapRblsHasRequestCountView,
 		BitswapRblsHasSuccessResponseCountView,
 		BitswapRblsHasFailResponseCountView,
+		MarketsGraphsyncLimitView,
+		MarketsGraphsyncUsageView,
+		MarketsGraphsyncPendingView,
 		lotusmetrics.DagStorePRBytesDiscardedView,
 		lotusmetrics.DagStorePRBytesRequestedView,
 		lotusmetrics.DagStorePRDiscardCountView,
@@ -0,0 +1,298 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The maximum size of a StatsD datagram, that fits in the MTU of most
+// networks
+const statsdMaxPacketSize = 1432
+
+// PushConfig configures pushing metrics to a Prometheus pushgateway and / or
+// a StatsD server, for processes that can't be scraped (eg because they are
+// short-lived)
+type PushConfig struct {
+	// The url of the Prometheus pushgateway, eg http://localhost:9091
+	// (empty to disable)
+	PushgatewayURL string
+	// The job label that metrics are grouped by in the pushgateway
+	Job string
+	// The instance label that metrics are grouped by in the pushgateway
+	// (defaults to the hostname)
+	Instance string
+	// The host:port of the StatsD server, eg localhost:8125 (empty to disable)
+	StatsdAddress string
+	// The prefix of the names of the metrics sent to the StatsD server
+	StatsdPrefix string
+	// Send metric labels as DogStatsD tags, rather than appending their
+	// values to the metric name
+	DogStatsd bool
+	// The interval between pushes
+	Interval time.Duration
+}
+
+// Pusher periodically pushes the metrics in a Prometheus registry to the
+// configured sinks
+type Pusher struct {
+	cfg      PushConfig
+	gatherer promclient.Gatherer
+	gateway  *push.Pusher
+	statsd   *statsdSink
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartPush starts pushing the metrics in the default Prometheus registry
+// (the metrics served at /metrics) every interval. The returned stop
+// function pushes the metrics one last time, so that the final values of
+// a short-lived process are recorded.
+func StartPush(cfg PushConfig) (func(context.Context) error, error) {
+	p, err := NewPusher(cfg, promclient.DefaultGatherer)
+	if err != nil {
+		return nil, err
+	}
+	p.Start()
+	return p.Stop, nil
+}
+
+func NewPusher(cfg PushConfig, gatherer promclient.Gatherer) (*Pusher, error) {
+	if cfg.PushgatewayURL == "" && cfg.StatsdAddress == "" {
+		return nil, fmt.Errorf("neither a pushgateway url nor a statsd address is configured")
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("metrics push interval must be greater than zero")
+	}
+
+	p := &Pusher{cfg: cfg, gatherer: gatherer, done: make(chan struct{})}
+	if cfg.PushgatewayURL != "" {
+		instance := cfg.Instance
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		p.gateway = push.New(cfg.PushgatewayURL, cfg.Job).Gatherer(gatherer)
+		if instance != "" {
+			p.gateway = p.gateway.Grouping("instance", instance)
+		}
+	}
+	if cfg.StatsdAddress != "" {
+		conn, err := net.Dial("udp", cfg.StatsdAddress)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to statsd server at %s: %w", cfg.StatsdAddress, err)
+		}
+		p.statsd = newStatsdSink(conn, cfg.StatsdPrefix, cfg.DogStatsd)
+	}
+	return p, nil
+}
+
+func (p *Pusher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.run(ctx)
+}
+
+func (p *Pusher) run(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Push(); err != nil {
+				log.Warnw("pushing metrics", "err", err)
+			}
+		}
+	}
+}
+
+// Stop stops pushing metrics, after pushing them one last time
+func (p *Pusher) Stop(ctx context.Context) error {
+	if p.cancel != nil {
+		p.cancel()
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := p.Push()
+	if p.statsd != nil {
+		p.statsd.conn.Close() //nolint:errcheck
+	}
+	return err
+}
+
+// Push pushes the current value of the metrics to each sink
+func (p *Pusher) Push() error {
+	var errs []string
+	if p.gateway != nil {
+		if err := p.gateway.Push(); err != nil {
+			errs = append(errs, fmt.Sprintf("pushgateway: %s", err))
+		}
+	}
+	if p.statsd != nil {
+		mfs, err := p.gatherer.Gather()
+		if err != nil {
+			// Gather returns the metrics it could gather along with the
+			// error, so still send them
+			errs = append(errs, fmt.Sprintf("gathering metrics: %s", err))
+		}
+		if err := p.statsd.send(mfs); err != nil {
+			errs = append(errs, fmt.Sprintf("statsd: %s", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// statsdSink sends metrics to a StatsD server. Prometheus counters are
+// sent as StatsD counters, by sending the increase since the last push.
+// Gauges are sent as StatsD gauges. Histograms and summaries are sent as
+// counters of their count and sum.
+type statsdSink struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsd bool
+
+	lk sync.Mutex
+	// The value of each counter at the last push
+	last map[string]float64
+}
+
+func newStatsdSink(conn net.Conn, prefix string, dogStatsd bool) *statsdSink {
+	return &statsdSink{conn: conn, prefix: prefix, dogStatsd: dogStatsd, last: make(map[string]float64)}
+}
+
+func (s *statsdSink) send(mfs []*dto.MetricFamily) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var lines []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name, tags := s.metricName(mf.GetName(), m.GetLabel())
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = s.appendCounter(lines, name, tags, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = append(lines, statsdLine(name, m.GetGauge().GetValue(), "g", tags))
+			case dto.MetricType_UNTYPED:
+				lines = append(lines, statsdLine(name, m.GetUntyped().GetValue(), "g", tags))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				lines = s.appendCounter(lines, name+".count", tags, float64(h.GetSampleCount()))
+				lines = s.appendCounter(lines, name+".sum", tags, h.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				sm := m.GetSummary()
+				lines = s.appendCounter(lines, name+".count", tags, float64(sm.GetSampleCount()))
+				lines = s.appendCounter(lines, name+".sum", tags, sm.GetSampleSum())
+			}
+		}
+	}
+	return s.write(lines)
+}
+
+// appendCounter appends the increase of the counter since the last push
+func (s *statsdSink) appendCounter(lines []string, name string, tags string, value float64) []string {
+	key := name + "|" + tags
+	delta := value - s.last[key]
+	if delta < 0 {
+		// The counter was reset
+		delta = value
+	}
+	s.last[key] = value
+	if delta == 0 {
+		return lines
+	}
+	return append(lines, statsdLine(name, delta, "c", tags))
+}
+
+// metricName returns the StatsD name of the metric, and its DogStatsD tags.
+// For plain StatsD, the label values are appended to the name (in order of
+// the label names).
+func (s *statsdSink) metricName(name string, labels []*dto.LabelPair) (string, string) {
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+
+	sorted := append([]*dto.LabelPair{}, labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+
+	if s.dogStatsd {
+		tags := make([]string, 0, len(sorted))
+		for _, l := range sorted {
+			tags = append(tags, sanitizeStatsd(l.GetName())+":"+sanitizeStatsd(l.GetValue()))
+		}
+		return name, strings.Join(tags, ",")
+	}
+
+	for _, l := range sorted {
+		if v := l.GetValue(); v != "" {
+			name += "." + sanitizeStatsd(v)
+		}
+	}
+	return name, ""
+}
+
+func statsdLine(name string, value float64, typ string, tags string) string {
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ
+	if tags != "" {
+		line += "|#" + tags
+	}
+	return line
+}
+
+// sanitizeStatsd replaces the characters that are part of the StatsD
+// protocol
+func sanitizeStatsd(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// write sends the lines in as few datagrams as possible
+func (s *statsdSink) write(lines []string) error {
+	var buf []byte
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(buf)
+		buf = buf[:0]
+		return err
+	}
+	for _, line := range lines {
+		if len(buf) > 0 && len(buf)+1+len(line) > statsdMaxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+	}
+	return flush()
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) (*promclient.Registry, *promclient.CounterVec, promclient.Gauge) {
+	reg := promclient.NewRegistry()
+	deals := promclient.NewCounterVec(promclient.CounterOpts{Name: "deals_total", Help: "deals"}, []string{"state"})
+	inflight := promclient.NewGauge(promclient.GaugeOpts{Name: "transfers_in_flight", Help: "transfers"})
+	reg.MustRegister(deals, inflight)
+	return reg, deals, inflight
+}
+
+// readStatsd reads the lines of one datagram from the statsd server
+func readStatsd(t *testing.T, conn net.PacketConn) []string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 64*1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	lines := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestPushStatsd(t *testing.T) {
+	reg, deals, inflight := newTestRegistry(t)
+
+	for _, dog := range []bool{false, true} {
+		server, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer server.Close() //nolint:errcheck
+
+		p, err := NewPusher(PushConfig{
+			StatsdAddress: server.LocalAddr().String(),
+			StatsdPrefix:  "boost",
+			DogStatsd:     dog,
+			Interval:      time.Hour,
+		}, reg)
+		require.NoError(t, err)
+
+		deals.Reset()
+		deals.WithLabelValues("accepted").Add(3)
+		inflight.Set(2)
+		require.NoError(t, p.Push())
+		if dog {
+			require.Equal(t, []string{
+				"boost.deals_total:3|c|#state:accepted",
+				"boost.transfers_in_flight:2|g",
+			}, readStatsd(t, server))
+		} else {
+			require.Equal(t, []string{
+				"boost.deals_total.accepted:3|c",
+				"boost.transfers_in_flight:2|g",
+			}, readStatsd(t, server))
+		}
+
+		// Counters should be sent as the increase since the last push, and
+		// not be sent if they haven't changed
+		deals.WithLabelValues("accepted").Add(2)
+		deals.WithLabelValues("rejected").Inc()
+		require.NoError(t, p.Push())
+		if dog {
+			require.Equal(t, []string{
+				"boost.deals_total:1|c|#state:rejected",
+				"boost.deals_total:2|c|#state:accepted",
+				"boost.transfers_in_flight:2|g",
+			}, readStatsd(t, server))
+		} else {
+			require.Equal(t, []string{
+				"boost.deals_total.accepted:2|c",
+				"boost.deals_total.rejected:1|c",
+				"boost.transfers_in_flight:2|g",
+			}, readStatsd(t, server))
+		}
+
+		require.NoError(t, p.Stop(context.Background()))
+	}
+}
+
+func TestPushgateway(t *testing.T) {
+	reg, deals, _ := newTestRegistry(t)
+	deals.WithLabelValues("accepted").Add(3)
+
+	var lk sync.Mutex
+	var last string
+	pushed := make(chan struct{}, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/metrics/job/boost-batch/instance/worker-1", r.URL.Path)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lk.Lock()
+		last = string(body)
+		lk.Unlock()
+		select {
+		case pushed <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	p, err := NewPusher(PushConfig{
+		PushgatewayURL: gateway.URL,
+		Job:            "boost-batch",
+		Instance:       "worker-1",
+		Interval:       10 * time.Millisecond,
+	}, reg)
+	require.NoError(t, err)
+	p.Start()
+
+	// Metrics should be pushed every interval
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for metrics push")
+	}
+
+	// Stopping should push the final values of the metrics
+	deals.WithLabelValues("accepted").Add(4)
+	require.NoError(t, p.Stop(context.Background()))
+	lk.Lock()
+	defer lk.Unlock()
+	require.Contains(t, last, "deals_total")
+}
+
+func TestNewPusherConfig(t *testing.T) {
+	_, err := NewPusher(PushConfig{Interval: time.Second}, promclient.NewRegistry())
+	require.Error(t, err)
+
+	_, err = NewPusher(PushConfig{PushgatewayURL: "http://localhost:9091", Job: "boost"}, promclient.NewRegistry())
+	require.Error(t, err)
+}
@@ -13,6 +13,15 @@ import (
 	"github.com/filecoin-project/boost/fundmanager"
 	"github.com/filecoin-project/boost/gql"
 	"github.com/filecoin-project/boost/indexprovider"
+	"github.com/filecoin-project/boost/lib/dtcontrol"
+	"github.com/filecoin-project/boost/lib/faults"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/lib/fundsmigration"
+	"github.com/filecoin-project/boost/lib/multiminer"
+	"github.com/filecoin-project/boost/lib/paychmanager"
+	"github.com/filecoin-project/boost/lib/piecegc"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	boostmetrics "github.com/filecoin-project/boost/metrics"
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/node/impl"
 	"github.com/filecoin-project/boost/node/impl/common"
@@ -23,9 +32,9 @@ import (
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemanager"
 	"github.com/filecoin-project/boost/storagemarket"
-	"github.com/filecoin-project/boost/storagemarket/dealfilter"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/tracing"
+	"github.com/filecoin-project/boost/transport/bandwidth"
 	"github.com/filecoin-project/dagstore"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
@@ -135,6 +144,7 @@ const (
 	HandleIncomingBlocksKey
 	HandleIncomingMessagesKey
 	HandleMigrateClientFundsKey
+	HandleClientDatastoreKey
 	HandlePaymentChannelManagerKey
 
 	// miner
@@ -143,12 +153,23 @@ const (
 	HandleDealsKey
 	HandleRetrievalKey
 	HandleRetrievalTransportsKey
+	HandleRetrievalStatsKey
+	HandleRetrievalEventsKey
+	HandleRetrievalACLKey
+	HandleGraphsyncTransferBandwidthKey
 	HandleProtocolProxyKey
 	RunSectorServiceKey
 
+	// additional miners must be added before boost is started
+	HandleAdditionalMinersKey
 	// boost should be started after legacy markets (HandleDealsKey)
 	HandleBoostDealsKey
 	HandleProposalLogCleanerKey
+	HandleFundsReconcilerKey
+	HandleDealStateSinkKey
+	HandlePaychManagerKey
+	HandleCollateralTopUpKey
+	HandlePieceGCKey
 
 	// daemon
 	ExtractApiKey
@@ -405,6 +426,7 @@ var BoostNode = Options(
 	Override(new(*sql.DB), modules.NewBoostDB),
 	Override(new(*modules.LogSqlDB), modules.NewLogsSqlDB),
 	Override(new(*db.DealsDB), modules.NewDealsDB),
+	Override(new(*db.DealAnnotationsDB), modules.NewDealAnnotationsDB),
 	Override(new(*db.LogsDB), modules.NewLogsDB),
 	Override(new(*db.ProposalLogsDB), modules.NewProposalLogsDB),
 	Override(new(*db.FundsDB), modules.NewFundsDB),
@@ -444,6 +466,28 @@ func ConfigBoost(cfg *config.Boost) Option {
 		return Error(fmt.Errorf("Detected custom DAG store path %s. The DAG store must be at $BOOST_PATH/dagstore", cfg.DAGStore.RootDir))
 	}
 
+	userDealFilter, err := modules.UserStorageDealFilter(cfg.Dealmaking.Filter, cfg.Dealmaking.FilterWebhook, cfg.Dealmaking.FilterRules)
+	if err != nil {
+		return Error(fmt.Errorf("failed to configure cfg.Dealmaking storage deal filter: %w", err))
+	}
+	userRetrievalFilter, err := modules.UserRetrievalDealFilter(cfg.Dealmaking.RetrievalFilter, cfg.Dealmaking.RetrievalFilterWebhook)
+	if err != nil {
+		return Error(fmt.Errorf("failed to configure cfg.Dealmaking retrieval deal filter: %w", err))
+	}
+	minerStagingBytes := make(map[address.Address]uint64, len(cfg.Miners))
+	for _, m := range cfg.Miners {
+		maddr, err := address.NewFromString(m.Address)
+		if err != nil {
+			return Error(fmt.Errorf("failed to parse cfg.Miners address: %s; err: %w", m.Address, err))
+		}
+		if maddr == walletMiner {
+			return Error(fmt.Errorf("cfg.Miners must not include the miner set in cfg.Wallets.Miner: %s", m.Address))
+		}
+		if m.MaxStagingDealsBytes > 0 {
+			minerStagingBytes[maddr] = uint64(m.MaxStagingDealsBytes)
+		}
+	}
+
 	legacyFees := cfg.LotusFees.Legacy()
 
 	return Options(
@@ -465,11 +509,20 @@ func ConfigBoost(cfg *config.Boost) Option {
 			CollatWallet: walletDealCollat,
 			PubMsgWallet: walletPSD,
 			PubMsgBalMin: abi.TokenAmount(cfg.LotusFees.MaxPublishDealsFee),
+
+			ReservationExpiryGrace:     time.Duration(cfg.Dealmaking.FundsReservationExpiryGrace),
+			ReleaseExpiredReservations: cfg.Dealmaking.ReleaseExpiredFundsReservations,
 		})),
+		Override(new(*fundsmigration.Migration), modules.NewClientFundsMigration),
+
+		// The records of the legacy markets client are encrypted at rest
+		Override(new(dtypes.ClientDatastore), modules.NewClientDatastore),
+		Override(HandleClientDatastoreKey, modules.HandleClientDatastore),
 
 		Override(new(*storagemanager.StorageManager), storagemanager.New(storagemanager.Config{
 			MaxStagingDealsBytes:          uint64(cfg.Dealmaking.MaxStagingDealsBytes),
 			MaxStagingDealsPercentPerHost: uint64(cfg.Dealmaking.MaxStagingDealsPercentPerHost),
+			MinerMaxStagingDealsBytes:     minerStagingBytes,
 		})),
 
 		// Sector API
@@ -485,6 +538,7 @@ func ConfigBoost(cfg *config.Boost) Option {
 		Override(new(*storagemarket.ChainDealManager), modules.NewChainDealManager),
 		Override(new(smtypes.CommpCalculator), From(new(lotus_modules.MinerStorageService))),
 
+		Override(new(*bandwidth.Manager), modules.NewTransferBandwidthManager(cfg)),
 		Override(new(*storagemarket.Provider), modules.NewStorageMarketProvider(walletMiner, cfg)),
 
 		// GraphQL server
@@ -493,6 +547,9 @@ func ConfigBoost(cfg *config.Boost) Option {
 		// Tracing
 		Override(new(*tracing.Tracing), modules.NewTracing(cfg)),
 
+		// Metrics push
+		Override(new(*boostmetrics.Pusher), modules.NewMetricsPusher(cfg)),
+
 		// Address selector
 		Override(new(*ctladdr.AddressSelector), lotus_modules.AddressSelector(&lotus_config.MinerAddressConfig{
 			DealPublishControl: []string{cfg.Wallets.PublishStorageDeals},
@@ -500,8 +557,9 @@ func ConfigBoost(cfg *config.Boost) Option {
 
 		// Lotus Markets
 		Override(new(lotus_dtypes.StagingBlockstore), lotus_modules.StagingBlockstore),
-		Override(new(lotus_dtypes.StagingGraphsync), lotus_modules.StagingGraphsync(cfg.LotusDealmaking.SimultaneousTransfersForStorage, cfg.LotusDealmaking.SimultaneousTransfersForStoragePerClient, cfg.LotusDealmaking.SimultaneousTransfersForRetrieval)),
-		Override(new(lotus_dtypes.ProviderPieceStore), lotus_modules.NewProviderPieceStore),
+		Override(new(lotus_dtypes.StagingGraphsync), modules.StagingGraphsync(cfg.MarketsGraphsync, cfg.LotusDealmaking.SimultaneousTransfersForStorage, cfg.LotusDealmaking.SimultaneousTransfersForStoragePerClient, cfg.LotusDealmaking.SimultaneousTransfersForRetrieval)),
+		Override(new(*multiminer.PieceStores), modules.NewPieceStores),
+		Override(new(lotus_dtypes.ProviderPieceStore), From(new(*multiminer.PieceStores))),
 
 		// Lotus Markets (retrieval deps)
 		Override(new(sealer.PieceProvider), sealer.NewPieceProvider),
@@ -514,7 +572,8 @@ func ConfigBoost(cfg *config.Boost) Option {
 		})),
 
 		// DAG Store
-		Override(new(mktsdagstore.MinerAPI), lotus_modules.NewMinerAPI(cfg.DAGStore)),
+		Override(new(*multiminer.MinerAPIs), modules.NewMinerAPIs(cfg.DAGStore)),
+		Override(new(mktsdagstore.MinerAPI), From(new(*multiminer.MinerAPIs))),
 		Override(DAGStoreKey, lotus_modules.DAGStore(cfg.DAGStore)),
 		Override(new(dagstore.Interface), From(new(*dagstore.DAGStore))),
 		Override(new(dtypes.IndexBackedBlockstore), modules.NewIndexBackedBlockstore),
@@ -529,6 +588,14 @@ func ConfigBoost(cfg *config.Boost) Option {
 		Override(new(*lp2pimpl.TransportsListener), modules.NewTransportsListener(cfg)),
 		Override(new(*protocolproxy.ProtocolProxy), modules.NewProtocolProxy(cfg)),
 		Override(HandleRetrievalTransportsKey, modules.HandleRetrievalTransports),
+		Override(HandleRetrievalStatsKey, modules.HandleRetrievalStats),
+		If(len(cfg.RetrievalEvents.Sinks) > 0,
+			Override(HandleRetrievalEventsKey, modules.HandleRetrievalEvents(cfg.RetrievalEvents)),
+		),
+		Override(new(*retrievalacl.ACL), modules.NewRetrievalACL(cfg)),
+		Override(new(*modules.RunningConfig), modules.NewRunningConfig(cfg)),
+		Override(HandleRetrievalACLKey, modules.HandleRetrievalACL),
+		Override(HandleGraphsyncTransferBandwidthKey, modules.HandleGraphsyncTransferBandwidth),
 		Override(HandleProtocolProxyKey, modules.HandleProtocolProxy),
 		Override(new(idxprov.MeshCreator), idxprov.NewMeshCreator),
 		Override(new(provider.Interface), modules.IndexProvider(cfg.IndexProvider)),
@@ -536,19 +603,45 @@ func ConfigBoost(cfg *config.Boost) Option {
 		// Lotus Markets (storage)
 		Override(new(lotus_dtypes.ProviderTransferNetwork), lotus_modules.NewProviderTransferNetwork),
 		Override(new(lotus_dtypes.ProviderTransport), lotus_modules.NewProviderTransport),
+		Override(new(*faults.Injector), faults.New(cfg.Testing.EnableFaultInjection)),
+		Override(new(*features.Flags), func() (*features.Flags, error) {
+			return features.New(cfg.Features.Enable, cfg.Features.Disable)
+		}),
 		Override(new(lotus_dtypes.ProviderDataTransfer), modules.NewProviderDataTransfer),
+		Override(new(*dtcontrol.Controller), modules.NewDataTransferControl),
 		Override(new(*storedask.StoredAsk), lotus_modules.NewStorageAsk),
 
 		Override(new(lotus_storagemarket.StorageProviderNode), lotus_storageadapter.NewProviderNodeAdapter(&legacyFees, &cfg.LotusDealmaking)),
 		Override(new(lotus_storagemarket.StorageProvider), modules.NewLegacyStorageProvider(cfg)),
 		Override(HandleDealsKey, modules.HandleLegacyDeals),
+		Override(HandleAdditionalMinersKey, modules.HandleAdditionalMiners(cfg)),
 		Override(HandleBoostDealsKey, modules.HandleBoostDeals),
 		Override(HandleProposalLogCleanerKey, modules.HandleProposalLogCleaner(time.Duration(cfg.Dealmaking.DealProposalLogDuration))),
+		If(cfg.Dealmaking.FundsReconcileInterval > 0,
+			Override(HandleFundsReconcilerKey, modules.HandleFundsReconciler(time.Duration(cfg.Dealmaking.FundsReconcileInterval))),
+		),
+		If(cfg.DealStateSink.Type != "",
+			Override(HandleDealStateSinkKey, modules.HandleDealStateSink(cfg.DealStateSink)),
+		),
+		Override(new(*paychmanager.Manager), modules.NewPaychManager(cfg.PaymentChannels)),
+		// The retrieval client (which pays for retrievals with payment
+		// channels) is only created the first time that it's used
+		Override(new(retrievalmarket.RetrievalClient), modules.LazyRetrievalClient(false)),
+		If(cfg.PaymentChannels.EnableManager,
+			Override(HandlePaychManagerKey, modules.HandlePaychManager),
+		),
+		If(cfg.CollateralTopUp.Enable,
+			Override(HandleCollateralTopUpKey, modules.HandleCollateralTopUp(cfg.CollateralTopUp, walletDealCollat)),
+		),
+		Override(new(*piecegc.Collector), modules.NewPieceGC(cfg.PieceGC, walletMiner)),
+		If(cfg.PieceGC.Enable,
+			Override(HandlePieceGCKey, modules.HandlePieceGC),
+		),
 
 		// Boost storage deal filter
 		Override(new(dtypes.StorageDealFilter), modules.BasicDealFilter(cfg.Dealmaking, nil)),
-		If(cfg.Dealmaking.Filter != "",
-			Override(new(dtypes.StorageDealFilter), modules.BasicDealFilter(cfg.Dealmaking, dealfilter.CliStorageDealFilter(cfg.Dealmaking.Filter))),
+		If(userDealFilter != nil,
+			Override(new(dtypes.StorageDealFilter), modules.BasicDealFilter(cfg.Dealmaking, userDealFilter)),
 		),
 
 		// Lotus markets storage deal filter
@@ -559,8 +652,8 @@ func ConfigBoost(cfg *config.Boost) Option {
 
 		// Boost retrieval deal filter
 		Override(new(dtypes.RetrievalDealFilter), modules.RetrievalDealFilter(nil)),
-		If(cfg.Dealmaking.RetrievalFilter != "",
-			Override(new(dtypes.RetrievalDealFilter), modules.RetrievalDealFilter(dealfilter.CliRetrievalDealFilter(cfg.Dealmaking.RetrievalFilter))),
+		If(userRetrievalFilter != nil,
+			Override(new(dtypes.RetrievalDealFilter), modules.RetrievalDealFilter(userRetrievalFilter)),
 		),
 
 		// Lotus markets retrieval deal filter
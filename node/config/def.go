@@ -72,6 +72,58 @@ func DefaultBoost() *Boost {
 			ServiceName: "boostd",
 		},
 
+		MetricsPush: MetricsPushConfig{
+			PushgatewayURL: "",
+			PushgatewayJob: "boostd",
+			StatsdAddress:  "",
+			Interval:       Duration(15 * time.Second),
+		},
+
+		DealStateSink: DealStateSinkConfig{
+			Type:                     "",
+			URL:                      "",
+			Table:                    "boost_deal_states",
+			BatchSize:                500,
+			FlushInterval:            Duration(5 * time.Second),
+			TransferProgressInterval: Duration(30 * time.Second),
+		},
+
+		RetrievalEvents: RetrievalEventsConfig{
+			Sinks:            []string{},
+			BatchSize:        100,
+			FlushInterval:    Duration(time.Second),
+			ProgressInterval: Duration(10 * time.Second),
+		},
+
+		PaymentChannels: PaymentChannelsConfig{
+			EnableManager: false,
+			CheckInterval: Duration(10 * time.Minute),
+			MaxBaseFee:    types.MustParseFIL("100 attofil"),
+			MaxWait:       Duration(24 * time.Hour),
+		},
+
+		CollateralTopUp: CollateralTopUpConfig{
+			Enable:        false,
+			Threshold:     types.MustParseFIL("10"),
+			Target:        types.MustParseFIL("20"),
+			MinTransfer:   types.MustParseFIL("1"),
+			MaxTransfer:   types.MustParseFIL("50"),
+			CheckInterval: Duration(10 * time.Minute),
+			DryRun:        false,
+		},
+
+		PieceGC: PieceGCConfig{
+			Enable:      false,
+			Interval:    Duration(time.Hour),
+			GracePeriod: Duration(7 * 24 * time.Hour),
+			DryRun:      false,
+		},
+
+		Features: FeaturesConfig{
+			Enable:  []string{},
+			Disable: []string{},
+		},
+
 		Dealmaking: DealmakingConfig{
 			ConsiderOnlineStorageDeals:     true,
 			ConsiderOfflineStorageDeals:    true,
@@ -89,6 +141,9 @@ func DefaultBoost() *Boost {
 
 			DealProposalLogDuration: Duration(time.Hour * 24),
 
+			FilterWebhook:          DealFilterWebhook{Timeout: Duration(10 * time.Second)},
+			RetrievalFilterWebhook: DealFilterWebhook{Timeout: Duration(10 * time.Second)},
+
 			RetrievalPricing: &lotus_config.RetrievalPricing{
 				Strategy: RetrievalPricingDefaultMode,
 				Default: &lotus_config.RetrievalPricingDefault{
@@ -99,15 +154,34 @@ func DefaultBoost() *Boost {
 				},
 			},
 
-			MaxTransferDuration: Duration(24 * 3600 * time.Second),
+			MaxTransferDuration:    Duration(24 * 3600 * time.Second),
+			MaxDealTransferTimeout: Duration(7 * 24 * 3600 * time.Second),
 
 			RemoteCommp:             false,
 			MaxConcurrentLocalCommp: 1,
+			LocalCommpBackend:       "go",
 
 			HttpTransferMaxConcurrentDownloads: 20,
 			HttpTransferStallTimeout:           Duration(5 * time.Minute),
 			HttpTransferStallCheckPeriod:       Duration(30 * time.Second),
+			HttpTransferReadStallTimeout:       Duration(2 * time.Minute),
+			HttpTransferKeepaliveInterval:      Duration(10 * time.Second),
+			HttpTransferKeepaliveTimeout:       Duration(10 * time.Second),
+			HttpTransferMaxCompressedTransfers: 4,
+			EnableTcpTransfers:                 false,
+			TcpTransferReadStallTimeout:        Duration(time.Minute),
 			DealLogDurationDays:                30,
+			FundsReconcileInterval:             Duration(10 * time.Minute),
+			FundsReservationExpiryGrace:        Duration(time.Hour),
+			ReleaseExpiredFundsReservations:    true,
+
+			S3Transfers: S3TransfersConfig{
+				Region:      "us-east-1",
+				Buckets:     []string{},
+				PartSize:    16 << 20,
+				Concurrency: 4,
+				MaxAttempts: 5,
+			},
 		},
 
 		LotusDealmaking: lotus_config.DealmakingConfig{
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"reflect"
+)
+
+// RestartRequired returns the settings that differ between the running
+// config and the config loaded from the config file, that are only applied
+// when boost starts. Each setting is named by its section and key (eg
+// Dealmaking.MaxStagingDealsBytes), or just by its key if it is at the top
+// level.
+func RestartRequired(running, loaded *Boost) []string {
+	r := reflect.ValueOf(withoutReloadable(*running))
+	l := reflect.ValueOf(withoutReloadable(*loaded))
+
+	var changed []string
+	for i := 0; i < r.NumField(); i++ {
+		if !r.Type().Field(i).IsExported() {
+			continue
+		}
+		section := r.Type().Field(i).Name
+		rs, ls := r.Field(i), l.Field(i)
+		if rs.Kind() != reflect.Struct {
+			if !reflect.DeepEqual(rs.Interface(), ls.Interface()) {
+				changed = append(changed, section)
+			}
+			continue
+		}
+		for j := 0; j < rs.NumField(); j++ {
+			if !rs.Type().Field(j).IsExported() {
+				continue
+			}
+			if !reflect.DeepEqual(rs.Field(j).Interface(), ls.Field(j).Interface()) {
+				changed = append(changed, section+"."+rs.Type().Field(j).Name)
+			}
+		}
+	}
+	return changed
+}
+
+// withoutReloadable clears the settings that can be changed while boost is
+// running: those that are applied by reloading the config, and those that
+// are read from the config file each time they are used
+func withoutReloadable(c Boost) Boost {
+	d := &c.Dealmaking
+	// Applied by reloading the config
+	d.MaxTransferDuration = 0
+	d.MaxDealTransferTimeout = 0
+	d.RemoteCommp = false
+	d.LocalCommpBackend = ""
+	d.LocalCommpBackendEndpoint = ""
+	d.HttpTransferMaxConcurrentDownloads = 0
+	d.HttpTransferStallCheckPeriod = 0
+	d.HttpTransferStallTimeout = 0
+	c.RetrievalACL = RetrievalACLConfig{}
+
+	// Read from the config file each time
+	d.ConsiderOnlineStorageDeals = false
+	d.ConsiderOfflineStorageDeals = false
+	d.ConsiderOnlineRetrievalDeals = false
+	d.ConsiderOfflineRetrievalDeals = false
+	d.ConsiderVerifiedStorageDeals = false
+	d.ConsiderUnverifiedStorageDeals = false
+	d.PieceCidBlocklist = nil
+	d.ExpectedSealDuration = 0
+	d.MaxDealStartDelay = 0
+	return c
+}
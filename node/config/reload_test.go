@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartRequired(t *testing.T) {
+	running := DefaultBoost()
+
+	// Settings that can be changed while boost is running
+	loaded := DefaultBoost()
+	loaded.Dealmaking.MaxTransferDuration = Duration(time.Hour)
+	loaded.Dealmaking.HttpTransferMaxConcurrentDownloads = 5
+	loaded.Dealmaking.ConsiderOnlineStorageDeals = !running.Dealmaking.ConsiderOnlineStorageDeals
+	loaded.RetrievalACL.Deny = []string{"10.0.0.0/8"}
+	require.Empty(t, RestartRequired(running, loaded))
+
+	// Settings that are only applied when boost starts
+	loaded.SealerApiInfo = "sealer"
+	loaded.Dealmaking.MaxStagingDealsBytes = 1 << 30
+	loaded.Graphql.Port++
+	require.Equal(t, []string{"SealerApiInfo", "Dealmaking.MaxStagingDealsBytes", "Graphql.Port"},
+		RestartRequired(running, loaded))
+}
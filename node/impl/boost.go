@@ -2,11 +2,17 @@ package impl
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	tracing "github.com/filecoin-project/boost/tracing"
 	"github.com/multiformats/go-multihash"
@@ -15,26 +21,42 @@ import (
 	"github.com/filecoin-project/go-fil-markets/stores"
 
 	"github.com/filecoin-project/boost/api"
+	"github.com/filecoin-project/boost/db"
 	"github.com/filecoin-project/boost/gql"
 	"github.com/filecoin-project/boost/indexprovider"
+	"github.com/filecoin-project/boost/lib/dtcontrol"
+	"github.com/filecoin-project/boost/lib/faults"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/lib/fundsmigration"
+	"github.com/filecoin-project/boost/lib/paychmanager"
+	"github.com/filecoin-project/boost/lib/piecegc"
+	"github.com/filecoin-project/boost/lib/repobackup"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
+	"github.com/filecoin-project/boost/metrics"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/node/modules"
 	"github.com/filecoin-project/boost/node/modules/dtypes"
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemarket"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/dagstore"
 	"github.com/filecoin-project/dagstore/shard"
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	lotus_storagemarket "github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-jsonrpc/auth"
 	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/lib/backupds"
 	mktsdagstore "github.com/filecoin-project/lotus/markets/dagstore"
 	"github.com/filecoin-project/lotus/markets/storageadapter"
 	lotus_dtypes "github.com/filecoin-project/lotus/node/modules/dtypes"
+	lotus_repo "github.com/filecoin-project/lotus/node/repo"
 	"github.com/filecoin-project/lotus/storage/sectorblocks"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/mitchellh/go-homedir"
 	"go.uber.org/fx"
 )
 
@@ -54,8 +76,13 @@ type BoostAPI struct {
 	DagStoreWrapper       *mktsdagstore.Wrapper
 	IndexBackedBlockstore dtypes.IndexBackedBlockstore
 	// Boost
-	StorageProvider *storagemarket.Provider
-	IndexProvider   *indexprovider.Wrapper
+	SqlDB             *sql.DB
+	LogsSqlDB         *modules.LogSqlDB
+	DealsDB           *db.DealsDB
+	DealAnnotationsDB *db.DealAnnotationsDB
+	LogsDB            *db.LogsDB
+	StorageProvider   *storagemarket.Provider
+	IndexProvider     *indexprovider.Wrapper
 
 	// Legacy Lotus
 	LegacyStorageProvider lotus_storagemarket.StorageProvider
@@ -65,9 +92,17 @@ type BoostAPI struct {
 	PieceStore   lotus_dtypes.ProviderPieceStore
 	DataTransfer lotus_dtypes.ProviderDataTransfer
 
+	// Operator control of data transfer channels
+	DataTransferControl *dtcontrol.Controller
+
 	RetrievalProvider retrievalmarket.RetrievalProvider
 	SectorAccessor    retrievalmarket.SectorAccessor
 	DealPublisher     *storageadapter.DealPublisher
+	RetrievalACL      *retrievalacl.ACL
+
+	// The config that boost is running with, which is checked when the
+	// config is reloaded
+	RunningConfig *modules.RunningConfig
 
 	// Sealing Pipeline API
 	Sps sealingpipeline.API
@@ -78,6 +113,23 @@ type BoostAPI struct {
 	// Tracing
 	Tracing *tracing.Tracing
 
+	// Metrics push (nil if disabled)
+	MetricsPusher *metrics.Pusher
+
+	// Failure injection
+	Faults *faults.Injector
+
+	// Experimental feature flags
+	Features *features.Flags
+
+	ClientFundsMigration *fundsmigration.Migration
+
+	PaychManager *paychmanager.Manager
+
+	PieceGC *piecegc.Collector
+
+	Repo lotus_repo.LockedRepo
+
 	DS lotus_dtypes.MetadataDS
 
 	ConsiderOnlineStorageDealsConfigFunc        lotus_dtypes.ConsiderOnlineStorageDealsConfigFunc        `optional:"true"`
@@ -138,11 +190,249 @@ func (sm *BoostAPI) BoostIndexerAnnounceAllDeals(ctx context.Context) error {
 	return sm.IndexProvider.IndexerAnnounceAllDeals(ctx)
 }
 
+func (sm *BoostAPI) BoostListImports(ctx context.Context, filter types.ImportsFilter) (<-chan types.ImportInfo, error) {
+	imports, err := sm.DealsDB.ListImports(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("listing imports: %w", err)
+	}
+
+	res := make(chan types.ImportInfo, 16)
+	go func() {
+		defer close(res)
+
+		for _, imp := range imports {
+			select {
+			case res <- imp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+func (sm *BoostAPI) BoostFaultsGet(ctx context.Context) (faults.Faults, error) {
+	if !sm.Faults.Enabled() {
+		return faults.Faults{}, faults.ErrDisabled
+	}
+	return sm.Faults.Get(), nil
+}
+
+func (sm *BoostAPI) BoostFaultsSet(ctx context.Context, f faults.Faults) error {
+	return sm.Faults.Set(f)
+}
+
+func (sm *BoostAPI) BoostFeatures(ctx context.Context) ([]features.Status, error) {
+	return sm.Features.List(), nil
+}
+
+func (sm *BoostAPI) BoostFeatureSet(ctx context.Context, name string, enabled bool) error {
+	return sm.Features.Set(name, enabled)
+}
+
+func (sm *BoostAPI) BoostConfigReload(ctx context.Context) error {
+	c, err := sm.Repo.Config()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	cfg, ok := c.(*config.Boost)
+	if !ok {
+		return fmt.Errorf("invalid config type %T", c)
+	}
+
+	err = sm.RunningConfig.Reload(cfg, func(cfg *config.Boost) error {
+		prvCfg, err := modules.StorageMarketProviderConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("reloading storage provider config: %w", err)
+		}
+		err = sm.StorageProvider.ReloadConfig(prvCfg.Reloadable())
+		if err != nil {
+			return fmt.Errorf("reloading storage provider config: %w", err)
+		}
+		err = sm.RetrievalACL.Update(modules.RetrievalACLConfig(cfg.RetrievalACL))
+		if err != nil {
+			return fmt.Errorf("reloading retrieval ACL: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infow("reloaded config")
+	return nil
+}
+
+func (sm *BoostAPI) BoostDealQueue(ctx context.Context) ([]types.QueuedDeal, error) {
+	return sm.StorageProvider.TransferQueue(), nil
+}
+
+func (sm *BoostAPI) BoostDealQueueSetWeight(ctx context.Context, dealUuid uuid.UUID, weight int64) error {
+	return sm.StorageProvider.SetTransferQueueWeight(dealUuid, weight)
+}
+
+func (sm *BoostAPI) BoostDealRateLimits(ctx context.Context) (*types.DealRateLimitStatus, error) {
+	st := sm.StorageProvider.DealRateLimits()
+	return &st, nil
+}
+
+func (sm *BoostAPI) BoostDealRateLimitsSet(ctx context.Context, limits types.DealRateLimits) error {
+	sm.StorageProvider.SetDealRateLimits(limits)
+	return nil
+}
+
+func (sm *BoostAPI) BoostDealAnnotate(ctx context.Context, dealUuid uuid.UUID, key string, value string) error {
+	if err := types.ValidateDealAnnotation(key, value); err != nil {
+		return err
+	}
+	// Check that the deal exists
+	if _, err := sm.StorageProvider.Deal(ctx, dealUuid); err != nil {
+		return fmt.Errorf("getting deal %s: %w", dealUuid, err)
+	}
+	return sm.DealAnnotationsDB.Set(ctx, dealUuid, key, value, time.Now())
+}
+
+func (sm *BoostAPI) BoostDealAnnotationRemove(ctx context.Context, dealUuid uuid.UUID, key string) error {
+	return sm.DealAnnotationsDB.Remove(ctx, dealUuid, key)
+}
+
+func (sm *BoostAPI) BoostDealAnnotations(ctx context.Context, dealUuid uuid.UUID) ([]types.DealAnnotation, error) {
+	return sm.DealAnnotationsDB.ByDeal(ctx, dealUuid)
+}
+
+func (sm *BoostAPI) BoostDealAnnotationsList(ctx context.Context, key string, value string) ([]types.DealAnnotation, error) {
+	return sm.DealAnnotationsDB.List(ctx, key, value)
+}
+
+func (sm *BoostAPI) BoostRetrievalACL(ctx context.Context) (*retrievalacl.Config, error) {
+	cfg := sm.RetrievalACL.Config()
+	return &cfg, nil
+}
+
+// BoostRetrievalStatsRecord records an HTTP retrieval of the piece served by
+// booster-http, so that it's included in the piece's retrieval statistics
+func (sm *BoostAPI) BoostRetrievalStatsRecord(ctx context.Context, pieceCid cid.Cid, bytesServed uint64) error {
+	return db.NewRetrievalStatsDB(sm.SqlDB).Record(ctx, pieceCid, bytesServed, time.Now())
+}
+
+// BoostRetrievalStatsRecordBlocks adds the bytes of blocks served over
+// bitswap by booster-bitswap to the retrieval statistics of the pieces that
+// contain them
+func (sm *BoostAPI) BoostRetrievalStatsRecordBlocks(ctx context.Context, blocks []types.BlockServed) error {
+	// Attribute each block to the first piece that contains it, as bitswap
+	// doesn't say which piece a block was read from
+	byPiece := make(map[cid.Cid]uint64)
+	for _, b := range blocks {
+		pieces, err := sm.BoostDagstorePiecesContainingMultihash(ctx, b.Cid.Hash())
+		if err != nil || len(pieces) == 0 {
+			log.Debugw("getting piece for block served over bitswap", "cid", b.Cid, "err", err)
+			continue
+		}
+		byPiece[pieces[0]] += b.Bytes
+	}
+
+	statsDB := db.NewRetrievalStatsDB(sm.SqlDB)
+	now := time.Now()
+	for pieceCid, bytesServed := range byPiece {
+		if err := statsDB.AddBytes(ctx, pieceCid, bytesServed, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sm *BoostAPI) BoostBackup(ctx context.Context, fpath string) (*repobackup.Manifest, error) {
+	// Only allow backups to be written inside the base path, as the API
+	// writes to the boostd host's filesystem
+	bb, ok := os.LookupEnv("BOOST_BACKUP_BASE_PATH")
+	if !ok {
+		return nil, errors.New("BOOST_BACKUP_BASE_PATH env var not set")
+	}
+
+	bds, ok := sm.DS.(*backupds.Datastore)
+	if !ok {
+		return nil, errors.New("expected a backup datastore")
+	}
+
+	bb, err := homedir.Expand(bb)
+	if err != nil {
+		return nil, fmt.Errorf("expanding base path: %w", err)
+	}
+	bb, err = filepath.Abs(bb)
+	if err != nil {
+		return nil, fmt.Errorf("getting absolute base path: %w", err)
+	}
+	fpath, err = homedir.Expand(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("expanding file path: %w", err)
+	}
+	fpath, err = filepath.Abs(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("getting absolute file path: %w", err)
+	}
+	if !strings.HasPrefix(fpath, bb+string(filepath.Separator)) {
+		return nil, fmt.Errorf("backup file name (%s) must be inside base path (%s)", fpath, bb)
+	}
+
+	log.Infow("creating online backup", "path", fpath)
+	m, err := repobackup.CreateFile(ctx, fpath, repobackup.Source{
+		RepoPath: sm.Repo.Path(),
+		Metadata: bds,
+		DB:       sm.SqlDB,
+		LogsDB:   sm.LogsSqlDB.DB(),
+		Online:   true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating backup: %w", err)
+	}
+	return m, nil
+}
+
+func (sm *BoostAPI) BoostCapacityReservations(ctx context.Context) ([]types.CapacityReservationStatus, error) {
+	return sm.StorageProvider.CapacityReservations(ctx)
+}
+
+func (sm *BoostAPI) BoostClientFundsMigrationStatus(ctx context.Context) (*fundsmigration.Status, error) {
+	return sm.ClientFundsMigration.Status(ctx)
+}
+
+func (sm *BoostAPI) BoostClientFundsMigrate(ctx context.Context, wallet address.Address, dryRun bool) (*fundsmigration.Status, error) {
+	return sm.ClientFundsMigration.Migrate(ctx, wallet, dryRun)
+}
+
+func (sm *BoostAPI) BoostPaychInventory(ctx context.Context) ([]paychmanager.Channel, error) {
+	return sm.PaychManager.Inventory(ctx)
+}
+
+func (sm *BoostAPI) BoostPaychSettle(ctx context.Context, ch address.Address) error {
+	return sm.PaychManager.Settle(ctx, ch)
+}
+
+func (sm *BoostAPI) BoostPieceGC(ctx context.Context, dryRun bool) (*piecegc.Report, error) {
+	return sm.PieceGC.Collect(ctx, dryRun)
+}
+
 func (sm *BoostAPI) BoostOfflineDealWithData(ctx context.Context, dealUuid uuid.UUID, filePath string) (*api.ProviderDealRejectionInfo, error) {
 	res, err := sm.StorageProvider.ImportOfflineDealData(ctx, dealUuid, filePath)
 	return res, err
 }
 
+func (sm *BoostAPI) BoostOfflineDealUpload(ctx context.Context, dealUuid uuid.UUID) (*types.OfflineDealUpload, error) {
+	return sm.StorageProvider.OfflineDealUpload(ctx, dealUuid)
+}
+
+func (sm *BoostAPI) BoostOfflineDealUploadChunk(ctx context.Context, dealUuid uuid.UUID, offset uint64, r io.Reader) (uint64, error) {
+	return sm.StorageProvider.UploadOfflineDealData(ctx, dealUuid, offset, r)
+}
+
+func (sm *BoostAPI) BoostOfflineDealUploadFinish(ctx context.Context, dealUuid uuid.UUID) (*api.ProviderDealRejectionInfo, error) {
+	return sm.StorageProvider.FinishOfflineDealUpload(ctx, dealUuid)
+}
+
+func (sm *BoostAPI) BoostOfflineDealUploadCancel(ctx context.Context, dealUuid uuid.UUID) error {
+	return sm.StorageProvider.CancelOfflineDealUpload(ctx, dealUuid)
+}
+
 func (sm *BoostAPI) BoostDagstoreGC(ctx context.Context) ([]api.DagstoreShardResult, error) {
 	if sm.DAGStore == nil {
 		return nil, fmt.Errorf("dagstore not available on this node")
@@ -489,7 +779,7 @@ func (sm *BoostAPI) BlockstoreGet(ctx context.Context, c cid.Cid) ([]byte, error
 	if err != nil {
 		return nil, err
 	}
-	return blk.RawData(), nil
+	return sm.Faults.MaybeCorrupt(blk.RawData()), nil
 }
 
 func (sm *BoostAPI) BlockstoreHas(ctx context.Context, c cid.Cid) (bool, error) {
@@ -1,12 +1,14 @@
 package impl
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/filecoin-project/boost/lib/dtcontrol"
 	"github.com/filecoin-project/dagstore/shard"
 	"github.com/multiformats/go-multihash"
 
@@ -19,6 +21,7 @@ import (
 	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/ipfs/go-cid"
+	carindex "github.com/ipld/go-car/v2/index"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -52,6 +55,43 @@ func (sm *BoostAPI) MarketCancelDataTransfer(ctx context.Context, transferID dat
 	return sm.DataTransfer.CloseDataTransferChannel(ctx, datatransfer.ChannelID{Initiator: otherPeer, Responder: selfPeer, ID: transferID})
 }
 
+// dataTransferChannelID returns the id of the channel with the other peer
+func (sm *BoostAPI) dataTransferChannelID(transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) datatransfer.ChannelID {
+	selfPeer := sm.Host.ID()
+	if isInitiator {
+		return datatransfer.ChannelID{Initiator: selfPeer, Responder: otherPeer, ID: transferID}
+	}
+	return datatransfer.ChannelID{Initiator: otherPeer, Responder: selfPeer, ID: transferID}
+}
+
+func (sm *BoostAPI) BoostDataTransferPause(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error {
+	return sm.DataTransferControl.Pause(ctx, sm.dataTransferChannelID(transferID, otherPeer, isInitiator))
+}
+
+func (sm *BoostAPI) BoostDataTransferResume(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error {
+	return sm.DataTransferControl.Resume(ctx, sm.dataTransferChannelID(transferID, otherPeer, isInitiator))
+}
+
+func (sm *BoostAPI) BoostDataTransferCancel(ctx context.Context, transferID datatransfer.TransferID, otherPeer peer.ID, isInitiator bool) error {
+	return sm.DataTransferControl.Cancel(ctx, sm.dataTransferChannelID(transferID, otherPeer, isInitiator))
+}
+
+func (sm *BoostAPI) BoostDataTransferPausePeer(ctx context.Context, otherPeer peer.ID) ([]dtcontrol.Result, error) {
+	return sm.DataTransferControl.PausePeer(ctx, otherPeer)
+}
+
+func (sm *BoostAPI) BoostDataTransferResumePeer(ctx context.Context, otherPeer peer.ID) ([]dtcontrol.Result, error) {
+	return sm.DataTransferControl.ResumePeer(ctx, otherPeer)
+}
+
+func (sm *BoostAPI) BoostDataTransferCancelPeer(ctx context.Context, otherPeer peer.ID) ([]dtcontrol.Result, error) {
+	return sm.DataTransferControl.CancelPeer(ctx, otherPeer)
+}
+
+func (sm *BoostAPI) BoostDataTransferIntents(ctx context.Context) ([]dtcontrol.Intent, error) {
+	return sm.DataTransferControl.Intents(ctx)
+}
+
 func (sm *BoostAPI) MarketDataTransferUpdates(ctx context.Context) (<-chan lapi.DataTransferChannel, error) {
 	channels := make(chan lapi.DataTransferChannel)
 
@@ -241,6 +281,19 @@ func (sm *BoostAPI) PiecesGetMaxOffset(ctx context.Context, pieceCid cid.Cid) (u
 	return maxOffset, err
 }
 
+func (sm *BoostAPI) PiecesGetIndex(ctx context.Context, pieceCid cid.Cid) ([]byte, error) {
+	idx, err := sm.DAGStore.GetIterableIndex(shard.KeyFromCID(pieceCid))
+	if err != nil {
+		return nil, fmt.Errorf("getting index for piece %s from DAG store: %w", pieceCid, err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := carindex.WriteTo(idx, &buf); err != nil {
+		return nil, fmt.Errorf("writing index for piece %s: %w", pieceCid, err)
+	}
+	return buf.Bytes(), nil
+}
+
 func (sm *BoostAPI) RuntimeSubsystems(context.Context) (res lapi.MinerSubsystems, err error) {
 	return []lapi.MinerSubsystem{lapi.SubsystemMarkets}, nil
 }
@@ -0,0 +1,136 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/boost/build"
+	"github.com/filecoin-project/boost/lib/supportbundle"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/google/uuid"
+)
+
+func (sm *BoostAPI) BoostSupportSnapshot(ctx context.Context, params supportbundle.SnapshotParams) (*supportbundle.Snapshot, error) {
+	s := &supportbundle.Snapshot{
+		Version:      supportbundle.Version,
+		TakenAt:      time.Now(),
+		BoostVersion: build.UserVersion(),
+	}
+
+	// Active deals, followed by the most recent deals that aren't active
+	deals, err := sm.DealsDB.ListActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing active deals: %w", err)
+	}
+	if params.RecentDeals > 0 {
+		recent, err := sm.DealsDB.List(ctx, "", nil, 0, params.RecentDeals)
+		if err != nil {
+			return nil, fmt.Errorf("listing recent deals: %w", err)
+		}
+		deals = append(deals, recent...)
+	}
+
+	queued := make(map[uuid.UUID]struct{})
+	for _, qd := range sm.StorageProvider.TransferQueue() {
+		queued[qd.DealUuid] = struct{}{}
+	}
+
+	seen := make(map[uuid.UUID]struct{}, len(deals))
+	for _, ds := range deals {
+		if _, ok := seen[ds.DealUuid]; ok {
+			continue
+		}
+		seen[ds.DealUuid] = struct{}{}
+
+		deal := supportbundle.NewDeal(ds)
+		if xfer, ok := sm.boostTransfer(ds, queued); ok {
+			// The bytes received are only saved to the database periodically
+			if xfer.Transferred > uint64(deal.Transferred) {
+				deal.Transferred = int64(xfer.Transferred)
+			}
+			s.Transfers = append(s.Transfers, xfer)
+		}
+		s.Deals = append(s.Deals, deal)
+
+		logs, err := sm.LogsDB.Logs(ctx, ds.DealUuid)
+		if err != nil {
+			return nil, fmt.Errorf("getting logs for deal %s: %w", ds.DealUuid, err)
+		}
+		if params.LogsPerDeal > 0 && len(logs) > params.LogsPerDeal {
+			logs = logs[len(logs)-params.LogsPerDeal:]
+		}
+		for _, l := range logs {
+			s.Logs = append(s.Logs, supportbundle.Log{
+				DealUUID:  l.DealUUID.String(),
+				CreatedAt: l.CreatedAt,
+				Level:     l.LogLevel,
+				Subsystem: l.Subsystem,
+				Msg:       supportbundle.RedactText(l.LogMsg),
+				Params:    supportbundle.RedactText(l.LogParams),
+			})
+		}
+	}
+
+	// Legacy transfers
+	channels, err := sm.DataTransfer.InProgressChannels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing legacy data transfers: %w", err)
+	}
+	var legacy []supportbundle.Transfer
+	for chid, st := range channels {
+		ch := lapi.NewDataTransferChannel(sm.Host.ID(), st)
+		legacy = append(legacy, supportbundle.Transfer{
+			ID:          chid.String(),
+			Kind:        "legacy",
+			Status:      datatransfer.Statuses[ch.Status],
+			Peer:        ch.OtherPeer.String(),
+			Transferred: ch.Transferred,
+			Message:     supportbundle.RedactText(ch.Message),
+		})
+	}
+	sort.Slice(legacy, func(i, j int) bool {
+		return legacy[i].ID < legacy[j].ID
+	})
+	s.Transfers = append(s.Transfers, legacy...)
+
+	cfg, err := os.ReadFile(filepath.Join(sm.Repo.Path(), "config.toml"))
+	if err != nil {
+		// Still return the rest of the snapshot
+		log.Warnw("reading config file for support snapshot", "err", err)
+	}
+	s.Config = supportbundle.RedactConfig(string(cfg))
+
+	return s, nil
+}
+
+// boostTransfer gets the state of the deal's data transfer, if the deal is
+// waiting for its data or downloading it
+func (sm *BoostAPI) boostTransfer(ds *types.ProviderDealState, queued map[uuid.UUID]struct{}) (supportbundle.Transfer, bool) {
+	if ds.IsOffline || ds.Checkpoint != dealcheckpoints.Accepted || ds.Err != "" {
+		return supportbundle.Transfer{}, false
+	}
+
+	xfer := supportbundle.Transfer{
+		ID:          ds.DealUuid.String(),
+		Kind:        "boost",
+		Status:      "transferring",
+		Peer:        ds.ClientPeerID.String(),
+		Transferred: sm.StorageProvider.NBytesReceived(ds.DealUuid),
+	}
+	if _, ok := queued[ds.DealUuid]; ok {
+		xfer.Status = "queued"
+	} else if sm.StorageProvider.IsTransferStalled(ds.DealUuid) {
+		xfer.Status = "stalled"
+	}
+	if host, err := ds.Transfer.Host(); err == nil {
+		xfer.Message = "from " + host
+	}
+	return xfer, true
+}
@@ -1,7 +1,6 @@
 package modules
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -11,11 +10,13 @@ import (
 
 	"go.uber.org/fx"
 
-	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
 	"github.com/libp2p/go-libp2p/core/host"
 
+	"github.com/filecoin-project/boost/lib/encds"
+	"github.com/filecoin-project/boost/lib/fundsmigration"
+	"github.com/filecoin-project/boost/lib/paychmanager"
 	"github.com/filecoin-project/boost/node/modules/dtypes"
 	"github.com/filecoin-project/go-fil-markets/discovery"
 	discoveryimpl "github.com/filecoin-project/go-fil-markets/discovery/impl"
@@ -26,8 +27,9 @@ import (
 	storageimpl "github.com/filecoin-project/go-fil-markets/storagemarket/impl"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/requestvalidation"
 	smnet "github.com/filecoin-project/go-fil-markets/storagemarket/network"
+	"github.com/filecoin-project/lotus/api/v1api"
 	"github.com/filecoin-project/lotus/blockstore"
-	"github.com/filecoin-project/lotus/chain/market"
+	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/journal"
 	"github.com/filecoin-project/lotus/markets"
 	marketevents "github.com/filecoin-project/lotus/markets/loggers"
@@ -41,38 +43,12 @@ import (
 	"github.com/filecoin-project/lotus/node/repo/imports"
 )
 
-func HandleMigrateClientFunds(lc fx.Lifecycle, ds lotus_dtypes.MetadataDS, wallet full.WalletAPI, fundMgr *market.FundManager) {
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			addr, err := wallet.WalletDefaultAddress(ctx)
-			// nothing to be done if there is no default address
-			if err != nil {
-				return nil
-			}
-			b, err := ds.Get(ctx, datastore.NewKey("/marketfunds/client"))
-			if err != nil {
-				if errors.Is(err, datastore.ErrNotFound) {
-					return nil
-				}
-				log.Errorf("client funds migration - getting datastore value: %v", err)
-				return nil
-			}
-
-			var value abi.TokenAmount
-			if err = value.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
-				log.Errorf("client funds migration - unmarshalling datastore value: %v", err)
-				return nil
-			}
-			_, err = fundMgr.Reserve(ctx, addr, addr, value)
-			if err != nil {
-				log.Errorf("client funds migration - reserving funds (wallet %s, addr %s, funds %d): %v",
-					addr, addr, value, err)
-				return nil
-			}
-
-			return ds.Delete(ctx, datastore.NewKey("/marketfunds/client"))
-		},
-	})
+// NewClientFundsMigration creates the migration of the client funds that were
+// reserved by the legacy markets client. Reserving funds moves them on chain,
+// so the migration is never run automatically: the operator runs it with
+// `boostd client-funds-migration run`.
+func NewClientFundsMigration(ds lotus_dtypes.MetadataDS, fullnodeApi v1api.FullNode) *fundsmigration.Migration {
+	return fundsmigration.New(ds, fullnodeApi)
 }
 
 func ClientImportMgr(ds lotus_dtypes.MetadataDS, r repo.LockedRepo) (lotus_dtypes.ClientImportMgr, error) {
@@ -101,9 +77,64 @@ func RegisterClientValidator(crv dtypes.ClientRequestValidator, dtm dtypes.Clien
 	}
 }
 
-// NewClientDatastore creates a datastore for the client to store its deals
-func NewClientDatastore(ds lotus_dtypes.MetadataDS) dtypes.ClientDatastore {
-	return namespace.Wrap(ds, datastore.NewKey("/deals/client"))
+// NewClientDatastore creates a datastore for the client to store its deals.
+// Deal records (which include labels and other client metadata) are
+// encrypted at rest with a key from the repo keystore.
+func NewClientDatastore(ds lotus_dtypes.MetadataDS, ks types.KeyStore) (dtypes.ClientDatastore, error) {
+	key, err := clientDatastoreKey(ks)
+	if err != nil {
+		return nil, err
+	}
+	return encds.Wrap(namespace.Wrap(ds, datastore.NewKey("/deals/client")), key)
+}
+
+// HandleClientDatastore encrypts the client deal records that were written
+// before encryption was enabled, so that no client metadata is left in
+// plaintext
+func HandleClientDatastore(lc fx.Lifecycle, ds dtypes.ClientDatastore) {
+	encDs, ok := ds.(*encds.Datastore)
+	if !ok {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			count, err := encDs.EncryptExisting(ctx)
+			if err != nil {
+				log.Errorf("encrypting existing client datastore records: %v", err)
+				return nil
+			}
+			if count > 0 {
+				log.Infow("encrypted existing client datastore records", "count", count)
+			}
+			return nil
+		},
+	})
+}
+
+// clientDatastoreKey gets the key used to encrypt the client datastore,
+// generating it the first time it's needed
+func clientDatastoreKey(ks types.KeyStore) ([]byte, error) {
+	ki, err := ks.Get(ClientDatastoreKeyName)
+	if err == nil {
+		return ki.PrivateKey, nil
+	}
+	if !errors.Is(err, types.ErrKeyInfoNotFound) {
+		return nil, fmt.Errorf("getting client datastore key: %w", err)
+	}
+
+	log.Info("Generating new client datastore encryption key")
+	key, err := encds.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	ki = types.KeyInfo{
+		Type:       KTDatastoreKey,
+		PrivateKey: key,
+	}
+	if err := ks.Put(ClientDatastoreKeyName, ki); err != nil {
+		return nil, fmt.Errorf("writing client datastore key: %w", err)
+	}
+	return key, nil
 }
 
 // StorageBlockstoreAccessor returns the default storage blockstore accessor
@@ -150,10 +181,65 @@ func StorageClient(lc fx.Lifecycle, h host.Host, dataTransfer dtypes.ClientDataT
 	return c, nil
 }
 
-// RetrievalClient creates a new retrieval client attached to the client blockstore
-func RetrievalClient(lc fx.Lifecycle, h host.Host, r repo.LockedRepo, dt dtypes.ClientDataTransfer, payAPI payapi.PaychAPI, resolver discovery.PeerResolver,
+// RetrievalClient creates a new retrieval client attached to the client blockstore.
+// If useHttp is true, the client retrieves whole DAGs from the provider's
+// booster-http endpoint when it has one, paying for the retrieval a chunk at
+// a time with vouchers on a payment channel that is set up out-of-band, and
+// falls back to retrieving with graphsync when the retrieval can't be made
+// over http.
+func RetrievalClient(useHttp bool) func(lc fx.Lifecycle, h host.Host, r repo.LockedRepo, dt dtypes.ClientDataTransfer, payAPI payapi.PaychAPI, resolver discovery.PeerResolver,
 	ds lotus_dtypes.MetadataDS, chainAPI full.ChainAPI, stateAPI full.StateAPI, accessor retrievalmarket.BlockstoreAccessor, j journal.Journal) (retrievalmarket.RetrievalClient, error) {
 
+	return func(lc fx.Lifecycle, h host.Host, r repo.LockedRepo, dt dtypes.ClientDataTransfer, payAPI payapi.PaychAPI, resolver discovery.PeerResolver,
+		ds lotus_dtypes.MetadataDS, chainAPI full.ChainAPI, stateAPI full.StateAPI, accessor retrievalmarket.BlockstoreAccessor, j journal.Journal) (retrievalmarket.RetrievalClient, error) {
+
+		client, err := newRetrievalClient(h, dt, payAPI, resolver, ds, chainAPI, stateAPI, accessor)
+		if err != nil {
+			return nil, err
+		}
+		if useHttp {
+			// Subscribe through the http client so that the events of
+			// both http and graphsync retrievals are logged
+			client = newHttpRetrievalClient(client, h, payAPI, accessor)
+		}
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				return startRetrievalClient(ctx, client, j)
+			},
+		})
+		return client, nil
+	}
+}
+
+// LazyRetrievalClient is like RetrievalClient, except that the retrieval
+// client (and the payment channel adapter that it uses to pay for
+// retrievals) is only created and started the first time that it is used.
+// The payment channel manager is started at the same time. Nodes that only
+// make storage deals don't pay the startup time and memory of either.
+func LazyRetrievalClient(useHttp bool) func(h host.Host, dt dtypes.ClientDataTransfer, payAPI payapi.PaychAPI, resolver discovery.PeerResolver,
+	ds lotus_dtypes.MetadataDS, chainAPI full.ChainAPI, stateAPI full.StateAPI, accessor retrievalmarket.BlockstoreAccessor, j journal.Journal, mgr *paychmanager.Manager) retrievalmarket.RetrievalClient {
+
+	return func(h host.Host, dt dtypes.ClientDataTransfer, payAPI payapi.PaychAPI, resolver discovery.PeerResolver,
+		ds lotus_dtypes.MetadataDS, chainAPI full.ChainAPI, stateAPI full.StateAPI, accessor retrievalmarket.BlockstoreAccessor, j journal.Journal, mgr *paychmanager.Manager) retrievalmarket.RetrievalClient {
+
+		return newLazyRetrievalClient(func(ctx context.Context) (retrievalmarket.RetrievalClient, error) {
+			log.Info("initializing retrieval client on first use")
+			client, err := newRetrievalClient(h, dt, payAPI, resolver, ds, chainAPI, stateAPI, accessor)
+			if err != nil {
+				return nil, err
+			}
+			if useHttp {
+				client = newHttpRetrievalClient(client, h, payAPI, accessor)
+			}
+			mgr.Use()
+			return client, startRetrievalClient(ctx, client, j)
+		})
+	}
+}
+
+func newRetrievalClient(h host.Host, dt dtypes.ClientDataTransfer, payAPI payapi.PaychAPI, resolver discovery.PeerResolver,
+	ds lotus_dtypes.MetadataDS, chainAPI full.ChainAPI, stateAPI full.StateAPI, accessor retrievalmarket.BlockstoreAccessor) (retrievalmarket.RetrievalClient, error) {
+
 	adapter := retrievaladapter.NewRetrievalClientNode(false, payAPI, chainAPI, stateAPI)
 	network := rmnet.NewFromLibp2pHost(h)
 	ds = namespace.Wrap(ds, datastore.NewKey("/retrievals/client"))
@@ -162,15 +248,14 @@ func RetrievalClient(lc fx.Lifecycle, h host.Host, r repo.LockedRepo, dt dtypes.
 		return nil, err
 	}
 	client.OnReady(marketevents.ReadyLogger("retrieval client"))
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			client.SubscribeToEvents(marketevents.RetrievalClientLogger)
+	return client, nil
+}
 
-			evtType := j.RegisterEventType("markets/retrieval/client", "state_change")
-			client.SubscribeToEvents(markets.RetrievalClientJournaler(j, evtType))
+func startRetrievalClient(ctx context.Context, client retrievalmarket.RetrievalClient, j journal.Journal) error {
+	client.SubscribeToEvents(marketevents.RetrievalClientLogger)
 
-			return client.Start(ctx)
-		},
-	})
-	return client, nil
+	evtType := j.RegisterEventType("markets/retrieval/client", "state_change")
+	client.SubscribeToEvents(markets.RetrievalClientJournaler(j, evtType))
+
+	return client.Start(ctx)
 }
@@ -0,0 +1,66 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/fundmanager"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/journal"
+	"go.uber.org/fx"
+)
+
+// HandleCollateralTopUp periodically checks the balance of the deal
+// collateral wallet, and tops it up from the source wallet when it drops
+// below the threshold
+func HandleCollateralTopUp(cfg config.CollateralTopUpConfig, collatWallet address.Address) func(lc fx.Lifecycle, fullnodeApi v1api.FullNode, j journal.Journal) error {
+	return func(lc fx.Lifecycle, fullnodeApi v1api.FullNode, j journal.Journal) error {
+		source, err := address.NewFromString(cfg.SourceWallet)
+		if err != nil {
+			return fmt.Errorf("failed to parse CollateralTopUp.SourceWallet: '%s'; err: %w", cfg.SourceWallet, err)
+		}
+		if source == collatWallet {
+			return fmt.Errorf("CollateralTopUp.SourceWallet must be different to the deal collateral wallet %s", collatWallet)
+		}
+		if abi.TokenAmount(cfg.Target).LessThan(abi.TokenAmount(cfg.Threshold)) {
+			return fmt.Errorf("CollateralTopUp.Target %s must be at least CollateralTopUp.Threshold %s", cfg.Target, cfg.Threshold)
+		}
+		if max := abi.TokenAmount(cfg.MaxTransfer); !max.IsZero() && max.LessThan(abi.TokenAmount(cfg.MinTransfer)) {
+			return fmt.Errorf("CollateralTopUp.MaxTransfer %s must be at least CollateralTopUp.MinTransfer %s", cfg.MaxTransfer, cfg.MinTransfer)
+		}
+		if cfg.CheckInterval <= 0 {
+			return errors.New("CollateralTopUp.CheckInterval must be greater than zero")
+		}
+
+		topUp := fundmanager.NewTopUp(fullnodeApi, j, fundmanager.TopUpConfig{
+			Wallet:        collatWallet,
+			SourceWallet:  source,
+			Threshold:     abi.TokenAmount(cfg.Threshold),
+			Target:        abi.TokenAmount(cfg.Target),
+			MinTransfer:   abi.TokenAmount(cfg.MinTransfer),
+			MaxTransfer:   abi.TokenAmount(cfg.MaxTransfer),
+			CheckInterval: time.Duration(cfg.CheckInterval),
+			DryRun:        cfg.DryRun,
+		})
+
+		var cancel context.CancelFunc
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				var topUpCtx context.Context
+				topUpCtx, cancel = context.WithCancel(context.Background())
+				go topUp.Run(topUpCtx)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+		return nil
+	}
+}
@@ -0,0 +1,41 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/boost/node/config"
+)
+
+// RunningConfig is the config that boost is running with: the config it was
+// started with, updated each time the config is reloaded
+type RunningConfig struct {
+	lk  sync.Mutex
+	cfg *config.Boost
+}
+
+func NewRunningConfig(cfg *config.Boost) func() *RunningConfig {
+	return func() *RunningConfig {
+		return &RunningConfig{cfg: cfg}
+	}
+}
+
+// Reload applies the settings in cfg that can be changed while boost is
+// running, by calling apply. If cfg also changes settings that are only
+// applied when boost starts, nothing is applied and an error naming those
+// settings is returned.
+func (r *RunningConfig) Reload(cfg *config.Boost, apply func(*config.Boost) error) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if changed := config.RestartRequired(r.cfg, cfg); len(changed) > 0 {
+		return fmt.Errorf("boost must be restarted to apply changes to %s (revert them to reload the other settings)",
+			strings.Join(changed, ", "))
+	}
+	if err := apply(cfg); err != nil {
+		return err
+	}
+	r.cfg = cfg
+	return nil
+}
@@ -39,6 +39,9 @@ const (
 const (
 	JWTSecretName   = "auth-jwt-private" //nolint:gosec
 	KTJwtHmacSecret = "jwt-hmac-secret"  //nolint:gosec
+
+	ClientDatastoreKeyName = "client-datastore-key"
+	KTDatastoreKey         = "datastore-aes-key"
 )
 
 var (
@@ -2,14 +2,67 @@ package modules
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/storagemarket/dealfilter"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	lotus_repo "github.com/filecoin-project/lotus/node/repo"
 )
 
+// UserStorageDealFilter returns a deal filter that checks deals against the
+// filter rules and then runs the filter command or calls the filter webhook,
+// or nil if none are set
+func UserStorageDealFilter(filterCmd string, webhook config.DealFilterWebhook, filterRules []config.DealFilterRule) (dtypes.StorageDealFilter, error) {
+	var userDealFilter dtypes.StorageDealFilter
+	switch {
+	case filterCmd != "" && webhook.URL != "":
+		return nil, errors.New("only one of a filter command and a filter webhook may be set")
+	case filterCmd != "":
+		userDealFilter = dealfilter.CliStorageDealFilter(filterCmd)
+	case webhook.URL != "":
+		userDealFilter = dealfilter.WebhookStorageDealFilter(dealFilterWebhook(webhook))
+	}
+	if len(filterRules) > 0 {
+		rules := make([]dealfilter.Rule, 0, len(filterRules))
+		for _, r := range filterRules {
+			rules = append(rules, dealfilter.Rule{Name: r.Name, Expr: r.Expr})
+		}
+		compiled, err := dealfilter.CompileRules(rules)
+		if err != nil {
+			return nil, err
+		}
+		userDealFilter = dealfilter.RulesStorageDealFilter(compiled, userDealFilter)
+	}
+	return userDealFilter, nil
+}
+
+// UserRetrievalDealFilter returns a deal filter that runs the retrieval
+// filter command or calls the retrieval filter webhook, or nil if neither is
+// set
+func UserRetrievalDealFilter(filterCmd string, webhook config.DealFilterWebhook) (dtypes.RetrievalDealFilter, error) {
+	switch {
+	case filterCmd != "" && webhook.URL != "":
+		return nil, errors.New("only one of a retrieval filter command and a retrieval filter webhook may be set")
+	case filterCmd != "":
+		return dealfilter.CliRetrievalDealFilter(filterCmd), nil
+	case webhook.URL != "":
+		return dealfilter.WebhookRetrievalDealFilter(dealFilterWebhook(webhook)), nil
+	}
+	return nil, nil
+}
+
+func dealFilterWebhook(webhook config.DealFilterWebhook) dealfilter.Webhook {
+	return dealfilter.Webhook{
+		URL:     webhook.URL,
+		Secret:  webhook.Secret,
+		Timeout: time.Duration(webhook.Timeout),
+	}
+}
+
 func BasicDealFilter(cfg config.DealmakingConfig, userCmd dtypes.StorageDealFilter) func(onlineOk dtypes.ConsiderOnlineStorageDealsConfigFunc,
 	offlineOk dtypes.ConsiderOfflineStorageDealsConfigFunc,
 	verifiedOk dtypes.ConsiderVerifiedStorageDealsConfigFunc,
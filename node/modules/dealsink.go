@@ -0,0 +1,71 @@
+package modules
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/dealsink"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"go.uber.org/fx"
+)
+
+// HandleDealStateSink streams the state changes of all deals into an
+// external database
+func HandleDealStateSink(cfg config.DealStateSinkConfig) func(lc fx.Lifecycle, prov *storagemarket.Provider) {
+	return func(lc fx.Lifecycle, prov *storagemarket.Provider) {
+		var cancel context.CancelFunc
+		done := make(chan struct{})
+
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				sink, err := dealsink.New(cfg.Type, cfg.URL, cfg.Table)
+				if err != nil {
+					return err
+				}
+				sub, err := prov.SubscribeAllDealUpdates()
+				if err != nil {
+					_ = sink.Close()
+					return err
+				}
+
+				fwd := dealsink.NewForwarder(sink, dealsink.Config{
+					BatchSize:                cfg.BatchSize,
+					FlushInterval:            time.Duration(cfg.FlushInterval),
+					TransferProgressInterval: time.Duration(cfg.TransferProgressInterval),
+				})
+
+				// The forwarder initializes the sink in the background, so
+				// that boostd starts even if the database is down
+				var sinkCtx context.Context
+				sinkCtx, cancel = context.WithCancel(context.Background())
+				go func() {
+					defer close(done)
+					defer sink.Close() //nolint:errcheck
+					defer sub.Close()  //nolint:errcheck
+
+					go func() {
+						for evt := range sub.Out() {
+							deal := evt.(types.ProviderDealState)
+							fwd.AddDeal(&deal)
+						}
+					}()
+					fwd.Run(sinkCtx)
+				}()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				if cancel == nil {
+					return nil
+				}
+				cancel()
+				select {
+				case <-done:
+				case <-ctx.Done():
+				}
+				return nil
+			},
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package modules
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/boost/fundmanager"
+	"go.uber.org/fx"
+)
+
+// HandleFundsReconciler periodically reconciles the funds reserved for each
+// deal against the provider's balances
+func HandleFundsReconciler(interval time.Duration) func(lc fx.Lifecycle, fundMgr *fundmanager.FundManager) {
+	return func(lc fx.Lifecycle, fundMgr *fundmanager.FundManager) {
+		var cancel context.CancelFunc
+
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				var reconcilerCtx context.Context
+				reconcilerCtx, cancel = context.WithCancel(context.Background())
+				go fundMgr.RunReconciler(reconcilerCtx, interval)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+	}
+}
@@ -1,23 +1,30 @@
 package modules
 
 import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/boost/metrics"
+	boost_config "github.com/filecoin-project/boost/node/config"
 	"github.com/ipfs/go-graphsync"
 	graphsyncimpl "github.com/ipfs/go-graphsync/impl"
 	gsnet "github.com/ipfs/go-graphsync/network"
 	"github.com/ipfs/go-graphsync/storeutil"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"go.uber.org/fx"
 
 	"github.com/filecoin-project/lotus/node/config"
-	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	lotus_dtypes "github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/node/modules/helpers"
 	"github.com/filecoin-project/lotus/node/repo"
 )
 
 // Graphsync creates a graphsync instance from the given loader and storer
-func Graphsync(parallelTransfersForStorage uint64, parallelTransfersForRetrieval uint64) func(mctx helpers.MetricsCtx, lc fx.Lifecycle, r repo.LockedRepo, clientBs dtypes.ClientBlockstore, chainBs dtypes.ExposedBlockstore, h host.Host) (dtypes.Graphsync, error) {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, r repo.LockedRepo, clientBs dtypes.ClientBlockstore, chainBs dtypes.ExposedBlockstore, h host.Host) (dtypes.Graphsync, error) {
+func Graphsync(parallelTransfersForStorage uint64, parallelTransfersForRetrieval uint64) func(mctx helpers.MetricsCtx, lc fx.Lifecycle, r repo.LockedRepo, clientBs lotus_dtypes.ClientBlockstore, chainBs lotus_dtypes.ExposedBlockstore, h host.Host) (lotus_dtypes.Graphsync, error) {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, r repo.LockedRepo, clientBs lotus_dtypes.ClientBlockstore, chainBs lotus_dtypes.ExposedBlockstore, h host.Host) (lotus_dtypes.Graphsync, error) {
 		graphsyncNetwork := gsnet.NewFromLibp2pHost(h)
 		lsys := storeutil.LinkSystemForBlockstore(clientBs)
 
@@ -52,3 +59,120 @@ func Graphsync(parallelTransfersForStorage uint64, parallelTransfersForRetrieval
 		return gs, nil
 	}
 }
+
+// The graphsync defaults for parameters that are not exported by graphsync
+const (
+	defaultGraphsyncMessageSendRetries = 10
+	defaultGraphsyncSendMessageTimeout = 10 * time.Minute
+)
+
+// StagingGraphsync creates the graphsync instance used for markets data
+// transfers, tuned by the MarketsGraphsync config. Parameters that are not set
+// fall back to the lotus dealmaking config, or to the graphsync defaults.
+func StagingGraphsync(cfg boost_config.MarketsGraphsyncConfig, parallelTransfersForStorage uint64, parallelTransfersForStoragePerPeer uint64, parallelTransfersForRetrieval uint64) func(mctx helpers.MetricsCtx, lc fx.Lifecycle, ibs lotus_dtypes.StagingBlockstore, h host.Host) lotus_dtypes.StagingGraphsync {
+	cfg = marketsGraphsyncConfig(cfg, parallelTransfersForStorage, parallelTransfersForStoragePerPeer, parallelTransfersForRetrieval)
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, ibs lotus_dtypes.StagingBlockstore, h host.Host) lotus_dtypes.StagingGraphsync {
+		opts := []graphsyncimpl.Option{
+			graphsyncimpl.RejectAllRequestsByDefault(),
+			graphsyncimpl.MaxInProgressIncomingRequests(cfg.MaxInProgressIncomingRequests),
+			graphsyncimpl.MaxInProgressIncomingRequestsPerPeer(cfg.MaxInProgressIncomingRequestsPerPeer),
+			graphsyncimpl.MaxInProgressOutgoingRequests(cfg.MaxInProgressOutgoingRequests),
+			graphsyncimpl.MaxLinksPerIncomingRequests(config.MaxTraversalLinks),
+			graphsyncimpl.MaxLinksPerOutgoingRequests(config.MaxTraversalLinks),
+			graphsyncimpl.MessageSendRetries(cfg.MessageSendRetries),
+			graphsyncimpl.SendMessageTimeout(time.Duration(cfg.SendMessageTimeout)),
+		}
+		if cfg.MaxMemoryResponder > 0 {
+			opts = append(opts, graphsyncimpl.MaxMemoryResponder(cfg.MaxMemoryResponder))
+		}
+		if cfg.MaxMemoryPerPeerResponder > 0 {
+			opts = append(opts, graphsyncimpl.MaxMemoryPerPeerResponder(cfg.MaxMemoryPerPeerResponder))
+		}
+
+		graphsyncNetwork := gsnet.NewFromLibp2pHost(h)
+		lsys := storeutil.LinkSystemForBlockstore(ibs)
+		gs := graphsyncimpl.New(helpers.LifecycleCtx(mctx, lc), graphsyncNetwork, lsys, opts...)
+
+		marketsGraphsyncStats(mctx, lc, cfg, gs)
+
+		return gs
+	}
+}
+
+// marketsGraphsyncConfig fills in the parameters that are not set in the
+// MarketsGraphsync config
+func marketsGraphsyncConfig(cfg boost_config.MarketsGraphsyncConfig, parallelTransfersForStorage uint64, parallelTransfersForStoragePerPeer uint64, parallelTransfersForRetrieval uint64) boost_config.MarketsGraphsyncConfig {
+	if cfg.MaxInProgressIncomingRequests == 0 {
+		cfg.MaxInProgressIncomingRequests = parallelTransfersForRetrieval
+	}
+	if cfg.MaxInProgressIncomingRequestsPerPeer == 0 {
+		cfg.MaxInProgressIncomingRequestsPerPeer = parallelTransfersForStoragePerPeer
+	}
+	if cfg.MaxInProgressOutgoingRequests == 0 {
+		cfg.MaxInProgressOutgoingRequests = parallelTransfersForStorage
+	}
+	if cfg.MessageSendRetries == 0 {
+		cfg.MessageSendRetries = defaultGraphsyncMessageSendRetries
+	}
+	if cfg.SendMessageTimeout == 0 {
+		cfg.SendMessageTimeout = boost_config.Duration(defaultGraphsyncSendMessageTimeout)
+	}
+	return cfg
+}
+
+// marketsGraphsyncStats periodically records the value of each markets
+// graphsync parameter, alongside the usage of the resource it limits, so that
+// operators can see which limits are being hit
+func marketsGraphsyncStats(mctx helpers.MetricsCtx, lc fx.Lifecycle, cfg boost_config.MarketsGraphsyncConfig, gs graphsync.GraphExchange) {
+	record := func(param string, ms ...stats.Measurement) {
+		_ = stats.RecordWithTags(mctx, []tag.Mutator{tag.Upsert(metrics.GraphsyncParam, param)}, ms...)
+	}
+
+	stopStats := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				t := time.NewTicker(10 * time.Second)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+						st := gs.Stats()
+						record("max_in_progress_incoming_requests",
+							metrics.MarketsGraphsyncLimit.M(int64(cfg.MaxInProgressIncomingRequests)),
+							metrics.MarketsGraphsyncUsage.M(int64(st.IncomingRequests.Active)),
+							metrics.MarketsGraphsyncPending.M(int64(st.IncomingRequests.Pending)))
+						record("max_in_progress_incoming_requests_per_peer",
+							metrics.MarketsGraphsyncLimit.M(int64(cfg.MaxInProgressIncomingRequestsPerPeer)))
+						record("max_in_progress_outgoing_requests",
+							metrics.MarketsGraphsyncLimit.M(int64(cfg.MaxInProgressOutgoingRequests)),
+							metrics.MarketsGraphsyncUsage.M(int64(st.OutgoingRequests.Active)),
+							metrics.MarketsGraphsyncPending.M(int64(st.OutgoingRequests.Pending)))
+						record("max_memory_responder",
+							metrics.MarketsGraphsyncLimit.M(int64(st.OutgoingResponses.MaxAllowedAllocatedTotal)),
+							metrics.MarketsGraphsyncUsage.M(int64(st.OutgoingResponses.TotalAllocatedAllPeers)),
+							metrics.MarketsGraphsyncPending.M(int64(st.OutgoingResponses.TotalPendingAllocations)))
+						// The usage of the per-peer memory limit is reported as
+						// the number of peers that are waiting for memory
+						record("max_memory_per_peer_responder",
+							metrics.MarketsGraphsyncLimit.M(int64(st.OutgoingResponses.MaxAllowedAllocatedPerPeer)),
+							metrics.MarketsGraphsyncPending.M(int64(st.OutgoingResponses.NumPeersWithPendingAllocations)))
+						record("message_send_retries",
+							metrics.MarketsGraphsyncLimit.M(int64(cfg.MessageSendRetries)))
+						record("send_message_timeout",
+							metrics.MarketsGraphsyncLimit.M(time.Duration(cfg.SendMessageTimeout).Milliseconds()))
+
+					case <-stopStats:
+						return
+					}
+				}
+			}()
+
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(stopStats)
+			return nil
+		},
+	})
+}
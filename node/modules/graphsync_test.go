@@ -0,0 +1,69 @@
+package modules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	boost_config "github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/lotus/blockstore"
+	ds "github.com/ipfs/go-datastore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestMarketsGraphsyncConfig(t *testing.T) {
+	// Parameters that are not set fall back to the lotus dealmaking config
+	// and to the graphsync defaults
+	cfg := marketsGraphsyncConfig(boost_config.MarketsGraphsyncConfig{}, 20, 5, 30)
+	require.Equal(t, boost_config.MarketsGraphsyncConfig{
+		MaxInProgressIncomingRequests:        30,
+		MaxInProgressIncomingRequestsPerPeer: 5,
+		MaxInProgressOutgoingRequests:        20,
+		MessageSendRetries:                   10,
+		SendMessageTimeout:                   boost_config.Duration(10 * time.Minute),
+	}, cfg)
+
+	// Parameters that are set are used as is
+	set := boost_config.MarketsGraphsyncConfig{
+		MaxInProgressIncomingRequests:        1,
+		MaxInProgressIncomingRequestsPerPeer: 2,
+		MaxInProgressOutgoingRequests:        3,
+		MaxMemoryResponder:                   4 << 20,
+		MaxMemoryPerPeerResponder:            1 << 20,
+		MessageSendRetries:                   5,
+		SendMessageTimeout:                   boost_config.Duration(time.Minute),
+	}
+	require.Equal(t, set, marketsGraphsyncConfig(set, 20, 5, 30))
+}
+
+func TestStagingGraphsync(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { _ = mn.Close() })
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	bs := blockstore.FromDatastore(ds.NewMapDatastore())
+
+	newGraphsync := func(cfg boost_config.MarketsGraphsyncConfig) *fxtest.Lifecycle {
+		lc := fxtest.NewLifecycle(t)
+		gs := StagingGraphsync(cfg, 20, 5, 30)(context.Background(), lc, bs, h)
+		lc.RequireStart()
+
+		// The memory limits are applied to the graphsync responder
+		st := gs.Stats()
+		if cfg.MaxMemoryResponder > 0 {
+			require.EqualValues(t, cfg.MaxMemoryResponder, st.OutgoingResponses.MaxAllowedAllocatedTotal)
+		}
+		if cfg.MaxMemoryPerPeerResponder > 0 {
+			require.EqualValues(t, cfg.MaxMemoryPerPeerResponder, st.OutgoingResponses.MaxAllowedAllocatedPerPeer)
+		}
+		return lc
+	}
+
+	newGraphsync(boost_config.MarketsGraphsyncConfig{}).RequireStop()
+	newGraphsync(boost_config.MarketsGraphsyncConfig{
+		MaxMemoryResponder:        4 << 20,
+		MaxMemoryPerPeerResponder: 1 << 20,
+	}).RequireStop()
+}
@@ -0,0 +1,363 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/boost/lib/httpretrieval"
+	"github.com/filecoin-project/boost/retrievalmarket/lp2pimpl"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	multiaddrutil "github.com/filecoin-project/go-legs/httpsync/multiaddr"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	paychtypes "github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	lapi "github.com/filecoin-project/lotus/api"
+	payapi "github.com/filecoin-project/lotus/node/impl/paych"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// httpRetrievalClient retrieves whole DAGs from the provider's booster-http
+// endpoint, and falls back to the graphsync retrieval client when the
+// retrieval can't be made over http: the provider has no http endpoint, the
+// endpoint doesn't serve the DAG, its price is higher than the retrieval's,
+// or the retrieval has a selector.
+//
+// Payment for a paid retrieval is negotiated out-of-band: a payment channel
+// to the provider is created (or topped up) on chain, and the retrieval is
+// paid for a chunk at a time, with a voucher for the total paid so far sent
+// with the request for each chunk. Only the vouchers that the provider
+// accepted are counted as spent.
+type httpRetrievalClient struct {
+	retrievalmarket.RetrievalClient
+
+	transports *lp2pimpl.TransportsClient
+	http       *httpretrieval.Client
+	payAPI     payapi.PaychAPI
+	accessor   retrievalmarket.BlockstoreAccessor
+
+	lk      sync.Mutex
+	deals   map[retrievalmarket.DealID]*httpRetrievalDeal
+	subs    map[int]retrievalmarket.ClientSubscriber
+	nextSub int
+}
+
+type httpRetrievalDeal struct {
+	state    retrievalmarket.ClientDealState
+	endpoint string
+	// The endpoint's payment terms, if it requires payment
+	terms  *httpretrieval.Terms
+	cancel context.CancelFunc
+}
+
+var _ retrievalmarket.RetrievalClient = (*httpRetrievalClient)(nil)
+
+func newHttpRetrievalClient(gs retrievalmarket.RetrievalClient, h host.Host, payAPI payapi.PaychAPI, accessor retrievalmarket.BlockstoreAccessor) *httpRetrievalClient {
+	return &httpRetrievalClient{
+		RetrievalClient: gs,
+		transports:      lp2pimpl.NewTransportsClient(h),
+		http:            httpretrieval.NewClient(nil),
+		payAPI:          payAPI,
+		accessor:        accessor,
+		deals:           make(map[retrievalmarket.DealID]*httpRetrievalDeal),
+		subs:            make(map[int]retrievalmarket.ClientSubscriber),
+	}
+}
+
+func (c *httpRetrievalClient) Retrieve(ctx context.Context, id retrievalmarket.DealID, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address) (retrievalmarket.DealID, error) {
+	endpoint, terms, err := c.httpAvailable(ctx, payloadCID, params, totalFunds, p)
+	if err != nil {
+		log.Infow("retrieving with graphsync", "id", id, "payload", payloadCID, "provider", p.Address, "reason", err)
+		return c.RetrievalClient.Retrieve(ctx, id, payloadCID, params, totalFunds, p, clientWallet, minerWallet)
+	}
+
+	// The retrieval outlives the request to start it, so it gets its own
+	// context that is cancelled by CancelDeal
+	dctx, cancel := context.WithCancel(context.Background())
+	d := &httpRetrievalDeal{
+		state: retrievalmarket.ClientDealState{
+			DealProposal:     retrievalmarket.DealProposal{PayloadCID: payloadCID, ID: id, Params: params},
+			TotalFunds:       totalFunds,
+			ClientWallet:     clientWallet,
+			MinerWallet:      minerWallet,
+			Status:           retrievalmarket.DealStatusNew,
+			Sender:           p.ID,
+			PaymentRequested: big.Zero(),
+			FundsSpent:       big.Zero(),
+			UnsealFundsPaid:  big.Zero(),
+			VoucherShortfall: big.Zero(),
+		},
+		endpoint: endpoint,
+		terms:    terms,
+		cancel:   cancel,
+	}
+	c.lk.Lock()
+	c.deals[id] = d
+	c.lk.Unlock()
+
+	log.Infow("retrieving over http", "id", id, "payload", payloadCID, "provider", p.Address, "endpoint", endpoint, "paid", terms != nil)
+	go c.retrieve(dctx, d)
+	return id, nil
+}
+
+// httpAvailable returns the provider's http endpoint and its payment terms
+// (nil if the endpoint is free), or the reason that the retrieval can't be
+// made over http
+func (c *httpRetrievalClient) httpAvailable(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer) (string, *httpretrieval.Terms, error) {
+	if params.SelectorSpecified() && !isExploreAll(params) {
+		return "", nil, errors.New("http retrieval only supports retrieving whole DAGs")
+	}
+
+	endpoint, err := c.httpEndpoint(ctx, p.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	av, terms, err := c.http.Probe(ctx, endpoint, payloadCID)
+	if err != nil {
+		return "", nil, err
+	}
+	if av != httpretrieval.Paid {
+		return endpoint, nil, nil
+	}
+	if totalFunds.IsZero() {
+		return "", nil, errors.New("http endpoint requires payment but the retrieval has no funds")
+	}
+	if terms.PricePerByte.GreaterThan(params.PricePerByte) {
+		return "", nil, fmt.Errorf("http endpoint price per byte %s is higher than the retrieval's %s", terms.PricePerByte, params.PricePerByte)
+	}
+	return endpoint, terms, nil
+}
+
+// httpEndpoint queries the provider's retrieval transports for the url of
+// its http endpoint
+func (c *httpRetrievalClient) httpEndpoint(ctx context.Context, id peer.ID) (string, error) {
+	resp, err := c.transports.SendQuery(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("fetching retrieval transports: %w", err)
+	}
+	for _, p := range resp.Protocols {
+		if p.Name != "http" && p.Name != "https" {
+			continue
+		}
+		for _, ma := range p.Addresses {
+			if u, err := multiaddrutil.ToURL(ma); err == nil {
+				return u.String(), nil
+			}
+		}
+	}
+	return "", errors.New("provider does not support retrieval over http")
+}
+
+func (c *httpRetrievalClient) retrieve(ctx context.Context, d *httpRetrievalDeal) {
+	st := c.update(d, retrievalmarket.ClientEventDealAccepted, func(st *retrievalmarket.ClientDealState) {
+		st.Status = retrievalmarket.DealStatusAccepted
+	})
+
+	var pay *httpretrieval.Payment
+	if d.terms != nil {
+		var err error
+		pay, err = c.payment(ctx, d)
+		if err != nil {
+			c.fail(d, retrievalmarket.ClientEventPaymentChannelErrored, err)
+			return
+		}
+	}
+
+	bs, err := c.accessor.Get(st.ID, st.PayloadCID)
+	if err != nil {
+		c.fail(d, retrievalmarket.ClientEventDataTransferError, fmt.Errorf("getting blockstore: %w", err))
+		return
+	}
+	c.update(d, retrievalmarket.ClientEventBlocksReceived, func(st *retrievalmarket.ClientDealState) {
+		st.Status = retrievalmarket.DealStatusOngoing
+	})
+
+	received, err := c.http.Retrieve(ctx, d.endpoint, st.PayloadCID, pay, bs, func(n uint64) {
+		c.lk.Lock()
+		d.state.TotalReceived = n
+		c.lk.Unlock()
+	})
+	if doneErr := c.accessor.Done(st.ID); doneErr != nil {
+		log.Warnw("finalizing http retrieval blockstore", "id", st.ID, "err", doneErr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			c.update(d, retrievalmarket.ClientEventCancelComplete, func(st *retrievalmarket.ClientDealState) {
+				st.Status = retrievalmarket.DealStatusCancelled
+			})
+			return
+		}
+		c.fail(d, retrievalmarket.ClientEventDataTransferError, err)
+		return
+	}
+
+	c.update(d, retrievalmarket.ClientEventAllBlocksReceived, func(st *retrievalmarket.ClientDealState) {
+		st.TotalReceived = received
+		st.AllBlocksReceived = true
+	})
+	c.update(d, retrievalmarket.ClientEventComplete, func(st *retrievalmarket.ClientDealState) {
+		st.Status = retrievalmarket.DealStatusCompleted
+	})
+}
+
+// payment gets a payment channel to the provider with enough funds for the
+// retrieval, waiting for it to be created or topped up on chain, and
+// allocates a lane for the retrieval's vouchers. The retrieval is then paid
+// for a chunk at a time, with a voucher on the lane for the total paid so
+// far.
+func (c *httpRetrievalClient) payment(ctx context.Context, d *httpRetrievalDeal) (*httpretrieval.Payment, error) {
+	st := c.update(d, retrievalmarket.ClientEventPaymentChannelCreateInitiated, func(st *retrievalmarket.ClientDealState) {
+		st.Status = retrievalmarket.DealStatusPaymentChannelCreating
+	})
+
+	ci, err := c.payAPI.PaychGet(ctx, st.ClientWallet, st.MinerWallet, st.TotalFunds, lapi.PaychGetOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("getting payment channel: %w", err)
+	}
+	ch := ci.Channel
+	if ci.WaitSentinel.Defined() {
+		ch, err = c.payAPI.PaychGetWaitReady(ctx, ci.WaitSentinel)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for payment channel: %w", err)
+		}
+	}
+	lane, err := c.payAPI.PaychAllocateLane(ctx, ch)
+	if err != nil {
+		return nil, fmt.Errorf("allocating payment channel lane: %w", err)
+	}
+	c.update(d, retrievalmarket.ClientEventPaymentChannelReady, func(st *retrievalmarket.ClientDealState) {
+		st.PaymentInfo = &retrievalmarket.PaymentInfo{PayCh: ch, Lane: lane}
+		st.Status = retrievalmarket.DealStatusOngoing
+	})
+
+	return &httpretrieval.Payment{
+		Terms: *d.terms,
+		Voucher: func(ctx context.Context, total abi.TokenAmount) (*paychtypes.SignedVoucher, error) {
+			if total.GreaterThan(st.TotalFunds) {
+				return nil, fmt.Errorf("retrieval costs more than its total funds %s", st.TotalFunds)
+			}
+			c.update(d, retrievalmarket.ClientEventPaymentRequested, func(st *retrievalmarket.ClientDealState) {
+				st.PaymentRequested = big.Sub(total, st.FundsSpent)
+			})
+			res, err := c.payAPI.PaychVoucherCreate(ctx, ch, total, lane)
+			if err != nil {
+				return nil, fmt.Errorf("creating payment voucher: %w", err)
+			}
+			if res.Voucher == nil {
+				c.update(d, retrievalmarket.ClientEventVoucherShortfall, func(st *retrievalmarket.ClientDealState) {
+					st.VoucherShortfall = res.Shortfall
+				})
+				return nil, fmt.Errorf("payment channel %s has a shortfall of %s", ch, res.Shortfall)
+			}
+			return res.Voucher, nil
+		},
+		Accepted: func(total abi.TokenAmount, bytesPaidFor uint64) {
+			c.update(d, retrievalmarket.ClientEventPaymentSent, func(st *retrievalmarket.ClientDealState) {
+				st.FundsSpent = total
+				st.BytesPaidFor = bytesPaidFor
+				st.PaymentRequested = big.Zero()
+			})
+		},
+	}, nil
+}
+
+// update changes the state of the deal and notifies subscribers of the
+// event. It returns the new state.
+func (c *httpRetrievalClient) update(d *httpRetrievalDeal, evt retrievalmarket.ClientEvent, change func(*retrievalmarket.ClientDealState)) retrievalmarket.ClientDealState {
+	c.lk.Lock()
+	change(&d.state)
+	st := d.state
+	subs := make([]retrievalmarket.ClientSubscriber, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	c.lk.Unlock()
+
+	for _, sub := range subs {
+		sub(evt, st)
+	}
+	return st
+}
+
+func (c *httpRetrievalClient) fail(d *httpRetrievalDeal, evt retrievalmarket.ClientEvent, err error) {
+	log.Warnw("http retrieval failed", "id", d.state.ID, "payload", d.state.PayloadCID, "err", err)
+	c.update(d, evt, func(st *retrievalmarket.ClientDealState) {
+		st.Status = retrievalmarket.DealStatusErrored
+		st.Message = err.Error()
+	})
+}
+
+// SubscribeToEvents subscribes to the events of both http and graphsync
+// retrievals
+func (c *httpRetrievalClient) SubscribeToEvents(subscriber retrievalmarket.ClientSubscriber) retrievalmarket.Unsubscribe {
+	unsubGs := c.RetrievalClient.SubscribeToEvents(subscriber)
+
+	c.lk.Lock()
+	id := c.nextSub
+	c.nextSub++
+	c.subs[id] = subscriber
+	c.lk.Unlock()
+
+	return func() {
+		unsubGs()
+		c.lk.Lock()
+		delete(c.subs, id)
+		c.lk.Unlock()
+	}
+}
+
+func (c *httpRetrievalClient) CancelDeal(id retrievalmarket.DealID) error {
+	c.lk.Lock()
+	d, ok := c.deals[id]
+	c.lk.Unlock()
+	if !ok {
+		return c.RetrievalClient.CancelDeal(id)
+	}
+	d.cancel()
+	return nil
+}
+
+func (c *httpRetrievalClient) GetDeal(id retrievalmarket.DealID) (retrievalmarket.ClientDealState, error) {
+	c.lk.Lock()
+	d, ok := c.deals[id]
+	var st retrievalmarket.ClientDealState
+	if ok {
+		st = d.state
+	}
+	c.lk.Unlock()
+	if !ok {
+		return c.RetrievalClient.GetDeal(id)
+	}
+	return st, nil
+}
+
+func (c *httpRetrievalClient) ListDeals() (map[retrievalmarket.DealID]retrievalmarket.ClientDealState, error) {
+	deals, err := c.RetrievalClient.ListDeals()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	for id, d := range c.deals {
+		deals[id] = d.state
+	}
+	return deals, nil
+}
+
+// isExploreAll returns true if the retrieval's selector selects the whole DAG
+func isExploreAll(params retrievalmarket.Params) bool {
+	all, err := ipld.Encode(selectorparse.CommonSelector_ExploreAllRecursively, dagcbor.Encode)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(params.Selector.Raw, all)
+}
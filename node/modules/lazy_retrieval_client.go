@@ -0,0 +1,190 @@
+package modules
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// lazyRetrievalClient creates the retrieval client the first time that a
+// method that needs it is called. Ready funcs and event subscribers are
+// queued until then.
+type lazyRetrievalClient struct {
+	newClient func(ctx context.Context) (retrievalmarket.RetrievalClient, error)
+
+	lk     sync.Mutex
+	client retrievalmarket.RetrievalClient
+	err    error
+	ready  []shared.ReadyFunc
+	// The subscribers that are queued until the client is created
+	subs map[int]retrievalmarket.ClientSubscriber
+	// The unsubscribe funcs of the queued subscribers, once they have been
+	// subscribed to the client
+	unsubs map[int]retrievalmarket.Unsubscribe
+	nextID int
+}
+
+var _ retrievalmarket.RetrievalClient = (*lazyRetrievalClient)(nil)
+
+func newLazyRetrievalClient(newClient func(ctx context.Context) (retrievalmarket.RetrievalClient, error)) *lazyRetrievalClient {
+	return &lazyRetrievalClient{
+		newClient: newClient,
+		subs:      make(map[int]retrievalmarket.ClientSubscriber),
+		unsubs:    make(map[int]retrievalmarket.Unsubscribe),
+	}
+}
+
+// get returns the retrieval client, creating it if it hasn't been created
+// yet. If creating the client fails, the error is returned from every call.
+func (l *lazyRetrievalClient) get() (retrievalmarket.RetrievalClient, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	if l.client != nil || l.err != nil {
+		return l.client, l.err
+	}
+
+	client, err := l.newClient(context.Background())
+	if err != nil {
+		log.Errorw("initializing retrieval client", "err", err)
+		l.err = err
+		for _, f := range l.ready {
+			f(err)
+		}
+		return nil, err
+	}
+	for _, f := range l.ready {
+		client.OnReady(f)
+	}
+	for id, sub := range l.subs {
+		l.unsubs[id] = client.SubscribeToEvents(sub)
+	}
+	l.client = client
+	l.ready = nil
+	l.subs = nil
+	return client, nil
+}
+
+// current returns the retrieval client if it has been created
+func (l *lazyRetrievalClient) current() retrievalmarket.RetrievalClient {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.client
+}
+
+func (l *lazyRetrievalClient) NextID() retrievalmarket.DealID {
+	c, err := l.get()
+	if err != nil {
+		return 0
+	}
+	return c.NextID()
+}
+
+// Start is a no-op: the retrieval client is started when it is created
+func (l *lazyRetrievalClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (l *lazyRetrievalClient) OnReady(f shared.ReadyFunc) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	switch {
+	case l.client != nil:
+		l.client.OnReady(f)
+	case l.err != nil:
+		go f(l.err)
+	default:
+		l.ready = append(l.ready, f)
+	}
+}
+
+func (l *lazyRetrievalClient) FindProviders(payloadCID cid.Cid) []retrievalmarket.RetrievalPeer {
+	c, err := l.get()
+	if err != nil {
+		return nil
+	}
+	return c.FindProviders(payloadCID)
+}
+
+func (l *lazyRetrievalClient) Query(ctx context.Context, p retrievalmarket.RetrievalPeer, payloadCID cid.Cid, params retrievalmarket.QueryParams) (retrievalmarket.QueryResponse, error) {
+	c, err := l.get()
+	if err != nil {
+		return retrievalmarket.QueryResponse{}, err
+	}
+	return c.Query(ctx, p, payloadCID, params)
+}
+
+func (l *lazyRetrievalClient) Retrieve(ctx context.Context, id retrievalmarket.DealID, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address) (retrievalmarket.DealID, error) {
+	c, err := l.get()
+	if err != nil {
+		return 0, err
+	}
+	return c.Retrieve(ctx, id, payloadCID, params, totalFunds, p, clientWallet, minerWallet)
+}
+
+func (l *lazyRetrievalClient) SubscribeToEvents(subscriber retrievalmarket.ClientSubscriber) retrievalmarket.Unsubscribe {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	if l.client != nil {
+		return l.client.SubscribeToEvents(subscriber)
+	}
+
+	// Queue the subscriber until the client is created
+	id := l.nextID
+	l.nextID++
+	l.subs[id] = subscriber
+	return func() {
+		l.lk.Lock()
+		unsub, ok := l.unsubs[id]
+		delete(l.unsubs, id)
+		delete(l.subs, id)
+		l.lk.Unlock()
+
+		// If the client has been created since the subscriber was queued,
+		// unsubscribe from the client
+		if ok {
+			unsub()
+		}
+	}
+}
+
+func (l *lazyRetrievalClient) TryRestartInsufficientFunds(paymentChannel address.Address) error {
+	// There can't be any deals waiting for funds if the client hasn't been
+	// created
+	c := l.current()
+	if c == nil {
+		return nil
+	}
+	return c.TryRestartInsufficientFunds(paymentChannel)
+}
+
+func (l *lazyRetrievalClient) CancelDeal(id retrievalmarket.DealID) error {
+	c, err := l.get()
+	if err != nil {
+		return err
+	}
+	return c.CancelDeal(id)
+}
+
+func (l *lazyRetrievalClient) GetDeal(dealID retrievalmarket.DealID) (retrievalmarket.ClientDealState, error) {
+	c, err := l.get()
+	if err != nil {
+		return retrievalmarket.ClientDealState{}, err
+	}
+	return c.GetDeal(dealID)
+}
+
+func (l *lazyRetrievalClient) ListDeals() (map[retrievalmarket.DealID]retrievalmarket.ClientDealState, error) {
+	c, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return c.ListDeals()
+}
@@ -0,0 +1,108 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/stretchr/testify/require"
+)
+
+// mockRetrievalClient records its event subscribers and ready funcs
+type mockRetrievalClient struct {
+	retrievalmarket.RetrievalClient
+
+	subs   map[int]retrievalmarket.ClientSubscriber
+	nextID int
+	ready  []shared.ReadyFunc
+}
+
+func newMockRetrievalClient() *mockRetrievalClient {
+	return &mockRetrievalClient{subs: make(map[int]retrievalmarket.ClientSubscriber)}
+}
+
+func (m *mockRetrievalClient) SubscribeToEvents(sub retrievalmarket.ClientSubscriber) retrievalmarket.Unsubscribe {
+	id := m.nextID
+	m.nextID++
+	m.subs[id] = sub
+	return func() {
+		delete(m.subs, id)
+	}
+}
+
+func (m *mockRetrievalClient) OnReady(f shared.ReadyFunc) {
+	m.ready = append(m.ready, f)
+}
+
+func (m *mockRetrievalClient) ListDeals() (map[retrievalmarket.DealID]retrievalmarket.ClientDealState, error) {
+	return map[retrievalmarket.DealID]retrievalmarket.ClientDealState{}, nil
+}
+
+func TestLazyRetrievalClient(t *testing.T) {
+	req := require.New(t)
+
+	mock := newMockRetrievalClient()
+	created := 0
+	lazy := newLazyRetrievalClient(func(ctx context.Context) (retrievalmarket.RetrievalClient, error) {
+		created++
+		return mock, nil
+	})
+
+	// Subscribers and ready funcs are queued until the client is created
+	sub := func(retrievalmarket.ClientEvent, retrievalmarket.ClientDealState) {}
+	unsubQueued := lazy.SubscribeToEvents(sub)
+	unsubBeforeCreate := lazy.SubscribeToEvents(sub)
+	lazy.OnReady(func(error) {})
+	unsubBeforeCreate()
+	req.NoError(lazy.Start(context.Background()))
+	req.NoError(lazy.TryRestartInsufficientFunds(address.Undef))
+	req.Equal(0, created)
+
+	// The client is created on first use, with the queued subscribers
+	_, err := lazy.ListDeals()
+	req.NoError(err)
+	req.Equal(1, created)
+	req.Len(mock.subs, 1)
+	req.Len(mock.ready, 1)
+
+	// A subscriber that was queued can unsubscribe from the created client
+	unsubQueued()
+	req.Empty(mock.subs)
+
+	// Subscribers are added to the client once it has been created, and the
+	// client is only created once
+	unsub := lazy.SubscribeToEvents(sub)
+	req.Len(mock.subs, 1)
+	unsub()
+	req.Empty(mock.subs)
+	_, err = lazy.ListDeals()
+	req.NoError(err)
+	req.Equal(1, created)
+}
+
+func TestLazyRetrievalClientError(t *testing.T) {
+	req := require.New(t)
+
+	created := 0
+	lazy := newLazyRetrievalClient(func(ctx context.Context) (retrievalmarket.RetrievalClient, error) {
+		created++
+		return nil, errors.New("failed to start")
+	})
+
+	readyErr := make(chan error, 2)
+	lazy.OnReady(func(err error) { readyErr <- err })
+
+	// The error is returned from every call, and passed to the ready funcs
+	_, err := lazy.ListDeals()
+	req.Error(err)
+	req.Error(<-readyErr)
+	_, err = lazy.GetDeal(1)
+	req.Error(err)
+	req.Equal(1, created)
+
+	lazy.OnReady(func(err error) { readyErr <- err })
+	req.Error(<-readyErr)
+}
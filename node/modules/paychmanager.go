@@ -0,0 +1,43 @@
+package modules
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/paychmanager"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"go.uber.org/fx"
+)
+
+func NewPaychManager(cfg config.PaymentChannelsConfig) func(fullnodeApi v1api.FullNode) *paychmanager.Manager {
+	return func(fullnodeApi v1api.FullNode) *paychmanager.Manager {
+		return paychmanager.New(fullnodeApi, paychmanager.ChainStateReader(fullnodeApi), paychmanager.Config{
+			CheckInterval: time.Duration(cfg.CheckInterval),
+			MaxBaseFee:    abi.TokenAmount(cfg.MaxBaseFee),
+			MaxWait:       time.Duration(cfg.MaxWait),
+		})
+	}
+}
+
+// HandlePaychManager runs the payment channel manager in the background,
+// consolidating redundant payment channels and settling and collecting
+// them while the base fee is low. The manager is only started once the node
+// first uses payment channels (when the retrieval client is created or the
+// channels are managed through the API), so nodes that only make storage
+// deals don't run it.
+func HandlePaychManager(lc fx.Lifecycle, mgr *paychmanager.Manager) {
+	mgrCtx, cancel := context.WithCancel(context.Background())
+	mgr.OnFirstUse(func() {
+		log.Info("starting payment channel manager on first use")
+		go mgr.Run(mgrCtx)
+	})
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/multiminer"
+	"github.com/filecoin-project/boost/lib/piecegc"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/sealingpipeline"
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/stores"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/build"
+	mktsdagstore "github.com/filecoin-project/lotus/markets/dagstore"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+)
+
+func NewPieceGC(cfg config.PieceGCConfig, maddr address.Address) func(fullnodeApi v1api.FullNode, sps sealingpipeline.API, pss *multiminer.PieceStores, idx paths.SectorIndex, remote *paths.Remote, dagst dagstore.Interface, dsw *mktsdagstore.Wrapper) *piecegc.Collector {
+	return func(fullnodeApi v1api.FullNode, sps sealingpipeline.API, pss *multiminer.PieceStores, idx paths.SectorIndex, remote *paths.Remote, dagst dagstore.Interface, dsw *mktsdagstore.Wrapper) *piecegc.Collector {
+		grace := abi.ChainEpoch(time.Duration(cfg.GracePeriod) / (time.Duration(build.BlockDelaySecs) * time.Second))
+		// Only the pieces of the provider's own miner are collected, as
+		// their sectors are looked up in the miner's sector index
+		return piecegc.New(piecegc.Config{
+			Miner:       maddr,
+			GracePeriod: grace,
+			Interval:    time.Duration(cfg.Interval),
+			DryRun:      cfg.DryRun,
+		}, fullnodeApi, sps, pss.Primary(), &sectorStorage{index: idx, remote: remote}, &dagstoreIndexes{dagst: dagst, wrapper: dsw})
+	}
+}
+
+// HandlePieceGC periodically removes the unsealed copies and indexes of
+// pieces that are no longer stored in any active deal
+func HandlePieceGC(lc fx.Lifecycle, gc *piecegc.Collector) {
+	var cancel context.CancelFunc
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			var gcCtx context.Context
+			gcCtx, cancel = context.WithCancel(context.Background())
+			go gc.Run(gcCtx)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+}
+
+// sectorStorage finds sector files with the sector index, and removes them
+// from the storage paths they are stored in (on the miner and workers)
+type sectorStorage struct {
+	index  paths.SectorIndex
+	remote *paths.Remote
+}
+
+func (s *sectorStorage) StorageFindSector(ctx context.Context, sector abi.SectorID, ft storiface.SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]storiface.SectorStorageInfo, error) {
+	return s.index.StorageFindSector(ctx, sector, ft, ssize, allowFetch)
+}
+
+func (s *sectorStorage) Remove(ctx context.Context, sid abi.SectorID, typ storiface.SectorFileType, force bool, keepIn []storiface.ID) error {
+	return s.remote.Remove(ctx, sid, typ, force, keepIn)
+}
+
+// dagstoreIndexes removes the indexes of pieces by destroying their
+// dagstore shards
+type dagstoreIndexes struct {
+	dagst   dagstore.Interface
+	wrapper *mktsdagstore.Wrapper
+}
+
+func (d *dagstoreIndexes) HasIndex(ctx context.Context, pieceCid cid.Cid) (bool, error) {
+	_, err := d.dagst.GetShardInfo(shard.KeyFromCID(pieceCid))
+	if errors.Is(err, dagstore.ErrShardUnknown) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *dagstoreIndexes) RemoveIndex(ctx context.Context, pieceCid cid.Cid) error {
+	return stores.DestroyShardSync(ctx, d.wrapper, pieceCid)
+}
@@ -5,17 +5,20 @@ import (
 	"errors"
 	"time"
 
+	"github.com/filecoin-project/boost/lib/dtcontrol"
+	"github.com/filecoin-project/boost/lib/faults"
 	dtimpl "github.com/filecoin-project/go-data-transfer/impl"
 	marketevents "github.com/filecoin-project/lotus/markets/loggers"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/node/repo"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	"github.com/libp2p/go-libp2p/core/host"
 	"go.uber.org/fx"
 )
 
 // NewProviderDataTransfer returns a data transfer manager
-func NewProviderDataTransfer(lc fx.Lifecycle, net dtypes.ProviderTransferNetwork, transport dtypes.ProviderTransport, ds dtypes.MetadataDS, r repo.LockedRepo) (dtypes.ProviderDataTransfer, error) {
+func NewProviderDataTransfer(lc fx.Lifecycle, net dtypes.ProviderTransferNetwork, transport dtypes.ProviderTransport, ds dtypes.MetadataDS, r repo.LockedRepo, inj *faults.Injector) (dtypes.ProviderDataTransfer, error) {
 	dtDs := namespace.Wrap(ds, datastore.NewKey("/datatransfer/provider/transfers"))
 
 	dt, err := dtimpl.NewDataTransfer(dtDs, net, transport)
@@ -44,5 +47,23 @@ func NewProviderDataTransfer(lc fx.Lifecycle, net dtypes.ProviderTransferNetwork
 			}
 		},
 	})
-	return dt, nil
+	return inj.WrapDataTransfer(dt), nil
+}
+
+// NewDataTransferControl returns the controller that pauses, resumes and
+// cancels provider data transfer channels on request of the operator
+func NewDataTransferControl(lc fx.Lifecycle, ds dtypes.MetadataDS, dt dtypes.ProviderDataTransfer, h host.Host) *dtcontrol.Controller {
+	c := dtcontrol.New(ds, dt, h.ID())
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return c.Start(ctx)
+		},
+		OnStop: func(context.Context) error {
+			c.Stop()
+			cancel()
+			return nil
+		},
+	})
+	return c
 }
@@ -2,18 +2,28 @@ package modules
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/filecoin-project/boost/cmd/booster-bitswap/bitswap"
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/lib/retrievalacl"
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/protocolproxy"
 	"github.com/filecoin-project/boost/retrievalmarket/lp2pimpl"
 	"github.com/filecoin-project/boost/retrievalmarket/types"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/fx"
+
+	lotus_dtypes "github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/node/modules/helpers"
 )
 
 func NewTransportsListener(cfg *config.Boost) func(h host.Host) (*lp2pimpl.TransportsListener, error) {
@@ -69,6 +79,100 @@ func HandleRetrievalTransports(lc fx.Lifecycle, l *lp2pimpl.TransportsListener)
 	})
 }
 
+// HandleRetrievalStats records the number of retrievals and bytes served for
+// each piece, so that the client that stored the piece can query them
+func HandleRetrievalStats(lc fx.Lifecycle, rp retrievalmarket.RetrievalProvider, sqldb *sql.DB) {
+	statsDB := db.NewRetrievalStatsDB(sqldb)
+	var unsubscribe retrievalmarket.Unsubscribe
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			unsubscribe = rp.SubscribeToEvents(func(event retrievalmarket.ProviderEvent, state retrievalmarket.ProviderDealState) {
+				if event != retrievalmarket.ProviderEventComplete {
+					return
+				}
+
+				var pieceCid cid.Cid
+				switch {
+				case state.PieceInfo != nil:
+					pieceCid = state.PieceInfo.PieceCID
+				case state.PieceCID != nil:
+					pieceCid = *state.PieceCID
+				default:
+					return
+				}
+
+				err := statsDB.Record(context.Background(), pieceCid, state.TotalSent, time.Now())
+				if err != nil {
+					log.Warnw("recording retrieval stats", "piece", pieceCid, "err", err)
+				}
+			})
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			if unsubscribe != nil {
+				unsubscribe()
+			}
+			return nil
+		},
+	})
+}
+
+// RetrievalACLConfig converts the retrieval ACL section of the boost config
+// to an ACL config
+func RetrievalACLConfig(cfg config.RetrievalACLConfig) retrievalacl.Config {
+	overrides := make([]retrievalacl.BandwidthOverride, 0, len(cfg.BandwidthOverrides))
+	for _, o := range cfg.BandwidthOverrides {
+		overrides = append(overrides, retrievalacl.BandwidthOverride{Client: o.Client, Bandwidth: o.Bandwidth})
+	}
+	return retrievalacl.Config{
+		Allow:              cfg.Allow,
+		Deny:               cfg.Deny,
+		ClientBandwidth:    cfg.ClientBandwidth,
+		BandwidthOverrides: overrides,
+	}
+}
+
+func NewRetrievalACL(cfg *config.Boost) func() (*retrievalacl.ACL, error) {
+	return func() (*retrievalacl.ACL, error) {
+		acl, err := retrievalacl.New(RetrievalACLConfig(cfg.RetrievalACL))
+		if err != nil {
+			return nil, fmt.Errorf("parsing RetrievalACL config: %w", err)
+		}
+		return acl, nil
+	}
+}
+
+// HandleRetrievalACL applies the retrieval ACL to graphsync retrievals:
+// requests from clients that are denied are rejected before any block is
+// sent, and blocks are sent to each client no faster than its bandwidth limit
+func HandleRetrievalACL(mctx helpers.MetricsCtx, lc fx.Lifecycle, h host.Host, gs lotus_dtypes.StagingGraphsync, acl *retrievalacl.ACL) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+	var unregister []graphsync.UnregisterHookFunc
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			unregister = append(unregister, gs.RegisterIncomingRequestHook(func(p peer.ID, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+				if err := acl.Check(retrievalacl.PeerClient(h, p)); err != nil {
+					log.Infow("rejecting graphsync request", "id", request.ID(), "err", err)
+					hookActions.TerminateWithError(err)
+				}
+			}))
+			unregister = append(unregister, gs.RegisterOutgoingBlockHook(func(p peer.ID, request graphsync.RequestData, block graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+				err := acl.Wait(ctx, retrievalacl.PeerClient(h, p), int(block.BlockSizeOnWire()))
+				if err != nil {
+					hookActions.TerminateWithError(err)
+				}
+			}))
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			for _, u := range unregister {
+				u()
+			}
+			return nil
+		},
+	})
+}
+
 func NewProtocolProxy(cfg *config.Boost) func(h host.Host) (*protocolproxy.ProtocolProxy, error) {
 	return func(h host.Host) (*protocolproxy.ProtocolProxy, error) {
 		peerConfig := map[peer.ID][]protocol.ID{}
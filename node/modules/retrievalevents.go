@@ -0,0 +1,137 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/retrievalevents"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"go.uber.org/fx"
+)
+
+// HandleRetrievalEvents publishes the events of the graphsync retrievals
+// that boost serves to the configured sinks
+func HandleRetrievalEvents(cfg config.RetrievalEventsConfig) func(lc fx.Lifecycle, rp retrievalmarket.RetrievalProvider) error {
+	return func(lc fx.Lifecycle, rp retrievalmarket.RetrievalProvider) error {
+		var sinks []retrievalevents.Sink
+		for _, spec := range cfg.Sinks {
+			sink, err := retrievalevents.New(spec)
+			if err != nil {
+				for _, s := range sinks {
+					_ = s.Close()
+				}
+				return fmt.Errorf("RetrievalEvents config: %w", err)
+			}
+			sinks = append(sinks, sink)
+		}
+		journal := retrievalevents.NewJournal(sinks, retrievalevents.Config{
+			BatchSize:        cfg.BatchSize,
+			FlushInterval:    time.Duration(cfg.FlushInterval),
+			ProgressInterval: time.Duration(cfg.ProgressInterval),
+		})
+
+		var unsubscribe retrievalmarket.Unsubscribe
+		var cancel context.CancelFunc
+		done := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				var journalCtx context.Context
+				journalCtx, cancel = context.WithCancel(context.Background())
+				go func() {
+					defer close(done)
+					journal.Run(journalCtx)
+				}()
+
+				events := &graphsyncRetrievalEvents{
+					journal:    journal,
+					retrievals: make(map[retrievalmarket.ProviderDealIdentifier]*retrievalevents.Retrieval),
+				}
+				unsubscribe = rp.SubscribeToEvents(events.onEvent)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				if unsubscribe != nil {
+					unsubscribe()
+				}
+				if cancel == nil {
+					return nil
+				}
+				cancel()
+				select {
+				case <-done:
+				case <-ctx.Done():
+				}
+				return nil
+			},
+		})
+		return nil
+	}
+}
+
+// graphsyncRetrievalEvents converts the events of the retrieval provider's
+// deals to retrieval events
+type graphsyncRetrievalEvents struct {
+	journal *retrievalevents.Journal
+
+	lk         sync.Mutex
+	retrievals map[retrievalmarket.ProviderDealIdentifier]*retrievalevents.Retrieval
+}
+
+func (g *graphsyncRetrievalEvents) onEvent(event retrievalmarket.ProviderEvent, state retrievalmarket.ProviderDealState) {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	id := state.Identifier()
+	if event == retrievalmarket.ProviderEventDealAccepted {
+		evt := retrievalevents.Event{
+			RetrievalID: id.String(),
+			Transport:   "graphsync",
+			Client:      state.Receiver.String(),
+			PayloadCid:  state.PayloadCID.String(),
+		}
+		switch {
+		case state.PieceInfo != nil:
+			evt.PieceCid = state.PieceInfo.PieceCID.String()
+		case state.PieceCID != nil:
+			evt.PieceCid = state.PieceCID.String()
+		}
+		g.retrievals[id] = g.journal.Start(evt)
+		return
+	}
+
+	r, ok := g.retrievals[id]
+	if !ok {
+		return
+	}
+	switch event {
+	case retrievalmarket.ProviderEventBlockSent, retrievalmarket.ProviderEventBlocksCompleted:
+		r.Served(state.TotalSent)
+	case retrievalmarket.ProviderEventComplete:
+		r.Complete(state.TotalSent)
+		delete(g.retrievals, id)
+	case retrievalmarket.ProviderEventDataTransferError,
+		retrievalmarket.ProviderEventUnsealError,
+		retrievalmarket.ProviderEventMultiStoreError,
+		retrievalmarket.ProviderEventSaveVoucherFailed:
+		r.Fail(state.TotalSent, providerDealError(event, state))
+		delete(g.retrievals, id)
+	case retrievalmarket.ProviderEventClientCancelled, retrievalmarket.ProviderEventCancelComplete:
+		r.Fail(state.TotalSent, errors.New("retrieval cancelled"))
+		delete(g.retrievals, id)
+	case retrievalmarket.ProviderEventCleanupComplete:
+		r.Fail(state.TotalSent, errors.New("retrieval ended without completing"))
+		delete(g.retrievals, id)
+	}
+}
+
+// providerDealError is the error of a retrieval deal that failed
+func providerDealError(event retrievalmarket.ProviderEvent, state retrievalmarket.ProviderDealState) error {
+	if state.Message != "" {
+		return errors.New(state.Message)
+	}
+	return errors.New(retrievalmarket.ProviderEvents[event])
+}
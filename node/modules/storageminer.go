@@ -17,16 +17,24 @@ import (
 	"github.com/filecoin-project/boost/fundmanager"
 	"github.com/filecoin-project/boost/gql"
 	"github.com/filecoin-project/boost/indexprovider"
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/boost/lib/faults"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/metrics"
 	"github.com/filecoin-project/boost/node/config"
 	"github.com/filecoin-project/boost/node/modules/dtypes"
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemanager"
 	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/boost/storagemarket/dealfilter"
 	"github.com/filecoin-project/boost/storagemarket/logs"
 	"github.com/filecoin-project/boost/storagemarket/lp2pimpl"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/tracing"
+	"github.com/filecoin-project/boost/transport/bandwidth"
 	"github.com/filecoin-project/boost/transport/httptransport"
+	"github.com/filecoin-project/boost/transport/s3transport"
+	"github.com/filecoin-project/boost/transport/tcptransport"
 	"github.com/filecoin-project/dagstore"
 	"github.com/filecoin-project/dagstore/indexbs"
 	"github.com/filecoin-project/go-address"
@@ -51,6 +59,7 @@ import (
 	"github.com/filecoin-project/lotus/storage/sectorblocks"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/host"
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/fx"
 	"go.uber.org/multierr"
 )
@@ -305,6 +314,10 @@ type LogSqlDB struct {
 	db *sql.DB
 }
 
+func (l *LogSqlDB) DB() *sql.DB {
+	return l.db
+}
+
 func NewLogsSqlDB(r repo.LockedRepo) (*LogSqlDB, error) {
 	// fixes error "database is locked", caused by concurrent access from deal goroutines to a single sqlite3 db connection
 	// see: https://github.com/mattn/go-sqlite3#:~:text=Error%3A%20database%20is%20locked
@@ -320,6 +333,10 @@ func NewDealsDB(sqldb *sql.DB) *db.DealsDB {
 	return db.NewDealsDB(sqldb)
 }
 
+func NewDealAnnotationsDB(sqldb *sql.DB) *db.DealAnnotationsDB {
+	return db.NewDealAnnotationsDB(sqldb)
+}
+
 func NewLogsDB(logsSqlDB *LogSqlDB) *db.LogsDB {
 	return db.NewLogsDB(logsSqlDB.db)
 }
@@ -338,8 +355,8 @@ func HandleLegacyDeals(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host,
 	return nil
 }
 
-func HandleBoostDeals(lc fx.Lifecycle, h host.Host, prov *storagemarket.Provider, a v1api.FullNode, legacySP lotus_storagemarket.StorageProvider, idxProv *indexprovider.Wrapper, plDB *db.ProposalLogsDB, spApi sealingpipeline.API) {
-	lp2pnet := lp2pimpl.NewDealProvider(h, prov, a, plDB, spApi)
+func HandleBoostDeals(lc fx.Lifecycle, h host.Host, prov *storagemarket.Provider, a v1api.FullNode, legacySP lotus_storagemarket.StorageProvider, idxProv *indexprovider.Wrapper, plDB *db.ProposalLogsDB, spApi sealingpipeline.API, inj *faults.Injector) {
+	lp2pnet := lp2pimpl.NewDealProvider(h, prov, a, plDB, spApi, lp2pimpl.WithFaultInjector(inj))
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -454,44 +471,144 @@ func NewLegacyStorageProvider(cfg *config.Boost) func(minerAddress lotus_dtypes.
 	}
 }
 
-func NewStorageMarketProvider(provAddr address.Address, cfg *config.Boost) func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks, commpc types.CommpCalculator, sps sealingpipeline.API, df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB, dagst *mktsdagstore.Wrapper, ps lotus_dtypes.ProviderPieceStore, ip *indexprovider.Wrapper, lp lotus_storagemarket.StorageProvider, cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
+// StorageMarketProviderConfig creates the storage market provider config
+// from the boost config
+func StorageMarketProviderConfig(cfg *config.Boost) (storagemarket.Config, error) {
+	priority, err := dealPriority(cfg.Dealmaking.PriorityRules)
+	if err != nil {
+		return storagemarket.Config{}, err
+	}
+	var published []types.PublishedFilterRule
+	if cfg.Dealmaking.PublishFilterRules {
+		for _, r := range cfg.Dealmaking.FilterRules {
+			published = append(published, types.PublishedFilterRule{Name: r.Name, Expr: r.Expr})
+		}
+	}
+	var offPeak []types.OffPeakWindow
+	for i, w := range cfg.Dealmaking.OffPeakWindows {
+		window, err := types.ParseOffPeakWindow(w.Start, w.End, w.DiscountPercent)
+		if err != nil {
+			return storagemarket.Config{}, fmt.Errorf("cfg.Dealmaking.OffPeakWindows[%d]: %w", i, err)
+		}
+		offPeak = append(offPeak, window)
+	}
+	schedule := make(types.TransferSchedule)
+	for i, w := range cfg.Dealmaking.TransferWindows {
+		window, err := types.ParseTransferWindow(w.Start, w.End)
+		if err != nil {
+			return storagemarket.Config{}, fmt.Errorf("cfg.Dealmaking.TransferWindows[%d]: %w", i, err)
+		}
+		schedule[w.TransferType] = append(schedule[w.TransferType], window)
+	}
+	return storagemarket.Config{
+		MaxTransferDuration:     time.Duration(cfg.Dealmaking.MaxTransferDuration),
+		MaxDealTransferTimeout:  time.Duration(cfg.Dealmaking.MaxDealTransferTimeout),
+		RemoteCommp:             cfg.Dealmaking.RemoteCommp,
+		MaxConcurrentLocalCommp: cfg.Dealmaking.MaxConcurrentLocalCommp,
+		LocalCommp: commp.Config{
+			Backend:  cfg.Dealmaking.LocalCommpBackend,
+			Endpoint: cfg.Dealmaking.LocalCommpBackendEndpoint,
+		},
+		TransferLimiter: storagemarket.TransferLimiterConfig{
+			MaxConcurrent:    cfg.Dealmaking.HttpTransferMaxConcurrentDownloads,
+			StallCheckPeriod: time.Duration(cfg.Dealmaking.HttpTransferStallCheckPeriod),
+			StallTimeout:     time.Duration(cfg.Dealmaking.HttpTransferStallTimeout),
+			Priority:         priority,
+			Schedule:         schedule,
+		},
+		DealLogDurationDays:   cfg.Dealmaking.DealLogDurationDays,
+		MaxReservedCapacity:   uint64(cfg.Dealmaking.MaxReservedCapacityBytes),
+		VerifiedDataCapBuffer: uint64(cfg.Dealmaking.VerifiedDealDataCapBufferBytes),
+		Region:                cfg.Dealmaking.Region,
+		OffPeakWindows:        offPeak,
+		PublishedFilterRules:  published,
+		DealRateLimits: types.DealRateLimits{
+			PerClient: types.DealRateLimit{
+				DealsPerHour: cfg.Dealmaking.DealRateLimits.ClientDealsPerHour,
+				BytesPerDay:  cfg.Dealmaking.DealRateLimits.ClientBytesPerDay,
+			},
+			PerPeer: types.DealRateLimit{
+				DealsPerHour: cfg.Dealmaking.DealRateLimits.PeerDealsPerHour,
+				BytesPerDay:  cfg.Dealmaking.DealRateLimits.PeerBytesPerDay,
+			},
+		},
+	}, nil
+}
+
+// dealPriority compiles the rules that rank the deals waiting for their
+// transfer to start. It returns nil if there are no rules.
+func dealPriority(rules []config.DealPriorityRule) (func(*types.ProviderDealState) int64, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	prules := make([]dealfilter.PriorityRule, 0, len(rules))
+	for _, r := range rules {
+		prules = append(prules, dealfilter.PriorityRule{Rule: dealfilter.Rule{Name: r.Name, Expr: r.Expr}, Weight: r.Weight})
+	}
+	compiled, err := dealfilter.CompilePriorityRules(prules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cfg.Dealmaking.PriorityRules: %w", err)
+	}
+	return compiled.DealPriority, nil
+}
+
+func NewStorageMarketProvider(provAddr address.Address, cfg *config.Boost) func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks, commpc types.CommpCalculator, sps sealingpipeline.API, df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB, dagst *mktsdagstore.Wrapper, ps lotus_dtypes.ProviderPieceStore, ip *indexprovider.Wrapper, lp lotus_storagemarket.StorageProvider, cdm *storagemarket.ChainDealManager, ff *features.Flags, bw *bandwidth.Manager) (*storagemarket.Provider, error) {
 	return func(lc fx.Lifecycle, h host.Host, a v1api.FullNode, sqldb *sql.DB, dealsDB *db.DealsDB,
 		fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, dp *storageadapter.DealPublisher, secb *sectorblocks.SectorBlocks,
 		commpc types.CommpCalculator, sps sealingpipeline.API,
 		df dtypes.StorageDealFilter, logsSqlDB *LogSqlDB, logsDB *db.LogsDB,
 		dagst *mktsdagstore.Wrapper, ps lotus_dtypes.ProviderPieceStore, ip *indexprovider.Wrapper,
-		lp lotus_storagemarket.StorageProvider, cdm *storagemarket.ChainDealManager) (*storagemarket.Provider, error) {
-
-		prvCfg := storagemarket.Config{
-			MaxTransferDuration:     time.Duration(cfg.Dealmaking.MaxTransferDuration),
-			RemoteCommp:             cfg.Dealmaking.RemoteCommp,
-			MaxConcurrentLocalCommp: cfg.Dealmaking.MaxConcurrentLocalCommp,
-			TransferLimiter: storagemarket.TransferLimiterConfig{
-				MaxConcurrent:    cfg.Dealmaking.HttpTransferMaxConcurrentDownloads,
-				StallCheckPeriod: time.Duration(cfg.Dealmaking.HttpTransferStallCheckPeriod),
-				StallTimeout:     time.Duration(cfg.Dealmaking.HttpTransferStallTimeout),
-			},
-			DealLogDurationDays: cfg.Dealmaking.DealLogDurationDays,
+		lp lotus_storagemarket.StorageProvider, cdm *storagemarket.ChainDealManager, ff *features.Flags, bw *bandwidth.Manager) (*storagemarket.Provider, error) {
+
+		prvCfg, err := StorageMarketProviderConfig(cfg)
+		if err != nil {
+			return nil, err
 		}
 		dl := logs.NewDealLogger(logsDB)
-		tspt := httptransport.New(h, dl)
+		tspt := httptransport.New(h, dl,
+			httptransport.StallTimeoutOpt(time.Duration(cfg.Dealmaking.HttpTransferReadStallTimeout)),
+			httptransport.KeepaliveOpt(time.Duration(cfg.Dealmaking.HttpTransferKeepaliveInterval),
+				time.Duration(cfg.Dealmaking.HttpTransferKeepaliveTimeout), 2),
+			httptransport.CompressionOpt(uint(cfg.Dealmaking.HttpTransferMaxCompressedTransfers)),
+			httptransport.FeaturesOpt(ff),
+			httptransport.BandwidthOpt(bw))
 		prov, err := storagemarket.NewProvider(prvCfg, sqldb, dealsDB, fundMgr, storageMgr, a, dp, provAddr, secb, commpc,
 			sps, cdm, df, logsSqlDB.db, logsDB, dagst, ps, ip, lp, &signatureVerifier{a}, dl, tspt)
 		if err != nil {
 			return nil, err
 		}
+		prov.SetFeatures(ff)
+		if cfg.Dealmaking.EnableTcpTransfers {
+			prov.RegisterTransport("tcp", tcptransport.New(dl,
+				tcptransport.StallTimeoutOpt(time.Duration(cfg.Dealmaking.TcpTransferReadStallTimeout))))
+		}
+		if s3cfg := cfg.Dealmaking.S3Transfers; s3cfg.Enable {
+			var creds *s3transport.Credentials
+			if s3cfg.AccessKeyID != "" {
+				creds = &s3transport.Credentials{AccessKeyID: s3cfg.AccessKeyID, SecretAccessKey: s3cfg.SecretAccessKey}
+			}
+			prov.RegisterTransport("s3", s3transport.New(dl, s3transport.Config{
+				Endpoint:    s3cfg.Endpoint,
+				Region:      s3cfg.Region,
+				Credentials: creds,
+				Buckets:     s3cfg.Buckets,
+				PartSize:    int64(s3cfg.PartSize),
+				Concurrency: int(s3cfg.Concurrency),
+				MaxAttempts: int(s3cfg.MaxAttempts),
+			}))
+		}
 
 		return prov, nil
 	}
 }
 
-func NewGraphqlServer(cfg *config.Boost) func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, publisher *storageadapter.DealPublisher, spApi sealingpipeline.API, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, ps lotus_dtypes.ProviderPieceStore, sa retrievalmarket.SectorAccessor, dagst dagstore.Interface, fullNode v1api.FullNode) *gql.Server {
-	return func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager,
+func NewGraphqlServer(cfg *config.Boost) func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, annotationsDB *db.DealAnnotationsDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager, storageMgr *storagemanager.StorageManager, publisher *storageadapter.DealPublisher, spApi sealingpipeline.API, legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer, ps lotus_dtypes.ProviderPieceStore, sa retrievalmarket.SectorAccessor, dagst dagstore.Interface, fullNode v1api.FullNode, bw *bandwidth.Manager) *gql.Server {
+	return func(lc fx.Lifecycle, r repo.LockedRepo, h host.Host, prov *storagemarket.Provider, dealsDB *db.DealsDB, logsDB *db.LogsDB, annotationsDB *db.DealAnnotationsDB, plDB *db.ProposalLogsDB, fundsDB *db.FundsDB, fundMgr *fundmanager.FundManager,
 		storageMgr *storagemanager.StorageManager, publisher *storageadapter.DealPublisher, spApi sealingpipeline.API,
 		legacyProv lotus_storagemarket.StorageProvider, legacyDT lotus_dtypes.ProviderDataTransfer,
-		ps lotus_dtypes.ProviderPieceStore, sa retrievalmarket.SectorAccessor, dagst dagstore.Interface, fullNode v1api.FullNode) *gql.Server {
+		ps lotus_dtypes.ProviderPieceStore, sa retrievalmarket.SectorAccessor, dagst dagstore.Interface, fullNode v1api.FullNode, bw *bandwidth.Manager) *gql.Server {
 
-		resolver := gql.NewResolver(cfg, r, h, dealsDB, logsDB, plDB, fundsDB, fundMgr, storageMgr, spApi, prov, legacyProv, legacyDT, ps, sa, dagst, publisher, fullNode)
+		resolver := gql.NewResolver(cfg, r, h, dealsDB, logsDB, annotationsDB, plDB, fundsDB, fundMgr, storageMgr, spApi, prov, legacyProv, legacyDT, ps, sa, dagst, publisher, fullNode, bw)
 		server := gql.NewServer(resolver)
 
 		lc.Append(fx.Hook{
@@ -538,3 +655,33 @@ func NewTracing(cfg *config.Boost) func(lc fx.Lifecycle) (*tracing.Tracing, erro
 		return &tracing.Tracing{}, nil
 	}
 }
+
+func NewMetricsPusher(cfg *config.Boost) func(lc fx.Lifecycle) (*metrics.Pusher, error) {
+	return func(lc fx.Lifecycle) (*metrics.Pusher, error) {
+		pcfg := cfg.MetricsPush
+		if pcfg.PushgatewayURL == "" && pcfg.StatsdAddress == "" {
+			return nil, nil
+		}
+
+		p, err := metrics.NewPusher(metrics.PushConfig{
+			PushgatewayURL: pcfg.PushgatewayURL,
+			Job:            pcfg.PushgatewayJob,
+			Instance:       pcfg.PushgatewayInstance,
+			StatsdAddress:  pcfg.StatsdAddress,
+			StatsdPrefix:   pcfg.StatsdPrefix,
+			DogStatsd:      pcfg.DogStatsd,
+			Interval:       time.Duration(pcfg.Interval),
+		}, promclient.DefaultGatherer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up metrics push: %w", err)
+		}
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				p.Start()
+				return nil
+			},
+			OnStop: p.Stop,
+		})
+		return p, nil
+	}
+}
@@ -0,0 +1,193 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/multiminer"
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/storagemarket"
+	"github.com/filecoin-project/go-address"
+	lotus_storagemarket "github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api/v1api"
+	ctypes "github.com/filecoin-project/lotus/chain/types"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	mktsdagstore "github.com/filecoin-project/lotus/markets/dagstore"
+	"github.com/filecoin-project/lotus/markets/sectoraccessor"
+	"github.com/filecoin-project/lotus/markets/storageadapter"
+	lotus_config "github.com/filecoin-project/lotus/node/config"
+	lotus_modules "github.com/filecoin-project/lotus/node/modules"
+	lotus_dtypes "github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/node/modules/helpers"
+	lotus_repo "github.com/filecoin-project/lotus/node/repo"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"go.uber.org/fx"
+)
+
+// NewPieceStores creates the piece store of the provider's own miner. The
+// piece stores of the additional miners are added by HandleAdditionalMiners,
+// so that retrievals are served for the deals of all the miners.
+func NewPieceStores(lc fx.Lifecycle, ds lotus_dtypes.MetadataDS) (*multiminer.PieceStores, error) {
+	ps, err := lotus_modules.NewProviderPieceStore(lc, ds)
+	if err != nil {
+		return nil, err
+	}
+	return multiminer.NewPieceStores(ps), nil
+}
+
+// NewMinerAPIs creates the dagstore mount API of the provider's own miner.
+// The mount APIs of the additional miners are added by HandleAdditionalMiners,
+// so that the dagstore can read the pieces of all the miners.
+func NewMinerAPIs(cfg lotus_config.DAGStoreConfig) func(lc fx.Lifecycle, r lotus_repo.LockedRepo, pss *multiminer.PieceStores, sa mktsdagstore.SectorAccessor) (*multiminer.MinerAPIs, error) {
+	return func(lc fx.Lifecycle, r lotus_repo.LockedRepo, pss *multiminer.PieceStores, sa mktsdagstore.SectorAccessor) (*multiminer.MinerAPIs, error) {
+		mountApi, err := lotus_modules.NewMinerAPI(cfg)(lc, r, pss.Primary(), sa)
+		if err != nil {
+			return nil, err
+		}
+		return multiminer.NewMinerAPIs(mountApi), nil
+	}
+}
+
+// HandleAdditionalMiners adds the miners in cfg.Miners to the storage market
+// provider. For each miner it connects to the miner's sealing service, sets
+// up the miner's ask and deal filter from the config, and creates the miner's
+// deal publisher, piece store and dagstore mount API.
+func HandleAdditionalMiners(cfg *config.Boost) func(mctx helpers.MetricsCtx, lc fx.Lifecycle, prov *storagemarket.Provider, ds lotus_dtypes.MetadataDS,
+	onlineOk dtypes.ConsiderOnlineStorageDealsConfigFunc, offlineOk dtypes.ConsiderOfflineStorageDealsConfigFunc,
+	verifiedOk dtypes.ConsiderVerifiedStorageDealsConfigFunc, unverifiedOk dtypes.ConsiderUnverifiedStorageDealsConfigFunc,
+	blocklistFunc dtypes.StorageDealPieceCidBlocklistConfigFunc, expectedSealTimeFunc dtypes.GetExpectedSealDurationFunc,
+	startDelay dtypes.GetMaxDealStartDelayFunc, r lotus_repo.LockedRepo, full v1api.FullNode, as *ctladdr.AddressSelector,
+	lstor *paths.Local, sc sealer.Config, pss *multiminer.PieceStores, mountApis *multiminer.MinerAPIs) error {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, prov *storagemarket.Provider, ds lotus_dtypes.MetadataDS,
+		onlineOk dtypes.ConsiderOnlineStorageDealsConfigFunc, offlineOk dtypes.ConsiderOfflineStorageDealsConfigFunc,
+		verifiedOk dtypes.ConsiderVerifiedStorageDealsConfigFunc, unverifiedOk dtypes.ConsiderUnverifiedStorageDealsConfigFunc,
+		blocklistFunc dtypes.StorageDealPieceCidBlocklistConfigFunc, expectedSealTimeFunc dtypes.GetExpectedSealDurationFunc,
+		startDelay dtypes.GetMaxDealStartDelayFunc, r lotus_repo.LockedRepo, full v1api.FullNode, as *ctladdr.AddressSelector,
+		lstor *paths.Local, sc sealer.Config, pss *multiminer.PieceStores, mountApis *multiminer.MinerAPIs) error {
+
+		legacyFees := cfg.LotusFees.Legacy()
+		publishCfg := storageadapter.PublishMsgConfig{
+			Period:                  time.Duration(cfg.LotusDealmaking.PublishMsgPeriod),
+			MaxDealsPerMsg:          cfg.LotusDealmaking.MaxDealsPerPublishMsg,
+			StartEpochSealingBuffer: cfg.LotusDealmaking.StartEpochSealingBuffer,
+		}
+
+		for _, mcfg := range cfg.Miners {
+			maddr, err := address.NewFromString(mcfg.Address)
+			if err != nil {
+				return fmt.Errorf("failed to parse miner address %s: %w", mcfg.Address, err)
+			}
+
+			userFilter, err := UserStorageDealFilter(mcfg.Filter, mcfg.FilterWebhook, mcfg.FilterRules)
+			if err != nil {
+				return fmt.Errorf("failed to configure deal filter of miner %s: %w", maddr, err)
+			}
+			df := BasicDealFilter(cfg.Dealmaking, userFilter)(onlineOk, offlineOk, verifiedOk, unverifiedOk,
+				blocklistFunc, expectedSealTimeFunc, startDelay, r)
+
+			mapi, err := connectMinerService(mcfg.SealerApiInfo)(mctx, lc)
+			if err != nil {
+				return fmt.Errorf("connecting to sealing service of miner %s: %w", maddr, err)
+			}
+			lc.Append(fx.Hook{
+				OnStart: func(ctx context.Context) error {
+					actor, err := mapi.ActorAddress(ctx)
+					if err != nil {
+						return fmt.Errorf("getting address of miner %s from sealing service: %w", maddr, err)
+					}
+					if actor != maddr {
+						return fmt.Errorf("the sealing service configured for miner %s is for miner %s", maddr, actor)
+					}
+					return nil
+				},
+			})
+
+			// The sector blocks of each miner are kept under their own
+			// namespace, as sector numbers are only unique per miner
+			mds := namespace.Wrap(ds, datastore.NewKey("/miners/"+maddr.String()))
+			secb := sectorblocks.NewSectorBlocks(mapi, mds)
+
+			// A publish deals message can only hold the deals of a single
+			// miner, so each miner has its own deal publisher
+			dp := storageadapter.NewDealPublisher(&legacyFees, publishCfg)(lc, full, as)
+
+			ps, err := lotus_modules.NewProviderPieceStore(lc, mds)
+			if err != nil {
+				return fmt.Errorf("creating piece store of miner %s: %w", maddr, err)
+			}
+
+			// Pieces are read from the miner's sectors (unsealing them if
+			// necessary) through the miner's sealing service
+			remote := paths.NewRemote(lstor, mapi, cliutil.ParseApiInfo(mcfg.SealerApiInfo).AuthHeader(),
+				sc.ParallelFetchLimit, &paths.DefaultPartialFileHandler{})
+			pp := sealer.NewPieceProvider(remote, mapi, mapi)
+			sa := sectoraccessor.NewSectorAccessor(lotus_dtypes.MinerAddress(maddr), secb, pp, full)
+			mountApi, err := lotus_modules.NewMinerAPI(cfg.DAGStore)(lc, r, ps, sa)
+			if err != nil {
+				return fmt.Errorf("creating dagstore mount api of miner %s: %w", maddr, err)
+			}
+
+			err = prov.AddMiner(&storagemarket.Miner{
+				Address:         maddr,
+				PieceAdder:      secb,
+				CommpCalc:       mapi,
+				SealingPipeline: mapi,
+				DealFilter:      df,
+				AskGetter:       newMinerAsk(maddr, mcfg),
+				DealPublisher:   dp,
+				PieceStore:      ps,
+			})
+			if err != nil {
+				return err
+			}
+			pss.Add(ps)
+			mountApis.Add(mountApi)
+			log.Infow("added miner", "miner", maddr)
+		}
+		return nil
+	}
+}
+
+// minerAsk is the ask of an additional miner, which is set in the config
+type minerAsk struct {
+	ask *lotus_storagemarket.SignedStorageAsk
+}
+
+func newMinerAsk(maddr address.Address, mcfg config.MinerConfig) *minerAsk {
+	maxPieceSize := abi.PaddedPieceSize(mcfg.MaxPieceSize)
+	if maxPieceSize == 0 {
+		maxPieceSize = math.MaxUint64
+	}
+	return &minerAsk{ask: &lotus_storagemarket.SignedStorageAsk{
+		Ask: &lotus_storagemarket.StorageAsk{
+			Price:         filOrZero(mcfg.Price),
+			VerifiedPrice: filOrZero(mcfg.VerifiedPrice),
+			MinPieceSize:  abi.PaddedPieceSize(mcfg.MinPieceSize),
+			MaxPieceSize:  maxPieceSize,
+			Miner:         maddr,
+		},
+	}}
+}
+
+// filOrZero returns the amount, or zero if the amount is not set in the
+// config
+func filOrZero(f ctypes.FIL) abi.TokenAmount {
+	if f.Int == nil {
+		return big.Zero()
+	}
+	return abi.TokenAmount(f)
+}
+
+func (a *minerAsk) GetAsk() *lotus_storagemarket.SignedStorageAsk {
+	return a.ask
+}
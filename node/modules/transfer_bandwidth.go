@@ -0,0 +1,117 @@
+package modules
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/node/config"
+	"github.com/filecoin-project/boost/transport/bandwidth"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-graphsync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/fx"
+
+	lotus_dtypes "github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/node/modules/helpers"
+)
+
+// A graphsync transfer that hasn't received a block for this long is
+// assumed to have ended without a final response (eg because it was
+// cancelled), and is released
+const graphsyncTransferIdleTimeout = 10 * time.Minute
+
+func NewTransferBandwidthManager(cfg *config.Boost) func() *bandwidth.Manager {
+	return func() *bandwidth.Manager {
+		return bandwidth.New(cfg.Dealmaking.TransferBandwidthLimit)
+	}
+}
+
+type graphsyncTransfer struct {
+	xfer      *bandwidth.Transfer
+	lastBlock time.Time
+}
+
+// HandleGraphsyncTransferBandwidth limits the rate at which blocks of
+// legacy deal data are received over graphsync to each transfer's share of
+// the transfer bandwidth. Each graphsync request is a transfer.
+func HandleGraphsyncTransferBandwidth(mctx helpers.MetricsCtx, lc fx.Lifecycle, gs lotus_dtypes.StagingGraphsync, bw *bandwidth.Manager) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+
+	var lk sync.Mutex
+	xfers := make(map[graphsync.RequestID]*graphsyncTransfer)
+	release := func(id graphsync.RequestID) {
+		lk.Lock()
+		defer lk.Unlock()
+		if gt, ok := xfers[id]; ok {
+			gt.xfer.Close()
+			delete(xfers, id)
+		}
+	}
+
+	var unregister []graphsync.UnregisterHookFunc
+	stopSweep := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			unregister = append(unregister, gs.RegisterIncomingBlockHook(func(p peer.ID, response graphsync.ResponseData, block graphsync.BlockData, hookActions graphsync.IncomingBlockHookActions) {
+				// Blocks that were already in the local blockstore are not
+				// received over the wire
+				size := block.BlockSizeOnWire()
+				if size == 0 {
+					return
+				}
+
+				id := response.RequestID()
+				lk.Lock()
+				gt, ok := xfers[id]
+				if !ok {
+					xferID, err := uuid.Parse(id.String())
+					if err != nil {
+						xferID = uuid.New()
+					}
+					gt = &graphsyncTransfer{xfer: bw.Start(xferID, "graphsync", p.String())}
+					xfers[id] = gt
+				}
+				gt.lastBlock = time.Now()
+				lk.Unlock()
+
+				if err := gt.xfer.Wait(ctx, int(size)); err != nil {
+					hookActions.TerminateWithError(err)
+				}
+			}))
+			unregister = append(unregister, gs.RegisterIncomingResponseHook(func(p peer.ID, response graphsync.ResponseData, hookActions graphsync.IncomingResponseHookActions) {
+				if response.Status().IsTerminal() {
+					release(response.RequestID())
+				}
+			}))
+
+			go func() {
+				ticker := time.NewTicker(time.Minute)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						lk.Lock()
+						for id, gt := range xfers {
+							if time.Since(gt.lastBlock) > graphsyncTransferIdleTimeout {
+								gt.xfer.Close()
+								delete(xfers, id)
+							}
+						}
+						lk.Unlock()
+					case <-stopSweep:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(stopSweep)
+			for _, u := range unregister {
+				u()
+			}
+			return nil
+		},
+	})
+}
@@ -43,7 +43,7 @@ export function DealDetail(props) {
 
     function allToClipboard() {
         const detailTableEl = document.body.querySelector('.deal-detail .deal-fields')
-        const allDataAsText = getAllDataAsText(detailTableEl, deal.ID, logs)
+        const allDataAsText = getAllDataAsText(detailTableEl, deal.ID, logs, annotations)
         navigator.clipboard.writeText(allDataAsText)
         const el = document.body.querySelector('.content .title .copy-all')
         addClassFor(el, 'copied', 500)
@@ -94,6 +94,8 @@ export function DealDetail(props) {
         console.error("parsing transfer params: "+e.message)
     }
 
+    var annotations = deal.Annotations || []
+
     var logRowData = []
     var logs = (deal.Logs || []).sort((a, b) => a.CreatedAt.getTime() - b.CreatedAt.getTime())
     for (var i = 0; i < logs.length; i++) {
@@ -309,6 +311,18 @@ export function DealDetail(props) {
 
             <DealActions deal={deal} />
 
+            {annotations.length ? (
+                <>
+                    <h3>Annotations</h3>
+
+                    <table className="deal-annotations">
+                        <tbody>
+                        {annotations.map(a => <DealAnnotation key={a.Key} annotation={a} />)}
+                        </tbody>
+                    </table>
+                </>
+            ) : null}
+
             <h3>Deal Logs</h3>
 
             <table className="deal-logs">
@@ -429,6 +443,19 @@ function DealLog(props) {
     </tr>
 }
 
+function DealAnnotation(props) {
+    const a = props.annotation
+    var value = a.Value
+    if (a.Key === 'ticket') {
+        value = <a href={a.Value} target="_blank" rel="noreferrer">{a.Value}</a>
+    }
+    return <tr>
+        <th>{a.Key}</th>
+        <td>{value}</td>
+        <td className="at">{moment(a.UpdatedAt).format(dateFormat)}</td>
+    </tr>
+}
+
 function LogParam(props) {
     const [expanded, setExpanded] = useState(false)
 
@@ -459,7 +486,7 @@ function LogParam(props) {
     )
 }
 
-function getAllDataAsText(detailTableEl, dealID, logs) {
+function getAllDataAsText(detailTableEl, dealID, logs, annotations) {
     var lines = []
     lines.push('=== Deal ' + dealID + ' ===')
     lines.push('')
@@ -469,6 +496,14 @@ function getAllDataAsText(detailTableEl, dealID, logs) {
         lines.push(fieldName + ': ' + fieldValue)
     }
 
+    if (annotations.length) {
+        lines.push('')
+        lines.push('=== Annotations ===')
+        for (var a of annotations) {
+            lines.push(a.Key + ': ' + a.Value + ' (' + moment(a.UpdatedAt).format(dateFormat) + ')')
+        }
+    }
+
     lines.push('')
     lines.push('=== Logs ===')
     for (var log of logs) {
@@ -1,13 +1,13 @@
 /* global BigInt */
-import {useQuery} from "@apollo/react-hooks";
+import {useQuery, useSubscription} from "@apollo/react-hooks";
 import {
     DealsCountQuery,
-    DealsListQuery, LegacyDealsCountQuery,
+    DealsListQuery, DealStateChangesSubscription, LegacyDealsCountQuery,
 } from "./gql";
 import moment from "moment";
 import {DebounceInput} from 'react-debounce-input';
 import {humanFileSize} from "./util";
-import React, {useState} from "react";
+import React, {useEffect, useRef, useState} from "react";
 import {PageContainer, ShortClientAddress, ShortDealLink} from "./Components";
 import {Link, useNavigate, useParams} from "react-router-dom";
 import {dateFormat} from "./util-date";
@@ -23,6 +23,10 @@ import {humanTransferRate} from "./DealTransfers";
 
 const dealsBasePath = '/storage-deals'
 
+// The minimum time between refetches of the deal list when deals change
+// checkpoint, so that a burst of changes only refetches the list once
+const dealsRefetchThrottleMs = 2000
+
 export function StorageDealsPage(props) {
     return <PageContainer pageType="storage-deals" title="Storage Deals">
         <StorageDealsContent />
@@ -66,8 +70,10 @@ function StorageDealsContent(props) {
     // Fetch deals on this page
     const dealListOffset = (pageNum-1) * dealsPerPage
     const queryCursor = (pageNum === 1) ? null : params.cursor
-    const {loading, error, data} = useQuery(DealsListQuery, {
-        pollInterval: searchQuery ? undefined : 1000,
+    const {loading, error, data, refetch} = useQuery(DealsListQuery, {
+        // Deal state changes are pushed by the subscription below, so only
+        // poll occasionally in case the subscription connection drops
+        pollInterval: searchQuery ? undefined : 10000,
         variables: {
             query: searchQuery,
             cursor: queryCursor,
@@ -77,6 +83,24 @@ function StorageDealsContent(props) {
         fetchPolicy: 'network-only',
     })
 
+    // Refresh the list when a deal moves to a new checkpoint (transfer
+    // progress and sealing state changes don't change the list)
+    const refetchTimer = useRef(null)
+    useEffect(() => () => clearTimeout(refetchTimer.current), [])
+    useSubscription(DealStateChangesSubscription, {
+        skip: !!searchQuery,
+        variables: {kinds: ['Checkpoint']},
+        onSubscriptionData: () => {
+            if (refetchTimer.current) {
+                return
+            }
+            refetchTimer.current = setTimeout(() => {
+                refetchTimer.current = null
+                refetch()
+            }, dealsRefetchThrottleMs)
+        },
+    })
+
     if (error) return <div>Error: {error.message + " - check connection to Boost server"}</div>
     if (loading) return <div>Loading...</div>
 
@@ -186,6 +186,11 @@ const DealSubscription = gql`
                 LogParams
                 Subsystem
             }
+            Annotations {
+                Key
+                Value
+                UpdatedAt
+            }
         }
     }
 `;
@@ -235,6 +240,24 @@ const DealFailPausedMutation = gql`
     }
 `;
 
+const DealStateChangesSubscription = gql`
+    subscription AppDealStateChangesSubscription($kinds: [String!]) {
+        dealStateChanges(kinds: $kinds) {
+            Kind
+            DealID
+            Checkpoint
+            PreviousCheckpoint
+            Transferred
+            TransferSize
+            TransferPercent
+            SealingState
+            PreviousSealingState
+            Message
+            At
+        }
+    }
+`;
+
 const NewDealsSubscription = gql`
     subscription AppNewDealsSubscription {
         dealNew {
@@ -272,6 +295,11 @@ const NewDealsSubscription = gql`
                     LogParams
                     Subsystem
                 }
+                Annotations {
+                    Key
+                    Value
+                    UpdatedAt
+                }
             }
             totalCount
         }
@@ -584,6 +612,7 @@ export {
     DealRetryPausedMutation,
     DealFailPausedMutation,
     NewDealsSubscription,
+    DealStateChangesSubscription,
     ProposalLogsListQuery,
     ProposalLogsCountQuery,
     PiecesWithPayloadCidQuery,
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/go-address"
 	lotus_repo "github.com/filecoin-project/lotus/node/repo"
 	"github.com/google/uuid"
 	logging "github.com/ipfs/go-log/v2"
@@ -24,6 +25,9 @@ var (
 type Config struct {
 	MaxStagingDealsBytes          uint64
 	MaxStagingDealsPercentPerHost uint64
+	// The maximum number of bytes of the staging area that the deals of
+	// each miner may use, for the miners that have a limit
+	MinerMaxStagingDealsBytes map[address.Address]uint64
 }
 
 type StorageManager struct {
@@ -68,11 +72,26 @@ func (m *StorageManager) Free(ctx context.Context) (uint64, error) {
 // ErrNoSpaceLeft indicates that there is insufficient storage to accept a deal
 var ErrNoSpaceLeft = errors.New("no space left")
 
-// Tags storage space for the deal.
+// Tags storage space for the deal with the given miner.
 // If there is not enough space left, returns ErrNoSpaceLeft.
-func (m *StorageManager) Tag(ctx context.Context, dealUuid uuid.UUID, size uint64, host string) error {
+func (m *StorageManager) Tag(ctx context.Context, dealUuid uuid.UUID, miner address.Address, size uint64, host string) error {
 	// Get the total tagged storage, so that we know how much is available.
-	log.Debugw("tagging", "id", dealUuid, "size", size, "host", host, "maxbytes", m.cfg.MaxStagingDealsBytes)
+	log.Debugw("tagging", "id", dealUuid, "miner", miner, "size", size, "host", host, "maxbytes", m.cfg.MaxStagingDealsBytes)
+
+	if minerMax := m.cfg.MinerMaxStagingDealsBytes[miner]; minerMax != 0 {
+		// Get the total amount tagged for the miner's deals
+		tagged, err := m.db.TotalTaggedForProvider(ctx, miner)
+		if err != nil {
+			return fmt.Errorf("getting total tagged for miner from DB: %w", err)
+		}
+
+		// Check the amount tagged + the size of the proposed deal against the miner's limit
+		if tagged+size >= minerMax {
+			return fmt.Errorf("%w: cannot accept piece of size %d for miner %s, on top of already allocated %d bytes, "+
+				"because it would exceed the miner's max staging area size %d",
+				ErrNoSpaceLeft, size, miner, tagged, minerMax)
+		}
+	}
 
 	if m.cfg.MaxStagingDealsBytes != 0 {
 		if m.cfg.MaxStagingDealsPercentPerHost != 0 {
@@ -106,7 +125,7 @@ func (m *StorageManager) Tag(ctx context.Context, dealUuid uuid.UUID, size uint6
 		}
 	}
 
-	err := m.persistTagged(ctx, dealUuid, size, host)
+	err := m.persistTagged(ctx, dealUuid, miner, size, host)
 	if err != nil {
 		return fmt.Errorf("saving total tagged storage: %w", err)
 	}
@@ -151,8 +170,8 @@ func (m *StorageManager) TotalTaggedForHost(ctx context.Context, host string) (u
 	return total, nil
 }
 
-func (m *StorageManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, size uint64, host string) error {
-	err := m.db.Tag(ctx, dealUuid, size, host)
+func (m *StorageManager) persistTagged(ctx context.Context, dealUuid uuid.UUID, miner address.Address, size uint64, host string) error {
+	err := m.db.Tag(ctx, dealUuid, miner, size, host)
 	if err != nil {
 		return fmt.Errorf("persisting tagged storage for deal to DB: %w", err)
 	}
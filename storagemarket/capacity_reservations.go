@@ -0,0 +1,172 @@
+package storagemarket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/google/uuid"
+)
+
+var ErrCapacityReservationNotFound = fmt.Errorf("capacity reservation not found")
+
+// ReserveCapacity reserves capacity for the client for the reservation
+// window. The caller is responsible for verifying that the request was
+// signed by the client.
+func (p *Provider) ReserveCapacity(ctx context.Context, res types.CapacityReservation) (*types.CapacityReservationResponse, error) {
+	reject := func(format string, args ...interface{}) (*types.CapacityReservationResponse, error) {
+		msg := fmt.Sprintf(format, args...)
+		log.Infow("rejected capacity reservation", "id", res.ID, "client", res.Client, "reason", msg)
+		return &types.CapacityReservationResponse{Message: msg}, nil
+	}
+
+	maxReserved := p.getConfig().MaxReservedCapacity
+	if maxReserved == 0 {
+		return reject("provider does not accept capacity reservations")
+	}
+	if !p.hasMiner(res.Provider) {
+		return reject("incorrect provider address %s (expected one of %s)", res.Provider, p.Miners())
+	}
+	if res.Size == 0 {
+		return reject("reservation size must be greater than zero")
+	}
+	if res.EndEpoch <= res.StartEpoch {
+		return reject("reservation end epoch %d must be after start epoch %d", res.EndEpoch, res.StartEpoch)
+	}
+	if res.Deposit.Int == nil {
+		res.Deposit = big.Zero()
+	}
+	if res.Deposit.LessThan(big.Zero()) {
+		return reject("reservation deposit must not be negative")
+	}
+
+	head, err := p.fullnodeApi.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	if res.EndEpoch <= head.Height() {
+		return reject("reservation end epoch %d has already passed (current epoch %d)", res.EndEpoch, head.Height())
+	}
+
+	// Check that the client has enough funds in escrow to cover the deposit
+	if !res.Deposit.IsZero() {
+		bal, err := p.fullnodeApi.StateMarketBalance(ctx, res.Client, chaintypes.EmptyTSK)
+		if err != nil {
+			return nil, fmt.Errorf("getting market balance for client %s: %w", res.Client, err)
+		}
+		available := big.Sub(bal.Escrow, bal.Locked)
+		if available.LessThan(res.Deposit) {
+			return reject("client market escrow available balance %s is less than deposit %s",
+				chaintypes.FIL(available), chaintypes.FIL(res.Deposit))
+		}
+	}
+
+	p.reservLk.Lock()
+	defer p.reservLk.Unlock()
+
+	if _, err := p.reservDB.ByID(ctx, res.ID); err == nil {
+		return reject("reservation with id %s already exists", res.ID)
+	} else if !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("getting capacity reservation %s: %w", res.ID, err)
+	}
+
+	unfilled, err := p.reservDB.Unfilled(ctx, head.Height())
+	if err != nil {
+		return nil, err
+	}
+	if unfilled+res.Size > maxReserved {
+		var available uint64
+		if unfilled < maxReserved {
+			available = maxReserved - unfilled
+		}
+		return reject("provider has insufficient capacity: requested %s but only %s is available",
+			humanize.IBytes(res.Size), humanize.IBytes(available))
+	}
+
+	if err := p.reservDB.Insert(ctx, &res); err != nil {
+		return nil, fmt.Errorf("saving capacity reservation %s: %w", res.ID, err)
+	}
+
+	log.Infow("accepted capacity reservation", "id", res.ID, "client", res.Client, "size", res.Size,
+		"start", res.StartEpoch, "end", res.EndEpoch, "deposit", res.Deposit)
+	return &types.CapacityReservationResponse{Accepted: true}, nil
+}
+
+// ReleaseCapacity removes a reservation that was accepted, eg because the
+// response could not be sent to the client
+func (p *Provider) ReleaseCapacity(ctx context.Context, id uuid.UUID) error {
+	p.reservLk.Lock()
+	defer p.reservLk.Unlock()
+
+	if err := p.reservDB.Delete(ctx, id); err != nil {
+		return fmt.Errorf("deleting capacity reservation %s: %w", id, err)
+	}
+	log.Infow("released capacity reservation", "id", id)
+	return nil
+}
+
+// CapacityReservation gets the current state of the capacity reservation
+func (p *Provider) CapacityReservation(ctx context.Context, id uuid.UUID) (*types.CapacityReservationStatus, error) {
+	st, err := p.reservDB.ByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("getting capacity reservation %s: %w", id, ErrCapacityReservationNotFound)
+		}
+		return nil, fmt.Errorf("getting capacity reservation %s: %w", id, err)
+	}
+
+	head, err := p.fullnodeApi.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	setReservationState(st, head.Height())
+	return st, nil
+}
+
+// CapacityReservations lists all capacity reservations
+func (p *Provider) CapacityReservations(ctx context.Context) ([]types.CapacityReservationStatus, error) {
+	list, err := p.reservDB.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing capacity reservations: %w", err)
+	}
+
+	head, err := p.fullnodeApi.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+	for i := range list {
+		setReservationState(&list[i], head.Height())
+	}
+	return list, nil
+}
+
+// fillCapacityReservation counts the deal against a reservation made by the
+// client, if the deal starts within the reservation window
+func (p *Provider) fillCapacityReservation(deal *types.ProviderDealState) {
+	prop := deal.ClientDealProposal.Proposal
+	id, err := p.reservDB.Fill(p.ctx, prop.Client, prop.StartEpoch, uint64(prop.PieceSize))
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			p.dealLogger.LogError(deal.DealUuid, "failed to fill capacity reservation", err)
+		}
+		return
+	}
+	p.dealLogger.Infow(deal.DealUuid, "deal filled capacity reservation", "reservation", id, "piece size", prop.PieceSize)
+}
+
+func setReservationState(st *types.CapacityReservationStatus, height abi.ChainEpoch) {
+	switch {
+	case st.Filled >= st.Reservation.Size:
+		st.State = types.CapacityReservationFilled
+	case st.Reservation.EndEpoch < height:
+		st.State = types.CapacityReservationExpired
+	default:
+		st.State = types.CapacityReservationActive
+	}
+}
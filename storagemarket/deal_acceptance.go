@@ -3,6 +3,7 @@ package storagemarket
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
 
@@ -62,8 +63,8 @@ func (p *Provider) validateDealProposal(deal types.ProviderDealState) *validatio
 	}
 
 	// validate deal proposal
-	if proposal.Provider != p.Address {
-		err := fmt.Errorf("incorrect provider for deal; proposal.Provider: %s; provider.Address: %s", proposal.Provider, p.Address)
+	if !p.hasMiner(proposal.Provider) {
+		err := fmt.Errorf("incorrect provider for deal; proposal.Provider: %s; provider miners: %s", proposal.Provider, p.Miners())
 		return &validationError{error: err}
 	}
 
@@ -72,6 +73,16 @@ func (p *Provider) validateDealProposal(deal types.ProviderDealState) *validatio
 		return &validationError{error: err}
 	}
 
+	if deal.TransferStartTimeout < 0 || deal.TransferTimeout < 0 || deal.CompletionTimeout < 0 {
+		err := fmt.Errorf("deal timeouts must not be negative")
+		return &validationError{error: err}
+	}
+
+	if ceiling := p.getConfig().maxDealTransferTimeout(); deal.TransferTimeout > ceiling {
+		err := fmt.Errorf("deal transfer timeout %s is longer than the provider's maximum of %s", deal.TransferTimeout, ceiling)
+		return &validationError{error: err}
+	}
+
 	if err := proposal.PieceSize.Validate(); err != nil {
 		err := fmt.Errorf("proposal piece size is invalid: %w", err)
 		return &validationError{error: err}
@@ -179,10 +190,29 @@ func (p *Provider) validateDealProposal(deal types.ProviderDealState) *validatio
 			}
 		}
 
-		pieceSize := big.NewIntUnsigned(uint64(proposal.PieceSize))
-		if dataCap.LessThan(pieceSize) {
-			err := fmt.Errorf("verified deal DataCap %d too small for proposed piece size %d", dataCap, pieceSize)
-			return &validationError{error: err}
+		// The datacap for the client's verified deals that haven't been
+		// published yet will be taken off the client's datacap when they
+		// are published, so count it as used
+		pendingDeals, pending, err := p.dealsDB.PendingVerified(p.ctx, proposal.Client)
+		if err != nil {
+			return &validationError{
+				reason: "server error: getting pending verified deals",
+				error:  err,
+			}
+		}
+
+		check := types.DataCapCheck{
+			OnChain:      *dataCap,
+			Pending:      pending,
+			PendingDeals: pendingDeals,
+			Buffer:       p.getConfig().VerifiedDataCapBuffer,
+			PieceSize:    uint64(proposal.PieceSize),
+		}
+		if !check.Sufficient() {
+			return &validationError{
+				reason: "insufficient datacap: " + check.Reason(),
+				error:  fmt.Errorf("verified deal datacap check failed: %s", check.Reason()),
+			}
 		}
 	}
 
@@ -190,12 +220,21 @@ func (p *Provider) validateDealProposal(deal types.ProviderDealState) *validatio
 }
 
 func (p *Provider) validateAsk(deal types.ProviderDealState) error {
-	ask := p.GetAsk().Ask
+	m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+	if err != nil {
+		return err
+	}
+	ask := m.AskGetter.GetAsk().Ask
 	askPrice := ask.Price
 	if deal.ClientDealProposal.Proposal.VerifiedDeal {
 		askPrice = ask.VerifiedPrice
 	}
 
+	// Deals proposed during an off-peak window get the window's discount
+	if w, ok := types.CurrentOffPeakWindow(p.OffPeakWindows(), time.Now()); ok && w.DiscountPercent > 0 {
+		askPrice = big.Div(big.Mul(askPrice, big.NewIntUnsigned(100-w.DiscountPercent)), big.NewInt(100))
+	}
+
 	proposal := deal.ClientDealProposal.Proposal
 	minPrice := big.Div(big.Mul(askPrice, abi.NewTokenAmount(int64(proposal.PieceSize))), abi.NewTokenAmount(1<<30))
 	if proposal.StoragePricePerEpoch.LessThan(minPrice) {
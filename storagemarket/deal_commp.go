@@ -1,16 +1,19 @@
 package storagemarket
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/filecoin-project/boost/lib/commp"
 	"github.com/filecoin-project/boost/storagemarket/types"
-	"github.com/filecoin-project/go-commp-utils/writer"
+	"github.com/filecoin-project/go-address"
 	commcid "github.com/filecoin-project/go-fil-commcid"
-	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	commphh "github.com/filecoin-project/go-fil-commp-hashhash"
 	"github.com/filecoin-project/go-padreader"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
 )
@@ -21,7 +24,7 @@ var ErrCommpMismatch = fmt.Errorf("commp mismatch")
 // over the downloaded file
 func (p *Provider) verifyCommP(deal *types.ProviderDealState) *dealMakingError {
 	p.dealLogger.Infow(deal.DealUuid, "checking commP")
-	pieceCid, err := p.generatePieceCommitment(deal.InboundFilePath, deal.ClientDealProposal.Proposal.PieceSize)
+	pieceCid, err := p.generatePieceCommitment(deal.DealUuid, deal.ClientDealProposal.Proposal.Provider, deal.InboundFilePath, deal.ClientDealProposal.Proposal.PieceSize)
 	if err != nil {
 		err.error = fmt.Errorf("failed to generate CommP: %w", err.error)
 		return err
@@ -46,26 +49,38 @@ func (p *Provider) verifyCommP(deal *types.ProviderDealState) *dealMakingError {
 	return nil
 }
 
-// generatePieceCommitment generates commp either locally or remotely,
-// depending on config, and pads it as necessary to match the piece size.
-func (p *Provider) generatePieceCommitment(filepath string, pieceSize abi.PaddedPieceSize) (cid.Cid, *dealMakingError) {
+// generatePieceCommitment generates commp either locally or remotely (on the
+// sealing service of the deal's miner), depending on config, and pads it as
+// necessary to match the piece size.
+func (p *Provider) generatePieceCommitment(dealUuid uuid.UUID, maddr address.Address, filepath string, pieceSize abi.PaddedPieceSize) (cid.Cid, *dealMakingError) {
 	// Check whether to send commp to a remote process or do it locally
 	var pi *abi.PieceInfo
-	if p.config.RemoteCommp {
+	if p.getConfig().RemoteCommp {
 		var err *dealMakingError
-		pi, err = p.remoteCommP(filepath)
+		pi, err = p.remoteCommP(maddr, filepath)
 		if err != nil {
 			err.error = fmt.Errorf("performing remote commp: %w", err.error)
 			return cid.Undef, err
 		}
 	} else {
-		// Throttle the number of processes that can do local commp in parallel
-		p.commpThrottle <- struct{}{}
-		defer func() { <-p.commpThrottle }()
-
+		// Run local commp on the worker pool, which limits the number of
+		// calculations that run in parallel
+		calc := p.getCommpBackend()
 		var err error
-		pi, err = GenerateCommP(filepath)
+		pi, err = generateCommP(filepath, func(size int64, r io.Reader) (abi.PieceInfo, error) {
+			pi, err := p.commpPool.Sum(p.ctx, calc, dealUuid.String(), size, r)
+			if err != nil {
+				return pi, fmt.Errorf("calculating CommP with %s backend: %w", calc.Name(), err)
+			}
+			return pi, nil
+		})
 		if err != nil {
+			if p.ctx.Err() != nil {
+				return cid.Undef, &dealMakingError{
+					retry: types.DealRetryAuto,
+					error: fmt.Errorf("boost shutdown while calculating commp: %w", p.ctx.Err()),
+				}
+			}
 			return cid.Undef, &dealMakingError{
 				retry: types.DealRetryFatal,
 				error: fmt.Errorf("performing local commp: %w", err),
@@ -76,7 +91,7 @@ func (p *Provider) generatePieceCommitment(filepath string, pieceSize abi.Padded
 	// if the data does not fill the whole piece
 	if pi.Size < pieceSize {
 		// pad the data so that it fills the piece
-		rawPaddedCommp, err := commp.PadCommP(
+		rawPaddedCommp, err := commphh.PadCommP(
 			// we know how long a pieceCid "hash" is, just blindly extract the trailing 32 bytes
 			pi.PieceCID.Hash()[len(pi.PieceCID.Hash())-32:],
 			uint64(pi.Size),
@@ -95,7 +110,12 @@ func (p *Provider) generatePieceCommitment(filepath string, pieceSize abi.Padded
 }
 
 // remoteCommP makes an API call to the sealing service to calculate commp
-func (p *Provider) remoteCommP(filepath string) (*abi.PieceInfo, *dealMakingError) {
+func (p *Provider) remoteCommP(maddr address.Address, filepath string) (*abi.PieceInfo, *dealMakingError) {
+	m, err := p.miner(maddr)
+	if err != nil {
+		return nil, &dealMakingError{retry: types.DealRetryFatal, error: err}
+	}
+
 	// Open the CAR file
 	rd, err := carv2.OpenReader(filepath)
 	if err != nil {
@@ -130,7 +150,7 @@ func (p *Provider) remoteCommP(filepath string) (*abi.PieceInfo, *dealMakingErro
 	// pieceSize.Unpadded(), so add zeros until it reaches that size
 	pr, numBytes := padreader.New(dataReader, uint64(size))
 	log.Debugw("computing remote commp", "size", size, "padded-size", numBytes)
-	pi, err := p.commpCalc.ComputeDataCid(p.ctx, numBytes, pr)
+	pi, err := m.CommpCalc.ComputeDataCid(p.ctx, numBytes, pr)
 	if err != nil {
 		if p.ctx.Err() != nil {
 			return nil, &dealMakingError{
@@ -146,8 +166,25 @@ func (p *Provider) remoteCommP(filepath string) (*abi.PieceInfo, *dealMakingErro
 	return &pi, nil
 }
 
-// GenerateCommP calculates commp locally
+// GenerateCommP calculates commp locally using the default backend
 func GenerateCommP(filepath string) (*abi.PieceInfo, error) {
+	return GenerateCommPWith(context.Background(), commp.Default(), filepath)
+}
+
+// GenerateCommPWith calculates commp locally using the given backend
+func GenerateCommPWith(ctx context.Context, calc commp.Calculator, filepath string) (*abi.PieceInfo, error) {
+	return generateCommP(filepath, func(_ int64, r io.Reader) (abi.PieceInfo, error) {
+		pi, err := calc.Sum(ctx, r)
+		if err != nil {
+			return pi, fmt.Errorf("calculating CommP with %s backend: %w", calc.Name(), err)
+		}
+		return pi, nil
+	})
+}
+
+// generateCommP passes the CARv1 payload of the CAR file, and its size, to
+// sum to calculate commp
+func generateCommP(filepath string, sum func(size int64, r io.Reader) (abi.PieceInfo, error)) (*abi.PieceInfo, error) {
 	rd, err := carv2.OpenReader(filepath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get CARv2 reader: %w", err)
@@ -159,37 +196,41 @@ func GenerateCommP(filepath string) (*abi.PieceInfo, error) {
 		}
 	}()
 
-	// dump the CARv1 payload of the CARv2 file to the Commp Writer and get back the CommP.
-	w := &writer.Writer{}
-	r, err := rd.DataReader()
+	// get the size of the CAR file
+	size, err := getCarSize(filepath, rd)
 	if err != nil {
-		return nil, fmt.Errorf("getting data reader for CAR v1 from CAR v2: %w", err)
+		return nil, err
 	}
 
-	written, err := io.Copy(w, r)
+	// dump the CARv1 payload of the CARv2 file to the commp backend and get back the CommP.
+	r, err := rd.DataReader()
 	if err != nil {
-		return nil, fmt.Errorf("writing to commp writer: %w", err)
+		return nil, fmt.Errorf("getting data reader for CAR v1 from CAR v2: %w", err)
 	}
 
-	// get the size of the CAR file
-	size, err := getCarSize(filepath, rd)
+	cr := &countingReader{r: r}
+	pi, err := sum(size, cr)
 	if err != nil {
 		return nil, err
 	}
+	written := cr.n
 
 	if written != size {
-		return nil, fmt.Errorf("number of bytes written to CommP writer %d not equal to the CARv1 payload size %d", written, rd.Header.DataSize)
+		return nil, fmt.Errorf("number of bytes written to CommP backend %d not equal to the CARv1 payload size %d", written, rd.Header.DataSize)
 	}
 
-	pi, err := w.Sum()
-	if err != nil {
-		return nil, fmt.Errorf("failed to calculate CommP: %w", err)
-	}
+	return &pi, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	return &abi.PieceInfo{
-		Size:     pi.PieceSize,
-		PieceCID: pi.PieceCID,
-	}, nil
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 func getCarSize(filepath string, rd *carv2.Reader) (int64, error) {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	smtypes "github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
@@ -117,10 +118,15 @@ func (p *Provider) execDeal(deal *smtypes.ProviderDealState, dh *dealHandler) *d
 	p.dealLogger.Infow(deal.DealUuid, "finished deal cleanup after successful execution")
 
 	// Watch the sealing status of the deal and fire events for each change
-	p.dealLogger.Infow(deal.DealUuid, "watching deal sealing state changes")
-	p.fireSealingUpdateEvents(dh, deal.DealUuid, deal.SectorID)
+	m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+	if err != nil {
+		p.dealLogger.LogError(deal.DealUuid, "failed to watch deal sealing state changes", err)
+	} else {
+		p.dealLogger.Infow(deal.DealUuid, "watching deal sealing state changes")
+		p.fireSealingUpdateEvents(dh, deal.DealUuid, m.SealingPipeline, deal.SectorID)
+		p.dealLogger.Infow(deal.DealUuid, "deal sealing reached termination state")
+	}
 	p.cleanupDealHandler(deal.DealUuid)
-	p.dealLogger.Infow(deal.DealUuid, "deal sealing reached termination state")
 
 	// TODO
 	// Watch deal on chain and change state in DB and emit notifications.
@@ -206,7 +212,7 @@ func (p *Provider) execDealUptoAddPiece(ctx context.Context, deal *types.Provide
 	}
 
 	// as deal has already been handed to the sealer, we can remove the inbound file and reclaim the tagged space
-	if !deal.IsOffline {
+	if p.ownsInboundFile(deal) {
 		_ = os.Remove(deal.InboundFilePath)
 		p.dealLogger.Infow(deal.DealUuid, "removed inbound file as deal handed to sealer", "path", deal.InboundFilePath)
 	}
@@ -269,8 +275,23 @@ func (p *Provider) transferAndVerify(dh *dealHandler, pub event.Emitter, deal *s
 
 	p.dealLogger.Infow(deal.DealUuid, "deal queued for transfer", "transfer client id", deal.Transfer.ClientID)
 
-	// Wait for a spot in the transfer queue
-	err := p.xferLimiter.waitInQueue(ctx, deal)
+	// If the client asked for the deal to be published within a certain
+	// time, the transfer must finish before then
+	if deal.CompletionTimeout > 0 {
+		var cancelCompletion context.CancelFunc
+		ctx, cancelCompletion = context.WithDeadline(ctx, deal.CreatedAt.Add(deal.CompletionTimeout))
+		defer cancelCompletion()
+	}
+
+	// Wait for a spot in the transfer queue. If the client asked for the
+	// transfer to start within a certain time, give up after that time.
+	queueCtx := ctx
+	if deal.TransferStartTimeout > 0 {
+		var cancelQueue context.CancelFunc
+		queueCtx, cancelQueue = context.WithDeadline(ctx, deal.CreatedAt.Add(deal.TransferStartTimeout))
+		defer cancelQueue()
+	}
+	err := p.xferLimiter.waitInQueue(queueCtx, deal)
 	if err != nil {
 		// If the transfer failed because the user cancelled the
 		// transfer, it's non-recoverable
@@ -281,6 +302,17 @@ func (p *Provider) transferAndVerify(dh *dealHandler, pub event.Emitter, deal *s
 			}
 		}
 
+		if derr := p.checkDealCompletionDeadline(deal); derr != nil {
+			return derr
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &dealMakingError{
+				retry: smtypes.DealRetryFatal,
+				error: fmt.Errorf("transfer for deal %s did not start within the deal's transfer start timeout of %s: %w", deal.DealUuid, deal.TransferStartTimeout, err),
+			}
+		}
+
 		// If boost was shutdown while waiting for the transfer to start,
 		// automatically retry on restart.
 		if errors.Is(err, context.Canceled) {
@@ -298,11 +330,30 @@ func (p *Provider) transferAndVerify(dh *dealHandler, pub event.Emitter, deal *s
 
 	p.dealLogger.Infow(deal.DealUuid, "start deal data transfer", "transfer client id", deal.Transfer.ClientID)
 	transferStart := time.Now()
-	tctx, cancel := context.WithDeadline(ctx, transferStart.Add(p.config.MaxTransferDuration))
+	// The client may ask for a transfer timeout up to the provider's
+	// ceiling, which is checked when the deal is accepted. The ceiling may
+	// have been lowered since then, so check it again here.
+	cfg := p.getConfig()
+	maxTransferDuration := cfg.MaxTransferDuration
+	if deal.TransferTimeout > 0 {
+		maxTransferDuration = deal.TransferTimeout
+		if ceiling := cfg.maxDealTransferTimeout(); maxTransferDuration > ceiling {
+			maxTransferDuration = ceiling
+		}
+	}
+	tctx, cancel := context.WithDeadline(ctx, transferStart.Add(maxTransferDuration))
 	defer cancel()
 
+	tspt, ok := p.transportFor(deal.Transfer.Type)
+	if !ok {
+		return &dealMakingError{
+			retry: smtypes.DealRetryFatal,
+			error: fmt.Errorf("transfer type %s is not supported", deal.Transfer.Type),
+		}
+	}
+
 	st := time.Now()
-	handler, err := p.Transport.Execute(tctx, deal.Transfer.Params, &transporttypes.TransportDealInfo{
+	handler, err := tspt.Execute(tctx, deal.Transfer.Params, &transporttypes.TransportDealInfo{
 		OutputFile: deal.InboundFilePath,
 		DealUuid:   deal.DealUuid,
 		DealSize:   int64(deal.Transfer.Size),
@@ -327,13 +378,17 @@ func (p *Provider) transferAndVerify(dh *dealHandler, pub event.Emitter, deal *s
 
 		// If the transfer failed because boost was shut down, it's
 		// automatically recoverable
-		if errors.Is(err, context.Canceled) && time.Since(transferStart) < p.config.MaxTransferDuration {
+		if errors.Is(err, context.Canceled) && time.Since(transferStart) < maxTransferDuration {
 			return &dealMakingError{
 				retry: types.DealRetryAuto,
 				error: fmt.Errorf("data transfer paused by boost shutdown after %d bytes: %w", deal.NBytesReceived, err),
 			}
 		}
 
+		if derr := p.checkDealCompletionDeadline(deal); derr != nil {
+			return derr
+		}
+
 		// Note that the data transfer has automatic retries built in, so if
 		// it fails, it means it's already retried several times and we should
 		// fail the deal
@@ -375,6 +430,10 @@ func (p *Provider) waitForTransferFinish(ctx context.Context, handler transport.
 		}
 	}
 
+	// The compression stats in transfer events are for this run of the
+	// transfer, so add them to the stats from any earlier runs
+	baseWireBytes, baseDecodedBytes := deal.TransferWireBytes, deal.TransferDecodedBytes
+
 	for {
 		select {
 		case evt, ok := <-handler.Sub():
@@ -385,6 +444,16 @@ func (p *Provider) waitForTransferFinish(ctx context.Context, handler transport.
 				return evt.Error
 			}
 			deal.NBytesReceived = evt.NBytesReceived
+			if evt.Origin != "" && evt.Origin != deal.TransferOrigin {
+				deal.TransferOrigin = evt.Origin
+				p.dealLogger.Infow(deal.DealUuid, "transferring deal data from origin", "origin", evt.Origin)
+			}
+			if evt.Compression != "" && evt.Compression != deal.TransferCompression {
+				deal.TransferCompression = evt.Compression
+				p.dealLogger.Infow(deal.DealUuid, "deal data is compressed on the wire", "encoding", evt.Compression)
+			}
+			deal.TransferWireBytes = baseWireBytes + evt.WireBytes
+			deal.TransferDecodedBytes = baseDecodedBytes + evt.DecodedBytes
 			p.transfers.setBytes(deal.DealUuid, uint64(evt.NBytesReceived))
 			p.xferLimiter.setBytes(deal.DealUuid, uint64(evt.NBytesReceived))
 			p.fireEventDealUpdate(pub, deal)
@@ -406,9 +475,18 @@ func (p *Provider) publishDeal(ctx context.Context, pub event.Emitter, deal *typ
 	// deal are locked and can no longer be withdrawn. Payment is transferred
 	// to the provider's wallet at each epoch.
 	if deal.Checkpoint < dealcheckpoints.Published {
+		if derr := p.checkDealCompletionDeadline(deal); derr != nil {
+			return derr
+		}
+
+		m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+		if err != nil {
+			return &dealMakingError{retry: types.DealRetryFatal, error: err}
+		}
+
 		p.dealLogger.Infow(deal.DealUuid, "sending deal to deal publisher")
 
-		mcid, err := p.dealPublisher.Publish(p.ctx, deal.ClientDealProposal)
+		mcid, err := m.DealPublisher.Publish(p.ctx, deal.ClientDealProposal)
 		if err != nil {
 			// Check if the deal start epoch has expired
 			if derr := p.checkDealProposalStartEpoch(deal); derr != nil {
@@ -563,10 +641,16 @@ func (p *Provider) addPiece(ctx context.Context, pub event.Emitter, deal *types.
 }
 
 func (p *Provider) indexAndAnnounce(ctx context.Context, pub event.Emitter, deal *types.ProviderDealState) *dealMakingError {
+	m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+	if err != nil {
+		return &dealMakingError{retry: types.DealRetryFatal, error: err}
+	}
+
 	pc := deal.ClientDealProposal.Proposal.PieceCID
 
-	// add deal to piecestore
-	if err := p.ps.AddDealForPiece(pc, piecestore.DealInfo{
+	// add deal to the piecestore of the deal's miner, which records the
+	// miner's sectors that the piece is read from when it is retrieved
+	if err := m.PieceStore.AddDealForPiece(pc, piecestore.DealInfo{
 		DealID:   deal.ChainDealID,
 		SectorID: deal.SectorID,
 		Offset:   deal.Offset,
@@ -580,7 +664,7 @@ func (p *Provider) indexAndAnnounce(ctx context.Context, pub event.Emitter, deal
 	p.dealLogger.Infow(deal.DealUuid, "deal successfully added to piecestore")
 
 	// register with dagstore
-	err := stores.RegisterShardSync(ctx, p.dagst, pc, "", true)
+	err = stores.RegisterShardSync(ctx, p.dagst, pc, "", true)
 
 	if err != nil {
 		if !errors.Is(err, dagstore.ErrShardExists) {
@@ -617,8 +701,8 @@ func (p *Provider) indexAndAnnounce(ctx context.Context, pub event.Emitter, deal
 }
 
 // fireSealingUpdateEvents periodically checks the sealing status of the deal
-// and fires events for each change
-func (p *Provider) fireSealingUpdateEvents(dh *dealHandler, dealUuid uuid.UUID, sectorNum abi.SectorNumber) {
+// with the miner's sealing pipeline and fires events for each change
+func (p *Provider) fireSealingUpdateEvents(dh *dealHandler, dealUuid uuid.UUID, sps sealingpipeline.API, sectorNum abi.SectorNumber) {
 	var lastSealingState lapi.SectorState
 	checkStatus := func(force bool) lapi.SectorState {
 		// To avoid overloading the sealing service, only get the sector status
@@ -628,7 +712,7 @@ func (p *Provider) fireSealingUpdateEvents(dh *dealHandler, dealUuid uuid.UUID,
 		}
 
 		// Get the sector status
-		si, err := p.sps.SectorsStatus(p.ctx, sectorNum, false)
+		si, err := sps.SectorsStatus(p.ctx, sectorNum, false)
 		if err == nil && si.State != lastSealingState {
 			lastSealingState = si.State
 
@@ -733,8 +817,9 @@ func (p *Provider) cleanupDeal(deal *types.ProviderDealState) {
 	p.dealLogger.Infow(deal.DealUuid, "cleaning up deal")
 	defer p.dealLogger.Infow(deal.DealUuid, "finished cleaning up deal")
 
-	// remove the temp file created for inbound deal data if it is not an offline deal
-	if !deal.IsOffline {
+	// remove the temp file created for inbound deal data if it is not an
+	// imported offline deal
+	if p.ownsInboundFile(deal) {
 		_ = os.Remove(deal.InboundFilePath)
 	}
 
@@ -780,6 +865,9 @@ func (p *Provider) fireEventDealUpdate(pub event.Emitter, deal *types.ProviderDe
 	if err := pub.Emit(*deal); err != nil {
 		p.dealLogger.Warnw(deal.DealUuid, "publishing deal state update", "err", err.Error())
 	}
+	if err := p.dealUpdatesPS.DealUpdates.Emit(*deal); err != nil {
+		p.dealLogger.Warnw(deal.DealUuid, "publishing deal state update to all deals subscribers", "err", err.Error())
+	}
 }
 
 func (p *Provider) updateCheckpoint(pub event.Emitter, deal *types.ProviderDealState, ckpt dealcheckpoints.Checkpoint) *dealMakingError {
@@ -799,6 +887,25 @@ func (p *Provider) updateCheckpoint(pub event.Emitter, deal *types.ProviderDealS
 	return nil
 }
 
+// checkDealCompletionDeadline fails the deal if the client asked for it to
+// be published within a certain time, and that time has passed
+func (p *Provider) checkDealCompletionDeadline(deal *smtypes.ProviderDealState) *dealMakingError {
+	if deal.CompletionTimeout <= 0 {
+		return nil
+	}
+
+	deadline := deal.CreatedAt.Add(deal.CompletionTimeout)
+	if time.Now().Before(deadline) {
+		return nil
+	}
+
+	return &dealMakingError{
+		retry: smtypes.DealRetryFatal,
+		error: fmt.Errorf("deal %s was not ready to publish within the deal's completion timeout of %s (deadline %s)",
+			deal.DealUuid, deal.CompletionTimeout, deadline.Format(time.RFC3339)),
+	}
+}
+
 func (p *Provider) checkDealProposalStartEpoch(deal *smtypes.ProviderDealState) *dealMakingError {
 	chainHead, err := p.fullnodeApi.ChainHead(p.ctx)
 	if err != nil {
@@ -0,0 +1,216 @@
+package storagemarket
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+)
+
+const (
+	dealRateHour = time.Hour
+	dealRateDay  = 24 * time.Hour
+)
+
+const (
+	dealRateKindClient = "client"
+	dealRateKindPeer   = "peer"
+)
+
+type dealRateEvent struct {
+	at    time.Time
+	bytes uint64
+}
+
+// dealRate is the recent deal proposals from a client wallet address or peer
+type dealRate struct {
+	// The proposals that were allowed in the last day, oldest first
+	proposals []dealRateEvent
+	// The number of proposals that were rejected since rejectedSince. The
+	// count is reset once it is a day old, so that a client that keeps
+	// making proposals over the limit doesn't use more and more memory.
+	rejected      uint64
+	rejectedSince time.Time
+}
+
+// prune drops events older than a day, and returns false if there are no
+// events left
+func (r *dealRate) prune(now time.Time) bool {
+	cutoff := now.Add(-dealRateDay)
+	i := 0
+	for i < len(r.proposals) && !r.proposals[i].at.After(cutoff) {
+		i++
+	}
+	r.proposals = r.proposals[i:]
+	if !r.rejectedSince.After(cutoff) {
+		r.rejected = 0
+	}
+	return len(r.proposals) > 0 || r.rejected > 0
+}
+
+func (r *dealRate) reject(now time.Time) {
+	if r.rejected == 0 {
+		r.rejectedSince = now
+	}
+	r.rejected++
+}
+
+func (r *dealRate) usage(now time.Time) (uint64, uint64) {
+	var deals, bytes uint64
+	for _, e := range r.proposals {
+		if e.at.After(now.Add(-dealRateHour)) {
+			deals++
+		}
+		bytes += e.bytes
+	}
+	return deals, bytes
+}
+
+// exceeds returns the reason that a proposal of the given size would take
+// the usage over the limit, or "" if it would not
+func (r *dealRate) exceeds(limit smtypes.DealRateLimit, size uint64, now time.Time) string {
+	deals, bytes := r.usage(now)
+	if limit.DealsPerHour > 0 && deals+1 > limit.DealsPerHour {
+		return fmt.Sprintf("more than %d deal proposals in an hour", limit.DealsPerHour)
+	}
+	if limit.BytesPerDay > 0 && bytes+size > limit.BytesPerDay {
+		return fmt.Sprintf("more than %s of deal proposals in a day", humanize.IBytes(limit.BytesPerDay))
+	}
+	return ""
+}
+
+// dealRateLimiter limits the rate of deal proposals from each client wallet
+// address and each client peer, so that a single client can't fill up the
+// queue of deals waiting to be accepted. Proposals are counted over a sliding
+// window of the last hour (for the number of deals) and the last day (for
+// the total piece size).
+type dealRateLimiter struct {
+	lk      sync.Mutex
+	limits  smtypes.DealRateLimits
+	clients map[string]*dealRate
+	peers   map[string]*dealRate
+	// The last time that all entries were pruned
+	prunedAt time.Time
+	now      func() time.Time
+}
+
+func newDealRateLimiter(limits smtypes.DealRateLimits) *dealRateLimiter {
+	return &dealRateLimiter{
+		limits:  limits,
+		clients: make(map[string]*dealRate),
+		peers:   make(map[string]*dealRate),
+		now:     time.Now,
+	}
+}
+
+func (l *dealRateLimiter) setLimits(limits smtypes.DealRateLimits) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.limits = limits
+}
+
+// allow records a deal proposal of the given piece size from the client
+// wallet address and peer, and returns "" if it is within the limits, or
+// otherwise the reason it is rejected. The peer may be empty (eg for a deal
+// that was not proposed over libp2p).
+func (l *dealRateLimiter) allow(client string, peer string, size uint64) string {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.now()
+	l.pruneAll(now)
+
+	type check struct {
+		kind  string
+		rates map[string]*dealRate
+		id    string
+		limit smtypes.DealRateLimit
+	}
+	checks := []check{{dealRateKindClient, l.clients, client, l.limits.PerClient}}
+	if peer != "" {
+		checks = append(checks, check{dealRateKindPeer, l.peers, peer, l.limits.PerPeer})
+	}
+
+	rates := make([]*dealRate, 0, len(checks))
+	for _, c := range checks {
+		r, ok := c.rates[c.id]
+		if !ok {
+			r = &dealRate{}
+			c.rates[c.id] = r
+		}
+		rates = append(rates, r)
+	}
+
+	for i, c := range checks {
+		if reason := rates[i].exceeds(c.limit, size, now); reason != "" {
+			for _, r := range rates {
+				r.reject(now)
+			}
+			return fmt.Sprintf("%s %s: %s", c.kind, c.id, reason)
+		}
+	}
+	for _, r := range rates {
+		r.proposals = append(r.proposals, dealRateEvent{at: now, bytes: size})
+	}
+	return ""
+}
+
+// pruneAll drops the clients and peers that have not made a proposal in the
+// last day, at most once an hour
+func (l *dealRateLimiter) pruneAll(now time.Time) {
+	if now.Sub(l.prunedAt) < dealRateHour {
+		return
+	}
+	l.prunedAt = now
+	for _, rates := range []map[string]*dealRate{l.clients, l.peers} {
+		for id, r := range rates {
+			if !r.prune(now) {
+				delete(rates, id)
+			}
+		}
+	}
+}
+
+// status returns the limits and the recent usage of each client wallet
+// address and peer, with the heaviest users first
+func (l *dealRateLimiter) status() smtypes.DealRateLimitStatus {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	now := l.now()
+	st := smtypes.DealRateLimitStatus{Limits: l.limits}
+	for _, kr := range []struct {
+		kind  string
+		rates map[string]*dealRate
+	}{{dealRateKindClient, l.clients}, {dealRateKindPeer, l.peers}} {
+		for id, r := range kr.rates {
+			if !r.prune(now) {
+				delete(kr.rates, id)
+				continue
+			}
+			deals, bytes := r.usage(now)
+			st.Usage = append(st.Usage, smtypes.DealRateUsage{
+				Kind:          kr.kind,
+				ID:            id,
+				DealsLastHour: deals,
+				BytesLastDay:  bytes,
+				Rejected:      r.rejected,
+			})
+		}
+	}
+	sort.Slice(st.Usage, func(i, j int) bool {
+		a, b := st.Usage[i], st.Usage[j]
+		if a.BytesLastDay != b.BytesLastDay {
+			return a.BytesLastDay > b.BytesLastDay
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.ID < b.ID
+	})
+	return st
+}
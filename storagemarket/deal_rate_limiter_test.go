@@ -0,0 +1,55 @@
+package storagemarket
+
+import (
+	"testing"
+	"time"
+
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDealRateLimiter(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := newDealRateLimiter(smtypes.DealRateLimits{
+		PerClient: smtypes.DealRateLimit{DealsPerHour: 2},
+		PerPeer:   smtypes.DealRateLimit{BytesPerDay: 100},
+	})
+	l.now = func() time.Time { return now }
+
+	// The client may make two proposals an hour
+	require.Empty(t, l.allow("f01000", "peerA", 10))
+	require.Empty(t, l.allow("f01000", "peerA", 10))
+	require.Contains(t, l.allow("f01000", "peerA", 10), "client f01000")
+
+	// Other clients are not affected, but the peer is limited to 100 bytes
+	// a day across all its clients
+	require.Empty(t, l.allow("f01001", "peerA", 70))
+	require.Contains(t, l.allow("f01002", "peerA", 20), "peer peerA")
+
+	// Deals without a peer are only limited by client
+	require.Empty(t, l.allow("f01002", "", 20))
+
+	st := l.status()
+	require.Len(t, st.Usage, 4)
+	require.Equal(t, smtypes.DealRateUsage{Kind: "peer", ID: "peerA", DealsLastHour: 3, BytesLastDay: 90, Rejected: 2}, st.Usage[0])
+
+	// After an hour the client may make more proposals
+	now = now.Add(time.Hour)
+	require.Empty(t, l.allow("f01000", "peerB", 10))
+
+	// After a day the peer may propose more bytes
+	require.Contains(t, l.allow("f01003", "peerA", 20), "peer peerA")
+	now = now.Add(24 * time.Hour)
+	require.Empty(t, l.allow("f01003", "peerA", 20))
+
+	// Clients and peers without recent proposals are dropped
+	st = l.status()
+	require.Len(t, st.Usage, 2)
+
+	// Limits can be changed while running
+	l.setLimits(smtypes.DealRateLimits{})
+	for i := 0; i < 10; i++ {
+		require.Empty(t, l.allow("f01003", "peerA", 20))
+	}
+	require.Equal(t, smtypes.DealRateLimits{}, l.status().Limits)
+}
@@ -17,35 +17,43 @@ const jsonVersion = "2.0.0"
 
 func CliStorageDealFilter(cmd string) dtypes.StorageDealFilter {
 	return func(ctx context.Context, deal types.DealFilterParams) (bool, string, error) {
-		d := struct {
-			types.DealParams
-			DealType      string
-			FormatVersion string
-			Agent         string
-		}{
-			DealParams:    *deal.DealParams,
-			DealType:      "storage",
-			FormatVersion: jsonVersion,
-			Agent:         agent,
-		}
-		return runDealFilter(ctx, cmd, d)
+		return runDealFilter(ctx, cmd, storageDealJSON(deal))
 	}
 }
 
 func CliRetrievalDealFilter(cmd string) dtypes.RetrievalDealFilter {
 	return func(ctx context.Context, deal retrievalmarket.ProviderDealState) (bool, string, error) {
-		d := struct {
-			retrievalmarket.ProviderDealState
-			DealType      string
-			FormatVersion string
-			Agent         string
-		}{
-			ProviderDealState: deal,
-			DealType:          "retrieval",
-			FormatVersion:     jsonVersion,
-			Agent:             agent,
-		}
-		return runDealFilter(ctx, cmd, d)
+		return runDealFilter(ctx, cmd, retrievalDealJSON(deal))
+	}
+}
+
+// storageDealJSON is the storage deal that is sent to a deal filter
+func storageDealJSON(deal types.DealFilterParams) interface{} {
+	return struct {
+		types.DealParams
+		DealType      string
+		FormatVersion string
+		Agent         string
+	}{
+		DealParams:    *deal.DealParams,
+		DealType:      "storage",
+		FormatVersion: jsonVersion,
+		Agent:         agent,
+	}
+}
+
+// retrievalDealJSON is the retrieval deal that is sent to a deal filter
+func retrievalDealJSON(deal retrievalmarket.ProviderDealState) interface{} {
+	return struct {
+		retrievalmarket.ProviderDealState
+		DealType      string
+		FormatVersion string
+		Agent         string
+	}{
+		ProviderDealState: deal,
+		DealType:          "retrieval",
+		FormatVersion:     jsonVersion,
+		Agent:             agent,
 	}
 }
 
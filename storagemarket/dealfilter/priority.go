@@ -0,0 +1,83 @@
+package dealfilter
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("dealfilter")
+
+// PriorityRule adds its weight to the priority of deals that satisfy its
+// expression. Expressions have the same syntax and variables as Rule
+// expressions, eg
+//
+//	verified
+//	oneOf(client, "f01234", "f05678")
+//	piece_size <= 8*GiB
+type PriorityRule struct {
+	Rule
+	Weight int64
+}
+
+type compiledPriorityRule struct {
+	compiledRule
+	weight int64
+}
+
+// Priorities is a set of compiled priority rules
+type Priorities struct {
+	rules []compiledPriorityRule
+}
+
+// CompilePriorityRules parses each rule's expression and checks that it
+// refers only to known variables and functions, and that it evaluates to a
+// bool
+func CompilePriorityRules(rules []PriorityRule) (*Priorities, error) {
+	ps := &Priorities{}
+	for i, r := range rules {
+		cr, err := compileRule("deal priority rule", i, r.Rule)
+		if err != nil {
+			return nil, err
+		}
+		ps.rules = append(ps.rules, compiledPriorityRule{compiledRule: cr, weight: r.Weight})
+	}
+	return ps, nil
+}
+
+// Weight returns the sum of the weights of the rules that the variables
+// satisfy
+func (ps *Priorities) Weight(vars map[string]interface{}) (int64, error) {
+	var weight int64
+	for _, r := range ps.rules {
+		v, err := eval(r.expr, vars)
+		if err != nil {
+			return 0, fmt.Errorf("evaluating deal priority rule %s: %w", r.Name, err)
+		}
+		if v.(bool) {
+			weight += r.weight
+		}
+	}
+	return weight, nil
+}
+
+// DealPriority returns the priority of a deal that has been accepted, ie the
+// sum of the weights of the rules it satisfies. The head_epoch variable is
+// zero, because the priority doesn't depend on when the deal was proposed.
+func (ps *Priorities) DealPriority(deal *types.ProviderDealState) int64 {
+	weight, err := ps.Weight(StorageDealVars(types.DealFilterParams{
+		DealParams: &types.DealParams{
+			DealUUID:           deal.DealUuid,
+			IsOffline:          deal.IsOffline,
+			ClientDealProposal: deal.ClientDealProposal,
+			DealDataRoot:       deal.DealDataRoot,
+			Transfer:           deal.Transfer,
+		},
+	}))
+	if err != nil {
+		log.Warnw("failed to evaluate deal priority rules", "id", deal.DealUuid, "err", err)
+		return 0
+	}
+	return weight
+}
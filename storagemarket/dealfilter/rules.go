@@ -0,0 +1,524 @@
+package dealfilter
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-state-types/builtin"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+)
+
+// Rule is a named expression that a storage deal must satisfy to be
+// accepted.
+//
+// Expressions have the syntax of Go expressions (which is close to CEL):
+// literals, the operators && || ! == != < <= > >= + - * / % and parentheses,
+// the deal's variables and these constants and functions:
+//
+//	KiB, MiB, GiB, TiB  sizes in bytes
+//	EpochsPerDay        the number of epochs in a day
+//	fil("0.0001")       an amount of FIL in attoFIL
+//	oneOf(x, a, b...)   whether x is equal to one of a, b...
+//	hasPrefix(s, p)     whether the string s starts with p
+//
+// eg: verified || price_per_gib_epoch >= fil("0.0000000005")
+type Rule struct {
+	Name string
+	Expr string
+}
+
+// The variables that an expression can refer to, and their kinds. Integer
+// variables are unbounded, so that prices in attoFIL can be compared.
+var ruleVars = map[string]kind{
+	// The client and provider addresses
+	"client":   kindString,
+	"provider": kindString,
+	// Whether the deal is for verified data (FIL+)
+	"verified":  kindBool,
+	"piece_cid": kindString,
+	// The padded size of the piece in bytes
+	"piece_size":  kindInt,
+	"start_epoch": kindInt,
+	"end_epoch":   kindInt,
+	// The duration of the deal in epochs
+	"duration": kindInt,
+	// The epoch of the chain head when the deal was proposed
+	"head_epoch": kindInt,
+	// The price that the client pays for the whole deal per epoch, in
+	// attoFIL
+	"price_per_epoch": kindInt,
+	// The price that the client pays per GiB per epoch, in attoFIL
+	"price_per_gib_epoch": kindInt,
+	"provider_collateral": kindInt,
+	"client_collateral":   kindInt,
+	// The transfer type, eg "http" or "libp2p", or "manual" for offline
+	// deals
+	"transfer_type": kindString,
+	// The size of the data to transfer in bytes
+	"transfer_size": kindInt,
+	// Whether the deal is an offline deal
+	"offline": kindBool,
+}
+
+var ruleConsts = map[string]*big.Int{
+	"KiB":          big.NewInt(1 << 10),
+	"MiB":          big.NewInt(1 << 20),
+	"GiB":          big.NewInt(1 << 30),
+	"TiB":          big.NewInt(1 << 40),
+	"EpochsPerDay": big.NewInt(builtin.EpochsInDay),
+}
+
+type kind int
+
+const (
+	kindBool kind = iota
+	kindInt
+	kindString
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindBool:
+		return "bool"
+	case kindInt:
+		return "int"
+	default:
+		return "string"
+	}
+}
+
+type compiledRule struct {
+	Rule
+	expr ast.Expr
+}
+
+// Rules is a set of compiled rules
+type Rules struct {
+	rules []compiledRule
+}
+
+// CompileRules parses each rule's expression and checks that it refers only
+// to known variables and functions, and that it evaluates to a bool
+func CompileRules(rules []Rule) (*Rules, error) {
+	rs := &Rules{}
+	for i, r := range rules {
+		cr, err := compileRule("deal filter rule", i, r)
+		if err != nil {
+			return nil, err
+		}
+		rs.rules = append(rs.rules, cr)
+	}
+	return rs, nil
+}
+
+// CompilePublishedRules compiles the rules that a provider publishes, so
+// that a client can check a deal against them before proposing it. Rules
+// that can't be compiled (eg because the provider runs a newer version with
+// variables that this version doesn't know about) are skipped, and their
+// names are returned.
+func CompilePublishedRules(rules []types.PublishedFilterRule) (*Rules, []string) {
+	rs := &Rules{}
+	var skipped []string
+	for i, r := range rules {
+		cr, err := compileRule("published filter rule", i, Rule{Name: r.Name, Expr: r.Expr})
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			log.Debugw("skipping published filter rule", "name", name, "err", err)
+			skipped = append(skipped, name)
+			continue
+		}
+		rs.rules = append(rs.rules, cr)
+	}
+	return rs, skipped
+}
+
+// compileRule parses the i-th rule's expression and checks that it evaluates
+// to a bool
+func compileRule(what string, i int, r Rule) (compiledRule, error) {
+	name := r.Name
+	if name == "" {
+		name = fmt.Sprintf("#%d", i+1)
+	}
+	expr, err := parser.ParseExpr(r.Expr)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("parsing %s %s: %w", what, name, err)
+	}
+	k, err := check(expr)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("%s %s: %w", what, name, err)
+	}
+	if k != kindBool {
+		return compiledRule{}, fmt.Errorf("%s %s: expression is a %s, not a bool", what, name, k)
+	}
+	return compiledRule{Rule: Rule{Name: name, Expr: r.Expr}, expr: expr}, nil
+}
+
+// Eval evaluates each rule in order against the variables. It returns false
+// and the name of the first rule that the variables do not satisfy, or true
+// if they satisfy all the rules.
+func (rs *Rules) Eval(vars map[string]interface{}) (bool, string, error) {
+	for _, r := range rs.rules {
+		v, err := eval(r.expr, vars)
+		if err != nil {
+			return false, r.Name, fmt.Errorf("evaluating deal filter rule %s: %w", r.Name, err)
+		}
+		if !v.(bool) {
+			return false, r.Name, nil
+		}
+	}
+	return true, "", nil
+}
+
+// RulesStorageDealFilter rejects storage deals that do not satisfy the rules,
+// and passes the deals that do on to next (if not nil)
+func RulesStorageDealFilter(rs *Rules, next dtypes.StorageDealFilter) dtypes.StorageDealFilter {
+	return func(ctx context.Context, deal types.DealFilterParams) (bool, string, error) {
+		ok, rule, err := rs.Eval(StorageDealVars(deal))
+		if err != nil {
+			return false, "deal filter rule error", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("deal rejected by provider's deal filter rule %s", rule), nil
+		}
+		if next != nil {
+			return next(ctx, deal)
+		}
+		return true, "", nil
+	}
+}
+
+// StorageDealVars returns the variables that rules are evaluated against
+// for a storage deal
+func StorageDealVars(deal types.DealFilterParams) map[string]interface{} {
+	prop := deal.DealParams.ClientDealProposal.Proposal
+	pricePerEpoch := prop.StoragePricePerEpoch.Int
+	if pricePerEpoch == nil {
+		pricePerEpoch = big.NewInt(0)
+	}
+	pricePerGiBEpoch := big.NewInt(0)
+	if prop.PieceSize > 0 {
+		pricePerGiBEpoch.Mul(pricePerEpoch, ruleConsts["GiB"])
+		pricePerGiBEpoch.Div(pricePerGiBEpoch, big.NewInt(int64(prop.PieceSize)))
+	}
+	bigOrZero := func(i *big.Int) *big.Int {
+		if i == nil {
+			return big.NewInt(0)
+		}
+		return i
+	}
+
+	transfer := deal.DealParams.Transfer
+	return map[string]interface{}{
+		"client":              prop.Client.String(),
+		"provider":            prop.Provider.String(),
+		"verified":            prop.VerifiedDeal,
+		"piece_cid":           prop.PieceCID.String(),
+		"piece_size":          new(big.Int).SetUint64(uint64(prop.PieceSize)),
+		"start_epoch":         big.NewInt(int64(prop.StartEpoch)),
+		"end_epoch":           big.NewInt(int64(prop.EndEpoch)),
+		"duration":            big.NewInt(int64(prop.EndEpoch - prop.StartEpoch)),
+		"head_epoch":          big.NewInt(int64(deal.ChainHead)),
+		"price_per_epoch":     pricePerEpoch,
+		"price_per_gib_epoch": pricePerGiBEpoch,
+		"provider_collateral": bigOrZero(prop.ProviderCollateral.Int),
+		"client_collateral":   bigOrZero(prop.ClientCollateral.Int),
+		"transfer_type":       transfer.Type,
+		"transfer_size":       new(big.Int).SetUint64(transfer.Size),
+		"offline":             transfer.Type == "manual",
+	}
+}
+
+// check returns the kind of value that the expression evaluates to, or an
+// error if the expression is not valid
+func check(e ast.Expr) (kind, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return check(e.X)
+
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return kindInt, nil
+		case token.STRING:
+			return kindString, nil
+		}
+		return 0, fmt.Errorf("unsupported literal %s", e.Value)
+
+	case *ast.Ident:
+		if e.Name == "true" || e.Name == "false" {
+			return kindBool, nil
+		}
+		if k, ok := ruleVars[e.Name]; ok {
+			return k, nil
+		}
+		if _, ok := ruleConsts[e.Name]; ok {
+			return kindInt, nil
+		}
+		return 0, fmt.Errorf("unknown variable %s", e.Name)
+
+	case *ast.UnaryExpr:
+		k, err := check(e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case e.Op == token.NOT && k == kindBool:
+			return kindBool, nil
+		case e.Op == token.SUB && k == kindInt:
+			return kindInt, nil
+		}
+		return 0, fmt.Errorf("operator %s is not defined on %s", e.Op, k)
+
+	case *ast.BinaryExpr:
+		x, err := check(e.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := check(e.Y)
+		if err != nil {
+			return 0, err
+		}
+		if x != y {
+			return 0, fmt.Errorf("mismatched kinds %s and %s for operator %s", x, y, e.Op)
+		}
+		switch e.Op {
+		case token.LAND, token.LOR:
+			if x == kindBool {
+				return kindBool, nil
+			}
+		case token.EQL, token.NEQ:
+			return kindBool, nil
+		case token.LSS, token.LEQ, token.GTR, token.GEQ:
+			if x != kindBool {
+				return kindBool, nil
+			}
+		case token.ADD:
+			if x != kindBool {
+				return x, nil
+			}
+		case token.SUB, token.MUL, token.QUO, token.REM:
+			if x == kindInt {
+				return kindInt, nil
+			}
+		}
+		return 0, fmt.Errorf("operator %s is not defined on %s", e.Op, x)
+
+	case *ast.CallExpr:
+		fn, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return 0, fmt.Errorf("unsupported function call")
+		}
+		args := make([]kind, 0, len(e.Args))
+		for _, a := range e.Args {
+			k, err := check(a)
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, k)
+		}
+		switch fn.Name {
+		case "fil":
+			var lit *ast.BasicLit
+			if len(e.Args) == 1 {
+				lit, _ = e.Args[0].(*ast.BasicLit)
+			}
+			if lit == nil || lit.Kind != token.STRING {
+				return 0, fmt.Errorf("fil takes a string literal, eg fil(\"0.5\")")
+			}
+			if _, err := parseFIL(lit); err != nil {
+				return 0, err
+			}
+			return kindInt, nil
+		case "oneOf":
+			if len(args) < 2 {
+				return 0, fmt.Errorf("oneOf takes a value and at least one value to compare it to")
+			}
+			for _, k := range args[1:] {
+				if k != args[0] {
+					return 0, fmt.Errorf("oneOf: mismatched kinds %s and %s", args[0], k)
+				}
+			}
+			return kindBool, nil
+		case "hasPrefix":
+			if len(args) != 2 || args[0] != kindString || args[1] != kindString {
+				return 0, fmt.Errorf("hasPrefix takes two strings")
+			}
+			return kindBool, nil
+		}
+		return 0, fmt.Errorf("unknown function %s", fn.Name)
+	}
+	return 0, fmt.Errorf("unsupported expression")
+}
+
+// eval evaluates an expression that has been checked
+func eval(e ast.Expr, vars map[string]interface{}) (interface{}, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return eval(e.X, vars)
+
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			return strconv.Unquote(e.Value)
+		}
+		i, ok := new(big.Int).SetString(e.Value, 0)
+		if !ok {
+			return nil, fmt.Errorf("parsing integer %s", e.Value)
+		}
+		return i, nil
+
+	case *ast.Ident:
+		switch e.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		if c, ok := ruleConsts[e.Name]; ok {
+			return c, nil
+		}
+		v, ok := vars[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is not set", e.Name)
+		}
+		return v, nil
+
+	case *ast.UnaryExpr:
+		x, err := eval(e.X, vars)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == token.NOT {
+			return !x.(bool), nil
+		}
+		return new(big.Int).Neg(x.(*big.Int)), nil
+
+	case *ast.BinaryExpr:
+		x, err := eval(e.X, vars)
+		if err != nil {
+			return nil, err
+		}
+		// Short-circuit the logical operators
+		switch e.Op {
+		case token.LAND:
+			if !x.(bool) {
+				return false, nil
+			}
+			return eval(e.Y, vars)
+		case token.LOR:
+			if x.(bool) {
+				return true, nil
+			}
+			return eval(e.Y, vars)
+		}
+		y, err := eval(e.Y, vars)
+		if err != nil {
+			return nil, err
+		}
+		return evalBinary(e.Op, x, y)
+
+	case *ast.CallExpr:
+		fn := e.Fun.(*ast.Ident)
+		if fn.Name == "fil" {
+			return parseFIL(e.Args[0].(*ast.BasicLit))
+		}
+		args := make([]interface{}, 0, len(e.Args))
+		for _, a := range e.Args {
+			v, err := eval(a, vars)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		if fn.Name == "hasPrefix" {
+			return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+		}
+		// oneOf
+		for _, a := range args[1:] {
+			if equal(args[0], a) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+func evalBinary(op token.Token, x, y interface{}) (interface{}, error) {
+	switch op {
+	case token.EQL:
+		return equal(x, y), nil
+	case token.NEQ:
+		return !equal(x, y), nil
+	}
+
+	if xs, ok := x.(string); ok {
+		ys := y.(string)
+		switch op {
+		case token.ADD:
+			return xs + ys, nil
+		case token.LSS:
+			return xs < ys, nil
+		case token.LEQ:
+			return xs <= ys, nil
+		case token.GTR:
+			return xs > ys, nil
+		default:
+			return xs >= ys, nil
+		}
+	}
+
+	xi, yi := x.(*big.Int), y.(*big.Int)
+	switch op {
+	case token.LSS:
+		return xi.Cmp(yi) < 0, nil
+	case token.LEQ:
+		return xi.Cmp(yi) <= 0, nil
+	case token.GTR:
+		return xi.Cmp(yi) > 0, nil
+	case token.GEQ:
+		return xi.Cmp(yi) >= 0, nil
+	case token.ADD:
+		return new(big.Int).Add(xi, yi), nil
+	case token.SUB:
+		return new(big.Int).Sub(xi, yi), nil
+	case token.MUL:
+		return new(big.Int).Mul(xi, yi), nil
+	}
+	if yi.Sign() == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if op == token.QUO {
+		return new(big.Int).Quo(xi, yi), nil
+	}
+	return new(big.Int).Rem(xi, yi), nil
+}
+
+func equal(x, y interface{}) bool {
+	if xi, ok := x.(*big.Int); ok {
+		return xi.Cmp(y.(*big.Int)) == 0
+	}
+	return x == y
+}
+
+func parseFIL(lit *ast.BasicLit) (*big.Int, error) {
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil, err
+	}
+	f, err := chaintypes.ParseFIL(s)
+	if err != nil {
+		return nil, fmt.Errorf("fil(%s): %w", lit.Value, err)
+	}
+	return f.Int, nil
+}
@@ -0,0 +1,189 @@
+package dealfilter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRules(t *testing.T) {
+	client, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	provider, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	newDeal := func(verified bool, pricePerEpoch int64) types.DealFilterParams {
+		return types.DealFilterParams{
+			DealParams: &types.DealParams{
+				ClientDealProposal: market.ClientDealProposal{
+					Proposal: market.DealProposal{
+						PieceCID:             testutil.GenerateCid(),
+						PieceSize:            abi.PaddedPieceSize(32 << 30),
+						VerifiedDeal:         verified,
+						Client:               client,
+						Provider:             provider,
+						StartEpoch:           1200,
+						EndEpoch:             1200 + 180*2880,
+						StoragePricePerEpoch: big.NewInt(pricePerEpoch),
+						ProviderCollateral:   big.NewInt(1),
+						ClientCollateral:     big.Zero(),
+					},
+				},
+				Transfer: types.Transfer{Type: "http", Size: 30 << 30},
+			},
+			ChainHead: 1000,
+		}
+	}
+
+	rules, err := CompileRules([]Rule{{
+		Name: "allowlist",
+		Expr: `oneOf(client, "f01001", "f01002")`,
+	}, {
+		Name: "price",
+		Expr: `verified || price_per_gib_epoch >= fil("0.000000000000000010")`,
+	}, {
+		Name: "size",
+		Expr: `piece_size >= 1*GiB && piece_size <= 32*GiB && !offline`,
+	}, {
+		Name: "start",
+		Expr: `start_epoch - head_epoch <= EpochsPerDay && duration >= 180*EpochsPerDay`,
+	}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	filter := RulesStorageDealFilter(rules, nil)
+
+	// 32 GiB at 320 attoFIL per epoch is 10 attoFIL per GiB per epoch
+	accept, reason, err := filter(ctx, newDeal(false, 320))
+	require.NoError(t, err)
+	require.True(t, accept, reason)
+
+	accept, reason, err = filter(ctx, newDeal(false, 319))
+	require.NoError(t, err)
+	require.False(t, accept)
+	require.Contains(t, reason, "price")
+
+	// Verified deals don't have a price floor
+	accept, _, err = filter(ctx, newDeal(true, 0))
+	require.NoError(t, err)
+	require.True(t, accept)
+
+	// The deal must start within a day
+	deal := newDeal(true, 0)
+	deal.DealParams.ClientDealProposal.Proposal.StartEpoch += 2 * 2880
+	deal.DealParams.ClientDealProposal.Proposal.EndEpoch += 2 * 2880
+	accept, reason, err = filter(ctx, deal)
+	require.NoError(t, err)
+	require.False(t, accept)
+	require.Contains(t, reason, "start")
+
+	deal = newDeal(true, 0)
+	deal.DealParams.ClientDealProposal.Proposal.Client = provider
+	accept, reason, err = filter(ctx, deal)
+	require.NoError(t, err)
+	require.False(t, accept)
+	require.Contains(t, reason, "allowlist")
+
+	// The next filter is only called for deals that satisfy the rules
+	var called int
+	next := func(ctx context.Context, deal types.DealFilterParams) (bool, string, error) {
+		called++
+		return false, "next", nil
+	}
+	filter = RulesStorageDealFilter(rules, next)
+	_, _, err = filter(ctx, deal)
+	require.NoError(t, err)
+	require.Equal(t, 0, called)
+	_, reason, err = filter(ctx, newDeal(true, 0))
+	require.NoError(t, err)
+	require.Equal(t, 1, called)
+	require.Equal(t, "next", reason)
+}
+
+func TestCompileRulesErrors(t *testing.T) {
+	for _, expr := range []string{
+		`piece_size >`,
+		`unknown_var > 1`,
+		`piece_size`,
+		`piece_size > "1"`,
+		`client + 1 == 2`,
+		`fil(1) > 0`,
+		`fil("abc") > 0`,
+		`oneOf(client)`,
+		`hasPrefix(client, 1)`,
+		`len(client) > 1`,
+		`verified < true`,
+		`1.5 > piece_size`,
+	} {
+		_, err := CompileRules([]Rule{{Name: "r", Expr: expr}})
+		require.Error(t, err, expr)
+	}
+
+	_, err := CompileRules([]Rule{{Expr: `hasPrefix(client, "f0") && (1 + 2) * 3 % 4 == 1 && -piece_size < 0`}})
+	require.NoError(t, err)
+}
+
+func TestCompilePublishedRules(t *testing.T) {
+	rules, skipped := CompilePublishedRules([]types.PublishedFilterRule{
+		{Name: "size", Expr: "piece_size <= 32*GiB"},
+		{Name: "newer", Expr: "sector_expiry > 0"},
+		{Expr: "verified +"},
+	})
+	require.Equal(t, []string{"newer", "#3"}, skipped)
+
+	ok, rule, err := rules.Eval(map[string]interface{}{"piece_size": big.NewInt(64 << 30).Int})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, "size", rule)
+}
+
+func TestRulesEvalError(t *testing.T) {
+	rules, err := CompileRules([]Rule{{Name: "div", Expr: `piece_size / (transfer_size - transfer_size) > 1`}})
+	require.NoError(t, err)
+
+	vars := StorageDealVars(types.DealFilterParams{
+		DealParams: &types.DealParams{
+			ClientDealProposal: market.ClientDealProposal{Proposal: market.DealProposal{PieceCID: testutil.GenerateCid()}},
+		},
+	})
+	_, _, err = rules.Eval(vars)
+	require.ErrorContains(t, err, "division by zero")
+}
+
+func TestPriorities(t *testing.T) {
+	client, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	ps, err := CompilePriorityRules([]PriorityRule{
+		{Rule: Rule{Name: "verified", Expr: `verified`}, Weight: 10},
+		{Rule: Rule{Name: "client", Expr: `client == "f01001"`}, Weight: 5},
+		{Rule: Rule{Name: "small", Expr: `piece_size <= 8*GiB`}, Weight: 1},
+	})
+	require.NoError(t, err)
+
+	newDeal := func(verified bool, pieceSize abi.PaddedPieceSize) *types.ProviderDealState {
+		return &types.ProviderDealState{
+			ClientDealProposal: market.ClientDealProposal{
+				Proposal: market.DealProposal{
+					PieceCID:     testutil.GenerateCid(),
+					PieceSize:    pieceSize,
+					VerifiedDeal: verified,
+					Client:       client,
+				},
+			},
+			Transfer: types.Transfer{Type: "http", Size: uint64(pieceSize)},
+		}
+	}
+	require.EqualValues(t, 16, ps.DealPriority(newDeal(true, 8<<30)))
+	require.EqualValues(t, 5, ps.DealPriority(newDeal(false, 32<<30)))
+
+	_, err = CompilePriorityRules([]PriorityRule{{Rule: Rule{Name: "size", Expr: `piece_size`}, Weight: 1}})
+	require.ErrorContains(t, err, "deal priority rule size")
+}
@@ -0,0 +1,180 @@
+package dealfilter
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+
+	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/storagemarket/types"
+)
+
+// A webhook deal filter POSTs each deal, as the same json that is sent to a
+// filter command on stdin, to a URL. The request is signed so that the
+// webhook can check that it was sent by boostd: the signature header is
+// "sha256=" followed by the hex-encoded HMAC-SHA256 of the timestamp header,
+// a ".", and the request body, keyed with the shared secret.
+//
+// The webhook responds with a 2xx status and a json body, eg
+//
+//	{"accept": false, "reason": "too busy", "retryAfter": 600}
+//
+// An empty body accepts the deal. If the deal is rejected with a retryAfter
+// (in seconds, or as a Retry-After header), the client is told when to
+// propose the deal again. A 429 or 503 response rejects the deal, and asks
+// the client to retry after the Retry-After header if there is one.
+const (
+	SignatureHeader = "X-Boost-Signature"
+	TimestampHeader = "X-Boost-Timestamp"
+
+	// DefaultWebhookTimeout is the default time to wait for the webhook to
+	// respond
+	DefaultWebhookTimeout = 10 * time.Second
+
+	// maxWebhookResponseSize is the maximum size of a webhook response body
+	maxWebhookResponseSize = 64 * 1024
+)
+
+// Webhook is the configuration of a webhook deal filter
+type Webhook struct {
+	URL string
+	// The secret that requests are signed with. If empty, requests are not
+	// signed.
+	Secret string
+	// If zero, DefaultWebhookTimeout is used
+	Timeout time.Duration
+}
+
+// WebhookResponse is the response of the webhook to a deal
+type WebhookResponse struct {
+	Accept bool `json:"accept"`
+	// The reason the deal was rejected, which is sent to the client
+	Reason string `json:"reason"`
+	// The number of seconds after which the client may propose the deal
+	// again
+	RetryAfter int64 `json:"retryAfter"`
+}
+
+func WebhookStorageDealFilter(wh Webhook) dtypes.StorageDealFilter {
+	client := &http.Client{}
+	return func(ctx context.Context, deal types.DealFilterParams) (bool, string, error) {
+		return callWebhook(ctx, client, wh, storageDealJSON(deal))
+	}
+}
+
+func WebhookRetrievalDealFilter(wh Webhook) dtypes.RetrievalDealFilter {
+	client := &http.Client{}
+	return func(ctx context.Context, deal retrievalmarket.ProviderDealState) (bool, string, error) {
+		return callWebhook(ctx, client, wh, retrievalDealJSON(deal))
+	}
+}
+
+// Sign returns the signature of a webhook request body sent at the given
+// timestamp (in unix seconds)
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp)) //nolint:errcheck
+	mac.Write([]byte("."))       //nolint:errcheck
+	mac.Write(body)              //nolint:errcheck
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func callWebhook(ctx context.Context, client *http.Client, wh Webhook, deal interface{}) (bool, string, error) {
+	body, err := json.Marshal(deal)
+	if err != nil {
+		return false, "", err
+	}
+
+	timeout := wh.Timeout
+	if timeout == 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, "filter webhook error", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", agent)
+	if wh.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(SignatureHeader, Sign(wh.Secret, ts, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, "filter webhook error", fmt.Errorf("calling deal filter webhook: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return false, "filter webhook error", fmt.Errorf("reading deal filter webhook response: %w", err)
+	}
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		return false, withRetryAfter("deal filter is busy", retryAfter), nil
+	case resp.StatusCode/100 != 2:
+		return false, "filter webhook error", fmt.Errorf("deal filter webhook responded with status %d: %s",
+			resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	if len(bytes.TrimSpace(respBody)) == 0 {
+		return true, "", nil
+	}
+	var wr WebhookResponse
+	if err := json.Unmarshal(respBody, &wr); err != nil {
+		return false, "filter webhook error", fmt.Errorf("parsing deal filter webhook response: %w", err)
+	}
+	if wr.Accept {
+		return true, "", nil
+	}
+	if wr.RetryAfter > 0 {
+		retryAfter = time.Duration(wr.RetryAfter) * time.Second
+	}
+	reason := wr.Reason
+	if reason == "" {
+		reason = "deal rejected by provider's deal filter"
+	}
+	return false, withRetryAfter(reason, retryAfter), nil
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or a date. It returns zero if the header is empty or invalid.
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseInt(h, 10, 64); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now).Round(time.Second)
+	}
+	return 0
+}
+
+func withRetryAfter(reason string, retryAfter time.Duration) string {
+	if retryAfter <= 0 {
+		return reason
+	}
+	return fmt.Sprintf("%s: retry after %s", reason, retryAfter)
+}
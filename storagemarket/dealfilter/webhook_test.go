@@ -0,0 +1,138 @@
+package dealfilter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookStorageDealFilter(t *testing.T) {
+	ctx := context.Background()
+	secret := "s3cret"
+
+	var status int
+	var header http.Header
+	var respBody string
+	var received map[string]interface{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		// Check the signature of the request
+		sig := Sign(secret, r.Header.Get(TimestampHeader), body)
+		if r.Header.Get(SignatureHeader) != sig {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, json.Unmarshal(body, &received))
+
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(respBody))
+	}))
+	defer svr.Close()
+
+	dealUuid := uuid.New()
+	deal := types.DealFilterParams{
+		DealParams: &types.DealParams{
+			DealUUID: dealUuid,
+			ClientDealProposal: market.ClientDealProposal{
+				Proposal: market.DealProposal{PieceCID: testutil.GenerateCid()},
+			},
+			Transfer: types.Transfer{Type: "http"},
+		},
+	}
+
+	filter := WebhookStorageDealFilter(Webhook{URL: svr.URL, Secret: secret, Timeout: time.Second})
+	tcs := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		accept     bool
+		reason     string
+		shouldFail bool
+	}{{
+		name:   "accepted",
+		status: http.StatusOK,
+		body:   `{"accept": true}`,
+		accept: true,
+	}, {
+		name:   "empty body accepts",
+		status: http.StatusNoContent,
+		accept: true,
+	}, {
+		name:   "rejected",
+		status: http.StatusOK,
+		body:   `{"accept": false, "reason": "client not allowed"}`,
+		reason: "client not allowed",
+	}, {
+		name:   "rejected with retry after",
+		status: http.StatusOK,
+		body:   `{"accept": false, "reason": "sealing pipeline full", "retryAfter": 600}`,
+		reason: "sealing pipeline full: retry after 10m0s",
+	}, {
+		name:   "rejected with retry after header",
+		status: http.StatusOK,
+		header: http.Header{"Retry-After": []string{"30"}},
+		body:   `{"accept": false}`,
+		reason: "deal rejected by provider's deal filter: retry after 30s",
+	}, {
+		name:   "busy",
+		status: http.StatusServiceUnavailable,
+		header: http.Header{"Retry-After": []string{"120"}},
+		reason: "deal filter is busy: retry after 2m0s",
+	}, {
+		name:       "server error",
+		status:     http.StatusInternalServerError,
+		body:       "oops",
+		shouldFail: true,
+	}, {
+		name:       "invalid response",
+		status:     http.StatusOK,
+		body:       "yes please",
+		shouldFail: true,
+	}}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			status, header, respBody = tc.status, tc.header, tc.body
+			accept, reason, err := filter(ctx, deal)
+			if tc.shouldFail {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.accept, accept)
+			require.Equal(t, tc.reason, reason)
+			require.Equal(t, "storage", received["DealType"])
+			require.Equal(t, dealUuid.String(), received["DealUUID"])
+		})
+	}
+
+	// A request with the wrong secret is rejected by the webhook
+	status, header, respBody = http.StatusOK, nil, `{"accept": true}`
+	_, _, err := WebhookStorageDealFilter(Webhook{URL: svr.URL, Secret: "wrong"})(ctx, deal)
+	require.ErrorContains(t, err, "status 401")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 11, 15, 10, 0, 0, 0, time.UTC)
+	require.Equal(t, 90*time.Second, parseRetryAfter("90", now))
+	require.Equal(t, time.Hour, parseRetryAfter(now.Add(time.Hour).Format(http.TimeFormat), now))
+	require.Zero(t, parseRetryAfter(now.Add(-time.Hour).Format(http.TimeFormat), now))
+	require.Zero(t, parseRetryAfter("-5", now))
+	require.Zero(t, parseRetryAfter("soon", now))
+	require.Zero(t, parseRetryAfter("", now))
+}
@@ -4,16 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/filecoin-project/boost/api"
 	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/lib/faults"
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemarket"
 	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
 	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/lotus/api/v1api"
 	chaintypes "github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/sigs"
@@ -23,13 +28,25 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	msmux "github.com/multiformats/go-multistream"
 )
 
 var log = logging.Logger("boost-net")
 var propLog = logging.Logger("boost-prop")
 
 const DealProtocolID = "/fil/storage/mk/1.2.0"
+
+// DealProtocolv130ID is the deal proposal protocol that supports transfer
+// options (alternate data urls and a transfer retry policy)
+const DealProtocolv130ID = "/fil/storage/mk/1.3.0"
 const DealStatusV12ProtocolID = "/fil/storage/status/1.2.0"
+const CapacityReservationProtocolID = "/fil/storage/reserve/1.0.0"
+const CapacityReservationStatusProtocolID = "/fil/storage/reserve/status/1.0.0"
+const RetrievalStatsProtocolID = "/fil/storage/retrieval-stats/1.0.0"
+const ProviderRegionProtocolID = "/fil/storage/region/1.0.0"
+const ProviderOffPeakProtocolID = "/fil/storage/offpeak/1.0.0"
+const ProviderFilterRulesProtocolID = "/fil/storage/filter-rules/1.0.0"
+const DealCancelProtocolID = "/fil/storage/cancel/1.0.0"
 const providerReadDeadline = 10 * time.Second
 const providerWriteDeadline = 10 * time.Second
 const clientReadDeadline = 10 * time.Second
@@ -48,16 +65,84 @@ func RetryParameters(minDuration time.Duration, maxDuration time.Duration, attem
 // DealClient sends deal proposals over libp2p
 type DealClient struct {
 	addr        address.Address
+	host        host.Host
 	retryStream *shared.RetryStream
 	walletApi   api.Wallet
 }
 
-// SendDealProposal sends a deal proposal over a libp2p stream to the peer
+// ProtocolNotSupportedError is returned when the provider doesn't support
+// any of the requested protocols
+type ProtocolNotSupportedError struct {
+	Peer      peer.ID
+	Requested []protocol.ID
+	// The versions of the protocol that the provider does support
+	Supported []string
+}
+
+func (e *ProtocolNotSupportedError) Error() string {
+	supported := "none"
+	if len(e.Supported) > 0 {
+		supported = strings.Join(e.Supported, ", ")
+	}
+	return fmt.Sprintf("peer %s does not support protocol %s (supported versions: %s)",
+		e.Peer, protocol.ConvertToStrings(e.Requested), supported)
+}
+
+// NewProtocolNotSupportedError creates a ProtocolNotSupportedError with the
+// versions of the requested protocols that the peer has advertised
+func NewProtocolNotSupportedError(h host.Host, id peer.ID, protos []protocol.ID) *ProtocolNotSupportedError {
+	// Find the protocol families, eg /fil/storage/mk/1.2.0 => /fil/storage/mk/
+	var families []string
+	for _, p := range protos {
+		families = append(families, string(p)[:strings.LastIndex(string(p), "/")+1])
+	}
+
+	var supported []string
+	peerProtos, err := h.Peerstore().GetProtocols(id)
+	if err != nil {
+		log.Debugw("getting protocols from peer store", "peer", id, "err", err)
+	}
+	for _, p := range peerProtos {
+		for _, family := range families {
+			if strings.HasPrefix(p, family) {
+				supported = append(supported, p)
+				break
+			}
+		}
+	}
+	sort.Strings(supported)
+
+	return &ProtocolNotSupportedError{Peer: id, Requested: protos, Supported: supported}
+}
+
+// openStream opens a stream to the peer, retrying on failure. If the peer
+// doesn't support the protocol it fails immediately with a
+// ProtocolNotSupportedError, as retrying won't help.
+func (c *DealClient) openStream(ctx context.Context, id peer.ID, protos []protocol.ID) (network.Stream, error) {
+	s, err := c.host.NewStream(ctx, id, protos...)
+	if err == nil {
+		return s, nil
+	}
+	if errors.Is(err, msmux.ErrNotSupported) {
+		return nil, NewProtocolNotSupportedError(c.host, id, protos)
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	log.Debugw("failed to open stream, retrying", "peer", id, "protocols", protos, "err", err)
+	return c.retryStream.OpenStream(ctx, id, protos)
+}
+
+// SendDealProposal sends a deal proposal over a libp2p stream to the peer.
+// If the proposal may have reached the peer but no response was received,
+// it returns a ProposalOutcomeUnknownError.
 func (c *DealClient) SendDealProposal(ctx context.Context, id peer.ID, params types.DealParams) (*types.DealResponse, error) {
 	log.Debugw("send deal proposal", "id", params.DealUUID, "provider-peer", id)
 
-	// Create a libp2p stream to the provider
-	s, err := c.retryStream.OpenStream(ctx, id, []protocol.ID{DealProtocolID})
+	// Create a libp2p stream to the provider. Transfer options are only
+	// understood by providers that support v1.3 of the protocol.
+	s, err := c.openStream(ctx, id, DealProtocols(params))
 	if err != nil {
 		return nil, err
 	}
@@ -68,9 +153,10 @@ func (c *DealClient) SendDealProposal(ctx context.Context, id peer.ID, params ty
 	_ = s.SetWriteDeadline(time.Now().Add(clientWriteDeadline))
 	defer s.SetWriteDeadline(time.Time{}) // nolint
 
-	// Write the deal proposal to the stream
+	// Write the deal proposal to the stream. Once any of it has been
+	// written, the provider may receive the proposal even if the write fails.
 	if err = cborutil.WriteCborRPC(s, &params); err != nil {
-		return nil, fmt.Errorf("sending deal proposal: %w", err)
+		return nil, &types.ProposalOutcomeUnknownError{DealUUID: params.DealUUID, Err: fmt.Errorf("sending deal proposal: %w", err)}
 	}
 
 	// Set a deadline on reading from the stream so it doesn't hang
@@ -80,7 +166,7 @@ func (c *DealClient) SendDealProposal(ctx context.Context, id peer.ID, params ty
 	// Read the response from the stream
 	var resp types.DealResponse
 	if err := resp.UnmarshalCBOR(s); err != nil {
-		return nil, fmt.Errorf("reading proposal response: %w", err)
+		return nil, &types.ProposalOutcomeUnknownError{DealUUID: params.DealUUID, Err: fmt.Errorf("reading proposal response: %w", err)}
 	}
 
 	log.Debugw("received deal proposal response", "id", params.DealUUID, "accepted", resp.Accepted, "reason", resp.Message)
@@ -88,6 +174,15 @@ func (c *DealClient) SendDealProposal(ctx context.Context, id peer.ID, params ty
 	return &resp, nil
 }
 
+// DealProtocols returns the deal proposal protocols that the deal can be
+// sent with, in order of preference
+func DealProtocols(params types.DealParams) []protocol.ID {
+	if params.HasTransferOptions() {
+		return []protocol.ID{DealProtocolv130ID}
+	}
+	return []protocol.ID{DealProtocolv130ID, DealProtocolID}
+}
+
 func (c *DealClient) SendDealStatusRequest(ctx context.Context, id peer.ID, dealUUID uuid.UUID) (*types.DealStatusResponse, error) {
 	log.Debugw("send deal status req", "deal-uuid", dealUUID, "id", id)
 
@@ -102,7 +197,7 @@ func (c *DealClient) SendDealStatusRequest(ctx context.Context, id peer.ID, deal
 	}
 
 	// Create a libp2p stream to the provider
-	s, err := c.retryStream.OpenStream(ctx, id, []protocol.ID{DealStatusV12ProtocolID})
+	s, err := c.openStream(ctx, id, []protocol.ID{DealStatusV12ProtocolID})
 	if err != nil {
 		return nil, err
 	}
@@ -134,9 +229,271 @@ func (c *DealClient) SendDealStatusRequest(ctx context.Context, id peer.ID, deal
 	return &resp, nil
 }
 
+// SendDealCancelRequest asks the peer to cancel a deal whose data has not
+// been transferred yet. The request is signed with the client's wallet.
+func (c *DealClient) SendDealCancelRequest(ctx context.Context, id peer.ID, dealUUID uuid.UUID) (*types.DealCancelResponse, error) {
+	log.Debugw("send deal cancel req", "deal-uuid", dealUUID, "id", id)
+
+	cancel := types.DealCancel{DealUUID: dealUUID}
+	sigBytes, err := cancel.SignatureBytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := c.walletApi.WalletSign(ctx, c.addr, sigBytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing deal cancel: %w", err)
+	}
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{DealCancelProtocolID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(clientWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	req := types.DealCancelRequest{Cancel: cancel, Signature: *sig}
+	if err = cborutil.WriteCborRPC(s, &req); err != nil {
+		return nil, fmt.Errorf("sending deal cancel req: %w", err)
+	}
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.DealCancelResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return nil, fmt.Errorf("reading deal cancel response: %w", err)
+	}
+
+	log.Debugw("received deal cancel response", "id", dealUUID, "cancelled", resp.Cancelled, "message", resp.Message)
+
+	return &resp, nil
+}
+
+// SendCapacityReservation sends a request to reserve capacity to the peer.
+// The reservation is signed with the client's wallet.
+func (c *DealClient) SendCapacityReservation(ctx context.Context, id peer.ID, res types.CapacityReservation) (*types.CapacityReservationResponse, error) {
+	log.Debugw("send capacity reservation", "id", res.ID, "provider-peer", id)
+
+	sigBytes, err := res.SignatureBytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := c.walletApi.WalletSign(ctx, res.Client, sigBytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing capacity reservation: %w", err)
+	}
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{CapacityReservationProtocolID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(clientWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	req := types.CapacityReservationRequest{Reservation: res, Signature: *sig}
+	if err = cborutil.WriteCborRPC(s, &req); err != nil {
+		return nil, fmt.Errorf("sending capacity reservation: %w", err)
+	}
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.CapacityReservationResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return nil, fmt.Errorf("reading capacity reservation response: %w", err)
+	}
+
+	log.Debugw("received capacity reservation response", "id", res.ID, "accepted", resp.Accepted, "reason", resp.Message)
+
+	return &resp, nil
+}
+
+// SendCapacityReservationStatusRequest gets the current state of a capacity
+// reservation from the peer
+func (c *DealClient) SendCapacityReservationStatusRequest(ctx context.Context, id peer.ID, reservationID uuid.UUID) (*types.CapacityReservationStatusResponse, error) {
+	log.Debugw("send capacity reservation status req", "id", reservationID, "provider-peer", id)
+
+	uuidBytes, err := reservationID.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("getting uuid bytes: %w", err)
+	}
+
+	sig, err := c.walletApi.WalletSign(ctx, c.addr, uuidBytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing uuid bytes: %w", err)
+	}
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{CapacityReservationStatusProtocolID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(clientWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	req := types.CapacityReservationStatusRequest{ReservationID: reservationID, Signature: *sig}
+	if err = cborutil.WriteCborRPC(s, &req); err != nil {
+		return nil, fmt.Errorf("sending capacity reservation status req: %w", err)
+	}
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.CapacityReservationStatusResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return nil, fmt.Errorf("reading capacity reservation status response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// SendRetrievalStatsRequest gets the retrieval statistics of the client's
+// pieces from the peer. The query is signed with the client's wallet.
+func (c *DealClient) SendRetrievalStatsRequest(ctx context.Context, id peer.ID, provider address.Address) (*types.RetrievalStatsResponse, error) {
+	log.Debugw("send retrieval stats req", "provider", provider, "provider-peer", id)
+
+	q := types.RetrievalStatsQuery{Client: c.addr, Provider: provider, Timestamp: time.Now().Unix()}
+	sigBytes, err := q.SignatureBytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := c.walletApi.WalletSign(ctx, c.addr, sigBytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing retrieval stats query: %w", err)
+	}
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{RetrievalStatsProtocolID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(clientWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	req := types.RetrievalStatsRequest{Query: q, Signature: *sig}
+	if err = cborutil.WriteCborRPC(s, &req); err != nil {
+		return nil, fmt.Errorf("sending retrieval stats req: %w", err)
+	}
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.RetrievalStatsResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return nil, fmt.Errorf("reading retrieval stats response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// SendProviderRegionRequest gets the region that the provider declares that
+// it stores data in. The region is empty if the provider doesn't declare one.
+func (c *DealClient) SendProviderRegionRequest(ctx context.Context, id peer.ID) (string, error) {
+	log.Debugw("send provider region req", "provider-peer", id)
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{ProviderRegionProtocolID})
+	if err != nil {
+		return "", err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.ProviderRegionResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return "", fmt.Errorf("reading provider region response: %w", err)
+	}
+
+	return resp.Region, nil
+}
+
+// SendProviderOffPeakRequest gets the off-peak windows in which the provider
+// prefers to receive deal data. There are no windows if the provider
+// doesn't announce any.
+func (c *DealClient) SendProviderOffPeakRequest(ctx context.Context, id peer.ID) ([]types.OffPeakWindow, error) {
+	log.Debugw("send provider off-peak windows req", "provider-peer", id)
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{ProviderOffPeakProtocolID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.ProviderOffPeakResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return nil, fmt.Errorf("reading provider off-peak windows response: %w", err)
+	}
+	for i, w := range resp.Windows {
+		if err := w.Validate(); err != nil {
+			return nil, fmt.Errorf("provider announced invalid off-peak window %d: %w", i, err)
+		}
+	}
+
+	return resp.Windows, nil
+}
+
+// SendProviderFilterRulesRequest gets the deal filter rules that the
+// provider publishes. There are no rules if the provider doesn't publish
+// them.
+func (c *DealClient) SendProviderFilterRulesRequest(ctx context.Context, id peer.ID) ([]types.PublishedFilterRule, error) {
+	log.Debugw("send provider filter rules req", "provider-peer", id)
+
+	// Create a libp2p stream to the provider
+	s, err := c.openStream(ctx, id, []protocol.ID{ProviderFilterRulesProtocolID})
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close() // nolint
+
+	// Set a deadline on reading from the stream so it doesn't hang
+	_ = s.SetReadDeadline(time.Now().Add(clientReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var resp types.ProviderFilterRulesResponse
+	if err := resp.UnmarshalCBOR(s); err != nil {
+		return nil, fmt.Errorf("reading provider filter rules response: %w", err)
+	}
+
+	return resp.Rules, nil
+}
+
 func NewDealClient(h host.Host, addr address.Address, walletApi api.Wallet, options ...DealClientOption) *DealClient {
 	c := &DealClient{
 		addr:        addr,
+		host:        h,
 		retryStream: shared.NewRetryStream(h),
 		walletApi:   walletApi,
 	}
@@ -154,9 +511,20 @@ type DealProvider struct {
 	fullNode v1api.FullNode
 	plDB     *db.ProposalLogsDB
 	spApi    sealingpipeline.API
+	faults   *faults.Injector
 }
 
-func NewDealProvider(h host.Host, prov *storagemarket.Provider, fullNodeApi v1api.FullNode, plDB *db.ProposalLogsDB, spApi sealingpipeline.API) *DealProvider {
+// DealProviderOption is an option for configuring the libp2p storage deal provider
+type DealProviderOption func(*DealProvider)
+
+// WithFaultInjector injects failures into the provider's responses (for testing)
+func WithFaultInjector(inj *faults.Injector) DealProviderOption {
+	return func(p *DealProvider) {
+		p.faults = inj
+	}
+}
+
+func NewDealProvider(h host.Host, prov *storagemarket.Provider, fullNodeApi v1api.FullNode, plDB *db.ProposalLogsDB, spApi sealingpipeline.API, options ...DealProviderOption) *DealProvider {
 	p := &DealProvider{
 		host:     h,
 		prov:     prov,
@@ -164,18 +532,37 @@ func NewDealProvider(h host.Host, prov *storagemarket.Provider, fullNodeApi v1ap
 		plDB:     plDB,
 		spApi:    spApi,
 	}
+	for _, option := range options {
+		option(p)
+	}
 	return p
 }
 
 func (p *DealProvider) Start(ctx context.Context) {
 	p.ctx = ctx
 	p.host.SetStreamHandler(DealProtocolID, p.handleNewDealStream)
+	p.host.SetStreamHandler(DealProtocolv130ID, p.handleNewDealStream)
 	p.host.SetStreamHandler(DealStatusV12ProtocolID, p.handleNewDealStatusStream)
+	p.host.SetStreamHandler(CapacityReservationProtocolID, p.handleCapacityReservationStream)
+	p.host.SetStreamHandler(CapacityReservationStatusProtocolID, p.handleCapacityReservationStatusStream)
+	p.host.SetStreamHandler(RetrievalStatsProtocolID, p.handleRetrievalStatsStream)
+	p.host.SetStreamHandler(ProviderRegionProtocolID, p.handleProviderRegionStream)
+	p.host.SetStreamHandler(ProviderOffPeakProtocolID, p.handleProviderOffPeakStream)
+	p.host.SetStreamHandler(ProviderFilterRulesProtocolID, p.handleProviderFilterRulesStream)
+	p.host.SetStreamHandler(DealCancelProtocolID, p.handleDealCancelStream)
 }
 
 func (p *DealProvider) Stop() {
 	p.host.RemoveStreamHandler(DealProtocolID)
+	p.host.RemoveStreamHandler(DealProtocolv130ID)
 	p.host.RemoveStreamHandler(DealStatusV12ProtocolID)
+	p.host.RemoveStreamHandler(CapacityReservationProtocolID)
+	p.host.RemoveStreamHandler(CapacityReservationStatusProtocolID)
+	p.host.RemoveStreamHandler(RetrievalStatsProtocolID)
+	p.host.RemoveStreamHandler(ProviderRegionProtocolID)
+	p.host.RemoveStreamHandler(ProviderOffPeakProtocolID)
+	p.host.RemoveStreamHandler(ProviderFilterRulesProtocolID)
+	p.host.RemoveStreamHandler(DealCancelProtocolID)
 }
 
 // Called when the client opens a libp2p stream with a new deal proposal
@@ -226,6 +613,8 @@ func (p *DealProvider) handleNewDealStream(s network.Stream) {
 	)
 	_ = p.plDB.InsertLog(p.ctx, proposal, res.Accepted, res.Reason) //nolint:errcheck
 
+	p.faults.Delay(p.ctx)
+
 	// Write the response to the client
 	err = cborutil.WriteCborRPC(s, &types.DealResponse{Accepted: res.Accepted, Message: res.Reason})
 	if err != nil {
@@ -249,6 +638,7 @@ func (p *DealProvider) handleNewDealStatusStream(s network.Stream) {
 	log.Debugw("received deal status request", "id", req.DealUUID, "client-peer", s.Conn().RemotePeer())
 
 	resp := p.getDealStatus(req)
+	p.faults.Delay(p.ctx)
 
 	// Set a deadline on writing to the stream so it doesn't hang
 	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
@@ -267,7 +657,7 @@ func (p *DealProvider) getDealStatus(req types.DealStatusRequest) types.DealStat
 
 	pds, err := p.prov.Deal(p.ctx, req.DealUUID)
 	if err != nil && errors.Is(err, storagemarket.ErrDealNotFound) {
-		return errResp(fmt.Sprintf("no storage deal found with deal UUID %s", req.DealUUID))
+		return errResp(fmt.Sprintf("%s with deal UUID %s", types.DealStatusNotFound, req.DealUUID))
 	}
 
 	if err != nil {
@@ -324,5 +714,303 @@ func (p *DealProvider) getDealStatus(req types.DealStatusRequest) types.DealStat
 		IsOffline:      pds.IsOffline,
 		TransferSize:   pds.Transfer.Size,
 		NBytesReceived: bts,
+		TransferOrigin: pds.TransferOrigin,
+	}
+}
+
+// Called when the client opens a libp2p stream to cancel a deal
+func (p *DealProvider) handleDealCancelStream(s network.Stream) {
+	defer s.Close()
+
+	_ = s.SetReadDeadline(time.Now().Add(providerReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var req types.DealCancelRequest
+	if err := req.UnmarshalCBOR(s); err != nil {
+		log.Warnw("reading deal cancel request from stream", "err", err)
+		return
+	}
+	log.Debugw("received deal cancel request", "id", req.Cancel.DealUUID, "client-peer", s.Conn().RemotePeer())
+
+	resp := p.cancelDeal(req)
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Errorw("failed to write deal cancel response", "err", err)
+		return
+	}
+}
+
+// cancelDeal cancels the data transfer of a deal, which fails the deal. A
+// deal whose data has been transferred is not cancelled.
+func (p *DealProvider) cancelDeal(req types.DealCancelRequest) types.DealCancelResponse {
+	dealUUID := req.Cancel.DealUUID
+	notCancelled := func(msg string) types.DealCancelResponse {
+		return types.DealCancelResponse{Message: msg}
+	}
+
+	pds, err := p.prov.Deal(p.ctx, dealUUID)
+	if err != nil && errors.Is(err, storagemarket.ErrDealNotFound) {
+		return notCancelled(fmt.Sprintf("%s with deal UUID %s", types.DealStatusNotFound, dealUUID))
+	}
+	if err != nil {
+		log.Errorw("failed to fetch deal", "id", dealUUID, "err", err)
+		return notCancelled("failed to fetch deal")
+	}
+
+	sigBytes, err := req.Cancel.SignatureBytes()
+	if err != nil {
+		log.Errorw("failed to serialize deal cancel", "err", err)
+		return notCancelled("failed to serialize deal cancel")
+	}
+	if msg := p.verifyClientSignature(pds.ClientDealProposal.Proposal.Client, &req.Signature, sigBytes); msg != "" {
+		return notCancelled(msg)
+	}
+
+	if pds.Err != "" {
+		// the deal has already failed
+		return types.DealCancelResponse{Cancelled: true}
+	}
+	if pds.IsOffline {
+		return notCancelled("offline deals can't be cancelled")
+	}
+	if pds.Checkpoint >= dealcheckpoints.Transferred {
+		return notCancelled("the deal's data has already been transferred")
+	}
+
+	// Cancelling the transfer fails the deal. It returns an error if the
+	// transfer has already completed.
+	if err := p.prov.CancelDealDataTransfer(dealUUID); err != nil {
+		log.Infow("could not cancel deal at client's request", "id", dealUUID, "err", err)
+		return notCancelled(fmt.Sprintf("could not cancel the deal's data transfer: %s", err))
+	}
+	log.Infow("cancelled deal at client's request", "id", dealUUID)
+	return types.DealCancelResponse{Cancelled: true}
+}
+
+// Called when the client opens a libp2p stream with a capacity reservation
+// request
+func (p *DealProvider) handleCapacityReservationStream(s network.Stream) {
+	defer s.Close()
+
+	_ = s.SetReadDeadline(time.Now().Add(providerReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var req types.CapacityReservationRequest
+	if err := req.UnmarshalCBOR(s); err != nil {
+		log.Warnw("reading capacity reservation request from stream", "err", err)
+		return
+	}
+	res := req.Reservation
+	log.Infow("received capacity reservation request", "id", res.ID, "client", res.Client, "client-peer", s.Conn().RemotePeer())
+
+	resp := p.reserveCapacity(req)
+	p.faults.Delay(p.ctx)
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Warnw("writing capacity reservation response", "id", res.ID, "err", err)
+		// The client doesn't know that the reservation was accepted, so
+		// release the capacity instead of holding it for the client
+		if resp.Accepted {
+			if err := p.prov.ReleaseCapacity(p.ctx, res.ID); err != nil {
+				log.Errorw("failed to release capacity reservation", "id", res.ID, "err", err)
+			}
+		}
+		return
+	}
+}
+
+func (p *DealProvider) reserveCapacity(req types.CapacityReservationRequest) types.CapacityReservationResponse {
+	res := req.Reservation
+	sigBytes, err := res.SignatureBytes()
+	if err != nil {
+		log.Errorw("failed to serialize capacity reservation", "id", res.ID, "err", err)
+		return types.CapacityReservationResponse{Message: "server error: serialize reservation"}
+	}
+	if msg := p.verifyClientSignature(res.Client, &req.Signature, sigBytes); msg != "" {
+		return types.CapacityReservationResponse{Message: msg}
+	}
+
+	resp, err := p.prov.ReserveCapacity(p.ctx, res)
+	if err != nil {
+		log.Errorw("failed to reserve capacity", "id", res.ID, "err", err)
+		return types.CapacityReservationResponse{Message: "server error: reserve capacity"}
+	}
+	return *resp
+}
+
+func (p *DealProvider) handleCapacityReservationStatusStream(s network.Stream) {
+	defer s.Close()
+
+	_ = s.SetReadDeadline(time.Now().Add(providerReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var req types.CapacityReservationStatusRequest
+	if err := req.UnmarshalCBOR(s); err != nil {
+		log.Warnw("reading capacity reservation status request from stream", "err", err)
+		return
+	}
+	log.Debugw("received capacity reservation status request", "id", req.ReservationID, "client-peer", s.Conn().RemotePeer())
+
+	resp := p.getCapacityReservationStatus(req)
+	p.faults.Delay(p.ctx)
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Errorw("failed to write capacity reservation status response", "err", err)
+		return
+	}
+}
+
+func (p *DealProvider) getCapacityReservationStatus(req types.CapacityReservationStatusRequest) types.CapacityReservationStatusResponse {
+	errResp := func(err string) types.CapacityReservationStatusResponse {
+		return types.CapacityReservationStatusResponse{ReservationID: req.ReservationID, Error: err}
+	}
+
+	st, err := p.prov.CapacityReservation(p.ctx, req.ReservationID)
+	if err != nil && errors.Is(err, storagemarket.ErrCapacityReservationNotFound) {
+		return errResp(fmt.Sprintf("no capacity reservation found with id %s", req.ReservationID))
+	}
+	if err != nil {
+		log.Errorw("failed to fetch capacity reservation status", "err", err)
+		return errResp("failed to fetch capacity reservation status")
+	}
+
+	uuidBytes, err := req.ReservationID.MarshalBinary()
+	if err != nil {
+		log.Errorw("failed to serialize request reservation id", "err", err)
+		return errResp("failed to serialize request reservation id")
+	}
+	if msg := p.verifyClientSignature(st.Reservation.Client, &req.Signature, uuidBytes); msg != "" {
+		return errResp(msg)
+	}
+
+	return types.CapacityReservationStatusResponse{ReservationID: req.ReservationID, Status: st}
+}
+
+// Called when a client opens a libp2p stream to get the retrieval statistics
+// of its pieces
+func (p *DealProvider) handleRetrievalStatsStream(s network.Stream) {
+	defer s.Close()
+
+	_ = s.SetReadDeadline(time.Now().Add(providerReadDeadline))
+	defer s.SetReadDeadline(time.Time{}) // nolint
+
+	var req types.RetrievalStatsRequest
+	if err := req.UnmarshalCBOR(s); err != nil {
+		log.Warnw("reading retrieval stats request from stream", "err", err)
+		return
+	}
+	log.Debugw("received retrieval stats request", "client", req.Query.Client, "client-peer", s.Conn().RemotePeer())
+
+	resp := p.getRetrievalStats(req)
+	p.faults.Delay(p.ctx)
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Errorw("failed to write retrieval stats response", "err", err)
+		return
+	}
+}
+
+func (p *DealProvider) getRetrievalStats(req types.RetrievalStatsRequest) types.RetrievalStatsResponse {
+	q := req.Query
+	sigBytes, err := q.SignatureBytes()
+	if err != nil {
+		log.Errorw("failed to serialize retrieval stats query", "client", q.Client, "err", err)
+		return types.RetrievalStatsResponse{Error: "server error: serialize query"}
+	}
+	if msg := p.verifyClientSignature(q.Client, &req.Signature, sigBytes); msg != "" {
+		return types.RetrievalStatsResponse{Error: msg}
+	}
+
+	resp, err := p.prov.RetrievalStats(p.ctx, q)
+	if err != nil {
+		log.Errorw("failed to get retrieval stats", "client", q.Client, "err", err)
+		return types.RetrievalStatsResponse{Error: "server error: get retrieval stats"}
+	}
+	return *resp
+}
+
+// Called when a client opens a libp2p stream to get the region that the
+// provider declares that it stores data in
+func (p *DealProvider) handleProviderRegionStream(s network.Stream) {
+	defer s.Close()
+
+	log.Debugw("received provider region request", "client-peer", s.Conn().RemotePeer())
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	resp := types.ProviderRegionResponse{Region: p.prov.Region()}
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Errorw("failed to write provider region response", "err", err)
+		return
+	}
+}
+
+// Called when a client opens a libp2p stream to get the off-peak windows in
+// which the provider prefers to receive deal data
+func (p *DealProvider) handleProviderOffPeakStream(s network.Stream) {
+	defer s.Close()
+
+	log.Debugw("received provider off-peak windows request", "client-peer", s.Conn().RemotePeer())
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	resp := types.ProviderOffPeakResponse{Windows: p.prov.OffPeakWindows()}
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Errorw("failed to write provider off-peak windows response", "err", err)
+		return
+	}
+}
+
+// Called when a client opens a libp2p stream to get the deal filter rules
+// that the provider publishes
+func (p *DealProvider) handleProviderFilterRulesStream(s network.Stream) {
+	defer s.Close()
+
+	log.Debugw("received provider filter rules request", "client-peer", s.Conn().RemotePeer())
+
+	// Set a deadline on writing to the stream so it doesn't hang
+	_ = s.SetWriteDeadline(time.Now().Add(providerWriteDeadline))
+	defer s.SetWriteDeadline(time.Time{}) // nolint
+
+	resp := types.ProviderFilterRulesResponse{Rules: p.prov.PublishedFilterRules()}
+	if err := cborutil.WriteCborRPC(s, &resp); err != nil {
+		log.Errorw("failed to write provider filter rules response", "err", err)
+		return
+	}
+}
+
+// verifyClientSignature verifies that the message was signed by the client.
+// It returns the reason for failure, or an empty string on success.
+func (p *DealProvider) verifyClientSignature(client address.Address, sig *crypto.Signature, msg []byte) string {
+	addr, err := p.fullNode.StateAccountKey(p.ctx, client, chaintypes.EmptyTSK)
+	if err != nil {
+		log.Errorw("failed to get account key for client addr", "client", client.String(), "err", err)
+		return fmt.Sprintf("failed to get account key for client addr %s", client.String())
+	}
+
+	if err := sigs.Verify(sig, addr, msg); err != nil {
+		log.Warnw("signature verification failed", "client", client.String(), "err", err)
+		return "signature verification failed"
 	}
+	return ""
 }
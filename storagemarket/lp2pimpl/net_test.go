@@ -0,0 +1,76 @@
+package lp2pimpl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendDealProposalProtocolNotSupported(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New()
+	t.Cleanup(func() { _ = mn.Close() })
+	client, err := mn.GenPeer()
+	require.NoError(t, err)
+	prov, err := mn.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, mn.LinkAll())
+
+	// The provider only supports older versions of the deal protocol, and
+	// an unrelated protocol
+	handler := func(s network.Stream) { _ = s.Close() }
+	prov.SetStreamHandler("/fil/storage/mk/1.1.0", handler)
+	prov.SetStreamHandler("/fil/storage/mk/1.0.1", handler)
+	prov.SetStreamHandler("/fil/retrieval/qry/1.0.0", handler)
+	require.NoError(t, client.Connect(ctx, peer.AddrInfo{ID: prov.ID(), Addrs: prov.Addrs()}))
+
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	// Retrying would take much longer than the test timeout
+	dc := NewDealClient(client, addr, nil, RetryParameters(time.Minute, time.Hour, 10, 2))
+
+	start := time.Now()
+	_, err = dc.SendDealProposal(ctx, prov.ID(), types.DealParams{DealUUID: uuid.New()})
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	var pnsErr *ProtocolNotSupportedError
+	require.True(t, errors.As(err, &pnsErr))
+	require.Equal(t, prov.ID(), pnsErr.Peer)
+	require.Equal(t, []protocol.ID{DealProtocolv130ID, DealProtocolID}, pnsErr.Requested)
+	require.Equal(t, []string{"/fil/storage/mk/1.0.1", "/fil/storage/mk/1.1.0"}, pnsErr.Supported)
+	require.Contains(t, err.Error(), "supported versions: /fil/storage/mk/1.0.1, /fil/storage/mk/1.1.0")
+}
+
+func TestNewProtocolNotSupportedError(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { _ = mn.Close() })
+	h, err := mn.GenPeer()
+	require.NoError(t, err)
+	other, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	// Nothing is known about the peer's protocols
+	perr := NewProtocolNotSupportedError(h, other.ID(), []protocol.ID{DealProtocolID})
+	require.Empty(t, perr.Supported)
+	require.Contains(t, perr.Error(), "supported versions: none")
+
+	// Only versions of the requested protocols are listed
+	require.NoError(t, h.Peerstore().AddProtocols(other.ID(), "/fil/storage/status/1.1.0", "/fil/storage/mk/1.1.0", "/ipfs/id/1.0.0"))
+	perr = NewProtocolNotSupportedError(h, other.ID(), []protocol.ID{DealStatusV12ProtocolID})
+	require.Equal(t, []string{"/fil/storage/status/1.1.0"}, perr.Supported)
+	perr = NewProtocolNotSupportedError(h, other.ID(), []protocol.ID{DealProtocolID, DealStatusV12ProtocolID})
+	require.Equal(t, []string{"/fil/storage/mk/1.1.0", "/fil/storage/status/1.1.0"}, perr.Supported)
+}
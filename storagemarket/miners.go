@@ -0,0 +1,82 @@
+package storagemarket
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/boost/node/modules/dtypes"
+	"github.com/filecoin-project/boost/sealingpipeline"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	smtypes "github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+)
+
+// Miner is a miner that the provider makes deals for. Each miner has its own
+// sealing pipeline, pricing and deal filter. The deals of all the miners
+// share the provider's libp2p host, publish message wallet and staging area.
+type Miner struct {
+	Address         address.Address
+	PieceAdder      types.PieceAdder
+	CommpCalc       smtypes.CommpCalculator
+	SealingPipeline sealingpipeline.API
+	DealFilter      dtypes.StorageDealFilter
+	AskGetter       types.AskGetter
+	// Each miner has its own deal publisher, as a publish message can only
+	// hold the deals of one miner
+	DealPublisher types.DealPublisher
+	// The piece store that the sectors of the miner's deals are recorded
+	// in. Sector numbers are only unique per miner, so each miner has its
+	// own piece store.
+	PieceStore piecestore.PieceStore
+}
+
+// AddMiner adds a miner that the provider makes deals for, in addition to
+// the miner that the provider was created with. It must be called before
+// the provider is started.
+func (p *Provider) AddMiner(m *Miner) error {
+	p.minersLk.Lock()
+	defer p.minersLk.Unlock()
+
+	if _, ok := p.miners[m.Address]; ok {
+		return fmt.Errorf("miner %s has already been added", m.Address)
+	}
+	p.miners[m.Address] = m
+	return nil
+}
+
+// Miners returns the addresses of the miners that the provider makes deals
+// for, starting with the miner that the provider was created with
+func (p *Provider) Miners() []address.Address {
+	p.minersLk.RLock()
+	defer p.minersLk.RUnlock()
+
+	addrs := make([]address.Address, 0, len(p.miners))
+	for maddr := range p.miners {
+		if maddr != p.Address {
+			addrs = append(addrs, maddr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].String() < addrs[j].String()
+	})
+	return append([]address.Address{p.Address}, addrs...)
+}
+
+// miner returns the miner with the given address, or an error if the
+// provider doesn't make deals for the miner
+func (p *Provider) miner(maddr address.Address) (*Miner, error) {
+	p.minersLk.RLock()
+	defer p.minersLk.RUnlock()
+
+	m, ok := p.miners[maddr]
+	if !ok {
+		return nil, fmt.Errorf("provider does not make deals for miner %s", maddr)
+	}
+	return m, nil
+}
+
+func (p *Provider) hasMiner(maddr address.Address) bool {
+	_, err := p.miner(maddr)
+	return err == nil
+}
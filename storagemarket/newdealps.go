@@ -34,3 +34,31 @@ func (m *newDealPS) subscribe() (event.Subscription, error) {
 	}
 	return sub, nil
 }
+
+// dealUpdatesPS publishes the state updates of all deals, so that
+// subscribers don't need to subscribe to each deal individually
+type dealUpdatesPS struct {
+	dealUpdatesBus event.Bus
+	DealUpdates    event.Emitter
+}
+
+func newDealUpdatesPubsub() (*dealUpdatesPS, error) {
+	bus := eventbus.NewBus()
+	emitter, err := bus.Emitter(&types.ProviderDealState{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event emitter: %w", err)
+	}
+
+	return &dealUpdatesPS{
+		dealUpdatesBus: bus,
+		DealUpdates:    emitter,
+	}, nil
+}
+
+func (m *dealUpdatesPS) subscribe() (event.Subscription, error) {
+	sub, err := m.dealUpdatesBus.Subscribe(new(types.ProviderDealState), eventbus.BufSize(256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subscriber to deal updates: %w", err)
+	}
+	return sub, nil
+}
@@ -0,0 +1,401 @@
+package storagemarket
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/api"
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/storagemarket/types"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/google/uuid"
+)
+
+// uploadFileExt is the extension of the files in the staging area that hold
+// offline deal data uploaded over the API
+const uploadFileExt = ".upload"
+
+// uploadTransferHost is the host that the staging area space for uploads is
+// tagged with: uploads share the per-host limit as if they were downloads
+// from a single host
+const uploadTransferHost = "offline-upload"
+
+// An upload for a deal whose data has not been imported, to which no chunk
+// has been written for uploadAbandonedAfter, is removed as abandoned. Upload
+// files are checked every uploadCleanupInterval.
+var (
+	uploadAbandonedAfter  = 24 * time.Hour
+	uploadCleanupInterval = time.Hour
+)
+
+// offlineUploads writes the data for offline deals that is uploaded over the
+// API to files in the staging area. An upload is made in chunks, each of
+// which is appended to the file, so that an interrupted upload can be
+// resumed from the number of bytes received so far.
+type offlineUploads struct {
+	dir string
+
+	lk sync.Mutex
+	// The deals for which a chunk is being written
+	active map[uuid.UUID]struct{}
+}
+
+func newOfflineUploads(dir string) *offlineUploads {
+	return &offlineUploads{dir: dir, active: make(map[uuid.UUID]struct{})}
+}
+
+func (u *offlineUploads) path(dealUuid uuid.UUID) string {
+	return filepath.Join(u.dir, dealUuid.String()+uploadFileExt)
+}
+
+// isUpload returns true if the file at path holds uploaded data
+func (u *offlineUploads) isUpload(path string) bool {
+	return path != "" && filepath.Dir(path) == filepath.Clean(u.dir) && strings.HasSuffix(path, uploadFileExt)
+}
+
+// received returns the number of bytes received for the deal so far
+func (u *offlineUploads) received(dealUuid uuid.UUID) (uint64, error) {
+	fi, err := os.Stat(u.path(dealUuid))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return uint64(fi.Size()), nil
+}
+
+// uploadFile is an upload file in the staging area
+type uploadFile struct {
+	dealUuid uuid.UUID
+	path     string
+	modTime  time.Time
+}
+
+// list returns the upload files in the staging area
+func (u *offlineUploads) list() ([]uploadFile, error) {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []uploadFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, uploadFileExt) {
+			continue
+		}
+		dealUuid, err := uuid.Parse(strings.TrimSuffix(name, uploadFileExt))
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, uploadFile{dealUuid: dealUuid, path: filepath.Join(u.dir, name), modTime: fi.ModTime()})
+	}
+	return files, nil
+}
+
+// write appends the chunk read from r to the deal's upload file, and returns
+// the number of bytes received for the deal. The offset must be the number
+// of bytes received before the chunk, so that a chunk that is sent twice (or
+// a chunk that is sent after a missing chunk) is rejected rather than
+// corrupting the file. The upload may not grow beyond maxSize bytes.
+// The upload file is created by the first chunk, after start is called (eg
+// to reserve space for the upload); if start fails the chunk is rejected.
+func (u *offlineUploads) write(dealUuid uuid.UUID, offset uint64, maxSize uint64, r io.Reader, start func() error) (uint64, error) {
+	u.lk.Lock()
+	if _, ok := u.active[dealUuid]; ok {
+		u.lk.Unlock()
+		return 0, fmt.Errorf("a chunk is already being uploaded for deal %s", dealUuid)
+	}
+	u.active[dealUuid] = struct{}{}
+	u.lk.Unlock()
+	defer func() {
+		u.lk.Lock()
+		delete(u.active, dealUuid)
+		u.lk.Unlock()
+	}()
+
+	if _, err := os.Stat(u.path(dealUuid)); errors.Is(err, os.ErrNotExist) {
+		if err := start(); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("getting upload file: %w", err)
+	}
+
+	f, err := os.OpenFile(u.path(dealUuid), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("opening upload file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("getting size of upload file: %w", err)
+	}
+	received := uint64(fi.Size())
+	if offset != received {
+		return received, fmt.Errorf("chunk offset %d does not match the %d bytes received so far", offset, received)
+	}
+
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return received, fmt.Errorf("seeking upload file: %w", err)
+	}
+
+	// Read one byte more than is allowed so as to detect an upload that is
+	// too large
+	n, err := io.Copy(f, io.LimitReader(r, int64(maxSize-offset)+1))
+	if err == nil && offset+uint64(n) > maxSize {
+		err = fmt.Errorf("upload is larger than the maximum of %d bytes", maxSize)
+	}
+	if err != nil {
+		// Drop the partial chunk so that it can be sent again from the same
+		// offset
+		if terr := f.Truncate(int64(offset)); terr != nil {
+			return received, fmt.Errorf("%w (and truncating upload file: %s)", err, terr)
+		}
+		return received, fmt.Errorf("writing chunk: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return received, fmt.Errorf("syncing upload file: %w", err)
+	}
+	return offset + uint64(n), nil
+}
+
+// remove deletes the deal's upload file
+func (u *offlineUploads) remove(dealUuid uuid.UUID) error {
+	err := os.Remove(u.path(dealUuid))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ownsInboundFile returns true if the deal's inbound file was created by
+// boost (by a data transfer or an upload), so that it can be removed once
+// the deal no longer needs it. The files of imported offline deals belong
+// to the SP.
+func (p *Provider) ownsInboundFile(deal *types.ProviderDealState) bool {
+	return !deal.IsOffline || p.uploads.isUpload(deal.InboundFilePath)
+}
+
+// offlineDealForUpload gets the offline deal and checks that its data has
+// not been imported yet
+func (p *Provider) offlineDealForUpload(ctx context.Context, dealUuid uuid.UUID) (*types.ProviderDealState, error) {
+	ds, err := p.dealsDB.ByID(ctx, dealUuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("no pre-existing deal proposal for offline deal %s: %w", dealUuid, err)
+		}
+		return nil, fmt.Errorf("getting offline deal %s: %w", dealUuid, err)
+	}
+	if !ds.IsOffline {
+		return nil, fmt.Errorf("deal %s is not an offline deal", dealUuid)
+	}
+	if ds.InboundFilePath != "" || ds.Checkpoint > dealcheckpoints.Accepted {
+		return nil, fmt.Errorf("deal %s has already been imported and reached checkpoint %s", dealUuid, ds.Checkpoint)
+	}
+	return ds, nil
+}
+
+// OfflineDealUpload returns the progress of the upload of the data for an
+// offline deal
+func (p *Provider) OfflineDealUpload(ctx context.Context, dealUuid uuid.UUID) (*types.OfflineDealUpload, error) {
+	ds, err := p.offlineDealForUpload(ctx, dealUuid)
+	if err != nil {
+		return nil, err
+	}
+	received, err := p.uploads.received(dealUuid)
+	if err != nil {
+		return nil, fmt.Errorf("getting upload progress for deal %s: %w", dealUuid, err)
+	}
+	return &types.OfflineDealUpload{
+		DealUuid: dealUuid,
+		Received: received,
+		MaxSize:  uint64(ds.ClientDealProposal.Proposal.PieceSize.Unpadded()),
+	}, nil
+}
+
+// UploadOfflineDealData appends a chunk of the CAR file for an offline deal
+// to its upload in the staging area, and returns the number of bytes
+// received so far. The offset is the number of bytes received before the
+// chunk.
+func (p *Provider) UploadOfflineDealData(ctx context.Context, dealUuid uuid.UUID, offset uint64, r io.Reader) (uint64, error) {
+	if err := p.features.Check(features.OfflineDealUpload); err != nil {
+		return 0, err
+	}
+	ds, err := p.offlineDealForUpload(ctx, dealUuid)
+	if err != nil {
+		return 0, err
+	}
+
+	// The space for the upload in the staging area is tagged when the
+	// upload starts, so that uploads count against the staging area quota.
+	// It is untagged when the deal's data is no longer needed, or when the
+	// upload is cancelled or abandoned.
+	maxSize := uint64(ds.ClientDealProposal.Proposal.PieceSize.Unpadded())
+	start := func() error {
+		if err := p.tagUpload(ds); err != nil {
+			return fmt.Errorf("failed to tag storage for upload: %w", err)
+		}
+		p.dealLogger.Infow(dealUuid, "tagged storage space for offline deal data upload", "size", maxSize)
+		return nil
+	}
+	received, err := p.uploads.write(dealUuid, offset, maxSize, r, start)
+	if err != nil {
+		p.dealLogger.LogError(dealUuid, "failed to write offline deal data upload chunk", err)
+		return received, fmt.Errorf("uploading data for deal %s: %w", dealUuid, err)
+	}
+	p.dealLogger.Infow(dealUuid, "received offline deal data upload chunk", "offset", offset, "received", received)
+	return received, nil
+}
+
+// FinishOfflineDealUpload imports the uploaded data for an offline deal, as
+// ImportOfflineDealData does for data on the boost host. The deal is then
+// executed, starting with the verification of the commP of the data.
+func (p *Provider) FinishOfflineDealUpload(ctx context.Context, dealUuid uuid.UUID) (*api.ProviderDealRejectionInfo, error) {
+	if err := p.features.Check(features.OfflineDealUpload); err != nil {
+		return nil, err
+	}
+	received, err := p.uploads.received(dealUuid)
+	if err != nil {
+		return nil, fmt.Errorf("getting upload progress for deal %s: %w", dealUuid, err)
+	}
+	if received == 0 {
+		return nil, fmt.Errorf("no data has been uploaded for deal %s", dealUuid)
+	}
+
+	p.dealLogger.Infow(dealUuid, "offline deal data upload finished", "size", received)
+	return p.ImportOfflineDealData(ctx, dealUuid, p.uploads.path(dealUuid))
+}
+
+// CancelOfflineDealUpload removes the data uploaded so far for an offline
+// deal, so that the upload can start again from the beginning
+func (p *Provider) CancelOfflineDealUpload(ctx context.Context, dealUuid uuid.UUID) error {
+	if _, err := p.offlineDealForUpload(ctx, dealUuid); err != nil {
+		return err
+	}
+	if err := p.removeUpload(dealUuid); err != nil {
+		return err
+	}
+	p.dealLogger.Infow(dealUuid, "offline deal data upload cancelled")
+	return nil
+}
+
+// removeUpload removes the deal's upload file and untags the staging area
+// space that was tagged for it
+func (p *Provider) removeUpload(dealUuid uuid.UUID) error {
+	if err := p.uploads.remove(dealUuid); err != nil {
+		return fmt.Errorf("removing upload for deal %s: %w", dealUuid, err)
+	}
+	// Untags the storage, if it is tagged
+	if err := p.untagStorageSpaceAfterSealing(p.ctx, &types.ProviderDealState{DealUuid: dealUuid}); err != nil {
+		return fmt.Errorf("untagging storage for upload for deal %s: %w", dealUuid, err)
+	}
+	return nil
+}
+
+// tagUpload tags the staging area space for the deal's upload in the
+// provider run loop
+func (p *Provider) tagUpload(deal *types.ProviderDealState) error {
+	resp := make(chan error, 1)
+	select {
+	case p.tagUploadChan <- tagUploadReq{deal: deal, done: resp}:
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+	select {
+	case err := <-resp:
+		return err
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// cleanupUploadsLoop removes abandoned uploads when the provider starts, and
+// then every uploadCleanupInterval
+func (p *Provider) cleanupUploadsLoop() {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.cleanupUploads(p.ctx); err != nil {
+			log.Errorw("failed to clean up abandoned offline deal data uploads", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanupUploads removes the upload files in the staging area that are no
+// longer needed: uploads for deals that don't exist, that have finished or
+// whose data was imported from another file, and uploads for deals waiting
+// for their data to which no chunk has been written for
+// uploadAbandonedAfter. The upload file of a deal that is executing is
+// removed when the deal no longer needs it.
+func (p *Provider) cleanupUploads(ctx context.Context) error {
+	files, err := p.uploads.list()
+	if err != nil {
+		return fmt.Errorf("listing upload files: %w", err)
+	}
+
+	for _, f := range files {
+		var reason string
+		ds, err := p.dealsDB.ByID(ctx, f.dealUuid)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			reason = "deal not found"
+		case err != nil:
+			return fmt.Errorf("getting deal %s: %w", f.dealUuid, err)
+		case !ds.IsOffline:
+			reason = "deal is not an offline deal"
+		case ds.InboundFilePath == f.path:
+			// The deal is executing with the uploaded data
+			if ds.Checkpoint != dealcheckpoints.Complete {
+				continue
+			}
+			reason = "deal has finished"
+		case ds.InboundFilePath != "":
+			reason = "deal data was imported from another file"
+		case ds.Checkpoint == dealcheckpoints.Complete:
+			reason = "deal has finished"
+		case time.Since(f.modTime) > uploadAbandonedAfter:
+			reason = fmt.Sprintf("no data uploaded since %s", f.modTime.Format(time.RFC3339))
+		default:
+			continue
+		}
+
+		// Don't remove a file that a chunk is being written to
+		p.uploads.lk.Lock()
+		_, active := p.uploads.active[f.dealUuid]
+		p.uploads.lk.Unlock()
+		if active {
+			continue
+		}
+
+		if err := p.removeUpload(f.dealUuid); err != nil {
+			p.dealLogger.LogError(f.dealUuid, "failed to remove abandoned offline deal data upload", err)
+			continue
+		}
+		p.dealLogger.Infow(f.dealUuid, "removed abandoned offline deal data upload", "path", f.path, "reason", reason)
+	}
+	return nil
+}
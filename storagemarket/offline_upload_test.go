@@ -0,0 +1,110 @@
+package storagemarket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineUploads(t *testing.T) {
+	dir := t.TempDir()
+	u := newOfflineUploads(dir)
+	dealUuid := uuid.New()
+
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	// Nothing has been received yet
+	received, err := u.received(dealUuid)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, received)
+
+	// Only the first chunk starts the upload, and the upload isn't created
+	// if starting it fails
+	starts := 0
+	start := func() error {
+		starts++
+		return nil
+	}
+	_, err = u.write(dealUuid, 0, 2000, bytes.NewReader(data[:400]), func() error { return errors.New("no space left") })
+	require.ErrorContains(t, err, "no space left")
+	_, err = os.Stat(u.path(dealUuid))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Upload the first chunk
+	received, err = u.write(dealUuid, 0, 2000, bytes.NewReader(data[:400]), start)
+	require.NoError(t, err)
+	require.EqualValues(t, 400, received)
+	require.Equal(t, 1, starts)
+
+	// Sending the same chunk again is rejected
+	received, err = u.write(dealUuid, 0, 2000, bytes.NewReader(data[:400]), start)
+	require.Error(t, err)
+	require.EqualValues(t, 400, received)
+
+	// A chunk that fails part way through is dropped, so that it can be sent
+	// again from the same offset
+	r := io.MultiReader(bytes.NewReader(data[400:500]), &errReader{err: errors.New("connection reset")})
+	_, err = u.write(dealUuid, 400, 2000, r, start)
+	require.ErrorContains(t, err, "connection reset")
+	received, err = u.received(dealUuid)
+	require.NoError(t, err)
+	require.EqualValues(t, 400, received)
+
+	// Resume from the number of bytes received
+	received, err = u.write(dealUuid, received, 2000, bytes.NewReader(data[400:]), start)
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), received)
+	require.Equal(t, 1, starts)
+
+	written, err := os.ReadFile(u.path(dealUuid))
+	require.NoError(t, err)
+	require.Equal(t, data, written)
+
+	// The upload file is recognized as an upload
+	require.True(t, u.isUpload(u.path(dealUuid)))
+	require.False(t, u.isUpload(filepath.Join(dir, dealUuid.String()+".download")))
+	require.False(t, u.isUpload(filepath.Join(t.TempDir(), dealUuid.String()+uploadFileExt)))
+	require.False(t, u.isUpload(""))
+
+	// An upload can't grow beyond the maximum size
+	other := uuid.New()
+	_, err = u.write(other, 0, 500, bytes.NewReader(data[:501]), start)
+	require.ErrorContains(t, err, "larger than the maximum")
+	received, err = u.received(other)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, received)
+	received, err = u.write(other, 0, 500, bytes.NewReader(data[:500]), start)
+	require.NoError(t, err)
+	require.EqualValues(t, 500, received)
+
+	// The uploads are listed, but not other files in the staging area
+	require.NoError(t, os.WriteFile(filepath.Join(dir, uuid.New().String()+".download"), nil, 0644))
+	files, err := u.list()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	for _, f := range files {
+		require.Contains(t, []uuid.UUID{dealUuid, other}, f.dealUuid)
+		require.Equal(t, u.path(f.dealUuid), f.path)
+	}
+
+	// Removing the upload allows it to start again
+	require.NoError(t, u.remove(other))
+	require.NoError(t, u.remove(other))
+	received, err = u.received(other)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, received)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/filecoin-project/boost/db"
 	"github.com/filecoin-project/boost/db/migrations"
 	"github.com/filecoin-project/boost/fundmanager"
+	"github.com/filecoin-project/boost/lib/commp"
+	"github.com/filecoin-project/boost/lib/features"
 	"github.com/filecoin-project/boost/node/modules/dtypes"
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/storagemanager"
@@ -55,19 +57,77 @@ var (
 type Config struct {
 	// The maximum amount of time a transfer can take before it fails
 	MaxTransferDuration time.Duration
+	// The longest transfer timeout that a client may ask for
+	MaxDealTransferTimeout time.Duration
 	// Whether to do commp on the Boost node (local) or the sealing node (remote)
 	RemoteCommp bool
 	// The number of commp processes that can run in parallel
 	MaxConcurrentLocalCommp uint64
-	TransferLimiter         TransferLimiterConfig
+	// The backend used to calculate commp locally
+	LocalCommp      commp.Config
+	TransferLimiter TransferLimiterConfig
 	// Cleanup deal logs from DB older than this many number of days
 	DealLogDurationDays int
+	// The maximum total unfilled capacity that clients may reserve.
+	// Zero means capacity reservations are not accepted.
+	MaxReservedCapacity uint64
+	// The datacap that a client must have left over after a verified deal
+	// and the client's other verified deals pending publish
+	VerifiedDataCapBuffer uint64
+	// The region in which the provider declares that it stores data
+	Region string
+	// The daily windows in which the provider prefers to receive deal data,
+	// which are announced to clients
+	OffPeakWindows []types.OffPeakWindow
+	// Limits on the rate of deal proposals from each client wallet address
+	// and each client peer
+	DealRateLimits types.DealRateLimits
+	// The deal filter rules that are published to clients, so that they can
+	// check deals against them before proposing them. Empty if the provider
+	// doesn't publish its rules.
+	PublishedFilterRules []types.PublishedFilterRule
+}
+
+// maxDealTransferTimeout is the longest transfer timeout that a client may
+// ask for. Clients may always ask for up to the default transfer duration.
+func (c Config) maxDealTransferTimeout() time.Duration {
+	if c.MaxDealTransferTimeout < c.MaxTransferDuration {
+		return c.MaxTransferDuration
+	}
+	return c.MaxDealTransferTimeout
+}
+
+// ReloadableConfig is the subset of the provider config that can be
+// changed while the provider is running
+type ReloadableConfig struct {
+	MaxTransferDuration     time.Duration
+	MaxDealTransferTimeout  time.Duration
+	RemoteCommp             bool
+	MaxConcurrentLocalCommp uint64
+	LocalCommp              commp.Config
+	TransferLimiter         TransferLimiterConfig
+	DealRateLimits          types.DealRateLimits
+}
+
+// Reloadable returns the subset of the config that can be changed while
+// the provider is running
+func (c Config) Reloadable() ReloadableConfig {
+	return ReloadableConfig{
+		MaxTransferDuration:     c.MaxTransferDuration,
+		MaxDealTransferTimeout:  c.MaxDealTransferTimeout,
+		RemoteCommp:             c.RemoteCommp,
+		MaxConcurrentLocalCommp: c.MaxConcurrentLocalCommp,
+		LocalCommp:              c.LocalCommp,
+		TransferLimiter:         c.TransferLimiter,
+		DealRateLimits:          c.DealRateLimits,
+	}
 }
 
 var log = logging.Logger("boost-provider")
 
 type Provider struct {
-	config Config
+	configLk sync.RWMutex
+	config   Config
 	// Address of the provider on chain.
 	Address address.Address
 
@@ -77,6 +137,8 @@ type Provider struct {
 	runWG     sync.WaitGroup
 
 	newDealPS *newDealPS
+	// publishes the state updates of all deals
+	dealUpdatesPS *dealUpdatesPS
 
 	// channels used to pass messages to run loop
 	acceptDealChan       chan acceptDealReq
@@ -84,29 +146,39 @@ type Provider struct {
 	publishedDealChan    chan publishDealReq
 	updateRetryStateChan chan updateRetryStateReq
 	storageSpaceChan     chan storageSpaceDealReq
+	tagUploadChan        chan tagUploadReq
 
-	// Sealing Pipeline API
-	sps sealingpipeline.API
-
-	// Boost deal filter
-	df dtypes.StorageDealFilter
+	// The miners that the provider makes deals for, each with its own
+	// sealing pipeline, deal filter and ask
+	minersLk sync.RWMutex
+	miners   map[address.Address]*Miner
 
 	// Database API
 	db        *sql.DB
 	dealsDB   *db.DealsDB
 	logsSqlDB *sql.DB
 	logsDB    *db.LogsDB
-
-	Transport      transport.Transport
+	reservDB  *db.CapacityReservationsDB
+	statsDB   *db.RetrievalStatsDB
+
+	// Serializes capacity reservation requests
+	reservLk sync.Mutex
+
+	Transport transport.Transport
+	// Transports for transfer types other than "http" and "libp2p" (eg
+	// "tcp"), by transfer type
+	transports map[string]transport.Transport
+	// Experimental features that gate transfer types and offline uploads
+	features       *features.Flags
 	xferLimiter    *transferLimiter
+	rateLimiter    *dealRateLimiter
 	fundManager    *fundmanager.FundManager
 	storageManager *storagemanager.StorageManager
-	dealPublisher  types.DealPublisher
+	uploads        *offlineUploads
 	transfers      *dealTransfers
 
-	pieceAdder                  types.PieceAdder
-	commpThrottle               chan struct{}
-	commpCalc                   smtypes.CommpCalculator
+	commpPool                   *commp.Pool
+	commpBackend                commp.Calculator
 	maxDealCollateralMultiplier uint64
 	chainDealManager            types.ChainDealManager
 
@@ -140,6 +212,14 @@ func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundma
 	if err != nil {
 		return nil, err
 	}
+	dealUpdatesPS, err := newDealUpdatesPubsub()
+	if err != nil {
+		return nil, err
+	}
+	commpBackend, err := commp.New(cfg.LocalCommp)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Make sure that max concurrent local commp is at least 1
@@ -148,33 +228,48 @@ func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundma
 	}
 
 	return &Provider{
-		ctx:       ctx,
-		cancel:    cancel,
-		config:    cfg,
-		Address:   addr,
-		newDealPS: newDealPS,
-		db:        sqldb,
-		dealsDB:   dealsDB,
-		logsSqlDB: logsSqlDB,
-		sps:       sps,
-		df:        df,
+		ctx:           ctx,
+		cancel:        cancel,
+		config:        cfg,
+		Address:       addr,
+		newDealPS:     newDealPS,
+		dealUpdatesPS: dealUpdatesPS,
+		db:            sqldb,
+		dealsDB:       dealsDB,
+		logsSqlDB:     logsSqlDB,
+		reservDB:      db.NewCapacityReservationsDB(sqldb),
+		statsDB:       db.NewRetrievalStatsDB(sqldb),
+		miners: map[address.Address]*Miner{
+			addr: {
+				Address:         addr,
+				PieceAdder:      pa,
+				CommpCalc:       commpCalc,
+				SealingPipeline: sps,
+				DealFilter:      df,
+				AskGetter:       askGetter,
+				DealPublisher:   dp,
+				PieceStore:      ps,
+			},
+		},
 
 		acceptDealChan:       make(chan acceptDealReq),
 		finishedDealChan:     make(chan finishedDealReq),
 		publishedDealChan:    make(chan publishDealReq),
 		updateRetryStateChan: make(chan updateRetryStateReq),
 		storageSpaceChan:     make(chan storageSpaceDealReq),
+		tagUploadChan:        make(chan tagUploadReq),
 
 		Transport:      tspt,
+		transports:     make(map[string]transport.Transport),
 		xferLimiter:    xferLimiter,
+		rateLimiter:    newDealRateLimiter(cfg.DealRateLimits),
 		fundManager:    fundMgr,
 		storageManager: storageMgr,
+		uploads:        newOfflineUploads(storageMgr.StagingAreaDirPath),
 
-		dealPublisher:               dp,
 		fullnodeApi:                 fullnodeApi,
-		pieceAdder:                  pa,
-		commpThrottle:               make(chan struct{}, cfg.MaxConcurrentLocalCommp),
-		commpCalc:                   commpCalc,
+		commpPool:                   commp.NewPool(int(cfg.MaxConcurrentLocalCommp)),
+		commpBackend:                commpBackend,
 		chainDealManager:            cm,
 		maxDealCollateralMultiplier: 2,
 		transfers:                   newDealTransfers(),
@@ -192,6 +287,88 @@ func NewProvider(cfg Config, sqldb *sql.DB, dealsDB *db.DealsDB, fundMgr *fundma
 	}, nil
 }
 
+// ReloadConfig applies changes to the provider config. Deals that are
+// already transferring data or computing commp are not affected.
+func (p *Provider) ReloadConfig(cfg ReloadableConfig) error {
+	commpBackend, err := commp.New(cfg.LocalCommp)
+	if err != nil {
+		return err
+	}
+	if err := p.xferLimiter.setConfig(cfg.TransferLimiter); err != nil {
+		return fmt.Errorf("updating transfer limiter config: %w", err)
+	}
+	p.rateLimiter.setLimits(cfg.DealRateLimits)
+	p.commpPool.SetWorkers(int(cfg.MaxConcurrentLocalCommp))
+
+	p.configLk.Lock()
+	defer p.configLk.Unlock()
+
+	p.config.MaxTransferDuration = cfg.MaxTransferDuration
+	p.config.MaxDealTransferTimeout = cfg.MaxDealTransferTimeout
+	p.config.RemoteCommp = cfg.RemoteCommp
+	p.config.MaxConcurrentLocalCommp = cfg.MaxConcurrentLocalCommp
+	p.config.LocalCommp = cfg.LocalCommp
+	p.config.TransferLimiter = cfg.TransferLimiter
+	p.config.DealRateLimits = cfg.DealRateLimits
+	p.commpBackend = commpBackend
+	return nil
+}
+
+func (p *Provider) getConfig() Config {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+
+	return p.config
+}
+
+// Region returns the region in which the provider declares that it stores
+// data, or an empty string if it doesn't declare one
+func (p *Provider) Region() string {
+	return p.getConfig().Region
+}
+
+// OffPeakWindows returns the daily windows in which the provider prefers to
+// receive deal data
+func (p *Provider) OffPeakWindows() []types.OffPeakWindow {
+	return p.getConfig().OffPeakWindows
+}
+
+// PublishedFilterRules returns the deal filter rules that the provider
+// publishes to clients
+func (p *Provider) PublishedFilterRules() []types.PublishedFilterRule {
+	return p.getConfig().PublishedFilterRules
+}
+
+// DealRateLimits returns the limits on the rate of deal proposals, and the
+// recent proposals of each client wallet address and peer
+func (p *Provider) DealRateLimits() types.DealRateLimitStatus {
+	return p.rateLimiter.status()
+}
+
+// SetDealRateLimits changes the limits on the rate of deal proposals until
+// the config is reloaded or the provider restarts
+func (p *Provider) SetDealRateLimits(limits types.DealRateLimits) {
+	p.rateLimiter.setLimits(limits)
+
+	p.configLk.Lock()
+	defer p.configLk.Unlock()
+
+	p.config.DealRateLimits = limits
+}
+
+func (p *Provider) getCommpBackend() commp.Calculator {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+
+	return p.commpBackend
+}
+
+// CommpJobs returns the local commp calculations that are running or are
+// waiting for a worker, by deal uuid
+func (p *Provider) CommpJobs() []commp.Job {
+	return p.commpPool.Jobs()
+}
+
 func (p *Provider) Deal(ctx context.Context, dealUuid uuid.UUID) (*types.ProviderDealState, error) {
 	ctx, span := tracing.Tracer.Start(ctx, "Provider.Deal")
 	defer span.End()
@@ -269,14 +446,28 @@ func (p *Provider) ExecuteDeal(ctx context.Context, dp *types.DealParams, client
 
 	p.dealLogger.Infow(dp.DealUUID, "executing deal proposal received from network", "peer", clientPeer)
 
+	// Add the transfer options (alternate urls and retry policy) to the
+	// transfer params, so that they're stored with the deal
+	transfer, err := dp.TransferWithOptions()
+	if err != nil {
+		p.dealLogger.Infow(dp.DealUUID, "deal proposal has invalid transfer options", "err", err)
+		return &api.ProviderDealRejectionInfo{
+			Reason: fmt.Sprintf("failed validation: %s", err),
+		}, nil
+	}
+
 	ds := types.ProviderDealState{
 		DealUuid:           dp.DealUUID,
 		ClientDealProposal: dp.ClientDealProposal,
 		ClientPeerID:       clientPeer,
 		DealDataRoot:       dp.DealDataRoot,
-		Transfer:           dp.Transfer,
+		Transfer:           transfer,
 		IsOffline:          dp.IsOffline,
 		Retry:              smtypes.DealRetryAuto,
+
+		TransferStartTimeout: dp.TransferStartTimeout,
+		TransferTimeout:      dp.TransferTimeout,
+		CompletionTimeout:    dp.CompletionTimeout,
 	}
 	// validate the deal proposal
 	if err := p.validateDealProposal(ds); err != nil {
@@ -291,6 +482,18 @@ func (p *Provider) ExecuteDeal(ctx context.Context, dp *types.DealParams, client
 		}, nil
 	}
 
+	// Reject the proposal if the client has made too many proposals
+	// recently, before it takes up a place in the queue of deals waiting to
+	// be accepted
+	prop := dp.ClientDealProposal.Proposal
+	if reason := p.rateLimiter.allow(prop.Client.String(), clientPeer.String(), uint64(prop.PieceSize)); reason != "" {
+		p.dealLogger.Infow(dp.DealUUID, "deal proposal rate limited", "reason", reason)
+
+		return &api.ProviderDealRejectionInfo{
+			Reason: fmt.Sprintf("rate limited: %s", reason),
+		}, nil
+	}
+
 	return p.executeDeal(ctx, ds)
 }
 
@@ -356,6 +559,35 @@ func (p *Provider) checkForDealAcceptance(ctx context.Context, ds *types.Provide
 	return resp, nil
 }
 
+// RegisterTransport adds support for deals with the transfer type (eg
+// "tcp"). It must be called before the provider is started.
+func (p *Provider) RegisterTransport(transferType string, t transport.Transport) {
+	p.transports[transferType] = t
+}
+
+// SetFeatures sets the feature flags that gate experimental subsystems of
+// the provider. It must be called before the provider is started.
+func (p *Provider) SetFeatures(f *features.Flags) {
+	p.features = f
+}
+
+// transferTypeFeatures are the features that gate deals with experimental
+// transfer types, by transfer type
+var transferTypeFeatures = map[string]string{
+	"tcp": features.TcpTransfers,
+	"s3":  features.S3Transfers,
+}
+
+// transportFor returns the transport for deals with the transfer type, or
+// false if the transfer type is not supported
+func (p *Provider) transportFor(transferType string) (transport.Transport, bool) {
+	if transferType == "http" || transferType == "libp2p" {
+		return p.Transport, true
+	}
+	t, ok := p.transports[transferType]
+	return t, ok
+}
+
 func (p *Provider) Start() error {
 	log.Infow("storage provider: starting")
 
@@ -410,7 +642,12 @@ func (p *Provider) Start() error {
 	for _, deal := range activeDeals {
 		// Check if deal is already proving
 		if deal.Checkpoint >= dealcheckpoints.IndexedAndAnnounced {
-			si, err := p.sps.SectorsStatus(p.ctx, deal.SectorID, false)
+			m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+			if err != nil {
+				p.dealLogger.LogError(deal.DealUuid, "failed to restart deal", err)
+				continue
+			}
+			si, err := m.SealingPipeline.SectorsStatus(p.ctx, deal.SectorID, false)
 			if err != nil || isFinalSealingState(si.State) {
 				continue
 			}
@@ -456,6 +693,9 @@ func (p *Provider) Start() error {
 	// Start the transfer limiter
 	go p.xferLimiter.run(p.ctx)
 
+	// Start removing abandoned offline deal data uploads
+	go p.cleanupUploadsLoop()
+
 	// Start hourly deal log cleanup
 	if p.config.DealLogDurationDays > 0 {
 		go p.dealLogger.LogCleanup(p.ctx, p.config.DealLogDurationDays)
@@ -466,8 +706,9 @@ func (p *Provider) Start() error {
 }
 
 func (p *Provider) cleanupDealOnRestart(deal *types.ProviderDealState) {
-	// remove the temp file created for inbound deal data if it is not an offline deal
-	if !deal.IsOffline {
+	// remove the temp file created for inbound deal data if it is not an
+	// imported offline deal
+	if p.ownsInboundFile(deal) {
 		_ = os.Remove(deal.InboundFilePath)
 	}
 
@@ -510,6 +751,11 @@ func (p *Provider) SubscribeNewDeals() (event.Subscription, error) {
 	return p.newDealPS.subscribe()
 }
 
+// SubscribeAllDealUpdates subscribes to the state updates of all deals
+func (p *Provider) SubscribeAllDealUpdates() (event.Subscription, error) {
+	return p.dealUpdatesPS.subscribe()
+}
+
 // SubscribeDealUpdates subscribes to updates to a deal
 func (p *Provider) SubscribeDealUpdates(dealUuid uuid.UUID) (event.Subscription, error) {
 	dh := p.getDealHandler(dealUuid)
@@ -592,9 +838,14 @@ func (p *Provider) AddPieceToSector(ctx context.Context, deal smtypes.ProviderDe
 		KeepUnsealed: true,
 	}
 
+	m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+	if err != nil {
+		return nil, err
+	}
+
 	// Attempt to add the piece to a sector (repeatedly if necessary)
 	pieceSize := deal.ClientDealProposal.Proposal.PieceSize.Unpadded()
-	sectorNum, offset, err := p.pieceAdder.AddPiece(ctx, pieceSize, pieceData, sdInfo)
+	sectorNum, offset, err := m.PieceAdder.AddPiece(ctx, pieceSize, pieceData, sdInfo)
 	curTime := build.Clock.Now()
 
 	for build.Clock.Since(curTime) < addPieceRetryTimeout {
@@ -606,7 +857,7 @@ func (p *Provider) AddPieceToSector(ctx context.Context, deal smtypes.ProviderDe
 		}
 		select {
 		case <-build.Clock.After(addPieceRetryWait):
-			sectorNum, offset, err = p.pieceAdder.AddPiece(ctx, pieceSize, pieceData, sdInfo)
+			sectorNum, offset, err = m.PieceAdder.AddPiece(ctx, pieceSize, pieceData, sdInfo)
 		case <-ctx.Done():
 			return nil, fmt.Errorf("error while waiting to retry AddPiece: %w", ctx.Err())
 		}
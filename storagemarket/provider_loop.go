@@ -44,6 +44,11 @@ type storageSpaceDealReq struct {
 	done chan struct{}
 }
 
+type tagUploadReq struct {
+	deal *types.ProviderDealState
+	done chan error
+}
+
 type updateRetryStateReq struct {
 	dealUuid uuid.UUID
 	retry    bool // whether to retry or to terminate the deal
@@ -79,6 +84,22 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 			isSevereError: false,
 		}
 	}
+	if _, ok := p.transportFor(deal.Transfer.Type); !ok {
+		return &acceptError{
+			error:         fmt.Errorf("transfer type %s is not supported", deal.Transfer.Type),
+			reason:        fmt.Sprintf("transfer type %s is not supported by this provider", deal.Transfer.Type),
+			isSevereError: false,
+		}
+	}
+	if name, ok := transferTypeFeatures[deal.Transfer.Type]; ok {
+		if err := p.features.Check(name); err != nil {
+			return &acceptError{
+				error:         fmt.Errorf("transfer type %s: %w", deal.Transfer.Type, err),
+				reason:        fmt.Sprintf("transfer type %s is not supported by this provider", deal.Transfer.Type),
+				isSevereError: false,
+			}
+		}
+	}
 
 	// Check that the deal proposal is unique
 	if aerr := p.checkDealPropUnique(deal); aerr != nil {
@@ -90,8 +111,18 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 		return aerr
 	}
 
+	// the deal is sealed, priced and filtered by the miner it was proposed to
+	m, err := p.miner(deal.ClientDealProposal.Proposal.Provider)
+	if err != nil {
+		return &acceptError{
+			error:         err,
+			reason:        fmt.Sprintf("incorrect provider for deal: %s", deal.ClientDealProposal.Proposal.Provider),
+			isSevereError: false,
+		}
+	}
+
 	// get current sealing pipeline status
-	status, err := sealingpipeline.GetStatus(p.ctx, p.fullnodeApi, p.sps)
+	status, err := sealingpipeline.GetStatus(p.ctx, p.fullnodeApi, m.SealingPipeline)
 	if err != nil {
 		return &acceptError{
 			error:         fmt.Errorf("failed to fetch sealing pipeline status: %w", err),
@@ -100,6 +131,15 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 		}
 	}
 
+	head, err := p.fullnodeApi.ChainHead(p.ctx)
+	if err != nil {
+		return &acceptError{
+			error:         fmt.Errorf("failed to get chain head: %w", err),
+			reason:        "server error: get chain head",
+			isSevereError: true,
+		}
+	}
+
 	// run custom decision logic by invoking the deal filter
 	// (the deal filter can be configured by the user)
 	params := types.DealParams{
@@ -109,9 +149,10 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 		Transfer:           deal.Transfer,
 	}
 
-	accept, reason, err := p.df(p.ctx, types.DealFilterParams{
+	accept, reason, err := m.DealFilter(p.ctx, types.DealFilterParams{
 		DealParams:           &params,
-		SealingPipelineState: status})
+		SealingPipelineState: status,
+		ChainHead:            head.Height()})
 
 	if err != nil {
 		return &acceptError{
@@ -171,7 +212,7 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 	p.logFunds(deal.DealUuid, trsp)
 
 	// tag the storage required for the deal in the staging area
-	err = p.storageManager.Tag(p.ctx, deal.DealUuid, deal.Transfer.Size, host)
+	err = p.storageManager.Tag(p.ctx, deal.DealUuid, m.Address, deal.Transfer.Size, host)
 	if err != nil {
 		cleanup()
 
@@ -219,6 +260,8 @@ func (p *Provider) processDealProposal(deal *types.ProviderDealState) *acceptErr
 
 	p.dealLogger.Infow(deal.DealUuid, "inserted deal into deals DB")
 
+	p.fillCapacityReservation(deal)
+
 	return nil
 }
 
@@ -247,6 +290,8 @@ func (p *Provider) processOfflineDealProposal(ds *smtypes.ProviderDealState, dh
 		}
 	}
 
+	p.fillCapacityReservation(ds)
+
 	// publish "new deal" event
 	p.fireEventDealNew(ds)
 	// publish an event with the current state of the deal
@@ -314,7 +359,7 @@ func (p *Provider) checkDealPropUnique(deal *smtypes.ProviderDealState) *acceptE
 	// The database lookup did not return a "not found" error, meaning we found
 	// a deal with a matching deal proposal cid. Therefore the deal proposal
 	// is not unique.
-	err = fmt.Errorf("deal proposal is identical to deal %s (proposed at %s)", dl.DealUuid, dl.CreatedAt)
+	err = fmt.Errorf("%s %s (proposed at %s)", smtypes.DealProposalNotUnique, dl.DealUuid, dl.CreatedAt)
 	return &acceptError{
 		error:         err,
 		reason:        err.Error(),
@@ -338,7 +383,7 @@ func (p *Provider) checkDealUuidUnique(deal *smtypes.ProviderDealState) *acceptE
 
 	// The database lookup did not return a "not found" error, meaning we found
 	// a deal with a matching deal uuid. Therefore the deal proposal is not unique.
-	err = fmt.Errorf("deal has the same uuid as deal %s (proposed at %s)", dl.DealUuid, dl.CreatedAt)
+	err = fmt.Errorf("%s %s (proposed at %s)", smtypes.DealUuidNotUnique, dl.DealUuid, dl.CreatedAt)
 	return &acceptError{
 		error:         err,
 		reason:        err.Error(),
@@ -450,6 +495,13 @@ func (p *Provider) run() {
 			}
 			close(storageSpaceDealReq.done)
 
+		case tagUploadReq := <-p.tagUploadChan:
+			// Tag the storage for an offline deal data upload here, so that
+			// the space available is checked in turn with accepted deals
+			prop := tagUploadReq.deal.ClientDealProposal.Proposal
+			tagUploadReq.done <- p.storageManager.Tag(p.ctx, tagUploadReq.deal.DealUuid, prop.Provider,
+				uint64(prop.PieceSize.Unpadded()), uploadTransferHost)
+
 		case publishedDeal := <-p.publishedDealChan:
 			deal := publishedDeal.deal
 			_, _, errf := p.fundManager.UntagFunds(p.ctx, deal.DealUuid)
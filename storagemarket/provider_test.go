@@ -826,6 +826,45 @@ func TestDealFailAfterManualRecoverableErrors(t *testing.T) {
 	harness.EventuallyAssertNoTagged(t, ctx)
 }
 
+func TestDealTransferTimeout(t *testing.T) {
+	ctx := context.Background()
+	harness := NewHarness(t, withMaxTransferDuration(100*time.Millisecond, time.Hour))
+	// start the provider test harness
+	harness.Start(t, ctx)
+	defer harness.Stop()
+
+	t.Run("transfer timeout longer than the default", func(t *testing.T) {
+		td := harness.newDealBuilder(t, 1).withAllMinerCallsNonBlocking().withBlockingHttpServer().build()
+		td.params.TransferTimeout = time.Minute
+		require.NoError(t, td.executeAndSubscribe())
+		td.waitForAndAssert(t, ctx, dealcheckpoints.Accepted)
+
+		// Keep the transfer going for longer than the provider's default
+		// transfer duration: the deal's transfer timeout applies instead
+		time.Sleep(300 * time.Millisecond)
+		td.unblockTransfer()
+		td.waitForAndAssert(t, ctx, dealcheckpoints.AddedPiece)
+	})
+
+	t.Run("transfer timeout longer than the provider's maximum", func(t *testing.T) {
+		td := harness.newDealBuilder(t, 2).withNoOpMinerStub().withBlockingHttpServer().build()
+		td.params.TransferTimeout = 2 * time.Hour
+		pi, err := td.ph.Provider.ExecuteDeal(ctx, td.params, "")
+		require.NoError(t, err)
+		require.False(t, pi.Accepted)
+		require.Contains(t, pi.Reason, "deal transfer timeout 2h0m0s is longer than the provider's maximum of 1h0m0s")
+	})
+
+	t.Run("deal not ready to publish within the completion timeout", func(t *testing.T) {
+		td := harness.newDealBuilder(t, 3).withNoOpMinerStub().withBlockingHttpServer().build()
+		td.params.TransferTimeout = time.Minute
+		td.params.CompletionTimeout = 200 * time.Millisecond
+		require.NoError(t, td.executeAndSubscribe())
+		require.NoError(t, td.waitForError("completion timeout of 200ms", types.DealRetryFatal))
+		td.assertDealFailedNonRecoverable(t, ctx, "completion timeout of 200ms")
+	})
+}
+
 func TestDealAskValidation(t *testing.T) {
 	ctx := context.Background()
 
@@ -920,7 +959,7 @@ func TestDealVerification(t *testing.T) {
 				h.MockFullNode.EXPECT().StateVerifiedClientStatus(gomock.Any(), gomock.Any(), gomock.Any()).Return(&sp,
 					nil)
 			},
-			expectedErr: "verified deal DataCap 1 too small",
+			expectedErr: "insufficient datacap: client datacap 1, is less than piece size",
 		},
 		"fails if can't fetch datacap for verified deal": {
 			ask: &storagemarket.StorageAsk{
@@ -1304,6 +1343,9 @@ type providerConfig struct {
 	maxPieceSize  abi.PaddedPieceSize
 
 	localCommp bool
+
+	maxTransferDuration    time.Duration
+	maxDealTransferTimeout time.Duration
 }
 
 type harnessOpt func(pc *providerConfig)
@@ -1323,6 +1365,15 @@ func withHttpDisconnectServerAfter(afterEvery int64) harnessOpt {
 	}
 }
 
+// withMaxTransferDuration configures the provider's default transfer
+// duration, and the longest transfer timeout that a client may ask for
+func withMaxTransferDuration(maxTransfer, maxDealTransferTimeout time.Duration) harnessOpt {
+	return func(pc *providerConfig) {
+		pc.maxTransferDuration = maxTransfer
+		pc.maxDealTransferTimeout = maxDealTransferTimeout
+	}
+}
+
 func withMinPublishFees(fee abi.TokenAmount) harnessOpt {
 	return func(pc *providerConfig) {
 		pc.minPublishFees = fee
@@ -1482,7 +1533,7 @@ func NewHarness(t *testing.T, opts ...harnessOpt) *ProviderHarness {
 		PubMsgBalMin: ph.MinPublishFees,
 		PubMsgWallet: pw,
 	})
-	fm := fminitF(fn, fundsDB)
+	fm := fminitF(fn, fundsDB, dealsDB)
 
 	// storage manager
 	fsRepo, err := repo.NewFS(dir)
@@ -1508,9 +1559,14 @@ func NewHarness(t *testing.T, opts ...harnessOpt) *ProviderHarness {
 	askStore := &mockAskStore{}
 	askStore.SetAsk(pc.price, pc.verifiedPrice, pc.minPieceSize, pc.maxPieceSize)
 
+	maxTransferDuration := time.Hour
+	if pc.maxTransferDuration > 0 {
+		maxTransferDuration = pc.maxTransferDuration
+	}
 	prvCfg := Config{
-		MaxTransferDuration: time.Hour,
-		RemoteCommp:         !pc.localCommp,
+		MaxTransferDuration:    maxTransferDuration,
+		MaxDealTransferTimeout: pc.maxDealTransferTimeout,
+		RemoteCommp:            !pc.localCommp,
 		TransferLimiter: TransferLimiterConfig{
 			MaxConcurrent:    10,
 			StallCheckPeriod: time.Millisecond,
@@ -0,0 +1,37 @@
+package storagemarket
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+)
+
+// How far the timestamp of a retrieval stats query may be from the
+// provider's clock
+const retrievalStatsQueryMaxAge = 10 * time.Minute
+
+// RetrievalStats returns the retrieval statistics of each piece in the
+// client's deals, so that the client can see the demand for its content.
+// The caller is responsible for verifying that the query was signed by the
+// client.
+func (p *Provider) RetrievalStats(ctx context.Context, q types.RetrievalStatsQuery) (*types.RetrievalStatsResponse, error) {
+	if !p.hasMiner(q.Provider) {
+		return &types.RetrievalStatsResponse{
+			Error: fmt.Sprintf("incorrect provider address %s (expected one of %s)", q.Provider, p.Miners()),
+		}, nil
+	}
+	age := time.Since(time.Unix(q.Timestamp, 0))
+	if age > retrievalStatsQueryMaxAge || age < -retrievalStatsQueryMaxAge {
+		return &types.RetrievalStatsResponse{
+			Error: fmt.Sprintf("query timestamp is more than %s from the provider's clock", retrievalStatsQueryMaxAge),
+		}, nil
+	}
+
+	pieces, err := p.statsDB.ByClient(ctx, q.Client)
+	if err != nil {
+		return nil, err
+	}
+	return &types.RetrievalStatsResponse{Pieces: pieces}, nil
+}
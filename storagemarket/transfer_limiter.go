@@ -17,6 +17,10 @@ type transfer struct {
 	host      string
 	updatedAt time.Time
 	bytes     uint64
+	// The weight set by the operator, which is added to the priority
+	weight int64
+	// The priority of the deal when the queue was last sorted
+	priority int64
 }
 
 func (t *transfer) isStarted() bool {
@@ -35,6 +39,15 @@ type TransferLimiterConfig struct {
 	StallCheckPeriod time.Duration
 	// The time that can elapse before a download is considered stalled
 	StallTimeout time.Duration
+	// Priority returns the priority of a queued deal: transfers for deals
+	// with a higher priority start first. If nil, all deals have the same
+	// priority.
+	Priority func(deal *smtypes.ProviderDealState) int64
+	// The windows in which transfers of each transfer type may start.
+	// Deals wait in the queue until the window for their transfer type
+	// opens. Transfers that have already started are not stopped when the
+	// window closes.
+	Schedule smtypes.TransferSchedule
 }
 
 //
@@ -45,11 +58,15 @@ type TransferLimiterConfig struct {
 // a couple of mitigations:
 //
 // The queue is ordered such that we
-// - start transferring data for the oldest deal first
+// - start transferring data for the deal with the highest priority first,
+//   and the oldest deal first among deals with the same priority
 // - prefer to start transfers with peers that don't have any ongoing transfer
 // - once the soft limit is reached, don't allow any new transfers with peers
 //   that have existing stalled transfers
 //
+// Transfers only start inside the transfer window for their transfer type
+// (if there is one): until it opens they are skipped over in the queue.
+//
 // Note that peers are distinguished by their host (eg foo.bar:8080) not by
 // libp2p peer ID.
 //
@@ -69,32 +86,62 @@ type TransferLimiterConfig struct {
 // one of the stalled peers)
 //
 type transferLimiter struct {
-	cfg TransferLimiterConfig
+	cfgLk sync.RWMutex
+	cfg   TransferLimiterConfig
 
 	lk    sync.RWMutex
 	xfers map[uuid.UUID]*transfer
 }
 
 func newTransferLimiter(cfg TransferLimiterConfig) (*transferLimiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &transferLimiter{
+		cfg:   cfg,
+		xfers: make(map[uuid.UUID]*transfer),
+	}, nil
+}
+
+func (cfg TransferLimiterConfig) validate() error {
 	if cfg.MaxConcurrent == 0 {
-		return nil, fmt.Errorf("maximum active concurrent transfers must be > 0")
+		return fmt.Errorf("maximum active concurrent transfers must be > 0")
 	}
 	if cfg.StallCheckPeriod == 0 {
-		return nil, fmt.Errorf("transfer stall check period must be > 0")
+		return fmt.Errorf("transfer stall check period must be > 0")
 	}
 	if cfg.StallTimeout == 0 {
-		return nil, fmt.Errorf("transfer stall timeout must be > 0")
+		return fmt.Errorf("transfer stall timeout must be > 0")
 	}
+	return nil
+}
 
-	return &transferLimiter{
-		cfg:   cfg,
-		xfers: make(map[uuid.UUID]*transfer),
-	}, nil
+func (tl *transferLimiter) config() TransferLimiterConfig {
+	tl.cfgLk.RLock()
+	defer tl.cfgLk.RUnlock()
+
+	return tl.cfg
+}
+
+// setConfig changes the config of a running transfer limiter. Transfers
+// that are already running are not affected.
+func (tl *transferLimiter) setConfig(cfg TransferLimiterConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	tl.cfgLk.Lock()
+	defer tl.cfgLk.Unlock()
+
+	tl.cfg = cfg
+	return nil
 }
 
 func (tl *transferLimiter) run(ctx context.Context) {
 	// Periodically check for stalled transfers
-	ticker := time.NewTicker(tl.cfg.StallCheckPeriod)
+	period := tl.config().StallCheckPeriod
+	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
 	// Note: The first tick will occur after one stall check period (not
@@ -104,6 +151,12 @@ func (tl *transferLimiter) run(ctx context.Context) {
 		case t := <-ticker.C:
 			tl.check(t)
 
+			// Pick up any change to the stall check period
+			if cfgPeriod := tl.config().StallCheckPeriod; cfgPeriod != period {
+				period = cfgPeriod
+				ticker.Reset(period)
+			}
+
 		case <-ctx.Done():
 			return
 		}
@@ -123,6 +176,8 @@ func (tl *transferLimiter) check(now time.Time) {
 	}
 	tl.lk.Unlock()
 
+	cfg := tl.config()
+
 	// Count how many transfers are active (not stalled)
 	var activeCount uint64
 	transferringPeers := make(map[string]struct{}, len(xfers))
@@ -130,8 +185,11 @@ func (tl *transferLimiter) check(now time.Time) {
 	unstartedXfers := make([]*transfer, 0, len(xfers))
 	for _, xfer := range xfers {
 		if !xfer.isStarted() {
-			// Build a list of unstarted transfers (needed later)
-			unstartedXfers = append(unstartedXfers, xfer)
+			// Build a list of unstarted transfers that are inside their
+			// transfer window (needed later)
+			if cfg.Schedule.Open(xfer.deal.Transfer.Type, now) {
+				unstartedXfers = append(unstartedXfers, xfer)
+			}
 
 			// Skip transfers that haven't started
 			continue
@@ -141,7 +199,7 @@ func (tl *transferLimiter) check(now time.Time) {
 		transferringPeers[xfer.host] = struct{}{}
 
 		// Check each transfer to see if it has stalled
-		if now.Sub(xfer.updatedAt) < tl.cfg.StallTimeout {
+		if now.Sub(xfer.updatedAt) < cfg.StallTimeout {
 			activeCount++
 		} else {
 			stalledPeers[xfer.host] = struct{}{}
@@ -149,20 +207,19 @@ func (tl *transferLimiter) check(now time.Time) {
 	}
 
 	// Check if there are already enough active transfers
-	if activeCount >= tl.cfg.MaxConcurrent {
+	if activeCount >= cfg.MaxConcurrent {
 		return
 	}
 
-	// Sort unstarted transfers by creation date (oldest first)
-	sort.Slice(unstartedXfers, func(i, j int) bool {
-		return unstartedXfers[i].deal.CreatedAt.Before(unstartedXfers[j].deal.CreatedAt)
-	})
+	// Sort unstarted transfers by priority, then by creation date (oldest
+	// first)
+	sortQueue(unstartedXfers, cfg)
 
 	// Gets the next transfer that should be started
 	nextTransfer := func() *transfer {
 		var next *transfer
 
-		// Iterate over unstarted transfers from oldest to newest
+		// Iterate over unstarted transfers from highest to lowest priority
 		startedCount := tl.startedCount(xfers)
 		for _, xfer := range unstartedXfers {
 			// Skip transfers that have already been started.
@@ -176,15 +233,22 @@ func (tl *transferLimiter) check(now time.Time) {
 			// allow a new transfer with that peer, but only up to the soft
 			// limit
 			_, isStalledPeer := stalledPeers[xfer.host]
-			if isStalledPeer && startedCount >= tl.cfg.MaxConcurrent {
+			if isStalledPeer && startedCount >= cfg.MaxConcurrent {
 				continue
 			}
 
-			// Default to choosing the oldest unstarted transfer
+			// Default to choosing the oldest unstarted transfer with the
+			// highest priority
 			if next == nil {
 				next = xfer
 			}
 
+			// Don't start a transfer with a lower priority than the default
+			// just because it's with a new peer
+			if xfer.priority < next.priority {
+				break
+			}
+
 			// If there are no transfers with the peer that sent the storage deal,
 			// start a transfer.
 			// This helps ensure that a slow peer doesn't block up the transfer
@@ -199,7 +263,7 @@ func (tl *transferLimiter) check(now time.Time) {
 	}
 
 	// Start new transfers until we reach the limit
-	for i := activeCount; i < tl.cfg.MaxConcurrent; i++ {
+	for i := activeCount; i < cfg.MaxConcurrent; i++ {
 		next := nextTransfer()
 		if next == nil {
 			return
@@ -214,6 +278,82 @@ func (tl *transferLimiter) check(now time.Time) {
 	}
 }
 
+// sortQueue sorts transfers by priority (highest first), then by creation
+// date (oldest first)
+func sortQueue(xfers []*transfer, cfg TransferLimiterConfig) {
+	for _, xfer := range xfers {
+		xfer.priority = xfer.weight
+		if cfg.Priority != nil {
+			xfer.priority += cfg.Priority(xfer.deal)
+		}
+	}
+	sort.SliceStable(xfers, func(i, j int) bool {
+		if xfers[i].priority != xfers[j].priority {
+			return xfers[i].priority > xfers[j].priority
+		}
+		return xfers[i].deal.CreatedAt.Before(xfers[j].deal.CreatedAt)
+	})
+}
+
+// queue returns the deals that are waiting for their transfer to start, in
+// the order that they will start (if the transfers with their hosts don't
+// stall)
+func (tl *transferLimiter) queue() []smtypes.QueuedDeal {
+	tl.lk.RLock()
+	unstarted := make([]*transfer, 0, len(tl.xfers))
+	for _, xfer := range tl.xfers {
+		if !xfer.isStarted() {
+			cp := *xfer
+			unstarted = append(unstarted, &cp)
+		}
+	}
+	tl.lk.RUnlock()
+
+	cfg := tl.config()
+	sortQueue(unstarted, cfg)
+	now := time.Now()
+	queued := make([]smtypes.QueuedDeal, 0, len(unstarted))
+	for i, xfer := range unstarted {
+		prop := xfer.deal.ClientDealProposal.Proposal
+		var windowOpens *time.Time
+		if !cfg.Schedule.Open(xfer.deal.Transfer.Type, now) {
+			opens := cfg.Schedule.NextOpen(xfer.deal.Transfer.Type, now)
+			windowOpens = &opens
+		}
+		queued = append(queued, smtypes.QueuedDeal{
+			DealUuid:           xfer.deal.DealUuid,
+			Client:             prop.Client,
+			PieceSize:          prop.PieceSize,
+			Verified:           prop.VerifiedDeal,
+			Host:               xfer.host,
+			CreatedAt:          xfer.deal.CreatedAt,
+			Priority:           xfer.priority,
+			Weight:             xfer.weight,
+			Position:           i + 1,
+			TransferType:       xfer.deal.Transfer.Type,
+			TransferWindowOpen: windowOpens,
+		})
+	}
+	return queued
+}
+
+// setWeight sets the weight that is added to the priority of a deal that is
+// waiting in the queue
+func (tl *transferLimiter) setWeight(dealUuid uuid.UUID, weight int64) error {
+	tl.lk.Lock()
+	defer tl.lk.Unlock()
+
+	xfer, ok := tl.xfers[dealUuid]
+	if !ok {
+		return fmt.Errorf("deal %s is not in the transfer queue", dealUuid)
+	}
+	if xfer.isStarted() {
+		return fmt.Errorf("the transfer for deal %s has already started", dealUuid)
+	}
+	xfer.weight = weight
+	return nil
+}
+
 // Count how many transfers have been started but not completed
 func (tl *transferLimiter) startedCount(xfers map[uuid.UUID]*transfer) uint64 {
 	var count uint64
@@ -295,7 +435,7 @@ func (tl *transferLimiter) isStalled(dealUuid uuid.UUID) bool {
 		return false
 	}
 
-	return now.Sub(xfer.updatedAt) >= tl.cfg.StallTimeout
+	return now.Sub(xfer.updatedAt) >= tl.config().StallTimeout
 }
 
 type HostTransferStats struct {
@@ -308,6 +448,7 @@ type HostTransferStats struct {
 
 func (tl *transferLimiter) stats() []*HostTransferStats {
 	now := time.Now()
+	stallTimeout := tl.config().StallTimeout
 
 	tl.lk.RLock()
 	defer tl.lk.RUnlock()
@@ -327,7 +468,7 @@ func (tl *transferLimiter) stats() []*HostTransferStats {
 			continue
 		}
 		hostStats.Started++
-		if now.Sub(xfer.updatedAt) >= tl.cfg.StallTimeout {
+		if now.Sub(xfer.updatedAt) >= stallTimeout {
 			hostStats.Stalled++
 		}
 	}
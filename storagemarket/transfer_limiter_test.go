@@ -113,6 +113,56 @@ func TestTransferLimiterQueueSize(t *testing.T) {
 	<-started
 }
 
+// Verifies that raising the concurrency limit on a running transfer limiter
+// allows queued transfers to start
+func TestTransferLimiterSetConfig(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tl, err := newTransferLimiter(TransferLimiterConfig{
+		MaxConcurrent:    1,
+		StallCheckPeriod: time.Millisecond,
+		StallTimeout:     30 * time.Second,
+	})
+	require.NoError(t, err)
+
+	deal1 := generateDeal()
+	deal2 := generateDeal()
+
+	started := make(chan struct{}, 2)
+	for _, deal := range []*smtypes.ProviderDealState{deal1, deal2} {
+		go func(deal *smtypes.ProviderDealState) {
+			err := tl.waitInQueue(ctx, deal)
+			require.NoError(t, err)
+			started <- struct{}{}
+		}(deal)
+	}
+	require.Eventually(t, func() bool { return tl.transfersCount() == 2 }, time.Second, time.Millisecond)
+
+	// Expect only one transfer to start
+	tl.check(time.Now())
+	<-started
+	select {
+	case <-started:
+		require.Fail(t, "expected second transfer not to start yet")
+	default:
+	}
+
+	// An invalid config should be rejected
+	err = tl.setConfig(TransferLimiterConfig{})
+	require.Error(t, err)
+
+	// Raise the limit and expect the second transfer to start
+	err = tl.setConfig(TransferLimiterConfig{
+		MaxConcurrent:    2,
+		StallCheckPeriod: time.Millisecond,
+		StallTimeout:     30 * time.Second,
+	})
+	require.NoError(t, err)
+	tl.check(time.Now())
+	<-started
+}
+
 // Verifies that if a transfer stalls, another transfer is allowed to start,
 // even if that means the total number of transfers breaks the soft limit
 func TestTransferLimiterStalledTransfer(t *testing.T) {
@@ -379,3 +429,115 @@ func TestTransferLimiterStalledTransferHardLimited(t *testing.T) {
 	default:
 	}
 }
+
+// Verifies that deals with a higher priority start first, and that the
+// operator can change the priority of a queued deal
+func TestTransferLimiterPriorityWeights(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Deals with a larger transfer get a higher priority
+	cfg := TransferLimiterConfig{
+		MaxConcurrent:    1,
+		StallCheckPeriod: time.Millisecond,
+		StallTimeout:     30 * time.Second,
+		Priority: func(deal *smtypes.ProviderDealState) int64 {
+			return int64(deal.Transfer.Size)
+		},
+	}
+	tl, err := newTransferLimiter(cfg)
+	require.NoError(t, err)
+
+	// deal1 is the oldest, deal3 has the highest priority
+	deals := make([]*smtypes.ProviderDealState, 0, 3)
+	for i, size := range []uint64{1, 2, 3} {
+		dl := generateDeal()
+		dl.CreatedAt = time.Now().Add(time.Duration(i) * time.Second)
+		dl.Transfer.Size = size
+		deals = append(deals, dl)
+	}
+	started := make(chan *smtypes.ProviderDealState)
+	for _, dl := range deals {
+		dl := dl
+		go func() {
+			err := tl.waitInQueue(ctx, dl)
+			require.NoError(t, err)
+			started <- dl
+		}()
+	}
+	require.Eventually(t, func() bool { return tl.transfersCount() == len(deals) }, time.Second, time.Millisecond)
+
+	queue := tl.queue()
+	require.Len(t, queue, 3)
+	require.Equal(t, deals[2].DealUuid, queue[0].DealUuid)
+	require.EqualValues(t, 3, queue[0].Priority)
+	require.Equal(t, 1, queue[0].Position)
+
+	// The operator moves deal1 to the front of the queue
+	require.NoError(t, tl.setWeight(deals[0].DealUuid, 10))
+	queue = tl.queue()
+	require.Equal(t, deals[0].DealUuid, queue[0].DealUuid)
+	require.EqualValues(t, 11, queue[0].Priority)
+	require.EqualValues(t, 10, queue[0].Weight)
+
+	for _, expected := range []*smtypes.ProviderDealState{deals[0], deals[2], deals[1]} {
+		go tl.check(time.Now())
+
+		dl := <-started
+		require.Equal(t, expected.DealUuid, dl.DealUuid)
+
+		// A deal that has started can't be reordered
+		require.Error(t, tl.setWeight(dl.DealUuid, 1))
+		tl.complete(dl.DealUuid)
+	}
+	require.Error(t, tl.setWeight(uuid.New(), 1))
+}
+
+// Verifies that a transfer waits in the queue until the transfer window for
+// its transfer type opens
+func TestTransferLimiterSchedule(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	night, err := smtypes.ParseTransferWindow("00:00", "06:00")
+	require.NoError(t, err)
+	tl, err := newTransferLimiter(TransferLimiterConfig{
+		MaxConcurrent:    2,
+		StallCheckPeriod: time.Millisecond,
+		StallTimeout:     30 * time.Second,
+		Schedule:         smtypes.TransferSchedule{"http": {night}},
+	})
+	require.NoError(t, err)
+
+	// deal1 is an http transfer, which is only allowed at night; deal2 is a
+	// libp2p transfer, which is allowed at any time
+	deal1 := generateDeal()
+	deal2 := generateDeal()
+	deal2.Transfer.Type = "libp2p"
+	started := make(chan *smtypes.ProviderDealState)
+	for _, dl := range []*smtypes.ProviderDealState{deal1, deal2} {
+		dl := dl
+		go func() {
+			err := tl.waitInQueue(ctx, dl)
+			require.NoError(t, err)
+			started <- dl
+		}()
+	}
+	require.Eventually(t, func() bool { return tl.transfersCount() == 2 }, time.Second, time.Millisecond)
+
+	// At midday only deal2 starts
+	now := time.Now()
+	midday := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+	go tl.check(midday)
+	require.Equal(t, deal2.DealUuid, (<-started).DealUuid)
+	tl.check(midday)
+	select {
+	case <-started:
+		require.Fail(t, "transfer started outside its transfer window")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Once the window opens deal1 starts
+	go tl.check(midday.Add(13 * time.Hour))
+	require.Equal(t, deal1.DealUuid, (<-started).DealUuid)
+}
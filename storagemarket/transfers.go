@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/google/uuid"
 )
 
@@ -196,3 +197,16 @@ func (p *Provider) IsTransferStalled(dealUuid uuid.UUID) bool {
 func (p *Provider) TransferStats() []*HostTransferStats {
 	return p.xferLimiter.stats()
 }
+
+// TransferQueue returns the deals that are waiting for their transfer to
+// start, in the order that they will start
+func (p *Provider) TransferQueue() []types.QueuedDeal {
+	return p.xferLimiter.queue()
+}
+
+// SetTransferQueueWeight sets a weight that is added to the priority of a
+// deal that is waiting for its transfer to start, to move it up (or down)
+// the queue
+func (p *Provider) SetTransferQueueWeight(dealUuid uuid.UUID, weight int64) error {
+	return p.xferLimiter.setWeight(dealUuid, weight)
+}
@@ -0,0 +1,61 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Well-known deal annotation keys. Any other key may be used for free-form
+// annotations.
+const (
+	// A note about the state of the deal, eg why it is stuck
+	AnnotationNote = "note"
+	// A link to a ticket that tracks a problem with the deal. The value
+	// must be an http(s) url.
+	AnnotationTicket = "ticket"
+	// An operator-defined status for the deal, eg "investigating"
+	AnnotationStatus = "status"
+)
+
+const (
+	maxAnnotationKeyLen   = 64
+	maxAnnotationValueLen = 4096
+)
+
+var annotationKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// DealAnnotation is a key / value annotation that an operator attached to a
+// deal, eg to track problem deals
+type DealAnnotation struct {
+	DealUuid  uuid.UUID
+	Key       string
+	Value     string
+	UpdatedAt time.Time
+}
+
+// ValidateDealAnnotation checks that the annotation key is a short
+// identifier, and that the value is valid for the key
+func ValidateDealAnnotation(key string, value string) error {
+	if len(key) > maxAnnotationKeyLen || !annotationKeyRegexp.MatchString(key) {
+		return fmt.Errorf("annotation key '%s' must be up to %d letters, digits, '_', '.' or '-', starting with a letter or digit",
+			key, maxAnnotationKeyLen)
+	}
+	if value == "" {
+		return fmt.Errorf("annotation %s has an empty value", key)
+	}
+	if len(value) > maxAnnotationValueLen {
+		return fmt.Errorf("annotation %s value is %d bytes, which is longer than the maximum of %d bytes",
+			key, len(value), maxAnnotationValueLen)
+	}
+	if key == AnnotationTicket {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("annotation %s value '%s' must be an http(s) url", key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDealAnnotation(t *testing.T) {
+	require.NoError(t, ValidateDealAnnotation(AnnotationNote, "waiting for client to resend data"))
+	require.NoError(t, ValidateDealAnnotation(AnnotationTicket, "https://tickets.example.com/OPS-123"))
+	require.NoError(t, ValidateDealAnnotation("team.owner", "storage-ops"))
+
+	require.Error(t, ValidateDealAnnotation("", "value"))
+	require.Error(t, ValidateDealAnnotation("has space", "value"))
+	require.Error(t, ValidateDealAnnotation("-leading-dash", "value"))
+	require.Error(t, ValidateDealAnnotation(strings.Repeat("k", 65), "value"))
+	require.Error(t, ValidateDealAnnotation(AnnotationNote, ""))
+	require.Error(t, ValidateDealAnnotation(AnnotationNote, strings.Repeat("v", 4097)))
+	require.Error(t, ValidateDealAnnotation(AnnotationTicket, "OPS-123"))
+	require.Error(t, ValidateDealAnnotation(AnnotationTicket, "ftp://tickets.example.com/OPS-123"))
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/google/uuid"
+)
+
+// DealCancel is a request from a client to cancel a deal whose data has not
+// been transferred yet, eg so that the client can make the deal with
+// another provider without paying for both deals
+type DealCancel struct {
+	DealUUID uuid.UUID
+}
+
+// SignatureBytes returns the bytes that the client signs to authenticate the
+// cancel request. They differ from the bytes signed for a deal status
+// request, so that a status request can't be replayed to cancel the deal.
+func (c *DealCancel) SignatureBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.MarshalCBOR(&buf); err != nil {
+		return nil, fmt.Errorf("marshalling deal cancel: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DealCancelRequest is sent by a client to cancel a deal
+type DealCancelRequest struct {
+	Cancel DealCancel
+	// The client's signature over the cancel request
+	Signature crypto.Signature
+}
+
+type DealCancelResponse struct {
+	// Cancelled is true if the deal was cancelled, or had already failed.
+	// A deal whose data has been transferred can't be cancelled.
+	Cancelled bool
+	// Message is the reason the deal was not cancelled
+	Message string
+}
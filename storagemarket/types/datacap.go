@@ -0,0 +1,52 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+// DataCapCheck is the check that a client has enough datacap left on chain
+// for a verified deal, once the datacap that will be used by the client's
+// other verified deals that have not yet been published is taken off
+type DataCapCheck struct {
+	// The client's datacap on chain
+	OnChain big.Int
+	// The total piece size of the client's verified deals that have been
+	// accepted but not yet published on chain
+	Pending uint64
+	// The number of the client's verified deals that are pending publish
+	PendingDeals int
+	// The datacap the provider requires to be left over after the deal
+	Buffer uint64
+	// The piece size of the proposed deal
+	PieceSize uint64
+}
+
+// Remaining is the datacap that will be left after the pending deals and
+// the proposed deal are published, less the buffer. It's negative if
+// the client doesn't have enough datacap.
+func (c DataCapCheck) Remaining() big.Int {
+	required := big.NewIntUnsigned(c.Pending)
+	required = big.Add(required, big.NewIntUnsigned(c.Buffer))
+	required = big.Add(required, big.NewIntUnsigned(c.PieceSize))
+	return big.Sub(c.OnChain, required)
+}
+
+// Sufficient is true if the client has enough datacap for the deal
+func (c DataCapCheck) Sufficient() bool {
+	return c.Remaining().GreaterThanEqual(big.Zero())
+}
+
+// Reason explains why the deal is rejected when the client doesn't have
+// enough datacap
+func (c DataCapCheck) Reason() string {
+	s := fmt.Sprintf("client datacap %d", c.OnChain)
+	if c.PendingDeals > 0 {
+		s += fmt.Sprintf(", less %d reserved by %d verified deals pending publish", c.Pending, c.PendingDeals)
+	}
+	if c.Buffer > 0 {
+		s += fmt.Sprintf(", less a buffer of %d", c.Buffer)
+	}
+	return s + fmt.Sprintf(", is less than piece size %d", c.PieceSize)
+}
@@ -0,0 +1,30 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataCapCheck(t *testing.T) {
+	c := DataCapCheck{OnChain: big.NewInt(1024), PieceSize: 512}
+	require.True(t, c.Sufficient())
+	require.Equal(t, big.NewInt(512), c.Remaining())
+
+	// Deals that are pending publish use up datacap
+	c.Pending = 256
+	c.PendingDeals = 2
+	require.True(t, c.Sufficient())
+	c.Pending = 768
+	require.False(t, c.Sufficient())
+	require.Equal(t, "client datacap 1024, less 768 reserved by 2 verified deals pending publish, is less than piece size 512", c.Reason())
+
+	// The buffer must be left over after the deal
+	c = DataCapCheck{OnChain: big.NewInt(1024), PieceSize: 512, Buffer: 512}
+	require.True(t, c.Sufficient())
+	c.Buffer = 513
+	require.False(t, c.Sufficient())
+	require.Equal(t, big.NewInt(-1), c.Remaining())
+	require.Equal(t, "client datacap 1024, less a buffer of 513, is less than piece size 512", c.Reason())
+}
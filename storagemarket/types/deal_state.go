@@ -58,6 +58,22 @@ type ProviderDealState struct {
 
 	// NBytesReceived is the number of bytes Received for this deal
 	NBytesReceived int64
+	// TransferOrigin identifies the http origin that the deal data was
+	// transferred from (when the client supplied several mirrors)
+	TransferOrigin string
+	// TransferCompression is the encoding of the deal data on the wire
+	// (eg "zstd"), or empty if the data was not compressed.
+	// TransferWireBytes is the number of compressed bytes received, and
+	// TransferDecodedBytes is the number of bytes they decompressed to.
+	TransferCompression  string
+	TransferWireBytes    int64
+	TransferDecodedBytes int64
+
+	// Overrides of the provider's timeouts requested by the client (zero
+	// means use the provider's default)
+	TransferStartTimeout time.Duration
+	TransferTimeout      time.Duration
+	CompletionTimeout    time.Duration
 }
 
 func (d *ProviderDealState) String() string {
@@ -0,0 +1,20 @@
+package types
+
+// PublishedFilterRule is one of the rules that a provider accepts deals by,
+// published so that clients can check a deal against it before proposing it
+type PublishedFilterRule struct {
+	// The name of the rule, which is the reason given when it rejects a deal
+	Name string
+	// The expression that a deal must satisfy to be accepted, in the syntax
+	// of the provider's deal filter rules (see dealfilter.Rule)
+	Expr string
+}
+
+// ProviderFilterRulesResponse is the deal filter rules that a provider
+// publishes. A deal that doesn't satisfy all the rules will be rejected,
+// but a deal that satisfies them may still be rejected for other reasons
+// (eg the provider's external deal filter, or its storage space).
+type ProviderFilterRulesResponse struct {
+	// Empty if the provider doesn't publish its rules
+	Rules []PublishedFilterRule
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+// ImportInfo describes the data for a piece that has been imported into
+// boost, either by an online data transfer or an offline deal import.
+// There is one import per piece, which may back several deals.
+type ImportInfo struct {
+	PieceCID  cid.Cid
+	PieceSize abi.PaddedPieceSize
+	// The roots of the CAR files for the piece (usually there is just one)
+	Roots []cid.Cid
+	// The size of the CAR file
+	Size uint64
+	// The time at which the piece was first proposed in a deal
+	CreatedAt time.Time
+	// The number of deals for the piece
+	DealCount int
+	// True if all deals for the piece are offline deals
+	IsOffline bool
+	// The paths on disk of the inbound CAR files for the piece
+	Paths []string
+}
+
+// The fields that imports can be sorted by
+const (
+	ImportsSortCreated = "created"
+	ImportsSortSize    = "size"
+	ImportsSortDeals   = "deals"
+)
+
+// ImportsFilter filters and sorts the list of imports
+type ImportsFilter struct {
+	// Only include imports for the given piece
+	PieceCID *cid.Cid
+	// Only include imports with the given root
+	Root *cid.Cid
+	// Only include online (false) or offline (true) imports
+	IsOffline *bool
+	// One of ImportsSortCreated (the default), ImportsSortSize or
+	// ImportsSortDeals
+	SortBy string
+	// Sort in ascending order (the default is descending)
+	Ascending bool
+	// The maximum number of imports to return (zero means no limit)
+	Limit int
+}
+
+// OfflineDealUpload is the progress of the upload of the data for an
+// offline deal over the API
+type OfflineDealUpload struct {
+	DealUuid uuid.UUID
+	// The number of bytes received so far. An interrupted upload resumes
+	// from this offset.
+	Received uint64
+	// The maximum size of the data (the unpadded piece size of the deal)
+	MaxSize uint64
+}
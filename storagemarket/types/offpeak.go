@@ -0,0 +1,154 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// OffPeakWindow is a daily time window in which a provider prefers to
+// receive deal data (eg because its bandwidth is less contended), and in
+// which it may accept a discounted storage price
+type OffPeakWindow struct {
+	// The start of the window in minutes after midnight UTC
+	Start uint64
+	// The end of the window in minutes after midnight UTC. If End is before
+	// Start the window spans midnight.
+	End uint64
+	// The percentage by which the provider discounts its asking price for
+	// deals proposed during the window
+	DiscountPercent uint64
+}
+
+// ProviderOffPeakResponse is the off-peak windows that a provider announces,
+// so that clients can shift non-urgent transfers into them
+type ProviderOffPeakResponse struct {
+	// Empty if the provider doesn't announce any off-peak windows
+	Windows []OffPeakWindow
+}
+
+// ParseOffPeakWindow parses an off-peak window from its start and end times
+// in the format HH:MM (UTC), eg 22:00 and 06:00
+func ParseOffPeakWindow(start, end string, discountPercent uint64) (OffPeakWindow, error) {
+	s, err := parseMinuteOfDay(start)
+	if err != nil {
+		return OffPeakWindow{}, fmt.Errorf("parsing off-peak window start: %w", err)
+	}
+	e, err := parseMinuteOfDay(end)
+	if err != nil {
+		return OffPeakWindow{}, fmt.Errorf("parsing off-peak window end: %w", err)
+	}
+	w := OffPeakWindow{Start: s, End: e, DiscountPercent: discountPercent}
+	if err := w.Validate(); err != nil {
+		return OffPeakWindow{}, err
+	}
+	return w, nil
+}
+
+// Validate checks that the window is a valid window within a day. Windows
+// announced by a provider must be validated before they are used.
+func (w OffPeakWindow) Validate() error {
+	if w.Start >= minutesPerDay || w.End >= minutesPerDay {
+		return fmt.Errorf("off-peak window start and end must be less than %d minutes after midnight", minutesPerDay)
+	}
+	if w.Start == w.End {
+		return fmt.Errorf("off-peak window start and end must be different")
+	}
+	if w.DiscountPercent > 100 {
+		return fmt.Errorf("off-peak window discount must not be more than 100 percent")
+	}
+	return nil
+}
+
+func parseMinuteOfDay(s string) (uint64, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%s is not in the format HH:MM", s)
+	}
+	return uint64(t.Hour()*60 + t.Minute()), nil
+}
+
+func (w OffPeakWindow) String() string {
+	s := fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+	if w.DiscountPercent > 0 {
+		s += fmt.Sprintf(" (%d%% discount)", w.DiscountPercent)
+	}
+	return s
+}
+
+// Contains returns true if t is inside the window
+func (w OffPeakWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	m := uint64(t.Hour()*60 + t.Minute())
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// Length returns the length of the window
+func (w OffPeakWindow) Length() time.Duration {
+	return time.Duration((w.End+minutesPerDay-w.Start)%minutesPerDay) * time.Minute
+}
+
+// Remaining returns the time from t until the end of the window, or zero if
+// t is not inside the window
+func (w OffPeakWindow) Remaining(t time.Time) time.Duration {
+	if !w.Contains(t) {
+		return 0
+	}
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	end := midnight.Add(time.Duration(w.End) * time.Minute)
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end.Sub(t)
+}
+
+// Next returns t if t is inside the window, or otherwise the next time after
+// t at which the window starts
+func (w OffPeakWindow) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	next := midnight.Add(time.Duration(w.Start%minutesPerDay) * time.Minute)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// CurrentOffPeakWindow returns the window that t is inside, or false if t is
+// not inside any of the windows. If t is inside more than one window, the
+// window with the largest discount is returned.
+func CurrentOffPeakWindow(windows []OffPeakWindow, t time.Time) (OffPeakWindow, bool) {
+	var current OffPeakWindow
+	found := false
+	for _, w := range windows {
+		if w.Contains(t) && (!found || w.DiscountPercent > current.DiscountPercent) {
+			current = w
+			found = true
+		}
+	}
+	return current, found
+}
+
+// NextOffPeak returns the earliest time at or after t that is inside one of
+// the windows, or t if there are no windows
+func NextOffPeak(windows []OffPeakWindow, t time.Time) time.Time {
+	if len(windows) == 0 {
+		return t
+	}
+	var next time.Time
+	for i, w := range windows {
+		if n := w.Next(t); i == 0 || n.Before(next) {
+			next = n
+		}
+	}
+	return next
+}
@@ -0,0 +1,62 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffPeakWindow(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", "2023-01-10 "+hhmm)
+		require.NoError(t, err)
+		return tm
+	}
+
+	// A window that spans midnight
+	night, err := ParseOffPeakWindow("22:00", "06:00", 10)
+	require.NoError(t, err)
+	require.Equal(t, OffPeakWindow{Start: 22 * 60, End: 6 * 60, DiscountPercent: 10}, night)
+	require.Equal(t, "22:00-06:00 (10% discount)", night.String())
+	require.True(t, night.Contains(at("23:30")))
+	require.True(t, night.Contains(at("05:59")))
+	require.False(t, night.Contains(at("06:00")))
+	require.Equal(t, at("22:00"), night.Next(at("12:00")))
+	require.Equal(t, at("01:00"), night.Next(at("01:00")))
+
+	lunch, err := ParseOffPeakWindow("12:00", "13:00", 0)
+	require.NoError(t, err)
+	require.False(t, lunch.Contains(at("13:00")))
+	require.Equal(t, at("12:00").AddDate(0, 0, 1), lunch.Next(at("13:30")))
+
+	windows := []OffPeakWindow{lunch, night}
+	require.Equal(t, at("12:00"), NextOffPeak(windows, at("07:00")))
+	require.Equal(t, at("22:00"), NextOffPeak(windows, at("14:00")))
+	require.Equal(t, at("14:00"), NextOffPeak(nil, at("14:00")))
+	w, ok := CurrentOffPeakWindow(windows, at("23:00"))
+	require.True(t, ok)
+	require.Equal(t, night, w)
+	_, ok = CurrentOffPeakWindow(windows, at("14:00"))
+	require.False(t, ok)
+
+	for _, tc := range [][2]string{{"22", "06:00"}, {"22:00", "25:00"}, {"06:00", "06:00"}} {
+		_, err := ParseOffPeakWindow(tc[0], tc[1], 0)
+		require.Error(t, err, tc)
+	}
+	_, err = ParseOffPeakWindow("22:00", "06:00", 101)
+	require.Error(t, err)
+
+	require.Equal(t, 8*time.Hour, night.Length())
+	require.Equal(t, time.Hour, lunch.Length())
+	require.Equal(t, 90*time.Minute, night.Remaining(at("04:30")))
+	require.Equal(t, 7*time.Hour, night.Remaining(at("23:00")))
+	require.Zero(t, night.Remaining(at("12:00")))
+
+	// Windows announced by a provider are validated
+	require.NoError(t, night.Validate())
+	require.Error(t, OffPeakWindow{Start: 22 * 60, End: 6 * 60, DiscountPercent: 200}.Validate())
+	require.Error(t, OffPeakWindow{Start: 1440, End: 6 * 60}.Validate())
+	require.Error(t, OffPeakWindow{Start: 22 * 60, End: 5000}.Validate())
+	require.Error(t, OffPeakWindow{Start: 60, End: 60}.Validate())
+}
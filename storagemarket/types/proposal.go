@@ -0,0 +1,48 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// DealStatusNotFound is the start of the error in a DealStatusResponse for
+// a deal that the provider doesn't have
+const DealStatusNotFound = "no storage deal found"
+
+// The start of the reasons that a provider gives for rejecting a proposal
+// for a deal that it already has
+const (
+	DealProposalNotUnique = "deal proposal is identical to deal"
+	DealUuidNotUnique     = "deal has the same uuid as deal"
+)
+
+// IsDuplicateRejection returns true if the reason that a provider gave for
+// rejecting a proposal is that it already has the deal
+func IsDuplicateRejection(reason string) bool {
+	return strings.Contains(reason, DealProposalNotUnique) || strings.Contains(reason, DealUuidNotUnique)
+}
+
+// IsDealNotFound returns true if the status response says that the provider
+// doesn't have the deal
+func (r *DealStatusResponse) IsDealNotFound() bool {
+	return strings.HasPrefix(r.Error, DealStatusNotFound)
+}
+
+// ProposalOutcomeUnknownError is returned when a deal proposal may have
+// reached the provider but no response was received (eg the response timed
+// out). The provider may have accepted the deal, so before proposing it
+// again the client must ask the provider for the status of the deal.
+type ProposalOutcomeUnknownError struct {
+	DealUUID uuid.UUID
+	Err      error
+}
+
+func (e *ProposalOutcomeUnknownError) Error() string {
+	return fmt.Sprintf("outcome of proposal for deal %s is unknown: %s", e.DealUUID, e.Err)
+}
+
+func (e *ProposalOutcomeUnknownError) Unwrap() error {
+	return e.Err
+}
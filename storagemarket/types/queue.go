@@ -0,0 +1,35 @@
+package types
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/google/uuid"
+)
+
+// QueuedDeal is a deal that is waiting in the transfer queue for its data
+// transfer to start
+type QueuedDeal struct {
+	DealUuid  uuid.UUID
+	Client    address.Address
+	PieceSize abi.PaddedPieceSize
+	Verified  bool
+	// The host the data is transferred from
+	Host      string
+	CreatedAt time.Time
+	// The priority of the deal: the sum of the weights of the priority rules
+	// that the deal satisfies, and the weight set by the operator. Deals with
+	// a higher priority start transferring first.
+	Priority int64
+	// The weight set by the operator for the deal
+	Weight int64
+	// The position of the deal in the queue, starting at 1
+	Position int
+	// The transfer type of the deal, eg "http"
+	TransferType string
+	// If the transfer window for the deal's transfer type is closed, the
+	// time at which it next opens. The deal's transfer doesn't start
+	// before then, whatever its position.
+	TransferWindowOpen *time.Time
+}
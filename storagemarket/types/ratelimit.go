@@ -0,0 +1,38 @@
+package types
+
+// DealRateLimit limits the deal proposals that the provider accepts from a
+// single client. Zero means no limit.
+type DealRateLimit struct {
+	// The maximum number of deal proposals in any hour
+	DealsPerHour uint64
+	// The maximum total piece size of deal proposals in any day
+	BytesPerDay uint64
+}
+
+// DealRateLimits are the limits on deal proposals from each client wallet
+// address and from each client peer
+type DealRateLimits struct {
+	PerClient DealRateLimit
+	PerPeer   DealRateLimit
+}
+
+// DealRateUsage is the recent deal proposals from a client wallet address or
+// peer, which count towards its rate limit
+type DealRateUsage struct {
+	// "client" or "peer"
+	Kind string
+	// The client wallet address or the peer ID
+	ID            string
+	DealsLastHour uint64
+	BytesLastDay  uint64
+	// The number of proposals that were rejected because they were over the
+	// limit, in roughly the last day
+	Rejected uint64
+}
+
+// DealRateLimitStatus is the current deal rate limits, and the usage of each
+// client wallet address and peer that has made deal proposals recently
+type DealRateLimitStatus struct {
+	Limits DealRateLimits
+	Usage  []DealRateUsage
+}
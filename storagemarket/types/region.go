@@ -0,0 +1,8 @@
+package types
+
+// ProviderRegionResponse is the region that a provider declares that it
+// stores data in, so that clients can spread replicas across regions
+type ProviderRegionResponse struct {
+	// Empty if the provider doesn't declare a region
+	Region string
+}
@@ -0,0 +1,88 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/google/uuid"
+)
+
+// CapacityReservation is a reservation of storage capacity with a provider
+// for a window of time. Deals from the client that start within the window
+// fill the reservation.
+type CapacityReservation struct {
+	ID       uuid.UUID
+	Client   address.Address
+	Provider address.Address
+	// The amount of capacity to reserve (sum of padded piece sizes)
+	Size uint64
+	// The window in which deals may start
+	StartEpoch abi.ChainEpoch
+	EndEpoch   abi.ChainEpoch
+	// An optional amount that the client commits to keep in market escrow
+	// for the duration of the reservation
+	Deposit abi.TokenAmount
+}
+
+// SignatureBytes returns the bytes that the client signs to authenticate the
+// reservation request
+func (r *CapacityReservation) SignatureBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.MarshalCBOR(&buf); err != nil {
+		return nil, fmt.Errorf("marshalling capacity reservation: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// CapacityReservationRequest is sent by a client to reserve capacity with a
+// storage provider
+type CapacityReservationRequest struct {
+	Reservation CapacityReservation
+	// The client's signature over the reservation
+	Signature crypto.Signature
+}
+
+type CapacityReservationResponse struct {
+	Accepted bool
+	// Message is the reason the reservation was rejected. It is empty if
+	// the reservation was accepted.
+	Message string
+}
+
+// CapacityReservationStatusRequest is sent to get the current state of a
+// capacity reservation from a storage provider
+type CapacityReservationStatusRequest struct {
+	ReservationID uuid.UUID
+	// The client's signature over the reservation id
+	Signature crypto.Signature
+}
+
+// CapacityReservationStatusResponse is the current state of a capacity
+// reservation
+type CapacityReservationStatusResponse struct {
+	ReservationID uuid.UUID
+	// Error is non-empty if there is an error getting the reservation status
+	// (eg invalid request signature)
+	Error  string
+	Status *CapacityReservationStatus
+}
+
+type CapacityReservationStatus struct {
+	Reservation CapacityReservation
+	// The sum of the padded piece sizes of the deals that have filled the
+	// reservation
+	Filled uint64
+	// The number of deals that have filled the reservation
+	DealCount uint64
+	// Active, Filled or Expired
+	State string
+}
+
+const (
+	CapacityReservationActive  = "Active"
+	CapacityReservationFilled  = "Filled"
+	CapacityReservationExpired = "Expired"
+)
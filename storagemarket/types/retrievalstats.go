@@ -0,0 +1,70 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/ipfs/go-cid"
+)
+
+// RetrievalStatsQuery is a query from a deal client for the retrieval
+// statistics of the pieces it has stored with a provider
+type RetrievalStatsQuery struct {
+	Client   address.Address
+	Provider address.Address
+	// The time at which the query was made, in seconds since the unix
+	// epoch. The provider rejects queries that are too old, so that a
+	// signed query can't be replayed by someone else.
+	Timestamp int64
+}
+
+// SignatureBytes returns the bytes that the client signs to authenticate the
+// query
+func (q *RetrievalStatsQuery) SignatureBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := q.MarshalCBOR(&buf); err != nil {
+		return nil, fmt.Errorf("marshalling retrieval stats query: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RetrievalStatsRequest is sent by a client to get the retrieval statistics
+// of its pieces from a provider
+type RetrievalStatsRequest struct {
+	Query RetrievalStatsQuery
+	// The client's signature over the query
+	Signature crypto.Signature
+}
+
+// RetrievalStatsResponse is the retrieval statistics of each of the client's
+// pieces stored by the provider
+type RetrievalStatsResponse struct {
+	// Error is non-empty if the statistics could not be fetched (eg invalid
+	// request signature)
+	Error  string
+	Pieces []PieceRetrievalStats
+}
+
+// PieceRetrievalStats is a summary of the retrievals of a piece served by the
+// provider
+type PieceRetrievalStats struct {
+	PieceCid cid.Cid
+	// The number of completed graphsync and HTTP retrievals. Bitswap serves
+	// blocks individually rather than in retrievals, so blocks served over
+	// bitswap only add to BytesServed.
+	Retrievals uint64
+	// The total number of bytes served, over any transport
+	BytesServed uint64
+	// The time of the last retrieval, in seconds since the unix epoch (zero
+	// if the piece has never been retrieved)
+	LastAccess int64
+}
+
+// BlockServed is the number of bytes of a block that were served over bitswap
+// since the last time it was reported to boostd
+type BlockServed struct {
+	Cid   cid.Cid
+	Bytes uint64
+}
@@ -0,0 +1,89 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+
+	"github.com/filecoin-project/boost/transport/types"
+)
+
+// MaxAlternateURLs is the maximum number of alternate URLs a deal may have
+const MaxAlternateURLs = 16
+
+// HasTransferOptions returns true if the deal has transfer options that
+// are only understood by providers that support deal protocol v1.3
+func (p *DealParams) HasTransferOptions() bool {
+	return p.TransferRetry != nil || len(p.AlternateURLs) > 0
+}
+
+// TransferWithOptions returns the deal's transfer with the transfer options
+// (the alternate URLs and retry policy) added to the http transfer params.
+// The options are kept in the params so that they are stored with the deal,
+// and still apply if the transfer is restarted.
+func (p *DealParams) TransferWithOptions() (Transfer, error) {
+	if !p.HasTransferOptions() {
+		return p.Transfer, nil
+	}
+	if p.IsOffline {
+		return Transfer{}, errors.New("transfer options cannot be set for an offline deal")
+	}
+	if p.Transfer.Type != "http" && p.Transfer.Type != "libp2p" {
+		return Transfer{}, fmt.Errorf("transfer options are not supported for transfer type '%s'", p.Transfer.Type)
+	}
+
+	req := &types.HttpRequest{}
+	if err := json.Unmarshal(p.Transfer.Params, req); err != nil {
+		return Transfer{}, fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(p.Transfer.Params), err)
+	}
+
+	if len(p.AlternateURLs) > 0 {
+		if p.Transfer.Type != "http" {
+			return Transfer{}, fmt.Errorf("alternate urls are only supported for http transfers")
+		}
+		if len(req.Mirrors) > 0 {
+			return Transfer{}, fmt.Errorf("alternate urls cannot be combined with mirrors in the transfer params")
+		}
+		if len(p.AlternateURLs) > MaxAlternateURLs {
+			return Transfer{}, fmt.Errorf("deal has %d alternate urls: the maximum is %d", len(p.AlternateURLs), MaxAlternateURLs)
+		}
+		for i, a := range p.AlternateURLs {
+			u, err := url.Parse(a.URL)
+			if err != nil {
+				return Transfer{}, fmt.Errorf("parsing alternate url %d: %w", i+1, err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return Transfer{}, fmt.Errorf("alternate url %d must be an http or https url", i+1)
+			}
+			req.Mirrors = append(req.Mirrors, types.HttpMirror{URL: a.URL, Priority: i + 1})
+		}
+		req.MirrorsInOrder = true
+	}
+
+	if r := p.TransferRetry; r != nil {
+		if r.MaxAttempts > math.MaxInt32 {
+			return Transfer{}, fmt.Errorf("transfer retry max attempts %d is too large", r.MaxAttempts)
+		}
+		if r.MinBackoff < 0 || r.MaxBackoff < 0 {
+			return Transfer{}, errors.New("transfer retry backoff cannot be negative")
+		}
+		if r.MaxBackoff > 0 && r.MinBackoff > r.MaxBackoff {
+			return Transfer{}, fmt.Errorf("transfer retry min backoff %s is greater than max backoff %s", r.MinBackoff, r.MaxBackoff)
+		}
+		req.Retry = &types.RetryPolicy{
+			MaxAttempts: int(r.MaxAttempts),
+			MinBackoff:  r.MinBackoff,
+			MaxBackoff:  r.MaxBackoff,
+		}
+	}
+
+	paramsBytes, err := json.Marshal(req)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("marshalling transfer params: %w", err)
+	}
+	t := p.Transfer
+	t.Params = paramsBytes
+	return t, nil
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferWithOptions(t *testing.T) {
+	reqBytes, err := json.Marshal(types.HttpRequest{URL: "https://example.com/data.car", Headers: map[string]string{"Authorization": "secret"}})
+	require.NoError(t, err)
+	params := DealParams{
+		Transfer: Transfer{Type: "http", Params: reqBytes, Size: 100},
+	}
+
+	// Without transfer options the transfer is unchanged
+	xfer, err := params.TransferWithOptions()
+	require.NoError(t, err)
+	require.Equal(t, params.Transfer, xfer)
+
+	// The alternate urls are added as mirrors that are tried in order, and
+	// the retry policy overrides the provider's
+	params.AlternateURLs = []AlternateURL{{URL: "https://mirror1.example.com/data.car"}, {URL: "http://mirror2.example.com/data.car"}}
+	params.TransferRetry = &TransferRetryPolicy{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Minute}
+	xfer, err = params.TransferWithOptions()
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), xfer.Size)
+
+	var req types.HttpRequest
+	require.NoError(t, json.Unmarshal(xfer.Params, &req))
+	require.Equal(t, "https://example.com/data.car", req.URL)
+	require.True(t, req.MirrorsInOrder)
+	require.Equal(t, []types.HttpMirror{
+		{URL: "https://mirror1.example.com/data.car", Priority: 1},
+		{URL: "http://mirror2.example.com/data.car", Priority: 2},
+	}, req.Mirrors)
+	require.Equal(t, &types.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Minute}, req.Retry)
+
+	// The deal's own transfer params are not modified
+	require.Equal(t, reqBytes, params.Transfer.Params)
+
+	// Invalid options
+	invalid := []func(p *DealParams){
+		func(p *DealParams) { p.IsOffline = true },
+		func(p *DealParams) { p.Transfer.Type = "tcp" },
+		func(p *DealParams) { p.AlternateURLs = []AlternateURL{{URL: "ftp://example.com/data.car"}} },
+		func(p *DealParams) { p.AlternateURLs = make([]AlternateURL, MaxAlternateURLs+1) },
+		func(p *DealParams) {
+			p.TransferRetry = &TransferRetryPolicy{MinBackoff: time.Minute, MaxBackoff: time.Second}
+		},
+		func(p *DealParams) { p.TransferRetry = &TransferRetryPolicy{MinBackoff: -time.Second} },
+		func(p *DealParams) {
+			p.Transfer.Params, _ = json.Marshal(types.HttpRequest{URL: "https://example.com", Mirrors: []types.HttpMirror{{URL: "https://m.example.com"}}})
+		},
+	}
+	for i, modify := range invalid {
+		p := params
+		modify(&p)
+		_, err := p.TransferWithOptions()
+		require.Error(t, err, "case %d", i)
+	}
+}
+
+func TestDealParamsTransferOptionsEncoding(t *testing.T) {
+	c, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	provider, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	params := DealParams{
+		ClientDealProposal: market.ClientDealProposal{
+			Proposal: market.DealProposal{
+				PieceCID:             c,
+				Client:               client,
+				Provider:             provider,
+				StoragePricePerEpoch: big.Zero(),
+				ProviderCollateral:   big.Zero(),
+				ClientCollateral:     big.Zero(),
+			},
+			ClientSignature: crypto.Signature{Type: crypto.SigTypeBLS, Data: []byte{1}},
+		},
+		DealDataRoot:  c,
+		Transfer:      Transfer{Type: "http", Params: []byte("{}")},
+		AlternateURLs: []AlternateURL{{URL: "https://mirror.example.com/data.car"}},
+		TransferRetry: &TransferRetryPolicy{MaxAttempts: 5, MaxBackoff: time.Minute},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, params.MarshalCBOR(&buf))
+
+	var decoded DealParams
+	require.NoError(t, decoded.UnmarshalCBOR(&buf))
+	require.Equal(t, params.AlternateURLs, decoded.AlternateURLs)
+	require.Equal(t, params.TransferRetry, decoded.TransferRetry)
+	require.True(t, decoded.HasTransferOptions())
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransferWindow is a daily time window in which inbound deal data
+// transfers may start. Unlike off-peak windows, which are announced to
+// clients and so are in UTC, transfer windows are in the provider's local
+// time.
+type TransferWindow struct {
+	// The start of the window in minutes after local midnight
+	Start uint64
+	// The end of the window in minutes after local midnight. If End is before
+	// Start the window spans midnight.
+	End uint64
+}
+
+// ParseTransferWindow parses a transfer window from its start and end times
+// in the format HH:MM (local time), eg 00:00 and 06:00
+func ParseTransferWindow(start, end string) (TransferWindow, error) {
+	s, err := parseMinuteOfDay(start)
+	if err != nil {
+		return TransferWindow{}, fmt.Errorf("parsing transfer window start: %w", err)
+	}
+	e, err := parseMinuteOfDay(end)
+	if err != nil {
+		return TransferWindow{}, fmt.Errorf("parsing transfer window end: %w", err)
+	}
+	if s == e {
+		return TransferWindow{}, fmt.Errorf("transfer window start and end must be different")
+	}
+	return TransferWindow{Start: s, End: e}, nil
+}
+
+func (w TransferWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Contains returns true if t is inside the window, in t's location
+func (w TransferWindow) Contains(t time.Time) bool {
+	m := uint64(t.Hour()*60 + t.Minute())
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// Next returns t if t is inside the window, or otherwise the next time after
+// t at which the window starts
+func (w TransferWindow) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	start := int(w.Start % minutesPerDay)
+	next := time.Date(t.Year(), t.Month(), t.Day(), start/60, start%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, start/60, start%60, 0, 0, t.Location())
+	}
+	return next
+}
+
+// TransferSchedule is the windows in which transfers of each transfer type
+// (eg "http") may start. The windows under the empty transfer type apply to
+// transfer types that don't have windows of their own. Transfers of a type
+// without any windows may start at any time.
+type TransferSchedule map[string][]TransferWindow
+
+func (s TransferSchedule) windows(transferType string) []TransferWindow {
+	if ws, ok := s[transferType]; ok {
+		return ws
+	}
+	return s[""]
+}
+
+// Open returns true if transfers of the transfer type may start at t
+func (s TransferSchedule) Open(transferType string, t time.Time) bool {
+	ws := s.windows(transferType)
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the earliest time at or after t at which transfers of
+// the transfer type may start
+func (s TransferSchedule) NextOpen(transferType string, t time.Time) time.Time {
+	ws := s.windows(transferType)
+	if len(ws) == 0 {
+		return t
+	}
+	var next time.Time
+	for i, w := range ws {
+		if n := w.Next(t); i == 0 || n.Before(next) {
+			next = n
+		}
+	}
+	return next
+}
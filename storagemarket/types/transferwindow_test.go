@@ -0,0 +1,56 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferSchedule(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	at := func(hhmm string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", "2023-01-10 "+hhmm, loc)
+		require.NoError(t, err)
+		return tm
+	}
+
+	_, err := ParseTransferWindow("06:00", "06:00")
+	require.Error(t, err)
+	_, err = ParseTransferWindow("6am", "08:00")
+	require.Error(t, err)
+
+	// Windows are in the location of the time they are checked against
+	night, err := ParseTransferWindow("00:00", "06:00")
+	require.NoError(t, err)
+	require.Equal(t, "00:00-06:00", night.String())
+	require.True(t, night.Contains(at("01:00")))
+	require.False(t, night.Contains(at("06:00")))
+	require.Equal(t, at("00:00").AddDate(0, 0, 1), night.Next(at("07:00")))
+
+	evening, err := ParseTransferWindow("20:00", "02:00")
+	require.NoError(t, err)
+	require.True(t, evening.Contains(at("23:00")))
+	require.True(t, evening.Contains(at("01:00")))
+	require.Equal(t, at("20:00"), evening.Next(at("12:00")))
+
+	sched := TransferSchedule{
+		"":       {night},
+		"http":   {night, evening},
+		"libp2p": {},
+	}
+	// Transfer types without windows of their own use the default windows
+	require.False(t, sched.Open("graphsync", at("12:00")))
+	require.Equal(t, at("00:00").AddDate(0, 0, 1), sched.NextOpen("graphsync", at("12:00")))
+	require.True(t, sched.Open("graphsync", at("03:00")))
+
+	// A transfer type may have several windows
+	require.False(t, sched.Open("http", at("12:00")))
+	require.Equal(t, at("20:00"), sched.NextOpen("http", at("12:00")))
+	require.True(t, sched.Open("http", at("21:00")))
+	require.Equal(t, at("21:00"), sched.NextOpen("http", at("21:00")))
+
+	// A transfer type with an empty list of windows is never restricted
+	require.True(t, sched.Open("libp2p", at("12:00")))
+	require.True(t, TransferSchedule(nil).Open("http", at("12:00")))
+}
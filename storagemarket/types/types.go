@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
+	"time"
 
 	"github.com/filecoin-project/boost/sealingpipeline"
 	"github.com/filecoin-project/boost/transport/httptransport/util"
@@ -23,7 +25,7 @@ import (
 	"github.com/ipfs/go-cid"
 )
 
-//go:generate cbor-gen-for --map-encoding StorageAsk DealParams Transfer DealResponse DealStatusRequest DealStatusResponse DealStatus
+//go:generate cbor-gen-for --map-encoding StorageAsk DealParams TransferRetryPolicy AlternateURL Transfer DealResponse DealStatusRequest DealStatusResponse DealStatus CapacityReservation CapacityReservationRequest CapacityReservationResponse CapacityReservationStatusRequest CapacityReservationStatusResponse CapacityReservationStatus RetrievalStatsQuery RetrievalStatsRequest RetrievalStatsResponse PieceRetrievalStats ProviderRegionResponse ProviderOffPeakResponse OffPeakWindow ProviderFilterRulesResponse PublishedFilterRule DealCancel DealCancelRequest DealCancelResponse
 //go:generate go run github.com/golang/mock/mockgen -destination=mock_types/mocks.go -package=mock_types . PieceAdder,CommpCalculator,DealPublisher,ChainDealManager
 
 // StorageAsk defines the parameters by which a miner will choose to accept or
@@ -57,6 +59,11 @@ type DealStatusResponse struct {
 	IsOffline      bool
 	TransferSize   uint64
 	NBytesReceived uint64
+	// TransferOrigin identifies the origin that the deal data was
+	// transferred from (or is being transferred from), eg one of the
+	// alternate URLs of the deal. Credentials and query parameters are
+	// stripped from URLs.
+	TransferOrigin string
 }
 
 type DealStatus struct {
@@ -83,11 +90,50 @@ type DealParams struct {
 	ClientDealProposal market.ClientDealProposal
 	DealDataRoot       cid.Cid
 	Transfer           Transfer // Transfer params will be the zero value if this is an offline deal
+
+	// Per-deal overrides of the provider's timeouts. Zero means use the
+	// provider's default.
+	// The maximum time to wait in the transfer queue for the transfer to start
+	TransferStartTimeout time.Duration
+	// The maximum time for the data transfer to complete, once it has started
+	TransferTimeout time.Duration
+	// The maximum time from when the provider accepts the deal until it
+	// publishes the deal. The provider fails the deal if the data has not
+	// been transferred and verified by then.
+	CompletionTimeout time.Duration
+
+	// Transfer options, that are sent with deal protocol v1.3 or later.
+	// They only apply to http transfers.
+	// The policy for retrying the data transfer when it fails
+	TransferRetry *TransferRetryPolicy
+	// Other URLs that serve the deal data. If the transfer from the
+	// transfer URL fails, the provider tries the alternate URLs in order.
+	AlternateURLs []AlternateURL
+}
+
+// AlternateURL is another http origin for the deal data
+type AlternateURL struct {
+	// URL must be an http or https URL. The headers of the transfer are sent
+	// with requests to the URL.
+	URL string
+}
+
+// TransferRetryPolicy is the client's policy for retrying a data transfer
+// that fails. Zero means use the provider's default.
+type TransferRetryPolicy struct {
+	// The maximum number of attempts to make to transfer the data
+	MaxAttempts uint64
+	// The time to wait before the first retry. The wait increases with
+	// each retry, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
 }
 
 type DealFilterParams struct {
 	DealParams           *DealParams
 	SealingPipelineState *sealingpipeline.Status
+	// The epoch of the chain head when the deal was proposed
+	ChainHead abi.ChainEpoch
 }
 
 // Transfer has the parameters for a data transfer
@@ -104,6 +150,28 @@ type Transfer struct {
 }
 
 func (t *Transfer) Host() (string, error) {
+	if t.Type == "tcp" {
+		tInfo := &types.TcpRequest{}
+		if err := json.Unmarshal(t.Params, tInfo); err != nil {
+			return "", fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(t.Params), err)
+		}
+		if _, _, err := net.SplitHostPort(tInfo.Addr); err != nil {
+			return "", fmt.Errorf("cannot parse address '%s': %w", tInfo.Addr, err)
+		}
+		return tInfo.Addr, nil
+	}
+	if t.Type == "s3" {
+		// Transfers from the same bucket share a host
+		tInfo := &types.S3Request{}
+		if err := json.Unmarshal(t.Params, tInfo); err != nil {
+			return "", fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(t.Params), err)
+		}
+		u, err := url.Parse(tInfo.URL)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("cannot parse s3 url '%s'", tInfo.URL)
+		}
+		return u.Host, nil
+	}
 	if t.Type != "http" && t.Type != "libp2p" {
 		return "", fmt.Errorf("cannot parse params for unrecognized transfer type '%s'", t.Type)
 	}
@@ -12,6 +12,7 @@ import (
 	cid "github.com/ipfs/go-cid"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	xerrors "golang.org/x/xerrors"
+	time "time"
 )
 
 var _ = xerrors.Errorf
@@ -228,7 +229,7 @@ func (t *DealParams) MarshalCBOR(w io.Writer) error {
 
 	cw := cbg.NewCborWriter(w)
 
-	if _, err := cw.Write([]byte{165}); err != nil {
+	if _, err := cw.Write([]byte{170}); err != nil {
 		return err
 	}
 
@@ -319,6 +320,113 @@ func (t *DealParams) MarshalCBOR(w io.Writer) error {
 	if err := t.Transfer.MarshalCBOR(cw); err != nil {
 		return err
 	}
+
+	// t.TransferStartTimeout (time.Duration) (int64)
+	if len("TransferStartTimeout") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferStartTimeout\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("TransferStartTimeout"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferStartTimeout")); err != nil {
+		return err
+	}
+
+	if t.TransferStartTimeout >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.TransferStartTimeout)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.TransferStartTimeout-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.TransferTimeout (time.Duration) (int64)
+	if len("TransferTimeout") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferTimeout\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("TransferTimeout"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferTimeout")); err != nil {
+		return err
+	}
+
+	if t.TransferTimeout >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.TransferTimeout)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.TransferTimeout-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.CompletionTimeout (time.Duration) (int64)
+	if len("CompletionTimeout") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"CompletionTimeout\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("CompletionTimeout"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("CompletionTimeout")); err != nil {
+		return err
+	}
+
+	if t.CompletionTimeout >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.CompletionTimeout)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.CompletionTimeout-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.TransferRetry (types.TransferRetryPolicy) (struct)
+	if len("TransferRetry") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferRetry\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("TransferRetry"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferRetry")); err != nil {
+		return err
+	}
+
+	if err := t.TransferRetry.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.AlternateURLs ([]types.AlternateURL) (slice)
+	if len("AlternateURLs") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"AlternateURLs\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("AlternateURLs"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("AlternateURLs")); err != nil {
+		return err
+	}
+
+	if len(t.AlternateURLs) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.AlternateURLs was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(t.AlternateURLs))); err != nil {
+		return err
+	}
+	for _, v := range t.AlternateURLs {
+		if err := v.MarshalCBOR(cw); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -435,6 +543,133 @@ func (t *DealParams) UnmarshalCBOR(r io.Reader) (err error) {
 				}
 
 			}
+			// t.TransferStartTimeout (time.Duration) (int64)
+		case "TransferStartTimeout":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.TransferStartTimeout = time.Duration(extraI)
+			}
+			// t.TransferTimeout (time.Duration) (int64)
+		case "TransferTimeout":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.TransferTimeout = time.Duration(extraI)
+			}
+			// t.CompletionTimeout (time.Duration) (int64)
+		case "CompletionTimeout":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.CompletionTimeout = time.Duration(extraI)
+			}
+			// t.TransferRetry (types.TransferRetryPolicy) (struct)
+		case "TransferRetry":
+
+			{
+
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+					t.TransferRetry = new(TransferRetryPolicy)
+					if err := t.TransferRetry.UnmarshalCBOR(cr); err != nil {
+						return xerrors.Errorf("unmarshaling t.TransferRetry pointer: %w", err)
+					}
+				}
+
+			}
+			// t.AlternateURLs ([]types.AlternateURL) (slice)
+		case "AlternateURLs":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.AlternateURLs: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.AlternateURLs = make([]AlternateURL, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				var v AlternateURL
+				if err := v.UnmarshalCBOR(cr); err != nil {
+					return err
+				}
+
+				t.AlternateURLs[i] = v
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -444,7 +679,7 @@ func (t *DealParams) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
-func (t *Transfer) MarshalCBOR(w io.Writer) error {
+func (t *TransferRetryPolicy) MarshalCBOR(w io.Writer) error {
 	if t == nil {
 		_, err := w.Write(cbg.CborNull)
 		return err
@@ -452,101 +687,74 @@ func (t *Transfer) MarshalCBOR(w io.Writer) error {
 
 	cw := cbg.NewCborWriter(w)
 
-	if _, err := cw.Write([]byte{164}); err != nil {
-		return err
-	}
-
-	// t.Type (string) (string)
-	if len("Type") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Type\" was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Type"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("Type")); err != nil {
-		return err
-	}
-
-	if len(t.Type) > cbg.MaxLength {
-		return xerrors.Errorf("Value in field t.Type was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Type))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string(t.Type)); err != nil {
+	if _, err := cw.Write([]byte{163}); err != nil {
 		return err
 	}
 
-	// t.ClientID (string) (string)
-	if len("ClientID") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"ClientID\" was too long")
+	// t.MaxAttempts (uint64) (uint64)
+	if len("MaxAttempts") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MaxAttempts\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ClientID"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("MaxAttempts"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("ClientID")); err != nil {
+	if _, err := io.WriteString(w, string("MaxAttempts")); err != nil {
 		return err
 	}
 
-	if len(t.ClientID) > cbg.MaxLength {
-		return xerrors.Errorf("Value in field t.ClientID was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.ClientID))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string(t.ClientID)); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.MaxAttempts)); err != nil {
 		return err
 	}
 
-	// t.Params ([]uint8) (slice)
-	if len("Params") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Params\" was too long")
+	// t.MinBackoff (time.Duration) (int64)
+	if len("MinBackoff") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MinBackoff\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Params"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("Params")); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("MinBackoff"))); err != nil {
 		return err
 	}
-
-	if len(t.Params) > cbg.ByteArrayMaxLen {
-		return xerrors.Errorf("Byte array in field t.Params was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.Params))); err != nil {
+	if _, err := io.WriteString(w, string("MinBackoff")); err != nil {
 		return err
 	}
 
-	if _, err := cw.Write(t.Params[:]); err != nil {
-		return err
+	if t.MinBackoff >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.MinBackoff)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.MinBackoff-1)); err != nil {
+			return err
+		}
 	}
 
-	// t.Size (uint64) (uint64)
-	if len("Size") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Size\" was too long")
+	// t.MaxBackoff (time.Duration) (int64)
+	if len("MaxBackoff") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MaxBackoff\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Size"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("MaxBackoff"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("Size")); err != nil {
+	if _, err := io.WriteString(w, string("MaxBackoff")); err != nil {
 		return err
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Size)); err != nil {
-		return err
+	if t.MaxBackoff >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.MaxBackoff)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.MaxBackoff-1)); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
-func (t *Transfer) UnmarshalCBOR(r io.Reader) (err error) {
-	*t = Transfer{}
+func (t *TransferRetryPolicy) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = TransferRetryPolicy{}
 
 	cr := cbg.NewCborReader(r)
 
@@ -565,7 +773,7 @@ func (t *Transfer) UnmarshalCBOR(r io.Reader) (err error) {
 	}
 
 	if extra > cbg.MaxLength {
-		return fmt.Errorf("Transfer: map struct too large (%d)", extra)
+		return fmt.Errorf("TransferRetryPolicy: map struct too large (%d)", extra)
 	}
 
 	var name string
@@ -583,64 +791,72 @@ func (t *Transfer) UnmarshalCBOR(r io.Reader) (err error) {
 		}
 
 		switch name {
-		// t.Type (string) (string)
-		case "Type":
+		// t.MaxAttempts (uint64) (uint64)
+		case "MaxAttempts":
 
 			{
-				sval, err := cbg.ReadString(cr)
+
+				maj, extra, err = cr.ReadHeader()
 				if err != nil {
 					return err
 				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.MaxAttempts = uint64(extra)
 
-				t.Type = string(sval)
 			}
-			// t.ClientID (string) (string)
-		case "ClientID":
-
+			// t.MinBackoff (time.Duration) (int64)
+		case "MinBackoff":
 			{
-				sval, err := cbg.ReadString(cr)
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
 				if err != nil {
 					return err
 				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
 
-				t.ClientID = string(sval)
-			}
-			// t.Params ([]uint8) (slice)
-		case "Params":
-
-			maj, extra, err = cr.ReadHeader()
-			if err != nil {
-				return err
-			}
-
-			if extra > cbg.ByteArrayMaxLen {
-				return fmt.Errorf("t.Params: byte array too large (%d)", extra)
-			}
-			if maj != cbg.MajByteString {
-				return fmt.Errorf("expected byte array")
-			}
-
-			if extra > 0 {
-				t.Params = make([]uint8, extra)
-			}
-
-			if _, err := io.ReadFull(cr, t.Params[:]); err != nil {
-				return err
+				t.MinBackoff = time.Duration(extraI)
 			}
-			// t.Size (uint64) (uint64)
-		case "Size":
-
+			// t.MaxBackoff (time.Duration) (int64)
+		case "MaxBackoff":
 			{
-
-				maj, extra, err = cr.ReadHeader()
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
 				if err != nil {
 					return err
 				}
-				if maj != cbg.MajUnsignedInt {
-					return fmt.Errorf("wrong type for uint64 field")
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
 				}
-				t.Size = uint64(extra)
 
+				t.MaxBackoff = time.Duration(extraI)
 			}
 
 		default:
@@ -651,7 +867,7 @@ func (t *Transfer) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
-func (t *DealResponse) MarshalCBOR(w io.Writer) error {
+func (t *AlternateURL) MarshalCBOR(w io.Writer) error {
 	if t == nil {
 		_, err := w.Write(cbg.CborNull)
 		return err
@@ -659,53 +875,37 @@ func (t *DealResponse) MarshalCBOR(w io.Writer) error {
 
 	cw := cbg.NewCborWriter(w)
 
-	if _, err := cw.Write([]byte{162}); err != nil {
+	if _, err := cw.Write([]byte{161}); err != nil {
 		return err
 	}
 
-	// t.Accepted (bool) (bool)
-	if len("Accepted") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Accepted\" was too long")
+	// t.URL (string) (string)
+	if len("URL") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"URL\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Accepted"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("Accepted")); err != nil {
-		return err
-	}
-
-	if err := cbg.WriteBool(w, t.Accepted); err != nil {
-		return err
-	}
-
-	// t.Message (string) (string)
-	if len("Message") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Message\" was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Message"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("URL"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("Message")); err != nil {
+	if _, err := io.WriteString(w, string("URL")); err != nil {
 		return err
 	}
 
-	if len(t.Message) > cbg.MaxLength {
-		return xerrors.Errorf("Value in field t.Message was too long")
+	if len(t.URL) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.URL was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Message))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.URL))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+	if _, err := io.WriteString(w, string(t.URL)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
-	*t = DealResponse{}
+func (t *AlternateURL) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = AlternateURL{}
 
 	cr := cbg.NewCborReader(r)
 
@@ -724,7 +924,7 @@ func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
 	}
 
 	if extra > cbg.MaxLength {
-		return fmt.Errorf("DealResponse: map struct too large (%d)", extra)
+		return fmt.Errorf("AlternateURL: map struct too large (%d)", extra)
 	}
 
 	var name string
@@ -742,26 +942,8 @@ func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
 		}
 
 		switch name {
-		// t.Accepted (bool) (bool)
-		case "Accepted":
-
-			maj, extra, err = cr.ReadHeader()
-			if err != nil {
-				return err
-			}
-			if maj != cbg.MajOther {
-				return fmt.Errorf("booleans must be major type 7")
-			}
-			switch extra {
-			case 20:
-				t.Accepted = false
-			case 21:
-				t.Accepted = true
-			default:
-				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
-			}
-			// t.Message (string) (string)
-		case "Message":
+		// t.URL (string) (string)
+		case "URL":
 
 			{
 				sval, err := cbg.ReadString(cr)
@@ -769,7 +951,7 @@ func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
 					return err
 				}
 
-				t.Message = string(sval)
+				t.URL = string(sval)
 			}
 
 		default:
@@ -780,7 +962,7 @@ func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
-func (t *DealStatusRequest) MarshalCBOR(w io.Writer) error {
+func (t *Transfer) MarshalCBOR(w io.Writer) error {
 	if t == nil {
 		_, err := w.Write(cbg.CborNull)
 		return err
@@ -788,54 +970,101 @@ func (t *DealStatusRequest) MarshalCBOR(w io.Writer) error {
 
 	cw := cbg.NewCborWriter(w)
 
-	if _, err := cw.Write([]byte{162}); err != nil {
+	if _, err := cw.Write([]byte{164}); err != nil {
 		return err
 	}
 
-	// t.DealUUID (uuid.UUID) (array)
-	if len("DealUUID") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"DealUUID\" was too long")
+	// t.Type (string) (string)
+	if len("Type") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Type\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealUUID"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Type"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("DealUUID")); err != nil {
+	if _, err := io.WriteString(w, string("Type")); err != nil {
 		return err
 	}
 
-	if len(t.DealUUID) > cbg.ByteArrayMaxLen {
-		return xerrors.Errorf("Byte array in field t.DealUUID was too long")
+	if len(t.Type) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Type was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.DealUUID))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Type))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Type)); err != nil {
 		return err
 	}
 
-	if _, err := cw.Write(t.DealUUID[:]); err != nil {
+	// t.ClientID (string) (string)
+	if len("ClientID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ClientID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ClientID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ClientID")); err != nil {
 		return err
 	}
 
-	// t.Signature (crypto.Signature) (struct)
-	if len("Signature") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	if len(t.ClientID) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.ClientID was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Signature"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.ClientID))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("Signature")); err != nil {
+	if _, err := io.WriteString(w, string(t.ClientID)); err != nil {
 		return err
 	}
 
-	if err := t.Signature.MarshalCBOR(cw); err != nil {
+	// t.Params ([]uint8) (slice)
+	if len("Params") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Params\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Params"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Params")); err != nil {
+		return err
+	}
+
+	if len(t.Params) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.Params was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.Params))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.Params[:]); err != nil {
+		return err
+	}
+
+	// t.Size (uint64) (uint64)
+	if len("Size") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Size\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Size"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Size")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Size)); err != nil {
 		return err
 	}
+
 	return nil
 }
 
-func (t *DealStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
-	*t = DealStatusRequest{}
+func (t *Transfer) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = Transfer{}
 
 	cr := cbg.NewCborReader(r)
 
@@ -854,7 +1083,7 @@ func (t *DealStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
 	}
 
 	if extra > cbg.MaxLength {
-		return fmt.Errorf("DealStatusRequest: map struct too large (%d)", extra)
+		return fmt.Errorf("Transfer: map struct too large (%d)", extra)
 	}
 
 	var name string
@@ -872,8 +1101,30 @@ func (t *DealStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
 		}
 
 		switch name {
-		// t.DealUUID (uuid.UUID) (array)
-		case "DealUUID":
+		// t.Type (string) (string)
+		case "Type":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Type = string(sval)
+			}
+			// t.ClientID (string) (string)
+		case "ClientID":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.ClientID = string(sval)
+			}
+			// t.Params ([]uint8) (slice)
+		case "Params":
 
 			maj, extra, err = cr.ReadHeader()
 			if err != nil {
@@ -881,29 +1132,32 @@ func (t *DealStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
 			}
 
 			if extra > cbg.ByteArrayMaxLen {
-				return fmt.Errorf("t.DealUUID: byte array too large (%d)", extra)
+				return fmt.Errorf("t.Params: byte array too large (%d)", extra)
 			}
 			if maj != cbg.MajByteString {
 				return fmt.Errorf("expected byte array")
 			}
 
-			if extra != 16 {
-				return fmt.Errorf("expected array to have 16 elements")
+			if extra > 0 {
+				t.Params = make([]uint8, extra)
 			}
 
-			t.DealUUID = [16]uint8{}
-
-			if _, err := io.ReadFull(cr, t.DealUUID[:]); err != nil {
+			if _, err := io.ReadFull(cr, t.Params[:]); err != nil {
 				return err
 			}
-			// t.Signature (crypto.Signature) (struct)
-		case "Signature":
+			// t.Size (uint64) (uint64)
+		case "Size":
 
 			{
 
-				if err := t.Signature.UnmarshalCBOR(cr); err != nil {
-					return xerrors.Errorf("unmarshaling t.Signature: %w", err)
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
 				}
+				t.Size = uint64(extra)
 
 			}
 
@@ -915,7 +1169,7 @@ func (t *DealStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
-func (t *DealStatusResponse) MarshalCBOR(w io.Writer) error {
+func (t *DealResponse) MarshalCBOR(w io.Writer) error {
 	if t == nil {
 		_, err := w.Write(cbg.CborNull)
 		return err
@@ -923,126 +1177,183 @@ func (t *DealStatusResponse) MarshalCBOR(w io.Writer) error {
 
 	cw := cbg.NewCborWriter(w)
 
-	if _, err := cw.Write([]byte{166}); err != nil {
+	if _, err := cw.Write([]byte{162}); err != nil {
 		return err
 	}
 
-	// t.DealUUID (uuid.UUID) (array)
-	if len("DealUUID") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"DealUUID\" was too long")
+	// t.Accepted (bool) (bool)
+	if len("Accepted") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Accepted\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealUUID"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("DealUUID")); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Accepted"))); err != nil {
 		return err
 	}
-
-	if len(t.DealUUID) > cbg.ByteArrayMaxLen {
-		return xerrors.Errorf("Byte array in field t.DealUUID was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.DealUUID))); err != nil {
+	if _, err := io.WriteString(w, string("Accepted")); err != nil {
 		return err
 	}
 
-	if _, err := cw.Write(t.DealUUID[:]); err != nil {
+	if err := cbg.WriteBool(w, t.Accepted); err != nil {
 		return err
 	}
 
-	// t.Error (string) (string)
-	if len("Error") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Error\" was too long")
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Error"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Message"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("Error")); err != nil {
+	if _, err := io.WriteString(w, string("Message")); err != nil {
 		return err
 	}
 
-	if len(t.Error) > cbg.MaxLength {
-		return xerrors.Errorf("Value in field t.Error was too long")
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Error))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Message))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string(t.Error)); err != nil {
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
 		return err
 	}
+	return nil
+}
 
-	// t.DealStatus (types.DealStatus) (struct)
-	if len("DealStatus") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"DealStatus\" was too long")
-	}
+func (t *DealResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealResponse{}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealStatus"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("DealStatus")); err != nil {
-		return err
-	}
+	cr := cbg.NewCborReader(r)
 
-	if err := t.DealStatus.MarshalCBOR(cw); err != nil {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
 
-	// t.IsOffline (bool) (bool)
-	if len("IsOffline") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"IsOffline\" was too long")
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("IsOffline"))); err != nil {
-		return err
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealResponse: map struct too large (%d)", extra)
 	}
-	if _, err := io.WriteString(w, string("IsOffline")); err != nil {
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Accepted (bool) (bool)
+		case "Accepted":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Accepted = false
+			case 21:
+				t.Accepted = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *DealStatusRequest) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
 		return err
 	}
 
-	if err := cbg.WriteBool(w, t.IsOffline); err != nil {
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
 		return err
 	}
 
-	// t.TransferSize (uint64) (uint64)
-	if len("TransferSize") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"TransferSize\" was too long")
+	// t.DealUUID (uuid.UUID) (array)
+	if len("DealUUID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealUUID\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("TransferSize"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealUUID"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("TransferSize")); err != nil {
+	if _, err := io.WriteString(w, string("DealUUID")); err != nil {
 		return err
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.TransferSize)); err != nil {
+	if len(t.DealUUID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.DealUUID was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.DealUUID))); err != nil {
 		return err
 	}
 
-	// t.NBytesReceived (uint64) (uint64)
-	if len("NBytesReceived") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"NBytesReceived\" was too long")
+	if _, err := cw.Write(t.DealUUID[:]); err != nil {
+		return err
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("NBytesReceived"))); err != nil {
+	// t.Signature (crypto.Signature) (struct)
+	if len("Signature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Signature"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("NBytesReceived")); err != nil {
+	if _, err := io.WriteString(w, string("Signature")); err != nil {
 		return err
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.NBytesReceived)); err != nil {
+	if err := t.Signature.MarshalCBOR(cw); err != nil {
 		return err
 	}
-
 	return nil
 }
 
-func (t *DealStatusResponse) UnmarshalCBOR(r io.Reader) (err error) {
-	*t = DealStatusResponse{}
+func (t *DealStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealStatusRequest{}
 
 	cr := cbg.NewCborReader(r)
 
@@ -1061,7 +1372,7 @@ func (t *DealStatusResponse) UnmarshalCBOR(r io.Reader) (err error) {
 	}
 
 	if extra > cbg.MaxLength {
-		return fmt.Errorf("DealStatusResponse: map struct too large (%d)", extra)
+		return fmt.Errorf("DealStatusRequest: map struct too large (%d)", extra)
 	}
 
 	var name string
@@ -1103,83 +1414,14 @@ func (t *DealStatusResponse) UnmarshalCBOR(r io.Reader) (err error) {
 			if _, err := io.ReadFull(cr, t.DealUUID[:]); err != nil {
 				return err
 			}
-			// t.Error (string) (string)
-		case "Error":
-
-			{
-				sval, err := cbg.ReadString(cr)
-				if err != nil {
-					return err
-				}
-
-				t.Error = string(sval)
-			}
-			// t.DealStatus (types.DealStatus) (struct)
-		case "DealStatus":
-
-			{
-
-				b, err := cr.ReadByte()
-				if err != nil {
-					return err
-				}
-				if b != cbg.CborNull[0] {
-					if err := cr.UnreadByte(); err != nil {
-						return err
-					}
-					t.DealStatus = new(DealStatus)
-					if err := t.DealStatus.UnmarshalCBOR(cr); err != nil {
-						return xerrors.Errorf("unmarshaling t.DealStatus pointer: %w", err)
-					}
-				}
-
-			}
-			// t.IsOffline (bool) (bool)
-		case "IsOffline":
-
-			maj, extra, err = cr.ReadHeader()
-			if err != nil {
-				return err
-			}
-			if maj != cbg.MajOther {
-				return fmt.Errorf("booleans must be major type 7")
-			}
-			switch extra {
-			case 20:
-				t.IsOffline = false
-			case 21:
-				t.IsOffline = true
-			default:
-				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
-			}
-			// t.TransferSize (uint64) (uint64)
-		case "TransferSize":
-
-			{
-
-				maj, extra, err = cr.ReadHeader()
-				if err != nil {
-					return err
-				}
-				if maj != cbg.MajUnsignedInt {
-					return fmt.Errorf("wrong type for uint64 field")
-				}
-				t.TransferSize = uint64(extra)
-
-			}
-			// t.NBytesReceived (uint64) (uint64)
-		case "NBytesReceived":
+			// t.Signature (crypto.Signature) (struct)
+		case "Signature":
 
 			{
 
-				maj, extra, err = cr.ReadHeader()
-				if err != nil {
-					return err
-				}
-				if maj != cbg.MajUnsignedInt {
-					return fmt.Errorf("wrong type for uint64 field")
+				if err := t.Signature.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Signature: %w", err)
 				}
-				t.NBytesReceived = uint64(extra)
 
 			}
 
@@ -1191,7 +1433,7 @@ func (t *DealStatusResponse) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
-func (t *DealStatus) MarshalCBOR(w io.Writer) error {
+func (t *DealStatusResponse) MarshalCBOR(w io.Writer) error {
 	if t == nil {
 		_, err := w.Write(cbg.CborNull)
 		return err
@@ -1203,6 +1445,30 @@ func (t *DealStatus) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.DealUUID (uuid.UUID) (array)
+	if len("DealUUID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealUUID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealUUID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealUUID")); err != nil {
+		return err
+	}
+
+	if len(t.DealUUID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.DealUUID was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.DealUUID))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.DealUUID[:]); err != nil {
+		return err
+	}
+
 	// t.Error (string) (string)
 	if len("Error") > cbg.MaxLength {
 		return xerrors.Errorf("Value in field \"Error\" was too long")
@@ -1226,127 +1492,97 @@ func (t *DealStatus) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
-	// t.Status (string) (string)
-	if len("Status") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Status\" was too long")
+	// t.DealStatus (types.DealStatus) (struct)
+	if len("DealStatus") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealStatus\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Status"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealStatus"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("Status")); err != nil {
+	if _, err := io.WriteString(w, string("DealStatus")); err != nil {
 		return err
 	}
 
-	if len(t.Status) > cbg.MaxLength {
-		return xerrors.Errorf("Value in field t.Status was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Status))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string(t.Status)); err != nil {
+	if err := t.DealStatus.MarshalCBOR(cw); err != nil {
 		return err
 	}
 
-	// t.SealingStatus (string) (string)
-	if len("SealingStatus") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"SealingStatus\" was too long")
+	// t.IsOffline (bool) (bool)
+	if len("IsOffline") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"IsOffline\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("SealingStatus"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("IsOffline"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("SealingStatus")); err != nil {
+	if _, err := io.WriteString(w, string("IsOffline")); err != nil {
 		return err
 	}
 
-	if len(t.SealingStatus) > cbg.MaxLength {
-		return xerrors.Errorf("Value in field t.SealingStatus was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.SealingStatus))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string(t.SealingStatus)); err != nil {
+	if err := cbg.WriteBool(w, t.IsOffline); err != nil {
 		return err
 	}
 
-	// t.Proposal (market.DealProposal) (struct)
-	if len("Proposal") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Proposal\" was too long")
+	// t.TransferSize (uint64) (uint64)
+	if len("TransferSize") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferSize\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Proposal"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("TransferSize"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("Proposal")); err != nil {
+	if _, err := io.WriteString(w, string("TransferSize")); err != nil {
 		return err
 	}
 
-	if err := t.Proposal.MarshalCBOR(cw); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.TransferSize)); err != nil {
 		return err
 	}
 
-	// t.SignedProposalCid (cid.Cid) (struct)
-	if len("SignedProposalCid") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"SignedProposalCid\" was too long")
+	// t.NBytesReceived (uint64) (uint64)
+	if len("NBytesReceived") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"NBytesReceived\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("SignedProposalCid"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("NBytesReceived"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("SignedProposalCid")); err != nil {
+	if _, err := io.WriteString(w, string("NBytesReceived")); err != nil {
 		return err
 	}
 
-	if err := cbg.WriteCid(cw, t.SignedProposalCid); err != nil {
-		return xerrors.Errorf("failed to write cid field t.SignedProposalCid: %w", err)
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.NBytesReceived)); err != nil {
+		return err
 	}
 
-	// t.PublishCid (cid.Cid) (struct)
-	if len("PublishCid") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"PublishCid\" was too long")
+	// t.TransferOrigin (string) (string)
+	if len("TransferOrigin") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferOrigin\" was too long")
 	}
 
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("PublishCid"))); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("TransferOrigin"))); err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, string("PublishCid")); err != nil {
+	if _, err := io.WriteString(w, string("TransferOrigin")); err != nil {
 		return err
 	}
 
-	if t.PublishCid == nil {
-		if _, err := cw.Write(cbg.CborNull); err != nil {
-			return err
-		}
-	} else {
-		if err := cbg.WriteCid(cw, *t.PublishCid); err != nil {
-			return xerrors.Errorf("failed to write cid field t.PublishCid: %w", err)
-		}
+	if len(t.TransferOrigin) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.TransferOrigin was too long")
 	}
 
-	// t.ChainDealID (abi.DealID) (uint64)
-	if len("ChainDealID") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"ChainDealID\" was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ChainDealID"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("ChainDealID")); err != nil {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.TransferOrigin))); err != nil {
 		return err
 	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.ChainDealID)); err != nil {
+	if _, err := io.WriteString(w, string(t.TransferOrigin)); err != nil {
 		return err
 	}
-
 	return nil
 }
 
-func (t *DealStatus) UnmarshalCBOR(r io.Reader) (err error) {
-	*t = DealStatus{}
+func (t *DealStatusResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealStatusResponse{}
 
 	cr := cbg.NewCborReader(r)
 
@@ -1365,7 +1601,7 @@ func (t *DealStatus) UnmarshalCBOR(r io.Reader) (err error) {
 	}
 
 	if extra > cbg.MaxLength {
-		return fmt.Errorf("DealStatus: map struct too large (%d)", extra)
+		return fmt.Errorf("DealStatusResponse: map struct too large (%d)", extra)
 	}
 
 	var name string
@@ -1383,7 +1619,31 @@ func (t *DealStatus) UnmarshalCBOR(r io.Reader) (err error) {
 		}
 
 		switch name {
-		// t.Error (string) (string)
+		// t.DealUUID (uuid.UUID) (array)
+		case "DealUUID":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.DealUUID: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra != 16 {
+				return fmt.Errorf("expected array to have 16 elements")
+			}
+
+			t.DealUUID = [16]uint8{}
+
+			if _, err := io.ReadFull(cr, t.DealUUID[:]); err != nil {
+				return err
+			}
+			// t.Error (string) (string)
 		case "Error":
 
 			{
@@ -1394,88 +1654,3015 @@ func (t *DealStatus) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.Error = string(sval)
 			}
-			// t.Status (string) (string)
-		case "Status":
+			// t.DealStatus (types.DealStatus) (struct)
+		case "DealStatus":
 
 			{
-				sval, err := cbg.ReadString(cr)
+
+				b, err := cr.ReadByte()
 				if err != nil {
 					return err
 				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+					t.DealStatus = new(DealStatus)
+					if err := t.DealStatus.UnmarshalCBOR(cr); err != nil {
+						return xerrors.Errorf("unmarshaling t.DealStatus pointer: %w", err)
+					}
+				}
 
-				t.Status = string(sval)
 			}
-			// t.SealingStatus (string) (string)
-		case "SealingStatus":
+			// t.IsOffline (bool) (bool)
+		case "IsOffline":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.IsOffline = false
+			case 21:
+				t.IsOffline = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.TransferSize (uint64) (uint64)
+		case "TransferSize":
 
 			{
-				sval, err := cbg.ReadString(cr)
+
+				maj, extra, err = cr.ReadHeader()
 				if err != nil {
 					return err
 				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferSize = uint64(extra)
 
-				t.SealingStatus = string(sval)
 			}
-			// t.Proposal (market.DealProposal) (struct)
-		case "Proposal":
+			// t.NBytesReceived (uint64) (uint64)
+		case "NBytesReceived":
 
 			{
 
-				if err := t.Proposal.UnmarshalCBOR(cr); err != nil {
-					return xerrors.Errorf("unmarshaling t.Proposal: %w", err)
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
 				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.NBytesReceived = uint64(extra)
 
 			}
-			// t.SignedProposalCid (cid.Cid) (struct)
-		case "SignedProposalCid":
+			// t.TransferOrigin (string) (string)
+		case "TransferOrigin":
 
 			{
-
-				c, err := cbg.ReadCid(cr)
+				sval, err := cbg.ReadString(cr)
 				if err != nil {
-					return xerrors.Errorf("failed to read cid field t.SignedProposalCid: %w", err)
+					return err
 				}
 
-				t.SignedProposalCid = c
-
+				t.TransferOrigin = string(sval)
 			}
-			// t.PublishCid (cid.Cid) (struct)
-		case "PublishCid":
 
-			{
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
 
-				b, err := cr.ReadByte()
-				if err != nil {
-					return err
-				}
-				if b != cbg.CborNull[0] {
-					if err := cr.UnreadByte(); err != nil {
-						return err
-					}
+	return nil
+}
+func (t *DealStatus) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
 
-					c, err := cbg.ReadCid(cr)
-					if err != nil {
-						return xerrors.Errorf("failed to read cid field t.PublishCid: %w", err)
-					}
+	cw := cbg.NewCborWriter(w)
 
-					t.PublishCid = &c
-				}
+	if _, err := cw.Write([]byte{167}); err != nil {
+		return err
+	}
 
-			}
-			// t.ChainDealID (abi.DealID) (uint64)
-		case "ChainDealID":
+	// t.Error (string) (string)
+	if len("Error") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Error\" was too long")
+	}
 
-			{
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Error"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Error")); err != nil {
+		return err
+	}
 
-				maj, extra, err = cr.ReadHeader()
-				if err != nil {
-					return err
-				}
-				if maj != cbg.MajUnsignedInt {
-					return fmt.Errorf("wrong type for uint64 field")
-				}
-				t.ChainDealID = abi.DealID(extra)
+	if len(t.Error) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Error was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Error))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Error)); err != nil {
+		return err
+	}
+
+	// t.Status (string) (string)
+	if len("Status") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Status\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Status"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Status")); err != nil {
+		return err
+	}
+
+	if len(t.Status) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Status was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Status))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Status)); err != nil {
+		return err
+	}
+
+	// t.SealingStatus (string) (string)
+	if len("SealingStatus") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"SealingStatus\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("SealingStatus"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("SealingStatus")); err != nil {
+		return err
+	}
+
+	if len(t.SealingStatus) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.SealingStatus was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.SealingStatus))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.SealingStatus)); err != nil {
+		return err
+	}
 
+	// t.Proposal (market.DealProposal) (struct)
+	if len("Proposal") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Proposal\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Proposal"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Proposal")); err != nil {
+		return err
+	}
+
+	if err := t.Proposal.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.SignedProposalCid (cid.Cid) (struct)
+	if len("SignedProposalCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"SignedProposalCid\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("SignedProposalCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("SignedProposalCid")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCid(cw, t.SignedProposalCid); err != nil {
+		return xerrors.Errorf("failed to write cid field t.SignedProposalCid: %w", err)
+	}
+
+	// t.PublishCid (cid.Cid) (struct)
+	if len("PublishCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PublishCid\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("PublishCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PublishCid")); err != nil {
+		return err
+	}
+
+	if t.PublishCid == nil {
+		if _, err := cw.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCid(cw, *t.PublishCid); err != nil {
+			return xerrors.Errorf("failed to write cid field t.PublishCid: %w", err)
+		}
+	}
+
+	// t.ChainDealID (abi.DealID) (uint64)
+	if len("ChainDealID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ChainDealID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ChainDealID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ChainDealID")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.ChainDealID)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *DealStatus) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealStatus{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealStatus: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Error (string) (string)
+		case "Error":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Error = string(sval)
+			}
+			// t.Status (string) (string)
+		case "Status":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Status = string(sval)
+			}
+			// t.SealingStatus (string) (string)
+		case "SealingStatus":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.SealingStatus = string(sval)
+			}
+			// t.Proposal (market.DealProposal) (struct)
+		case "Proposal":
+
+			{
+
+				if err := t.Proposal.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Proposal: %w", err)
+				}
+
+			}
+			// t.SignedProposalCid (cid.Cid) (struct)
+		case "SignedProposalCid":
+
+			{
+
+				c, err := cbg.ReadCid(cr)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.SignedProposalCid: %w", err)
+				}
+
+				t.SignedProposalCid = c
+
+			}
+			// t.PublishCid (cid.Cid) (struct)
+		case "PublishCid":
+
+			{
+
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					c, err := cbg.ReadCid(cr)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.PublishCid: %w", err)
+					}
+
+					t.PublishCid = &c
+				}
+
+			}
+			// t.ChainDealID (abi.DealID) (uint64)
+		case "ChainDealID":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.ChainDealID = abi.DealID(extra)
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *CapacityReservation) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{167}); err != nil {
+		return err
+	}
+
+	// t.ID (uuid.UUID) (array)
+	if len("ID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ID")); err != nil {
+		return err
+	}
+
+	if len(t.ID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.ID was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.ID))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.ID[:]); err != nil {
+		return err
+	}
+
+	// t.Client (address.Address) (struct)
+	if len("Client") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Client\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Client"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Client")); err != nil {
+		return err
+	}
+
+	if err := t.Client.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Provider (address.Address) (struct)
+	if len("Provider") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Provider\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Provider"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Provider")); err != nil {
+		return err
+	}
+
+	if err := t.Provider.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Size (uint64) (uint64)
+	if len("Size") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Size\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Size"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Size")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Size)); err != nil {
+		return err
+	}
+
+	// t.StartEpoch (abi.ChainEpoch) (int64)
+	if len("StartEpoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"StartEpoch\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("StartEpoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("StartEpoch")); err != nil {
+		return err
+	}
+
+	if t.StartEpoch >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.StartEpoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.StartEpoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.EndEpoch (abi.ChainEpoch) (int64)
+	if len("EndEpoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"EndEpoch\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("EndEpoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("EndEpoch")); err != nil {
+		return err
+	}
+
+	if t.EndEpoch >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.EndEpoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.EndEpoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Deposit (big.Int) (struct)
+	if len("Deposit") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Deposit\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Deposit"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Deposit")); err != nil {
+		return err
+	}
+
+	if err := t.Deposit.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CapacityReservation) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CapacityReservation{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("CapacityReservation: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.ID (uuid.UUID) (array)
+		case "ID":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.ID: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra != 16 {
+				return fmt.Errorf("expected array to have 16 elements")
+			}
+
+			t.ID = [16]uint8{}
+
+			if _, err := io.ReadFull(cr, t.ID[:]); err != nil {
+				return err
+			}
+			// t.Client (address.Address) (struct)
+		case "Client":
+
+			{
+
+				if err := t.Client.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Client: %w", err)
+				}
+
+			}
+			// t.Provider (address.Address) (struct)
+		case "Provider":
+
+			{
+
+				if err := t.Provider.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Provider: %w", err)
+				}
+
+			}
+			// t.Size (uint64) (uint64)
+		case "Size":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Size = uint64(extra)
+
+			}
+			// t.StartEpoch (abi.ChainEpoch) (int64)
+		case "StartEpoch":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.StartEpoch = abi.ChainEpoch(extraI)
+			}
+			// t.EndEpoch (abi.ChainEpoch) (int64)
+		case "EndEpoch":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.EndEpoch = abi.ChainEpoch(extraI)
+			}
+			// t.Deposit (big.Int) (struct)
+		case "Deposit":
+
+			{
+
+				if err := t.Deposit.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Deposit: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *CapacityReservationRequest) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Reservation (types.CapacityReservation) (struct)
+	if len("Reservation") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Reservation\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Reservation"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Reservation")); err != nil {
+		return err
+	}
+
+	if err := t.Reservation.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Signature (crypto.Signature) (struct)
+	if len("Signature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Signature"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Signature")); err != nil {
+		return err
+	}
+
+	if err := t.Signature.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CapacityReservationRequest) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CapacityReservationRequest{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("CapacityReservationRequest: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Reservation (types.CapacityReservation) (struct)
+		case "Reservation":
+
+			{
+
+				if err := t.Reservation.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Reservation: %w", err)
+				}
+
+			}
+			// t.Signature (crypto.Signature) (struct)
+		case "Signature":
+
+			{
+
+				if err := t.Signature.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Signature: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *CapacityReservationResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Accepted (bool) (bool)
+	if len("Accepted") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Accepted\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Accepted"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Accepted")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Accepted); err != nil {
+		return err
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CapacityReservationResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CapacityReservationResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("CapacityReservationResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Accepted (bool) (bool)
+		case "Accepted":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Accepted = false
+			case 21:
+				t.Accepted = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *CapacityReservationStatusRequest) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.ReservationID (uuid.UUID) (array)
+	if len("ReservationID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ReservationID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ReservationID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ReservationID")); err != nil {
+		return err
+	}
+
+	if len(t.ReservationID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.ReservationID was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.ReservationID))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.ReservationID[:]); err != nil {
+		return err
+	}
+
+	// t.Signature (crypto.Signature) (struct)
+	if len("Signature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Signature"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Signature")); err != nil {
+		return err
+	}
+
+	if err := t.Signature.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CapacityReservationStatusRequest) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CapacityReservationStatusRequest{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("CapacityReservationStatusRequest: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.ReservationID (uuid.UUID) (array)
+		case "ReservationID":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.ReservationID: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra != 16 {
+				return fmt.Errorf("expected array to have 16 elements")
+			}
+
+			t.ReservationID = [16]uint8{}
+
+			if _, err := io.ReadFull(cr, t.ReservationID[:]); err != nil {
+				return err
+			}
+			// t.Signature (crypto.Signature) (struct)
+		case "Signature":
+
+			{
+
+				if err := t.Signature.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Signature: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *CapacityReservationStatusResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	// t.ReservationID (uuid.UUID) (array)
+	if len("ReservationID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ReservationID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("ReservationID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ReservationID")); err != nil {
+		return err
+	}
+
+	if len(t.ReservationID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.ReservationID was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.ReservationID))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.ReservationID[:]); err != nil {
+		return err
+	}
+
+	// t.Error (string) (string)
+	if len("Error") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Error\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Error"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Error")); err != nil {
+		return err
+	}
+
+	if len(t.Error) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Error was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Error))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Error)); err != nil {
+		return err
+	}
+
+	// t.Status (types.CapacityReservationStatus) (struct)
+	if len("Status") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Status\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Status"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Status")); err != nil {
+		return err
+	}
+
+	if err := t.Status.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CapacityReservationStatusResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CapacityReservationStatusResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("CapacityReservationStatusResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.ReservationID (uuid.UUID) (array)
+		case "ReservationID":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.ReservationID: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra != 16 {
+				return fmt.Errorf("expected array to have 16 elements")
+			}
+
+			t.ReservationID = [16]uint8{}
+
+			if _, err := io.ReadFull(cr, t.ReservationID[:]); err != nil {
+				return err
+			}
+			// t.Error (string) (string)
+		case "Error":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Error = string(sval)
+			}
+			// t.Status (types.CapacityReservationStatus) (struct)
+		case "Status":
+
+			{
+
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+					t.Status = new(CapacityReservationStatus)
+					if err := t.Status.UnmarshalCBOR(cr); err != nil {
+						return xerrors.Errorf("unmarshaling t.Status pointer: %w", err)
+					}
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *CapacityReservationStatus) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{164}); err != nil {
+		return err
+	}
+
+	// t.Reservation (types.CapacityReservation) (struct)
+	if len("Reservation") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Reservation\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Reservation"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Reservation")); err != nil {
+		return err
+	}
+
+	if err := t.Reservation.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Filled (uint64) (uint64)
+	if len("Filled") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Filled\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Filled"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Filled")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Filled)); err != nil {
+		return err
+	}
+
+	// t.DealCount (uint64) (uint64)
+	if len("DealCount") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealCount\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealCount"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealCount")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.DealCount)); err != nil {
+		return err
+	}
+
+	// t.State (string) (string)
+	if len("State") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"State\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("State"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("State")); err != nil {
+		return err
+	}
+
+	if len(t.State) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.State was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.State))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.State)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CapacityReservationStatus) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CapacityReservationStatus{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("CapacityReservationStatus: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Reservation (types.CapacityReservation) (struct)
+		case "Reservation":
+
+			{
+
+				if err := t.Reservation.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Reservation: %w", err)
+				}
+
+			}
+			// t.Filled (uint64) (uint64)
+		case "Filled":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Filled = uint64(extra)
+
+			}
+			// t.DealCount (uint64) (uint64)
+		case "DealCount":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.DealCount = uint64(extra)
+
+			}
+			// t.State (string) (string)
+		case "State":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.State = string(sval)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *RetrievalStatsQuery) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	// t.Client (address.Address) (struct)
+	if len("Client") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Client\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Client"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Client")); err != nil {
+		return err
+	}
+
+	if err := t.Client.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Provider (address.Address) (struct)
+	if len("Provider") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Provider\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Provider"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Provider")); err != nil {
+		return err
+	}
+
+	if err := t.Provider.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Timestamp (int64) (int64)
+	if len("Timestamp") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Timestamp\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Timestamp"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Timestamp")); err != nil {
+		return err
+	}
+
+	if t.Timestamp >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Timestamp)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.Timestamp-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *RetrievalStatsQuery) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = RetrievalStatsQuery{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("RetrievalStatsQuery: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Client (address.Address) (struct)
+		case "Client":
+
+			{
+
+				if err := t.Client.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Client: %w", err)
+				}
+
+			}
+			// t.Provider (address.Address) (struct)
+		case "Provider":
+
+			{
+
+				if err := t.Provider.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Provider: %w", err)
+				}
+
+			}
+			// t.Timestamp (int64) (int64)
+		case "Timestamp":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Timestamp = int64(extraI)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *RetrievalStatsRequest) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Query (types.RetrievalStatsQuery) (struct)
+	if len("Query") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Query\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Query"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Query")); err != nil {
+		return err
+	}
+
+	if err := t.Query.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Signature (crypto.Signature) (struct)
+	if len("Signature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Signature"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Signature")); err != nil {
+		return err
+	}
+
+	if err := t.Signature.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *RetrievalStatsRequest) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = RetrievalStatsRequest{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("RetrievalStatsRequest: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Query (types.RetrievalStatsQuery) (struct)
+		case "Query":
+
+			{
+
+				if err := t.Query.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Query: %w", err)
+				}
+
+			}
+			// t.Signature (crypto.Signature) (struct)
+		case "Signature":
+
+			{
+
+				if err := t.Signature.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Signature: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *RetrievalStatsResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Error (string) (string)
+	if len("Error") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Error\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Error"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Error")); err != nil {
+		return err
+	}
+
+	if len(t.Error) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Error was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Error))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Error)); err != nil {
+		return err
+	}
+
+	// t.Pieces ([]types.PieceRetrievalStats) (slice)
+	if len("Pieces") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Pieces\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Pieces"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Pieces")); err != nil {
+		return err
+	}
+
+	if len(t.Pieces) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Pieces was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(t.Pieces))); err != nil {
+		return err
+	}
+	for _, v := range t.Pieces {
+		if err := v.MarshalCBOR(cw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *RetrievalStatsResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = RetrievalStatsResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("RetrievalStatsResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Error (string) (string)
+		case "Error":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Error = string(sval)
+			}
+			// t.Pieces ([]types.PieceRetrievalStats) (slice)
+		case "Pieces":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Pieces: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Pieces = make([]PieceRetrievalStats, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				var v PieceRetrievalStats
+				if err := v.UnmarshalCBOR(cr); err != nil {
+					return err
+				}
+
+				t.Pieces[i] = v
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *PieceRetrievalStats) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{164}); err != nil {
+		return err
+	}
+
+	// t.PieceCid (cid.Cid) (struct)
+	if len("PieceCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PieceCid\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("PieceCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PieceCid")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCid(cw, t.PieceCid); err != nil {
+		return xerrors.Errorf("failed to write cid field t.PieceCid: %w", err)
+	}
+
+	// t.Retrievals (uint64) (uint64)
+	if len("Retrievals") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Retrievals\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Retrievals"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Retrievals")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Retrievals)); err != nil {
+		return err
+	}
+
+	// t.BytesServed (uint64) (uint64)
+	if len("BytesServed") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"BytesServed\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("BytesServed"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("BytesServed")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.BytesServed)); err != nil {
+		return err
+	}
+
+	// t.LastAccess (int64) (int64)
+	if len("LastAccess") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"LastAccess\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("LastAccess"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("LastAccess")); err != nil {
+		return err
+	}
+
+	if t.LastAccess >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.LastAccess)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.LastAccess-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *PieceRetrievalStats) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = PieceRetrievalStats{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("PieceRetrievalStats: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.PieceCid (cid.Cid) (struct)
+		case "PieceCid":
+
+			{
+
+				c, err := cbg.ReadCid(cr)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.PieceCid: %w", err)
+				}
+
+				t.PieceCid = c
+
+			}
+			// t.Retrievals (uint64) (uint64)
+		case "Retrievals":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Retrievals = uint64(extra)
+
+			}
+			// t.BytesServed (uint64) (uint64)
+		case "BytesServed":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.BytesServed = uint64(extra)
+
+			}
+			// t.LastAccess (int64) (int64)
+		case "LastAccess":
+			{
+				maj, extra, err := cr.ReadHeader()
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.LastAccess = int64(extraI)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *ProviderRegionResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	// t.Region (string) (string)
+	if len("Region") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Region\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Region"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Region")); err != nil {
+		return err
+	}
+
+	if len(t.Region) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Region was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Region))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Region)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ProviderRegionResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = ProviderRegionResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ProviderRegionResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Region (string) (string)
+		case "Region":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Region = string(sval)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *ProviderOffPeakResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	// t.Windows ([]types.OffPeakWindow) (slice)
+	if len("Windows") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Windows\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Windows"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Windows")); err != nil {
+		return err
+	}
+
+	if len(t.Windows) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Windows was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(t.Windows))); err != nil {
+		return err
+	}
+	for _, v := range t.Windows {
+		if err := v.MarshalCBOR(cw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *ProviderOffPeakResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = ProviderOffPeakResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ProviderOffPeakResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Windows ([]types.OffPeakWindow) (slice)
+		case "Windows":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Windows: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Windows = make([]OffPeakWindow, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				var v OffPeakWindow
+				if err := v.UnmarshalCBOR(cr); err != nil {
+					return err
+				}
+
+				t.Windows[i] = v
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *OffPeakWindow) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	// t.Start (uint64) (uint64)
+	if len("Start") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Start\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Start"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Start")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Start)); err != nil {
+		return err
+	}
+
+	// t.End (uint64) (uint64)
+	if len("End") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"End\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("End"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("End")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.End)); err != nil {
+		return err
+	}
+
+	// t.DiscountPercent (uint64) (uint64)
+	if len("DiscountPercent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DiscountPercent\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DiscountPercent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DiscountPercent")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.DiscountPercent)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *OffPeakWindow) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = OffPeakWindow{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("OffPeakWindow: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Start (uint64) (uint64)
+		case "Start":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Start = uint64(extra)
+
+			}
+			// t.End (uint64) (uint64)
+		case "End":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.End = uint64(extra)
+
+			}
+			// t.DiscountPercent (uint64) (uint64)
+		case "DiscountPercent":
+
+			{
+
+				maj, extra, err = cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.DiscountPercent = uint64(extra)
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *ProviderFilterRulesResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	// t.Rules ([]types.PublishedFilterRule) (slice)
+	if len("Rules") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Rules\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Rules"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Rules")); err != nil {
+		return err
+	}
+
+	if len(t.Rules) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Rules was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(t.Rules))); err != nil {
+		return err
+	}
+	for _, v := range t.Rules {
+		if err := v.MarshalCBOR(cw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *ProviderFilterRulesResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = ProviderFilterRulesResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ProviderFilterRulesResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Rules ([]types.PublishedFilterRule) (slice)
+		case "Rules":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Rules: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Rules = make([]PublishedFilterRule, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				var v PublishedFilterRule
+				if err := v.UnmarshalCBOR(cr); err != nil {
+					return err
+				}
+
+				t.Rules[i] = v
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *PublishedFilterRule) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Name (string) (string)
+	if len("Name") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Name\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Name"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Name")); err != nil {
+		return err
+	}
+
+	if len(t.Name) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Name was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Name))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Name)); err != nil {
+		return err
+	}
+
+	// t.Expr (string) (string)
+	if len("Expr") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Expr\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Expr"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Expr")); err != nil {
+		return err
+	}
+
+	if len(t.Expr) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Expr was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Expr))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Expr)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *PublishedFilterRule) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = PublishedFilterRule{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("PublishedFilterRule: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Name (string) (string)
+		case "Name":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Name = string(sval)
+			}
+			// t.Expr (string) (string)
+		case "Expr":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Expr = string(sval)
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *DealCancel) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	// t.DealUUID (uuid.UUID) (array)
+	if len("DealUUID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealUUID\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DealUUID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealUUID")); err != nil {
+		return err
+	}
+
+	if len(t.DealUUID) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.DealUUID was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.DealUUID))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.DealUUID[:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DealCancel) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealCancel{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealCancel: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.DealUUID (uuid.UUID) (array)
+		case "DealUUID":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.DealUUID: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra != 16 {
+				return fmt.Errorf("expected array to have 16 elements")
+			}
+
+			t.DealUUID = [16]uint8{}
+
+			if _, err := io.ReadFull(cr, t.DealUUID[:]); err != nil {
+				return err
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *DealCancelRequest) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Cancel (types.DealCancel) (struct)
+	if len("Cancel") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Cancel\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Cancel"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Cancel")); err != nil {
+		return err
+	}
+
+	if err := t.Cancel.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Signature (crypto.Signature) (struct)
+	if len("Signature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Signature"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Signature")); err != nil {
+		return err
+	}
+
+	if err := t.Signature.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DealCancelRequest) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealCancelRequest{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealCancelRequest: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Cancel (types.DealCancel) (struct)
+		case "Cancel":
+
+			{
+
+				if err := t.Cancel.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Cancel: %w", err)
+				}
+
+			}
+			// t.Signature (crypto.Signature) (struct)
+		case "Signature":
+
+			{
+
+				if err := t.Signature.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Signature: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *DealCancelResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	// t.Cancelled (bool) (bool)
+	if len("Cancelled") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Cancelled\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Cancelled"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Cancelled")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Cancelled); err != nil {
+		return err
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DealCancelResponse) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = DealCancelResponse{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealCancelResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Cancelled (bool) (bool)
+		case "Cancelled":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Cancelled = false
+			case 21:
+				t.Cancelled = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadString(cr)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
 			}
 
 		default:
@@ -28,6 +28,11 @@ func TestTransferHost(t *testing.T) {
 		xferType: "libp2p",
 		url:      "libp2p:///ip4/1.2.3.4/udp/5678/quic/p2p/Qma9T5YraSnpRDZqRR4krcSJabThc8nwZuJV3LercPHufi",
 		expected: "1.2.3.4:5678",
+	}, {
+		name:     "s3",
+		xferType: "s3",
+		url:      "s3://my-bucket/path/to/file.car",
+		expected: "my-bucket",
 	}}
 
 	for _, tc := range testCases {
@@ -0,0 +1,329 @@
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// The maximum number of bytes that a transfer waits for at a time, so
+	// that changes to the transfer's share of the bandwidth take effect
+	// quickly
+	burstSize = 64 * 1024
+	// A transfer that hasn't received any data for this long is not given a
+	// share of the bandwidth until it receives data again
+	idleTimeout = 5 * time.Second
+	// How often the shares of active transfers are recalculated, so that the
+	// bandwidth of transfers that have gone idle is given to the others
+	rebalanceInterval = time.Second
+)
+
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// Manager enforces a global budget for the bandwidth used to receive deal
+// data, and shares it fairly between the transfers that are in progress.
+// Each transfer may also have its own limit, and may be paused and resumed.
+// The bandwidth that a transfer doesn't use because of its own limit is
+// shared between the other transfers. All limits are in bytes per second,
+// and a limit of zero means unlimited.
+type Manager struct {
+	lk         sync.Mutex
+	global     uint64
+	transfers  map[uuid.UUID]*Transfer
+	rebalanced time.Time
+	now        func() time.Time
+}
+
+// TransferInfo describes a transfer in progress
+type TransferInfo struct {
+	ID uuid.UUID
+	// The kind of transfer, eg "http" or "graphsync"
+	Kind string
+	// The peer or host that the data is received from
+	Peer      string
+	StartedAt time.Time
+	// The limit set for the transfer (zero means there is no
+	// transfer-specific limit)
+	Limit  uint64
+	Paused bool
+	// The bandwidth that the transfer may currently use (zero means
+	// unlimited)
+	Share uint64
+	// The number of bytes received since the transfer started
+	Received uint64
+}
+
+func New(globalLimit uint64) *Manager {
+	return &Manager{
+		global:    globalLimit,
+		transfers: make(map[uuid.UUID]*Transfer),
+		now:       time.Now,
+	}
+}
+
+// Start registers a transfer with the given id. If a transfer with the same
+// id is already registered, it is returned instead, so that retries of a
+// transfer keep the transfer's limit and paused state. The transfer must be
+// closed when it is complete.
+func (m *Manager) Start(id uuid.UUID, kind string, peer string) *Transfer {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		t = &Transfer{
+			id:        id,
+			kind:      kind,
+			peer:      peer,
+			startedAt: m.now(),
+			mgr:       m,
+			lim:       rate.NewLimiter(rate.Inf, burstSize),
+		}
+		m.transfers[id] = t
+	}
+	t.refs++
+	return t
+}
+
+func (m *Manager) release(t *Transfer) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	t.refs--
+	if t.refs > 0 {
+		return
+	}
+	delete(m.transfers, t.id)
+	if t.resume != nil {
+		close(t.resume)
+		t.resume = nil
+	}
+	m.rebalanceLocked()
+}
+
+// GlobalLimit returns the limit across all transfers
+func (m *Manager) GlobalLimit() uint64 {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.global
+}
+
+// SetGlobalLimit sets the limit across all transfers
+func (m *Manager) SetGlobalLimit(limit uint64) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.global = limit
+	m.rebalanceLocked()
+}
+
+// SetTransferLimit sets the limit for a transfer in progress
+func (m *Manager) SetTransferLimit(id uuid.UUID, limit uint64) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		return ErrTransferNotFound
+	}
+	t.limit = limit
+	m.rebalanceLocked()
+	return nil
+}
+
+// Pause stops a transfer from receiving data until it is resumed
+func (m *Manager) Pause(id uuid.UUID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		return ErrTransferNotFound
+	}
+	if t.resume == nil {
+		t.resume = make(chan struct{})
+		m.rebalanceLocked()
+	}
+	return nil
+}
+
+// Resume resumes a paused transfer
+func (m *Manager) Resume(id uuid.UUID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		return ErrTransferNotFound
+	}
+	if t.resume != nil {
+		close(t.resume)
+		t.resume = nil
+		m.rebalanceLocked()
+	}
+	return nil
+}
+
+// Transfers returns information about all transfers in progress, in the
+// order they started
+func (m *Manager) Transfers() []TransferInfo {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	infos := make([]TransferInfo, 0, len(m.transfers))
+	for _, t := range m.transfers {
+		infos = append(infos, TransferInfo{
+			ID:        t.id,
+			Kind:      t.kind,
+			Peer:      t.peer,
+			StartedAt: t.startedAt,
+			Limit:     t.limit,
+			Paused:    t.resume != nil,
+			Share:     t.share,
+			Received:  t.received,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].StartedAt.Equal(infos[j].StartedAt) {
+			return infos[i].ID.String() < infos[j].ID.String()
+		}
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
+
+// rebalanceLocked shares the global limit between the active transfers:
+// each transfer gets an equal share, except that a transfer with a limit
+// below its share gets its limit, and the rest is shared between the others
+func (m *Manager) rebalanceLocked() {
+	now := m.now()
+	m.rebalanced = now
+
+	var active []*Transfer
+	for _, t := range m.transfers {
+		if t.resume != nil || now.Sub(t.lastActive) > idleTimeout {
+			t.share = 0
+			continue
+		}
+		active = append(active, t)
+	}
+
+	if m.global == 0 {
+		for _, t := range active {
+			t.setShare(t.limit)
+		}
+		return
+	}
+
+	// Visit transfers in order of limit, lowest first, with unlimited
+	// transfers last
+	sort.Slice(active, func(i, j int) bool {
+		li, lj := active[i].limit, active[j].limit
+		if li == 0 || lj == 0 {
+			return lj == 0 && li != 0
+		}
+		return li < lj
+	})
+	remaining := m.global
+	for i, t := range active {
+		share := remaining / uint64(len(active)-i)
+		if share == 0 {
+			share = 1
+		}
+		if t.limit > 0 && t.limit < share {
+			share = t.limit
+		}
+		t.setShare(share)
+		if share < remaining {
+			remaining -= share
+		} else {
+			remaining = 0
+		}
+	}
+}
+
+// Transfer is a stream of deal data received from a peer
+type Transfer struct {
+	id        uuid.UUID
+	kind      string
+	peer      string
+	startedAt time.Time
+	mgr       *Manager
+	lim       *rate.Limiter
+
+	// The fields below are guarded by the manager's lock
+	refs       int
+	limit      uint64
+	share      uint64
+	received   uint64
+	lastActive time.Time
+	// resume is closed when a paused transfer is resumed, and is nil if the
+	// transfer is not paused
+	resume chan struct{}
+}
+
+func (t *Transfer) ID() uuid.UUID {
+	return t.id
+}
+
+func (t *Transfer) setShare(share uint64) {
+	t.share = share
+	if share == 0 {
+		t.lim.SetLimit(rate.Inf)
+		return
+	}
+	t.lim.SetLimit(rate.Limit(share))
+}
+
+// Wait is called after n bytes have been received, and blocks until the
+// transfer may receive more data: while the transfer is paused, and for as
+// long as it takes to receive n bytes at the transfer's share of the
+// bandwidth.
+func (t *Transfer) Wait(ctx context.Context, n int) error {
+	m := t.mgr
+	for {
+		m.lk.Lock()
+		resume := t.resume
+		if resume == nil {
+			now := m.now()
+			wasIdle := now.Sub(t.lastActive) > idleTimeout
+			t.lastActive = now
+			t.received += uint64(n)
+			if wasIdle || now.Sub(m.rebalanced) >= rebalanceInterval {
+				m.rebalanceLocked()
+			}
+			m.lk.Unlock()
+			break
+		}
+		m.lk.Unlock()
+
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for n > 0 {
+		wait := n
+		if wait > burstSize {
+			wait = burstSize
+		}
+		if err := t.lim.WaitN(ctx, wait); err != nil {
+			return err
+		}
+		n -= wait
+	}
+	return nil
+}
+
+// Close releases the transfer
+func (t *Transfer) Close() {
+	t.mgr.release(t)
+}
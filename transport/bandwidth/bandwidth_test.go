@@ -0,0 +1,124 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func shares(m *Manager) map[uuid.UUID]uint64 {
+	s := make(map[uuid.UUID]uint64)
+	for _, t := range m.Transfers() {
+		s[t.ID] = t.Share
+	}
+	return s
+}
+
+func TestFairShare(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	now := time.Now()
+	m := New(900)
+	m.now = func() time.Time { return now }
+
+	a := m.Start(uuid.New(), "http", "a")
+	b := m.Start(uuid.New(), "http", "b")
+	c := m.Start(uuid.New(), "graphsync", "c")
+	for _, tr := range []*Transfer{a, b, c} {
+		req.NoError(tr.Wait(ctx, 1))
+	}
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 300, b.ID(): 300, c.ID(): 300}, shares(m))
+
+	// The bandwidth that a transfer doesn't use because of its limit is
+	// shared between the other transfers
+	req.NoError(m.SetTransferLimit(a.ID(), 100))
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 100, b.ID(): 400, c.ID(): 400}, shares(m))
+
+	// A transfer with a limit above its share gets its share
+	req.NoError(m.SetTransferLimit(b.ID(), 500))
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 100, b.ID(): 400, c.ID(): 400}, shares(m))
+
+	// A paused transfer gets no share
+	req.NoError(m.Pause(c.ID()))
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 100, b.ID(): 500, c.ID(): 0}, shares(m))
+	req.NoError(m.Resume(c.ID()))
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 100, b.ID(): 400, c.ID(): 400}, shares(m))
+
+	// A transfer that goes idle gets no share after the next rebalance
+	now = now.Add(idleTimeout + time.Second)
+	req.NoError(a.Wait(ctx, 1))
+	req.NoError(b.Wait(ctx, 1))
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 100, b.ID(): 500, c.ID(): 0}, shares(m))
+
+	// Without a global limit, each transfer may use up to its own limit
+	m.SetGlobalLimit(0)
+	req.Equal(map[uuid.UUID]uint64{a.ID(): 100, b.ID(): 500, c.ID(): 0}, shares(m))
+	req.Equal(uint64(0), m.GlobalLimit())
+
+	c.Close()
+	require.Len(t, m.Transfers(), 2)
+	require.ErrorIs(t, m.SetTransferLimit(c.ID(), 10), ErrTransferNotFound)
+	require.ErrorIs(t, m.Pause(c.ID()), ErrTransferNotFound)
+}
+
+func TestStartSameTransfer(t *testing.T) {
+	m := New(0)
+	id := uuid.New()
+
+	// A transfer that is started again keeps its limit until it has been
+	// closed as many times as it was started
+	tr := m.Start(id, "http", "a")
+	require.NoError(t, m.SetTransferLimit(id, 1000))
+	require.Same(t, tr, m.Start(id, "http", "a"))
+	tr.Close()
+	require.Equal(t, uint64(1000), m.Transfers()[0].Limit)
+	tr.Close()
+	require.Empty(t, m.Transfers())
+}
+
+func TestPause(t *testing.T) {
+	ctx := context.Background()
+	m := New(0)
+	tr := m.Start(uuid.New(), "http", "a")
+	defer tr.Close()
+
+	require.NoError(t, m.Pause(tr.ID()))
+	require.True(t, m.Transfers()[0].Paused)
+
+	done := make(chan error)
+	go func() {
+		done <- tr.Wait(ctx, 10)
+	}()
+	select {
+	case <-done:
+		require.Fail(t, "expected paused transfer to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, m.Resume(tr.ID()))
+	require.NoError(t, <-done)
+	require.Equal(t, uint64(10), m.Transfers()[0].Received)
+
+	// A paused transfer stops waiting when its context is cancelled
+	require.NoError(t, m.Pause(tr.ID()))
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, tr.Wait(cctx, 10), context.Canceled)
+}
+
+func TestWaitLimitsRate(t *testing.T) {
+	ctx := context.Background()
+	m := New(2 * burstSize)
+	tr := m.Start(uuid.New(), "http", "a")
+	defer tr.Close()
+
+	// The first burst is received immediately, and the rest at the limit
+	start := time.Now()
+	require.NoError(t, tr.Wait(ctx, 2*burstSize))
+	require.NoError(t, tr.Wait(ctx, burstSize))
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
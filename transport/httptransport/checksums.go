@@ -0,0 +1,344 @@
+package httptransport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/boost/lib/chunksum"
+)
+
+// Per-chunk checksums of deal data.
+//
+// The provider sends "Boost-Chunk-Checksums: crc32c" with each request for
+// deal data. If the server supports chunk checksums it responds with the
+// chunk size in the "Boost-Chunk-Size" header, and sends the checksums of
+// the chunks of the response body in the "Boost-Chunk-Checksums" trailer.
+// The provider computes the checksums of the data it receives, and fetches
+// the chunks whose checksums don't match again, with the range of the chunk
+// in the "Boost-Chunk-Mismatch" header.
+//
+// If a response is interrupted before the trailer is received, the provider
+// verifies the data it received with a HEAD request for the same range: the
+// server responds with the checksums of the range in the
+// "Boost-Chunk-Checksums" header, without sending the data.
+const (
+	ChunkChecksumsHeader = "Boost-Chunk-Checksums"
+	ChunkSizeHeader      = "Boost-Chunk-Size"
+	ChunkMismatchHeader  = "Boost-Chunk-Mismatch"
+
+	// The maximum number of chunks verified with each HEAD request, to
+	// bound the size of the response header
+	maxVerifyChunks = 256
+	// The number of times a corrupted chunk is fetched again before the
+	// transfer fails
+	maxChunkRefetches = 3
+)
+
+var errChecksumsUnsupported = errors.New("server did not send chunk checksums")
+
+// wantsChunkChecksums returns true if the request asks for chunk checksums
+func wantsChunkChecksums(r *http.Request) bool {
+	return strings.TrimSpace(r.Header.Get(ChunkChecksumsHeader)) == chunksum.Algorithm
+}
+
+// chunkSumReader computes the checksums of the chunks of the data that is
+// read from the underlying reader, from the offset of the last seek
+type chunkSumReader struct {
+	rs        io.ReadSeeker
+	chunkSize uint64
+	sums      *chunksum.Writer
+}
+
+func newChunkSumReader(rs io.ReadSeeker, chunkSize uint64) *chunkSumReader {
+	return &chunkSumReader{rs: rs, chunkSize: chunkSize, sums: chunksum.NewWriter(0, chunkSize)}
+}
+
+func (c *chunkSumReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := c.rs.Seek(offset, whence)
+	if err == nil {
+		c.sums = chunksum.NewWriter(uint64(n), c.chunkSize)
+	}
+	return n, err
+}
+
+func (c *chunkSumReader) Read(p []byte) (int, error) {
+	n, err := c.rs.Read(p)
+	_, _ = c.sums.Write(p[:n])
+	return n, err
+}
+
+// chunkSumResponse announces that the checksums of the chunks of the
+// response body will be sent in the trailer. It returns a response writer
+// that sends the body with chunked encoding (trailers can't be sent with a
+// Content-Length), and a reader over the content that computes the
+// checksums.
+func chunkSumResponse(w http.ResponseWriter, rs io.ReadSeeker) (http.ResponseWriter, *chunkSumReader) {
+	w.Header().Set(ChunkSizeHeader, strconv.Itoa(chunksum.DefaultChunkSize))
+	w.Header().Set("Trailer", ChunkChecksumsHeader)
+	return &trailerWriter{ResponseWriter: w}, newChunkSumReader(rs, chunksum.DefaultChunkSize)
+}
+
+// sendTrailer sets the checksums of the data that was sent in the trailer
+func (c *chunkSumReader) sendTrailer(w http.ResponseWriter) {
+	w.Header().Set(ChunkChecksumsHeader, c.sums.Checksums().String())
+}
+
+// trailerWriter removes the Content-Length header from the response, so
+// that the response body is sent with chunked encoding
+type trailerWriter struct {
+	http.ResponseWriter
+}
+
+func (t *trailerWriter) WriteHeader(code int) {
+	t.Header().Del("Content-Length")
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// setRangeChecksums responds to a HEAD request for chunk checksums with the
+// checksums of the requested range of the content
+func setRangeChecksums(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, size uint64) error {
+	rg, err := parseByteRange(r.Header.Get("Range"), size)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(int64(rg.Offset), io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to %d: %w", rg.Offset, err)
+	}
+	sums, err := chunksum.Compute(content, rg, chunksum.DefaultChunkSize)
+	if err != nil {
+		return fmt.Errorf("computing checksums of %s: %w", rg, err)
+	}
+	w.Header().Set(ChunkSizeHeader, strconv.Itoa(chunksum.DefaultChunkSize))
+	w.Header().Set(ChunkChecksumsHeader, sums.String())
+	return nil
+}
+
+// parseByteRange parses a Range header with a single range, eg
+// "bytes=100-199" or "bytes=100-". A missing header is the whole content.
+func parseByteRange(h string, size uint64) (chunksum.Range, error) {
+	if h == "" {
+		return chunksum.Range{Length: size}, nil
+	}
+	spec := strings.TrimPrefix(h, "bytes=")
+	startEnd := strings.SplitN(spec, "-", 2)
+	if spec == h || len(startEnd) != 2 || strings.Contains(spec, ",") {
+		return chunksum.Range{}, fmt.Errorf("unsupported range '%s'", h)
+	}
+	start, err := strconv.ParseUint(startEnd[0], 10, 64)
+	if err != nil {
+		return chunksum.Range{}, fmt.Errorf("parsing range '%s': %w", h, err)
+	}
+	end := size - 1
+	if startEnd[1] != "" {
+		end, err = strconv.ParseUint(startEnd[1], 10, 64)
+		if err != nil {
+			return chunksum.Range{}, fmt.Errorf("parsing range '%s': %w", h, err)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start > end || start >= size {
+		return chunksum.Range{}, fmt.Errorf("range '%s' is outside the content (%d bytes)", h, size)
+	}
+	return chunksum.Range{Offset: start, Length: end - start + 1}, nil
+}
+
+// checkTrailer compares the checksums of the data received in a response
+// with the checksums in the response trailer. It returns false if the
+// server didn't send the checksums.
+func (t *transfer) checkTrailer(resp *http.Response, got *chunksum.Checksums) (bool, error) {
+	// Read to the end of the body to get the trailer
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return false, fmt.Errorf("reading http response trailer: %w", err)
+	}
+	v := resp.Trailer.Get(ChunkChecksumsHeader)
+	if v == "" {
+		return false, nil
+	}
+	want, err := chunksum.Parse(v)
+	if err != nil {
+		return false, fmt.Errorf("parsing chunk checksums: %w", err)
+	}
+	bad, err := chunksum.Mismatches(want, got)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range bad {
+		t.dl.Warnw(t.dealInfo.DealUuid, "chunk checksum mismatch", "chunk", r.String(),
+			"origin", t.origins[t.originIdx].label())
+	}
+	t.corrupt = append(t.corrupt, bad...)
+	return true, nil
+}
+
+// repairChunks verifies the data that was received without checksums (eg
+// because the response was interrupted), and fetches each chunk that failed
+// verification again
+func (t *transfer) repairChunks(ctx context.Context) error {
+	duuid := t.dealInfo.DealUuid
+	if len(t.unverified) == 0 && len(t.corrupt) == 0 {
+		return nil
+	}
+
+	of, err := os.OpenFile(t.dealInfo.OutputFile, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer of.Close() //nolint:errcheck
+
+	if len(t.unverified) > 0 && t.chunkSize == 0 {
+		t.dl.Infow(duuid, "could not verify chunk checksums: server does not support them")
+		t.unverified = nil
+	}
+	for _, rg := range t.unverified {
+		for off := rg.Offset; off < rg.End(); {
+			// Verify up to maxVerifyChunks chunks at a time
+			end := (off/t.chunkSize + maxVerifyChunks) * t.chunkSize
+			if end > rg.End() {
+				end = rg.End()
+			}
+			part := chunksum.Range{Offset: off, Length: end - off}
+			off = end
+
+			bad, err := t.verifyRange(ctx, of, part)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				t.dl.Infow(duuid, "could not verify chunk checksums", "range", part.String(), "err", err.Error())
+				continue
+			}
+			for _, r := range bad {
+				t.dl.Warnw(duuid, "chunk checksum mismatch", "chunk", r.String(),
+					"origin", t.origins[t.originIdx].label())
+			}
+			t.corrupt = append(t.corrupt, bad...)
+		}
+	}
+	t.unverified = nil
+
+	for _, r := range t.corrupt {
+		var err error
+		for i := 0; i < maxChunkRefetches; i++ {
+			if err = t.refetchChunk(ctx, of, r); err == nil {
+				t.dl.Infow(duuid, "fetched corrupted chunk again", "chunk", r.String(), "attempt", i+1)
+				break
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			t.dl.Infow(duuid, "failed to fetch corrupted chunk again", "chunk", r.String(), "attempt", i+1,
+				"err", err.Error())
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %s of deal data is corrupt, and fetching it again failed %d times: %w",
+				r, maxChunkRefetches, err)
+		}
+	}
+	t.corrupt = nil
+	return nil
+}
+
+// verifyRange gets the checksums of the range from the server with a HEAD
+// request, and compares them with the checksums of the data in the output
+// file. It returns the chunks whose checksums don't match.
+func (t *transfer) verifyRange(ctx context.Context, of *os.File, rg chunksum.Range) ([]chunksum.Range, error) {
+	resp, err := t.chunkRequest(ctx, http.MethodHead, rg, "")
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	v := resp.Header.Get(ChunkChecksumsHeader)
+	if v == "" {
+		return nil, errChecksumsUnsupported
+	}
+	want, err := chunksum.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("parsing chunk checksums: %w", err)
+	}
+	got, err := chunksum.Compute(io.NewSectionReader(of, int64(rg.Offset), int64(rg.Length)), rg, want.ChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading output file: %w", err)
+	}
+	return chunksum.Mismatches(want, got)
+}
+
+// refetchChunk fetches a chunk again, checks it against its checksum, and
+// writes it to the output file
+func (t *transfer) refetchChunk(ctx context.Context, of *os.File, chunk chunksum.Range) error {
+	resp, err := t.chunkRequest(ctx, http.MethodGet, chunk, chunk.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(chunk.Length)))
+	if err != nil {
+		return fmt.Errorf("reading chunk: %w", err)
+	}
+	// Read to the end of the body to get the trailer
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("reading chunk: %w", err)
+	}
+	v := resp.Trailer.Get(ChunkChecksumsHeader)
+	if v == "" {
+		return errChecksumsUnsupported
+	}
+	want, err := chunksum.Parse(v)
+	if err != nil {
+		return fmt.Errorf("parsing chunk checksums: %w", err)
+	}
+	got, err := chunksum.Compute(bytes.NewReader(data), chunk, want.ChunkSize)
+	if err != nil {
+		return err
+	}
+	bad, err := chunksum.Mismatches(want, got)
+	if err != nil {
+		return err
+	}
+	if len(bad) > 0 {
+		return errors.New("chunk checksum mismatch")
+	}
+
+	if _, err := of.WriteAt(data, int64(chunk.Offset)); err != nil {
+		return fmt.Errorf("writing chunk to output file: %w", err)
+	}
+	return nil
+}
+
+// chunkRequest requests a range of the data, with chunk checksums, from the
+// current origin
+func (t *transfer) chunkRequest(ctx context.Context, method string, rg chunksum.Range, mismatch string) (*http.Response, error) {
+	o := t.origins[t.originIdx]
+	req, err := http.NewRequestWithContext(ctx, method, o.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http req: %w", err)
+	}
+	for name, val := range o.headers {
+		req.Header.Set(name, val)
+	}
+	req.Header.Set("Range", "bytes="+rg.String())
+	req.Header.Set(ChunkChecksumsHeader, chunksum.Algorithm)
+	if mismatch != "" {
+		req.Header.Set(ChunkMismatchHeader, mismatch)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send http req: %w", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && rg.Offset == 0) {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("http req failed: code: %d, status: %s", resp.StatusCode, resp.Status)
+	}
+	return resp, nil
+}
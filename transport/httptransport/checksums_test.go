@@ -0,0 +1,136 @@
+package httptransport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/chunksum"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/stretchr/testify/require"
+)
+
+// corruptWriter flips a byte of the response body
+type corruptWriter struct {
+	http.ResponseWriter
+	at      int
+	written int
+}
+
+func (c *corruptWriter) Write(p []byte) (int, error) {
+	if c.at >= c.written && c.at < c.written+len(p) {
+		p = append([]byte(nil), p...)
+		p[c.at-c.written] ^= 0xff
+	}
+	c.written += len(p)
+	return c.ResponseWriter.Write(p)
+}
+
+func TestTransferChunkChecksums(t *testing.T) {
+	ctx := context.Background()
+
+	size := 3*chunksum.DefaultChunkSize + 100
+	str := randSeq(size)
+	corruptAt := chunksum.DefaultChunkSize + 1000
+	badChunk := chunksum.Range{Offset: chunksum.DefaultChunkSize, Length: chunksum.DefaultChunkSize}.String()
+
+	// newServer returns a server that sends chunk checksums, and corrupts
+	// the data in the first response. If interrupt is true, the first
+	// response is interrupted before the trailer is sent.
+	newServer := func(interrupt bool) (*httptest.Server, func() []string) {
+		var lk sync.Mutex
+		var requests int
+		var mismatches []string
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.True(t, wantsChunkChecksums(r))
+			if r.Method == http.MethodHead {
+				require.NoError(t, setRangeChecksums(w, r, strings.NewReader(str), uint64(size)))
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+				return
+			}
+
+			lk.Lock()
+			first := requests == 0
+			requests++
+			if m := r.Header.Get(ChunkMismatchHeader); m != "" {
+				mismatches = append(mismatches, m)
+			}
+			lk.Unlock()
+
+			tw, sums := chunkSumResponse(w, strings.NewReader(str))
+			if !first {
+				http.ServeContent(tw, r, "", time.Time{}, sums)
+				sums.sendTrailer(w)
+				return
+			}
+
+			cw := &corruptWriter{ResponseWriter: tw, at: corruptAt}
+			if interrupt {
+				cw.WriteHeader(http.StatusOK)
+				_, _ = cw.Write([]byte(str[:2*chunksum.DefaultChunkSize]))
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(cw, r, "", time.Time{}, sums)
+			sums.sendTrailer(w)
+		}))
+		return svr, func() []string {
+			lk.Lock()
+			defer lk.Unlock()
+			return mismatches
+		}
+	}
+
+	t.Run("corrupt chunk is fetched again", func(t *testing.T) {
+		svr, mismatches := newServer(false)
+		defer svr.Close()
+
+		of := getTempFilePath(t)
+		ht := New(nil, newDealLogger(t, ctx))
+		th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+		evts := waitForTransferComplete(th)
+		require.NotEmpty(t, evts)
+		require.NoError(t, evts[len(evts)-1].Error)
+		assertFileContents(t, of, []byte(str))
+		require.Equal(t, []string{badChunk}, mismatches())
+	})
+
+	t.Run("interrupted response is verified", func(t *testing.T) {
+		svr, mismatches := newServer(true)
+		defer svr.Close()
+
+		of := getTempFilePath(t)
+		ht := New(nil, newDealLogger(t, ctx), BackOffRetryOpt(50*time.Millisecond, 100*time.Millisecond, 2, 10))
+		th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+		evts := waitForTransferComplete(th)
+		require.NotEmpty(t, evts)
+		require.NoError(t, evts[len(evts)-1].Error)
+		assertFileContents(t, of, []byte(str))
+		require.Equal(t, []string{badChunk}, mismatches())
+	})
+}
+
+func TestParseByteRange(t *testing.T) {
+	req := require.New(t)
+
+	rg, err := parseByteRange("bytes=100-199", 1000)
+	req.NoError(err)
+	req.Equal(chunksum.Range{Offset: 100, Length: 100}, rg)
+
+	rg, err = parseByteRange("bytes=100-", 1000)
+	req.NoError(err)
+	req.Equal(chunksum.Range{Offset: 100, Length: 900}, rg)
+
+	rg, err = parseByteRange("", 1000)
+	req.NoError(err)
+	req.Equal(chunksum.Range{Length: 1000}, rg)
+
+	for _, h := range []string{"bytes=1000-", "items=0-10", "bytes=0-10,20-30", "bytes=-100", "bytes=20-10"} {
+		_, err := parseByteRange(h, 1000)
+		req.Error(err, h)
+	}
+}
@@ -0,0 +1,168 @@
+package httptransport
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// EncodingZstd is the content encoding used to compress deal data on the
+// wire.
+//
+// Compression is negotiated per request: the provider sends
+// "Accept-Encoding: zstd" if it has the CPU budget to decompress the
+// transfer, and the server responds with "Content-Encoding: zstd" if it has
+// the CPU budget to compress it. Range requests select a range of the
+// uncompressed data, and the server compresses the selected range.
+const EncodingZstd = "zstd"
+
+// compressionBudget limits the number of transfers that are compressed or
+// decompressed at the same time, to bound the CPU used for compression
+type compressionBudget struct {
+	slots chan struct{}
+}
+
+// newCompressionBudget returns a budget for max concurrent compressed
+// transfers. If max is zero, nil is returned and transfers are never
+// compressed.
+func newCompressionBudget(max uint) *compressionBudget {
+	if max == 0 {
+		return nil
+	}
+	return &compressionBudget{slots: make(chan struct{}, max)}
+}
+
+// tryAcquire returns true if there is budget for another compressed
+// transfer. The caller must call release when the transfer completes.
+func (b *compressionBudget) tryAcquire() bool {
+	if b == nil {
+		return false
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *compressionBudget) release() {
+	<-b.slots
+}
+
+// acceptsZstd returns true if the request's Accept-Encoding header includes
+// zstd
+func acceptsZstd(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc = strings.TrimSpace(strings.SplitN(enc, ";", 2)[0])
+			if enc == EncodingZstd {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compressResponse returns a response writer that compresses the response
+// body with zstd, if the client accepts zstd and there is budget to
+// compress the response. The returned close function must be called after
+// the response has been written; it returns the number of uncompressed and
+// compressed bytes written.
+func compressResponse(w http.ResponseWriter, r *http.Request, budget *compressionBudget) (http.ResponseWriter, func() (int64, int64, error)) {
+	noop := func() (int64, int64, error) { return 0, 0, nil }
+	if r.Method == http.MethodHead || !acceptsZstd(r) || !budget.tryAcquire() {
+		return w, noop
+	}
+
+	cw := &compressWriter{ResponseWriter: w}
+	return cw, func() (int64, int64, error) {
+		defer budget.release()
+		if cw.enc == nil {
+			return 0, 0, nil
+		}
+		err := cw.enc.Close()
+		return cw.raw, cw.wire.n, err
+	}
+}
+
+// compressWriter compresses successful responses with zstd
+type compressWriter struct {
+	http.ResponseWriter
+	enc  *zstd.Encoder
+	wire countingWriter
+	raw  int64
+
+	wroteHeader bool
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		// The length of the compressed body isn't known in advance
+		c.Header().Del("Content-Length")
+		c.Header().Set("Content-Encoding", EncodingZstd)
+		c.Header().Add("Vary", "Accept-Encoding")
+		c.wire.w = c.ResponseWriter
+		// Compress with a single goroutine at the fastest level to limit
+		// CPU usage. NewWriter only fails with invalid options.
+		c.enc, _ = zstd.NewWriter(&c.wire, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.enc == nil {
+		return c.ResponseWriter.Write(p)
+	}
+	n, err := c.enc.Write(p)
+	c.raw += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// decompressResponse returns a reader over the uncompressed response body,
+// and a reader that counts the compressed bytes read from the wire. If the
+// response is not compressed, the wire reader is nil.
+func decompressResponse(resp *http.Response) (io.ReadCloser, *countingReader, error) {
+	if resp.Header.Get("Content-Encoding") != EncodingZstd {
+		return resp.Body, nil, nil
+	}
+	wire := &countingReader{r: resp.Body}
+	dec, err := zstd.NewReader(wire, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dec.IOReadCloser(), wire, nil
+}
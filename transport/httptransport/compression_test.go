@@ -0,0 +1,100 @@
+package httptransport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/lib/features"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferCompression(t *testing.T) {
+	ctx := context.Background()
+
+	// Highly compressible data, eg a log file
+	line := "2022-11-15T10:00:00Z INFO deal accepted by storage provider\n"
+	size := (3 * readBufferSize) + 30
+	str := strings.Repeat(line, size/len(line)+1)[:size]
+
+	var lk sync.Mutex
+	var acceptEncodings []string
+	budget := newCompressionBudget(1)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		acceptEncodings = append(acceptEncodings, r.Header.Get("Accept-Encoding"))
+		lk.Unlock()
+
+		cw, closeCompression := compressResponse(w, r, budget)
+		http.ServeContent(cw, r, "", time.Time{}, strings.NewReader(str))
+		_, _, err := closeCompression()
+		require.NoError(t, err)
+	}))
+	defer svr.Close()
+
+	t.Run("compressed", func(t *testing.T) {
+		of := getTempFilePath(t)
+		ht := New(nil, newDealLogger(t, ctx), CompressionOpt(1))
+		th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+		evts := waitForTransferComplete(th)
+		require.NotEmpty(t, evts)
+		last := evts[len(evts)-1]
+		require.NoError(t, last.Error)
+		require.EqualValues(t, size, last.NBytesReceived)
+		require.Equal(t, EncodingZstd, last.Compression)
+		require.EqualValues(t, size, last.DecodedBytes)
+		require.Less(t, last.WireBytes*10, last.DecodedBytes)
+		assertFileContents(t, of, []byte(str))
+	})
+
+	t.Run("compression disabled", func(t *testing.T) {
+		of := getTempFilePath(t)
+		ht := New(nil, newDealLogger(t, ctx))
+		th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+		evts := waitForTransferComplete(th)
+		last := evts[len(evts)-1]
+		require.NoError(t, last.Error)
+		require.Empty(t, last.Compression)
+		require.Zero(t, last.WireBytes)
+		assertFileContents(t, of, []byte(str))
+	})
+
+	t.Run("compression feature disabled", func(t *testing.T) {
+		ff, err := features.New(nil, []string{features.HttpTransferCompression})
+		require.NoError(t, err)
+		of := getTempFilePath(t)
+		ht := New(nil, newDealLogger(t, ctx), CompressionOpt(1), FeaturesOpt(ff))
+		th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+		evts := waitForTransferComplete(th)
+		last := evts[len(evts)-1]
+		require.NoError(t, last.Error)
+		require.Empty(t, last.Compression)
+		assertFileContents(t, of, []byte(str))
+	})
+
+	t.Run("no compression when resuming from plain http server", func(t *testing.T) {
+		of := getTempFilePath(t)
+		require.NoError(t, os.WriteFile(of, []byte(str[:100]), 0644))
+		lk.Lock()
+		acceptEncodings = nil
+		lk.Unlock()
+
+		ht := New(nil, newDealLogger(t, ctx), CompressionOpt(1))
+		th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+		evts := waitForTransferComplete(th)
+		last := evts[len(evts)-1]
+		require.NoError(t, last.Error)
+		require.Empty(t, last.Compression)
+		assertFileContents(t, of, []byte(str))
+
+		lk.Lock()
+		defer lk.Unlock()
+		require.NotContains(t, acceptEncodings, EncodingZstd)
+	})
+}
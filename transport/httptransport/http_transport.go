@@ -7,18 +7,26 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/filecoin-project/boost/lib/chunksum"
+	"github.com/filecoin-project/boost/lib/features"
 	"github.com/filecoin-project/boost/storagemarket/logs"
 	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/bandwidth"
 	"github.com/filecoin-project/boost/transport/httptransport/util"
 	"github.com/filecoin-project/boost/transport/types"
 	"github.com/google/uuid"
 	"github.com/jpillora/backoff"
 	p2phttp "github.com/libp2p/go-libp2p-http"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 const (
@@ -30,6 +38,11 @@ const (
 	maxBackOff           = 10 * time.Minute
 	factor               = 1.5
 	maxReconnectAttempts = 15
+
+	// Probe the peer every 10s while a libp2p transfer is in progress, and
+	// restart the transfer if the peer doesn't respond to two probes in a row
+	defaultKeepaliveInterval = 10 * time.Second
+	defaultKeepaliveTimeout  = 10 * time.Second
 )
 
 type httpError struct {
@@ -50,15 +63,50 @@ func BackOffRetryOpt(minBackoff, maxBackoff time.Duration, factor, maxReconnectA
 	}
 }
 
+// CompressionOpt allows up to maxTransfers transfers at a time to be
+// compressed on the wire (if the server supports it). If maxTransfers is
+// zero, compression is not requested.
+func CompressionOpt(maxTransfers uint) Option {
+	return func(h *httpTransport) {
+		h.compression = newCompressionBudget(maxTransfers)
+	}
+}
+
+// FeaturesOpt gates experimental transfer features (eg compression and
+// mirrors) behind feature flags that can be changed at runtime
+func FeaturesOpt(f *features.Flags) Option {
+	return func(h *httpTransport) {
+		h.features = f
+	}
+}
+
+// BandwidthOpt limits the rate at which transfers receive data to the
+// transfers' shares of the bandwidth managed by m
+func BandwidthOpt(m *bandwidth.Manager) Option {
+	return func(h *httpTransport) {
+		h.bandwidth = m
+	}
+}
+
 type httpTransport struct {
 	libp2pHost   host.Host
 	libp2pClient *http.Client
+	httpClient   *http.Client
+	dialer       *failoverDialer
+	compression  *compressionBudget
+	features     *features.Flags
+	bandwidth    *bandwidth.Manager
 
 	minBackOffWait       time.Duration
 	maxBackoffWait       time.Duration
 	backOffFactor        float64
 	maxReconnectAttempts float64
 
+	stallTimeout      time.Duration
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	maxProbeFailures  int
+
 	dl *logs.DealLogger
 }
 
@@ -69,6 +117,9 @@ func New(host host.Host, dealLogger *logs.DealLogger, opts ...Option) *httpTrans
 		maxBackoffWait:       maxBackOff,
 		backOffFactor:        factor,
 		maxReconnectAttempts: maxReconnectAttempts,
+		keepaliveInterval:    defaultKeepaliveInterval,
+		keepaliveTimeout:     defaultKeepaliveTimeout,
+		maxProbeFailures:     defaultMaxProbeFailures,
 		dl:                   dealLogger.Subsystem("http-transport"),
 	}
 	for _, o := range opts {
@@ -81,9 +132,22 @@ func New(host host.Host, dealLogger *logs.DealLogger, opts ...Option) *httpTrans
 	tr.RegisterProtocol("libp2p", p2ptr)
 	ht.libp2pClient = &http.Client{Transport: tr}
 
+	// init a plain http client that fails over to the host's other
+	// addresses when a transfer stalls
+	ht.dialer = newFailoverDialer(ht.keepaliveInterval)
+	httpTr := http.DefaultTransport.(*http.Transport).Clone()
+	httpTr.DialContext = ht.dialer.DialContext
+	ht.httpClient = &http.Client{Transport: httpTr}
+
 	return ht
 }
 
+// featureEnabled returns true if the feature flag is enabled, or if there
+// are no feature flags (in which case features are configured by options)
+func (h *httpTransport) featureEnabled(name string) bool {
+	return h.features == nil || h.features.Enabled(name)
+}
+
 func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (th transport.Handler, err error) {
 	deadline, _ := ctx.Deadline()
 	duuid := dealInfo.DealUuid
@@ -107,6 +171,18 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 	}
 	tInfo.URL = u.Url
 
+	// The request URL and any mirrors that serve the same data (mirrors
+	// are only supported for plain http transfers). Mirrors that the
+	// client asked to be tried in order are not experimental, as they are
+	// part of the deal protocol.
+	origins := []*origin{newOrigin(tInfo.URL, tInfo.Headers, "", 0)}
+	if u.Scheme != util.Libp2pScheme && (tInfo.MirrorsInOrder || h.featureEnabled(features.HttpTransferMirrors)) {
+		origins, err = originsFromRequest(tInfo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// check that the outputFile exists
 	fi, err := os.Stat(dealInfo.OutputFile)
 	if err != nil {
@@ -120,23 +196,54 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 	}
 	h.dl.Infow(duuid, "existing file size", "file size", fileSize, "deal size", dealInfo.DealSize)
 
+	// The client may override the retry policy, but may not make the
+	// provider wait longer between retries than it would by default
+	minBackoff, maxBackoff, maxAttempts := h.minBackOffWait, h.maxBackoffWait, h.maxReconnectAttempts
+	if r := tInfo.Retry; r != nil {
+		if r.MaxAttempts > 0 {
+			maxAttempts = float64(r.MaxAttempts)
+		}
+		if r.MaxBackoff > 0 && r.MaxBackoff < maxBackoff {
+			maxBackoff = r.MaxBackoff
+		}
+		if r.MinBackoff > 0 {
+			minBackoff = r.MinBackoff
+		}
+		if minBackoff > maxBackoff {
+			minBackoff = maxBackoff
+		}
+		h.dl.Infow(duuid, "using client's transfer retry policy", "max attempts", maxAttempts,
+			"min backoff", minBackoff.String(), "max backoff", maxBackoff.String())
+	}
+
 	// construct the transfer instance that will act as the transfer handler
 	tctx, cancel := context.WithCancel(ctx)
 	t := &transfer{
 		cancel:         cancel,
 		tInfo:          tInfo,
+		origins:        origins,
 		dealInfo:       dealInfo,
 		eventCh:        make(chan types.TransportEvent, 256),
 		nBytesReceived: fileSize,
 		backoff: &backoff.Backoff{
-			Min:    h.minBackOffWait,
-			Max:    h.maxBackoffWait,
+			Min:    minBackoff,
+			Max:    maxBackoff,
 			Factor: h.backOffFactor,
 			Jitter: true,
 		},
-		maxReconnectAttempts: h.maxReconnectAttempts,
+		maxReconnectAttempts: maxAttempts,
+		stallTimeout:         h.stallTimeout,
+		compression:          h.compression,
+		compress:             h.featureEnabled(features.HttpTransferCompression),
+		checksums:            h.featureEnabled(features.HttpTransferChecksums),
+		isLibp2p:             u.Scheme == util.Libp2pScheme,
 		dl:                   h.dl,
 	}
+	// The data received before the transfer was restarted is verified
+	// once the transfer completes
+	if t.checksums && fileSize > 0 {
+		t.unverified = []chunksum.Range{{Length: uint64(fileSize)}}
+	}
 
 	cleanupFns := []func(){
 		cancel,
@@ -154,6 +261,8 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 
 		// Use the libp2p client
 		t.client = h.libp2pClient
+		t.host = h.libp2pHost
+		t.peerID = u.PeerID
 
 		// Add the peer's address to the peerstore so we can dial it
 		addrTtl := time.Hour
@@ -168,9 +277,26 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 		cleanupFns = append(cleanupFns, func() {
 			h.libp2pHost.ConnManager().Unprotect(u.PeerID, tag)
 		})
+
+		// Probe the peer while the transfer is in progress so that a
+		// half-open connection is detected quickly
+		if h.keepaliveInterval > 0 {
+			t.prober = &peerProber{
+				host:        h.libp2pHost,
+				peerID:      u.PeerID,
+				interval:    h.keepaliveInterval,
+				timeout:     h.keepaliveTimeout,
+				maxFailures: h.maxProbeFailures,
+				dealUuid:    duuid,
+				dl:          h.dl,
+			}
+		}
 	} else {
-		t.client = http.DefaultClient
-		h.dl.Infow(duuid, "http url", "url", tInfo.URL)
+		t.client = h.httpClient
+		t.dialer = h.dialer
+		// The url may carry credentials (eg a signed url), so only the
+		// scheme, host and path are logged
+		h.dl.Infow(duuid, "http url", "url", originLabel(tInfo.URL))
 	}
 
 	// is the transfer already complete ? we check this by comparing the number of bytes
@@ -188,6 +314,16 @@ func (h *httpTransport) Execute(ctx context.Context, transportInfo []byte, dealI
 		return t, nil
 	}
 
+	// receive data no faster than the transfer's share of the bandwidth
+	if h.bandwidth != nil {
+		from := origins[0].label()
+		if t.isLibp2p {
+			from = u.PeerID.String()
+		}
+		t.bandwidth = h.bandwidth.Start(duuid, "http", from)
+		cleanupFns = append(cleanupFns, t.bandwidth.Close)
+	}
+
 	// start executing the transfer
 	t.wg.Add(1)
 	go func() {
@@ -217,11 +353,48 @@ type transfer struct {
 	dealInfo *types.TransportDealInfo
 	wg       sync.WaitGroup
 
+	// the origins that serve the deal data, in order of preference, and
+	// the index of the origin currently being transferred from
+	origins   []*origin
+	originIdx int
+
 	nBytesReceived int64
 
 	backoff              *backoff.Backoff
 	maxReconnectAttempts float64
 
+	// restart the request if no data is received for this long
+	stallTimeout time.Duration
+	// probes the peer for liveness (libp2p transfers only)
+	prober *peerProber
+	// the dialer used for plain http transfers
+	dialer *failoverDialer
+
+	// limits the number of transfers that are decompressed at a time
+	compression *compressionBudget
+	compress    bool
+	isLibp2p    bool
+	// the encoding of the data on the wire, and the number of compressed
+	// bytes received and the number of bytes they decompressed to
+	encoding     string
+	wireBytes    int64
+	decodedBytes int64
+
+	// whether to ask for chunk checksums, the chunk size used by the
+	// server, and the ranges of the output file that have not been verified
+	// and the chunks that failed verification
+	checksums  bool
+	chunkSize  uint64
+	unverified []chunksum.Range
+	corrupt    []chunksum.Range
+
+	// limits the rate at which data is received (nil if unlimited)
+	bandwidth *bandwidth.Transfer
+
+	// the host and the client's peer, for libp2p transfers
+	host   host.Host
+	peerID peer.ID
+
 	client *http.Client
 	dl     *logs.DealLogger
 }
@@ -237,9 +410,34 @@ func (t *transfer) emitEvent(ctx context.Context, evt types.TransportEvent, id u
 
 func (t *transfer) execute(ctx context.Context) error {
 	duuid := t.dealInfo.DealUuid
+
+	// If the data is served by several origins, pull from the closest
+	// healthy origin, unless the client asked for the origins to be tried
+	// in order
+	if len(t.origins) > 1 && !t.tInfo.MirrorsInOrder {
+		rankOrigins(ctx, t.client, t.origins)
+		for i, o := range t.origins {
+			errMsg := ""
+			if o.probeErr != nil {
+				errMsg = o.probeErr.Error()
+			}
+			t.dl.Infow(duuid, "probed http origin", "rank", i+1, "origin", o.label(), "healthy", o.healthy,
+				"latency", o.latency.String(), "priority", o.priority, "err", errMsg)
+		}
+	}
+
+	// If the transfer is resuming after the provider restarted, tell the
+	// client where it resumes from
+	var restartReason string
+	if t.nBytesReceived > 0 {
+		restartReason = "provider is resuming the transfer"
+	}
+
 	for {
+		o := t.origins[t.originIdx]
+
 		// construct request
-		req, err := http.NewRequest("GET", t.tInfo.URL, nil)
+		req, err := http.NewRequest("GET", o.url, nil)
 		if err != nil {
 			return fmt.Errorf("failed to create http req: %w", err)
 		}
@@ -251,13 +449,30 @@ func (t *transfer) execute(ctx context.Context) error {
 		}
 		t.nBytesReceived = st.Size()
 
+		if restartReason != "" {
+			t.requestRestart(ctx, o, restartReason)
+			restartReason = ""
+		}
+
 		// add request headers
-		for name, val := range t.tInfo.Headers {
+		for name, val := range o.headers {
 			req.Header.Set(name, val)
 		}
 
 		// add range req to start reading from the last byte we have in the output file
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", t.nBytesReceived))
+
+		// ask for the data to be compressed if there's budget to decompress
+		// it. Plain http servers may apply the range to the compressed
+		// data, so compression is only requested from them for the whole
+		// file.
+		compress := t.compress && (t.isLibp2p || t.nBytesReceived == 0) && t.compression.tryAcquire()
+		if compress {
+			req.Header.Set("Accept-Encoding", EncodingZstd)
+		}
+		if t.checksums {
+			req.Header.Set(ChunkChecksumsHeader, chunksum.Algorithm)
+		}
 		// init the request with the transfer context
 		req = req.WithContext(ctx)
 		// open output file in append-only mode for writing
@@ -270,6 +485,9 @@ func (t *transfer) execute(ctx context.Context) error {
 		// start the http transfer
 		remaining := t.dealInfo.DealSize - t.nBytesReceived
 		reqErr := t.doHttp(ctx, req, of, remaining)
+		if compress {
+			t.compression.release()
+		}
 		if reqErr == nil {
 			t.dl.Infow(duuid, "http transfer completed successfully")
 			// if there's no error, transfer was successful
@@ -283,6 +501,12 @@ func (t *transfer) execute(ctx context.Context) error {
 		// check if the error is a 4xx error, meaning there is a problem with
 		// the request (eg 401 Unauthorized)
 		if reqErr.code/100 == 4 {
+			// if another origin serves the data, try that origin instead
+			if t.failover(true) {
+				t.dl.Infow(duuid, "http origin rejected request, failing over to next origin", "http code", reqErr.code,
+					"origin", o.label(), "next origin", t.origins[t.originIdx].label())
+				continue
+			}
 			msg := fmt.Sprintf("terminating http request: received %d response from server", reqErr.code)
 			t.dl.LogError(duuid, msg, reqErr)
 			return reqErr.error
@@ -300,6 +524,10 @@ func (t *transfer) execute(ctx context.Context) error {
 			t.dl.Infow(duuid, "some data was transferred before connection error, so resetting backoff to zero",
 				"transferred", t.nBytesReceived-st.Size())
 			t.backoff.Reset()
+		} else if t.failover(false) {
+			// no data was transferred from this origin, so try the next one
+			t.dl.Infow(duuid, "http origin failed without transferring data, failing over to next origin",
+				"origin", o.label(), "next origin", t.origins[t.originIdx].label())
 		}
 
 		// backoff-retry transfer if max number of attempts haven't been exhausted
@@ -316,6 +544,7 @@ func (t *transfer) execute(ctx context.Context) error {
 		select {
 		case <-bt.C:
 			t.dl.Infow(duuid, "back-off complete, retrying http request", "backoff time", duration.String())
+			restartReason = err.Error()
 		case <-ctx.Done():
 			t.dl.LogError(duuid, "did not retry http request: context cancelled", ctx.Err())
 			return fmt.Errorf("transfer canceled after %.0f attempts to finish transfer, lastErr=%s, contextErr=%w", t.backoff.Attempt(), err, ctx.Err())
@@ -337,21 +566,120 @@ func (t *transfer) execute(ctx context.Context) error {
 		return fmt.Errorf("mismtach in output file size vs received bytes, fileSize=%d, receivedBytes=%d", st.Size(), t.nBytesReceived)
 	}
 
+	// fetch any chunks that were corrupted in transit again
+	if err := t.repairChunks(ctx); err != nil {
+		return err
+	}
+
 	t.dl.Infow(duuid, "http request finished successfully", "nBytesReceived", t.nBytesReceived,
 		"file size", st.Size())
+	if t.wireBytes > 0 {
+		t.dl.Infow(duuid, "transfer compression", "encoding", t.encoding, "wire bytes", t.wireBytes,
+			"decoded bytes", t.decodedBytes, "ratio", fmt.Sprintf("%.2f", float64(t.decodedBytes)/float64(t.wireBytes)))
+	}
 
 	return nil
 }
 
+// requestRestart asks the client serving a libp2p transfer to restart it
+// from the number of bytes already received, so that the client knows the
+// provider is resuming the transfer rather than starting it again. The
+// request is advisory: if the client rejects it (eg because the transfer
+// has been restarted too often) or doesn't support it, the transfer is still
+// retried.
+func (t *transfer) requestRestart(ctx context.Context, o *origin, reason string) {
+	if !t.isLibp2p || t.nBytesReceived == 0 {
+		return
+	}
+	duuid := t.dealInfo.DealUuid
+	token, ok := basicAuthToken(o.headers)
+	if !ok {
+		return
+	}
+
+	resp, err := SendTransferRestartRequest(ctx, t.host, t.peerID, types.TransferRestartRequest{
+		AuthToken: token,
+		Offset:    uint64(t.nBytesReceived),
+		Reason:    reason,
+	})
+	if err != nil {
+		t.dl.Infow(duuid, "could not ask client to restart transfer", "offset", t.nBytesReceived, "err", err)
+		return
+	}
+	if !resp.Accepted {
+		t.dl.Infow(duuid, "client rejected transfer restart request", "offset", t.nBytesReceived, "reason", resp.Message)
+		return
+	}
+	t.dl.Infow(duuid, "client accepted transfer restart request", "offset", t.nBytesReceived)
+}
+
+// basicAuthToken returns the password in the basic auth header, which is
+// the auth token of a libp2p transfer
+func basicAuthToken(headers map[string]string) (string, bool) {
+	for name, val := range headers {
+		if strings.EqualFold(name, "Authorization") {
+			r := http.Request{Header: http.Header{"Authorization": []string{val}}}
+			_, token, ok := r.BasicAuth()
+			return token, ok
+		}
+	}
+	return "", false
+}
+
+// failover moves to the next origin that hasn't rejected the request. If
+// rejected is true the current origin is not tried again. It returns false if
+// there are no other origins to try.
+func (t *transfer) failover(rejected bool) bool {
+	if rejected {
+		t.origins[t.originIdx].rejected = true
+	}
+	for i := 1; i < len(t.origins); i++ {
+		idx := (t.originIdx + i) % len(t.origins)
+		if !t.origins[idx].rejected {
+			t.originIdx = idx
+			return true
+		}
+	}
+	return false
+}
+
 func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer, toRead int64) *httpError {
 	duid := t.dealInfo.DealUuid
 	t.dl.Infow(duid, "sending http request", "received", t.nBytesReceived, "remaining",
 		toRead, "range-rq", req.Header.Get("Range"))
 
+	// cancel the request if it stops making progress or the peer stops
+	// responding to liveness probes
+	rctx, wd := newWatchdog(ctx, t.stallTimeout)
+	defer wd.stop()
+	if t.prober != nil {
+		go t.prober.run(rctx, wd)
+	}
+
+	var remoteAddr string
+	req = req.WithContext(httptrace.WithClientTrace(rctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			remoteAddr = info.Conn.RemoteAddr().String()
+		},
+	}))
+
+	// if the watchdog fired, return the reason instead of the context
+	// cancellation error, so that the transfer is retried
+	reqErr := func(err error) *httpError {
+		if werr := wd.Err(); werr != nil {
+			t.dl.Infow(duid, "http request cancelled by liveness check", "remote", remoteAddr, "err", werr.Error())
+			if t.dialer != nil && remoteAddr != "" {
+				t.dialer.markFailed(remoteAddr)
+			}
+			return &httpError{error: werr}
+		}
+		return &httpError{error: err}
+	}
+
 	// send http request and validate response
 	resp, err := t.client.Do(req)
 	if err != nil {
-		return &httpError{error: fmt.Errorf("failed to send  http req: %w", err)}
+		return reqErr(fmt.Errorf("failed to send  http req: %w", err))
 	}
 	// we should either get back a 200 or a 206 -> anything else means something has gone wrong and we return an error.
 	defer resp.Body.Close() // nolint
@@ -362,9 +690,55 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 		}
 	}
 
+	// decompress the response if the server compressed it
+	body, wire, err := decompressResponse(resp)
+	if err != nil {
+		return &httpError{error: fmt.Errorf("failed to decompress http response: %w", err)}
+	}
+	defer body.Close() // nolint
+	var decoded int64
+	if wire != nil {
+		t.encoding = resp.Header.Get("Content-Encoding")
+		t.dl.Infow(duid, "http response is compressed", "encoding", t.encoding)
+		defer func() {
+			t.wireBytes += atomic.LoadInt64(&wire.n)
+			t.decodedBytes += decoded
+		}()
+	}
+
+	// compute the checksums of the chunks received if the server sends
+	// them, and compare them with the checksums in the response trailer.
+	// If the response doesn't complete, the data received is verified at
+	// the end of the transfer.
+	var sums *chunksum.Writer
+	start := t.nBytesReceived
+	if t.checksums {
+		if chunkSize, err := strconv.ParseUint(resp.Header.Get(ChunkSizeHeader), 10, 64); err == nil && chunkSize > 0 {
+			t.chunkSize = chunkSize
+			sums = chunksum.NewWriter(uint64(start), chunkSize)
+			dst = io.MultiWriter(dst, sums)
+		}
+	}
+	complete := false
+	defer func() {
+		if sums == nil || t.nBytesReceived == start {
+			return
+		}
+		if complete {
+			ok, err := t.checkTrailer(resp, sums.Checksums())
+			if err != nil {
+				t.dl.Infow(duid, "failed to check chunk checksums", "err", err.Error())
+			}
+			if ok {
+				return
+			}
+		}
+		t.unverified = append(t.unverified, chunksum.Range{Offset: uint64(start), Length: uint64(t.nBytesReceived - start)})
+	}()
+
 	//  start reading the response stream `readBufferSize` at a time using a limit reader so we only read as many bytes as we need to.
 	buf := make([]byte, readBufferSize)
-	limitR := io.LimitReader(resp.Body, toRead)
+	limitR := io.LimitReader(body, toRead)
 	for {
 		if ctx.Err() != nil {
 			t.dl.LogError(duid, "stopped reading http response: context canceled", ctx.Err())
@@ -372,6 +746,14 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 		}
 		nr, readErr := limitR.Read(buf)
 
+		// the read may return data that was buffered before the transfer
+		// was cancelled. Don't write it: the output file may already be
+		// in use by a new transfer that resumes from its current size.
+		if ctx.Err() != nil {
+			t.dl.LogError(duid, "stopped reading http response: context canceled", ctx.Err())
+			return &httpError{error: ctx.Err()}
+		}
+
 		// if we read more than zero bytes, write whatever read.
 		if nr > 0 {
 			nw, writeErr := dst.Write(buf[0:nr])
@@ -385,21 +767,45 @@ func (t *transfer) doHttp(ctx context.Context, req *http.Request, dst io.Writer,
 			}
 
 			t.nBytesReceived = t.nBytesReceived + int64(nw)
+			wd.progress()
 
 			// emit event updating the number of bytes received
-			if err := t.emitEvent(ctx, types.TransportEvent{
+			evt := types.TransportEvent{
 				NBytesReceived: t.nBytesReceived,
-			}, t.dealInfo.DealUuid); err != nil {
+				Origin:         t.origins[t.originIdx].label(),
+				Compression:    t.encoding,
+				WireBytes:      t.wireBytes,
+				DecodedBytes:   t.decodedBytes,
+			}
+			if wire != nil {
+				decoded += int64(nw)
+				evt.WireBytes += atomic.LoadInt64(&wire.n)
+				evt.DecodedBytes += decoded
+			}
+			if err := t.emitEvent(ctx, evt, t.dealInfo.DealUuid); err != nil {
 				t.dl.LogError(duid, "failed to publish transport event", err)
 			}
+
+			// wait for the transfer's share of the bandwidth (or for the
+			// transfer to be resumed if it's paused) before reading more.
+			// The stall timer is stopped while waiting, as it's not the
+			// peer that's holding up the transfer.
+			if t.bandwidth != nil {
+				wd.suspend()
+				if err := t.bandwidth.Wait(ctx, nw); err != nil {
+					return &httpError{error: err}
+				}
+				wd.progress()
+			}
 		}
 		// the http stream we're reading from has sent us an EOF, nothing to do here.
 		if readErr == io.EOF {
 			t.dl.Infow(duid, "http server sent EOF", "received", t.nBytesReceived, "deal-size", t.dealInfo.DealSize)
+			complete = true
 			return nil
 		}
 		if readErr != nil {
-			return &httpError{error: fmt.Errorf("error reading from http response stream: %w", readErr)}
+			return reqErr(fmt.Errorf("error reading from http response stream: %w", readErr))
 		}
 	}
 }
@@ -22,6 +22,7 @@ import (
 	"github.com/filecoin-project/boost/storagemarket/logs"
 
 	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/bandwidth"
 	"github.com/filecoin-project/boost/transport/types"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-blockservice"
@@ -385,6 +386,38 @@ func TestLibp2pTransferResumption(t *testing.T) {
 	require.True(t, nAttempts.Load() > 10)
 }
 
+func TestTransferPausedByBandwidthManager(t *testing.T) {
+	ctx := context.Background()
+	size := 3 * readBufferSize
+	str := randSeq(size)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+	}))
+	defer svr.Close()
+
+	// A paused transfer doesn't fail when it stops reading for longer than
+	// the stall timeout
+	bw := bandwidth.New(0)
+	ht := New(nil, newDealLogger(t, ctx), StallTimeoutOpt(100*time.Millisecond), BandwidthOpt(bw))
+	of := getTempFilePath(t)
+	th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+	xfers := bw.Transfers()
+	require.Len(t, xfers, 1)
+	require.NoError(t, bw.Pause(xfers[0].ID))
+
+	time.Sleep(300 * time.Millisecond)
+	xfers = bw.Transfers()
+	require.Len(t, xfers, 1)
+	require.True(t, xfers[0].Paused)
+	require.Less(t, xfers[0].Received, uint64(size))
+
+	require.NoError(t, bw.Resume(xfers[0].ID))
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	require.NoError(t, evts[len(evts)-1].Error)
+	assertFileContents(t, of, []byte(str))
+}
+
 func executeTransfer(t *testing.T, ctx context.Context, ht *httpTransport, size int, req types.HttpRequest, tmpFile string) transport.Handler {
 	dealInfo := &types.TransportDealInfo{
 		OutputFile: tmpFile,
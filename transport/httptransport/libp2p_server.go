@@ -45,6 +45,10 @@ type Libp2pCarServer struct {
 	streamMonitor *streamCloseMonitor
 
 	throttler chan struct{}
+	stats     *car.TraversalStats
+	restarts  *restartTracker
+
+	compression *compressionBudget
 
 	*transfersMgr
 }
@@ -52,6 +56,16 @@ type Libp2pCarServer struct {
 type ServerConfig struct {
 	BlockInfoCacheManager car.BlockInfoCacheManager
 	ThrottleLimit         uint
+	// Bounds the memory used when traversing the DAG to serve a CAR.
+	// If nil, the DAG is traversed without a memory limit.
+	Traversal *car.TraversalOptions
+	// Limits how often the provider may ask for a transfer to be restarted
+	Restarts RestartPolicy
+	// The maximum number of transfers that are compressed at a time, if
+	// the provider accepts compressed data. Compression trades CPU for
+	// bandwidth, so it's most useful for highly compressible data.
+	// If zero, data is never compressed.
+	MaxCompressedTransfers uint
 }
 
 func NewLibp2pCarServer(h host.Host, auth *AuthTokenDB, bstore blockstore.Blockstore, cfg ServerConfig) *Libp2pCarServer {
@@ -70,6 +84,9 @@ func NewLibp2pCarServer(h host.Host, auth *AuthTokenDB, bstore blockstore.Blocks
 		cfg:          cfg,
 		bicm:         bcim,
 		throttler:    throttler,
+		stats:        &car.TraversalStats{},
+		restarts:     newRestartTracker(cfg.Restarts),
+		compression:  newCompressionBudget(cfg.MaxCompressedTransfers),
 		transfersMgr: newTransfersManager(),
 	}
 }
@@ -78,6 +95,12 @@ func (s *Libp2pCarServer) ID() peer.ID {
 	return s.h.ID()
 }
 
+// TraversalStats returns memory stats for the DAG traversals performed by
+// the server while serving CAR files
+func (s *Libp2pCarServer) TraversalStats() car.TraversalStatsSnapshot {
+	return s.stats.Snapshot()
+}
+
 func (s *Libp2pCarServer) Start(ctx context.Context) error {
 	s.ctx, s.cancel = context.WithCancel(ctx)
 
@@ -96,6 +119,9 @@ func (s *Libp2pCarServer) Start(ctx context.Context) error {
 	s.streamMonitor = newStreamCloseMonitor()
 	s.h.Network().Notify(s.streamMonitor)
 
+	// Listen for requests from the provider to restart a transfer
+	s.h.SetStreamHandler(types.TransferRestartProtocol, s.handleRestartRequest)
+
 	handler := http.NewServeMux()
 	handler.HandleFunc("/", s.handler)
 	s.server = &http.Server{
@@ -116,6 +142,7 @@ func (s *Libp2pCarServer) Start(ctx context.Context) error {
 
 func (s *Libp2pCarServer) Stop(ctx context.Context) error {
 	bicmerr := s.bicm.Close()
+	s.h.RemoveStreamHandler(types.TransferRestartProtocol)
 	s.cancel()
 	lerr := s.netListener.Close()
 	serr := s.server.Close()
@@ -210,7 +237,11 @@ func (s *Libp2pCarServer) serveContent(w http.ResponseWriter, r *http.Request, a
 	ctx := r.Context()
 
 	// Create a CarOffsetWriter and a reader for it
-	cow := car.NewCarOffsetWriter(val.PayloadCid, s.bstore, bic)
+	var opts []car.CarOffsetWriterOption
+	if s.cfg.Traversal != nil {
+		opts = append(opts, car.WithTraversalOptions(*s.cfg.Traversal, s.stats))
+	}
+	cow := car.NewCarOffsetWriter(val.PayloadCid, s.bstore, bic, opts...)
 	content := car.NewCarReaderSeeker(ctx, cow, val.Size)
 
 	// Set the Content-Type header explicitly so that http.ServeContent doesn't
@@ -218,17 +249,49 @@ func (s *Libp2pCarServer) serveContent(w http.ResponseWriter, r *http.Request, a
 	w.Header().Set("Content-Type", "application/vnd.ipld.car")
 
 	if r.Method == "HEAD" {
+		// If the provider asks for chunk checksums, send the checksums of
+		// the requested range in the response headers
+		if wantsChunkChecksums(r) {
+			sumContent := car.NewCarReaderSeeker(ctx, cow, val.Size)
+			err := setRangeChecksums(w, r, sumContent, val.Size)
+			_ = sumContent.Cancel(ctx)
+			if err != nil {
+				log.Infow("computing chunk checksums", "id", val.ID, "range", r.Header.Get("Range"), "err", err)
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return nil
+			}
+		}
+
 		// For an HTTP HEAD request we don't send any data (just headers)
 		http.ServeContent(w, r, "", time.Time{}, content)
 
 		return nil
 	}
 
+	// Compress the CAR file on the wire if the provider accepts compressed
+	// data and there's budget to compress it
+	cw, closeCompression := compressResponse(w, r, s.compression)
+
+	// The provider is fetching a chunk again because it failed checksum
+	// verification. Send the chunk without starting a new transfer.
+	if mismatch := r.Header.Get(ChunkMismatchHeader); mismatch != "" {
+		log.Warnw("provider reported chunk checksum mismatch, sending chunk again", "id", val.ID,
+			"chunk", mismatch, "peer", r.RemoteAddr)
+		tw, sums := chunkSumResponse(cw, content)
+		http.ServeContent(tw, r, "", time.Time{}, sums)
+		_ = content.Cancel(ctx)
+		if _, _, err := closeCompression(); err != nil {
+			return fmt.Errorf("compressing data: %w", err)
+		}
+		sums.sendTrailer(w)
+		return nil
+	}
+
 	// Send the CAR file
-	return s.sendCar(r, w, val, authToken, content)
+	return s.sendCar(r, cw, val, authToken, content, closeCompression)
 }
 
-func (s *Libp2pCarServer) sendCar(r *http.Request, w http.ResponseWriter, val *AuthValue, authToken string, content *car.CarReaderSeeker) error {
+func (s *Libp2pCarServer) sendCar(r *http.Request, w http.ResponseWriter, val *AuthValue, authToken string, content *car.CarReaderSeeker, closeCompression func() (int64, int64, error)) error {
 	// Create transfer
 	xfer := newLibp2pTransfer(val, authToken, s.h.ID().String(), r.RemoteAddr, content)
 
@@ -262,6 +325,15 @@ func (s *Libp2pCarServer) sendCar(r *http.Request, w http.ResponseWriter, val *A
 		}
 	}}
 
+	// Send the checksums of the chunks of the data in the trailer, if the
+	// provider asks for them
+	var rs io.ReadSeeker = readEmitter
+	var sums *chunkSumReader
+	if wantsChunkChecksums(r) {
+		w, sums = chunkSumResponse(w, readEmitter)
+		rs = sums
+	}
+
 	// http.ServeContent ignores errors when writing to the stream, so we
 	// replace the writer with a class that watches for errors
 	writeErrWatcher := &writeErrorWatcher{ResponseWriter: w, onError: func(e error) {
@@ -275,7 +347,17 @@ func (s *Libp2pCarServer) sendCar(r *http.Request, w http.ResponseWriter, val *A
 	closeCh := s.streamMonitor.getCloseChan(stream.ID())
 
 	// Send the content
-	http.ServeContent(writeErrWatcher, r, "", time.Time{}, readEmitter)
+	http.ServeContent(writeErrWatcher, r, "", time.Time{}, rs)
+
+	// Flush any compressed data that is still buffered
+	sent, wire, cerr := closeCompression()
+	if cerr != nil && err == nil {
+		err = fmt.Errorf("compressing data: %w", cerr)
+	}
+	if wire > 0 {
+		log.Infow("compressed transfer data", append(logParams, "bytes", sent, "wire bytes", wire,
+			"ratio", fmt.Sprintf("%.2f", float64(sent)/float64(wire)))...)
+	}
 
 	// Check if there was an error during the transfer
 	if err != nil {
@@ -285,6 +367,9 @@ func (s *Libp2pCarServer) sendCar(r *http.Request, w http.ResponseWriter, val *A
 		fireEvent(st)
 		return err
 	}
+	if sums != nil {
+		sums.sendTrailer(w)
+	}
 
 	go func() {
 		// Wait for the client to receive all data and close the connection
@@ -518,6 +603,12 @@ func (m *transfersMgr) run(ctx context.Context) {
 		}
 
 		// 2. Publish an event
+		if xfer == nil {
+			// The event is not associated with an active transfer (eg a
+			// restart request for a transfer that hasn't started yet)
+			m.publishEvent(action.transferState.ID, action.transferState)
+			return
+		}
 		if xfer.isRestart && action.transferState.Status == types.TransferStatusStarted {
 			// If this transfer replaces an event with the same id, it's a
 			// restart
@@ -200,6 +200,13 @@ func TestLibp2pCarServerResume(t *testing.T) {
 	lastSrvEvt := srvEvts[len(srvEvts)-1]
 	require.Equal(t, types.TransferStatusCompleted, lastSrvEvt.Status)
 	require.EqualValues(t, carSize, int(lastSrvEvt.Sent))
+
+	// Each time the transfer resumed, the provider asked the client to
+	// restart it from the data already received
+	restarts := srv.RestartRequests(id)
+	require.NotEmpty(t, restarts)
+	require.True(t, restarts[0].Accepted)
+	require.NotZero(t, restarts[0].Offset)
 }
 
 // TestLibp2pCarServerCancelTransfer verifies that cancelling a transfer
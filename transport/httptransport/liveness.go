@@ -0,0 +1,295 @@
+package httptransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	// The number of consecutive liveness probes that must fail before the
+	// connection to the peer is considered dead
+	defaultMaxProbeFailures = 2
+	// The amount of time an address that a transfer stalled on is moved to
+	// the back of the dial order
+	failedAddrTtl = 10 * time.Minute
+)
+
+var (
+	// errTransferStalled is returned when no data has been received for
+	// longer than the read stall timeout
+	errTransferStalled = errors.New("no data received before read stall timeout")
+	// errPeerUnresponsive is returned when the peer stops responding to
+	// liveness probes
+	errPeerUnresponsive = errors.New("peer stopped responding to liveness probes")
+)
+
+// StallTimeoutOpt sets the time that can elapse without receiving any data
+// before the http request is cancelled and the transfer is restarted.
+// Set to zero to disable.
+func StallTimeoutOpt(timeout time.Duration) Option {
+	return func(h *httpTransport) {
+		h.stallTimeout = timeout
+	}
+}
+
+// KeepaliveOpt sets how often the peer is probed while a libp2p transfer is
+// in progress, and how long to wait for each probe. When maxFailures probes
+// in a row fail, the connection is closed and the transfer is restarted. For
+// plain http transfers the interval is used as the TCP keepalive period.
+// Set the interval to zero to disable.
+func KeepaliveOpt(interval, timeout time.Duration, maxFailures int) Option {
+	return func(h *httpTransport) {
+		h.keepaliveInterval = interval
+		h.keepaliveTimeout = timeout
+		h.maxProbeFailures = maxFailures
+	}
+}
+
+// watchdog cancels the context of a single http request when the request
+// stops making progress, recording the reason so that the transfer can tell
+// a stalled request apart from a cancelled transfer
+type watchdog struct {
+	cancel context.CancelFunc
+
+	lk    sync.Mutex
+	err   error
+	timer *time.Timer
+	// the timeout after which the request is considered stalled
+	timeout time.Duration
+}
+
+func newWatchdog(ctx context.Context, stallTimeout time.Duration) (context.Context, *watchdog) {
+	wctx, cancel := context.WithCancel(ctx)
+	w := &watchdog{cancel: cancel, timeout: stallTimeout}
+	if stallTimeout > 0 {
+		w.timer = time.AfterFunc(stallTimeout, func() {
+			w.fail(fmt.Errorf("%w (%s)", errTransferStalled, stallTimeout))
+		})
+	}
+	return wctx, w
+}
+
+// progress is called each time data is received from the peer
+func (w *watchdog) progress() {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.timer != nil && w.err == nil {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// suspend stops the stall timer until the next call to progress, while the
+// transfer deliberately isn't reading data
+func (w *watchdog) suspend() {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// fail cancels the request with the given reason. Only the first reason is
+// recorded.
+func (w *watchdog) fail(err error) {
+	w.lk.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.lk.Unlock()
+	w.cancel()
+}
+
+// Err returns the reason the request was cancelled, or nil if the watchdog
+// has not fired
+func (w *watchdog) Err() error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.err
+}
+
+func (w *watchdog) stop() {
+	w.lk.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.lk.Unlock()
+	w.cancel()
+}
+
+// peerProber probes a libp2p peer at a regular interval with the ping
+// protocol, over the existing connection
+type peerProber struct {
+	host        host.Host
+	peerID      peer.ID
+	interval    time.Duration
+	timeout     time.Duration
+	maxFailures int
+
+	dealUuid uuid.UUID
+	dl       *logs.DealLogger
+}
+
+// run probes the peer until the context is cancelled. If the peer fails to
+// respond to maxFailures probes in a row, the connections to the peer are
+// closed (so that the next attempt redials, possibly on a different address)
+// and the watchdog is fired.
+func (p *peerProber) run(ctx context.Context, wd *watchdog) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := p.probe(ctx)
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		failures++
+		p.dl.Infow(p.dealUuid, "liveness probe failed", "peer", p.peerID, "failures", failures, "err", err)
+		if failures < p.maxFailures {
+			continue
+		}
+
+		p.dl.Warnw(p.dealUuid, "peer is unresponsive, closing connection", "peer", p.peerID, "failures", failures, "err", err)
+		_ = p.host.Network().ClosePeer(p.peerID)
+		wd.fail(fmt.Errorf("%w after %d attempts: %s", errPeerUnresponsive, failures, err))
+		return
+	}
+}
+
+func (p *peerProber) probe(ctx context.Context) error {
+	// Don't dial: if the connection has gone away the probe should fail
+	pctx, cancel := context.WithTimeout(network.WithNoDial(ctx, "liveness probe"), p.timeout)
+	defer cancel()
+
+	select {
+	case res, ok := <-ping.Ping(pctx, p.host, p.peerID):
+		if !ok {
+			return pctx.Err()
+		}
+		return res.Error
+	case <-pctx.Done():
+		return pctx.Err()
+	}
+}
+
+// failoverDialer dials each of the IP addresses that a host name resolves
+// to in turn. Addresses that a transfer recently stalled on are tried last,
+// so that a restarted transfer will prefer an alternate address.
+type failoverDialer struct {
+	dialer   *net.Dialer
+	resolver *net.Resolver
+
+	lk     sync.Mutex
+	failed map[string]time.Time
+}
+
+func newFailoverDialer(keepalive time.Duration) *failoverDialer {
+	return &failoverDialer{
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: keepalive,
+		},
+		resolver: net.DefaultResolver,
+		failed:   make(map[string]time.Time),
+	}
+}
+
+// markFailed records that a transfer stalled on the given remote address
+func (d *failoverDialer) markFailed(addr string) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.failed[addr] = time.Now()
+}
+
+func (d *failoverDialer) isFailed(addr string) bool {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	at, ok := d.failed[addr]
+	if !ok {
+		return false
+	}
+	if time.Since(at) > failedAddrTtl {
+		delete(d.failed, addr)
+		return false
+	}
+	return true
+}
+
+func (d *failoverDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	hostname, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := d.dialOrder(ctx, hostname, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := d.dialer.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// dialOrder returns the addresses to dial, with the addresses that have
+// recently failed at the end
+func (d *failoverDialer) dialOrder(ctx context.Context, hostname string, port string) ([]string, error) {
+	var ips []string
+	if ip := net.ParseIP(hostname); ip != nil {
+		ips = []string{hostname}
+	} else {
+		ipAddrs, err := d.resolver.LookupIPAddr(ctx, hostname)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", hostname, err)
+		}
+		for _, ipAddr := range ipAddrs {
+			ips = append(ips, ipAddr.String())
+		}
+	}
+
+	addrs := make([]string, 0, len(ips))
+	var failed []string
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip, port)
+		if d.isFailed(addr) {
+			failed = append(failed, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return append(addrs, failed...), nil
+}
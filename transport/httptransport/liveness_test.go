@@ -0,0 +1,69 @@
+package httptransport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferRestartsAfterStall(t *testing.T) {
+	ctx := context.Background()
+	of := getTempFilePath(t)
+
+	size := (10 * readBufferSize) + 30
+	str := randSeq(size)
+
+	// The first request sends half the data and then stops sending without
+	// closing the connection. Subsequent requests serve the rest of the data.
+	var reqs int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&reqs, 1) == 1 {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(str[:size/2]))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+	}
+	svr := httptest.NewServer(http.HandlerFunc(handler))
+	defer svr.Close()
+
+	ht := New(nil, newDealLogger(t, ctx),
+		StallTimeoutOpt(200*time.Millisecond),
+		BackOffRetryOpt(50*time.Millisecond, 100*time.Millisecond, 2, 5))
+	th := executeTransfer(t, ctx, ht, size, types.HttpRequest{URL: svr.URL}, of)
+	require.NotNil(t, th)
+
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	last := evts[len(evts)-1]
+	require.NoError(t, last.Error)
+	require.EqualValues(t, size, last.NBytesReceived)
+	require.EqualValues(t, 2, atomic.LoadInt32(&reqs))
+	assertFileContents(t, of, []byte(str))
+
+	// The address that stalled should be tried last by the next transfer
+	addr := strings.TrimPrefix(svr.URL, "http://")
+	require.True(t, ht.dialer.isFailed(addr))
+}
+
+func TestFailoverDialOrder(t *testing.T) {
+	d := newFailoverDialer(0)
+	d.markFailed("127.0.0.1:80")
+
+	addrs, err := d.dialOrder(context.Background(), "127.0.0.1", "80")
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1:80"}, addrs)
+
+	// Failed addresses expire
+	d.failed["127.0.0.1:80"] = time.Now().Add(-2 * failedAddrTtl)
+	require.False(t, d.isFailed("127.0.0.1:80"))
+}
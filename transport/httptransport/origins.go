@@ -0,0 +1,153 @@
+package httptransport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+)
+
+const (
+	// The maximum time to wait for an origin to respond to a probe
+	originProbeTimeout = 10 * time.Second
+	// Origins whose latency is within the same bucket are considered to be
+	// equally close, and are ordered by the client's priority hint
+	originLatencyBucket = 25 * time.Millisecond
+)
+
+// origin is an http server that the deal data can be downloaded from
+type origin struct {
+	url      string
+	headers  map[string]string
+	region   string
+	priority int
+	// identifies the origin in logs and in the deal state
+	name string
+
+	// the results of probing the origin
+	healthy  bool
+	latency  time.Duration
+	probeErr error
+	// set if the origin rejected the request (eg with a 403 or 404)
+	rejected bool
+}
+
+// originsFromRequest returns the request URL and its mirrors as origins
+func originsFromRequest(tInfo *types.HttpRequest) ([]*origin, error) {
+	origins := []*origin{newOrigin(tInfo.URL, tInfo.Headers, "", 0)}
+	for _, m := range tInfo.Mirrors {
+		u, err := url.Parse(m.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing mirror url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("mirror url '%s' must be an http or https url", originLabel(m.URL))
+		}
+		headers := m.Headers
+		if headers == nil {
+			headers = tInfo.Headers
+		}
+		origins = append(origins, newOrigin(m.URL, headers, m.Region, m.Priority))
+	}
+	return origins, nil
+}
+
+func newOrigin(rawUrl string, headers map[string]string, region string, priority int) *origin {
+	name := originLabel(rawUrl)
+	if region != "" {
+		name = region + " " + name
+	}
+	return &origin{
+		url:      rawUrl,
+		headers:  headers,
+		region:   region,
+		priority: priority,
+		name:     name,
+		healthy:  true,
+	}
+}
+
+func (o *origin) label() string {
+	return o.name
+}
+
+// originLabel strips credentials and query parameters (eg the signature of
+// a pre-signed URL) from an origin URL so that it's safe to log
+func originLabel(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// rankOrigins probes all the origins in parallel, and sorts them so that
+// healthy origins come first, then the closest origins, then origins with
+// the lowest priority hint
+func rankOrigins(ctx context.Context, client *http.Client, origins []*origin) {
+	var wg sync.WaitGroup
+	for _, o := range origins {
+		wg.Add(1)
+		go func(o *origin) {
+			defer wg.Done()
+			o.latency, o.probeErr = probeOrigin(ctx, client, o)
+			o.healthy = o.probeErr == nil
+		}(o)
+	}
+	wg.Wait()
+
+	sort.SliceStable(origins, func(i, j int) bool {
+		a, b := origins[i], origins[j]
+		if a.healthy != b.healthy {
+			return a.healthy
+		}
+		if !a.healthy {
+			return false
+		}
+		ab, bb := a.latency/originLatencyBucket, b.latency/originLatencyBucket
+		if ab != bb {
+			return ab < bb
+		}
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return a.latency < b.latency
+	})
+}
+
+// probeOrigin requests the first byte of the data from the origin and
+// returns the time taken to get a response. A GET is used rather than a
+// HEAD because pre-signed URLs are usually only valid for GET requests.
+func probeOrigin(ctx context.Context, client *http.Client, o *origin) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, originProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, val := range o.headers {
+		req.Header.Set(name, val)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return latency, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return latency, nil
+}
@@ -0,0 +1,160 @@
+package httptransport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRankOrigins(t *testing.T) {
+	content := "some deal data"
+	serve := func(delay time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}))
+	}
+	slow := serve(200 * time.Millisecond)
+	defer slow.Close()
+	fast := serve(0)
+	defer fast.Close()
+	fastLowPriority := serve(0)
+	defer fastLowPriority.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	origins, err := originsFromRequest(&types.HttpRequest{
+		URL: broken.URL + "/data?X-Amz-Signature=secret",
+		Mirrors: []types.HttpMirror{
+			{URL: slow.URL, Region: "ap-south-1"},
+			{URL: fastLowPriority.URL, Priority: 2},
+			{URL: fast.URL, Region: "us-east-1", Priority: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	rankOrigins(context.Background(), http.DefaultClient, origins)
+	require.Equal(t, fast.URL, origins[0].url)
+	require.Equal(t, fastLowPriority.URL, origins[1].url)
+	require.Equal(t, slow.URL, origins[2].url)
+	require.Equal(t, broken.URL+"/data?X-Amz-Signature=secret", origins[3].url)
+	require.False(t, origins[3].healthy)
+
+	// Labels don't include query parameters
+	require.Equal(t, broken.URL+"/data", origins[3].label())
+	require.Equal(t, "us-east-1 "+fast.URL, origins[0].label())
+
+	// Mirrors must be http urls
+	_, err = originsFromRequest(&types.HttpRequest{
+		URL:     fast.URL,
+		Mirrors: []types.HttpMirror{{URL: "libp2p:///ip4/127.0.0.1/tcp/1234"}},
+	})
+	require.Error(t, err)
+}
+
+func TestTransferFailsOverToMirror(t *testing.T) {
+	ctx := context.Background()
+	of := getTempFilePath(t)
+
+	size := (3 * readBufferSize) + 30
+	str := randSeq(size)
+
+	// The primary origin serves the probe, but rejects the transfer (eg
+	// because the data hasn't been replicated to it yet)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=0-0" {
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer primary.Close()
+
+	// The mirror is slower, so it's ranked second
+	var mirrorAuth string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorAuth = r.Header.Get("Authorization")
+		time.Sleep(100 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+	}))
+	defer mirror.Close()
+
+	ht := New(nil, newDealLogger(t, ctx), BackOffRetryOpt(50*time.Millisecond, 100*time.Millisecond, 2, 5))
+	req := types.HttpRequest{
+		URL:     primary.URL,
+		Headers: map[string]string{"Authorization": "primary"},
+		Mirrors: []types.HttpMirror{{
+			URL:     mirror.URL,
+			Headers: map[string]string{"Authorization": "mirror"},
+			Region:  "eu-west-1",
+		}},
+	}
+	th := executeTransfer(t, ctx, ht, size, req, of)
+	require.NotNil(t, th)
+
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	last := evts[len(evts)-1]
+	require.NoError(t, last.Error)
+	require.EqualValues(t, size, last.NBytesReceived)
+	require.Equal(t, "eu-west-1 "+mirror.URL, last.Origin)
+	require.Equal(t, "mirror", mirrorAuth)
+	assertFileContents(t, of, []byte(str))
+}
+
+func TestTransferTriesMirrorsInOrder(t *testing.T) {
+	ctx := context.Background()
+	of := getTempFilePath(t)
+
+	size := (3 * readBufferSize) + 30
+	str := randSeq(size)
+
+	// The primary origin is down
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	// The first mirror is slower than the second, but as the client asked
+	// for the mirrors to be tried in order, it's tried first
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+	}))
+	defer first.Close()
+	var secondRequests int32
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondRequests, 1)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(str))
+	}))
+	defer second.Close()
+
+	ht := New(nil, newDealLogger(t, ctx), BackOffRetryOpt(time.Minute, time.Minute, 2, 1))
+	req := types.HttpRequest{
+		URL:            primary.URL,
+		Mirrors:        []types.HttpMirror{{URL: first.URL, Priority: 1}, {URL: second.URL, Priority: 2}},
+		MirrorsInOrder: true,
+		// The client's retry policy overrides the provider's
+		Retry: &types.RetryPolicy{MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond},
+	}
+	th := executeTransfer(t, ctx, ht, size, req, of)
+	require.NotNil(t, th)
+
+	evts := waitForTransferComplete(th)
+	require.NotEmpty(t, evts)
+	last := evts[len(evts)-1]
+	require.NoError(t, last.Error)
+	require.EqualValues(t, size, last.NBytesReceived)
+	require.Equal(t, first.URL, last.Origin)
+	require.Zero(t, atomic.LoadInt32(&secondRequests))
+	assertFileContents(t, of, []byte(str))
+}
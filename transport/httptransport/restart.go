@@ -0,0 +1,215 @@
+package httptransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// DefaultMaxRestarts is the default number of times the provider may
+	// ask the client to restart a transfer
+	DefaultMaxRestarts = 5
+	// DefaultMinRestartInterval is the default minimum time between restart
+	// requests for the same transfer
+	DefaultMinRestartInterval = 10 * time.Second
+
+	// restartStreamTimeout bounds the time to read a restart request and
+	// write the response
+	restartStreamTimeout = 30 * time.Second
+	// maxRestartMessageSize is the maximum size of a restart request
+	maxRestartMessageSize = 64 * 1024
+)
+
+// RestartPolicy limits how often the provider can ask the client to restart
+// a transfer
+type RestartPolicy struct {
+	// The maximum number of restart requests accepted for a transfer.
+	// If zero, DefaultMaxRestarts is used. If negative, all restart requests
+	// are rejected.
+	MaxRestarts int
+	// The minimum time between restart requests for the same transfer.
+	// If zero, DefaultMinRestartInterval is used.
+	MinInterval time.Duration
+}
+
+// TransferRestart records a restart request from the provider
+type TransferRestart struct {
+	At       time.Time
+	Offset   uint64
+	Reason   string
+	Accepted bool
+	Message  string
+}
+
+// restartTracker applies the restart policy, and keeps a record of restart
+// requests for each transfer
+type restartTracker struct {
+	policy RestartPolicy
+	now    func() time.Time
+
+	lk       sync.Mutex
+	restarts map[string][]TransferRestart
+}
+
+func newRestartTracker(policy RestartPolicy) *restartTracker {
+	if policy.MaxRestarts == 0 {
+		policy.MaxRestarts = DefaultMaxRestarts
+	}
+	if policy.MinInterval == 0 {
+		policy.MinInterval = DefaultMinRestartInterval
+	}
+	return &restartTracker{
+		policy:   policy,
+		now:      time.Now,
+		restarts: make(map[string][]TransferRestart),
+	}
+}
+
+// request checks whether a restart of the transfer from offset is allowed by
+// the policy, and records the request
+func (t *restartTracker) request(id string, size uint64, offset uint64, reason string) TransferRestart {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	r := TransferRestart{At: t.now(), Offset: offset, Reason: reason}
+	prev := t.restarts[id]
+	accepted := 0
+	var last time.Time
+	for _, p := range prev {
+		if p.Accepted {
+			accepted++
+			last = p.At
+		}
+	}
+
+	switch {
+	case t.policy.MaxRestarts < 0:
+		r.Message = "transfer restarts are disabled"
+	case size > 0 && offset > size:
+		r.Message = fmt.Sprintf("offset %d is beyond the end of the data (%d bytes)", offset, size)
+	case accepted >= t.policy.MaxRestarts:
+		r.Message = fmt.Sprintf("transfer has already been restarted %d times", accepted)
+	case !last.IsZero() && r.At.Sub(last) < t.policy.MinInterval:
+		r.Message = fmt.Sprintf("transfer was restarted %s ago, minimum interval is %s",
+			r.At.Sub(last).Truncate(time.Millisecond), t.policy.MinInterval)
+	default:
+		r.Accepted = true
+	}
+
+	t.restarts[id] = append(prev, r)
+	return r
+}
+
+func (t *restartTracker) get(id string) []TransferRestart {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	return append([]TransferRestart(nil), t.restarts[id]...)
+}
+
+// RestartRequests returns the restart requests that the provider has made
+// for the transfer with the given id
+func (s *Libp2pCarServer) RestartRequests(id string) []TransferRestart {
+	return s.restarts.get(id)
+}
+
+// handleRestartRequest is called when the provider asks the client to
+// restart a transfer. If the request is accepted, a restarted event is fired
+// for the transfer and the provider may resume requesting data from the
+// offset.
+func (s *Libp2pCarServer) handleRestartRequest(stream network.Stream) {
+	defer stream.Close() //nolint:errcheck
+	_ = stream.SetDeadline(time.Now().Add(restartStreamTimeout))
+
+	remote := stream.Conn().RemotePeer()
+	var req types.TransferRestartRequest
+	err := json.NewDecoder(io.LimitReader(stream, maxRestartMessageSize)).Decode(&req)
+	if err != nil {
+		log.Infow("reading transfer restart request", "peer", remote, "err", err)
+		return
+	}
+
+	resp := s.processRestartRequest(s.ctx, remote, req)
+	if err := json.NewEncoder(stream).Encode(&resp); err != nil {
+		log.Infow("writing transfer restart response", "peer", remote, "err", err)
+	}
+}
+
+func (s *Libp2pCarServer) processRestartRequest(ctx context.Context, remote peer.ID, req types.TransferRestartRequest) types.TransferRestartResponse {
+	val, err := s.auth.Get(ctx, req.AuthToken)
+	if err != nil {
+		if !errors.Is(err, ErrTokenNotFound) {
+			log.Warnw("getting auth token for transfer restart request", "peer", remote, "err", err)
+		}
+		log.Infow("rejected transfer restart request with unrecognized auth token", "peer", remote)
+		return types.TransferRestartResponse{Message: "unrecognized auth token"}
+	}
+
+	r := s.restarts.request(val.ID, val.Size, req.Offset, req.Reason)
+	logParams := []interface{}{"id", val.ID, "peer", remote, "offset", req.Offset, "reason", req.Reason}
+	if !r.Accepted {
+		log.Infow("rejected transfer restart request", append(logParams, "err", r.Message)...)
+		return types.TransferRestartResponse{Message: r.Message}
+	}
+
+	log.Infow("provider requested transfer restart", logParams...)
+	msg := fmt.Sprintf("provider requested restart from offset %d", req.Offset)
+	if req.Reason != "" {
+		msg += ": " + req.Reason
+	}
+	s.transfersMgr.restartRequested(val, msg)
+
+	return types.TransferRestartResponse{Accepted: true}
+}
+
+// restartRequested fires a restarted event for the transfer
+func (m *transfersMgr) restartRequested(val *AuthValue, msg string) {
+	st := types.TransferState{
+		ID:         val.ID,
+		Status:     types.TransferStatusRestarted,
+		Message:    msg,
+		PayloadCid: val.PayloadCid,
+	}
+	xfer, err := m.Get(val.ID)
+	if err == nil {
+		st = xfer.State()
+		st.Status = types.TransferStatusRestarted
+		st.Message = msg
+	}
+	_ = m.enqueueAction(&xferAction{xfer: xfer, transferState: &st}) //nolint:errcheck
+}
+
+// SendTransferRestartRequest asks the client serving the data for a transfer
+// to restart the transfer from an offset
+func SendTransferRestartRequest(ctx context.Context, h host.Host, client peer.ID, req types.TransferRestartRequest) (*types.TransferRestartResponse, error) {
+	stream, err := h.NewStream(ctx, client, types.TransferRestartProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("opening transfer restart stream to %s: %w", client, err)
+	}
+	defer stream.Close() //nolint:errcheck
+	_ = stream.SetDeadline(time.Now().Add(restartStreamTimeout))
+
+	if err := json.NewEncoder(stream).Encode(&req); err != nil {
+		return nil, fmt.Errorf("sending transfer restart request: %w", err)
+	}
+	if err := stream.CloseWrite(); err != nil {
+		return nil, fmt.Errorf("closing transfer restart stream for writing: %w", err)
+	}
+
+	var resp types.TransferRestartResponse
+	err = json.NewDecoder(io.LimitReader(stream, maxRestartMessageSize)).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("reading transfer restart response: %w", err)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,88 @@
+package httptransport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/testutil"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferRestartRequests(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	authDB := NewAuthTokenDB(dssync.MutexWrap(datastore.NewMapDatastore()))
+	authToken, err := GenerateAuthToken()
+	req.NoError(err)
+	val := AuthValue{ID: "deal-1", PayloadCid: testutil.GenerateCid(), Size: 1000}
+	req.NoError(authDB.Put(ctx, authToken, val))
+
+	srv := NewLibp2pCarServer(nil, authDB, nil, ServerConfig{
+		Restarts: RestartPolicy{MaxRestarts: 2, MinInterval: time.Minute},
+	})
+	now := time.Now()
+	srv.restarts.now = func() time.Time { return now }
+	srv.transfersMgr.start(ctx)
+
+	evts := make(chan types.TransferState, 16)
+	unsub := srv.Subscribe(func(id string, st types.TransferState) {
+		evts <- st
+	})
+	defer unsub()
+
+	restart := func(token string, offset uint64) types.TransferRestartResponse {
+		return srv.processRestartRequest(ctx, "", types.TransferRestartRequest{
+			AuthToken: token,
+			Offset:    offset,
+			Reason:    "provider restarted",
+		})
+	}
+
+	// Unknown auth tokens are rejected
+	resp := restart("unknown", 0)
+	req.False(resp.Accepted)
+
+	// A restart within the limits is accepted and fires a restarted event
+	resp = restart(authToken, 200)
+	req.True(resp.Accepted)
+	select {
+	case st := <-evts:
+		req.Equal("deal-1", st.ID)
+		req.Equal(types.TransferStatusRestarted, st.Status)
+		req.Contains(st.Message, "offset 200: provider restarted")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for restarted event")
+	}
+
+	// Restarts within the minimum interval are rejected
+	now = now.Add(time.Second)
+	resp = restart(authToken, 200)
+	req.False(resp.Accepted)
+	req.Contains(resp.Message, "minimum interval")
+
+	// Offsets beyond the end of the data are rejected
+	now = now.Add(time.Minute)
+	resp = restart(authToken, 2000)
+	req.False(resp.Accepted)
+
+	// Restarts beyond the maximum are rejected
+	resp = restart(authToken, 0)
+	req.True(resp.Accepted)
+	now = now.Add(time.Minute)
+	resp = restart(authToken, 0)
+	req.False(resp.Accepted)
+	req.Contains(resp.Message, "restarted 2 times")
+
+	// All requests are recorded
+	history := srv.RestartRequests("deal-1")
+	req.Len(history, 5)
+	req.True(history[0].Accepted)
+	req.EqualValues(200, history[0].Offset)
+	req.False(history[4].Accepted)
+}
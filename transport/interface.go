@@ -21,6 +21,34 @@ type Handler interface {
 }
 
 func TransferParamsAsJson(transfer smtypes.Transfer) (string, error) {
+	if transfer.Type == "tcp" {
+		tInfo := &types.TcpRequest{}
+		if err := json.Unmarshal(transfer.Params, tInfo); err != nil {
+			return "", fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(transfer.Params), err)
+		}
+
+		// Leave out the token, which authorizes the download
+		bz, err := json.Marshal(map[string]string{
+			"Addr": tInfo.Addr,
+		})
+		if err != nil {
+			return "", fmt.Errorf("marshalling transfer params json: %w", err)
+		}
+		return string(bz), nil
+	}
+
+	if transfer.Type == "s3" {
+		tInfo := &types.S3Request{}
+		if err := json.Unmarshal(transfer.Params, tInfo); err != nil {
+			return "", fmt.Errorf("failed to de-serialize transport params bytes '%s': %w", string(transfer.Params), err)
+		}
+		bz, err := json.Marshal(tInfo)
+		if err != nil {
+			return "", fmt.Errorf("marshalling transfer params json: %w", err)
+		}
+		return string(bz), nil
+	}
+
 	if transfer.Type != "http" && transfer.Type != "libp2p" {
 		return "", fmt.Errorf("cannot parse params for unrecognized transfer type '%s'", transfer.Type)
 	}
@@ -0,0 +1,189 @@
+package s3transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The EC2 instance metadata service, that serves the credentials of
+	// the instance's IAM role
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	// The ECS task metadata endpoint, that serves the credentials of the
+	// task's IAM role
+	ecsCredentialsEndpoint = "http://169.254.170.2"
+
+	// Temporary credentials are refreshed this long before they expire
+	credentialsRefreshWindow = 5 * time.Minute
+)
+
+// Credentials are the AWS credentials that requests to S3 are signed with
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// The time at which temporary credentials expire (zero if the
+	// credentials don't expire)
+	Expires time.Time
+}
+
+// credentialsProvider gets the credentials to sign requests with, from
+// (in order):
+// - the credentials in the config
+// - the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables
+// - the IAM role of the ECS task, if running in ECS
+// - the IAM role of the EC2 instance, from the instance metadata service
+type credentialsProvider struct {
+	static       *Credentials
+	imdsEndpoint string
+	client       *http.Client
+
+	lk     sync.Mutex
+	cached *Credentials
+}
+
+func newCredentialsProvider(static *Credentials) *credentialsProvider {
+	return &credentialsProvider{
+		static:       static,
+		imdsEndpoint: defaultIMDSEndpoint,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *credentialsProvider) get(ctx context.Context) (*Credentials, error) {
+	if p.static != nil {
+		return p.static, nil
+	}
+
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if p.cached != nil && time.Until(p.cached.Expires) > credentialsRefreshWindow {
+		return p.cached, nil
+	}
+
+	var creds *Credentials
+	var err error
+	if ecsURL, ecsToken := ecsCredentialsURL(); ecsURL != "" {
+		creds, err = p.ecsRoleCredentials(ctx, ecsURL, ecsToken)
+	} else {
+		creds, err = p.instanceRoleCredentials(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no S3 credentials configured, and getting IAM role credentials failed: %w", err)
+	}
+	p.cached = creds
+	return creds, nil
+}
+
+// ecsCredentialsURL returns the url of the ECS task's role credentials and
+// the token to fetch them with, or an empty url if not running in ECS
+func ecsCredentialsURL() (string, string) {
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		return ecsCredentialsEndpoint + rel, token
+	}
+	return os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), token
+}
+
+func (p *credentialsProvider) ecsRoleCredentials(ctx context.Context, credsURL string, token string) (*Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, credsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return p.fetchRoleCredentials(req)
+}
+
+func (p *credentialsProvider) instanceRoleCredentials(ctx context.Context) (*Credentials, error) {
+	// Get a session token for the metadata service (IMDSv2)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := p.fetch(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance metadata token: %w", err)
+	}
+
+	// Get the name of the instance's role
+	rolesURL := p.imdsEndpoint + "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, rolesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+	roles, err := p.fetch(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance IAM role: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("the instance has no IAM role")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, rolesURL+role, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+	return p.fetchRoleCredentials(req)
+}
+
+func (p *credentialsProvider) fetchRoleCredentials(req *http.Request) (*Credentials, error) {
+	body, err := p.fetch(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting IAM role credentials: %w", err)
+	}
+	var resp struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parsing IAM role credentials: %w", err)
+	}
+	if resp.AccessKeyId == "" || resp.SecretAccessKey == "" {
+		return nil, errors.New("IAM role credentials are empty")
+	}
+	return &Credentials{
+		AccessKeyID:     resp.AccessKeyId,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.Token,
+		Expires:         resp.Expiration,
+	}, nil
+}
+
+func (p *credentialsProvider) fetch(req *http.Request) ([]byte, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: status %d", req.Method, req.URL, resp.StatusCode)
+	}
+	return body, nil
+}
@@ -0,0 +1,590 @@
+// Package s3transport pulls deal data from an S3-compatible object store
+// (transfer type "s3"), such as AWS S3 or MinIO.
+//
+// The object is downloaded in parts with ranged GET requests, several parts
+// at a time. Parts are appended to the output file in order, so that a
+// transfer that is restarted resumes from the size of the file. Once the
+// download is complete, the data is checked against the checksum in the
+// transfer params, or else against the checksum that S3 stores for the
+// object.
+package s3transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/jpillora/backoff"
+)
+
+const (
+	defaultRegion      = "us-east-1"
+	defaultPartSize    = 16 << 20
+	defaultConcurrency = 4
+	defaultMaxAttempts = 5
+
+	minBackOff = 2 * time.Second
+	maxBackOff = time.Minute
+
+	// The time allowed to download a single part
+	partTimeout = 5 * time.Minute
+)
+
+// errFatal is returned for errors that are not resolved by retrying, in
+// which case the request is not retried
+type errFatal struct {
+	error
+}
+
+func (e *errFatal) Unwrap() error {
+	return e.error
+}
+
+type Config struct {
+	// Endpoint is the url of an S3-compatible service, eg
+	// "https://minio.example.com:9000". Objects are addressed by path
+	// (endpoint/bucket/key). If empty, AWS S3 is used.
+	Endpoint string
+	// Region is the region that requests are signed for. Defaults to
+	// us-east-1.
+	Region string
+	// Credentials are the credentials that requests are signed with. If
+	// nil, the credentials are taken from the environment or from the IAM
+	// role of the ECS task or EC2 instance.
+	Credentials *Credentials
+	// Buckets are the buckets that deal data may be pulled from. Transfers
+	// from other buckets are rejected, so that clients can't use the
+	// provider's credentials to read other objects.
+	Buckets []string
+	// PartSize is the size of each ranged download
+	PartSize int64
+	// Concurrency is the number of parts that are downloaded at a time
+	Concurrency int
+	// MaxAttempts is the number of times a request is attempted before the
+	// transfer fails
+	MaxAttempts int
+}
+
+var _ transport.Transport = (*s3Transport)(nil)
+
+type s3Transport struct {
+	cfg    Config
+	creds  *credentialsProvider
+	client *http.Client
+
+	dl *logs.DealLogger
+}
+
+func New(dealLogger *logs.DealLogger, cfg Config) *s3Transport {
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	return &s3Transport{
+		cfg:    cfg,
+		creds:  newCredentialsProvider(cfg.Credentials),
+		client: &http.Client{},
+		dl:     dealLogger.Subsystem("s3-transport"),
+	}
+}
+
+// ParseURL returns the bucket and key of an s3 url, eg
+// "s3://bucket/path/to/file.car"
+func ParseURL(s3url string) (string, string, error) {
+	u, err := url.Parse(s3url)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing s3 url '%s': %w", s3url, err)
+	}
+	if u.Scheme != "s3" {
+		return "", "", fmt.Errorf("s3 url '%s' must have the scheme s3://", s3url)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("s3 url '%s' must be of the form s3://bucket/key", s3url)
+	}
+	return u.Host, key, nil
+}
+
+func (t *s3Transport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (transport.Handler, error) {
+	duuid := dealInfo.DealUuid
+	t.dl.Infow(duuid, "execute s3 transfer", "deal size", dealInfo.DealSize, "output file", dealInfo.OutputFile)
+
+	// de-serialize transport opaque token
+	tInfo := &types.S3Request{}
+	if err := json.Unmarshal(transportInfo, tInfo); err != nil {
+		return nil, fmt.Errorf("failed to de-serialize transport info bytes, bytes:%s, err:%w", string(transportInfo), err)
+	}
+	bucket, key, err := ParseURL(tInfo.URL)
+	if err != nil {
+		return nil, err
+	}
+	if !t.bucketAllowed(bucket) {
+		return nil, fmt.Errorf("pulling deal data from bucket '%s' is not allowed", bucket)
+	}
+	if tInfo.SHA256 != "" {
+		if h, err := hex.DecodeString(tInfo.SHA256); err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 '%s'", tInfo.SHA256)
+		}
+	}
+
+	// check that the outputFile exists
+	fi, err := os.Stat(dealInfo.OutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("output file state error: %w", err)
+	}
+	fileSize := fi.Size()
+	if fileSize > dealInfo.DealSize {
+		return nil, fmt.Errorf("deal size=%d but file size=%d", dealInfo.DealSize, fileSize)
+	}
+
+	tctx, cancel := context.WithCancel(ctx)
+	tr := &transfer{
+		cancel:   cancel,
+		t:        t,
+		tInfo:    tInfo,
+		bucket:   bucket,
+		key:      key,
+		dealInfo: dealInfo,
+		eventCh:  make(chan types.TransportEvent, 256),
+	}
+
+	// is the transfer already complete ?
+	if fileSize == dealInfo.DealSize {
+		defer cancel()
+		defer close(tr.eventCh)
+		if err := tr.emitEvent(types.TransportEvent{NBytesReceived: fileSize}); err != nil {
+			return nil, fmt.Errorf("failed to publish transfer completion event, id: %s, err: %w", duuid, err)
+		}
+		t.dl.Infow(duuid, "file size is already equal to deal size, returning")
+		return tr, nil
+	}
+
+	tr.wg.Add(1)
+	go func() {
+		defer tr.wg.Done()
+		defer cancel()
+		defer close(tr.eventCh)
+
+		if err := tr.execute(tctx); err != nil {
+			if err := tr.emitEvent(types.TransportEvent{Error: err}); err != nil {
+				t.dl.LogError(duuid, "failed to publish transport error", err)
+			}
+		}
+	}()
+
+	t.dl.Infow(duuid, "started async s3 transfer", "url", tInfo.URL)
+	return tr, nil
+}
+
+func (t *s3Transport) bucketAllowed(bucket string) bool {
+	for _, b := range t.cfg.Buckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// objectURL returns the url of the object: virtual-hosted style for AWS,
+// and path style for other S3-compatible services
+func (t *s3Transport) objectURL(bucket string, key string) (*url.URL, error) {
+	if t.cfg.Endpoint == "" {
+		return &url.URL{
+			Scheme:  "https",
+			Host:    bucket + ".s3." + t.cfg.Region + ".amazonaws.com",
+			Path:    "/" + key,
+			RawPath: "/" + uriEncode(key, false),
+		}, nil
+	}
+
+	u, err := url.Parse(t.cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing s3 endpoint '%s': %w", t.cfg.Endpoint, err)
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + "/" + bucket + "/" + key
+	u.RawPath = uriEncode(base, false) + "/" + uriEncode(bucket, false) + "/" + uriEncode(key, false)
+	return u, nil
+}
+
+type transfer struct {
+	closeOnce sync.Once
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	t       *s3Transport
+	eventCh chan types.TransportEvent
+
+	tInfo    *types.S3Request
+	bucket   string
+	key      string
+	dealInfo *types.TransportDealInfo
+
+	nBytesReceived int64
+}
+
+// object is the state of the object when the transfer started
+type object struct {
+	size   int64
+	etag   string
+	sha256 string
+}
+
+func (t *transfer) emitEvent(evt types.TransportEvent) error {
+	select {
+	case t.eventCh <- evt:
+		return nil
+	default:
+		return fmt.Errorf("dropping event %+v as channel is full for deal id %s", evt, t.dealInfo.DealUuid)
+	}
+}
+
+func (t *transfer) execute(ctx context.Context) error {
+	duuid := t.dealInfo.DealUuid
+
+	var obj *object
+	err := t.withRetries(ctx, "head object", func(ctx context.Context) error {
+		var err error
+		obj, err = t.head(ctx)
+		return err
+	})
+	if err != nil {
+		t.t.dl.LogError(duuid, "terminating s3 transfer", err)
+		return err
+	}
+	if obj.size != t.dealInfo.DealSize {
+		return fmt.Errorf("object size %d does not match deal size %d", obj.size, t.dealInfo.DealSize)
+	}
+
+	// get the number of bytes already received (the size of the output file)
+	st, err := os.Stat(t.dealInfo.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat output file: %w", err)
+	}
+	t.nBytesReceived = st.Size()
+
+	if err := t.download(ctx, obj); err != nil {
+		t.t.dl.LogError(duuid, "terminating s3 transfer", err)
+		return err
+	}
+
+	if err := t.verify(obj); err != nil {
+		// Discard the data so that it's downloaded again if the transfer is
+		// retried
+		if terr := os.Truncate(t.dealInfo.OutputFile, 0); terr != nil {
+			t.t.dl.LogError(duuid, "failed to truncate output file", terr)
+		}
+		t.t.dl.LogError(duuid, "terminating s3 transfer", err)
+		return err
+	}
+
+	t.t.dl.Infow(duuid, "s3 transfer finished successfully", "nBytesReceived", t.nBytesReceived)
+	return nil
+}
+
+type part struct {
+	start int64
+	end   int64
+	done  chan partResult
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// download fetches the parts of the object from the number of bytes already
+// received, and appends them to the output file in order
+func (t *transfer) download(ctx context.Context, obj *object) error {
+	of, err := os.OpenFile(t.dealInfo.OutputFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer of.Close() //nolint:errcheck
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The part being written, plus the parts in the channel, are being
+	// fetched at a time
+	parts := make(chan *part, t.t.cfg.Concurrency-1)
+	go func() {
+		defer close(parts)
+		for start := t.nBytesReceived; start < obj.size; start += t.t.cfg.PartSize {
+			end := start + t.t.cfg.PartSize
+			if end > obj.size {
+				end = obj.size
+			}
+			p := &part{start: start, end: end, done: make(chan partResult, 1)}
+			select {
+			case parts <- p:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := t.fetchPart(ctx, obj, p.start, p.end)
+				p.done <- partResult{data: data, err: err}
+			}()
+		}
+	}()
+
+	for p := range parts {
+		var res partResult
+		select {
+		case res = <-p.done:
+		case <-ctx.Done():
+			return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+		}
+		if res.err != nil {
+			return res.err
+		}
+
+		if _, err := of.Write(res.data); err != nil {
+			return fmt.Errorf("writing output file: %w", err)
+		}
+		t.nBytesReceived = p.end
+		if err := t.emitEvent(types.TransportEvent{NBytesReceived: t.nBytesReceived}); err != nil {
+			t.t.dl.LogError(t.dealInfo.DealUuid, "failed to publish transport event", err)
+		}
+	}
+
+	if t.nBytesReceived != obj.size {
+		return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+	}
+	return nil
+}
+
+// head gets the size and checksums of the object
+func (t *transfer) head(ctx context.Context) (*object, error) {
+	req, err := t.newRequest(ctx, http.MethodHead, map[string]string{"X-Amz-Checksum-Mode": "ENABLED"})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusNotFound:
+		return nil, &errFatal{statusError(resp, t.tInfo.URL)}
+	default:
+		return nil, statusError(resp, t.tInfo.URL)
+	}
+	return &object{
+		size:   resp.ContentLength,
+		etag:   resp.Header.Get("ETag"),
+		sha256: resp.Header.Get("X-Amz-Checksum-Sha256"),
+	}, nil
+}
+
+// fetchPart gets the bytes of the object from start up to (not including)
+// end
+func (t *transfer) fetchPart(ctx context.Context, obj *object, start int64, end int64) ([]byte, error) {
+	var data []byte
+	what := fmt.Sprintf("get bytes %d-%d", start, end-1)
+	err := t.withRetries(ctx, what, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, partTimeout)
+		defer cancel()
+
+		headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", start, end-1)}
+		// Fail if the object changes during the transfer
+		if obj.etag != "" {
+			headers["If-Match"] = obj.etag
+		}
+		req, err := t.newRequest(ctx, http.MethodGet, headers)
+		if err != nil {
+			return err
+		}
+
+		resp, err := t.t.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close() //nolint:errcheck
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusPreconditionFailed:
+			return &errFatal{errors.New("the object changed during the transfer")}
+		case http.StatusForbidden, http.StatusNotFound:
+			return &errFatal{statusError(resp, t.tInfo.URL)}
+		default:
+			return statusError(resp, t.tInfo.URL)
+		}
+
+		buf := make([]byte, end-start)
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			return fmt.Errorf("reading %s: %w", what, err)
+		}
+		data = buf
+		return nil
+	})
+	return data, err
+}
+
+// withRetries calls f until it succeeds, it returns a fatal error, or the
+// maximum number of attempts is reached
+func (t *transfer) withRetries(ctx context.Context, what string, f func(context.Context) error) error {
+	b := &backoff.Backoff{
+		Min:    minBackOff,
+		Max:    maxBackOff,
+		Factor: 2,
+		Jitter: true,
+	}
+	for {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+
+		var fatal *errFatal
+		if errors.As(err, &fatal) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+		}
+
+		nAttempts := int(b.Attempt()) + 1
+		if nAttempts >= t.t.cfg.MaxAttempts {
+			return fmt.Errorf("%s: could not complete request even after %d attempts, lastErr: %w", what, nAttempts, err)
+		}
+		duration := b.Duration()
+		t.t.dl.Infow(t.dealInfo.DealUuid, "backing off before retrying s3 request", "request", what,
+			"err", err.Error(), "backoff time", duration.String(), "attempts", nAttempts)
+		bt := time.NewTimer(duration)
+		select {
+		case <-bt.C:
+		case <-ctx.Done():
+			bt.Stop()
+			return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+		}
+	}
+}
+
+// newRequest creates a signed request for the object with the given headers
+func (t *transfer) newRequest(ctx context.Context, method string, headers map[string]string) (*http.Request, error) {
+	u, err := t.t.objectURL(t.bucket, t.key)
+	if err != nil {
+		return nil, &errFatal{err}
+	}
+	creds, err := t.t.creds.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, &errFatal{err}
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	signRequest(req, creds, t.t.cfg.Region, time.Now())
+	return req, nil
+}
+
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// verify checks the downloaded data against the checksum in the transfer
+// params, or else against the checksum that S3 stores for the object
+func (t *transfer) verify(obj *object) error {
+	var h hash.Hash
+	var expected []byte
+	var source string
+	etag := strings.Trim(obj.etag, `"`)
+	switch {
+	case t.tInfo.SHA256 != "":
+		h = sha256.New()
+		expected, _ = hex.DecodeString(t.tInfo.SHA256)
+		source = "transfer params sha256"
+	case obj.sha256 != "" && !strings.Contains(obj.sha256, "-"):
+		// The checksum of an object uploaded in parts is a checksum of the
+		// checksums of the parts (eg "<checksum>-3"), which can't be checked
+		// against the whole object
+		b, err := base64.StdEncoding.DecodeString(obj.sha256)
+		if err != nil {
+			return fmt.Errorf("parsing object sha256 checksum '%s': %w", obj.sha256, err)
+		}
+		h = sha256.New()
+		expected = b
+		source = "object sha256 checksum"
+	case md5ETag.MatchString(etag):
+		// The ETag of an object that was uploaded in a single part (and
+		// not encrypted with a KMS key) is the MD5 hash of the object
+		h = md5.New() //nolint:gosec
+		expected, _ = hex.DecodeString(etag)
+		source = "object etag"
+	default:
+		t.t.dl.Infow(t.dealInfo.DealUuid, "no checksum for s3 object, skipping checksum verification")
+		return nil
+	}
+
+	f, err := os.Open(t.dealInfo.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("reading output file: %w", err)
+	}
+	if actual := h.Sum(nil); !bytes.Equal(actual, expected) {
+		return fmt.Errorf("checksum of downloaded data %x does not match %s %x", actual, source, expected)
+	}
+	t.t.dl.Infow(t.dealInfo.DealUuid, "verified checksum of downloaded data", "checksum", source)
+	return nil
+}
+
+var s3ErrorCode = regexp.MustCompile(`<Code>([^<]*)</Code>`)
+
+// statusError returns an error for an unexpected response, including the
+// S3 error code if there is one
+func statusError(resp *http.Response, s3url string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if m := s3ErrorCode.FindSubmatch(body); m != nil {
+		return fmt.Errorf("%s: status %d: %s", s3url, resp.StatusCode, m[1])
+	}
+	return fmt.Errorf("%s: status %d", s3url, resp.StatusCode)
+}
+
+// Close shuts down the transfer. It is the caller's responsibility to call
+// Close after it no longer needs the transfer.
+func (t *transfer) Close() {
+	t.closeOnce.Do(func() {
+		t.cancel()
+		t.wg.Wait()
+	})
+}
+
+func (t *transfer) Sub() chan types.TransportEvent {
+	return t.eventCh
+}
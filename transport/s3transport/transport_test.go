@@ -0,0 +1,315 @@
+package s3transport
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var testCreds = &Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}
+
+func newDealLogger(t testing.TB, ctx context.Context) *logs.DealLogger {
+	tmp, err := db.SqlDB(filepath.Join(t.TempDir(), "logs.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.CreateAllBoostTables(ctx, tmp, tmp))
+	return logs.NewDealLogger(db.NewLogsDB(tmp))
+}
+
+// fakeS3 serves an object at /bucket/key, and checks that requests are
+// signed with the test credentials
+type fakeS3 struct {
+	t      *testing.T
+	data   []byte
+	etag   string
+	sha256 string
+
+	lk       sync.Mutex
+	failGets int
+	gets     int
+}
+
+func newFakeS3(t *testing.T, size int) *fakeS3 {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	sum := md5.Sum(data) //nolint:gosec
+	return &fakeS3{t: t, data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSignature(r) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code></Error>"))
+		return
+	}
+	if r.URL.Path != "/bucket/dir/file.car" {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+		return
+	}
+
+	w.Header().Set("ETag", s.etag)
+	if r.Method == http.MethodHead {
+		if s.sha256 != "" && r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+			w.Header().Set("X-Amz-Checksum-Sha256", s.sha256)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		return
+	}
+
+	s.lk.Lock()
+	s.gets++
+	fail := s.failGets > 0
+	if fail {
+		s.failGets--
+	}
+	s.lk.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("<Error><Code>SlowDown</Code></Error>"))
+		return
+	}
+	if r.Header.Get("If-Match") != s.etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	var start, end int
+	_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+	require.NoError(s.t, err)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.data)))
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = w.Write(s.data[start : end+1])
+}
+
+func (s *fakeS3) checkSignature(r *http.Request) bool {
+	now, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	require.NoError(s.t, err)
+	for _, h := range []string{"Range", "X-Amz-Checksum-Mode"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	signRequest(req, testCreds, "us-east-1", now)
+	return req.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func newTestTransport(t *testing.T, ctx context.Context, endpoint string) *s3Transport {
+	tr := New(newDealLogger(t, ctx), Config{
+		Endpoint:    endpoint,
+		Credentials: testCreds,
+		Buckets:     []string{"bucket"},
+		PartSize:    1000,
+		Concurrency: 3,
+		MaxAttempts: 3,
+	})
+	return tr
+}
+
+func executeTransfer(t *testing.T, ctx context.Context, tr transport.Transport, req *types.S3Request, size int, of string) []types.TransportEvent {
+	bz, err := json.Marshal(req)
+	require.NoError(t, err)
+	th, err := tr.Execute(ctx, bz, &types.TransportDealInfo{OutputFile: of, DealUuid: uuid.New(), DealSize: int64(size)})
+	require.NoError(t, err)
+	defer th.Close()
+
+	var evts []types.TransportEvent
+	for evt := range th.Sub() {
+		evts = append(evts, evt)
+	}
+	return evts
+}
+
+func newOutputFile(t *testing.T, data []byte) string {
+	of := filepath.Join(t.TempDir(), "out.car")
+	require.NoError(t, os.WriteFile(of, data, 0644))
+	return of
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	size := 10500
+	s3 := newFakeS3(t, size)
+	svr := httptest.NewServer(s3)
+	defer svr.Close()
+	tr := newTestTransport(t, ctx, svr.URL)
+
+	t.Run("full object", func(t *testing.T) {
+		of := newOutputFile(t, nil)
+		evts := executeTransfer(t, ctx, tr, &types.S3Request{URL: "s3://bucket/dir/file.car"}, size, of)
+		require.NoError(t, evts[len(evts)-1].Error)
+		require.Len(t, evts, 11)
+		require.EqualValues(t, size, evts[len(evts)-1].NBytesReceived)
+
+		got, err := os.ReadFile(of)
+		require.NoError(t, err)
+		require.Equal(t, s3.data, got)
+	})
+
+	t.Run("resume from partial file", func(t *testing.T) {
+		of := newOutputFile(t, s3.data[:4000])
+		evts := executeTransfer(t, ctx, tr, &types.S3Request{URL: "s3://bucket/dir/file.car"}, size, of)
+		require.NoError(t, evts[len(evts)-1].Error)
+		require.Len(t, evts, 7)
+
+		got, err := os.ReadFile(of)
+		require.NoError(t, err)
+		require.Equal(t, s3.data, got)
+	})
+
+	t.Run("retry failed parts", func(t *testing.T) {
+		s3.lk.Lock()
+		s3.failGets = 2
+		s3.lk.Unlock()
+
+		of := newOutputFile(t, nil)
+		evts := executeTransfer(t, ctx, tr, &types.S3Request{URL: "s3://bucket/dir/file.car"}, size, of)
+		require.NoError(t, evts[len(evts)-1].Error)
+
+		got, err := os.ReadFile(of)
+		require.NoError(t, err)
+		require.Equal(t, s3.data, got)
+	})
+
+	t.Run("object not found", func(t *testing.T) {
+		of := newOutputFile(t, nil)
+		evts := executeTransfer(t, ctx, tr, &types.S3Request{URL: "s3://bucket/dir/nope.car"}, size, of)
+		require.Len(t, evts, 1)
+		require.ErrorContains(t, evts[0].Error, "status 404")
+	})
+
+	t.Run("bucket not allowed", func(t *testing.T) {
+		bz, err := json.Marshal(&types.S3Request{URL: "s3://other/dir/file.car"})
+		require.NoError(t, err)
+		_, err = tr.Execute(ctx, bz, &types.TransportDealInfo{OutputFile: newOutputFile(t, nil), DealUuid: uuid.New(), DealSize: int64(size)})
+		require.ErrorContains(t, err, "not allowed")
+	})
+}
+
+func TestTransferChecksum(t *testing.T) {
+	ctx := context.Background()
+	size := 2500
+	s3 := newFakeS3(t, size)
+	svr := httptest.NewServer(s3)
+	defer svr.Close()
+	tr := newTestTransport(t, ctx, svr.URL)
+	sum := sha256.Sum256(s3.data)
+
+	t.Run("transfer params sha256", func(t *testing.T) {
+		of := newOutputFile(t, nil)
+		req := &types.S3Request{URL: "s3://bucket/dir/file.car", SHA256: hex.EncodeToString(sum[:])}
+		evts := executeTransfer(t, ctx, tr, req, size, of)
+		require.NoError(t, evts[len(evts)-1].Error)
+	})
+
+	t.Run("transfer params sha256 mismatch", func(t *testing.T) {
+		of := newOutputFile(t, nil)
+		other := sha256.Sum256([]byte("other"))
+		req := &types.S3Request{URL: "s3://bucket/dir/file.car", SHA256: hex.EncodeToString(other[:])}
+		evts := executeTransfer(t, ctx, tr, req, size, of)
+		require.ErrorContains(t, evts[len(evts)-1].Error, "does not match transfer params sha256")
+
+		// The data should be discarded so that it's downloaded again
+		st, err := os.Stat(of)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, st.Size())
+	})
+
+	t.Run("object sha256 mismatch", func(t *testing.T) {
+		other := sha256.Sum256([]byte("other"))
+		s3.sha256 = base64.StdEncoding.EncodeToString(other[:])
+		defer func() { s3.sha256 = "" }()
+
+		of := newOutputFile(t, nil)
+		evts := executeTransfer(t, ctx, tr, &types.S3Request{URL: "s3://bucket/dir/file.car"}, size, of)
+		require.ErrorContains(t, evts[len(evts)-1].Error, "does not match object sha256 checksum")
+	})
+
+	t.Run("object etag mismatch", func(t *testing.T) {
+		etag := s3.etag
+		s3.etag = `"` + strings.Repeat("0", 32) + `"`
+		defer func() { s3.etag = etag }()
+
+		of := newOutputFile(t, nil)
+		evts := executeTransfer(t, ctx, tr, &types.S3Request{URL: "s3://bucket/dir/file.car"}, size, of)
+		require.ErrorContains(t, evts[len(evts)-1].Error, "does not match object etag")
+	})
+}
+
+func TestParseURL(t *testing.T) {
+	bucket, key, err := ParseURL("s3://my-bucket/path/to/file.car")
+	require.NoError(t, err)
+	require.Equal(t, "my-bucket", bucket)
+	require.Equal(t, "path/to/file.car", key)
+
+	for _, u := range []string{"https://my-bucket/file.car", "s3://my-bucket", "s3:///file.car"} {
+		_, _, err := ParseURL(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestInstanceRoleCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+
+	var fetches int
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("token"))
+		case r.Header.Get("X-Aws-Ec2-Metadata-Token") != "token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("boost-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/boost-role":
+			fetches++
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"AccessKeyId":     "ROLEKEY",
+				"SecretAccessKey": "ROLESECRET",
+				"Token":           "ROLETOKEN",
+				"Expiration":      time.Now().Add(time.Hour),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer imds.Close()
+
+	p := newCredentialsProvider(nil)
+	p.imdsEndpoint = imds.URL
+	creds, err := p.get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "ROLEKEY", creds.AccessKeyID)
+	require.Equal(t, "ROLETOKEN", creds.SessionToken)
+
+	// The credentials should be cached until they are about to expire
+	_, err = p.get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, fetches)
+}
@@ -0,0 +1,136 @@
+package tcptransport
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-graphsync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/go-graphsync/storeutil"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// The amount of data transferred by each iteration of the benchmarks
+const benchmarkDataSize = 64 * 1024 * 1024
+
+// BenchmarkTcpTransfer measures the throughput of a transfer over a raw TLS
+// connection on the loopback interface
+func BenchmarkTcpTransfer(b *testing.B) {
+	ctx := context.Background()
+	srv, path, _ := newTestServer(b, benchmarkDataSize)
+	tr := New(newDealLogger(b, ctx))
+
+	b.SetBytes(benchmarkDataSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		req, err := srv.Add("deal", path)
+		require.NoError(b, err)
+		of := emptyFile(b)
+		b.StartTimer()
+
+		th := executeTransfer(b, ctx, tr, req, benchmarkDataSize, of)
+		evts := waitForTransfer(th)
+		th.Close()
+
+		b.StopTimer()
+		require.NoError(b, evts[len(evts)-1].Error)
+		srv.Remove("deal")
+		b.StartTimer()
+	}
+}
+
+// BenchmarkGraphsyncTransfer measures the throughput of a graphsync transfer
+// of the same amount of data. The libp2p hosts are connected by an in-memory
+// mock network, so the result is an upper bound for graphsync over a real
+// connection.
+func BenchmarkGraphsyncTransfer(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New()
+	defer mn.Close() //nolint:errcheck
+	newHost := func() host.Host {
+		h, err := mn.GenPeer()
+		require.NoError(b, err)
+		return h
+	}
+	newBlockstore := func() bstore.Blockstore {
+		return bstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	}
+
+	// The client serves a DAG with a root that links to blocks of random data
+	clientHost := newHost()
+	clientBs := newBlockstore()
+	root := createBenchmarkDAG(b, storeutil.LinkSystemForBlockstore(clientBs))
+	clientGs := gsimpl.New(ctx, gsnet.NewFromLibp2pHost(clientHost), storeutil.LinkSystemForBlockstore(clientBs))
+	clientGs.RegisterIncomingRequestHook(func(p peer.ID, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		hookActions.ValidateRequest()
+	})
+
+	b.SetBytes(benchmarkDataSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A new provider fetches the DAG into an empty blockstore
+		b.StopTimer()
+		providerHost := newHost()
+		require.NoError(b, mn.LinkAll())
+		_, err := mn.ConnectPeers(providerHost.ID(), clientHost.ID())
+		require.NoError(b, err)
+		providerBs := newBlockstore()
+		providerGs := gsimpl.New(ctx, gsnet.NewFromLibp2pHost(providerHost), storeutil.LinkSystemForBlockstore(providerBs))
+		b.StartTimer()
+
+		progress, errs := providerGs.Request(ctx, clientHost.ID(), root, selectorparse.CommonSelector_ExploreAllRecursively)
+		for range progress {
+		}
+		for err := range errs {
+			require.NoError(b, err)
+		}
+	}
+}
+
+// createBenchmarkDAG stores blocks of random data, and a dag-cbor root node
+// that links to all of them, and returns a link to the root
+func createBenchmarkDAG(b *testing.B, lsys ipld.LinkSystem) ipld.Link {
+	const blockSize = 1024 * 1024
+	rawPrefix := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}
+	var links []ipld.Link
+	for n := 0; n < benchmarkDataSize; n += blockSize {
+		data := make([]byte, blockSize)
+		_, err := rand.Read(data)
+		require.NoError(b, err)
+		lnk, err := lsys.Store(ipld.LinkContext{}, cidlink.LinkPrototype{Prefix: rawPrefix}, basicnode.NewBytes(data))
+		require.NoError(b, err)
+		links = append(links, lnk)
+	}
+
+	nd, err := qp.BuildList(basicnode.Prototype.Any, int64(len(links)), func(la datamodel.ListAssembler) {
+		for _, lnk := range links {
+			qp.ListEntry(la, qp.Link(lnk))
+		}
+	})
+	require.NoError(b, err)
+	cborPrefix := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}
+	root, err := lsys.Store(ipld.LinkContext{}, cidlink.LinkPrototype{Prefix: cborPrefix}, nd)
+	require.NoError(b, err)
+	return root
+}
@@ -0,0 +1,87 @@
+// Package tcptransport transfers deal data from the client to the provider
+// over a raw TLS connection (transfer type "tcp"), for environments where
+// libp2p throughput is the bottleneck.
+//
+// The client serves the data with a Server, and puts the server's address, a
+// token for the data and the hash of the server's self-signed TLS certificate
+// in the deal's transfer params. The provider connects to the server, checks
+// the certificate against the hash, and sends a request frame with the token
+// and the offset to resume the transfer from. The server replies with a
+// response frame, followed by the data in frames of up to maxDataFrameSize
+// bytes and an empty frame at the end of the data.
+package tcptransport
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ProtocolID is negotiated (with ALPN) during the TLS handshake
+const ProtocolID = "boost-tcp-transfer/1"
+
+const (
+	// The maximum size of a request or response frame
+	maxControlFrameSize = 64 * 1024
+	// The maximum size of a data frame
+	maxDataFrameSize = 1024 * 1024
+)
+
+// request is sent by the provider to ask for the data
+type request struct {
+	Token  string
+	Offset int64
+}
+
+// response is sent by the client before the data. If Error is set, the
+// request was rejected and no data follows.
+type response struct {
+	Size  int64
+	Error string `json:",omitempty"`
+}
+
+// writeFrame writes a frame: the length of the payload as a big-endian
+// uint32, followed by the payload
+func writeFrame(w io.Writer, payload []byte) error {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrameHeader reads the length of the payload of the next frame
+func readFrameHeader(r io.Reader, max uint32) (uint32, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > max {
+		return 0, fmt.Errorf("frame size %d exceeds maximum of %d", n, max)
+	}
+	return n, nil
+}
+
+func writeMsg(w io.Writer, msg interface{}) error {
+	bz, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, bz)
+}
+
+func readMsg(r io.Reader, msg interface{}) error {
+	n, err := readFrameHeader(r, maxControlFrameSize)
+	if err != nil {
+		return err
+	}
+	bz := make([]byte, n)
+	if _, err := io.ReadFull(r, bz); err != nil {
+		return err
+	}
+	return json.Unmarshal(bz, msg)
+}
@@ -0,0 +1,302 @@
+package tcptransport
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/transport/types"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("tcptransport")
+
+// The time allowed for the provider to complete the TLS handshake and send
+// its request
+const requestTimeout = 30 * time.Second
+
+type file struct {
+	path  string
+	token string
+	size  int64
+	// The size of the data frames the file is served in
+	frameSize int
+	// The number of bytes from the start of the file that have been served
+	served int64
+	done   chan struct{}
+}
+
+// Server serves files to providers over raw TLS connections
+type Server struct {
+	addr     string
+	cert     tls.Certificate
+	certHash string
+
+	lk     sync.Mutex
+	files  map[string]*file
+	tokens map[string]string
+}
+
+// NewServer creates a server with a new self-signed TLS certificate. The
+// address is the host:port at which providers can reach the server.
+func NewServer(addr string) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, fmt.Errorf("generating TLS certificate: %w", err)
+	}
+	hash := sha256.Sum256(cert.Certificate[0])
+	return &Server{
+		addr:     addr,
+		cert:     cert,
+		certHash: hex.EncodeToString(hash[:]),
+		files:    make(map[string]*file),
+		tokens:   make(map[string]string),
+	}, nil
+}
+
+// FileOption configures how a file is served
+type FileOption func(*file)
+
+// FrameSize serves the file in data frames of the given size. Sizes above
+// the largest frame that providers accept (1MiB) are reduced to it.
+func FrameSize(size int) FileOption {
+	return func(f *file) {
+		if size > 0 && size < maxDataFrameSize {
+			f.frameSize = size
+		}
+	}
+}
+
+// Add serves the file at path under the id (eg the deal uuid), and returns
+// the transfer parameters for a provider to download it
+func (s *Server) Add(id string, path string, opts ...FileOption) (*types.TcpRequest, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("getting size of file %s: %w", path, err)
+	}
+	tok := make([]byte, 32)
+	if _, err := rand.Read(tok); err != nil {
+		return nil, fmt.Errorf("generating token: %w", err)
+	}
+	f := &file{
+		path:      path,
+		token:     hex.EncodeToString(tok),
+		size:      st.Size(),
+		frameSize: maxDataFrameSize,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, ok := s.files[id]; ok {
+		return nil, fmt.Errorf("a file is already served with id %s", id)
+	}
+	s.files[id] = f
+	s.tokens[f.token] = id
+	return &types.TcpRequest{
+		Addr:       s.addr,
+		Token:      f.token,
+		CertSHA256: s.certHash,
+	}, nil
+}
+
+// Remove stops serving the file with the id
+func (s *Server) Remove(id string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if f, ok := s.files[id]; ok {
+		delete(s.tokens, f.token)
+		delete(s.files, id)
+	}
+}
+
+// Done returns a channel that is closed once the file with the id has been
+// served through to the end, ie the provider has downloaded all of it. It
+// returns nil if there is no file with the id.
+func (s *Server) Done(id string) <-chan struct{} {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return nil
+	}
+	return f.done
+}
+
+// Served returns the number of bytes of the file with the id that have been
+// served, and its size
+func (s *Server) Served(id string) (int64, int64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return 0, 0
+	}
+	return f.served, f.size
+}
+
+// Serve accepts connections on the listener until it is closed
+func (s *Server) Serve(ln net.Listener) error {
+	tlsLn := tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{s.cert},
+		NextProtos:   []string{ProtocolID},
+		MinVersion:   tls.VersionTLS13,
+	})
+	for {
+		conn, err := tlsLn.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn.(*tls.Conn))
+	}
+}
+
+func (s *Server) handle(conn *tls.Conn) {
+	defer conn.Close() //nolint:errcheck
+
+	remote := conn.RemoteAddr().String()
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	if err := conn.Handshake(); err != nil {
+		log.Debugw("TLS handshake failed", "remote", remote, "err", err)
+		return
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != ProtocolID {
+		log.Debugw("unsupported protocol", "remote", remote, "protocol", p)
+		return
+	}
+	var req request
+	if err := readMsg(conn, &req); err != nil {
+		log.Debugw("reading request", "remote", remote, "err", err)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	s.lk.Lock()
+	id, ok := s.tokens[req.Token]
+	f := s.files[id]
+	s.lk.Unlock()
+
+	reject := func(msg string) {
+		log.Infow("rejected transfer request", "remote", remote, "reason", msg)
+		_ = writeMsg(conn, &response{Error: msg})
+	}
+	if !ok {
+		reject("unknown token")
+		return
+	}
+	if req.Offset < 0 || req.Offset > f.size {
+		reject(fmt.Sprintf("offset %d is outside file of size %d", req.Offset, f.size))
+		return
+	}
+
+	fd, err := os.Open(f.path)
+	if err != nil {
+		log.Errorw("opening file", "id", id, "path", f.path, "err", err)
+		reject("failed to open file")
+		return
+	}
+	defer fd.Close() //nolint:errcheck
+	if _, err := fd.Seek(req.Offset, io.SeekStart); err != nil {
+		log.Errorw("seeking in file", "id", id, "path", f.path, "err", err)
+		reject("failed to read file")
+		return
+	}
+
+	log.Debugw("serving file", "id", id, "offset", req.Offset, "remote", remote)
+	w := bufio.NewWriterSize(conn, 4+maxDataFrameSize)
+	if err := writeMsg(w, &response{Size: f.size}); err != nil {
+		return
+	}
+	buf := make([]byte, f.frameSize)
+	offset := req.Offset
+	for offset < f.size {
+		n, err := io.ReadFull(fd, buf[:min(int64(len(buf)), f.size-offset)])
+		if err != nil {
+			log.Errorw("reading file", "id", id, "path", f.path, "err", err)
+			return
+		}
+		if err := writeFrame(w, buf[:n]); err != nil {
+			log.Debugw("writing data", "id", id, "remote", remote, "err", err)
+			return
+		}
+		offset += int64(n)
+		s.served(id, f, offset-int64(n), offset)
+	}
+	if err := writeFrame(w, nil); err != nil {
+		return
+	}
+	if err := w.Flush(); err != nil {
+		log.Debugw("writing data", "id", id, "remote", remote, "err", err)
+	}
+}
+
+// served records that the bytes from start to end have been written to the
+// connection. Only bytes that follow on from the bytes already served are
+// counted, eg when a download resumes from where it failed.
+func (s *Server) served(id string, f *file, start, end int64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if start <= f.served && end > f.served {
+		f.served = end
+		if f.served == f.size {
+			log.Infow("file has been downloaded", "id", id, "size", f.size)
+			close(f.done)
+		}
+	}
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "boost-tcp-transfer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,342 @@
+package tcptransport
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/jpillora/backoff"
+)
+
+const (
+	// 5s, 7s, 11s, 16s, 25s, 38s, 1m, 1m30s, 2m, 3m, 7m, 10m, 10m, 10m, 10m
+	minBackOff           = 5 * time.Second
+	maxBackOff           = 10 * time.Minute
+	factor               = 1.5
+	maxReconnectAttempts = 15
+
+	// Restart the transfer if no data is received for this long
+	defaultStallTimeout = time.Minute
+)
+
+// errRejected is returned when the server rejects the request, in which
+// case the request is not retried
+type errRejected struct {
+	msg string
+}
+
+func (e *errRejected) Error() string {
+	return "transfer request rejected by client: " + e.msg
+}
+
+var _ transport.Transport = (*tcpTransport)(nil)
+
+type Option func(*tcpTransport)
+
+func BackOffRetryOpt(minBackoff, maxBackoff time.Duration, factor, maxReconnectAttempts float64) Option {
+	return func(t *tcpTransport) {
+		t.minBackOffWait = minBackoff
+		t.maxBackoffWait = maxBackoff
+		t.backOffFactor = factor
+		t.maxReconnectAttempts = maxReconnectAttempts
+	}
+}
+
+// StallTimeoutOpt restarts a transfer if no data is received for the timeout
+func StallTimeoutOpt(timeout time.Duration) Option {
+	return func(t *tcpTransport) {
+		t.stallTimeout = timeout
+	}
+}
+
+type tcpTransport struct {
+	minBackOffWait       time.Duration
+	maxBackoffWait       time.Duration
+	backOffFactor        float64
+	maxReconnectAttempts float64
+	stallTimeout         time.Duration
+
+	dl *logs.DealLogger
+}
+
+func New(dealLogger *logs.DealLogger, opts ...Option) *tcpTransport {
+	t := &tcpTransport{
+		minBackOffWait:       minBackOff,
+		maxBackoffWait:       maxBackOff,
+		backOffFactor:        factor,
+		maxReconnectAttempts: maxReconnectAttempts,
+		stallTimeout:         defaultStallTimeout,
+		dl:                   dealLogger.Subsystem("tcp-transport"),
+	}
+	for _, o := range opts {
+		o(t)
+	}
+	return t
+}
+
+func (t *tcpTransport) Execute(ctx context.Context, transportInfo []byte, dealInfo *types.TransportDealInfo) (transport.Handler, error) {
+	duuid := dealInfo.DealUuid
+	t.dl.Infow(duuid, "execute tcp transfer", "deal size", dealInfo.DealSize, "output file", dealInfo.OutputFile)
+
+	// de-serialize transport opaque token
+	tInfo := &types.TcpRequest{}
+	if err := json.Unmarshal(transportInfo, tInfo); err != nil {
+		return nil, fmt.Errorf("failed to de-serialize transport info bytes, bytes:%s, err:%w", string(transportInfo), err)
+	}
+	if tInfo.Addr == "" {
+		return nil, errors.New("deal transfer address is empty")
+	}
+	certHash, err := hex.DecodeString(tInfo.CertSHA256)
+	if err != nil || len(certHash) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate hash '%s'", tInfo.CertSHA256)
+	}
+
+	// check that the outputFile exists
+	fi, err := os.Stat(dealInfo.OutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("output file state error: %w", err)
+	}
+	fileSize := fi.Size()
+	if fileSize > dealInfo.DealSize {
+		return nil, fmt.Errorf("deal size=%d but file size=%d", dealInfo.DealSize, fileSize)
+	}
+
+	tctx, cancel := context.WithCancel(ctx)
+	tr := &transfer{
+		cancel:   cancel,
+		tInfo:    tInfo,
+		certHash: certHash,
+		dealInfo: dealInfo,
+		eventCh:  make(chan types.TransportEvent, 256),
+		backoff: &backoff.Backoff{
+			Min:    t.minBackOffWait,
+			Max:    t.maxBackoffWait,
+			Factor: t.backOffFactor,
+			Jitter: true,
+		},
+		maxReconnectAttempts: t.maxReconnectAttempts,
+		stallTimeout:         t.stallTimeout,
+		dl:                   t.dl,
+	}
+
+	// is the transfer already complete ?
+	if fileSize == dealInfo.DealSize {
+		defer cancel()
+		defer close(tr.eventCh)
+		if err := tr.emitEvent(types.TransportEvent{NBytesReceived: fileSize}); err != nil {
+			return nil, fmt.Errorf("failed to publish transfer completion event, id: %s, err: %w", duuid, err)
+		}
+		t.dl.Infow(duuid, "file size is already equal to deal size, returning")
+		return tr, nil
+	}
+
+	tr.wg.Add(1)
+	go func() {
+		defer tr.wg.Done()
+		defer cancel()
+		defer close(tr.eventCh)
+
+		if err := tr.execute(tctx); err != nil {
+			if err := tr.emitEvent(types.TransportEvent{Error: err}); err != nil {
+				t.dl.LogError(duuid, "failed to publish transport error", err)
+			}
+		}
+	}()
+
+	t.dl.Infow(duuid, "started async tcp transfer", "addr", tInfo.Addr)
+	return tr, nil
+}
+
+type transfer struct {
+	closeOnce sync.Once
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	eventCh chan types.TransportEvent
+
+	tInfo    *types.TcpRequest
+	certHash []byte
+	dealInfo *types.TransportDealInfo
+
+	nBytesReceived int64
+
+	backoff              *backoff.Backoff
+	maxReconnectAttempts float64
+	stallTimeout         time.Duration
+
+	dl *logs.DealLogger
+}
+
+func (t *transfer) emitEvent(evt types.TransportEvent) error {
+	select {
+	case t.eventCh <- evt:
+		return nil
+	default:
+		return fmt.Errorf("dropping event %+v as channel is full for deal id %s", evt, t.dealInfo.DealUuid)
+	}
+}
+
+func (t *transfer) execute(ctx context.Context) error {
+	duuid := t.dealInfo.DealUuid
+	for {
+		// get the number of bytes already received (the size of the output file)
+		st, err := os.Stat(t.dealInfo.OutputFile)
+		if err != nil {
+			return fmt.Errorf("failed to stat output file: %w", err)
+		}
+		t.nBytesReceived = st.Size()
+
+		err = t.download(ctx)
+		if err == nil {
+			break
+		}
+
+		var rejected *errRejected
+		if errors.As(err, &rejected) {
+			t.dl.LogError(duuid, "terminating tcp transfer", err)
+			return err
+		}
+		if ctx.Err() != nil {
+			t.dl.LogError(duuid, "terminating tcp transfer: context cancelled or deadline exceeded", err)
+			return fmt.Errorf("transfer context canceled err: %w", ctx.Err())
+		}
+
+		// If some data was transferred, reset the back-off count to zero
+		if t.nBytesReceived > st.Size() {
+			t.backoff.Reset()
+		}
+		nAttempts := t.backoff.Attempt() + 1
+		if nAttempts >= t.maxReconnectAttempts {
+			t.dl.Errorw(duuid, "terminating tcp transfer: exhausted max attempts", "err", err.Error(), "maxAttempts", t.maxReconnectAttempts)
+			return fmt.Errorf("could not finish transfer even after %.0f attempts, lastErr: %w", t.maxReconnectAttempts, err)
+		}
+		duration := t.backoff.Duration()
+		t.dl.Infow(duuid, "backing off before retrying tcp transfer", "err", err.Error(), "backoff time", duration.String(),
+			"attempts", nAttempts)
+		bt := time.NewTimer(duration)
+		select {
+		case <-bt.C:
+		case <-ctx.Done():
+			bt.Stop()
+			return fmt.Errorf("transfer canceled after %.0f attempts to finish transfer, lastErr=%s, contextErr=%w", t.backoff.Attempt(), err, ctx.Err())
+		}
+	}
+
+	if t.nBytesReceived != t.dealInfo.DealSize {
+		return fmt.Errorf("mismatch in dealSize vs received bytes, dealSize=%d, received=%d", t.dealInfo.DealSize, t.nBytesReceived)
+	}
+	t.dl.Infow(duuid, "tcp transfer finished successfully", "nBytesReceived", t.nBytesReceived)
+	return nil
+}
+
+// download connects to the client and appends the data it sends to the
+// output file, from the number of bytes already received
+func (t *transfer) download(ctx context.Context) error {
+	d := &tls.Dialer{Config: &tls.Config{
+		NextProtos: []string{ProtocolID},
+		MinVersion: tls.VersionTLS13,
+		// The client's certificate is self-signed: it is verified against
+		// the hash in the transfer params instead of a CA
+		InsecureSkipVerify: true, //nolint:gosec
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate")
+			}
+			hash := sha256.Sum256(rawCerts[0])
+			if subtle.ConstantTimeCompare(hash[:], t.certHash) != 1 {
+				return errors.New("certificate does not match the hash in the transfer params")
+			}
+			return nil
+		},
+	}}
+	conn, err := d.DialContext(ctx, "tcp", t.tInfo.Addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", t.tInfo.Addr, err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	// Close the connection when the context is cancelled, to interrupt reads
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if p := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; p != ProtocolID {
+		return &errRejected{msg: fmt.Sprintf("unsupported protocol '%s'", p)}
+	}
+	if err := writeMsg(conn, &request{Token: t.tInfo.Token, Offset: t.nBytesReceived}); err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+
+	r := bufio.NewReaderSize(conn, 4+maxDataFrameSize)
+	_ = conn.SetReadDeadline(time.Now().Add(t.stallTimeout))
+	var resp response
+	if err := readMsg(r, &resp); err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.Error != "" {
+		return &errRejected{msg: resp.Error}
+	}
+	if resp.Size != t.dealInfo.DealSize {
+		return &errRejected{msg: fmt.Sprintf("data size %d does not match deal size %d", resp.Size, t.dealInfo.DealSize)}
+	}
+
+	of, err := os.OpenFile(t.dealInfo.OutputFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer of.Close() //nolint:errcheck
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(t.stallTimeout))
+		n, err := readFrameHeader(r, maxDataFrameSize)
+		if err != nil {
+			return fmt.Errorf("reading frame: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+		if t.nBytesReceived+int64(n) > t.dealInfo.DealSize {
+			return &errRejected{msg: fmt.Sprintf("received more than deal size %d", t.dealInfo.DealSize)}
+		}
+		if _, err := io.CopyN(of, r, int64(n)); err != nil {
+			return fmt.Errorf("receiving data: %w", err)
+		}
+		t.nBytesReceived += int64(n)
+		if err := t.emitEvent(types.TransportEvent{NBytesReceived: t.nBytesReceived}); err != nil {
+			t.dl.LogError(t.dealInfo.DealUuid, "failed to publish transport event", err)
+		}
+	}
+}
+
+// Close shuts down the transfer. It is the caller's responsibility to call
+// Close after it no longer needs the transfer.
+func (t *transfer) Close() {
+	t.closeOnce.Do(func() {
+		t.cancel()
+		t.wg.Wait()
+	})
+}
+
+func (t *transfer) Sub() chan types.TransportEvent {
+	return t.eventCh
+}
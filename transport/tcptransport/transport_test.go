@@ -0,0 +1,216 @@
+package tcptransport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/boost/storagemarket/logs"
+	"github.com/filecoin-project/boost/transport"
+	"github.com/filecoin-project/boost/transport/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newDealLogger(t testing.TB, ctx context.Context) *logs.DealLogger {
+	tmp, err := db.SqlDB(filepath.Join(t.TempDir(), "logs.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.CreateAllBoostTables(ctx, tmp, tmp))
+	return logs.NewDealLogger(db.NewLogsDB(tmp))
+}
+
+// newTestServer serves a file with random data of the given size
+func newTestServer(t testing.TB, size int) (*Server, string, []byte) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data.car")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	srv, err := NewServer(ln.Addr().String())
+	require.NoError(t, err)
+	go srv.Serve(ln) //nolint:errcheck
+	return srv, path, data
+}
+
+func executeTransfer(t testing.TB, ctx context.Context, tr transport.Transport, req *types.TcpRequest, size int, of string) transport.Handler {
+	bz, err := json.Marshal(req)
+	require.NoError(t, err)
+	th, err := tr.Execute(ctx, bz, &types.TransportDealInfo{OutputFile: of, DealUuid: uuid.New(), DealSize: int64(size)})
+	require.NoError(t, err)
+	return th
+}
+
+func waitForTransfer(th transport.Handler) []types.TransportEvent {
+	var evts []types.TransportEvent
+	for evt := range th.Sub() {
+		evts = append(evts, evt)
+	}
+	return evts
+}
+
+func emptyFile(t testing.TB) string {
+	of := filepath.Join(t.TempDir(), "out.car")
+	require.NoError(t, os.WriteFile(of, nil, 0644))
+	return of
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	size := 5*maxDataFrameSize + 30
+	srv, path, data := newTestServer(t, size)
+
+	req, err := srv.Add("deal", path)
+	require.NoError(t, err)
+
+	of := emptyFile(t)
+	th := executeTransfer(t, ctx, New(newDealLogger(t, ctx)), req, size, of)
+	defer th.Close()
+
+	evts := waitForTransfer(th)
+	require.NotEmpty(t, evts)
+	last := evts[len(evts)-1]
+	require.NoError(t, last.Error)
+	require.EqualValues(t, size, last.NBytesReceived)
+
+	got, err := os.ReadFile(of)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	select {
+	case <-srv.Done("deal"):
+	case <-time.After(time.Second):
+		require.Fail(t, "server did not record the transfer as done")
+	}
+	served, total := srv.Served("deal")
+	require.EqualValues(t, size, served)
+	require.EqualValues(t, size, total)
+}
+
+func TestTransferFrameSize(t *testing.T) {
+	ctx := context.Background()
+	size := 5*1000 + 30
+	srv, path, data := newTestServer(t, size)
+
+	req, err := srv.Add("deal", path, FrameSize(1000))
+	require.NoError(t, err)
+
+	of := emptyFile(t)
+	th := executeTransfer(t, ctx, New(newDealLogger(t, ctx)), req, size, of)
+	defer th.Close()
+
+	evts := waitForTransfer(th)
+	require.NotEmpty(t, evts)
+	require.NoError(t, evts[len(evts)-1].Error)
+	got, err := os.ReadFile(of)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestTransferResumption(t *testing.T) {
+	ctx := context.Background()
+	size := 3*maxDataFrameSize + 30
+	srv, path, data := newTestServer(t, size)
+
+	req, err := srv.Add("deal", path)
+	require.NoError(t, err)
+
+	// The provider already has part of the data
+	of := filepath.Join(t.TempDir(), "out.car")
+	require.NoError(t, os.WriteFile(of, data[:size/2], 0644))
+
+	th := executeTransfer(t, ctx, New(newDealLogger(t, ctx)), req, size, of)
+	defer th.Close()
+
+	evts := waitForTransfer(th)
+	require.NotEmpty(t, evts)
+	require.NoError(t, evts[len(evts)-1].Error)
+	got, err := os.ReadFile(of)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestTransferRejected(t *testing.T) {
+	ctx := context.Background()
+	size := 1024
+	srv, path, _ := newTestServer(t, size)
+
+	req, err := srv.Add("deal", path)
+	require.NoError(t, err)
+
+	tr := New(newDealLogger(t, ctx), BackOffRetryOpt(time.Millisecond, time.Millisecond, 1, 2))
+	for name, mod := range map[string]func(r types.TcpRequest) types.TcpRequest{
+		"unknown token": func(r types.TcpRequest) types.TcpRequest {
+			r.Token = "unknown"
+			return r
+		},
+		"wrong certificate": func(r types.TcpRequest) types.TcpRequest {
+			r.CertSHA256 = "0000000000000000000000000000000000000000000000000000000000000000"
+			return r
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := mod(*req)
+			th := executeTransfer(t, ctx, tr, &r, size, emptyFile(t))
+			defer th.Close()
+
+			evts := waitForTransfer(th)
+			require.NotEmpty(t, evts)
+			require.Error(t, evts[len(evts)-1].Error)
+		})
+	}
+
+	// A file that has been removed is no longer served
+	srv.Remove("deal")
+	th := executeTransfer(t, ctx, tr, req, size, emptyFile(t))
+	defer th.Close()
+	evts := waitForTransfer(th)
+	require.NotEmpty(t, evts)
+	require.ErrorContains(t, evts[len(evts)-1].Error, "unknown token")
+}
+
+func TestTransferCancellation(t *testing.T) {
+	ctx := context.Background()
+
+	// A server that accepts connections but never responds
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() //nolint:errcheck
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() //nolint:errcheck
+		}
+	}()
+
+	req := &types.TcpRequest{
+		Addr:       ln.Addr().String(),
+		Token:      "token",
+		CertSHA256: "0000000000000000000000000000000000000000000000000000000000000000",
+	}
+	th := executeTransfer(t, ctx, New(newDealLogger(t, ctx)), req, 1024, emptyFile(t))
+
+	done := make(chan struct{})
+	go func() {
+		waitForTransfer(th)
+		close(done)
+	}()
+	th.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "transfer was not cancelled")
+	}
+}
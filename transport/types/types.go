@@ -1,12 +1,18 @@
 package types
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 )
 
 const DataTransferProtocol = "/fil/storage/transfer/1.0.0"
 
+// TransferRestartProtocol is used by the provider to ask the client to
+// restart a transfer that the client is serving over libp2p
+const TransferRestartProtocol = "/fil/storage/transfer/restart/1.0.0"
+
 // HttpRequest has parameters for an HTTP transfer
 type HttpRequest struct {
 	// URL can be
@@ -19,6 +25,88 @@ type HttpRequest struct {
 	// Headers are the HTTP headers that are sent as part of the request,
 	// eg "Authorization"
 	Headers map[string]string
+	// Mirrors are other http origins that serve the same data as URL (eg
+	// copies of the data in other regions). The provider pulls from the
+	// origin that is healthy and has the lowest latency from the provider,
+	// and fails over to the other origins if a transfer fails.
+	Mirrors []HttpMirror `json:",omitempty"`
+	// MirrorsInOrder is set if the client asked for the mirrors to be tried
+	// in the order given (after URL), rather than ranked by their latency
+	// from the provider
+	MirrorsInOrder bool `json:",omitempty"`
+	// Retry is the client's policy for retrying the transfer. If nil, the
+	// provider's policy is used.
+	Retry *RetryPolicy `json:",omitempty"`
+}
+
+// RetryPolicy overrides the provider's policy for retrying a transfer.
+// Zero means use the provider's default.
+type RetryPolicy struct {
+	MaxAttempts int           `json:",omitempty"`
+	MinBackoff  time.Duration `json:",omitempty"`
+	MaxBackoff  time.Duration `json:",omitempty"`
+}
+
+// TcpRequest has parameters for a transfer over a raw TLS connection to the
+// client (transfer type "tcp"), for environments where libp2p throughput is
+// the bottleneck
+type TcpRequest struct {
+	// Addr is the host:port at which the client serves the data
+	Addr string
+	// Token identifies the data and authorizes the provider to download it
+	Token string
+	// CertSHA256 is the hex-encoded SHA-256 hash of the client's (self-signed)
+	// TLS certificate, that the provider checks when it connects
+	CertSHA256 string
+}
+
+// S3Request has parameters for a transfer of deal data that the provider
+// pulls from an S3-compatible object store (transfer type "s3"), with the
+// credentials that the provider is configured with
+type S3Request struct {
+	// URL is the location of the object, eg "s3://bucket/path/to/file.car"
+	URL string
+	// SHA256 is the optional hex-encoded SHA-256 hash of the object, that
+	// the provider checks once the download is complete. If empty, the
+	// provider checks the object against the checksum that S3 stores for
+	// it, if there is one.
+	SHA256 string `json:",omitempty"`
+}
+
+// HttpMirror is an alternative http origin for the deal data
+type HttpMirror struct {
+	// URL must be an http or https URL
+	URL string
+	// Headers are sent when requesting data from the mirror. If nil, the
+	// headers of the request are used.
+	Headers map[string]string `json:",omitempty"`
+	// Region is an optional label for the location of the mirror, eg
+	// "us-east-1"
+	Region string `json:",omitempty"`
+	// Priority is a hint from the client: when the provider measures a
+	// similar latency to several origins, origins with a lower priority
+	// are preferred
+	Priority int `json:",omitempty"`
+}
+
+// TransferRestartRequest is sent by the provider to ask the client to
+// restart a transfer from the given offset, eg because the provider restarted
+// or lost some of the data it had already received
+type TransferRestartRequest struct {
+	// AuthToken is the token that the provider uses to request the data. It
+	// identifies the transfer.
+	AuthToken string
+	// Offset is the byte offset the provider will resume the transfer from
+	Offset uint64
+	// Reason is a human-readable explanation of why the restart is needed
+	Reason string
+}
+
+// TransferRestartResponse is the client's response to a TransferRestartRequest
+type TransferRestartResponse struct {
+	Accepted bool
+	// Message explains why the request was rejected
+	Message string
 }
 
 // TransportDealInfo has parameters for a transfer to be executed
@@ -32,6 +120,16 @@ type TransportDealInfo struct {
 type TransportEvent struct {
 	NBytesReceived int64
 	Error          error
+	// Origin identifies the http origin that the data is being transferred
+	// from (it changes if the transfer fails over to another origin)
+	Origin string
+	// Compression is the encoding of the data on the wire (eg "zstd"), or
+	// empty if the data is not compressed
+	Compression string
+	// WireBytes is the number of compressed bytes received, and
+	// DecodedBytes is the number of bytes they decompressed to
+	WireBytes    int64
+	DecodedBytes int64
 }
 
 // TransferStatus describes the status of a transfer (started, completed etc)